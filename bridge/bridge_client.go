@@ -206,6 +206,17 @@ func (ws *BridgeClient) listenSocket() {
 			ws.inboundChan <- f
 		}
 	}
+
+	// the subscriptions end with the socket, so their listeners know the connection is gone.
+	ws.lock.Lock()
+	subs := make([]*BridgeClientSub, 0, len(ws.Subscriptions))
+	for _, sub := range ws.Subscriptions {
+		subs = append(subs, sub)
+	}
+	ws.lock.Unlock()
+	for _, sub := range subs {
+		sub.closeChannel()
+	}
 }
 
 func (ws *BridgeClient) handleIncomingSTOMPFrames() {
@@ -18,6 +18,7 @@ type BridgeClientSub struct {
 	Destination string
 	Client      *BridgeClient
 	subscribed  bool
+	closeOnce   sync.Once
 	lock        sync.RWMutex
 }
 
@@ -32,3 +33,13 @@ func (cs *BridgeClientSub) Unsubscribe() {
 
 	cs.Client.SendFrame(unsubscribeFrame)
 }
+
+// closeChannel stops delivering messages and closes C, when unsubscribed or when the socket is lost.
+func (cs *BridgeClientSub) closeChannel() {
+	cs.closeOnce.Do(func() {
+		cs.lock.Lock()
+		cs.subscribed = false
+		close(cs.C)
+		cs.lock.Unlock()
+	})
+}
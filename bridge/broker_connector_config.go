@@ -19,8 +19,9 @@ type WebSocketConfig struct {
 
 // BrokerConnectorConfig is a configuration used when connecting to a message broker
 type BrokerConnectorConfig struct {
+	Transport       string // transport adapter to connect with (see RegisterTransportAdapter), defaults to TransportSTOMP
 	Username        string
-	Password        string
+	Password        string `secret:"true"`
 	ServerAddr      string
 	UseWS           bool             // use WebSocket instead of TCP
 	WebSocketConfig *WebSocketConfig // WebSocket configuration for when UseWS is true
@@ -11,8 +11,8 @@ import (
 	"github.com/go-stomp/stomp/v3"
 	"github.com/go-stomp/stomp/v3/frame"
 	"github.com/google/uuid"
+	"github.com/pb33f/ranch/log"
 	"github.com/pb33f/ranch/model"
-	"log"
 	"sync"
 )
 
@@ -172,9 +172,8 @@ func (c *connection) subscribeTCP(destination string) (Subscription, error) {
 	if c.conn != nil {
 		sub, _ := c.conn.Subscribe(destination, stomp.AckAuto)
 		id := uuid.New()
-		destChan := make(chan *model.Message)
-		go c.listenTCPFrames(sub.C, destChan)
-		bcSub := &subscription{stompTCPSub: sub, id: &id, c: destChan}
+		bcSub := &subscription{stompTCPSub: sub, id: &id, c: make(chan *model.Message)}
+		go c.listenTCPFrames(sub.C, bcSub)
 		c.subscriptions[destination] = bcSub
 		return bcSub, nil
 	}
@@ -193,23 +192,27 @@ func (c *connection) subscribeTCPUsingReplyDestination(destination string) (Subs
 
 		sub, _ := c.conn.Subscribe(destination, stomp.AckAuto, reply)
 		id := uuid.New()
-		destChan := make(chan *model.Message)
-		go c.listenTCPFrames(sub.C, destChan)
-		bcSub := &subscription{stompTCPSub: sub, id: &id, c: destChan}
+		bcSub := &subscription{stompTCPSub: sub, id: &id, c: make(chan *model.Message)}
+		go c.listenTCPFrames(sub.C, bcSub)
 		c.subscriptions[destination] = bcSub
 		return bcSub, nil
 	}
 	return nil, fmt.Errorf("no STOMP TCP connection established")
 }
 
-func (c *connection) listenTCPFrames(src chan *stomp.Message, dst chan *model.Message) {
+func (c *connection) listenTCPFrames(src chan *stomp.Message, dst *subscription) {
 	defer func() {
 		if r := recover(); r != nil {
-			log.Println("subscription is closed, message undeliverable to closed channel.")
+			log.Logger().Warn("[ranch] subscription is closed, message undeliverable to closed channel")
 		}
 	}()
 	for {
-		f := <-src
+		f, ok := <-src
+		if !ok {
+			// unsubscribed, or the connection to the broker was lost.
+			dst.closeMsgChannel()
+			return
+		}
 		var body []byte
 		var dest string
 		if f != nil && f.Body != nil {
@@ -227,7 +230,7 @@ func (c *connection) listenTCPFrames(src chan *stomp.Message, dst chan *model.Me
 			}
 
 			m := model.GenerateResponse(cf)
-			dst <- m
+			dst.c <- m
 		}
 	}
 }
@@ -0,0 +1,246 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/pb33f/ranch/model"
+)
+
+const natsConnectTimeout = 10 * time.Second
+
+// natsAdapter is a TransportAdapter backed by the NATS client, so galactic channels can be mapped onto
+// NATS subjects without running a STOMP broker.
+type natsAdapter struct{}
+
+// NewNATSAdapter returns a TransportAdapter for NATS. Destinations passed to the resulting Connection are
+// NATS subjects (e.g. "ranch.orders"), STOMP frame options are ignored. The client reconnects on its own
+// and restores subscriptions once it is back.
+func NewNATSAdapter() TransportAdapter {
+	return &natsAdapter{}
+}
+
+// Connect dials the NATS server at config.ServerAddr. A password without a username is sent as an auth token.
+// The TLS config of config.WebSocketConfig is used when the server requires TLS.
+func (a *natsAdapter) Connect(config *BrokerConnectorConfig, enableLogging bool) (Connection, error) {
+	if config == nil || config.ServerAddr == "" {
+		return nil, fmt.Errorf("config invalid, config missing server address")
+	}
+	id := uuid.New()
+	nc := &natsConnection{
+		id:            &id,
+		subscriptions: make(map[string]*natsSubscription),
+	}
+
+	opts := []nats.Option{
+		nats.Name("ranch"),
+		nats.Timeout(natsConnectTimeout),
+		nats.ClosedHandler(func(*nats.Conn) { nc.closeSubscriptions() }),
+	}
+	if config.Username != "" {
+		opts = append(opts, nats.UserInfo(config.Username, config.Password))
+	} else if config.Password != "" {
+		opts = append(opts, nats.Token(config.Password))
+	}
+	if config.WebSocketConfig != nil && config.WebSocketConfig.TLSConfig != nil {
+		opts = append(opts, nats.Secure(config.WebSocketConfig.TLSConfig))
+	}
+	if enableLogging {
+		logger := log.New(os.Stderr, "NATS Client: ", 2)
+		opts = append(opts, nats.ErrorHandler(func(_ *nats.Conn, sub *nats.Subscription, err error) {
+			logger.Printf("error: %s", err.Error())
+		}))
+	}
+
+	conn, err := nats.Connect("nats://"+config.ServerAddr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	nc.conn = conn
+	return nc, nil
+}
+
+// natsConnection is a Connection to a NATS server.
+type natsConnection struct {
+	id            *uuid.UUID
+	conn          *nats.Conn
+	subscriptions map[string]*natsSubscription // keyed by subject
+	lock          sync.Mutex
+}
+
+func (nc *natsConnection) closeSubscriptions() {
+	nc.lock.Lock()
+	subs := nc.subscriptions
+	nc.subscriptions = make(map[string]*natsSubscription)
+	nc.lock.Unlock()
+	for _, sub := range subs {
+		sub.close()
+	}
+}
+
+func (nc *natsConnection) GetId() *uuid.UUID {
+	return nc.id
+}
+
+// Subscribe to a subject, only one subscription can exist for a subject.
+func (nc *natsConnection) Subscribe(destination string) (Subscription, error) {
+	nc.lock.Lock()
+	defer nc.lock.Unlock()
+	if nc.conn.IsClosed() {
+		return nil, fmt.Errorf("cannot subscribe to '%s', no connection to NATS", destination)
+	}
+	if sub, ok := nc.subscriptions[destination]; ok {
+		return sub, nil
+	}
+	id := uuid.New()
+	sub := &natsSubscription{
+		id:          &id,
+		destination: destination,
+		c:           make(chan *model.Message),
+		done:        make(chan struct{}),
+		conn:        nc,
+	}
+	natsSub, err := nc.conn.Subscribe(destination, func(msg *nats.Msg) {
+		sub.deliver(natsMessage(msg))
+	})
+	if err != nil {
+		return nil, err
+	}
+	sub.sub = natsSub
+	nc.subscriptions[destination] = sub
+	return sub, nil
+}
+
+// SubscribeReplyDestination subscribes to a reply subject. NATS needs no special handling for replies.
+func (nc *natsConnection) SubscribeReplyDestination(destination string) (Subscription, error) {
+	return nc.Subscribe(destination)
+}
+
+func (nc *natsConnection) unsubscribe(sub *natsSubscription) error {
+	nc.lock.Lock()
+	if nc.subscriptions[sub.destination] != sub {
+		nc.lock.Unlock()
+		return nil
+	}
+	delete(nc.subscriptions, sub.destination)
+	nc.lock.Unlock()
+	err := sub.sub.Unsubscribe()
+	sub.close()
+	return err
+}
+
+// Disconnect from the NATS server, will close all subscription channels.
+func (nc *natsConnection) Disconnect() error {
+	if nc.conn.IsClosed() {
+		return fmt.Errorf("cannot disconnect, not connected")
+	}
+	nc.conn.Close()
+	return nil
+}
+
+// SendJSONMessage publishes a payload to a subject.
+func (nc *natsConnection) SendJSONMessage(destination string, payload []byte, opts ...func(*frame.Frame) error) error {
+	return nc.conn.Publish(destination, payload)
+}
+
+// SendMessage publishes a payload to a subject, the content type is not transmitted.
+func (nc *natsConnection) SendMessage(destination, contentType string, payload []byte, opts ...func(*frame.Frame) error) error {
+	return nc.conn.Publish(destination, payload)
+}
+
+// SendMessageWithReplyDestination publishes a payload to a subject with a reply subject attached.
+func (nc *natsConnection) SendMessageWithReplyDestination(destination, replyDestination, contentType string,
+	payload []byte, opts ...func(*frame.Frame) error) error {
+	return nc.conn.PublishRequest(destination, replyDestination, payload)
+}
+
+// Conversation subscribes to a subject and then publishes a payload to it.
+func (nc *natsConnection) Conversation(destination string, payload []byte, opts ...func(*frame.Frame) error) (Subscription, error) {
+	sub, err := nc.Subscribe(destination)
+	if err != nil {
+		return sub, err
+	}
+	return sub, nc.conn.Publish(destination, payload)
+}
+
+// RequestResponse sends a NATS request with the payload to the subject held by the "destination" context
+// value, and waits for the first reply or for the context to be done.
+func (nc *natsConnection) RequestResponse(ctx context.Context, payload []byte, opts ...func(*frame.Frame) error) (*model.Message, error) {
+	destination := ctx.Value("destination").(string)
+	msg, err := nc.conn.RequestWithContext(ctx, destination, payload)
+	if err != nil {
+		return nil, err
+	}
+	return natsMessage(msg), nil
+}
+
+func natsMessage(msg *nats.Msg) *model.Message {
+	return model.GenerateResponse(&model.MessageConfig{
+		Payload:     msg.Data,
+		Destination: msg.Subject,
+	})
+}
+
+// natsSubscription is a Subscription to a NATS subject.
+type natsSubscription struct {
+	id          *uuid.UUID
+	destination string
+	c           chan *model.Message
+	done        chan struct{}
+	sub         *nats.Subscription
+	conn        *natsConnection
+	closeOnce   sync.Once
+	lock        sync.RWMutex
+	closed      bool
+}
+
+func (s *natsSubscription) GetId() *uuid.UUID {
+	return s.id
+}
+
+func (s *natsSubscription) GetMsgChannel() chan *model.Message {
+	return s.c
+}
+
+func (s *natsSubscription) GetDestination() string {
+	return s.destination
+}
+
+// Unsubscribe from the subject. The message channel will be closed.
+func (s *natsSubscription) Unsubscribe() error {
+	return s.conn.unsubscribe(s)
+}
+
+// deliver hands a message to the reader of the channel, messages arriving after the subscription is
+// closed are dropped.
+func (s *natsSubscription) deliver(msg *model.Message) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.c <- msg:
+	case <-s.done:
+	}
+}
+
+func (s *natsSubscription) close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.lock.Lock()
+		s.closed = true
+		close(s.c)
+		s.lock.Unlock()
+	})
+}
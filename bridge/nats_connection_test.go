@@ -0,0 +1,259 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package bridge
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+)
+
+type natsTestClient struct {
+	conn      net.Conn
+	writeLock sync.Mutex
+	subs      map[string]string // sid -> subject
+}
+
+func (c *natsTestClient) write(data string) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	_, _ = io.WriteString(c.conn, data)
+}
+
+// natsEchoSubject is answered by the test server itself, echoing the payload to the reply subject.
+const natsEchoSubject = "ranch.echo"
+
+// natsTestConnectOptions holds the CONNECT fields the test server checks.
+type natsTestConnectOptions struct {
+	User string `json:"user"`
+	Pass string `json:"pass"`
+}
+
+// natsTestServer is a tiny core NATS server, enough of the protocol for the NATS client to talk to it.
+type natsTestServer struct {
+	listener net.Listener
+	password string
+	clients  map[*natsTestClient]bool
+	lock     sync.Mutex
+}
+
+func newNATSTestServer(t *testing.T, password string) *natsTestServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	s := &natsTestServer{listener: l, password: password, clients: make(map[*natsTestClient]bool)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { _ = l.Close() })
+	return s
+}
+
+func (s *natsTestServer) serve(conn net.Conn) {
+	defer conn.Close()
+	client := &natsTestClient{conn: conn, subs: make(map[string]string)}
+	client.write(`INFO {"server_id":"test","max_payload":1048576}` + "\r\n")
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			break
+		}
+		line = strings.TrimRight(line, "\r\n")
+		op, args, _ := strings.Cut(line, " ")
+		switch op {
+		case "CONNECT":
+			var opts natsTestConnectOptions
+			_ = json.Unmarshal([]byte(args), &opts)
+			if opts.Pass != s.password {
+				client.write("-ERR 'Authorization Violation'\r\n")
+				return
+			}
+			s.lock.Lock()
+			s.clients[client] = true
+			s.lock.Unlock()
+		case "PING":
+			client.write("PONG\r\n")
+		case "SUB":
+			fields := strings.Fields(args)
+			s.lock.Lock()
+			client.subs[fields[1]] = fields[0]
+			s.lock.Unlock()
+		case "UNSUB":
+			s.lock.Lock()
+			delete(client.subs, args)
+			s.lock.Unlock()
+		case "PUB":
+			fields := strings.Fields(args)
+			size, _ := strconv.Atoi(fields[len(fields)-1])
+			payload := make([]byte, size+2)
+			if _, err = io.ReadFull(reader, payload); err != nil {
+				return
+			}
+			reply := ""
+			if len(fields) == 3 {
+				reply = " " + fields[1]
+			}
+			if fields[0] == natsEchoSubject && reply != "" {
+				s.publish(fields[1], "", payload[:size])
+				continue
+			}
+			s.publish(fields[0], reply, payload[:size])
+		}
+	}
+	s.lock.Lock()
+	delete(s.clients, client)
+	s.lock.Unlock()
+}
+
+func (s *natsTestServer) publish(subject, reply string, payload []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for client := range s.clients {
+		for sid, sub := range client.subs {
+			if natsSubjectMatches(sub, subject) {
+				client.write(fmt.Sprintf("MSG %s %s%s %d\r\n%s\r\n", subject, sid, reply, len(payload), payload))
+			}
+		}
+	}
+}
+
+// natsSubjectMatches matches a subject against a subscription, which may use the * and > wildcards.
+func natsSubjectMatches(subscription, subject string) bool {
+	want, got := strings.Split(subscription, "."), strings.Split(subject, ".")
+	for i, token := range want {
+		if token == ">" {
+			return len(got) > i
+		}
+		if i >= len(got) || (token != "*" && token != got[i]) {
+			return false
+		}
+	}
+	return len(want) == len(got)
+}
+
+func (s *natsTestServer) connect(t *testing.T) Connection {
+	adapter, err := NewTransportAdapter(TransportNATS)
+	assert.NoError(t, err)
+	conn, err := adapter.Connect(&BrokerConnectorConfig{
+		Transport:  TransportNATS,
+		ServerAddr: s.listener.Addr().String(),
+		Username:   "ranch",
+		Password:   s.password,
+	}, false)
+	assert.NoError(t, err)
+	return conn
+}
+
+func TestNATSConnection_PubSub(t *testing.T) {
+	srv := newNATSTestServer(t, "secret")
+	publisher := srv.connect(t)
+	subscriber := srv.connect(t)
+
+	sub, err := subscriber.Subscribe("ranch.orders")
+	assert.NoError(t, err)
+	assert.Equal(t, "ranch.orders", sub.GetDestination())
+
+	// subscriptions are reused per subject.
+	again, _ := subscriber.Subscribe("ranch.orders")
+	assert.Equal(t, sub.GetId(), again.GetId())
+
+	// make sure the server has processed the SUB before publishing from another connection.
+	ready := mustSubscribe(t, subscriber, "ranch.sync")
+	assert.NoError(t, subscriber.SendJSONMessage("ranch.sync", []byte("sync")))
+	<-ready.GetMsgChannel()
+
+	assert.NoError(t, publisher.SendJSONMessage("ranch.orders", []byte(`{"id":1}`)))
+	select {
+	case msg := <-sub.GetMsgChannel():
+		assert.Equal(t, []byte(`{"id":1}`), msg.Payload)
+		assert.Equal(t, "ranch.orders", msg.Destination)
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "message was not delivered")
+	}
+
+	assert.NoError(t, sub.Unsubscribe())
+	_, ok := <-sub.GetMsgChannel()
+	assert.False(t, ok)
+
+	assert.NoError(t, publisher.Disconnect())
+	assert.Error(t, publisher.Disconnect())
+	assert.Error(t, publisher.SendJSONMessage("ranch.orders", []byte("late")))
+	assert.NoError(t, subscriber.Disconnect())
+}
+
+func mustSubscribe(t *testing.T, conn Connection, subject string) Subscription {
+	sub, err := conn.Subscribe(subject)
+	assert.NoError(t, err)
+	return sub
+}
+
+func TestNATSConnection_RequestResponse(t *testing.T) {
+	srv := newNATSTestServer(t, "secret")
+	requester := srv.connect(t)
+	defer requester.Disconnect()
+
+	ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), "destination", natsEchoSubject), 2*time.Second)
+	defer cancel()
+	msg, err := requester.RequestResponse(ctx, []byte("hello"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello"), msg.Payload)
+	assert.True(t, strings.HasPrefix(msg.Destination, nats.InboxPrefix))
+
+	// nobody answers on this subject.
+	ctx, cancel = context.WithTimeout(context.WithValue(context.Background(), "destination", "ranch.nobody"), 50*time.Millisecond)
+	defer cancel()
+	_, err = requester.RequestResponse(ctx, []byte("hello"))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestNATSAdapter_AuthFailure(t *testing.T) {
+	srv := newNATSTestServer(t, "secret")
+	adapter := NewNATSAdapter()
+	conn, err := adapter.Connect(&BrokerConnectorConfig{
+		ServerAddr: srv.listener.Addr().String(),
+		Username:   "ranch",
+		Password:   "wrong",
+	}, false)
+	assert.Nil(t, conn)
+	assert.ErrorIs(t, err, nats.ErrAuthorization)
+
+	_, err = adapter.Connect(&BrokerConnectorConfig{}, false)
+	assert.Error(t, err)
+}
+
+func TestNewTransportAdapter(t *testing.T) {
+	adapter, err := NewTransportAdapter("")
+	assert.NoError(t, err)
+	assert.IsType(t, &brokerConnector{}, adapter)
+
+	_, err = NewTransportAdapter("carrier-pigeon")
+	assert.ErrorContains(t, err, "unknown transport")
+
+	RegisterTransportAdapter("carrier-pigeon", NewNATSAdapter)
+	defer func() {
+		transportAdaptersLock.Lock()
+		delete(transportAdapters, "carrier-pigeon")
+		transportAdaptersLock.Unlock()
+	}()
+	adapter, err = NewTransportAdapter("carrier-pigeon")
+	assert.NoError(t, err)
+	assert.IsType(t, &natsAdapter{}, adapter)
+}
@@ -0,0 +1,267 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package bridge
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/google/uuid"
+	"github.com/pb33f/ranch/model"
+	"github.com/redis/go-redis/v9"
+)
+
+const redisDialTimeout = 10 * time.Second
+
+// redisAdapter is a TransportAdapter for Redis pub/sub, so galactic channels can be shared by processes
+// connected to the same Redis server.
+type redisAdapter struct{}
+
+// NewRedisAdapter returns a TransportAdapter for Redis pub/sub. Destinations are Redis channel names. TLS is
+// used when config.WebSocketConfig.UseTLS is set. When the connection to Redis is lost, the client
+// reconnects and restores the subscriptions, their message channels stay open in the meantime. Redis
+// pub/sub has no reply destinations, so RequestResponse is not supported.
+func NewRedisAdapter() TransportAdapter {
+	return &redisAdapter{}
+}
+
+func (a *redisAdapter) Connect(config *BrokerConnectorConfig, enableLogging bool) (Connection, error) {
+	if config == nil || config.ServerAddr == "" {
+		return nil, fmt.Errorf("config invalid, config missing server address")
+	}
+	client, err := newRedisClient(config)
+	if err != nil {
+		return nil, err
+	}
+	id := uuid.New()
+	rc := &redisConnection{
+		id:            &id,
+		client:        client,
+		pubsub:        client.Subscribe(context.Background()),
+		subscriptions: make(map[string]*redisSubscription),
+	}
+	if enableLogging {
+		rc.logger = log.New(os.Stderr, "Redis Client: ", 2)
+	}
+	go rc.dispatch(rc.pubsub.Channel())
+	return rc, nil
+}
+
+// newRedisClient creates a client for the Redis server in config and checks it can reach and authenticate
+// with the server.
+func newRedisClient(config *BrokerConnectorConfig) (*redis.Client, error) {
+	opts := &redis.Options{
+		Addr:        config.ServerAddr,
+		Username:    config.Username,
+		Password:    config.Password,
+		DialTimeout: redisDialTimeout,
+	}
+	if wsc := config.WebSocketConfig; wsc != nil && wsc.UseTLS {
+		opts.TLSConfig = wsc.TLSConfig
+		if opts.TLSConfig == nil {
+			opts.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+	}
+	client := redis.NewClient(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), redisDialTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("unable to connect to redis: %w", err)
+	}
+	return client, nil
+}
+
+// redisConnection is a Connection to Redis. Commands are sent by the client's connection pool while the
+// subscriptions share a single connection in subscribe mode.
+type redisConnection struct {
+	id            *uuid.UUID
+	client        *redis.Client
+	pubsub        *redis.PubSub
+	logger        *log.Logger
+	subscriptions map[string]*redisSubscription
+	disconnected  bool
+	lock          sync.Mutex
+}
+
+// dispatch hands the messages received on the subscribe connection to their subscriptions, until the
+// connection is closed.
+func (rc *redisConnection) dispatch(messages <-chan *redis.Message) {
+	for msg := range messages {
+		rc.lock.Lock()
+		sub, found := rc.subscriptions[msg.Channel]
+		rc.lock.Unlock()
+		if found {
+			sub.deliver(model.GenerateResponse(&model.MessageConfig{
+				Payload:     []byte(msg.Payload),
+				Destination: msg.Channel,
+			}))
+		}
+	}
+	rc.closeSubscriptions()
+}
+
+func (rc *redisConnection) closeSubscriptions() {
+	rc.lock.Lock()
+	subs := rc.subscriptions
+	rc.subscriptions = make(map[string]*redisSubscription)
+	rc.lock.Unlock()
+	for _, sub := range subs {
+		sub.close()
+	}
+}
+
+func (rc *redisConnection) GetId() *uuid.UUID {
+	return rc.id
+}
+
+// Subscribe to a Redis channel, only one subscription can exist for a channel. If the subscribe connection
+// is down, the subscription is made once it has been re-established.
+func (rc *redisConnection) Subscribe(destination string) (Subscription, error) {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	if rc.disconnected {
+		return nil, fmt.Errorf("cannot subscribe to '%s', no connection to redis", destination)
+	}
+	if sub, ok := rc.subscriptions[destination]; ok {
+		return sub, nil
+	}
+	id := uuid.New()
+	sub := &redisSubscription{
+		id:          &id,
+		destination: destination,
+		c:           make(chan *model.Message),
+		conn:        rc,
+		done:        make(chan struct{}),
+	}
+	rc.subscriptions[destination] = sub
+	if err := rc.pubsub.Subscribe(context.Background(), destination); err != nil && rc.logger != nil {
+		rc.logger.Printf("subscribe to '%s' deferred until reconnected: %v", destination, err)
+	}
+	return sub, nil
+}
+
+// SubscribeReplyDestination subscribes to a Redis channel, Redis needs no special handling for replies.
+func (rc *redisConnection) SubscribeReplyDestination(destination string) (Subscription, error) {
+	return rc.Subscribe(destination)
+}
+
+func (rc *redisConnection) unsubscribe(sub *redisSubscription) error {
+	rc.lock.Lock()
+	if rc.subscriptions[sub.destination] != sub {
+		rc.lock.Unlock()
+		return nil
+	}
+	delete(rc.subscriptions, sub.destination)
+	rc.lock.Unlock()
+	err := rc.pubsub.Unsubscribe(context.Background(), sub.destination)
+	sub.close()
+	return err
+}
+
+// Disconnect from Redis, will close all subscription channels.
+func (rc *redisConnection) Disconnect() error {
+	rc.lock.Lock()
+	if rc.disconnected {
+		rc.lock.Unlock()
+		return fmt.Errorf("cannot disconnect, not connected")
+	}
+	rc.disconnected = true
+	rc.lock.Unlock()
+	_ = rc.pubsub.Close()
+	return rc.client.Close()
+}
+
+// SendJSONMessage publishes a payload to a Redis channel.
+func (rc *redisConnection) SendJSONMessage(destination string, payload []byte, opts ...func(*frame.Frame) error) error {
+	return rc.publish(destination, payload)
+}
+
+// SendMessage publishes a payload to a Redis channel, the content type is not transmitted.
+func (rc *redisConnection) SendMessage(destination, contentType string, payload []byte, opts ...func(*frame.Frame) error) error {
+	return rc.publish(destination, payload)
+}
+
+// SendMessageWithReplyDestination publishes a payload to a Redis channel. Redis pub/sub cannot carry a
+// reply destination, so it is dropped.
+func (rc *redisConnection) SendMessageWithReplyDestination(destination, replyDestination, contentType string,
+	payload []byte, opts ...func(*frame.Frame) error) error {
+	return rc.publish(destination, payload)
+}
+
+func (rc *redisConnection) publish(destination string, payload []byte) error {
+	return rc.client.Publish(context.Background(), destination, payload).Err()
+}
+
+// Conversation subscribes to a Redis channel and then publishes a payload to it.
+func (rc *redisConnection) Conversation(destination string, payload []byte, opts ...func(*frame.Frame) error) (Subscription, error) {
+	sub, err := rc.Subscribe(destination)
+	if err != nil {
+		return sub, err
+	}
+	return sub, rc.publish(destination, payload)
+}
+
+// RequestResponse is not supported, Redis pub/sub has no way to address a reply.
+func (rc *redisConnection) RequestResponse(ctx context.Context, payload []byte, opts ...func(*frame.Frame) error) (*model.Message, error) {
+	return nil, fmt.Errorf("request/response is not supported by the redis transport")
+}
+
+// redisSubscription is a Subscription to a Redis channel.
+type redisSubscription struct {
+	id          *uuid.UUID
+	destination string
+	c           chan *model.Message
+	conn        *redisConnection
+	done        chan struct{}
+	closeOnce   sync.Once
+	lock        sync.RWMutex
+	closed      bool
+}
+
+func (s *redisSubscription) GetId() *uuid.UUID {
+	return s.id
+}
+
+func (s *redisSubscription) GetMsgChannel() chan *model.Message {
+	return s.c
+}
+
+func (s *redisSubscription) GetDestination() string {
+	return s.destination
+}
+
+// Unsubscribe from the Redis channel. The message channel will be closed.
+func (s *redisSubscription) Unsubscribe() error {
+	return s.conn.unsubscribe(s)
+}
+
+// deliver hands a message to the subscriber, giving up if the subscription is closed in the meantime.
+func (s *redisSubscription) deliver(msg *model.Message) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.c <- msg:
+	case <-s.done:
+	}
+}
+
+func (s *redisSubscription) close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.lock.Lock()
+		s.closed = true
+		close(s.c)
+		s.lock.Unlock()
+	})
+}
@@ -0,0 +1,104 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package bridge
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// newRedisTestServer starts an in memory Redis server, requiring the password if it is not empty.
+func newRedisTestServer(t *testing.T, password string) *miniredis.Miniredis {
+	srv := miniredis.RunT(t)
+	if password != "" {
+		srv.RequireAuth(password)
+	}
+	return srv
+}
+
+func connectRedis(t *testing.T, srv *miniredis.Miniredis, password string) Connection {
+	adapter, err := NewTransportAdapter(TransportRedis)
+	assert.NoError(t, err)
+	conn, err := adapter.Connect(&BrokerConnectorConfig{
+		ServerAddr: srv.Addr(),
+		Password:   password,
+	}, false)
+	assert.NoError(t, err)
+	return conn
+}
+
+func redisSubscribers(srv *miniredis.Miniredis, channel string) int {
+	return srv.PubSubNumSub(channel)[channel]
+}
+
+func TestRedisConnection_PubSub(t *testing.T) {
+	srv := newRedisTestServer(t, "secret")
+	publisher := connectRedis(t, srv, "secret")
+	subscriber := connectRedis(t, srv, "secret")
+
+	sub, err := subscriber.Subscribe("ranch-orders")
+	assert.NoError(t, err)
+	again, _ := subscriber.Subscribe("ranch-orders")
+	assert.Equal(t, sub.GetId(), again.GetId())
+	assert.Eventually(t, func() bool { return redisSubscribers(srv, "ranch-orders") == 1 }, time.Second, time.Millisecond)
+
+	assert.NoError(t, publisher.SendJSONMessage("ranch-orders", []byte(`{"id":1}`)))
+	select {
+	case msg := <-sub.GetMsgChannel():
+		assert.Equal(t, []byte(`{"id":1}`), msg.Payload)
+		assert.Equal(t, "ranch-orders", msg.Destination)
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "message was not delivered")
+	}
+
+	_, err = publisher.RequestResponse(context.Background(), []byte("hello"))
+	assert.Error(t, err)
+
+	assert.NoError(t, sub.Unsubscribe())
+	_, ok := <-sub.GetMsgChannel()
+	assert.False(t, ok)
+	assert.Eventually(t, func() bool { return redisSubscribers(srv, "ranch-orders") == 0 }, time.Second, time.Millisecond)
+
+	assert.NoError(t, publisher.Disconnect())
+	assert.Error(t, publisher.Disconnect())
+	assert.Error(t, publisher.SendJSONMessage("ranch-orders", []byte("late")))
+	assert.NoError(t, subscriber.Disconnect())
+}
+
+func TestRedisConnection_ResubscribesAfterConnectionLoss(t *testing.T) {
+	srv := newRedisTestServer(t, "")
+	conn := connectRedis(t, srv, "")
+	defer conn.Disconnect()
+
+	sub, _ := conn.Subscribe("ranch-events")
+	assert.Eventually(t, func() bool { return redisSubscribers(srv, "ranch-events") == 1 }, time.Second, time.Millisecond)
+
+	srv.Close()
+	assert.NoError(t, srv.Restart())
+	assert.Eventually(t, func() bool { return redisSubscribers(srv, "ranch-events") == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	// the connections used for commands were dropped as well and are redialed on demand.
+	assert.NoError(t, conn.SendJSONMessage("ranch-events", []byte("after")))
+	select {
+	case msg, ok := <-sub.GetMsgChannel():
+		assert.True(t, ok)
+		assert.Equal(t, []byte("after"), msg.Payload)
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "message was not delivered after reconnecting")
+	}
+}
+
+func TestRedisAdapter_AuthFailure(t *testing.T) {
+	srv := newRedisTestServer(t, "secret")
+	conn, err := NewRedisAdapter().Connect(&BrokerConnectorConfig{
+		ServerAddr: srv.Addr(),
+		Password:   "wrong",
+	}, false)
+	assert.Nil(t, conn)
+	assert.ErrorContains(t, err, "WRONGPASS")
+}
@@ -0,0 +1,323 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package bridge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// DefaultRedisStoreKeyPrefix prefixes the Redis keys of stores when no prefix is configured.
+	DefaultRedisStoreKeyPrefix = "ranch:store:"
+
+	redisKeyspaceEventsParam  = "notify-keyspace-events"
+	redisKeyspaceEventsPrefix = "__keyspace@0__:"
+)
+
+// The scripts below change a store hash, bump the store version and announce the change on the changes
+// channel of the store, all at once. They return the new store version. The version is bumped first, so
+// watchers know the keyspace events of the hash that follow come with an announced change.
+// KEYS: the store hash, the version key. ARGV: the changes channel, the origin, then the script arguments.
+var (
+	// ARGV holds the number of items removed, their ids, then item id and value pairs to set.
+	redisStoreUpdateScript = redis.NewScript(`
+local version = redis.call('INCR', KEYS[2])
+local removed, items = {}, {}
+local count = tonumber(ARGV[3])
+for i = 4, 3 + count do
+	redis.call('HDEL', KEYS[1], ARGV[i])
+	removed[ARGV[i]] = true
+end
+for i = 4 + count, #ARGV, 2 do
+	redis.call('HSET', KEYS[1], ARGV[i], ARGV[i + 1])
+	items[ARGV[i]] = ARGV[i + 1]
+end
+-- empty tables are left out, cjson cannot tell them from empty arrays.
+local change = {origin = ARGV[2], version = version}
+if next(items) then change.items = items end
+if next(removed) then change.removed = removed end
+redis.call('PUBLISH', ARGV[1], cjson.encode(change))
+return version`)
+
+	// ARGV holds item id and value pairs.
+	redisStoreReplaceScript = redis.NewScript(`
+local version = redis.call('INCR', KEYS[2])
+redis.call('DEL', KEYS[1])
+for i = 3, #ARGV, 2 do
+	redis.call('HSET', KEYS[1], ARGV[i], ARGV[i + 1])
+end
+redis.call('PUBLISH', ARGV[1], cjson.encode({origin = ARGV[2], version = version, replaced = true}))
+return version`)
+)
+
+// redisStoreChange is the message announcing a change on the changes channel of a store.
+type redisStoreChange struct {
+	Origin   string            `json:"origin"`
+	Version  int64             `json:"version"`
+	Items    map[string]string `json:"items"`
+	Removed  map[string]bool   `json:"removed"`
+	Replaced bool              `json:"replaced"`
+}
+
+// RedisStorePersistence keeps stores in Redis so every instance connected to the same Redis server shares
+// them (see bus.DistributedStorePersistence). Each store is a hash of JSON encoded items, next to a key
+// holding the store version that Redis increments with every change. Changes are announced on a channel
+// per store, along with the item, so instances watching the store apply them without reading the hash.
+// Changes made to the hash by anything else, such as another tool or the key expiring, are picked up from
+// Redis keyspace events and reload the store. They need notify-keyspace-events to include "K$hgx" (or
+// "KA"), and are enabled on connect if the server allows CONFIG SET, otherwise they must be enabled on the
+// server. Only database 0 is supported.
+type RedisStorePersistence struct {
+	client   *redis.Client
+	origin   string // tells the changes made by this instance apart
+	prefix   string
+	logger   *log.Logger
+	watchers map[string]*redis.PubSub
+	lock     sync.Mutex
+}
+
+// NewRedisStorePersistence connects to the Redis server in config, using config.Username, config.Password
+// and config.WebSocketConfig.UseTLS like the Redis transport does. Store keys start with keyPrefix, or
+// DefaultRedisStoreKeyPrefix if empty.
+func NewRedisStorePersistence(config *BrokerConnectorConfig, keyPrefix string, enableLogging bool) (*RedisStorePersistence, error) {
+	if config == nil || config.ServerAddr == "" {
+		return nil, fmt.Errorf("config invalid, config missing server address")
+	}
+	client, err := newRedisClient(config)
+	if err != nil {
+		return nil, err
+	}
+	if keyPrefix == "" {
+		keyPrefix = DefaultRedisStoreKeyPrefix
+	}
+	p := &RedisStorePersistence{
+		client:   client,
+		origin:   uuid.NewString(),
+		prefix:   keyPrefix,
+		watchers: make(map[string]*redis.PubSub),
+	}
+	if enableLogging {
+		p.logger = log.New(os.Stderr, "Redis Store: ", 2)
+	}
+	if err = p.enableKeyspaceEvents(); err != nil && p.logger != nil {
+		p.logger.Printf("unable to enable keyspace events, changes made outside ranch may go unnoticed: %v", err)
+	}
+	return p, nil
+}
+
+// enableKeyspaceEvents adds the keyspace events for strings, hashes, generic commands and expiry to the
+// server configuration, keeping whatever else is enabled.
+func (p *RedisStorePersistence) enableKeyspaceEvents() error {
+	ctx := context.Background()
+	config, err := p.client.ConfigGet(ctx, redisKeyspaceEventsParam).Result()
+	if err != nil {
+		return err
+	}
+	flags := config[redisKeyspaceEventsParam]
+	wanted := flags
+	for _, flag := range []string{"K", "$", "h", "g", "x"} {
+		if !strings.Contains(wanted, flag) && (flag == "K" || !strings.Contains(wanted, "A")) {
+			wanted += flag
+		}
+	}
+	if wanted == flags {
+		return nil
+	}
+	return p.client.ConfigSet(ctx, redisKeyspaceEventsParam, wanted).Err()
+}
+
+func (p *RedisStorePersistence) itemsKey(storeName string) string {
+	return p.prefix + storeName
+}
+
+func (p *RedisStorePersistence) versionKey(storeName string) string {
+	return p.prefix + storeName + ":version"
+}
+
+func (p *RedisStorePersistence) changesChannel(storeName string) string {
+	return p.prefix + storeName + ":changes"
+}
+
+// Load reads the items and the version of a store in one transaction.
+func (p *RedisStorePersistence) Load(storeName string) (map[string][]byte, int64, error) {
+	ctx := context.Background()
+	var items *redis.MapStringStringCmd
+	var version *redis.StringCmd
+	_, err := p.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		items = pipe.HGetAll(ctx, p.itemsKey(storeName))
+		version = pipe.Get(ctx, p.versionKey(storeName))
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, 0, err
+	}
+	saved := make(map[string][]byte, len(items.Val()))
+	for id, value := range items.Val() {
+		saved[id] = []byte(value)
+	}
+	var storeVersion int64
+	if version.Err() != redis.Nil {
+		if storeVersion, err = version.Int64(); err != nil {
+			return nil, 0, fmt.Errorf("invalid version of store '%s': %w", storeName, err)
+		}
+	}
+	return saved, storeVersion, nil
+}
+
+// Put sets an item of the store hash. The version is ignored, Redis assigns the next store version.
+func (p *RedisStorePersistence) Put(storeName string, id string, value []byte, _ int64) (int64, error) {
+	return p.Update(storeName, map[string][]byte{id: value}, 0)
+}
+
+// Delete removes an item from the store hash. The version is ignored, Redis assigns the next store version.
+func (p *RedisStorePersistence) Delete(storeName string, id string, _ int64) (int64, error) {
+	return p.Update(storeName, map[string][]byte{id: nil}, 0)
+}
+
+// Update sets and removes items of the store hash in one go, items with a nil value are removed. The
+// version is ignored, Redis assigns the next store version.
+func (p *RedisStorePersistence) Update(storeName string, items map[string][]byte, _ int64) (int64, error) {
+	var removed, set []interface{}
+	for id, value := range items {
+		if value == nil {
+			removed = append(removed, id)
+		} else {
+			set = append(set, id, value)
+		}
+	}
+	args := append(append([]interface{}{len(removed)}, removed...), set...)
+	return p.run(redisStoreUpdateScript, storeName, args...)
+}
+
+// Replace swaps the store hash for the items. The version is ignored, Redis assigns the next store version.
+func (p *RedisStorePersistence) Replace(storeName string, items map[string][]byte, _ int64) (int64, error) {
+	args := make([]interface{}, 0, 2*len(items))
+	for id, value := range items {
+		args = append(args, id, value)
+	}
+	return p.run(redisStoreReplaceScript, storeName, args...)
+}
+
+func (p *RedisStorePersistence) run(script *redis.Script, storeName string, args ...interface{}) (int64, error) {
+	keys := []string{p.itemsKey(storeName), p.versionKey(storeName)}
+	args = append([]interface{}{p.changesChannel(storeName), p.origin}, args...)
+	return script.Run(context.Background(), p.client, keys, args...).Int64()
+}
+
+// Drop deletes the store hash and version.
+func (p *RedisStorePersistence) Drop(storeName string) error {
+	return p.client.Del(context.Background(), p.itemsKey(storeName), p.versionKey(storeName)).Err()
+}
+
+// Watch subscribes to the changes channel and the keyspace events of the store. Changes made by other
+// instances are passed to changed in the order Redis made them, value is nil for deleted items. reload is
+// called when all items were replaced, when the hash was changed without a change being announced, and
+// whenever the subscription is restored after the connection to Redis was lost, as changes made in the
+// meantime were missed.
+func (p *RedisStorePersistence) Watch(storeName string, changed func(id string, value []byte, version int64),
+	reload func()) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if _, ok := p.watchers[storeName]; ok {
+		return nil
+	}
+	ctx := context.Background()
+	pubsub := p.client.Subscribe(ctx)
+	if err := pubsub.Subscribe(ctx, p.changesChannel(storeName), redisKeyspaceEventsPrefix+p.itemsKey(storeName),
+		redisKeyspaceEventsPrefix+p.versionKey(storeName)); err != nil {
+		_ = pubsub.Close()
+		return err
+	}
+	p.watchers[storeName] = pubsub
+	go p.watch(storeName, pubsub.ChannelWithSubscriptions(), changed, reload)
+	return nil
+}
+
+// watch hands the changes made by other instances to changed until the subscription is closed.
+func (p *RedisStorePersistence) watch(storeName string, messages <-chan interface{},
+	changed func(id string, value []byte, version int64), reload func()) {
+	changesChannel := p.changesChannel(storeName)
+	itemsEvents := redisKeyspaceEventsPrefix + p.itemsKey(storeName)
+	versionEvents := redisKeyspaceEventsPrefix + p.versionKey(storeName)
+	subscribed := false
+	// true from the version being bumped by a script until its change is announced, the events of the
+	// hash in between are explained by the change.
+	announcing := false
+	for msg := range messages {
+		switch msg := msg.(type) {
+		case *redis.Subscription:
+			if msg.Kind != "subscribe" || msg.Channel != changesChannel {
+				continue
+			}
+			if subscribed {
+				reload()
+			}
+			subscribed = true
+			announcing = false
+		case *redis.Message:
+			switch msg.Channel {
+			case versionEvents:
+				announcing = msg.Payload == "incrby"
+				continue
+			case itemsEvents:
+				if !announcing {
+					reload()
+				}
+				continue
+			}
+			announcing = false
+			var change redisStoreChange
+			if err := json.Unmarshal([]byte(msg.Payload), &change); err != nil {
+				if p.logger != nil {
+					p.logger.Printf("invalid change on '%s': %v", msg.Channel, err)
+				}
+				continue
+			}
+			switch {
+			case change.Origin == p.origin:
+			case change.Replaced:
+				reload()
+			default:
+				for id := range change.Removed {
+					changed(id, nil, change.Version)
+				}
+				for id, value := range change.Items {
+					changed(id, []byte(value), change.Version)
+				}
+			}
+		}
+	}
+}
+
+// Unwatch stops watching the store.
+func (p *RedisStorePersistence) Unwatch(storeName string) {
+	p.lock.Lock()
+	pubsub, ok := p.watchers[storeName]
+	delete(p.watchers, storeName)
+	p.lock.Unlock()
+	if ok {
+		_ = pubsub.Close()
+	}
+}
+
+// Close stops watching every store and disconnects from Redis.
+func (p *RedisStorePersistence) Close() error {
+	p.lock.Lock()
+	watchers := p.watchers
+	p.watchers = make(map[string]*redis.PubSub)
+	p.lock.Unlock()
+	for _, pubsub := range watchers {
+		_ = pubsub.Close()
+	}
+	return p.client.Close()
+}
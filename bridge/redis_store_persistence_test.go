@@ -0,0 +1,173 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package bridge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRedisStorePersistence(t *testing.T, srv *miniredis.Miniredis, password string) *RedisStorePersistence {
+	p, err := NewRedisStorePersistence(&BrokerConnectorConfig{
+		ServerAddr: srv.Addr(),
+		Password:   password,
+	}, "", false)
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = p.Close() })
+	return p
+}
+
+func TestRedisStorePersistence(t *testing.T) {
+	srv := newRedisTestServer(t, "secret")
+	p := newTestRedisStorePersistence(t, srv, "secret")
+
+	items, version, err := p.Load("cattle")
+	assert.NoError(t, err)
+	assert.Empty(t, items)
+	assert.Zero(t, version)
+
+	// Redis hands out the versions, the ones passed in are ignored.
+	saved := func(version int64, err error) int64 {
+		assert.NoError(t, err)
+		return version
+	}
+	assert.EqualValues(t, 1, saved(p.Put("cattle", "bessie", []byte(`"moo"`), 7)))
+	assert.EqualValues(t, 2, saved(p.Put("cattle", "daisy", []byte(`"moo"`), 7)))
+	assert.EqualValues(t, 3, saved(p.Delete("cattle", "daisy", 7)))
+	assert.Equal(t, `"moo"`, srv.HGet("ranch:store:cattle", "bessie"))
+	assert.Equal(t, "", srv.HGet("ranch:store:cattle", "daisy"))
+	assert.EqualValues(t, 4, saved(p.Update("cattle", map[string][]byte{"daisy": []byte(`"baa"`), "bessie": nil}, 7)))
+	assert.EqualValues(t, 5, saved(p.Update("cattle", map[string][]byte{"bessie": []byte(`"moo"`), "daisy": nil}, 7)))
+
+	items, version, err = p.Load("cattle")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"bessie": []byte(`"moo"`)}, items)
+	assert.EqualValues(t, 5, version)
+
+	assert.EqualValues(t, 6, saved(p.Replace("cattle", map[string][]byte{"ed": []byte(`"neigh"`)}, 7)))
+	items, version, _ = p.Load("cattle")
+	assert.Equal(t, map[string][]byte{"ed": []byte(`"neigh"`)}, items)
+	assert.EqualValues(t, 6, version)
+
+	assert.NoError(t, p.Drop("cattle"))
+	items, version, _ = p.Load("cattle")
+	assert.Empty(t, items)
+	assert.Zero(t, version)
+}
+
+// redisTestChange is a change handed to a watcher of a store.
+type redisTestChange struct {
+	id      string
+	value   []byte
+	version int64
+}
+
+func TestRedisStorePersistence_Watch(t *testing.T) {
+	srv := newRedisTestServer(t, "")
+	watcher := newTestRedisStorePersistence(t, srv, "")
+	writer := newTestRedisStorePersistence(t, srv, "")
+
+	changes := make(chan redisTestChange, 10)
+	reloads := make(chan bool, 10)
+	assert.NoError(t, watcher.Watch("herd", func(id string, value []byte, version int64) {
+		changes <- redisTestChange{id, value, version}
+	}, func() { reloads <- true }))
+	assert.NoError(t, watcher.Watch("herd", func(string, []byte, int64) { assert.Fail(t, "watched twice") }, nil))
+	assert.Eventually(t, func() bool { return redisSubscribers(srv, "ranch:store:herd:changes") == 1 },
+		time.Second, time.Millisecond)
+
+	next := func() redisTestChange {
+		select {
+		case change := <-changes:
+			return change
+		case <-time.After(2 * time.Second):
+			assert.Fail(t, "change was not noticed")
+			return redisTestChange{}
+		}
+	}
+
+	// changes made by the watcher itself are not handed back to it.
+	_, err := watcher.Put("herd", "daisy", []byte(`"moo"`), 0)
+	assert.NoError(t, err)
+	_, err = writer.Put("herd", "bessie", []byte(`"moo"`), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, redisTestChange{"bessie", []byte(`"moo"`), 2}, next())
+
+	_, err = writer.Delete("herd", "bessie", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, redisTestChange{"bessie", nil, 3}, next())
+
+	// the items of an update arrive with the same version.
+	_, err = writer.Update("herd", map[string][]byte{"daisy": nil, "ed": []byte(`"neigh"`)}, 0)
+	assert.NoError(t, err)
+	batch := []redisTestChange{next(), next()}
+	assert.ElementsMatch(t, []redisTestChange{{"daisy", nil, 4}, {"ed", []byte(`"neigh"`), 4}}, batch)
+
+	_, err = writer.Replace("herd", map[string][]byte{}, 0)
+	assert.NoError(t, err)
+	select {
+	case <-reloads:
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "replace did not reload the store")
+	}
+
+	// changes made while the connection was lost are missed, the store is reloaded once it is back.
+	srv.Close()
+	assert.NoError(t, srv.Restart())
+	select {
+	case <-reloads:
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "store was not reloaded after reconnecting")
+	}
+	assert.Empty(t, changes)
+
+	watcher.Unwatch("herd")
+	assert.Eventually(t, func() bool { return redisSubscribers(srv, "ranch:store:herd:changes") == 0 },
+		time.Second, time.Millisecond)
+}
+
+func TestRedisStorePersistence_WatchKeyspaceEvents(t *testing.T) {
+	srv := newRedisTestServer(t, "")
+	watcher := newTestRedisStorePersistence(t, srv, "")
+
+	changes := make(chan redisTestChange, 10)
+	reloads := make(chan bool, 10)
+	assert.NoError(t, watcher.Watch("herd", func(id string, value []byte, version int64) {
+		changes <- redisTestChange{id, value, version}
+	}, func() { reloads <- true }))
+	assert.Eventually(t, func() bool { return redisSubscribers(srv, "__keyspace@0__:ranch:store:herd") == 1 },
+		time.Second, time.Millisecond)
+
+	// miniredis raises no keyspace events, they are published the way Redis would.
+	srv.Publish("__keyspace@0__:ranch:store:herd:version", "incrby")
+	srv.Publish("__keyspace@0__:ranch:store:herd", "hset")
+	srv.Publish("ranch:store:herd:changes", `{"origin":"elsewhere","version":1,"items":{"bessie":"\"moo\""}}`)
+	select {
+	case change := <-changes:
+		assert.Equal(t, redisTestChange{"bessie", []byte(`"moo"`), 1}, change)
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "change was not noticed")
+	}
+	assert.Empty(t, reloads)
+
+	// the hash changed without a change being announced, by another tool.
+	srv.Publish("__keyspace@0__:ranch:store:herd", "hset")
+	select {
+	case <-reloads:
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "unannounced change did not reload the store")
+	}
+
+	// deleting the version does not hide the changes that follow.
+	srv.Publish("__keyspace@0__:ranch:store:herd:version", "del")
+	srv.Publish("__keyspace@0__:ranch:store:herd", "del")
+	select {
+	case <-reloads:
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "dropped store was not reloaded")
+	}
+}
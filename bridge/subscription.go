@@ -8,6 +8,7 @@ import (
 	"github.com/go-stomp/stomp/v3"
 	"github.com/google/uuid"
 	"github.com/pb33f/ranch/model"
+	"sync"
 )
 
 type Subscription interface {
@@ -24,6 +25,7 @@ type subscription struct {
 	destination string // Destination of where this message was sent.
 	stompTCPSub *stomp.Subscription
 	wsStompSub  *BridgeClientSub
+	closeOnce   sync.Once
 }
 
 func (s *subscription) GetId() *uuid.UUID {
@@ -38,20 +40,27 @@ func (s *subscription) GetDestination() string {
 	return s.destination
 }
 
+// closeMsgChannel closes the message channel, when unsubscribed or when the connection to the broker is lost.
+func (s *subscription) closeMsgChannel() {
+	s.closeOnce.Do(func() {
+		close(s.c)
+	})
+}
+
 // Unsubscribe from destination. All channels will be closed.
 func (s *subscription) Unsubscribe() error {
 
 	// if we're using TCP
 	if s.stompTCPSub != nil {
 		go s.stompTCPSub.Unsubscribe() // local broker hangs, so lets make sure it is non blocking.
-		close(s.c)
+		s.closeMsgChannel()
 		return nil
 	}
 
 	// if we're using Websockets.
 	if s.wsStompSub != nil {
 		s.wsStompSub.Unsubscribe()
-		close(s.c)
+		s.wsStompSub.closeChannel()
 		return nil
 	}
 	return fmt.Errorf("cannot unsubscribe from destination %s, no connection", s.destination)
@@ -0,0 +1,52 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package bridge
+
+import (
+	"fmt"
+	"sync"
+)
+
+const (
+	TransportSTOMP = "stomp"
+	TransportNATS  = "nats"
+	TransportRedis = "redis"
+)
+
+// TransportAdapter connects to a message transport and returns a Connection that galactic channels can be
+// mapped onto. The STOMP BrokerConnector is the default adapter, others can be plugged in with
+// RegisterTransportAdapter and selected using BrokerConnectorConfig.Transport.
+type TransportAdapter interface {
+	Connect(config *BrokerConnectorConfig, enableLogging bool) (Connection, error)
+}
+
+var (
+	transportAdapters = map[string]func() TransportAdapter{
+		TransportSTOMP: func() TransportAdapter { return NewBrokerConnector() },
+		TransportNATS:  func() TransportAdapter { return NewNATSAdapter() },
+		TransportRedis: func() TransportAdapter { return NewRedisAdapter() },
+	}
+	transportAdaptersLock sync.RWMutex
+)
+
+// RegisterTransportAdapter makes a transport available under name, replacing any existing registration.
+func RegisterTransportAdapter(name string, factory func() TransportAdapter) {
+	transportAdaptersLock.Lock()
+	defer transportAdaptersLock.Unlock()
+	transportAdapters[name] = factory
+}
+
+// NewTransportAdapter creates a new adapter for the named transport.
+func NewTransportAdapter(name string) (TransportAdapter, error) {
+	if name == "" {
+		name = TransportSTOMP
+	}
+	transportAdaptersLock.RLock()
+	factory, ok := transportAdapters[name]
+	transportAdaptersLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown transport '%s'", name)
+	}
+	return factory(), nil
+}
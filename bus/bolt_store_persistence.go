@@ -0,0 +1,160 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package bus
+
+import (
+	"encoding/binary"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltItemsBucket = []byte("items")
+	boltVersionKey  = []byte("version")
+)
+
+// BoltStorePersistence is an embedded StorePersistence backed by a bbolt database file. Every store has a
+// bucket holding its version and a nested bucket of items, each change is a single bbolt transaction, so
+// a change is either saved in full or not at all.
+type BoltStorePersistence struct {
+	db *bolt.DB
+}
+
+// NewBoltStorePersistence opens the bbolt database at path, creating it if missing. If syncWrites is set,
+// every change is flushed to disk before the store changes, surviving power loss at the cost of throughput.
+// Otherwise changes survive the process, but may be lost if the machine goes down.
+func NewBoltStorePersistence(path string, syncWrites bool) (*BoltStorePersistence, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second, NoSync: !syncWrites})
+	if err != nil {
+		return nil, err
+	}
+	return &BoltStorePersistence{db: db}, nil
+}
+
+// Load reads the items and the version of the store.
+func (p *BoltStorePersistence) Load(storeName string) (map[string][]byte, int64, error) {
+	items := make(map[string][]byte)
+	var version int64
+	err := p.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(storeName))
+		if bucket == nil {
+			return nil
+		}
+		if raw := bucket.Get(boltVersionKey); len(raw) == 8 {
+			version = int64(binary.BigEndian.Uint64(raw))
+		}
+		if itemsBucket := bucket.Bucket(boltItemsBucket); itemsBucket != nil {
+			return itemsBucket.ForEach(func(id, value []byte) error {
+				// values are only valid during the transaction.
+				items[string(id)] = append([]byte(nil), value...)
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return items, version, nil
+}
+
+// Put saves the item.
+func (p *BoltStorePersistence) Put(storeName string, id string, value []byte, version int64) (int64, error) {
+	return version, p.update(storeName, version, func(items *bolt.Bucket) error {
+		return items.Put([]byte(id), value)
+	})
+}
+
+// Delete removes the item.
+func (p *BoltStorePersistence) Delete(storeName string, id string, version int64) (int64, error) {
+	return version, p.update(storeName, version, func(items *bolt.Bucket) error {
+		return items.Delete([]byte(id))
+	})
+}
+
+// Update saves and removes the items in a single transaction.
+func (p *BoltStorePersistence) Update(storeName string, items map[string][]byte, version int64) (int64, error) {
+	return version, p.update(storeName, version, func(bucket *bolt.Bucket) error {
+		return putBoltItems(bucket, items)
+	})
+}
+
+// Replace swaps the items of the store for the items.
+func (p *BoltStorePersistence) Replace(storeName string, items map[string][]byte, version int64) (int64, error) {
+	return version, p.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(storeName))
+		if err != nil {
+			return err
+		}
+		if bucket.Bucket(boltItemsBucket) != nil {
+			if err = bucket.DeleteBucket(boltItemsBucket); err != nil {
+				return err
+			}
+		}
+		itemsBucket, err := bucket.CreateBucket(boltItemsBucket)
+		if err != nil {
+			return err
+		}
+		if err = putBoltItems(itemsBucket, items); err != nil {
+			return err
+		}
+		return putBoltVersion(bucket, version)
+	})
+}
+
+// Drop deletes the bucket of the store.
+func (p *BoltStorePersistence) Drop(storeName string) error {
+	return p.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(storeName)) == nil {
+			return nil
+		}
+		return tx.DeleteBucket([]byte(storeName))
+	})
+}
+
+// Close closes the database, the persistence must not be used afterwards.
+func (p *BoltStorePersistence) Close() error {
+	return p.db.Close()
+}
+
+// update changes the items of the store and saves the version in one transaction.
+func (p *BoltStorePersistence) update(storeName string, version int64, change func(items *bolt.Bucket) error) error {
+	return p.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(storeName))
+		if err != nil {
+			return err
+		}
+		items, err := bucket.CreateBucketIfNotExists(boltItemsBucket)
+		if err != nil {
+			return err
+		}
+		if err = change(items); err != nil {
+			return err
+		}
+		return putBoltVersion(bucket, version)
+	})
+}
+
+// putBoltItems saves the items in the bucket, removing the ones with a nil value.
+func putBoltItems(bucket *bolt.Bucket, items map[string][]byte) error {
+	for id, value := range items {
+		var err error
+		if value == nil {
+			err = bucket.Delete([]byte(id))
+		} else {
+			err = bucket.Put([]byte(id), value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func putBoltVersion(bucket *bolt.Bucket, version int64) error {
+	raw := make([]byte, 8)
+	binary.BigEndian.PutUint64(raw, uint64(version))
+	return bucket.Put(boltVersionKey, raw)
+}
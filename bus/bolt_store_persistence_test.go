@@ -0,0 +1,53 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package bus
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBoltStorePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stores.db")
+	p, err := NewBoltStorePersistence(path, true)
+	assert.NoError(t, err)
+
+	items, version, err := p.Load("cattle")
+	assert.NoError(t, err)
+	assert.Empty(t, items)
+	assert.Zero(t, version)
+
+	saved := func(version int64, err error) int64 {
+		assert.NoError(t, err)
+		return version
+	}
+	assert.EqualValues(t, 2, saved(p.Put("cattle", "bessie", []byte(`"moo"`), 2)))
+	assert.EqualValues(t, 3, saved(p.Put("cattle", "daisy", []byte(`"moo"`), 3)))
+	assert.EqualValues(t, 4, saved(p.Update("cattle", map[string][]byte{"bessie": []byte(`"baa"`), "ed": nil}, 4)))
+	assert.EqualValues(t, 5, saved(p.Delete("cattle", "daisy", 5)))
+	assert.EqualValues(t, 7, saved(p.Replace("horses", map[string][]byte{"ed": []byte(`"neigh"`)}, 7)))
+	assert.NoError(t, p.Close())
+
+	// the items are there after reopening the database.
+	p, err = NewBoltStorePersistence(path, false)
+	assert.NoError(t, err)
+	defer p.Close()
+	items, version, err = p.Load("cattle")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"bessie": []byte(`"baa"`)}, items)
+	assert.EqualValues(t, 5, version)
+
+	assert.EqualValues(t, 8, saved(p.Replace("horses", map[string][]byte{"trigger": []byte(`"neigh"`)}, 8)))
+	items, version, _ = p.Load("horses")
+	assert.Equal(t, map[string][]byte{"trigger": []byte(`"neigh"`)}, items)
+	assert.EqualValues(t, 8, version)
+
+	assert.NoError(t, p.Drop("horses"))
+	assert.NoError(t, p.Drop("horses"))
+	items, version, _ = p.Load("horses")
+	assert.Empty(t, items)
+	assert.Zero(t, version)
+}
@@ -23,6 +23,8 @@ type Channel struct {
 	brokerSubs                []*connectionSub
 	brokerConns               []bridge.Connection
 	brokerMappedEvent         chan bool
+	validator                 PayloadValidator // checks payloads sent through the bus, guarded by channelLock
+	sent                      atomic.Uint64    // messages sent on the channel
 }
 
 // Create a new Channel with the supplied Channel name. Returns a pointer to that Channel.
@@ -69,6 +71,7 @@ func (channel *Channel) IsPrivate() bool {
 
 // Send a new message on this Channel, to all event handlers.
 func (channel *Channel) Send(message *model.Message) {
+	channel.sent.Add(1)
 	channel.channelLock.Lock()
 	defer channel.channelLock.Unlock()
 	if eventHandlers := channel.eventHandlers; len(eventHandlers) > 0 {
@@ -88,11 +91,22 @@ func (channel *Channel) Send(message *model.Message) {
 	}
 }
 
+// Returns how many messages were sent on the Channel since it was created
+func (channel *Channel) MessagesSent() uint64 {
+	return channel.sent.Load()
+}
+
 // Check if the Channel has any registered subscribers
 func (channel *Channel) ContainsHandlers() bool {
 	return len(channel.eventHandlers) > 0
 }
 
+func (channel *Channel) countHandlers() int {
+	channel.channelLock.Lock()
+	defer channel.channelLock.Unlock()
+	return len(channel.eventHandlers)
+}
+
 // Send message to handler function
 func (channel *Channel) sendMessageToHandler(handler *channelEventHandler, message *model.Message) {
 	handler.callBackFunction(message)
@@ -136,7 +150,7 @@ func (channel *Channel) removeEventHandler(index int) {
 	channel.eventHandlers = channel.eventHandlers[:numHandlers-1]
 }
 
-func (channel *Channel) listenToBrokerSubscription(sub bridge.Subscription) {
+func (channel *Channel) listenToBrokerSubscription(sub bridge.Subscription, lost func()) {
 	for {
 		msg, m := <-sub.GetMsgChannel()
 		if m {
@@ -145,6 +159,11 @@ func (channel *Channel) listenToBrokerSubscription(sub bridge.Subscription) {
 			break
 		}
 	}
+
+	// a subscription still mapped was not unsubscribed, it closed with its connection to the broker.
+	if channel.forgetBrokerSubscription(sub) && lost != nil {
+		lost()
+	}
 }
 
 func (channel *Channel) isBrokerSubscribed(sub bridge.Subscription) bool {
@@ -191,25 +210,41 @@ func (channel *Channel) removeBrokerConnections() {
 	channel.brokerConns = []bridge.Connection{}
 }
 
-func (channel *Channel) addBrokerSubscription(conn bridge.Connection, sub bridge.Subscription) {
+// addBrokerSubscription relays the messages of a broker subscription to the channel. lost, if set, is called
+// if the subscription closes without being removed first, when its connection to the broker is lost.
+func (channel *Channel) addBrokerSubscription(conn bridge.Connection, sub bridge.Subscription, lost func()) {
 	cs := &connectionSub{c: conn, s: sub}
 
 	channel.channelLock.Lock()
 	channel.brokerSubs = append(channel.brokerSubs, cs)
 	channel.channelLock.Unlock()
 
-	go channel.listenToBrokerSubscription(sub)
+	go channel.listenToBrokerSubscription(sub, lost)
 }
 
 func (channel *Channel) removeBrokerSubscription(sub bridge.Subscription) {
+	channel.forgetBrokerSubscription(sub)
+}
+
+// forgetBrokerSubscription removes a broker subscription, returning whether the channel had it.
+func (channel *Channel) forgetBrokerSubscription(sub bridge.Subscription) bool {
 	channel.channelLock.Lock()
 	defer channel.channelLock.Unlock()
 
 	for i, cs := range channel.brokerSubs {
 		if sub.GetId().String() == cs.s.GetId().String() {
 			channel.brokerSubs = removeSub(channel.brokerSubs, i)
+			return true
 		}
 	}
+	return false
+}
+
+// getBrokerSubscriptions returns the broker subscriptions of the channel.
+func (channel *Channel) getBrokerSubscriptions() []*connectionSub {
+	channel.channelLock.Lock()
+	defer channel.channelLock.Unlock()
+	return append([]*connectionSub(nil), channel.brokerSubs...)
 }
 
 func removeSub(s []*connectionSub, i int) []*connectionSub {
@@ -5,6 +5,7 @@ package bus
 
 import (
 	"github.com/google/uuid"
+	"github.com/pb33f/ranch/model"
 )
 
 type channelEventHandler struct {
@@ -12,4 +13,5 @@ type channelEventHandler struct {
 	runOnce          bool
 	runCount         int64
 	uuid             *uuid.UUID
+	filter           func(*model.Message) bool // the messages the handler wants, nil if it wants all of them
 }
@@ -0,0 +1,51 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package bus
+
+const (
+	CHANNEL_LIFECYCLE_CHANNEL = RANCH_INTERNAL_CHANNEL_PREFIX + "channel-lifecycle"
+)
+
+type ChannelLifecycleEventType int
+
+const (
+	ChannelCreated ChannelLifecycleEventType = iota
+	ChannelDestroyed
+	SubscriberAdded
+	SubscriberRemoved
+)
+
+// ChannelLifecycleEvent is broadcast on CHANNEL_LIFECYCLE_CHANNEL every time the topology of the bus changes.
+type ChannelLifecycleEvent struct {
+	Channel     string                    `json:"channel"`     // name of the channel the event relates to
+	EventType   ChannelLifecycleEventType `json:"eventType"`   // what happened to the channel
+	Subscribers int                       `json:"subscribers"` // number of handlers on the channel after the change
+}
+
+var monitorToLifecycleEvent = map[MonitorEventType]ChannelLifecycleEventType{
+	ChannelCreatedEvt:          ChannelCreated,
+	ChannelDestroyedEvt:        ChannelDestroyed,
+	ChannelSubscriberJoinedEvt: SubscriberAdded,
+	ChannelSubscriberLeftEvt:   SubscriberRemoved,
+}
+
+// initChannelLifecycleStream creates the lifecycle channel and relays channel related monitor events
+// onto it, so anything on the bus can react to channels coming and going.
+func (bus *transportEventBus) initChannelLifecycleStream() {
+	bus.ChannelManager.CreateChannel(CHANNEL_LIFECYCLE_CHANNEL)
+	bus.AddMonitorEventListener(func(monitorEvt *MonitorEvent) {
+		// never report on the lifecycle channel itself, listening to it would cause a feedback loop.
+		if monitorEvt.EntityName == CHANNEL_LIFECYCLE_CHANNEL {
+			return
+		}
+		evt := &ChannelLifecycleEvent{
+			Channel:   monitorEvt.EntityName,
+			EventType: monitorToLifecycleEvent[monitorEvt.EventType],
+		}
+		if ch, err := bus.ChannelManager.GetChannel(monitorEvt.EntityName); err == nil {
+			evt.Subscribers = ch.countHandlers()
+		}
+		bus.SendResponseMessage(CHANNEL_LIFECYCLE_CHANNEL, evt, nil)
+	}, ChannelCreatedEvt, ChannelDestroyedEvt, ChannelSubscriberJoinedEvt, ChannelSubscriberLeftEvt)
+}
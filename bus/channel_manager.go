@@ -54,8 +54,16 @@ func (manager *busChannelManager) CreateChannel(channelName string) *Channel {
 	return manager.Channels[channelName]
 }
 
-// Destroy a Channel and all the handlers listening on it.
+// Destroy a Channel and all the handlers listening on it. If the channel is galactic, the broker
+// mappings are torn down first.
 func (manager *busChannelManager) DestroyChannel(channelName string) {
+	if channel, err := manager.GetChannel(channelName); err == nil {
+		if channel.IsGalactic() {
+			manager.MarkChannelAsLocal(channelName)
+		}
+		manager.bus.checkDestroyedChannel(channel)
+	}
+
 	manager.lock.Lock()
 	defer manager.lock.Unlock()
 
@@ -77,7 +85,13 @@ func (manager *busChannelManager) GetChannel(channelName string) (*Channel, erro
 
 // Get all channels currently open. Returns a map of Channel names and pointers to those Channel objects.
 func (manager *busChannelManager) GetAllChannels() map[string]*Channel {
-	return manager.Channels
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+	channels := make(map[string]*Channel, len(manager.Channels))
+	for name, channel := range manager.Channels {
+		channels[name] = channel
+	}
+	return channels
 }
 
 // Check Channel exists, returns true if so.
@@ -175,7 +189,14 @@ func (manager *busChannelManager) handleGalacticChannelEvent(channelName string,
 			ch.addBrokerConnection(ge.conn)
 
 			m := model.GenerateResponse(&model.MessageConfig{Payload: ge.dest}) // set the mapped destination as the payload
-			ch.addBrokerSubscription(ge.conn, sub)
+			ch.addBrokerSubscription(ge.conn, sub, func() {
+				lost := model.GenerateResponse(&model.MessageConfig{Payload: ge.dest})
+				manager.bus.SendMonitorEvent(BrokerSubscriptionLostEvt, channelName, lost)
+				select {
+				case ch.brokerMappedEvent <- false: // let channel watcher know, the channel is un-mapped
+				default: // if no-one is listening, drop.
+				}
+			})
 			manager.bus.SendMonitorEvent(BrokerSubscribedEvt, channelName, m)
 			select {
 			case ch.brokerMappedEvent <- true: // let channel watcher know, the channel is mapped
@@ -188,9 +209,10 @@ func (manager *busChannelManager) handleGalacticChannelEvent(channelName string,
 func (manager *busChannelManager) handleLocalChannelEvent(channelName string) {
 	ch, _ := manager.GetChannel(channelName)
 	// loop through all the connections we have mapped, and subscribe!
-	for _, s := range ch.brokerSubs {
+	for _, s := range ch.getBrokerSubscriptions() {
+		// forgotten first, so the subscription closing is not mistaken for a lost connection
+		ch.removeBrokerSubscription(s.s)
 		if e := s.s.Unsubscribe(); e == nil {
-			ch.removeBrokerSubscription(s.s)
 			m := model.GenerateResponse(&model.MessageConfig{Payload: s.s.GetDestination()}) // set the unmapped destination as the payload
 			manager.bus.SendMonitorEvent(BrokerUnsubscribedEvt, channelName, m)
 			select {
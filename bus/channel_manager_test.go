@@ -292,6 +292,44 @@ func TestChannelManager_TestListenToMonitorLocal(t *testing.T) {
 	assert.Len(t, c.brokerSubs, 0)
 }
 
+func TestChannelManager_TestBrokerSubscriptionLost(t *testing.T) {
+	myChan := "mychan-lost"
+
+	b := newTestEventBus()
+	testChannelManager = b.GetChannelManager()
+	c := testChannelManager.CreateChannel(myChan)
+
+	lost := make(chan *MonitorEvent, 1)
+	b.AddMonitorEventListener(func(event *MonitorEvent) {
+		lost <- event
+	}, BrokerSubscriptionLostEvt)
+
+	subId := uuid.New()
+	sub := &MockBridgeSubscription{
+		Id:          &subId,
+		Channel:     make(chan *model.Message),
+		Destination: "/queue/gone",
+	}
+	id := uuid.New()
+	mockCon := &MockBridgeConnection{Id: &id}
+	mockCon.On("Subscribe", "/queue/gone").Return(sub, nil).Once()
+
+	testChannelManager.MarkChannelAsGalactic(myChan, "/queue/gone", mockCon)
+	<-c.brokerMappedEvent
+
+	// the connection to the broker drops, closing the subscription
+	close(sub.Channel)
+
+	select {
+	case event := <-lost:
+		assert.Equal(t, myChan, event.EntityName)
+		assert.Equal(t, "/queue/gone", event.Data.(*model.Message).Payload)
+	case <-time.After(5 * time.Second):
+		assert.FailNow(t, "TestChannelManager_TestBrokerSubscriptionLost timeout on lost subscription")
+	}
+	assert.Len(t, c.getBrokerSubscriptions(), 0)
+}
+
 func TestChannelManager_TestGalacticMonitorInvalidChannel(t *testing.T) {
 	testChannelManager, _ = createManager()
 	testChannelManager.CreateChannel("fun-chan")
@@ -308,3 +346,66 @@ func TestChannelManager_TestLocalMonitorInvalidChannel(t *testing.T) {
 	err := testChannelManager.MarkChannelAsLocal("fun-chan")
 	assert.Nil(t, err)
 }
+
+func TestChannelManager_LifecycleEvents(t *testing.T) {
+	b := newTestEventBus()
+	cm := b.GetChannelManager()
+
+	events := make(chan *ChannelLifecycleEvent, 10)
+	h, err := b.ListenStream(CHANNEL_LIFECYCLE_CHANNEL)
+	assert.Nil(t, err)
+	h.Handle(func(msg *model.Message) {
+		evt := msg.Payload.(*ChannelLifecycleEvent)
+		if evt.Channel == "lifecycle-chan" {
+			events <- evt
+		}
+	}, func(err error) {})
+
+	waitForEvent := func(expected ChannelLifecycleEventType) *ChannelLifecycleEvent {
+		select {
+		case evt := <-events:
+			assert.Equal(t, expected, evt.EventType)
+			return evt
+		case <-time.After(5 * time.Second):
+			assert.FailNow(t, "TestChannelManager_LifecycleEvents timeout on lifecycle event")
+		}
+		return nil
+	}
+
+	cm.CreateChannel("lifecycle-chan")
+	waitForEvent(ChannelCreated)
+
+	id, _ := cm.SubscribeChannelHandler("lifecycle-chan", func(*model.Message) {}, false)
+	assert.Equal(t, 1, waitForEvent(SubscriberAdded).Subscribers)
+
+	cm.UnsubscribeChannelHandler("lifecycle-chan", id)
+	assert.Equal(t, 0, waitForEvent(SubscriberRemoved).Subscribers)
+
+	cm.DestroyChannel("lifecycle-chan")
+	waitForEvent(ChannelDestroyed)
+}
+
+func TestChannelManager_DestroyGalacticChannel(t *testing.T) {
+	b := newTestEventBus()
+	cm := b.GetChannelManager()
+	c := cm.CreateChannel("galactic-doomed")
+
+	subId := uuid.New()
+	sub := &MockBridgeSubscription{
+		Id: &subId,
+	}
+
+	id := uuid.New()
+	mockCon := &MockBridgeConnection{Id: &id}
+	mockCon.On("Subscribe", "/queue/doomed").Return(sub, nil).Once()
+
+	cm.MarkChannelAsGalactic("galactic-doomed", "/queue/doomed", mockCon)
+	<-c.brokerMappedEvent
+	assert.Len(t, c.brokerSubs, 1)
+
+	cm.DestroyChannel("galactic-doomed")
+	<-c.brokerMappedEvent
+	assert.False(t, c.IsGalactic())
+	assert.Len(t, c.brokerSubs, 0)
+	assert.False(t, cm.CheckChannelExists("galactic-doomed"))
+}
@@ -65,6 +65,7 @@ func TestChannel_SendMessage(t *testing.T) {
 
 	channel.Send(message)
 	channel.wg.Wait()
+	assert.Equal(t, uint64(1), channel.MessagesSent())
 }
 
 func TestChannel_SendMessageRunOnceHasRun(t *testing.T) {
@@ -215,7 +216,7 @@ func TestChannel_AddRemoveBrokerSubscription(t *testing.T) {
 	id := uuid.New()
 	sub := &MockBridgeSubscription{Id: &id}
 	c := &MockBridgeConnection{Id: &id}
-	channel.addBrokerSubscription(c, sub)
+	channel.addBrokerSubscription(c, sub, nil)
 	assert.Len(t, channel.brokerSubs, 1)
 	channel.removeBrokerSubscription(sub)
 	assert.Len(t, channel.brokerSubs, 0)
@@ -235,7 +236,7 @@ func TestChannel_CheckIfBrokerSubscribed(t *testing.T) {
 
 	cm := NewBusChannelManager(GetBus())
 	ch := cm.CreateChannel("testing-broker-subs")
-	ch.addBrokerSubscription(c, s)
+	ch.addBrokerSubscription(c, s, nil)
 	assert.True(t, ch.isBrokerSubscribed(s))
 	assert.False(t, ch.isBrokerSubscribed(s2))
 
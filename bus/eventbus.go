@@ -4,13 +4,16 @@
 package bus
 
 import (
+	"context"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/pb33f/ranch/bridge"
 	"github.com/pb33f/ranch/model"
 	"github.com/pb33f/ranch/stompserver"
+	"io"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 const RANCH_INTERNAL_CHANNEL_PREFIX = "_ranchInternal/"
@@ -40,12 +43,19 @@ type EventBus interface {
 	ConnectBroker(config *bridge.BrokerConnectorConfig) (conn bridge.Connection, err error)
 	StartFabricEndpoint(connectionListener stompserver.RawConnectionListener, config EndpointConfig) error
 	StopFabricEndpoint() error
+	DrainFabricEndpoint(ctx context.Context, grace time.Duration) error
+	GetFabricConnections() []*stompserver.ConnectionInfo
+	RecordFabricTraffic(recorder *stompserver.Recorder) (*stompserver.Recorder, error)
+	ReplayFabricRecording(ctx context.Context, recording io.Reader, config ReplayConfig) (*ReplayReport, error)
+	SetFabricBackplane(backplane stompserver.Backplane) (stompserver.Backplane, error)
 	GetStoreManager() StoreManager
 	CreateSyncTransaction() BusTransaction
 	CreateAsyncTransaction() BusTransaction
 	AddMonitorEventListener(listener MonitorEventHandler, eventTypes ...MonitorEventType) MonitorEventListenerId
 	RemoveMonitorEventListener(listenerId MonitorEventListenerId)
 	SendMonitorEvent(evtType MonitorEventType, entityName string, data interface{})
+	EnableStrictMode(handler StrictModeHandler)
+	DisableStrictMode()
 }
 
 var enableLogging bool = false
@@ -87,6 +97,8 @@ type transportEventBus struct {
 	initStoreSync     sync.Once
 	storeSyncService  *storeSyncService
 	monitor           *transportMonitor
+	strictLock        sync.RWMutex
+	strictHandler     StrictModeHandler
 }
 
 type MonitorEventListenerId int
@@ -160,6 +172,7 @@ func (bus *transportEventBus) init() {
 	bus.brokerConnections = make(map[*uuid.UUID]bridge.Connection)
 	bus.bc = bridge.NewBrokerConnector()
 	bus.monitor = newMonitor()
+	bus.initChannelLifecycleStream()
 	if enableLogging {
 		fmt.Printf("🌈 ranch booted with Id [%s]\n", bus.Id.String())
 	}
@@ -175,7 +188,7 @@ func (bus *transportEventBus) GetChannelManager() ChannelManager {
 }
 
 // SendResponseMessage Send a ResponseDir type (inbound) message on Channel, with supplied Payload.
-// Throws error if the Channel does not exist.
+// Throws error if the Channel does not exist, or if the Channel validator rejects the Payload.
 func (bus *transportEventBus) SendResponseMessage(channelName string, payload interface{}, destId *uuid.UUID) error {
 	channelObject, err := bus.ChannelManager.GetChannel(channelName)
 	if err != nil {
@@ -183,12 +196,12 @@ func (bus *transportEventBus) SendResponseMessage(channelName string, payload in
 	}
 	config := buildConfig(channelName, payload, destId)
 	message := model.GenerateResponse(config)
-	sendMessageToChannel(channelObject, message)
-	return nil
+	return bus.sendMessageToChannel(channelObject, message)
 }
 
 // SendBroadcastMessage sends the payload as an outbound broadcast message to channelName. Since it is a broadcast,
-// the payload does not require a destination ID. Throws an error if the channel does not exist.
+// the payload does not require a destination ID. Throws an error if the channel does not exist, or if the
+// channel validator rejects the payload.
 func (bus *transportEventBus) SendBroadcastMessage(channelName string, payload interface{}) error {
 	channelObject, err := bus.ChannelManager.GetChannel(channelName)
 	if err != nil {
@@ -196,12 +209,11 @@ func (bus *transportEventBus) SendBroadcastMessage(channelName string, payload i
 	}
 	config := buildConfig(channelName, payload, nil)
 	message := model.GenerateResponse(config)
-	sendMessageToChannel(channelObject, message)
-	return nil
+	return bus.sendMessageToChannel(channelObject, message)
 }
 
 // SendRequestMessage Send a RequestDir type message (outbound) message on Channel, with supplied Payload.
-// Throws error if the Channel does not exist.
+// Throws error if the Channel does not exist, or if the Channel validator rejects the Payload.
 func (bus *transportEventBus) SendRequestMessage(channelName string, payload interface{}, destId *uuid.UUID) error {
 	channelObject, err := bus.ChannelManager.GetChannel(channelName)
 	if err != nil {
@@ -209,8 +221,7 @@ func (bus *transportEventBus) SendRequestMessage(channelName string, payload int
 	}
 	config := buildConfig(channelName, payload, destId)
 	message := model.GenerateRequest(config)
-	sendMessageToChannel(channelObject, message)
-	return nil
+	return bus.sendMessageToChannel(channelObject, message)
 }
 
 // SendErrorMessage Send a ErrorDir type message (outbound) message on Channel, with supplied error
@@ -222,7 +233,7 @@ func (bus *transportEventBus) SendErrorMessage(channelName string, err error, de
 	}
 	config := buildError(channelName, err, destId)
 	message := model.GenerateError(config)
-	sendMessageToChannel(channelObject, message)
+	bus.sendMessageToChannel(channelObject, message)
 	return nil
 }
 
@@ -449,8 +460,17 @@ func (bus *transportEventBus) RequestStreamForDestination(
 }
 
 // ConnectBroker Connect to a message broker. If successful, you get a pointer to a Connection. If not, you will get an error.
+// The STOMP broker connector is used unless config.Transport selects another transport adapter.
 func (bus *transportEventBus) ConnectBroker(config *bridge.BrokerConnectorConfig) (conn bridge.Connection, err error) {
-	conn, err = bus.bc.Connect(config, enableLogging)
+	if config != nil && config.Transport != "" && config.Transport != bridge.TransportSTOMP {
+		var adapter bridge.TransportAdapter
+		if adapter, err = bridge.NewTransportAdapter(config.Transport); err != nil {
+			return nil, err
+		}
+		conn, err = adapter.Connect(config, enableLogging)
+	} else {
+		conn, err = bus.bc.Connect(config, enableLogging)
+	}
 	if conn != nil {
 		bus.brokerConnections[conn.GetId()] = conn
 	}
@@ -490,6 +510,62 @@ func (bus *transportEventBus) StopFabricEndpoint() error {
 	return nil
 }
 
+// DrainFabricEndpoint closes the connections of the running fabric endpoint gracefully before it is stopped:
+// clients are sent a shutdown notice and refused new subscriptions, and after grace, the messages queued
+// for them are sent and their connections closed with an ERROR frame. It returns once they are closed, or
+// ctx is done.
+func (bus *transportEventBus) DrainFabricEndpoint(ctx context.Context, grace time.Duration) error {
+	fe := bus.fabEndpoint
+	if fe == nil {
+		return fmt.Errorf("unable to drain: fabric endpoint is not running")
+	}
+	fe.Drain(ctx, grace)
+	return nil
+}
+
+// GetFabricConnections describes the connections of the running fabric endpoint and their subscriptions,
+// nil if no fabric endpoint is running.
+func (bus *transportEventBus) GetFabricConnections() []*stompserver.ConnectionInfo {
+	fe := bus.fabEndpoint
+	if fe == nil {
+		return nil
+	}
+	return fe.Connections()
+}
+
+// RecordFabricTraffic records the frames going through the running fabric endpoint with recorder, or stops
+// recording them if recorder is nil. It returns the recorder replaced, for the caller to close.
+func (bus *transportEventBus) RecordFabricTraffic(recorder *stompserver.Recorder) (*stompserver.Recorder, error) {
+	fe := bus.fabEndpoint
+	if fe == nil {
+		return nil, fmt.Errorf("unable to record: fabric endpoint is not running")
+	}
+	return fe.SetRecorder(recorder), nil
+}
+
+// ReplayFabricRecording replays a recording of a fabric endpoint onto the bus, through the running fabric
+// endpoint which maps its destinations onto channels. See ReplayConfig.
+func (bus *transportEventBus) ReplayFabricRecording(ctx context.Context, recording io.Reader,
+	config ReplayConfig) (*ReplayReport, error) {
+
+	fe := bus.fabEndpoint
+	if fe == nil {
+		return nil, fmt.Errorf("unable to replay: fabric endpoint is not running")
+	}
+	return fe.Replay(ctx, recording, config)
+}
+
+// SetFabricBackplane carries the messages the running fabric endpoint broadcasts to the fabric endpoints of
+// the other instances of a deployment through backplane, and theirs to its clients, or stops if backplane
+// is nil. It returns the backplane replaced. See stompserver.Backplane.
+func (bus *transportEventBus) SetFabricBackplane(backplane stompserver.Backplane) (stompserver.Backplane, error) {
+	fe := bus.fabEndpoint
+	if fe == nil {
+		return nil, fmt.Errorf("unable to set backplane: fabric endpoint is not running")
+	}
+	return fe.SetBackplane(backplane), nil
+}
+
 func (bus *transportEventBus) CreateAsyncTransaction() BusTransaction {
 	return newBusTransaction(bus, asyncTransaction)
 }
@@ -510,6 +586,7 @@ func (bus *transportEventBus) wrapMessageHandler(
 
 	messageHandler := createMessageHandler(channel, destId, bus.ChannelManager)
 	messageHandler.ignoreId = ignoreId
+	messageHandler.bus = bus
 
 	if runOnce {
 		messageHandler.invokeOnce = &sync.Once{}
@@ -548,29 +625,27 @@ func (bus *transportEventBus) wrapMessageHandler(
 		}
 	}
 
-	handlerWrapper := func(msg *model.Message) {
-		dir := direction
+	messageHandler.accepts = func(msg *model.Message) bool {
+		if allTraffic || msg.Direction == model.ErrorDir {
+			return true
+		}
+		if msg.Direction != direction {
+			return false
+		}
+		// if we're checking for specific traffic, check a DestinationId match is required.
 		id := messageHandler.destination
-		if allTraffic {
-			if msg.Direction == model.ErrorDir {
-				errorHandler(msg.Error)
-			} else {
-				successHandler(msg)
-			}
+		return messageHandler.ignoreId ||
+			((msg.DestinationId != nil && id != nil) && (id.String() == msg.DestinationId.String()))
+	}
+
+	handlerWrapper := func(msg *model.Message) {
+		if !messageHandler.accepts(msg) {
+			return
+		}
+		if msg.Direction == model.ErrorDir {
+			errorHandler(msg.Error)
 		} else {
-			if msg.Direction == dir {
-				// if we're checking for specific traffic, check a DestinationId match is required.
-				if !messageHandler.ignoreId &&
-					(msg.DestinationId != nil && id != nil) && (id.String() == msg.DestinationId.String()) {
-					successHandler(msg)
-				}
-				if messageHandler.ignoreId {
-					successHandler(msg)
-				}
-			}
-			if msg.Direction == model.ErrorDir {
-				errorHandler(msg.Error)
-			}
+			successHandler(msg)
 		}
 	}
 
@@ -586,8 +661,16 @@ func checkForSuppliedId(id *uuid.UUID) *uuid.UUID {
 	return id
 }
 
-func sendMessageToChannel(channelObject *Channel, message *model.Message) {
+// sendMessageToChannel sends the message unless the channel validator rejects its payload. In strict mode,
+// the message is checked against the handlers of the channel before it is sent, as runOnce handlers may
+// leave once it is.
+func (bus *transportEventBus) sendMessageToChannel(channelObject *Channel, message *model.Message) error {
+	if err := channelObject.validate(message); err != nil {
+		return err
+	}
+	bus.checkStrictMode(channelObject, message)
 	channelObject.Send(message)
+	return nil
 }
 
 func buildConfig(channelName string, payload interface{}, destinationId *uuid.UUID) *model.MessageConfig {
@@ -4,6 +4,7 @@
 package bus
 
 import (
+    "context"
     "errors"
     "fmt"
     "github.com/google/uuid"
@@ -708,6 +709,8 @@ func TestBifrostEventBus_StartFabricEndpoint(t *testing.T) {
 
     err = bus.StartFabricEndpoint(connListener, EndpointConfig{TopicPrefix: "/topic"})
     assert.EqualError(t, err, "unable to start: fabric endpoint is already running")
+    assert.Empty(t, bus.GetFabricConnections())
+    assert.NoError(t, bus.DrainFabricEndpoint(context.Background(), 0))
 
     connListener.wg.Add(1)
     bus.StopFabricEndpoint()
@@ -717,6 +720,9 @@ func TestBifrostEventBus_StartFabricEndpoint(t *testing.T) {
     assert.True(t, connListener.stopped)
 
     assert.EqualError(t, bus.StopFabricEndpoint(), "unable to stop: fabric endpoint is not running")
+    assert.Nil(t, bus.GetFabricConnections())
+    assert.EqualError(t, bus.DrainFabricEndpoint(context.Background(), 0),
+        "unable to drain: fabric endpoint is not running")
 }
 
 func TestBifrostEventBus_AddMonitorEventListener(t *testing.T) {
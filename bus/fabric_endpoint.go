@@ -4,20 +4,28 @@
 package bus
 
 import (
+    "context"
     "encoding/json"
+    "errors"
     "fmt"
     "github.com/go-stomp/stomp/v3/frame"
     "github.com/pb33f/ranch/log"
     "github.com/pb33f/ranch/model"
     "github.com/pb33f/ranch/stompserver"
+    "io"
     "strings"
     "sync"
+    "time"
 )
 
 const (
     STOMP_SESSION_NOTIFY_CHANNEL = RANCH_INTERNAL_CHANNEL_PREFIX + "stomp-session-notify"
 )
 
+// replyMappingSuffix follows the connection id of the channel mappings relaying responses to temporary
+// reply destinations, it cannot be mistaken for a subscription id which follows a '#'.
+const replyMappingSuffix = "@reply"
+
 type EndpointConfig struct {
     // Prefix for public topics e.g. "/topic"
     TopicPrefix string
@@ -33,10 +41,83 @@ type EndpointConfig struct {
     // "/user/queue/sample-channel" destination.
     // This behavior will mimic the Spring SimpleMessageBroker implementation.
     AppRequestQueuePrefix string
-    Heartbeat             int64
+    // Prefix for point-to-point queues e.g. "/queue", no queues if empty. Each message sent to a queue is
+    // delivered to one of its subscribers in turn, so clients subscribed to it form a pool of workers.
+    // Responses on a channel are delivered to one subscriber of its queue, as well as to every subscriber
+    // of its topic, and clients may send messages to queues. Must not overlap the other prefixes, e.g. set
+    // UserQueuePrefix to "/user/queue" to use "/queue".
+    QueuePrefix string
+    // Prefix for temporary reply destinations e.g. "/temp-queue", none if empty. A client subscribes to a
+    // destination of its choice under it, e.g. "/temp-queue/orders", and names it in the
+    // stompserver.ReplyToHeader of its requests: the responses to them are then delivered to that
+    // subscription of the client only, rather than to every subscriber of the channel. Each session has
+    // its own temporary reply destinations, they go away when it closes. Must not overlap the other
+    // prefixes.
+    TempQueuePrefix string
+    Heartbeat       int64
 
     // Custom middleware for broker commands and destinations.
     MiddlewareRegistry stompserver.MiddlewareRegistry
+
+    // Revoked session tokens, rejected by the broker. If not set, the endpoint creates its own list.
+    // Revocations published on TOKEN_REVOCATION_CHANNEL are added to this list.
+    RevocationList *stompserver.RevocationList
+
+    // Authenticates clients when they connect, e.g. stompserver.NewJwtAuthenticator. Clients failing to
+    // authenticate are rejected, the others take the principal it returns.
+    Authenticator stompserver.Authenticator `json:"-"`
+
+    // Resolves the principal of a client once it has connected, e.g. from its session token. Requests the
+    // client sends carry it in model.Request.Principal, and store access control is checked against it.
+    // Defaults to the principal the Authenticator returned, clients are anonymous if neither is set.
+    Principal func(conn stompserver.StompConn) string `json:"-"`
+
+    // Decides whether a client may read or write a destination. Called with frame.SUBSCRIBE or frame.SEND,
+    // the destination of the frame and the principal of the client, empty if anonymous. Unless it returns nil
+    // the frame is refused with the error and the client disconnected. Every destination is allowed if not set.
+    Authorize func(command string, destination string, principal string) error `json:"-"`
+
+    // Labels a client with the class of traffic it belongs to once it has connected, e.g. from the headers
+    // of its CONNECT frame or the claims of its session token. Requests the client sends carry the labels in
+    // model.Request.Labels, they must not be modified. Clients are not labeled if not set.
+    Labels func(conn stompserver.StompConn, connect *frame.Frame) map[string]string `json:"-"`
+
+    // Shapes the error responses sent to clients, e.g. model.ProblemDetailsErrorConverter. Error responses
+    // are sent as they are if not set.
+    ErrorConverter model.ErrorConverter `json:"-"`
+
+    // When clients are told that messages queue up for one of their subscriptions. Clients subscribed to
+    // stompserver.BackpressureAdvisoryDestination receive the advisories, and the BackpressureApplied and
+    // BackpressureReleased events are published on STOMP_SESSION_NOTIFY_CHANNEL. Disabled if not set.
+    Backpressure stompserver.BackpressureConfig
+
+    // How many heart-beat intervals a client may send nothing for before it is disconnected, 2 if not set.
+    // Closed connections are published on STOMP_SESSION_NOTIFY_CHANNEL with the reason they were closed,
+    // so services can release what they hold for the session.
+    MaxMissedHeartBeats int
+
+    // How fast each client may send frames, over its connection and to each destination, and whether
+    // clients sending faster are slowed down or disconnected. Not limited if not set.
+    RateLimits stompserver.RateLimitConfig
+
+    // How many messages are queued for durable subscribers while they are offline, and for how long, so
+    // browser sessions connecting with a stompserver.ClientIdHeader and subscribing with a
+    // stompserver.DurableHeader miss nothing across page reloads. Not supported if not set.
+    DurableSubscriptions stompserver.DurableSubscriptionConfig
+
+    // Carries the messages broadcast on the channels to the fabric endpoints of the other instances of a
+    // deployment, and theirs to the clients of this one, so clients may connect to any instance behind a
+    // load balancer. Instances are on their own if not set. See stompserver.Backplane.
+    Backplane stompserver.Backplane `json:"-"`
+
+    // Channels whose messages are encrypted with a key of the client session, on every destination of the
+    // channel, so intermediaries terminating TLS only see ciphertext. Clients must exchange a key when they
+    // connect to use them, see stompserver.EncryptionConfig. Responses relayed to temporary reply
+    // destinations are only encrypted if the TempQueuePrefix is in Encryption.Destinations.
+    EncryptedChannels []string
+
+    // Further destinations to encrypt, and how often session keys rotate.
+    Encryption stompserver.EncryptionConfig
 }
 
 func (ec *EndpointConfig) validate() error {
@@ -48,12 +129,64 @@ func (ec *EndpointConfig) validate() error {
         return fmt.Errorf("missing UserQueuePrefix")
     }
 
+    if err := validateExclusivePrefix("QueuePrefix", ec.QueuePrefix, ec.TopicPrefix, ec.UserQueuePrefix,
+        ec.AppRequestPrefix, ec.AppRequestQueuePrefix); err != nil {
+        return err
+    }
+
+    return validateExclusivePrefix("TempQueuePrefix", ec.TempQueuePrefix, ec.TopicPrefix, ec.UserQueuePrefix,
+        ec.AppRequestPrefix, ec.AppRequestQueuePrefix, ec.QueuePrefix)
+}
+
+// encryption returns the encryption of the broker, with the destinations of the encrypted channels.
+func (ec *EndpointConfig) encryption() stompserver.EncryptionConfig {
+    encryption := ec.Encryption
+    encryption.Destinations = append([]string(nil), ec.Encryption.Destinations...)
+    for _, channel := range ec.EncryptedChannels {
+        for _, prefix := range []string{ec.TopicPrefix, ec.UserQueuePrefix, ec.AppRequestPrefix,
+            ec.AppRequestQueuePrefix, ec.QueuePrefix} {
+            if prefix != "" {
+                encryption.Destinations = append(encryption.Destinations, prefix+channel)
+            }
+        }
+    }
+    return encryption
+}
+
+// validateExclusivePrefix checks that an optional prefix, which destinations are given a meaning of their
+// own under, starts with a slash and does not overlap any of the others.
+func validateExclusivePrefix(name string, prefix string, others ...string) error {
+    if prefix == "" {
+        return nil
+    }
+    if !strings.HasPrefix(prefix, "/") {
+        return fmt.Errorf("invalid %s", name)
+    }
+    prefix = addPrefixIfNotEmpty(prefix, "/")
+    for _, other := range others {
+        other = addPrefixIfNotEmpty(other, "/")
+        if other != "" && (strings.HasPrefix(prefix, other) || strings.HasPrefix(other, prefix)) {
+            return fmt.Errorf("%s overlaps %s", name, other)
+        }
+    }
     return nil
 }
 
 type FabricEndpoint interface {
     Start()
     Stop()
+    // closes the connections of the broker gracefully, see stompserver.StompServer
+    Drain(ctx context.Context, grace time.Duration)
+    // describes every active connection and its subscriptions
+    Connections() []*stompserver.ConnectionInfo
+    // records the frames going through the broker with the recorder, nil stops recording. returns the
+    // recorder replaced
+    SetRecorder(recorder *stompserver.Recorder) *stompserver.Recorder
+    // replays a recording of the broker onto the bus
+    Replay(ctx context.Context, recording io.Reader, config ReplayConfig) (*ReplayReport, error)
+    // carries the messages the broker broadcasts to the other instances of a deployment, and theirs to its
+    // clients, nil stops. returns the backplane replaced
+    SetBackplane(backplane stompserver.Backplane) stompserver.Backplane
 }
 
 type channelMapping struct {
@@ -65,14 +198,26 @@ type channelMapping struct {
 type StompSessionEvent struct {
     Id        string
     EventType stompserver.StompSessionEventType
+    // the destination of the subscription and its queued messages, set for backpressure events
+    Destination string
+    Queued      int
+    // why the connection was closed (one of the stompserver.CloseReason constants) and the principal of its
+    // client, set for ConnectionClosed events
+    Reason    string
+    Principal string
 }
 
 type fabricEndpoint struct {
-    server       stompserver.StompServer
-    bus          EventBus
-    config       EndpointConfig
-    chanLock     sync.RWMutex
-    chanMappings map[string]*channelMapping
+    server            stompserver.StompServer
+    bus               EventBus
+    config            EndpointConfig
+    chanLock          sync.RWMutex
+    chanMappings      map[string]*channelMapping
+    revocations       *stompserver.RevocationList
+    revocationHandler MessageHandler
+    storeSync         *fabricStoreSync
+    principals        sync.Map // connection id -> principal
+    labels            sync.Map // connection id -> labels
 }
 
 func addPrefixIfNotEmpty(s string, prefix string) string {
@@ -89,6 +234,8 @@ func newFabricEndpoint(bus EventBus,
     config.AppRequestPrefix = addPrefixIfNotEmpty(config.AppRequestPrefix, "/")
     config.AppRequestQueuePrefix = addPrefixIfNotEmpty(config.AppRequestQueuePrefix, "/")
     config.UserQueuePrefix = addPrefixIfNotEmpty(config.UserQueuePrefix, "/")
+    config.QueuePrefix = addPrefixIfNotEmpty(config.QueuePrefix, "/")
+    config.TempQueuePrefix = addPrefixIfNotEmpty(config.TempQueuePrefix, "/")
 
     stompConf := stompserver.NewStompConfig(config.Heartbeat,
         []string{config.AppRequestPrefix, config.AppRequestQueuePrefix})
@@ -98,11 +245,43 @@ func newFabricEndpoint(bus EventBus,
         stompConf.SetMiddlewareRegistry(config.MiddlewareRegistry)
     }
 
+    // revoked session tokens are rejected before any other middleware runs.
+    revocations := config.RevocationList
+    if revocations == nil {
+        revocations = stompserver.NewRevocationList()
+    }
+    stompConf.SetMiddlewareRegistry(withRevocationMiddleware(stompConf.GetMiddlewareRegistry(), revocations))
+    stompConf.SetBackpressure(config.Backpressure)
+    stompConf.SetMaxMissedHeartBeats(config.MaxMissedHeartBeats)
+    stompConf.SetRateLimits(config.RateLimits)
+    stompConf.SetDurableSubscriptions(config.DurableSubscriptions)
+    stompConf.SetQueuePrefix(config.QueuePrefix)
+    stompConf.SetTempQueuePrefix(config.TempQueuePrefix)
+    stompConf.SetEncryption(config.encryption())
+
     fep := &fabricEndpoint{
         server:       stompserver.NewStompServer(conListener, stompConf),
         config:       config,
         bus:          bus,
         chanMappings: make(map[string]*channelMapping),
+        revocations:  revocations,
+    }
+    if config.Authenticator != nil {
+        stompConf.SetMiddlewareRegistry(withAuthenticationMiddleware(stompConf.GetMiddlewareRegistry(),
+            config.Authenticator))
+    }
+    if config.Principal != nil || config.Authenticator != nil || config.Labels != nil {
+        stompConf.SetMiddlewareRegistry(withPrincipalMiddleware(stompConf.GetMiddlewareRegistry(), fep))
+    }
+    if config.Authorize != nil {
+        stompConf.SetMiddlewareRegistry(withAuthorizationMiddleware(stompConf.GetMiddlewareRegistry(), fep))
+    }
+    fep.storeSync = newFabricStoreSync(bus, func(conId string, destination string, data []byte) {
+        fep.server.SendMessageToClient(conId, destination, data)
+    })
+
+    if config.Backplane != nil {
+        fep.server.SetBackplane(config.Backplane)
     }
 
     fep.initHandlers()
@@ -117,9 +296,15 @@ func (fe *fabricEndpoint) Start() {
         }, nil)
     })
     fe.server.SetConnectionEventCallback(stompserver.ConnectionClosed, func(connEvent *stompserver.ConnEvent) {
+        principal := fe.principal(connEvent.ConnId)
+        fe.principals.Delete(connEvent.ConnId)
+        fe.labels.Delete(connEvent.ConnId)
+        fe.removeReplyMappings(connEvent.ConnId)
         busInstance.SendResponseMessage(STOMP_SESSION_NOTIFY_CHANNEL, &StompSessionEvent{
             Id:        connEvent.ConnId,
             EventType: stompserver.ConnectionClosed,
+            Reason:    connEvent.Reason(),
+            Principal: principal,
         }, nil)
     })
     fe.server.SetConnectionEventCallback(stompserver.UnsubscribeFromTopic, func(connEvent *stompserver.ConnEvent) {
@@ -128,15 +313,162 @@ func (fe *fabricEndpoint) Start() {
             EventType: stompserver.UnsubscribeFromTopic,
         }, nil)
     })
+    for _, eventType := range []stompserver.StompSessionEventType{
+        stompserver.BackpressureApplied, stompserver.BackpressureReleased} {
+        fe.server.SetConnectionEventCallback(eventType, func(connEvent *stompserver.ConnEvent) {
+            busInstance.SendResponseMessage(STOMP_SESSION_NOTIFY_CHANNEL, &StompSessionEvent{
+                Id:          connEvent.ConnId,
+                EventType:   eventType,
+                Destination: connEvent.Destination(),
+                Queued:      connEvent.Queued(),
+            }, nil)
+        })
+    }
+    fe.listenForRevocations()
     fe.server.Start()
 }
 
+func (fe *fabricEndpoint) Drain(ctx context.Context, grace time.Duration) {
+    fe.server.Drain(ctx, grace)
+}
+
+func (fe *fabricEndpoint) SetBackplane(backplane stompserver.Backplane) stompserver.Backplane {
+    return fe.server.SetBackplane(backplane)
+}
+
 func (fe *fabricEndpoint) Stop() {
+    if fe.revocationHandler != nil {
+        fe.revocationHandler.Close()
+        fe.revocationHandler = nil
+    }
+    fe.storeSync.stop()
+    fe.server.SetBackplane(nil)
     fe.server.Stop()
 }
 
+// withAuthenticationMiddleware returns a copy of the registry with the authentication middleware prepended
+// to the CONNECT middleware chain, so unauthenticated clients are rejected before any other CONNECT middleware.
+func withAuthenticationMiddleware(registry stompserver.MiddlewareRegistry,
+    authenticator stompserver.Authenticator) stompserver.MiddlewareRegistry {
+    return registry.Prepend(frame.CONNECT, stompserver.AuthenticationMiddleware(authenticator))
+}
+
+// withPrincipalMiddleware returns a copy of the registry with a CONNECT middleware recording the principal
+// and the labels of every client that connected successfully. It runs last, after any middleware establishing the session
+// token of the connection.
+func withPrincipalMiddleware(registry stompserver.MiddlewareRegistry,
+    fe *fabricEndpoint) stompserver.MiddlewareRegistry {
+    return registry.Append(frame.CONNECT,
+        func(next stompserver.FrameHandlerFunc) stompserver.FrameHandlerFunc {
+            return func(conn stompserver.StompConn, f *frame.Frame) error {
+                if err := next(conn, f); err != nil {
+                    return err
+                }
+                var principal string
+                if fe.config.Principal != nil {
+                    principal = fe.config.Principal(conn)
+                } else {
+                    principal = conn.GetPrincipal()
+                }
+                if principal != "" {
+                    fe.principals.Store(conn.GetId(), principal)
+                }
+                if fe.config.Labels != nil {
+                    if labels := fe.config.Labels(conn, f); len(labels) > 0 {
+                        fe.labels.Store(conn.GetId(), labels)
+                    }
+                }
+                return nil
+            }
+        })
+}
+
+// withAuthorizationMiddleware returns a copy of the registry with SUBSCRIBE and SEND middleware asking the
+// Authorize callback whether the principal of the client may use the destination of the frame.
+func withAuthorizationMiddleware(registry stompserver.MiddlewareRegistry,
+    fe *fabricEndpoint) stompserver.MiddlewareRegistry {
+    for _, command := range []string{frame.SUBSCRIBE, frame.SEND} {
+        registry = registry.Append(command,
+            func(next stompserver.FrameHandlerFunc) stompserver.FrameHandlerFunc {
+                return func(conn stompserver.StompConn, f *frame.Frame) error {
+                    destination := f.Header.Get(frame.Destination)
+                    if err := fe.config.Authorize(command, destination, fe.principal(conn.GetId())); err != nil {
+                        log.Warn("Client %s may not %s %s: %s", conn.GetId(), strings.ToLower(command),
+                            destination, err.Error())
+                        return err
+                    }
+                    return next(conn, f)
+                }
+            })
+    }
+    return registry
+}
+
+// principal returns the principal of a connected client, empty if anonymous.
+func (fe *fabricEndpoint) Connections() []*stompserver.ConnectionInfo {
+    connections := fe.server.Connections()
+    for _, c := range connections {
+        // the Principal resolver may have replaced the principal the client authenticated as
+        if principal := fe.principal(c.Id); principal != "" {
+            c.Principal = principal
+        }
+    }
+    return connections
+}
+
+func (fe *fabricEndpoint) principal(conId string) string {
+    if principal, ok := fe.principals.Load(conId); ok {
+        return principal.(string)
+    }
+    return ""
+}
+
+// connectionLabels returns the labels of a connected client, nil if it has none.
+func (fe *fabricEndpoint) connectionLabels(conId string) map[string]string {
+    if labels, ok := fe.labels.Load(conId); ok {
+        return labels.(map[string]string)
+    }
+    return nil
+}
+
+// withRevocationMiddleware returns a copy of the registry with the revocation middleware prepended
+// to the global middleware chain.
+func withRevocationMiddleware(registry stompserver.MiddlewareRegistry,
+    revocations *stompserver.RevocationList) stompserver.MiddlewareRegistry {
+    return registry.Prepend("*", stompserver.RevocationMiddleware(revocations))
+}
+
+// listenForRevocations adds every token published on TOKEN_REVOCATION_CHANNEL to the revocation list
+// and drops any STOMP sessions established with it.
+func (fe *fabricEndpoint) listenForRevocations() {
+    if fe.bus == nil {
+        return
+    }
+    cm := fe.bus.GetChannelManager()
+    if !cm.CheckChannelExists(TOKEN_REVOCATION_CHANNEL) {
+        cm.CreateChannel(TOKEN_REVOCATION_CHANNEL)
+    }
+    handler, err := fe.bus.ListenFirehose(TOKEN_REVOCATION_CHANNEL)
+    if err != nil {
+        log.Warn("Unable to listen for token revocations: %s", err.Error())
+        return
+    }
+    handler.Handle(
+        func(message *model.Message) {
+            revocation, err := DecodeTokenRevocation(message.Payload)
+            if err != nil {
+                log.Warn("Ignoring invalid token revocation: %s", err.Error())
+                return
+            }
+            fe.revocations.Revoke(revocation.Token, revocation.ExpiresAt)
+            fe.server.DisconnectSessionToken(revocation.Token)
+        },
+        func(e error) {})
+    fe.revocationHandler = handler
+}
+
 func (fe *fabricEndpoint) initHandlers() {
-    fe.server.OnApplicationRequest(fe.bridgeMessage)
+    fe.server.OnApplicationRequestWithReply(fe.bridgeMessage)
     fe.server.OnSubscribeEvent(fe.addSubscription)
     fe.server.OnUnsubscribeEvent(fe.removeSubscription)
 }
@@ -155,9 +487,29 @@ func (fe *fabricEndpoint) addSubscription(
         return
     }
 
+    // store destinations sync the store to the client rather than relaying a channel
+    if storeName, ok := strings.CutPrefix(channelName, GALACTIC_STORE_DESTINATION_PREFIX); ok {
+        if !fe.bus.GetStoreManager().GetAccessControl().CanRead(storeName, fe.principal(conId)) {
+            log.Warn("Client %s may not read store '%s', not syncing it to %s", conId, storeName, destination)
+            fe.storeSync.deny(conId, destination, storeName)
+            return
+        }
+        fe.storeSync.subscribe(conId, subId, destination, storeName)
+        return
+    }
+
     fe.chanLock.Lock()
     defer fe.chanLock.Unlock()
 
+    if fe.mapChannel(channelName, destination, conId+"#"+subId) {
+        fe.bus.SendMonitorEvent(FabricEndpointSubscribeEvt, channelName, nil)
+    }
+}
+
+// mapChannel relays the messages of a channel to clients, until the last of its mappings is removed. The
+// errors of the channel are sent to destination. Returns false if the channel cannot be listened to. Must
+// be called with chanLock held.
+func (fe *fabricEndpoint) mapChannel(channelName string, destination string, mappingId string) bool {
     chanMap, ok := fe.chanMappings[channelName]
     if !ok {
         messageHandler, err := fe.bus.ListenStream(channelName)
@@ -167,13 +519,13 @@ func (fe *fabricEndpoint) addSubscription(
             messageHandler, err = fe.bus.ListenStream(channelName)
             if messageHandler == nil || err != nil {
                 log.Warn("Unable to auto-create channel for destination: %s", destination)
-                return
+                return false
             }
             autoCreated = true
         }
         messageHandler.Handle(
             func(message *model.Message) {
-                data, err := marshalMessagePayload(message)
+                data, err := fe.marshalForClient(message)
                 if err == nil {
                     resp, ok := convertPayloadToResponseObj(message)
                     if ok && resp != nil && resp.BrokerDestination != nil {
@@ -183,6 +535,9 @@ func (fe *fabricEndpoint) addSubscription(
                             data)
                     } else {
                         fe.server.SendMessage(fe.config.TopicPrefix+channelName, data)
+                        if fe.config.QueuePrefix != "" {
+                            fe.server.SendMessage(fe.config.QueuePrefix+channelName, data)
+                        }
                     }
                 }
             },
@@ -198,8 +553,8 @@ func (fe *fabricEndpoint) addSubscription(
 
         fe.chanMappings[channelName] = chanMap
     }
-    chanMap.subs[conId+"#"+subId] = true
-    fe.bus.SendMonitorEvent(FabricEndpointSubscribeEvt, channelName, nil)
+    chanMap.subs[mappingId] = true
+    return true
 }
 
 func convertPayloadToResponseObj(message *model.Message) (*model.Response, bool) {
@@ -220,6 +575,17 @@ func convertPayloadToResponseObj(message *model.Message) (*model.Response, bool)
     return nil, false
 }
 
+// marshalForClient marshals the payload of a message relayed to clients, converting error responses
+// with the ErrorConverter if one is set.
+func (fe *fabricEndpoint) marshalForClient(message *model.Message) ([]byte, error) {
+    if fe.config.ErrorConverter != nil {
+        if resp, ok := convertPayloadToResponseObj(message); ok && resp.Error {
+            return json.Marshal(fe.config.ErrorConverter.ConvertError(resp))
+        }
+    }
+    return marshalMessagePayload(message)
+}
+
 func marshalMessagePayload(message *model.Message) ([]byte, error) {
     // don't marshal string and []byte payloads
     stringPayload, ok := message.Payload.(string)
@@ -241,38 +607,72 @@ func (fe *fabricEndpoint) removeSubscription(conId string, subId string, destina
         return
     }
 
+    if storeName, ok := strings.CutPrefix(channelName, GALACTIC_STORE_DESTINATION_PREFIX); ok {
+        fe.storeSync.unsubscribe(conId, subId, storeName)
+        return
+    }
+
     fe.chanLock.Lock()
     defer fe.chanLock.Unlock()
 
+    if fe.unmapChannel(channelName, conId+"#"+subId) {
+        fe.bus.SendMonitorEvent(FabricEndpointUnsubscribeEvt, channelName, nil)
+    }
+}
+
+// unmapChannel removes a mapping of a channel, returning false if there was none. Must be called with
+// chanLock held.
+func (fe *fabricEndpoint) unmapChannel(channelName string, mappingId string) bool {
     chanMap, ok := fe.chanMappings[channelName]
-    if ok {
-        mappingId := conId + "#" + subId
-        if chanMap.subs[mappingId] {
-            delete(chanMap.subs, mappingId)
-            if len(chanMap.subs) == 0 {
-                // if this was the last subscription to the channel,
-                // close the message handler and remove the channel mapping
-                chanMap.handler.Close()
-                delete(fe.chanMappings, channelName)
-                if chanMap.autoCreated {
-                    fe.bus.GetChannelManager().DestroyChannel(channelName)
-                }
-            }
-            fe.bus.SendMonitorEvent(FabricEndpointUnsubscribeEvt, channelName, nil)
+    if !ok || !chanMap.subs[mappingId] {
+        return false
+    }
+    delete(chanMap.subs, mappingId)
+    if len(chanMap.subs) == 0 {
+        // if this was the last subscription to the channel,
+        // close the message handler and remove the channel mapping
+        chanMap.handler.Close()
+        delete(fe.chanMappings, channelName)
+        if chanMap.autoCreated {
+            fe.bus.GetChannelManager().DestroyChannel(channelName)
         }
     }
+    return true
 }
 
-func (fe *fabricEndpoint) bridgeMessage(destination string, message []byte, connectionId string) {
-    var channelName string
-    isPrivateRequest := false
+// listenForReplies relays the responses of a channel while a client waits for them on a temporary reply
+// destination, which unlike the channel's destinations is not mapped to it by a subscription.
+func (fe *fabricEndpoint) listenForReplies(conId string, channelName string) bool {
+    fe.chanLock.Lock()
+    defer fe.chanLock.Unlock()
+    return fe.mapChannel(channelName, fe.config.TopicPrefix+channelName, conId+replyMappingSuffix)
+}
 
+// removeReplyMappings stops relaying responses for the temporary reply destinations of a closed
+// connection.
+func (fe *fabricEndpoint) removeReplyMappings(conId string) {
+    fe.chanLock.Lock()
+    defer fe.chanLock.Unlock()
+    for channelName := range fe.chanMappings {
+        fe.unmapChannel(channelName, conId+replyMappingSuffix)
+    }
+}
+
+// getChannelNameFromRequest returns the channel an application request destination maps to, and whether
+// the destination is that of private requests.
+func (fe *fabricEndpoint) getChannelNameFromRequest(destination string) (channelName string, private bool, ok bool) {
     if fe.config.AppRequestQueuePrefix != "" && strings.HasPrefix(destination, fe.config.AppRequestQueuePrefix) {
-        channelName = destination[len(fe.config.AppRequestQueuePrefix):]
-        isPrivateRequest = true
-    } else if fe.config.AppRequestPrefix != "" && strings.HasPrefix(destination, fe.config.AppRequestPrefix) {
-        channelName = destination[len(fe.config.AppRequestPrefix):]
-    } else {
+        return destination[len(fe.config.AppRequestQueuePrefix):], true, true
+    }
+    if fe.config.AppRequestPrefix != "" && strings.HasPrefix(destination, fe.config.AppRequestPrefix) {
+        return destination[len(fe.config.AppRequestPrefix):], false, true
+    }
+    return "", false, false
+}
+
+func (fe *fabricEndpoint) bridgeMessage(destination string, message []byte, connectionId string, replyTo string) {
+    channelName, isPrivateRequest, ok := fe.getChannelNameFromRequest(destination)
+    if !ok {
         return
     }
 
@@ -283,14 +683,32 @@ func (fe *fabricEndpoint) bridgeMessage(destination string, message []byte, conn
         return
     }
 
-    if isPrivateRequest {
+    req.Principal = fe.principal(connectionId)
+    req.Labels = fe.connectionLabels(connectionId)
+    if replyTo != "" && !isProtectedDestination(channelName) && fe.listenForReplies(connectionId, channelName) {
+        req.BrokerDestination = &model.BrokerDestinationConfig{
+            Destination:  replyTo,
+            ConnectionId: connectionId,
+        }
+    } else if isPrivateRequest {
         req.BrokerDestination = &model.BrokerDestinationConfig{
             Destination:  fe.config.UserQueuePrefix + channelName,
             ConnectionId: connectionId,
         }
     }
 
-    fe.bus.SendRequestMessage(channelName, &req, nil)
+    var validationErr *ValidationError
+    if err := fe.bus.SendRequestMessage(channelName, &req, nil); errors.As(err, &validationErr) {
+        // tell the client why its request was dropped, the same way a service reports errors.
+        fe.bus.SendResponseMessage(channelName, &model.Response{
+            Id:                req.Id,
+            Destination:       channelName,
+            Error:             true,
+            ErrorCode:         400,
+            ErrorMessage:      validationErr.Error(),
+            BrokerDestination: req.BrokerDestination,
+        }, nil)
+    }
 }
 
 func (fe *fabricEndpoint) getChannelNameFromSubscription(destination string) (channelName string, ok bool) {
@@ -301,6 +719,10 @@ func (fe *fabricEndpoint) getChannelNameFromSubscription(destination string) (ch
     if fe.config.UserQueuePrefix != "" && strings.HasPrefix(destination, fe.config.UserQueuePrefix) {
         return destination[len(fe.config.UserQueuePrefix):], true
     }
+
+    if fe.config.QueuePrefix != "" && strings.HasPrefix(destination, fe.config.QueuePrefix) {
+        return destination[len(fe.config.QueuePrefix):], true
+    }
     return "", false
 }
 
@@ -4,14 +4,19 @@
 package bus
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"github.com/go-stomp/stomp/v3/frame"
 	"github.com/google/uuid"
 	"github.com/pb33f/ranch/model"
 	"github.com/pb33f/ranch/stompserver"
 	"github.com/stretchr/testify/assert"
+	"slices"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
 type MockStompServerMessage struct {
@@ -27,7 +32,14 @@ type MockStompServer struct {
 	connectionEventCallbacks          map[stompserver.StompSessionEventType]func(event *stompserver.ConnEvent)
 	unsubscribeHandlerFunction        stompserver.UnsubscribeHandlerFunction
 	applicationRequestHandlerFunction stompserver.ApplicationRequestHandlerFunction
+	replyRequestHandlerFunction       stompserver.ApplicationRequestWithReplyHandlerFunction
 	wg                                *sync.WaitGroup
+	disconnectedTokens                []string
+	tokenLock                         sync.Mutex
+	connections                       []*stompserver.ConnectionInfo
+	recorder                          *stompserver.Recorder
+	drainGrace                        time.Duration
+	backplane                         stompserver.Backplane
 }
 
 func (s *MockStompServer) Start() {
@@ -38,6 +50,10 @@ func (s *MockStompServer) Stop() {
 	s.started = false
 }
 
+func (s *MockStompServer) Drain(ctx context.Context, grace time.Duration) {
+	s.drainGrace = grace
+}
+
 func (s *MockStompServer) SendMessage(destination string, messageBody []byte) {
 	s.sentMessages = append(s.sentMessages,
 		MockStompServerMessage{Destination: destination, Payload: messageBody})
@@ -64,10 +80,45 @@ func (s *MockStompServer) OnApplicationRequest(callback stompserver.ApplicationR
 	s.applicationRequestHandlerFunction = callback
 }
 
+func (s *MockStompServer) OnApplicationRequestWithReply(callback stompserver.ApplicationRequestWithReplyHandlerFunction) {
+	s.replyRequestHandlerFunction = callback
+	s.applicationRequestHandlerFunction = func(destination string, message []byte, connectionId string) {
+		callback(destination, message, connectionId, "")
+	}
+}
+
 func (s *MockStompServer) OnSubscribeEvent(callback stompserver.SubscribeHandlerFunction) {
 	s.subscribeHandlerFunction = callback
 }
 
+func (s *MockStompServer) DisconnectSessionToken(token string) {
+	s.tokenLock.Lock()
+	defer s.tokenLock.Unlock()
+	s.disconnectedTokens = append(s.disconnectedTokens, token)
+}
+
+func (s *MockStompServer) Connections() []*stompserver.ConnectionInfo {
+	return s.connections
+}
+
+func (s *MockStompServer) SetRecorder(recorder *stompserver.Recorder) *stompserver.Recorder {
+	previous := s.recorder
+	s.recorder = recorder
+	return previous
+}
+
+func (s *MockStompServer) SetBackplane(backplane stompserver.Backplane) stompserver.Backplane {
+	previous := s.backplane
+	s.backplane = backplane
+	return previous
+}
+
+func (s *MockStompServer) getDisconnectedTokens() []string {
+	s.tokenLock.Lock()
+	defer s.tokenLock.Unlock()
+	return append([]string{}, s.disconnectedTokens...)
+}
+
 func (s *MockStompServer) SetConnectionEventCallback(connEventType stompserver.StompSessionEventType, cb func(connEvent *stompserver.ConnEvent)) {
 	s.connectionEventCallbacks[connEventType] = cb
 	cb(&stompserver.ConnEvent{ConnId: "id"})
@@ -105,6 +156,12 @@ func TestFabricEndpoint_newFabricEndpoint(t *testing.T) {
 	assert.Equal(t, fe.config.AppRequestPrefix, "")
 }
 
+func TestFabricEndpoint_Drain(t *testing.T) {
+	fe, mockServer := newTestFabricEndpoint(nil, EndpointConfig{TopicPrefix: "/topic"})
+	fe.Drain(context.Background(), 3*time.Second)
+	assert.Equal(t, 3*time.Second, mockServer.drainGrace)
+}
+
 func TestFabricEndpoint_StartAndStop(t *testing.T) {
 	fe, mockServer := newTestFabricEndpoint(nil, EndpointConfig{})
 	assert.Equal(t, mockServer.started, false)
@@ -114,6 +171,62 @@ func TestFabricEndpoint_StartAndStop(t *testing.T) {
 	assert.Equal(t, mockServer.started, false)
 }
 
+func TestFabricEndpoint_BackpressureEvents(t *testing.T) {
+	fe, mockServer := newTestFabricEndpoint(nil, EndpointConfig{
+		TopicPrefix:  "/topic",
+		Backpressure: stompserver.BackpressureConfig{HighWaterMark: 64},
+	})
+	fe.Start()
+	defer fe.Stop()
+	assert.Contains(t, mockServer.connectionEventCallbacks, stompserver.BackpressureApplied)
+	assert.Contains(t, mockServer.connectionEventCallbacks, stompserver.BackpressureReleased)
+}
+
+func TestFabricEndpoint_ClosedSessionEvent(t *testing.T) {
+	GetBus().GetChannelManager().CreateChannel(STOMP_SESSION_NOTIFY_CHANNEL)
+	events := make(chan *StompSessionEvent, 10)
+	h, err := GetBus().ListenStream(STOMP_SESSION_NOTIFY_CHANNEL)
+	assert.Nil(t, err)
+	defer h.Close()
+	h.Handle(func(msg *model.Message) {
+		if evt := msg.Payload.(*StompSessionEvent); evt.Id == "closed-con" {
+			events <- evt
+		}
+	}, func(err error) {})
+
+	fe, mockServer := newTestFabricEndpoint(nil, EndpointConfig{TopicPrefix: "/topic", MaxMissedHeartBeats: 3})
+	fe.Start()
+	defer fe.Stop()
+
+	// services releasing what they hold for the session learn whose session it was
+	fe.principals.Store("closed-con", "alice")
+	mockServer.connectionEventCallbacks[stompserver.ConnectionClosed](&stompserver.ConnEvent{ConnId: "closed-con"})
+
+	select {
+	case evt := <-events:
+		assert.Equal(t, stompserver.ConnectionClosed, evt.EventType)
+		assert.Equal(t, "alice", evt.Principal)
+	case <-time.After(5 * time.Second):
+		assert.FailNow(t, "TestFabricEndpoint_ClosedSessionEvent timeout on session event")
+	}
+	assert.Equal(t, "", fe.principal("closed-con"))
+}
+
+func TestFabricEndpoint_Connections(t *testing.T) {
+	fe, mockServer := newTestFabricEndpoint(nil, EndpointConfig{TopicPrefix: "/topic"})
+	mockServer.connections = []*stompserver.ConnectionInfo{
+		{Id: "con1", Principal: "from-authenticator"},
+		{Id: "con2", Principal: "from-authenticator"},
+	}
+
+	// principals resolved by the endpoint take precedence
+	fe.principals.Store("con1", "resolved")
+	connections := fe.Connections()
+	assert.Len(t, connections, 2)
+	assert.Equal(t, "resolved", connections[0].Principal)
+	assert.Equal(t, "from-authenticator", connections[1].Principal)
+}
+
 func TestFabricEndpoint_SubscribeEvent(t *testing.T) {
 
 	bus := newTestEventBus()
@@ -391,3 +504,370 @@ func TestFabricEndpoint_BridgeMessage(t *testing.T) {
 	assert.Equal(t, receivedReq2.BrokerDestination.ConnectionId, "con2")
 	assert.Equal(t, receivedReq2.BrokerDestination.Destination, "/user/queue/request-channel")
 }
+
+func TestFabricEndpoint_TokenRevocation(t *testing.T) {
+	bus := newTestEventBus()
+	fe, mockServer := newTestFabricEndpoint(bus, EndpointConfig{TopicPrefix: "/topic"})
+	fe.Start()
+	defer fe.Stop()
+
+	assert.Error(t, RevokeSessionToken(bus, &TokenRevocation{}))
+
+	// revoked locally
+	assert.NoError(t, RevokeSessionToken(bus, &TokenRevocation{Token: "local-token", Reason: "logout"}))
+	assert.Eventually(t, func() bool {
+		return len(mockServer.getDisconnectedTokens()) == 1
+	}, time.Second, 5*time.Millisecond)
+	assert.True(t, fe.revocations.IsRevoked("local-token"))
+
+	// revoked by another instance and relayed from a broker as raw JSON
+	bus.SendResponseMessage(TOKEN_REVOCATION_CHANNEL, []byte(`{"token":"remote-token"}`), nil)
+	assert.Eventually(t, func() bool {
+		return len(mockServer.getDisconnectedTokens()) == 2
+	}, time.Second, 5*time.Millisecond)
+	assert.True(t, fe.revocations.IsRevoked("remote-token"))
+	assert.Equal(t, []string{"local-token", "remote-token"}, mockServer.getDisconnectedTokens())
+}
+
+func TestFabricEndpoint_RevocationMiddlewareIsInstalled(t *testing.T) {
+	list := stompserver.NewRevocationList()
+	registry := withRevocationMiddleware(stompserver.MiddlewareRegistry{
+		"*": []stompserver.MiddlewareFunc{stompserver.AuthzMiddleware("all")},
+	}, list)
+	assert.Len(t, registry["*"], 2)
+}
+
+func TestFabricEndpoint_GalacticStoreSync(t *testing.T) {
+	bus := newTestEventBus()
+	fe, mockServer := newTestFabricEndpoint(bus,
+		EndpointConfig{TopicPrefix: "/topic", UserQueuePrefix: "/user/queue"})
+	store := bus.GetStoreManager().CreateStore("dashboard")
+	store.Populate(map[string]interface{}{"cpu": 42})
+
+	// the subscriber is sent a snapshot of the store
+	mockServer.wg = &sync.WaitGroup{}
+	mockServer.wg.Add(1)
+	mockServer.subscribeHandlerFunction("con1", "sub1", "/topic/"+GALACTIC_STORE_DESTINATION_PREFIX+"dashboard", nil)
+	mockServer.wg.Wait()
+	assert.Len(t, fe.chanMappings, 0)
+	assert.Len(t, mockServer.sentMessages, 1)
+	assert.Equal(t, "con1", mockServer.sentMessages[0].conId)
+	assert.Equal(t, "/topic/galactic-store/dashboard", mockServer.sentMessages[0].Destination)
+	var snapshot model.StoreContentResponse
+	assert.NoError(t, json.Unmarshal(mockServer.sentMessages[0].Payload, &snapshot))
+	assert.Equal(t, "storeContentResponse", snapshot.ResponseType)
+	assert.Equal(t, map[string]interface{}{"cpu": 42.0}, snapshot.Items)
+	assert.Equal(t, int64(1), snapshot.StoreVersion)
+
+	// followed by the changes made after it
+	mockServer.wg.Add(1)
+	store.Put("mem", 7, "updated")
+	mockServer.wg.Wait()
+	var update model.UpdateStoreResponse
+	assert.NoError(t, json.Unmarshal(mockServer.sentMessages[1].Payload, &update))
+	assert.Equal(t, "updateStoreResponse", update.ResponseType)
+	assert.Equal(t, "mem", update.ItemId)
+	assert.Equal(t, 7.0, update.NewItemValue)
+	assert.Equal(t, int64(2), update.StoreVersion)
+
+	// a second subscriber gets its own snapshot, then both get every change
+	mockServer.wg.Add(1)
+	mockServer.subscribeHandlerFunction("con2", "sub1", "/user/queue/"+GALACTIC_STORE_DESTINATION_PREFIX+"dashboard", nil)
+	mockServer.wg.Wait()
+	assert.Equal(t, "con2", mockServer.sentMessages[2].conId)
+	assert.Equal(t, "/user/queue/galactic-store/dashboard", mockServer.sentMessages[2].Destination)
+
+	mockServer.wg.Add(2)
+	store.Remove("cpu", "removed")
+	mockServer.wg.Wait()
+	assert.Len(t, mockServer.sentMessages, 5)
+	assert.NoError(t, json.Unmarshal(mockServer.sentMessages[4].Payload, &update))
+	assert.Equal(t, "cpu", update.ItemId)
+	assert.Nil(t, update.NewItemValue)
+
+	// unsubscribing stops the updates, and the store is no longer watched once nobody is subscribed
+	mockServer.unsubscribeHandlerFunction("con1", "sub1", "/topic/"+GALACTIC_STORE_DESTINATION_PREFIX+"dashboard")
+	mockServer.unsubscribeHandlerFunction("con2", "sub1", "/user/queue/"+GALACTIC_STORE_DESTINATION_PREFIX+"dashboard")
+	assert.Eventually(t, func() bool {
+		done := make(chan bool)
+		fe.storeSync.enqueue(func() { done <- len(fe.storeSync.stores) == 0 })
+		return <-done
+	}, time.Second, 5*time.Millisecond)
+
+	// subscribing to a store that does not exist sends nothing
+	mockServer.subscribeHandlerFunction("con1", "sub2", "/topic/"+GALACTIC_STORE_DESTINATION_PREFIX+"missing", nil)
+	done := make(chan bool)
+	fe.storeSync.enqueue(func() { done <- len(fe.storeSync.stores) == 0 })
+	assert.True(t, <-done)
+	assert.Len(t, mockServer.sentMessages, 5)
+}
+
+type principalTestConn struct {
+	stompserver.StompConn
+	id        string
+	token     string
+	principal string
+}
+
+func (c *principalTestConn) GetId() string           { return c.id }
+func (c *principalTestConn) GetSessionToken() string { return c.token }
+func (c *principalTestConn) GetPrincipal() string    { return c.principal }
+
+func TestFabricEndpoint_Principals(t *testing.T) {
+	bus := newTestEventBus()
+	fe, mockServer := newTestFabricEndpoint(bus, EndpointConfig{TopicPrefix: "/topic", AppRequestPrefix: "/pub",
+		Principal: func(conn stompserver.StompConn) string {
+			return strings.TrimPrefix(conn.GetSessionToken(), "token-of-")
+		}})
+
+	// the principal of a client is resolved once it has connected
+	connect := stompserver.ChainCommandMiddleware(withPrincipalMiddleware(stompserver.MiddlewareRegistry{}, fe),
+		frame.CONNECT, func(conn stompserver.StompConn, f *frame.Frame) error { return nil })
+	assert.NoError(t, connect(&principalTestConn{id: "con1", token: "token-of-browser"}, frame.New(frame.CONNECT)))
+	assert.NoError(t, connect(&principalTestConn{id: "con2", token: "token-of-backend"}, frame.New(frame.CONNECT)))
+	assert.Equal(t, "browser", fe.principal("con1"))
+
+	// requests carry it
+	bus.GetChannelManager().CreateChannel("request-channel")
+	requests := make(chan *model.Request, 1)
+	mh, _ := bus.ListenRequestStream("request-channel")
+	mh.Handle(func(message *model.Message) {
+		requests <- message.Payload.(*model.Request)
+	}, func(e error) {})
+	req, _ := json.Marshal(model.Request{RequestCommand: "test-request"})
+	mockServer.applicationRequestHandlerFunction("/pub/request-channel", req, "con1")
+	assert.Equal(t, "browser", (<-requests).Principal)
+
+	// and store destinations are only synced to principals allowed to read the store
+	store := bus.GetStoreManager().CreateStore("payroll")
+	store.Populate(map[string]interface{}{"alice": 100})
+	accessControl := NewStoreAccessControl()
+	accessControl.SetRule("payroll", &StoreAccessRule{ReadPrincipals: []string{"backend"}})
+	bus.GetStoreManager().SetAccessControl(accessControl)
+
+	mockServer.wg = &sync.WaitGroup{}
+	mockServer.wg.Add(2)
+	mockServer.subscribeHandlerFunction("con1", "sub1", "/topic/"+GALACTIC_STORE_DESTINATION_PREFIX+"payroll", nil)
+	mockServer.subscribeHandlerFunction("con2", "sub1", "/topic/"+GALACTIC_STORE_DESTINATION_PREFIX+"payroll", nil)
+	mockServer.wg.Wait()
+	assert.Len(t, mockServer.sentMessages, 2)
+
+	// others are told they may not
+	var denied model.Response
+	assert.Equal(t, "con1", mockServer.sentMessages[0].conId)
+	assert.Equal(t, "/topic/galactic-store/payroll", mockServer.sentMessages[0].Destination)
+	assert.NoError(t, json.Unmarshal(mockServer.sentMessages[0].Payload, &denied))
+	assert.True(t, denied.Error)
+	assert.Equal(t, "Access denied, cannot sync store: payroll", denied.ErrorMessage)
+	assert.Equal(t, "con2", mockServer.sentMessages[1].conId)
+
+	// and forgotten once it disconnects
+	fe.Start()
+	defer fe.Stop()
+	mockServer.connectionEventCallbacks[stompserver.ConnectionClosed](&stompserver.ConnEvent{ConnId: "con1"})
+	assert.Equal(t, "", fe.principal("con1"))
+}
+
+func TestFabricEndpoint_Authorization(t *testing.T) {
+	fe, _ := newTestFabricEndpoint(nil, EndpointConfig{TopicPrefix: "/topic", AppRequestPrefix: "/pub",
+		Authorize: func(command string, destination string, principal string) error {
+			if principal == "admin" || (command == frame.SUBSCRIBE && destination == "/topic/public") {
+				return nil
+			}
+			return errors.New("access denied")
+		}})
+	fe.principals.Store("con1", "admin")
+
+	registry := withAuthorizationMiddleware(stompserver.MiddlewareRegistry{}, fe)
+	handled := 0
+	handle := func(command string, conId string, destination string) error {
+		return stompserver.ChainCommandMiddleware(registry, command,
+			func(conn stompserver.StompConn, f *frame.Frame) error {
+				handled++
+				return nil
+			})(&principalTestConn{id: conId}, frame.New(command, frame.Destination, destination))
+	}
+
+	assert.NoError(t, handle(frame.SUBSCRIBE, "con1", "/topic/payroll"))
+	assert.NoError(t, handle(frame.SEND, "con1", "/pub/payroll"))
+	assert.NoError(t, handle(frame.SUBSCRIBE, "con2", "/topic/public"))
+	assert.EqualError(t, handle(frame.SUBSCRIBE, "con2", "/topic/payroll"), "access denied")
+	assert.EqualError(t, handle(frame.SEND, "con2", "/pub/public"), "access denied")
+	assert.Equal(t, 3, handled)
+}
+
+func TestFabricEndpoint_AuthenticatedPrincipals(t *testing.T) {
+	fe, _ := newTestFabricEndpoint(nil, EndpointConfig{TopicPrefix: "/topic",
+		Authenticator: stompserver.NewTokenAuthenticator(map[string]string{"secret": "backend"})})
+
+	// the principal the authenticator returned is used when there is no Principal resolver
+	connect := stompserver.ChainCommandMiddleware(withPrincipalMiddleware(stompserver.MiddlewareRegistry{}, fe),
+		frame.CONNECT, func(conn stompserver.StompConn, f *frame.Frame) error { return nil })
+	assert.NoError(t, connect(&principalTestConn{id: "con1", principal: "backend"}, frame.New(frame.CONNECT)))
+	assert.Equal(t, "backend", fe.principal("con1"))
+
+	// and authentication runs before any other CONNECT middleware
+	called := false
+	registry := withAuthenticationMiddleware(stompserver.MiddlewareRegistry{
+		frame.CONNECT: {func(next stompserver.FrameHandlerFunc) stompserver.FrameHandlerFunc {
+			return func(conn stompserver.StompConn, f *frame.Frame) error {
+				called = true
+				return next(conn, f)
+			}
+		}},
+	}, fe.config.Authenticator)
+	connect = stompserver.ChainCommandMiddleware(registry, frame.CONNECT,
+		func(conn stompserver.StompConn, f *frame.Frame) error { return nil })
+	assert.Error(t, connect(&principalTestConn{id: "con2"}, frame.New(frame.CONNECT, frame.Passcode, "guess")))
+	assert.False(t, called)
+}
+
+func TestFabricEndpoint_Labels(t *testing.T) {
+	bus := newTestEventBus()
+	fe, mockServer := newTestFabricEndpoint(bus, EndpointConfig{TopicPrefix: "/topic", AppRequestPrefix: "/pub",
+		Labels: func(conn stompserver.StompConn, connect *frame.Frame) map[string]string {
+			if tier := connect.Header.Get("tier"); tier != "" {
+				return map[string]string{"tier": tier}
+			}
+			return nil
+		}})
+
+	// clients are labeled once they have connected
+	connect := stompserver.ChainCommandMiddleware(withPrincipalMiddleware(stompserver.MiddlewareRegistry{}, fe),
+		frame.CONNECT, func(conn stompserver.StompConn, f *frame.Frame) error { return nil })
+	assert.NoError(t, connect(&principalTestConn{id: "con1"}, frame.New(frame.CONNECT, "tier", "gold")))
+	assert.NoError(t, connect(&principalTestConn{id: "con2"}, frame.New(frame.CONNECT)))
+	assert.Nil(t, fe.connectionLabels("con2"))
+
+	// requests carry the labels
+	bus.GetChannelManager().CreateChannel("request-channel")
+	requests := make(chan *model.Request, 1)
+	mh, _ := bus.ListenRequestStream("request-channel")
+	mh.Handle(func(message *model.Message) {
+		requests <- message.Payload.(*model.Request)
+	}, func(e error) {})
+	req, _ := json.Marshal(model.Request{RequestCommand: "test-request"})
+	mockServer.applicationRequestHandlerFunction("/pub/request-channel", req, "con1")
+	assert.Equal(t, map[string]string{"tier": "gold"}, (<-requests).Labels)
+
+	// and forgotten once it disconnects
+	fe.Start()
+	defer fe.Stop()
+	mockServer.connectionEventCallbacks[stompserver.ConnectionClosed](&stompserver.ConnEvent{ConnId: "con1"})
+	assert.Nil(t, fe.connectionLabels("con1"))
+}
+
+func TestFabricEndpoint_ErrorConverter(t *testing.T) {
+	bus := newTestEventBus()
+	_, mockServer := newTestFabricEndpoint(bus, EndpointConfig{TopicPrefix: "/topic",
+		ErrorConverter: model.ProblemDetailsErrorConverter})
+	bus.GetChannelManager().CreateChannel("test-service")
+	mockServer.subscribeHandlerFunction("con1", "sub1", "/topic/test-service", nil)
+
+	mockServer.wg = &sync.WaitGroup{}
+	mockServer.wg.Add(2)
+	bus.SendResponseMessage("test-service", &model.Response{Payload: "fine"}, nil)
+	bus.SendResponseMessage("test-service", &model.Response{Error: true, ErrorCode: 404, ErrorMessage: "no such order"}, nil)
+	mockServer.wg.Wait()
+
+	// only error responses are converted
+	sent := []string{string(mockServer.sentMessages[0].Payload), string(mockServer.sentMessages[1].Payload)}
+	slices.Sort(sent)
+	assert.JSONEq(t, `{"payload":"fine"}`, sent[0])
+	assert.JSONEq(t, `{"title":"no such order","status":404,"kind":"not_found","retriable":false}`, sent[1])
+}
+
+func TestFabricEndpoint_QueueDestinations(t *testing.T) {
+	bus := newTestEventBus()
+	fe, mockServer := newTestFabricEndpoint(bus,
+		EndpointConfig{TopicPrefix: "/topic", UserQueuePrefix: "/user/queue", QueuePrefix: "/queue"})
+	bus.GetChannelManager().CreateChannel("jobs")
+
+	// subscribing to a queue relays its channel, to the queue as well as to the topic
+	mockServer.subscribeHandlerFunction("con1", "sub1", "/queue/jobs", nil)
+	assert.True(t, fe.chanMappings["jobs"].subs["con1#sub1"])
+	mockServer.wg = &sync.WaitGroup{}
+	mockServer.wg.Add(2)
+	bus.SendResponseMessage("jobs", "job-1", nil)
+	mockServer.wg.Wait()
+	assert.ElementsMatch(t, []string{"/topic/jobs", "/queue/jobs"},
+		[]string{mockServer.sentMessages[0].Destination, mockServer.sentMessages[1].Destination})
+
+	mockServer.unsubscribeHandlerFunction("con1", "sub1", "/queue/jobs")
+	assert.Empty(t, fe.chanMappings)
+}
+
+func TestFabricEndpoint_TempQueueReplies(t *testing.T) {
+	bus := newTestEventBus()
+	fe, mockServer := newTestFabricEndpoint(bus,
+		EndpointConfig{TopicPrefix: "/topic", AppRequestPrefix: "/pub", TempQueuePrefix: "/temp-queue"})
+	bus.GetChannelManager().CreateChannel("orders")
+	mh, _ := bus.ListenRequestStream("orders")
+	mh.Handle(func(message *model.Message) {
+		req := message.Payload.(*model.Request)
+		bus.SendResponseMessage("orders", &model.Response{Id: req.Id, Payload: "shipped",
+			BrokerDestination: req.BrokerDestination}, req.Id)
+	}, func(e error) {})
+
+	// the temporary reply destination of the session is not a subscription to the channel, its responses
+	// are relayed to the requesting client only
+	id := uuid.New()
+	req, _ := json.Marshal(model.Request{RequestCommand: "ship", Id: &id})
+	mockServer.wg = &sync.WaitGroup{}
+	mockServer.wg.Add(1)
+	mockServer.replyRequestHandlerFunction("/pub/orders", req, "con1", "/temp-queue/orders-1")
+	mockServer.wg.Wait()
+	if assert.Len(t, mockServer.sentMessages, 1) {
+		assert.Equal(t, "/temp-queue/orders-1", mockServer.sentMessages[0].Destination)
+		assert.Equal(t, "con1", mockServer.sentMessages[0].conId)
+	}
+	assert.True(t, fe.chanMappings["orders"].subs["con1"+replyMappingSuffix])
+
+	// until the session closes
+	fe.removeReplyMappings("con1")
+	assert.Empty(t, fe.chanMappings)
+}
+
+func TestEndpointConfig_QueuePrefix(t *testing.T) {
+	assert.NoError(t, (&EndpointConfig{TopicPrefix: "/topic", UserQueuePrefix: "/user/queue",
+		QueuePrefix: "/queue"}).validate())
+	assert.Error(t, (&EndpointConfig{TopicPrefix: "/topic", QueuePrefix: "queue"}).validate())
+	assert.Error(t, (&EndpointConfig{TopicPrefix: "/topic", UserQueuePrefix: "/queue",
+		QueuePrefix: "/queue/"}).validate(), "user queues are /queue by default in plank")
+	assert.Error(t, (&EndpointConfig{TopicPrefix: "/topic", QueuePrefix: "/topic/queue"}).validate())
+	assert.NoError(t, (&EndpointConfig{TopicPrefix: "/topic", QueuePrefix: "/queue",
+		TempQueuePrefix: "/temp-queue"}).validate())
+	assert.Error(t, (&EndpointConfig{TopicPrefix: "/topic", QueuePrefix: "/queue",
+		TempQueuePrefix: "/queue/temp"}).validate())
+}
+
+func TestEndpointConfig_Encryption(t *testing.T) {
+	config := &EndpointConfig{TopicPrefix: "/topic/", UserQueuePrefix: "/user/queue/", AppRequestPrefix: "/pub/",
+		EncryptedChannels: []string{"secrets"},
+		Encryption:        stompserver.EncryptionConfig{Destinations: []string{"/temp-queue/"}, RekeyMessages: 10}}
+	assert.Equal(t, stompserver.EncryptionConfig{Destinations: []string{"/temp-queue/", "/topic/secrets",
+		"/user/queue/secrets", "/pub/secrets"}, RekeyMessages: 10}, config.encryption())
+	assert.Equal(t, []string{"/temp-queue/"}, config.Encryption.Destinations)
+}
+
+func TestFabricEndpoint_SetBackplane(t *testing.T) {
+	bus := newTestEventBus()
+	_, err := bus.SetFabricBackplane(nil)
+	assert.Error(t, err)
+
+	fe, mockServer := newTestFabricEndpoint(bus, EndpointConfig{TopicPrefix: "/topic"})
+	bus.(*transportEventBus).fabEndpoint = fe
+	backplane := &testBackplane{}
+	previous, err := bus.SetFabricBackplane(backplane)
+	assert.NoError(t, err)
+	assert.Nil(t, previous)
+	assert.Same(t, backplane, mockServer.backplane)
+	previous, err = bus.SetFabricBackplane(nil)
+	assert.NoError(t, err)
+	assert.Same(t, backplane, previous)
+}
+
+type testBackplane struct{}
+
+func (b *testBackplane) Publish(msg *stompserver.BackplaneMessage) error           { return nil }
+func (b *testBackplane) Subscribe(deliver func(msg *stompserver.BackplaneMessage)) {}
@@ -0,0 +1,118 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pb33f/ranch/clock"
+	"github.com/pb33f/ranch/model"
+	"github.com/pb33f/ranch/stompserver"
+)
+
+// ReplayConfig selects what of a broker recording is replayed onto the bus, and how fast.
+type ReplayConfig struct {
+	Speed        float64  // pace relative to the recording, 2 replays it twice as fast. as fast as possible if 0
+	Destinations []string // destination prefixes replayed, all if empty
+	// also replay the messages the broker sent to every subscriber of a destination, as responses on its
+	// channel. services answer the replayed requests again, so the recorded responses are usually left out
+	Outgoing bool
+}
+
+// ReplayReport counts what a replay sent onto the bus.
+type ReplayReport struct {
+	Requests  int `json:"requests"`  // requests sent to the channels of their destinations
+	Responses int `json:"responses"` // recorded messages sent as responses on their channels
+	Skipped   int `json:"skipped"`   // frames not replayed: filtered out, sent to a single client, or not mapping onto a channel
+}
+
+func (fe *fabricEndpoint) SetRecorder(recorder *stompserver.Recorder) *stompserver.Recorder {
+	return fe.server.SetRecorder(recorder)
+}
+
+// Replay sends the frames of a recording onto the bus, as their clients and the broker did: requests clients
+// sent to application request destinations go to their channels, and if config.Outgoing is set, messages
+// the broker sent to the subscribers of a destination go to its channel as responses, relayed to the
+// clients subscribed now. Their payload is the recorded body. Messages sent to a single client are not
+// replayed, their client is long gone. Replay returns once the recording is replayed, or ctx is done.
+func (fe *fabricEndpoint) Replay(ctx context.Context, recording io.Reader, config ReplayConfig) (*ReplayReport, error) {
+	reader := stompserver.NewRecordingReader(recording)
+	report := &ReplayReport{}
+	var previous time.Time
+	for {
+		f, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return report, nil
+		}
+		if err != nil {
+			return report, err
+		}
+		if !replays(config.Destinations, f.Destination) {
+			report.Skipped++
+			continue
+		}
+		if config.Speed > 0 && !previous.IsZero() {
+			if wait := time.Duration(float64(f.Time.Sub(previous)) / config.Speed); wait > 0 {
+				select {
+				case <-ctx.Done():
+					return report, ctx.Err()
+				case <-clock.After(wait):
+				}
+			}
+		}
+		previous = f.Time
+		if err = ctx.Err(); err != nil {
+			return report, err
+		}
+
+		switch {
+		case f.Direction == stompserver.RecordedIncoming && fe.replayRequest(f):
+			report.Requests++
+		case f.Direction == stompserver.RecordedOutgoing && config.Outgoing && fe.replayResponse(f):
+			report.Responses++
+		default:
+			report.Skipped++
+		}
+	}
+}
+
+func (fe *fabricEndpoint) replayRequest(f *stompserver.RecordedFrame) bool {
+	channelName, _, ok := fe.getChannelNameFromRequest(f.Destination)
+	if !ok || isProtectedDestination(channelName) {
+		return false
+	}
+	var req model.Request
+	if err := json.Unmarshal(f.Body, &req); err != nil {
+		return false
+	}
+	return fe.bus.SendRequestMessage(channelName, &req, nil) == nil
+}
+
+func (fe *fabricEndpoint) replayResponse(f *stompserver.RecordedFrame) bool {
+	if f.ConnectionId != "" {
+		return false
+	}
+	channelName, ok := fe.getChannelNameFromSubscription(f.Destination)
+	if !ok || isProtectedDestination(channelName) {
+		return false
+	}
+	return fe.bus.SendResponseMessage(channelName, f.Body, nil) == nil
+}
+
+func replays(destinations []string, destination string) bool {
+	if len(destinations) == 0 {
+		return true
+	}
+	for _, prefix := range destinations {
+		if strings.HasPrefix(destination, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package bus
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/google/uuid"
+	"github.com/pb33f/ranch/clock"
+	"github.com/pb33f/ranch/model"
+	"github.com/pb33f/ranch/stompserver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordedTestFrame struct {
+	direction string
+	connId    string
+	dest      string
+	body      []byte
+}
+
+func newTestRecording(t *testing.T, frames ...recordedTestFrame) *bytes.Buffer {
+	fake := clock.NewFakeClock(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	clock.Set(fake)
+	defer clock.Reset()
+
+	var buf bytes.Buffer
+	recorder := stompserver.NewRecorder(&buf)
+	for _, f := range frames {
+		recorded := frame.New(frame.MESSAGE, frame.Destination, f.dest)
+		recorded.Body = f.body
+		recorder.Record(f.direction, f.connId, f.dest, recorded)
+		fake.Advance(time.Hour)
+	}
+	require.NoError(t, recorder.Close())
+	return &buf
+}
+
+func TestFabricEndpoint_Replay(t *testing.T) {
+	bus := newTestEventBus()
+	fe, _ := newTestFabricEndpoint(bus, EndpointConfig{TopicPrefix: "/topic", AppRequestPrefix: "/pub"})
+	bus.GetChannelManager().CreateChannel("orders")
+
+	var lock sync.Mutex
+	var requests, responses []*model.Message
+	requestHandler, _ := bus.ListenRequestStream("orders")
+	requestHandler.Handle(func(message *model.Message) {
+		lock.Lock()
+		requests = append(requests, message)
+		lock.Unlock()
+	}, func(e error) {})
+	responseHandler, _ := bus.ListenStream("orders")
+	responseHandler.Handle(func(message *model.Message) {
+		lock.Lock()
+		responses = append(responses, message)
+		lock.Unlock()
+	}, func(e error) {})
+
+	id := uuid.New()
+	req, _ := json.Marshal(model.Request{RequestCommand: "list", Id: &id})
+	recording := newTestRecording(t,
+		recordedTestFrame{direction: stompserver.RecordedIncoming, connId: "con1", dest: "/pub/orders", body: req},
+		recordedTestFrame{direction: stompserver.RecordedIncoming, connId: "con1", dest: "/pub/orders", body: []byte("nope")},
+		recordedTestFrame{direction: stompserver.RecordedIncoming, connId: "con1", dest: "/topic/orders", body: req},
+		recordedTestFrame{direction: stompserver.RecordedOutgoing, dest: "/topic/orders", body: []byte(`{"orders":[]}`)},
+		recordedTestFrame{direction: stompserver.RecordedOutgoing, connId: "con1", dest: "/topic/orders", body: []byte("yours")},
+		recordedTestFrame{direction: stompserver.RecordedOutgoing, dest: "/topic/stock", body: []byte("filtered")},
+	)
+
+	report, err := fe.Replay(context.Background(), recording, ReplayConfig{
+		Destinations: []string{"/pub/", "/topic/orders"},
+		Outgoing:     true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, &ReplayReport{Requests: 1, Responses: 1, Skipped: 4}, report)
+	assert.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(requests) == 1 && len(responses) == 1
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, "list", requests[0].Payload.(*model.Request).RequestCommand)
+	assert.Equal(t, []byte(`{"orders":[]}`), responses[0].Payload)
+
+	// recorded responses are left out unless asked for
+	recording = newTestRecording(t,
+		recordedTestFrame{direction: stompserver.RecordedOutgoing, dest: "/topic/orders", body: []byte("{}")})
+	report, err = fe.Replay(context.Background(), recording, ReplayConfig{})
+	require.NoError(t, err)
+	assert.Equal(t, &ReplayReport{Skipped: 1}, report)
+
+	// a replay at the recorded pace stops with its context
+	recording = newTestRecording(t,
+		recordedTestFrame{direction: stompserver.RecordedIncoming, dest: "/pub/orders", body: req},
+		recordedTestFrame{direction: stompserver.RecordedIncoming, dest: "/pub/orders", body: req})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	report, err = fe.Replay(ctx, recording, ReplayConfig{Speed: 1})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, &ReplayReport{}, report)
+
+	_, err = fe.Replay(context.Background(), bytes.NewBufferString("not a recording"), ReplayConfig{})
+	assert.Error(t, err)
+}
+
+func TestFabricEndpoint_SetRecorder(t *testing.T) {
+	fe, mockServer := newTestFabricEndpoint(newTestEventBus(), EndpointConfig{TopicPrefix: "/topic"})
+	recorder := stompserver.NewRecorder(&bytes.Buffer{})
+	defer recorder.Close()
+	assert.Nil(t, fe.SetRecorder(recorder))
+	assert.Same(t, recorder, mockServer.recorder)
+	assert.Same(t, recorder, fe.SetRecorder(nil))
+}
+
+func TestEventBus_RecordFabricTraffic(t *testing.T) {
+	bus := newTestEventBus()
+	_, err := bus.RecordFabricTraffic(nil)
+	assert.Error(t, err)
+	_, err = bus.ReplayFabricRecording(context.Background(), &bytes.Buffer{}, ReplayConfig{})
+	assert.Error(t, err)
+
+	fe, _ := newTestFabricEndpoint(bus, EndpointConfig{TopicPrefix: "/topic"})
+	bus.(*transportEventBus).fabEndpoint = fe
+	recorder := stompserver.NewRecorder(&bytes.Buffer{})
+	defer recorder.Close()
+	previous, err := bus.RecordFabricTraffic(recorder)
+	assert.NoError(t, err)
+	assert.Nil(t, previous)
+	previous, err = bus.RecordFabricTraffic(nil)
+	assert.NoError(t, err)
+	assert.Same(t, recorder, previous)
+
+	report, err := bus.ReplayFabricRecording(context.Background(), &bytes.Buffer{}, ReplayConfig{})
+	assert.NoError(t, err)
+	assert.Equal(t, &ReplayReport{}, report)
+}
@@ -0,0 +1,189 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package bus
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/pb33f/ranch/log"
+	"github.com/pb33f/ranch/model"
+)
+
+// GALACTIC_STORE_DESTINATION_PREFIX prefixes the fabric destinations stores are synced on. A client that
+// subscribes to e.g. "/topic/galactic-store/my-store" is sent a storeContentResponse with the items and
+// version of the store, followed by an updateStoreResponse for every change made after that version.
+const GALACTIC_STORE_DESTINATION_PREFIX = "galactic-store/"
+
+// fabricStoreSync syncs stores to the fabric clients subscribed to their destinations. Subscriptions,
+// snapshots and changes are handled one at a time, in order, so a client never misses a change between its
+// snapshot and the updates that follow. Nothing is done on the STOMP server goroutine, which must stay free
+// to deliver the messages.
+type fabricStoreSync struct {
+	bus     EventBus
+	send    func(conId string, destination string, data []byte)
+	stores  map[string]*fabricSyncedStore // only used by the worker
+	queue   []func()
+	running bool
+	lock    sync.Mutex
+}
+
+type fabricSyncedStore struct {
+	name   string
+	store  BusStore
+	stream StoreStream
+	subs   map[string]*fabricStoreSubscriber
+}
+
+type fabricStoreSubscriber struct {
+	conId       string
+	destination string
+	ready       bool // the snapshot was sent, changes may follow
+}
+
+func newFabricStoreSync(bus EventBus, send func(conId string, destination string, data []byte)) *fabricStoreSync {
+	return &fabricStoreSync{
+		bus:    bus,
+		send:   send,
+		stores: make(map[string]*fabricSyncedStore),
+	}
+}
+
+// enqueue hands an operation to the worker, starting it if idle. Never blocks.
+func (s *fabricStoreSync) enqueue(op func()) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.queue = append(s.queue, op)
+	if !s.running {
+		s.running = true
+		go s.run()
+	}
+}
+
+func (s *fabricStoreSync) run() {
+	for {
+		s.lock.Lock()
+		if len(s.queue) == 0 {
+			s.running = false
+			s.lock.Unlock()
+			return
+		}
+		op := s.queue[0]
+		s.queue[0] = nil
+		s.queue = s.queue[1:]
+		s.lock.Unlock()
+		op()
+	}
+}
+
+// subscribe starts syncing a store to a client subscription, the snapshot is sent once the store is ready.
+func (s *fabricStoreSync) subscribe(conId string, subId string, destination string, storeName string) {
+	s.enqueue(func() {
+		synced := s.stores[storeName]
+		if synced == nil {
+			store := s.bus.GetStoreManager().GetStore(storeName)
+			if store == nil {
+				log.Warn("Cannot sync non-existing store '%s' to %s", storeName, destination)
+				return
+			}
+			synced = &fabricSyncedStore{
+				name:   storeName,
+				store:  store,
+				stream: store.OnAllChanges(),
+				subs:   make(map[string]*fabricStoreSubscriber),
+			}
+			_ = synced.stream.Subscribe(func(change *StoreChange) {
+				s.enqueue(func() { s.relayChange(synced, change) })
+			})
+			s.stores[storeName] = synced
+		}
+		key := conId + "#" + subId
+		synced.subs[key] = &fabricStoreSubscriber{conId: conId, destination: destination}
+		synced.store.WhenReady(func() {
+			s.enqueue(func() { s.sendSnapshot(synced, key) })
+		})
+	})
+}
+
+// deny tells a client subscription it may not read the store with an error response, like the store sync
+// service does for clients that may not open a store.
+func (s *fabricStoreSync) deny(conId string, destination string, storeName string) {
+	s.enqueue(func() {
+		data, err := json.Marshal(&model.Response{
+			Error:        true,
+			ErrorCode:    1,
+			ErrorMessage: "Access denied, cannot sync store: " + storeName,
+		})
+		if err != nil {
+			return
+		}
+		s.send(conId, destination, data)
+	})
+}
+
+// unsubscribe stops syncing a store to a client subscription.
+func (s *fabricStoreSync) unsubscribe(conId string, subId string, storeName string) {
+	s.enqueue(func() {
+		synced := s.stores[storeName]
+		if synced == nil {
+			return
+		}
+		delete(synced.subs, conId+"#"+subId)
+		if len(synced.subs) == 0 {
+			_ = synced.stream.Unsubscribe()
+			delete(s.stores, storeName)
+		}
+	})
+}
+
+// stop stops syncing every store.
+func (s *fabricStoreSync) stop() {
+	s.enqueue(func() {
+		for name, synced := range s.stores {
+			_ = synced.stream.Unsubscribe()
+			delete(s.stores, name)
+		}
+	})
+}
+
+func (s *fabricStoreSync) sendSnapshot(synced *fabricSyncedStore, key string) {
+	sub := synced.subs[key]
+	if sub == nil || sub.ready || s.stores[synced.name] != synced {
+		return
+	}
+	items, itemVersions, version := synced.store.AllValuesAndItemVersions()
+	contentResp := model.NewStoreContentResponse(synced.name, items, version)
+	contentResp.ItemVersions = itemVersions
+	data, err := json.Marshal(contentResp)
+	if err != nil {
+		log.Warn("Cannot sync store '%s': %s", synced.name, err.Error())
+		return
+	}
+	s.send(sub.conId, sub.destination, data)
+	sub.ready = true
+}
+
+// relayChange sends a change to every subscriber that was sent its snapshot. The changes of a store
+// transaction are sent as an update per item.
+func (s *fabricStoreSync) relayChange(synced *fabricSyncedStore, change *StoreChange) {
+	if s.stores[synced.name] != synced {
+		return
+	}
+	changes := []*StoreChange{change}
+	if len(change.Batch) > 0 {
+		changes = change.Batch
+	}
+	for _, itemChange := range changes {
+		data, err := json.Marshal(newSyncUpdateStoreResponse(synced.name, itemChange, false))
+		if err != nil {
+			log.Warn("Cannot sync change of item '%s' in store '%s': %s", itemChange.Id, synced.name, err.Error())
+			continue
+		}
+		for _, sub := range synced.subs {
+			if sub.ready {
+				s.send(sub.conId, sub.destination, data)
+			}
+		}
+	}
+}
@@ -42,6 +42,8 @@ type messageHandler struct {
 	subscriptionId  *uuid.UUID
 	invokeOnce      *sync.Once
 	channelManager  ChannelManager
+	bus             *transportEventBus
+	accepts         func(*model.Message) bool // reports if the handler wants a message sent on the channel
 }
 
 func (msgHandler *messageHandler) Handle(successHandler MessageHandlerFunction, errorHandler MessageErrorFunction) {
@@ -50,6 +52,7 @@ func (msgHandler *messageHandler) Handle(successHandler MessageHandlerFunction,
 
 	msgHandler.subscriptionId, _ = msgHandler.channelManager.SubscribeChannelHandler(
 		msgHandler.channel.Name, msgHandler.wrapperFunction, false)
+	msgHandler.channel.setHandlerFilter(msgHandler.subscriptionId, msgHandler.accepts)
 }
 
 func (msgHandler *messageHandler) Close() {
@@ -69,7 +72,9 @@ func (msgHandler *messageHandler) GetDestinationId() *uuid.UUID {
 
 func (msgHandler *messageHandler) Fire() error {
 	if msgHandler.requestMessage != nil {
-		sendMessageToChannel(msgHandler.channel, msgHandler.requestMessage)
+		if err := msgHandler.bus.sendMessageToChannel(msgHandler.channel, msgHandler.requestMessage); err != nil {
+			return err
+		}
 		msgHandler.channel.wg.Wait()
 		return nil
 	} else {
@@ -17,6 +17,7 @@ const (
 	BrokerUnsubscribedEvt
 	FabricEndpointSubscribeEvt
 	FabricEndpointUnsubscribeEvt
+	BrokerSubscriptionLostEvt // a broker subscription of a galactic channel closed with its connection
 )
 
 type MonitorEventHandler func(event *MonitorEvent)
@@ -11,15 +11,31 @@ import (
 	"github.com/pb33f/ranch/model"
 	"reflect"
 	"sync"
+	"time"
 )
 
 // Describes a single store item change
 type StoreChange struct {
-	Id             string      // the id of the updated item
-	Value          interface{} // the updated value of the item
-	State          interface{} // state associated with this change
-	IsDeleteChange bool        // true if the item was removed from the store
-	StoreVersion   int64       // the store's version when this change was made
+	Id             string         // the id of the updated item
+	Value          interface{}    // the updated value of the item
+	OldValue       interface{}    // the value of the item before the change, nil if the item was added
+	State          interface{}    // state associated with this change
+	IsDeleteChange bool           // true if the item was removed from the store
+	StoreVersion   int64          // the store's version when this change was made
+	ItemVersion    int64          // the item's version after the change, 0 if the item was removed
+	Batch          []*StoreChange // the item changes of a committed store transaction, in the order they were made
+}
+
+// Diff returns how the item changed, with the fields that changed when the item is a struct or a map.
+// Computing it is not free, so it is only done when asked for. Returns nil for batch changes, see Batch.
+func (change *StoreChange) Diff() *model.StoreItemDiff {
+	if len(change.Batch) > 0 {
+		return nil
+	}
+	if change.IsDeleteChange {
+		return model.DiffStoreItems(change.OldValue, nil)
+	}
+	return model.DiffStoreItems(change.OldValue, change.Value)
 }
 
 // BusStore is a stateful in memory cache for objects. All state changes (any time the cache is modified)
@@ -28,21 +44,37 @@ type StoreChange struct {
 type BusStore interface {
 	// Get the name (the id) of the store.
 	GetName() string
-	// Add new or updates existing item in the store.
+	// Add new or updates existing item in the store. Items the store validator rejects are dropped
+	// with a warning, use TryPut to be told about it.
 	Put(id string, value interface{}, state interface{})
+	// Add new or updates existing item in the store, returns a *ValidationError if the store validator
+	// rejects the item.
+	TryPut(id string, value interface{}, state interface{}) error
+	// Add new or updates existing item in the store, the item is removed once ttl has passed.
+	PutWithExpiry(id string, value interface{}, state interface{}, ttl time.Duration)
 	// Returns an item from the store and a boolean flag
 	// indicating whether the item exists
 	Get(id string) (interface{}, bool)
 	// Shorten version of the Get() method, returns only the item value.
 	GetValue(id string) interface{}
+	// Returns an item from the store with its version and a boolean flag
+	// indicating whether the item exists
+	GetWithVersion(id string) (interface{}, int64, bool)
+	// Add new or updates existing item in the store if its version is still expectedVersion,
+	// returns a *StoreVersionConflictError otherwise.
+	PutIfVersion(id string, value interface{}, state interface{}, expectedVersion int64) error
 	// Remove an item from the store. Returns true if the remove operation was successful.
 	Remove(id string, state interface{}) bool
+	// Start a transaction, applying several puts and removals at once when committed.
+	BeginTx(state interface{}) StoreTx
 	// Return a slice containing all store items.
 	AllValues() []interface{}
 	// Return a map with all items from the store.
 	AllValuesAsMap() map[string]interface{}
 	// Return a map with all items from the store with the current store version.
 	AllValuesAndVersion() (map[string]interface{}, int64)
+	// Return a map with all items from the store, a map with their versions and the current store version.
+	AllValuesAndItemVersions() (map[string]interface{}, map[string]int64, int64)
 	// Subscribe to state changes for a specific object.
 	OnChange(id string, state ...interface{}) StoreStream
 	// Subscribe to state changes for all objects
@@ -65,6 +97,16 @@ type BusStore interface {
 	// Get the item type if such is specified during the creation of the
 	// store
 	GetItemType() reflect.Type
+	// Reject items the validator rejects from now on, nil accepts every item.
+	SetValidator(validator PayloadValidator)
+	// Index the store items by a key, so they can be queried by it.
+	CreateIndex(name string, keyFn IndexKeyFunction) error
+	// Remove an index, closing the views that query it.
+	DropIndex(name string)
+	// Return a view of the items matching every predicate, kept up to date as the store changes.
+	Query(predicates ...QueryPredicate) (StoreView, error)
+	// Return the items matching every predicate.
+	QueryValues(predicates ...QueryPredicate) ([]interface{}, error)
 }
 
 // Internal BusStore implementation
@@ -72,6 +114,8 @@ type busStore struct {
 	name                string
 	itemsLock           sync.RWMutex
 	items               map[string]interface{}
+	itemVersions        map[string]int64 // the version of every item, guarded by itemsLock
+	itemVersionClock    int64            // the last item version handed out, never reset, guarded by itemsLock
 	storeVersion        int64
 	storeStreamsLock    sync.RWMutex
 	storeStreams        []*storeStream
@@ -84,6 +128,15 @@ type busStore struct {
 	bus                 EventBus
 	itemType            reflect.Type
 	storeSynHandler     MessageHandler
+	persistence         StorePersistence       // writes changes through, nil if the store is not persistent
+	persistedVersions   map[string]int64       // the persisted version of each item's last change, distributed stores only, guarded by itemsLock
+	persistedFloor      int64                  // the persisted version the items were last loaded at, guarded by itemsLock
+	expiries            map[string]time.Time   // when items put with an expiry are removed, guarded by itemsLock
+	sweeping            bool                   // true while the expiry sweeper runs, guarded by itemsLock
+	indexes             map[string]*storeIndex // secondary indexes of the items, guarded by itemsLock
+	views               []*storeView           // open query views, guarded by itemsLock
+	validator           PayloadValidator       // checks items before they are put, guarded by itemsLock
+	destroyed           chan struct{}
 }
 
 type galacticStoreConfig struct {
@@ -97,6 +150,7 @@ func newBusStore(name string, bus EventBus, itemType reflect.Type, galacticConf
 	store.bus = bus
 	store.itemType = itemType
 	store.galacticConf = galacticConf
+	store.destroyed = make(chan struct{})
 
 	initStore(store)
 
@@ -114,6 +168,8 @@ func initStore(store *busStore) {
 	store.storeStreams = []*storeStream{}
 	store.mutationStreams = []*mutationStoreStream{}
 	store.items = make(map[string]interface{})
+	store.itemVersions = make(map[string]int64)
+	store.expiries = make(map[string]time.Time)
 	store.storeVersion = 1
 	store.initializer = sync.Once{}
 }
@@ -164,6 +220,7 @@ func initGalacticStore(store *busStore) {
 						store.items[key] = deserializedValue
 					}
 				}
+				store.reindex()
 				store.Initialize()
 			case "updateStoreResponse":
 
@@ -183,6 +240,13 @@ func initGalacticStore(store *busStore) {
 					}
 					store.putInternal(itemId, newItemValue, "galacticSyncUpdate")
 				}
+			case "updateStoreBatchResponse":
+
+				store.itemsLock.Lock()
+				defer store.itemsLock.Unlock()
+
+				store.updateVersionFromResponse(storeResponse)
+				store.applyGalacticBatch(storeResponse)
 			}
 		},
 		func(e error) {
@@ -216,20 +280,17 @@ func (store *busStore) sendOpenStoreRequest() {
 }
 
 func (store *busStore) sendGalacticRequest(requestCmd string, requestPayload interface{}) {
-	// create request
-	id := uuid.New()
-	r := &model.Request{}
-	r.RequestCommand = requestCmd
-	r.Payload = requestPayload
-	r.Id = &id
-	jsonReq, _ := json.Marshal(r)
-
 	syncChannelConfig := store.galacticConf.syncChannelConfig
 
-	// send request.
-	syncChannelConfig.conn.SendJSONMessage(
-		syncChannelConfig.pubPrefix+syncChannelConfig.syncChannelName,
-		jsonReq)
+	// don't send requests the peer would drop or misread.
+	if protocol := syncChannelConfig.protocol.get(); !protocol.Supports(requestCmd) {
+		log.Warn("store sync peer does not support %s requests (protocol version %d), store %s is not synced",
+			requestCmd, protocol.Version, store.GetName())
+		return
+	}
+
+	id := uuid.New()
+	syncChannelConfig.sendRequest(requestCmd, requestPayload, &id)
 }
 
 func (store *busStore) sendCloseStoreRequest() {
@@ -240,6 +301,7 @@ func (store *busStore) sendCloseStoreRequest() {
 }
 
 func (store *busStore) OnDestroy() {
+	close(store.destroyed)
 	if store.IsGalactic() {
 		store.sendCloseStoreRequest()
 		if store.storeSynHandler != nil {
@@ -265,6 +327,12 @@ func (store *busStore) Populate(items map[string]interface{}) error {
 		return fmt.Errorf("populate() API is not supported for galactic stores")
 	}
 
+	for k, v := range items {
+		if err := store.validate(k, v); err != nil {
+			return err
+		}
+	}
+
 	store.itemsLock.Lock()
 	defer store.itemsLock.Unlock()
 
@@ -272,14 +340,27 @@ func (store *busStore) Populate(items map[string]interface{}) error {
 		return fmt.Errorf("store items already initialized")
 	}
 
+	ids := make([]string, 0, len(items))
 	for k, v := range items {
 		store.items[k] = v
+		ids = append(ids, k)
 	}
+	store.reindex()
+	store.persistUpdate(ids)
 	store.Initialize()
 	return nil
 }
 
 func (store *busStore) Put(id string, value interface{}, state interface{}) {
+	if err := store.TryPut(id, value, state); err != nil {
+		log.Warn("%s, item dropped", err.Error())
+	}
+}
+
+func (store *busStore) TryPut(id string, value interface{}, state interface{}) error {
+	if err := store.validate(id, value); err != nil {
+		return err
+	}
 	if store.IsGalactic() {
 		store.putGalactic(id, value)
 	} else {
@@ -288,6 +369,7 @@ func (store *busStore) Put(id string, value interface{}, state interface{}) {
 
 		store.putInternal(id, value, state)
 	}
+	return nil
 }
 
 func (store *busStore) putGalactic(id string, value interface{}) {
@@ -313,16 +395,21 @@ func (store *busStore) putInternal(id string, value interface{}, state interface
 	if !store.IsGalactic() {
 		store.storeVersion++
 	}
-	store.items[id] = value
+	oldValue := store.items[id]
+	store.setItem(id, value)
+	delete(store.expiries, id)
+	store.persistPut(id, value)
 
 	change := &StoreChange{
 		Id:           id,
 		State:        state,
 		Value:        value,
+		OldValue:     oldValue,
 		StoreVersion: store.storeVersion,
+		ItemVersion:  store.itemVersions[id],
 	}
 
-	go store.onStoreChange(change)
+	store.notifyChange(change)
 }
 
 func (store *busStore) Get(id string) (interface{}, bool) {
@@ -372,17 +459,20 @@ func (store *busStore) removeInternal(id string, state interface{}) bool {
 	if !store.IsGalactic() {
 		store.storeVersion++
 	}
-	delete(store.items, id)
+	store.deleteItem(id)
+	delete(store.expiries, id)
+	store.persistDelete(id)
 
 	change := &StoreChange{
 		Id:             id,
 		State:          state,
 		Value:          value,
+		OldValue:       value,
 		StoreVersion:   store.storeVersion,
 		IsDeleteChange: true,
 	}
 
-	go store.onStoreChange(change)
+	store.notifyChange(change)
 	return true
 }
 
@@ -474,6 +564,8 @@ func (store *busStore) Reset() {
 	defer store.storeStreamsLock.Unlock()
 
 	initStore(store)
+	store.reindex()
+	store.persistAll()
 
 	if store.IsGalactic() {
 		store.sendOpenStoreRequest()
@@ -0,0 +1,96 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package bus
+
+import (
+	"slices"
+	"sync"
+)
+
+// AnyPrincipal in a StoreAccessRule grants access to every client, authenticated or not.
+const AnyPrincipal = "*"
+
+// StoreAccessRule lists the principals that may read and write a store. A store with a rule can only be
+// read and written by the principals listed, leave WritePrincipals empty for a store only backend services
+// write to.
+type StoreAccessRule struct {
+	ReadPrincipals  []string `json:"read_principals"`  // principals that may open or subscribe to the store, or AnyPrincipal
+	WritePrincipals []string `json:"write_principals"` // principals that may update the store, or AnyPrincipal
+}
+
+// StoreAccessControl decides which principals may read and write stores on behalf of clients, over the
+// fabric (store sync requests and store destinations) and in services checking Request.Principal. Code
+// running in the process is not restricted. Stores without a rule are open to every client, unless a
+// fallback decides.
+type StoreAccessControl struct {
+	rules    map[string]*StoreAccessRule
+	fallback StoreAccessFallback
+	lock     sync.RWMutex
+}
+
+// StoreAccessFallback decides whether a principal may read, or write if write is set, a store without a
+// rule, e.g. by consulting an access control file.
+type StoreAccessFallback func(storeName string, principal string, write bool) bool
+
+// NewStoreAccessControl creates a StoreAccessControl without any rules.
+func NewStoreAccessControl() *StoreAccessControl {
+	return &StoreAccessControl{rules: make(map[string]*StoreAccessRule)}
+}
+
+// SetRule restricts access to the store to the principals of the rule, a nil rule lifts the restriction.
+func (ac *StoreAccessControl) SetRule(storeName string, rule *StoreAccessRule) {
+	ac.lock.Lock()
+	defer ac.lock.Unlock()
+	if rule == nil {
+		delete(ac.rules, storeName)
+		return
+	}
+	ac.rules[storeName] = rule
+}
+
+// SetFallback sets what decides access to the stores without a rule, a nil fallback opens them again.
+func (ac *StoreAccessControl) SetFallback(fallback StoreAccessFallback) {
+	ac.lock.Lock()
+	defer ac.lock.Unlock()
+	ac.fallback = fallback
+}
+
+// CanRead returns true if the principal may read the store. An empty principal is an anonymous client.
+func (ac *StoreAccessControl) CanRead(storeName string, principal string) bool {
+	return ac.allowed(storeName, principal, false)
+}
+
+// CanWrite returns true if the principal may write to the store. An empty principal is an anonymous client.
+func (ac *StoreAccessControl) CanWrite(storeName string, principal string) bool {
+	return ac.allowed(storeName, principal, true)
+}
+
+func (ac *StoreAccessControl) allowed(storeName string, principal string, write bool) bool {
+	if ac == nil {
+		return true
+	}
+	ac.lock.RLock()
+	rule, ok := ac.rules[storeName]
+	fallback := ac.fallback
+	ac.lock.RUnlock()
+	if !ok {
+		return fallback == nil || fallback(storeName, principal, write)
+	}
+	allowed := rule.ReadPrincipals
+	if write {
+		allowed = rule.WritePrincipals
+	}
+	if slices.Contains(allowed, AnyPrincipal) {
+		return true
+	}
+	return principal != "" && slices.Contains(allowed, principal)
+}
+
+func (m *storeManager) SetAccessControl(accessControl *StoreAccessControl) {
+	m.accessControl.Store(accessControl)
+}
+
+func (m *storeManager) GetAccessControl() *StoreAccessControl {
+	return m.accessControl.Load()
+}
@@ -0,0 +1,53 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package bus
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStoreAccessControl_Rules(t *testing.T) {
+	var unrestricted *StoreAccessControl
+	assert.True(t, unrestricted.CanWrite("config", ""))
+
+	ac := NewStoreAccessControl()
+	ac.SetRule("config", &StoreAccessRule{ReadPrincipals: []string{AnyPrincipal}})
+	ac.SetRule("orders", &StoreAccessRule{ReadPrincipals: []string{"alice"}, WritePrincipals: []string{"alice"}})
+
+	assert.True(t, ac.CanRead("config", ""))
+	assert.True(t, ac.CanRead("config", "bob"))
+	assert.False(t, ac.CanWrite("config", "bob"))
+
+	assert.True(t, ac.CanWrite("orders", "alice"))
+	assert.False(t, ac.CanRead("orders", "bob"))
+	assert.False(t, ac.CanRead("orders", ""))
+
+	// stores without a rule are open to everyone
+	assert.True(t, ac.CanWrite("scratch", ""))
+
+	ac.SetRule("orders", nil)
+	assert.True(t, ac.CanRead("orders", "bob"))
+}
+
+func TestStoreAccessControl_Fallback(t *testing.T) {
+	ac := NewStoreAccessControl()
+	ac.SetRule("config", &StoreAccessRule{ReadPrincipals: []string{AnyPrincipal}})
+	ac.SetFallback(func(storeName string, principal string, write bool) bool {
+		return principal == "alice" && (!write || storeName == "orders")
+	})
+
+	// stores with a rule do not consult the fallback
+	assert.True(t, ac.CanRead("config", "bob"))
+	assert.False(t, ac.CanWrite("config", "alice"))
+
+	assert.True(t, ac.CanWrite("orders", "alice"))
+	assert.True(t, ac.CanRead("scratch", "alice"))
+	assert.False(t, ac.CanWrite("scratch", "alice"))
+	assert.False(t, ac.CanRead("orders", "bob"))
+
+	ac.SetFallback(nil)
+	assert.True(t, ac.CanWrite("scratch", ""))
+}
@@ -0,0 +1,259 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package bus
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pb33f/ranch/clock"
+	"github.com/pb33f/ranch/model"
+	"io"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// StoreBackupFormatVersion is the version of the backup format written by StoreManager.Backup().
+const StoreBackupFormatVersion = 1
+
+// StoreRestoreState is the state of the store changes published when a store is restored from a backup.
+const StoreRestoreState = "storeRestore"
+
+// StoreBackup is a point in time snapshot of every local store.
+type StoreBackup struct {
+	FormatVersion int                `json:"formatVersion"`
+	Created       time.Time          `json:"created"`
+	Stores        []*StoreBackupItem `json:"stores"`
+}
+
+// StoreBackupItem holds the items of a single store.
+type StoreBackupItem struct {
+	Name    string                     `json:"name"`
+	Version int64                      `json:"version"`
+	Items   map[string]json.RawMessage `json:"items"`
+}
+
+// StoreSnapshot holds the items of a single store, for backing up, debugging or seeding that store.
+type StoreSnapshot struct {
+	FormatVersion int       `json:"formatVersion"`
+	Created       time.Time `json:"created"`
+	StoreBackupItem
+}
+
+// Backup writes a snapshot of every local store as JSON. All stores are locked while the snapshot is
+// taken, so it reflects the same point in time across stores: changes made before the backup started are
+// in it, changes made after are not. Mutation requests are not dispatched while the snapshot is taken.
+// Galactic stores mirror another instance and are left out.
+func (m *storeManager) Backup(w io.Writer) error {
+	m.storesLock.RLock()
+	stores := make([]*busStore, 0, len(m.stores))
+	for _, store := range m.stores {
+		if s, ok := store.(*busStore); ok && !s.IsGalactic() {
+			stores = append(stores, s)
+		}
+	}
+	m.storesLock.RUnlock()
+
+	// lock in the same order as Reset() does, and in name order across stores.
+	sort.Slice(stores, func(i, j int) bool {
+		return stores[i].name < stores[j].name
+	})
+	for _, store := range stores {
+		store.itemsLock.RLock()
+		store.mutationStreamsLock.Lock()
+	}
+
+	backup := &StoreBackup{FormatVersion: StoreBackupFormatVersion, Created: clock.Now().UTC()}
+	snapshots := make([]map[string]interface{}, len(stores))
+	for i, store := range stores {
+		snapshots[i] = make(map[string]interface{}, len(store.items))
+		for id, value := range store.items {
+			snapshots[i][id] = value
+		}
+		backup.Stores = append(backup.Stores, &StoreBackupItem{Name: store.name, Version: store.storeVersion})
+	}
+
+	for i := len(stores) - 1; i >= 0; i-- {
+		stores[i].mutationStreamsLock.Unlock()
+		stores[i].itemsLock.RUnlock()
+	}
+
+	// values are serialized once the stores are unlocked again.
+	for i, item := range backup.Stores {
+		item.Items = make(map[string]json.RawMessage, len(snapshots[i]))
+		for id, value := range snapshots[i] {
+			raw, err := json.Marshal(value)
+			if err != nil {
+				return fmt.Errorf("cannot back up item '%s' of store '%s': %w", id, item.Name, err)
+			}
+			item.Items[id] = raw
+		}
+	}
+	return json.NewEncoder(w).Encode(backup)
+}
+
+// Restore replaces the items of the stores in a backup written by Backup(). Stores missing from the
+// instance are created, stores missing from the backup are left alone. Items are converted to the item type
+// of existing stores. The whole backup is validated before any store is changed, and all restored stores
+// change at once. Subscribers see the changes with the StoreRestoreState state.
+func (m *storeManager) Restore(r io.Reader) error {
+	var backup StoreBackup
+	if err := json.NewDecoder(r).Decode(&backup); err != nil {
+		return fmt.Errorf("cannot read store backup: %w", err)
+	}
+	if backup.FormatVersion < 1 || backup.FormatVersion > StoreBackupFormatVersion {
+		return fmt.Errorf("unsupported store backup format version %d", backup.FormatVersion)
+	}
+
+	return m.restoreItems(backup.Stores)
+}
+
+// ExportSnapshot takes a snapshot of the items of a store. Unlike Backup(), it also takes snapshots of
+// galactic stores, as they were last synced.
+func (m *storeManager) ExportSnapshot(storeName string) (*StoreSnapshot, error) {
+	store, ok := m.GetStore(storeName).(*busStore)
+	if !ok {
+		return nil, fmt.Errorf("cannot export store '%s': store does not exist", storeName)
+	}
+	store.itemsLock.RLock()
+	items := make(map[string]interface{}, len(store.items))
+	for id, value := range store.items {
+		items[id] = value
+	}
+	snapshot := &StoreSnapshot{
+		FormatVersion:   StoreBackupFormatVersion,
+		Created:         clock.Now().UTC(),
+		StoreBackupItem: StoreBackupItem{Name: store.name, Version: store.storeVersion},
+	}
+	store.itemsLock.RUnlock()
+
+	// values are serialized once the store is unlocked again.
+	snapshot.Items = make(map[string]json.RawMessage, len(items))
+	for id, value := range items {
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("cannot export item '%s' of store '%s': %w", id, storeName, err)
+		}
+		snapshot.Items[id] = raw
+	}
+	return snapshot, nil
+}
+
+// ImportSnapshot replaces the items of the store named in the snapshot, creating the store if missing.
+// Items are converted to the item type of an existing store, and subscribers see the changes with the
+// StoreRestoreState state, like Restore() does.
+func (m *storeManager) ImportSnapshot(snapshot *StoreSnapshot) error {
+	if snapshot == nil {
+		return fmt.Errorf("cannot import store snapshot: no snapshot")
+	}
+	if snapshot.FormatVersion < 1 || snapshot.FormatVersion > StoreBackupFormatVersion {
+		return fmt.Errorf("unsupported store snapshot format version %d", snapshot.FormatVersion)
+	}
+	return m.restoreItems([]*StoreBackupItem{&snapshot.StoreBackupItem})
+}
+
+// restoreItems replaces the items of the stores, all at once. Every item is decoded before any store is
+// changed, so bad contents leave the stores untouched.
+func (m *storeManager) restoreItems(items []*StoreBackupItem) error {
+	stores := make([]*busStore, len(items))
+	contents := make([]map[string]interface{}, len(items))
+	names := make(map[string]bool)
+	for i, item := range items {
+		if item.Name == "" || names[item.Name] {
+			return fmt.Errorf("store backup contains an invalid or duplicate store name '%s'", item.Name)
+		}
+		names[item.Name] = true
+
+		existing := m.GetStore(item.Name)
+		if existing != nil && existing.IsGalactic() {
+			return fmt.Errorf("cannot restore galactic store '%s'", item.Name)
+		}
+		var itemType reflect.Type
+		if existing != nil {
+			itemType = existing.GetItemType()
+		}
+		contents[i] = make(map[string]interface{}, len(item.Items))
+		for id, raw := range item.Items {
+			var value interface{}
+			if err := json.Unmarshal(raw, &value); err != nil {
+				return fmt.Errorf("cannot restore item '%s' of store '%s': %w", id, item.Name, err)
+			}
+			if itemType != nil {
+				converted, err := model.ConvertValueToType(value, itemType)
+				if err != nil {
+					return fmt.Errorf("cannot restore item '%s' of store '%s': %w", id, item.Name, err)
+				}
+				value = converted
+			}
+			contents[i][id] = value
+		}
+	}
+
+	for i, item := range items {
+		store, ok := m.CreateStore(item.Name).(*busStore)
+		if !ok {
+			return fmt.Errorf("cannot restore store '%s'", item.Name)
+		}
+		stores[i] = store
+	}
+
+	order := make([]int, len(stores))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		return stores[order[a]].name < stores[order[b]].name
+	})
+	for _, i := range order {
+		stores[i].itemsLock.Lock()
+	}
+	for i, store := range stores {
+		store.restoreInternal(contents[i], items[i].Version)
+	}
+	for j := len(order) - 1; j >= 0; j-- {
+		stores[order[j]].itemsLock.Unlock()
+	}
+
+	for _, store := range stores {
+		store.Initialize()
+	}
+	return nil
+}
+
+// restoreInternal replaces the store items, the items lock must be held. The store version keeps
+// increasing, so that clients that synced a newer version of the store pick up the restored items.
+func (store *busStore) restoreInternal(items map[string]interface{}, version int64) {
+	if version < store.storeVersion {
+		version = store.storeVersion
+	}
+	store.storeVersion = version + 1
+	clear(store.expiries)
+
+	for id, value := range store.items {
+		if _, ok := items[id]; !ok {
+			store.deleteItem(id)
+			store.notifyChange(&StoreChange{
+				Id:             id,
+				State:          StoreRestoreState,
+				Value:          value,
+				OldValue:       value,
+				StoreVersion:   store.storeVersion,
+				IsDeleteChange: true,
+			})
+		}
+	}
+	for id, value := range items {
+		oldValue := store.items[id]
+		store.setItem(id, value)
+		store.notifyChange(&StoreChange{
+			Id:           id,
+			State:        StoreRestoreState,
+			Value:        value,
+			OldValue:     oldValue,
+			StoreVersion: store.storeVersion,
+			ItemVersion:  store.itemVersions[id],
+		})
+	}
+	store.persistAll()
+}
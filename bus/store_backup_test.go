@@ -0,0 +1,157 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package bus
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestStoreManager_BackupAndRestore(t *testing.T) {
+	m := newStoreManager(newTestEventBus())
+	typed := m.CreateStoreWithType("typed", reflect.TypeOf(MockStoreItem{}))
+	typed.Put("item1", MockStoreItem{From: "dave", Message: "howdy"}, nil)
+	plain := m.CreateStore("plain")
+	plain.Put("a", "value-a", nil)
+	plain.Put("b", float64(42), nil)
+
+	var buf bytes.Buffer
+	assert.NoError(t, m.Backup(&buf))
+
+	var backup StoreBackup
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &backup))
+	assert.Equal(t, StoreBackupFormatVersion, backup.FormatVersion)
+	assert.Len(t, backup.Stores, 2)
+	assert.Equal(t, "plain", backup.Stores[0].Name)
+	assert.Equal(t, int64(3), backup.Stores[0].Version)
+	assert.JSONEq(t, `{"from":"dave","message":"howdy"}`, string(backup.Stores[1].Items["item1"]))
+
+	// changes made after the backup are rolled back by a restore.
+	typed.Put("item2", MockStoreItem{From: "b33f"}, nil)
+	plain.Remove("a", nil)
+
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	var states []interface{}
+	var lock sync.Mutex
+	typed.OnAllChanges(StoreRestoreState).Subscribe(func(change *StoreChange) {
+		lock.Lock()
+		states = append(states, change.State)
+		lock.Unlock()
+		wg.Done()
+	})
+
+	assert.NoError(t, m.Restore(bytes.NewReader(buf.Bytes())))
+	wg.Wait()
+
+	assert.Equal(t, MockStoreItem{From: "dave", Message: "howdy"}, typed.GetValue("item1"))
+	assert.Nil(t, typed.GetValue("item2"))
+	assert.Equal(t, "value-a", plain.GetValue("a"))
+	assert.Equal(t, float64(42), plain.GetValue("b"))
+	assert.Equal(t, []interface{}{StoreRestoreState, StoreRestoreState}, states)
+
+	// the version keeps increasing.
+	_, version := plain.AllValuesAndVersion()
+	assert.Equal(t, int64(5), version)
+}
+
+func TestStoreManager_RestoreCreatesStores(t *testing.T) {
+	m := newStoreManager(newTestEventBus())
+	err := m.Restore(strings.NewReader(
+		`{"formatVersion":1,"stores":[{"name":"fresh","version":7,"items":{"x":"y"}}]}`))
+	assert.NoError(t, err)
+
+	store := m.GetStore("fresh")
+	assert.NotNil(t, store)
+	assert.Equal(t, "y", store.GetValue("x"))
+
+	ready := make(chan bool)
+	store.WhenReady(func() { close(ready) })
+	<-ready
+}
+
+func TestStoreManager_RestoreErrors(t *testing.T) {
+	m := newStoreManager(newTestEventBus())
+	typed := m.CreateStoreWithType("typed", reflect.TypeOf(MockStoreItem{}))
+	typed.Put("item1", MockStoreItem{From: "dave"}, nil)
+
+	assert.ErrorContains(t, m.Restore(strings.NewReader(`nope`)), "cannot read store backup")
+	assert.EqualError(t, m.Restore(strings.NewReader(`{"formatVersion":2}`)),
+		"unsupported store backup format version 2")
+	assert.EqualError(t, m.Restore(strings.NewReader(
+		`{"formatVersion":1,"stores":[{"name":"a"},{"name":"a"}]}`)),
+		"store backup contains an invalid or duplicate store name 'a'")
+
+	// a bad item leaves every store untouched, including the ones listed before it.
+	err := m.Restore(strings.NewReader(`{"formatVersion":1,"stores":[
+		{"name":"other","items":{"x":"y"}},
+		{"name":"typed","items":{"item1":"not-an-object"}}]}`))
+	assert.ErrorContains(t, err, "cannot restore item 'item1' of store 'typed'")
+	assert.Nil(t, m.GetStore("other"))
+	assert.Equal(t, MockStoreItem{From: "dave"}, typed.GetValue("item1"))
+}
+
+func TestStoreManager_BackupSkipsGalacticStores(t *testing.T) {
+	m := newStoreManager(newTestEventBus())
+	id := uuid.New()
+	con := &MockBridgeConnection{Id: &id}
+	subId := uuid.New()
+	con.On("Subscribe", mock.Anything).Return(&MockBridgeSubscription{Id: &subId}, nil)
+	con.On("SendJSONMessage", mock.Anything, mock.Anything).Return(nil)
+	assert.NoError(t, m.ConfigureStoreSyncChannel(con, "/topic", "/pub"))
+	_, err := m.OpenGalacticStore("remote", con)
+	assert.NoError(t, err)
+	m.CreateStore("local")
+
+	var buf bytes.Buffer
+	assert.NoError(t, m.Backup(&buf))
+	var backup StoreBackup
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &backup))
+	assert.Len(t, backup.Stores, 1)
+	assert.Equal(t, "local", backup.Stores[0].Name)
+
+	assert.EqualError(t, m.Restore(strings.NewReader(`{"formatVersion":1,"stores":[{"name":"remote"}]}`)),
+		"cannot restore galactic store 'remote'")
+}
+
+func TestStoreManager_ExportAndImportSnapshot(t *testing.T) {
+	m := newStoreManager(newTestEventBus())
+	_, err := m.ExportSnapshot("missing")
+	assert.ErrorContains(t, err, "store does not exist")
+
+	typed := m.CreateStoreWithType("typed", reflect.TypeOf(MockStoreItem{}))
+	typed.Put("item1", MockStoreItem{From: "dave", Message: "howdy"}, nil)
+	snapshot, err := m.ExportSnapshot("typed")
+	assert.NoError(t, err)
+
+	raw, _ := json.Marshal(snapshot)
+	var decoded StoreSnapshot
+	assert.NoError(t, json.Unmarshal(raw, &decoded))
+	assert.Equal(t, StoreBackupFormatVersion, decoded.FormatVersion)
+	assert.Equal(t, "typed", decoded.Name)
+	assert.EqualValues(t, 2, decoded.Version)
+	assert.JSONEq(t, `{"from":"dave","message":"howdy"}`, string(decoded.Items["item1"]))
+
+	typed.Put("item2", MockStoreItem{From: "b33f"}, nil)
+	assert.NoError(t, m.ImportSnapshot(&decoded))
+	assert.Equal(t, MockStoreItem{From: "dave", Message: "howdy"}, typed.GetValue("item1"))
+	assert.Nil(t, typed.GetValue("item2"))
+
+	// a snapshot can seed another store.
+	decoded.Name = "seeded"
+	assert.NoError(t, m.ImportSnapshot(&decoded))
+	assert.Equal(t, map[string]interface{}{"from": "dave", "message": "howdy"}, m.GetStore("seeded").GetValue("item1"))
+
+	assert.Error(t, m.ImportSnapshot(nil))
+	assert.EqualError(t, m.ImportSnapshot(&StoreSnapshot{FormatVersion: 2}),
+		"unsupported store snapshot format version 2")
+}
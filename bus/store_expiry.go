@@ -0,0 +1,81 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package bus
+
+import (
+	"time"
+
+	"github.com/pb33f/ranch/clock"
+	"github.com/pb33f/ranch/log"
+)
+
+// StoreItemExpiredState is the state of store changes removing items whose expiry has passed.
+const StoreItemExpiredState = "storeItemExpired"
+
+// StoreExpirySweepInterval is how often stores look for expired items, so an item is removed at most this
+// long after it expired.
+var StoreExpirySweepInterval = time.Second
+
+// PutWithExpiry adds or updates an item like Put does, and removes it once ttl has passed. The removal is
+// a store change with the StoreItemExpiredState state, synced to fabric clients like any other. Putting
+// the item again without an expiry keeps it. Expiries are kept in memory, so items of persistent stores
+// outlive their expiry across restarts, and galactic stores do not support them. Items the store validator
+// rejects are dropped with a warning.
+func (store *busStore) PutWithExpiry(id string, value interface{}, state interface{}, ttl time.Duration) {
+	if err := store.validate(id, value); err != nil {
+		log.Warn("%s, item dropped", err.Error())
+		return
+	}
+	if store.IsGalactic() {
+		log.Warn("galactic store %s does not support item expiry, item %s will not expire", store.name, id)
+		store.putGalactic(id, value)
+		return
+	}
+	store.itemsLock.Lock()
+	defer store.itemsLock.Unlock()
+
+	store.putInternal(id, value, state)
+	store.expiries[id] = clock.Now().Add(ttl)
+	if !store.sweeping {
+		store.sweeping = true
+		go store.sweepExpired()
+	}
+}
+
+// sweepExpired removes expired items every StoreExpirySweepInterval, until no item is left to expire or
+// the store is destroyed.
+func (store *busStore) sweepExpired() {
+	ticker := clock.NewTicker(StoreExpirySweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-store.destroyed:
+			return
+		case <-ticker.C():
+		}
+		if !store.removeExpired() {
+			return
+		}
+	}
+}
+
+// removeExpired removes the items whose expiry has passed. Returns false, and stops sweeping, if no item
+// is left to expire.
+func (store *busStore) removeExpired() bool {
+	store.itemsLock.Lock()
+	defer store.itemsLock.Unlock()
+
+	now := clock.Now()
+	for id, expiry := range store.expiries {
+		if !expiry.After(now) {
+			store.removeInternal(id, StoreItemExpiredState)
+			delete(store.expiries, id)
+		}
+	}
+	if len(store.expiries) == 0 {
+		store.sweeping = false
+		return false
+	}
+	return true
+}
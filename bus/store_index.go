@@ -0,0 +1,314 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package bus
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// IndexKeyFunction returns the key a store item is indexed under, false if the item is left out of the
+// index. Keys are numbers, strings, booleans or time.Time values, numbers of any type compare by value.
+type IndexKeyFunction func(value interface{}) (interface{}, bool)
+
+// FieldIndexKey indexes store items by a field. path is a dot separated path through struct fields, named
+// as in Go or in their json tag, and string keyed maps, e.g. "owner.name".
+func FieldIndexKey(path string) IndexKeyFunction {
+	fields := strings.Split(path, ".")
+	return func(value interface{}) (interface{}, bool) {
+		v := reflect.ValueOf(value)
+		for _, field := range fields {
+			if v = indirectValue(v); !v.IsValid() {
+				return nil, false
+			}
+			switch v.Kind() {
+			case reflect.Struct:
+				v = structField(v, field)
+			case reflect.Map:
+				if v.Type().Key().Kind() != reflect.String {
+					return nil, false
+				}
+				v = v.MapIndex(reflect.ValueOf(field).Convert(v.Type().Key()))
+			default:
+				return nil, false
+			}
+			if !v.IsValid() {
+				return nil, false
+			}
+		}
+		if v = indirectValue(v); !v.IsValid() {
+			return nil, false
+		}
+		return v.Interface(), true
+	}
+}
+
+func indirectValue(v reflect.Value) reflect.Value {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+func structField(v reflect.Value, name string) reflect.Value {
+	if f := v.FieldByName(name); f.IsValid() {
+		return f
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if tag, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ","); tag == name && t.Field(i).IsExported() {
+			return v.Field(i)
+		}
+	}
+	return reflect.Value{}
+}
+
+// QueryPredicate restricts a store query to the items whose key in Index lies between Min and Max.
+// A nil bound is open, see WhereEquals, WhereBetween, WhereGreaterThan and WhereLessThan.
+type QueryPredicate struct {
+	Index      string
+	Min        interface{}
+	Max        interface{}
+	ExcludeMin bool // true if items keyed Min do not match
+	ExcludeMax bool // true if items keyed Max do not match
+}
+
+// WhereEquals matches the items keyed value in the index.
+func WhereEquals(index string, value interface{}) QueryPredicate {
+	return QueryPredicate{Index: index, Min: value, Max: value}
+}
+
+// WhereBetween matches the items keyed from min up to and including max in the index.
+func WhereBetween(index string, min interface{}, max interface{}) QueryPredicate {
+	return QueryPredicate{Index: index, Min: min, Max: max}
+}
+
+// WhereGreaterThan matches the items keyed above value in the index.
+func WhereGreaterThan(index string, value interface{}) QueryPredicate {
+	return QueryPredicate{Index: index, Min: value, ExcludeMin: true}
+}
+
+// WhereLessThan matches the items keyed below value in the index.
+func WhereLessThan(index string, value interface{}) QueryPredicate {
+	return QueryPredicate{Index: index, Max: value, ExcludeMax: true}
+}
+
+// matches returns true if key lies within the bounds of the predicate, which are normalized.
+func (p *QueryPredicate) matches(key interface{}) bool {
+	if p.Min != nil {
+		c := compareIndexKeys(key, p.Min)
+		if c < 0 || (c == 0 && p.ExcludeMin) {
+			return false
+		}
+	}
+	if p.Max != nil {
+		c := compareIndexKeys(key, p.Max)
+		if c > 0 || (c == 0 && p.ExcludeMax) {
+			return false
+		}
+	}
+	return true
+}
+
+// normalized returns a copy of the predicate with its bounds normalized like index keys.
+func (p QueryPredicate) normalized() (QueryPredicate, error) {
+	for _, bound := range []*interface{}{&p.Min, &p.Max} {
+		if *bound == nil {
+			continue
+		}
+		key, ok := normalizeIndexKey(*bound)
+		if !ok {
+			return p, fmt.Errorf("cannot query index '%s' for %v (%T)", p.Index, *bound, *bound)
+		}
+		*bound = key
+	}
+	return p, nil
+}
+
+// storeIndex is a secondary index of a store, items are kept sorted by their key.
+type storeIndex struct {
+	keyFn   IndexKeyFunction
+	entries []indexEntry           // sorted by key, then id
+	keys    map[string]interface{} // key each indexed item is indexed under
+}
+
+type indexEntry struct {
+	key interface{}
+	id  string
+}
+
+func newStoreIndex(keyFn IndexKeyFunction) *storeIndex {
+	return &storeIndex{keyFn: keyFn, keys: make(map[string]interface{})}
+}
+
+// position returns where an entry with the key and id is, or would be inserted.
+func (idx *storeIndex) position(key interface{}, id string) int {
+	return sort.Search(len(idx.entries), func(i int) bool {
+		if c := compareIndexKeys(idx.entries[i].key, key); c != 0 {
+			return c > 0
+		}
+		return idx.entries[i].id >= id
+	})
+}
+
+func (idx *storeIndex) add(id string, value interface{}) {
+	raw, ok := idx.keyFn(value)
+	if !ok {
+		return
+	}
+	key, ok := normalizeIndexKey(raw)
+	if !ok {
+		return
+	}
+	i := idx.position(key, id)
+	idx.entries = append(idx.entries, indexEntry{})
+	copy(idx.entries[i+1:], idx.entries[i:])
+	idx.entries[i] = indexEntry{key: key, id: id}
+	idx.keys[id] = key
+}
+
+func (idx *storeIndex) remove(id string) {
+	key, ok := idx.keys[id]
+	if !ok {
+		return
+	}
+	i := idx.position(key, id)
+	idx.entries = append(idx.entries[:i], idx.entries[i+1:]...)
+	delete(idx.keys, id)
+}
+
+func (idx *storeIndex) rebuild(items map[string]interface{}) {
+	idx.entries = idx.entries[:0]
+	clear(idx.keys)
+	for id, value := range items {
+		if raw, ok := idx.keyFn(value); ok {
+			if key, ok := normalizeIndexKey(raw); ok {
+				idx.entries = append(idx.entries, indexEntry{key: key, id: id})
+				idx.keys[id] = key
+			}
+		}
+	}
+	sort.Slice(idx.entries, func(i, j int) bool {
+		if c := compareIndexKeys(idx.entries[i].key, idx.entries[j].key); c != 0 {
+			return c < 0
+		}
+		return idx.entries[i].id < idx.entries[j].id
+	})
+}
+
+// search returns the ids of the items matching the predicate, in key order.
+func (idx *storeIndex) search(p *QueryPredicate) []string {
+	start := 0
+	if p.Min != nil {
+		start = sort.Search(len(idx.entries), func(i int) bool {
+			c := compareIndexKeys(idx.entries[i].key, p.Min)
+			return c > 0 || (c == 0 && !p.ExcludeMin)
+		})
+	}
+	var ids []string
+	for _, entry := range idx.entries[start:] {
+		if !p.matches(entry.key) {
+			break
+		}
+		ids = append(ids, entry.id)
+	}
+	return ids
+}
+
+// normalizeIndexKey converts an index key to the form keys are compared in: int64 or float64 for numbers,
+// string, bool or time.Time. Returns false for any other kind of value.
+func normalizeIndexKey(key interface{}) (interface{}, bool) {
+	if t, ok := key.(time.Time); ok {
+		return t, true
+	}
+	v := indirectValue(reflect.ValueOf(key))
+	if !v.IsValid() {
+		return nil, false
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if u := v.Uint(); u <= math.MaxInt64 {
+			return int64(u), true
+		}
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		if f := v.Float(); !math.IsNaN(f) {
+			return f, true
+		}
+	case reflect.String:
+		return v.String(), true
+	case reflect.Bool:
+		return v.Bool(), true
+	case reflect.Struct:
+		if t, ok := v.Interface().(time.Time); ok {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// compareIndexKeys orders normalized keys. Keys of different kinds order booleans first, then numbers,
+// strings and times.
+func compareIndexKeys(a, b interface{}) int {
+	if ra, rb := indexKeyRank(a), indexKeyRank(b); ra != rb {
+		return ra - rb
+	}
+	switch av := a.(type) {
+	case bool:
+		bv := b.(bool)
+		switch {
+		case av == bv:
+			return 0
+		case !av:
+			return -1
+		}
+		return 1
+	case int64:
+		if bv, ok := b.(int64); ok {
+			return compareOrdered(av, bv)
+		}
+		return compareOrdered(float64(av), b.(float64))
+	case float64:
+		if bv, ok := b.(int64); ok {
+			return compareOrdered(av, float64(bv))
+		}
+		return compareOrdered(av, b.(float64))
+	case string:
+		return strings.Compare(av, b.(string))
+	case time.Time:
+		return av.Compare(b.(time.Time))
+	}
+	return 0
+}
+
+func indexKeyRank(key interface{}) int {
+	switch key.(type) {
+	case bool:
+		return 0
+	case int64, float64:
+		return 1
+	case string:
+		return 2
+	}
+	return 3
+}
+
+func compareOrdered[T int64 | float64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
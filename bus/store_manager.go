@@ -8,9 +8,11 @@ import (
 	"github.com/go-stomp/stomp/v3/frame"
 	"github.com/google/uuid"
 	"github.com/pb33f/ranch/bridge"
+	"io"
 	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 // StoreManager interface controls all access to BusStores
@@ -32,6 +34,22 @@ type StoreManager interface {
 	OpenGalacticStore(name string, conn bridge.Connection) (BusStore, error)
 	// Open new galactic store and deserialize items from server to itemType
 	OpenGalacticStoreWithItemType(name string, conn bridge.Connection, itemType reflect.Type) (BusStore, error)
+	// Write a consistent point in time snapshot of all local stores.
+	Backup(w io.Writer) error
+	// Replace the items of the stores in a snapshot written by Backup().
+	Restore(r io.Reader) error
+	// Take a snapshot of a single store, which can be encoded as JSON.
+	ExportSnapshot(storeName string) (*StoreSnapshot, error)
+	// Replace the items of the store named in a snapshot taken by ExportSnapshot().
+	ImportSnapshot(snapshot *StoreSnapshot) error
+	// Back the named stores with persistence, must be called before the stores are created.
+	SetStorePersistence(persistence StorePersistence, storeNames ...string) error
+	// Restrict which clients may read and write stores, nil lifts every restriction.
+	SetAccessControl(accessControl *StoreAccessControl)
+	// Get the access control of the stores, nil if clients are not restricted.
+	GetAccessControl() *StoreAccessControl
+	// Get the number of items in every store, by store name.
+	GetStoreSizes() map[string]int
 }
 
 // Interface which is a subset of the bridge.Connection methods.
@@ -46,6 +64,7 @@ type storeSyncChannelConfig struct {
 	pubPrefix       string
 	syncChannelName string
 	conn            galacticStoreConnection
+	protocol        syncProtocolState
 }
 
 type storeManager struct {
@@ -54,6 +73,9 @@ type storeManager struct {
 	eventBus         EventBus
 	syncChannelsLock sync.RWMutex
 	syncChannels     map[uuid.UUID]*storeSyncChannelConfig
+	persistence      StorePersistence
+	persistentStores map[string]bool
+	accessControl    atomic.Pointer[StoreAccessControl]
 }
 
 func newStoreManager(eventBus EventBus) StoreManager {
@@ -71,17 +93,24 @@ func (m *storeManager) CreateStore(name string) BusStore {
 
 func (m *storeManager) CreateStoreWithType(name string, itemType reflect.Type) BusStore {
 	m.storesLock.Lock()
-	defer m.storesLock.Unlock()
 
 	store, ok := m.stores[name]
 
 	if ok {
+		m.storesLock.Unlock()
 		return store
 	}
 
-	m.stores[name] = newBusStore(name, m.eventBus, itemType, nil)
+	store = newBusStore(name, m.eventBus, itemType, nil)
+	m.stores[name] = store
+	loaded := m.attachPersistence(store.(*busStore))
+	m.storesLock.Unlock()
+
 	go m.eventBus.SendMonitorEvent(StoreCreatedEvt, name, nil)
-	return m.stores[name]
+	if loaded {
+		store.Initialize()
+	}
+	return store
 }
 
 func (m *storeManager) GetStore(name string) BusStore {
@@ -91,6 +120,21 @@ func (m *storeManager) GetStore(name string) BusStore {
 	return m.stores[name]
 }
 
+func (m *storeManager) GetStoreSizes() map[string]int {
+	m.storesLock.RLock()
+	defer m.storesLock.RUnlock()
+
+	sizes := make(map[string]int, len(m.stores))
+	for name, store := range m.stores {
+		if s, ok := store.(*busStore); ok {
+			s.itemsLock.RLock()
+			sizes[name] = len(s.items)
+			s.itemsLock.RUnlock()
+		}
+	}
+	return sizes
+}
+
 func (m *storeManager) DestroyStore(name string) bool {
 	m.storesLock.Lock()
 	defer m.storesLock.Unlock()
@@ -99,6 +143,7 @@ func (m *storeManager) DestroyStore(name string) bool {
 	if ok {
 		store.(*busStore).OnDestroy()
 		delete(m.stores, name)
+		store.(*busStore).dropPersistence()
 
 		go m.eventBus.SendMonitorEvent(StoreDestroyedEvt, name, nil)
 	}
@@ -135,6 +180,7 @@ func (m *storeManager) ConfigureStoreSyncChannel(
 
 	m.eventBus.GetChannelManager().CreateChannel(syncChannel)
 	m.eventBus.GetChannelManager().MarkChannelAsGalactic(syncChannel, topicPrefix+syncChannel, conn)
+	storeSyncChannelConfig.negotiateProtocol(m.eventBus)
 
 	return nil
 }
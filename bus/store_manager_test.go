@@ -4,6 +4,7 @@
 package bus
 
 import (
+	"encoding/json"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -49,6 +50,15 @@ func TestStoreManager_GetStore(t *testing.T) {
 	assert.Nil(t, storeManager.GetStore("invalid-store"))
 }
 
+func TestStoreManager_GetStoreSizes(t *testing.T) {
+	storeManager := createTestStoreManager()
+	storeManager.CreateStore("testStore").Put("item1", "value1", nil)
+	storeManager.GetStore("testStore").Put("item2", "value2", nil)
+	storeManager.CreateStore("testStore2")
+
+	assert.Equal(t, map[string]int{"testStore": 2, "testStore2": 0}, storeManager.GetStoreSizes())
+}
+
 func TestStoreManager_DestroyStore(t *testing.T) {
 	storeManager := createTestStoreManager()
 	storeManager.CreateStore("testStore")
@@ -86,8 +96,15 @@ func TestStoreManager_ConfigureStoreSyncChannel(t *testing.T) {
 	syncChannelDst := "/topic-prefix/transport-store-sync." + id.String()
 	con.On("Subscribe", syncChannelDst).Return(s, nil)
 	con.On("SendMessage", syncChannelDst, mock.Anything).Return(nil)
+	con.On("SendJSONMessage", "/pub-prefix/transport-store-sync."+id.String(), mock.Anything).Return(nil)
 	m.ConfigureStoreSyncChannel(con, "/topic-prefix", "/pub-prefix")
 
+	// the protocol handshake is sent once the channel is configured
+	var hello map[string]interface{}
+	json.Unmarshal(con.Calls[len(con.Calls)-1].Arguments.Get(1).([]byte), &hello)
+	assert.Equal(t, "syncHello", hello["request"])
+	assert.Equal(t, float64(SyncProtocolVersion), hello["payload"].(map[string]interface{})["protocolVersion"])
+
 	storeManagerImpl := m.(*storeManager)
 
 	conf, ok := storeManagerImpl.syncChannels[id]
@@ -233,6 +250,7 @@ func TestStoreManager_OpenGalacticStoreWithLocalStoreId(t *testing.T) {
 	}
 	con.On("Subscribe", mock.Anything).Return(sub, nil)
 	con.On("SendMessage", mock.Anything, mock.Anything).Return(nil)
+	con.On("SendJSONMessage", mock.Anything, mock.Anything).Return(nil)
 	m.ConfigureStoreSyncChannel(con, "/topic-prefix", "/pub-prefix")
 
 	localStore := m.CreateStore("localStore")
@@ -0,0 +1,327 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package bus
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/pb33f/ranch/log"
+	"github.com/pb33f/ranch/model"
+	"reflect"
+)
+
+// StorePersistence keeps the items of persistent stores across restarts. Item values are JSON encoded.
+// An implementation wraps an embedded key/value store (see BoltStorePersistence, a Badger database works
+// just as well). Calls for the same store are never concurrent.
+type StorePersistence interface {
+	// Load returns the items and the version of a store, no items if nothing was saved for it.
+	Load(storeName string) (items map[string][]byte, version int64, err error)
+	// Put saves an item, along with the store version after the change. Returns the version saved.
+	Put(storeName string, id string, value []byte, version int64) (int64, error)
+	// Delete removes an item, along with the store version after the change. Returns the version saved.
+	Delete(storeName string, id string, version int64) (int64, error)
+	// Update saves several items at once, along with the store version after the change. Items with a nil
+	// value are removed. Returns the version saved.
+	Update(storeName string, items map[string][]byte, version int64) (int64, error)
+	// Replace replaces all items of a store. Returns the version saved.
+	Replace(storeName string, items map[string][]byte, version int64) (int64, error)
+	// Drop deletes everything saved for a store.
+	Drop(storeName string) error
+}
+
+// DistributedStorePersistence is a StorePersistence shared by several instances, so they all see the same
+// store state. It keeps the store versions itself: the version passed to Put, Delete and Replace is ignored
+// and the next version of the store is returned, so instances agree on them. Changes made by another
+// instance are applied as they arrive and emitted as store changes with the RemoteStoreChangeState state.
+type DistributedStorePersistence interface {
+	StorePersistence
+	// Watch starts calling changed with every change another instance makes to the store, value is nil
+	// for deleted items. reload is called instead when changes may have been missed or all items were
+	// replaced, the store then reloads all of its items.
+	Watch(storeName string, changed func(id string, value []byte, version int64), reload func()) error
+	// Unwatch stops watching the store.
+	Unwatch(storeName string)
+}
+
+// RemoteStoreChangeState is the state of store changes made by another instance sharing the store.
+const RemoteStoreChangeState = "remote-store-change"
+
+// SetStorePersistence backs the named stores with persistence. Their items are loaded when the store is
+// created and every change is written through, while reads keep being served from memory. Stores that
+// already exist cannot be made persistent, so this is called before the stores are created.
+func (m *storeManager) SetStorePersistence(persistence StorePersistence, storeNames ...string) error {
+	m.storesLock.Lock()
+	defer m.storesLock.Unlock()
+
+	for _, name := range storeNames {
+		if _, ok := m.stores[name]; ok {
+			return fmt.Errorf("cannot make store '%s' persistent: it already exists", name)
+		}
+	}
+	m.persistence = persistence
+	m.persistentStores = make(map[string]bool, len(storeNames))
+	for _, name := range storeNames {
+		m.persistentStores[name] = true
+	}
+	return nil
+}
+
+// attachPersistence loads the saved items of a new store and writes its changes through from now on.
+// Returns true if saved items were loaded, the store is then ready to be initialized.
+func (m *storeManager) attachPersistence(store *busStore) bool {
+	if m.persistence == nil || !m.persistentStores[store.name] {
+		return false
+	}
+	store.itemsLock.Lock()
+	defer store.itemsLock.Unlock()
+
+	// a distributed store is watched before it is loaded, so no change made in between is missed. Changes
+	// arriving while it loads wait for the items lock and are dropped if the loaded items include them.
+	if distributed, ok := m.persistence.(DistributedStorePersistence); ok {
+		store.persistedVersions = make(map[string]int64)
+		if err := distributed.Watch(store.name, store.applyPersistedChange, store.reloadPersisted); err != nil {
+			log.Warn("cannot watch distributed store %s for changes: %s", store.name, err.Error())
+		}
+	}
+	saved, version, err := m.persistence.Load(store.name)
+	if err != nil {
+		log.Warn("cannot load persistent store %s, starting empty: %s", store.name, err.Error())
+	}
+	for id, raw := range saved {
+		value, err := decodePersistedValue(raw, store.itemType)
+		if err != nil {
+			log.Warn("cannot load item %s of persistent store %s: %s", id, store.name, err.Error())
+			continue
+		}
+		store.setItem(id, value)
+	}
+	// a distributed store takes the shared version even if nothing was saved yet, so instances agree on it.
+	if version > store.storeVersion || store.persistedVersions != nil {
+		store.storeVersion = version
+	}
+	store.persistedFloor = version
+	store.persistence = m.persistence
+	return len(store.items) > 0
+}
+
+// dropPersistence deletes the persisted items of a destroyed store, and stops writing its changes through.
+// The items of a distributed store are left alone, other instances may still be using it.
+func (store *busStore) dropPersistence() {
+	store.itemsLock.Lock()
+	defer store.itemsLock.Unlock()
+	if store.persistence == nil {
+		return
+	}
+	if distributed, ok := store.persistence.(DistributedStorePersistence); ok {
+		distributed.Unwatch(store.name)
+	} else if err := store.persistence.Drop(store.name); err != nil {
+		log.Warn("cannot drop persistent store %s: %s", store.name, err.Error())
+	}
+	store.persistence = nil
+}
+
+// isPersistedChangeSeen returns true if the change of an item made elsewhere is already reflected by the
+// store, it was loaded since or the item was changed again, the items lock must be held.
+func (store *busStore) isPersistedChangeSeen(id string, version int64) bool {
+	return version <= store.persistedFloor || version <= store.persistedVersions[id]
+}
+
+// adoptPersistedVersion records the version the persistence gave a change of an item, the items lock must
+// be held. Distributed persistence hands out the versions, the store version catches up with them.
+func (store *busStore) adoptPersistedVersion(id string, version int64) {
+	if version > store.storeVersion {
+		store.storeVersion = version
+	}
+	if store.persistedVersions != nil {
+		store.persistedVersions[id] = version
+	}
+}
+
+// applyPersistedChange applies a change another instance made to an item of a distributed store, value is
+// nil if the item was deleted.
+func (store *busStore) applyPersistedChange(id string, raw []byte, version int64) {
+	store.itemsLock.Lock()
+	defer store.itemsLock.Unlock()
+	if store.persistence == nil || store.isPersistedChangeSeen(id, version) {
+		return
+	}
+	store.adoptPersistedVersion(id, version)
+
+	oldValue, exists := store.items[id]
+	if raw == nil {
+		if !exists {
+			return
+		}
+		store.deleteItem(id)
+		delete(store.expiries, id)
+		store.notifyChange(&StoreChange{Id: id, Value: oldValue, OldValue: oldValue,
+			State: RemoteStoreChangeState, StoreVersion: store.storeVersion, IsDeleteChange: true})
+		return
+	}
+	value, err := decodePersistedValue(raw, store.itemType)
+	if err != nil {
+		log.Warn("cannot load item %s of distributed store %s: %s", id, store.name, err.Error())
+		return
+	}
+	store.setItem(id, value)
+	delete(store.expiries, id)
+	store.notifyChange(&StoreChange{Id: id, Value: value, OldValue: oldValue,
+		State: RemoteStoreChangeState, StoreVersion: store.storeVersion, ItemVersion: store.itemVersions[id]})
+	store.Initialize()
+}
+
+// reloadPersisted brings the store in line with its persisted items after changes made elsewhere may have
+// been missed. Loading happens with the items lock held, so the persisted items include every change made here.
+func (store *busStore) reloadPersisted() {
+	store.itemsLock.Lock()
+	defer store.itemsLock.Unlock()
+	if store.persistence == nil {
+		return
+	}
+	saved, version, err := store.persistence.Load(store.name)
+	if err != nil {
+		log.Warn("cannot reload distributed store %s: %s", store.name, err.Error())
+		return
+	}
+
+	if version > store.storeVersion {
+		store.storeVersion = version
+	}
+	store.persistedFloor = version
+	store.persistedVersions = make(map[string]int64)
+
+	var changes []*StoreChange
+	for id, raw := range saved {
+		if current, ok := store.items[id]; ok {
+			if encoded, err := json.Marshal(current); err == nil && bytes.Equal(encoded, raw) {
+				continue
+			}
+		}
+		value, err := decodePersistedValue(raw, store.itemType)
+		if err != nil {
+			log.Warn("cannot load item %s of distributed store %s: %s", id, store.name, err.Error())
+			continue
+		}
+		oldValue := store.items[id]
+		store.setItem(id, value)
+		delete(store.expiries, id)
+		changes = append(changes, &StoreChange{Id: id, Value: value, OldValue: oldValue,
+			State: RemoteStoreChangeState, StoreVersion: store.storeVersion, ItemVersion: store.itemVersions[id]})
+	}
+	for id, value := range store.items {
+		if _, ok := saved[id]; !ok {
+			store.deleteItem(id)
+			delete(store.expiries, id)
+			changes = append(changes, &StoreChange{Id: id, Value: value, OldValue: value,
+				State: RemoteStoreChangeState, StoreVersion: store.storeVersion, IsDeleteChange: true})
+		}
+	}
+	for _, change := range changes {
+		store.notifyChange(change)
+	}
+	if len(store.items) > 0 {
+		store.Initialize()
+	}
+}
+
+func decodePersistedValue(raw []byte, itemType reflect.Type) (interface{}, error) {
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, err
+	}
+	if itemType == nil {
+		return value, nil
+	}
+	return model.ConvertValueToType(value, itemType)
+}
+
+// persistPut writes an item through to the persistence, the items lock must be held. Failures are
+// logged, the store keeps the change in memory.
+func (store *busStore) persistPut(id string, value interface{}) {
+	if store.persistence == nil {
+		return
+	}
+	raw, err := json.Marshal(value)
+	if err == nil {
+		var version int64
+		if version, err = store.persistence.Put(store.name, id, raw, store.storeVersion); err == nil {
+			store.adoptPersistedVersion(id, version)
+		}
+	}
+	if err != nil {
+		log.Warn("cannot persist item %s of store %s: %s", id, store.name, err.Error())
+	}
+}
+
+// persistDelete removes an item from the persistence, the items lock must be held.
+func (store *busStore) persistDelete(id string) {
+	if store.persistence == nil {
+		return
+	}
+	version, err := store.persistence.Delete(store.name, id, store.storeVersion)
+	if err != nil {
+		log.Warn("cannot remove persisted item %s of store %s: %s", id, store.name, err.Error())
+		return
+	}
+	store.adoptPersistedVersion(id, version)
+}
+
+// persistUpdate writes the items through to the persistence at once, the ones no longer in the store are
+// removed. The items lock must be held.
+func (store *busStore) persistUpdate(ids []string) {
+	if store.persistence == nil {
+		return
+	}
+	items := make(map[string][]byte, len(ids))
+	for _, id := range ids {
+		value, ok := store.items[id]
+		if !ok {
+			items[id] = nil
+			continue
+		}
+		raw, err := json.Marshal(value)
+		if err != nil {
+			log.Warn("cannot persist item %s of store %s: %s", id, store.name, err.Error())
+			continue
+		}
+		items[id] = raw
+	}
+	version, err := store.persistence.Update(store.name, items, store.storeVersion)
+	if err != nil {
+		log.Warn("cannot persist store %s: %s", store.name, err.Error())
+		return
+	}
+	for id := range items {
+		store.adoptPersistedVersion(id, version)
+	}
+}
+
+// persistAll replaces the persisted items with the items in memory, the items lock must be held.
+func (store *busStore) persistAll() {
+	if store.persistence == nil {
+		return
+	}
+	items := make(map[string][]byte, len(store.items))
+	for id, value := range store.items {
+		raw, err := json.Marshal(value)
+		if err != nil {
+			log.Warn("cannot persist item %s of store %s: %s", id, store.name, err.Error())
+			continue
+		}
+		items[id] = raw
+	}
+	version, err := store.persistence.Replace(store.name, items, store.storeVersion)
+	if err != nil {
+		log.Warn("cannot persist store %s: %s", store.name, err.Error())
+		return
+	}
+	if version > store.storeVersion {
+		store.storeVersion = version
+	}
+	if store.persistedVersions != nil {
+		store.persistedFloor = version
+		store.persistedVersions = make(map[string]int64)
+	}
+}
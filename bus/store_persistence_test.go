@@ -0,0 +1,236 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package bus
+
+import (
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStoreManager_PersistentStores(t *testing.T) {
+	p, _ := NewBoltStorePersistence(filepath.Join(t.TempDir(), "stores.db"), false)
+	defer p.Close()
+	counted := &countingTestPersistence{StorePersistence: p}
+
+	m := newStoreManager(newTestEventBus())
+	m.CreateStore("already-there")
+	assert.Error(t, m.SetStorePersistence(counted, "already-there"))
+	assert.NoError(t, m.SetStorePersistence(counted, "chats", "plain"))
+
+	chats := m.CreateStoreWithType("chats", reflect.TypeOf(MockStoreItem{}))
+	chats.Put("msg1", MockStoreItem{From: "dave", Message: "howdy"}, nil)
+	chats.Put("msg2", MockStoreItem{From: "b33f", Message: "moo"}, nil)
+	chats.Remove("msg2", nil)
+	tx := chats.BeginTx(nil)
+	tx.Put("msg3", MockStoreItem{From: "dave", Message: "bye"})
+	tx.Delete("msg1")
+	assert.NoError(t, tx.Commit())
+	plain := m.CreateStore("plain")
+	assert.NoError(t, plain.Populate(map[string]interface{}{"a": "value-a"}))
+	m.CreateStore("transient").Put("gone", "soon", nil)
+
+	// transactions and populating write only the items they change.
+	assert.Zero(t, counted.replaced)
+	assert.Equal(t, 2, counted.updated)
+
+	// a new instance picks up where the last one left off.
+	restarted := newStoreManager(newTestEventBus())
+	assert.NoError(t, restarted.SetStorePersistence(p, "chats", "plain"))
+	chats = restarted.CreateStoreWithType("chats", reflect.TypeOf(MockStoreItem{}))
+	items, version := chats.AllValuesAndVersion()
+	assert.Equal(t, map[string]interface{}{"msg3": MockStoreItem{From: "dave", Message: "bye"}}, items)
+	assert.EqualValues(t, 5, version)
+	assert.Equal(t, "value-a", restarted.CreateStore("plain").GetValue("a"))
+	assert.Nil(t, restarted.CreateStore("transient").GetValue("gone"))
+
+	ready := make(chan bool, 1)
+	chats.WhenReady(func() {
+		ready <- true
+	})
+	assert.True(t, <-ready)
+
+	chats.Reset()
+	assert.True(t, restarted.DestroyStore("plain"))
+	saved, _, _ := p.Load("chats")
+	assert.Empty(t, saved)
+	saved, _, _ = p.Load("plain")
+	assert.Empty(t, saved)
+}
+
+// countingTestPersistence counts the updates and replacements of all items passed to a StorePersistence.
+type countingTestPersistence struct {
+	StorePersistence
+	updated  int
+	replaced int
+}
+
+func (p *countingTestPersistence) Update(storeName string, items map[string][]byte, version int64) (int64, error) {
+	p.updated++
+	return p.StorePersistence.Update(storeName, items, version)
+}
+
+func (p *countingTestPersistence) Replace(storeName string, items map[string][]byte, version int64) (int64, error) {
+	p.replaced++
+	return p.StorePersistence.Replace(storeName, items, version)
+}
+
+// sharedTestItems holds the items of a distributed store in memory, shared by store managers the way
+// instances share a Redis server.
+type sharedTestItems struct {
+	items    map[string][]byte
+	version  int64
+	watchers map[*sharedTestPersistence]func(id string, value []byte, version int64)
+	lock     sync.Mutex
+}
+
+// sharedTestPersistence is the DistributedStorePersistence of a single instance, backed by sharedTestItems.
+type sharedTestPersistence struct {
+	shared *sharedTestItems
+}
+
+func newSharedTestItems() *sharedTestItems {
+	return &sharedTestItems{
+		items:    make(map[string][]byte),
+		watchers: make(map[*sharedTestPersistence]func(id string, value []byte, version int64)),
+	}
+}
+
+func (s *sharedTestItems) instance() *sharedTestPersistence {
+	return &sharedTestPersistence{shared: s}
+}
+
+func (p *sharedTestPersistence) Load(string) (map[string][]byte, int64, error) {
+	p.shared.lock.Lock()
+	defer p.shared.lock.Unlock()
+	items := make(map[string][]byte, len(p.shared.items))
+	for id, raw := range p.shared.items {
+		items[id] = raw
+	}
+	return items, p.shared.version, nil
+}
+
+// change applies changed items to the shared items and hands them to the other instances watching them.
+func (p *sharedTestPersistence) change(items map[string][]byte) (int64, error) {
+	s := p.shared
+	s.lock.Lock()
+	for id, value := range items {
+		if value == nil {
+			delete(s.items, id)
+		} else {
+			s.items[id] = value
+		}
+	}
+	s.version++
+	version := s.version
+	var watchers []func(id string, value []byte, version int64)
+	for instance, changed := range s.watchers {
+		if instance != p {
+			watchers = append(watchers, changed)
+		}
+	}
+	s.lock.Unlock()
+	for _, changed := range watchers {
+		for id, value := range items {
+			changed(id, value, version)
+		}
+	}
+	return version, nil
+}
+
+func (p *sharedTestPersistence) Put(_ string, id string, value []byte, _ int64) (int64, error) {
+	return p.change(map[string][]byte{id: value})
+}
+
+func (p *sharedTestPersistence) Delete(_ string, id string, _ int64) (int64, error) {
+	return p.change(map[string][]byte{id: nil})
+}
+
+func (p *sharedTestPersistence) Update(_ string, items map[string][]byte, _ int64) (int64, error) {
+	return p.change(items)
+}
+
+func (p *sharedTestPersistence) Replace(_ string, items map[string][]byte, _ int64) (int64, error) {
+	p.shared.lock.Lock()
+	defer p.shared.lock.Unlock()
+	p.shared.items = items
+	p.shared.version++
+	return p.shared.version, nil
+}
+
+func (p *sharedTestPersistence) Drop(string) error {
+	return nil
+}
+
+func (p *sharedTestPersistence) Watch(_ string, changed func(id string, value []byte, version int64), _ func()) error {
+	p.shared.lock.Lock()
+	defer p.shared.lock.Unlock()
+	p.shared.watchers[p] = changed
+	return nil
+}
+
+func (p *sharedTestPersistence) Unwatch(string) {
+	p.shared.lock.Lock()
+	defer p.shared.lock.Unlock()
+	delete(p.shared.watchers, p)
+}
+
+func TestStoreManager_DistributedStores(t *testing.T) {
+	shared := newSharedTestItems()
+	first := newStoreManager(newTestEventBus())
+	second := newStoreManager(newTestEventBus())
+	assert.NoError(t, first.SetStorePersistence(shared.instance(), "chats"))
+	assert.NoError(t, second.SetStorePersistence(shared.instance(), "chats"))
+
+	mine := first.CreateStoreWithType("chats", reflect.TypeOf(MockStoreItem{}))
+	theirs := second.CreateStoreWithType("chats", reflect.TypeOf(MockStoreItem{}))
+	changes := make(chan *StoreChange, 10)
+	sub := theirs.OnAllChanges(RemoteStoreChangeState)
+	_ = sub.Subscribe(func(change *StoreChange) {
+		changes <- change
+	})
+	echoes := make(chan *StoreChange, 10)
+	mySub := mine.OnAllChanges(RemoteStoreChangeState)
+	_ = mySub.Subscribe(func(change *StoreChange) {
+		echoes <- change
+	})
+
+	mine.Put("msg1", MockStoreItem{From: "dave", Message: "howdy"}, nil)
+	change := <-changes
+	assert.Equal(t, "msg1", change.Id)
+	assert.Equal(t, RemoteStoreChangeState, change.State)
+	assert.Equal(t, MockStoreItem{From: "dave", Message: "howdy"}, change.Value)
+	assert.EqualValues(t, 1, change.StoreVersion)
+	assert.Equal(t, MockStoreItem{From: "dave", Message: "howdy"}, theirs.GetValue("msg1"))
+
+	mine.Remove("msg1", nil)
+	change = <-changes
+	assert.True(t, change.IsDeleteChange)
+	assert.EqualValues(t, 2, change.StoreVersion)
+	assert.Nil(t, theirs.GetValue("msg1"))
+
+	// both instances agree on the store version, and neither sees its own changes coming back.
+	_, myVersion := mine.AllValuesAndVersion()
+	_, theirVersion := theirs.AllValuesAndVersion()
+	assert.EqualValues(t, 2, myVersion)
+	assert.EqualValues(t, 2, theirVersion)
+	assert.Empty(t, echoes)
+
+	// a change arriving after a later change of the same item was made here is dropped.
+	theirs.Put("msg3", MockStoreItem{From: "b33f", Message: "later"}, nil)
+	<-echoes
+	theirs.(*busStore).applyPersistedChange("msg3", []byte(`{"from":"b33f","message":"earlier"}`), 2)
+	assert.Equal(t, MockStoreItem{From: "b33f", Message: "later"}, theirs.GetValue("msg3"))
+	assert.Empty(t, changes)
+
+	// destroying a distributed store leaves the shared items to the other instances.
+	theirs.Put("msg2", MockStoreItem{From: "b33f", Message: "moo"}, nil)
+	<-echoes
+	assert.True(t, second.DestroyStore("chats"))
+	items, _, _ := shared.instance().Load("chats")
+	assert.Len(t, items, 2)
+}
@@ -85,6 +85,13 @@ func (s *storeStream) Unsubscribe() error {
 }
 
 func (s *storeStream) onStoreChange(change *StoreChange) {
+	if len(change.Batch) > 0 && !s.filter.matchAllItems {
+		// streams of a single item see the changes of that item only.
+		for _, itemChange := range change.Batch {
+			s.onStoreChange(itemChange)
+		}
+		return
+	}
 	if !s.filter.match(change) {
 		return
 	}
@@ -4,6 +4,7 @@
 package bus
 
 import (
+	"fmt"
 	"github.com/google/uuid"
 	"github.com/pb33f/ranch/model"
 	"strings"
@@ -28,6 +29,7 @@ type storeSyncService struct {
 type syncStoreListener struct {
 	storeStream        StoreStream
 	clientSyncChannels map[string]bool
+	clientProtocols    map[string]*syncProtocolState
 	lock               sync.RWMutex
 }
 
@@ -35,6 +37,7 @@ type syncClientChannel struct {
 	channelName           string
 	clientRequestListener MessageHandler
 	openStores            map[string]bool
+	protocol              syncProtocolState
 }
 
 func newStoreSyncService(bus EventBus) *storeSyncService {
@@ -86,6 +89,13 @@ func (syncService *storeSyncService) openNewClientSyncChannel(channelName string
 				if !reqOk || request.Payload == nil {
 					return
 				}
+				if request.RequestCommand == syncHelloRequest {
+					syncService.syncHello(syncClient, request.Payload, request.Id)
+					return
+				}
+				if !syncService.checkProtocol(syncClient, request) {
+					return
+				}
 				var storeRequest map[string]interface{}
 				storeRequest, ok := request.Payload.(map[string]interface{})
 				if !ok {
@@ -94,11 +104,11 @@ func (syncService *storeSyncService) openNewClientSyncChannel(channelName string
 
 				switch request.RequestCommand {
 				case openStoreRequest:
-					syncService.openStore(syncClient, storeRequest, request.Id)
+					syncService.openStore(syncClient, storeRequest, request.Id, request.Principal)
 				case closeStoreRequest:
 					syncService.closeStore(syncClient, storeRequest, request.Id)
 				case updateStoreRequest:
-					syncService.updateStore(syncClient, storeRequest, request.Id)
+					syncService.updateStore(syncClient, storeRequest, request.Id, request.Principal)
 				}
 			}, func(e error) {})
 	}
@@ -129,8 +139,50 @@ func (syncService *storeSyncService) closeClientSyncChannel(channelName string)
 	delete(syncService.syncClients, channelName)
 }
 
+// syncHello negotiates the protocol spoken on the channel with the peer that opened it.
+func (syncService *storeSyncService) syncHello(
+	syncClient *syncClientChannel, payload interface{}, reqId *uuid.UUID) {
+
+	hello, err := decodeSyncHello(payload)
+	if err != nil {
+		syncService.sendErrorResponse(syncClient.channelName, "Invalid SyncHelloRequest: "+err.Error(), reqId)
+		return
+	}
+
+	protocol := hello.negotiate()
+	syncClient.protocol.set(protocol)
+	if !protocol.Compatible() {
+		syncService.sendErrorResponse(syncClient.channelName,
+			fmt.Sprintf("Incompatible store sync protocol: peer speaks %s, this instance speaks %s",
+				hello, newSyncHello()), reqId)
+		return
+	}
+
+	syncService.bus.SendResponseMessage(syncClient.channelName,
+		&syncHelloReply{ResponseType: syncHelloResponse, SyncProtocol: *protocol}, nil)
+}
+
+// checkProtocol returns true if the request may be handled under the protocol negotiated with the peer.
+// Peers that announced the request-errors capability are told why a request was refused, older peers
+// would not know what to make of the error.
+func (syncService *storeSyncService) checkProtocol(syncClient *syncClientChannel, request *model.Request) bool {
+	protocol := syncClient.protocol.get()
+	if protocol.Supports(request.RequestCommand) {
+		return true
+	}
+	if protocol.HasCapability(SyncCapabilityRequestErrors) {
+		errMsg := fmt.Sprintf("Unsupported store sync request %q for protocol version %d",
+			request.RequestCommand, protocol.Version)
+		if !protocol.Compatible() {
+			errMsg = "Incompatible store sync protocol, refusing request " + request.RequestCommand
+		}
+		syncService.sendErrorResponse(syncClient.channelName, errMsg, request.Id)
+	}
+	return false
+}
+
 func (syncService *storeSyncService) openStore(
-	syncClient *syncClientChannel, request map[string]interface{}, reqId *uuid.UUID) {
+	syncClient *syncClientChannel, request map[string]interface{}, reqId *uuid.UUID, principal string) {
 
 	storeId, ok := getStingProperty("storeId", request)
 	if !ok || storeId == "" {
@@ -144,6 +196,11 @@ func (syncService *storeSyncService) openStore(
 			syncClient.channelName, "Cannot open non-existing store: "+storeId, reqId)
 		return
 	}
+	if !syncService.bus.GetStoreManager().GetAccessControl().CanRead(storeId, principal) {
+		syncService.sendErrorResponse(
+			syncClient.channelName, "Access denied, cannot open store: "+storeId, reqId)
+		return
+	}
 
 	syncService.lock.Lock()
 	defer syncService.lock.Unlock()
@@ -155,13 +212,14 @@ func (syncService *storeSyncService) openStore(
 		storeListener = newSyncStoreListener(syncService.bus, store)
 		syncService.syncStoreListeners[storeId] = storeListener
 	}
-	storeListener.addChannel(syncClient.channelName)
+	storeListener.addChannel(syncClient.channelName, &syncClient.protocol)
 
 	store.WhenReady(func() {
-		items, version := store.AllValuesAndVersion()
+		items, itemVersions, version := store.AllValuesAndItemVersions()
 
-		syncService.bus.SendResponseMessage(syncClient.channelName,
-			model.NewStoreContentResponse(storeId, items, version), nil)
+		contentResp := model.NewStoreContentResponse(storeId, items, version)
+		contentResp.ItemVersions = itemVersions
+		syncService.bus.SendResponseMessage(syncClient.channelName, contentResp, nil)
 	})
 }
 
@@ -190,7 +248,7 @@ func (syncService *storeSyncService) closeStore(
 }
 
 func (syncService *storeSyncService) updateStore(
-	syncClient *syncClientChannel, request map[string]interface{}, reqId *uuid.UUID) {
+	syncClient *syncClientChannel, request map[string]interface{}, reqId *uuid.UUID, principal string) {
 
 	storeId, ok := getStingProperty("storeId", request)
 	if !ok || storeId == "" {
@@ -211,6 +269,11 @@ func (syncService *storeSyncService) updateStore(
 			syncClient.channelName, "Cannot update non-existing store: "+storeId, reqId)
 		return
 	}
+	if !syncService.bus.GetStoreManager().GetAccessControl().CanWrite(storeId, principal) {
+		syncService.sendErrorResponse(
+			syncClient.channelName, "Access denied, cannot update store: "+storeId, reqId)
+		return
+	}
 
 	rawValue, ok := request["newItemValue"]
 	if rawValue == nil {
@@ -225,7 +288,14 @@ func (syncService *storeSyncService) updateStore(
 			syncService.sendErrorResponse(syncClient.channelName, errMsg, reqId)
 			return
 		}
-		store.Put(itemId, deserializedValue, galacticStoreSyncUpdate)
+		if expectedVersion, versioned := getInt64Property("expectedItemVersion", request); versioned {
+			err = store.PutIfVersion(itemId, deserializedValue, galacticStoreSyncUpdate, expectedVersion)
+		} else {
+			err = store.TryPut(itemId, deserializedValue, galacticStoreSyncUpdate)
+		}
+		if err != nil {
+			syncService.sendErrorResponse(syncClient.channelName, "Cannot update store item: "+err.Error(), reqId)
+		}
 	}
 }
 
@@ -238,6 +308,19 @@ func getStingProperty(id string, request map[string]interface{}) (string, bool)
 	return stringValue, ok
 }
 
+// getInt64Property reads a number from a request, which carries float64 values once decoded from JSON.
+func getInt64Property(id string, request map[string]interface{}) (int64, bool) {
+	switch v := request[id].(type) {
+	case float64:
+		return int64(v), true
+	case int64:
+		return v, true
+	case int:
+		return int64(v), true
+	}
+	return 0, false
+}
+
 func (syncService *storeSyncService) sendErrorResponse(
 	clientChannel string, errorMsg string, reqId *uuid.UUID) {
 
@@ -254,19 +337,29 @@ func newSyncStoreListener(bus EventBus, store BusStore) *syncStoreListener {
 	listener := &syncStoreListener{
 		storeStream:        store.OnAllChanges(),
 		clientSyncChannels: make(map[string]bool),
+		clientProtocols:    make(map[string]*syncProtocolState),
 	}
 
 	listener.storeStream.Subscribe(func(change *StoreChange) {
-		updateStoreResp := model.NewUpdateStoreResponse(
-			store.GetName(), change.Id, change.Value, change.StoreVersion)
-		if change.IsDeleteChange {
-			updateStoreResp.NewItemValue = nil
-		}
-
 		listener.lock.RLock()
 		defer listener.lock.RUnlock()
 
+		if len(change.Batch) > 0 {
+			listener.sendBatch(bus, store.GetName(), change)
+			return
+		}
+		var updateStoreResp, diffResp *model.UpdateStoreResponse
 		for chName := range listener.clientSyncChannels {
+			if listener.hasCapability(chName, SyncCapabilityItemDiffs) {
+				if diffResp == nil {
+					diffResp = newSyncUpdateStoreResponse(store.GetName(), change, true)
+				}
+				bus.SendResponseMessage(chName, diffResp, nil)
+				continue
+			}
+			if updateStoreResp == nil {
+				updateStoreResp = newSyncUpdateStoreResponse(store.GetName(), change, false)
+			}
 			bus.SendResponseMessage(chName, updateStoreResp, nil)
 		}
 	})
@@ -279,16 +372,71 @@ func (l *syncStoreListener) unsubscribe() {
 	l.storeStream.Unsubscribe()
 }
 
-func (l *syncStoreListener) addChannel(clientChannel string) {
+// hasCapability returns true if the client of the channel negotiated the capability. The listener lock
+// must be held.
+func (l *syncStoreListener) hasCapability(chName string, capability string) bool {
+	protocol := l.clientProtocols[chName]
+	return protocol != nil && protocol.get().HasCapability(capability)
+}
+
+// sendBatch relays the changes of a store transaction, as a single update to clients that support
+// transactions and as an update per item to the others. The listener lock must be held.
+func (l *syncStoreListener) sendBatch(bus EventBus, storeName string, change *StoreChange) {
+	batchResps := make(map[bool]*model.UpdateStoreBatchResponse)
+	for chName := range l.clientSyncChannels {
+		withDiffs := l.hasCapability(chName, SyncCapabilityItemDiffs)
+		if l.hasCapability(chName, SyncCapabilityTransactions) {
+			batchResp := batchResps[withDiffs]
+			if batchResp == nil {
+				items := make([]*model.StoreItemUpdate, 0, len(change.Batch))
+				for _, itemChange := range change.Batch {
+					update := &model.StoreItemUpdate{ItemId: itemChange.Id, NewItemValue: itemChange.Value,
+						ItemVersion: itemChange.ItemVersion}
+					if itemChange.IsDeleteChange {
+						update.NewItemValue = nil
+					}
+					if withDiffs {
+						update.Diff = itemChange.Diff()
+					}
+					items = append(items, update)
+				}
+				batchResp = model.NewUpdateStoreBatchResponse(storeName, items, change.StoreVersion)
+				batchResps[withDiffs] = batchResp
+			}
+			bus.SendResponseMessage(chName, batchResp, nil)
+			continue
+		}
+		for _, itemChange := range change.Batch {
+			bus.SendResponseMessage(chName, newSyncUpdateStoreResponse(storeName, itemChange, withDiffs), nil)
+		}
+	}
+}
+
+// newSyncUpdateStoreResponse creates the update relayed to sync clients, withDiff adds the diff of the item.
+func newSyncUpdateStoreResponse(storeName string, change *StoreChange, withDiff bool) *model.UpdateStoreResponse {
+	updateStoreResp := model.NewUpdateStoreResponse(storeName, change.Id, change.Value, change.StoreVersion)
+	updateStoreResp.ItemVersion = change.ItemVersion
+	if change.IsDeleteChange {
+		updateStoreResp.NewItemValue = nil
+	}
+	if withDiff {
+		updateStoreResp.Diff = change.Diff()
+	}
+	return updateStoreResp
+}
+
+func (l *syncStoreListener) addChannel(clientChannel string, protocol *syncProtocolState) {
 	l.lock.Lock()
 	defer l.lock.Unlock()
 	l.clientSyncChannels[clientChannel] = true
+	l.clientProtocols[clientChannel] = protocol
 }
 
 func (l *syncStoreListener) removeChannel(clientChannel string) {
 	l.lock.Lock()
 	defer l.lock.Unlock()
 	delete(l.clientSyncChannels, clientChannel)
+	delete(l.clientProtocols, clientChannel)
 }
 
 func (l *syncStoreListener) isEmpty() bool {
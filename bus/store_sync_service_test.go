@@ -512,3 +512,113 @@ func TestStoreSyncService_UpdateStore(t *testing.T) {
 	assert.True(t, strings.HasPrefix(syncResp1[5].(*model.Response).ErrorMessage,
 		"Cannot deserialize UpdateStoreRequest item value:"))
 }
+
+func TestStoreSyncService_ItemDiffs(t *testing.T) {
+	_, bus := testStoreSyncService()
+	store := bus.GetStoreManager().CreateStore("test-store")
+	store.Populate(map[string]interface{}{"item1": map[string]interface{}{"name": "cow", "count": 1}})
+
+	syncChan := "transport-store-sync.1"
+	bus.GetChannelManager().CreateChannel(syncChan)
+	bus.SendMonitorEvent(FabricEndpointSubscribeEvt, syncChan, nil)
+	responses := listenSyncResponses(t, bus, syncChan)
+	id := uuid.New()
+	bus.SendRequestMessage(syncChan, &model.Request{
+		RequestCommand: syncHelloRequest,
+		Payload: map[string]interface{}{
+			"protocolVersion": 2, "minProtocolVersion": 1, "capabilities": []string{SyncCapabilityItemDiffs}},
+		Id: &id,
+	}, nil)
+	assert.IsType(t, &syncHelloReply{}, <-responses)
+	bus.SendRequestMessage(syncChan, &model.Request{
+		RequestCommand: openStoreRequest,
+		Payload:        map[string]interface{}{"storeId": "test-store"},
+	}, nil)
+	assert.IsType(t, &model.StoreContentResponse{}, <-responses)
+
+	oldValue := store.GetValue("item1")
+	newValue := map[string]interface{}{"name": "cow", "count": 2}
+	store.Put("item1", newValue, nil)
+	_, version := store.AllValuesAndVersion()
+
+	expected := model.NewUpdateStoreResponse("test-store", "item1", newValue, version)
+	expected.ItemVersion = 2
+	expected.Diff = &model.StoreItemDiff{
+		OldValue: oldValue,
+		NewValue: newValue,
+		ChangedFields: []*model.StoreFieldChange{
+			{Path: []string{"count"}, OldValue: float64(1), NewValue: float64(2)},
+		},
+	}
+	assert.Equal(t, expected, <-responses)
+
+	store.Remove("item1", nil)
+	_, version = store.AllValuesAndVersion()
+	expected = model.NewUpdateStoreResponse("test-store", "item1", nil, version)
+	expected.Diff = &model.StoreItemDiff{OldValue: newValue}
+	assert.Equal(t, expected, <-responses)
+}
+
+func TestStoreSyncService_AccessControl(t *testing.T) {
+	_, bus := testStoreSyncService()
+
+	store := bus.GetStoreManager().CreateStoreWithType("config", reflect.TypeOf(&MockStoreItem{}))
+	store.Populate(map[string]interface{}{"item1": &MockStoreItem{From: "backend", Message: "config"}})
+	accessControl := NewStoreAccessControl()
+	accessControl.SetRule("config", &StoreAccessRule{
+		ReadPrincipals:  []string{AnyPrincipal},
+		WritePrincipals: []string{"backend-service"},
+	})
+	bus.GetStoreManager().SetAccessControl(accessControl)
+
+	syncChan := "transport-store-sync.1"
+	bus.GetChannelManager().CreateChannel(syncChan)
+	bus.SendMonitorEvent(FabricEndpointSubscribeEvt, syncChan, nil)
+
+	responses := make(chan interface{}, 10)
+	mh, _ := bus.ListenStream(syncChan)
+	mh.Handle(func(message *model.Message) {
+		responses <- message.Payload
+	}, func(e error) {
+		assert.Fail(t, "Unexpected error")
+	})
+
+	// anyone may read the store
+	bus.SendRequestMessage(syncChan, &model.Request{
+		RequestCommand: openStoreRequest,
+		Payload:        map[string]interface{}{"storeId": "config"},
+		Principal:      "browser-user",
+	}, nil)
+	_, ok := (<-responses).(*model.StoreContentResponse)
+	assert.True(t, ok)
+
+	// but only the backend service may write it
+	id := uuid.New()
+	bus.SendRequestMessage(syncChan, &model.Request{
+		Id:             &id,
+		RequestCommand: updateStoreRequest,
+		Payload: map[string]interface{}{
+			"storeId":      "config",
+			"itemId":       "item1",
+			"newItemValue": map[string]interface{}{"from": "browser", "message": "hijacked"},
+		},
+		Principal: "browser-user",
+	}, nil)
+	rsp := (<-responses).(*model.Response)
+	assert.True(t, rsp.Error)
+	assert.Equal(t, "Access denied, cannot update store: config", rsp.ErrorMessage)
+	assert.Equal(t, "config", store.GetValue("item1").(*MockStoreItem).Message)
+
+	bus.SendRequestMessage(syncChan, &model.Request{
+		RequestCommand: updateStoreRequest,
+		Payload: map[string]interface{}{
+			"storeId":      "config",
+			"itemId":       "item1",
+			"newItemValue": map[string]interface{}{"from": "backend", "message": "updated"},
+		},
+		Principal: "backend-service",
+	}, nil)
+	_, ok = (<-responses).(*model.UpdateStoreResponse)
+	assert.True(t, ok)
+	assert.Equal(t, "updated", store.GetValue("item1").(*MockStoreItem).Message)
+}
@@ -7,11 +7,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/pb33f/ranch/clock"
 	"github.com/stretchr/testify/assert"
 	"reflect"
 	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 )
 
 type testItem struct {
@@ -246,6 +248,43 @@ func TestBusStore_OnChangeErrorHandling(t *testing.T) {
 	assert.EqualError(t, e, "invalid StoreChangeHandlerFunction")
 }
 
+func TestBusStore_PutWithExpiry(t *testing.T) {
+	fake := clock.NewFakeClock(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	clock.Set(fake)
+	defer clock.Reset()
+
+	store := testStore()
+	expired := make(chan *StoreChange, 3)
+	store.OnAllChanges(StoreItemExpiredState).Subscribe(func(change *StoreChange) {
+		expired <- change
+	})
+
+	store.PutWithExpiry("id1", "item1", "ITEM_ADDED", 5*time.Second)
+	store.PutWithExpiry("id2", "item2", "ITEM_ADDED", 20*time.Second)
+	store.PutWithExpiry("id3", "item3", "ITEM_ADDED", 5*time.Second)
+	store.Put("id3", "item3", "ITEM_UPDATED")
+	fake.BlockUntil(1)
+
+	fake.Advance(6 * time.Second)
+	change := <-expired
+	assert.Equal(t, "id1", change.Id)
+	assert.Equal(t, "item1", change.Value)
+	assert.True(t, change.IsDeleteChange)
+	_, ok := store.Get("id1")
+	assert.False(t, ok)
+	assert.Equal(t, "item2", store.GetValue("id2"))
+
+	fake.Advance(15 * time.Second)
+	assert.Equal(t, "id2", (<-expired).Id)
+	assert.Equal(t, map[string]interface{}{"id3": "item3"}, store.AllValuesAsMap())
+
+	// the sweeper stops once nothing is left to expire.
+	assert.Eventually(t, func() bool {
+		return fake.Waiters() == 0
+	}, time.Second, time.Millisecond)
+	assert.Empty(t, expired)
+}
+
 func TestBusStore_OnAllChanges(t *testing.T) {
 	store := testStore()
 
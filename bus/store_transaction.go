@@ -0,0 +1,171 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package bus
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/pb33f/ranch/log"
+)
+
+// StoreTx collects puts and removals of store items and applies them at once on Commit. Subscribers see a
+// single StoreChange carrying the item changes in its Batch, and fabric clients that announced the
+// store-transactions capability receive a single update, so nobody observes some of the changes without
+// the others. Stores subscribed to by item id see the changes of that item only.
+type StoreTx interface {
+	// Put adds or updates an item when the transaction is committed.
+	Put(id string, value interface{})
+	// Delete removes an item when the transaction is committed, nothing happens if it does not exist by then.
+	Delete(id string)
+	// Commit applies every mutation at once, as a single store version. Nothing is applied if the store
+	// validator rejects one of the items. Galactic stores do not support transactions.
+	Commit() error
+	// Rollback discards the mutations.
+	Rollback()
+}
+
+// storeTxOp is a single mutation of a store transaction.
+type storeTxOp struct {
+	id     string
+	value  interface{}
+	delete bool
+}
+
+type storeTx struct {
+	store *busStore
+	state interface{}
+	ops   []*storeTxOp
+	done  bool
+	lock  sync.Mutex
+}
+
+func (store *busStore) BeginTx(state interface{}) StoreTx {
+	return &storeTx{store: store, state: state}
+}
+
+func (tx *storeTx) Put(id string, value interface{}) {
+	tx.add(&storeTxOp{id: id, value: value})
+}
+
+func (tx *storeTx) Delete(id string) {
+	tx.add(&storeTxOp{id: id, delete: true})
+}
+
+func (tx *storeTx) add(op *storeTxOp) {
+	tx.lock.Lock()
+	defer tx.lock.Unlock()
+	if !tx.done {
+		tx.ops = append(tx.ops, op)
+	}
+}
+
+func (tx *storeTx) Commit() error {
+	tx.lock.Lock()
+	defer tx.lock.Unlock()
+	if tx.done {
+		return fmt.Errorf("store transaction already committed or rolled back")
+	}
+	tx.done = true
+
+	store := tx.store
+	if store.IsGalactic() {
+		return fmt.Errorf("transactions are not supported for galactic stores")
+	}
+	for _, op := range tx.ops {
+		if !op.delete {
+			if err := store.validate(op.id, op.value); err != nil {
+				return err
+			}
+		}
+	}
+	store.itemsLock.Lock()
+	defer store.itemsLock.Unlock()
+
+	store.storeVersion++
+	change := store.applyBatch(tx.ops, tx.state)
+	if change == nil {
+		// nothing changed after all.
+		store.storeVersion--
+		return nil
+	}
+	if store.persistence != nil {
+		ids := make([]string, len(change.Batch))
+		for i, itemChange := range change.Batch {
+			ids[i] = itemChange.Id
+		}
+		store.persistUpdate(ids)
+		// distributed persistence may have handed out a later version.
+		change.StoreVersion = store.storeVersion
+		for _, itemChange := range change.Batch {
+			itemChange.StoreVersion = store.storeVersion
+		}
+	}
+	store.notifyChange(change)
+	return nil
+}
+
+func (tx *storeTx) Rollback() {
+	tx.lock.Lock()
+	defer tx.lock.Unlock()
+	tx.done = true
+	tx.ops = nil
+}
+
+// applyBatch applies the mutations in order, the items lock must be held. Returns the change carrying the
+// item changes, nil if there were none.
+func (store *busStore) applyBatch(ops []*storeTxOp, state interface{}) *StoreChange {
+	var batch []*StoreChange
+	for _, op := range ops {
+		change := &StoreChange{Id: op.id, Value: op.value, State: state, StoreVersion: store.storeVersion}
+		if op.delete {
+			value, ok := store.items[op.id]
+			if !ok {
+				continue
+			}
+			store.deleteItem(op.id)
+			change.Value = value
+			change.OldValue = value
+			change.IsDeleteChange = true
+		} else {
+			change.OldValue = store.items[op.id]
+			store.setItem(op.id, op.value)
+			change.ItemVersion = store.itemVersions[op.id]
+		}
+		delete(store.expiries, op.id)
+		batch = append(batch, change)
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+	return &StoreChange{State: state, StoreVersion: store.storeVersion, Batch: batch}
+}
+
+// applyGalacticBatch applies a batched update received from the owner of a galactic store, the items lock
+// must be held.
+func (store *busStore) applyGalacticBatch(storeResponse map[string]interface{}) {
+	items, _ := storeResponse["items"].([]interface{})
+	ops := make([]*storeTxOp, 0, len(items))
+	for _, raw := range items {
+		item, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		id, _ := item["itemId"].(string)
+		newItemRaw := item["newItemValue"]
+		if newItemRaw == nil {
+			ops = append(ops, &storeTxOp{id: id, delete: true})
+			continue
+		}
+		value, err := store.deserializeRawValue(newItemRaw)
+		if err != nil {
+			log.Warn("failed to deserialize store item value %e", err)
+			return
+		}
+		ops = append(ops, &storeTxOp{id: id, value: value})
+	}
+	if change := store.applyBatch(ops, "galacticSyncBatch"); change != nil {
+		store.notifyChange(change)
+	}
+}
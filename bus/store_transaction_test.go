@@ -0,0 +1,144 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package bus
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/pb33f/ranch/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStoreTx_Commit(t *testing.T) {
+	store := testStore()
+	store.Put("id1", "item1", "ITEM_ADDED")
+	store.Put("id2", "item2", "ITEM_ADDED")
+	_, version := store.AllValuesAndVersion()
+
+	all := make(chan *StoreChange, 5)
+	store.OnAllChanges("TX").Subscribe(func(change *StoreChange) {
+		all <- change
+	})
+	single := make(chan *StoreChange, 5)
+	store.OnChange("id2", "TX").Subscribe(func(change *StoreChange) {
+		single <- change
+	})
+
+	tx := store.BeginTx("TX")
+	tx.Put("id3", "item3")
+	tx.Delete("id2")
+	tx.Put("id1", "item1-updated")
+	tx.Delete("missing")
+	assert.Equal(t, "item2", store.GetValue("id2"))
+	assert.NoError(t, tx.Commit())
+
+	items, newVersion := store.AllValuesAndVersion()
+	assert.Equal(t, map[string]interface{}{"id1": "item1-updated", "id3": "item3"}, items)
+	assert.Equal(t, version+1, newVersion)
+
+	change := <-all
+	assert.Equal(t, newVersion, change.StoreVersion)
+	assert.Equal(t, []*StoreChange{
+		{Id: "id3", Value: "item3", State: "TX", StoreVersion: newVersion, ItemVersion: 3},
+		{Id: "id2", Value: "item2", OldValue: "item2", State: "TX", StoreVersion: newVersion, IsDeleteChange: true},
+		{Id: "id1", Value: "item1-updated", OldValue: "item1", State: "TX", StoreVersion: newVersion, ItemVersion: 4},
+	}, change.Batch)
+	assert.Equal(t, change.Batch[1], <-single)
+	assert.Empty(t, all)
+
+	assert.EqualError(t, tx.Commit(), "store transaction already committed or rolled back")
+
+	tx = store.BeginTx("TX")
+	tx.Put("id4", "item4")
+	tx.Rollback()
+	assert.Error(t, tx.Commit())
+	assert.Nil(t, store.GetValue("id4"))
+
+	// transactions that change nothing leave the store alone.
+	tx = store.BeginTx("TX")
+	tx.Delete("missing")
+	assert.NoError(t, tx.Commit())
+	_, version = store.AllValuesAndVersion()
+	assert.Equal(t, newVersion, version)
+}
+
+func TestStoreTx_GalacticStore(t *testing.T) {
+	store, _, bus := testGalacticStore(reflect.TypeOf(MockStoreItem{}))
+	tx := store.BeginTx("TX")
+	tx.Put("id1", "item1")
+	assert.EqualError(t, tx.Commit(), "transactions are not supported for galactic stores")
+
+	store.Put("id0", MockStoreItem{From: "admin", Message: "value0"}, nil)
+	changes := make(chan *StoreChange, 5)
+	store.OnAllChanges().Subscribe(func(change *StoreChange) {
+		changes <- change
+	})
+	bus.SendResponseMessage("sync-channel", []byte(`{
+        "storeId": "testStore",
+        "responseType": "updateStoreBatchResponse",
+        "items": [
+            {"itemId": "id1", "newItemValue": { "from": "admin", "message": "value1"}},
+            {"itemId": "id2", "newItemValue": { "from": "admin", "message": "value2"}}
+        ],
+        "storeVersion": 12
+    }`), nil)
+
+	change := <-changes
+	assert.Len(t, change.Batch, 2)
+	assert.Equal(t, int64(12), change.StoreVersion)
+	assert.Equal(t, MockStoreItem{From: "admin", Message: "value1"}, store.GetValue("id1"))
+	assert.Equal(t, MockStoreItem{From: "admin", Message: "value2"}, store.GetValue("id2"))
+}
+
+func TestStoreSyncService_Transactions(t *testing.T) {
+	_, bus := testStoreSyncService()
+	store := bus.GetStoreManager().CreateStore("test-store")
+	store.Populate(map[string]interface{}{"item1": "value1"})
+
+	openSyncChannel := func(syncChan string, capabilities []string) chan interface{} {
+		bus.GetChannelManager().CreateChannel(syncChan)
+		bus.SendMonitorEvent(FabricEndpointSubscribeEvt, syncChan, nil)
+		responses := listenSyncResponses(t, bus, syncChan)
+		if capabilities != nil {
+			id := uuid.New()
+			bus.SendRequestMessage(syncChan, &model.Request{
+				RequestCommand: syncHelloRequest,
+				Payload: map[string]interface{}{
+					"protocolVersion": 2, "minProtocolVersion": 1, "capabilities": capabilities},
+				Id: &id,
+			}, nil)
+			assert.IsType(t, &syncHelloReply{}, <-responses)
+		}
+		bus.SendRequestMessage(syncChan, &model.Request{
+			RequestCommand: openStoreRequest,
+			Payload:        map[string]interface{}{"storeId": "test-store"},
+		}, nil)
+		assert.IsType(t, &model.StoreContentResponse{}, <-responses)
+		return responses
+	}
+	batched := openSyncChannel("transport-store-sync.1", []string{SyncCapabilityStoreSync, SyncCapabilityTransactions})
+	legacy := openSyncChannel("transport-store-sync.2", nil)
+
+	tx := store.BeginTx(nil)
+	tx.Put("item2", "value2")
+	tx.Delete("item1")
+	assert.NoError(t, tx.Commit())
+	_, version := store.AllValuesAndVersion()
+
+	assert.Equal(t, model.NewUpdateStoreBatchResponse("test-store", []*model.StoreItemUpdate{
+		{ItemId: "item2", NewItemValue: "value2", ItemVersion: 2},
+		{ItemId: "item1"},
+	}, version), <-batched)
+
+	// clients that do not support transactions get the changes one by one.
+	added := model.NewUpdateStoreResponse("test-store", "item2", "value2", version)
+	added.ItemVersion = 2
+	assert.ElementsMatch(t, []interface{}{
+		added,
+		model.NewUpdateStoreResponse("test-store", "item1", nil, version),
+	}, []interface{}{<-legacy, <-legacy})
+	assert.Empty(t, batched)
+}
@@ -0,0 +1,95 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package bus
+
+import (
+	"fmt"
+)
+
+// StoreVersionConflictError is returned by PutIfVersion when the item was changed since the caller read it.
+// Callers read the item again with GetWithVersion and retry their update.
+type StoreVersionConflictError struct {
+	StoreName       string
+	Id              string
+	ExpectedVersion int64 // the version the caller read
+	ActualVersion   int64 // the version of the item in the store, 0 if it does not exist
+}
+
+func (e *StoreVersionConflictError) Error() string {
+	if e.ActualVersion == 0 {
+		return fmt.Sprintf("version conflict on item '%s' of store '%s': expected version %d, item does not exist",
+			e.Id, e.StoreName, e.ExpectedVersion)
+	}
+	return fmt.Sprintf("version conflict on item '%s' of store '%s': expected version %d, found version %d",
+		e.Id, e.StoreName, e.ExpectedVersion, e.ActualVersion)
+}
+
+// GetWithVersion returns an item, its version and whether it exists. Every put gives the item a version
+// higher than any version handed out by the store before, including to items that were removed since or
+// dropped by Reset, so a version read before an item was removed and added again never matches. Versions
+// are not consecutive, items that do not exist have version 0. Versions are kept in memory by each instance, they are not synced to galactic stores or shared between instances
+// using distributed persistence.
+func (store *busStore) GetWithVersion(id string) (interface{}, int64, bool) {
+	store.itemsLock.RLock()
+	defer store.itemsLock.RUnlock()
+
+	val, ok := store.items[id]
+	return val, store.itemVersions[id], ok
+}
+
+// PutIfVersion adds or updates an item like Put does, provided its version is still expectedVersion.
+// Pass 0 to add an item that must not exist yet. Returns a *StoreVersionConflictError without changing
+// the store if the item was changed in the meantime, or a *ValidationError if the store validator rejects
+// the item. Galactic stores do not support it.
+func (store *busStore) PutIfVersion(id string, value interface{}, state interface{}, expectedVersion int64) error {
+	if store.IsGalactic() {
+		return fmt.Errorf("versioned puts are not supported for galactic stores")
+	}
+	if err := store.validate(id, value); err != nil {
+		return err
+	}
+	store.itemsLock.Lock()
+	defer store.itemsLock.Unlock()
+
+	if actual := store.itemVersions[id]; actual != expectedVersion {
+		return &StoreVersionConflictError{
+			StoreName:       store.name,
+			Id:              id,
+			ExpectedVersion: expectedVersion,
+			ActualVersion:   actual,
+		}
+	}
+	store.putInternal(id, value, state)
+	return nil
+}
+
+// AllValuesAndItemVersions returns the items with their versions and the store version, all read at once.
+func (store *busStore) AllValuesAndItemVersions() (map[string]interface{}, map[string]int64, int64) {
+	store.itemsLock.RLock()
+	defer store.itemsLock.RUnlock()
+
+	values := make(map[string]interface{}, len(store.items))
+	versions := make(map[string]int64, len(store.items))
+	for key, value := range store.items {
+		values[key] = value
+		versions[key] = store.itemVersions[key]
+	}
+	return values, versions, store.storeVersion
+}
+
+// syncItemVersions gives items that were set without setItem a new version and forgets the versions of
+// items that are gone, the items lock must be held.
+func (store *busStore) syncItemVersions() {
+	for id := range store.itemVersions {
+		if _, ok := store.items[id]; !ok {
+			delete(store.itemVersions, id)
+		}
+	}
+	for id := range store.items {
+		if store.itemVersions[id] == 0 {
+			store.itemVersionClock++
+			store.itemVersions[id] = store.itemVersionClock
+		}
+	}
+}
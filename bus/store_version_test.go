@@ -0,0 +1,115 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package bus
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/pb33f/ranch/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBusStore_PutIfVersion(t *testing.T) {
+	store := testStore()
+
+	_, version, ok := store.GetWithVersion("id1")
+	assert.False(t, ok)
+	assert.Equal(t, int64(0), version)
+
+	assert.NoError(t, store.PutIfVersion("id1", "value1", "ADD", 0))
+	value, version, ok := store.GetWithVersion("id1")
+	assert.True(t, ok)
+	assert.Equal(t, "value1", value)
+	assert.Equal(t, int64(1), version)
+
+	store.Put("id1", "value2", "UPDATE")
+	err := store.PutIfVersion("id1", "value3", "UPDATE", version)
+	var conflict *StoreVersionConflictError
+	assert.True(t, errors.As(err, &conflict))
+	assert.Equal(t, int64(2), conflict.ActualVersion)
+	assert.EqualError(t, err,
+		"version conflict on item 'id1' of store 'testStore': expected version 1, found version 2")
+	assert.Equal(t, "value2", store.GetValue("id1"))
+
+	changes := make(chan *StoreChange, 1)
+	store.OnChange("id1").Subscribe(func(change *StoreChange) {
+		changes <- change
+	})
+	assert.NoError(t, store.PutIfVersion("id1", "value3", "UPDATE", 2))
+	assert.Equal(t, int64(3), (<-changes).ItemVersion)
+
+	// a removed item that is added again never gets back a version read before the removal.
+	store.Remove("id1", "REMOVE")
+	assert.Equal(t, int64(0), (<-changes).ItemVersion)
+	assert.EqualError(t, store.PutIfVersion("id1", "value4", "UPDATE", 3),
+		"version conflict on item 'id1' of store 'testStore': expected version 3, item does not exist")
+	assert.NoError(t, store.PutIfVersion("id1", "value4", "ADD", 0))
+	<-changes
+	_, version, _ = store.GetWithVersion("id1")
+	assert.Equal(t, int64(4), version)
+}
+
+func TestBusStore_ItemVersionsAfterPopulate(t *testing.T) {
+	store := newBusStore("testStore", newTestEventBus(), nil, nil)
+	assert.NoError(t, store.Populate(map[string]interface{}{"id1": "value1", "id2": "value2"}))
+	store.Put("id2", "value2-updated", nil)
+
+	items, versions, storeVersion := store.AllValuesAndItemVersions()
+	assert.Equal(t, map[string]interface{}{"id1": "value1", "id2": "value2-updated"}, items)
+	assert.Contains(t, []int64{1, 2}, versions["id1"])
+	assert.Equal(t, int64(3), versions["id2"])
+	assert.Equal(t, int64(2), storeVersion)
+
+	// versions keep increasing after a reset.
+	store.Reset()
+	_, versions, _ = store.AllValuesAndItemVersions()
+	assert.Empty(t, versions)
+	assert.NoError(t, store.Populate(map[string]interface{}{"id1": "value1"}))
+	_, versions, _ = store.AllValuesAndItemVersions()
+	assert.Equal(t, map[string]int64{"id1": 4}, versions)
+}
+
+func TestStoreSyncService_UpdateStoreIfVersion(t *testing.T) {
+	_, bus := testStoreSyncService()
+	store := bus.GetStoreManager().CreateStore("test-store")
+	store.Populate(map[string]interface{}{"item1": "value1"})
+
+	syncChan := "transport-store-sync.1"
+	bus.GetChannelManager().CreateChannel(syncChan)
+	bus.SendMonitorEvent(FabricEndpointSubscribeEvt, syncChan, nil)
+	responses := listenSyncResponses(t, bus, syncChan)
+
+	bus.SendRequestMessage(syncChan, &model.Request{
+		RequestCommand: openStoreRequest,
+		Payload:        map[string]interface{}{"storeId": "test-store"},
+	}, nil)
+	content := (<-responses).(*model.StoreContentResponse)
+	assert.Equal(t, map[string]int64{"item1": 1}, content.ItemVersions)
+
+	bus.SendRequestMessage(syncChan, &model.Request{
+		RequestCommand: updateStoreRequest,
+		Payload: map[string]interface{}{
+			"storeId": "test-store", "itemId": "item1", "newItemValue": "value2", "expectedItemVersion": float64(1)},
+	}, nil)
+	update := (<-responses).(*model.UpdateStoreResponse)
+	assert.Equal(t, "value2", update.NewItemValue)
+	assert.Equal(t, int64(2), update.ItemVersion)
+
+	// a client that read the item before the update is told about the conflict, and the item is kept.
+	id := uuid.New()
+	bus.SendRequestMessage(syncChan, &model.Request{
+		RequestCommand: updateStoreRequest,
+		Payload: map[string]interface{}{
+			"storeId": "test-store", "itemId": "item1", "newItemValue": "value3", "expectedItemVersion": float64(1)},
+		Id: &id,
+	}, nil)
+	resp := (<-responses).(*model.Response)
+	assert.True(t, resp.Error)
+	assert.Equal(t, &id, resp.Id)
+	assert.Equal(t, "Cannot update store item: version conflict on item 'item1' of store 'test-store': "+
+		"expected version 1, found version 2", resp.ErrorMessage)
+	assert.Equal(t, "value2", store.GetValue("item1"))
+}
@@ -0,0 +1,341 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package bus
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// StoreView is the live result of a store query, kept up to date as the store changes until it is closed.
+// Subscribers see items entering the view or changing while in it, and items leaving it, because they
+// were removed or no longer match, as delete changes.
+type StoreView interface {
+	StoreStream
+	// Items returns the items currently in the view.
+	Items() map[string]interface{}
+	// Ids returns the ids of the items currently in the view, in the key order of the first predicate.
+	Ids() []string
+	// Len returns the number of items currently in the view.
+	Len() int
+	// Close stops updating the view and unsubscribes its subscriber.
+	Close()
+}
+
+type storeView struct {
+	store      *busStore
+	predicates []QueryPredicate
+	members    map[string]interface{} // items in the view, guarded by the items lock of the store
+	lock       sync.RWMutex
+	handler    StoreChangeHandlerFunction
+}
+
+// CreateIndex indexes the items of the store under the keys returned by keyFn, so they can be queried by
+// that key. Returns an error if an index with the name exists.
+func (store *busStore) CreateIndex(name string, keyFn IndexKeyFunction) error {
+	if keyFn == nil {
+		return fmt.Errorf("invalid IndexKeyFunction")
+	}
+	store.itemsLock.Lock()
+	defer store.itemsLock.Unlock()
+	if _, ok := store.indexes[name]; ok {
+		return fmt.Errorf("index '%s' already exists in store '%s'", name, store.name)
+	}
+	if store.indexes == nil {
+		store.indexes = make(map[string]*storeIndex)
+	}
+	idx := newStoreIndex(keyFn)
+	idx.rebuild(store.items)
+	store.indexes[name] = idx
+	return nil
+}
+
+// DropIndex removes an index, views querying it are closed.
+func (store *busStore) DropIndex(name string) {
+	store.itemsLock.Lock()
+	defer store.itemsLock.Unlock()
+	delete(store.indexes, name)
+	views := store.views[:0]
+	for _, view := range store.views {
+		if view.queries(name) {
+			view.unsubscribe()
+			continue
+		}
+		views = append(views, view)
+	}
+	store.views = views
+}
+
+// Query returns a view of the items matching every predicate, kept up to date until it is closed.
+func (store *busStore) Query(predicates ...QueryPredicate) (StoreView, error) {
+	store.itemsLock.Lock()
+	defer store.itemsLock.Unlock()
+	normalized, err := store.normalizePredicates(predicates)
+	if err != nil {
+		return nil, err
+	}
+	view := &storeView{store: store, predicates: normalized, members: make(map[string]interface{})}
+	for _, id := range store.search(normalized) {
+		view.members[id] = store.items[id]
+	}
+	store.views = append(store.views, view)
+	return view, nil
+}
+
+// QueryValues returns the items matching every predicate, in the key order of the first predicate.
+func (store *busStore) QueryValues(predicates ...QueryPredicate) ([]interface{}, error) {
+	store.itemsLock.RLock()
+	defer store.itemsLock.RUnlock()
+	normalized, err := store.normalizePredicates(predicates)
+	if err != nil {
+		return nil, err
+	}
+	ids := store.search(normalized)
+	values := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		values = append(values, store.items[id])
+	}
+	return values, nil
+}
+
+// normalizePredicates checks the predicates query existing indexes, the items lock must be held.
+func (store *busStore) normalizePredicates(predicates []QueryPredicate) ([]QueryPredicate, error) {
+	if len(predicates) == 0 {
+		return nil, fmt.Errorf("a store query needs at least one predicate")
+	}
+	normalized := make([]QueryPredicate, 0, len(predicates))
+	for _, p := range predicates {
+		if _, ok := store.indexes[p.Index]; !ok {
+			return nil, fmt.Errorf("index '%s' does not exist in store '%s'", p.Index, store.name)
+		}
+		n, err := p.normalized()
+		if err != nil {
+			return nil, err
+		}
+		normalized = append(normalized, n)
+	}
+	return normalized, nil
+}
+
+// search returns the ids of the items matching every predicate in the key order of the first one, the
+// items lock must be held.
+func (store *busStore) search(predicates []QueryPredicate) []string {
+	candidates := store.indexes[predicates[0].Index].search(&predicates[0])
+	ids := candidates[:0]
+	for _, id := range candidates {
+		if store.matchesOthers(id, predicates[1:]) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func (store *busStore) matchesOthers(id string, predicates []QueryPredicate) bool {
+	for i := range predicates {
+		key, ok := store.indexes[predicates[i].Index].keys[id]
+		if !ok || !predicates[i].matches(key) {
+			return false
+		}
+	}
+	return true
+}
+
+// setItem adds or replaces an item, bumps its version and indexes it, the items lock must be held.
+func (store *busStore) setItem(id string, value interface{}) {
+	store.items[id] = value
+	store.itemVersionClock++
+	store.itemVersions[id] = store.itemVersionClock
+	for _, idx := range store.indexes {
+		idx.remove(id)
+		idx.add(id, value)
+	}
+}
+
+// deleteItem removes an item from the store and its indexes, the items lock must be held.
+func (store *busStore) deleteItem(id string) {
+	delete(store.items, id)
+	delete(store.itemVersions, id)
+	for _, idx := range store.indexes {
+		idx.remove(id)
+	}
+}
+
+// reindex rebuilds the item versions, indexes and views after the items were replaced at once, the items
+// lock must be held.
+func (store *busStore) reindex() {
+	store.syncItemVersions()
+	for _, idx := range store.indexes {
+		idx.rebuild(store.items)
+	}
+	for _, view := range store.views {
+		view.refreshAll()
+	}
+}
+
+// notifyChange updates the views with the change and sends it to the store streams, the items lock must
+// be held.
+func (store *busStore) notifyChange(change *StoreChange) {
+	if len(store.views) > 0 {
+		changes := []*StoreChange{change}
+		if len(change.Batch) > 0 {
+			changes = change.Batch
+		}
+		seen := make(map[string]bool, len(changes))
+		for _, itemChange := range changes {
+			if seen[itemChange.Id] {
+				continue
+			}
+			seen[itemChange.Id] = true
+			for _, view := range store.views {
+				view.refresh(itemChange.Id, change.State, change.StoreVersion)
+			}
+		}
+	}
+	go store.onStoreChange(change)
+}
+
+func (v *storeView) queries(index string) bool {
+	for _, p := range v.predicates {
+		if p.Index == index {
+			return true
+		}
+	}
+	return false
+}
+
+// refresh works out whether an item that changed is in the view, the items lock must be held.
+func (v *storeView) refresh(id string, state interface{}, storeVersion int64) {
+	oldValue, wasMember := v.members[id]
+	value, exists := v.store.items[id]
+	isMember := exists && v.store.matchesOthers(id, v.predicates)
+	change := &StoreChange{Id: id, Value: value, State: state, StoreVersion: storeVersion}
+	switch {
+	case isMember:
+		v.members[id] = value
+		if wasMember {
+			change.OldValue = oldValue
+		}
+	case wasMember:
+		delete(v.members, id)
+		change.Value = oldValue
+		change.OldValue = oldValue
+		change.IsDeleteChange = true
+	default:
+		return
+	}
+	v.send(change)
+}
+
+// refreshAll compares the view with the items of the store, the items lock must be held.
+func (v *storeView) refreshAll() {
+	matching := make(map[string]bool)
+	for _, id := range v.store.search(v.predicates) {
+		matching[id] = true
+		value := v.store.items[id]
+		oldValue, wasMember := v.members[id]
+		if wasMember && reflect.DeepEqual(oldValue, value) {
+			continue
+		}
+		v.members[id] = value
+		change := &StoreChange{Id: id, Value: value, StoreVersion: v.store.storeVersion}
+		if wasMember {
+			change.OldValue = oldValue
+		}
+		v.send(change)
+	}
+	for id, oldValue := range v.members {
+		if !matching[id] {
+			delete(v.members, id)
+			v.send(&StoreChange{Id: id, Value: oldValue, OldValue: oldValue, IsDeleteChange: true,
+				StoreVersion: v.store.storeVersion})
+		}
+	}
+}
+
+func (v *storeView) send(change *StoreChange) {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+	if v.handler != nil {
+		go v.handler(change)
+	}
+}
+
+func (v *storeView) Subscribe(handler StoreChangeHandlerFunction) error {
+	if handler == nil {
+		return fmt.Errorf("invalid StoreChangeHandlerFunction")
+	}
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if v.handler != nil {
+		return fmt.Errorf("stream already subscribed")
+	}
+	v.handler = handler
+	return nil
+}
+
+func (v *storeView) Unsubscribe() error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if v.handler == nil {
+		return fmt.Errorf("stream not subscribed")
+	}
+	v.handler = nil
+	return nil
+}
+
+func (v *storeView) unsubscribe() {
+	v.lock.Lock()
+	v.handler = nil
+	v.lock.Unlock()
+}
+
+func (v *storeView) Items() map[string]interface{} {
+	v.store.itemsLock.RLock()
+	defer v.store.itemsLock.RUnlock()
+	items := make(map[string]interface{}, len(v.members))
+	for id, value := range v.members {
+		items[id] = value
+	}
+	return items
+}
+
+func (v *storeView) Ids() []string {
+	v.store.itemsLock.RLock()
+	defer v.store.itemsLock.RUnlock()
+	ids := make([]string, 0, len(v.members))
+	for id := range v.members {
+		ids = append(ids, id)
+	}
+	var keys map[string]interface{}
+	if idx := v.store.indexes[v.predicates[0].Index]; idx != nil {
+		keys = idx.keys
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if c := compareIndexKeys(keys[ids[i]], keys[ids[j]]); c != 0 {
+			return c < 0
+		}
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
+func (v *storeView) Len() int {
+	v.store.itemsLock.RLock()
+	defer v.store.itemsLock.RUnlock()
+	return len(v.members)
+}
+
+func (v *storeView) Close() {
+	v.store.itemsLock.Lock()
+	defer v.store.itemsLock.Unlock()
+	for i, view := range v.store.views {
+		if view == v {
+			v.store.views = append(v.store.views[:i], v.store.views[i+1:]...)
+			break
+		}
+	}
+	v.unsubscribe()
+}
@@ -0,0 +1,187 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package bus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type indexTestOwner struct {
+	Name string `json:"name"`
+}
+
+type indexTestItem struct {
+	Name  string          `json:"name"`
+	Age   int             `json:"age"`
+	Owner *indexTestOwner `json:"owner,omitempty"`
+}
+
+func TestFieldIndexKey(t *testing.T) {
+	item := &indexTestItem{Name: "daisy", Age: 3, Owner: &indexTestOwner{Name: "dave"}}
+	tests := []struct {
+		path  string
+		value interface{}
+		key   interface{}
+		ok    bool
+	}{
+		{path: "age", value: item, key: 3, ok: true},
+		{path: "Name", value: *item, key: "daisy", ok: true},
+		{path: "owner.name", value: item, key: "dave", ok: true},
+		{path: "owner.name", value: &indexTestItem{}},
+		{path: "missing", value: item},
+		{path: "herd.size", value: map[string]interface{}{"herd": map[string]interface{}{"size": 10.0}}, key: 10.0, ok: true},
+		{path: "age", value: "moo"},
+		{path: "age", value: nil},
+	}
+	for _, test := range tests {
+		key, ok := FieldIndexKey(test.path)(test.value)
+		assert.Equal(t, test.ok, ok, test.path)
+		assert.Equal(t, test.key, key, test.path)
+	}
+}
+
+func TestCompareIndexKeys(t *testing.T) {
+	key := func(v interface{}) interface{} {
+		k, ok := normalizeIndexKey(v)
+		assert.True(t, ok)
+		return k
+	}
+	assert.Equal(t, 0, compareIndexKeys(key(int8(2)), key(2.0)))
+	assert.Equal(t, -1, compareIndexKeys(key(uint(1)), key(1.5)))
+	assert.Equal(t, 1, compareIndexKeys(key("b"), key("a")))
+	assert.True(t, compareIndexKeys(key(true), key(0)) < 0)
+	assert.True(t, compareIndexKeys(key(10), key("1")) < 0)
+	assert.True(t, compareIndexKeys(key("z"), key(time.Now())) < 0)
+	assert.Equal(t, -1, compareIndexKeys(key(time.Unix(1, 0)), key(time.Unix(2, 0))))
+
+	_, ok := normalizeIndexKey([]string{"moo"})
+	assert.False(t, ok)
+}
+
+func indexTestStore(t *testing.T) BusStore {
+	store := testStore()
+	assert.NoError(t, store.Populate(map[string]interface{}{
+		"1": &indexTestItem{Name: "daisy", Age: 3},
+		"2": &indexTestItem{Name: "bessie", Age: 7},
+		"3": &indexTestItem{Name: "clarabelle", Age: 5},
+		"4": &indexTestItem{Name: "daisy", Age: 9},
+		"5": "not a cow",
+	}))
+	assert.NoError(t, store.CreateIndex("age", FieldIndexKey("age")))
+	assert.NoError(t, store.CreateIndex("name", FieldIndexKey("name")))
+	return store
+}
+
+func TestBusStore_QueryValues(t *testing.T) {
+	store := indexTestStore(t)
+	names := func(predicates ...QueryPredicate) []string {
+		values, err := store.QueryValues(predicates...)
+		assert.NoError(t, err)
+		var names []string
+		for _, v := range values {
+			names = append(names, v.(*indexTestItem).Name)
+		}
+		return names
+	}
+
+	assert.Equal(t, []string{"daisy", "clarabelle", "bessie"}, names(WhereBetween("age", 3, 7.0)))
+	assert.Equal(t, []string{"clarabelle", "bessie", "daisy"}, names(WhereGreaterThan("age", 3)))
+	assert.Equal(t, []string{"daisy", "clarabelle"}, names(WhereLessThan("age", int64(7))))
+	assert.Equal(t, []string{"daisy", "daisy"}, names(WhereEquals("name", "daisy")))
+	assert.Equal(t, []string{"daisy"}, names(WhereEquals("name", "daisy"), WhereGreaterThan("age", 5)))
+	assert.Empty(t, names(WhereEquals("name", "ermintrude")))
+
+	// indexes follow the store.
+	store.Put("6", &indexTestItem{Name: "ermintrude", Age: 4}, nil)
+	store.Put("1", &indexTestItem{Name: "daisy", Age: 12}, nil)
+	store.Remove("3", nil)
+	assert.Equal(t, []string{"ermintrude", "bessie", "daisy", "daisy"}, names(WhereGreaterThan("age", 0)))
+
+	_, err := store.QueryValues(WhereEquals("color", "brown"))
+	assert.EqualError(t, err, "index 'color' does not exist in store 'testStore'")
+	_, err = store.QueryValues(WhereEquals("name", []string{"daisy"}))
+	assert.EqualError(t, err, "cannot query index 'name' for [daisy] ([]string)")
+	_, err = store.QueryValues()
+	assert.EqualError(t, err, "a store query needs at least one predicate")
+	assert.EqualError(t, store.CreateIndex("age", FieldIndexKey("age")),
+		"index 'age' already exists in store 'testStore'")
+}
+
+func TestBusStore_Query(t *testing.T) {
+	store := indexTestStore(t)
+	view, err := store.Query(WhereBetween("age", 4, 8))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"3", "2"}, view.Ids())
+
+	changes := make(chan *StoreChange, 10)
+	assert.NoError(t, view.Subscribe(func(change *StoreChange) {
+		changes <- change
+	}))
+
+	// entering the view.
+	daisy := &indexTestItem{Name: "daisy", Age: 4}
+	store.Put("1", daisy, "aged")
+	assert.Equal(t, &StoreChange{Id: "1", Value: daisy, State: "aged", StoreVersion: 2}, <-changes)
+
+	// changing in the view.
+	bessie := &indexTestItem{Name: "bessie", Age: 8}
+	store.Put("2", bessie, "aged")
+	change := <-changes
+	assert.Equal(t, bessie, change.Value)
+	assert.Equal(t, &indexTestItem{Name: "bessie", Age: 7}, change.OldValue)
+	assert.False(t, change.IsDeleteChange)
+
+	// leaving the view, by no longer matching and by being removed.
+	store.Put("2", &indexTestItem{Name: "bessie", Age: 9}, "aged")
+	change = <-changes
+	assert.True(t, change.IsDeleteChange)
+	assert.Equal(t, bessie, change.Value)
+	store.Remove("3", "sold")
+	change = <-changes
+	assert.True(t, change.IsDeleteChange)
+	assert.Equal(t, "sold", change.State)
+
+	// changes outside the view are not seen.
+	store.Put("7", &indexTestItem{Name: "ermintrude", Age: 20}, nil)
+
+	tx := store.BeginTx("herd")
+	tx.Put("8", &indexTestItem{Name: "buttercup", Age: 6})
+	tx.Put("8", &indexTestItem{Name: "buttercup", Age: 7})
+	assert.NoError(t, tx.Commit())
+	change = <-changes
+	assert.Equal(t, "8", change.Id)
+	assert.Equal(t, 7, change.Value.(*indexTestItem).Age)
+
+	assert.Equal(t, []string{"1", "8"}, view.Ids())
+	assert.Equal(t, 2, view.Len())
+	assert.Equal(t, map[string]interface{}{"1": daisy, "8": store.GetValue("8")}, view.Items())
+	assert.Empty(t, changes)
+
+	// items replaced at once.
+	store.Reset()
+	assert.ElementsMatch(t, []string{"1", "8"}, []string{(<-changes).Id, (<-changes).Id})
+	assert.Zero(t, view.Len())
+
+	view.Close()
+	store.Put("1", daisy, nil)
+	assert.Zero(t, view.Len())
+	assert.Error(t, view.Unsubscribe())
+}
+
+func TestBusStore_DropIndex(t *testing.T) {
+	store := indexTestStore(t)
+	view, err := store.Query(WhereEquals("name", "daisy"), WhereGreaterThan("age", 0))
+	assert.NoError(t, err)
+	assert.NoError(t, view.Subscribe(func(change *StoreChange) {}))
+
+	store.DropIndex("age")
+	assert.Error(t, view.Unsubscribe())
+	store.Put("9", &indexTestItem{Name: "daisy", Age: 1}, nil)
+	assert.Equal(t, []string{"1", "4"}, view.Ids())
+	_, err = store.QueryValues(WhereEquals("age", 3))
+	assert.Error(t, err)
+}
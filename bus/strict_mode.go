@@ -0,0 +1,150 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package bus
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+	"github.com/pb33f/ranch/log"
+	"github.com/pb33f/ranch/model"
+)
+
+type StrictModeViolationType int
+
+const (
+	UnhandledRequest      StrictModeViolationType = iota // a request was sent to a channel with no request listeners
+	UnexpectedResponse                                   // a response was sent to a destination no one is waiting on
+	ChannelDestroyedInUse                                // a channel was destroyed while handlers were still listening
+)
+
+// StrictModeViolation describes a wiring bug caught by strict mode.
+type StrictModeViolation struct {
+	Type     StrictModeViolationType
+	Channel  string         // the name of the channel
+	Message  *model.Message // the unhandled request or unexpected response, nil for destroyed channels
+	Handlers int            // the number of handlers left on a destroyed channel
+}
+
+func (v *StrictModeViolation) Error() string {
+	switch v.Type {
+	case UnhandledRequest:
+		return fmt.Sprintf("strict mode: request sent to channel '%s' has no listeners", v.Channel)
+	case UnexpectedResponse:
+		return fmt.Sprintf("strict mode: response sent to channel '%s' for destination %s has no outstanding request",
+			v.Channel, v.Message.DestinationId)
+	default:
+		return fmt.Sprintf("strict mode: channel '%s' destroyed with %d active handlers", v.Channel, v.Handlers)
+	}
+}
+
+// StrictModeHandler is called with every violation found in strict mode. Tests can fail on violations
+// by passing a handler that calls t.Error.
+type StrictModeHandler func(violation *StrictModeViolation)
+
+// EnableStrictMode makes the bus report common wiring bugs to the handler: requests sent to a channel
+// with no request listeners, responses sent to a destination with no outstanding request and channels
+// destroyed while handlers are still listening. Violations are logged as warnings if handler is nil.
+// Requests sent to galactic channels are handled by the broker and never reported.
+func (bus *transportEventBus) EnableStrictMode(handler StrictModeHandler) {
+	if handler == nil {
+		handler = func(violation *StrictModeViolation) {
+			log.Warn(violation.Error())
+		}
+	}
+	bus.strictLock.Lock()
+	defer bus.strictLock.Unlock()
+	bus.strictHandler = handler
+}
+
+// DisableStrictMode stops reporting violations.
+func (bus *transportEventBus) DisableStrictMode() {
+	bus.strictLock.Lock()
+	defer bus.strictLock.Unlock()
+	bus.strictHandler = nil
+}
+
+func (bus *transportEventBus) getStrictHandler() StrictModeHandler {
+	bus.strictLock.RLock()
+	defer bus.strictLock.RUnlock()
+	return bus.strictHandler
+}
+
+// checkStrictMode reports a request no handler of the channel listens for, or a response for a destination
+// no handler is waiting on. Broadcasts and errors are never reported.
+func (bus *transportEventBus) checkStrictMode(channel *Channel, message *model.Message) {
+	handler := bus.getStrictHandler()
+	if handler == nil || channel.IsGalactic() {
+		return
+	}
+	var violationType StrictModeViolationType
+	switch {
+	case message.Direction == model.RequestDir:
+		violationType = UnhandledRequest
+	case message.Direction == model.ResponseDir && message.DestinationId != nil:
+		violationType = UnexpectedResponse
+	default:
+		return
+	}
+	if !channel.hasHandlerFor(message) {
+		handler(&StrictModeViolation{Type: violationType, Channel: channel.Name, Message: message})
+	}
+}
+
+// checkDestroyedChannel reports a channel destroyed while handlers are still listening to it.
+func (bus *transportEventBus) checkDestroyedChannel(channel *Channel) {
+	handler := bus.getStrictHandler()
+	if handler == nil {
+		return
+	}
+	if handlers := channel.countActiveHandlers(); handlers > 0 {
+		handler(&StrictModeViolation{Type: ChannelDestroyedInUse, Channel: channel.Name, Handlers: handlers})
+	}
+}
+
+// setHandlerFilter records the messages a subscribed handler wants, so strict mode can tell if a message
+// will be handled.
+func (channel *Channel) setHandlerFilter(id *uuid.UUID, filter func(*model.Message) bool) {
+	if id == nil {
+		return
+	}
+	channel.channelLock.Lock()
+	defer channel.channelLock.Unlock()
+	for _, handler := range channel.eventHandlers {
+		if handler.uuid.String() == id.String() {
+			handler.filter = filter
+			return
+		}
+	}
+}
+
+// hasHandlerFor returns true if an active handler of the channel wants the message.
+func (channel *Channel) hasHandlerFor(message *model.Message) bool {
+	channel.channelLock.Lock()
+	defer channel.channelLock.Unlock()
+	for _, handler := range channel.eventHandlers {
+		if handler.isActive() && (handler.filter == nil || handler.filter(message)) {
+			return true
+		}
+	}
+	return false
+}
+
+// countActiveHandlers counts the handlers of the channel, leaving out run once handlers that already ran.
+func (channel *Channel) countActiveHandlers() int {
+	channel.channelLock.Lock()
+	defer channel.channelLock.Unlock()
+	count := 0
+	for _, handler := range channel.eventHandlers {
+		if handler.isActive() {
+			count++
+		}
+	}
+	return count
+}
+
+func (handler *channelEventHandler) isActive() bool {
+	return !handler.runOnce || atomic.LoadInt64(&handler.runCount) == 0
+}
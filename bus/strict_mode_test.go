@@ -0,0 +1,119 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package bus
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/pb33f/ranch/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// strictBus creates a bus in strict mode, collecting the violations it reports.
+func strictBus() (EventBus, func() []*StrictModeViolation) {
+	b := newTestEventBus()
+	var lock sync.Mutex
+	var violations []*StrictModeViolation
+	b.EnableStrictMode(func(violation *StrictModeViolation) {
+		lock.Lock()
+		defer lock.Unlock()
+		violations = append(violations, violation)
+	})
+	return b, func() []*StrictModeViolation {
+		lock.Lock()
+		defer lock.Unlock()
+		return violations
+	}
+}
+
+func TestStrictMode_UnhandledRequest(t *testing.T) {
+	b, violations := strictBus()
+	b.GetChannelManager().CreateChannel("strict")
+
+	// a response listener does not handle requests.
+	responses, _ := b.ListenStream("strict")
+	responses.Handle(func(*model.Message) {}, func(error) {})
+
+	assert.NoError(t, b.SendRequestMessage("strict", "hello", nil))
+	assert.Len(t, violations(), 1)
+	assert.Equal(t, UnhandledRequest, violations()[0].Type)
+	assert.Equal(t, "hello", violations()[0].Message.Payload)
+	assert.EqualError(t, violations()[0], "strict mode: request sent to channel 'strict' has no listeners")
+
+	requests, _ := b.ListenRequestStream("strict")
+	requests.Handle(func(*model.Message) {}, func(error) {})
+	b.SendRequestMessage("strict", "hello", nil)
+	b.GetChannelManager().WaitForChannel("strict")
+	assert.Len(t, violations(), 1)
+}
+
+func TestStrictMode_UnexpectedResponse(t *testing.T) {
+	b, violations := strictBus()
+	b.GetChannelManager().CreateChannel("strict")
+
+	requests, _ := b.ListenRequestStream("strict")
+	requests.Handle(func(msg *model.Message) {
+		b.SendResponseMessage("strict", "world", msg.DestinationId)
+	}, func(error) {})
+
+	done := make(chan bool, 1)
+	responses, _ := b.RequestOnce("strict", "hello")
+	responses.Handle(func(*model.Message) { done <- true }, func(error) {})
+	assert.NoError(t, responses.Fire())
+	<-done
+	b.GetChannelManager().WaitForChannel("strict")
+	assert.Empty(t, violations())
+
+	// the request was answered, a second response for the same destination is unexpected.
+	id := responses.GetDestinationId()
+	b.SendResponseMessage("strict", "again", id)
+	assert.Len(t, violations(), 1)
+	assert.Equal(t, UnexpectedResponse, violations()[0].Type)
+	assert.Equal(t, id, violations()[0].Message.DestinationId)
+
+	// broadcasts and errors need no listeners.
+	b.SendBroadcastMessage("strict", "news")
+	b.SendErrorMessage("strict", assert.AnError, id)
+	b.GetChannelManager().WaitForChannel("strict")
+	assert.Len(t, violations(), 1)
+
+	// a stream of every response expects them all.
+	other := uuid.New()
+	stream, _ := b.ListenStream("strict")
+	stream.Handle(func(*model.Message) {}, func(error) {})
+	b.SendResponseMessage("strict", "again", &other)
+	b.GetChannelManager().WaitForChannel("strict")
+	assert.Len(t, violations(), 1)
+}
+
+func TestStrictMode_ChannelDestroyedInUse(t *testing.T) {
+	b, violations := strictBus()
+	cm := b.GetChannelManager()
+	cm.CreateChannel("empty")
+	cm.DestroyChannel("empty")
+	assert.Empty(t, violations())
+
+	cm.CreateChannel("strict")
+	handler, _ := b.ListenStream("strict")
+	handler.Handle(func(*model.Message) {}, func(error) {})
+	cm.DestroyChannel("strict")
+	assert.Len(t, violations(), 1)
+	assert.Equal(t, ChannelDestroyedInUse, violations()[0].Type)
+	assert.Equal(t, 1, violations()[0].Handlers)
+	assert.EqualError(t, violations()[0], "strict mode: channel 'strict' destroyed with 1 active handlers")
+}
+
+func TestStrictMode_Disabled(t *testing.T) {
+	b, violations := strictBus()
+	b.DisableStrictMode()
+	b.GetChannelManager().CreateChannel("strict")
+	b.SendRequestMessage("strict", "hello", nil)
+	assert.Empty(t, violations())
+
+	// without a handler, violations are logged.
+	b.EnableStrictMode(nil)
+	assert.NoError(t, b.SendRequestMessage("strict", "hello", nil))
+}
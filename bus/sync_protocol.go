@@ -0,0 +1,228 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package bus
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/pb33f/ranch/log"
+	"github.com/pb33f/ranch/model"
+	"sort"
+	"sync"
+)
+
+// Store sync protocol versions. Version 1 is the protocol spoken before peers negotiated versions, it is
+// assumed for any peer that does not answer the handshake, so old and new instances keep syncing during
+// rolling upgrades.
+const (
+	SyncProtocolVersion    = 2 // newest version spoken by this instance
+	SyncProtocolMinVersion = 1 // oldest version this instance still speaks
+)
+
+// Capabilities announced during the store sync handshake. Peers only rely on capabilities both announced.
+const (
+	SyncCapabilityStoreSync     = "store-sync"         // openStore, updateStore and closeStore requests
+	SyncCapabilityRequestErrors = "request-errors"     // unsupported or refused requests are answered with an error
+	SyncCapabilityTransactions  = "store-transactions" // store transactions are synced as a single updateStoreBatchResponse
+	SyncCapabilityItemDiffs     = "store-item-diffs"   // store updates carry the diff of the item, see model.StoreItemDiff
+)
+
+const (
+	syncHelloRequest  = "syncHello"
+	syncHelloResponse = "syncHelloResponse"
+)
+
+// syncRequestVersions holds the protocol version that introduced each store sync request. Requests are
+// neither sent to nor accepted from peers that negotiated an older version, rather than being dropped or
+// misread on the other side.
+var syncRequestVersions = map[string]int{
+	syncHelloRequest:   2,
+	openStoreRequest:   1,
+	updateStoreRequest: 1,
+	closeStoreRequest:  1,
+}
+
+// localSyncCapabilities lists the capabilities this instance announces.
+var localSyncCapabilities = []string{SyncCapabilityStoreSync, SyncCapabilityRequestErrors, SyncCapabilityTransactions,
+	SyncCapabilityItemDiffs}
+
+// SyncProtocol is the outcome of the store sync handshake with a peer.
+type SyncProtocol struct {
+	Version      int      `json:"protocolVersion"` // version both peers speak, 0 if they have none in common
+	Capabilities []string `json:"capabilities"`    // capabilities both peers announced
+}
+
+// HasCapability returns true if both peers announced the capability.
+func (p *SyncProtocol) HasCapability(capability string) bool {
+	for _, c := range p.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// Compatible returns true if the peers have a protocol version in common.
+func (p *SyncProtocol) Compatible() bool {
+	return p.Version >= SyncProtocolMinVersion
+}
+
+// Supports returns true if the request was introduced in the negotiated version or earlier.
+func (p *SyncProtocol) Supports(requestCommand string) bool {
+	version, ok := syncRequestVersions[requestCommand]
+	return ok && p.Compatible() && version <= p.Version
+}
+
+// syncHello is the payload of the handshake request, announcing the versions and capabilities of a peer.
+type syncHello struct {
+	ProtocolVersion    int      `json:"protocolVersion"`
+	MinProtocolVersion int      `json:"minProtocolVersion"`
+	Capabilities       []string `json:"capabilities"`
+}
+
+// syncHelloReply is sent back to the peer that sent the handshake, with the negotiated protocol.
+type syncHelloReply struct {
+	ResponseType string `json:"responseType"` // should be "syncHelloResponse"
+	SyncProtocol
+}
+
+func newSyncHello() *syncHello {
+	return &syncHello{
+		ProtocolVersion:    SyncProtocolVersion,
+		MinProtocolVersion: SyncProtocolMinVersion,
+		Capabilities:       localSyncCapabilities,
+	}
+}
+
+// legacySyncProtocol is assumed for peers that have not completed the handshake.
+func legacySyncProtocol() *SyncProtocol {
+	return &SyncProtocol{Version: 1, Capabilities: []string{SyncCapabilityStoreSync}}
+}
+
+// negotiate picks the newest version both peers speak and the capabilities both announced. The returned
+// protocol is not compatible if the version ranges do not overlap.
+func (h *syncHello) negotiate() *SyncProtocol {
+	version := SyncProtocolVersion
+	if h.ProtocolVersion < version {
+		version = h.ProtocolVersion
+	}
+	if version < h.MinProtocolVersion || version < SyncProtocolMinVersion {
+		version = 0
+	}
+	var capabilities []string
+	for _, c := range localSyncCapabilities {
+		for _, peer := range h.Capabilities {
+			if c == peer {
+				capabilities = append(capabilities, c)
+				break
+			}
+		}
+	}
+	sort.Strings(capabilities)
+	return &SyncProtocol{Version: version, Capabilities: capabilities}
+}
+
+func (h *syncHello) String() string {
+	return fmt.Sprintf("versions %d-%d", h.MinProtocolVersion, h.ProtocolVersion)
+}
+
+// decodeSyncHello converts a handshake payload into a syncHello. Local requests carry maps, requests
+// relayed from a broker may carry raw JSON.
+func decodeSyncHello(payload interface{}) (*syncHello, error) {
+	var raw []byte
+	switch p := payload.(type) {
+	case *syncHello:
+		return p, nil
+	case []byte:
+		raw = p
+	case string:
+		raw = []byte(p)
+	default:
+		var err error
+		if raw, err = json.Marshal(p); err != nil {
+			return nil, err
+		}
+	}
+	var hello syncHello
+	if err := json.Unmarshal(raw, &hello); err != nil {
+		return nil, err
+	}
+	if hello.ProtocolVersion <= 0 || hello.MinProtocolVersion > hello.ProtocolVersion {
+		return nil, fmt.Errorf("invalid store sync protocol versions %d-%d",
+			hello.MinProtocolVersion, hello.ProtocolVersion)
+	}
+	return &hello, nil
+}
+
+// syncProtocolState tracks the protocol negotiated on one sync channel.
+type syncProtocolState struct {
+	lock     sync.RWMutex
+	protocol *SyncProtocol
+}
+
+func (s *syncProtocolState) get() *SyncProtocol {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.protocol == nil {
+		return legacySyncProtocol()
+	}
+	return s.protocol
+}
+
+func (s *syncProtocolState) set(protocol *SyncProtocol) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.protocol = protocol
+}
+
+// negotiateProtocol sends the handshake on the sync channel and records the protocol the peer answers
+// with. Peers that predate the handshake never answer, and keep being spoken to using version 1.
+func (conf *storeSyncChannelConfig) negotiateProtocol(eventBus EventBus) {
+	handler, err := eventBus.ListenStream(conf.syncChannelName)
+	if err != nil {
+		return
+	}
+
+	helloId := uuid.New()
+	handler.Handle(
+		func(msg *model.Message) {
+			d, ok := msg.Payload.([]byte)
+			if !ok {
+				return
+			}
+			var reply struct {
+				syncHelloReply
+				Id           *uuid.UUID `json:"id"`
+				Error        bool       `json:"error"`
+				ErrorMessage string     `json:"errorMessage"`
+			}
+			if json.Unmarshal(d, &reply) != nil {
+				return
+			}
+
+			switch {
+			case reply.ResponseType == syncHelloResponse:
+				conf.protocol.set(&reply.SyncProtocol)
+			case reply.Error && reply.Id != nil && *reply.Id == helloId:
+				// the peer does not speak any version we do, stop syncing rather than corrupting its stores.
+				log.Warn("store sync handshake on %s failed: %s", conf.syncChannelName, reply.ErrorMessage)
+				conf.protocol.set(&SyncProtocol{})
+			}
+		},
+		func(e error) {})
+
+	conf.sendRequest(syncHelloRequest, newSyncHello(), &helloId)
+}
+
+// sendRequest publishes a store sync request to the peer.
+func (conf *storeSyncChannelConfig) sendRequest(requestCmd string, requestPayload interface{}, id *uuid.UUID) {
+	r := &model.Request{}
+	r.RequestCommand = requestCmd
+	r.Payload = requestPayload
+	r.Id = id
+	jsonReq, _ := json.Marshal(r)
+
+	conf.conn.SendJSONMessage(conf.pubPrefix+conf.syncChannelName, jsonReq)
+}
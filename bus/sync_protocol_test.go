@@ -0,0 +1,166 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package bus
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pb33f/ranch/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyncHello_Negotiate(t *testing.T) {
+	// an older peer, the newest version it speaks wins.
+	p := (&syncHello{ProtocolVersion: 1, MinProtocolVersion: 1, Capabilities: []string{SyncCapabilityStoreSync}}).negotiate()
+	assert.Equal(t, 1, p.Version)
+	assert.Equal(t, []string{SyncCapabilityStoreSync}, p.Capabilities)
+	assert.True(t, p.Supports(openStoreRequest))
+	assert.False(t, p.Supports(syncHelloRequest))
+	assert.False(t, p.HasCapability(SyncCapabilityRequestErrors))
+
+	// a newer peer that still speaks our version, unknown capabilities are ignored.
+	p = (&syncHello{ProtocolVersion: 5, MinProtocolVersion: 2,
+		Capabilities: []string{SyncCapabilityRequestErrors, "from-the-future"}}).negotiate()
+	assert.Equal(t, SyncProtocolVersion, p.Version)
+	assert.Equal(t, []string{SyncCapabilityRequestErrors}, p.Capabilities)
+	assert.True(t, p.Compatible())
+
+	// a newer peer that dropped every version we speak.
+	p = (&syncHello{ProtocolVersion: 5, MinProtocolVersion: 4}).negotiate()
+	assert.False(t, p.Compatible())
+	assert.False(t, p.Supports(updateStoreRequest))
+}
+
+func TestDecodeSyncHello(t *testing.T) {
+	hello, err := decodeSyncHello(map[string]interface{}{
+		"protocolVersion": float64(2), "minProtocolVersion": float64(1), "capabilities": []interface{}{"store-sync"}})
+	assert.NoError(t, err)
+	assert.Equal(t, &syncHello{ProtocolVersion: 2, MinProtocolVersion: 1, Capabilities: []string{"store-sync"}}, hello)
+
+	_, err = decodeSyncHello(`{"protocolVersion":1,"minProtocolVersion":2}`)
+	assert.EqualError(t, err, "invalid store sync protocol versions 2-1")
+
+	_, err = decodeSyncHello(`{}`)
+	assert.Error(t, err)
+}
+
+func listenSyncResponses(t *testing.T, bus EventBus, channel string) chan interface{} {
+	responses := make(chan interface{}, 10)
+	mh, _ := bus.ListenStream(channel)
+	mh.Handle(func(message *model.Message) {
+		responses <- message.Payload
+	}, func(e error) {
+		assert.Fail(t, "Unexpected error")
+	})
+	return responses
+}
+
+func TestStoreSyncService_SyncHello(t *testing.T) {
+	service, bus := testStoreSyncService()
+
+	syncChan := "transport-store-sync.1"
+	bus.GetChannelManager().CreateChannel(syncChan)
+	bus.SendMonitorEvent(FabricEndpointSubscribeEvt, syncChan, nil)
+	responses := listenSyncResponses(t, bus, syncChan)
+
+	// peers that did not shake hands are spoken to with the legacy protocol, and not sent errors.
+	bus.SendRequestMessage(syncChan, &model.Request{
+		RequestCommand: "compactStore",
+		Payload:        map[string]interface{}{"storeId": "test-store"},
+	}, nil)
+
+	id := uuid.New()
+	bus.SendRequestMessage(syncChan, &model.Request{
+		RequestCommand: syncHelloRequest,
+		Payload: map[string]interface{}{
+			"protocolVersion": 3, "minProtocolVersion": 1, "capabilities": []string{"request-errors", "store-sync"}},
+		Id: &id,
+	}, nil)
+
+	reply := (<-responses).(*syncHelloReply)
+	assert.Equal(t, syncHelloResponse, reply.ResponseType)
+	assert.Equal(t, SyncProtocolVersion, reply.Version)
+	assert.Equal(t, []string{SyncCapabilityRequestErrors, SyncCapabilityStoreSync}, reply.Capabilities)
+	assert.Equal(t, SyncProtocolVersion, service.syncClients[syncChan].protocol.get().Version)
+
+	// once both peers announced request-errors, unsupported requests are answered.
+	bus.SendRequestMessage(syncChan, &model.Request{
+		RequestCommand: "compactStore",
+		Payload:        map[string]interface{}{"storeId": "test-store"},
+		Id:             &id,
+	}, nil)
+
+	errResp := (<-responses).(*model.Response)
+	assert.True(t, errResp.Error)
+	assert.Equal(t, &id, errResp.Id)
+	assert.Equal(t, `Unsupported store sync request "compactStore" for protocol version 2`, errResp.ErrorMessage)
+}
+
+func TestStoreSyncService_SyncHelloIncompatible(t *testing.T) {
+	service, bus := testStoreSyncService()
+	bus.GetStoreManager().CreateStore("test-store").Initialize()
+
+	syncChan := "transport-store-sync.1"
+	bus.GetChannelManager().CreateChannel(syncChan)
+	bus.SendMonitorEvent(FabricEndpointSubscribeEvt, syncChan, nil)
+	responses := listenSyncResponses(t, bus, syncChan)
+
+	id := uuid.New()
+	bus.SendRequestMessage(syncChan, &model.Request{
+		RequestCommand: syncHelloRequest,
+		Payload: map[string]interface{}{
+			"protocolVersion": 7, "minProtocolVersion": 6, "capabilities": []string{"request-errors"}},
+		Id: &id,
+	}, nil)
+
+	errResp := (<-responses).(*model.Response)
+	assert.True(t, errResp.Error)
+	assert.Equal(t, &id, errResp.Id)
+	assert.Equal(t, "Incompatible store sync protocol: peer speaks versions 6-7, this instance speaks versions 1-2",
+		errResp.ErrorMessage)
+
+	// the peer's requests are refused rather than applied to the store.
+	bus.SendRequestMessage(syncChan, &model.Request{
+		RequestCommand: updateStoreRequest,
+		Payload:        map[string]interface{}{"storeId": "test-store", "itemId": "item1", "newItemValue": "value1"},
+		Id:             &id,
+	}, nil)
+
+	errResp = (<-responses).(*model.Response)
+	assert.Equal(t, "Incompatible store sync protocol, refusing request updateStore", errResp.ErrorMessage)
+	assert.Nil(t, bus.GetStoreManager().GetStore("test-store").GetValue("item1"))
+	assert.Len(t, service.syncClients[syncChan].openStores, 0)
+}
+
+func TestStoreSyncChannelConfig_NegotiateProtocol(t *testing.T) {
+	store, conn, bus := testGalacticStore(nil)
+	conf := store.(*busStore).galacticConf.syncChannelConfig
+
+	conf.negotiateProtocol(bus)
+	assert.Equal(t, "syncHello", conn.lastMessage()["request"])
+	assert.Equal(t, "/pub/sync-channel", conn.lastTopic())
+	assert.Equal(t, 1, conf.protocol.get().Version)
+
+	reply, _ := json.Marshal(&syncHelloReply{ResponseType: syncHelloResponse,
+		SyncProtocol: SyncProtocol{Version: 2, Capabilities: []string{SyncCapabilityStoreSync}}})
+	bus.SendResponseMessage("sync-channel", reply, nil)
+	assert.Eventually(t, func() bool {
+		return conf.protocol.get().Version == 2
+	}, time.Second, time.Millisecond)
+
+	// a peer refusing the handshake stops the store from syncing.
+	helloId, _ := uuid.Parse(conn.lastMessage()["id"].(string))
+	refusal, _ := json.Marshal(&model.Response{Id: &helloId, Error: true, ErrorMessage: "Incompatible store sync protocol"})
+	bus.SendResponseMessage("sync-channel", refusal, nil)
+	assert.Eventually(t, func() bool {
+		return !conf.protocol.get().Compatible()
+	}, time.Second, time.Millisecond)
+
+	sent := len(conn.messages)
+	store.Put("item1", "value1", nil)
+	assert.Len(t, conn.messages, sent)
+}
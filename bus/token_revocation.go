@@ -0,0 +1,64 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package bus
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	TOKEN_REVOCATION_CHANNEL = RANCH_INTERNAL_CHANNEL_PREFIX + "token-revocation"
+)
+
+// TokenRevocation is published on TOKEN_REVOCATION_CHANNEL when a session token is revoked. Every fabric
+// endpoint listening to the channel drops STOMP sessions using the token and rejects it from then on, and
+// plank servers reject HTTP requests bearing it. Revocations only reach the other instances when the channel
+// is bridged to a broker they share, e.g. with the TokenRevocations destination of a plank broker bridge.
+type TokenRevocation struct {
+	Token     string    `json:"token"`               // the revoked session token
+	Reason    string    `json:"reason,omitempty"`    // why the token was revoked, for auditing
+	ExpiresAt time.Time `json:"expiresAt,omitempty"` // when the token would have expired anyway, zero if unknown
+}
+
+// RevokeSessionToken publishes a revocation on TOKEN_REVOCATION_CHANNEL. It is sent as a request so that
+// broker bridges relay it to remote instances, as well as being picked up by local fabric endpoints.
+// To rotate a session token, issue the new token to the client first, then revoke the old one.
+func RevokeSessionToken(eventBus EventBus, revocation *TokenRevocation) error {
+	if revocation == nil || revocation.Token == "" {
+		return fmt.Errorf("token revocation requires a token")
+	}
+	cm := eventBus.GetChannelManager()
+	if !cm.CheckChannelExists(TOKEN_REVOCATION_CHANNEL) {
+		cm.CreateChannel(TOKEN_REVOCATION_CHANNEL)
+	}
+	return eventBus.SendRequestMessage(TOKEN_REVOCATION_CHANNEL, revocation, nil)
+}
+
+// DecodeTokenRevocation converts a revocation payload into a TokenRevocation. Local revocations arrive as
+// structs, revocations relayed from a broker arrive as raw JSON.
+func DecodeTokenRevocation(payload interface{}) (*TokenRevocation, error) {
+	var raw []byte
+	switch p := payload.(type) {
+	case *TokenRevocation:
+		return p, nil
+	case TokenRevocation:
+		return &p, nil
+	case []byte:
+		raw = p
+	case string:
+		raw = []byte(p)
+	default:
+		return nil, fmt.Errorf("unsupported token revocation payload type %T", payload)
+	}
+	var revocation TokenRevocation
+	if err := json.Unmarshal(raw, &revocation); err != nil {
+		return nil, err
+	}
+	if revocation.Token == "" {
+		return nil, fmt.Errorf("token revocation is missing a token")
+	}
+	return &revocation, nil
+}
@@ -0,0 +1,110 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package bus
+
+import (
+	"fmt"
+
+	"github.com/pb33f/ranch/model"
+)
+
+// PayloadValidator checks a store item or the payload of a channel message, returning an error that
+// describes why the value is invalid.
+type PayloadValidator func(value interface{}) error
+
+// JSONSchemaValidator creates a PayloadValidator checking values against a JSON schema, see model.JSONSchema
+// for the keywords supported.
+func JSONSchemaValidator(schema []byte) (PayloadValidator, error) {
+	compiled, err := model.CompileJSONSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+	return compiled.Validate, nil
+}
+
+// ValidationError is returned when a validator rejects a store item or a channel payload. Nothing was
+// stored or sent.
+type ValidationError struct {
+	Store   string // the store the item was put in, empty for channel payloads
+	ItemId  string // the id of the rejected store item
+	Channel string // the channel the payload was sent on, empty for store items
+	Err     error  // the error returned by the validator
+}
+
+func (e *ValidationError) Error() string {
+	if e.Store != "" {
+		return fmt.Sprintf("invalid item '%s' for store '%s': %s", e.ItemId, e.Store, e.Err.Error())
+	}
+	return fmt.Sprintf("invalid payload for channel '%s': %s", e.Channel, e.Err.Error())
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// SetValidator makes the channel reject requests and responses whose payload the validator rejects, a nil
+// validator accepts every payload. The payload of a model.Request or model.Response is validated rather
+// than the envelope, error responses are never rejected. Only messages sent through the bus are validated,
+// including requests from fabric clients, messages relayed from a broker are not.
+func (channel *Channel) SetValidator(validator PayloadValidator) {
+	channel.channelLock.Lock()
+	defer channel.channelLock.Unlock()
+	channel.validator = validator
+}
+
+// validate returns a *ValidationError if the channel validator rejects the payload of the message.
+func (channel *Channel) validate(message *model.Message) error {
+	channel.channelLock.Lock()
+	validator := channel.validator
+	channel.channelLock.Unlock()
+	if validator == nil || message.Direction == model.ErrorDir {
+		return nil
+	}
+
+	payload := message.Payload
+	switch p := payload.(type) {
+	case *model.Request:
+		payload = p.Payload
+	case model.Request:
+		payload = p.Payload
+	case *model.Response:
+		if p.Error {
+			return nil
+		}
+		payload = p.Payload
+	case model.Response:
+		if p.Error {
+			return nil
+		}
+		payload = p.Payload
+	}
+	if err := validator(payload); err != nil {
+		return &ValidationError{Channel: channel.Name, Err: err}
+	}
+	return nil
+}
+
+// SetValidator makes the store reject items the validator rejects, a nil validator accepts every item.
+// Items already in the store are not checked. Changes synced from the owner of a galactic store or from
+// other instances sharing a distributed store are not validated, they were validated where they were made.
+func (store *busStore) SetValidator(validator PayloadValidator) {
+	store.itemsLock.Lock()
+	defer store.itemsLock.Unlock()
+	store.validator = validator
+}
+
+// validate returns a *ValidationError if the store validator rejects the item. The items lock must not
+// be held, validators may be slow.
+func (store *busStore) validate(id string, value interface{}) error {
+	store.itemsLock.RLock()
+	validator := store.validator
+	store.itemsLock.RUnlock()
+	if validator == nil {
+		return nil
+	}
+	if err := validator(value); err != nil {
+		return &ValidationError{Store: store.name, ItemId: id, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package bus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/pb33f/ranch/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChannel_Validator(t *testing.T) {
+	bus := newTestEventBus()
+	channel := bus.GetChannelManager().CreateChannel("validated")
+	validator, err := JSONSchemaValidator([]byte(`{"type": "object", "required": ["name"]}`))
+	assert.NoError(t, err)
+	channel.SetValidator(validator)
+
+	received := make(chan *model.Message, 5)
+	mh, _ := bus.ListenRequestStream("validated")
+	mh.Handle(func(message *model.Message) {
+		received <- message
+	}, func(e error) {})
+
+	err = bus.SendRequestMessage("validated", &model.Request{Payload: map[string]interface{}{"age": 3}}, nil)
+	var validationErr *ValidationError
+	assert.True(t, errors.As(err, &validationErr))
+	assert.EqualError(t, err, "invalid payload for channel 'validated': $: missing required property 'name'")
+
+	valid := &model.Request{Payload: map[string]interface{}{"name": "daisy"}}
+	assert.NoError(t, bus.SendRequestMessage("validated", valid, nil))
+	assert.Equal(t, valid, (<-received).Payload)
+	assert.Empty(t, received)
+
+	// error responses are never rejected.
+	assert.NoError(t, bus.SendResponseMessage("validated", &model.Response{Error: true, ErrorMessage: "nope"}, nil))
+	assert.Error(t, bus.SendBroadcastMessage("validated", "daisy"))
+
+	channel.SetValidator(nil)
+	assert.NoError(t, bus.SendBroadcastMessage("validated", "daisy"))
+}
+
+func TestBusStore_Validator(t *testing.T) {
+	store := testStore()
+	store.SetValidator(func(value interface{}) error {
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("expected a string, got %T", value)
+		}
+		return nil
+	})
+
+	assert.NoError(t, store.TryPut("id1", "value1", nil))
+	assert.EqualError(t, store.TryPut("id2", 2, nil), "invalid item 'id2' for store 'testStore': expected a string, got int")
+	store.Put("id2", 2, nil)
+	store.PutWithExpiry("id2", 2, nil, 0)
+	assert.Error(t, store.PutIfVersion("id1", 2, nil, 1))
+	assert.Equal(t, map[string]interface{}{"id1": "value1"}, store.AllValuesAsMap())
+
+	tx := store.BeginTx(nil)
+	tx.Put("id3", "value3")
+	tx.Put("id4", 4)
+	assert.EqualError(t, tx.Commit(), "invalid item 'id4' for store 'testStore': expected a string, got int")
+	assert.Nil(t, store.GetValue("id3"))
+
+	populated := newBusStore("populated", newTestEventBus(), nil, nil)
+	populated.SetValidator(store.(*busStore).validator)
+	assert.Error(t, populated.Populate(map[string]interface{}{"id1": 1}))
+	assert.Empty(t, populated.AllValues())
+}
+
+func TestFabricEndpoint_BridgeMessageValidation(t *testing.T) {
+	bus := newTestEventBus()
+	_, mockServer := newTestFabricEndpoint(bus, EndpointConfig{TopicPrefix: "/topic", AppRequestPrefix: "/pub",
+		AppRequestQueuePrefix: "/pub/queue", UserQueuePrefix: "/user/queue"})
+
+	channel := bus.GetChannelManager().CreateChannel("request-channel")
+	channel.SetValidator(func(value interface{}) error {
+		return fmt.Errorf("no requests today")
+	})
+	wg := sync.WaitGroup{}
+	mockServer.wg = &wg
+	mockServer.subscribeHandlerFunction("con1", "sub1", "/user/queue/request-channel", nil)
+
+	id := uuid.New()
+	req, _ := json.Marshal(model.Request{RequestCommand: "test-request", Payload: "test-rq", Id: &id})
+	wg.Add(1)
+	mockServer.applicationRequestHandlerFunction("/pub/queue/request-channel", req, "con1")
+	wg.Wait()
+
+	assert.Len(t, mockServer.sentMessages, 1)
+	assert.Equal(t, "con1", mockServer.sentMessages[0].conId)
+	assert.Equal(t, "/user/queue/request-channel", mockServer.sentMessages[0].Destination)
+	var resp model.Response
+	assert.NoError(t, json.Unmarshal(mockServer.sentMessages[0].Payload, &resp))
+	assert.True(t, resp.Error)
+	assert.Equal(t, &id, resp.Id)
+	assert.Equal(t, "invalid payload for channel 'request-channel': no requests today", resp.ErrorMessage)
+}
@@ -0,0 +1,468 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+// Package client is a STOMP 1.2 client for the ranch fabric, and any other STOMP broker, over TCP, TLS or
+// WebSocket. Clients exchange heart-beats with the broker, and reconnect with a backoff when the connection
+// is lost, subscribing to their destinations again.
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/pb33f/ranch/clock"
+	"github.com/pb33f/ranch/log"
+)
+
+var (
+	// ErrNotConnected is returned when sending while the client is reconnecting.
+	ErrNotConnected = errors.New("not connected to the broker")
+	// ErrClosed is returned once the client disconnected, or gave up reconnecting.
+	ErrClosed = errors.New("client is closed")
+)
+
+// Defaults of Config.
+const (
+	defaultConnectTimeout    = 10 * time.Second
+	defaultReconnectDelay    = time.Second
+	defaultMaxReconnectDelay = 30 * time.Second
+)
+
+// Config sets the broker a client connects to, and how.
+type Config struct {
+	Addr                 string            // host:port of the broker
+	WebSocketPath        string            // path of the WebSocket endpoint, e.g. /ranch, connects over TCP if empty
+	TLSConfig            *tls.Config       // connect over TLS, or secure WebSocket, if set
+	Login                string            // login header of the CONNECT frame
+	Passcode             string            `secret:"true"` // passcode header of the CONNECT frame
+	Host                 string            // host header of the CONNECT frame, defaults to "/"
+	Header               map[string]string // additional CONNECT headers, e.g. Authorization
+	HttpHeader           http.Header       // additional headers of the WebSocket upgrade request
+	HeartBeatOut         time.Duration     // heart-beats sent at least this often, or as often as the broker asks, none if 0
+	HeartBeatIn          time.Duration     // heart-beats asked of the broker this often, none if 0
+	ConnectTimeout       time.Duration     // wait for the broker to accept a connection, defaults to 10 seconds
+	ReconnectDelay       time.Duration     // wait before reconnecting, doubled after every failed attempt, defaults to 1 second
+	MaxReconnectDelay    time.Duration     // longest wait between attempts, defaults to 30 seconds
+	MaxReconnectAttempts int               // failed attempts before giving up and closing, unlimited if 0
+	DisableReconnect     bool              // close the client when the connection is lost instead
+	OnConnect            func()            // called on every connection after the first, once subscribed again
+	OnDisconnect         func(err error)   // called when the connection is lost, with the reason
+	Logger               *slog.Logger      // defaults to the ranch logger
+}
+
+// Client is a connection to a STOMP broker, kept up until Disconnect is called. Clients are safe for
+// concurrent use.
+type Client struct {
+	config        Config
+	lock          sync.Mutex
+	conn          frameConn // nil while reconnecting
+	subscriptions map[string]*Subscription
+	receipts      map[string]chan struct{} // closed once the RECEIPT frame arrives, by receipt id
+	writeLock     sync.Mutex
+	ids           atomic.Uint64
+	closed        chan struct{}
+	closeOnce     sync.Once
+	err           error // why the client closed, set before closed is
+}
+
+// Dial connects to a broker, returning an error if the connection cannot be made or is refused. Connections
+// lost afterwards are made again.
+func Dial(config *Config) (*Client, error) {
+	if config == nil || config.Addr == "" {
+		return nil, fmt.Errorf("unable to dial broker: missing address")
+	}
+	c := &Client{
+		config:        *config,
+		subscriptions: make(map[string]*Subscription),
+		receipts:      make(map[string]chan struct{}),
+		closed:        make(chan struct{}),
+	}
+	if c.config.Host == "" {
+		c.config.Host = "/"
+	}
+	if c.config.ConnectTimeout <= 0 {
+		c.config.ConnectTimeout = defaultConnectTimeout
+	}
+	if c.config.ReconnectDelay <= 0 {
+		c.config.ReconnectDelay = defaultReconnectDelay
+	}
+	if c.config.MaxReconnectDelay <= 0 {
+		c.config.MaxReconnectDelay = defaultMaxReconnectDelay
+	}
+	if c.config.Logger == nil {
+		c.config.Logger = log.Logger()
+	}
+
+	conn, out, in, err := c.connect()
+	if err != nil {
+		return nil, fmt.Errorf("unable to dial broker: %w", err)
+	}
+	c.conn = conn
+	go c.run(conn, out, in)
+	return c, nil
+}
+
+// Subscribe subscribes to a destination. opts may change the SUBSCRIBE frame, e.g. to add headers. While
+// reconnecting, the subscription is made once connected.
+func (c *Client) Subscribe(destination string, opts ...func(*frame.Frame) error) (*Subscription, error) {
+	id := "sub-" + strconv.FormatUint(c.ids.Add(1), 10)
+	f := frame.New(frame.SUBSCRIBE,
+		frame.Id, id,
+		frame.Destination, destination,
+		frame.Ack, "auto")
+	for _, opt := range opts {
+		if err := opt(f); err != nil {
+			return nil, err
+		}
+	}
+	sub := newSubscription(c, id, f)
+
+	c.lock.Lock()
+	if c.isClosed() {
+		c.lock.Unlock()
+		return nil, ErrClosed
+	}
+	c.subscriptions[id] = sub
+	conn := c.conn
+	c.lock.Unlock()
+	if conn != nil {
+		// a connection lost meanwhile makes the subscription again once reconnected
+		_ = c.writeTo(conn, f)
+	}
+	return sub, nil
+}
+
+// Send sends a message to a destination. opts may change the SEND frame, e.g. to add headers. Returns
+// ErrNotConnected while reconnecting.
+func (c *Client) Send(destination, contentType string, body []byte, opts ...func(*frame.Frame) error) error {
+	f := frame.New(frame.SEND,
+		frame.Destination, destination,
+		frame.ContentLength, strconv.Itoa(len(body)),
+		frame.ContentType, contentType)
+	for _, opt := range opts {
+		if err := opt(f); err != nil {
+			return err
+		}
+	}
+	f.Body = body
+	return c.write(f)
+}
+
+// Connected returns whether the client is connected, rather than reconnecting or closed.
+func (c *Client) Connected() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.conn != nil
+}
+
+// Done is closed once the client is closed, by Disconnect or after giving up reconnecting.
+func (c *Client) Done() <-chan struct{} {
+	return c.closed
+}
+
+// Err returns why the client closed, ErrClosed if it was disconnected, nil while it is open.
+func (c *Client) Err() error {
+	if !c.isClosed() {
+		return nil
+	}
+	return c.err
+}
+
+// Disconnect sends a DISCONNECT frame and waits for the broker to acknowledge it, then closes the
+// connection and ends every subscription.
+func (c *Client) Disconnect() error {
+	if !c.close(ErrClosed) {
+		return ErrClosed
+	}
+	c.lock.Lock()
+	conn := c.conn
+	c.lock.Unlock()
+
+	var err error
+	if conn != nil {
+		receipt := "receipt-" + strconv.FormatUint(c.ids.Add(1), 10)
+		received := make(chan struct{})
+		c.lock.Lock()
+		c.receipts[receipt] = received
+		c.lock.Unlock()
+		if err = c.writeTo(conn, frame.New(frame.DISCONNECT, frame.Receipt, receipt)); err == nil {
+			select {
+			case <-received:
+			case <-clock.After(c.config.ConnectTimeout):
+				err = fmt.Errorf("unable to disconnect gracefully: no receipt from the broker")
+			}
+		}
+	}
+	c.shutdown()
+	return err
+}
+
+// connect dials the broker and sends the CONNECT frame, returning the connection and the heart-beat
+// intervals agreed on with the broker.
+func (c *Client) connect() (conn frameConn, out time.Duration, in time.Duration, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.config.ConnectTimeout)
+	defer cancel()
+	dialed, err := dial(ctx, &c.config)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer func() {
+		if err != nil {
+			_ = dialed.Close()
+		}
+	}()
+	conn = dialed
+
+	f := frame.New(frame.CONNECT,
+		frame.AcceptVersion, "1.2",
+		frame.Host, c.config.Host,
+		frame.HeartBeat, fmt.Sprintf("%d,%d", c.config.HeartBeatOut.Milliseconds(), c.config.HeartBeatIn.Milliseconds()))
+	if c.config.Login != "" {
+		f.Header.Add(frame.Login, c.config.Login)
+		f.Header.Add(frame.Passcode, c.config.Passcode)
+	}
+	for key, value := range c.config.Header {
+		f.Header.Add(key, value)
+	}
+	if err = conn.WriteFrame(f); err != nil {
+		return nil, 0, 0, err
+	}
+
+	// network deadlines are on the wall clock
+	_ = conn.SetReadDeadline(time.Now().Add(c.config.ConnectTimeout))
+	var reply *frame.Frame
+	for reply == nil {
+		if reply, err = conn.ReadFrame(); err != nil {
+			return nil, 0, 0, err
+		}
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	switch reply.Command {
+	case frame.CONNECTED:
+	case frame.ERROR:
+		return nil, 0, 0, fmt.Errorf("connection refused by the broker: %s", reply.Header.Get(frame.Message))
+	default:
+		return nil, 0, 0, fmt.Errorf("unexpected %s frame from the broker", reply.Command)
+	}
+
+	// each side sends heart-beats as rarely as both sides allow, if both want them
+	var sx, sy time.Duration
+	if hb, ok := reply.Header.Contains(frame.HeartBeat); ok {
+		if sx, sy, err = frame.ParseHeartBeat(hb); err != nil {
+			return nil, 0, 0, err
+		}
+	}
+	if c.config.HeartBeatOut > 0 && sy > 0 {
+		out = max(c.config.HeartBeatOut, sy)
+	}
+	if c.config.HeartBeatIn > 0 && sx > 0 {
+		in = max(c.config.HeartBeatIn, sx)
+	}
+	return conn, out, in, nil
+}
+
+// run serves connections until the client closes, reconnecting when one is lost.
+func (c *Client) run(conn frameConn, out, in time.Duration) {
+	for {
+		err := c.serve(conn, out, in)
+		c.lock.Lock()
+		c.conn = nil
+		c.lock.Unlock()
+		if c.isClosed() {
+			return
+		}
+		c.config.Logger.Warn("[ranch] lost connection to the broker", "addr", c.config.Addr, "error", err)
+		if c.config.OnDisconnect != nil {
+			c.config.OnDisconnect(err)
+		}
+		if c.config.DisableReconnect {
+			c.close(err)
+			c.shutdown()
+			return
+		}
+		if conn, out, in, err = c.reconnect(); err != nil {
+			c.close(err)
+			c.shutdown()
+			return
+		}
+		if c.config.OnConnect != nil {
+			c.config.OnConnect()
+		}
+	}
+}
+
+// serve reads the frames of a connection, and sends heart-beats on it, until it is lost or closed.
+func (c *Client) serve(conn frameConn, out, in time.Duration) error {
+	defer conn.Close()
+	if out > 0 {
+		stop := make(chan struct{})
+		defer close(stop)
+		go c.heartBeat(conn, out, stop)
+	}
+
+	var brokerErr error
+	for {
+		if in > 0 {
+			// the broker is given as long again before it is considered gone
+			_ = conn.SetReadDeadline(time.Now().Add(2 * in))
+		}
+		f, err := conn.ReadFrame()
+		if err != nil {
+			if brokerErr != nil {
+				return brokerErr
+			}
+			return err
+		}
+		if f == nil {
+			continue
+		}
+		switch f.Command {
+		case frame.MESSAGE:
+			c.dispatch(f)
+		case frame.RECEIPT:
+			c.lock.Lock()
+			if received, ok := c.receipts[f.Header.Get(frame.ReceiptId)]; ok {
+				delete(c.receipts, f.Header.Get(frame.ReceiptId))
+				close(received)
+			}
+			c.lock.Unlock()
+		case frame.ERROR:
+			// the broker closes the connection after an error
+			brokerErr = fmt.Errorf("error from the broker: %s", f.Header.Get(frame.Message))
+			c.config.Logger.Warn("[ranch] error from the broker", "addr", c.config.Addr,
+				"message", f.Header.Get(frame.Message))
+		}
+	}
+}
+
+func (c *Client) heartBeat(conn frameConn, interval time.Duration, stop chan struct{}) {
+	ticker := clock.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C():
+			if err := c.writeTo(conn, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// reconnect connects again, waiting longer after every failed attempt, then subscribes again to the
+// destinations subscribed to.
+func (c *Client) reconnect() (frameConn, time.Duration, time.Duration, error) {
+	delay := c.config.ReconnectDelay
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-c.closed:
+			return nil, 0, 0, ErrClosed
+		case <-clock.After(delay):
+		}
+		conn, out, in, err := c.connect()
+		if err == nil {
+			c.lock.Lock()
+			defer c.lock.Unlock()
+			if c.isClosed() {
+				_ = conn.Close()
+				return nil, 0, 0, ErrClosed
+			}
+			c.conn = conn
+			for _, sub := range c.subscriptions {
+				_ = c.writeTo(conn, sub.frame)
+			}
+			c.config.Logger.Info("[ranch] reconnected to the broker", "addr", c.config.Addr, "attempts", attempt)
+			return conn, out, in, nil
+		}
+		if c.config.MaxReconnectAttempts > 0 && attempt >= c.config.MaxReconnectAttempts {
+			return nil, 0, 0, fmt.Errorf("unable to reconnect to the broker after %d attempts: %w", attempt, err)
+		}
+		c.config.Logger.Debug("[ranch] unable to reconnect to the broker", "addr", c.config.Addr,
+			"attempt", attempt, "error", err)
+		delay = min(delay*2, c.config.MaxReconnectDelay)
+	}
+}
+
+// dispatch delivers a MESSAGE frame to its subscription.
+func (c *Client) dispatch(f *frame.Frame) {
+	c.lock.Lock()
+	sub := c.subscriptions[f.Header.Get(frame.Subscription)]
+	c.lock.Unlock()
+	if sub == nil {
+		return
+	}
+	sub.deliver(&Message{
+		Destination:  f.Header.Get(frame.Destination),
+		ContentType:  f.Header.Get(frame.ContentType),
+		Subscription: sub.Id,
+		Header:       f.Header,
+		Body:         f.Body,
+	})
+}
+
+// write sends a frame on the current connection.
+func (c *Client) write(f *frame.Frame) error {
+	c.lock.Lock()
+	conn := c.conn
+	c.lock.Unlock()
+	if c.isClosed() {
+		return ErrClosed
+	}
+	if conn == nil {
+		return ErrNotConnected
+	}
+	return c.writeTo(conn, f)
+}
+
+// writeTo sends a frame, or a heart-beat if nil, on a connection. Frames are written one at a time.
+func (c *Client) writeTo(conn frameConn, f *frame.Frame) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	return conn.WriteFrame(f)
+}
+
+func (c *Client) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// close marks the client closed for a reason, returning false if it was already.
+func (c *Client) close(err error) bool {
+	closed := false
+	c.closeOnce.Do(func() {
+		c.err = err
+		close(c.closed)
+		closed = true
+	})
+	return closed
+}
+
+// shutdown closes the connection of a closed client, and ends its subscriptions.
+func (c *Client) shutdown() {
+	c.lock.Lock()
+	conn := c.conn
+	c.conn = nil
+	subscriptions := c.subscriptions
+	c.subscriptions = make(map[string]*Subscription)
+	c.lock.Unlock()
+	if conn != nil {
+		_ = conn.Close()
+	}
+	for _, sub := range subscriptions {
+		sub.end()
+	}
+}
@@ -0,0 +1,310 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package client
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/gorilla/mux"
+	"github.com/pb33f/ranch/stompserver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var discard = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// startTestBroker starts a ranch STOMP server, returning its address and the destinations subscribed to
+// and the bodies of the requests sent to it.
+func startTestBroker(t *testing.T) (stompserver.StompServer, string, chan string, chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := stompserver.NewStompServer(stompserver.NewTcpConnectionListenerFromListener(listener),
+		stompserver.NewStompConfig(0, []string{"/pub/"}))
+	subscribed := make(chan string, 10)
+	requests := make(chan string, 10)
+	server.OnSubscribeEvent(func(conId string, subId string, destination string, f *frame.Frame) {
+		subscribed <- destination
+	})
+	server.OnApplicationRequest(func(destination string, message []byte, connectionId string) {
+		requests <- string(message)
+	})
+	go server.Start()
+	return server, listener.Addr().String(), subscribed, requests
+}
+
+func receive[T any](t *testing.T, c <-chan T) T {
+	t.Helper()
+	select {
+	case v := <-c:
+		return v
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out")
+	}
+	var zero T
+	return zero
+}
+
+// dropProxy forwards connections to an address, until drop closes them.
+type dropProxy struct {
+	listener net.Listener
+	lock     sync.Mutex
+	conns    []net.Conn
+}
+
+func newDropProxy(t *testing.T, target string) *dropProxy {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	p := &dropProxy{listener: listener}
+	t.Cleanup(func() {
+		_ = listener.Close()
+		p.drop()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			upstream, err := net.Dial("tcp", target)
+			if err != nil {
+				_ = conn.Close()
+				continue
+			}
+			p.lock.Lock()
+			p.conns = append(p.conns, conn, upstream)
+			p.lock.Unlock()
+			go func() { _, _ = io.Copy(upstream, conn) }()
+			go func() { _, _ = io.Copy(conn, upstream) }()
+		}
+	}()
+	return p
+}
+
+func (p *dropProxy) drop() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, conn := range p.conns {
+		_ = conn.Close()
+	}
+	p.conns = nil
+}
+
+func TestDial_Invalid(t *testing.T) {
+	_, err := Dial(&Config{})
+	assert.Error(t, err)
+	_, err = Dial(&Config{Addr: "127.0.0.1:1", ConnectTimeout: time.Second})
+	assert.Error(t, err)
+
+	// connections the broker refuses are not retried
+	addr := startFakeBroker(t, func(conn net.Conn, connect *frame.Frame) {
+		_ = frame.NewWriter(conn).Write(frame.New(frame.ERROR, frame.Message, "authentication failed"))
+	})
+	_, err = Dial(&Config{Addr: addr, Login: "daisy", Passcode: "moo", Logger: discard})
+	assert.ErrorContains(t, err, "authentication failed")
+}
+
+func TestClient_SendAndSubscribe(t *testing.T) {
+	server, addr, subscribed, requests := startTestBroker(t)
+	c, err := Dial(&Config{Addr: addr, Logger: discard})
+	require.NoError(t, err)
+	assert.True(t, c.Connected())
+
+	sub, err := c.Subscribe("/topic/cows", func(f *frame.Frame) error {
+		f.Header.Add("selector", "body.name = 'daisy'")
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "/topic/cows", receive(t, subscribed))
+	server.SendMessage("/topic/cows", []byte(`{"name":"bessie"}`))
+	server.SendMessage("/topic/cows", []byte(`{"name":"daisy"}`))
+	msg := receive(t, sub.C)
+	assert.Equal(t, "/topic/cows", msg.Destination)
+	assert.Equal(t, sub.Id, msg.Subscription)
+	assert.Equal(t, `{"name":"daisy"}`, string(msg.Body))
+
+	require.NoError(t, c.Send("/pub/cows", "application/json", []byte(`"moo"`)))
+	assert.Equal(t, `"moo"`, receive(t, requests))
+
+	require.NoError(t, sub.Unsubscribe())
+	_, open := <-sub.C
+	assert.False(t, open)
+
+	// disconnecting ends the subscriptions left
+	sub, err = c.Subscribe("/topic/sheep")
+	require.NoError(t, err)
+	require.NoError(t, c.Disconnect())
+	_, open = <-sub.C
+	assert.False(t, open)
+	assert.ErrorIs(t, c.Err(), ErrClosed)
+	assert.ErrorIs(t, c.Send("/pub/cows", "text/plain", nil), ErrClosed)
+	assert.ErrorIs(t, c.Disconnect(), ErrClosed)
+	_, err = c.Subscribe("/topic/cows")
+	assert.ErrorIs(t, err, ErrClosed)
+}
+
+func TestClient_WebSocket(t *testing.T) {
+	router := mux.NewRouter()
+	httpServer := httptest.NewServer(router)
+	defer httpServer.Close()
+	listener, err := stompserver.NewWebSocketConnectionFromExistingHttpServer(httpServer.Config, router, "/ranch",
+		nil, discard, false, nil)
+	require.NoError(t, err)
+	server := stompserver.NewStompServer(listener, stompserver.NewStompConfig(0, nil))
+	subscribed := make(chan string, 1)
+	server.OnSubscribeEvent(func(conId string, subId string, destination string, f *frame.Frame) {
+		subscribed <- destination
+	})
+	go server.Start()
+
+	c, err := Dial(&Config{Addr: strings.TrimPrefix(httpServer.URL, "http://"), WebSocketPath: "/ranch",
+		Logger: discard})
+	require.NoError(t, err)
+	sub, err := c.Subscribe("/topic/cows")
+	require.NoError(t, err)
+	assert.Equal(t, "/topic/cows", receive(t, subscribed))
+	server.SendMessage("/topic/cows", []byte("moo"))
+	assert.Equal(t, "moo", string(receive(t, sub.C).Body))
+	require.NoError(t, c.Disconnect())
+}
+
+func TestClient_Reconnect(t *testing.T) {
+	server, addr, subscribed, _ := startTestBroker(t)
+	proxy := newDropProxy(t, addr)
+	connected := make(chan struct{}, 1)
+	disconnected := make(chan error, 1)
+	c, err := Dial(&Config{
+		Addr:           proxy.listener.Addr().String(),
+		ReconnectDelay: 10 * time.Millisecond,
+		OnConnect:      func() { connected <- struct{}{} },
+		OnDisconnect:   func(err error) { disconnected <- err },
+		Logger:         discard,
+	})
+	require.NoError(t, err)
+	defer c.Disconnect()
+
+	sub, err := c.Subscribe("/topic/cows")
+	require.NoError(t, err)
+	assert.Equal(t, "/topic/cows", receive(t, subscribed))
+
+	// lost connections are made again, along with the subscriptions
+	proxy.drop()
+	assert.Error(t, receive(t, disconnected))
+	receive(t, connected)
+	assert.True(t, c.Connected())
+	assert.Equal(t, "/topic/cows", receive(t, subscribed))
+	server.SendMessage("/topic/cows", []byte("moo"))
+	assert.Equal(t, "moo", string(receive(t, sub.C).Body))
+}
+
+// startFakeBroker accepts connections, answering their CONNECT frame with handle.
+func startFakeBroker(t *testing.T, handle func(conn net.Conn, connect *frame.Frame)) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				connect, err := frame.NewReader(conn).Read()
+				if err == nil {
+					handle(conn, connect)
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestClient_HeartBeats(t *testing.T) {
+	connects := make(chan *frame.Frame, 10)
+	heartBeats := make(chan struct{}, 100)
+	addr := startFakeBroker(t, func(conn net.Conn, connect *frame.Frame) {
+		connects <- connect
+		// the broker wants heart-beats, and promises some it never sends
+		_ = frame.NewWriter(conn).Write(frame.New(frame.CONNECTED, frame.HeartBeat, "20,20"))
+		reader := frame.NewReader(conn)
+		for {
+			f, err := reader.Read()
+			if err != nil {
+				return
+			}
+			if f == nil {
+				heartBeats <- struct{}{}
+			}
+		}
+	})
+
+	c, err := Dial(&Config{
+		Addr:                 addr,
+		Login:                "daisy",
+		Passcode:             "moo",
+		Header:               map[string]string{"Authorization": "Bearer cows"},
+		HeartBeatOut:         10 * time.Millisecond,
+		HeartBeatIn:          10 * time.Millisecond,
+		ReconnectDelay:       10 * time.Millisecond,
+		MaxReconnectAttempts: 2,
+		Logger:               discard,
+	})
+	require.NoError(t, err)
+	connect := receive(t, connects)
+	assert.Equal(t, "1.2", connect.Header.Get(frame.AcceptVersion))
+	assert.Equal(t, "/", connect.Header.Get(frame.Host))
+	assert.Equal(t, "10,10", connect.Header.Get(frame.HeartBeat))
+	assert.Equal(t, "daisy", connect.Header.Get(frame.Login))
+	assert.Equal(t, "moo", connect.Header.Get(frame.Passcode))
+	assert.Equal(t, "Bearer cows", connect.Header.Get("Authorization"))
+
+	// heart-beats are sent, and a broker that does not send any is considered gone
+	receive(t, heartBeats)
+	receive(t, connects)
+	receive(t, connects)
+	assert.NoError(t, c.Err())
+	require.NoError(t, c.Disconnect())
+}
+
+func TestClient_GiveUp(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		_, _ = frame.NewReader(conn).Read()
+		_ = frame.NewWriter(conn).Write(frame.New(frame.CONNECTED))
+		accepted <- conn
+	}()
+
+	c, err := Dial(&Config{
+		Addr:                 listener.Addr().String(),
+		ReconnectDelay:       5 * time.Millisecond,
+		MaxReconnectAttempts: 3,
+		Logger:               discard,
+	})
+	require.NoError(t, err)
+	sub, err := c.Subscribe("/topic/cows")
+	require.NoError(t, err)
+
+	// clients close once every reconnection attempt failed
+	_ = listener.Close()
+	_ = receive(t, accepted).Close()
+	receive(t, c.Done())
+	assert.ErrorContains(t, c.Err(), "after 3 attempts")
+	assert.False(t, c.Connected())
+	_, open := <-sub.C
+	assert.False(t, open)
+}
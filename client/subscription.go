@@ -0,0 +1,98 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package client
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// subscriptionBuffer is how many messages a subscription holds before the client stops reading from the
+// broker, until they are received.
+const subscriptionBuffer = 64
+
+// Message is a MESSAGE frame sent to a subscription.
+type Message struct {
+	Destination  string
+	ContentType  string
+	Subscription string        // id of the subscription
+	Header       *frame.Header // every header of the frame
+	Body         []byte
+}
+
+// Subscription receives the messages sent to a destination on C, in order. C is closed once the
+// subscription ends, when unsubscribing or disconnecting. Subscriptions outlive connections, the client
+// subscribes again once reconnected.
+type Subscription struct {
+	C           <-chan *Message
+	Id          string
+	Destination string
+
+	client  *Client
+	frame   *frame.Frame  // SUBSCRIBE frame, sent again on every connection
+	c       chan *Message // C, which the read goroutine sends to
+	done    chan struct{} // closed once unsubscribed, unblocking the read goroutine
+	lock    sync.Mutex    // held while sending to c, so it is not closed meanwhile
+	active  bool
+	endOnce sync.Once
+}
+
+func newSubscription(client *Client, id string, f *frame.Frame) *Subscription {
+	c := make(chan *Message, subscriptionBuffer)
+	return &Subscription{
+		C:           c,
+		Id:          id,
+		Destination: f.Header.Get(frame.Destination),
+		client:      client,
+		frame:       f,
+		c:           c,
+		done:        make(chan struct{}),
+		active:      true,
+	}
+}
+
+// Unsubscribe ends the subscription, the broker is told if connected.
+func (s *Subscription) Unsubscribe() error {
+	if !s.end() {
+		return nil
+	}
+	s.client.lock.Lock()
+	delete(s.client.subscriptions, s.Id)
+	s.client.lock.Unlock()
+	err := s.client.write(frame.New(frame.UNSUBSCRIBE, frame.Id, s.Id))
+	if errors.Is(err, ErrNotConnected) {
+		// the subscription is not made again on the next connection
+		return nil
+	}
+	return err
+}
+
+// deliver hands a message to the receiver of C, waiting for room unless the subscription ends meanwhile.
+func (s *Subscription) deliver(msg *Message) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.active {
+		return
+	}
+	select {
+	case s.c <- msg:
+	case <-s.done:
+	}
+}
+
+// end closes C, returning false if the subscription had ended already.
+func (s *Subscription) end() bool {
+	ended := false
+	s.endOnce.Do(func() {
+		close(s.done)
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.active = false
+		close(s.c)
+		ended = true
+	})
+	return ended
+}
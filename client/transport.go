@@ -0,0 +1,109 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/gorilla/websocket"
+)
+
+// frameConn reads and writes the STOMP frames of a connection to the broker. Heart-beats are nil frames.
+type frameConn interface {
+	ReadFrame() (*frame.Frame, error)
+	WriteFrame(f *frame.Frame) error
+	SetReadDeadline(t time.Time) error
+	Close() error
+}
+
+// dial opens a connection to the broker over TCP, TLS or WebSocket, as the config asks for.
+func dial(ctx context.Context, config *Config) (frameConn, error) {
+	if config.WebSocketPath != "" {
+		return dialWebSocket(ctx, config)
+	}
+	dialer := &net.Dialer{}
+	var conn net.Conn
+	var err error
+	if config.TLSConfig != nil {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: config.TLSConfig}).DialContext(ctx, "tcp", config.Addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", config.Addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tcpFrameConn{conn: conn, reader: frame.NewReader(conn), writer: frame.NewWriter(conn)}, nil
+}
+
+// tcpFrameConn is a connection over TCP or TLS, frames follow each other on the stream.
+type tcpFrameConn struct {
+	conn   net.Conn
+	reader *frame.Reader
+	writer *frame.Writer
+}
+
+func (c *tcpFrameConn) ReadFrame() (*frame.Frame, error) {
+	return c.reader.Read()
+}
+
+func (c *tcpFrameConn) WriteFrame(f *frame.Frame) error {
+	return c.writer.Write(f)
+}
+
+func (c *tcpFrameConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *tcpFrameConn) Close() error {
+	return c.conn.Close()
+}
+
+func dialWebSocket(ctx context.Context, config *Config) (frameConn, error) {
+	u := url.URL{Scheme: "ws", Host: config.Addr, Path: config.WebSocketPath}
+	dialer := *websocket.DefaultDialer
+	if config.TLSConfig != nil {
+		u.Scheme = "wss"
+		dialer.TLSClientConfig = config.TLSConfig
+	}
+	conn, _, err := dialer.DialContext(ctx, u.String(), config.HttpHeader)
+	if err != nil {
+		return nil, err
+	}
+	return &webSocketFrameConn{conn: conn}, nil
+}
+
+// webSocketFrameConn is a connection over WebSocket, each message carries a frame or a heart-beat.
+type webSocketFrameConn struct {
+	conn *websocket.Conn
+}
+
+func (c *webSocketFrameConn) ReadFrame() (*frame.Frame, error) {
+	_, data, err := c.conn.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	return frame.NewReader(bytes.NewReader(data)).Read()
+}
+
+func (c *webSocketFrameConn) WriteFrame(f *frame.Frame) error {
+	var buf bytes.Buffer
+	if err := frame.NewWriter(&buf).Write(f); err != nil {
+		return err
+	}
+	return c.conn.WriteMessage(websocket.TextMessage, buf.Bytes())
+}
+
+func (c *webSocketFrameConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *webSocketFrameConn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,125 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+// Package clock is the source of time for timeouts, TTLs, schedulers and heart-beats across ranch. It uses
+// real time unless another Clock is set, such as a FakeClock that tests and simulations move forward at
+// will. Deadlines of network connections are enforced by the operating system and always use real time, as
+// do the request timeouts of the shared timer wheel.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time and schedules things to happen later.
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer delivers the time on C once it expires, like time.Timer.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Ticker delivers the time on C every period, like time.Ticker.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+	Reset(d time.Duration)
+}
+
+var (
+	current Clock = realClock{}
+	lock    sync.RWMutex
+)
+
+// Get returns the clock in use.
+func Get() Clock {
+	lock.RLock()
+	defer lock.RUnlock()
+	return current
+}
+
+// Set replaces the clock in use, nil restores real time. Timers and tickers already created keep running
+// on the clock they were created with.
+func Set(c Clock) {
+	lock.Lock()
+	defer lock.Unlock()
+	if c == nil {
+		c = realClock{}
+	}
+	current = c
+}
+
+// Reset restores real time.
+func Reset() {
+	Set(nil)
+}
+
+// Real returns a Clock that uses real time.
+func Real() Clock {
+	return realClock{}
+}
+
+// Now returns the current time of the clock in use.
+func Now() time.Time {
+	return Get().Now()
+}
+
+// Since returns the time elapsed since t on the clock in use.
+func Since(t time.Time) time.Duration {
+	return Get().Since(t)
+}
+
+// Sleep pauses the current goroutine for d on the clock in use.
+func Sleep(d time.Duration) {
+	Get().Sleep(d)
+}
+
+// After waits for d on the clock in use, then sends the current time on the returned channel.
+func After(d time.Duration) <-chan time.Time {
+	return Get().After(d)
+}
+
+// NewTimer creates a Timer on the clock in use.
+func NewTimer(d time.Duration) Timer {
+	return Get().NewTimer(d)
+}
+
+// NewTicker creates a Ticker on the clock in use.
+func NewTicker(d time.Duration) Ticker {
+	return Get().NewTicker(d)
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) Since(t time.Time) time.Duration        { return time.Since(t) }
+func (realClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) NewTimer(d time.Duration) Timer         { return &realTimer{time.NewTimer(d)} }
+func (realClock) NewTicker(d time.Duration) Ticker       { return &realTicker{time.NewTicker(d)} }
+
+type realTimer struct {
+	timer *time.Timer
+}
+
+func (t *realTimer) C() <-chan time.Time        { return t.timer.C }
+func (t *realTimer) Stop() bool                 { return t.timer.Stop() }
+func (t *realTimer) Reset(d time.Duration) bool { return t.timer.Reset(d) }
+
+type realTicker struct {
+	ticker *time.Ticker
+}
+
+func (t *realTicker) C() <-chan time.Time   { return t.ticker.C }
+func (t *realTicker) Stop()                 { t.ticker.Stop() }
+func (t *realTicker) Reset(d time.Duration) { t.ticker.Reset(d) }
@@ -0,0 +1,86 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var epoch = time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestSet(t *testing.T) {
+	fake := NewFakeClock(epoch)
+	Set(fake)
+	defer Reset()
+	assert.Equal(t, epoch, Now())
+	fake.Advance(time.Hour)
+	assert.Equal(t, time.Hour, Since(epoch))
+
+	Reset()
+	assert.WithinDuration(t, time.Now(), Now(), time.Second)
+}
+
+func TestFakeClock_Timers(t *testing.T) {
+	fake := NewFakeClock(epoch)
+	late := fake.NewTimer(2 * time.Minute)
+	early := fake.After(time.Minute)
+	stopped := fake.NewTimer(time.Minute)
+	assert.True(t, stopped.Stop())
+	assert.False(t, stopped.Stop())
+	assert.Equal(t, 2, fake.Waiters())
+
+	fake.Advance(59 * time.Second)
+	assert.Empty(t, early)
+	fake.Advance(2 * time.Minute)
+	assert.Equal(t, epoch.Add(time.Minute), <-early)
+	assert.Equal(t, epoch.Add(2*time.Minute), <-late.C())
+	assert.Empty(t, stopped.C())
+	assert.Equal(t, epoch.Add(179*time.Second), fake.Now())
+
+	assert.False(t, late.Reset(time.Second))
+	fake.Advance(time.Second)
+	assert.Equal(t, epoch.Add(180*time.Second), <-late.C())
+
+	// moving back fires nothing.
+	timer := fake.NewTimer(time.Second)
+	fake.Set(epoch)
+	assert.Empty(t, timer.C())
+	assert.Equal(t, epoch, fake.Now())
+}
+
+func TestFakeClock_Ticker(t *testing.T) {
+	fake := NewFakeClock(epoch)
+	ticker := fake.NewTicker(time.Second)
+	fake.Advance(time.Second)
+	assert.Equal(t, epoch.Add(time.Second), <-ticker.C())
+
+	// ticks that are not received are dropped.
+	fake.Advance(5 * time.Second)
+	assert.Equal(t, epoch.Add(2*time.Second), <-ticker.C())
+	assert.Empty(t, ticker.C())
+
+	ticker.Reset(time.Minute)
+	fake.Advance(59 * time.Second)
+	assert.Empty(t, ticker.C())
+	fake.Advance(time.Second)
+	assert.Equal(t, epoch.Add(66*time.Second), <-ticker.C())
+
+	ticker.Stop()
+	assert.Zero(t, fake.Waiters())
+}
+
+func TestFakeClock_Sleep(t *testing.T) {
+	fake := NewFakeClock(epoch)
+	woke := make(chan time.Time)
+	go func() {
+		fake.Sleep(time.Hour)
+		woke <- fake.Now()
+	}()
+	fake.BlockUntil(1)
+	fake.Advance(2 * time.Hour)
+	assert.Equal(t, epoch.Add(2*time.Hour), <-woke)
+}
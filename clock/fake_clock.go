@@ -0,0 +1,192 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// FakeClock is a Clock that only moves when told to, so tests and simulations are deterministic. Timers,
+// tickers and sleepers fire as Advance or Set move the clock past their deadlines, in deadline order. Like
+// real tickers, a ticker whose tick has not been received drops the ticks that follow.
+type FakeClock struct {
+	now     time.Time
+	waiters []*fakeWaiter
+	changed chan struct{}
+	lock    sync.Mutex
+}
+
+// fakeWaiter is a timer, ticker or sleeper waiting for the clock to reach its deadline. A period is set
+// for tickers.
+type fakeWaiter struct {
+	clock    *FakeClock
+	deadline time.Time
+	period   time.Duration
+	c        chan time.Time
+}
+
+// NewFakeClock creates a FakeClock showing start.
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start, changed: make(chan struct{})}
+}
+
+func (f *FakeClock) Now() time.Time {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.now
+}
+
+func (f *FakeClock) Since(t time.Time) time.Duration {
+	return f.Now().Sub(t)
+}
+
+// Sleep blocks until the clock has been moved forward by d.
+func (f *FakeClock) Sleep(d time.Duration) {
+	<-f.After(d)
+}
+
+func (f *FakeClock) After(d time.Duration) <-chan time.Time {
+	return f.NewTimer(d).C()
+}
+
+func (f *FakeClock) NewTimer(d time.Duration) Timer {
+	w := &fakeWaiter{clock: f, c: make(chan time.Time, 1)}
+	f.schedule(w, d, 0)
+	return &fakeTimer{w}
+}
+
+func (f *FakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	w := &fakeWaiter{clock: f, c: make(chan time.Time, 1)}
+	f.schedule(w, d, d)
+	return &fakeTicker{w}
+}
+
+// Advance moves the clock forward by d, firing everything due on the way.
+func (f *FakeClock) Advance(d time.Duration) {
+	f.Set(f.Now().Add(d))
+}
+
+// Set moves the clock to t. Moving forward fires everything due by t, moving back fires nothing.
+func (f *FakeClock) Set(t time.Time) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for {
+		if len(f.waiters) == 0 || f.waiters[0].deadline.After(t) {
+			break
+		}
+		w := f.waiters[0]
+		f.waiters = f.waiters[1:]
+		if w.deadline.After(f.now) {
+			f.now = w.deadline
+		}
+		select {
+		case w.c <- f.now:
+		default:
+		}
+		if w.period > 0 {
+			w.deadline = w.deadline.Add(w.period)
+			f.insert(w)
+		}
+	}
+	f.now = t
+}
+
+// Waiters returns the number of timers, tickers and sleepers waiting on the clock.
+func (f *FakeClock) Waiters() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return len(f.waiters)
+}
+
+// BlockUntil blocks until at least n timers, tickers or sleepers are waiting on the clock, so a test can
+// be sure the code under test is waiting before moving the clock.
+func (f *FakeClock) BlockUntil(n int) {
+	for {
+		f.lock.Lock()
+		waiting, changed := len(f.waiters), f.changed
+		f.lock.Unlock()
+		if waiting >= n {
+			return
+		}
+		<-changed
+	}
+}
+
+// schedule makes a waiter due after d, and every period after that if set. A timer is fired straight away
+// if d is not positive. Returns true if the waiter was still waiting.
+func (f *FakeClock) schedule(w *fakeWaiter, d, period time.Duration) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	active := f.remove(w)
+	w.deadline = f.now.Add(d)
+	w.period = period
+	if d <= 0 && w.period == 0 {
+		select {
+		case w.c <- f.now:
+		default:
+		}
+		return active
+	}
+	f.insert(w)
+	return active
+}
+
+// insert adds a waiter in deadline order, the lock must be held.
+func (f *FakeClock) insert(w *fakeWaiter) {
+	i := sort.Search(len(f.waiters), func(i int) bool {
+		return f.waiters[i].deadline.After(w.deadline)
+	})
+	f.waiters = append(f.waiters, nil)
+	copy(f.waiters[i+1:], f.waiters[i:])
+	f.waiters[i] = w
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// remove takes a waiter off the clock, the lock must be held. Returns true if it was waiting.
+func (f *FakeClock) remove(w *fakeWaiter) bool {
+	for i, waiting := range f.waiters {
+		if waiting == w {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (w *fakeWaiter) stop() bool {
+	w.clock.lock.Lock()
+	defer w.clock.lock.Unlock()
+	return w.clock.remove(w)
+}
+
+type fakeTimer struct {
+	w *fakeWaiter
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.w.c }
+func (t *fakeTimer) Stop() bool          { return t.w.stop() }
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	return t.w.clock.schedule(t.w, d, 0)
+}
+
+type fakeTicker struct {
+	w *fakeWaiter
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.w.c }
+func (t *fakeTicker) Stop()               { t.w.stop() }
+
+func (t *fakeTicker) Reset(d time.Duration) {
+	if d <= 0 {
+		panic("non-positive interval for Ticker.Reset")
+	}
+	t.w.clock.schedule(t.w, d, d)
+}
@@ -0,0 +1,230 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package clock
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Wheel schedules timeouts on a hashed timer wheel: a ring of slots a single ticker walks through, firing
+// the timeouts of each slot it reaches. Scheduling and stopping a timeout are constant time whatever the
+// number pending, and the entries of timeouts that fired or were stopped are reused, so tens of thousands
+// of requests waiting at once do not churn runtime timers. Timeouts fire on the first tick at or after
+// their deadline, at most a tick late. The ticker only runs while timeouts are pending.
+type Wheel struct {
+	clock   Clock
+	tick    time.Duration
+	slots   []*wheelEntry // every slot is a doubly linked list of entries
+	free    *wheelEntry   // entries ready for reuse
+	cursor  int           // slot of the last tick
+	ticks   int64         // ticks walked since the ticker started
+	started time.Time
+	pending int
+	running bool
+	lock    sync.Mutex
+}
+
+// wheelEntry is a timeout waiting in a slot. rounds counts the turns of the wheel left before it fires,
+// gen tells a reused entry from the timeout it held before.
+type wheelEntry struct {
+	expirer    expirer
+	slot       int
+	rounds     int64
+	gen        uint64
+	prev, next *wheelEntry
+}
+
+// expirer is told when its timeout expires, contexts of the wheel are told without a closure to allocate.
+type expirer interface {
+	expire()
+}
+
+type expireFunc func()
+
+func (f expireFunc) expire() { f() }
+
+// WheelTimer is a timeout scheduled on a Wheel.
+type WheelTimer struct {
+	wheel *Wheel
+	entry *wheelEntry
+	gen   uint64
+}
+
+// the wheel shared by the timeouts of requests, a turn takes a little over 10 seconds.
+var timeouts = NewWheel(Real(), 10*time.Millisecond, 1024)
+
+// NewWheel creates a Wheel of slots, ticking every tick on c.
+func NewWheel(c Clock, tick time.Duration, slots int) *Wheel {
+	if tick <= 0 || slots <= 0 {
+		panic("non-positive tick or slot count for NewWheel")
+	}
+	return &Wheel{clock: c, tick: tick, slots: make([]*wheelEntry, slots)}
+}
+
+// AfterFunc calls f on the goroutine of the wheel once d has elapsed, f must not block. Returns the timer
+// that stops the call.
+func (w *Wheel) AfterFunc(d time.Duration, f func()) WheelTimer {
+	return w.schedule(d, expireFunc(f))
+}
+
+// schedule tells x once d has elapsed.
+func (w *Wheel) schedule(d time.Duration, x expirer) WheelTimer {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if !w.running {
+		w.running, w.started, w.ticks = true, w.clock.Now(), 0
+		go w.run(w.started)
+	}
+
+	// the number of ticks from the last one walked to the first at or after the deadline
+	ticks := int64((w.clock.Since(w.started)+d+w.tick-1)/w.tick) - w.ticks
+	if ticks < 1 {
+		ticks = 1
+	}
+	e := w.free
+	if e != nil {
+		w.free = e.next
+	} else {
+		e = &wheelEntry{}
+	}
+	e.expirer, e.rounds = x, (ticks-1)/int64(len(w.slots))
+	e.slot = int((int64(w.cursor) + ticks) % int64(len(w.slots)))
+	e.prev, e.next = nil, w.slots[e.slot]
+	if e.next != nil {
+		e.next.prev = e
+	}
+	w.slots[e.slot] = e
+	w.pending++
+	return WheelTimer{wheel: w, entry: e, gen: e.gen}
+}
+
+// Pending returns the number of timeouts waiting on the wheel.
+func (w *Wheel) Pending() int {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.pending
+}
+
+// Stop prevents the timer from firing. Returns false if it already fired or was stopped.
+func (t WheelTimer) Stop() bool {
+	if t.wheel == nil {
+		return false
+	}
+	w := t.wheel
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if t.entry.gen != t.gen {
+		return false
+	}
+	w.remove(t.entry)
+	return true
+}
+
+// remove takes an entry out of its slot and keeps it for reuse, the lock must be held.
+func (w *Wheel) remove(e *wheelEntry) {
+	if e.prev != nil {
+		e.prev.next = e.next
+	} else {
+		w.slots[e.slot] = e.next
+	}
+	if e.next != nil {
+		e.next.prev = e.prev
+	}
+	e.expirer, e.prev, e.next = nil, nil, w.free
+	e.gen++
+	w.free = e
+	w.pending--
+}
+
+// run walks the wheel every tick until no timeout is pending. Ticks the ticker dropped are caught up
+// with, so a slow receiver does not delay timeouts further.
+func (w *Wheel) run(started time.Time) {
+	ticker := w.clock.NewTicker(w.tick)
+	defer ticker.Stop()
+	var expired []expirer
+	for range ticker.C() {
+		due := int64(w.clock.Since(started) / w.tick)
+		w.lock.Lock()
+		for w.ticks < due {
+			w.ticks++
+			w.cursor = (w.cursor + 1) % len(w.slots)
+			for e := w.slots[w.cursor]; e != nil; {
+				next := e.next
+				if e.rounds > 0 {
+					e.rounds--
+				} else {
+					expired = append(expired, e.expirer)
+					w.remove(e)
+				}
+				e = next
+			}
+		}
+		idle := w.pending == 0
+		if idle {
+			w.running = false
+		}
+		w.lock.Unlock()
+
+		for _, x := range expired {
+			x.expire()
+		}
+		clear(expired)
+		expired = expired[:0]
+		if idle {
+			return
+		}
+	}
+}
+
+// WithTimeout is context.WithTimeout with the deadline kept on the wheel rather than a runtime timer. Once
+// the timeout expires, Err of the context returns context.DeadlineExceeded, and contexts derived from it
+// are cancelled with context.DeadlineExceeded as their cause.
+func (w *Wheel) WithTimeout(parent context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	deadline := w.clock.Now().Add(d)
+	if current, ok := parent.Deadline(); ok && current.Before(deadline) {
+		// the parent times out first, as context.WithTimeout does
+		return context.WithCancel(parent)
+	}
+	inner, cancel := context.WithCancelCause(parent)
+	ctx := &timeoutCtx{Context: inner, cancel: cancel, deadline: deadline}
+	ctx.timer = w.schedule(d, ctx)
+	return ctx, func() {
+		ctx.timer.Stop()
+		ctx.cancel(context.Canceled)
+	}
+}
+
+// timeoutCtx reports the deadline of a context cancelled by a wheel, and that it expired once it has.
+type timeoutCtx struct {
+	context.Context
+	cancel   context.CancelCauseFunc
+	deadline time.Time
+	timer    WheelTimer
+}
+
+func (c *timeoutCtx) expire() {
+	c.cancel(context.DeadlineExceeded)
+}
+
+func (c *timeoutCtx) Deadline() (time.Time, bool) {
+	return c.deadline, true
+}
+
+func (c *timeoutCtx) Err() error {
+	err := c.Context.Err()
+	if err != nil && errors.Is(context.Cause(c.Context), context.DeadlineExceeded) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+// WithTimeout is context.WithTimeout on the wheel shared by the timeouts of requests across ranch, for
+// the many short lived deadlines of bridged requests. Like deadlines of network connections, the shared
+// wheel always uses real time.
+func WithTimeout(parent context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	return timeouts.WithTimeout(parent, d)
+}
@@ -0,0 +1,154 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package clock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWheel_AfterFunc(t *testing.T) {
+	fake := NewFakeClock(epoch)
+	wheel := NewWheel(fake, time.Second, 4)
+	fired := make(chan string, 10)
+	wheel.AfterFunc(2500*time.Millisecond, func() { fired <- "soon" })
+	wheel.AfterFunc(9*time.Second, func() { fired <- "after two turns" })
+	wheel.AfterFunc(2*time.Second, func() { fired <- "probe" })
+	assert.Equal(t, 3, wheel.Pending())
+
+	// timeouts fire on the first tick at or after their deadline, the ticks dropped are caught up with
+	fake.BlockUntil(1)
+	fake.Advance(2 * time.Second)
+	assert.Equal(t, "probe", <-fired)
+	fake.Advance(time.Second)
+	assert.Equal(t, "soon", <-fired)
+
+	wheel.AfterFunc(2*time.Second, func() { fired <- "probe" })
+	fake.Advance(5 * time.Second)
+	assert.Equal(t, "probe", <-fired)
+	assert.Empty(t, fired)
+	fake.Advance(time.Second)
+	assert.Equal(t, "after two turns", <-fired)
+
+	// the ticker stops once nothing is pending
+	assert.Eventually(t, func() bool { return fake.Waiters() == 0 }, time.Second, time.Millisecond)
+	assert.Zero(t, wheel.Pending())
+}
+
+func TestWheel_Stop(t *testing.T) {
+	fake := NewFakeClock(epoch)
+	wheel := NewWheel(fake, time.Second, 4)
+	fired := make(chan string, 10)
+	stopped := wheel.AfterFunc(time.Second, func() { fired <- "stopped" })
+	assert.True(t, stopped.Stop())
+	assert.False(t, stopped.Stop())
+	assert.False(t, WheelTimer{}.Stop())
+
+	// the entry of the stopped timer is reused, stopping it again leaves the new timer alone
+	wheel.AfterFunc(time.Second, func() { fired <- "kept" })
+	assert.False(t, stopped.Stop())
+	fake.BlockUntil(1)
+	fake.Advance(time.Second)
+	assert.Equal(t, "kept", <-fired)
+	assert.Empty(t, fired)
+}
+
+func TestWheel_WithTimeout(t *testing.T) {
+	fake := NewFakeClock(epoch)
+	wheel := NewWheel(fake, time.Second, 4)
+
+	ctx, cancel := wheel.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	deadline, ok := ctx.Deadline()
+	assert.True(t, ok)
+	assert.Equal(t, epoch.Add(2*time.Second), deadline)
+	child, cancelChild := context.WithCancel(ctx)
+	defer cancelChild()
+	assert.NoError(t, ctx.Err())
+
+	fake.BlockUntil(1)
+	fake.Advance(2 * time.Second)
+	<-ctx.Done()
+	assert.ErrorIs(t, ctx.Err(), context.DeadlineExceeded)
+	<-child.Done()
+	assert.ErrorIs(t, context.Cause(child), context.DeadlineExceeded)
+
+	// cancelling stops the timeout
+	ctx, cancel = wheel.WithTimeout(context.Background(), time.Hour)
+	assert.Equal(t, 1, wheel.Pending())
+	cancel()
+	assert.ErrorIs(t, ctx.Err(), context.Canceled)
+	assert.Zero(t, wheel.Pending())
+
+	// a parent timing out first is left to do so
+	parent, cancelParent := wheel.WithTimeout(context.Background(), time.Second)
+	defer cancelParent()
+	ctx, cancel = wheel.WithTimeout(parent, time.Hour)
+	defer cancel()
+	deadline, _ = ctx.Deadline()
+	assert.Equal(t, epoch.Add(3*time.Second), deadline)
+	assert.Equal(t, 1, wheel.Pending())
+}
+
+// pendingTimeouts keeps n timeouts waiting, as the requests in flight on a busy server would.
+func pendingTimeouts(b *testing.B, n int, withTimeout func(context.Context, time.Duration) (context.Context, context.CancelFunc)) {
+	cancels := make([]context.CancelFunc, n)
+	for i := range cancels {
+		_, cancels[i] = withTimeout(context.Background(), time.Minute)
+	}
+	b.Cleanup(func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	})
+}
+
+func BenchmarkWithTimeout_Wheel(b *testing.B) {
+	wheel := NewWheel(Real(), 10*time.Millisecond, 1024)
+	pendingTimeouts(b, 50000, wheel.WithTimeout)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_, cancel := wheel.WithTimeout(context.Background(), 5*time.Second)
+			cancel()
+		}
+	})
+}
+
+func BenchmarkWithTimeout_Context(b *testing.B) {
+	pendingTimeouts(b, 50000, context.WithTimeout)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			cancel()
+		}
+	})
+}
+
+func BenchmarkAfterFunc_Wheel(b *testing.B) {
+	wheel := NewWheel(Real(), 10*time.Millisecond, 1024)
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		f := func() {}
+		for pb.Next() {
+			wheel.AfterFunc(5*time.Second, f).Stop()
+		}
+	})
+}
+
+func BenchmarkAfterFunc_Runtime(b *testing.B) {
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		f := func() {}
+		for pb.Next() {
+			time.AfterFunc(5*time.Second, f).Stop()
+		}
+	})
+}
@@ -0,0 +1,60 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+// Command ranch generates the scaffolding of fabric services.
+//
+//	ranch gen service --name stock --channel stock-service [--package services] [--dir .] [--force]
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pb33f/ranch/plank/pkg/scaffold"
+	"github.com/spf13/pflag"
+)
+
+const usage = `usage: ranch gen service --name <name> [--channel <channel>] [--package <package>] [--dir <dir>] [--force]`
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, out io.Writer) error {
+	if len(args) < 2 || args[0] != "gen" || args[1] != "service" {
+		return errors.New(usage)
+	}
+
+	flags := pflag.NewFlagSet("ranch gen service", pflag.ContinueOnError)
+	flags.SetOutput(out)
+	var opts scaffold.ServiceOptions
+	flags.StringVar(&opts.Name, "name", "", "name of the service, e.g. stock")
+	flags.StringVar(&opts.Channel, "channel", "", "channel the service listens to, <name>-service by default")
+	flags.StringVar(&opts.Package, "package", "services", "Go package of the generated files")
+	dir := flags.String("dir", ".", "directory the files are written to")
+	force := flags.Bool("force", false, "overwrite existing files")
+	if err := flags.Parse(args[2:]); err != nil {
+		return err
+	}
+	if opts.Name == "" {
+		return fmt.Errorf("--name is required\n%s", usage)
+	}
+
+	files, err := scaffold.GenerateService(opts)
+	if err != nil {
+		return err
+	}
+	if err := scaffold.WriteFiles(*dir, files, *force); err != nil {
+		return err
+	}
+	for _, f := range files {
+		fmt.Fprintf(out, "created %s\n", filepath.Join(*dir, f.Name))
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package connectors
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+const (
+	CodecJSON   = "json"   // payloads are JSON documents, decoded into generic maps and slices
+	CodecString = "string" // payloads are UTF-8 text, decoded into a string
+	CodecBytes  = "bytes"  // payloads are passed through untouched as []byte
+)
+
+// Codec converts bus message payloads to and from the bytes carried by an external system.
+type Codec interface {
+	Encode(payload interface{}) ([]byte, error)
+	Decode(data []byte) (interface{}, error)
+}
+
+var (
+	codecs = map[string]Codec{
+		CodecJSON:   jsonCodec{},
+		CodecString: stringCodec{},
+		CodecBytes:  bytesCodec{},
+	}
+	codecsLock sync.RWMutex
+)
+
+// RegisterCodec makes a codec available under name, replacing any existing registration.
+func RegisterCodec(name string, codec Codec) {
+	codecsLock.Lock()
+	defer codecsLock.Unlock()
+	codecs[name] = codec
+}
+
+// GetCodec returns the named codec. An empty name returns the JSON codec.
+func GetCodec(name string) (Codec, error) {
+	if name == "" {
+		name = CodecJSON
+	}
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+	codec, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown codec '%s'", name)
+	}
+	return codec, nil
+}
+
+type jsonCodec struct{}
+
+// Encode marshals the payload, byte slices are assumed to already hold JSON and are passed through.
+func (jsonCodec) Encode(payload interface{}) ([]byte, error) {
+	switch p := payload.(type) {
+	case []byte:
+		return p, nil
+	case json.RawMessage:
+		return p, nil
+	}
+	return json.Marshal(payload)
+}
+
+func (jsonCodec) Decode(data []byte) (interface{}, error) {
+	var payload interface{}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+type stringCodec struct{}
+
+func (stringCodec) Encode(payload interface{}) ([]byte, error) {
+	switch p := payload.(type) {
+	case string:
+		return []byte(p), nil
+	case []byte:
+		return p, nil
+	case fmt.Stringer:
+		return []byte(p.String()), nil
+	}
+	return nil, fmt.Errorf("string codec cannot encode payload of type %T", payload)
+}
+
+func (stringCodec) Decode(data []byte) (interface{}, error) {
+	return string(data), nil
+}
+
+type bytesCodec struct{}
+
+func (bytesCodec) Encode(payload interface{}) ([]byte, error) {
+	switch p := payload.(type) {
+	case []byte:
+		return p, nil
+	case string:
+		return []byte(p), nil
+	}
+	return nil, fmt.Errorf("bytes codec cannot encode payload of type %T", payload)
+}
+
+func (bytesCodec) Decode(data []byte) (interface{}, error) {
+	return data, nil
+}
@@ -0,0 +1,55 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package connectors
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodecs(t *testing.T) {
+	codec, err := GetCodec("")
+	assert.NoError(t, err)
+	encoded, err := codec.Encode(map[string]string{"name": "ranch"})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(`{"name":"ranch"}`), encoded)
+	encoded, _ = codec.Encode(json.RawMessage(`[1]`))
+	assert.Equal(t, []byte(`[1]`), encoded)
+	decoded, err := codec.Decode([]byte(`[1,2]`))
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{float64(1), float64(2)}, decoded)
+	_, err = codec.Decode([]byte(`{`))
+	assert.Error(t, err)
+
+	codec, _ = GetCodec(CodecString)
+	encoded, _ = codec.Encode("hello")
+	assert.Equal(t, []byte("hello"), encoded)
+	_, err = codec.Encode(42)
+	assert.Error(t, err)
+	decoded, _ = codec.Decode([]byte("hello"))
+	assert.Equal(t, "hello", decoded)
+
+	codec, _ = GetCodec(CodecBytes)
+	decoded, _ = codec.Decode([]byte{0x1, 0x2})
+	assert.Equal(t, []byte{0x1, 0x2}, decoded)
+	_, err = codec.Encode(map[string]string{})
+	assert.Error(t, err)
+}
+
+func TestRegisterCodec(t *testing.T) {
+	_, err := GetCodec("upper")
+	assert.ErrorContains(t, err, "unknown codec")
+
+	RegisterCodec("upper", stringCodec{})
+	defer func() {
+		codecsLock.Lock()
+		delete(codecs, "upper")
+		codecsLock.Unlock()
+	}()
+	codec, err := GetCodec("upper")
+	assert.NoError(t, err)
+	assert.IsType(t, stringCodec{}, codec)
+}
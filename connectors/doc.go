@@ -0,0 +1,11 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+/*
+Package connectors pumps messages between bus channels and external streaming systems.
+
+Sources consume from an external system and deliver each record to a channel as a response message, sinks
+listen for request messages on a channel and publish them to the external system. Using different message
+directions means a source and a sink can share a channel without echoing messages back and forth.
+*/
+package connectors
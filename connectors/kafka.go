@@ -0,0 +1,386 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package connectors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/clock"
+	"github.com/pb33f/ranch/log"
+	"github.com/pb33f/ranch/model"
+)
+
+const defaultKafkaRetryDelay = 5 * time.Second
+
+// KafkaRecord is a single record consumed from, or produced to, a Kafka topic.
+type KafkaRecord struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Headers   map[string]string
+	Timestamp time.Time
+}
+
+// TopicPartition identifies a partition of a topic.
+type TopicPartition struct {
+	Topic     string
+	Partition int32
+}
+
+type StartOffset int
+
+const (
+	OffsetLatest   StartOffset = iota // only consume records produced after the group first joins
+	OffsetEarliest                    // consume from the oldest retained record
+)
+
+// ConsumerGroupConfig configures how a source joins a Kafka consumer group.
+type ConsumerGroupConfig struct {
+	Brokers        []string      // bootstrap broker addresses (host:port)
+	GroupId        string        // consumer group to join, offsets are committed against it
+	Topics         []string      // topics to consume
+	StartOffset    StartOffset   // where to start when the group has no committed offset for a partition
+	SessionTimeout time.Duration // how long the group waits before rebalancing a silent member, 0 uses the client default
+}
+
+// KafkaConsumer is a member of a consumer group. Implementations wrap a Kafka client library.
+type KafkaConsumer interface {
+	// Poll blocks until records are available or ctx is done.
+	Poll(ctx context.Context) ([]*KafkaRecord, error)
+	// CommitOffsets commits the next offset to consume for each partition.
+	CommitOffsets(ctx context.Context, offsets map[TopicPartition]int64) error
+	Close() error
+}
+
+// KafkaProducer publishes records to Kafka. Implementations wrap a Kafka client library.
+type KafkaProducer interface {
+	Produce(ctx context.Context, record *KafkaRecord) error
+	Close() error
+}
+
+// KafkaClient creates consumers and producers. NewFranzKafkaClient returns one backed by franz-go, adapt
+// another client library (sarama, confluent-kafka-go etc.) to this interface if your pipelines use it.
+type KafkaClient interface {
+	NewConsumer(config *ConsumerGroupConfig) (KafkaConsumer, error)
+	NewProducer(brokers []string) (KafkaProducer, error)
+}
+
+// KafkaSourceConfig configures a KafkaSource.
+type KafkaSourceConfig struct {
+	Client         KafkaClient         // client used to join the consumer group
+	ConsumerGroup  ConsumerGroupConfig // consumer group to join
+	Channel        string              // bus channel records are delivered to
+	Codec          string              // codec used to decode record values, defaults to CodecJSON
+	CommitInterval time.Duration       // how often offsets are committed, 0 commits after every poll
+	Logger         *slog.Logger        // defaults to log.Logger()
+}
+
+// KafkaSource consumes records from Kafka topics and delivers them to a bus channel as response messages.
+// Offsets are only committed once a record has been handed to the bus, so records are delivered at least once.
+type KafkaSource struct {
+	config     KafkaSourceConfig
+	eventBus   bus.EventBus
+	codec      Codec
+	logger     *slog.Logger
+	consumer   KafkaConsumer
+	pending    map[TopicPartition]int64
+	cancel     context.CancelFunc
+	done       chan struct{}
+	retryDelay time.Duration
+	lock       sync.Mutex
+}
+
+// NewKafkaSource validates the config and creates a source that delivers to eventBus once started.
+func NewKafkaSource(eventBus bus.EventBus, config *KafkaSourceConfig) (*KafkaSource, error) {
+	if config == nil {
+		return nil, fmt.Errorf("kafka source config is nil")
+	}
+	if config.Client == nil {
+		return nil, fmt.Errorf("kafka source for channel '%s' has no client", config.Channel)
+	}
+	if config.Channel == "" {
+		return nil, fmt.Errorf("kafka source has no channel")
+	}
+	if config.ConsumerGroup.GroupId == "" {
+		return nil, fmt.Errorf("kafka source for channel '%s' has no consumer group", config.Channel)
+	}
+	if len(config.ConsumerGroup.Topics) == 0 {
+		return nil, fmt.Errorf("kafka source for channel '%s' has no topics", config.Channel)
+	}
+	codec, err := GetCodec(config.Codec)
+	if err != nil {
+		return nil, err
+	}
+	logger := config.Logger
+	if logger == nil {
+		logger = log.Logger()
+	}
+	return &KafkaSource{
+		config:     *config,
+		eventBus:   eventBus,
+		codec:      codec,
+		logger:     logger,
+		pending:    make(map[TopicPartition]int64),
+		retryDelay: defaultKafkaRetryDelay,
+	}, nil
+}
+
+// Start joins the consumer group and begins delivering records to the channel.
+func (ks *KafkaSource) Start() error {
+	ks.lock.Lock()
+	defer ks.lock.Unlock()
+	if ks.consumer != nil {
+		return fmt.Errorf("kafka source for channel '%s' is already running", ks.config.Channel)
+	}
+	cm := ks.eventBus.GetChannelManager()
+	if !cm.CheckChannelExists(ks.config.Channel) {
+		cm.CreateChannel(ks.config.Channel)
+	}
+	consumer, err := ks.config.Client.NewConsumer(&ks.config.ConsumerGroup)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ks.consumer = consumer
+	ks.cancel = cancel
+	ks.done = make(chan struct{})
+	go ks.run(ctx, consumer, ks.done)
+	return nil
+}
+
+// Stop stops consuming, commits any outstanding offsets and leaves the consumer group.
+func (ks *KafkaSource) Stop() error {
+	ks.lock.Lock()
+	consumer, cancel, done := ks.consumer, ks.cancel, ks.done
+	ks.consumer = nil
+	ks.lock.Unlock()
+	if consumer == nil {
+		return fmt.Errorf("kafka source for channel '%s' is not running", ks.config.Channel)
+	}
+	cancel()
+	<-done
+	commitErr := ks.commit(context.Background(), consumer)
+	return errors.Join(commitErr, consumer.Close())
+}
+
+func (ks *KafkaSource) run(ctx context.Context, consumer KafkaConsumer, done chan struct{}) {
+	defer close(done)
+	var ticker <-chan time.Time
+	if ks.config.CommitInterval > 0 {
+		t := clock.NewTicker(ks.config.CommitInterval)
+		defer t.Stop()
+		ticker = t.C()
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker:
+			if err := ks.commit(ctx, consumer); err != nil && ctx.Err() == nil {
+				ks.logger.Error("[ranch] kafka source unable to commit offsets", "channel", ks.config.Channel,
+					"group", ks.config.ConsumerGroup.GroupId, "error", err.Error())
+			}
+		default:
+		}
+
+		records, err := consumer.Poll(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			ks.logger.Warn("[ranch] kafka source poll failed, retrying", "channel", ks.config.Channel,
+				"group", ks.config.ConsumerGroup.GroupId, "error", err.Error())
+			select {
+			case <-ctx.Done():
+				return
+			case <-clock.After(ks.retryDelay):
+			}
+			continue
+		}
+		for _, record := range records {
+			ks.deliver(record)
+		}
+		if ks.config.CommitInterval <= 0 && len(records) > 0 {
+			if err = ks.commit(ctx, consumer); err != nil && ctx.Err() == nil {
+				ks.logger.Error("[ranch] kafka source unable to commit offsets", "channel", ks.config.Channel,
+					"group", ks.config.ConsumerGroup.GroupId, "error", err.Error())
+			}
+		}
+	}
+}
+
+// deliver sends a record to the channel and marks it as processed. Records that cannot be decoded are
+// reported as error messages on the channel and skipped, so a bad record cannot stall the partition.
+func (ks *KafkaSource) deliver(record *KafkaRecord) {
+	payload, err := ks.codec.Decode(record.Value)
+	if err != nil {
+		ks.logger.Warn("[ranch] kafka source unable to decode record", "channel", ks.config.Channel,
+			"topic", record.Topic, "partition", record.Partition, "offset", record.Offset, "error", err.Error())
+		_ = ks.eventBus.SendErrorMessage(ks.config.Channel,
+			fmt.Errorf("unable to decode record %s/%d@%d: %w", record.Topic, record.Partition, record.Offset, err), nil)
+	} else if err = ks.eventBus.SendResponseMessage(ks.config.Channel, payload, nil); err != nil {
+		ks.logger.Error("[ranch] kafka source unable to deliver record", "channel", ks.config.Channel,
+			"topic", record.Topic, "partition", record.Partition, "offset", record.Offset, "error", err.Error())
+	}
+	ks.lock.Lock()
+	ks.pending[TopicPartition{Topic: record.Topic, Partition: record.Partition}] = record.Offset + 1
+	ks.lock.Unlock()
+}
+
+// commit commits the offsets of every record delivered since the last commit.
+func (ks *KafkaSource) commit(ctx context.Context, consumer KafkaConsumer) error {
+	ks.lock.Lock()
+	if len(ks.pending) == 0 {
+		ks.lock.Unlock()
+		return nil
+	}
+	offsets := ks.pending
+	ks.pending = make(map[TopicPartition]int64)
+	ks.lock.Unlock()
+
+	if err := consumer.CommitOffsets(ctx, offsets); err != nil {
+		// put the offsets back unless newer ones have been recorded in the meantime.
+		ks.lock.Lock()
+		for tp, offset := range offsets {
+			if _, ok := ks.pending[tp]; !ok {
+				ks.pending[tp] = offset
+			}
+		}
+		ks.lock.Unlock()
+		return err
+	}
+	return nil
+}
+
+// KafkaSinkConfig configures a KafkaSink.
+type KafkaSinkConfig struct {
+	Client  KafkaClient                     // client used to create the producer
+	Brokers []string                        // bootstrap broker addresses (host:port)
+	Channel string                          // bus channel to listen to for requests
+	Topic   string                          // topic messages are produced to
+	Codec   string                          // codec used to encode payloads, defaults to CodecJSON
+	Key     func(msg *model.Message) []byte // optional record key, used by Kafka for partitioning
+	Logger  *slog.Logger                    // defaults to log.Logger()
+}
+
+// KafkaSink listens for request messages on a bus channel and produces them to a Kafka topic. Message
+// headers are carried over as record headers.
+type KafkaSink struct {
+	config   KafkaSinkConfig
+	eventBus bus.EventBus
+	codec    Codec
+	logger   *slog.Logger
+	producer KafkaProducer
+	handler  bus.MessageHandler
+	lock     sync.Mutex
+}
+
+// NewKafkaSink validates the config and creates a sink that listens to eventBus once started.
+func NewKafkaSink(eventBus bus.EventBus, config *KafkaSinkConfig) (*KafkaSink, error) {
+	if config == nil {
+		return nil, fmt.Errorf("kafka sink config is nil")
+	}
+	if config.Client == nil {
+		return nil, fmt.Errorf("kafka sink for channel '%s' has no client", config.Channel)
+	}
+	if config.Channel == "" {
+		return nil, fmt.Errorf("kafka sink has no channel")
+	}
+	if config.Topic == "" {
+		return nil, fmt.Errorf("kafka sink for channel '%s' has no topic", config.Channel)
+	}
+	codec, err := GetCodec(config.Codec)
+	if err != nil {
+		return nil, err
+	}
+	logger := config.Logger
+	if logger == nil {
+		logger = log.Logger()
+	}
+	return &KafkaSink{
+		config:   *config,
+		eventBus: eventBus,
+		codec:    codec,
+		logger:   logger,
+	}, nil
+}
+
+// Start creates the producer and begins relaying requests from the channel.
+func (sink *KafkaSink) Start() error {
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	if sink.producer != nil {
+		return fmt.Errorf("kafka sink for channel '%s' is already running", sink.config.Channel)
+	}
+	cm := sink.eventBus.GetChannelManager()
+	if !cm.CheckChannelExists(sink.config.Channel) {
+		cm.CreateChannel(sink.config.Channel)
+	}
+	producer, err := sink.config.Client.NewProducer(sink.config.Brokers)
+	if err != nil {
+		return err
+	}
+	handler, err := sink.eventBus.ListenRequestStream(sink.config.Channel)
+	if err != nil {
+		_ = producer.Close()
+		return err
+	}
+	handler.Handle(func(msg *model.Message) {
+		sink.produce(producer, msg)
+	}, func(err error) {
+		sink.logger.Error("[ranch] kafka sink request stream failed", "channel", sink.config.Channel,
+			"error", err.Error())
+	})
+	sink.producer = producer
+	sink.handler = handler
+	return nil
+}
+
+// Stop stops listening to the channel and closes the producer.
+func (sink *KafkaSink) Stop() error {
+	sink.lock.Lock()
+	producer, handler := sink.producer, sink.handler
+	sink.producer, sink.handler = nil, nil
+	sink.lock.Unlock()
+	if producer == nil {
+		return fmt.Errorf("kafka sink for channel '%s' is not running", sink.config.Channel)
+	}
+	handler.Close()
+	return producer.Close()
+}
+
+func (sink *KafkaSink) produce(producer KafkaProducer, msg *model.Message) {
+	value, err := sink.codec.Encode(msg.Payload)
+	if err != nil {
+		sink.logger.Warn("[ranch] kafka sink unable to encode message", "channel", sink.config.Channel,
+			"topic", sink.config.Topic, "error", err.Error())
+		return
+	}
+	record := &KafkaRecord{
+		Topic:     sink.config.Topic,
+		Value:     value,
+		Timestamp: clock.Now(),
+	}
+	if sink.config.Key != nil {
+		record.Key = sink.config.Key(msg)
+	}
+	if len(msg.Headers) > 0 {
+		record.Headers = make(map[string]string, len(msg.Headers))
+		for _, header := range msg.Headers {
+			record.Headers[header.Label] = header.Value
+		}
+	}
+	if err = producer.Produce(context.Background(), record); err != nil {
+		sink.logger.Error("[ranch] kafka sink unable to produce record", "channel", sink.config.Channel,
+			"topic", sink.config.Topic, "error", err.Error())
+	}
+}
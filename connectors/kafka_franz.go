@@ -0,0 +1,163 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package connectors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// franzKafkaClient is a KafkaClient backed by the franz-go client.
+type franzKafkaClient struct {
+	opts []kgo.Opt
+}
+
+// NewFranzKafkaClient returns a KafkaClient backed by franz-go. The options are added to every client it
+// creates, use them for TLS (kgo.DialTLSConfig), SASL (kgo.SASL), client ids and the like. Brokers, groups,
+// topics and offset handling are taken from the source and sink configs.
+func NewFranzKafkaClient(opts ...kgo.Opt) KafkaClient {
+	return &franzKafkaClient{opts: opts}
+}
+
+// NewConsumer joins the consumer group. Offsets are never committed automatically, the source commits
+// the offsets of the records it delivered.
+func (c *franzKafkaClient) NewConsumer(config *ConsumerGroupConfig) (KafkaConsumer, error) {
+	resetOffset := kgo.NewOffset().AtEnd()
+	if config.StartOffset == OffsetEarliest {
+		resetOffset = kgo.NewOffset().AtStart()
+	}
+	opts := []kgo.Opt{
+		kgo.SeedBrokers(config.Brokers...),
+		kgo.ConsumerGroup(config.GroupId),
+		kgo.ConsumeTopics(config.Topics...),
+		kgo.ConsumeResetOffset(resetOffset),
+		kgo.DisableAutoCommit(),
+	}
+	if config.SessionTimeout > 0 {
+		opts = append(opts, kgo.SessionTimeout(config.SessionTimeout))
+	}
+	client, err := kgo.NewClient(append(opts, c.opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	return &franzKafkaConsumer{client: client}, nil
+}
+
+// NewProducer creates a producer for the brokers.
+func (c *franzKafkaClient) NewProducer(brokers []string) (KafkaProducer, error) {
+	client, err := kgo.NewClient(append([]kgo.Opt{kgo.SeedBrokers(brokers...)}, c.opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	return &franzKafkaProducer{client: client}, nil
+}
+
+type franzKafkaConsumer struct {
+	client *kgo.Client
+}
+
+// Poll returns the records of the next fetch. Fetch errors are only returned if no records came with them,
+// the client keeps retrying the partitions that failed.
+func (c *franzKafkaConsumer) Poll(ctx context.Context) ([]*KafkaRecord, error) {
+	fetches := c.client.PollFetches(ctx)
+	if fetches.IsClientClosed() {
+		return nil, kgo.ErrClientClosed
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var records []*KafkaRecord
+	fetches.EachRecord(func(r *kgo.Record) {
+		records = append(records, franzRecord(r))
+	})
+	if len(records) == 0 {
+		var err error
+		for _, fetchErr := range fetches.Errors() {
+			err = errors.Join(err, fmt.Errorf("unable to fetch %s/%d: %w", fetchErr.Topic, fetchErr.Partition, fetchErr.Err))
+		}
+		return nil, err
+	}
+	return records, nil
+}
+
+// CommitOffsets commits the offsets for the group, failing if the broker refused any of them.
+func (c *franzKafkaConsumer) CommitOffsets(ctx context.Context, offsets map[TopicPartition]int64) error {
+	uncommitted := make(map[string]map[int32]kgo.EpochOffset)
+	for tp, offset := range offsets {
+		if uncommitted[tp.Topic] == nil {
+			uncommitted[tp.Topic] = make(map[int32]kgo.EpochOffset)
+		}
+		uncommitted[tp.Topic][tp.Partition] = kgo.EpochOffset{Epoch: -1, Offset: offset}
+	}
+	var commitErr error
+	c.client.CommitOffsetsSync(ctx, uncommitted,
+		func(_ *kgo.Client, _ *kmsg.OffsetCommitRequest, resp *kmsg.OffsetCommitResponse, err error) {
+			if err != nil {
+				commitErr = err
+				return
+			}
+			for _, topic := range resp.Topics {
+				for _, partition := range topic.Partitions {
+					if err = kerr.ErrorForCode(partition.ErrorCode); err != nil {
+						commitErr = errors.Join(commitErr,
+							fmt.Errorf("unable to commit %s/%d: %w", topic.Topic, partition.Partition, err))
+					}
+				}
+			}
+		})
+	return commitErr
+}
+
+// Close leaves the consumer group and closes the client.
+func (c *franzKafkaConsumer) Close() error {
+	c.client.Close()
+	return nil
+}
+
+type franzKafkaProducer struct {
+	client *kgo.Client
+}
+
+// Produce waits until the brokers acknowledged the record.
+func (p *franzKafkaProducer) Produce(ctx context.Context, record *KafkaRecord) error {
+	r := &kgo.Record{
+		Topic:     record.Topic,
+		Key:       record.Key,
+		Value:     record.Value,
+		Timestamp: record.Timestamp,
+	}
+	for key, value := range record.Headers {
+		r.Headers = append(r.Headers, kgo.RecordHeader{Key: key, Value: []byte(value)})
+	}
+	return p.client.ProduceSync(ctx, r).FirstErr()
+}
+
+// Close closes the client, every record was acknowledged by Produce already.
+func (p *franzKafkaProducer) Close() error {
+	p.client.Close()
+	return nil
+}
+
+func franzRecord(r *kgo.Record) *KafkaRecord {
+	record := &KafkaRecord{
+		Topic:     r.Topic,
+		Partition: r.Partition,
+		Offset:    r.Offset,
+		Key:       r.Key,
+		Value:     r.Value,
+		Timestamp: r.Timestamp,
+	}
+	if len(r.Headers) > 0 {
+		record.Headers = make(map[string]string, len(r.Headers))
+		for _, header := range r.Headers {
+			record.Headers[header.Key] = string(header.Value)
+		}
+	}
+	return record
+}
@@ -0,0 +1,77 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package connectors
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/twmb/franz-go/pkg/kfake"
+)
+
+func TestFranzKafkaClient_SinkAndSource(t *testing.T) {
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(1, "orders"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer cluster.Close()
+	brokers := cluster.ListenAddrs()
+	client := NewFranzKafkaClient()
+	b := bus.ResetBus()
+
+	sink, err := NewKafkaSink(b, &KafkaSinkConfig{
+		Client:  client,
+		Brokers: brokers,
+		Channel: "orders-out",
+		Topic:   "orders",
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, sink.Start())
+	defer sink.Stop()
+
+	received := make(chan *model.Message, 2)
+	b.GetChannelManager().CreateChannel("orders-in")
+	handler, _ := b.ListenStream("orders-in")
+	handler.Handle(func(msg *model.Message) {
+		received <- msg
+	}, nil)
+	receive := func() interface{} {
+		select {
+		case msg := <-received:
+			return msg.Payload
+		case <-time.After(10 * time.Second):
+			assert.Fail(t, "record was not delivered")
+			return nil
+		}
+	}
+	startSource := func() *KafkaSource {
+		source, err := NewKafkaSource(b, &KafkaSourceConfig{
+			Client:  client,
+			Channel: "orders-in",
+			ConsumerGroup: ConsumerGroupConfig{
+				Brokers:     brokers,
+				GroupId:     "ranch",
+				Topics:      []string{"orders"},
+				StartOffset: OffsetEarliest,
+			},
+		})
+		assert.NoError(t, err)
+		assert.NoError(t, source.Start())
+		return source
+	}
+
+	assert.NoError(t, b.SendRequestMessage("orders-out", map[string]int{"id": 1}, nil))
+	source := startSource()
+	assert.Equal(t, map[string]interface{}{"id": float64(1)}, receive())
+	assert.NoError(t, source.Stop())
+
+	// the group committed the first record, so a new member of the group starts after it.
+	assert.NoError(t, b.SendRequestMessage("orders-out", map[string]int{"id": 2}, nil))
+	source = startSource()
+	assert.Equal(t, map[string]interface{}{"id": float64(2)}, receive())
+	assert.NoError(t, source.Stop())
+}
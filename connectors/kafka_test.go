@@ -0,0 +1,254 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package connectors
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/model"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeKafkaConsumer struct {
+	records   chan []*KafkaRecord
+	pollErr   error
+	committed []map[TopicPartition]int64
+	closed    bool
+	lock      sync.Mutex
+}
+
+func (c *fakeKafkaConsumer) Poll(ctx context.Context) ([]*KafkaRecord, error) {
+	c.lock.Lock()
+	err := c.pollErr
+	c.pollErr = nil
+	c.lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case records := <-c.records:
+		return records, nil
+	}
+}
+
+func (c *fakeKafkaConsumer) CommitOffsets(ctx context.Context, offsets map[TopicPartition]int64) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.committed = append(c.committed, offsets)
+	return nil
+}
+
+func (c *fakeKafkaConsumer) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.closed = true
+	return nil
+}
+
+func (c *fakeKafkaConsumer) getCommitted() []map[TopicPartition]int64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.committed
+}
+
+type fakeKafkaProducer struct {
+	produced chan *KafkaRecord
+	closed   bool
+}
+
+func (p *fakeKafkaProducer) Produce(ctx context.Context, record *KafkaRecord) error {
+	p.produced <- record
+	return nil
+}
+
+func (p *fakeKafkaProducer) Close() error {
+	p.closed = true
+	return nil
+}
+
+type fakeKafkaClient struct {
+	consumer      *fakeKafkaConsumer
+	producer      *fakeKafkaProducer
+	groupConfig   *ConsumerGroupConfig
+	brokers       []string
+	consumerError error
+}
+
+func newFakeKafkaClient() *fakeKafkaClient {
+	return &fakeKafkaClient{
+		consumer: &fakeKafkaConsumer{records: make(chan []*KafkaRecord)},
+		producer: &fakeKafkaProducer{produced: make(chan *KafkaRecord, 10)},
+	}
+}
+
+func (c *fakeKafkaClient) NewConsumer(config *ConsumerGroupConfig) (KafkaConsumer, error) {
+	c.groupConfig = config
+	return c.consumer, c.consumerError
+}
+
+func (c *fakeKafkaClient) NewProducer(brokers []string) (KafkaProducer, error) {
+	c.brokers = brokers
+	return c.producer, nil
+}
+
+func TestNewKafkaSource_Validation(t *testing.T) {
+	b := bus.ResetBus()
+	client := newFakeKafkaClient()
+	group := ConsumerGroupConfig{GroupId: "ranch", Topics: []string{"orders"}}
+
+	_, err := NewKafkaSource(b, nil)
+	assert.Error(t, err)
+	_, err = NewKafkaSource(b, &KafkaSourceConfig{Channel: "orders", ConsumerGroup: group})
+	assert.ErrorContains(t, err, "no client")
+	_, err = NewKafkaSource(b, &KafkaSourceConfig{Client: client, ConsumerGroup: group})
+	assert.ErrorContains(t, err, "no channel")
+	_, err = NewKafkaSource(b, &KafkaSourceConfig{Client: client, Channel: "orders",
+		ConsumerGroup: ConsumerGroupConfig{Topics: []string{"orders"}}})
+	assert.ErrorContains(t, err, "no consumer group")
+	_, err = NewKafkaSource(b, &KafkaSourceConfig{Client: client, Channel: "orders",
+		ConsumerGroup: ConsumerGroupConfig{GroupId: "ranch"}})
+	assert.ErrorContains(t, err, "no topics")
+	_, err = NewKafkaSource(b, &KafkaSourceConfig{Client: client, Channel: "orders", ConsumerGroup: group,
+		Codec: "morse"})
+	assert.ErrorContains(t, err, "unknown codec")
+}
+
+func TestKafkaSource_DeliversAndCommits(t *testing.T) {
+	b := bus.ResetBus()
+	client := newFakeKafkaClient()
+	source, err := NewKafkaSource(b, &KafkaSourceConfig{
+		Client:  client,
+		Channel: "orders",
+		ConsumerGroup: ConsumerGroupConfig{
+			Brokers:     []string{"localhost:9092"},
+			GroupId:     "ranch",
+			Topics:      []string{"orders"},
+			StartOffset: OffsetEarliest,
+		},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, source.Start())
+	assert.Error(t, source.Start())
+	assert.Equal(t, "ranch", client.groupConfig.GroupId)
+	assert.Equal(t, OffsetEarliest, client.groupConfig.StartOffset)
+
+	received := make(chan *model.Message, 2)
+	errors := make(chan error, 1)
+	handler, _ := b.ListenStream("orders")
+	handler.Handle(func(msg *model.Message) {
+		received <- msg
+	}, func(err error) {
+		errors <- err
+	})
+
+	client.consumer.records <- []*KafkaRecord{
+		{Topic: "orders", Partition: 0, Offset: 41, Value: []byte(`{"id":1}`)},
+		{Topic: "orders", Partition: 0, Offset: 42, Value: []byte(`not json`)},
+		{Topic: "orders", Partition: 1, Offset: 7, Value: []byte(`{"id":2}`)},
+	}
+
+	// the bus does not guarantee delivery order to handlers.
+	payloads := []interface{}{(<-received).Payload, (<-received).Payload}
+	assert.ElementsMatch(t, []interface{}{
+		map[string]interface{}{"id": float64(1)},
+		map[string]interface{}{"id": float64(2)},
+	}, payloads)
+	assert.ErrorContains(t, <-errors, "orders/0@42")
+
+	assert.Eventually(t, func() bool { return len(client.consumer.getCommitted()) == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, map[TopicPartition]int64{
+		{Topic: "orders", Partition: 0}: 43,
+		{Topic: "orders", Partition: 1}: 8,
+	}, client.consumer.getCommitted()[0])
+
+	assert.NoError(t, source.Stop())
+	assert.True(t, client.consumer.closed)
+	assert.Error(t, source.Stop())
+	// nothing new was delivered, so stopping does not commit again.
+	assert.Len(t, client.consumer.getCommitted(), 1)
+}
+
+func TestKafkaSource_CommitInterval(t *testing.T) {
+	b := bus.ResetBus()
+	client := newFakeKafkaClient()
+	client.consumer.pollErr = fmt.Errorf("broker unavailable")
+	source, _ := NewKafkaSource(b, &KafkaSourceConfig{
+		Client:         client,
+		Channel:        "events",
+		Codec:          CodecString,
+		CommitInterval: time.Hour,
+		ConsumerGroup:  ConsumerGroupConfig{GroupId: "ranch", Topics: []string{"events"}},
+	})
+	source.retryDelay = time.Millisecond
+	assert.NoError(t, source.Start())
+
+	received := make(chan *model.Message, 1)
+	handler, _ := b.ListenStream("events")
+	handler.Handle(func(msg *model.Message) {
+		received <- msg
+	}, nil)
+
+	client.consumer.records <- []*KafkaRecord{{Topic: "events", Partition: 3, Offset: 0, Value: []byte("hello")}}
+	assert.Equal(t, "hello", (<-received).Payload)
+	assert.Empty(t, client.consumer.getCommitted())
+
+	// pending offsets are committed when the source stops.
+	assert.NoError(t, source.Stop())
+	assert.Equal(t, []map[TopicPartition]int64{{{Topic: "events", Partition: 3}: 1}}, client.consumer.getCommitted())
+}
+
+func TestKafkaSource_StartFails(t *testing.T) {
+	client := newFakeKafkaClient()
+	client.consumerError = fmt.Errorf("no brokers")
+	source, _ := NewKafkaSource(bus.ResetBus(), &KafkaSourceConfig{
+		Client:        client,
+		Channel:       "orders",
+		ConsumerGroup: ConsumerGroupConfig{GroupId: "ranch", Topics: []string{"orders"}},
+	})
+	assert.ErrorContains(t, source.Start(), "no brokers")
+	assert.Error(t, source.Stop())
+}
+
+func TestKafkaSink(t *testing.T) {
+	b := bus.ResetBus()
+	client := newFakeKafkaClient()
+
+	_, err := NewKafkaSink(b, &KafkaSinkConfig{Client: client, Channel: "orders"})
+	assert.ErrorContains(t, err, "no topic")
+
+	sink, err := NewKafkaSink(b, &KafkaSinkConfig{
+		Client:  client,
+		Brokers: []string{"localhost:9092"},
+		Channel: "orders",
+		Topic:   "orders-out",
+		Key: func(msg *model.Message) []byte {
+			return []byte("order-key")
+		},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, sink.Start())
+	assert.Equal(t, []string{"localhost:9092"}, client.brokers)
+
+	assert.NoError(t, b.SendRequestMessage("orders", map[string]int{"id": 1}, nil))
+	record := <-client.producer.produced
+	assert.Equal(t, "orders-out", record.Topic)
+	assert.Equal(t, []byte(`{"id":1}`), record.Value)
+	assert.Equal(t, []byte("order-key"), record.Key)
+
+	// responses are not relayed, so a source can deliver to the same channel without looping.
+	assert.NoError(t, b.SendResponseMessage("orders", map[string]int{"id": 2}, nil))
+	assert.NoError(t, b.SendRequestMessage("orders", []byte(`{"id":3}`), nil))
+	assert.Equal(t, []byte(`{"id":3}`), (<-client.producer.produced).Value)
+
+	assert.NoError(t, sink.Stop())
+	assert.True(t, client.producer.closed)
+	assert.Error(t, sink.Stop())
+}
@@ -0,0 +1,125 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package log
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+// Adapter is the little a logger has to offer for ranch to log through it. Wrap a zap, zerolog or any other
+// logger in an Adapter and bridge it into slog with NewAdapterHandler. A zerolog adapter could look like
+//
+//	type zerologAdapter struct{ logger zerolog.Logger }
+//
+//	func (a *zerologAdapter) Enabled(level slog.Level) bool {
+//		return zerologLevel(level) >= a.logger.GetLevel()
+//	}
+//
+//	func (a *zerologAdapter) Log(level slog.Level, msg string, fields map[string]any) {
+//		a.logger.WithLevel(zerologLevel(level)).Fields(fields).Msg(msg)
+//	}
+//
+// while zap users can skip the adapter, zap ships an slog.Handler of its own (go.uber.org/zap/exp/zapslog).
+type Adapter interface {
+	// Enabled returns true if records of the level are logged.
+	Enabled(level slog.Level) bool
+	// Log logs a record. Fields of groups are keyed by the group names and the field name, joined by dots.
+	Log(level slog.Level, msg string, fields map[string]any)
+}
+
+// LevelTrace is the level Trace logs at once a logger is set.
+const LevelTrace = slog.LevelDebug - 4
+
+// NewAdapterHandler returns an slog.Handler logging through adapter.
+func NewAdapterHandler(adapter Adapter) slog.Handler {
+	return &adapterHandler{adapter: adapter}
+}
+
+type adapterHandler struct {
+	adapter Adapter
+	attrs   []slog.Attr // attributes added with WithAttrs, keyed with their groups
+	group   string      // prefix of the open groups, e.g. "request."
+}
+
+func (h *adapterHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return h.adapter.Enabled(level)
+}
+
+func (h *adapterHandler) Handle(_ context.Context, record slog.Record) error {
+	fields := make(map[string]any, len(h.attrs)+record.NumAttrs())
+	for _, attr := range h.attrs {
+		addField(fields, "", attr)
+	}
+	record.Attrs(func(attr slog.Attr) bool {
+		addField(fields, h.group, attr)
+		return true
+	})
+	h.adapter.Log(record.Level, record.Message, fields)
+	return nil
+}
+
+func (h *adapterHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handler := &adapterHandler{adapter: h.adapter, group: h.group}
+	handler.attrs = append(handler.attrs, h.attrs...)
+	for _, attr := range attrs {
+		attr.Key = h.group + attr.Key
+		handler.attrs = append(handler.attrs, attr)
+	}
+	return handler
+}
+
+func (h *adapterHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &adapterHandler{adapter: h.adapter, attrs: h.attrs, group: h.group + name + "."}
+}
+
+// addField adds an attribute to the fields, flattening groups.
+func addField(fields map[string]any, prefix string, attr slog.Attr) {
+	value := attr.Value.Resolve()
+	if value.Kind() == slog.KindGroup {
+		if attr.Key != "" {
+			prefix += attr.Key + "."
+		}
+		for _, groupAttr := range value.Group() {
+			addField(fields, prefix, groupAttr)
+		}
+		return
+	}
+	if attr.Key == "" {
+		return
+	}
+	fields[prefix+attr.Key] = value.Any()
+}
+
+var (
+	logger     *slog.Logger
+	loggerLock sync.RWMutex
+)
+
+// SetLogger routes the logging of every ranch subsystem (the bus, stores, the STOMP server, bridges and
+// services) through logger. Warn, Trace, Debug, Verbose and Panicf log through it too, instead of
+// printing, with DebugFlag, TraceFlag and VerboseFlag still applying. nil restores the default.
+func SetLogger(l *slog.Logger) {
+	loggerLock.Lock()
+	defer loggerLock.Unlock()
+	logger = l
+}
+
+// Logger returns the logger set with SetLogger, or slog.Default() if there is none.
+func Logger() *slog.Logger {
+	if l := configuredLogger(); l != nil {
+		return l
+	}
+	return slog.Default()
+}
+
+func configuredLogger() *slog.Logger {
+	loggerLock.RLock()
+	defer loggerLock.RUnlock()
+	return logger
+}
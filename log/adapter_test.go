@@ -0,0 +1,71 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package log
+
+import (
+	"log/slog"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type logRecord struct {
+	level  slog.Level
+	msg    string
+	fields map[string]any
+}
+
+type recordingAdapter struct {
+	level   slog.Level
+	records []*logRecord
+	lock    sync.Mutex
+}
+
+func (a *recordingAdapter) Enabled(level slog.Level) bool {
+	return level >= a.level
+}
+
+func (a *recordingAdapter) Log(level slog.Level, msg string, fields map[string]any) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.records = append(a.records, &logRecord{level: level, msg: msg, fields: fields})
+}
+
+func TestAdapterHandler(t *testing.T) {
+	adapter := &recordingAdapter{level: slog.LevelInfo}
+	logger := slog.New(NewAdapterHandler(adapter)).With("service", "moo")
+
+	logger.Debug("not logged")
+	logger.WithGroup("request").With("id", "abc-123").Info("handled",
+		"status", 200, slog.Group("principal", "name", "dave"))
+
+	assert.Equal(t, []*logRecord{{level: slog.LevelInfo, msg: "handled", fields: map[string]any{
+		"service":                "moo",
+		"request.id":             "abc-123",
+		"request.status":         int64(200),
+		"request.principal.name": "dave",
+	}}}, adapter.records)
+}
+
+func TestSetLogger(t *testing.T) {
+	assert.Equal(t, slog.Default(), Logger())
+
+	adapter := &recordingAdapter{level: LevelTrace}
+	logger := slog.New(NewAdapterHandler(adapter))
+	SetLogger(logger)
+	defer SetLogger(nil)
+	assert.Equal(t, logger, Logger())
+
+	Warn("cannot load store %s\n", "moo")
+	Trace("traced")
+	DebugFlag = false
+	Debug("not logged")
+	DebugFlag = true
+
+	assert.Equal(t, []*logRecord{
+		{level: slog.LevelWarn, msg: "cannot load store moo", fields: map[string]any{}},
+		{level: LevelTrace, msg: "traced", fields: map[string]any{}},
+	}, adapter.records)
+}
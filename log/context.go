@@ -0,0 +1,56 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package log
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Attribute keys of request-scoped loggers, so logs of the same request can be correlated.
+const (
+	RequestIdKey = "request_id"
+	PrincipalKey = "principal"
+	ChannelKey   = "channel"
+	RouteKey     = "route"
+	LabelsKey    = "labels"
+)
+
+type loggerKey struct{}
+
+// NewContext returns a copy of ctx carrying logger.
+func NewContext(ctx context.Context, logger *slog.Logger) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the logger carried by ctx, or Logger() if there is none. ctx may be nil.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := loggerFromContext(ctx); ok {
+		return logger
+	}
+	return Logger()
+}
+
+// HasLogger returns true if ctx carries a logger.
+func HasLogger(ctx context.Context) bool {
+	_, ok := loggerFromContext(ctx)
+	return ok
+}
+
+// With returns a copy of ctx carrying its logger (see FromContext) with the attributes added, so everything
+// logged through it from then on carries them too.
+func With(ctx context.Context, args ...any) context.Context {
+	return NewContext(ctx, FromContext(ctx).With(args...))
+}
+
+func loggerFromContext(ctx context.Context) (*slog.Logger, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	logger, ok := ctx.Value(loggerKey{}).(*slog.Logger)
+	return logger, ok && logger != nil
+}
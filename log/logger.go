@@ -4,8 +4,10 @@
 package log
 
 import (
+	"context"
 	"fmt"
 	"github.com/fatih/color"
+	"log/slog"
 	"os"
 	"strings"
 )
@@ -21,6 +23,9 @@ var Version = ""
 
 // Print warnings
 func Warn(format string, arg ...interface{}) {
+	if logTo(slog.LevelWarn, format, arg...) {
+		return
+	}
 	color.NoColor = false
 	color.Set(color.FgHiMagenta)
 	if !WarnFlag {
@@ -31,6 +36,9 @@ func Warn(format string, arg ...interface{}) {
 
 // Print traces
 func Trace(format string, arg ...interface{}) {
+	if TraceFlag && logTo(LevelTrace, format, arg...) {
+		return
+	}
 	color.NoColor = false
 	color.Set(color.FgCyan)
 	color.Set(color.Faint)
@@ -42,6 +50,9 @@ func Trace(format string, arg ...interface{}) {
 
 // Print debug
 func Debug(format string, arg ...interface{}) {
+	if DebugFlag && logTo(slog.LevelDebug, format, arg...) {
+		return
+	}
 	if DebugFlag {
 		fmt.Printf(format, arg...)
 	}
@@ -49,6 +60,9 @@ func Debug(format string, arg ...interface{}) {
 
 // Print verbose
 func Verbose(format string, arg ...interface{}) {
+	if VerboseFlag && logTo(slog.LevelDebug, format, arg...) {
+		return
+	}
 	color.NoColor = false
 	color.Set(color.FgHiMagenta)
 	if VerboseFlag {
@@ -59,17 +73,29 @@ func Verbose(format string, arg ...interface{}) {
 
 // Catchable Panic
 func Panicf(format string, args ...interface{}) {
-	color.NoColor = false
-	color.Set(color.FgRed)
-	color.Set(color.Bold)
+	if !logTo(slog.LevelError, format, args...) {
+		color.NoColor = false
+		color.Set(color.FgRed)
+		color.Set(color.Bold)
 
-	fmt.Printf("❌ FATAL: "+format, args...)
-	color.Unset()
+		fmt.Printf("❌ FATAL: "+format, args...)
+		color.Unset()
+	}
 	if !RecoverOnError {
 		os.Exit(4)
 	}
 }
 
+// logTo logs through the logger set with SetLogger, returns false if there is none.
+func logTo(level slog.Level, format string, arg ...interface{}) bool {
+	l := configuredLogger()
+	if l == nil {
+		return false
+	}
+	l.Log(context.Background(), level, strings.TrimRight(fmt.Sprintf(format, arg...), "\n"))
+	return true
+}
+
 func SetVersion(version string) {
 	Version = version
 	if strings.Contains(Version, "-") {
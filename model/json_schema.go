@@ -0,0 +1,270 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// JSONSchema is a compiled JSON Schema. The keywords describing the shape of plain JSON documents are
+// supported: type, enum, const, properties, required, additionalProperties, items, minItems, maxItems,
+// minLength, maxLength, pattern, minimum, maximum, exclusiveMinimum and exclusiveMaximum. Other keywords,
+// such as $ref or format, are ignored.
+type JSONSchema struct {
+	Type                 schemaTypes            `json:"type,omitempty"`
+	Enum                 []interface{}          `json:"enum,omitempty"`
+	Const                interface{}            `json:"const,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *additionalProperties  `json:"additionalProperties,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+	MinItems             *int                   `json:"minItems,omitempty"`
+	MaxItems             *int                   `json:"maxItems,omitempty"`
+	MinLength            *int                   `json:"minLength,omitempty"`
+	MaxLength            *int                   `json:"maxLength,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+	Minimum              *float64               `json:"minimum,omitempty"`
+	Maximum              *float64               `json:"maximum,omitempty"`
+	ExclusiveMinimum     *float64               `json:"exclusiveMinimum,omitempty"`
+	ExclusiveMaximum     *float64               `json:"exclusiveMaximum,omitempty"`
+	pattern              *regexp.Regexp
+}
+
+// schemaTypes holds the "type" keyword, which is either a single type name or a list of them.
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var single string
+	if json.Unmarshal(data, &single) == nil {
+		*t = schemaTypes{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("type must be a string or a list of strings")
+	}
+	*t = list
+	return nil
+}
+
+// additionalProperties holds the "additionalProperties" keyword, which is either a boolean or a schema.
+type additionalProperties struct {
+	allowed bool
+	schema  *JSONSchema
+}
+
+func (a *additionalProperties) UnmarshalJSON(data []byte) error {
+	if json.Unmarshal(data, &a.allowed) == nil {
+		return nil
+	}
+	a.allowed = true
+	return json.Unmarshal(data, &a.schema)
+}
+
+// CompileJSONSchema parses a JSON Schema document.
+func CompileJSONSchema(schema []byte) (*JSONSchema, error) {
+	var compiled JSONSchema
+	if err := json.Unmarshal(schema, &compiled); err != nil {
+		return nil, fmt.Errorf("invalid JSON schema: %w", err)
+	}
+	if err := compiled.compile(); err != nil {
+		return nil, fmt.Errorf("invalid JSON schema: %w", err)
+	}
+	return &compiled, nil
+}
+
+func (s *JSONSchema) compile() error {
+	for _, t := range s.Type {
+		switch t {
+		case "object", "array", "string", "number", "integer", "boolean", "null":
+		default:
+			return fmt.Errorf("unknown type '%s'", t)
+		}
+	}
+	if s.Pattern != "" {
+		var err error
+		if s.pattern, err = regexp.Compile(s.Pattern); err != nil {
+			return fmt.Errorf("invalid pattern '%s': %w", s.Pattern, err)
+		}
+	}
+	for _, property := range s.Properties {
+		if err := property.compile(); err != nil {
+			return err
+		}
+	}
+	if s.AdditionalProperties != nil && s.AdditionalProperties.schema != nil {
+		if err := s.AdditionalProperties.schema.compile(); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.compile()
+	}
+	return nil
+}
+
+// Validate checks a value against the schema, using the JSON form of the value so struct fields are
+// checked by their JSON names. The error names the first field that does not match and why.
+func (s *JSONSchema) Validate(value interface{}) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("cannot encode value as JSON: %w", err)
+	}
+	var document interface{}
+	if err := json.Unmarshal(encoded, &document); err != nil {
+		return fmt.Errorf("cannot encode value as JSON: %w", err)
+	}
+	return s.validate("$", document)
+}
+
+func (s *JSONSchema) validate(path string, value interface{}) error {
+	if len(s.Type) > 0 && !s.matchesType(value) {
+		return fmt.Errorf("%s: expected %s, got %s", path, strings.Join(s.Type, " or "), jsonTypeOf(value))
+	}
+	if len(s.Enum) > 0 && !containsJSONValue(s.Enum, value) {
+		return fmt.Errorf("%s: value %v is not one of %v", path, value, s.Enum)
+	}
+	if s.Const != nil && !reflect.DeepEqual(s.Const, value) {
+		return fmt.Errorf("%s: value %v is not %v", path, value, s.Const)
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return s.validateObject(path, v)
+	case []interface{}:
+		return s.validateArray(path, v)
+	case string:
+		return s.validateString(path, v)
+	case float64:
+		return s.validateNumber(path, v)
+	}
+	return nil
+}
+
+func (s *JSONSchema) validateObject(path string, object map[string]interface{}) error {
+	for _, name := range s.Required {
+		if _, ok := object[name]; !ok {
+			return fmt.Errorf("%s: missing required property '%s'", path, name)
+		}
+	}
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		propertyPath := path + "." + name
+		if property, ok := s.Properties[name]; ok {
+			if err := property.validate(propertyPath, object[name]); err != nil {
+				return err
+			}
+			continue
+		}
+		if s.AdditionalProperties == nil {
+			continue
+		}
+		if !s.AdditionalProperties.allowed {
+			return fmt.Errorf("%s: property is not allowed", propertyPath)
+		}
+		if s.AdditionalProperties.schema != nil {
+			if err := s.AdditionalProperties.schema.validate(propertyPath, object[name]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *JSONSchema) validateArray(path string, array []interface{}) error {
+	if s.MinItems != nil && len(array) < *s.MinItems {
+		return fmt.Errorf("%s: expected at least %d items, got %d", path, *s.MinItems, len(array))
+	}
+	if s.MaxItems != nil && len(array) > *s.MaxItems {
+		return fmt.Errorf("%s: expected at most %d items, got %d", path, *s.MaxItems, len(array))
+	}
+	if s.Items == nil {
+		return nil
+	}
+	for i, item := range array {
+		if err := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *JSONSchema) validateString(path string, str string) error {
+	length := len([]rune(str))
+	if s.MinLength != nil && length < *s.MinLength {
+		return fmt.Errorf("%s: expected at least %d characters, got %d", path, *s.MinLength, length)
+	}
+	if s.MaxLength != nil && length > *s.MaxLength {
+		return fmt.Errorf("%s: expected at most %d characters, got %d", path, *s.MaxLength, length)
+	}
+	if s.pattern != nil && !s.pattern.MatchString(str) {
+		return fmt.Errorf("%s: '%s' does not match pattern '%s'", path, str, s.Pattern)
+	}
+	return nil
+}
+
+func (s *JSONSchema) validateNumber(path string, number float64) error {
+	if s.Minimum != nil && number < *s.Minimum {
+		return fmt.Errorf("%s: %v is less than the minimum of %v", path, number, *s.Minimum)
+	}
+	if s.Maximum != nil && number > *s.Maximum {
+		return fmt.Errorf("%s: %v is greater than the maximum of %v", path, number, *s.Maximum)
+	}
+	if s.ExclusiveMinimum != nil && number <= *s.ExclusiveMinimum {
+		return fmt.Errorf("%s: %v must be greater than %v", path, number, *s.ExclusiveMinimum)
+	}
+	if s.ExclusiveMaximum != nil && number >= *s.ExclusiveMaximum {
+		return fmt.Errorf("%s: %v must be less than %v", path, number, *s.ExclusiveMaximum)
+	}
+	return nil
+}
+
+func (s *JSONSchema) matchesType(value interface{}) bool {
+	actual := jsonTypeOf(value)
+	for _, t := range s.Type {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonTypeOf returns the JSON Schema type of a decoded JSON value, numbers without a fraction are integers.
+func jsonTypeOf(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if v == float64(int64(v)) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+func containsJSONValue(values []interface{}, value interface{}) bool {
+	for _, v := range values {
+		if reflect.DeepEqual(v, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type schemaTestItem struct {
+	Name  string   `json:"name"`
+	Count int      `json:"count"`
+	Tags  []string `json:"tags,omitempty"`
+}
+
+func TestJSONSchema_Validate(t *testing.T) {
+	schema, err := CompileJSONSchema([]byte(`{
+		"type": "object",
+		"required": ["name", "count"],
+		"additionalProperties": false,
+		"properties": {
+			"name": {"type": "string", "minLength": 1, "pattern": "^[a-z]+$"},
+			"count": {"type": "integer", "minimum": 0, "exclusiveMaximum": 10},
+			"tags": {"type": "array", "maxItems": 2, "items": {"enum": ["cow", "pig"]}}
+		}
+	}`))
+	assert.NoError(t, err)
+
+	assert.NoError(t, schema.Validate(&schemaTestItem{Name: "daisy", Count: 3, Tags: []string{"cow"}}))
+	assert.NoError(t, schema.Validate(map[string]interface{}{"name": "daisy", "count": 0}))
+
+	tests := []struct {
+		value interface{}
+		err   string
+	}{
+		{"daisy", "$: expected object, got string"},
+		{map[string]interface{}{"name": "daisy"}, "$: missing required property 'count'"},
+		{&schemaTestItem{Name: "", Count: 1}, "$.name: expected at least 1 characters, got 0"},
+		{&schemaTestItem{Name: "Daisy", Count: 1}, "$.name: 'Daisy' does not match pattern '^[a-z]+$'"},
+		{&schemaTestItem{Name: "daisy", Count: -1}, "$.count: -1 is less than the minimum of 0"},
+		{&schemaTestItem{Name: "daisy", Count: 10}, "$.count: 10 must be less than 10"},
+		{map[string]interface{}{"name": "daisy", "count": 1.5}, "$.count: expected integer, got number"},
+		{&schemaTestItem{Name: "daisy", Count: 1, Tags: []string{"cow", "hen"}},
+			"$.tags[1]: value hen is not one of [cow pig]"},
+		{&schemaTestItem{Name: "daisy", Count: 1, Tags: []string{"cow", "cow", "pig"}},
+			"$.tags: expected at most 2 items, got 3"},
+		{map[string]interface{}{"name": "daisy", "count": 1, "owner": "farmer"}, "$.owner: property is not allowed"},
+	}
+	for _, test := range tests {
+		assert.EqualError(t, schema.Validate(test.value), test.err)
+	}
+}
+
+func TestJSONSchema_TypeLists(t *testing.T) {
+	schema, err := CompileJSONSchema([]byte(`{"type": ["number", "null"], "maximum": 5}`))
+	assert.NoError(t, err)
+	assert.NoError(t, schema.Validate(nil))
+	assert.NoError(t, schema.Validate(4.5))
+	assert.EqualError(t, schema.Validate(true), "$: expected number or null, got boolean")
+	assert.EqualError(t, schema.Validate(6), "$: 6 is greater than the maximum of 5")
+
+	schema, err = CompileJSONSchema([]byte(`{"additionalProperties": {"type": "boolean"}}`))
+	assert.NoError(t, err)
+	assert.NoError(t, schema.Validate(map[string]bool{"a": true}))
+	assert.EqualError(t, schema.Validate(map[string]int{"a": 1}), "$.a: expected boolean, got integer")
+}
+
+func TestCompileJSONSchema_Invalid(t *testing.T) {
+	_, err := CompileJSONSchema([]byte(`{"type": "thing"}`))
+	assert.EqualError(t, err, "invalid JSON schema: unknown type 'thing'")
+
+	_, err = CompileJSONSchema([]byte(`{"properties": {"a": {"pattern": "("}}}`))
+	assert.ErrorContains(t, err, "invalid JSON schema: invalid pattern '('")
+
+	_, err = CompileJSONSchema([]byte(`not json`))
+	assert.Error(t, err)
+}
@@ -4,7 +4,10 @@
 package model
 
 import (
+	"context"
 	"github.com/google/uuid"
+	"github.com/pb33f/ranch/log"
+	"log/slog"
 	"net/http"
 	"net/url"
 )
@@ -22,6 +25,21 @@ type Request struct {
 	// Response.BrokerDestination field to ensure that the response will be sent
 	// back on the correct the "private" channel.
 	BrokerDestination *BrokerDestinationConfig `json:"-"`
+	// Authenticated principal that sent the request, empty if unknown. Set by the fabric endpoint for
+	// requests sent by STOMP clients, and by REST bridges when a principal resolver is configured.
+	Principal string `json:"-"`
+	// Labels of the class of traffic the request belongs to, e.g. {"client": "bot", "tier": "free"}, nil if
+	// the server does not classify traffic. Set by REST bridges and the fabric endpoint.
+	Labels map[string]string `json:"-"`
+	// Context of the request, carrying a request-scoped logger (see Logger). Set by the service registry
+	// before the request is handled, REST bridge requests derive it from the HTTP request.
+	Ctx context.Context `json:"-"`
+}
+
+// Logger returns the request-scoped logger, which adds the request id and the service channel (and for
+// HTTP requests the route and principal) to everything logged through it.
+func (request *Request) Logger() *slog.Logger {
+	return log.FromContext(request.Ctx)
 }
 
 // CreateServiceRequest is a small utility function that takes request type and payload and
@@ -4,6 +4,8 @@
 package model
 
 import (
+	"net/http"
+
 	"github.com/google/uuid"
 )
 
@@ -17,13 +19,28 @@ type Response struct {
 	ErrorCode      int         `json:"errorCode,omitempty"`
 	ErrorMessage   string      `json:"errorMessage,omitempty"`
 	ErrorObject    interface{} `json:"errorObject,omitempty"`
+	ErrorKind      ErrorKind   `json:"errorKind,omitempty"` // classifies the error, see ErrorDetail
+	ErrorKey       string      `json:"errorKey,omitempty"`  // key of the error message in the translations of the client
+	Retriable      bool        `json:"retriable,omitempty"` // the request may succeed if sent again
+	Stream         bool        `json:"stream,omitempty"`    // a chunk of a streamed response, the stream ends with the first response that is not
 	// If populated the response will be sent to a single client
 	// on the specified destination topic.
 	BrokerDestination *BrokerDestinationConfig `json:"-"`
-	Headers           map[string]interface{}   `json:"-"` // passthrough any http headers
+	Headers           map[string]interface{}   `json:"-"` // passthrough any http headers, a []string value sets a header once per value
+	ContentType       string                   `json:"-"` // content type of the http response, overrides a Content-Type header
+	Cookies           []*http.Cookie           `json:"-"` // cookies set by the http response
 	Marshal           bool                     `json:"-"` // if true, the payload be marshalled into JSON.
 }
 
+// HttpResponse controls the HTTP response REST bridges write for a response: its status code, content type,
+// headers and cookies. Fabric clients do not see any of it.
+type HttpResponse struct {
+	StatusCode  int                    // status code of the response, 200 if 0
+	ContentType string                 // content type of the response, overrides a Content-Type header
+	Headers     map[string]interface{} // headers of the response, a []string value sets a header once per value
+	Cookies     []*http.Cookie         // cookies set by the response
+}
+
 // Used to specify the target user queue of the Response
 type BrokerDestinationConfig struct {
 	Destination  string
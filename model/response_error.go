@@ -0,0 +1,140 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package model
+
+// ErrorKind classifies a service error, so clients can react to it without parsing the message.
+type ErrorKind string
+
+const (
+	ErrorKindInvalidRequest ErrorKind = "invalid_request" // the request is malformed or fails validation
+	ErrorKindUnauthorized   ErrorKind = "unauthorized"    // the client is not authenticated
+	ErrorKindForbidden      ErrorKind = "forbidden"       // the client may not make the request
+	ErrorKindNotFound       ErrorKind = "not_found"       // what the request is about does not exist
+	ErrorKindConflict       ErrorKind = "conflict"        // the request conflicts with the current state
+	ErrorKindRateLimited    ErrorKind = "rate_limited"    // the client sent too many requests
+	ErrorKindUnavailable    ErrorKind = "unavailable"     // the service cannot handle the request right now
+	ErrorKindTimeout        ErrorKind = "timeout"         // the service did not complete the request in time
+	ErrorKindInternal       ErrorKind = "internal"        // the service failed
+)
+
+// ErrorKindForCode returns the kind of error an HTTP status code stands for, services use them as error codes.
+func ErrorKindForCode(code int) ErrorKind {
+	switch code {
+	case 400, 405, 406, 413, 415, 422:
+		return ErrorKindInvalidRequest
+	case 401:
+		return ErrorKindUnauthorized
+	case 403:
+		return ErrorKindForbidden
+	case 404, 410:
+		return ErrorKindNotFound
+	case 409, 412:
+		return ErrorKindConflict
+	case 429:
+		return ErrorKindRateLimited
+	case 502, 503:
+		return ErrorKindUnavailable
+	case 408, 504:
+		return ErrorKindTimeout
+	}
+	return ErrorKindInternal
+}
+
+// ErrorDetail classifies an error response beyond its code and message.
+type ErrorDetail struct {
+	Kind      ErrorKind // derived from the error code if empty
+	Key       string    // key of the message in the translations of the client
+	Retriable bool      // the request may succeed if sent again
+}
+
+// ErrorDetail returns the classification of an error response, deriving the kind from the error code if the
+// service did not set it.
+func (r *Response) ErrorDetail() ErrorDetail {
+	detail := ErrorDetail{Kind: r.ErrorKind, Key: r.ErrorKey, Retriable: r.Retriable}
+	if detail.Kind == "" {
+		detail.Kind = ErrorKindForCode(r.ErrorCode)
+	}
+	return detail
+}
+
+// ErrorConverter shapes error responses the way a client expects them, e.g. as RFC 7807 problem details.
+// The fabric endpoint sends what it returns, marshalled to JSON, instead of the response.
+type ErrorConverter interface {
+	ConvertError(response *Response) interface{}
+}
+
+// ErrorConverterFunc is an ErrorConverter calling a function.
+type ErrorConverterFunc func(response *Response) interface{}
+
+// ConvertError calls the function.
+func (fn ErrorConverterFunc) ConvertError(response *Response) interface{} {
+	return fn(response)
+}
+
+// ProblemDetails is an RFC 7807 problem, extended with the request id and the error classification.
+type ProblemDetails struct {
+	Type      string      `json:"type,omitempty"`
+	Title     string      `json:"title"`
+	Status    int         `json:"status"`
+	Detail    interface{} `json:"detail,omitempty"`
+	Id        string      `json:"id,omitempty"`
+	Channel   string      `json:"channel,omitempty"`
+	Kind      ErrorKind   `json:"kind"`
+	Key       string      `json:"key,omitempty"`
+	Retriable bool        `json:"retriable"`
+}
+
+// ProblemDetailsErrorConverter sends error responses as RFC 7807 problem details, with the error object as
+// the detail, or the payload if there is no error object.
+var ProblemDetailsErrorConverter = ErrorConverterFunc(func(response *Response) interface{} {
+	detail := response.ErrorDetail()
+	problem := &ProblemDetails{
+		Title:     response.ErrorMessage,
+		Status:    response.ErrorCode,
+		Detail:    response.ErrorObject,
+		Channel:   response.Destination,
+		Kind:      detail.Kind,
+		Key:       detail.Key,
+		Retriable: detail.Retriable,
+	}
+	if problem.Detail == nil {
+		problem.Detail = response.Payload
+	}
+	if response.Id != nil {
+		problem.Id = response.Id.String()
+	}
+	return problem
+})
+
+// GraphQLErrors is an error response in the shape of a GraphQL result with errors.
+type GraphQLErrors struct {
+	Errors []GraphQLError `json:"errors"`
+}
+
+// GraphQLError is a GraphQL error, the classification goes in its extensions.
+type GraphQLError struct {
+	Message    string                 `json:"message"`
+	Extensions map[string]interface{} `json:"extensions"`
+}
+
+// GraphQLErrorConverter sends error responses in the shape GraphQL clients expect, the error code and its
+// classification in the extensions of the error.
+var GraphQLErrorConverter = ErrorConverterFunc(func(response *Response) interface{} {
+	detail := response.ErrorDetail()
+	extensions := map[string]interface{}{
+		"code":      detail.Kind,
+		"status":    response.ErrorCode,
+		"retriable": detail.Retriable,
+	}
+	if detail.Key != "" {
+		extensions["key"] = detail.Key
+	}
+	if response.Id != nil {
+		extensions["id"] = response.Id.String()
+	}
+	if response.ErrorObject != nil {
+		extensions["error"] = response.ErrorObject
+	}
+	return &GraphQLErrors{Errors: []GraphQLError{{Message: response.ErrorMessage, Extensions: extensions}}}
+})
@@ -0,0 +1,43 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package model
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponse_ErrorDetail(t *testing.T) {
+	assert.Equal(t, ErrorDetail{Kind: ErrorKindNotFound}, (&Response{Error: true, ErrorCode: 404}).ErrorDetail())
+	assert.Equal(t, ErrorDetail{Kind: ErrorKindInternal}, (&Response{Error: true, ErrorCode: 1234}).ErrorDetail())
+	assert.Equal(t, ErrorDetail{Kind: ErrorKindConflict, Key: "errors.stale", Retriable: true},
+		(&Response{Error: true, ErrorCode: 500, ErrorKind: ErrorKindConflict, ErrorKey: "errors.stale", Retriable: true}).ErrorDetail())
+}
+
+func TestErrorConverters(t *testing.T) {
+	id := uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	response := &Response{
+		Id:           &id,
+		Destination:  "orders",
+		Error:        true,
+		ErrorCode:    429,
+		ErrorMessage: "slow down",
+		ErrorKey:     "errors.rateLimited",
+		Retriable:    true,
+		ErrorObject:  map[string]int{"retryAfter": 5},
+	}
+
+	problem, _ := json.Marshal(ProblemDetailsErrorConverter.ConvertError(response))
+	assert.JSONEq(t, `{"title":"slow down","status":429,"detail":{"retryAfter":5},
+		"id":"6ba7b810-9dad-11d1-80b4-00c04fd430c8","channel":"orders",
+		"kind":"rate_limited","key":"errors.rateLimited","retriable":true}`, string(problem))
+
+	graphql, _ := json.Marshal(GraphQLErrorConverter.ConvertError(response))
+	assert.JSONEq(t, `{"errors":[{"message":"slow down","extensions":{"code":"rate_limited","status":429,
+		"retriable":true,"key":"errors.rateLimited","id":"6ba7b810-9dad-11d1-80b4-00c04fd430c8",
+		"error":{"retryAfter":5}}}]}`, string(graphql))
+}
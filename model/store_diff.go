@@ -0,0 +1,111 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package model
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+)
+
+// StoreItemDiff describes how a store item changed, so clients can patch their copy of the item rather than
+// replacing it. OldValue is nil for added items and NewValue is nil for removed ones.
+type StoreItemDiff struct {
+	OldValue      interface{}         `json:"oldValue"`
+	NewValue      interface{}         `json:"newValue"`
+	ChangedFields []*StoreFieldChange `json:"changedFields,omitempty"` // only when both values are structs or maps
+}
+
+// StoreFieldChange is a field that was added, changed or removed. Path holds the JSON names of the field and
+// the objects it is nested in. OldValue is nil for added fields and NewValue is nil for removed ones.
+type StoreFieldChange struct {
+	Path     []string    `json:"path"`
+	OldValue interface{} `json:"oldValue"`
+	NewValue interface{} `json:"newValue"`
+	Removed  bool        `json:"removed,omitempty"` // true if the field is gone, rather than set to null
+}
+
+// DiffStoreItems compares two values of a store item. Struct and map values are compared field by field,
+// using their JSON form so field names match what clients receive, nested objects are compared in turn.
+func DiffStoreItems(oldValue interface{}, newValue interface{}) *StoreItemDiff {
+	diff := &StoreItemDiff{OldValue: oldValue, NewValue: newValue}
+	if oldValue == nil || newValue == nil {
+		return diff
+	}
+	oldFields, ok := jsonObject(oldValue)
+	if !ok {
+		return diff
+	}
+	newFields, ok := jsonObject(newValue)
+	if !ok {
+		return diff
+	}
+	diff.ChangedFields = diffFields(nil, oldFields, newFields, []*StoreFieldChange{})
+	return diff
+}
+
+func diffFields(path []string, oldFields, newFields map[string]interface{},
+	changes []*StoreFieldChange) []*StoreFieldChange {
+
+	for _, name := range sortedFieldNames(oldFields, newFields) {
+		fieldPath := append(append([]string{}, path...), name)
+		oldField, inOld := oldFields[name]
+		newField, inNew := newFields[name]
+		if inOld && inNew {
+			oldObject, oldIsObject := oldField.(map[string]interface{})
+			newObject, newIsObject := newField.(map[string]interface{})
+			if oldIsObject && newIsObject {
+				changes = diffFields(fieldPath, oldObject, newObject, changes)
+				continue
+			}
+			if reflect.DeepEqual(oldField, newField) {
+				continue
+			}
+		}
+		changes = append(changes, &StoreFieldChange{
+			Path:     fieldPath,
+			OldValue: oldField,
+			NewValue: newField,
+			Removed:  !inNew,
+		})
+	}
+	return changes
+}
+
+func sortedFieldNames(oldFields, newFields map[string]interface{}) []string {
+	names := make([]string, 0, len(oldFields)+len(newFields))
+	for name := range oldFields {
+		names = append(names, name)
+	}
+	for name := range newFields {
+		if _, ok := oldFields[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// jsonObject returns the JSON form of a struct or map value, false for any other kind of value.
+func jsonObject(value interface{}) (map[string]interface{}, bool) {
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil, false
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct && v.Kind() != reflect.Map {
+		return nil, false
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, false
+	}
+	var fields map[string]interface{}
+	if json.Unmarshal(encoded, &fields) != nil || fields == nil {
+		return nil, false
+	}
+	return fields, true
+}
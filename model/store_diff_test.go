@@ -0,0 +1,63 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type diffTestOwner struct {
+	Name string `json:"name"`
+}
+
+type diffTestItem struct {
+	Name  string            `json:"name"`
+	Count int               `json:"count"`
+	Owner *diffTestOwner    `json:"owner"`
+	Tags  map[string]string `json:"tags,omitempty"`
+}
+
+func TestDiffStoreItems_Structs(t *testing.T) {
+	oldItem := &diffTestItem{Name: "cow", Count: 1, Owner: &diffTestOwner{Name: "dave"}, Tags: map[string]string{"a": "b"}}
+	newItem := &diffTestItem{Name: "cow", Count: 2, Owner: &diffTestOwner{Name: "ranch"}}
+
+	diff := DiffStoreItems(oldItem, newItem)
+	assert.Equal(t, oldItem, diff.OldValue)
+	assert.Equal(t, newItem, diff.NewValue)
+	assert.Equal(t, []*StoreFieldChange{
+		{Path: []string{"count"}, OldValue: float64(1), NewValue: float64(2)},
+		{Path: []string{"owner", "name"}, OldValue: "dave", NewValue: "ranch"},
+		{Path: []string{"tags"}, OldValue: map[string]interface{}{"a": "b"}, Removed: true},
+	}, diff.ChangedFields)
+
+	assert.Empty(t, DiffStoreItems(oldItem, oldItem).ChangedFields)
+}
+
+func TestDiffStoreItems_Maps(t *testing.T) {
+	diff := DiffStoreItems(map[string]interface{}{"a": 1, "b": nil}, map[string]interface{}{"b": "moo", "c": nil})
+	assert.Equal(t, []*StoreFieldChange{
+		{Path: []string{"a"}, OldValue: float64(1), Removed: true},
+		{Path: []string{"b"}, NewValue: "moo"},
+		{Path: []string{"c"}},
+	}, diff.ChangedFields)
+}
+
+func TestDiffStoreItems_Values(t *testing.T) {
+	tests := []struct {
+		oldValue interface{}
+		newValue interface{}
+	}{
+		{oldValue: nil, newValue: &diffTestItem{}},
+		{oldValue: &diffTestItem{}, newValue: nil},
+		{oldValue: "moo", newValue: "baa"},
+		{oldValue: []string{"moo"}, newValue: []string{"baa"}},
+		{oldValue: "moo", newValue: map[string]interface{}{"a": 1}},
+	}
+	for _, test := range tests {
+		diff := DiffStoreItems(test.oldValue, test.newValue)
+		assert.Equal(t, &StoreItemDiff{OldValue: test.oldValue, NewValue: test.newValue}, diff)
+	}
+}
@@ -5,7 +5,8 @@ package model
 
 type StoreContentResponse struct {
 	Items        map[string]interface{} `json:"items"`
-	ResponseType string                 `json:"responseType"` // should be "storeContentResponse"
+	ItemVersions map[string]int64       `json:"itemVersions,omitempty"` // the version of every item
+	ResponseType string                 `json:"responseType"`           // should be "storeContentResponse"
 	StoreId      string                 `json:"storeId"`
 	StoreVersion int64                  `json:"storeVersion"`
 }
@@ -22,11 +23,13 @@ func NewStoreContentResponse(
 }
 
 type UpdateStoreResponse struct {
-	ItemId       string      `json:"itemId"`
-	NewItemValue interface{} `json:"newItemValue"`
-	ResponseType string      `json:"responseType"` // should be "updateStoreResponse"
-	StoreId      string      `json:"storeId"`
-	StoreVersion int64       `json:"storeVersion"`
+	ItemId       string         `json:"itemId"`
+	NewItemValue interface{}    `json:"newItemValue"`
+	ItemVersion  int64          `json:"itemVersion,omitempty"` // the version of the item, omitted for removed items
+	Diff         *StoreItemDiff `json:"diff,omitempty"`        // only sent to clients that support item diffs
+	ResponseType string         `json:"responseType"`          // should be "updateStoreResponse"
+	StoreId      string         `json:"storeId"`
+	StoreVersion int64          `json:"storeVersion"`
 }
 
 func NewUpdateStoreResponse(
@@ -40,3 +43,30 @@ func NewUpdateStoreResponse(
 		NewItemValue: newValue,
 	}
 }
+
+// StoreItemUpdate is a single item change of a batched store update, NewItemValue is nil for removed items.
+type StoreItemUpdate struct {
+	ItemId       string         `json:"itemId"`
+	NewItemValue interface{}    `json:"newItemValue"`
+	ItemVersion  int64          `json:"itemVersion,omitempty"` // the version of the item, omitted for removed items
+	Diff         *StoreItemDiff `json:"diff,omitempty"`        // only sent to clients that support item diffs
+}
+
+// UpdateStoreBatchResponse carries the item changes of a store transaction, to be applied at once.
+type UpdateStoreBatchResponse struct {
+	Items        []*StoreItemUpdate `json:"items"`
+	ResponseType string             `json:"responseType"` // should be "updateStoreBatchResponse"
+	StoreId      string             `json:"storeId"`
+	StoreVersion int64              `json:"storeVersion"`
+}
+
+func NewUpdateStoreBatchResponse(
+	storeId string, items []*StoreItemUpdate, storeVersion int64) *UpdateStoreBatchResponse {
+
+	return &UpdateStoreBatchResponse{
+		ResponseType: "updateStoreBatchResponse",
+		StoreId:      storeId,
+		StoreVersion: storeVersion,
+		Items:        items,
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package abuse
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// slidingWindow keeps the timestamps (and failure flags) of recent observations per key.
+type slidingWindow struct {
+	window    time.Duration
+	entries   map[string][]windowEntry
+	lastSweep time.Time
+	lock      sync.Mutex
+}
+
+type windowEntry struct {
+	at     time.Time
+	failed bool
+}
+
+func newSlidingWindow(window time.Duration) *slidingWindow {
+	return &slidingWindow{window: window, entries: make(map[string][]windowEntry)}
+}
+
+// add records an observation for a key and returns the entries still inside the window.
+func (w *slidingWindow) add(key string, obs *Observation) []windowEntry {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	cutoff := obs.Time.Add(-w.window)
+	entries := w.entries[key]
+	i := 0
+	for i < len(entries) && !entries[i].at.After(cutoff) {
+		i++
+	}
+	entries = append(entries[i:], windowEntry{at: obs.Time, failed: obs.Failed})
+	w.entries[key] = entries
+	w.sweep(obs.Time, cutoff)
+	return entries
+}
+
+// sweep drops keys that have gone quiet, at most once per window, so idle clients don't accumulate.
+func (w *slidingWindow) sweep(now, cutoff time.Time) {
+	if now.Sub(w.lastSweep) < w.window {
+		return
+	}
+	w.lastSweep = now
+	for key, entries := range w.entries {
+		if !entries[len(entries)-1].at.After(cutoff) {
+			delete(w.entries, key)
+		}
+	}
+}
+
+// reset forgets a key, so it does not immediately trip again after being acted upon.
+func (w *slidingWindow) reset(key string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	delete(w.entries, key)
+}
+
+// BurstDetector flags clients that send more than Limit requests within Window.
+type BurstDetector struct {
+	limit  int
+	action Action
+	counts *slidingWindow
+}
+
+// NewBurstDetector creates a BurstDetector that applies action to clients exceeding limit requests per window.
+func NewBurstDetector(limit int, window time.Duration, action Action) *BurstDetector {
+	return &BurstDetector{limit: limit, action: action, counts: newSlidingWindow(window)}
+}
+
+func (d *BurstDetector) Name() string {
+	return "burst"
+}
+
+func (d *BurstDetector) Observe(obs *Observation) []*Verdict {
+	var verdicts []*Verdict
+	for _, key := range obs.Keys() {
+		entries := d.counts.add(key, obs)
+		if len(entries) > d.limit {
+			d.counts.reset(key)
+			verdicts = append(verdicts, &Verdict{
+				Detector: d.Name(),
+				Key:      key,
+				Score:    float64(len(entries)) / float64(d.limit),
+				Action:   d.action,
+				Reason: fmt.Sprintf("%d requests within %s exceeds the limit of %d",
+					len(entries), d.counts.window, d.limit),
+			})
+		}
+	}
+	return verdicts
+}
+
+// ErrorRateDetector flags clients whose requests fail more often than MaxRate within Window, once at
+// least MinRequests have been seen.
+type ErrorRateDetector struct {
+	maxRate     float64
+	minRequests int
+	action      Action
+	results     *slidingWindow
+}
+
+// NewErrorRateDetector creates an ErrorRateDetector that applies action to clients whose error rate
+// exceeds maxRate (0.0 - 1.0) across at least minRequests requests within window.
+func NewErrorRateDetector(maxRate float64, minRequests int, window time.Duration, action Action) *ErrorRateDetector {
+	return &ErrorRateDetector{
+		maxRate:     maxRate,
+		minRequests: minRequests,
+		action:      action,
+		results:     newSlidingWindow(window),
+	}
+}
+
+func (d *ErrorRateDetector) Name() string {
+	return "error-rate"
+}
+
+func (d *ErrorRateDetector) Observe(obs *Observation) []*Verdict {
+	var verdicts []*Verdict
+	for _, key := range obs.Keys() {
+		entries := d.results.add(key, obs)
+		if len(entries) < d.minRequests {
+			continue
+		}
+		failed := 0
+		for _, entry := range entries {
+			if entry.failed {
+				failed++
+			}
+		}
+		rate := float64(failed) / float64(len(entries))
+		if rate > d.maxRate {
+			d.results.reset(key)
+			score := rate
+			if d.maxRate > 0 {
+				score = rate / d.maxRate
+			}
+			verdicts = append(verdicts, &Verdict{
+				Detector: d.Name(),
+				Key:      key,
+				Score:    score,
+				Action:   d.action,
+				Reason: fmt.Sprintf("error rate of %.0f%% over %d requests exceeds %.0f%%",
+					rate*100, len(entries), d.maxRate*100),
+			})
+		}
+	}
+	return verdicts
+}
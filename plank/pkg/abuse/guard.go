@@ -0,0 +1,305 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+// Package abuse provides pluggable anomaly detection for HTTP and STOMP traffic. Detectors are fed
+// observations built from access logs and connection telemetry, and their verdicts are enforced by a Guard
+// as temporary bans or stepped-up authentication challenges. Every enforcement is reported as an Event so
+// it can be exported to a SIEM.
+package abuse
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pb33f/ranch/clock"
+	"github.com/pb33f/ranch/stompserver"
+)
+
+const (
+	SourceHttp  = "http"
+	SourceStomp = "stomp"
+)
+
+const (
+	defaultBanDuration       = 15 * time.Minute
+	defaultChallengeDuration = 15 * time.Minute
+)
+
+// Observation is a single piece of telemetry fed to detectors, such as an access log entry or a STOMP frame.
+type Observation struct {
+	Source    string    // SourceHttp or SourceStomp
+	IP        string    // remote address of the client, if known
+	Principal string    // authenticated identity of the client, if known
+	Failed    bool      // true if the request errored (HTTP status >= 400 or a rejected frame)
+	Time      time.Time // when the observation was made
+}
+
+// Keys returns the keys that identify the client behind the observation.
+func (o *Observation) Keys() []string {
+	var keys []string
+	if o.IP != "" {
+		keys = append(keys, IPKey(o.IP))
+	}
+	if o.Principal != "" {
+		keys = append(keys, PrincipalKey(o.Principal))
+	}
+	return keys
+}
+
+// IPKey returns the guard key used to track a remote IP address.
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// PrincipalKey returns the guard key used to track an authenticated principal.
+func PrincipalKey(principal string) string {
+	return "principal:" + principal
+}
+
+type Action int
+
+const (
+	ActionNone Action = iota
+	ActionChallenge
+	ActionBan
+)
+
+func (a Action) String() string {
+	switch a {
+	case ActionChallenge:
+		return "challenge"
+	case ActionBan:
+		return "ban"
+	}
+	return "none"
+}
+
+// Verdict is returned by a Detector when it considers a client's behaviour anomalous.
+type Verdict struct {
+	Detector string  // name of the detector that produced the verdict
+	Key      string  // key of the offending client (see IPKey and PrincipalKey)
+	Score    float64 // how anomalous the behaviour is, 1.0 being the detector threshold
+	Action   Action  // what the guard should do about it
+	Reason   string  // human readable explanation
+}
+
+// Detector inspects observations and reports anomalies. Detectors are called concurrently and must be safe
+// for concurrent use.
+type Detector interface {
+	Name() string
+	Observe(obs *Observation) []*Verdict
+}
+
+type EventType string
+
+const (
+	EventBanned     EventType = "banned"
+	EventChallenged EventType = "challenged"
+	EventRejected   EventType = "rejected"
+)
+
+// Event describes an enforcement made by the guard, suitable for exporting to a SIEM.
+type Event struct {
+	Type      EventType `json:"type"`
+	Source    string    `json:"source"`
+	Detector  string    `json:"detector,omitempty"`
+	Key       string    `json:"key"`
+	IP        string    `json:"ip,omitempty"`
+	Principal string    `json:"principal,omitempty"`
+	Score     float64   `json:"score,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	Time      time.Time `json:"time"`
+	Until     time.Time `json:"until,omitempty"`
+}
+
+// EventHandler is called for every event raised by the guard.
+type EventHandler func(evt *Event)
+
+// GuardConfig configures how a Guard enforces verdicts and identifies clients.
+type GuardConfig struct {
+	BanDuration       time.Duration                           // how long a ban lasts, defaults to 15 minutes
+	ChallengeDuration time.Duration                           // how long a challenge stays pending, defaults to 15 minutes
+	ChallengeHeader   string                                  // WWW-Authenticate value sent with HTTP challenges
+	HttpPrincipal     func(r *http.Request) string            // resolves the principal of an HTTP request
+	StompPrincipal    func(conn stompserver.StompConn) string // resolves the principal of a STOMP connection, defaults to the one it authenticated as
+}
+
+// Guard feeds observations to detectors and enforces their verdicts.
+type Guard struct {
+	config     GuardConfig
+	detectors  []Detector
+	handlers   []EventHandler
+	bans       map[string]time.Time
+	challenges map[string]time.Time
+	lock       sync.RWMutex
+}
+
+// NewGuard creates a Guard using the supplied detectors. A nil config uses the defaults.
+func NewGuard(config *GuardConfig, detectors ...Detector) *Guard {
+	g := &Guard{
+		detectors:  detectors,
+		bans:       make(map[string]time.Time),
+		challenges: make(map[string]time.Time),
+	}
+	if config != nil {
+		g.config = *config
+	}
+	if g.config.BanDuration <= 0 {
+		g.config.BanDuration = defaultBanDuration
+	}
+	if g.config.ChallengeDuration <= 0 {
+		g.config.ChallengeDuration = defaultChallengeDuration
+	}
+	if g.config.ChallengeHeader == "" {
+		g.config.ChallengeHeader = `Bearer error="insufficient_user_authentication"`
+	}
+	return g
+}
+
+// AddDetector plugs another detector into the guard.
+func (g *Guard) AddDetector(detector Detector) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.detectors = append(g.detectors, detector)
+}
+
+// AddEventHandler registers a handler that is called for every event the guard raises.
+func (g *Guard) AddEventHandler(handler EventHandler) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.handlers = append(g.handlers, handler)
+}
+
+// Observe feeds an observation to every detector and enforces any verdicts returned.
+func (g *Guard) Observe(obs *Observation) {
+	if obs.Time.IsZero() {
+		obs.Time = clock.Now()
+	}
+	g.lock.RLock()
+	detectors := g.detectors
+	g.lock.RUnlock()
+
+	for _, detector := range detectors {
+		for _, verdict := range detector.Observe(obs) {
+			g.enforce(obs, verdict)
+		}
+	}
+}
+
+func (g *Guard) enforce(obs *Observation, verdict *Verdict) {
+	evt := &Event{
+		Source:    obs.Source,
+		Detector:  verdict.Detector,
+		Key:       verdict.Key,
+		IP:        obs.IP,
+		Principal: obs.Principal,
+		Score:     verdict.Score,
+		Reason:    verdict.Reason,
+		Time:      obs.Time,
+	}
+	switch verdict.Action {
+	case ActionBan:
+		evt.Type = EventBanned
+		evt.Until = g.Ban(verdict.Key, obs.Time.Add(g.config.BanDuration))
+	case ActionChallenge:
+		evt.Type = EventChallenged
+		evt.Until = g.Challenge(verdict.Key, obs.Time.Add(g.config.ChallengeDuration))
+	default:
+		return
+	}
+	g.raise(evt)
+}
+
+func (g *Guard) raise(evt *Event) {
+	g.lock.RLock()
+	handlers := g.handlers
+	g.lock.RUnlock()
+	for _, handler := range handlers {
+		handler(evt)
+	}
+}
+
+// Ban bans a key until the supplied time, returning the time the ban expires. An existing longer ban is kept.
+func (g *Guard) Ban(key string, until time.Time) time.Time {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if existing, ok := g.bans[key]; ok && existing.After(until) {
+		return existing
+	}
+	g.bans[key] = until
+	return until
+}
+
+// Unban lifts a ban on a key.
+func (g *Guard) Unban(key string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	delete(g.bans, key)
+}
+
+// Challenge requires a key to complete a stepped-up authentication challenge before the supplied time passes.
+func (g *Guard) Challenge(key string, until time.Time) time.Time {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if existing, ok := g.challenges[key]; ok && existing.After(until) {
+		return existing
+	}
+	g.challenges[key] = until
+	return until
+}
+
+// ClearChallenge marks a challenge as satisfied, call it once a client has stepped up its authentication.
+func (g *Guard) ClearChallenge(key string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	delete(g.challenges, key)
+}
+
+// IsBanned returns true if any of the keys are currently banned.
+func (g *Guard) IsBanned(keys ...string) bool {
+	return g.isActive(g.bans, keys)
+}
+
+// RequiresChallenge returns true if any of the keys have a pending challenge.
+func (g *Guard) RequiresChallenge(keys ...string) bool {
+	return g.isActive(g.challenges, keys)
+}
+
+func (g *Guard) isActive(entries map[string]time.Time, keys []string) bool {
+	now := clock.Now()
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for _, key := range keys {
+		until, ok := entries[key]
+		if !ok {
+			continue
+		}
+		if now.After(until) {
+			delete(entries, key)
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// reject raises an EventRejected event for a request turned away by the guard.
+func (g *Guard) reject(obs *Observation, reason string) {
+	g.raise(&Event{
+		Type:      EventRejected,
+		Source:    obs.Source,
+		Key:       firstKey(obs),
+		IP:        obs.IP,
+		Principal: obs.Principal,
+		Reason:    reason,
+		Time:      clock.Now(),
+	})
+}
+
+func firstKey(obs *Observation) string {
+	if keys := obs.Keys(); len(keys) > 0 {
+		return keys[0]
+	}
+	return ""
+}
@@ -0,0 +1,87 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package abuse
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBurstDetector(t *testing.T) {
+	d := NewBurstDetector(3, time.Second, ActionBan)
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		assert.Empty(t, d.Observe(&Observation{IP: "10.0.0.1", Time: now}))
+	}
+	verdicts := d.Observe(&Observation{IP: "10.0.0.1", Principal: "bob", Time: now})
+	assert.Len(t, verdicts, 1)
+	assert.Equal(t, IPKey("10.0.0.1"), verdicts[0].Key)
+	assert.Equal(t, ActionBan, verdicts[0].Action)
+	assert.InDelta(t, 4.0/3.0, verdicts[0].Score, 0.001)
+
+	// requests outside the window are forgotten.
+	later := now.Add(2 * time.Second)
+	for i := 0; i < 3; i++ {
+		assert.Empty(t, d.Observe(&Observation{IP: "10.0.0.2", Time: now}))
+	}
+	assert.Empty(t, d.Observe(&Observation{IP: "10.0.0.2", Time: later}))
+}
+
+func TestErrorRateDetector(t *testing.T) {
+	d := NewErrorRateDetector(0.5, 4, time.Minute, ActionChallenge)
+	now := time.Now()
+	assert.Empty(t, d.Observe(&Observation{Principal: "bob", Failed: true, Time: now}))
+	assert.Empty(t, d.Observe(&Observation{Principal: "bob", Failed: true, Time: now}))
+	assert.Empty(t, d.Observe(&Observation{Principal: "bob", Failed: true, Time: now}))
+	verdicts := d.Observe(&Observation{Principal: "bob", Failed: false, Time: now})
+	assert.Len(t, verdicts, 1)
+	assert.Equal(t, PrincipalKey("bob"), verdicts[0].Key)
+	assert.Equal(t, ActionChallenge, verdicts[0].Action)
+
+	// a healthy client is left alone.
+	for i := 0; i < 10; i++ {
+		assert.Empty(t, d.Observe(&Observation{Principal: "alice", Failed: i%3 == 0, Time: now}))
+	}
+}
+
+func TestGuard_EnforcesVerdicts(t *testing.T) {
+	var events []*Event
+	g := NewGuard(&GuardConfig{BanDuration: time.Minute},
+		NewBurstDetector(1, time.Minute, ActionBan),
+		NewErrorRateDetector(0.5, 1, time.Minute, ActionChallenge))
+	g.AddEventHandler(func(evt *Event) {
+		events = append(events, evt)
+	})
+
+	g.Observe(&Observation{Source: SourceHttp, Principal: "mallory", Failed: true})
+	assert.True(t, g.RequiresChallenge(PrincipalKey("mallory")))
+	assert.False(t, g.IsBanned(PrincipalKey("mallory")))
+
+	g.Observe(&Observation{Source: SourceHttp, Principal: "mallory"})
+	assert.True(t, g.IsBanned(PrincipalKey("mallory")))
+
+	assert.Len(t, events, 2)
+	assert.Equal(t, EventChallenged, events[0].Type)
+	assert.Equal(t, EventBanned, events[1].Type)
+	assert.Equal(t, "burst", events[1].Detector)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), events[1].Until, time.Second)
+
+	g.ClearChallenge(PrincipalKey("mallory"))
+	g.Unban(PrincipalKey("mallory"))
+	assert.False(t, g.RequiresChallenge(PrincipalKey("mallory")))
+	assert.False(t, g.IsBanned(PrincipalKey("mallory")))
+}
+
+func TestGuard_BansExpire(t *testing.T) {
+	g := NewGuard(nil)
+	g.Ban(IPKey("10.0.0.1"), time.Now().Add(-time.Second))
+	assert.False(t, g.IsBanned(IPKey("10.0.0.1")))
+
+	// a shorter ban does not shorten an existing one.
+	until := time.Now().Add(time.Hour)
+	g.Ban(IPKey("10.0.0.2"), until)
+	assert.Equal(t, until, g.Ban(IPKey("10.0.0.2"), time.Now().Add(time.Minute)))
+}
@@ -0,0 +1,103 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package abuse
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/pb33f/ranch/clock"
+)
+
+// statusRecorder captures the status code written by a handler so it can be fed to detectors.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack is passed through so WebSocket upgrades keep working behind the guard.
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := r.ResponseWriter.(http.Hijacker); ok {
+		r.status = http.StatusSwitchingProtocols
+		return h.Hijack()
+	}
+	return nil, nil, fmt.Errorf("response writer does not support hijacking")
+}
+
+type connectionAddrKey struct{}
+
+// ConnectionAddrMiddleware records the address of the connection a request came in on, for the guard to
+// key clients on. It must wrap any middleware replacing the remote address with forwarded headers, such as
+// handlers.ProxyHeaders, which anyone can set to dodge bans or frame other clients.
+func ConnectionAddrMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), connectionAddrKey{}, r.RemoteAddr)))
+	})
+}
+
+// HttpMiddleware returns middleware that turns away banned clients, challenges clients with a pending
+// stepped-up authentication challenge, and feeds every request to the guard's detectors. Clients are
+// keyed on the address recorded by ConnectionAddrMiddleware, or the remote address of the request if
+// it was not recorded.
+func (g *Guard) HttpMiddleware() mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			obs := &Observation{Source: SourceHttp, IP: remoteIP(r), Time: clock.Now()}
+			if g.config.HttpPrincipal != nil {
+				obs.Principal = g.config.HttpPrincipal(r)
+			}
+			keys := obs.Keys()
+
+			if g.IsBanned(keys...) {
+				g.reject(obs, "client is banned")
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			if g.RequiresChallenge(keys...) {
+				g.reject(obs, "stepped-up authentication required")
+				w.Header().Set("WWW-Authenticate", g.config.ChallengeHeader)
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+
+			recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(recorder, r)
+			obs.Failed = recorder.status >= http.StatusBadRequest
+			g.Observe(obs)
+		})
+	}
+}
+
+func remoteIP(r *http.Request) string {
+	addr, ok := r.Context().Value(connectionAddrKey{}).(string)
+	if !ok {
+		addr = r.RemoteAddr
+	}
+	return hostOf(addr)
+}
+
+// hostOf strips the port from an address, if it has one.
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
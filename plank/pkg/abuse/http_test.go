@@ -0,0 +1,89 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package abuse
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGuard_HttpMiddleware(t *testing.T) {
+	g := NewGuard(&GuardConfig{
+		HttpPrincipal: func(r *http.Request) string { return r.Header.Get("X-User") },
+	}, NewErrorRateDetector(0.5, 2, time.Minute, ActionBan))
+
+	var rejected []*Event
+	g.AddEventHandler(func(evt *Event) {
+		if evt.Type == EventRejected {
+			rejected = append(rejected, evt)
+		}
+	})
+
+	handler := g.HttpMiddleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+
+	request := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "10.0.0.1:5000"
+		req.Header.Set("X-User", "scanner")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	assert.Equal(t, http.StatusOK, request("/").Code)
+	assert.Equal(t, http.StatusNotFound, request("/missing").Code)
+	assert.Equal(t, http.StatusNotFound, request("/missing").Code)
+	assert.True(t, g.IsBanned(IPKey("10.0.0.1")))
+	assert.True(t, g.IsBanned(PrincipalKey("scanner")))
+
+	assert.Equal(t, http.StatusForbidden, request("/").Code)
+	assert.Len(t, rejected, 1)
+	assert.Equal(t, IPKey("10.0.0.1"), rejected[0].Key)
+}
+
+func TestGuard_HttpMiddleware_Challenge(t *testing.T) {
+	g := NewGuard(nil)
+	g.Challenge(IPKey("10.0.0.9"), time.Now().Add(time.Minute))
+
+	handler := g.HttpMiddleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.9:5000"
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Contains(t, rr.Header().Get("WWW-Authenticate"), "insufficient_user_authentication")
+}
+
+func TestGuard_HttpMiddleware_IgnoresForwardedAddress(t *testing.T) {
+	g := NewGuard(nil)
+	g.Ban(IPKey("10.0.0.1"), time.Now().Add(time.Minute))
+
+	// forwarded headers replace the remote address inside ConnectionAddrMiddleware
+	forwarded := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.RemoteAddr = r.Header.Get("X-Forwarded-For") + ":0"
+			next.ServeHTTP(w, r)
+		})
+	}
+	handler := ConnectionAddrMiddleware(forwarded(g.HttpMiddleware()(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.1:5000"
+	req.Header.Set("X-Forwarded-For", "192.168.1.1")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+}
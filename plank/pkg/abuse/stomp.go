@@ -0,0 +1,50 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package abuse
+
+import (
+	"fmt"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/pb33f/ranch/clock"
+	"github.com/pb33f/ranch/stompserver"
+)
+
+// StompMiddleware returns STOMP broker middleware that rejects frames from banned or challenged
+// principals and feeds every frame to the guard's detectors. Clients are keyed on their remote address
+// and on their principal, resolved by StompPrincipal or the one they authenticated as, so reconnecting
+// does not escape a ban. Connections knowing neither are keyed on their id.
+func (g *Guard) StompMiddleware() stompserver.MiddlewareFunc {
+	return func(next stompserver.FrameHandlerFunc) stompserver.FrameHandlerFunc {
+		return func(conn stompserver.StompConn, f *frame.Frame) error {
+			obs := &Observation{Source: SourceStomp, Time: clock.Now()}
+			if addr := conn.GetInfo().RemoteAddress; addr != "" {
+				obs.IP = hostOf(addr)
+			}
+			if g.config.StompPrincipal != nil {
+				obs.Principal = g.config.StompPrincipal(conn)
+			} else {
+				obs.Principal = conn.GetPrincipal()
+			}
+			if obs.IP == "" && obs.Principal == "" {
+				obs.Principal = "conn:" + conn.GetId()
+			}
+			keys := obs.Keys()
+
+			if g.IsBanned(keys...) {
+				g.reject(obs, "client is banned")
+				return fmt.Errorf("client is banned")
+			}
+			if g.RequiresChallenge(keys...) {
+				g.reject(obs, "stepped-up authentication required")
+				return fmt.Errorf("stepped-up authentication required")
+			}
+
+			err := next(conn, f)
+			obs.Failed = err != nil
+			g.Observe(obs)
+			return err
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package abuse
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/pb33f/ranch/stompserver"
+	"github.com/stretchr/testify/assert"
+)
+
+type testStompConn struct {
+	stompserver.StompConn
+	id        string
+	addr      string
+	principal string
+}
+
+func (c *testStompConn) GetId() string        { return c.id }
+func (c *testStompConn) GetPrincipal() string { return c.principal }
+func (c *testStompConn) GetInfo() *stompserver.ConnectionInfo {
+	return &stompserver.ConnectionInfo{Id: c.id, RemoteAddress: c.addr, Principal: c.principal}
+}
+
+func TestGuard_StompMiddleware(t *testing.T) {
+	g := NewGuard(nil, NewErrorRateDetector(0.5, 2, time.Minute, ActionBan))
+	failing := g.StompMiddleware()(func(conn stompserver.StompConn, f *frame.Frame) error {
+		return fmt.Errorf("rejected")
+	})
+	conn := &testStompConn{id: "abc"}
+
+	assert.Error(t, failing(conn, frame.New(frame.SEND)))
+	assert.Error(t, failing(conn, frame.New(frame.SEND)))
+	assert.True(t, g.IsBanned(PrincipalKey("conn:abc")))
+
+	passing := g.StompMiddleware()(func(conn stompserver.StompConn, f *frame.Frame) error {
+		return nil
+	})
+	assert.EqualError(t, passing(conn, frame.New(frame.SEND)), "client is banned")
+	assert.NoError(t, passing(&testStompConn{id: "other"}, frame.New(frame.SEND)))
+}
+
+func TestGuard_StompMiddleware_KeysOnAddressAndPrincipal(t *testing.T) {
+	g := NewGuard(nil, NewErrorRateDetector(0.5, 2, time.Minute, ActionBan))
+	failing := g.StompMiddleware()(func(conn stompserver.StompConn, f *frame.Frame) error {
+		return fmt.Errorf("rejected")
+	})
+	passing := g.StompMiddleware()(func(conn stompserver.StompConn, f *frame.Frame) error {
+		return nil
+	})
+
+	conn := &testStompConn{id: "abc", addr: "10.0.0.1:5000", principal: "scanner"}
+	assert.Error(t, failing(conn, frame.New(frame.SEND)))
+	assert.Error(t, failing(conn, frame.New(frame.SEND)))
+	assert.True(t, g.IsBanned(IPKey("10.0.0.1")))
+	assert.True(t, g.IsBanned(PrincipalKey("scanner")))
+	assert.False(t, g.IsBanned(PrincipalKey("conn:abc")))
+
+	// reconnecting gets a new connection id, but not a new address or principal
+	assert.EqualError(t, passing(&testStompConn{id: "def", addr: "10.0.0.1:6000"}, frame.New(frame.SEND)),
+		"client is banned")
+	assert.EqualError(t, passing(&testStompConn{id: "ghi", addr: "10.0.0.2:5000", principal: "scanner"},
+		frame.New(frame.SEND)), "client is banned")
+	assert.NoError(t, passing(&testStompConn{id: "jkl", addr: "10.0.0.2:5000"}, frame.New(frame.SEND)))
+}
@@ -0,0 +1,291 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+// Package acl reads access control lists from a YAML file, so who may read and write channels, call REST
+// routes and read and write stores is reviewed in one place. Rules grant access to roles, principals get
+// their roles from the file and from a resolver, e.g. reading the claims of their token. A file looks like:
+//
+//	default: deny            # resources no rule matches are denied, allowed if omitted
+//	principals:
+//	  alice: [admin]
+//	channels:
+//	  - channel: orders-*    # a trailing * matches every name starting with what precedes it
+//	    read: [admin, clerk]
+//	    write: [admin]
+//	routes:
+//	  - path: /api/orders/*
+//	    methods: [GET]       # every method if omitted
+//	    roles: [clerk]
+//	stores:
+//	  - store: prices
+//	    read: ["*"]          # AnyRole, every client including anonymous ones
+//	    write: [admin]
+//
+// The first rule matching a resource decides, later ones are not considered.
+package acl
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// AnyRole in a rule grants access to every client, authenticated or not.
+const AnyRole = "*"
+
+// Defaults for resources no rule matches.
+const (
+	DefaultAllow = "allow"
+	DefaultDeny  = "deny"
+)
+
+// Policy is the content of an access control file.
+type Policy struct {
+	Default    string              `yaml:"default"`    // DefaultAllow or DefaultDeny, allow if empty
+	Principals map[string][]string `yaml:"principals"` // roles by principal
+	Channels   []*ChannelRule      `yaml:"channels"`
+	Routes     []*RouteRule        `yaml:"routes"`
+	Stores     []*StoreRule        `yaml:"stores"`
+}
+
+// ChannelRule grants roles access to the channels matching Channel, over the fabric.
+type ChannelRule struct {
+	Channel string   `yaml:"channel"`
+	Read    []string `yaml:"read"`  // roles that may subscribe to the channels
+	Write   []string `yaml:"write"` // roles that may send requests to the channels
+}
+
+// RouteRule grants roles access to the REST routes matching Path, with one of the methods.
+type RouteRule struct {
+	Path    string   `yaml:"path"`
+	Methods []string `yaml:"methods"` // every method if empty
+	Roles   []string `yaml:"roles"`
+}
+
+// StoreRule grants roles access to the stores matching Store.
+type StoreRule struct {
+	Store string   `yaml:"store"`
+	Read  []string `yaml:"read"`  // roles that may open or subscribe to the stores
+	Write []string `yaml:"write"` // roles that may update the stores
+}
+
+// DenyAll is a policy denying every client access to everything, used when a file cannot be read.
+var DenyAll = &Policy{Default: DefaultDeny}
+
+// Parse reads a policy from YAML.
+func Parse(data []byte) (*Policy, error) {
+	var policy Policy
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&policy); err != nil {
+		return nil, fmt.Errorf("invalid access control list: %w", err)
+	}
+	if err := policy.validate(); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+func (p *Policy) validate() error {
+	if p.Default != "" && p.Default != DefaultAllow && p.Default != DefaultDeny {
+		return fmt.Errorf("access control list has an unknown default '%s'", p.Default)
+	}
+	for _, rule := range p.Channels {
+		if rule.Channel == "" {
+			return fmt.Errorf("access control list has a channel rule without a channel")
+		}
+	}
+	for _, rule := range p.Routes {
+		if rule.Path == "" {
+			return fmt.Errorf("access control list has a route rule without a path")
+		}
+	}
+	for _, rule := range p.Stores {
+		if rule.Store == "" {
+			return fmt.Errorf("access control list has a store rule without a store")
+		}
+	}
+	return nil
+}
+
+// RoleResolver returns the roles of a principal besides those listed in the file.
+type RoleResolver func(principal string) []string
+
+// ACL decides what clients may do with the policy of an access control file, which can be replaced at any
+// time, e.g. when the file changed (see Reload).
+type ACL struct {
+	policy  atomic.Pointer[Policy]
+	roles   RoleResolver
+	file    string
+	lock    sync.Mutex
+	modTime time.Time
+	size    int64
+}
+
+// New returns an ACL enforcing the policy, roles may be nil.
+func New(policy *Policy, roles RoleResolver) *ACL {
+	acl := &ACL{roles: roles}
+	acl.policy.Store(policy)
+	return acl
+}
+
+// NewFromFile returns an ACL enforcing the policy of the file. If the file cannot be read, the ACL denies
+// everything until Reload succeeds, and the error is returned.
+func NewFromFile(file string, roles RoleResolver) (*ACL, error) {
+	acl := New(DenyAll, roles)
+	acl.file = file
+	_, err := acl.Reload()
+	return acl, err
+}
+
+// Reload reads the file of the ACL again if it changed since it was last read, returning true if the policy
+// was replaced. The previous policy stays in force if the file cannot be read.
+func (a *ACL) Reload() (bool, error) {
+	if a.file == "" {
+		return false, nil
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	info, err := os.Stat(a.file)
+	if err != nil {
+		return false, err
+	}
+	if info.ModTime().Equal(a.modTime) && info.Size() == a.size {
+		return false, nil
+	}
+	data, err := os.ReadFile(a.file)
+	if err != nil {
+		return false, err
+	}
+	policy, err := Parse(data)
+	if err != nil {
+		return false, err
+	}
+	a.policy.Store(policy)
+	a.modTime, a.size = info.ModTime(), info.Size()
+	return true, nil
+}
+
+// Policy returns the policy in force.
+func (a *ACL) Policy() *Policy {
+	return a.policy.Load()
+}
+
+// SetPolicy replaces the policy in force.
+func (a *ACL) SetPolicy(policy *Policy) {
+	a.policy.Store(policy)
+}
+
+// CanReadChannel returns true if the principal may subscribe to the channel. An empty principal is an
+// anonymous client.
+func (a *ACL) CanReadChannel(channel string, principal string) bool {
+	policy := a.Policy()
+	for _, rule := range policy.Channels {
+		if matches(rule.Channel, channel) {
+			return a.granted(policy, rule.Read, principal)
+		}
+	}
+	return policy.Default != DefaultDeny
+}
+
+// CanWriteChannel returns true if the principal may send requests to the channel.
+func (a *ACL) CanWriteChannel(channel string, principal string) bool {
+	policy := a.Policy()
+	for _, rule := range policy.Channels {
+		if matches(rule.Channel, channel) {
+			return a.granted(policy, rule.Write, principal)
+		}
+	}
+	return policy.Default != DefaultDeny
+}
+
+// CanAccessRoute returns true if the principal may call the REST route at the path with the method.
+func (a *ACL) CanAccessRoute(method string, path string, principal string) bool {
+	policy := a.Policy()
+	for _, rule := range policy.Routes {
+		if matches(rule.Path, path) && (len(rule.Methods) == 0 || slices.ContainsFunc(rule.Methods,
+			func(m string) bool { return strings.EqualFold(m, method) })) {
+			return a.granted(policy, rule.Roles, principal)
+		}
+	}
+	return policy.Default != DefaultDeny
+}
+
+// CanReadStore returns true if the principal may open or subscribe to the store.
+func (a *ACL) CanReadStore(store string, principal string) bool {
+	policy := a.Policy()
+	for _, rule := range policy.Stores {
+		if matches(rule.Store, store) {
+			return a.granted(policy, rule.Read, principal)
+		}
+	}
+	return policy.Default != DefaultDeny
+}
+
+// CanWriteStore returns true if the principal may update the store.
+func (a *ACL) CanWriteStore(store string, principal string) bool {
+	policy := a.Policy()
+	for _, rule := range policy.Stores {
+		if matches(rule.Store, store) {
+			return a.granted(policy, rule.Write, principal)
+		}
+	}
+	return policy.Default != DefaultDeny
+}
+
+// Middleware refuses REST requests the principal returned by principal may not make with a 403.
+func (a *ACL) Middleware(principal func(r *http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p := ""
+			if principal != nil {
+				p = principal(r)
+			}
+			if !a.CanAccessRoute(r.Method, r.URL.Path, p) {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// granted returns true if the principal has one of the roles.
+func (a *ACL) granted(policy *Policy, roles []string, principal string) bool {
+	if slices.Contains(roles, AnyRole) {
+		return true
+	}
+	if principal == "" {
+		return false
+	}
+	for _, role := range policy.Principals[principal] {
+		if slices.Contains(roles, role) {
+			return true
+		}
+	}
+	if a.roles != nil {
+		for _, role := range a.roles(principal) {
+			if slices.Contains(roles, role) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matches returns true if the name matches the pattern, equal to it or starting with what precedes its
+// trailing *.
+func matches(pattern string, name string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(name, prefix)
+	}
+	return pattern == name
+}
@@ -0,0 +1,126 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package acl
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testPolicy = `
+default: deny
+principals:
+  alice: [admin]
+  bob: [clerk]
+channels:
+  - channel: orders-audit
+    read: [admin]
+  - channel: orders-*
+    read: [admin, clerk]
+    write: [admin]
+  - channel: weather
+    read: ["*"]
+routes:
+  - path: /api/orders/*
+    methods: [get]
+    roles: [clerk, admin]
+  - path: /health
+    roles: ["*"]
+stores:
+  - store: prices
+    read: ["*"]
+    write: [pricing]
+`
+
+func TestParse(t *testing.T) {
+	policy, err := Parse([]byte(testPolicy))
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultDeny, policy.Default)
+	assert.Len(t, policy.Channels, 3)
+
+	_, err = Parse([]byte("default: maybe"))
+	assert.Error(t, err)
+	_, err = Parse([]byte("channels:\n  - read: [admin]"))
+	assert.Error(t, err)
+	_, err = Parse([]byte("chanels: []"))
+	assert.Error(t, err, "unknown fields are typos, not ignored")
+}
+
+func TestACL_Checks(t *testing.T) {
+	policy, _ := Parse([]byte(testPolicy))
+	acl := New(policy, func(principal string) []string {
+		if principal == "carol" {
+			return []string{"pricing"}
+		}
+		return nil
+	})
+
+	// the first matching rule decides
+	assert.True(t, acl.CanReadChannel("orders-audit", "alice"))
+	assert.False(t, acl.CanReadChannel("orders-audit", "bob"))
+	assert.True(t, acl.CanReadChannel("orders-eu", "bob"))
+	assert.False(t, acl.CanWriteChannel("orders-eu", "bob"))
+	assert.True(t, acl.CanWriteChannel("orders-eu", "alice"))
+	assert.True(t, acl.CanReadChannel("weather", ""))
+	assert.False(t, acl.CanReadChannel("payroll", "alice"))
+
+	assert.True(t, acl.CanAccessRoute(http.MethodGet, "/api/orders/7", "bob"))
+	assert.False(t, acl.CanAccessRoute(http.MethodDelete, "/api/orders/7", "alice"))
+	assert.True(t, acl.CanAccessRoute(http.MethodPost, "/health", ""))
+
+	// roles come from the resolver too
+	assert.True(t, acl.CanWriteStore("prices", "carol"))
+	assert.False(t, acl.CanWriteStore("prices", "alice"))
+	assert.True(t, acl.CanReadStore("prices", ""))
+
+	acl.SetPolicy(&Policy{})
+	assert.True(t, acl.CanReadChannel("payroll", ""), "everything is allowed by default")
+}
+
+func TestACL_Middleware(t *testing.T) {
+	policy, _ := Parse([]byte(testPolicy))
+	handler := New(policy, nil).Middleware(func(r *http.Request) string {
+		return r.Header.Get("X-User")
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	r := httptest.NewRequest(http.MethodGet, "/api/orders/7", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	r.Header.Set("X-User", "bob")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestACL_Reload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "acl.yaml")
+	acl, err := NewFromFile(file, nil)
+	assert.Error(t, err)
+	assert.False(t, acl.CanReadChannel("weather", ""), "everything is denied until the file can be read")
+
+	assert.NoError(t, os.WriteFile(file, []byte(testPolicy), 0o600))
+	reloaded, err := acl.Reload()
+	assert.NoError(t, err)
+	assert.True(t, reloaded)
+	assert.True(t, acl.CanReadChannel("weather", ""))
+
+	reloaded, err = acl.Reload()
+	assert.NoError(t, err)
+	assert.False(t, reloaded, "the file did not change")
+
+	// an invalid file leaves the policy in force
+	assert.NoError(t, os.WriteFile(file, []byte("default: maybe"), 0o600))
+	assert.NoError(t, os.Chtimes(file, time.Now(), time.Now().Add(time.Minute)))
+	_, err = acl.Reload()
+	assert.Error(t, err)
+	assert.True(t, acl.CanReadChannel("weather", ""))
+}
@@ -0,0 +1,322 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+// Package apidocs documents the API of a ranch deployment: its REST bridges as an OpenAPI document, its
+// service channels as an AsyncAPI document, and both as a searchable HTML reference.
+package apidocs
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pb33f/ranch/service"
+)
+
+// Info describes the documented API.
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// Endpoint is a documented REST bridge.
+type Endpoint struct {
+	Method      string                   // HTTP method, empty if every method is bridged
+	Path        string                   // path template of the bridge, or the path prefix of a prefix bridge
+	Prefix      bool                     // every path under Path is bridged
+	Channel     string                   // service channel requests are sent to
+	Summary     string                   // one line summary
+	Description string                   // longer description
+	Examples    []*service.BridgeExample // example requests and responses
+}
+
+// NewEndpoint returns the documentation of a REST bridge, prefix is set for bridges of a path prefix.
+func NewEndpoint(config *service.RESTBridgeConfig, prefix bool) *Endpoint {
+	e := &Endpoint{
+		Method:      strings.ToUpper(config.Method),
+		Path:        config.Uri,
+		Prefix:      prefix,
+		Channel:     config.ServiceChannel,
+		Summary:     config.Summary,
+		Description: config.Description,
+		Examples:    config.Examples,
+	}
+	if prefix {
+		e.Method = ""
+	}
+	return e
+}
+
+// Broker describes the fabric broker the service channels are reached through.
+type Broker struct {
+	Url              string // URL of the broker, e.g. ws://localhost:30080/ws
+	Protocol         string // stomp, or stomps for STOMP over TLS
+	TopicPrefix      string // prefix of the destinations responses are published on, e.g. /topic
+	AppRequestPrefix string // prefix of the destinations requests are sent to, e.g. /pub
+}
+
+// prefixMethods are the methods a prefix bridge is documented with.
+var prefixMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// pathParameter matches a variable of a mux path template, e.g. {id} or {id:[0-9]+}.
+var pathParameter = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+// OpenAPI is an OpenAPI 3 document.
+type OpenAPI struct {
+	OpenAPI string                                  `json:"openapi"`
+	Info    Info                                    `json:"info"`
+	Paths   map[string]map[string]*OpenAPIOperation `json:"paths"`
+}
+
+// OpenAPIOperation is an operation of an OpenAPI document.
+type OpenAPIOperation struct {
+	OperationId string                      `json:"operationId"`
+	Summary     string                      `json:"summary,omitempty"`
+	Description string                      `json:"description,omitempty"`
+	Tags        []string                    `json:"tags"`
+	Parameters  []*OpenAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *OpenAPIBody                `json:"requestBody,omitempty"`
+	Responses   map[string]*OpenAPIResponse `json:"responses"`
+	Channel     string                      `json:"x-ranch-channel"`
+}
+
+// OpenAPIParameter is a path parameter of an OpenAPI operation.
+type OpenAPIParameter struct {
+	Name     string            `json:"name"`
+	In       string            `json:"in"`
+	Required bool              `json:"required"`
+	Schema   map[string]string `json:"schema"`
+}
+
+// OpenAPIBody is a request body of an OpenAPI operation.
+type OpenAPIBody struct {
+	Content map[string]*OpenAPIMedia `json:"content"`
+}
+
+// OpenAPIResponse is a response of an OpenAPI operation.
+type OpenAPIResponse struct {
+	Description string                   `json:"description"`
+	Content     map[string]*OpenAPIMedia `json:"content,omitempty"`
+}
+
+// OpenAPIMedia holds the examples of a body.
+type OpenAPIMedia struct {
+	Examples map[string]*OpenAPIExample `json:"examples,omitempty"`
+}
+
+// OpenAPIExample is an example body.
+type OpenAPIExample struct {
+	Value interface{} `json:"value"`
+}
+
+// NewOpenAPI documents REST bridges as an OpenAPI document.
+func NewOpenAPI(info Info, endpoints []*Endpoint) *OpenAPI {
+	doc := &OpenAPI{OpenAPI: "3.0.3", Info: info, Paths: make(map[string]map[string]*OpenAPIOperation)}
+	for _, e := range endpoints {
+		path, parameters := openAPIPath(e)
+		methods := []string{e.Method}
+		if e.Prefix {
+			methods = prefixMethods
+		}
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = make(map[string]*OpenAPIOperation)
+		}
+		for _, method := range methods {
+			doc.Paths[path][strings.ToLower(method)] = openAPIOperation(e, method, path, parameters)
+		}
+	}
+	return doc
+}
+
+// openAPIPath returns the OpenAPI path of an endpoint and its parameters. Prefix bridges take the rest of
+// the path as a parameter.
+func openAPIPath(e *Endpoint) (string, []*OpenAPIParameter) {
+	var parameters []*OpenAPIParameter
+	path := pathParameter.ReplaceAllStringFunc(e.Path, func(variable string) string {
+		name := pathParameter.FindStringSubmatch(variable)[1]
+		parameters = append(parameters, &OpenAPIParameter{
+			Name: name, In: "path", Required: true, Schema: map[string]string{"type": "string"},
+		})
+		return "{" + name + "}"
+	})
+	if e.Prefix {
+		path = strings.TrimSuffix(path, "/") + "/{path}"
+		parameters = append(parameters, &OpenAPIParameter{
+			Name: "path", In: "path", Required: true, Schema: map[string]string{"type": "string"},
+		})
+	}
+	return path, parameters
+}
+
+func openAPIOperation(e *Endpoint, method, path string, parameters []*OpenAPIParameter) *OpenAPIOperation {
+	op := &OpenAPIOperation{
+		OperationId: strings.ToLower(method) + operationName(path),
+		Summary:     e.Summary,
+		Description: e.Description,
+		Tags:        []string{e.Channel},
+		Parameters:  parameters,
+		Responses:   map[string]*OpenAPIResponse{"200": {Description: "response of " + e.Channel}},
+		Channel:     e.Channel,
+	}
+	requests := make(map[string]*OpenAPIExample)
+	responses := make(map[string]*OpenAPIExample)
+	for i, example := range e.Examples {
+		name := exampleName(example, i)
+		if example.Request != nil {
+			requests[name] = &OpenAPIExample{Value: example.Request}
+		}
+		if example.Response != nil {
+			responses[name] = &OpenAPIExample{Value: example.Response}
+		}
+	}
+	// GET requests carry no body
+	if len(requests) > 0 && method != http.MethodGet && method != http.MethodHead {
+		op.RequestBody = &OpenAPIBody{Content: map[string]*OpenAPIMedia{"application/json": {Examples: requests}}}
+	}
+	if len(responses) > 0 {
+		op.Responses["200"].Content = map[string]*OpenAPIMedia{"application/json": {Examples: responses}}
+	}
+	return op
+}
+
+// operationName turns a path into the suffix of an operation id, e.g. /rest/stock/{id} into
+// -rest-stock-id.
+func operationName(path string) string {
+	return strings.NewReplacer("/", "-", "{", "", "}", "").Replace(strings.TrimSuffix(path, "/"))
+}
+
+func exampleName(example *service.BridgeExample, i int) string {
+	if example.Name != "" {
+		return example.Name
+	}
+	return "example-" + strconv.Itoa(i+1)
+}
+
+// AsyncAPI is an AsyncAPI 2 document.
+type AsyncAPI struct {
+	AsyncAPI string                          `json:"asyncapi"`
+	Info     Info                            `json:"info"`
+	Servers  map[string]*AsyncAPIServer      `json:"servers,omitempty"`
+	Channels map[string]*AsyncAPIChannelItem `json:"channels"`
+}
+
+// AsyncAPIServer is the broker of an AsyncAPI document.
+type AsyncAPIServer struct {
+	Url      string `json:"url"`
+	Protocol string `json:"protocol"`
+}
+
+// AsyncAPIChannelItem is a channel of an AsyncAPI document.
+type AsyncAPIChannelItem struct {
+	Description string             `json:"description,omitempty"`
+	Publish     *AsyncAPIOperation `json:"publish"`
+	Subscribe   *AsyncAPIOperation `json:"subscribe"`
+}
+
+// AsyncAPIOperation is an operation on a channel of an AsyncAPI document.
+type AsyncAPIOperation struct {
+	OperationId string           `json:"operationId"`
+	Summary     string           `json:"summary"`
+	Message     *AsyncAPIMessage `json:"message"`
+	Destination string           `json:"x-ranch-destination,omitempty"`
+}
+
+// AsyncAPIMessage is a message of an AsyncAPI operation.
+type AsyncAPIMessage struct {
+	Name     string                   `json:"name"`
+	Payload  map[string]interface{}   `json:"payload"`
+	Examples []*AsyncAPIMessageSample `json:"examples,omitempty"`
+}
+
+// AsyncAPIMessageSample is an example message.
+type AsyncAPIMessageSample struct {
+	Name    string      `json:"name"`
+	Payload interface{} `json:"payload"`
+}
+
+var requestSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"id":      map[string]string{"type": "string", "format": "uuid"},
+		"request": map[string]string{"type": "string", "description": "command of the request"},
+		"payload": map[string]interface{}{},
+	},
+}
+
+var responseSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"id":           map[string]string{"type": "string", "format": "uuid"},
+		"payload":      map[string]interface{}{},
+		"error":        map[string]string{"type": "boolean"},
+		"errorCode":    map[string]string{"type": "integer"},
+		"errorMessage": map[string]string{"type": "string"},
+	},
+}
+
+// NewAsyncAPI documents service channels as an AsyncAPI document, with the examples of the REST bridges of
+// each channel. The broker is left out if nil.
+func NewAsyncAPI(info Info, broker *Broker, channels []string, endpoints []*Endpoint) *AsyncAPI {
+	doc := &AsyncAPI{AsyncAPI: "2.6.0", Info: info, Channels: make(map[string]*AsyncAPIChannelItem)}
+	if broker != nil {
+		doc.Servers = map[string]*AsyncAPIServer{"fabric": {Url: broker.Url, Protocol: broker.Protocol}}
+	} else {
+		broker = &Broker{}
+	}
+
+	byChannel := make(map[string][]*Endpoint)
+	for _, e := range endpoints {
+		byChannel[e.Channel] = append(byChannel[e.Channel], e)
+	}
+	for _, channel := range channels {
+		item := &AsyncAPIChannelItem{
+			Publish: &AsyncAPIOperation{
+				OperationId: "send-" + channel,
+				Summary:     "requests sent to the service",
+				Message:     &AsyncAPIMessage{Name: "Request", Payload: requestSchema},
+			},
+			Subscribe: &AsyncAPIOperation{
+				OperationId: "receive-" + channel,
+				Summary:     "responses of the service",
+				Message:     &AsyncAPIMessage{Name: "Response", Payload: responseSchema},
+			},
+		}
+		if broker.AppRequestPrefix != "" {
+			item.Publish.Destination = strings.TrimSuffix(broker.AppRequestPrefix, "/") + "/" + channel
+		}
+		if broker.TopicPrefix != "" {
+			item.Subscribe.Destination = strings.TrimSuffix(broker.TopicPrefix, "/") + "/" + channel
+		}
+
+		var bridges []string
+		for _, e := range byChannel[channel] {
+			bridges = append(bridges, e.label())
+			for i, example := range e.Examples {
+				name := exampleName(example, i)
+				if example.Request != nil {
+					item.Publish.Message.Examples = append(item.Publish.Message.Examples,
+						&AsyncAPIMessageSample{Name: name, Payload: example.Request})
+				}
+				if example.Response != nil {
+					item.Subscribe.Message.Examples = append(item.Subscribe.Message.Examples,
+						&AsyncAPIMessageSample{Name: name, Payload: example.Response})
+				}
+			}
+		}
+		if len(bridges) > 0 {
+			item.Description = "bridged to " + strings.Join(bridges, ", ")
+		}
+		doc.Channels[channel] = item
+	}
+	return doc
+}
+
+// label returns the method and path of an endpoint, e.g. GET /rest/stock/{id}.
+func (e *Endpoint) label() string {
+	if e.Prefix {
+		return "* " + strings.TrimSuffix(e.Path, "/") + "/*"
+	}
+	return e.Method + " " + e.Path
+}
@@ -0,0 +1,103 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package apidocs
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/pb33f/ranch/service"
+	"github.com/stretchr/testify/assert"
+)
+
+var testEndpoints = []*Endpoint{
+	NewEndpoint(&service.RESTBridgeConfig{
+		ServiceChannel: "stock-service",
+		Uri:            "/rest/stock/{id:[0-9]+}",
+		Method:         http.MethodPut,
+		Summary:        "Update a stock",
+		Description:    "Sets the price of a stock.",
+		Examples: []*service.BridgeExample{
+			{Request: map[string]int{"price": 42}, Response: map[string]interface{}{"id": "1", "price": 42}},
+		},
+	}, false),
+	NewEndpoint(&service.RESTBridgeConfig{
+		ServiceChannel: "stock-service",
+		Uri:            "/rest/stock/{id:[0-9]+}",
+		Method:         http.MethodGet,
+		Examples:       []*service.BridgeExample{{Name: "ignored", Request: "no body"}},
+	}, false),
+	NewEndpoint(&service.RESTBridgeConfig{ServiceChannel: "files-service", Uri: "/files/"}, true),
+}
+
+func TestNewOpenAPI(t *testing.T) {
+	doc := NewOpenAPI(Info{Title: "stocks", Version: "1"}, testEndpoints)
+	assert.Equal(t, "3.0.3", doc.OpenAPI)
+
+	put := doc.Paths["/rest/stock/{id}"]["put"]
+	if assert.NotNil(t, put) {
+		assert.Equal(t, "put-rest-stock-id", put.OperationId)
+		assert.Equal(t, "Update a stock", put.Summary)
+		assert.Equal(t, []string{"stock-service"}, put.Tags)
+		assert.Equal(t, "stock-service", put.Channel)
+		assert.Equal(t, "id", put.Parameters[0].Name)
+		assert.Equal(t, map[string]int{"price": 42},
+			put.RequestBody.Content["application/json"].Examples["example-1"].Value)
+		assert.NotNil(t, put.Responses["200"].Content["application/json"].Examples["example-1"])
+	}
+
+	// GET requests carry no body
+	assert.Nil(t, doc.Paths["/rest/stock/{id}"]["get"].RequestBody)
+
+	// prefix bridges take every method and the rest of the path
+	files := doc.Paths["/files/{path}"]
+	assert.Len(t, files, 5)
+	assert.Equal(t, "path", files["delete"].Parameters[0].Name)
+}
+
+func TestNewAsyncAPI(t *testing.T) {
+	broker := &Broker{Url: "ws://localhost:30080/ws", Protocol: "stomp", TopicPrefix: "/topic", AppRequestPrefix: "/pub"}
+	doc := NewAsyncAPI(Info{Title: "stocks"}, broker, []string{"stock-service", "ping-pong-service"}, testEndpoints)
+	assert.Equal(t, "2.6.0", doc.AsyncAPI)
+	assert.Equal(t, "ws://localhost:30080/ws", doc.Servers["fabric"].Url)
+
+	stock := doc.Channels["stock-service"]
+	if assert.NotNil(t, stock) {
+		assert.Equal(t, "/pub/stock-service", stock.Publish.Destination)
+		assert.Equal(t, "/topic/stock-service", stock.Subscribe.Destination)
+		assert.Equal(t, "bridged to PUT /rest/stock/{id:[0-9]+}, GET /rest/stock/{id:[0-9]+}", stock.Description)
+		assert.Len(t, stock.Publish.Message.Examples, 2)
+		assert.Len(t, stock.Subscribe.Message.Examples, 1)
+	}
+	assert.Empty(t, doc.Channels["ping-pong-service"].Description)
+	assert.NotContains(t, doc.Channels, "files-service", "only the channels given are documented")
+
+	assert.Nil(t, NewAsyncAPI(Info{}, nil, nil, nil).Servers)
+}
+
+func TestReference_Render(t *testing.T) {
+	info := Info{Title: "stocks", Version: "1", Description: "Prices of <b>stocks</b>."}
+	channels := []string{"stock-service", "files-service"}
+	ref := NewReference(NewOpenAPI(info, testEndpoints), NewAsyncAPI(info, nil, channels, testEndpoints),
+		"/docs/openapi.json", "/docs/asyncapi.json")
+
+	if assert.Len(t, ref.Channels, 2) {
+		assert.Equal(t, "files-service", ref.Channels[0].Name)
+		stock := ref.Channels[1]
+		assert.Len(t, stock.Endpoints, 2)
+		assert.Equal(t, http.MethodGet, stock.Endpoints[0].Method)
+		put := stock.Endpoints[1]
+		if assert.Len(t, put.Examples, 1) {
+			assert.Equal(t, "{\n  \"price\": 42\n}", put.Examples[0].Request)
+		}
+	}
+
+	var html strings.Builder
+	assert.NoError(t, ref.Render(&html))
+	assert.Contains(t, html.String(), "<title>stocks</title>")
+	assert.Contains(t, html.String(), "Prices of &lt;b&gt;stocks&lt;/b&gt;.")
+	assert.Contains(t, html.String(), `data-search="put /rest/stock/{id} update a stock sets the price of a stock."`)
+	assert.Contains(t, html.String(), `<a href="/docs/asyncapi.json">`)
+}
@@ -0,0 +1,192 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package apidocs
+
+import (
+	"encoding/json"
+	"html/template"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Reference is the HTML API reference of a deployment.
+type Reference struct {
+	Info     Info
+	OpenAPI  string // URL of the OpenAPI document
+	AsyncAPI string // URL of the AsyncAPI document
+	Channels []*ReferenceChannel
+}
+
+// ReferenceChannel is a service channel of the HTML API reference, with its REST bridges.
+type ReferenceChannel struct {
+	Name        string
+	Description string
+	Publish     string // destination requests are sent to, empty if unknown
+	Subscribe   string // destination responses are published on, empty if unknown
+	Endpoints   []*ReferenceEndpoint
+}
+
+// ReferenceEndpoint is a REST bridge of the HTML API reference.
+type ReferenceEndpoint struct {
+	Method      string
+	Path        string
+	Summary     string
+	Description string
+	Examples    []*ReferenceExample
+}
+
+// ReferenceExample is an example of the HTML API reference, its bodies rendered as indented JSON.
+type ReferenceExample struct {
+	Name     string
+	Request  string
+	Response string
+}
+
+// NewReference builds the HTML API reference from the OpenAPI and AsyncAPI documents of a deployment, so
+// the page documents exactly what the documents do.
+func NewReference(openAPI *OpenAPI, asyncAPI *AsyncAPI, openAPIUrl, asyncAPIUrl string) *Reference {
+	ref := &Reference{Info: openAPI.Info, OpenAPI: openAPIUrl, AsyncAPI: asyncAPIUrl}
+	channels := make(map[string]*ReferenceChannel)
+	channel := func(name string) *ReferenceChannel {
+		if channels[name] == nil {
+			channels[name] = &ReferenceChannel{Name: name}
+			ref.Channels = append(ref.Channels, channels[name])
+		}
+		return channels[name]
+	}
+
+	for name, item := range asyncAPI.Channels {
+		c := channel(name)
+		c.Description = item.Description
+		c.Publish = item.Publish.Destination
+		c.Subscribe = item.Subscribe.Destination
+	}
+	for path, operations := range openAPI.Paths {
+		for method, op := range operations {
+			e := &ReferenceEndpoint{
+				Method:      strings.ToUpper(method),
+				Path:        path,
+				Summary:     op.Summary,
+				Description: op.Description,
+			}
+			examples := make(map[string]*ReferenceExample)
+			var names []string
+			example := func(name string) *ReferenceExample {
+				if examples[name] == nil {
+					examples[name] = &ReferenceExample{Name: name}
+					names = append(names, name)
+				}
+				return examples[name]
+			}
+			if op.RequestBody != nil {
+				for name, ex := range op.RequestBody.Content["application/json"].Examples {
+					example(name).Request = indentJSON(ex.Value)
+				}
+			}
+			if content := op.Responses["200"].Content; content != nil {
+				for name, ex := range content["application/json"].Examples {
+					example(name).Response = indentJSON(ex.Value)
+				}
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				e.Examples = append(e.Examples, examples[name])
+			}
+			c := channel(op.Channel)
+			c.Endpoints = append(c.Endpoints, e)
+		}
+	}
+
+	sort.Slice(ref.Channels, func(i, j int) bool { return ref.Channels[i].Name < ref.Channels[j].Name })
+	for _, c := range ref.Channels {
+		sort.Slice(c.Endpoints, func(i, j int) bool {
+			if c.Endpoints[i].Path != c.Endpoints[j].Path {
+				return c.Endpoints[i].Path < c.Endpoints[j].Path
+			}
+			return c.Endpoints[i].Method < c.Endpoints[j].Method
+		})
+	}
+	return ref
+}
+
+// Render writes the reference as an HTML page, searchable by channel, path and summary.
+func (ref *Reference) Render(w io.Writer) error {
+	return referenceTemplate.Execute(w, ref)
+}
+
+func indentJSON(value interface{}) string {
+	out, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err.Error()
+	}
+	return string(out)
+}
+
+// search returns the lower case text an item is found by.
+func search(values ...string) string {
+	return strings.ToLower(strings.Join(values, " "))
+}
+
+var referenceTemplate = template.Must(template.New("reference").Funcs(template.FuncMap{"search": search}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Info.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em auto; max-width: 60em; color: #222; }
+input { width: 100%; padding: .5em; font-size: 1em; box-sizing: border-box; }
+section { border-top: 1px solid #ddd; margin-top: 1.5em; }
+.endpoint { margin: 1em 0 1em 1em; }
+.method { display: inline-block; min-width: 4em; font-weight: bold; font-family: monospace; }
+code, pre { font-family: monospace; background: #f5f5f5; }
+pre { padding: .5em; overflow-x: auto; }
+.hidden { display: none; }
+</style>
+</head>
+<body>
+<h1>{{.Info.Title}}{{if .Info.Version}} <small>{{.Info.Version}}</small>{{end}}</h1>
+{{if .Info.Description}}<p>{{.Info.Description}}</p>{{end}}
+<p><a href="{{.OpenAPI}}">OpenAPI</a> · <a href="{{.AsyncAPI}}">AsyncAPI</a></p>
+<input id="search" type="search" placeholder="Search channels, paths and summaries" autofocus>
+{{range .Channels}}
+<section class="channel" data-search="{{search .Name .Description}}">
+<h2><code>{{.Name}}</code></h2>
+{{if .Description}}<p>{{.Description}}</p>{{end}}
+{{if or .Publish .Subscribe}}<p>{{if .Publish}}requests to <code>{{.Publish}}</code>{{end}}{{if and .Publish .Subscribe}}, {{end}}{{if .Subscribe}}responses on <code>{{.Subscribe}}</code>{{end}}</p>{{end}}
+{{range .Endpoints}}
+<div class="endpoint" data-search="{{search .Method .Path .Summary .Description}}">
+<h3><span class="method">{{.Method}}</span> <code>{{.Path}}</code></h3>
+{{if .Summary}}<p><strong>{{.Summary}}</strong></p>{{end}}
+{{if .Description}}<p>{{.Description}}</p>{{end}}
+{{range .Examples}}
+<details><summary>{{.Name}}</summary>
+{{if .Request}}<p>request</p><pre>{{.Request}}</pre>{{end}}
+{{if .Response}}<p>response</p><pre>{{.Response}}</pre>{{end}}
+</details>
+{{end}}
+</div>
+{{end}}
+</section>
+{{end}}
+<script>
+document.getElementById("search").addEventListener("input", function (e) {
+  var terms = e.target.value.toLowerCase().split(/\s+/).filter(Boolean);
+  var matches = function (el) {
+    return terms.every(function (t) { return el.dataset.search.indexOf(t) >= 0; });
+  };
+  document.querySelectorAll("section.channel").forEach(function (section) {
+    var channelMatches = matches(section), any = false;
+    section.querySelectorAll(".endpoint").forEach(function (endpoint) {
+      var show = channelMatches || matches(endpoint);
+      endpoint.classList.toggle("hidden", !show);
+      any = any || show;
+    });
+    section.classList.toggle("hidden", !channelMatches && !any);
+  });
+});
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,201 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+// Package archive keeps the recent responses of bus channels, so they can be replayed to clients later at
+// the pace they were sent, or faster. See ReplayService. High-volume channels can have their payloads
+// compressed and deduplicated, see Config.Compress.
+package archive
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/clock"
+	"github.com/pb33f/ranch/model"
+)
+
+const defaultMaxMessages = 10000
+
+// Config selects the channels archived and how much of their history is kept.
+type Config struct {
+	Channels    []string      // channels whose responses are archived
+	MaxMessages int           // messages kept per channel, the oldest are dropped first, defaults to 10000
+	MaxAge      time.Duration // messages older than this are dropped, 0 keeps them until MaxMessages is reached
+
+	// Store each distinct payload once, compressed when that makes it smaller, however many messages carry
+	// it. Payloads are then returned as the JSON they were encoded to (a json.RawMessage), or as the bytes
+	// they were sent as if they were a []byte. Payloads that cannot be encoded to JSON are kept as they are.
+	Compress bool
+}
+
+// Stats tells how much the archive holds.
+type Stats struct {
+	Messages     int   `json:"messages"`      // messages archived, over every channel
+	Payloads     int   `json:"payloads"`      // distinct payloads stored, when payloads are compressed
+	PayloadBytes int64 `json:"payload_bytes"` // bytes of the payloads of the messages, when payloads are compressed
+	StoredBytes  int64 `json:"stored_bytes"`  // bytes the payloads take once compressed and deduplicated
+}
+
+// Record is an archived message.
+type Record struct {
+	Channel   string      `json:"channel"`   // channel the message was sent on
+	Timestamp time.Time   `json:"timestamp"` // when the message was sent
+	Payload   interface{} `json:"payload"`   // payload of the message
+}
+
+// entry is an archived message, whose payload is held by a blob when payloads are compressed.
+type entry struct {
+	*Record
+	blob *blob
+}
+
+// Archive records the responses sent on its channels.
+type Archive struct {
+	config   *Config
+	eventBus bus.EventBus
+	records  map[string][]*entry // per channel, oldest first
+	blobs    *blobStore
+	handlers []bus.MessageHandler
+	lock     sync.RWMutex
+}
+
+func NewArchive(eventBus bus.EventBus, config *Config) *Archive {
+	return &Archive{
+		config:   config,
+		eventBus: eventBus,
+		records:  make(map[string][]*entry),
+		blobs:    newBlobStore(),
+	}
+}
+
+// Start records the responses sent on the archived channels from now on.
+func (a *Archive) Start() error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if len(a.handlers) > 0 {
+		return fmt.Errorf("archive already started")
+	}
+	cm := a.eventBus.GetChannelManager()
+	for _, channel := range a.config.Channels {
+		if !cm.CheckChannelExists(channel) {
+			cm.CreateChannel(channel)
+		}
+		handler, err := a.eventBus.ListenStream(channel)
+		if err != nil {
+			a.closeHandlers()
+			return err
+		}
+		handler.Handle(func(msg *model.Message) {
+			a.record(channel, msg.Payload)
+		}, func(err error) {})
+		a.handlers = append(a.handlers, handler)
+	}
+	return nil
+}
+
+// Stop stops recording, what was archived so far is kept.
+func (a *Archive) Stop() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.closeHandlers()
+}
+
+func (a *Archive) closeHandlers() {
+	for _, handler := range a.handlers {
+		handler.Close()
+	}
+	a.handlers = nil
+}
+
+// Archives returns true if the responses of the channel are archived.
+func (a *Archive) Archives(channel string) bool {
+	for _, c := range a.config.Channels {
+		if c == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// Messages returns the messages archived for the channel that were sent from from up to, but not
+// including, to, oldest first. A zero from or to leaves that end of the range open.
+func (a *Archive) Messages(channel string, from time.Time, to time.Time) []*Record {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	records := a.records[channel]
+	start := 0
+	if !from.IsZero() {
+		start = sort.Search(len(records), func(i int) bool { return !records[i].Timestamp.Before(from) })
+	}
+	end := len(records)
+	if !to.IsZero() {
+		end = sort.Search(len(records), func(i int) bool { return !records[i].Timestamp.Before(to) })
+	}
+	if start >= end {
+		return nil
+	}
+	messages := make([]*Record, 0, end-start)
+	for _, e := range records[start:end] {
+		if e.blob == nil {
+			messages = append(messages, e.Record)
+		} else {
+			messages = append(messages, &Record{Channel: e.Channel, Timestamp: e.Timestamp, Payload: e.blob.payload()})
+		}
+	}
+	return messages
+}
+
+// Stats returns how much the archive holds.
+func (a *Archive) Stats() *Stats {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	stats := &Stats{Payloads: len(a.blobs.blobs), StoredBytes: a.blobs.storedBytes}
+	for _, records := range a.records {
+		stats.Messages += len(records)
+		for _, e := range records {
+			if e.blob != nil {
+				stats.PayloadBytes += int64(e.blob.size)
+			}
+		}
+	}
+	return stats
+}
+
+func (a *Archive) record(channel string, payload interface{}) {
+	now := clock.Now()
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	e := &entry{Record: &Record{Channel: channel, Timestamp: now, Payload: payload}}
+	if a.config.Compress {
+		if b, err := a.blobs.put(payload); err == nil {
+			e.Record.Payload, e.blob = nil, b
+		}
+	}
+	records := append(a.records[channel], e)
+	maxMessages := a.config.MaxMessages
+	if maxMessages <= 0 {
+		maxMessages = defaultMaxMessages
+	}
+	drop := len(records) - maxMessages
+	if a.config.MaxAge > 0 {
+		cutoff := now.Add(-a.config.MaxAge)
+		if expired := sort.Search(len(records), func(i int) bool {
+			return records[i].Timestamp.After(cutoff)
+		}); expired > drop {
+			drop = expired
+		}
+	}
+	if drop > 0 {
+		for _, dropped := range records[:drop] {
+			if dropped.blob != nil {
+				a.blobs.release(dropped.blob)
+			}
+		}
+		clear(records[:drop])
+		records = records[drop:]
+	}
+	a.records[channel] = records
+}
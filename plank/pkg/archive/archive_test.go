@@ -0,0 +1,275 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package archive
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/clock"
+	"github.com/pb33f/ranch/model"
+	"github.com/pb33f/ranch/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var start = time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+
+// archiveMessages sends a response on the channel at each offset from start, and waits for them to be
+// archived.
+func archiveMessages(t *testing.T, b bus.EventBus, a *Archive, fake *clock.FakeClock, channel string,
+	offsets ...time.Duration) {
+
+	for i, offset := range offsets {
+		fake.Set(start.Add(offset))
+		require.NoError(t, b.SendResponseMessage(channel, i, nil))
+		assert.Eventually(t, func() bool {
+			return len(a.Messages(channel, time.Time{}, time.Time{})) == i+1
+		}, time.Second, time.Millisecond)
+	}
+}
+
+func TestArchive_Messages(t *testing.T) {
+	fake := clock.NewFakeClock(start)
+	clock.Set(fake)
+	defer clock.Reset()
+
+	b := bus.NewEventBusInstance()
+	a := NewArchive(b, &Config{Channels: []string{"metrics"}, MaxMessages: 3})
+	require.NoError(t, a.Start())
+	defer a.Stop()
+	assert.Error(t, a.Start())
+	assert.True(t, a.Archives("metrics"))
+	assert.False(t, a.Archives("other"))
+
+	archiveMessages(t, b, a, fake, "metrics", 0, time.Second, 2*time.Second)
+	records := a.Messages("metrics", start.Add(time.Second), start.Add(2*time.Second))
+	require.Len(t, records, 1)
+	assert.Equal(t, 1, records[0].Payload)
+	assert.Equal(t, start.Add(time.Second), records[0].Timestamp)
+	assert.Nil(t, a.Messages("metrics", start.Add(time.Minute), time.Time{}))
+
+	// the oldest message is dropped once there are more than MaxMessages
+	fake.Set(start.Add(3 * time.Second))
+	require.NoError(t, b.SendResponseMessage("metrics", 3, nil))
+	assert.Eventually(t, func() bool {
+		records = a.Messages("metrics", time.Time{}, time.Time{})
+		return len(records) == 3 && records[2].Payload == 3
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, 1, records[0].Payload)
+}
+
+func TestArchive_MaxAge(t *testing.T) {
+	fake := clock.NewFakeClock(start)
+	clock.Set(fake)
+	defer clock.Reset()
+
+	b := bus.NewEventBusInstance()
+	a := NewArchive(b, &Config{Channels: []string{"metrics"}, MaxAge: time.Minute})
+	require.NoError(t, a.Start())
+	defer a.Stop()
+
+	archiveMessages(t, b, a, fake, "metrics", 0, 30*time.Second)
+	fake.Set(start.Add(70 * time.Second))
+	require.NoError(t, b.SendResponseMessage("metrics", 2, nil))
+	assert.Eventually(t, func() bool {
+		records := a.Messages("metrics", time.Time{}, time.Time{})
+		return len(records) == 2 && records[0].Payload == 1
+	}, time.Second, time.Millisecond)
+}
+
+func TestArchive_Compress(t *testing.T) {
+	fake := clock.NewFakeClock(start)
+	clock.Set(fake)
+	defer clock.Reset()
+
+	b := bus.NewEventBusInstance()
+	a := NewArchive(b, &Config{Channels: []string{"reports"}, MaxMessages: 3, Compress: true})
+	require.NoError(t, a.Start())
+	defer a.Stop()
+
+	report := map[string]interface{}{"rows": strings.Repeat("moo,", 1000)}
+	send := func(payload interface{}, messages int) {
+		require.NoError(t, b.SendResponseMessage("reports", payload, nil))
+		assert.Eventually(t, func() bool { return a.Stats().Messages == messages }, time.Second, time.Millisecond)
+	}
+	send(report, 1)
+	send(report, 2)
+	send([]byte("raw bytes"), 3)
+
+	// identical payloads are stored once, compressed
+	stats := a.Stats()
+	assert.Equal(t, 2, stats.Payloads)
+	assert.EqualValues(t, 2*len(`{"rows":""}`)+2*4000+len("raw bytes"), stats.PayloadBytes)
+	assert.Less(t, stats.StoredBytes, int64(4000))
+
+	// and decompressed when queried
+	records := a.Messages("reports", time.Time{}, time.Time{})
+	require.Len(t, records, 3)
+	assert.JSONEq(t, `{"rows":"`+strings.Repeat("moo,", 1000)+`"}`, string(records[0].Payload.(json.RawMessage)))
+	assert.Equal(t, records[0].Payload, records[1].Payload)
+	assert.Equal(t, []byte("raw bytes"), records[2].Payload)
+
+	// blobs go once no message carries them
+	require.NoError(t, b.SendResponseMessage("reports", 1, nil))
+	require.NoError(t, b.SendResponseMessage("reports", 2, nil))
+	assert.Eventually(t, func() bool {
+		stats = a.Stats()
+		return stats.Messages == 3 && stats.Payloads == 3 && stats.PayloadBytes == int64(len("raw bytes")+2)
+	}, time.Second, time.Millisecond)
+	assert.EqualValues(t, len("raw bytes")+2, stats.StoredBytes)
+}
+
+func newTestReplayService(t *testing.T, maxReplays int) (bus.EventBus, *Archive, *ReplayService, *clock.FakeClock) {
+	fake := clock.NewFakeClock(start)
+	clock.Set(fake)
+	t.Cleanup(clock.Reset)
+
+	b := bus.ResetBus()
+	registry := service.ResetServiceRegistry()
+	a := NewArchive(b, &Config{Channels: []string{"metrics"}})
+	require.NoError(t, a.Start())
+	t.Cleanup(a.Stop)
+	rs := NewReplayService(a, 10, maxReplays)
+	require.NoError(t, registry.RegisterService(rs, "replay-service"))
+	t.Cleanup(rs.Close)
+	return b, a, rs, fake
+}
+
+func listenReplay(t *testing.T, b bus.EventBus) chan *model.Response {
+	responses := make(chan *model.Response, 10)
+	handler, err := b.ListenStream("replay-service")
+	require.NoError(t, err)
+	handler.Handle(func(msg *model.Message) {
+		responses <- msg.Payload.(*model.Response)
+	}, func(err error) {})
+	t.Cleanup(handler.Close)
+	return responses
+}
+
+func receiveReplay(t *testing.T, responses chan *model.Response) *model.Response {
+	select {
+	case rsp := <-responses:
+		return rsp
+	case <-time.After(time.Second):
+		t.Fatal("no replay response")
+	}
+	return nil
+}
+
+func TestReplayService_ReplaysAtSpeed(t *testing.T) {
+	b, a, _, fake := newTestReplayService(t, 0)
+	archiveMessages(t, b, a, fake, "metrics", 0, 4*time.Second, 6*time.Second)
+	responses := listenReplay(t, b)
+
+	id := uuid.New()
+	require.NoError(t, b.SendRequestMessage("replay-service", &model.Request{
+		Id:             &id,
+		RequestCommand: ReplayRequestCommand,
+		Payload:        map[string]interface{}{"channel": "metrics", "speed": 2},
+	}, nil))
+
+	rsp := receiveReplay(t, responses)
+	assert.Equal(t, id, *rsp.Id)
+	msg := rsp.Payload.(*ReplayMessage)
+	assert.Equal(t, 0, msg.Sequence)
+	assert.Equal(t, 0, msg.Payload)
+	assert.Equal(t, start, msg.Timestamp)
+
+	// the 4 second gap is replayed in 2 seconds
+	fake.BlockUntil(1)
+	fake.Advance(1999 * time.Millisecond)
+	select {
+	case <-responses:
+		t.Fatal("replayed too fast")
+	case <-time.After(20 * time.Millisecond):
+	}
+	fake.Advance(time.Millisecond)
+	assert.Equal(t, 1, receiveReplay(t, responses).Payload.(*ReplayMessage).Payload)
+
+	// bus responses may be delivered out of order, messages carry their sequence for clients to order them
+	fake.BlockUntil(1)
+	fake.Advance(time.Second)
+	var last *ReplayMessage
+	var complete *ReplayComplete
+	for i := 0; i < 2; i++ {
+		switch payload := receiveReplay(t, responses).Payload.(type) {
+		case *ReplayMessage:
+			last = payload
+		case *ReplayComplete:
+			complete = payload
+		}
+	}
+	require.NotNil(t, last)
+	assert.Equal(t, 2, last.Sequence)
+	assert.Equal(t, 2, last.Payload)
+	assert.Equal(t, &ReplayComplete{ResponseType: "replayComplete", Channel: "metrics", Messages: 3}, complete)
+}
+
+func TestReplayService_Stop(t *testing.T) {
+	b, a, _, fake := newTestReplayService(t, 1)
+	archiveMessages(t, b, a, fake, "metrics", 0, time.Hour)
+	responses := listenReplay(t, b)
+
+	id := uuid.New()
+	require.NoError(t, b.SendRequestMessage("replay-service", &model.Request{
+		Id:             &id,
+		RequestCommand: ReplayRequestCommand,
+		Payload:        []byte(`{"channel":"metrics"}`),
+	}, nil))
+	receiveReplay(t, responses)
+	fake.BlockUntil(1)
+
+	// only one replay may run at once
+	second := uuid.New()
+	_ = b.SendRequestMessage("replay-service", &model.Request{
+		Id: &second, RequestCommand: ReplayRequestCommand, Payload: map[string]interface{}{"channel": "metrics"},
+	}, nil)
+	rsp := receiveReplay(t, responses)
+	assert.True(t, rsp.Error)
+	assert.Equal(t, 429, rsp.ErrorCode)
+
+	stopId := uuid.New()
+	_ = b.SendRequestMessage("replay-service", &model.Request{
+		Id: &stopId, RequestCommand: StopReplayRequestCommand, Payload: map[string]interface{}{"id": id.String()},
+	}, nil)
+
+	var complete *ReplayComplete
+	for complete == nil {
+		rsp = receiveReplay(t, responses)
+		complete, _ = rsp.Payload.(*ReplayComplete)
+	}
+	assert.True(t, complete.Stopped)
+	assert.Equal(t, 1, complete.Messages)
+}
+
+func TestReplayService_InvalidRequests(t *testing.T) {
+	b, _, _, _ := newTestReplayService(t, 0)
+	responses := listenReplay(t, b)
+
+	for _, payload := range []interface{}{
+		map[string]interface{}{"channel": "not-archived"},
+		map[string]interface{}{"channel": "metrics", "speed": 11},
+		map[string]interface{}{"channel": "metrics", "speed": -1},
+		"not json",
+	} {
+		id := uuid.New()
+		_ = b.SendRequestMessage("replay-service", &model.Request{
+			Id: &id, RequestCommand: ReplayRequestCommand, Payload: payload,
+		}, nil)
+		rsp := receiveReplay(t, responses)
+		assert.True(t, rsp.Error, "%v", payload)
+	}
+
+	id := uuid.New()
+	_ = b.SendRequestMessage("replay-service", &model.Request{
+		Id: &id, RequestCommand: StopReplayRequestCommand, Payload: map[string]interface{}{"id": uuid.NewString()},
+	}, nil)
+	rsp := receiveReplay(t, responses)
+	assert.Equal(t, 404, rsp.ErrorCode)
+}
@@ -0,0 +1,109 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package archive
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+)
+
+// blob is a payload stored once however many messages carry it, compressed when that makes it smaller.
+type blob struct {
+	key        [sha256.Size]byte
+	data       []byte
+	compressed bool
+	raw        bool // the payload was sent as a []byte rather than encoded to JSON
+	size       int  // bytes of the payload before compression
+	refs       int  // messages carrying the payload
+}
+
+// payload returns the payload the blob stores, decompressed: the bytes themselves if it was sent as a
+// []byte, its JSON as a json.RawMessage otherwise.
+func (b *blob) payload() interface{} {
+	data := b.data
+	if b.compressed {
+		inflated, err := io.ReadAll(flate.NewReader(bytes.NewReader(b.data)))
+		if err != nil {
+			return nil // cannot happen, the archive compressed the data itself
+		}
+		data = inflated
+	} else {
+		data = bytes.Clone(data)
+	}
+	if b.raw {
+		return data
+	}
+	return json.RawMessage(data)
+}
+
+// blobStore is a content addressed store of payloads, keyed by the hash of their content.
+type blobStore struct {
+	blobs       map[[sha256.Size]byte]*blob
+	storedBytes int64
+}
+
+func newBlobStore() *blobStore {
+	return &blobStore{blobs: make(map[[sha256.Size]byte]*blob)}
+}
+
+// put stores a payload, or references the blob of an identical payload stored before. Payloads that cannot
+// be encoded to JSON cannot be stored.
+func (s *blobStore) put(payload interface{}) (*blob, error) {
+	data, raw := payload.([]byte)
+	if !raw {
+		var err error
+		if data, err = json.Marshal(payload); err != nil {
+			return nil, err
+		}
+	}
+	h := sha256.New()
+	if raw {
+		h.Write([]byte{1})
+	} else {
+		h.Write([]byte{0})
+	}
+	h.Write(data)
+	var key [sha256.Size]byte
+	h.Sum(key[:0])
+
+	if b, ok := s.blobs[key]; ok {
+		b.refs++
+		return b, nil
+	}
+	b := &blob{key: key, data: bytes.Clone(data), raw: raw, size: len(data), refs: 1}
+	if compressed := deflate(data); compressed != nil && len(compressed) < len(data) {
+		b.data, b.compressed = compressed, true
+	}
+	s.blobs[key] = b
+	s.storedBytes += int64(len(b.data))
+	return b, nil
+}
+
+// release drops a reference to a blob, removing it once no message carries it.
+func (s *blobStore) release(b *blob) {
+	if b.refs--; b.refs > 0 {
+		return
+	}
+	delete(s.blobs, b.key)
+	s.storedBytes -= int64(len(b.data))
+}
+
+// deflate compresses data, nil if it could not.
+func deflate(data []byte) []byte {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestSpeed)
+	if err != nil {
+		return nil
+	}
+	if _, err = w.Write(data); err != nil {
+		return nil
+	}
+	if err = w.Close(); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,225 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package archive
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pb33f/ranch/clock"
+	"github.com/pb33f/ranch/model"
+	"github.com/pb33f/ranch/service"
+)
+
+const (
+	ReplayRequestCommand     = "replay"      // replays archived messages, see ReplayRequest
+	StopReplayRequestCommand = "stop-replay" // stops a replay, see StopReplayRequest
+)
+
+const (
+	defaultMaxSpeed   = 100
+	defaultMaxReplays = 16
+)
+
+// ReplayRequest is the payload of a replay request. Messages are replayed with the gaps they were sent
+// with, divided by Speed.
+type ReplayRequest struct {
+	Channel string    `json:"channel"` // archived channel to replay
+	From    time.Time `json:"from"`    // replay messages sent from this time, defaults to the oldest archived
+	To      time.Time `json:"to"`      // replay messages sent before this time, defaults to now
+	Speed   float64   `json:"speed"`   // 1 replays at the pace messages were sent, 4 four times faster, defaults to 1
+}
+
+// StopReplayRequest is the payload of a stop-replay request.
+type StopReplayRequest struct {
+	Id string `json:"id"` // id of the replay request
+}
+
+// ReplayMessage is sent in response to a replay request for every message replayed.
+type ReplayMessage struct {
+	ResponseType string `json:"responseType"` // should be "replayMessage"
+	Sequence     int    `json:"sequence"`     // position of the message in the replay, from 0
+	*Record
+}
+
+// ReplayComplete is sent in response to a replay request once it is over.
+type ReplayComplete struct {
+	ResponseType string `json:"responseType"` // should be "replayComplete"
+	Channel      string `json:"channel"`
+	Messages     int    `json:"messages"` // number of messages replayed
+	Stopped      bool   `json:"stopped"`  // true if the replay was stopped before the end
+}
+
+// ReplayService streams archived messages to the client that asked for them, as responses to its replay
+// request. Clients send the request to a private destination of the service (e.g. "/pub/queue/<channel>")
+// to have the replay streamed to their own queue, so monitoring UIs can scrub back in time without any
+// service of their own.
+type ReplayService struct {
+	archive    *Archive
+	maxSpeed   float64
+	maxReplays int
+	replays    map[uuid.UUID]chan struct{}
+	wg         sync.WaitGroup
+	lock       sync.Mutex
+}
+
+// NewReplayService creates a service replaying what the archive recorded. Replays faster than maxSpeed
+// (default 100) are refused, as are replays beyond maxReplays (default 16) running at once.
+func NewReplayService(archive *Archive, maxSpeed float64, maxReplays int) *ReplayService {
+	if maxSpeed <= 0 {
+		maxSpeed = defaultMaxSpeed
+	}
+	if maxReplays <= 0 {
+		maxReplays = defaultMaxReplays
+	}
+	return &ReplayService{
+		archive:    archive,
+		maxSpeed:   maxSpeed,
+		maxReplays: maxReplays,
+		replays:    make(map[uuid.UUID]chan struct{}),
+	}
+}
+
+func (rs *ReplayService) HandleServiceRequest(request *model.Request, core service.FabricServiceCore) {
+	switch request.RequestCommand {
+	case ReplayRequestCommand:
+		rs.replay(request, core)
+	case StopReplayRequestCommand:
+		var stop StopReplayRequest
+		if err := decodePayload(request.Payload, &stop); err != nil {
+			core.SendErrorResponse(request, 400, "invalid stop-replay request: "+err.Error())
+			return
+		}
+		id, err := uuid.Parse(stop.Id)
+		if err != nil || !rs.stop(id) {
+			core.SendErrorResponse(request, 404, fmt.Sprintf("no replay with id '%s'", stop.Id))
+			return
+		}
+		core.SendResponse(request, &stop)
+	default:
+		core.HandleUnknownRequest(request)
+	}
+}
+
+func (rs *ReplayService) replay(request *model.Request, core service.FabricServiceCore) {
+	var replay ReplayRequest
+	if err := decodePayload(request.Payload, &replay); err != nil {
+		core.SendErrorResponse(request, 400, "invalid replay request: "+err.Error())
+		return
+	}
+	if request.Id == nil {
+		core.SendErrorResponse(request, 400, "replay request is missing an id")
+		return
+	}
+	if !rs.archive.Archives(replay.Channel) {
+		core.SendErrorResponse(request, 404, fmt.Sprintf("channel '%s' is not archived", replay.Channel))
+		return
+	}
+	if replay.Speed == 0 {
+		replay.Speed = 1
+	}
+	if replay.Speed < 0 || replay.Speed > rs.maxSpeed {
+		core.SendErrorResponse(request, 400, fmt.Sprintf("replay speed must be above 0 and at most %g",
+			rs.maxSpeed))
+		return
+	}
+
+	stopChan := make(chan struct{})
+	rs.lock.Lock()
+	if _, ok := rs.replays[*request.Id]; ok {
+		rs.lock.Unlock()
+		core.SendErrorResponse(request, 409, fmt.Sprintf("replay '%s' is already running", request.Id))
+		return
+	}
+	if len(rs.replays) >= rs.maxReplays {
+		rs.lock.Unlock()
+		core.SendErrorResponse(request, 429, "too many replays running, try again later")
+		return
+	}
+	rs.replays[*request.Id] = stopChan
+	rs.wg.Add(1)
+	rs.lock.Unlock()
+
+	records := rs.archive.Messages(replay.Channel, replay.From, replay.To)
+	go func() {
+		defer rs.wg.Done()
+		sent, stopped := rs.stream(request, core, records, replay.Speed, stopChan)
+		rs.lock.Lock()
+		delete(rs.replays, *request.Id)
+		rs.lock.Unlock()
+		core.SendResponse(request, &ReplayComplete{
+			ResponseType: "replayComplete",
+			Channel:      replay.Channel,
+			Messages:     sent,
+			Stopped:      stopped,
+		})
+	}()
+}
+
+// stream sends the records paced by the gaps between them, returning how many were sent and whether the
+// replay was stopped.
+func (rs *ReplayService) stream(request *model.Request, core service.FabricServiceCore, records []*Record,
+	speed float64, stopChan chan struct{}) (int, bool) {
+
+	for i, record := range records {
+		if i > 0 {
+			delay := time.Duration(float64(record.Timestamp.Sub(records[i-1].Timestamp)) / speed)
+			if delay > 0 {
+				select {
+				case <-stopChan:
+					return i, true
+				case <-clock.After(delay):
+				}
+			}
+		}
+		select {
+		case <-stopChan:
+			return i, true
+		default:
+		}
+		core.SendResponse(request, &ReplayMessage{ResponseType: "replayMessage", Sequence: i, Record: record})
+	}
+	return len(records), false
+}
+
+// stop stops a running replay, returns false if there is none with the id.
+func (rs *ReplayService) stop(id uuid.UUID) bool {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	stopChan, ok := rs.replays[id]
+	if ok {
+		close(stopChan)
+		delete(rs.replays, id)
+	}
+	return ok
+}
+
+// Close stops every running replay and waits for them to end.
+func (rs *ReplayService) Close() {
+	rs.lock.Lock()
+	for id, stopChan := range rs.replays {
+		close(stopChan)
+		delete(rs.replays, id)
+	}
+	rs.lock.Unlock()
+	rs.wg.Wait()
+}
+
+// decodePayload decodes a request payload, sent as JSON or already decoded from it.
+func decodePayload(payload interface{}, target interface{}) error {
+	switch p := payload.(type) {
+	case []byte:
+		return json.Unmarshal(p, target)
+	case string:
+		return json.Unmarshal([]byte(p), target)
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, target)
+}
@@ -0,0 +1,204 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+// Package classify labels inbound traffic with the class it belongs to: the kind of client (bot, browser or
+// service), the API version it calls, the tier of the customer behind it. Classifiers derive the labels from
+// the headers and token claims of HTTP requests and fabric clients, so policies can target classes of
+// traffic rather than individual routes: a Policy rate limits the clients of a class or bounds how many of
+// its requests are served at once, and a Counter counts the traffic of every label.
+package classify
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Labels are the labels of a request, by name, e.g. {"client": "bot", "tier": "free"}.
+type Labels map[string]string
+
+// Input is what classifiers know of a request or a fabric client.
+type Input struct {
+	Header    http.Header            // headers of the HTTP request, or of the CONNECT frame of a fabric client
+	Claims    map[string]interface{} // claims of the token of the client, nil if unknown
+	Principal string                 // principal of the client, empty if anonymous
+}
+
+// Classifier labels traffic. Classifiers run in turn, each seeing the labels set by those before it, and
+// should not replace labels already set. Classifiers are called concurrently.
+type Classifier interface {
+	Classify(in *Input, labels Labels)
+}
+
+// ClassifierFunc adapts a function to a Classifier.
+type ClassifierFunc func(in *Input, labels Labels)
+
+func (f ClassifierFunc) Classify(in *Input, labels Labels) {
+	f(in, labels)
+}
+
+// Engine runs classifiers.
+type Engine struct {
+	classifiers []Classifier
+}
+
+// New creates an Engine running classifiers in order.
+func New(classifiers ...Classifier) *Engine {
+	return &Engine{classifiers: classifiers}
+}
+
+// Classify returns the labels of a request or fabric client, never nil.
+func (e *Engine) Classify(in *Input) Labels {
+	labels := make(Labels)
+	for _, c := range e.classifiers {
+		c.Classify(in, labels)
+	}
+	return labels
+}
+
+// Rule sets a label when a header or a claim matches. The first rule setting a label wins, so later rules
+// with the same label act as fallbacks.
+type Rule struct {
+	Label   string `json:"label"`             // label set
+	Value   string `json:"value,omitempty"`   // value of the label, the value of the header or claim if empty
+	Header  string `json:"header,omitempty"`  // header matched
+	Claim   string `json:"claim,omitempty"`   // claim matched, instead of a header
+	Pattern string `json:"pattern,omitempty"` // regular expression the value must match, any value does if empty
+}
+
+type compiledRule struct {
+	*Rule
+	pattern *regexp.Regexp
+}
+
+// NewRuleClassifier returns a Classifier applying rules in order. Rules matching neither a header nor a
+// claim always set their value, as defaults.
+func NewRuleClassifier(rules []*Rule) (Classifier, error) {
+	compiled := make([]compiledRule, 0, len(rules))
+	for _, rule := range rules {
+		if rule == nil || rule.Label == "" {
+			return nil, fmt.Errorf("classification rule without a label")
+		}
+		if rule.Header != "" && rule.Claim != "" {
+			return nil, fmt.Errorf("classification rule of '%s' matches both a header and a claim", rule.Label)
+		}
+		if rule.Header == "" && rule.Claim == "" && rule.Value == "" {
+			return nil, fmt.Errorf("classification rule of '%s' has no value", rule.Label)
+		}
+		cr := compiledRule{Rule: rule}
+		if rule.Pattern != "" {
+			pattern, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("classification rule of '%s' has an invalid pattern: %w", rule.Label, err)
+			}
+			cr.pattern = pattern
+		}
+		compiled = append(compiled, cr)
+	}
+	return ClassifierFunc(func(in *Input, labels Labels) {
+		for _, rule := range compiled {
+			if _, set := labels[rule.Label]; set {
+				continue
+			}
+			if value, ok := rule.match(in); ok {
+				labels[rule.Label] = value
+			}
+		}
+	}), nil
+}
+
+// match returns the value the rule sets for a request, if it matches it.
+func (r compiledRule) match(in *Input) (string, bool) {
+	var value string
+	switch {
+	case r.Header != "":
+		value = in.Header.Get(r.Header)
+	case r.Claim != "":
+		if claim, ok := in.Claims[r.Claim]; ok && claim != nil {
+			value = fmt.Sprint(claim)
+		}
+	default:
+		return r.Value, true
+	}
+	if value == "" || (r.pattern != nil && !r.pattern.MatchString(value)) {
+		return "", false
+	}
+	if r.Value != "" {
+		return r.Value, true
+	}
+	return value, true
+}
+
+// Kinds of clients, the values of LabelClient.
+const (
+	LabelClient   = "client"
+	ClientBot     = "bot"
+	ClientBrowser = "browser"
+	ClientService = "service"
+)
+
+var botPattern = regexp.MustCompile(`(?i)bot|crawl|spider|slurp|scrap|headless|lighthouse`)
+
+// ClientKind labels the kind of client from its User-Agent header: ClientBot for crawlers and headless
+// browsers, ClientBrowser for browsers and ClientService for anything else, e.g. HTTP libraries.
+var ClientKind Classifier = ClassifierFunc(func(in *Input, labels Labels) {
+	if _, set := labels[LabelClient]; set {
+		return
+	}
+	agent := in.Header.Get("User-Agent")
+	switch {
+	case agent != "" && botPattern.MatchString(agent):
+		labels[LabelClient] = ClientBot
+	case strings.HasPrefix(agent, "Mozilla/"):
+		labels[LabelClient] = ClientBrowser
+	default:
+		labels[LabelClient] = ClientService
+	}
+})
+
+// Selector selects the traffic whose labels have every value it lists. The empty selector selects any.
+type Selector map[string]string
+
+// ParseSelector parses a selector written as comma separated label=value pairs, e.g. "client=bot,tier=free".
+func ParseSelector(s string) (Selector, error) {
+	selector := make(Selector)
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		label, value, ok := strings.Cut(pair, "=")
+		if label, value = strings.TrimSpace(label), strings.TrimSpace(value); !ok || label == "" {
+			return nil, fmt.Errorf("invalid selector '%s'", s)
+		}
+		selector[label] = value
+	}
+	return selector, nil
+}
+
+// Matches returns whether the selector selects traffic with the labels.
+func (s Selector) Matches(labels Labels) bool {
+	for label, value := range s {
+		if labels[label] != value {
+			return false
+		}
+	}
+	return true
+}
+
+type labelsKey struct{}
+
+// NewContext returns a copy of ctx carrying the labels of its request.
+func NewContext(ctx context.Context, labels Labels) context.Context {
+	return context.WithValue(ctx, labelsKey{}, labels)
+}
+
+// FromContext returns the labels carried by ctx, nil if none.
+func FromContext(ctx context.Context) Labels {
+	if ctx == nil {
+		return nil
+	}
+	labels, _ := ctx.Value(labelsKey{}).(Labels)
+	return labels
+}
@@ -0,0 +1,141 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package classify
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pb33f/ranch/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEngine_Classify(t *testing.T) {
+	rules, err := NewRuleClassifier([]*Rule{
+		{Label: "version", Header: "Accept-Version"},
+		{Label: "tier", Claim: "plan", Pattern: "^(gold|silver)$"},
+		{Label: "tier", Header: "X-Internal", Value: "internal"},
+		{Label: "tier", Value: "free"},
+	})
+	require.NoError(t, err)
+	engine := New(rules, ClientKind)
+
+	header := http.Header{}
+	header.Set("Accept-Version", "v2")
+	header.Set("User-Agent", "Mozilla/5.0 (Macintosh)")
+	assert.Equal(t, Labels{"version": "v2", "tier": "gold", "client": ClientBrowser},
+		engine.Classify(&Input{Header: header, Claims: map[string]interface{}{"plan": "gold"}}))
+
+	header = http.Header{}
+	header.Set("X-Internal", "1")
+	header.Set("User-Agent", "Go-http-client/1.1")
+	assert.Equal(t, Labels{"tier": "internal", "client": ClientService},
+		engine.Classify(&Input{Header: header, Claims: map[string]interface{}{"plan": "platinum"}}))
+
+	header = http.Header{}
+	header.Set("User-Agent", "Mozilla/5.0 (compatible; Googlebot/2.1)")
+	assert.Equal(t, Labels{"tier": "free", "client": ClientBot}, engine.Classify(&Input{Header: header}))
+}
+
+func TestNewRuleClassifier_Invalid(t *testing.T) {
+	for _, rule := range []*Rule{
+		{Header: "X-Tier"},
+		{Label: "tier"},
+		{Label: "tier", Header: "X-Tier", Claim: "tier"},
+		{Label: "tier", Header: "X-Tier", Pattern: "("},
+	} {
+		_, err := NewRuleClassifier([]*Rule{rule})
+		assert.Error(t, err)
+	}
+}
+
+func TestParseSelector(t *testing.T) {
+	selector, err := ParseSelector(" client=bot, tier = free ")
+	require.NoError(t, err)
+	assert.Equal(t, Selector{"client": "bot", "tier": "free"}, selector)
+	assert.True(t, selector.Matches(Labels{"client": "bot", "tier": "free", "version": "v1"}))
+	assert.False(t, selector.Matches(Labels{"client": "bot"}))
+
+	selector, err = ParseSelector("")
+	require.NoError(t, err)
+	assert.True(t, selector.Matches(nil))
+
+	_, err = ParseSelector("client")
+	assert.Error(t, err)
+}
+
+func TestContext(t *testing.T) {
+	assert.Nil(t, FromContext(context.Background()))
+	labels := Labels{"client": ClientBot}
+	assert.Equal(t, labels, FromContext(NewContext(context.Background(), labels)))
+}
+
+func TestPolicies_RateLimit(t *testing.T) {
+	mock := clock.NewFakeClock(time.Unix(0, 0))
+	clock.Set(mock)
+	defer clock.Reset()
+
+	policies, err := NewPolicies([]*Policy{{Match: "client=bot", RequestsPerSecond: 2}})
+	require.NoError(t, err)
+	bot := Labels{"client": ClientBot}
+
+	for i := 0; i < 2; i++ {
+		_, rejection, _ := policies.Admit(bot, "crawler")
+		assert.Equal(t, Admitted, rejection)
+	}
+	_, rejection, retryAfter := policies.Admit(bot, "crawler")
+	assert.Equal(t, RateLimited, rejection)
+	assert.Equal(t, 500*time.Millisecond, retryAfter)
+
+	// each client has its own limit, and other classes have none
+	_, rejection, _ = policies.Admit(bot, "spider")
+	assert.Equal(t, Admitted, rejection)
+	for i := 0; i < 10; i++ {
+		_, rejection, _ = policies.Admit(Labels{"client": ClientBrowser}, "crawler")
+		assert.Equal(t, Admitted, rejection)
+	}
+
+	mock.Advance(500 * time.Millisecond)
+	_, rejection, _ = policies.Admit(bot, "crawler")
+	assert.Equal(t, Admitted, rejection)
+}
+
+func TestPolicies_MaxInFlight(t *testing.T) {
+	policies, err := NewPolicies([]*Policy{{Match: "tier=free", MaxInFlight: 1}})
+	require.NoError(t, err)
+	free := Labels{"tier": "free"}
+
+	release, rejection, _ := policies.Admit(free, "a")
+	require.Equal(t, Admitted, rejection)
+	_, rejection, _ = policies.Admit(free, "b")
+	assert.Equal(t, Overloaded, rejection)
+
+	release()
+	release() // releasing twice frees one place only
+	second, rejection, _ := policies.Admit(free, "b")
+	assert.Equal(t, Admitted, rejection)
+	_, rejection, _ = policies.Admit(free, "c")
+	assert.Equal(t, Overloaded, rejection)
+	second()
+
+	_, err = NewPolicies([]*Policy{{Match: "tier=free", MaxInFlight: -1}})
+	assert.Error(t, err)
+	_, err = NewPolicies([]*Policy{{Match: "tier"}})
+	assert.Error(t, err)
+}
+
+func TestCounter(t *testing.T) {
+	counter := NewCounter()
+	counter.Count(Labels{"client": ClientBot, "tier": "free"}, false)
+	counter.Count(Labels{"client": ClientBot}, true)
+	counter.Count(Labels{"client": ClientBrowser}, false)
+	assert.Equal(t, []*ClassStats{
+		{Label: "client", Value: ClientBot, Requests: 2, Rejected: 1},
+		{Label: "client", Value: ClientBrowser, Requests: 1},
+		{Label: "tier", Value: "free", Requests: 1},
+	}, counter.Stats())
+}
@@ -0,0 +1,63 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package classify
+
+import (
+	"sort"
+	"sync"
+)
+
+// ClassStats counts the traffic with a label value.
+type ClassStats struct {
+	Label    string `json:"label"`
+	Value    string `json:"value"`
+	Requests uint64 `json:"requests"` // requests, or fabric connections, with the label value
+	Rejected uint64 `json:"rejected"` // requests a policy turned away
+}
+
+// Counter counts traffic by label value. Safe for concurrent use.
+type Counter struct {
+	lock  sync.Mutex
+	stats map[[2]string]*ClassStats
+}
+
+// NewCounter creates an empty Counter.
+func NewCounter() *Counter {
+	return &Counter{stats: make(map[[2]string]*ClassStats)}
+}
+
+// Count counts traffic with labels, rejected or not.
+func (c *Counter) Count(labels Labels, rejected bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for label, value := range labels {
+		stats, ok := c.stats[[2]string{label, value}]
+		if !ok {
+			stats = &ClassStats{Label: label, Value: value}
+			c.stats[[2]string{label, value}] = stats
+		}
+		stats.Requests++
+		if rejected {
+			stats.Rejected++
+		}
+	}
+}
+
+// Stats returns the counts, by label then value.
+func (c *Counter) Stats() []*ClassStats {
+	c.lock.Lock()
+	stats := make([]*ClassStats, 0, len(c.stats))
+	for _, s := range c.stats {
+		copied := *s
+		stats = append(stats, &copied)
+	}
+	c.lock.Unlock()
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Label != stats[j].Label {
+			return stats[i].Label < stats[j].Label
+		}
+		return stats[i].Value < stats[j].Value
+	})
+	return stats
+}
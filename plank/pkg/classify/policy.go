@@ -0,0 +1,152 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package classify
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pb33f/ranch/clock"
+)
+
+// maxClientBuckets is how many clients a policy keeps rate limits for before those that have not sent
+// requests lately are forgotten.
+const maxClientBuckets = 4096
+
+// Policy limits the traffic of the classes a selector selects.
+type Policy struct {
+	Match             string  `json:"match"`               // selector of the classes, e.g. "client=bot,tier=free", see ParseSelector
+	RequestsPerSecond float64 `json:"requests_per_second"` // requests each client of the classes may send per second, not limited if 0
+	Burst             int     `json:"burst"`               // requests a client may send at once, defaults to a second's worth
+	MaxInFlight       int     `json:"max_in_flight"`       // requests of the classes served at once, not limited if 0
+}
+
+// Rejection is why a policy turned a request away.
+type Rejection int
+
+const (
+	Admitted    Rejection = iota
+	RateLimited           // the client sent more requests than the rate of its class allows
+	Overloaded            // the class has as many requests being served as it may
+)
+
+// bucket refills at rate tokens per second up to burst.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type compiledPolicy struct {
+	*Policy
+	selector Selector
+	burst    float64
+	lock     sync.Mutex
+	buckets  map[string]*bucket // by client
+	inFlight int
+}
+
+// take takes a token of a client, returning how long until one is available if there is none.
+func (p *compiledPolicy) take(client string, now time.Time) time.Duration {
+	b, ok := p.buckets[client]
+	if !ok {
+		if len(p.buckets) >= maxClientBuckets {
+			p.forgetIdle(now)
+		}
+		b = &bucket{tokens: p.burst, last: now}
+		p.buckets[client] = b
+	}
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(p.burst, b.tokens+elapsed*p.RequestsPerSecond)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / p.RequestsPerSecond * float64(time.Second))
+	}
+	b.tokens--
+	return 0
+}
+
+// forgetIdle forgets the clients whose bucket refilled, keeping them would allow them no more.
+func (p *compiledPolicy) forgetIdle(now time.Time) {
+	for client, b := range p.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*p.RequestsPerSecond >= p.burst {
+			delete(p.buckets, client)
+		}
+	}
+}
+
+// Policies enforces policies. Safe for concurrent use.
+type Policies struct {
+	policies []*compiledPolicy
+}
+
+// NewPolicies compiles policies.
+func NewPolicies(policies []*Policy) (*Policies, error) {
+	ps := &Policies{}
+	for _, policy := range policies {
+		if policy == nil {
+			continue
+		}
+		selector, err := ParseSelector(policy.Match)
+		if err != nil {
+			return nil, err
+		}
+		if policy.RequestsPerSecond < 0 || policy.Burst < 0 || policy.MaxInFlight < 0 {
+			return nil, fmt.Errorf("policy of '%s' has a negative limit", policy.Match)
+		}
+		burst := float64(policy.Burst)
+		if burst == 0 {
+			burst = math.Max(1, math.Ceil(policy.RequestsPerSecond))
+		}
+		ps.policies = append(ps.policies, &compiledPolicy{
+			Policy:   policy,
+			selector: selector,
+			burst:    burst,
+			buckets:  make(map[string]*bucket),
+		})
+	}
+	return ps, nil
+}
+
+// Admit checks a request of a client against every policy selecting its labels. An admitted request holds a
+// place in the classes bounding their requests in flight until release is called. A rate limited request is
+// told how long to wait before retrying.
+func (ps *Policies) Admit(labels Labels, client string) (release func(), rejection Rejection, retryAfter time.Duration) {
+	now := clock.Now()
+	var held []*compiledPolicy
+	releaseHeld := func() {
+		for _, p := range held {
+			p.lock.Lock()
+			p.inFlight--
+			p.lock.Unlock()
+		}
+	}
+	for _, p := range ps.policies {
+		if !p.selector.Matches(labels) {
+			continue
+		}
+		p.lock.Lock()
+		if p.MaxInFlight > 0 && p.inFlight >= p.MaxInFlight {
+			p.lock.Unlock()
+			releaseHeld()
+			return nil, Overloaded, 0
+		}
+		if p.RequestsPerSecond > 0 {
+			if wait := p.take(client, now); wait > 0 {
+				p.lock.Unlock()
+				releaseHeld()
+				return nil, RateLimited, wait
+			}
+		}
+		if p.MaxInFlight > 0 {
+			p.inFlight++
+			held = append(held, p)
+		}
+		p.lock.Unlock()
+	}
+	var once sync.Once
+	return func() { once.Do(releaseHeld) }, Admitted, 0
+}
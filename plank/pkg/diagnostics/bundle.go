@@ -0,0 +1,112 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+// Package diagnostics assembles diagnostics bundles, a single zip archive describing a running ranch
+// instance (configuration, recent logs, profiles, manifests and metrics) to attach to bug reports.
+package diagnostics
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"time"
+
+	"github.com/pb33f/ranch/clock"
+)
+
+// ManifestFile is the name of the file listing the contents of a bundle, it is always written last.
+const ManifestFile = "manifest.json"
+
+// Manifest describes a bundle and the files it contains.
+type Manifest struct {
+	Created   time.Time `json:"created"`
+	Hostname  string    `json:"hostname"`
+	GoVersion string    `json:"go_version"`
+	OS        string    `json:"os"`
+	Arch      string    `json:"arch"`
+	Files     []string  `json:"files"`
+	Errors    []string  `json:"errors,omitempty"` // parts of the bundle that could not be collected
+}
+
+// Bundle writes a diagnostics bundle as a zip archive. Files are added one by one and the manifest is
+// written by Close. A Bundle is not safe for concurrent use.
+type Bundle struct {
+	zw       *zip.Writer
+	manifest Manifest
+}
+
+// NewBundle returns a Bundle writing its archive to w.
+func NewBundle(w io.Writer) *Bundle {
+	hostname, _ := os.Hostname()
+	return &Bundle{
+		zw: zip.NewWriter(w),
+		manifest: Manifest{
+			Created:   clock.Now().UTC(),
+			Hostname:  hostname,
+			GoVersion: runtime.Version(),
+			OS:        runtime.GOOS,
+			Arch:      runtime.GOARCH,
+		},
+	}
+}
+
+// AddFile adds a file with the given name and content to the bundle.
+func (b *Bundle) AddFile(name string, data []byte) error {
+	f, err := b.zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: b.manifest.Created,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		return err
+	}
+	b.manifest.Files = append(b.manifest.Files, name)
+	return nil
+}
+
+// AddJSON adds the indented JSON encoding of v to the bundle.
+func (b *Bundle) AddJSON(name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return b.AddFile(name, data)
+}
+
+// AddProfile adds a runtime profile (see pprof.Lookup) to the bundle. A debug value of 0 writes the
+// binary format read by `go tool pprof`, higher values write text (for goroutines, 2 writes full stacks).
+func (b *Bundle) AddProfile(name, profile string, debug int) error {
+	p := pprof.Lookup(profile)
+	if p == nil {
+		return fmt.Errorf("unknown profile '%s'", profile)
+	}
+	var buf bytes.Buffer
+	if err := p.WriteTo(&buf, debug); err != nil {
+		return err
+	}
+	return b.AddFile(name, buf.Bytes())
+}
+
+// AddError records that part of the bundle could not be collected, so the bundle is still produced and
+// the manifest says what is missing.
+func (b *Bundle) AddError(part string, err error) {
+	b.manifest.Errors = append(b.manifest.Errors, fmt.Sprintf("%s: %s", part, err.Error()))
+}
+
+// Close writes the manifest and finishes the archive, it does not close the underlying writer.
+func (b *Bundle) Close() error {
+	manifest := b.manifest
+	manifest.Files = append(append([]string{}, manifest.Files...), ManifestFile)
+	if err := b.AddJSON(ManifestFile, manifest); err != nil {
+		return err
+	}
+	return b.zw.Close()
+}
@@ -0,0 +1,47 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package diagnostics
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBundle(t *testing.T) {
+	var buf bytes.Buffer
+	bundle := NewBundle(&buf)
+	assert.NoError(t, bundle.AddFile("notes.txt", []byte("hello")))
+	assert.NoError(t, bundle.AddJSON("metrics.json", ReadRuntimeMetrics()))
+	assert.NoError(t, bundle.AddProfile("goroutines.txt", "goroutine", 1))
+	assert.Error(t, bundle.AddProfile("nope.pprof", "nope", 0))
+	bundle.AddError("services.json", fmt.Errorf("registry unavailable"))
+	assert.NoError(t, bundle.Close())
+
+	reader, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.NoError(t, err)
+	files := make(map[string][]byte)
+	for _, f := range reader.File {
+		rc, _ := f.Open()
+		files[f.Name], _ = io.ReadAll(rc)
+		_ = rc.Close()
+	}
+	assert.Equal(t, "hello", string(files["notes.txt"]))
+	assert.Contains(t, string(files["goroutines.txt"]), "goroutine profile")
+
+	var metrics RuntimeMetrics
+	assert.NoError(t, json.Unmarshal(files["metrics.json"], &metrics))
+	assert.Positive(t, metrics.Goroutines)
+
+	var manifest Manifest
+	assert.NoError(t, json.Unmarshal(files[ManifestFile], &manifest))
+	assert.Equal(t, []string{"notes.txt", "metrics.json", "goroutines.txt", ManifestFile}, manifest.Files)
+	assert.Equal(t, []string{"services.json: registry unavailable"}, manifest.Errors)
+	assert.NotEmpty(t, manifest.GoVersion)
+}
@@ -0,0 +1,260 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package diagnostics
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pb33f/ranch/plank/pkg/redact"
+)
+
+// DefaultLogBufferSize is the number of log records kept when no size is given.
+const DefaultLogBufferSize = 1000
+
+// SubsystemAttr is the attribute naming the part of the server a record was logged by, e.g. "bus" or
+// "broker-bridge". Records without it belong to the subsystem their message starts with in brackets, as in
+// "[ranch] server started".
+const SubsystemAttr = "subsystem"
+
+// LogEntry is a log record captured by a LogBuffer. Attribute keys of grouped attributes are joined
+// with dots, and secrets are masked (see the redact package).
+type LogEntry struct {
+	Time      time.Time              `json:"time"`
+	Level     string                 `json:"level"`
+	Message   string                 `json:"msg"`
+	Subsystem string                 `json:"subsystem,omitempty"`
+	Attrs     map[string]interface{} `json:"attrs,omitempty"`
+	level     slog.Level
+}
+
+// LogFilter selects log entries by level and subsystem.
+type LogFilter struct {
+	Level      slog.Level // entries below the level are left out
+	Subsystems []string   // entries of other subsystems are left out, every subsystem if empty
+}
+
+// Matches returns true if the entry passes the filter.
+func (f LogFilter) Matches(entry LogEntry) bool {
+	return entry.level >= f.Level && (len(f.Subsystems) == 0 || slices.Contains(f.Subsystems, entry.Subsystem))
+}
+
+// logRing is the fixed size buffer shared by a LogBuffer and the handlers derived from it, and the live
+// subscriptions to the records added.
+type logRing struct {
+	lock          sync.Mutex
+	entries       []LogEntry
+	next          int
+	full          bool
+	subscriptions map[*LogSubscription]struct{}
+}
+
+func (r *logRing) add(entry LogEntry) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.entries[r.next] = entry
+	r.next = (r.next + 1) % len(r.entries)
+	if r.next == 0 {
+		r.full = true
+	}
+	for sub := range r.subscriptions {
+		sub.offer(entry)
+	}
+}
+
+func (r *logRing) snapshot() []LogEntry {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.entriesLocked()
+}
+
+func (r *logRing) entriesLocked() []LogEntry {
+	if !r.full {
+		return append([]LogEntry{}, r.entries[:r.next]...)
+	}
+	return append(append([]LogEntry{}, r.entries[r.next:]...), r.entries[:r.next]...)
+}
+
+// LogBuffer is a slog.Handler that keeps the most recent log records in memory, so they can be included
+// in a diagnostics bundle, and passes every record on to the handler it wraps.
+type LogBuffer struct {
+	next   slog.Handler
+	ring   *logRing
+	attrs  []groupedAttr
+	prefix string
+}
+
+// groupedAttr is an attribute added by WithAttrs along with the groups open at the time.
+type groupedAttr struct {
+	prefix string
+	attr   slog.Attr
+}
+
+// NewLogBuffer wraps next and keeps the last size records it handles, DefaultLogBufferSize if size
+// is not positive.
+func NewLogBuffer(next slog.Handler, size int) *LogBuffer {
+	if size <= 0 {
+		size = DefaultLogBufferSize
+	}
+	return &LogBuffer{next: next, ring: &logRing{entries: make([]LogEntry, size)}}
+}
+
+// Entries returns the buffered records, oldest first.
+func (b *LogBuffer) Entries() []LogEntry {
+	return b.ring.snapshot()
+}
+
+// LogSubscription receives the records added to a LogBuffer that pass its filter, as they are logged.
+type LogSubscription struct {
+	ring    *logRing
+	filter  LogFilter
+	entries chan LogEntry
+	dropped uint64 // guarded by the lock of the ring
+}
+
+// Subscribe returns a subscription to the records logged from now on that pass the filter, along with
+// the buffered records that do, oldest first. Records are dropped rather than slowing logging down when
+// size of them are waiting to be received. Close the subscription once done.
+func (b *LogBuffer) Subscribe(filter LogFilter, size int) (*LogSubscription, []LogEntry) {
+	if size <= 0 {
+		size = DefaultLogBufferSize
+	}
+	sub := &LogSubscription{ring: b.ring, filter: filter, entries: make(chan LogEntry, size)}
+	b.ring.lock.Lock()
+	defer b.ring.lock.Unlock()
+	var recent []LogEntry
+	for _, entry := range b.ring.entriesLocked() {
+		if filter.Matches(entry) {
+			recent = append(recent, entry)
+		}
+	}
+	if b.ring.subscriptions == nil {
+		b.ring.subscriptions = make(map[*LogSubscription]struct{})
+	}
+	b.ring.subscriptions[sub] = struct{}{}
+	return sub, recent
+}
+
+// Entries returns the channel the records are received on.
+func (s *LogSubscription) Entries() <-chan LogEntry {
+	return s.entries
+}
+
+// Dropped returns the number of records dropped since the last call, as they were not received in time.
+func (s *LogSubscription) Dropped() uint64 {
+	s.ring.lock.Lock()
+	defer s.ring.lock.Unlock()
+	dropped := s.dropped
+	s.dropped = 0
+	return dropped
+}
+
+// Close ends the subscription, no more records are sent on Entries.
+func (s *LogSubscription) Close() {
+	s.ring.lock.Lock()
+	defer s.ring.lock.Unlock()
+	delete(s.ring.subscriptions, s)
+}
+
+// offer sends the entry to the subscription if it passes the filter. Called with the lock of the ring held.
+func (s *LogSubscription) offer(entry LogEntry) {
+	if !s.filter.Matches(entry) {
+		return
+	}
+	select {
+	case s.entries <- entry:
+	default:
+		s.dropped++
+	}
+}
+
+// Enabled reports whether the wrapped handler handles records at the given level.
+func (b *LogBuffer) Enabled(ctx context.Context, level slog.Level) bool {
+	return b.next.Enabled(ctx, level)
+}
+
+// Handle buffers the record, then passes it on to the wrapped handler.
+func (b *LogBuffer) Handle(ctx context.Context, r slog.Record) error {
+	attrs := make(map[string]interface{}, len(b.attrs)+r.NumAttrs())
+	for _, ga := range b.attrs {
+		addAttr(attrs, ga.prefix, ga.attr)
+	}
+	r.Attrs(func(attr slog.Attr) bool {
+		addAttr(attrs, b.prefix, attr)
+		return true
+	})
+	entry := LogEntry{Time: r.Time, Level: r.Level.String(), Message: redact.String(r.Message), level: r.Level}
+	entry.Subsystem = subsystem(attrs, r.Message)
+	if len(attrs) > 0 {
+		entry.Attrs = attrs
+	}
+	b.ring.add(entry)
+	return b.next.Handle(ctx, r)
+}
+
+// WithAttrs returns a handler sharing the buffer that adds attrs to every record.
+func (b *LogBuffer) WithAttrs(attrs []slog.Attr) slog.Handler {
+	grouped := make([]groupedAttr, 0, len(b.attrs)+len(attrs))
+	grouped = append(grouped, b.attrs...)
+	for _, attr := range attrs {
+		grouped = append(grouped, groupedAttr{prefix: b.prefix, attr: attr})
+	}
+	return &LogBuffer{next: b.next.WithAttrs(attrs), ring: b.ring, attrs: grouped, prefix: b.prefix}
+}
+
+// WithGroup returns a handler sharing the buffer that nests the attributes of records under name.
+func (b *LogBuffer) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return b
+	}
+	return &LogBuffer{next: b.next.WithGroup(name), ring: b.ring, attrs: b.attrs, prefix: b.prefix + name + "."}
+}
+
+func addAttr(attrs map[string]interface{}, prefix string, attr slog.Attr) {
+	value := attr.Value.Resolve()
+	if value.Kind() == slog.KindGroup {
+		groupPrefix := prefix
+		if attr.Key != "" {
+			groupPrefix = prefix + attr.Key + "."
+		}
+		for _, member := range value.Group() {
+			addAttr(attrs, groupPrefix, member)
+		}
+		return
+	}
+	if attr.Key == "" {
+		return
+	}
+	// keys are checked before prefixing, a grouped password is still a password.
+	key := prefix + attr.Key
+	if redact.IsSecretName(attr.Key) {
+		attrs[key] = redact.Mask
+		return
+	}
+	switch v := value.Any().(type) {
+	case error:
+		attrs[key] = redact.String(v.Error())
+	case time.Duration:
+		attrs[key] = v.String()
+	default:
+		attrs[key] = redact.Value(v)
+	}
+}
+
+// subsystem returns the subsystem of a record: its SubsystemAttr, or the bracketed start of its message.
+func subsystem(attrs map[string]interface{}, message string) string {
+	if name, ok := attrs[SubsystemAttr].(string); ok {
+		return name
+	}
+	if rest, ok := strings.CutPrefix(message, "["); ok {
+		if name, _, ok := strings.Cut(rest, "]"); ok {
+			return name
+		}
+	}
+	return ""
+}
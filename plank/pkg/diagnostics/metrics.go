@@ -0,0 +1,61 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package diagnostics
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/pb33f/ranch/clock"
+)
+
+// RuntimeMetrics is a snapshot of the Go runtime statistics of the process.
+type RuntimeMetrics struct {
+	Goroutines     int           `json:"goroutines"`
+	NumCPU         int           `json:"num_cpu"`
+	GOMAXPROCS     int           `json:"gomaxprocs"`
+	HeapAlloc      uint64        `json:"heap_alloc_bytes"`
+	HeapInuse      uint64        `json:"heap_inuse_bytes"`
+	HeapObjects    uint64        `json:"heap_objects"`
+	StackInuse     uint64        `json:"stack_inuse_bytes"`
+	Sys            uint64        `json:"sys_bytes"`
+	TotalAlloc     uint64        `json:"total_alloc_bytes"`
+	NumGC          uint32        `json:"num_gc"`
+	GCPauseTotal   time.Duration `json:"gc_pause_total_ns"`
+	LastGC         time.Time     `json:"last_gc"`
+	GCCPUFraction  float64       `json:"gc_cpu_fraction"`
+	CgoCalls       int64         `json:"cgo_calls"`
+	ProcessStarted time.Time     `json:"process_started"`
+	Uptime         string        `json:"uptime"`
+}
+
+var processStarted = clock.Now()
+
+// ReadRuntimeMetrics returns the current runtime statistics. It briefly stops the world, as
+// runtime.ReadMemStats does.
+func ReadRuntimeMetrics() *RuntimeMetrics {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	m := &RuntimeMetrics{
+		Goroutines:     runtime.NumGoroutine(),
+		NumCPU:         runtime.NumCPU(),
+		GOMAXPROCS:     runtime.GOMAXPROCS(0),
+		HeapAlloc:      mem.HeapAlloc,
+		HeapInuse:      mem.HeapInuse,
+		HeapObjects:    mem.HeapObjects,
+		StackInuse:     mem.StackInuse,
+		Sys:            mem.Sys,
+		TotalAlloc:     mem.TotalAlloc,
+		NumGC:          mem.NumGC,
+		GCPauseTotal:   time.Duration(mem.PauseTotalNs),
+		GCCPUFraction:  mem.GCCPUFraction,
+		CgoCalls:       runtime.NumCgoCall(),
+		ProcessStarted: processStarted,
+		Uptime:         clock.Since(processStarted).Round(time.Second).String(),
+	}
+	if mem.LastGC > 0 {
+		m.LastGC = time.Unix(0, int64(mem.LastGC))
+	}
+	return m
+}
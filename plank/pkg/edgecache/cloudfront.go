@@ -0,0 +1,150 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package edgecache
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pb33f/ranch/clock"
+)
+
+const (
+	defaultCloudFrontEndpoint = "https://cloudfront.amazonaws.com"
+	cloudFrontApiVersion      = "2020-05-31"
+	cloudFrontRegion          = "us-east-1" // CloudFront is a global service, signed for us-east-1
+	cloudFrontService         = "cloudfront"
+	cloudFrontMaxPaths        = 3000
+)
+
+// CloudFrontPurger creates invalidations on a CloudFront distribution. CloudFront invalidates by path, so
+// Keys are ignored, the server resolves the keys of its own REST bridges to their paths before purging.
+type CloudFrontPurger struct {
+	DistributionId  string       `json:"distribution_id"`                 // id of the distribution fronting the server
+	AccessKeyId     string       `json:"access_key_id"`                   // AWS access key allowed to create invalidations
+	SecretAccessKey string       `json:"secret_access_key" secret:"true"` // AWS secret key
+	SessionToken    string       `json:"session_token" secret:"true"`     // session token of temporary credentials, if used
+	Endpoint        string       `json:"endpoint"`                        // CloudFront API base URL, defaults to https://cloudfront.amazonaws.com
+	Client          *http.Client `json:"-"`                               // defaults to a client with a 30 second timeout
+}
+
+type cloudFrontInvalidationBatch struct {
+	XMLName         xml.Name `xml:"http://cloudfront.amazonaws.com/doc/2020-05-31/ InvalidationBatch"`
+	Quantity        int      `xml:"Paths>Quantity"`
+	Paths           []string `xml:"Paths>Items>Path"`
+	CallerReference string   `xml:"CallerReference"`
+}
+
+func (p *CloudFrontPurger) Name() string {
+	return "cloudfront:" + p.DistributionId
+}
+
+// Purge creates an invalidation for the paths, in batches of the most paths CloudFront accepts at once.
+func (p *CloudFrontPurger) Purge(ctx context.Context, invalidation *Invalidation) error {
+	endpoint := p.Endpoint
+	if endpoint == "" {
+		endpoint = defaultCloudFrontEndpoint
+	}
+	url := fmt.Sprintf("%s/%s/distribution/%s/invalidation", strings.TrimSuffix(endpoint, "/"),
+		cloudFrontApiVersion, p.DistributionId)
+	paths := invalidation.Paths
+	for len(paths) > 0 {
+		batch := paths[:min(len(paths), cloudFrontMaxPaths)]
+		paths = paths[len(batch):]
+
+		body, err := xml.Marshal(&cloudFrontInvalidationBatch{
+			Quantity:        len(batch),
+			Paths:           batch,
+			CallerReference: uuid.New().String(),
+		})
+		if err != nil {
+			return err
+		}
+		body = append([]byte(xml.Header), body...)
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "text/xml")
+		if p.SessionToken != "" {
+			req.Header.Set("X-Amz-Security-Token", p.SessionToken)
+		}
+		signV4(req, body, p.AccessKeyId, p.SecretAccessKey, cloudFrontRegion, cloudFrontService, clock.Now())
+		if err = doPurgeRequest(p.Client, req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// signV4 signs a request with AWS Signature Version 4, signing the host and x-amz-* headers.
+func signV4(req *http.Request, body []byte, accessKeyId, secretAccessKey, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		if name = strings.ToLower(name); strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	payloadHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKeyId, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,97 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+// Package edgecache keeps CDNs fronting ranch APIs cache-correct. Responses are tagged with surrogate keys,
+// and when services publish an Invalidation the configured purgers ask the CDNs (Fastly, CloudFront or
+// anything reachable through a webhook) to drop what they cached for those keys or paths.
+package edgecache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultSurrogateKeyHeader is the header responses carry their surrogate keys in, space separated.
+const DefaultSurrogateKeyHeader = "Surrogate-Key"
+
+const defaultPurgeTimeout = 30 * time.Second
+
+// Invalidation names the cached content that is stale. CDNs that purge by surrogate key use Keys, those
+// that purge by path use Paths, a path ending in * matches everything under it.
+type Invalidation struct {
+	Keys  []string `json:"keys,omitempty"`
+	Paths []string `json:"paths,omitempty"`
+}
+
+// Empty returns true if the invalidation names nothing to purge.
+func (i *Invalidation) Empty() bool {
+	return len(i.Keys) == 0 && len(i.Paths) == 0
+}
+
+// Purger drops cached content from a CDN.
+type Purger interface {
+	// Name identifies the purger in logs.
+	Name() string
+	// Purge drops the content named by the invalidation. Purgers ignore what their CDN cannot purge by,
+	// e.g. Keys for a CDN that purges by path only.
+	Purge(ctx context.Context, invalidation *Invalidation) error
+}
+
+// FromPayload converts a bus message payload into an invalidation, returning nil if the payload is not one.
+// Payloads relayed from brokers or fabric clients are decoded from their JSON form.
+func FromPayload(payload interface{}) *Invalidation {
+	switch p := payload.(type) {
+	case *Invalidation:
+		return p
+	case Invalidation:
+		return &p
+	case nil:
+		return nil
+	}
+	var raw []byte
+	switch p := payload.(type) {
+	case []byte:
+		raw = p
+	case string:
+		raw = []byte(p)
+	default:
+		var err error
+		if raw, err = json.Marshal(p); err != nil {
+			return nil
+		}
+	}
+	var invalidation Invalidation
+	if json.Unmarshal(raw, &invalidation) != nil || invalidation.Empty() {
+		return nil
+	}
+	return &invalidation
+}
+
+// SanitizeKey makes a value usable as a surrogate key, which cannot contain whitespace.
+func SanitizeKey(key string) string {
+	return strings.Join(strings.Fields(key), "-")
+}
+
+// doPurgeRequest sends a purge request, any status other than 2xx is an error.
+func doPurgeRequest(client *http.Client, req *http.Request) error {
+	if client == nil {
+		client = &http.Client{Timeout: defaultPurgeTimeout}
+	}
+	rsp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode < http.StatusOK || rsp.StatusCode >= http.StatusMultipleChoices {
+		body, _ := io.ReadAll(io.LimitReader(rsp.Body, 512))
+		return fmt.Errorf("purge request to %s failed with status %d: %s", req.URL.Host, rsp.StatusCode,
+			strings.TrimSpace(string(body)))
+	}
+	_, _ = io.Copy(io.Discard, rsp.Body)
+	return nil
+}
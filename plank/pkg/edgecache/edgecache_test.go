@@ -0,0 +1,130 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package edgecache
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFromPayload(t *testing.T) {
+	invalidation := &Invalidation{Keys: []string{"moo"}}
+	assert.Same(t, invalidation, FromPayload(invalidation))
+	assert.Equal(t, invalidation, FromPayload(*invalidation))
+	assert.Equal(t, invalidation, FromPayload(map[string]interface{}{"keys": []string{"moo"}}))
+	assert.Equal(t, &Invalidation{Paths: []string{"/moo/*"}}, FromPayload(`{"paths":["/moo/*"]}`))
+	assert.Nil(t, FromPayload([]byte(`{"moo":true}`)))
+	assert.Nil(t, FromPayload("moo"))
+	assert.Nil(t, FromPayload(nil))
+}
+
+func TestSanitizeKey(t *testing.T) {
+	assert.Equal(t, "my-cow-channel", SanitizeKey(" my cow\tchannel "))
+}
+
+// purgeServer records the purge requests it receives and answers them with status.
+func purgeServer(t *testing.T, status int) (*httptest.Server, chan *http.Request, chan []byte) {
+	requests := make(chan *http.Request, 10)
+	bodies := make(chan []byte, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- r
+		bodies <- body
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte("moo"))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, requests, bodies
+}
+
+func TestFastlyPurger(t *testing.T) {
+	srv, requests, _ := purgeServer(t, http.StatusOK)
+	purger := &FastlyPurger{ServiceId: "svc", ApiToken: "token", SoftPurge: true, Endpoint: srv.URL}
+	assert.Equal(t, "fastly:svc", purger.Name())
+
+	keys := make([]string, fastlyMaxKeysPerPurge+1)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+	}
+	assert.NoError(t, purger.Purge(context.Background(), &Invalidation{Keys: keys, Paths: []string{"/ignored"}}))
+
+	req := <-requests
+	assert.Equal(t, http.MethodPost, req.Method)
+	assert.Equal(t, "/service/svc/purge", req.URL.Path)
+	assert.Equal(t, "token", req.Header.Get("Fastly-Key"))
+	assert.Equal(t, "1", req.Header.Get("Fastly-Soft-Purge"))
+	assert.Equal(t, strings.Join(keys[:fastlyMaxKeysPerPurge], " "), req.Header.Get("Surrogate-Key"))
+	assert.Equal(t, keys[fastlyMaxKeysPerPurge], (<-requests).Header.Get("Surrogate-Key"))
+	assert.Empty(t, requests)
+}
+
+func TestFastlyPurger_Error(t *testing.T) {
+	srv, _, _ := purgeServer(t, http.StatusUnauthorized)
+	purger := &FastlyPurger{ServiceId: "svc", Endpoint: srv.URL}
+	err := purger.Purge(context.Background(), &Invalidation{Keys: []string{"key"}})
+	assert.ErrorContains(t, err, "failed with status 401: moo")
+}
+
+func TestCloudFrontPurger(t *testing.T) {
+	srv, requests, bodies := purgeServer(t, http.StatusCreated)
+	purger := &CloudFrontPurger{DistributionId: "dist", AccessKeyId: "AKID", SecretAccessKey: "secret",
+		SessionToken: "session", Endpoint: srv.URL}
+	assert.Equal(t, "cloudfront:dist", purger.Name())
+
+	// nothing to invalidate without paths.
+	assert.NoError(t, purger.Purge(context.Background(), &Invalidation{Keys: []string{"key"}}))
+	assert.Empty(t, requests)
+
+	assert.NoError(t, purger.Purge(context.Background(), &Invalidation{Paths: []string{"/moo", "/baa/*"}}))
+	req := <-requests
+	assert.Equal(t, "/2020-05-31/distribution/dist/invalidation", req.URL.Path)
+	assert.Equal(t, "session", req.Header.Get("X-Amz-Security-Token"))
+	assert.True(t, strings.HasPrefix(req.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/"))
+	assert.Contains(t, req.Header.Get("Authorization"),
+		"/us-east-1/cloudfront/aws4_request, SignedHeaders=host;x-amz-date;x-amz-security-token, Signature=")
+
+	var batch cloudFrontInvalidationBatch
+	assert.NoError(t, xml.Unmarshal(<-bodies, &batch))
+	assert.Equal(t, 2, batch.Quantity)
+	assert.Equal(t, []string{"/moo", "/baa/*"}, batch.Paths)
+	assert.NotEmpty(t, batch.CallerReference)
+}
+
+func TestSignV4(t *testing.T) {
+	// the get-vanilla case of the AWS Signature Version 4 test suite.
+	req := httptest.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	req.Header = http.Header{}
+	signV4(req, nil, "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "us-east-1", "service",
+		time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+	assert.Equal(t, "20150830T123600Z", req.Header.Get("X-Amz-Date"))
+	assert.Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, "+
+		"SignedHeaders=host;x-amz-date, "+
+		"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31", req.Header.Get("Authorization"))
+}
+
+func TestWebhookPurger(t *testing.T) {
+	srv, requests, bodies := purgeServer(t, http.StatusNoContent)
+	purger := &WebhookPurger{WebhookName: "cdn", URL: srv.URL + "/purge", Headers: map[string]string{"X-Auth": "moo"}}
+	assert.Equal(t, "webhook:cdn", purger.Name())
+
+	invalidation := &Invalidation{Keys: []string{"key"}, Paths: []string{"/moo"}}
+	assert.NoError(t, purger.Purge(context.Background(), invalidation))
+	req := <-requests
+	assert.Equal(t, "/purge", req.URL.Path)
+	assert.Equal(t, "moo", req.Header.Get("X-Auth"))
+	assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+	var received Invalidation
+	assert.NoError(t, json.Unmarshal(<-bodies, &received))
+	assert.Equal(t, invalidation, &received)
+}
@@ -0,0 +1,59 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package edgecache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	defaultFastlyEndpoint = "https://api.fastly.com"
+	fastlyMaxKeysPerPurge = 256
+)
+
+// FastlyPurger purges a Fastly service by surrogate key. Paths are ignored, as Fastly purges single URLs
+// only and the hosts a service is reached through are not known.
+type FastlyPurger struct {
+	ServiceId string       `json:"service_id"`              // id of the Fastly service fronting the server
+	ApiToken  string       `json:"api_token" secret:"true"` // Fastly API token with purge rights
+	SoftPurge bool         `json:"soft_purge"`              // mark content stale instead of dropping it, so it can still be served stale
+	Endpoint  string       `json:"endpoint"`                // Fastly API base URL, defaults to https://api.fastly.com
+	Client    *http.Client `json:"-"`                       // defaults to a client with a 30 second timeout
+}
+
+func (p *FastlyPurger) Name() string {
+	return "fastly:" + p.ServiceId
+}
+
+// Purge purges the keys, in batches of the most keys Fastly accepts per request.
+func (p *FastlyPurger) Purge(ctx context.Context, invalidation *Invalidation) error {
+	endpoint := p.Endpoint
+	if endpoint == "" {
+		endpoint = defaultFastlyEndpoint
+	}
+	url := fmt.Sprintf("%s/service/%s/purge", strings.TrimSuffix(endpoint, "/"), p.ServiceId)
+	keys := invalidation.Keys
+	for len(keys) > 0 {
+		batch := keys[:min(len(keys), fastlyMaxKeysPerPurge)]
+		keys = keys[len(batch):]
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Fastly-Key", p.ApiToken)
+		req.Header.Set("Surrogate-Key", strings.Join(batch, " "))
+		req.Header.Set("Accept", "application/json")
+		if p.SoftPurge {
+			req.Header.Set("Fastly-Soft-Purge", "1")
+		}
+		if err = doPurgeRequest(p.Client, req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
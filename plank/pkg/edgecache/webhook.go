@@ -0,0 +1,43 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package edgecache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// WebhookPurger posts every invalidation as JSON to a URL, for CDNs or purge proxies without a built-in
+// purger.
+type WebhookPurger struct {
+	WebhookName string            `json:"name"`                  // name of the webhook, used in logs
+	URL         string            `json:"url"`                   // URL invalidations are posted to
+	Headers     map[string]string `json:"headers" secret:"true"` // extra request headers, e.g. for authentication
+	Client      *http.Client      `json:"-"`                     // defaults to a client with a 30 second timeout
+}
+
+func (p *WebhookPurger) Name() string {
+	if p.WebhookName != "" {
+		return "webhook:" + p.WebhookName
+	}
+	return "webhook"
+}
+
+func (p *WebhookPurger) Purge(ctx context.Context, invalidation *Invalidation) error {
+	body, err := json.Marshal(invalidation)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range p.Headers {
+		req.Header.Set(k, v)
+	}
+	return doPurgeRequest(p.Client, req)
+}
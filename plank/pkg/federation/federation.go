@@ -0,0 +1,337 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+// Package federation relays bus channels between ranch instances forming a mesh. Every message sent on a
+// federated channel is delivered to the other instances tagged with the instance it was sent on, whatever
+// the path it takes. Messages travel in envelopes recording the instances they crossed, so they are never
+// delivered twice nor relayed in circles. The transport is left to the caller: instances are joined by links,
+// whatever a link is given must reach the Receive method of the instance at its other end.
+package federation
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/model"
+)
+
+const (
+	defaultMaxHops = 8
+	seenCapacity   = 4096 // ids of the envelopes remembered, to drop those arriving again by another path
+)
+
+// Config selects what a Federation relays.
+type Config struct {
+	Node        string            // name of this instance, unique in the mesh
+	Channels    []string          // channels whose responses are relayed to the other instances
+	Names       map[string]string // name in the mesh of the channels named differently here, by local name
+	Transparent bool              // deliver the payloads of other instances as sent rather than in a FederatedMessage
+	MaxHops     int               // instances a message may cross, defaults to 8
+	Logger      *slog.Logger      // defaults to slog.Default()
+}
+
+// FederatedMessage is delivered as a response on a federated channel for every response sent on it on
+// another instance. Transparent federations deliver the JSON of the payload as a []byte instead, as a
+// galactic channel would, so the local consumers of the channel cannot tell it crossed instances.
+type FederatedMessage struct {
+	Node    string      `json:"node"`    // instance the message was sent on
+	Payload interface{} `json:"payload"` // payload of the message, decoded from JSON
+}
+
+// Stats counts the envelopes of a Federation.
+type Stats struct {
+	Sent      uint64 `json:"sent"`      // envelopes of local messages sent to the links
+	Delivered uint64 `json:"delivered"` // messages from other instances delivered locally
+	Relayed   uint64 `json:"relayed"`   // envelopes relayed from a link to the others
+	Dropped   uint64 `json:"dropped"`   // envelopes dropped as they were seen already or crossed too many instances
+}
+
+// envelope carries a message across the mesh.
+type envelope struct {
+	Id      string          `json:"id"`
+	Origin  string          `json:"origin"`
+	Hops    []string        `json:"hops"` // instances crossed, the origin first
+	Channel string          `json:"channel"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// Federation relays the channels of one instance.
+type Federation struct {
+	config    *Config
+	eventBus  bus.EventBus
+	logger    *slog.Logger
+	links     map[string]func(payload []byte) error
+	handlers  []bus.MessageHandler
+	seen      map[string]bool
+	seenOrder []string
+	unrelayed map[*byte]bool // payloads delivered by a transparent federation, not to be relayed back
+	started   bool
+	lock      sync.Mutex
+	sent      atomic.Uint64
+	delivered atomic.Uint64
+	relayed   atomic.Uint64
+	dropped   atomic.Uint64
+}
+
+// New creates a Federation for the instance.
+func New(eventBus bus.EventBus, config *Config) (*Federation, error) {
+	if config == nil || config.Node == "" {
+		return nil, fmt.Errorf("federation needs the name of the instance")
+	}
+	if len(config.Channels) == 0 {
+		return nil, fmt.Errorf("federation of '%s' has no channels", config.Node)
+	}
+	f := &Federation{
+		config:    config,
+		eventBus:  eventBus,
+		logger:    config.Logger,
+		links:     make(map[string]func(payload []byte) error),
+		seen:      make(map[string]bool),
+		unrelayed: make(map[*byte]bool),
+	}
+	if f.logger == nil {
+		f.logger = slog.Default()
+	}
+	return f, nil
+}
+
+// AddLink joins the instance to another, or to several, through send. A link added under the name of an
+// existing one replaces it.
+func (f *Federation) AddLink(name string, send func(payload []byte) error) {
+	f.lock.Lock()
+	f.links[name] = send
+	f.lock.Unlock()
+}
+
+// RemoveLink removes a link.
+func (f *Federation) RemoveLink(name string) {
+	f.lock.Lock()
+	delete(f.links, name)
+	f.lock.Unlock()
+}
+
+// Start relays the responses sent on the federated channels.
+func (f *Federation) Start() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.started {
+		return fmt.Errorf("federation of '%s' already started", f.config.Node)
+	}
+	cm := f.eventBus.GetChannelManager()
+	for _, channel := range f.config.Channels {
+		if !cm.CheckChannelExists(channel) {
+			cm.CreateChannel(channel)
+		}
+		handler, err := f.eventBus.ListenStream(channel)
+		if err != nil {
+			f.closeHandlers()
+			return err
+		}
+		handler.Handle(f.relay(channel), func(err error) {})
+		f.handlers = append(f.handlers, handler)
+	}
+	f.started = true
+	return nil
+}
+
+// Stop stops relaying, links are kept.
+func (f *Federation) Stop() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.started = false
+	f.closeHandlers()
+}
+
+func (f *Federation) closeHandlers() {
+	for _, handler := range f.handlers {
+		handler.Close()
+	}
+	f.handlers = nil
+	clear(f.unrelayed)
+}
+
+// Stats returns the counters of the federation.
+func (f *Federation) Stats() Stats {
+	return Stats{
+		Sent:      f.sent.Load(),
+		Delivered: f.delivered.Load(),
+		Relayed:   f.relayed.Load(),
+		Dropped:   f.dropped.Load(),
+	}
+}
+
+// Receive handles what arrived on a link: the message is delivered locally and relayed to the other links,
+// unless it was seen already or crossed too many instances. A link shared by several instances is named
+// "", so what arrives on it is relayed back to it for the others, and dropped by the instance it came from.
+func (f *Federation) Receive(link string, payload []byte) {
+	env := &envelope{}
+	if err := json.Unmarshal(payload, env); err != nil || env.Id == "" {
+		f.logger.Warn("[ranch] federation received an unreadable envelope", "link", link)
+		return
+	}
+	maxHops := f.config.MaxHops
+	if maxHops <= 0 {
+		maxHops = defaultMaxHops
+	}
+	if slices.Contains(env.Hops, f.config.Node) || len(env.Hops) >= maxHops || !f.markSeen(env.Id) {
+		f.dropped.Add(1)
+		return
+	}
+
+	f.deliver(env)
+	env.Hops = append(env.Hops, f.config.Node)
+	if f.send(env, link) > 0 {
+		f.relayed.Add(1)
+	}
+}
+
+// relay returns a handler sending the responses sent on a channel of this instance to every link.
+func (f *Federation) relay(channel string) bus.MessageHandlerFunction {
+	return func(msg *model.Message) {
+		switch p := msg.Payload.(type) {
+		case *FederatedMessage, FederatedMessage:
+			return // delivered from another instance
+		case []byte:
+			if f.config.Transparent && f.takeDelivered(p) {
+				return
+			}
+		}
+		payload, err := encodePayload(msg.Payload)
+		if err != nil {
+			f.logger.Error("[ranch] federation unable to encode message", "channel", channel, "error", err.Error())
+			return
+		}
+		env := &envelope{
+			Id:      uuid.New().String(),
+			Origin:  f.config.Node,
+			Hops:    []string{f.config.Node},
+			Channel: f.meshName(channel),
+			Payload: payload,
+		}
+		f.markSeen(env.Id)
+		f.send(env, "")
+		f.sent.Add(1)
+	}
+}
+
+// deliver sends a message from another instance on the local channel, if the channel is federated.
+func (f *Federation) deliver(env *envelope) {
+	channel, ok := f.localName(env.Channel)
+	if !ok {
+		return
+	}
+	if f.config.Transparent {
+		payload := []byte(env.Payload)
+		if len(payload) == 0 {
+			payload = []byte("null")
+		}
+		f.lock.Lock()
+		f.unrelayed[&payload[0]] = true
+		f.lock.Unlock()
+		if err := f.eventBus.SendResponseMessage(channel, payload, nil); err != nil {
+			f.takeDelivered(payload)
+			return
+		}
+		f.delivered.Add(1)
+		return
+	}
+	var payload interface{}
+	if len(env.Payload) > 0 {
+		if err := json.Unmarshal(env.Payload, &payload); err != nil {
+			f.logger.Warn("[ranch] federation received an unreadable message", "origin", env.Origin,
+				"channel", env.Channel, "error", err.Error())
+			return
+		}
+	}
+	if err := f.eventBus.SendResponseMessage(channel,
+		&FederatedMessage{Node: env.Origin, Payload: payload}, nil); err == nil {
+		f.delivered.Add(1)
+	}
+}
+
+// takeDelivered returns whether a payload was delivered from another instance, forgetting it.
+func (f *Federation) takeDelivered(payload []byte) bool {
+	if len(payload) == 0 {
+		return false
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if !f.unrelayed[&payload[0]] {
+		return false
+	}
+	delete(f.unrelayed, &payload[0])
+	return true
+}
+
+// meshName returns the name in the mesh of a local channel.
+func (f *Federation) meshName(channel string) string {
+	if name, ok := f.config.Names[channel]; ok && name != "" {
+		return name
+	}
+	return channel
+}
+
+// localName returns the local channel with a name in the mesh, if it is federated.
+func (f *Federation) localName(name string) (string, bool) {
+	for _, channel := range f.config.Channels {
+		if f.meshName(channel) == name {
+			return channel, true
+		}
+	}
+	return "", false
+}
+
+// send hands an envelope to every link but the one it came from, returning the links it was handed to.
+func (f *Federation) send(env *envelope, from string) int {
+	payload, err := json.Marshal(env)
+	if err != nil {
+		f.logger.Error("[ranch] federation unable to encode envelope", "error", err.Error())
+		return 0
+	}
+	f.lock.Lock()
+	links := make(map[string]func(payload []byte) error, len(f.links))
+	for name, link := range f.links {
+		if name != from {
+			links[name] = link
+		}
+	}
+	f.lock.Unlock()
+
+	for name, link := range links {
+		if err := link(payload); err != nil {
+			f.logger.Warn("[ranch] federation unable to send", "link", name, "channel", env.Channel,
+				"error", err.Error())
+		}
+	}
+	return len(links)
+}
+
+// markSeen remembers the id of an envelope, returning false if it was seen already. The oldest ids are
+// forgotten first.
+func (f *Federation) markSeen(id string) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.seen[id] {
+		return false
+	}
+	f.seen[id] = true
+	f.seenOrder = append(f.seenOrder, id)
+	if len(f.seenOrder) > seenCapacity {
+		delete(f.seen, f.seenOrder[0])
+		f.seenOrder = f.seenOrder[1:]
+	}
+	return true
+}
+
+func encodePayload(payload interface{}) (json.RawMessage, error) {
+	if p, ok := payload.([]byte); ok && json.Valid(p) {
+		return p, nil
+	}
+	return json.Marshal(payload)
+}
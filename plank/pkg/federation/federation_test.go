@@ -0,0 +1,205 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package federation
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testNode struct {
+	bus        bus.EventBus
+	federation *Federation
+	lock       sync.Mutex
+	received   []*FederatedMessage
+}
+
+func (n *testNode) messages() []*FederatedMessage {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return append([]*FederatedMessage(nil), n.received...)
+}
+
+// newTestNodes creates a started Federation per name, each on its own bus, recording the messages
+// delivered on channel.
+func newTestNodes(t *testing.T, channel string, names ...string) map[string]*testNode {
+	nodes := make(map[string]*testNode)
+	for _, name := range names {
+		node := &testNode{bus: bus.NewEventBusInstance()}
+		f, err := New(node.bus, &Config{Node: name, Channels: []string{channel},
+			Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+		require.NoError(t, err)
+		node.federation = f
+		require.NoError(t, f.Start())
+		t.Cleanup(f.Stop)
+
+		handler, err := node.bus.ListenStream(channel)
+		require.NoError(t, err)
+		handler.Handle(func(msg *model.Message) {
+			if fm, ok := msg.Payload.(*FederatedMessage); ok {
+				node.lock.Lock()
+				node.received = append(node.received, fm)
+				node.lock.Unlock()
+			}
+		}, func(err error) {})
+		t.Cleanup(handler.Close)
+		nodes[name] = node
+	}
+	return nodes
+}
+
+// link joins two nodes both ways.
+func link(a, b *testNode) {
+	a.federation.AddLink(b.federation.config.Node, func(payload []byte) error {
+		b.federation.Receive(a.federation.config.Node, payload)
+		return nil
+	})
+	b.federation.AddLink(a.federation.config.Node, func(payload []byte) error {
+		a.federation.Receive(b.federation.config.Node, payload)
+		return nil
+	})
+}
+
+func TestNew_Invalid(t *testing.T) {
+	b := bus.NewEventBusInstance()
+	_, err := New(b, nil)
+	assert.Error(t, err)
+	_, err = New(b, &Config{Channels: []string{"chat"}})
+	assert.Error(t, err)
+	_, err = New(b, &Config{Node: "eu"})
+	assert.Error(t, err)
+}
+
+func TestFederation_Chain(t *testing.T) {
+	nodes := newTestNodes(t, "chat", "eu", "us", "ap")
+	link(nodes["eu"], nodes["us"])
+	link(nodes["us"], nodes["ap"])
+
+	require.NoError(t, nodes["eu"].bus.SendResponseMessage("chat", map[string]string{"text": "hello"}, nil))
+	for _, name := range []string{"us", "ap"} {
+		node := nodes[name]
+		assert.Eventually(t, func() bool { return len(node.messages()) == 1 }, time.Second, 5*time.Millisecond)
+		msg := node.messages()[0]
+		assert.Equal(t, "eu", msg.Node)
+		assert.Equal(t, map[string]interface{}{"text": "hello"}, msg.Payload)
+	}
+	assert.Empty(t, nodes["eu"].messages(), "messages do not come back to the instance they were sent on")
+	assert.Equal(t, uint64(1), nodes["eu"].federation.Stats().Sent)
+	assert.Equal(t, uint64(1), nodes["us"].federation.Stats().Relayed)
+}
+
+func TestFederation_Ring(t *testing.T) {
+	nodes := newTestNodes(t, "chat", "eu", "us", "ap")
+	link(nodes["eu"], nodes["us"])
+	link(nodes["us"], nodes["ap"])
+	link(nodes["ap"], nodes["eu"])
+
+	require.NoError(t, nodes["us"].bus.SendResponseMessage("chat", []byte(`{"text":"hello"}`), nil))
+	for _, name := range []string{"eu", "ap"} {
+		node := nodes[name]
+		assert.Eventually(t, func() bool { return len(node.messages()) == 1 }, time.Second, 5*time.Millisecond)
+	}
+
+	// the envelope reaches each instance twice, the second copy is dropped
+	time.Sleep(20 * time.Millisecond)
+	assert.Len(t, nodes["eu"].messages(), 1)
+	assert.Len(t, nodes["ap"].messages(), 1)
+	assert.Empty(t, nodes["us"].messages())
+	assert.Equal(t, uint64(1), nodes["eu"].federation.Stats().Delivered)
+	assert.Equal(t, uint64(2),
+		nodes["eu"].federation.Stats().Dropped+nodes["ap"].federation.Stats().Dropped+
+			nodes["us"].federation.Stats().Dropped)
+}
+
+func TestFederation_Receive(t *testing.T) {
+	nodes := newTestNodes(t, "chat", "eu")
+	f := nodes["eu"].federation
+	var forwarded [][]byte
+	f.AddLink("us", func(payload []byte) error {
+		forwarded = append(forwarded, payload)
+		return nil
+	})
+	receive := func(env *envelope) {
+		payload, _ := json.Marshal(env)
+		f.Receive("ap", payload)
+	}
+
+	receive(&envelope{Id: "1", Origin: "ap", Hops: []string{"ap"}, Channel: "chat", Payload: []byte(`"hi"`)})
+	receive(&envelope{Id: "1", Origin: "ap", Hops: []string{"ap"}, Channel: "chat", Payload: []byte(`"hi"`)})
+	receive(&envelope{Id: "2", Origin: "ap", Hops: []string{"ap", "eu"}, Channel: "chat"})
+	receive(&envelope{Id: "3", Origin: "ap", Hops: []string{"1", "2", "3", "4", "5", "6", "7", "8"}, Channel: "chat"})
+	f.Receive("ap", []byte("not json"))
+
+	assert.Eventually(t, func() bool { return len(nodes["eu"].messages()) == 1 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, "hi", nodes["eu"].messages()[0].Payload)
+	if assert.Len(t, forwarded, 1) {
+		env := &envelope{}
+		require.NoError(t, json.Unmarshal(forwarded[0], env))
+		assert.Equal(t, []string{"ap", "eu"}, env.Hops)
+	}
+	assert.Equal(t, Stats{Delivered: 1, Relayed: 1, Dropped: 3}, f.Stats())
+
+	// envelopes of channels not federated here are relayed but not delivered
+	receive(&envelope{Id: "4", Origin: "ap", Hops: []string{"ap"}, Channel: "news"})
+	assert.Len(t, forwarded, 2)
+	assert.Equal(t, uint64(1), f.Stats().Delivered)
+}
+
+func TestFederation_Transparent(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	edge, core := bus.NewEventBusInstance(), bus.NewEventBusInstance()
+	fe, err := New(edge, &Config{Node: "edge", Channels: []string{"edge-orders"},
+		Names: map[string]string{"edge-orders": "orders"}, Transparent: true, Logger: logger})
+	require.NoError(t, err)
+	fc, err := New(core, &Config{Node: "core", Channels: []string{"orders"}, Transparent: true, Logger: logger})
+	require.NoError(t, err)
+	require.NoError(t, fe.Start())
+	defer fe.Stop()
+	require.NoError(t, fc.Start())
+	defer fc.Stop()
+	link(&testNode{federation: fe}, &testNode{federation: fc})
+
+	listen := func(b bus.EventBus, channel string) chan interface{} {
+		received := make(chan interface{}, 10)
+		handler, err := b.ListenStream(channel)
+		require.NoError(t, err)
+		handler.Handle(func(msg *model.Message) { received <- msg.Payload }, func(err error) {})
+		t.Cleanup(handler.Close)
+		return received
+	}
+	edgeOrders, coreOrders := listen(edge, "edge-orders"), listen(core, "orders")
+	next := func(c chan interface{}) interface{} {
+		select {
+		case payload := <-c:
+			return payload
+		case <-time.After(time.Second):
+			t.Fatal("timed out")
+		}
+		return nil
+	}
+
+	// channels are mirrored under their name in the mesh, payloads are delivered as the JSON sent
+	require.NoError(t, edge.SendResponseMessage("edge-orders", map[string]string{"item": "hay"}, nil))
+	next(edgeOrders)
+	assert.Equal(t, []byte(`{"item":"hay"}`), next(coreOrders))
+	require.NoError(t, core.SendResponseMessage("orders", []byte(`"straw"`), nil))
+	next(coreOrders)
+	assert.Equal(t, []byte(`"straw"`), next(edgeOrders))
+
+	// what was delivered is not relayed back
+	time.Sleep(20 * time.Millisecond)
+	assert.Empty(t, edgeOrders)
+	assert.Empty(t, coreOrders)
+	assert.Equal(t, Stats{Sent: 1, Delivered: 1}, fe.Stats())
+	assert.Equal(t, Stats{Sent: 1, Delivered: 1}, fc.Stats())
+}
@@ -0,0 +1,388 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+// Package grpcbridge exposes bus service channels as bidirectional gRPC streams (see fabric.proto), an
+// HTTP/2 based alternative to the STOMP over WebSocket fabric for non-browser clients.
+//
+// A client opens the ranch.fabric.v1.Fabric/Stream method with the channel name in the "ranch-channel"
+// metadata entry. Frames sent by the client become requests on the channel, and responses published on the
+// channel are sent back as frames, to every stream on the channel unless the request was private. When the
+// client closes its side of the stream, the stream ends once its outstanding requests have been answered.
+package grpcbridge
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative fabric.proto
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/clock"
+	"github.com/pb33f/ranch/log"
+	"github.com/pb33f/ranch/model"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// ChannelMetadataKey is the metadata entry naming the channel a stream is bound to.
+	ChannelMetadataKey = "ranch-channel"
+
+	// DefaultMaxMessageBytes is the largest frame accepted from clients when no limit is configured.
+	DefaultMaxMessageBytes = 4 << 20
+
+	// DefaultResponseTimeout is how long a half closed stream waits for outstanding responses.
+	DefaultResponseTimeout = time.Minute
+)
+
+var errShuttingDown = status.Error(codes.Unavailable, "bridge is shutting down")
+
+// Config configures a Bridge.
+type Config struct {
+	Channels        []string      // channels clients may open streams on, internal channels are always refused
+	MaxMessageBytes int           // largest frame accepted from clients, defaults to DefaultMaxMessageBytes
+	ResponseTimeout time.Duration // wait for outstanding responses after the client half closes, defaults to DefaultResponseTimeout
+	Logger          *slog.Logger  // defaults to log.Logger()
+}
+
+// Bridge implements the Fabric service. It is an http.Handler serving it through a gRPC server, so it can
+// sit behind HTTP middleware, and must be served over HTTP/2, with TLS or h2c.
+type Bridge struct {
+	UnimplementedFabricServer
+	eventBus bus.EventBus
+	config   Config
+	channels map[string]bool
+	logger   *slog.Logger
+	server   *grpc.Server
+	streams  map[*stream]bool
+	closed   bool
+	lock     sync.Mutex
+}
+
+// NewBridge returns a Bridge relaying streams to the channels listed in config.
+func NewBridge(eventBus bus.EventBus, config *Config) *Bridge {
+	b := &Bridge{eventBus: eventBus, channels: make(map[string]bool), streams: make(map[*stream]bool)}
+	if config != nil {
+		b.config = *config
+	}
+	if b.config.MaxMessageBytes <= 0 {
+		b.config.MaxMessageBytes = DefaultMaxMessageBytes
+	}
+	if b.config.ResponseTimeout <= 0 {
+		b.config.ResponseTimeout = DefaultResponseTimeout
+	}
+	b.logger = b.config.Logger
+	if b.logger == nil {
+		b.logger = log.Logger()
+	}
+	for _, channel := range b.config.Channels {
+		b.channels[channel] = true
+	}
+	b.server = grpc.NewServer(grpc.MaxRecvMsgSize(b.config.MaxMessageBytes))
+	RegisterFabricServer(b.server, b)
+	return b
+}
+
+// Close ends every open stream with an UNAVAILABLE status and refuses new ones. The HTTP server serving the
+// bridge is closed by its owner.
+func (b *Bridge) Close() {
+	b.lock.Lock()
+	b.closed = true
+	streams := b.streams
+	b.streams = make(map[*stream]bool)
+	b.lock.Unlock()
+	for s := range streams {
+		s.shutdown()
+	}
+}
+
+// ServeHTTP serves gRPC calls.
+func (b *Bridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.server.ServeHTTP(w, r)
+}
+
+// Stream relays the frames sent by the client to the channel named in its metadata, and the responses
+// published on the channel back to the client.
+func (b *Bridge) Stream(srv grpc.BidiStreamingServer[Frame, Frame]) error {
+	ctx := srv.Context()
+	var channel string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(ChannelMetadataKey); len(values) > 0 {
+			channel = values[0]
+		}
+	}
+	if err := b.checkChannel(channel); err != nil {
+		return err
+	}
+	handler, err := b.eventBus.ListenStream(channel)
+	if err != nil {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	defer handler.Close()
+
+	s := &stream{
+		id:       uuid.New().String(),
+		channel:  channel,
+		bridge:   b,
+		srv:      srv,
+		pending:  make(map[uuid.UUID]bool),
+		answered: make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	if !b.addStream(s) {
+		return errShuttingDown
+	}
+	defer b.removeStream(s)
+
+	handler.Handle(s.deliver, s.deliverError)
+	b.logger.Debug("[ranch] gRPC stream opened", "channel", channel, "stream", s.id)
+
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- s.readRequests()
+	}()
+
+	select {
+	case <-ctx.Done():
+		err = status.FromContextError(ctx.Err()).Err()
+	case <-s.done:
+		err = errShuttingDown
+	case err = <-readErr:
+		if errors.Is(err, io.EOF) {
+			err = s.awaitResponses()
+		}
+	}
+	s.finish()
+	b.logger.Debug("[ranch] gRPC stream closed", "channel", channel, "stream", s.id, "status", status.Code(err))
+	return err
+}
+
+// checkChannel decides whether a stream may be opened on channel.
+func (b *Bridge) checkChannel(channel string) error {
+	if channel == "" {
+		return status.Errorf(codes.InvalidArgument, "missing '%s' metadata", ChannelMetadataKey)
+	}
+	if strings.HasPrefix(channel, bus.RANCH_INTERNAL_CHANNEL_PREFIX) || !b.channels[channel] {
+		return status.Errorf(codes.PermissionDenied, "channel '%s' is not bridged", channel)
+	}
+	if !b.eventBus.GetChannelManager().CheckChannelExists(channel) {
+		return status.Errorf(codes.NotFound, "channel '%s' does not exist", channel)
+	}
+	return nil
+}
+
+func (b *Bridge) addStream(s *stream) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.closed {
+		return false
+	}
+	b.streams[s] = true
+	return true
+}
+
+func (b *Bridge) removeStream(s *stream) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	delete(b.streams, s)
+}
+
+// stream is a single Fabric/Stream call bound to a channel.
+type stream struct {
+	id        string
+	channel   string
+	bridge    *Bridge
+	srv       grpc.BidiStreamingServer[Frame, Frame]
+	writeLock sync.Mutex
+	finished  bool
+	pending   map[uuid.UUID]bool
+	pendLock  sync.Mutex
+	answered  chan struct{}
+	done      chan struct{}
+	doneOnce  sync.Once
+}
+
+func (s *stream) shutdown() {
+	s.doneOnce.Do(func() { close(s.done) })
+}
+
+// readRequests relays every frame sent by the client to the channel, until the client half closes.
+func (s *stream) readRequests() error {
+	for {
+		frame, err := s.srv.Recv()
+		if err != nil {
+			return err
+		}
+		s.request(frame)
+	}
+}
+
+func (s *stream) request(frame *Frame) {
+	id := uuid.New()
+	if frame.Id != "" {
+		parsed, err := uuid.Parse(frame.Id)
+		if err != nil {
+			s.send(&Frame{Id: frame.Id, Error: true, ErrorCode: http.StatusBadRequest,
+				ErrorMessage: "request id is not a UUID"})
+			return
+		}
+		id = parsed
+	}
+	var payload interface{}
+	if len(frame.Payload) > 0 {
+		if err := json.Unmarshal(frame.Payload, &payload); err != nil {
+			s.send(&Frame{Id: id.String(), Error: true, ErrorCode: http.StatusBadRequest,
+				ErrorMessage: "request payload is not valid JSON"})
+			return
+		}
+	}
+	req := &model.Request{Id: &id, Destination: s.channel, RequestCommand: frame.Command, Payload: payload}
+	if frame.Private {
+		req.BrokerDestination = &model.BrokerDestinationConfig{Destination: s.channel, ConnectionId: s.id}
+	}
+
+	s.pendLock.Lock()
+	s.pending[id] = true
+	s.pendLock.Unlock()
+	if err := s.bridge.eventBus.SendRequestMessage(s.channel, req, nil); err != nil {
+		s.markAnswered(&id)
+		s.send(&Frame{Id: id.String(), Error: true, ErrorCode: http.StatusServiceUnavailable, ErrorMessage: err.Error()})
+	}
+}
+
+// awaitResponses waits until every request sent on the stream has been answered.
+func (s *stream) awaitResponses() error {
+	timeout := clock.NewTimer(s.bridge.config.ResponseTimeout)
+	defer timeout.Stop()
+	for s.pendingCount() > 0 {
+		select {
+		case <-s.answered:
+		case <-timeout.C():
+			return status.Errorf(codes.DeadlineExceeded, "no response received from channel in %s",
+				s.bridge.config.ResponseTimeout.String())
+		case <-s.done:
+			return errShuttingDown
+		case <-s.srv.Context().Done():
+			return status.FromContextError(s.srv.Context().Err()).Err()
+		}
+	}
+	return nil
+}
+
+func (s *stream) pendingCount() int {
+	s.pendLock.Lock()
+	defer s.pendLock.Unlock()
+	return len(s.pending)
+}
+
+func (s *stream) markAnswered(id *uuid.UUID) {
+	if id == nil {
+		return
+	}
+	s.pendLock.Lock()
+	_, ok := s.pending[*id]
+	delete(s.pending, *id)
+	s.pendLock.Unlock()
+	if ok {
+		select {
+		case s.answered <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// deliver sends a response published on the channel to the client, unless it is private to another stream.
+func (s *stream) deliver(msg *model.Message) {
+	frame := &Frame{}
+	var payload interface{}
+	switch resp := msg.Payload.(type) {
+	case *model.Response:
+		if !s.responseFrame(frame, resp) {
+			return
+		}
+		payload = resp.Payload
+	case model.Response:
+		if !s.responseFrame(frame, &resp) {
+			return
+		}
+		payload = resp.Payload
+	default:
+		if msg.DestinationId != nil {
+			frame.Id = msg.DestinationId.String()
+			s.markAnswered(msg.DestinationId)
+		}
+		payload = msg.Payload
+	}
+	data, err := marshalPayload(payload)
+	if err != nil {
+		frame.Error, frame.ErrorCode, frame.ErrorMessage = true, http.StatusInternalServerError, err.Error()
+	}
+	frame.Payload = data
+	s.send(frame)
+}
+
+func (s *stream) responseFrame(frame *Frame, resp *model.Response) bool {
+	if resp.BrokerDestination != nil && resp.BrokerDestination.ConnectionId != s.id {
+		return false
+	}
+	if resp.Id != nil {
+		frame.Id = resp.Id.String()
+		s.markAnswered(resp.Id)
+	}
+	frame.Error = resp.Error
+	frame.ErrorCode = int32(resp.ErrorCode)
+	frame.ErrorMessage = resp.ErrorMessage
+	if len(resp.Headers) > 0 {
+		frame.Headers = make(map[string]string, len(resp.Headers))
+		for k, v := range resp.Headers {
+			frame.Headers[k] = fmt.Sprint(v)
+		}
+	}
+	return true
+}
+
+// deliverError sends an error published on the channel to the client.
+func (s *stream) deliverError(err error) {
+	s.send(&Frame{Error: true, ErrorCode: http.StatusInternalServerError, ErrorMessage: err.Error()})
+}
+
+func (s *stream) send(frame *Frame) {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	if s.finished {
+		return
+	}
+	if err := s.srv.Send(frame); err != nil {
+		s.shutdown()
+	}
+}
+
+// finish stops any further frames being sent, the call ends once Stream returns.
+func (s *stream) finish() {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	s.finished = true
+}
+
+// marshalPayload encodes a response payload as JSON, strings and bytes are passed through.
+func marshalPayload(payload interface{}) ([]byte, error) {
+	switch p := payload.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []byte(p), nil
+	case []byte:
+		return p, nil
+	}
+	return json.Marshal(payload)
+}
@@ -0,0 +1,208 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package grpcbridge
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/model"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type testStream = grpc.BidiStreamingClient[Frame, Frame]
+
+func openStream(t *testing.T, client FabricClient, channel string) testStream {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	if channel != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, ChannelMetadataKey, channel)
+	}
+	s, err := client.Stream(ctx)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return s
+}
+
+func recv(t *testing.T, s testStream) *Frame {
+	received := make(chan *Frame, 1)
+	go func() {
+		frame, _ := s.Recv()
+		received <- frame
+	}()
+	select {
+	case frame := <-received:
+		return frame
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "no frame received")
+		return nil
+	}
+}
+
+// streamStatus reads the rest of the stream and returns its status.
+func streamStatus(s testStream) *status.Status {
+	for {
+		if _, err := s.Recv(); err != nil {
+			if err == io.EOF {
+				return status.New(codes.OK, "")
+			}
+			return status.Convert(err)
+		}
+	}
+}
+
+// startEchoService answers every request on channel with its own payload, echoing the private destination.
+func startEchoService(t *testing.T, eventBus bus.EventBus, channel string) {
+	eventBus.GetChannelManager().CreateChannel(channel)
+	handler, err := eventBus.ListenRequestStream(channel)
+	assert.NoError(t, err)
+	handler.Handle(func(msg *model.Message) {
+		req := msg.Payload.(*model.Request)
+		_ = eventBus.SendResponseMessage(channel, &model.Response{
+			Id:                req.Id,
+			Payload:           map[string]interface{}{"command": req.RequestCommand, "echo": req.Payload},
+			Headers:           map[string]interface{}{"X-Ranch": "yes"},
+			BrokerDestination: req.BrokerDestination,
+		}, req.Id)
+	}, func(err error) {})
+	t.Cleanup(handler.Close)
+}
+
+func newTestBridge(t *testing.T, config *Config) (*Bridge, bus.EventBus, FabricClient, string) {
+	eventBus := bus.ResetBus()
+	startEchoService(t, eventBus, "echo-service")
+	eventBus.GetChannelManager().CreateChannel("hidden-service")
+	bridge := NewBridge(eventBus, config)
+	srv := httptest.NewServer(h2c.NewHandler(bridge, &http2.Server{}))
+	t.Cleanup(srv.Close)
+	conn, err := grpc.NewClient(strings.TrimPrefix(srv.URL, "http://"),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return bridge, eventBus, NewFabricClient(conn), srv.URL
+}
+
+func TestBridge_Stream(t *testing.T) {
+	_, _, client, _ := newTestBridge(t, &Config{Channels: []string{"echo-service"}})
+	s := openStream(t, client, "echo-service")
+
+	id := uuid.New().String()
+	assert.NoError(t, s.Send(&Frame{Id: id, Command: "ping", Payload: []byte(`{"n":1}`)}))
+	frame := recv(t, s)
+	if assert.NotNil(t, frame) {
+		assert.Equal(t, id, frame.Id)
+		assert.JSONEq(t, `{"command":"ping","echo":{"n":1}}`, string(frame.Payload))
+		assert.Equal(t, map[string]string{"X-Ranch": "yes"}, frame.Headers)
+	}
+
+	// ids are generated when not set, payloads must be JSON.
+	assert.NoError(t, s.Send(&Frame{Command: "no-id"}))
+	frame = recv(t, s)
+	if assert.NotNil(t, frame) {
+		_, err := uuid.Parse(frame.Id)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, s.Send(&Frame{Command: "bad", Payload: []byte("{")}))
+	frame = recv(t, s)
+	if assert.NotNil(t, frame) {
+		assert.True(t, frame.Error)
+		assert.Equal(t, int32(http.StatusBadRequest), frame.ErrorCode)
+	}
+
+	// half closing ends the stream once every request has been answered.
+	assert.NoError(t, s.CloseSend())
+	assert.Equal(t, codes.OK, streamStatus(s).Code())
+}
+
+func TestBridge_PrivateAndBroadcastResponses(t *testing.T) {
+	_, _, client, _ := newTestBridge(t, &Config{Channels: []string{"echo-service"}})
+	first := openStream(t, client, "echo-service")
+	second := openStream(t, client, "echo-service")
+
+	assert.NoError(t, first.Send(&Frame{Command: "private", Private: true}))
+	frame := recv(t, first)
+	if assert.NotNil(t, frame) {
+		assert.Contains(t, string(frame.Payload), `"private"`)
+	}
+
+	// the private response never reached the second stream, the broadcast one reaches both.
+	assert.NoError(t, second.Send(&Frame{Command: "broadcast"}))
+	frame = recv(t, second)
+	if assert.NotNil(t, frame) {
+		assert.Contains(t, string(frame.Payload), `"broadcast"`)
+	}
+	frame = recv(t, first)
+	if assert.NotNil(t, frame) {
+		assert.Contains(t, string(frame.Payload), `"broadcast"`)
+	}
+}
+
+func TestBridge_RefusesStreams(t *testing.T) {
+	_, _, client, url := newTestBridge(t, &Config{Channels: []string{"echo-service", "missing-service",
+		bus.RANCH_INTERNAL_CHANNEL_PREFIX + "secret"}})
+
+	for channel, code := range map[string]codes.Code{
+		"":               codes.InvalidArgument,
+		"hidden-service": codes.PermissionDenied,
+		bus.RANCH_INTERNAL_CHANNEL_PREFIX + "secret": codes.PermissionDenied,
+		"missing-service": codes.NotFound,
+	} {
+		st := streamStatus(openStream(t, client, channel))
+		assert.Equal(t, code, st.Code(), channel)
+		assert.NotEmpty(t, st.Message())
+	}
+
+	// gRPC needs HTTP/2.
+	rsp, err := http.Post(url+Fabric_Stream_FullMethodName, "application/grpc", nil)
+	if assert.NoError(t, err) {
+		assert.GreaterOrEqual(t, rsp.StatusCode, http.StatusBadRequest)
+		_ = rsp.Body.Close()
+	}
+}
+
+func TestBridge_LimitsAndTimeouts(t *testing.T) {
+	_, eventBus, client, _ := newTestBridge(t, &Config{
+		Channels:        []string{"echo-service", "silent-service"},
+		MaxMessageBytes: 32,
+		ResponseTimeout: 50 * time.Millisecond,
+	})
+
+	s := openStream(t, client, "echo-service")
+	_ = s.Send(&Frame{Payload: []byte(`"this payload is far too large for the limit"`)})
+	assert.Equal(t, codes.ResourceExhausted, streamStatus(s).Code())
+
+	eventBus.GetChannelManager().CreateChannel("silent-service")
+	s = openStream(t, client, "silent-service")
+	assert.NoError(t, s.Send(&Frame{Command: "anyone?"}))
+	assert.NoError(t, s.CloseSend())
+	st := streamStatus(s)
+	assert.Equal(t, codes.DeadlineExceeded, st.Code())
+	assert.Contains(t, st.Message(), "no response received")
+}
+
+func TestBridge_Close(t *testing.T) {
+	bridge, _, client, _ := newTestBridge(t, &Config{Channels: []string{"echo-service"}})
+	s := openStream(t, client, "echo-service")
+	assert.NoError(t, s.Send(&Frame{Command: "ping"}))
+	assert.NotNil(t, recv(t, s))
+
+	bridge.Close()
+	assert.Equal(t, codes.Unavailable, streamStatus(s).Code())
+	assert.Equal(t, codes.Unavailable, streamStatus(openStream(t, client, "echo-service")).Code())
+}
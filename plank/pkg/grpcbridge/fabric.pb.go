@@ -0,0 +1,209 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+// The gRPC bridge exposes ranch service channels as bidirectional streams. Generate a client from this
+// file with any protobuf toolchain, open a Stream with the channel name in the "ranch-channel" metadata
+// entry, then send requests and receive the responses published on the channel.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: fabric.proto
+
+package grpcbridge
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Frame carries a request sent to the channel, or a response published on it.
+type Frame struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// request id (a UUID), generated if empty. responses carry the id of the request they answer.
+	Id string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	// request command, the same as the "request" field of STOMP and REST bridge requests.
+	Command string `protobuf:"bytes,2,opt,name=command,proto3" json:"command,omitempty"`
+	// payload as JSON. response payloads published as strings or bytes are passed through unchanged.
+	Payload []byte `protobuf:"bytes,3,opt,name=payload,proto3" json:"payload,omitempty"`
+	// requests only: deliver the responses to this stream alone rather than to every stream on the channel.
+	Private bool `protobuf:"varint,4,opt,name=private,proto3" json:"private,omitempty"`
+	// responses only: the service answered with an error.
+	Error        bool   `protobuf:"varint,5,opt,name=error,proto3" json:"error,omitempty"`
+	ErrorCode    int32  `protobuf:"varint,6,opt,name=error_code,json=errorCode,proto3" json:"error_code,omitempty"`
+	ErrorMessage string `protobuf:"bytes,7,opt,name=error_message,json=errorMessage,proto3" json:"error_message,omitempty"`
+	// responses only: headers set by the service.
+	Headers       map[string]string `protobuf:"bytes,8,rep,name=headers,proto3" json:"headers,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Frame) Reset() {
+	*x = Frame{}
+	mi := &file_fabric_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Frame) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Frame) ProtoMessage() {}
+
+func (x *Frame) ProtoReflect() protoreflect.Message {
+	mi := &file_fabric_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Frame.ProtoReflect.Descriptor instead.
+func (*Frame) Descriptor() ([]byte, []int) {
+	return file_fabric_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Frame) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Frame) GetCommand() string {
+	if x != nil {
+		return x.Command
+	}
+	return ""
+}
+
+func (x *Frame) GetPayload() []byte {
+	if x != nil {
+		return x.Payload
+	}
+	return nil
+}
+
+func (x *Frame) GetPrivate() bool {
+	if x != nil {
+		return x.Private
+	}
+	return false
+}
+
+func (x *Frame) GetError() bool {
+	if x != nil {
+		return x.Error
+	}
+	return false
+}
+
+func (x *Frame) GetErrorCode() int32 {
+	if x != nil {
+		return x.ErrorCode
+	}
+	return 0
+}
+
+func (x *Frame) GetErrorMessage() string {
+	if x != nil {
+		return x.ErrorMessage
+	}
+	return ""
+}
+
+func (x *Frame) GetHeaders() map[string]string {
+	if x != nil {
+		return x.Headers
+	}
+	return nil
+}
+
+var File_fabric_proto protoreflect.FileDescriptor
+
+const file_fabric_proto_rawDesc = "" +
+	"\n" +
+	"\ffabric.proto\x12\x0franch.fabric.v1\"\xba\x02\n" +
+	"\x05Frame\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x18\n" +
+	"\acommand\x18\x02 \x01(\tR\acommand\x12\x18\n" +
+	"\apayload\x18\x03 \x01(\fR\apayload\x12\x18\n" +
+	"\aprivate\x18\x04 \x01(\bR\aprivate\x12\x14\n" +
+	"\x05error\x18\x05 \x01(\bR\x05error\x12\x1d\n" +
+	"\n" +
+	"error_code\x18\x06 \x01(\x05R\terrorCode\x12#\n" +
+	"\rerror_message\x18\a \x01(\tR\ferrorMessage\x12=\n" +
+	"\aheaders\x18\b \x03(\v2#.ranch.fabric.v1.Frame.HeadersEntryR\aheaders\x1a:\n" +
+	"\fHeadersEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x012F\n" +
+	"\x06Fabric\x12<\n" +
+	"\x06Stream\x12\x16.ranch.fabric.v1.Frame\x1a\x16.ranch.fabric.v1.Frame(\x010\x01B-Z+github.com/pb33f/ranch/plank/pkg/grpcbridgeb\x06proto3"
+
+var (
+	file_fabric_proto_rawDescOnce sync.Once
+	file_fabric_proto_rawDescData []byte
+)
+
+func file_fabric_proto_rawDescGZIP() []byte {
+	file_fabric_proto_rawDescOnce.Do(func() {
+		file_fabric_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_fabric_proto_rawDesc), len(file_fabric_proto_rawDesc)))
+	})
+	return file_fabric_proto_rawDescData
+}
+
+var file_fabric_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_fabric_proto_goTypes = []any{
+	(*Frame)(nil), // 0: ranch.fabric.v1.Frame
+	nil,           // 1: ranch.fabric.v1.Frame.HeadersEntry
+}
+var file_fabric_proto_depIdxs = []int32{
+	1, // 0: ranch.fabric.v1.Frame.headers:type_name -> ranch.fabric.v1.Frame.HeadersEntry
+	0, // 1: ranch.fabric.v1.Fabric.Stream:input_type -> ranch.fabric.v1.Frame
+	0, // 2: ranch.fabric.v1.Fabric.Stream:output_type -> ranch.fabric.v1.Frame
+	2, // [2:3] is the sub-list for method output_type
+	1, // [1:2] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_fabric_proto_init() }
+func file_fabric_proto_init() {
+	if File_fabric_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_fabric_proto_rawDesc), len(file_fabric_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_fabric_proto_goTypes,
+		DependencyIndexes: file_fabric_proto_depIdxs,
+		MessageInfos:      file_fabric_proto_msgTypes,
+	}.Build()
+	File_fabric_proto = out.File
+	file_fabric_proto_goTypes = nil
+	file_fabric_proto_depIdxs = nil
+}
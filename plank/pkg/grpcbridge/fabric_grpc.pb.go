@@ -0,0 +1,122 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+// The gRPC bridge exposes ranch service channels as bidirectional streams. Generate a client from this
+// file with any protobuf toolchain, open a Stream with the channel name in the "ranch-channel" metadata
+// entry, then send requests and receive the responses published on the channel.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: fabric.proto
+
+package grpcbridge
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Fabric_Stream_FullMethodName = "/ranch.fabric.v1.Fabric/Stream"
+)
+
+// FabricClient is the client API for Fabric service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type FabricClient interface {
+	Stream(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[Frame, Frame], error)
+}
+
+type fabricClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewFabricClient(cc grpc.ClientConnInterface) FabricClient {
+	return &fabricClient{cc}
+}
+
+func (c *fabricClient) Stream(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[Frame, Frame], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Fabric_ServiceDesc.Streams[0], Fabric_Stream_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[Frame, Frame]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Fabric_StreamClient = grpc.BidiStreamingClient[Frame, Frame]
+
+// FabricServer is the server API for Fabric service.
+// All implementations must embed UnimplementedFabricServer
+// for forward compatibility.
+type FabricServer interface {
+	Stream(grpc.BidiStreamingServer[Frame, Frame]) error
+	mustEmbedUnimplementedFabricServer()
+}
+
+// UnimplementedFabricServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedFabricServer struct{}
+
+func (UnimplementedFabricServer) Stream(grpc.BidiStreamingServer[Frame, Frame]) error {
+	return status.Errorf(codes.Unimplemented, "method Stream not implemented")
+}
+func (UnimplementedFabricServer) mustEmbedUnimplementedFabricServer() {}
+func (UnimplementedFabricServer) testEmbeddedByValue()                {}
+
+// UnsafeFabricServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to FabricServer will
+// result in compilation errors.
+type UnsafeFabricServer interface {
+	mustEmbedUnimplementedFabricServer()
+}
+
+func RegisterFabricServer(s grpc.ServiceRegistrar, srv FabricServer) {
+	// If the following call pancis, it indicates UnimplementedFabricServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Fabric_ServiceDesc, srv)
+}
+
+func _Fabric_Stream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(FabricServer).Stream(&grpc.GenericServerStream[Frame, Frame]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Fabric_StreamServer = grpc.BidiStreamingServer[Frame, Frame]
+
+// Fabric_ServiceDesc is the grpc.ServiceDesc for Fabric service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Fabric_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "ranch.fabric.v1.Fabric",
+	HandlerType: (*FabricServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Stream",
+			Handler:       _Fabric_Stream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "fabric.proto",
+}
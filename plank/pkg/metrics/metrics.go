@@ -0,0 +1,400 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+// Package metrics is a small metrics registry written out in the Prometheus text exposition format, so the
+// server can be scraped without pulling in a client library. Counters and histograms are updated as things
+// happen, collectors read values kept elsewhere, such as store sizes, when the registry is written out.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the content type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Metric types, as declared in the exposition format.
+const (
+	TypeCounter   = "counter"
+	TypeGauge     = "gauge"
+	TypeHistogram = "histogram"
+)
+
+// DefaultBuckets are the upper bounds of histogram buckets in seconds, suited to HTTP request latencies.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// labelSeparator joins label values into series keys, it cannot appear in valid UTF-8 label values.
+const labelSeparator = "\xff"
+
+// metric is a metric family written out by the registry.
+type metric interface {
+	desc() *descriptor
+	write(w *bufio.Writer)
+}
+
+// descriptor names a metric family and its labels.
+type descriptor struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+// Registry holds metric families, and writes them out for scrapes. It is safe for concurrent use.
+type Registry struct {
+	lock    sync.RWMutex
+	metrics map[string]metric
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]metric)}
+}
+
+// register adds a metric family, panicking if its name is invalid or taken, as for a programming error.
+func (r *Registry) register(m metric) {
+	d := m.desc()
+	if !validName(d.name) {
+		panic(fmt.Sprintf("metrics: invalid metric name %q", d.name))
+	}
+	for _, label := range d.labels {
+		if !validName(label) || strings.ContainsRune(label, ':') || strings.HasPrefix(label, "__") || label == "le" {
+			panic(fmt.Sprintf("metrics: invalid label name %q of %s", label, d.name))
+		}
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, taken := r.metrics[d.name]; taken {
+		panic(fmt.Sprintf("metrics: %s is already registered", d.name))
+	}
+	r.metrics[d.name] = m
+}
+
+// Unregister removes the metric family of a name, returning whether there was one.
+func (r *Registry) Unregister(name string) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	_, found := r.metrics[name]
+	delete(r.metrics, name)
+	return found
+}
+
+// WriteTo writes every metric family out in the text exposition format, in name order.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.lock.RLock()
+	metrics := make([]metric, 0, len(r.metrics))
+	for _, m := range r.metrics {
+		metrics = append(metrics, m)
+	}
+	r.lock.RUnlock()
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].desc().name < metrics[j].desc().name
+	})
+
+	counter := &countingWriter{w: w}
+	buf := bufio.NewWriter(counter)
+	for _, m := range metrics {
+		d := m.desc()
+		fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", d.name, escapeHelp(d.help), d.name, d.kind)
+		m.write(buf)
+	}
+	err := buf.Flush()
+	return counter.n, err
+}
+
+// CounterVec is a counter for every combination of label values.
+type CounterVec struct {
+	descriptor
+	lock   sync.Mutex
+	series map[string]*counterSeries
+}
+
+type counterSeries struct {
+	values []string
+	value  float64
+}
+
+// NewCounterVec registers a counter with the labels.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{descriptor: descriptor{name: name, help: help, kind: TypeCounter, labels: labels},
+		series: make(map[string]*counterSeries)}
+	r.register(c)
+	return c
+}
+
+// Inc adds one to the counter of the label values.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds a value to the counter of the label values. Counters only go up, negative values panic.
+func (c *CounterVec) Add(value float64, labelValues ...string) {
+	if value < 0 {
+		panic(fmt.Sprintf("metrics: counter %s cannot decrease", c.name))
+	}
+	c.checkLabels(labelValues)
+	key := strings.Join(labelValues, labelSeparator)
+	c.lock.Lock()
+	s, found := c.series[key]
+	if !found {
+		s = &counterSeries{values: append([]string(nil), labelValues...)}
+		c.series[key] = s
+	}
+	s.value += value
+	c.lock.Unlock()
+}
+
+// Value returns the counter of the label values.
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if s, found := c.series[strings.Join(labelValues, labelSeparator)]; found {
+		return s.value
+	}
+	return 0
+}
+
+func (c *CounterVec) desc() *descriptor {
+	return &c.descriptor
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, key := range sortedKeys(c.series) {
+		s := c.series[key]
+		writeSample(w, c.name, c.labels, s.values, "", "", s.value)
+	}
+}
+
+// HistogramVec is a histogram for every combination of label values, counting observations in buckets.
+type HistogramVec struct {
+	descriptor
+	buckets []float64
+	lock    sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	values []string
+	counts []uint64 // observations in each bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// NewHistogramVec registers a histogram with the upper bounds of its buckets, DefaultBuckets if nil, and
+// the labels.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	h := &HistogramVec{descriptor: descriptor{name: name, help: help, kind: TypeHistogram, labels: labels},
+		buckets: buckets, series: make(map[string]*histogramSeries)}
+	r.register(h)
+	return h
+}
+
+// Observe adds an observation to the histogram of the label values.
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	h.checkLabels(labelValues)
+	key := strings.Join(labelValues, labelSeparator)
+	bucket := sort.SearchFloat64s(h.buckets, value)
+	h.lock.Lock()
+	s, found := h.series[key]
+	if !found {
+		s = &histogramSeries{values: append([]string(nil), labelValues...), counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	if bucket < len(s.counts) {
+		s.counts[bucket]++
+	}
+	s.count++
+	s.sum += value
+	h.lock.Unlock()
+}
+
+// Count returns the number of observations of the label values.
+func (h *HistogramVec) Count(labelValues ...string) uint64 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if s, found := h.series[strings.Join(labelValues, labelSeparator)]; found {
+		return s.count
+	}
+	return 0
+}
+
+func (h *HistogramVec) desc() *descriptor {
+	return &h.descriptor
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += s.counts[i]
+			writeSample(w, h.name+"_bucket", h.labels, s.values, "le", formatFloat(upper), float64(cumulative))
+		}
+		writeSample(w, h.name+"_bucket", h.labels, s.values, "le", "+Inf", float64(s.count))
+		writeSample(w, h.name+"_sum", h.labels, s.values, "", "", s.sum)
+		writeSample(w, h.name+"_count", h.labels, s.values, "", "", float64(s.count))
+	}
+}
+
+// Collector reads its values when the registry is written out, for values kept elsewhere.
+type Collector struct {
+	descriptor
+	collect func(observe func(value float64, labelValues ...string))
+}
+
+// NewCollector registers a metric of a type, counter or gauge, whose values are read by collect when the
+// registry is written out. collect calls observe with the value of every combination of label values.
+func (r *Registry) NewCollector(name, help, kind string, labels []string,
+	collect func(observe func(value float64, labelValues ...string))) *Collector {
+	if kind != TypeCounter && kind != TypeGauge {
+		panic(fmt.Sprintf("metrics: collector %s cannot be a %s", name, kind))
+	}
+	c := &Collector{descriptor: descriptor{name: name, help: help, kind: kind, labels: labels}, collect: collect}
+	r.register(c)
+	return c
+}
+
+// NewGaugeFunc registers a gauge without labels whose value is read by value when the registry is written out.
+func (r *Registry) NewGaugeFunc(name, help string, value func() float64) *Collector {
+	return r.NewCollector(name, help, TypeGauge, nil, func(observe func(float64, ...string)) {
+		observe(value())
+	})
+}
+
+func (c *Collector) desc() *descriptor {
+	return &c.descriptor
+}
+
+func (c *Collector) write(w *bufio.Writer) {
+	type sample struct {
+		values []string
+		value  float64
+	}
+	var samples []sample
+	c.collect(func(value float64, labelValues ...string) {
+		if len(labelValues) == len(c.labels) {
+			samples = append(samples, sample{values: labelValues, value: value})
+		}
+	})
+	sort.Slice(samples, func(i, j int) bool {
+		return strings.Join(samples[i].values, labelSeparator) < strings.Join(samples[j].values, labelSeparator)
+	})
+	for _, s := range samples {
+		writeSample(w, c.name, c.labels, s.values, "", "", s.value)
+	}
+}
+
+// checkLabels panics unless there is a value for every label, as for a programming error.
+func (d *descriptor) checkLabels(values []string) {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s has labels %v, got %d values", d.name, d.labels, len(values)))
+	}
+}
+
+// writeSample writes a sample line, with an extra label if extraName is set, e.g. the le label of buckets.
+func writeSample(w *bufio.Writer, name string, labels, values []string, extraName, extraValue string, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(label)
+			w.WriteString(`="`)
+			w.WriteString(escapeLabelValue(values[i]))
+			w.WriteByte('"')
+		}
+		if extraName != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(extraName)
+			w.WriteString(`="`)
+			w.WriteString(extraValue)
+			w.WriteByte('"')
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func escapeLabelValue(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+// validName tells whether a metric or label name matches [a-zA-Z_:][a-zA-Z0-9_:]*.
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case c == '_' || c == ':' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// countingWriter counts the bytes written through it, for WriteTo.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,76 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package metrics
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry_WriteTo(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("ranch_requests_total", "Requests served.", "route", "code")
+	latency := r.NewHistogramVec("ranch_request_duration_seconds", "Request latency.", []float64{0.5, 0.1},
+		"route")
+	r.NewCollector("ranch_store_items", "Items in each store.", TypeGauge, []string{"store"},
+		func(observe func(float64, ...string)) {
+			observe(3, "herd")
+			observe(1, "barn \"north\"\n")
+			observe(2) // missing label values are dropped
+		})
+	r.NewGaugeFunc("ranch_up", "Whether the ranch is up.\nAlways.", func() float64 { return 1 })
+
+	requests.Inc("/cows", "200")
+	requests.Add(2, "/cows", "200")
+	requests.Inc("/cows", "404")
+	latency.Observe(0.05, "/cows")
+	latency.Observe(0.3, "/cows")
+	latency.Observe(2, "/cows")
+	assert.Equal(t, float64(3), requests.Value("/cows", "200"))
+	assert.Zero(t, requests.Value("/pigs", "200"))
+	assert.Equal(t, uint64(3), latency.Count("/cows"))
+
+	var out bytes.Buffer
+	n, err := r.WriteTo(&out)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(out.Len()), n)
+	assert.Equal(t, `# HELP ranch_request_duration_seconds Request latency.
+# TYPE ranch_request_duration_seconds histogram
+ranch_request_duration_seconds_bucket{route="/cows",le="0.1"} 1
+ranch_request_duration_seconds_bucket{route="/cows",le="0.5"} 2
+ranch_request_duration_seconds_bucket{route="/cows",le="+Inf"} 3
+ranch_request_duration_seconds_sum{route="/cows"} 2.35
+ranch_request_duration_seconds_count{route="/cows"} 3
+# HELP ranch_requests_total Requests served.
+# TYPE ranch_requests_total counter
+ranch_requests_total{route="/cows",code="200"} 3
+ranch_requests_total{route="/cows",code="404"} 1
+# HELP ranch_store_items Items in each store.
+# TYPE ranch_store_items gauge
+ranch_store_items{store="barn \"north\"\n"} 1
+ranch_store_items{store="herd"} 3
+# HELP ranch_up Whether the ranch is up.\nAlways.
+# TYPE ranch_up gauge
+ranch_up 1
+`, out.String())
+
+	// families can be removed, and registered again
+	assert.True(t, r.Unregister("ranch_up"))
+	assert.False(t, r.Unregister("ranch_up"))
+	r.NewGaugeFunc("ranch_up", "Whether the ranch is up.", func() float64 { return 0 })
+}
+
+func TestRegistry_Invalid(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("ranch_requests_total", "Requests served.", "route")
+	assert.Panics(t, func() { r.NewCounterVec("ranch_requests_total", "Again.") })
+	assert.Panics(t, func() { r.NewCounterVec("ranch-requests", "Invalid name.") })
+	assert.Panics(t, func() { r.NewCounterVec("ranch_latency", "Reserved label.", "le") })
+	assert.Panics(t, func() { r.NewHistogramVec("ranch_sizes", "Invalid label.", nil, "a:b") })
+	assert.Panics(t, func() { r.NewCollector("ranch_things", "Not collectable.", TypeHistogram, nil, nil) })
+	assert.Panics(t, func() { requests.Inc() })
+	assert.Panics(t, func() { requests.Add(-1, "/cows") })
+}
@@ -0,0 +1,210 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+// Package redact masks secrets in configuration before it is printed, served by an admin endpoint or
+// included in a diagnostics bundle.
+//
+// A value is treated as a secret if its struct field is tagged with `secret:"true"`, or if its struct field
+// or map key matches a registered secret name (see RegisterSecretNames). Passwords embedded in URLs and DSNs
+// held by any string are masked as well.
+package redact
+
+import (
+	"encoding"
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Mask replaces the value of every secret.
+const Mask = "********"
+
+// TagName is the struct tag used to mark a field as a secret, e.g. `secret:"true"`.
+const TagName = "secret"
+
+const maxDepth = 32
+
+var (
+	secretNames = map[string]bool{
+		"password":      true,
+		"passcode":      true,
+		"secret":        true,
+		"clientsecret":  true,
+		"token":         true,
+		"apikey":        true,
+		"privatekey":    true,
+		"dsn":           true,
+		"authorization": true,
+		"credentials":   true,
+	}
+	secretNamesLock sync.RWMutex
+)
+
+// RegisterSecretNames registers struct field names or map keys whose values are always masked. Matching
+// ignores case, underscores and dashes, so "client_secret" matches both ClientSecret and "client-secret".
+func RegisterSecretNames(names ...string) {
+	secretNamesLock.Lock()
+	defer secretNamesLock.Unlock()
+	for _, name := range names {
+		secretNames[normalize(name)] = true
+	}
+}
+
+// IsSecretName reports whether values held under the struct field name or map key name are masked.
+func IsSecretName(name string) bool {
+	return isSecretName(name)
+}
+
+func isSecretName(name string) bool {
+	secretNamesLock.RLock()
+	defer secretNamesLock.RUnlock()
+	return secretNames[normalize(name)]
+}
+
+func normalize(name string) string {
+	return strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(name))
+}
+
+// Value returns a copy of v, made of maps, slices and scalars, with every secret masked. Structs become maps
+// keyed by their JSON field names, fields tagged `json:"-"` as well as functions and channels are left out.
+// The result is safe to print or marshal.
+func Value(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	return walk(reflect.ValueOf(v), 0)
+}
+
+// JSON returns the indented JSON encoding of v with every secret masked.
+func JSON(v interface{}) ([]byte, error) {
+	return json.MarshalIndent(Value(v), "", "  ")
+}
+
+// String masks the password of a URL or DSN held by s, other strings are returned unchanged.
+func String(s string) string {
+	if !strings.Contains(s, "://") || !strings.Contains(s, "@") {
+		return s
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	if _, hasPassword := u.User.Password(); !hasPassword {
+		return s
+	}
+	// url.URL.Redacted uses its own placeholder and would escape the mask, so swap one for the other.
+	return strings.Replace(u.Redacted(), ":xxxxx@", ":"+Mask+"@", 1)
+}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+func walk(v reflect.Value, depth int) interface{} {
+	if depth > maxDepth {
+		return nil
+	}
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if v.Type().Implements(jsonMarshalerType) || v.Type().Implements(textMarshalerType) {
+		// types such as time.Time know how to present themselves.
+		return v.Interface()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		return walkStruct(v, depth)
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		out := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key := mapKey(iter.Key())
+			if isSecretName(key) {
+				out[key] = mask(iter.Value())
+			} else {
+				out[key] = walk(iter.Value(), depth+1)
+			}
+		}
+		return out
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return v.Interface()
+		}
+		out := make([]interface{}, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			out[i] = walk(v.Index(i), depth+1)
+		}
+		return out
+	case reflect.String:
+		return String(v.String())
+	case reflect.Func, reflect.Chan, reflect.UnsafePointer:
+		return nil
+	}
+	return v.Interface()
+}
+
+func walkStruct(v reflect.Value, depth int) map[string]interface{} {
+	t := v.Type()
+	out := make(map[string]interface{}, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		switch field.Type.Kind() {
+		case reflect.Func, reflect.Chan, reflect.UnsafePointer:
+			continue
+		}
+		name := field.Name
+		if tag, ok := field.Tag.Lookup("json"); ok {
+			tagName, _, _ := strings.Cut(tag, ",")
+			if tagName == "-" {
+				continue
+			}
+			if tagName != "" {
+				name = tagName
+			}
+		}
+		value := v.Field(i)
+		if field.Tag.Get(TagName) == "true" || isSecretName(field.Name) || isSecretName(name) {
+			out[name] = mask(value)
+			continue
+		}
+		out[name] = walk(value, depth+1)
+	}
+	return out
+}
+
+// mask hides a secret value, unset secrets are left empty so it is still clear they are not configured.
+func mask(v reflect.Value) interface{} {
+	if !v.IsValid() || v.IsZero() {
+		return ""
+	}
+	return Mask
+}
+
+func mapKey(v reflect.Value) string {
+	if v.Kind() == reflect.String {
+		return v.String()
+	}
+	if v.Type().Implements(textMarshalerType) {
+		if text, err := v.Interface().(encoding.TextMarshaler).MarshalText(); err == nil {
+			return string(text)
+		}
+	}
+	b, _ := json.Marshal(v.Interface())
+	return strings.Trim(string(b), `"`)
+}
@@ -0,0 +1,73 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package replication
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/pb33f/ranch/clock"
+)
+
+// Timestamp is a hybrid logical clock reading. Readings of every region are totally ordered: by wall time,
+// then logical counter, then region name, so regions whose clocks drift still agree on which write is last.
+type Timestamp struct {
+	Wall    int64  `json:"wall"`    // unix nanoseconds
+	Logical uint32 `json:"logical"` // orders readings within the same wall time
+	Region  string `json:"region"`  // region that took the reading
+}
+
+// Compare returns -1 if t happened before other, 1 if after and 0 if they are the same reading.
+func (t Timestamp) Compare(other Timestamp) int {
+	switch {
+	case t.Wall < other.Wall:
+		return -1
+	case t.Wall > other.Wall:
+		return 1
+	case t.Logical < other.Logical:
+		return -1
+	case t.Logical > other.Logical:
+		return 1
+	}
+	return strings.Compare(t.Region, other.Region)
+}
+
+// IsZero returns true if the timestamp was never set.
+func (t Timestamp) IsZero() bool {
+	return t.Wall == 0 && t.Logical == 0 && t.Region == ""
+}
+
+// Clock is a hybrid logical clock. Its readings never go backwards and always follow every reading it has
+// observed from other regions, even if the wall clock of this region is behind.
+type Clock struct {
+	region string
+	last   Timestamp
+	lock   sync.Mutex
+}
+
+func NewClock(region string) *Clock {
+	return &Clock{region: region}
+}
+
+// Now returns a reading later than every reading taken or observed so far.
+func (c *Clock) Now() Timestamp {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	wall := clock.Now().UnixNano()
+	if wall > c.last.Wall {
+		c.last = Timestamp{Wall: wall, Region: c.region}
+	} else {
+		c.last = Timestamp{Wall: c.last.Wall, Logical: c.last.Logical + 1, Region: c.region}
+	}
+	return c.last
+}
+
+// Observe moves the clock past a reading received from another region.
+func (c *Clock) Observe(remote Timestamp) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if remote.Wall > c.last.Wall || (remote.Wall == c.last.Wall && remote.Logical > c.last.Logical) {
+		c.last = Timestamp{Wall: remote.Wall, Logical: remote.Logical, Region: c.region}
+	}
+}
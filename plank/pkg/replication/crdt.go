@@ -0,0 +1,103 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package replication
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+)
+
+const (
+	// StrategyLWW replicates store items as last-writer-wins registers: the latest put or remove of an
+	// item, in any region, wins.
+	StrategyLWW = "lww"
+	// StrategyORSet replicates the items of a store as an observed-remove set: an item put in one region
+	// while it is concurrently removed in another is kept, only the puts a region has seen are removed.
+	// The value of a kept item is the latest put.
+	StrategyORSet = "or-set"
+)
+
+// itemState is the replicated state of a store item. Merging two states of an item is commutative,
+// associative and idempotent, so regions converge whatever order updates arrive in and however often.
+type itemState struct {
+	Store   string          `json:"store"`
+	Id      string          `json:"id"`
+	Value   json.RawMessage `json:"value,omitempty"`   // latest value put
+	Stamp   Timestamp       `json:"stamp"`             // when Value was put, or the item removed (lww)
+	Deleted bool            `json:"deleted,omitempty"` // lww: the latest write removed the item
+	Adds    []Timestamp     `json:"adds,omitempty"`    // or-set: the puts of the item, sorted
+	Removes []Timestamp     `json:"removes,omitempty"` // or-set: the puts observed by a remove, sorted
+}
+
+// present returns true if the item is in the store.
+func (s *itemState) present(strategy string) bool {
+	if strategy == StrategyORSet {
+		for _, add := range s.Adds {
+			if !containsTimestamp(s.Removes, add) {
+				return true
+			}
+		}
+		return false
+	}
+	return !s.Deleted && s.Value != nil
+}
+
+// put records a value written in this region.
+func (s *itemState) put(strategy string, value json.RawMessage, stamp Timestamp) {
+	s.Value = value
+	s.Stamp = stamp
+	s.Deleted = false
+	if strategy == StrategyORSet {
+		s.Adds = addTimestamp(s.Adds, stamp)
+	}
+}
+
+// remove records the item was removed in this region.
+func (s *itemState) remove(strategy string, stamp Timestamp) {
+	if strategy == StrategyORSet {
+		for _, add := range s.Adds {
+			s.Removes = addTimestamp(s.Removes, add)
+		}
+		return
+	}
+	s.Value = nil
+	s.Stamp = stamp
+	s.Deleted = true
+}
+
+// merge folds the state of the item in another region into s. Returns true if the value or presence of
+// the item changed.
+func (s *itemState) merge(strategy string, remote *itemState) bool {
+	wasPresent, oldValue := s.present(strategy), s.Value
+	if remote.Stamp.Compare(s.Stamp) > 0 {
+		s.Value = remote.Value
+		s.Stamp = remote.Stamp
+		s.Deleted = remote.Deleted
+	}
+	for _, add := range remote.Adds {
+		s.Adds = addTimestamp(s.Adds, add)
+	}
+	for _, remove := range remote.Removes {
+		s.Removes = addTimestamp(s.Removes, remove)
+	}
+	isPresent := s.present(strategy)
+	return wasPresent != isPresent || (isPresent && !bytes.Equal(oldValue, s.Value))
+}
+
+func containsTimestamp(stamps []Timestamp, stamp Timestamp) bool {
+	i := sort.Search(len(stamps), func(i int) bool { return stamps[i].Compare(stamp) >= 0 })
+	return i < len(stamps) && stamps[i] == stamp
+}
+
+func addTimestamp(stamps []Timestamp, stamp Timestamp) []Timestamp {
+	i := sort.Search(len(stamps), func(i int) bool { return stamps[i].Compare(stamp) >= 0 })
+	if i < len(stamps) && stamps[i] == stamp {
+		return stamps
+	}
+	stamps = append(stamps, Timestamp{})
+	copy(stamps[i+1:], stamps[i:])
+	stamps[i] = stamp
+	return stamps
+}
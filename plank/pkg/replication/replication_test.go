@@ -0,0 +1,242 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package replication
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClock_Now(t *testing.T) {
+	c := NewClock("eu")
+	a := c.Now()
+	b := c.Now()
+	assert.Equal(t, 1, b.Compare(a))
+	assert.Equal(t, "eu", b.Region)
+
+	ahead := Timestamp{Wall: b.Wall + int64(time.Hour), Logical: 3, Region: "us"}
+	c.Observe(ahead)
+	next := c.Now()
+	assert.Equal(t, 1, next.Compare(ahead))
+	assert.Equal(t, "eu", next.Region)
+}
+
+func TestTimestamp_Compare(t *testing.T) {
+	assert.Equal(t, -1, Timestamp{Wall: 1}.Compare(Timestamp{Wall: 2}))
+	assert.Equal(t, -1, Timestamp{Wall: 1, Logical: 1}.Compare(Timestamp{Wall: 1, Logical: 2}))
+	assert.Equal(t, -1, Timestamp{Wall: 1, Region: "eu"}.Compare(Timestamp{Wall: 1, Region: "us"}))
+	assert.Equal(t, 0, Timestamp{Wall: 1, Region: "eu"}.Compare(Timestamp{Wall: 1, Region: "eu"}))
+	assert.True(t, Timestamp{}.IsZero())
+}
+
+func TestItemState_LWW(t *testing.T) {
+	eu := &itemState{Id: "a"}
+	us := &itemState{Id: "a"}
+	eu.put(StrategyLWW, json.RawMessage(`"eu"`), Timestamp{Wall: 1, Region: "eu"})
+	us.put(StrategyLWW, json.RawMessage(`"us"`), Timestamp{Wall: 2, Region: "us"})
+
+	euCopy, usCopy := copyItemState(eu), copyItemState(us)
+	assert.True(t, eu.merge(StrategyLWW, usCopy))
+	assert.False(t, us.merge(StrategyLWW, euCopy))
+	assert.Equal(t, json.RawMessage(`"us"`), eu.Value)
+	assert.Equal(t, eu, us)
+
+	// merging again changes nothing
+	assert.False(t, eu.merge(StrategyLWW, copyItemState(us)))
+
+	// a later remove wins over the put
+	eu.remove(StrategyLWW, Timestamp{Wall: 3, Region: "eu"})
+	assert.False(t, eu.present(StrategyLWW))
+	assert.True(t, us.merge(StrategyLWW, copyItemState(eu)))
+	assert.False(t, us.present(StrategyLWW))
+}
+
+func TestItemState_ORSetAddWins(t *testing.T) {
+	eu := &itemState{Id: "a"}
+	eu.put(StrategyORSet, json.RawMessage(`1`), Timestamp{Wall: 1, Region: "eu"})
+	us := copyItemState(eu)
+
+	// eu removes the item while us concurrently updates it
+	eu.remove(StrategyORSet, Timestamp{Wall: 3, Region: "eu"})
+	us.put(StrategyORSet, json.RawMessage(`2`), Timestamp{Wall: 2, Region: "us"})
+	assert.False(t, eu.present(StrategyORSet))
+
+	euCopy := copyItemState(eu)
+	assert.True(t, eu.merge(StrategyORSet, copyItemState(us)))
+	us.merge(StrategyORSet, euCopy)
+	assert.True(t, eu.present(StrategyORSet))
+	assert.True(t, us.present(StrategyORSet))
+	assert.Equal(t, json.RawMessage(`2`), eu.Value)
+	assert.Equal(t, eu, us)
+
+	// removing once the put was observed removes the item everywhere
+	us.remove(StrategyORSet, Timestamp{Wall: 4, Region: "us"})
+	assert.True(t, eu.merge(StrategyORSet, copyItemState(us)))
+	assert.False(t, eu.present(StrategyORSet))
+}
+
+type testRegion struct {
+	bus        bus.EventBus
+	replicator *Replicator
+	peers      []*testRegion
+	connected  atomic.Bool
+}
+
+func newTestRegions(t *testing.T, config *Config, names ...string) []*testRegion {
+	var regions []*testRegion
+	for _, name := range names {
+		region := &testRegion{bus: bus.NewEventBusInstance()}
+		region.connected.Store(true)
+		cfg := *config
+		cfg.Region = name
+		cfg.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+		r, err := NewReplicator(region.bus, &cfg, func(payload []byte) error {
+			if !region.connected.Load() {
+				return nil
+			}
+			for _, peer := range region.peers {
+				if peer.connected.Load() {
+					peer.replicator.Receive(payload)
+				}
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		region.replicator = r
+		regions = append(regions, region)
+	}
+	for _, region := range regions {
+		for _, peer := range regions {
+			if peer != region {
+				region.peers = append(region.peers, peer)
+			}
+		}
+	}
+	for _, region := range regions {
+		require.NoError(t, region.replicator.Start())
+		t.Cleanup(region.replicator.Stop)
+	}
+	return regions
+}
+
+func TestNewReplicator_Invalid(t *testing.T) {
+	publish := func([]byte) error { return nil }
+	b := bus.NewEventBusInstance()
+
+	_, err := NewReplicator(b, &Config{}, publish)
+	assert.Error(t, err)
+	_, err = NewReplicator(b, &Config{Region: "eu"}, nil)
+	assert.Error(t, err)
+	_, err = NewReplicator(b, &Config{Region: "eu", Stores: []*StoreConfig{{Name: "s", Strategy: "mvr"}}}, publish)
+	assert.Error(t, err)
+	_, err = NewReplicator(b, &Config{Region: "eu", Stores: []*StoreConfig{{Name: "s"}, {Name: "s"}}}, publish)
+	assert.Error(t, err)
+}
+
+func TestReplicator_Stores(t *testing.T) {
+	regions := newTestRegions(t, &Config{Stores: []*StoreConfig{{Name: "dashboard"}}}, "eu", "us")
+	eu := regions[0].bus.GetStoreManager().CreateStore("dashboard")
+	us := regions[1].bus.GetStoreManager().CreateStore("dashboard")
+
+	eu.Put("cpu", map[string]interface{}{"value": 42.0}, "updated")
+	assert.Eventually(t, func() bool {
+		v, ok := us.Get("cpu")
+		return ok && v.(map[string]interface{})["value"] == 42.0
+	}, time.Second, 5*time.Millisecond)
+
+	us.Remove("cpu", "removed")
+	assert.Eventually(t, func() bool {
+		_, ok := eu.Get("cpu")
+		return !ok
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestReplicator_StoreCreatedLater(t *testing.T) {
+	type metric struct {
+		Value int `json:"value"`
+	}
+	regions := newTestRegions(t, &Config{Stores: []*StoreConfig{{Name: "metrics", Strategy: StrategyORSet}}},
+		"eu", "us")
+	eu := regions[0].bus.GetStoreManager().CreateStoreWithType("metrics", reflect.TypeOf(metric{}))
+	eu.Put("mem", metric{Value: 7}, "updated")
+
+	// the us store is created once the update was replicated, and receives it typed
+	assert.Eventually(t, func() bool {
+		regions[1].replicator.lock.Lock()
+		defer regions[1].replicator.lock.Unlock()
+		return len(regions[1].replicator.stores["metrics"].items) == 1
+	}, time.Second, 5*time.Millisecond)
+	us := regions[1].bus.GetStoreManager().CreateStoreWithType("metrics", reflect.TypeOf(metric{}))
+	assert.Eventually(t, func() bool {
+		v, ok := us.Get("mem")
+		return ok && v == metric{Value: 7}
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestReplicator_Resync(t *testing.T) {
+	regions := newTestRegions(t, &Config{Stores: []*StoreConfig{{Name: "dashboard"}}}, "eu", "us")
+	eu := regions[0].bus.GetStoreManager().CreateStore("dashboard")
+	us := regions[1].bus.GetStoreManager().CreateStore("dashboard")
+
+	// both regions write while cut off from each other
+	regions[0].connected.Store(false)
+	regions[1].connected.Store(false)
+	eu.Put("eu-only", "a", "updated")
+	us.Put("us-only", "b", "updated")
+	assert.Eventually(t, func() bool {
+		regions[1].replicator.lock.Lock()
+		defer regions[1].replicator.lock.Unlock()
+		return len(regions[1].replicator.stores["dashboard"].items) == 1
+	}, time.Second, 5*time.Millisecond)
+
+	regions[0].connected.Store(true)
+	regions[1].connected.Store(true)
+	regions[0].replicator.Resync()
+	assert.Eventually(t, func() bool {
+		return len(eu.AllValues()) == 2 && len(us.AllValues()) == 2
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestReplicator_Channels(t *testing.T) {
+	regions := newTestRegions(t, &Config{Channels: []string{"dashboard-events"}}, "eu", "us")
+
+	received := make(chan *model.Message, 2)
+	euHandler, _ := regions[0].bus.ListenStream("dashboard-events")
+	euHandler.Handle(func(msg *model.Message) { received <- msg }, func(err error) {})
+	usHandler, _ := regions[1].bus.ListenStream("dashboard-events")
+	usHandler.Handle(func(msg *model.Message) {
+		if rm, ok := msg.Payload.(*RegionMessage); ok {
+			received <- msg
+			assert.Equal(t, "eu", rm.Region)
+			assert.Equal(t, map[string]interface{}{"cpu": 42.0}, rm.Payload)
+		}
+	}, func(err error) {})
+
+	require.NoError(t, regions[0].bus.SendResponseMessage("dashboard-events", map[string]interface{}{"cpu": 42}, nil))
+
+	// the message is delivered locally, and tagged with its region in the other region
+	for i := 0; i < 2; i++ {
+		select {
+		case <-received:
+		case <-time.After(time.Second):
+			t.Fatal("message not delivered")
+		}
+	}
+	// but never echoed back to the region it was sent in
+	select {
+	case msg := <-received:
+		t.Fatalf("unexpected message %v", msg.Payload)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,445 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+// Package replication replicates bus channels and stores between regions running active-active. Every
+// region accepts writes, store items converge through conflict-free replicated data types and messages are
+// delivered to the other regions tagged with the region they were sent in. The transport is left to the
+// caller: whatever a Replicator publishes must reach the Receive method of the Replicators of every other
+// region, such as a topic on a broker they all connect to.
+package replication
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/model"
+)
+
+// ReplicatedState is the state of store changes applied from other regions.
+const ReplicatedState = "replicated"
+
+const (
+	envelopeMessage = "message"
+	envelopeState   = "state"
+)
+
+// StoreConfig names a store to replicate and how concurrent writes to its items are resolved.
+type StoreConfig struct {
+	Name     string `json:"name"`     // name of the store
+	Strategy string `json:"strategy"` // StrategyLWW (default) or StrategyORSet
+}
+
+// Config selects what a Replicator replicates.
+type Config struct {
+	Region   string         // name of this region, unique among the regions
+	Channels []string       // channels whose responses are delivered to the other regions
+	Stores   []*StoreConfig // stores kept in sync with the other regions
+	Logger   *slog.Logger   // defaults to slog.Default()
+}
+
+// RegionMessage is delivered as a response on a replicated channel for every response sent on it in
+// another region.
+type RegionMessage struct {
+	Region  string      `json:"region"`  // region the message was sent in
+	Payload interface{} `json:"payload"` // payload of the message, decoded from JSON
+}
+
+// envelope is what regions exchange, either a channel message or the state of store items.
+type envelope struct {
+	Type    string          `json:"type"`
+	Region  string          `json:"region"`
+	Channel string          `json:"channel,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Items   []*itemState    `json:"items,omitempty"`
+	Reply   bool            `json:"reply,omitempty"` // receivers reply with the state of all their items
+}
+
+type replicatedStore struct {
+	name     string
+	strategy string
+	store    bus.BusStore    // nil until the store is created
+	stream   bus.StoreStream // changes of store
+	items    map[string]*itemState
+}
+
+// Replicator replicates the channels and stores of one region.
+type Replicator struct {
+	config    *Config
+	eventBus  bus.EventBus
+	publish   func(payload []byte) error
+	logger    *slog.Logger
+	clock     *Clock
+	stores    map[string]*replicatedStore
+	handlers  []bus.MessageHandler
+	monitorId bus.MonitorEventListenerId
+	started   bool
+	lock      sync.Mutex
+}
+
+// NewReplicator creates a Replicator for the region, which hands what it replicates to publish.
+func NewReplicator(eventBus bus.EventBus, config *Config, publish func(payload []byte) error) (*Replicator, error) {
+	if config == nil || config.Region == "" {
+		return nil, fmt.Errorf("replication needs the name of the region")
+	}
+	if publish == nil {
+		return nil, fmt.Errorf("replication needs a publish function")
+	}
+	r := &Replicator{
+		config:   config,
+		eventBus: eventBus,
+		publish:  publish,
+		logger:   config.Logger,
+		clock:    NewClock(config.Region),
+		stores:   make(map[string]*replicatedStore),
+	}
+	if r.logger == nil {
+		r.logger = slog.Default()
+	}
+	for _, storeConfig := range config.Stores {
+		if storeConfig == nil || storeConfig.Name == "" {
+			return nil, fmt.Errorf("replicated store is missing a name")
+		}
+		strategy := storeConfig.Strategy
+		if strategy == "" {
+			strategy = StrategyLWW
+		}
+		if strategy != StrategyLWW && strategy != StrategyORSet {
+			return nil, fmt.Errorf("replicated store '%s' has unknown strategy '%s'", storeConfig.Name, strategy)
+		}
+		if _, ok := r.stores[storeConfig.Name]; ok {
+			return nil, fmt.Errorf("store '%s' is replicated more than once", storeConfig.Name)
+		}
+		r.stores[storeConfig.Name] = &replicatedStore{
+			name:     storeConfig.Name,
+			strategy: strategy,
+			items:    make(map[string]*itemState),
+		}
+	}
+	return r, nil
+}
+
+// Start replicates the responses of the channels and the changes of the stores. Stores that do not exist
+// yet are replicated once they are created.
+func (r *Replicator) Start() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.started {
+		return fmt.Errorf("replication of region '%s' already started", r.config.Region)
+	}
+	cm := r.eventBus.GetChannelManager()
+	for _, channel := range r.config.Channels {
+		if !cm.CheckChannelExists(channel) {
+			cm.CreateChannel(channel)
+		}
+		handler, err := r.eventBus.ListenStream(channel)
+		if err != nil {
+			r.closeHandlers()
+			return err
+		}
+		handler.Handle(r.relay(channel), func(err error) {})
+		r.handlers = append(r.handlers, handler)
+	}
+	r.monitorId = r.eventBus.AddMonitorEventListener(func(event *bus.MonitorEvent) {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		if !r.started {
+			return
+		}
+		if event.EventType == bus.StoreCreatedEvt {
+			r.attach(event.EntityName)
+		} else {
+			r.detach(event.EntityName)
+		}
+	}, bus.StoreCreatedEvt, bus.StoreDestroyedEvt)
+	r.started = true
+	for name := range r.stores {
+		r.attach(name)
+	}
+	return nil
+}
+
+// Stop stops replicating, the state of the replicated stores is kept.
+func (r *Replicator) Stop() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !r.started {
+		return
+	}
+	r.started = false
+	r.eventBus.RemoveMonitorEventListener(r.monitorId)
+	r.closeHandlers()
+	for name := range r.stores {
+		r.detach(name)
+	}
+}
+
+func (r *Replicator) closeHandlers() {
+	for _, handler := range r.handlers {
+		handler.Close()
+	}
+	r.handlers = nil
+}
+
+// Resync sends the state of every replicated item to the other regions, and asks them for theirs. Call it
+// whenever the transport (re)connects, so updates missed while disconnected converge.
+func (r *Replicator) Resync() {
+	r.lock.Lock()
+	env := r.fullState(true)
+	r.lock.Unlock()
+	r.send(env)
+}
+
+// Receive handles what another region published.
+func (r *Replicator) Receive(payload []byte) {
+	env := &envelope{}
+	if err := json.Unmarshal(payload, env); err != nil {
+		r.logger.Warn("[ranch] replication received an unreadable update", "error", err.Error())
+		return
+	}
+	if env.Region == r.config.Region {
+		return
+	}
+	switch env.Type {
+	case envelopeMessage:
+		r.deliver(env)
+	case envelopeState:
+		r.lock.Lock()
+		r.merge(env.Items)
+		var reply *envelope
+		if env.Reply {
+			reply = r.fullState(false)
+		}
+		r.lock.Unlock()
+		if reply != nil {
+			r.send(reply)
+		}
+	}
+}
+
+// relay returns a handler publishing the responses sent on a channel in this region.
+func (r *Replicator) relay(channel string) bus.MessageHandlerFunction {
+	return func(msg *model.Message) {
+		switch msg.Payload.(type) {
+		case *RegionMessage, RegionMessage:
+			return // delivered from another region
+		}
+		payload, err := encodePayload(msg.Payload)
+		if err != nil {
+			r.logger.Error("[ranch] replication unable to encode message", "channel", channel, "error", err.Error())
+			return
+		}
+		r.send(&envelope{Type: envelopeMessage, Region: r.config.Region, Channel: channel, Payload: payload})
+	}
+}
+
+// deliver sends a message from another region on the local channel, if the channel is replicated.
+func (r *Replicator) deliver(env *envelope) {
+	if !slices.Contains(r.config.Channels, env.Channel) {
+		return
+	}
+	var payload interface{}
+	if len(env.Payload) > 0 {
+		if err := json.Unmarshal(env.Payload, &payload); err != nil {
+			r.logger.Warn("[ranch] replication received an unreadable message", "region", env.Region,
+				"channel", env.Channel, "error", err.Error())
+			return
+		}
+	}
+	_ = r.eventBus.SendResponseMessage(env.Channel, &RegionMessage{Region: env.Region, Payload: payload}, nil)
+}
+
+// attach starts replicating a store once it exists. Items replicated before the store existed are put in
+// it, and items only the store has are published as written in this region. The lock must be held.
+func (r *Replicator) attach(name string) {
+	rs := r.stores[name]
+	if rs == nil || rs.store != nil {
+		return
+	}
+	store := r.eventBus.GetStoreManager().GetStore(name)
+	if store == nil {
+		return
+	}
+	stream := store.OnAllChanges()
+	if err := stream.Subscribe(func(change *bus.StoreChange) { r.storeChanged(rs, change) }); err != nil {
+		r.logger.Error("[ranch] replication unable to watch store", "store", name, "error", err.Error())
+		return
+	}
+	rs.store, rs.stream = store, stream
+
+	for _, state := range rs.items {
+		r.apply(rs, state)
+	}
+	var updates []*itemState
+	for id := range store.AllValuesAsMap() {
+		if _, ok := rs.items[id]; ok {
+			continue
+		}
+		if state := r.localChange(rs, id); state != nil {
+			updates = append(updates, state)
+		}
+	}
+	if len(updates) > 0 {
+		go r.send(&envelope{Type: envelopeState, Region: r.config.Region, Items: updates})
+	}
+}
+
+// detach stops replicating a store that was destroyed. The lock must be held.
+func (r *Replicator) detach(name string) {
+	rs := r.stores[name]
+	if rs == nil || rs.store == nil {
+		return
+	}
+	_ = rs.stream.Unsubscribe()
+	rs.store, rs.stream = nil, nil
+}
+
+// storeChanged publishes the items changed in this region.
+func (r *Replicator) storeChanged(rs *replicatedStore, change *bus.StoreChange) {
+	if change.State == ReplicatedState {
+		return
+	}
+	changes := []*bus.StoreChange{change}
+	if len(change.Batch) > 0 {
+		changes = change.Batch
+	}
+	r.lock.Lock()
+	if rs.store == nil {
+		r.lock.Unlock()
+		return
+	}
+	var updates []*itemState
+	for _, itemChange := range changes {
+		if state := r.localChange(rs, itemChange.Id); state != nil {
+			updates = append(updates, state)
+		}
+	}
+	var env *envelope
+	if len(updates) > 0 {
+		env = &envelope{Type: envelopeState, Region: r.config.Region, Items: updates}
+	}
+	r.lock.Unlock()
+	if env != nil {
+		r.send(env)
+	}
+}
+
+// localChange records the current value of an item written in this region, returning a copy of its
+// state, or nil if the store holds what was already replicated. The store is read rather than the change,
+// as changes are delivered asynchronously and may be stale by now. The lock must be held.
+func (r *Replicator) localChange(rs *replicatedStore, id string) *itemState {
+	state := rs.items[id]
+	if state == nil {
+		state = &itemState{Store: rs.name, Id: id}
+	}
+	present := state.present(rs.strategy)
+	value, ok := rs.store.Get(id)
+	if !ok {
+		if !present {
+			return nil
+		}
+		state.remove(rs.strategy, r.clock.Now())
+	} else {
+		raw, err := json.Marshal(value)
+		if err != nil {
+			r.logger.Error("[ranch] replication unable to encode store item", "store", rs.name, "id", id,
+				"error", err.Error())
+			return nil
+		}
+		if present && bytes.Equal(raw, state.Value) {
+			return nil
+		}
+		state.put(rs.strategy, raw, r.clock.Now())
+	}
+	rs.items[id] = state
+	return copyItemState(state)
+}
+
+// merge folds item states from another region into the replicated stores. The lock must be held.
+func (r *Replicator) merge(items []*itemState) {
+	for _, remote := range items {
+		if remote == nil {
+			continue
+		}
+		r.clock.Observe(remote.Stamp)
+		for _, add := range remote.Adds {
+			r.clock.Observe(add)
+		}
+		rs := r.stores[remote.Store]
+		if rs == nil {
+			continue
+		}
+		state := rs.items[remote.Id]
+		if state == nil {
+			state = &itemState{Store: rs.name, Id: remote.Id}
+			rs.items[remote.Id] = state
+		}
+		if state.merge(rs.strategy, remote) && rs.store != nil {
+			r.apply(rs, state)
+		}
+	}
+}
+
+// apply makes the store hold the replicated state of an item. The lock must be held.
+func (r *Replicator) apply(rs *replicatedStore, state *itemState) {
+	if !state.present(rs.strategy) {
+		if _, ok := rs.store.Get(state.Id); ok {
+			rs.store.Remove(state.Id, ReplicatedState)
+		}
+		return
+	}
+	var value interface{}
+	if err := json.Unmarshal(state.Value, &value); err != nil {
+		r.logger.Error("[ranch] replication unable to decode store item", "store", rs.name, "id", state.Id,
+			"error", err.Error())
+		return
+	}
+	converted, err := model.ConvertValueToType(value, rs.store.GetItemType())
+	if err != nil {
+		r.logger.Error("[ranch] replication unable to convert store item", "store", rs.name, "id", state.Id,
+			"error", err.Error())
+		return
+	}
+	rs.store.Put(state.Id, converted, ReplicatedState)
+}
+
+// fullState returns the state of every replicated item. The lock must be held.
+func (r *Replicator) fullState(reply bool) *envelope {
+	env := &envelope{Type: envelopeState, Region: r.config.Region, Reply: reply}
+	for _, rs := range r.stores {
+		for _, state := range rs.items {
+			env.Items = append(env.Items, copyItemState(state))
+		}
+	}
+	return env
+}
+
+func (r *Replicator) send(env *envelope) {
+	payload, err := json.Marshal(env)
+	if err != nil {
+		r.logger.Error("[ranch] replication unable to encode update", "error", err.Error())
+		return
+	}
+	if err = r.publish(payload); err != nil {
+		r.logger.Warn("[ranch] replication unable to publish update", "type", env.Type, "error", err.Error())
+	}
+}
+
+func copyItemState(state *itemState) *itemState {
+	c := *state
+	c.Adds = slices.Clone(state.Adds)
+	c.Removes = slices.Clone(state.Removes)
+	return &c
+}
+
+// encodePayload encodes a message payload as JSON, payloads that already are JSON are used as they are.
+func encodePayload(payload interface{}) (json.RawMessage, error) {
+	if p, ok := payload.([]byte); ok && json.Valid(p) {
+		return p, nil
+	}
+	return json.Marshal(payload)
+}
@@ -0,0 +1,115 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package responsecache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pb33f/ranch/clock"
+)
+
+// DefaultMaxEntries is how many responses a MemoryStore keeps if no maximum is given.
+const DefaultMaxEntries = 10000
+
+// MemoryStore keeps responses in memory, evicting the least recently used once it holds its maximum.
+type MemoryStore struct {
+	lock       sync.Mutex
+	maxEntries int
+	entries    map[string]*list.Element
+	lru        *list.List // most recently used first
+	tags       map[string]map[string]struct{}
+}
+
+type memoryEntry struct {
+	key     string
+	entry   *Entry
+	expires time.Time
+}
+
+// NewMemoryStore returns a store keeping up to maxEntries responses, DefaultMaxEntries if maxEntries is 0.
+func NewMemoryStore(maxEntries int) *MemoryStore {
+	if maxEntries <= 0 {
+		maxEntries = DefaultMaxEntries
+	}
+	return &MemoryStore{
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+		tags:       make(map[string]map[string]struct{}),
+	}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) (*Entry, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	element, ok := s.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	me := element.Value.(*memoryEntry)
+	if !clock.Now().Before(me.expires) {
+		s.remove(element)
+		return nil, nil
+	}
+	s.lru.MoveToFront(element)
+	return me.entry, nil
+}
+
+func (s *MemoryStore) Set(ctx context.Context, key string, entry *Entry, ttl time.Duration) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if element, ok := s.entries[key]; ok {
+		s.remove(element)
+	}
+	s.entries[key] = s.lru.PushFront(&memoryEntry{key: key, entry: entry, expires: clock.Now().Add(ttl)})
+	for _, tag := range entry.Tags {
+		if s.tags[tag] == nil {
+			s.tags[tag] = make(map[string]struct{})
+		}
+		s.tags[tag][key] = struct{}{}
+	}
+	for s.lru.Len() > s.maxEntries {
+		s.remove(s.lru.Back())
+	}
+	return nil
+}
+
+func (s *MemoryStore) Purge(ctx context.Context, tags ...string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, tag := range tags {
+		for key := range s.tags[tag] {
+			if element, ok := s.entries[key]; ok {
+				s.remove(element)
+			}
+		}
+	}
+	return nil
+}
+
+// Len returns how many responses the store holds, expired ones included until they are looked up or evicted.
+func (s *MemoryStore) Len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.lru.Len()
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// remove drops an entry and its tags, the lock must be held.
+func (s *MemoryStore) remove(element *list.Element) {
+	me := s.lru.Remove(element).(*memoryEntry)
+	delete(s.entries, me.key)
+	for _, tag := range me.entry.Tags {
+		delete(s.tags[tag], me.key)
+		if len(s.tags[tag]) == 0 {
+			delete(s.tags, tag)
+		}
+	}
+}
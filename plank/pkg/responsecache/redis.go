@@ -0,0 +1,107 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package responsecache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultRedisKeyPrefix prefixes the Redis keys of cached responses when no prefix is configured.
+const DefaultRedisKeyPrefix = "ranch:cache:"
+
+var (
+	// KEYS: the entry key, then the sets of the keys tagged with each tag of the entry. ARGV: the entry and
+	// its TTL in milliseconds. Tag sets live as long as the longest lived entry they hold.
+	redisCacheSetScript = redis.NewScript(`
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+for i = 2, #KEYS do
+	redis.call('SADD', KEYS[i], KEYS[1])
+	if redis.call('PTTL', KEYS[i]) < tonumber(ARGV[2]) then
+		redis.call('PEXPIRE', KEYS[i], ARGV[2])
+	end
+end
+return 1`)
+
+	// KEYS: the tag sets of the entries to purge.
+	redisCachePurgeScript = redis.NewScript(`
+for i = 1, #KEYS do
+	for _, key in ipairs(redis.call('SMEMBERS', KEYS[i])) do
+		redis.call('DEL', key)
+	end
+	redis.call('DEL', KEYS[i])
+end
+return 1`)
+)
+
+// RedisStore keeps responses in Redis, shared by every instance connected to the same Redis server. Entries
+// are JSON encoded and expire along with their TTL, each tag is a set of the keys of the entries tagged
+// with it. Only database 0 is supported.
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisStore returns a store keeping responses in Redis through client, under keys starting with
+// keyPrefix, DefaultRedisKeyPrefix if empty. The store closes the client when it is closed.
+func NewRedisStore(client *redis.Client, keyPrefix string) *RedisStore {
+	if keyPrefix == "" {
+		keyPrefix = DefaultRedisKeyPrefix
+	}
+	return &RedisStore{client: client, prefix: keyPrefix}
+}
+
+func (s *RedisStore) entryKey(key string) string {
+	return s.prefix + "entry:" + key
+}
+
+func (s *RedisStore) tagKey(tag string) string {
+	return s.prefix + "tag:" + tag
+}
+
+func (s *RedisStore) Get(ctx context.Context, key string) (*Entry, error) {
+	data, err := s.client.Get(ctx, s.entryKey(key)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entry Entry
+	if err = json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (s *RedisStore) Set(ctx context.Context, key string, entry *Entry, ttl time.Duration) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	keys := []string{s.entryKey(key)}
+	for _, tag := range entry.Tags {
+		keys = append(keys, s.tagKey(tag))
+	}
+	return redisCacheSetScript.Run(ctx, s.client, keys, data, max(ttl.Milliseconds(), 1)).Err()
+}
+
+func (s *RedisStore) Purge(ctx context.Context, tags ...string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		keys = append(keys, s.tagKey(tag))
+	}
+	return redisCachePurgeScript.Run(ctx, s.client, keys).Err()
+}
+
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,58 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+// Package responsecache keeps the responses of idempotent REST bridges, so repeated requests are answered
+// without a round trip to the service channel. Responses are kept in memory, evicting the least recently
+// used, or in Redis to share them between instances, for a TTL. They are tagged, e.g. with the service
+// channel that produced them, and purged by tag when they go stale.
+package responsecache
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Entry is a cached response.
+type Entry struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"` // headers set by the service, not those of the middleware in front of it
+	Body   []byte      `json:"body"`
+	Tags   []string    `json:"tags"`   // the entry is purged along with any of these tags
+	Stored time.Time   `json:"stored"` // when the response was cached, for its Age header
+}
+
+// Store keeps cached responses.
+type Store interface {
+	// Get returns the entry cached for key, nil if there is none or it expired.
+	Get(ctx context.Context, key string) (*Entry, error)
+	// Set caches an entry for key, for ttl.
+	Set(ctx context.Context, key string, entry *Entry, ttl time.Duration) error
+	// Purge removes the entries tagged with any of the tags.
+	Purge(ctx context.Context, tags ...string) error
+	// Close releases the resources of the store.
+	Close() error
+}
+
+// Key returns the key of the response to a request: its method, its URI and the values of the vary headers
+// the response depends on, e.g. Accept.
+func Key(r *http.Request, vary []string) string {
+	var key strings.Builder
+	key.WriteString(r.Method)
+	key.WriteByte(' ')
+	key.WriteString(r.URL.RequestURI())
+	names := make([]string, 0, len(vary))
+	for _, name := range vary {
+		names = append(names, http.CanonicalHeaderKey(name))
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		key.WriteByte('\n')
+		key.WriteString(name)
+		key.WriteByte(':')
+		key.WriteString(strings.Join(r.Header.Values(name), ","))
+	}
+	return key.String()
+}
@@ -0,0 +1,116 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package responsecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/pb33f/ranch/clock"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKey(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/herd?name=daisy", nil)
+	r.Header.Set("Accept", "application/json")
+	r.Header.Set("Accept-Language", "en")
+	assert.Equal(t, "GET /herd?name=daisy", Key(r, nil))
+	assert.Equal(t, "GET /herd?name=daisy\nAccept:application/json\nAccept-Language:en",
+		Key(r, []string{"accept-language", "Accept"}))
+
+	// requests for other representations are other responses
+	json := Key(r, []string{"Accept"})
+	r.Header.Set("Accept", "application/yaml")
+	assert.NotEqual(t, json, Key(r, []string{"Accept"}))
+	assert.Equal(t, Key(r, nil), Key(r, []string{}))
+	r.Method = http.MethodHead
+	assert.Equal(t, "HEAD /herd?name=daisy", Key(r, nil))
+}
+
+// testStore runs the tests every store passes, advance moves the time of the store forward.
+func testStore(t *testing.T, store Store, advance func(d time.Duration)) {
+	ctx := context.Background()
+	daisy := &Entry{Status: http.StatusOK, Header: http.Header{"Content-Type": {"application/json"}},
+		Body: []byte(`{"name":"daisy"}`), Tags: []string{"cows", "daisy"}, Stored: time.Unix(1714564800, 0).UTC()}
+	bessie := &Entry{Status: http.StatusOK, Body: []byte(`{"name":"bessie"}`), Tags: []string{"cows"}}
+	pig := &Entry{Status: http.StatusOK, Body: []byte(`{"name":"wilbur"}`), Tags: []string{"pigs"}}
+
+	entry, err := store.Get(ctx, "daisy")
+	require.NoError(t, err)
+	assert.Nil(t, entry)
+
+	require.NoError(t, store.Set(ctx, "daisy", daisy, time.Minute))
+	require.NoError(t, store.Set(ctx, "bessie", bessie, 2*time.Minute))
+	require.NoError(t, store.Set(ctx, "wilbur", pig, time.Minute))
+	entry, err = store.Get(ctx, "daisy")
+	require.NoError(t, err)
+	assert.Equal(t, daisy, entry)
+
+	// entries expire with their TTL
+	advance(time.Minute + time.Second)
+	entry, err = store.Get(ctx, "daisy")
+	require.NoError(t, err)
+	assert.Nil(t, entry)
+	entry, err = store.Get(ctx, "bessie")
+	require.NoError(t, err)
+	assert.Equal(t, bessie.Body, entry.Body)
+
+	// and are purged by tag
+	require.NoError(t, store.Set(ctx, "daisy", daisy, time.Minute))
+	require.NoError(t, store.Set(ctx, "wilbur", pig, time.Minute))
+	require.NoError(t, store.Purge(ctx, "cows", "sheep"))
+	for _, key := range []string{"daisy", "bessie"} {
+		entry, err = store.Get(ctx, key)
+		require.NoError(t, err)
+		assert.Nil(t, entry, key)
+	}
+	entry, err = store.Get(ctx, "wilbur")
+	require.NoError(t, err)
+	assert.NotNil(t, entry)
+	require.NoError(t, store.Purge(ctx))
+}
+
+func TestMemoryStore(t *testing.T) {
+	fake := clock.NewFakeClock(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	clock.Set(fake)
+	defer clock.Reset()
+	testStore(t, NewMemoryStore(0), fake.Advance)
+}
+
+func TestMemoryStore_Evicts(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(2)
+	for _, key := range []string{"daisy", "bessie"} {
+		require.NoError(t, store.Set(ctx, key, &Entry{Tags: []string{"cows"}}, time.Minute))
+	}
+	// daisy was used more recently than bessie, who makes room for wilbur
+	entry, _ := store.Get(ctx, "daisy")
+	assert.NotNil(t, entry)
+	require.NoError(t, store.Set(ctx, "wilbur", &Entry{Tags: []string{"pigs"}}, time.Minute))
+	assert.Equal(t, 2, store.Len())
+	entry, _ = store.Get(ctx, "bessie")
+	assert.Nil(t, entry)
+
+	require.NoError(t, store.Purge(ctx, "cows"))
+	assert.Equal(t, 1, store.Len())
+	assert.Empty(t, store.tags["cows"])
+}
+
+func TestRedisStore(t *testing.T) {
+	srv := miniredis.RunT(t)
+	store := NewRedisStore(redis.NewClient(&redis.Options{Addr: srv.Addr()}), "")
+	defer store.Close()
+	testStore(t, store, srv.FastForward)
+
+	ctx := context.Background()
+	require.NoError(t, store.Set(ctx, "daisy", &Entry{Tags: []string{"cows"}}, time.Minute))
+	assert.True(t, srv.Exists(DefaultRedisKeyPrefix+"entry:daisy"))
+	assert.Equal(t, time.Minute, srv.TTL(DefaultRedisKeyPrefix+"tag:cows"))
+}
@@ -0,0 +1,123 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+// Package scaffold generates the source of new fabric services, so a team adding its first service starts
+// from working code: lifecycle hooks, REST bridges, typed request and response structs, and tests.
+package scaffold
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+// ServiceOptions describe the service to generate.
+type ServiceOptions struct {
+	Name    string // name of the service, e.g. stock or stock-price
+	Channel string // channel the service listens to, <name>-service if empty
+	Package string // Go package of the generated files, services if empty
+}
+
+// File is a generated source file.
+type File struct {
+	Name    string // name of the file, relative to the directory it is written to
+	Content []byte
+}
+
+var validName = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9]*([-_][a-zA-Z0-9]+)*$`)
+
+// serviceData is what the templates of a service are executed with.
+type serviceData struct {
+	ServiceOptions
+	Type string // exported Go name of the service, e.g. StockPrice
+	Path string // URI path segment of its REST bridges, e.g. stock-price
+}
+
+// GenerateService returns the source files of a new service, formatted with gofmt.
+func GenerateService(opts ServiceOptions) ([]*File, error) {
+	if !validName.MatchString(opts.Name) {
+		return nil, fmt.Errorf("invalid service name '%s', use letters and digits separated by - or _", opts.Name)
+	}
+	if opts.Channel == "" {
+		opts.Channel = strings.ToLower(opts.Name) + "-service"
+	}
+	if opts.Package == "" {
+		opts.Package = "services"
+	}
+	if !isIdentifier(opts.Package) {
+		return nil, fmt.Errorf("invalid package name '%s'", opts.Package)
+	}
+	data := &serviceData{
+		ServiceOptions: opts,
+		Type:           typeName(opts.Name),
+		Path:           strings.ToLower(strings.ReplaceAll(opts.Name, "_", "-")),
+	}
+
+	base := strings.ToLower(strings.ReplaceAll(opts.Name, "-", "_")) + "_service"
+	var files []*File
+	for _, t := range []struct {
+		name     string
+		template *template.Template
+	}{
+		{base + ".go", serviceTemplate},
+		{base + "_test.go", serviceTestTemplate},
+	} {
+		var buf bytes.Buffer
+		if err := t.template.Execute(&buf, data); err != nil {
+			return nil, err
+		}
+		src, err := format.Source(buf.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("generated %s does not compile: %w", t.name, err)
+		}
+		files = append(files, &File{Name: t.name, Content: src})
+	}
+	return files, nil
+}
+
+// WriteFiles writes generated files to a directory, creating it if needed. Existing files are only
+// replaced if overwrite is set, otherwise nothing is written.
+func WriteFiles(dir string, files []*File, overwrite bool) error {
+	if !overwrite {
+		for _, f := range files {
+			if _, err := os.Stat(filepath.Join(dir, f.Name)); err == nil {
+				return fmt.Errorf("%s already exists", filepath.Join(dir, f.Name))
+			}
+		}
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := os.WriteFile(filepath.Join(dir, f.Name), f.Content, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// typeName returns the exported Go name of a service name, e.g. StockPrice for stock-price.
+func typeName(name string) string {
+	var b strings.Builder
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool { return r == '-' || r == '_' }) {
+		runes := []rune(part)
+		runes[0] = unicode.ToUpper(runes[0])
+		b.WriteString(string(runes))
+	}
+	return b.String()
+}
+
+func isIdentifier(s string) bool {
+	for i, r := range s {
+		if !unicode.IsLetter(r) && r != '_' && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return s != ""
+}
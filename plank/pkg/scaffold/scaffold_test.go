@@ -0,0 +1,61 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package scaffold
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateService(t *testing.T) {
+	files, err := GenerateService(ServiceOptions{Name: "stock-price"})
+	assert.NoError(t, err)
+	if assert.Len(t, files, 2) {
+		assert.Equal(t, "stock_price_service.go", files[0].Name)
+		assert.Equal(t, "stock_price_service_test.go", files[1].Name)
+
+		src := string(files[0].Content)
+		assert.Contains(t, src, "package services\n")
+		assert.Contains(t, src, `StockPriceServiceChannel = "stock-price-service"`)
+		assert.Contains(t, src, "func (s *StockPriceService) GetRESTBridgeConfig() []*service.RESTBridgeConfig {")
+		assert.Contains(t, src, `"/rest/stock-price/{id}"`)
+		assert.Contains(t, string(files[1].Content), "func TestStockPriceService_HandleServiceRequest(t *testing.T) {")
+	}
+
+	files, err = GenerateService(ServiceOptions{Name: "stock", Channel: "stocks", Package: "market"})
+	assert.NoError(t, err)
+	assert.Contains(t, string(files[0].Content), `StockServiceChannel = "stocks"`)
+	assert.Contains(t, string(files[0].Content), "package market\n")
+
+	_, err = GenerateService(ServiceOptions{Name: "stock price"})
+	assert.Error(t, err)
+	_, err = GenerateService(ServiceOptions{Name: "stock", Package: "my-services"})
+	assert.Error(t, err)
+}
+
+func TestWriteFiles(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "services")
+	files := []*File{{Name: "moo.go", Content: []byte("package services\n")}}
+	assert.NoError(t, WriteFiles(dir, files, false))
+
+	content, err := os.ReadFile(filepath.Join(dir, "moo.go"))
+	assert.NoError(t, err)
+	assert.Equal(t, "package services\n", string(content))
+
+	files[0].Content = []byte("package cows\n")
+	assert.Error(t, WriteFiles(dir, files, false))
+	assert.NoError(t, WriteFiles(dir, files, true))
+	content, _ = os.ReadFile(filepath.Join(dir, "moo.go"))
+	assert.Equal(t, "package cows\n", string(content))
+}
+
+func TestTypeName(t *testing.T) {
+	assert.Equal(t, "Stock", typeName("stock"))
+	assert.Equal(t, "StockPrice", typeName("stock-price"))
+	assert.Equal(t, "StockPrice", typeName("stock_price"))
+	assert.Equal(t, "HTTPProbe", typeName("HTTPProbe"))
+}
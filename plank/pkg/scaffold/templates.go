@@ -0,0 +1,221 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package scaffold
+
+import "text/template"
+
+var serviceTemplate = template.Must(template.New("service").Parse(`package {{.Package}}
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/pb33f/ranch/model"
+	"github.com/pb33f/ranch/service"
+)
+
+const (
+	// {{.Type}}ServiceChannel is the channel the {{.Name}} service listens to.
+	{{.Type}}ServiceChannel = "{{.Channel}}"
+
+	// Get{{.Type}}Command returns the value of an item.
+	Get{{.Type}}Command = "get-{{.Path}}"
+	// Update{{.Type}}Command sets the value of an item.
+	Update{{.Type}}Command = "update-{{.Path}}"
+)
+
+// {{.Type}}Request is the payload of the requests of the {{.Name}} service.
+type {{.Type}}Request struct {
+	Id    string ` + "`json:\"id\"`" + `
+	Value string ` + "`json:\"value,omitempty\"`" + ` // only used by Update{{.Type}}Command
+}
+
+// {{.Type}}Response is the payload of the responses of the {{.Name}} service.
+type {{.Type}}Response struct {
+	Id    string ` + "`json:\"id\"`" + `
+	Value string ` + "`json:\"value\"`" + `
+}
+
+// {{.Type}}Service keeps items in memory, replace them with what the service is about.
+type {{.Type}}Service struct {
+	lock  sync.RWMutex
+	items map[string]string
+}
+
+// New{{.Type}}Service returns the service, register it with
+// service.GetServiceRegistry().RegisterService(New{{.Type}}Service(), {{.Type}}ServiceChannel).
+func New{{.Type}}Service() *{{.Type}}Service {
+	return &{{.Type}}Service{items: make(map[string]string)}
+}
+
+// Init is called when the service is registered.
+func (s *{{.Type}}Service) Init(core service.FabricServiceCore) error {
+	core.SetDefaultJSONHeaders()
+	return nil
+}
+
+// HandleServiceRequest handles the requests sent to {{.Type}}ServiceChannel, by fabric clients, REST bridges
+// or other services.
+func (s *{{.Type}}Service) HandleServiceRequest(request *model.Request, core service.FabricServiceCore) {
+	// fabric clients send JSON objects, REST bridges and services send a *{{.Type}}Request
+	payload, err := model.ConvertValueToType(request.Payload, reflect.TypeOf(&{{.Type}}Request{}))
+	if err != nil {
+		core.SendErrorResponse(request, http.StatusBadRequest, "invalid {{.Name}} request: "+err.Error())
+		return
+	}
+	req := payload.(*{{.Type}}Request)
+
+	switch request.RequestCommand {
+	case Get{{.Type}}Command:
+		s.lock.RLock()
+		value, ok := s.items[req.Id]
+		s.lock.RUnlock()
+		if !ok {
+			core.SendErrorResponse(request, http.StatusNotFound, "no {{.Name}} item '"+req.Id+"'")
+			return
+		}
+		core.SendResponse(request, &{{.Type}}Response{Id: req.Id, Value: value})
+	case Update{{.Type}}Command:
+		s.lock.Lock()
+		s.items[req.Id] = req.Value
+		s.lock.Unlock()
+		core.SendResponse(request, &{{.Type}}Response{Id: req.Id, Value: req.Value})
+	default:
+		core.HandleUnknownRequest(request)
+	}
+}
+
+// OnServiceReady is called once the service is registered, the server waits for the channel returned to
+// receive true before the service gets requests.
+func (s *{{.Type}}Service) OnServiceReady() chan bool {
+	ready := make(chan bool, 1)
+	ready <- true
+	return ready
+}
+
+// OnServerShutdown is called when the server shuts down, release what the service holds here.
+func (s *{{.Type}}Service) OnServerShutdown() {}
+
+// GetRESTBridgeConfig returns the REST endpoints the server bridges to the requests of the service.
+func (s *{{.Type}}Service) GetRESTBridgeConfig() []*service.RESTBridgeConfig {
+	return []*service.RESTBridgeConfig{
+		{
+			ServiceChannel: {{.Type}}ServiceChannel,
+			Uri:            "/rest/{{.Path}}/{id}",
+			Method:         http.MethodGet,
+			Summary:        "Get a {{.Name}} item",
+			FabricRequestBuilder: func(w http.ResponseWriter, r *http.Request) model.Request {
+				id := uuid.New()
+				return model.Request{
+					Id:             &id,
+					RequestCommand: Get{{.Type}}Command,
+					Payload:        &{{.Type}}Request{Id: mux.Vars(r)["id"]},
+				}
+			},
+		},
+		{
+			ServiceChannel: {{.Type}}ServiceChannel,
+			Uri:            "/rest/{{.Path}}/{id}",
+			Method:         http.MethodPut,
+			Summary:        "Update a {{.Name}} item",
+			FabricRequestBuilder: func(w http.ResponseWriter, r *http.Request) model.Request {
+				id := uuid.New()
+				req := &{{.Type}}Request{}
+				_ = json.NewDecoder(r.Body).Decode(req)
+				req.Id = mux.Vars(r)["id"]
+				return model.Request{Id: &id, RequestCommand: Update{{.Type}}Command, Payload: req}
+			},
+		},
+	}
+}
+`))
+
+var serviceTestTemplate = template.Must(template.New("service_test").Parse(`package {{.Package}}
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/model"
+	"github.com/pb33f/ranch/service"
+	"github.com/stretchr/testify/assert"
+)
+
+// register{{.Type}}Service registers a new {{.Name}} service on the bus, as the server would.
+func register{{.Type}}Service(t *testing.T) {
+	service.ResetServiceRegistry()
+	cm := bus.GetBus().GetChannelManager()
+	if !cm.CheckChannelExists(service.LifecycleManagerChannelName) {
+		cm.CreateChannel(service.LifecycleManagerChannelName)
+	}
+	assert.NoError(t, service.GetServiceRegistry().RegisterService(New{{.Type}}Service(), {{.Type}}ServiceChannel))
+	t.Cleanup(func() { _ = service.GetServiceRegistry().UnregisterService({{.Type}}ServiceChannel) })
+}
+
+// request{{.Type}} sends a request to the service and returns its response.
+func request{{.Type}}(t *testing.T, request model.Request) *model.Response {
+	id := uuid.New()
+	request.Id = &id
+	handler, err := bus.GetBus().ListenStreamForDestination({{.Type}}ServiceChannel, &id)
+	if !assert.NoError(t, err) {
+		return nil
+	}
+	defer handler.Close()
+
+	responses := make(chan *model.Response, 1)
+	handler.Handle(func(msg *model.Message) {
+		responses <- msg.Payload.(*model.Response)
+	}, func(err error) {})
+	assert.NoError(t, bus.GetBus().SendRequestMessage({{.Type}}ServiceChannel, request, &id))
+
+	select {
+	case response := <-responses:
+		return response
+	case <-time.After(5 * time.Second):
+		t.Fatal("no response from {{.Type}}ServiceChannel")
+		return nil
+	}
+}
+
+func Test{{.Type}}Service_HandleServiceRequest(t *testing.T) {
+	register{{.Type}}Service(t)
+
+	response := request{{.Type}}(t, model.Request{RequestCommand: Get{{.Type}}Command, Payload: &{{.Type}}Request{Id: "1"}})
+	assert.True(t, response.Error)
+	assert.Equal(t, http.StatusNotFound, response.ErrorCode)
+
+	response = request{{.Type}}(t, model.Request{RequestCommand: Update{{.Type}}Command,
+		Payload: &{{.Type}}Request{Id: "1", Value: "moo"}})
+	assert.False(t, response.Error)
+
+	// fabric clients send JSON objects
+	response = request{{.Type}}(t, model.Request{RequestCommand: Get{{.Type}}Command,
+		Payload: map[string]interface{}{"id": "1"}})
+	assert.Equal(t, &{{.Type}}Response{Id: "1", Value: "moo"}, response.Payload)
+
+	response = request{{.Type}}(t, model.Request{RequestCommand: "moo"})
+	assert.True(t, response.Error)
+}
+
+func Test{{.Type}}Service_GetRESTBridgeConfig(t *testing.T) {
+	configs := New{{.Type}}Service().GetRESTBridgeConfig()
+	assert.Len(t, configs, 2)
+
+	r := httptest.NewRequest(http.MethodPut, "/rest/{{.Path}}/1", strings.NewReader(` + "`{\"value\":\"moo\"}`" + `))
+	r = mux.SetURLVars(r, map[string]string{"id": "1"})
+	request := configs[1].FabricRequestBuilder(httptest.NewRecorder(), r)
+	assert.Equal(t, Update{{.Type}}Command, request.RequestCommand)
+	assert.Equal(t, &{{.Type}}Request{Id: "1", Value: "moo"}, request.Payload)
+}
+`))
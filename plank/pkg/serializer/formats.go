@@ -0,0 +1,173 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package serializer
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v3"
+)
+
+// xmlRootElement is the element payloads encoding/xml cannot render, e.g. maps, are rendered in.
+const xmlRootElement = "response"
+
+func serializeJSON(payload interface{}) ([]byte, error) {
+	return json.Marshal(payload)
+}
+
+// serializeXML renders payloads with encoding/xml, honouring their xml tags. Payloads it cannot render, e.g.
+// maps, are rendered from their JSON representation instead: objects as elements named by their keys, arrays
+// as repeated item elements, in a response root element.
+func serializeXML(payload interface{}) ([]byte, error) {
+	if isStruct(payload) {
+		data, err := xml.Marshal(payload)
+		var unsupported *xml.UnsupportedTypeError
+		if !errors.As(err, &unsupported) {
+			return append([]byte(xml.Header), data...), err
+		}
+	}
+	value, err := jsonValue(payload)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	writeXMLElement(&b, xmlRootElement, value)
+	return b.Bytes(), nil
+}
+
+func writeXMLElement(b *bytes.Buffer, name string, value interface{}) {
+	if !isXMLName(name) {
+		b.WriteString(`<entry key="`)
+		_ = xml.EscapeText(b, []byte(name))
+		b.WriteString(`">`)
+		writeXMLContent(b, value)
+		b.WriteString("</entry>")
+		return
+	}
+	fmt.Fprintf(b, "<%s>", name)
+	writeXMLContent(b, value)
+	fmt.Fprintf(b, "</%s>", name)
+}
+
+func writeXMLContent(b *bytes.Buffer, value interface{}) {
+	switch v := value.(type) {
+	case nil:
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			writeXMLElement(b, key, v[key])
+		}
+	case []interface{}:
+		for _, item := range v {
+			writeXMLElement(b, "item", item)
+		}
+	default:
+		_ = xml.EscapeText(b, []byte(fmt.Sprint(v)))
+	}
+}
+
+// isXMLName tells whether a key can be used as the name of an element as it is.
+func isXMLName(name string) bool {
+	if name == "" || strings.HasPrefix(strings.ToLower(name), "xml") {
+		return false
+	}
+	for i, c := range name {
+		letter := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		if !letter && (i == 0 || !(c == '-' || c == '.' || (c >= '0' && c <= '9'))) {
+			return false
+		}
+	}
+	return true
+}
+
+// serializeYAML renders the JSON representation of payloads as YAML, so fields are named by their json tags
+// and keep their order.
+func serializeYAML(payload interface{}) ([]byte, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	var node yaml.Node
+	if err = yaml.Unmarshal(data, &node); err != nil {
+		return nil, err
+	}
+	clearStyle(&node)
+	return yaml.Marshal(&node)
+}
+
+// clearStyle drops the flow style and quotes the JSON a node was parsed from is written with.
+func clearStyle(node *yaml.Node) {
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!str" && node.Style == yaml.DoubleQuotedStyle {
+		node.Style = 0
+	} else if node.Kind != yaml.ScalarNode {
+		node.Style = 0
+	}
+	for _, child := range node.Content {
+		clearStyle(child)
+	}
+}
+
+func serializeProtobuf(payload interface{}) ([]byte, error) {
+	message, ok := payload.(proto.Message)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return proto.Marshal(message)
+}
+
+// serializeText renders strings, numbers, booleans, errors and payloads implementing fmt.Stringer or
+// encoding.TextMarshaler.
+func serializeText(payload interface{}) ([]byte, error) {
+	switch v := payload.(type) {
+	case string:
+		return []byte(v), nil
+	case encoding.TextMarshaler:
+		return v.MarshalText()
+	case fmt.Stringer:
+		return []byte(v.String()), nil
+	case error:
+		return []byte(v.Error()), nil
+	}
+	switch reflect.ValueOf(payload).Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint,
+		reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		return []byte(fmt.Sprint(payload)), nil
+	}
+	return nil, ErrUnsupported
+}
+
+// jsonValue returns the JSON representation of a payload as maps, slices and scalars.
+func jsonValue(payload interface{}) (interface{}, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	err = decoder.Decode(&value)
+	return value, err
+}
+
+func isStruct(payload interface{}) bool {
+	v := reflect.ValueOf(payload)
+	for v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	return v.Kind() == reflect.Struct
+}
@@ -0,0 +1,246 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+// Package serializer renders the payloads of service responses in the representation a client asks for with
+// its Accept header: JSON, XML, YAML, Protobuf or plain text, and any other registered.
+package serializer
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Media types of the built-in serializers.
+const (
+	MediaTypeJSON     = "application/json"
+	MediaTypeXML      = "application/xml"
+	MediaTypeYAML     = "application/yaml"
+	MediaTypeProtobuf = "application/x-protobuf"
+	MediaTypeText     = "text/plain"
+)
+
+var (
+	// ErrUnsupported is returned by serializers unable to render a payload, e.g. Protobuf for payloads that
+	// are not proto.Message. The next representation the client accepts is tried.
+	ErrUnsupported = errors.New("payload cannot be rendered in this representation")
+
+	// ErrNotAcceptable is returned when no representation the client accepts can render a payload.
+	ErrNotAcceptable = errors.New("no acceptable representation of the payload")
+)
+
+// Serializer renders payloads in a representation.
+type Serializer interface {
+	// Serialize renders payload, failing with ErrUnsupported if it cannot be rendered in this representation.
+	Serialize(payload interface{}) ([]byte, error)
+}
+
+// SerializerFunc is a function used as a Serializer.
+type SerializerFunc func(payload interface{}) ([]byte, error)
+
+func (f SerializerFunc) Serialize(payload interface{}) ([]byte, error) {
+	return f(payload)
+}
+
+// builtIn are the serializers of a new registry, in order of preference for wildcards, by media type.
+var builtIn = []struct {
+	mediaType  string
+	serializer Serializer
+}{
+	{MediaTypeJSON, SerializerFunc(serializeJSON)},
+	{MediaTypeXML, SerializerFunc(serializeXML)},
+	{"text/xml", SerializerFunc(serializeXML)},
+	{MediaTypeYAML, SerializerFunc(serializeYAML)},
+	{"application/x-yaml", SerializerFunc(serializeYAML)},
+	{"text/yaml", SerializerFunc(serializeYAML)},
+	{MediaTypeProtobuf, SerializerFunc(serializeProtobuf)},
+	{"application/protobuf", SerializerFunc(serializeProtobuf)},
+	{MediaTypeText, SerializerFunc(serializeText)},
+}
+
+// Registry holds the serializers of the representations offered, by media type.
+type Registry struct {
+	lock        sync.RWMutex
+	mediaTypes  []string // in order of preference for wildcards
+	serializers map[string]Serializer
+}
+
+// NewRegistry returns a registry of the built-in serializers of mediaTypes, in that order of preference, or
+// of every built-in serializer if none is given. Media types without a built-in serializer are ignored.
+func NewRegistry(mediaTypes ...string) *Registry {
+	r := &Registry{serializers: make(map[string]Serializer)}
+	if len(mediaTypes) == 0 {
+		for _, b := range builtIn {
+			r.Register(b.mediaType, b.serializer)
+		}
+	}
+	for _, mediaType := range mediaTypes {
+		for _, b := range builtIn {
+			if b.mediaType == normalize(mediaType) {
+				r.Register(b.mediaType, b.serializer)
+			}
+		}
+	}
+	return r
+}
+
+// Register adds the serializer of a media type, replacing the one registered already. Media types added are
+// the least preferred for wildcards.
+func (r *Registry) Register(mediaType string, serializer Serializer) {
+	mediaType = normalize(mediaType)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, exists := r.serializers[mediaType]; !exists {
+		r.mediaTypes = append(r.mediaTypes, mediaType)
+	}
+	r.serializers[mediaType] = serializer
+}
+
+// Unregister removes the serializer of a media type.
+func (r *Registry) Unregister(mediaType string) {
+	mediaType = normalize(mediaType)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.serializers, mediaType)
+	r.mediaTypes = slices.DeleteFunc(r.mediaTypes, func(m string) bool { return m == mediaType })
+}
+
+// MediaTypes returns the media types offered, in order of preference for wildcards.
+func (r *Registry) MediaTypes() []string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return slices.Clone(r.mediaTypes)
+}
+
+// Serialize renders payload in the representation of accept, the value of an Accept header, that is most
+// preferred and able to render it, and returns its media type. It fails with ErrNotAcceptable if none is.
+func (r *Registry) Serialize(accept string, payload interface{}) (string, []byte, error) {
+	for _, mediaType := range r.negotiate(accept) {
+		r.lock.RLock()
+		serializer := r.serializers[mediaType]
+		r.lock.RUnlock()
+		if serializer == nil {
+			continue
+		}
+		data, err := serializer.Serialize(payload)
+		if errors.Is(err, ErrUnsupported) {
+			continue
+		}
+		if err != nil {
+			return mediaType, nil, fmt.Errorf("cannot render payload as %s: %w", mediaType, err)
+		}
+		return mediaType, data, nil
+	}
+	return "", nil, ErrNotAcceptable
+}
+
+// negotiate returns the media types offered that accept matches, most preferred first. Ranges are preferred
+// by quality, then by how specific they are, then by their order in accept.
+func (r *Registry) negotiate(accept string) []string {
+	ranges := parseAccept(accept)
+	offered := r.MediaTypes()
+	var matched []string
+	excluded := make(map[string]bool)
+	for _, ar := range ranges {
+		if ar.q > 0 {
+			continue
+		}
+		for _, mediaType := range offered {
+			if ar.specificity == 2 && ar.matches(mediaType) {
+				excluded[mediaType] = true
+			}
+		}
+	}
+	for _, ar := range ranges {
+		if ar.q <= 0 {
+			continue
+		}
+		for _, mediaType := range offered {
+			if ar.matches(mediaType) && !excluded[mediaType] && !slices.Contains(matched, mediaType) {
+				matched = append(matched, mediaType)
+			}
+		}
+	}
+	return matched
+}
+
+// AcceptsAnything returns whether accept, the value of an Accept header, accepts every representation with
+// "*/*", as the Accept headers of browsers do. The other types such headers list are those a browser
+// renders, not a preference among the representations of an API.
+func AcceptsAnything(accept string) bool {
+	return slices.ContainsFunc(parseAccept(accept), func(ar acceptRange) bool {
+		return ar.specificity == 0 && ar.q > 0
+	})
+}
+
+// acceptRange is a media range of an Accept header, e.g. "application/*;q=0.5".
+type acceptRange struct {
+	mediaType   string
+	q           float64
+	specificity int // 0 for */*, 1 for type/*, 2 for type/subtype
+}
+
+func (ar acceptRange) matches(mediaType string) bool {
+	switch ar.specificity {
+	case 0:
+		return true
+	case 1:
+		return strings.HasPrefix(mediaType, strings.TrimSuffix(ar.mediaType, "*"))
+	}
+	return ar.mediaType == mediaType
+}
+
+// parseAccept parses the media ranges of an Accept header, sorted by preference.
+func parseAccept(accept string) []acceptRange {
+	var ranges []acceptRange
+	for _, field := range strings.Split(accept, ",") {
+		params := strings.Split(field, ";")
+		ar := acceptRange{mediaType: normalize(params[0]), q: 1}
+		if ar.mediaType == "" || !strings.Contains(ar.mediaType, "/") {
+			continue
+		}
+		for _, param := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(name, "q") {
+				if q, err := strconv.ParseFloat(value, 64); err == nil {
+					ar.q = q
+				}
+			}
+		}
+		switch {
+		case ar.mediaType == "*/*":
+			ar.specificity = 0
+		case strings.HasSuffix(ar.mediaType, "/*"):
+			ar.specificity = 1
+		default:
+			ar.specificity = 2
+		}
+		ranges = append(ranges, ar)
+	}
+	slices.SortStableFunc(ranges, func(a, b acceptRange) int {
+		if a.q != b.q {
+			if a.q > b.q {
+				return -1
+			}
+			return 1
+		}
+		return b.specificity - a.specificity
+	})
+	return ranges
+}
+
+// ContentType returns the Content-Type header of a media type, with the charset of textual representations.
+func ContentType(mediaType string) string {
+	if strings.HasPrefix(mediaType, "text/") {
+		return mediaType + "; charset=utf-8"
+	}
+	return mediaType
+}
+
+func normalize(mediaType string) string {
+	mediaType, _, _ = strings.Cut(mediaType, ";")
+	return strings.ToLower(strings.TrimSpace(mediaType))
+}
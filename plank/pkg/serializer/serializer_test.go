@@ -0,0 +1,110 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package serializer
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type cow struct {
+	Name  string   `json:"name" xml:"name,attr"`
+	Herd  string   `json:"herd,omitempty" xml:"herd"`
+	Moos  int      `json:"moos" xml:"moos"`
+	Spots []string `json:"spots" xml:"spot"`
+}
+
+func TestRegistry_Negotiate(t *testing.T) {
+	r := NewRegistry()
+	negotiate := func(accept string) string {
+		mediaType, _, err := r.Serialize(accept, map[string]int{"moos": 3})
+		if errors.Is(err, ErrNotAcceptable) {
+			return "none"
+		}
+		require.NoError(t, err)
+		return mediaType
+	}
+
+	assert.Equal(t, MediaTypeJSON, negotiate("*/*"))
+	assert.Equal(t, MediaTypeYAML, negotiate("application/yaml"))
+	assert.Equal(t, MediaTypeXML, negotiate("text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"))
+	assert.Equal(t, "text/xml", negotiate("text/*"))
+	assert.Equal(t, MediaTypeYAML, negotiate("application/json;q=0.5, application/yaml"))
+	assert.Equal(t, MediaTypeXML, negotiate("application/json;q=0, application/*"))
+	assert.Equal(t, "none", negotiate("image/png"))
+
+	// representations unable to render a payload are skipped
+	assert.Equal(t, MediaTypeJSON, negotiate("application/x-protobuf, application/json;q=0.1"))
+	assert.Equal(t, "none", negotiate("text/plain"))
+
+	// serializers are registered and unregistered
+	r.Register("application/vnd.cow+json", SerializerFunc(func(payload interface{}) ([]byte, error) {
+		return []byte("moo"), nil
+	}))
+	assert.Equal(t, "application/vnd.cow+json", negotiate("application/vnd.cow+json"))
+	r.Unregister(MediaTypeJSON)
+	assert.Equal(t, MediaTypeXML, negotiate("*/*"))
+
+	r = NewRegistry(MediaTypeYAML, "text/plain; charset=utf-8", "image/png")
+	assert.Equal(t, []string{MediaTypeYAML, MediaTypeText}, r.MediaTypes())
+	assert.Equal(t, "text/plain; charset=utf-8", ContentType(MediaTypeText))
+	assert.Equal(t, MediaTypeYAML, ContentType(MediaTypeYAML))
+}
+
+func TestAcceptsAnything(t *testing.T) {
+	assert.True(t, AcceptsAnything("*/*"))
+	assert.True(t, AcceptsAnything("text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"))
+	assert.False(t, AcceptsAnything("application/yaml, application/*"))
+	assert.False(t, AcceptsAnything("application/json, */*;q=0"))
+	assert.False(t, AcceptsAnything(""))
+}
+
+func TestSerializers(t *testing.T) {
+	daisy := &cow{Name: "daisy", Moos: 3, Spots: []string{"left", "right"}}
+
+	data, err := serializeJSON(daisy)
+	require.NoError(t, err)
+	assert.Equal(t, `{"name":"daisy","moos":3,"spots":["left","right"]}`, string(data))
+
+	// structs honour their xml tags, other payloads are rendered from JSON
+	data, err = serializeXML(daisy)
+	require.NoError(t, err)
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>`+"\n"+
+		`<cow name="daisy"><herd></herd><moos>3</moos><spot>left</spot><spot>right</spot></cow>`, string(data))
+	data, err = serializeXML(map[string]interface{}{"herd": []string{"daisy", "bessie"}, "1st": "daisy & co",
+		"count": 2})
+	require.NoError(t, err)
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>`+"\n"+`<response><entry key="1st">daisy &amp; co</entry>`+
+		`<count>2</count><herd><item>daisy</item><item>bessie</item></herd></response>`, string(data))
+
+	// YAML names fields by their json tags, in order
+	data, err = serializeYAML(daisy)
+	require.NoError(t, err)
+	assert.Equal(t, "name: daisy\nmoos: 3\nspots:\n    - left\n    - right\n", string(data))
+	data, err = serializeYAML(map[string]string{"answer": "true"})
+	require.NoError(t, err)
+	assert.Equal(t, "answer: \"true\"\n", string(data))
+
+	data, err = serializeProtobuf(wrapperspb.String("moo"))
+	require.NoError(t, err)
+	var message wrapperspb.StringValue
+	require.NoError(t, proto.Unmarshal(data, &message))
+	assert.Equal(t, "moo", message.Value)
+	_, err = serializeProtobuf(daisy)
+	assert.ErrorIs(t, err, ErrUnsupported)
+
+	for payload, text := range map[interface{}]string{"moo": "moo", 42: "42", 4.2: "4.2", true: "true",
+		errors.New("stampede"): "stampede"} {
+		data, err = serializeText(payload)
+		require.NoError(t, err)
+		assert.Equal(t, text, string(data))
+	}
+	_, err = serializeText(daisy)
+	assert.ErrorIs(t, err, ErrUnsupported)
+}
@@ -0,0 +1,240 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/clock"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	AccessLogSinkStdout  = "stdout"  // records written to standard output
+	AccessLogSinkStderr  = "stderr"  // records written to standard error
+	AccessLogSinkFile    = "file"    // records written to a file, rotated once it grows too big
+	AccessLogSinkChannel = "channel" // records sent on a bus channel, as *AccessLogEntry
+
+	AccessLogFormatJSON = "json"
+	AccessLogFormatText = "text"
+
+	defaultAccessLogTraceHeader = "traceparent"
+	defaultAccessLogMaxSizeMB   = 100
+)
+
+// AccessLogEntry is an HTTP request served, as recorded by the access log.
+type AccessLogEntry struct {
+	Time      time.Time     `json:"time"`
+	Method    string        `json:"method"`
+	Path      string        `json:"path"`
+	Proto     string        `json:"proto"`
+	Status    int           `json:"status"`
+	Latency   time.Duration `json:"latency_ns"`
+	Bytes     int64         `json:"bytes"` // response body bytes written
+	Remote    string        `json:"remote"`
+	Principal string        `json:"principal,omitempty"`
+	TraceId   string        `json:"trace_id,omitempty"`
+	RequestId string        `json:"request_id,omitempty"` // set if request logging is configured
+	UserAgent string        `json:"user_agent,omitempty"`
+	Referer   string        `json:"referer,omitempty"`
+}
+
+// attrs returns the entry as the attributes of a slog record.
+func (e *AccessLogEntry) attrs() []slog.Attr {
+	attrs := []slog.Attr{
+		slog.String("method", e.Method),
+		slog.String("path", e.Path),
+		slog.String("proto", e.Proto),
+		slog.Int("status", e.Status),
+		slog.Duration("latency", e.Latency),
+		slog.Int64("bytes", e.Bytes),
+		slog.String("remote", e.Remote),
+	}
+	for _, attr := range []slog.Attr{
+		slog.String("principal", e.Principal),
+		slog.String("trace_id", e.TraceId),
+		slog.String("request_id", e.RequestId),
+		slog.String("user_agent", e.UserAgent),
+		slog.String("referer", e.Referer),
+	} {
+		if attr.Value.String() != "" {
+			attrs = append(attrs, attr)
+		}
+	}
+	return attrs
+}
+
+// accessLogState is the sinks access log records are written to, and the files to close on shutdown.
+type accessLogState struct {
+	loggers  []*slog.Logger
+	channels []string
+	closers  []io.Closer
+	eventbus bus.EventBus
+}
+
+// initAccessLog opens the sinks of the access log, if configured.
+func (ps *platformServer) initAccessLog() {
+	cfg := ps.serverConfig.AccessLog
+	if cfg == nil {
+		return
+	}
+	state := &accessLogState{eventbus: ps.eventbus}
+	for _, sink := range cfg.Sinks {
+		if err := ps.openAccessLogSink(state, sink); err != nil {
+			ps.serverConfig.Logger.Error(wrapError(errServerInit, err).Error())
+		}
+	}
+	if cfg.Handler != nil {
+		state.loggers = append(state.loggers, slog.New(cfg.Handler))
+	}
+	if len(state.loggers) == 0 && len(state.channels) == 0 {
+		return
+	}
+	ps.accessLog = state
+}
+
+// openAccessLogSink adds a sink to the access log.
+func (ps *platformServer) openAccessLogSink(state *accessLogState, sink *AccessLogSink) error {
+	if sink == nil {
+		return nil
+	}
+	var out io.Writer
+	switch sink.Type {
+	case AccessLogSinkStdout:
+		out = os.Stdout
+	case AccessLogSinkStderr:
+		out = os.Stderr
+	case AccessLogSinkFile:
+		if sink.Path == "" {
+			return fmt.Errorf("access log file sink has no path")
+		}
+		path := sink.Path
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(ps.serverConfig.RootDir, path)
+		}
+		maxSize := sink.MaxSizeMB
+		if maxSize <= 0 {
+			maxSize = defaultAccessLogMaxSizeMB
+		}
+		file := &lumberjack.Logger{
+			Filename:   path,
+			MaxSize:    maxSize,
+			MaxBackups: sink.MaxBackups,
+			MaxAge:     sink.MaxAgeDays,
+			Compress:   sink.Compress,
+		}
+		state.closers = append(state.closers, file)
+		out = file
+	case AccessLogSinkChannel:
+		channel := sink.Channel
+		if channel == "" {
+			channel = RANCH_ACCESS_LOG_CHANNEL
+		}
+		ps.eventbus.GetChannelManager().CreateChannel(channel)
+		state.channels = append(state.channels, channel)
+		return nil
+	default:
+		return fmt.Errorf("unknown access log sink '%s'", sink.Type)
+	}
+
+	switch sink.Format {
+	case "", AccessLogFormatJSON:
+		state.loggers = append(state.loggers, slog.New(slog.NewJSONHandler(out, nil)))
+	case AccessLogFormatText:
+		state.loggers = append(state.loggers, slog.New(slog.NewTextHandler(out, nil)))
+	default:
+		return fmt.Errorf("unknown access log format '%s'", sink.Format)
+	}
+	return nil
+}
+
+// accessLogMiddleware records every HTTP request served to the sinks of the access log, once answered.
+func (ps *platformServer) accessLogMiddleware(next http.Handler) http.Handler {
+	cfg := ps.serverConfig.AccessLog
+	state := ps.accessLog
+	traceHeader := cfg.TraceHeader
+	if traceHeader == "" {
+		traceHeader = defaultAccessLogTraceHeader
+	}
+	requestIdHeader := ""
+	if rl := ps.serverConfig.RequestLogging; rl != nil {
+		requestIdHeader = rl.RequestIdHeader
+		if requestIdHeader == "" {
+			requestIdHeader = defaultRequestIdHeader
+		}
+	}
+	principal := cfg.Principal
+	if principal == nil {
+		principal = ps.httpPrincipal
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, prefix := range cfg.ExcludePaths {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		started := clock.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		entry := &AccessLogEntry{
+			Time:      started.UTC(),
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			Proto:     r.Proto,
+			Status:    recorder.status,
+			Latency:   clock.Since(started),
+			Bytes:     recorder.bytes,
+			Remote:    r.RemoteAddr,
+			Principal: principal(r),
+			TraceId:   traceId(r.Header.Get(traceHeader)),
+			UserAgent: r.UserAgent(),
+			Referer:   r.Referer(),
+		}
+		if requestIdHeader != "" {
+			entry.RequestId = w.Header().Get(requestIdHeader)
+		}
+		state.write(r.Context(), entry)
+	})
+}
+
+// write records an entry to every sink.
+func (s *accessLogState) write(ctx context.Context, entry *AccessLogEntry) {
+	attrs := entry.attrs()
+	for _, logger := range s.loggers {
+		logger.LogAttrs(ctx, slog.LevelInfo, "access", attrs...)
+	}
+	for _, channel := range s.channels {
+		_ = s.eventbus.SendResponseMessage(channel, entry, nil)
+	}
+}
+
+// traceId returns the trace id of a trace header: the trace-id field of a W3C traceparent header
+// (version-traceid-parentid-flags), the whole value of others such as X-B3-TraceId.
+func traceId(value string) string {
+	if parts := strings.Split(value, "-"); len(parts) == 4 && len(parts[1]) == 32 {
+		return parts[1]
+	}
+	return value
+}
+
+// stopAccessLog closes the files of the access log.
+func (ps *platformServer) stopAccessLog() {
+	if ps.accessLog == nil {
+		return
+	}
+	for _, closer := range ps.accessLog.closers {
+		_ = closer.Close()
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/model"
+	"github.com/pb33f/ranch/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccessLog_Sinks(t *testing.T) {
+	bus.ResetBus()
+	service.ResetServiceRegistry()
+	dir := t.TempDir()
+	var records bytes.Buffer
+	config := GetBasicTestServerConfig(dir, "stdout", "stdout", "stderr", GetTestPort(), true)
+	config.RequestLogging = &RequestLoggingConfig{}
+	config.AccessLog = &AccessLogConfig{
+		Sinks: []*AccessLogSink{
+			{Type: AccessLogSinkFile, Path: "logs/access.log", MaxBackups: 2},
+			{Type: AccessLogSinkChannel},
+			{Type: "carrier-pigeon"},
+		},
+		ExcludePaths: []string{"/health"},
+		Principal:    func(r *http.Request) string { return r.Header.Get("X-Rancher") },
+		Handler:      slog.NewJSONHandler(&records, nil),
+	}
+	ps := NewPlatformServer(config).(*platformServer)
+	require.NotNil(t, ps.accessLog)
+	ps.router.HandleFunc("/cows", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte("moo"))
+	})
+	ps.router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {})
+	ps.loadGlobalHttpHandler(ps.router)
+
+	entries := make(chan *AccessLogEntry, 2)
+	handler, err := ps.eventbus.ListenStream(RANCH_ACCESS_LOG_CHANNEL)
+	require.NoError(t, err)
+	defer handler.Close()
+	handler.Handle(func(msg *model.Message) {
+		entries <- msg.Payload.(*AccessLogEntry)
+	}, func(err error) {})
+
+	serve := func(path string) {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.Header.Set("X-Rancher", "dolly")
+		req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		req.Header.Set("User-Agent", "lasso/1.0")
+		ps.HttpServer.Handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	serve("/health")
+	serve("/cows")
+
+	// every sink gets the request, probes are left out
+	var entry *AccessLogEntry
+	select {
+	case entry = <-entries:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no access log entry on the channel")
+	}
+	assert.Equal(t, http.MethodPost, entry.Method)
+	assert.Equal(t, "/cows", entry.Path)
+	assert.Equal(t, http.StatusCreated, entry.Status)
+	assert.Equal(t, int64(3), entry.Bytes)
+	assert.Equal(t, "dolly", entry.Principal)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", entry.TraceId)
+	assert.NotEmpty(t, entry.RequestId)
+	assert.Equal(t, "lasso/1.0", entry.UserAgent)
+
+	var record map[string]any
+	require.NoError(t, json.Unmarshal(records.Bytes(), &record))
+	assert.Equal(t, "access", record["msg"])
+	assert.Equal(t, "/cows", record["path"])
+	assert.Equal(t, float64(http.StatusCreated), record["status"])
+	assert.Equal(t, entry.RequestId, record["request_id"])
+	assert.Contains(t, record, "latency")
+
+	ps.stopAccessLog()
+	written, err := os.ReadFile(filepath.Join(dir, "logs", "access.log"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, bytes.Count(written, []byte("\n")))
+	assert.Contains(t, string(written), `"trace_id":"4bf92f3577b34da6a3ce929d0e0e4736"`)
+}
+
+func TestAccessLog_Config(t *testing.T) {
+	assert.Nil(t, accessLogConfig("null"))
+	assert.Equal(t, AccessLogSinkStderr, accessLogConfig("stderr").Sinks[0].Type)
+	file := accessLogConfig("/var/log/ranch/access.log").Sinks[0]
+	assert.Equal(t, AccessLogSinkFile, file.Type)
+	assert.Equal(t, "/var/log/ranch/access.log", file.Path)
+
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c",
+		traceId("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"))
+	assert.Equal(t, "80f198ee56343ba864fe8b2a57d3eff7", traceId("80f198ee56343ba864fe8b2a57d3eff7"))
+}
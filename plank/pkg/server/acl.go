@@ -0,0 +1,180 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/clock"
+	"github.com/pb33f/ranch/plank/pkg/acl"
+	"github.com/pb33f/ranch/stompserver"
+)
+
+const defaultAclReloadInterval = 10 * time.Second
+
+// initAcl reads the access control file, if configured. A file that cannot be read denies everything until
+// it is fixed.
+func (ps *platformServer) initAcl() {
+	cfg := ps.serverConfig.ACL
+	if cfg == nil {
+		return
+	}
+	file := cfg.File
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(ps.serverConfig.RootDir, file)
+	}
+	var err error
+	if ps.acl, err = acl.NewFromFile(file, ps.aclRoles); err != nil {
+		ps.serverConfig.Logger.Error(wrapError(errServerInit, err).Error())
+		ps.serverConfig.Logger.Warn("[ranch] access control file cannot be read, denying everything", "file", file)
+		return
+	}
+	ps.serverConfig.Logger.Info("[ranch] access control file enforced", "file", file)
+}
+
+// aclRoles returns the roles of a principal besides those in the access control file: those returned by
+// the configured resolver and the groups the trusted proxy sent for it.
+func (ps *platformServer) aclRoles(principal string) []string {
+	var roles []string
+	if ps.serverConfig.ACL.Roles != nil {
+		roles = ps.serverConfig.ACL.Roles(principal)
+	}
+	if ps.trustedHeaders != nil {
+		roles = append(slices.Clone(roles), ps.trustedHeaders.roles(principal)...)
+	}
+	return roles
+}
+
+// httpPrincipal returns the principal of a REST request, empty if anonymous or not resolved. The user
+// sent by the trusted proxy comes first.
+func (ps *platformServer) httpPrincipal(r *http.Request) string {
+	if principal := stompserver.PrincipalFromContext(r.Context()); principal != "" && ps.trustedHeaders != nil {
+		return principal
+	}
+	if cfg := ps.serverConfig.ACL; cfg != nil && cfg.HttpPrincipal != nil {
+		return cfg.HttpPrincipal(r)
+	}
+	if cfg := ps.serverConfig.StoreAccess; cfg != nil && cfg.HttpPrincipal != nil {
+		return cfg.HttpPrincipal(r)
+	}
+	return ""
+}
+
+// aclMiddleware refuses REST requests to routes the access control file does not grant the principal.
+func (ps *platformServer) aclMiddleware(next http.Handler) http.Handler {
+	return ps.acl.Middleware(ps.httpPrincipal)(next)
+}
+
+// withAclAuthorization returns the Authorize callback of the fabric endpoint, checking the access control
+// file after the configured callback. Subscriptions read a channel and requests write it, store
+// destinations are left to the store access control. Temporary reply destinations are not channels, they
+// only carry the responses to the requests of their session, which the file was checked for.
+func (ps *platformServer) withAclAuthorization(config *bus.EndpointConfig) func(string, string, string) error {
+	authorize := config.Authorize
+	prefixes := []string{config.AppRequestQueuePrefix, config.UserQueuePrefix, config.AppRequestPrefix,
+		config.TopicPrefix, config.QueuePrefix}
+	return func(command string, destination string, principal string) error {
+		if authorize != nil {
+			if err := authorize(command, destination, principal); err != nil {
+				return err
+			}
+		}
+		if config.TempQueuePrefix != "" && strings.HasPrefix(destination, withTrailingSlash(config.TempQueuePrefix)) {
+			return nil
+		}
+		channel := aclChannel(prefixes, destination)
+		if strings.HasPrefix(channel, bus.GALACTIC_STORE_DESTINATION_PREFIX) {
+			return nil
+		}
+		allowed := ps.acl.CanReadChannel(channel, principal)
+		if command == frame.SEND {
+			allowed = ps.acl.CanWriteChannel(channel, principal)
+		}
+		if !allowed {
+			return fmt.Errorf("access to channel '%s' denied", channel)
+		}
+		return nil
+	}
+}
+
+// aclChannel returns the channel of a destination, without the longest of the prefixes it starts with.
+func aclChannel(prefixes []string, destination string) string {
+	channel := destination
+	for _, prefix := range prefixes {
+		if prefix == "" {
+			continue
+		}
+		prefix = withTrailingSlash(prefix)
+		if trimmed, ok := strings.CutPrefix(destination, prefix); ok && len(trimmed) < len(channel) {
+			channel = trimmed
+		}
+	}
+	return channel
+}
+
+// aclStoreAccess decides access to the stores without a StoreAccess rule with the access control file.
+func (ps *platformServer) aclStoreAccess(storeName string, principal string, write bool) bool {
+	if write {
+		return ps.acl.CanWriteStore(storeName, principal)
+	}
+	return ps.acl.CanReadStore(storeName, principal)
+}
+
+// startAclReloads reads the access control file again whenever it changes, until the server stops.
+func (ps *platformServer) startAclReloads() {
+	if ps.acl == nil || ps.serverConfig.ACL.ReloadIntervalSeconds < 0 {
+		return
+	}
+	interval := defaultAclReloadInterval
+	if ps.serverConfig.ACL.ReloadIntervalSeconds > 0 {
+		interval = time.Duration(ps.serverConfig.ACL.ReloadIntervalSeconds) * time.Second
+	}
+	stop := make(chan struct{})
+	ps.lock.Lock()
+	ps.aclStop = stop
+	ps.lock.Unlock()
+
+	go func() {
+		ticker := clock.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C():
+				ps.reloadAcl()
+			}
+		}
+	}()
+}
+
+// reloadAcl reads the access control file again if it changed, keeping the policy in force if it is invalid.
+func (ps *platformServer) reloadAcl() {
+	reloaded, err := ps.acl.Reload()
+	if err != nil {
+		ps.serverConfig.Logger.Error("[ranch] access control file not reloaded", "error", err.Error())
+		return
+	}
+	if reloaded {
+		ps.serverConfig.Logger.Info("[ranch] access control file reloaded")
+	}
+}
+
+// stopAclReloads stops checking the access control file for changes.
+func (ps *platformServer) stopAclReloads() {
+	ps.lock.Lock()
+	stop := ps.aclStop
+	ps.aclStop = nil
+	ps.lock.Unlock()
+	if stop != nil {
+		close(stop)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/pb33f/ranch/bus"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestAcl(t *testing.T, policy string) *platformServer {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "acl.yaml"), []byte(policy), 0o600))
+	ps := &platformServer{
+		eventbus: bus.NewEventBusInstance(),
+		serverConfig: &PlatformServerConfig{
+			Logger:  slog.Default(),
+			RootDir: dir,
+			ACL: &AclConfig{
+				File:          "acl.yaml",
+				HttpPrincipal: func(r *http.Request) string { return r.Header.Get("X-User") },
+			},
+		},
+	}
+	ps.initAcl()
+	return ps
+}
+
+func TestPlatformServer_AclRoutes(t *testing.T) {
+	ps := newTestAcl(t, "principals: {alice: [admin]}\nroutes:\n  - path: /admin/*\n    roles: [admin]\n")
+	handler := ps.aclMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func(path, user string) int {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("X-User", user)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusForbidden, serve("/admin/stores", "bob"))
+	assert.Equal(t, http.StatusOK, serve("/admin/stores", "alice"))
+	assert.Equal(t, http.StatusOK, serve("/api/cows", ""))
+}
+
+func TestPlatformServer_AclAuthorization(t *testing.T) {
+	ps := newTestAcl(t, "default: deny\nprincipals: {alice: [admin]}\nchannels:\n"+
+		"  - channel: cows\n    read: [\"*\"]\n    write: [admin]\n")
+	authorize := ps.withAclAuthorization(&bus.EndpointConfig{
+		TopicPrefix: "/topic/", AppRequestPrefix: "/pub/", AppRequestQueuePrefix: "/pub/queue/",
+		UserQueuePrefix: "/user/queue/", QueuePrefix: "/queue/", TempQueuePrefix: "/temp-queue",
+		Authorize: func(command string, destination string, principal string) error {
+			if principal == "mallory" {
+				return assert.AnError
+			}
+			return nil
+		},
+	})
+
+	assert.NoError(t, authorize(frame.SUBSCRIBE, "/topic/cows", ""))
+	assert.NoError(t, authorize(frame.SUBSCRIBE, "/user/queue/cows", "bob"))
+	assert.Error(t, authorize(frame.SEND, "/pub/cows", "bob"))
+	assert.NoError(t, authorize(frame.SEND, "/pub/queue/cows", "alice"))
+	assert.Error(t, authorize(frame.SEND, "/queue/cows", "bob"), "sending to a queue writes its channel")
+	assert.NoError(t, authorize(frame.SUBSCRIBE, "/queue/cows", "bob"))
+	assert.NoError(t, authorize(frame.SUBSCRIBE, "/temp-queue/sheep", "bob"), "replies were checked as requests")
+	assert.Error(t, authorize(frame.SUBSCRIBE, "/topic/sheep", "alice"))
+	assert.Error(t, authorize(frame.SUBSCRIBE, "/topic/cows", "mallory"), "the configured callback still decides")
+
+	// store destinations are left to the store access control, which asks the file about stores without a rule
+	assert.NoError(t, authorize(frame.SUBSCRIBE, "/topic/"+bus.GALACTIC_STORE_DESTINATION_PREFIX+"prices", ""))
+	ps.startStoreAccess()
+	defer ps.stopStoreAccess()
+	assert.False(t, ps.eventbus.GetStoreManager().GetAccessControl().CanRead("prices", "alice"))
+}
+
+func TestPlatformServer_AclUnreadable(t *testing.T) {
+	ps := &platformServer{serverConfig: &PlatformServerConfig{
+		Logger: slog.Default(),
+		ACL:    &AclConfig{File: filepath.Join(t.TempDir(), "missing.yaml")},
+	}}
+	ps.initAcl()
+	assert.False(t, ps.acl.CanAccessRoute(http.MethodGet, "/", ""))
+}
@@ -0,0 +1,120 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const (
+	// AcmeChallengeTLSALPN01 answers the challenges of the ACME CA in TLS handshakes on the HTTPS port.
+	AcmeChallengeTLSALPN01 = "tls-alpn-01"
+
+	// AcmeChallengeHTTP01 answers the challenges of the ACME CA over HTTP on port 80.
+	AcmeChallengeHTTP01 = "http-01"
+
+	// defaultAcmeHttpPort is the port HTTP-01 challenges are answered on, unless configured.
+	defaultAcmeHttpPort = 80
+)
+
+// acmeState is the manager obtaining and renewing certificates, and the server answering HTTP-01 challenges.
+type acmeState struct {
+	manager         *autocert.Manager
+	challengeServer *http.Server // answers HTTP-01 challenges, nil for TLS-ALPN-01
+}
+
+// initAcme has the HTTPS server get its certificates from the ACME CA, if configured. It runs once the
+// HTTP/2 settings are applied, which replace the protocols the server negotiates.
+func (ps *platformServer) initAcme() {
+	if ps.serverConfig.TLSCertConfig == nil || ps.serverConfig.TLSCertConfig.ACME == nil {
+		return
+	}
+	cfg := ps.serverConfig.TLSCertConfig.ACME
+	var err error
+	switch {
+	case len(cfg.Domains) == 0:
+		err = errors.New("ACME requires the domains to obtain certificates for")
+	case cfg.CacheDir == "":
+		err = errors.New("ACME requires a cache directory, for certificates to survive restarts")
+	case cfg.Challenge != "" && cfg.Challenge != AcmeChallengeTLSALPN01 && cfg.Challenge != AcmeChallengeHTTP01:
+		err = fmt.Errorf("unknown ACME challenge %q, use %s or %s", cfg.Challenge, AcmeChallengeTLSALPN01,
+			AcmeChallengeHTTP01)
+	}
+	if err != nil {
+		ps.serverConfig.Logger.Error(wrapError(errServerInit, err).Error())
+		return
+	}
+
+	manager := &autocert.Manager{
+		Prompt:      autocert.AcceptTOS,
+		Cache:       autocert.DirCache(cfg.CacheDir),
+		HostPolicy:  autocert.HostWhitelist(cfg.Domains...),
+		Email:       cfg.Email,
+		RenewBefore: time.Duration(cfg.RenewBeforeDays) * 24 * time.Hour,
+	}
+	if cfg.DirectoryURL != "" {
+		manager.Client = &acme.Client{DirectoryURL: cfg.DirectoryURL}
+	}
+	if ps.HttpServer.TLSConfig == nil {
+		ps.HttpServer.TLSConfig = &tls.Config{}
+	}
+	ps.HttpServer.TLSConfig.GetCertificate = manager.GetCertificate
+
+	state := &acmeState{manager: manager}
+	if cfg.Challenge == AcmeChallengeHTTP01 {
+		port := cfg.HTTPPort
+		if port == 0 {
+			port = defaultAcmeHttpPort
+		}
+		// other requests are redirected to HTTPS
+		state.challengeServer = &http.Server{
+			Addr:              fmt.Sprintf(":%d", port),
+			Handler:           manager.HTTPHandler(nil),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+	} else if !slices.Contains(ps.HttpServer.TLSConfig.NextProtos, acme.ALPNProto) {
+		ps.HttpServer.TLSConfig.NextProtos = append(ps.HttpServer.TLSConfig.NextProtos, acme.ALPNProto)
+	}
+	ps.acme = state
+}
+
+// startAcme starts answering HTTP-01 challenges, if the ACME CA verifies domains with them.
+func (ps *platformServer) startAcme() {
+	if ps.acme == nil || ps.acme.challengeServer == nil {
+		return
+	}
+	srv := ps.acme.challengeServer
+	listener, err := ps.listen("acme", srv.Addr)
+	if err != nil {
+		ps.serverConfig.Logger.Error(wrapError(errServerInit,
+			fmt.Errorf("unable to answer ACME challenges: %w", err)).Error())
+		return
+	}
+	ps.serverConfig.Logger.Info("[ranch] answering ACME challenges, and pointing strays to HTTPS",
+		"address", listener.Addr().String(), "domains", ps.serverConfig.TLSCertConfig.ACME.Domains)
+	go func() {
+		if err := srv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			ps.serverConfig.Logger.Error(wrapError(errServerInit, err).Error())
+		}
+	}()
+}
+
+// stopAcme stops answering HTTP-01 challenges, until ctx is done.
+func (ps *platformServer) stopAcme(ctx context.Context) {
+	if ps.acme == nil || ps.acme.challengeServer == nil {
+		return
+	}
+	if err := ps.acme.challengeServer.Shutdown(ctx); err != nil {
+		ps.serverConfig.Logger.Error(err.Error())
+	}
+}
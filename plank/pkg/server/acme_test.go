@@ -0,0 +1,137 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/acme"
+)
+
+// testCertificatePEM returns a self-signed certificate for domain and its key.
+func testCertificatePEM(t *testing.T, domain string) (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+// cacheAcmeCertificate writes a certificate for domain to an ACME cache directory, as if the CA issued it.
+func cacheAcmeCertificate(t *testing.T, dir, domain string) {
+	certPEM, keyPEM := testCertificatePEM(t, domain)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, domain), append(keyPEM, certPEM...), 0600))
+}
+
+func TestAcme_TLSALPN01(t *testing.T) {
+	cacheDir := t.TempDir()
+	cacheAcmeCertificate(t, cacheDir, "ranch.example")
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", GetTestPort(), true)
+	config.TLSCertConfig = &TLSCertConfig{ACME: &ACMEConfig{Domains: []string{"ranch.example"}, CacheDir: cacheDir,
+		RenewBeforeDays: 14}}
+	ps := protocolServer(config)
+	require.NotNil(t, ps.acme)
+	assert.Nil(t, ps.acme.challengeServer)
+	assert.Equal(t, 14*24*time.Hour, ps.acme.manager.RenewBefore)
+	assert.Contains(t, ps.HttpServer.TLSConfig.NextProtos, acme.ALPNProto)
+
+	srv := httptest.NewUnstartedServer(ps.HttpServer.Handler)
+	srv.TLS = ps.HttpServer.TLSConfig.Clone()
+	srv.StartTLS()
+	defer srv.Close()
+
+	// the certificate of the domain comes from the cache, the CA is not asked for it again
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{ServerName: "ranch.example", InsecureSkipVerify: true}}}
+	rsp, err := client.Get(srv.URL + "/proto")
+	require.NoError(t, err)
+	_ = rsp.Body.Close()
+	require.NotNil(t, rsp.TLS)
+	assert.Equal(t, []string{"ranch.example"}, rsp.TLS.PeerCertificates[0].DNSNames)
+
+	// certificates are not obtained for other domains
+	_, err = ps.HttpServer.TLSConfig.GetCertificate(&tls.ClientHelloInfo{ServerName: "rustler.example"})
+	assert.Error(t, err)
+}
+
+func TestAcme_HTTP01(t *testing.T) {
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", GetTestPort(), true)
+	config.TLSCertConfig = &TLSCertConfig{ACME: &ACMEConfig{Domains: []string{"ranch.example"},
+		CacheDir: t.TempDir(), Challenge: AcmeChallengeHTTP01, HTTPPort: freePort(t)}}
+	ps := protocolServer(config)
+	require.NotNil(t, ps.acme)
+	assert.NotContains(t, ps.HttpServer.TLSConfig.NextProtos, acme.ALPNProto)
+	ps.startAcme()
+	defer ps.stopAcme(context.Background())
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	get := func(path string) (*http.Response, error) {
+		req, _ := http.NewRequest(http.MethodGet,
+			fmt.Sprintf("http://127.0.0.1:%d%s", config.TLSCertConfig.ACME.HTTPPort, path), nil)
+		req.Host = "ranch.example"
+		rsp, err := client.Do(req)
+		if err == nil {
+			_ = rsp.Body.Close()
+		}
+		return rsp, err
+	}
+	var rsp *http.Response
+	require.Eventually(t, func() bool {
+		var err error
+		rsp, err = get("/herd?size=12")
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// requests other than challenges are sent to HTTPS
+	assert.Equal(t, http.StatusFound, rsp.StatusCode)
+	assert.Equal(t, "https://ranch.example/herd?size=12", rsp.Header.Get("Location"))
+
+	// challenges the CA did not ask for are not found
+	rsp, err := get("/.well-known/acme-challenge/moo")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, rsp.StatusCode)
+}
+
+func TestAcme_Invalid(t *testing.T) {
+	for _, cfg := range []*ACMEConfig{
+		{CacheDir: t.TempDir()},
+		{Domains: []string{"ranch.example"}},
+		{Domains: []string{"ranch.example"}, CacheDir: t.TempDir(), Challenge: "dns-01"},
+	} {
+		config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", GetTestPort(), true)
+		config.TLSCertConfig = &TLSCertConfig{ACME: cfg}
+		ps := protocolServer(config)
+		assert.Nil(t, ps.acme)
+		assert.True(t, ps.HttpServer.TLSConfig == nil || ps.HttpServer.TLSConfig.GetCertificate == nil)
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pb33f/ranch/plank/pkg/apidocs"
+	"github.com/pb33f/ranch/service"
+)
+
+const defaultApiDocsEndpoint = "/docs"
+
+// apiDocsState documents the REST bridges of the server as they come and go.
+type apiDocsState struct {
+	config  *ApiDocsConfig
+	lock    sync.RWMutex
+	bridges map[string]*apidocs.Endpoint // documented bridges, by the name of their route
+}
+
+// initApiDocs serves the API reference and the OpenAPI and AsyncAPI documents, if configured.
+func (ps *platformServer) initApiDocs() {
+	cfg := ps.serverConfig.ApiDocs
+	if cfg == nil {
+		return
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = defaultApiDocsEndpoint
+	}
+	if cfg.Title == "" {
+		cfg.Title = "ranch API"
+	}
+	ps.apiDocs = &apiDocsState{config: cfg, bridges: make(map[string]*apidocs.Endpoint)}
+
+	endpoint := strings.TrimSuffix(cfg.Endpoint, "/")
+	ps.router.Path(cfg.Endpoint).Name(cfg.Endpoint).Methods(http.MethodGet).HandlerFunc(
+		ps.apiDocsHandler(func(w http.ResponseWriter, r *http.Request) {
+			openAPI, asyncAPI := ps.ApiDocs()
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			ref := apidocs.NewReference(openAPI, asyncAPI, endpoint+"/openapi.json", endpoint+"/asyncapi.json")
+			if err := ref.Render(w); err != nil {
+				ps.serverConfig.Logger.Error("[ranch] API reference cannot be rendered", "error", err.Error())
+			}
+		}))
+	for name, document := range map[string]func() interface{}{
+		"/openapi.json": func() interface{} {
+			openAPI, _ := ps.ApiDocs()
+			return openAPI
+		},
+		"/asyncapi.json": func() interface{} {
+			_, asyncAPI := ps.ApiDocs()
+			return asyncAPI
+		},
+	} {
+		document := document
+		ps.router.Path(endpoint + name).Name(endpoint + name).Methods(http.MethodGet).HandlerFunc(
+			ps.apiDocsHandler(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				_ = json.NewEncoder(w).Encode(document())
+			}))
+	}
+	ps.serverConfig.Logger.Info("[ranch] API reference enabled", "endpoint", cfg.Endpoint)
+}
+
+// apiDocsHandler only lets through the requests ApiDocsConfig.Authorize allows.
+func (ps *platformServer) apiDocsHandler(handler http.HandlerFunc) http.HandlerFunc {
+	authorize := ps.apiDocs.config.Authorize
+	return func(w http.ResponseWriter, r *http.Request) {
+		if authorize != nil && !authorize(r) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		handler(w, r)
+	}
+}
+
+// ApiDocs returns the OpenAPI document of the REST bridges and the AsyncAPI document of the service
+// channels, nil if the API reference is not configured.
+func (ps *platformServer) ApiDocs() (*apidocs.OpenAPI, *apidocs.AsyncAPI) {
+	if ps.apiDocs == nil {
+		return nil, nil
+	}
+	cfg := ps.apiDocs.config
+	info := apidocs.Info{Title: cfg.Title, Version: cfg.Version, Description: cfg.Description}
+	endpoints := ps.apiDocs.endpoints()
+
+	channels := service.GetServiceRegistry().GetAllServiceChannels()
+	known := make(map[string]bool)
+	for _, channel := range channels {
+		known[channel] = true
+	}
+	for _, e := range endpoints {
+		if !known[e.Channel] {
+			known[e.Channel] = true
+			channels = append(channels, e.Channel)
+		}
+	}
+	sort.Strings(channels)
+
+	return apidocs.NewOpenAPI(info, endpoints), apidocs.NewAsyncAPI(info, ps.fabricBroker(), channels, endpoints)
+}
+
+// fabricBroker describes the fabric broker for the AsyncAPI document, nil if the broker is not configured.
+func (ps *platformServer) fabricBroker() *apidocs.Broker {
+	cfg := ps.serverConfig.FabricConfig
+	if cfg == nil {
+		return nil
+	}
+	host := ps.serverConfig.Host
+	if host == "" {
+		host = "localhost"
+	}
+	broker := &apidocs.Broker{Protocol: "stomp"}
+	switch {
+	case cfg.UseTCP && !cfg.SharePort:
+		broker.Url = fmt.Sprintf("tcp://%s:%d", host, cfg.TCPPort)
+		if cfg.UseTLS {
+			broker.Protocol = "stomps"
+		}
+	case cfg.UseTCP:
+		broker.Url = fmt.Sprintf("tcp://%s:%d", host, ps.serverConfig.Port)
+		if ps.serverConfig.TLSCertConfig != nil {
+			broker.Protocol = "stomps"
+		}
+	case ps.serverConfig.TLSCertConfig != nil:
+		broker.Url = fmt.Sprintf("wss://%s:%d%s", host, ps.serverConfig.Port, cfg.FabricEndpoint)
+	default:
+		broker.Url = fmt.Sprintf("ws://%s:%d%s", host, ps.serverConfig.Port, cfg.FabricEndpoint)
+	}
+	if cfg.EndpointConfig != nil {
+		broker.TopicPrefix = cfg.EndpointConfig.TopicPrefix
+		broker.AppRequestPrefix = cfg.EndpointConfig.AppRequestPrefix
+	}
+	return broker
+}
+
+// addBridge documents the REST bridge of a route.
+func (ds *apiDocsState) addBridge(routeName string, config *service.RESTBridgeConfig, prefix bool) {
+	ds.lock.Lock()
+	ds.bridges[routeName] = apidocs.NewEndpoint(config, prefix)
+	ds.lock.Unlock()
+}
+
+// removeBridge stops documenting the REST bridge of a route.
+func (ds *apiDocsState) removeBridge(routeName string) {
+	ds.lock.Lock()
+	delete(ds.bridges, routeName)
+	ds.lock.Unlock()
+}
+
+func (ds *apiDocsState) endpoints() []*apidocs.Endpoint {
+	ds.lock.RLock()
+	defer ds.lock.RUnlock()
+	endpoints := make([]*apidocs.Endpoint, 0, len(ds.bridges))
+	for _, e := range ds.bridges {
+		endpoints = append(endpoints, e)
+	}
+	return endpoints
+}
@@ -0,0 +1,103 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/plank/pkg/apidocs"
+	"github.com/pb33f/ranch/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlatformServer_ApiDocs(t *testing.T) {
+	service.ResetServiceRegistry()
+	ps := &platformServer{
+		eventbus: bus.NewEventBusInstance(),
+		router:   mux.NewRouter(),
+		serverConfig: &PlatformServerConfig{
+			Logger:  slog.Default(),
+			Host:    "ranch.pb33f.io",
+			Port:    8080,
+			ApiDocs: &ApiDocsConfig{Version: "1.0.0"},
+			FabricConfig: &FabricBrokerConfig{
+				FabricEndpoint: "/ws",
+				EndpointConfig: &bus.EndpointConfig{TopicPrefix: "/topic", AppRequestPrefix: "/pub"},
+			},
+		},
+	}
+	ps.initApiDocs()
+	ps.apiDocs.addBridge("/rest/cows/{name}-GET", &service.RESTBridgeConfig{
+		ServiceChannel: "cow-service",
+		Uri:            "/rest/cows/{name}",
+		Method:         http.MethodGet,
+		Summary:        "Look up a cow",
+		Examples:       []*service.BridgeExample{{Name: "daisy", Response: map[string]string{"name": "daisy"}}},
+	}, false)
+	ps.apiDocs.addBridge("/rest/barn-*", &service.RESTBridgeConfig{ServiceChannel: "barn-service", Uri: "/rest/barn"}, true)
+	ps.apiDocs.addBridge("/rest/hay-POST", &service.RESTBridgeConfig{
+		ServiceChannel: "hay-service", Uri: "/rest/hay", Method: http.MethodPost,
+	}, false)
+	ps.apiDocs.removeBridge("/rest/hay-POST")
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		ps.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	w := get("/docs/openapi.json")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var openAPI apidocs.OpenAPI
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &openAPI))
+	assert.Equal(t, "ranch API", openAPI.Info.Title)
+	assert.Equal(t, "Look up a cow", openAPI.Paths["/rest/cows/{name}"]["get"].Summary)
+	assert.Len(t, openAPI.Paths["/rest/barn/{path}"], 5)
+	assert.NotContains(t, openAPI.Paths, "/rest/hay")
+
+	w = get("/docs/asyncapi.json")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var asyncAPI apidocs.AsyncAPI
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &asyncAPI))
+	assert.Equal(t, "ws://ranch.pb33f.io:8080/ws", asyncAPI.Servers["fabric"].Url)
+	assert.Equal(t, "/pub/cow-service", asyncAPI.Channels["cow-service"].Publish.Destination)
+	assert.Equal(t, "/topic/cow-service", asyncAPI.Channels["cow-service"].Subscribe.Destination)
+	assert.Contains(t, asyncAPI.Channels, "barn-service")
+
+	w = get("/docs")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), "Look up a cow")
+	assert.Contains(t, w.Body.String(), `href="/docs/openapi.json"`)
+}
+
+func TestPlatformServer_ApiDocsAuthorize(t *testing.T) {
+	ps := &platformServer{
+		router: mux.NewRouter(),
+		serverConfig: &PlatformServerConfig{
+			Logger: slog.Default(),
+			ApiDocs: &ApiDocsConfig{
+				Endpoint:  "/ranch/docs",
+				Authorize: func(r *http.Request) bool { return r.Header.Get("Authorization") != "" },
+			},
+		},
+	}
+	ps.initApiDocs()
+
+	w := httptest.NewRecorder()
+	ps.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ranch/docs/openapi.json", nil))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	r := httptest.NewRequest(http.MethodGet, "/ranch/docs/openapi.json", nil)
+	r.Header.Set("Authorization", "Bearer moo")
+	w = httptest.NewRecorder()
+	ps.router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,61 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"time"
+
+	"github.com/pb33f/ranch/plank/pkg/archive"
+	"github.com/pb33f/ranch/service"
+)
+
+const defaultReplayChannel = "ranch-replay"
+
+// startArchive archives the configured channels and registers the service replaying them, if configured.
+func (ps *platformServer) startArchive() {
+	cfg := ps.serverConfig.Archive
+	if cfg == nil {
+		return
+	}
+	a := archive.NewArchive(ps.eventbus, &archive.Config{
+		Channels:    cfg.Channels,
+		MaxMessages: cfg.MaxMessagesPerChannel,
+		MaxAge:      time.Duration(cfg.MaxAgeMinutes) * time.Minute,
+		Compress:    cfg.Compress,
+	})
+	if err := a.Start(); err != nil {
+		ps.serverConfig.Logger.Error(wrapError(errServerInit, err).Error())
+		return
+	}
+	replayService := archive.NewReplayService(a, cfg.MaxReplaySpeed, cfg.MaxReplays)
+	if err := ps.RegisterService(replayService, ps.replayChannel()); err != nil {
+		a.Stop()
+		ps.serverConfig.Logger.Error(wrapError(errServerInit, err).Error())
+		return
+	}
+	ps.lock.Lock()
+	ps.archive, ps.replayService = a, replayService
+	ps.lock.Unlock()
+}
+
+// stopArchive stops the replays in progress and archiving.
+func (ps *platformServer) stopArchive() {
+	ps.lock.Lock()
+	a, replayService := ps.archive, ps.replayService
+	ps.archive, ps.replayService = nil, nil
+	ps.lock.Unlock()
+	if a == nil {
+		return
+	}
+	_ = service.GetServiceRegistry().UnregisterService(ps.replayChannel())
+	replayService.Close()
+	a.Stop()
+}
+
+func (ps *platformServer) replayChannel() string {
+	if ps.serverConfig.Archive.ReplayChannel != "" {
+		return ps.serverConfig.Archive.ReplayChannel
+	}
+	return defaultReplayChannel
+}
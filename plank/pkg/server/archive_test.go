@@ -0,0 +1,67 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/model"
+	"github.com/pb33f/ranch/plank/pkg/archive"
+	"github.com/pb33f/ranch/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlatformServer_Archive(t *testing.T) {
+	newBus := bus.ResetBus()
+	service.ResetServiceRegistry()
+	port := GetTestPort()
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", port, true)
+	config.Archive = &ArchiveConfig{Channels: []string{"metrics"}}
+	ps := NewPlatformServer(config)
+	ps.(*platformServer).eventbus = newBus
+
+	syschan := make(chan os.Signal, 1)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go ps.StartServer(syschan)
+	RunWhenServerReady(t, newBus, func(t2 *testing.T) {
+		_ = newBus.SendResponseMessage("metrics", "cpu 42", nil)
+		assert.Eventually(t2, func() bool {
+			return len(ps.(*platformServer).archive.Messages("metrics", time.Time{}, time.Time{})) == 1
+		}, time.Second, 5*time.Millisecond)
+
+		replayed := make(chan *archive.ReplayMessage, 1)
+		handler, _ := newBus.ListenStream(defaultReplayChannel)
+		handler.Handle(func(msg *model.Message) {
+			if m, ok := msg.Payload.(*model.Response).Payload.(*archive.ReplayMessage); ok {
+				replayed <- m
+			}
+		}, func(err error) {})
+		defer handler.Close()
+
+		id := uuid.New()
+		_ = newBus.SendRequestMessage(defaultReplayChannel, &model.Request{
+			Id:             &id,
+			RequestCommand: archive.ReplayRequestCommand,
+			Payload:        map[string]interface{}{"channel": "metrics", "speed": 10},
+		}, nil)
+		select {
+		case m := <-replayed:
+			assert.Equal(t2, "metrics", m.Channel)
+			assert.Equal(t2, "cpu 42", m.Payload)
+		case <-time.After(time.Second):
+			t2.Fatal("archived message was not replayed")
+		}
+
+		ps.StopServer()
+		assert.Nil(t2, ps.(*platformServer).archive)
+		wg.Done()
+	})
+	wg.Wait()
+}
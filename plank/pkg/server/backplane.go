@@ -0,0 +1,155 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/pb33f/ranch/bridge"
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/stompserver"
+)
+
+const defaultBackplaneDestination = "ranch-backplane"
+
+// backplaneState is the stompserver.Backplane of the fabric broker, exchanging the messages it broadcasts
+// with the other instances on a destination of a broker they all connect to. The broker connection is
+// managed by a brokerBridge without channel mappings, so it is dialed and re-established the same way, and
+// every time it connects the destination is subscribed to again.
+type backplaneState struct {
+	bridge      *brokerBridge
+	destination string
+	logger      *slog.Logger
+	deliver     func(msg *stompserver.BackplaneMessage)
+	sub         bridge.Subscription
+	lock        sync.Mutex
+}
+
+func newBackplaneState(config *BackplaneConfig, eventBus bus.EventBus, logger *slog.Logger) (*backplaneState, error) {
+	if err := validateBrokerConnection(config.Broker); err != nil {
+		return nil, err
+	}
+	bs := &backplaneState{
+		bridge:      newBrokerBridge(config.Broker, eventBus, logger),
+		destination: config.Destination,
+		logger:      logger,
+	}
+	if bs.destination == "" {
+		bs.destination = defaultBackplaneDestination
+	}
+	bs.bridge.onConnect = bs.connected
+	return bs, nil
+}
+
+// start connects to the broker in the background.
+func (bs *backplaneState) start() {
+	go func() {
+		if err := bs.bridge.dial(); err != nil {
+			bs.logger.Error("[ranch] backplane unable to connect to broker", "error", err.Error())
+		}
+	}()
+}
+
+func (bs *backplaneState) stop() {
+	bs.bridge.stop()
+	bs.lock.Lock()
+	sub := bs.sub
+	bs.sub = nil
+	bs.lock.Unlock()
+	if sub != nil {
+		_ = sub.Unsubscribe()
+	}
+}
+
+// connected subscribes to the messages of the other instances on a new broker connection.
+func (bs *backplaneState) connected(conn bridge.Connection) {
+	sub, err := conn.Subscribe(bs.destination)
+	if err != nil {
+		bs.logger.Error("[ranch] backplane unable to subscribe", "destination", bs.destination,
+			"error", err.Error())
+		return
+	}
+	bs.lock.Lock()
+	previous := bs.sub
+	bs.sub = sub
+	bs.lock.Unlock()
+	if previous != nil {
+		_ = previous.Unsubscribe()
+	}
+	go func() {
+		for msg := range sub.GetMsgChannel() {
+			payload, err := encodeBrokerPayload(msg.Payload)
+			if err != nil {
+				continue
+			}
+			var bm stompserver.BackplaneMessage
+			if err = json.Unmarshal(payload, &bm); err != nil {
+				bs.logger.Warn("[ranch] backplane received an unreadable message", "error", err.Error())
+				continue
+			}
+			bs.lock.Lock()
+			deliver := bs.deliver
+			bs.lock.Unlock()
+			if deliver != nil {
+				deliver(&bm)
+			}
+		}
+	}()
+}
+
+// Publish sends a message broadcast by the fabric broker to the other instances, reconnecting if the broker
+// cannot be reached.
+func (bs *backplaneState) Publish(msg *stompserver.BackplaneMessage) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	conn := bs.bridge.connection()
+	if conn == nil {
+		return fmt.Errorf("not connected to broker '%s'", bs.bridge.config.Name)
+	}
+	if err = conn.SendJSONMessage(bs.destination, payload); err != nil {
+		go bs.bridge.reconnect()
+		return err
+	}
+	return nil
+}
+
+func (bs *backplaneState) Subscribe(deliver func(msg *stompserver.BackplaneMessage)) {
+	bs.lock.Lock()
+	bs.deliver = deliver
+	bs.lock.Unlock()
+}
+
+// startBackplane connects to the backplane, if configured, before the fabric broker starts relaying
+// through it.
+func (ps *platformServer) startBackplane() {
+	cfg := ps.serverConfig.Backplane
+	if cfg == nil {
+		return
+	}
+	bs, err := newBackplaneState(cfg, ps.eventbus, ps.serverConfig.Logger)
+	if err != nil {
+		ps.serverConfig.Logger.Error(wrapError(errServerInit, err).Error())
+		return
+	}
+	bs.start()
+	ps.lock.Lock()
+	ps.backplane = bs
+	ps.lock.Unlock()
+}
+
+// stopBackplane disconnects from the backplane.
+func (ps *platformServer) stopBackplane() {
+	ps.lock.Lock()
+	bs := ps.backplane
+	ps.backplane = nil
+	ps.lock.Unlock()
+	if bs != nil {
+		bs.stop()
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/pb33f/ranch/bridge"
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/model"
+	"github.com/pb33f/ranch/stompserver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewBackplaneState_Invalid(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	_, err := newBackplaneState(&BackplaneConfig{}, bus.NewEventBusInstance(), logger)
+	assert.Error(t, err)
+	_, err = newBackplaneState(&BackplaneConfig{Broker: &BrokerBridgeConfig{Name: "redis"}}, bus.NewEventBusInstance(), logger)
+	assert.Error(t, err)
+}
+
+func TestBackplane_ExchangesMessages(t *testing.T) {
+	conn := newFakeBrokerConnection()
+	bs, err := newBackplaneState(&BackplaneConfig{
+		Broker: &BrokerBridgeConfig{Name: "redis", Transport: "redis", ServerAddr: "localhost:6379"},
+	}, bus.NewEventBusInstance(), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	require.NoError(t, err)
+	bs.bridge.connectFn = func(config *bridge.BrokerConnectorConfig) (bridge.Connection, error) {
+		return conn, nil
+	}
+
+	// nothing is published before connecting
+	assert.Error(t, bs.Publish(&stompserver.BackplaneMessage{Node: "a", Destination: "/topic/cows"}))
+
+	received := make(chan *stompserver.BackplaneMessage, 1)
+	bs.Subscribe(func(msg *stompserver.BackplaneMessage) {
+		received <- msg
+	})
+	bs.start()
+	defer bs.stop()
+	require.Eventually(t, func() bool {
+		return conn.getSub(defaultBackplaneDestination) != nil
+	}, time.Second, 5*time.Millisecond)
+
+	// broadcast messages are published
+	require.NoError(t, bs.Publish(&stompserver.BackplaneMessage{Node: "a", Destination: "/topic/cows", Body: []byte("moo")}))
+	select {
+	case msg := <-conn.sent:
+		assert.Equal(t, defaultBackplaneDestination, msg.destination)
+		var published stompserver.BackplaneMessage
+		require.NoError(t, json.Unmarshal(msg.payload, &published))
+		assert.Equal(t, stompserver.BackplaneMessage{Node: "a", Destination: "/topic/cows", Body: []byte("moo")}, published)
+	case <-time.After(time.Second):
+		t.Fatal("message not published")
+	}
+
+	// and those of the other instances delivered
+	payload, _ := json.Marshal(&stompserver.BackplaneMessage{Node: "b", Destination: "/topic/cows", Body: []byte("baa")})
+	conn.getSub(defaultBackplaneDestination).c <- model.GenerateResponse(&model.MessageConfig{Payload: payload})
+	select {
+	case msg := <-received:
+		assert.Equal(t, "b", msg.Node)
+		assert.Equal(t, []byte("baa"), msg.Body)
+	case <-time.After(time.Second):
+		t.Fatal("message of another instance was not delivered")
+	}
+}
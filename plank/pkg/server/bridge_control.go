@@ -0,0 +1,114 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"fmt"
+
+	"github.com/pb33f/ranch/model"
+	"github.com/pb33f/ranch/service"
+)
+
+// Commands of a BridgeCommand.
+const (
+	BridgeCommandAdd    = "add"    // set up the bridge, replacing the bridge of its URI and method
+	BridgeCommandRemove = "remove" // remove the bridge of the URI and method
+)
+
+// BridgeCommand adds or removes a REST bridge while the server runs. Services publish commands on
+// RANCH_BRIDGE_CONTROL_CHANNEL to reconfigure their bridges, e.g. to move an endpoint to a new URI or hand
+// it to another service, without restarting the server. The channel is internal, fabric clients cannot publish on it.
+type BridgeCommand struct {
+	Command    string                    // BridgeCommandAdd or BridgeCommandRemove
+	Bridge     *service.RESTBridgeConfig // bridge added, or the URI and method of the bridge removed
+	PathPrefix bool                      // the bridge is a path prefix bridge of every method, see SetHttpPathPrefixChannelBridge
+}
+
+func (cmd *BridgeCommand) validate() error {
+	if cmd.Bridge == nil || cmd.Bridge.Uri == "" {
+		return fmt.Errorf("bridge command '%s' has no URI", cmd.Command)
+	}
+	if !cmd.PathPrefix && cmd.Bridge.Method == "" {
+		return fmt.Errorf("bridge command '%s' of %s has no method", cmd.Command, cmd.Bridge.Uri)
+	}
+	switch cmd.Command {
+	case BridgeCommandAdd:
+		if cmd.Bridge.ServiceChannel == "" {
+			return fmt.Errorf("bridge command '%s' of %s has no service channel", cmd.Command, cmd.Bridge.Uri)
+		}
+	case BridgeCommandRemove:
+	default:
+		return fmt.Errorf("unknown bridge command '%s'", cmd.Command)
+	}
+	return nil
+}
+
+// applyBridgeCommand adds or removes the REST bridge of a command.
+func (ps *platformServer) applyBridgeCommand(cmd *BridgeCommand) error {
+	if err := cmd.validate(); err != nil {
+		return err
+	}
+	unset := func() error { return ps.UnsetHttpChannelBridge(cmd.Bridge.Uri, cmd.Bridge.Method) }
+	if cmd.PathPrefix {
+		unset = func() error { return ps.UnsetHttpPathPrefixChannelBridge(cmd.Bridge.Uri) }
+	}
+	if cmd.Command == BridgeCommandRemove {
+		return unset()
+	}
+
+	if !ps.eventbus.GetChannelManager().CheckChannelExists(cmd.Bridge.ServiceChannel) {
+		return fmt.Errorf("bridge command '%s' of %s: channel '%s' does not exist", cmd.Command, cmd.Bridge.Uri,
+			cmd.Bridge.ServiceChannel)
+	}
+	_ = unset() // nothing to replace if the bridge does not exist yet
+	if cmd.PathPrefix {
+		ps.SetHttpPathPrefixChannelBridge(cmd.Bridge)
+	} else {
+		ps.SetHttpChannelBridge(cmd.Bridge)
+	}
+	return nil
+}
+
+// startBridgeControl applies the bridge commands published on RANCH_BRIDGE_CONTROL_CHANNEL.
+func (ps *platformServer) startBridgeControl() {
+	cm := ps.eventbus.GetChannelManager()
+	if !cm.CheckChannelExists(RANCH_BRIDGE_CONTROL_CHANNEL) {
+		cm.CreateChannel(RANCH_BRIDGE_CONTROL_CHANNEL)
+	}
+	handler, err := ps.eventbus.ListenFirehose(RANCH_BRIDGE_CONTROL_CHANNEL)
+	if err != nil {
+		ps.serverConfig.Logger.Error(wrapError(errServerInit, err).Error())
+		return
+	}
+	handler.Handle(func(msg *model.Message) {
+		var cmd *BridgeCommand
+		switch p := msg.Payload.(type) {
+		case *BridgeCommand:
+			cmd = p
+		case BridgeCommand:
+			cmd = &p
+		default:
+			ps.serverConfig.Logger.Warn("[ranch] ignoring invalid bridge command", "channel", RANCH_BRIDGE_CONTROL_CHANNEL)
+			return
+		}
+		if err := ps.applyBridgeCommand(cmd); err != nil {
+			ps.serverConfig.Logger.Warn("[ranch] ignoring bridge command", "error", err.Error())
+		}
+	}, func(err error) {})
+
+	ps.lock.Lock()
+	ps.bridgeControlHandler = handler
+	ps.lock.Unlock()
+}
+
+// stopBridgeControl stops applying bridge commands, the bridges stay as they are.
+func (ps *platformServer) stopBridgeControl() {
+	ps.lock.Lock()
+	handler := ps.bridgeControlHandler
+	ps.bridgeControlHandler = nil
+	ps.lock.Unlock()
+	if handler != nil {
+		handler.Close()
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/model"
+	"github.com/pb33f/ranch/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBridgeCommand_Validate(t *testing.T) {
+	bridge := &service.RESTBridgeConfig{ServiceChannel: "cows", Uri: "/cows", Method: http.MethodGet}
+	assert.NoError(t, (&BridgeCommand{Command: BridgeCommandAdd, Bridge: bridge}).validate())
+	assert.NoError(t, (&BridgeCommand{Command: BridgeCommandRemove, Bridge: &service.RESTBridgeConfig{Uri: "/barn"},
+		PathPrefix: true}).validate())
+	assert.Error(t, (&BridgeCommand{Command: "moo", Bridge: bridge}).validate())
+	assert.Error(t, (&BridgeCommand{Command: BridgeCommandAdd}).validate())
+	assert.Error(t, (&BridgeCommand{Command: BridgeCommandAdd, Bridge: &service.RESTBridgeConfig{Uri: "/cows",
+		Method: http.MethodGet}}).validate())
+	assert.Error(t, (&BridgeCommand{Command: BridgeCommandRemove, Bridge: &service.RESTBridgeConfig{
+		Uri: "/cows"}}).validate())
+}
+
+func TestPlatformServer_BridgeControlChannel(t *testing.T) {
+	b := bus.ResetBus()
+	service.ResetServiceRegistry()
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", GetTestPort(), true)
+	ps := NewPlatformServer(config).(*platformServer)
+	ps.eventbus = b
+	b.GetChannelManager().CreateChannel("cows")
+	b.GetChannelManager().CreateChannel("pigs")
+	ps.startBridgeControl()
+	defer ps.stopBridgeControl()
+
+	requestBuilder := func(w http.ResponseWriter, r *http.Request) model.Request {
+		return model.Request{RequestCommand: "moo"}
+	}
+	routed := func(method string, path string) bool {
+		ps.lock.Lock()
+		defer ps.lock.Unlock()
+		var match mux.RouteMatch
+		return ps.router.Match(httptest.NewRequest(method, "http://localhost"+path, nil), &match)
+	}
+	send := func(cmd *BridgeCommand) {
+		require.NoError(t, b.SendResponseMessage(RANCH_BRIDGE_CONTROL_CHANNEL, cmd, nil))
+	}
+
+	// services add bridges at runtime
+	send(&BridgeCommand{Command: BridgeCommandAdd, Bridge: &service.RESTBridgeConfig{ServiceChannel: "cows",
+		Uri: "/cows", Method: http.MethodGet, FabricRequestBuilder: requestBuilder}})
+	send(&BridgeCommand{Command: BridgeCommandAdd, PathPrefix: true, Bridge: &service.RESTBridgeConfig{
+		ServiceChannel: "cows", Uri: "/barn", FabricRequestBuilder: requestBuilder}})
+	assert.Eventually(t, func() bool { return routed(http.MethodGet, "/cows") && routed(http.MethodPut, "/barn/1") },
+		time.Second, 5*time.Millisecond)
+
+	// and replace them, e.g. with the bridge of another service
+	send(&BridgeCommand{Command: BridgeCommandAdd, Bridge: &service.RESTBridgeConfig{ServiceChannel: "pigs",
+		Uri: "/cows", Method: http.MethodGet, FabricRequestBuilder: requestBuilder}})
+	assert.Eventually(t, func() bool {
+		ps.lock.Lock()
+		defer ps.lock.Unlock()
+		return assert.ObjectsAreEqual([]string{"/barn-*"}, ps.serviceChanToBridgeEndpoints["cows"]) &&
+			assert.ObjectsAreEqual([]string{"/cows-GET"}, ps.serviceChanToBridgeEndpoints["pigs"])
+	}, time.Second, 5*time.Millisecond)
+
+	// or remove them
+	send(&BridgeCommand{Command: BridgeCommandRemove, Bridge: &service.RESTBridgeConfig{Uri: "/cows",
+		Method: http.MethodGet}})
+	send(&BridgeCommand{Command: BridgeCommandRemove, PathPrefix: true, Bridge: &service.RESTBridgeConfig{
+		Uri: "/barn"}})
+	assert.Eventually(t, func() bool { return !routed(http.MethodGet, "/cows") && !routed(http.MethodPut, "/barn/1") },
+		time.Second, 5*time.Millisecond)
+
+	// bridges of channels that do not exist are not added
+	assert.Error(t, ps.applyBridgeCommand(&BridgeCommand{Command: BridgeCommandAdd,
+		Bridge: &service.RESTBridgeConfig{ServiceChannel: "sheep", Uri: "/sheep", Method: http.MethodGet}}))
+	assert.Error(t, ps.applyBridgeCommand(&BridgeCommand{Command: BridgeCommandRemove,
+		Bridge: &service.RESTBridgeConfig{Uri: "/sheep", Method: http.MethodGet}}))
+}
@@ -0,0 +1,277 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/model"
+)
+
+// BridgeRoute sends part of the requests of the REST bridges of a service channel to an alternate channel,
+// e.g. a canary release of the service, while clients keep calling the same endpoints. Requests matching
+// the header or one of the principals always go to the alternate channel, the others with the percentage.
+type BridgeRoute struct {
+	Channel     string   `json:"channel"`                // service channel of the bridges whose requests are routed
+	Alternate   string   `json:"alternate"`              // service channel requests are routed to
+	Percent     int      `json:"percent"`                // share of the requests routed, 0 to 100
+	Header      string   `json:"header,omitempty"`       // requests carrying this header are routed
+	HeaderValue string   `json:"header_value,omitempty"` // only if the header has this value, any value if empty
+	Principals  []string `json:"principals,omitempty"`   // requests made by these principals are routed
+	Remove      bool     `json:"remove,omitempty"`       // stop routing the requests of Channel, published on RANCH_BRIDGE_ROUTING_CHANNEL
+}
+
+func (route *BridgeRoute) validate() error {
+	if route.Channel == "" {
+		return fmt.Errorf("bridge route has no channel")
+	}
+	if route.Remove {
+		return nil
+	}
+	if route.Alternate == "" || route.Alternate == route.Channel {
+		return fmt.Errorf("bridge route of '%s' needs an alternate channel", route.Channel)
+	}
+	if route.Percent < 0 || route.Percent > 100 {
+		return fmt.Errorf("bridge route of '%s' has an invalid percentage %d", route.Channel, route.Percent)
+	}
+	return nil
+}
+
+// matches returns whether a request to a bridge of the route is routed to the alternate channel.
+func (route *BridgeRoute) matches(r *http.Request, principal string) bool {
+	if route.Header != "" {
+		if value := r.Header.Get(route.Header); value != "" && (route.HeaderValue == "" || value == route.HeaderValue) {
+			return true
+		}
+	}
+	if principal != "" && slices.Contains(route.Principals, principal) {
+		return true
+	}
+	return route.Percent >= 100 || (route.Percent > 0 && rand.IntN(100) < route.Percent)
+}
+
+// activeBridgeRoute is a route in use, with the message bridge of its alternate channel.
+type activeBridgeRoute struct {
+	route  BridgeRoute
+	bridge *MessageBridge
+}
+
+// bridgeRoutingState holds the routes of the REST bridges, changed at runtime on
+// RANCH_BRIDGE_ROUTING_CHANNEL.
+type bridgeRoutingState struct {
+	lock    sync.RWMutex
+	routes  map[string]*activeBridgeRoute // by the service channel of the bridges
+	handler bus.MessageHandler
+}
+
+// initBridgeRouting prepares routing REST bridge requests to alternate channels, if configured.
+func (ps *platformServer) initBridgeRouting() {
+	if ps.serverConfig.BridgeRouting == nil {
+		return
+	}
+	ps.bridgeRouting = &bridgeRoutingState{routes: make(map[string]*activeBridgeRoute)}
+}
+
+// SetBridgeRoute starts routing requests of the REST bridges of route.Channel to route.Alternate, replacing
+// the previous route of the channel, or stops routing them if route.Remove is set.
+func (ps *platformServer) SetBridgeRoute(route *BridgeRoute) error {
+	state := ps.bridgeRouting
+	if state == nil {
+		return fmt.Errorf("bridge routing is not enabled")
+	}
+	if err := route.validate(); err != nil {
+		return err
+	}
+	if route.Remove {
+		ps.lock.Lock()
+		state.lock.Lock()
+		previous, routed := state.routes[route.Channel]
+		delete(state.routes, route.Channel)
+		state.lock.Unlock()
+		if routed {
+			ps.releaseMessageBridge(previous.route.Alternate)
+		}
+		ps.lock.Unlock()
+		ps.serverConfig.Logger.Info("[ranch] bridge route removed", "channel", route.Channel)
+		return nil
+	}
+
+	// the bridge is in use by the route before ps.lock is released, so it cannot be released meanwhile
+	ps.lock.Lock()
+	active := &activeBridgeRoute{route: *route, bridge: ps.lockedBridgeFor(route.Alternate)}
+	active.route.Principals = slices.Clone(route.Principals)
+	state.lock.Lock()
+	previous := state.routes[route.Channel]
+	state.routes[route.Channel] = active
+	state.lock.Unlock()
+	if previous != nil && previous.bridge != active.bridge {
+		ps.releaseMessageBridge(previous.route.Alternate)
+	}
+	ps.lock.Unlock()
+	ps.serverConfig.Logger.Info("[ranch] bridge requests routed to alternate channel", "channel", route.Channel,
+		"alternate", route.Alternate, "percent", route.Percent, "header", route.Header,
+		"principals", len(route.Principals))
+	return nil
+}
+
+// routesTo returns whether a route in use sends requests to the alternate channel of a message bridge.
+func (ps *platformServer) routesTo(mb *MessageBridge) bool {
+	state := ps.bridgeRouting
+	if state == nil {
+		return false
+	}
+	state.lock.RLock()
+	defer state.lock.RUnlock()
+	for _, active := range state.routes {
+		if active.bridge == mb {
+			return true
+		}
+	}
+	return false
+}
+
+// BridgeRoutes returns the routes of the REST bridges in use.
+func (ps *platformServer) BridgeRoutes() []*BridgeRoute {
+	routes := make([]*BridgeRoute, 0)
+	state := ps.bridgeRouting
+	if state == nil {
+		return routes
+	}
+	state.lock.RLock()
+	defer state.lock.RUnlock()
+	for _, active := range state.routes {
+		route := active.route
+		routes = append(routes, &route)
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Channel < routes[j].Channel })
+	return routes
+}
+
+// bridgeFor returns the message bridge relaying the responses of a service channel to REST bridge requests,
+// listening to the service channel if no bridge does yet.
+func (ps *platformServer) bridgeFor(serviceChannel string) *MessageBridge {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	return ps.lockedBridgeFor(serviceChannel)
+}
+
+// lockedBridgeFor is bridgeFor for callers holding ps.lock.
+func (ps *platformServer) lockedBridgeFor(serviceChannel string) *MessageBridge {
+	if mb, exists := ps.messageBridgeMap[serviceChannel]; exists {
+		return mb
+	}
+	cm := ps.eventbus.GetChannelManager()
+	if !cm.CheckChannelExists(serviceChannel) {
+		cm.CreateChannel(serviceChannel)
+	}
+	return ps.newMessageBridge(serviceChannel)
+}
+
+// routeBridgeRequest returns the service channel a request to a REST bridge of serviceChannel is sent to,
+// and the message bridge its responses arrive on. Requests stay on serviceChannel unless a route matches them.
+func (ps *platformServer) routeBridgeRequest(serviceChannel string, bridge *MessageBridge,
+	r *http.Request, principal string) (string, *MessageBridge) {
+
+	state := ps.bridgeRouting
+	if state == nil {
+		return serviceChannel, bridge
+	}
+	state.lock.RLock()
+	active := state.routes[serviceChannel]
+	state.lock.RUnlock()
+	if active == nil || !active.route.matches(r, principal) {
+		return serviceChannel, bridge
+	}
+	return active.route.Alternate, active.bridge
+}
+
+// startBridgeRouting applies the configured routes, and changes them whenever a route is published on
+// RANCH_BRIDGE_ROUTING_CHANNEL.
+func (ps *platformServer) startBridgeRouting() {
+	state := ps.bridgeRouting
+	if state == nil {
+		return
+	}
+	for _, route := range ps.serverConfig.BridgeRouting.Routes {
+		if err := ps.SetBridgeRoute(route); err != nil {
+			ps.serverConfig.Logger.Error(wrapError(errServerInit, err).Error())
+		}
+	}
+
+	cm := ps.eventbus.GetChannelManager()
+	if !cm.CheckChannelExists(RANCH_BRIDGE_ROUTING_CHANNEL) {
+		cm.CreateChannel(RANCH_BRIDGE_ROUTING_CHANNEL)
+	}
+	handler, err := ps.eventbus.ListenFirehose(RANCH_BRIDGE_ROUTING_CHANNEL)
+	if err != nil {
+		ps.serverConfig.Logger.Error(wrapError(errServerInit, err).Error())
+		return
+	}
+	handler.Handle(func(msg *model.Message) {
+		route := bridgeRouteFromPayload(msg.Payload)
+		if route == nil {
+			ps.serverConfig.Logger.Warn("[ranch] ignoring invalid bridge route", "channel", RANCH_BRIDGE_ROUTING_CHANNEL)
+			return
+		}
+		if err := ps.SetBridgeRoute(route); err != nil {
+			ps.serverConfig.Logger.Warn("[ranch] ignoring invalid bridge route", "error", err.Error())
+		}
+	}, func(err error) {})
+
+	ps.lock.Lock()
+	state.handler = handler
+	ps.lock.Unlock()
+}
+
+// stopBridgeRouting stops listening for route changes, the routes stay in use.
+func (ps *platformServer) stopBridgeRouting() {
+	state := ps.bridgeRouting
+	if state == nil {
+		return
+	}
+	ps.lock.Lock()
+	handler := state.handler
+	state.handler = nil
+	ps.lock.Unlock()
+	if handler != nil {
+		handler.Close()
+	}
+}
+
+// bridgeRouteFromPayload returns the route published on RANCH_BRIDGE_ROUTING_CHANNEL, relayed from fabric
+// clients as JSON. Returns nil if the payload is not a route.
+func bridgeRouteFromPayload(payload interface{}) *BridgeRoute {
+	switch p := payload.(type) {
+	case *BridgeRoute:
+		return p
+	case BridgeRoute:
+		return &p
+	case nil:
+		return nil
+	}
+	var raw []byte
+	switch p := payload.(type) {
+	case []byte:
+		raw = p
+	case string:
+		raw = []byte(p)
+	default:
+		var err error
+		if raw, err = json.Marshal(p); err != nil {
+			return nil
+		}
+	}
+	var route BridgeRoute
+	if json.Unmarshal(raw, &route) != nil || route.Channel == "" {
+		return nil
+	}
+	return &route
+}
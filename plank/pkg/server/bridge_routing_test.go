@@ -0,0 +1,100 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/model"
+	"github.com/pb33f/ranch/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestBridgeRouting() *platformServer {
+	b := bus.ResetBus()
+	service.ResetServiceRegistry()
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", GetTestPort(), true)
+	config.BridgeRouting = &BridgeRoutingConfig{}
+	ps := NewPlatformServer(config).(*platformServer)
+	ps.eventbus = b
+	return ps
+}
+
+func TestBridgeRoute_Validate(t *testing.T) {
+	assert.Error(t, (&BridgeRoute{Alternate: "cows-canary"}).validate())
+	assert.Error(t, (&BridgeRoute{Channel: "cows"}).validate())
+	assert.Error(t, (&BridgeRoute{Channel: "cows", Alternate: "cows"}).validate())
+	assert.Error(t, (&BridgeRoute{Channel: "cows", Alternate: "cows-canary", Percent: 101}).validate())
+	assert.NoError(t, (&BridgeRoute{Channel: "cows", Alternate: "cows-canary", Percent: 10}).validate())
+	assert.NoError(t, (&BridgeRoute{Channel: "cows", Remove: true}).validate())
+}
+
+func TestPlatformServer_RouteBridgeRequest(t *testing.T) {
+	ps := newTestBridgeRouting()
+	bridge := &MessageBridge{}
+	assert.NoError(t, ps.SetBridgeRoute(&BridgeRoute{Channel: "cows", Alternate: "cows-canary",
+		Header: "X-Canary", HeaderValue: "moo", Principals: []string{"daisy"}}))
+
+	route := func(header, principal string) string {
+		r := httptest.NewRequest(http.MethodGet, "/cows", nil)
+		if header != "" {
+			r.Header.Set("X-Canary", header)
+		}
+		channel, _ := ps.routeBridgeRequest("cows", bridge, r, principal)
+		return channel
+	}
+	assert.Equal(t, "cows-canary", route("moo", ""))
+	assert.Equal(t, "cows", route("baa", ""))
+	assert.Equal(t, "cows-canary", route("", "daisy"))
+	assert.Equal(t, "cows", route("", "bessie"))
+
+	// requests to other channels are not routed
+	channel, routed := ps.routeBridgeRequest("sheep", bridge, httptest.NewRequest(http.MethodGet, "/", nil), "")
+	assert.Equal(t, "sheep", channel)
+	assert.Same(t, bridge, routed)
+
+	// every request is routed at 100 percent
+	assert.NoError(t, ps.SetBridgeRoute(&BridgeRoute{Channel: "cows", Alternate: "cows-canary", Percent: 100}))
+	assert.Equal(t, "cows-canary", route("", ""))
+	assert.Len(t, ps.BridgeRoutes(), 1)
+
+	assert.NoError(t, ps.SetBridgeRoute(&BridgeRoute{Channel: "cows", Remove: true}))
+	assert.Equal(t, "cows", route("", ""))
+	assert.Empty(t, ps.BridgeRoutes())
+}
+
+func TestPlatformServer_BridgeRoutingChannel(t *testing.T) {
+	ps := newTestBridgeRouting()
+	ps.startBridgeRouting()
+	defer ps.stopBridgeRouting()
+
+	// routes are changed at runtime by publishing them, fabric clients relay them as JSON
+	_ = ps.eventbus.SendResponseMessage(RANCH_BRIDGE_ROUTING_CHANNEL,
+		[]byte(`{"channel":"cows","alternate":"cows-canary","percent":100}`), nil)
+	assert.Eventually(t, func() bool { return len(ps.BridgeRoutes()) == 1 }, time.Second, time.Millisecond)
+
+	// the alternate channel answers the requests of the bridge
+	_ = ps.eventbus.GetChannelManager().CreateChannel("cows")
+	canary, _ := ps.eventbus.ListenRequestStream("cows-canary")
+	defer canary.Close()
+	canary.Handle(func(msg *model.Message) {
+		req := msg.Payload.(model.Request)
+		_ = ps.eventbus.SendResponseMessage("cows-canary", &model.Response{Id: req.Id, Payload: "canary moo"}, req.Id)
+	}, func(err error) {})
+
+	handler := ps.buildEndpointHandler("cows", func(w http.ResponseWriter, r *http.Request) model.Request {
+		id := uuid.New()
+		return model.Request{Id: &id, RequestCommand: "moo"}
+	}, 5*time.Second, ps.bridgeFor("cows"), requestLimits{})
+	assert.HTTPBodyContains(t, handler, http.MethodGet, "http://localhost/cows", nil, "canary moo")
+
+	_ = ps.eventbus.SendResponseMessage(RANCH_BRIDGE_ROUTING_CHANNEL, &BridgeRoute{Channel: "cows", Remove: true}, nil)
+	assert.Eventually(t, func() bool { return len(ps.BridgeRoutes()) == 0 }, time.Second, time.Millisecond)
+}
@@ -0,0 +1,98 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/pb33f/ranch/clock"
+	"github.com/pb33f/ranch/model"
+)
+
+const ndjsonContentType = "application/x-ndjson"
+
+// writeStreamedResponse writes the chunks of a streamed service response (see model.Response.Stream) as they
+// arrive, flushing each one, until the service ends the stream, the client goes away or no chunk arrives for
+// as long as the REST bridge timeout. Chunks marshalled to JSON are written as NDJSON, one per line, others
+// as they are. Without a Content-Length the response goes out with chunked transfer encoding.
+func (ps *platformServer) writeStreamedResponse(w http.ResponseWriter, r *http.Request, channel string,
+	first *model.Response, responses chan *model.Message, timeout time.Duration) {
+
+	ps.setResponseHeaders(w, first)
+	w.Header().Del("Content-Length")
+	if contentType := w.Header().Get("Content-Type"); first.Marshal && first.ContentType == "" &&
+		(contentType == "" || contentType == "application/json") {
+		w.Header().Set("Content-Type", ndjsonContentType)
+	}
+	status := first.HttpStatusCode
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+
+	rc := http.NewResponseController(w)
+	response := first
+	for {
+		if err := writeStreamChunk(w, response); err != nil {
+			ps.serverConfig.Logger.Debug("[ranch] streamed response interrupted", "channel", channel,
+				"error", err.Error())
+			return
+		}
+		_ = rc.Flush()
+		if !response.Stream || response.Error {
+			if response.Error {
+				ps.serverConfig.Logger.Error("Error received from channel", "error", response.ErrorMessage,
+					"channel", channel)
+			}
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-clock.After(timeout):
+			ps.serverConfig.Logger.Warn("[ranch] streamed response timed out waiting for the next chunk",
+				"channel", channel, "timeout", timeout.String())
+			return
+		case msg := <-responses:
+			next, ok := msg.Payload.(*model.Response)
+			if msg.Error != nil || !ok {
+				ps.serverConfig.Logger.Error("Error received from channel", "error", msg.Error,
+					"channel", channel)
+				return
+			}
+			response = next
+		}
+	}
+}
+
+// writeStreamChunk writes the payload of a response to a stream: as a line of JSON if the response is
+// marshalled, as it is otherwise. Error responses without a payload are written as they are, the response
+// ending a stream has no payload.
+func writeStreamChunk(w io.Writer, response *model.Response) error {
+	chunk := response.Payload
+	if chunk == nil && response.Error {
+		chunk = response
+	}
+	if chunk == nil {
+		return nil
+	}
+	if !response.Marshal {
+		if data, ok := chunk.([]byte); ok {
+			_, err := w.Write(data)
+			return err
+		}
+		_, err := fmt.Fprint(w, chunk)
+		return err
+	}
+	data, err := ensureResponseInByteSlice(chunk)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,359 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/pb33f/ranch/bridge"
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/clock"
+	"github.com/pb33f/ranch/model"
+)
+
+const defaultBrokerReconnectDelay = 5 * time.Second
+
+// brokerBridge relays messages between local bus channels and destinations on an external STOMP broker.
+// Inbound traffic uses the bus galactic channel machinery, outbound requests are published by the bridge.
+// The connection is re-established whenever a publish to the broker fails, or a subscription of a bridged
+// channel closes with the connection.
+type brokerBridge struct {
+	config       *BrokerBridgeConfig
+	eventBus     bus.EventBus
+	logger       *slog.Logger
+	connectFn    func(config *bridge.BrokerConnectorConfig) (bridge.Connection, error)
+	onConnect    func(conn bridge.Connection) // called every time the bridge connects, if set
+	conn         bridge.Connection
+	handlers     []bus.MessageHandler
+	monitorId    bus.MonitorEventListenerId // reports subscriptions of bridged channels lost, when watching
+	watching     bool
+	stopChan     chan struct{}
+	stopped      bool
+	reconnecting bool
+	lock         sync.Mutex
+}
+
+func newBrokerBridge(config *BrokerBridgeConfig, eventBus bus.EventBus, logger *slog.Logger) *brokerBridge {
+	return &brokerBridge{
+		config:    config,
+		eventBus:  eventBus,
+		logger:    logger,
+		connectFn: eventBus.ConnectBroker,
+		stopChan:  make(chan struct{}),
+	}
+}
+
+// validateBrokerBridgeConfig checks a bridge configuration has everything needed to connect and relay.
+func validateBrokerBridgeConfig(config *BrokerBridgeConfig) error {
+	if err := validateBrokerConnection(config); err != nil {
+		return err
+	}
+	if len(config.Channels) == 0 && config.TokenRevocations == "" {
+		return fmt.Errorf("broker bridge '%s' has no channel mappings", config.Name)
+	}
+	for _, mapping := range config.Channels {
+		if mapping == nil || mapping.Channel == "" || mapping.Destination == "" {
+			return fmt.Errorf("broker bridge '%s' has a channel mapping without a channel or destination", config.Name)
+		}
+	}
+	return nil
+}
+
+// validateBrokerConnection checks a bridge configuration has everything needed to connect.
+func validateBrokerConnection(config *BrokerBridgeConfig) error {
+	if config == nil {
+		return fmt.Errorf("broker bridge config is nil")
+	}
+	if config.ServerAddr == "" {
+		return fmt.Errorf("broker bridge '%s' is missing a server address", config.Name)
+	}
+	if _, err := bridge.NewTransportAdapter(config.Transport); err != nil {
+		return fmt.Errorf("broker bridge '%s' cannot be used: %w", config.Name, err)
+	}
+	return nil
+}
+
+// connectorConfig converts the bridge configuration into a broker connector configuration.
+func (bb *brokerBridge) connectorConfig() *bridge.BrokerConnectorConfig {
+	cfg := &bridge.BrokerConnectorConfig{
+		Transport:  bb.config.Transport,
+		Username:   bb.config.Username,
+		Password:   bb.config.Password,
+		ServerAddr: bb.config.ServerAddr,
+		HostHeader: bb.config.HostHeader,
+		UseWS:      bb.config.UseWebSocket,
+	}
+	if bb.config.UseWebSocket || bb.config.UseTLS {
+		cfg.WebSocketConfig = &bridge.WebSocketConfig{
+			WSPath: bb.config.WebSocketPath,
+			UseTLS: bb.config.UseTLS,
+		}
+	}
+	return cfg
+}
+
+// mappings returns the channel mappings of the bridge, including the token revocation channel when
+// revocations are shared.
+func (bb *brokerBridge) mappings() []*BrokerChannelMapping {
+	if bb.config.TokenRevocations == "" {
+		return bb.config.Channels
+	}
+	return append(append([]*BrokerChannelMapping(nil), bb.config.Channels...), &BrokerChannelMapping{
+		Channel:     bus.TOKEN_REVOCATION_CHANNEL,
+		Destination: bb.config.TokenRevocations,
+	})
+}
+
+func (bb *brokerBridge) reconnectDelay() time.Duration {
+	if bb.config.ReconnectDelaySeconds > 0 {
+		return time.Duration(bb.config.ReconnectDelaySeconds) * time.Second
+	}
+	return defaultBrokerReconnectDelay
+}
+
+// start connects to the broker, then binds every mapped channel to its destinations.
+func (bb *brokerBridge) start() error {
+	if err := validateBrokerBridgeConfig(bb.config); err != nil {
+		return err
+	}
+	if err := bb.dial(); err != nil {
+		return err
+	}
+	if bb.isStopped() {
+		bb.disconnect()
+		return fmt.Errorf("broker bridge '%s' stopped", bb.config.Name)
+	}
+	cm := bb.eventBus.GetChannelManager()
+	for _, mapping := range bb.mappings() {
+		if !cm.CheckChannelExists(mapping.Channel) {
+			cm.CreateChannel(mapping.Channel)
+		}
+		handler, err := bb.eventBus.ListenRequestStream(mapping.Channel)
+		if err != nil {
+			return err
+		}
+		handler.Handle(bb.relay(mapping), func(err error) {
+			bb.logger.Error("[ranch] broker bridge request stream failed", "bridge", bb.config.Name,
+				"channel", mapping.Channel, "error", err.Error())
+		})
+		bb.lock.Lock()
+		if bb.stopped {
+			bb.lock.Unlock()
+			handler.Close()
+			return fmt.Errorf("broker bridge '%s' stopped", bb.config.Name)
+		}
+		bb.handlers = append(bb.handlers, handler)
+		bb.lock.Unlock()
+	}
+	bb.watchSubscriptions()
+	return bb.markGalactic()
+}
+
+// watchSubscriptions reconnects when a subscription of a bridged channel is lost. The broker can drop the
+// connection while nothing is published, only the inbound subscriptions notice.
+func (bb *brokerBridge) watchSubscriptions() {
+	channels := make(map[string]bool)
+	for _, mapping := range bb.mappings() {
+		channels[mapping.Channel] = true
+	}
+	id := bb.eventBus.AddMonitorEventListener(func(event *bus.MonitorEvent) {
+		if !channels[event.EntityName] {
+			return
+		}
+		bb.logger.Warn("[ranch] broker bridge lost its subscription, reconnecting", "bridge", bb.config.Name,
+			"channel", event.EntityName)
+		go bb.reconnect()
+	}, bus.BrokerSubscriptionLostEvt)
+
+	bb.lock.Lock()
+	if bb.stopped {
+		bb.lock.Unlock()
+		bb.eventBus.RemoveMonitorEventListener(id)
+		return
+	}
+	bb.monitorId = id
+	bb.watching = true
+	bb.lock.Unlock()
+}
+
+// stop unbinds all channels and disconnects from the broker. A stopped bridge cannot be restarted.
+func (bb *brokerBridge) stop() {
+	bb.lock.Lock()
+	if bb.stopped {
+		bb.lock.Unlock()
+		return
+	}
+	bb.stopped = true
+	close(bb.stopChan)
+	handlers := bb.handlers
+	bb.handlers = nil
+	watching := bb.watching
+	bb.watching = false
+	bb.lock.Unlock()
+
+	if watching {
+		bb.eventBus.RemoveMonitorEventListener(bb.monitorId)
+	}
+	for _, handler := range handlers {
+		handler.Close()
+	}
+	bb.markLocal()
+	bb.disconnect()
+}
+
+func (bb *brokerBridge) isStopped() bool {
+	bb.lock.Lock()
+	defer bb.lock.Unlock()
+	return bb.stopped
+}
+
+// isConnected reports whether the bridge currently holds a connection to the broker.
+func (bb *brokerBridge) isConnected() bool {
+	bb.lock.Lock()
+	defer bb.lock.Unlock()
+	return bb.conn != nil && !bb.reconnecting
+}
+
+// dial connects to the broker, retrying until it succeeds, the bridge is stopped or the
+// maximum number of attempts is reached.
+func (bb *brokerBridge) dial() error {
+	attempts := 0
+	for {
+		conn, err := bb.connectFn(bb.connectorConfig())
+		if err == nil {
+			bb.lock.Lock()
+			bb.conn = conn
+			bb.lock.Unlock()
+			bb.logger.Info("[ranch] connected to external broker", "bridge", bb.config.Name,
+				"address", bb.config.ServerAddr)
+			if bb.onConnect != nil {
+				bb.onConnect(conn)
+			}
+			return nil
+		}
+		attempts++
+		if bb.config.MaxReconnectAttempts > 0 && attempts >= bb.config.MaxReconnectAttempts {
+			return fmt.Errorf("unable to connect to broker '%s' after %d attempts: %w",
+				bb.config.Name, attempts, err)
+		}
+		bb.logger.Warn("[ranch] unable to connect to external broker, retrying", "bridge", bb.config.Name,
+			"address", bb.config.ServerAddr, "attempt", attempts, "error", err.Error())
+		select {
+		case <-bb.stopChan:
+			return fmt.Errorf("broker bridge '%s' stopped", bb.config.Name)
+		case <-clock.After(bb.reconnectDelay()):
+		}
+	}
+}
+
+// connection returns the current connection to the broker, nil if not connected.
+func (bb *brokerBridge) connection() bridge.Connection {
+	bb.lock.Lock()
+	defer bb.lock.Unlock()
+	return bb.conn
+}
+
+func (bb *brokerBridge) disconnect() {
+	bb.lock.Lock()
+	conn := bb.conn
+	bb.conn = nil
+	bb.lock.Unlock()
+	if conn != nil {
+		_ = conn.Disconnect()
+	}
+}
+
+func (bb *brokerBridge) markGalactic() error {
+	bb.lock.Lock()
+	conn := bb.conn
+	bb.lock.Unlock()
+	cm := bb.eventBus.GetChannelManager()
+	for _, mapping := range bb.mappings() {
+		if err := cm.MarkChannelAsGalactic(mapping.Channel, mapping.Destination, conn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (bb *brokerBridge) markLocal() {
+	cm := bb.eventBus.GetChannelManager()
+	for _, mapping := range bb.mappings() {
+		_ = cm.MarkChannelAsLocal(mapping.Channel)
+	}
+}
+
+// reconnect drops the current connection and dials the broker again, re-binding the galactic channels
+// once connected. Only one reconnect runs at a time.
+func (bb *brokerBridge) reconnect() {
+	bb.lock.Lock()
+	if bb.reconnecting || bb.stopped {
+		bb.lock.Unlock()
+		return
+	}
+	bb.reconnecting = true
+	bb.lock.Unlock()
+
+	defer func() {
+		bb.lock.Lock()
+		bb.reconnecting = false
+		bb.lock.Unlock()
+	}()
+
+	bb.markLocal()
+	bb.disconnect()
+	if err := bb.dial(); err != nil {
+		bb.logger.Error("[ranch] broker bridge giving up", "bridge", bb.config.Name, "error", err.Error())
+		return
+	}
+	if err := bb.markGalactic(); err != nil {
+		bb.logger.Error("[ranch] broker bridge unable to re-bind channels", "bridge", bb.config.Name,
+			"error", err.Error())
+	}
+}
+
+// relay returns a handler that publishes request messages from a local channel to the broker.
+func (bb *brokerBridge) relay(mapping *BrokerChannelMapping) bus.MessageHandlerFunction {
+	destination := mapping.PublishDestination
+	if destination == "" {
+		destination = mapping.Destination
+	}
+	return func(msg *model.Message) {
+		payload, err := encodeBrokerPayload(msg.Payload)
+		if err != nil {
+			bb.logger.Error("[ranch] broker bridge unable to encode payload", "bridge", bb.config.Name,
+				"channel", mapping.Channel, "error", err.Error())
+			return
+		}
+
+		bb.lock.Lock()
+		conn := bb.conn
+		bb.lock.Unlock()
+		if conn == nil {
+			bb.logger.Warn("[ranch] broker bridge not connected, dropping message", "bridge", bb.config.Name,
+				"channel", mapping.Channel)
+			return
+		}
+		if err = conn.SendJSONMessage(destination, payload); err != nil {
+			bb.logger.Error("[ranch] broker bridge unable to publish, reconnecting", "bridge", bb.config.Name,
+				"destination", destination, "error", err.Error())
+			go bb.reconnect()
+		}
+	}
+}
+
+func encodeBrokerPayload(payload interface{}) ([]byte, error) {
+	switch p := payload.(type) {
+	case []byte:
+		return p, nil
+	case string:
+		return []byte(p), nil
+	default:
+		return json.Marshal(p)
+	}
+}
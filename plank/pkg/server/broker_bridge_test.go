@@ -0,0 +1,234 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/google/uuid"
+	"github.com/pb33f/ranch/bridge"
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/model"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeBrokerSubscription struct {
+	id          *uuid.UUID
+	destination string
+	c           chan *model.Message
+	once        sync.Once
+}
+
+func (s *fakeBrokerSubscription) GetId() *uuid.UUID                  { return s.id }
+func (s *fakeBrokerSubscription) GetMsgChannel() chan *model.Message { return s.c }
+func (s *fakeBrokerSubscription) GetDestination() string             { return s.destination }
+func (s *fakeBrokerSubscription) Unsubscribe() error {
+	s.once.Do(func() { close(s.c) })
+	return nil
+}
+
+type fakeBrokerMessage struct {
+	destination string
+	payload     []byte
+}
+
+type fakeBrokerConnection struct {
+	id      *uuid.UUID
+	sendErr error
+	subs    map[string]*fakeBrokerSubscription
+	sent    chan fakeBrokerMessage
+	lock    sync.Mutex
+}
+
+func newFakeBrokerConnection() *fakeBrokerConnection {
+	id := uuid.New()
+	return &fakeBrokerConnection{
+		id:   &id,
+		subs: make(map[string]*fakeBrokerSubscription),
+		sent: make(chan fakeBrokerMessage, 10),
+	}
+}
+
+func (c *fakeBrokerConnection) GetId() *uuid.UUID { return c.id }
+func (c *fakeBrokerConnection) Subscribe(destination string) (bridge.Subscription, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	id := uuid.New()
+	sub := &fakeBrokerSubscription{id: &id, destination: destination, c: make(chan *model.Message)}
+	c.subs[destination] = sub
+	return sub, nil
+}
+func (c *fakeBrokerConnection) SubscribeReplyDestination(destination string) (bridge.Subscription, error) {
+	return c.Subscribe(destination)
+}
+func (c *fakeBrokerConnection) Disconnect() error { return nil }
+func (c *fakeBrokerConnection) SendJSONMessage(destination string, payload []byte, _ ...func(*frame.Frame) error) error {
+	if c.sendErr != nil {
+		return c.sendErr
+	}
+	c.sent <- fakeBrokerMessage{destination: destination, payload: payload}
+	return nil
+}
+func (c *fakeBrokerConnection) SendMessage(destination, _ string, payload []byte, opts ...func(*frame.Frame) error) error {
+	return c.SendJSONMessage(destination, payload, opts...)
+}
+func (c *fakeBrokerConnection) SendMessageWithReplyDestination(destination, _, _ string, payload []byte, opts ...func(*frame.Frame) error) error {
+	return c.SendJSONMessage(destination, payload, opts...)
+}
+func (c *fakeBrokerConnection) Conversation(destination string, payload []byte, opts ...func(*frame.Frame) error) (bridge.Subscription, error) {
+	return nil, fmt.Errorf("not supported")
+}
+func (c *fakeBrokerConnection) RequestResponse(ctx context.Context, payload []byte, opts ...func(*frame.Frame) error) (*model.Message, error) {
+	return nil, fmt.Errorf("not supported")
+}
+
+func (c *fakeBrokerConnection) getSub(destination string) *fakeBrokerSubscription {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.subs[destination]
+}
+
+func newTestBrokerBridge(b bus.EventBus, conns ...*fakeBrokerConnection) (*brokerBridge, *int) {
+	config := &BrokerBridgeConfig{
+		Name:                  "test",
+		ServerAddr:            "localhost:61613",
+		ReconnectDelaySeconds: 1,
+		Channels: []*BrokerChannelMapping{
+			{Channel: "bridged-channel", Destination: "/topic/inbound", PublishDestination: "/queue/outbound"},
+		},
+	}
+	bb := newBrokerBridge(config, b, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	dialCount := 0
+	bb.connectFn = func(config *bridge.BrokerConnectorConfig) (bridge.Connection, error) {
+		dialCount++
+		if dialCount > len(conns) {
+			return nil, fmt.Errorf("broker unavailable")
+		}
+		return conns[dialCount-1], nil
+	}
+	return bb, &dialCount
+}
+
+func TestValidateBrokerBridgeConfig(t *testing.T) {
+	assert.Error(t, validateBrokerBridgeConfig(nil))
+	assert.Error(t, validateBrokerBridgeConfig(&BrokerBridgeConfig{Name: "a"}))
+	assert.Error(t, validateBrokerBridgeConfig(&BrokerBridgeConfig{Name: "a", ServerAddr: "localhost:61613"}))
+	assert.Error(t, validateBrokerBridgeConfig(&BrokerBridgeConfig{
+		Name: "a", ServerAddr: "localhost:61613", Channels: []*BrokerChannelMapping{{Channel: "c"}}}))
+	assert.NoError(t, validateBrokerBridgeConfig(&BrokerBridgeConfig{
+		Name: "a", ServerAddr: "localhost:61613", Channels: []*BrokerChannelMapping{{Channel: "c", Destination: "/topic/c"}}}))
+	assert.NoError(t, validateBrokerBridgeConfig(&BrokerBridgeConfig{Name: "a", Transport: bridge.TransportRedis,
+		ServerAddr: "localhost:6379", Channels: []*BrokerChannelMapping{{Channel: "c", Destination: "c"}}}))
+	assert.ErrorContains(t, validateBrokerBridgeConfig(&BrokerBridgeConfig{Name: "a", Transport: "smoke-signals",
+		ServerAddr: "localhost:6379", Channels: []*BrokerChannelMapping{{Channel: "c", Destination: "c"}}}),
+		"unknown transport")
+}
+
+func TestBrokerBridge_RelaysBothWays(t *testing.T) {
+	b := bus.ResetBus()
+	conn := newFakeBrokerConnection()
+	bb, _ := newTestBrokerBridge(b, conn)
+	assert.NoError(t, bb.start())
+	defer bb.stop()
+
+	ch, _ := b.GetChannelManager().GetChannel("bridged-channel")
+	assert.True(t, ch.IsGalactic())
+
+	// inbound: broker messages arrive on the channel as responses.
+	received := make(chan *model.Message, 1)
+	handler, _ := b.ListenStream("bridged-channel")
+	handler.Handle(func(msg *model.Message) {
+		received <- msg
+	}, func(err error) {})
+
+	sub := conn.getSub("/topic/inbound")
+	assert.NotNil(t, sub)
+	sub.c <- model.GenerateResponse(&model.MessageConfig{Payload: []byte("from-broker")})
+	select {
+	case msg := <-received:
+		assert.Equal(t, []byte("from-broker"), msg.Payload)
+	case <-time.After(time.Second):
+		t.Fatal("inbound message was not relayed")
+	}
+
+	// outbound: requests on the channel are published to the broker.
+	_ = b.SendRequestMessage("bridged-channel", map[string]string{"hello": "broker"}, nil)
+	select {
+	case msg := <-conn.sent:
+		assert.Equal(t, "/queue/outbound", msg.destination)
+		assert.JSONEq(t, `{"hello":"broker"}`, string(msg.payload))
+	case <-time.After(time.Second):
+		t.Fatal("outbound message was not relayed")
+	}
+}
+
+func TestBrokerBridge_ReconnectsOnPublishFailure(t *testing.T) {
+	b := bus.ResetBus()
+	broken := newFakeBrokerConnection()
+	broken.sendErr = fmt.Errorf("connection lost")
+	healthy := newFakeBrokerConnection()
+	bb, dialCount := newTestBrokerBridge(b, broken, healthy)
+	assert.NoError(t, bb.start())
+	defer bb.stop()
+
+	_ = b.SendRequestMessage("bridged-channel", "lost", nil)
+	assert.Eventually(t, func() bool {
+		return healthy.getSub("/topic/inbound") != nil
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 2, *dialCount)
+
+	_ = b.SendRequestMessage("bridged-channel", "delivered", nil)
+	select {
+	case msg := <-healthy.sent:
+		assert.Equal(t, "delivered", string(msg.payload))
+	case <-time.After(time.Second):
+		t.Fatal("message was not published after reconnecting")
+	}
+}
+
+func TestBrokerBridge_ReconnectsOnLostSubscription(t *testing.T) {
+	b := bus.ResetBus()
+	dropped := newFakeBrokerConnection()
+	healthy := newFakeBrokerConnection()
+	bb, dialCount := newTestBrokerBridge(b, dropped, healthy)
+	assert.NoError(t, bb.start())
+	defer bb.stop()
+
+	// the broker goes away while nothing is published, closing the inbound subscription
+	sub := dropped.getSub("/topic/inbound")
+	sub.once.Do(func() { close(sub.c) })
+	assert.Eventually(t, func() bool {
+		return healthy.getSub("/topic/inbound") != nil
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 2, *dialCount)
+
+	received := make(chan *model.Message, 1)
+	handler, _ := b.ListenStream("bridged-channel")
+	handler.Handle(func(msg *model.Message) {
+		received <- msg
+	}, func(err error) {})
+	healthy.getSub("/topic/inbound").c <- model.GenerateResponse(&model.MessageConfig{Payload: []byte("back")})
+	select {
+	case msg := <-received:
+		assert.Equal(t, []byte("back"), msg.Payload)
+	case <-time.After(time.Second):
+		t.Fatal("inbound message was not relayed after reconnecting")
+	}
+}
+
+func TestBrokerBridge_GivesUpAfterMaxAttempts(t *testing.T) {
+	b := bus.ResetBus()
+	bb, dialCount := newTestBrokerBridge(b)
+	bb.config.ReconnectDelaySeconds = 0
+	bb.config.MaxReconnectAttempts = 1
+	assert.Error(t, bb.start())
+	assert.Equal(t, 1, *dialCount)
+}
@@ -0,0 +1,145 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/pb33f/ranch/log"
+	"github.com/pb33f/ranch/plank/pkg/classify"
+	"github.com/pb33f/ranch/stompserver"
+)
+
+// classificationState classifies the traffic, enforces the policies of its classes and counts it.
+type classificationState struct {
+	engine   *classify.Engine
+	policies *classify.Policies
+	counter  *classify.Counter
+}
+
+// newClassificationState builds the classifiers and policies of the configuration.
+func newClassificationState(cfg *ClassificationConfig) (*classificationState, error) {
+	var classifiers []classify.Classifier
+	if len(cfg.Rules) > 0 {
+		rules, err := classify.NewRuleClassifier(cfg.Rules)
+		if err != nil {
+			return nil, err
+		}
+		classifiers = append(classifiers, rules)
+	}
+	classifiers = append(classifiers, cfg.Classifiers...)
+	if cfg.ClientKind {
+		classifiers = append(classifiers, classify.ClientKind)
+	}
+	policies, err := classify.NewPolicies(cfg.Policies)
+	if err != nil {
+		return nil, err
+	}
+	return &classificationState{
+		engine:   classify.New(classifiers...),
+		policies: policies,
+		counter:  classify.NewCounter(),
+	}, nil
+}
+
+// initClassification sets up the classification of the traffic and registers the traffic counts endpoint,
+// if classification is configured.
+func (ps *platformServer) initClassification() {
+	cfg := ps.serverConfig.Classification
+	if cfg == nil {
+		return
+	}
+	state, err := newClassificationState(cfg)
+	if err != nil {
+		ps.serverConfig.Logger.Error(wrapError(errServerInit, err).Error())
+		return
+	}
+	ps.classification = state
+	if cfg.Endpoint == "" {
+		return
+	}
+	ps.router.Path(cfg.Endpoint).Name(cfg.Endpoint).Methods(http.MethodGet).HandlerFunc(
+		ps.adminHandler("traffic classes", cfg.Authorize, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "no-store")
+			_ = json.NewEncoder(w).Encode(ps.TrafficClasses())
+		}))
+	ps.serverConfig.Logger.Info("[ranch] traffic classes endpoint enabled", "endpoint", cfg.Endpoint)
+}
+
+// classificationMiddleware labels every HTTP request, turns away those the policies of their classes do not
+// admit and counts them. The labels are added to the context of the request, and to its logger if it has one.
+func (ps *platformServer) classificationMiddleware(next http.Handler) http.Handler {
+	cfg, state := ps.serverConfig.Classification, ps.classification
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal := ps.httpPrincipal(r)
+		in := &classify.Input{Header: r.Header, Principal: principal}
+		if cfg.Claims != nil {
+			in.Claims = cfg.Claims(r)
+		}
+		labels := state.engine.Classify(in)
+
+		client := principal
+		if client == "" {
+			client = remoteHost(r)
+		}
+		release, rejection, retryAfter := state.policies.Admit(labels, client)
+		state.counter.Count(labels, rejection != classify.Admitted)
+		switch rejection {
+		case classify.RateLimited:
+			w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(retryAfter.Seconds()))))
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		case classify.Overloaded:
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		defer release()
+
+		ctx := classify.NewContext(r.Context(), labels)
+		if log.HasLogger(ctx) && len(labels) > 0 {
+			ctx = log.With(ctx, log.LabelsKey, map[string]string(labels))
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// fabricLabels labels fabric clients from the headers of their CONNECT frame, and counts them.
+func (ps *platformServer) fabricLabels(conn stompserver.StompConn, connect *frame.Frame) map[string]string {
+	cfg, state := ps.serverConfig.Classification, ps.classification
+	header := make(http.Header, connect.Header.Len())
+	for i := 0; i < connect.Header.Len(); i++ {
+		key, value := connect.Header.GetAt(i)
+		header.Add(key, value)
+	}
+	in := &classify.Input{Header: header, Principal: conn.GetPrincipal()}
+	if cfg.FabricClaims != nil {
+		in.Claims = cfg.FabricClaims(conn)
+	}
+	labels := state.engine.Classify(in)
+	state.counter.Count(labels, false)
+	return labels
+}
+
+// TrafficClasses returns the HTTP requests and fabric clients counted by label value since the server
+// started, nil if traffic is not classified.
+func (ps *platformServer) TrafficClasses() []*classify.ClassStats {
+	if ps.classification == nil {
+		return nil
+	}
+	return ps.classification.counter.Stats()
+}
+
+// remoteHost returns the address of the client of a request, without its port.
+func remoteHost(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
@@ -0,0 +1,132 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/gorilla/mux"
+	"github.com/pb33f/ranch/log"
+	"github.com/pb33f/ranch/plank/pkg/classify"
+	"github.com/pb33f/ranch/stompserver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestClassification(t *testing.T, config *ClassificationConfig, logs *bytes.Buffer) *platformServer {
+	ps := &platformServer{router: mux.NewRouter(), serverConfig: &PlatformServerConfig{Classification: config,
+		Logger: slog.New(slog.NewTextHandler(logs, nil))}}
+	ps.initClassification()
+	require.NotNil(t, ps.classification)
+	return ps
+}
+
+func TestClassification_HttpRequests(t *testing.T) {
+	var logs bytes.Buffer
+	ps := newTestClassification(t, &ClassificationConfig{
+		Rules: []*classify.Rule{
+			{Label: "tier", Claim: "plan"},
+			{Label: "tier", Value: "free"},
+		},
+		ClientKind: true,
+		Claims: func(r *http.Request) map[string]interface{} {
+			if r.Header.Get("Authorization") == "Bearer gold" {
+				return map[string]interface{}{"plan": "gold"}
+			}
+			return nil
+		},
+		Policies: []*classify.Policy{{Match: "client=bot", RequestsPerSecond: 1}},
+		Endpoint: "/ranch/classes",
+	}, &logs)
+
+	var seen classify.Labels
+	handler := ps.classificationMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = classify.FromContext(r.Context())
+		log.FromContext(r.Context()).Info("moo")
+	}))
+	serve := func(agent, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/cows", nil)
+		r.Header.Set("User-Agent", agent)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		// request loggers carry the labels
+		r = r.WithContext(log.NewContext(r.Context(), ps.serverConfig.Logger))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	// requests are labeled
+	assert.Equal(t, http.StatusOK, serve("Mozilla/5.0", "gold").Code)
+	assert.Equal(t, classify.Labels{"tier": "gold", "client": classify.ClientBrowser}, seen)
+	assert.Contains(t, logs.String(), `labels="map[client:browser tier:gold]"`)
+
+	// and policies target their classes
+	assert.Equal(t, http.StatusOK, serve("Googlebot/2.1", "").Code)
+	w := serve("Googlebot/2.1", "")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusOK, serve("Mozilla/5.0", "").Code)
+
+	// the traffic of every class is counted
+	assert.Equal(t, []*classify.ClassStats{
+		{Label: "client", Value: classify.ClientBot, Requests: 2, Rejected: 1},
+		{Label: "client", Value: classify.ClientBrowser, Requests: 2},
+		{Label: "tier", Value: "free", Requests: 3, Rejected: 1},
+		{Label: "tier", Value: "gold", Requests: 1},
+	}, ps.TrafficClasses())
+
+	r := httptest.NewRequest(http.MethodGet, "/ranch/classes", nil)
+	r.RemoteAddr = "127.0.0.1:50000"
+	w = httptest.NewRecorder()
+	ps.router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var stats []*classify.ClassStats
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	assert.Len(t, stats, 4)
+}
+
+type classifiedTestConn struct {
+	stompserver.StompConn
+	principal string
+}
+
+func (c *classifiedTestConn) GetPrincipal() string { return c.principal }
+
+func TestClassification_FabricClients(t *testing.T) {
+	var logs bytes.Buffer
+	ps := newTestClassification(t, &ClassificationConfig{
+		Rules: []*classify.Rule{{Label: "version", Header: "api-version"}},
+		FabricClaims: func(conn stompserver.StompConn) map[string]interface{} {
+			return map[string]interface{}{"plan": "gold"}
+		},
+		Classifiers: []classify.Classifier{classify.ClassifierFunc(func(in *classify.Input, labels classify.Labels) {
+			labels["tier"] = in.Claims["plan"].(string)
+			labels["principal"] = in.Principal
+		})},
+	}, &logs)
+
+	labels := ps.fabricLabels(&classifiedTestConn{principal: "backend"},
+		frame.New(frame.CONNECT, "api-version", "v2"))
+	assert.Equal(t, map[string]string{"version": "v2", "tier": "gold", "principal": "backend"}, labels)
+	assert.Len(t, ps.TrafficClasses(), 3)
+}
+
+func TestClassification_Invalid(t *testing.T) {
+	var logs bytes.Buffer
+	ps := &platformServer{router: mux.NewRouter(), serverConfig: &PlatformServerConfig{
+		Classification: &ClassificationConfig{Policies: []*classify.Policy{{Match: "client"}}},
+		Logger:         slog.New(slog.NewTextHandler(&logs, nil))}}
+	ps.initClassification()
+	assert.Nil(t, ps.classification)
+	assert.Nil(t, ps.TrafficClasses())
+	assert.Contains(t, logs.String(), "invalid selector")
+}
@@ -0,0 +1,61 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// notModified tags the body of a successful GET or HEAD response of a REST bridge with a strong ETag, unless
+// its service set one, and tells whether the request is conditional on a representation the client has
+// already: one of the ETags of its If-None-Match, or one not modified since its If-Modified-Since if the
+// service set the Last-Modified header. The response is then answered with 304 rather than the body.
+func notModified(w http.ResponseWriter, r *http.Request, status int, body []byte) bool {
+	if (r.Method != http.MethodGet && r.Method != http.MethodHead) || (status != 0 && status != http.StatusOK) {
+		return false
+	}
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		sum := sha256.Sum256(body)
+		etag = `"` + hex.EncodeToString(sum[:16]) + `"`
+	} else if !strings.HasPrefix(etag, `"`) && !strings.HasPrefix(etag, `W/"`) {
+		etag = `"` + etag + `"`
+	}
+	w.Header().Set("ETag", etag)
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatches(inm, etag)
+	}
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lastModified, err := http.ParseTime(w.Header().Get("Last-Modified"))
+	return err == nil && !lastModified.After(ims)
+}
+
+// etagMatches tells whether the ETags of an If-None-Match header match an ETag, comparing them weakly.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// writeNotModified answers a conditional request with 304, without the headers describing the body.
+func writeNotModified(w http.ResponseWriter) {
+	for _, header := range []string{"Content-Type", "Content-Length", "Content-Encoding", "Transfer-Encoding"} {
+		w.Header().Del(header)
+	}
+	w.WriteHeader(http.StatusNotModified)
+}
@@ -0,0 +1,93 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/model"
+	"github.com/pb33f/ranch/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildEndpointHandler_ConditionalRequests(t *testing.T) {
+	b := bus.ResetBus()
+	service.ResetServiceRegistry()
+	_ = b.GetChannelManager().CreateChannel("test-chan")
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", GetTestPort(), true)
+	config.ETags = true
+	ps := NewPlatformServer(config).(*platformServer)
+	ps.eventbus = b
+	bridge := ps.bridgeFor("test-chan")
+	replies, _ := answerRequests(t, b, bridge, "test-chan")
+	handler := ps.buildEndpointHandler("test-chan", func(w http.ResponseWriter, r *http.Request) model.Request {
+		return model.Request{RequestCommand: "test-request"}
+	}, time.Second, bridge, requestLimits{})
+	serve := func(method string, headers map[string]string, rsp *model.Response) *httptest.ResponseRecorder {
+		replies <- []*model.Message{{Payload: rsp}}
+		req := httptest.NewRequest(method, "http://localhost", nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+	herd := func() *model.Response {
+		return &model.Response{Payload: []string{"daisy", "bessie"}, Marshal: true,
+			Headers: map[string]interface{}{"Content-Type": "application/json"}}
+	}
+
+	// responses are tagged with a strong ETag of their body
+	rec := serve(http.MethodGet, nil, herd())
+	assert.Equal(t, http.StatusOK, rec.Code)
+	etag := rec.Header().Get("ETag")
+	assert.Regexp(t, `^"[0-9a-f]{32}"$`, etag)
+	assert.Equal(t, `["daisy","bessie"]`, rec.Body.String())
+
+	// clients polling with it get 304 until the body changes
+	rec = serve(http.MethodGet, map[string]string{"If-None-Match": `"stale", ` + etag}, herd())
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+	assert.Equal(t, etag, rec.Header().Get("ETag"))
+	assert.Empty(t, rec.Header().Get("Content-Type"))
+	assert.Empty(t, rec.Body.String())
+	rec = serve(http.MethodGet, map[string]string{"If-None-Match": "W/" + etag}, herd())
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+	rec = serve(http.MethodGet, map[string]string{"If-None-Match": etag},
+		&model.Response{Payload: []string{"daisy"}, Marshal: true})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotEqual(t, etag, rec.Header().Get("ETag"))
+
+	// only GET and HEAD requests answered successfully are conditional
+	assert.Equal(t, http.StatusOK, serve(http.MethodPost, map[string]string{"If-None-Match": etag}, herd()).Code)
+	rsp := herd()
+	rsp.HttpStatusCode = http.StatusAccepted
+	assert.Equal(t, http.StatusAccepted, serve(http.MethodGet, map[string]string{"If-None-Match": etag}, rsp).Code)
+
+	// services set their own ETags and Last-Modified
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	versioned := func() *model.Response {
+		rsp := herd()
+		rsp.Headers["ETag"] = "v42"
+		rsp.Headers["Last-Modified"] = modified.Format(http.TimeFormat)
+		return rsp
+	}
+	rec = serve(http.MethodGet, nil, versioned())
+	assert.Equal(t, `"v42"`, rec.Header().Get("ETag"))
+	assert.Equal(t, http.StatusNotModified, serve(http.MethodGet, map[string]string{"If-None-Match": `"v42"`},
+		versioned()).Code)
+	assert.Equal(t, http.StatusNotModified, serve(http.MethodHead,
+		map[string]string{"If-Modified-Since": modified.Format(http.TimeFormat)}, versioned()).Code)
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet,
+		map[string]string{"If-Modified-Since": modified.Add(-time.Second).Format(http.TimeFormat)}, versioned()).Code)
+
+	// If-None-Match takes over from If-Modified-Since
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, map[string]string{"If-None-Match": `"v41"`,
+		"If-Modified-Since": modified.Format(http.TimeFormat)}, versioned()).Code)
+}
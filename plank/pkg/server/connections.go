@@ -0,0 +1,93 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/pb33f/ranch/clock"
+	"github.com/pb33f/ranch/stompserver"
+)
+
+// FabricConnections is the inventory of the connections of the fabric broker, for dashboards.
+type FabricConnections struct {
+	Connections   []*stompserver.ConnectionInfo `json:"connections"`
+	Subscriptions int                           `json:"subscriptions"` // subscriptions of every connection
+	Timestamp     time.Time                     `json:"timestamp"`
+}
+
+// FabricConnections returns the connections of the fabric broker and their subscriptions, none if the broker
+// is not running.
+func (ps *platformServer) FabricConnections() *FabricConnections {
+	inventory := &FabricConnections{
+		Connections: ps.eventbus.GetFabricConnections(),
+		Timestamp:   clock.Now().UTC(),
+	}
+	if inventory.Connections == nil {
+		inventory.Connections = make([]*stompserver.ConnectionInfo, 0)
+	}
+	for _, c := range inventory.Connections {
+		inventory.Subscriptions += len(c.Subscriptions)
+	}
+	return inventory
+}
+
+// setConnectionsRoute registers the endpoint the fabric connection inventory is served at, if one is
+// configured.
+func (ps *platformServer) setConnectionsRoute() {
+	cfg := ps.serverConfig.Connections
+	if cfg == nil || cfg.Endpoint == "" {
+		return
+	}
+	ps.router.Path(cfg.Endpoint).Name(cfg.Endpoint).Methods(http.MethodGet).HandlerFunc(ps.adminHandler(
+		"fabric connections", cfg.Authorize, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "no-store")
+			_ = json.NewEncoder(w).Encode(ps.FabricConnections())
+		}))
+	ps.serverConfig.Logger.Info("[ranch] fabric connections endpoint enabled", "endpoint", cfg.Endpoint)
+}
+
+// startConnectionsPublishing publishes the fabric connection inventory on RANCH_FABRIC_CONNECTIONS_CHANNEL on
+// the configured interval, until the server stops.
+func (ps *platformServer) startConnectionsPublishing() {
+	cfg := ps.serverConfig.Connections
+	if cfg == nil || cfg.PublishIntervalSeconds <= 0 || ps.serverConfig.FabricConfig == nil {
+		return
+	}
+	ps.eventbus.GetChannelManager().CreateChannel(RANCH_FABRIC_CONNECTIONS_CHANNEL)
+	stop := make(chan struct{})
+	ps.lock.Lock()
+	ps.connectionsStop = stop
+	ps.lock.Unlock()
+
+	interval := time.Duration(cfg.PublishIntervalSeconds) * time.Second
+	ps.serverConfig.Logger.Info("[ranch] publishing fabric connections", "channel", RANCH_FABRIC_CONNECTIONS_CHANNEL,
+		"interval", interval.String())
+	go func() {
+		ticker := clock.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C():
+				_ = ps.eventbus.SendResponseMessage(RANCH_FABRIC_CONNECTIONS_CHANNEL, ps.FabricConnections(), nil)
+			}
+		}
+	}()
+}
+
+// stopConnectionsPublishing stops publishing the fabric connection inventory.
+func (ps *platformServer) stopConnectionsPublishing() {
+	ps.lock.Lock()
+	stop := ps.connectionsStop
+	ps.connectionsStop = nil
+	ps.lock.Unlock()
+	if stop != nil {
+		close(stop)
+	}
+}
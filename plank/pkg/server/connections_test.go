@@ -0,0 +1,50 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/pb33f/ranch/bus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlatformServer_FabricConnections(t *testing.T) {
+	ps := &platformServer{
+		eventbus: bus.NewEventBusInstance(),
+		router:   mux.NewRouter(),
+		serverConfig: &PlatformServerConfig{
+			Logger:      slog.Default(),
+			Connections: &ConnectionsConfig{Endpoint: "/ranch/connections"},
+		},
+	}
+	ps.setConnectionsRoute()
+
+	// no broker is running, so there is nothing to list
+	r := httptest.NewRequest(http.MethodGet, "/ranch/connections", nil)
+	r.RemoteAddr = "127.0.0.1:50000"
+	w := httptest.NewRecorder()
+	ps.router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+
+	var inventory FabricConnections
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &inventory))
+	assert.NotNil(t, inventory.Connections)
+	assert.Empty(t, inventory.Connections)
+	assert.Zero(t, inventory.Subscriptions)
+	assert.False(t, inventory.Timestamp.IsZero())
+
+	// the inventory names principals and addresses, so only local clients may read it by default
+	r = httptest.NewRequest(http.MethodGet, "/ranch/connections", nil)
+	r.RemoteAddr = "203.0.113.9:50000"
+	w = httptest.NewRecorder()
+	ps.router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
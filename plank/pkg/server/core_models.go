@@ -4,17 +4,36 @@
 package server
 
 import (
+    "context"
     "crypto/tls"
+    "github.com/google/uuid"
     "github.com/gorilla/mux"
     "github.com/pb33f/ranch/bus"
-    "github.com/pb33f/ranch/model"
+    "github.com/pb33f/ranch/log"
+    "github.com/pb33f/ranch/plank/pkg/abuse"
+    "github.com/pb33f/ranch/plank/pkg/acl"
+    "github.com/pb33f/ranch/plank/pkg/archive"
+    "github.com/pb33f/ranch/plank/pkg/classify"
+    "github.com/pb33f/ranch/plank/pkg/diagnostics"
+    "github.com/pb33f/ranch/plank/pkg/edgecache"
+    "github.com/pb33f/ranch/plank/pkg/grpcbridge"
+    "github.com/pb33f/ranch/plank/pkg/metrics"
     "github.com/pb33f/ranch/plank/pkg/middleware"
+    "github.com/pb33f/ranch/plank/pkg/redact"
+    "github.com/pb33f/ranch/plank/pkg/replication"
+    "github.com/pb33f/ranch/plank/pkg/responsecache"
+    "github.com/pb33f/ranch/plank/pkg/serializer"
+    "github.com/pb33f/ranch/plank/pkg/websub"
+    "github.com/pb33f/ranch/plank/pkg/settings"
+    "github.com/pb33f/ranch/plank/pkg/siem"
     "log/slog"
 
     "github.com/pb33f/ranch/service"
     "github.com/pb33f/ranch/stompserver"
+    "github.com/quic-go/quic-go/http3"
     "golang.org/x/net/http2"
     "io"
+    "io/fs"
     "net/http"
     "os"
     "sync"
@@ -23,55 +42,709 @@ import (
 
 // PlatformServerConfig holds all the core configuration needed for the functionality of Plank
 type PlatformServerConfig struct {
-    RootDir            string              `json:"root_dir"`                       // root directory the server should base itself on
-    StaticDir          []string            `json:"static_dir"`                     // static content folders that HTTP server should serve
-    SpaConfig          *SpaConfig          `json:"spa_config"`                     // single page application configuration
-    Host               string              `json:"host"`                           // hostname for the server
-    Port               int                 `json:"port"`                           // port for the server
-    Logger             *slog.Logger        `json:"-"`                              // logger instance
-    FabricConfig       *FabricBrokerConfig `json:"fabric_config"`                  // Fabric (websocket) configuration
-    TLSCertConfig      *TLSCertConfig      `json:"tls_config"`                     // TLS certificate configuration
-    Debug              bool                `json:"debug"`                          // enable debug logging
-    NoBanner           bool                `json:"no_banner"`                      // start server without displaying the banner
-    ShutdownTimeout    time.Duration       `json:"shutdown_timeout_in_minutes"`    // graceful server shutdown timeout in minutes
-    RestBridgeTimeout  time.Duration       `json:"rest_bridge_timeout_in_minutes"` // rest bridge timeout in minutes
-    SocketCreationFunc http.HandlerFunc    `json:"-"`                              // override default websocket creation code.
+    RootDir            string                   `json:"root_dir"`                       // root directory the server should base itself on
+    StaticDir          []string                 `json:"static_dir"`                     // static content folders that HTTP server should serve
+    SpaConfig          *SpaConfig               `json:"spa_config"`                     // single page application configuration
+    Host               string                   `json:"host"`                           // hostname for the server
+    Port               int                      `json:"port"`                           // port for the server
+    Logger             *slog.Logger             `json:"-"`                              // logger instance
+    LogAdapter         log.Adapter              `json:"-"`                              // zerolog, zap or any other logger to log through, when Logger is not set
+    FabricConfig       *FabricBrokerConfig      `json:"fabric_config"`                  // Fabric (websocket) configuration
+    TLSCertConfig      *TLSCertConfig           `json:"tls_config"`                     // TLS certificate configuration
+    Debug              bool                     `json:"debug"`                          // enable debug logging
+    NoBanner           bool                     `json:"no_banner"`                      // start server without displaying the banner
+    ShutdownTimeout    time.Duration            `json:"shutdown_timeout_in_minutes"`    // graceful server shutdown timeout in minutes
+    RestBridgeTimeout  time.Duration            `json:"rest_bridge_timeout_in_minutes"` // rest bridge timeout in minutes, unless the bridge sets its own
+    SocketCreationFunc http.HandlerFunc         `json:"-"`                              // override default websocket creation code.
+    BrokerBridges      []*BrokerBridgeConfig    `json:"broker_bridges"`                 // external STOMP brokers to bridge local channels to
+    AbuseGuard         *abuse.Guard             `json:"-"`                              // anomaly detection guarding HTTP and STOMP traffic
+    SiemExporters      []*siem.ExporterConfig   `json:"siem_exporters"`                 // syslog/CEF/LEEF collectors audit and security events are shipped to
+    Diagnostics        *DiagnosticsConfig       `json:"diagnostics"`                    // diagnostics bundle endpoint and recent log capture
+    GrpcBridge         *GrpcBridgeConfig        `json:"grpc_bridge"`                    // expose service channels as bidirectional gRPC streams
+    StoreBackup        *StoreBackupConfig       `json:"store_backup"`                   // store backup endpoint and scheduled backups
+    LoadSignal         *LoadSignalConfig        `json:"load_signal"`                    // load signal for external autoscalers such as KEDA or an HPA
+    UsageAccounting    *UsageAccountingConfig   `json:"usage_accounting"`               // per service usage reports for cost attribution
+    StorePersistence   *StorePersistenceConfig  `json:"store_persistence"`              // stores kept across restarts
+    Dependencies       *DependenciesConfig      `json:"dependencies"`                   // external systems probed during startup and reported in health output
+    RequestLogging     *RequestLoggingConfig    `json:"request_logging"`                // request-scoped loggers for correlating the logs of an HTTP request
+    EdgeCache          *EdgeCacheConfig         `json:"edge_cache"`                     // surrogate keys on REST bridge responses, and CDN purges when services invalidate them
+    Replication        *ReplicationConfig       `json:"replication"`                    // active-active replication of channels and stores with other regions
+    Archive            *ArchiveConfig           `json:"archive"`                        // recent channel history clients can replay
+    StoreAccess        *StoreAccessConfig       `json:"store_access"`                   // which principals may read and write stores
+    DevMode            *DevModeConfig           `json:"dev_mode"`                       // REST endpoints serving canned fixtures, for running the server standalone
+    Cors               *CorsConfig              `json:"cors"`                           // browsers on other origins calling the REST bridges, or every route
+    Connections        *ConnectionsConfig       `json:"connections"`                    // inventory of the fabric connections and their subscriptions
+    BridgeRouting      *BridgeRoutingConfig     `json:"bridge_routing"`                 // REST bridge requests routed to alternate service channels, e.g. canaries
+    ACL                *AclConfig               `json:"acl"`                            // access control file for channels, REST routes and stores
+    ApiDocs            *ApiDocsConfig           `json:"api_docs"`                       // API reference of the REST bridges and service channels
+    Federation         *FederationConfig        `json:"federation"`                     // channels relayed between ranch instances forming a mesh
+    Relay              *RelayConfig             `json:"relay"`                          // channels mirrored with an upstream ranch instance, e.g. from the edge to the core
+    StaticContent      *StaticContentConfig     `json:"static_content"`                 // placeholder page and checks while static directories or the SPA are missing
+    TrustedHeaderAuth  *TrustedHeaderAuthConfig `json:"trusted_header_auth"`            // principal taken from the headers of an SSO reverse proxy
+    Warmup             *WarmupConfig            `json:"warmup"`                         // service warm-up before reporting online, and traffic ramp after
+    WebSub             *WebSubConfig            `json:"websub"`                         // WebSub hub pushing channel responses to the HTTP callbacks of subscribers
+    Backplane          *BackplaneConfig         `json:"backplane"`                      // messages broadcast to the clients of every instance, for scaling out without sticky sessions
+    MessageBridge      *MessageBridgeConfig     `json:"message_bridge"`                 // bounds of the buffers service responses wait in for REST bridge requests, defaults if nil
+    Classification     *ClassificationConfig    `json:"classification"`                 // labels classing requests and fabric clients, for policies, logs and traffic counts
+    Settings           *SettingsConfig          `json:"settings"`                       // server and service settings changed at runtime, with validation, audit and rollback
+    RequestLimits      *RequestLimitsConfig     `json:"request_limits"`                 // size and read timeout of the request bodies of REST bridges, no limits if nil
+    Negotiation        *NegotiationConfig       `json:"negotiation"`                    // representations of REST bridge responses negotiated with the Accept header, JSON only if nil
+    ETags              bool                     `json:"etags"`                          // tag REST bridge responses with ETags, services may set their own, and answer conditional GETs with 304
+    ResponseCache      *ResponseCacheConfig     `json:"response_cache"`                 // cache the responses of the REST bridges that opt in, so repeated requests skip the service channel
+    Http2              *Http2Config             `json:"http2"`                          // HTTP/2 settings, or HTTP/1.1 only, and cleartext HTTP/2 (h2c) behind a proxy. net/http defaults if nil
+    Http3              *Http3Config             `json:"http3"`                          // experimental HTTP/3 (QUIC) listener next to the HTTPS server
+    Listeners          []*ListenerConfig        `json:"listeners"`                      // extra listeners serving a set of the routes on their own port, e.g. admin endpoints off the public one
+    GracefulRestart    *GracefulRestartConfig   `json:"graceful_restart"`               // hand the listening sockets over to a new process on SIGUSR2, for deploys that drop no clients
+    Health             *HealthConfig            `json:"health"`                         // liveness and readiness endpoints for orchestrators and load balancers, covering the services
+    Metrics            *MetricsConfig           `json:"metrics"`                        // Prometheus metrics of the HTTP routes, bus channels, fabric broker and stores
+    AccessLog          *AccessLogConfig         `json:"access_log"`                     // structured records of the HTTP requests served, written to files, stdout or a bus channel
+}
+
+// String returns the configuration as JSON with every secret masked (see the redact package), so the
+// configuration can be printed or logged safely.
+func (psc *PlatformServerConfig) String() string {
+    out, err := redact.JSON(psc)
+    if err != nil {
+        return err.Error()
+    }
+    return string(out)
+}
+
+// LogValue implements slog.LogValuer so logging the configuration never leaks secrets.
+func (psc *PlatformServerConfig) LogValue() slog.Value {
+    return slog.AnyValue(redact.Value(psc))
 }
 
 // TLSCertConfig wraps around key information for TLS configuration
 type TLSCertConfig struct {
-    CertFile                  string `json:"cert_file"`                   // path to certificate file
-    KeyFile                   string `json:"key_file"`                    // path to private key file
-    SkipCertificateValidation bool   `json:"skip_certificate_validation"` // whether to skip certificate validation (useful for self-signed cert)
+    CertFile                  string      `json:"cert_file"`                   // path to certificate file
+    KeyFile                   string      `json:"key_file"`                    // path to private key file
+    SkipCertificateValidation bool        `json:"skip_certificate_validation"` // whether to skip certificate validation (useful for self-signed cert)
+    FIPSMode                  bool        `json:"fips_mode"`                   // restrict TLS versions, ciphers, curves and cert keys to a FIPS vetted set
+    ACME                      *ACMEConfig `json:"acme"`                        // obtain and renew the certificate from an ACME CA such as Let's Encrypt, in place of the cert and key files
+    ReloadIntervalSeconds     int         `json:"reload_interval_seconds"`     // how often the cert and key files are checked for a rotated certificate, defaults to 10, -1 never. SIGHUP reloads them too
+}
+
+// ACMEConfig obtains and renews the certificate of the server from an ACME CA such as Let's Encrypt, in
+// place of CertFile and KeyFile. The certificate of a domain is obtained on its first TLS handshake, kept in
+// CacheDir across restarts and renewed in the background. The CA verifies the server controls the domains
+// with TLS-ALPN-01 challenges, answered on the HTTPS port which must be reachable on port 443, or HTTP-01
+// challenges, answered on HTTPPort which must be reachable on port 80.
+type ACMEConfig struct {
+    Domains         []string `json:"domains"`           // domains certificates are obtained for, handshakes for other names fail
+    Email           string   `json:"email"`             // contact of the ACME account, the CA sends expiry and problem notices to it
+    CacheDir        string   `json:"cache_dir"`         // directory the account key and certificates are kept in, relative to the root directory
+    DirectoryURL    string   `json:"directory_url"`     // directory of the ACME CA, Let's Encrypt if empty. e.g. its staging directory while testing
+    Challenge       string   `json:"challenge"`         // tls-alpn-01 (default) or http-01
+    HTTPPort        int      `json:"http_port"`         // port HTTP-01 challenges are answered on, other requests to it are redirected to HTTPS. defaults to 80
+    RenewBeforeDays int      `json:"renew_before_days"` // renew certificates this many days before they expire, defaults to 30
+}
+
+// Http2Config tunes the HTTP/2 connections of the HTTP server. HTTPS clients negotiate HTTP/2 with ALPN unless
+// it is Disabled. Servers without TLS, behind a proxy terminating it, accept cleartext HTTP/2 (h2c) from the
+// proxy if H2C is set, both with prior knowledge and as an upgrade from HTTP/1.1.
+type Http2Config struct {
+    Disabled                     bool   `json:"disabled"`                         // serve HTTP/1.1 only
+    H2C                          bool   `json:"h2c"`                              // accept cleartext HTTP/2, ignored with TLS
+    MaxConcurrentStreams         uint32 `json:"max_concurrent_streams"`           // streams a client may open at once, defaults to 250
+    MaxReadFrameSize             uint32 `json:"max_read_frame_size"`              // largest frame read, defaults to 1MB
+    MaxUploadBufferPerConnection int32  `json:"max_upload_buffer_per_connection"` // flow control window of a connection, defaults to 1MB
+    MaxUploadBufferPerStream     int32  `json:"max_upload_buffer_per_stream"`     // flow control window of a stream, defaults to 1MB
+    IdleTimeoutSeconds           int    `json:"idle_timeout_seconds"`             // close connections idle this long, the server's idle timeout if 0
+}
+
+// Http3Config serves HTTP/3 over QUIC on a UDP port, with the certificate of the HTTPS server, which it
+// requires. HTTPS responses advertise it with an Alt-Svc header so clients switch to it. HTTP/3 support is
+// experimental: WebSocket fabric connections are not served over it.
+type Http3Config struct {
+    Port                  int   `json:"port"`                     // UDP port HTTP/3 is served on, defaults to the server port
+    MaxIdleTimeoutSeconds int   `json:"max_idle_timeout_seconds"` // close connections idle this long, defaults to 30
+    MaxIncomingStreams    int64 `json:"max_incoming_streams"`     // streams a client may open at once, defaults to 100
+    Allow0RTT             bool  `json:"allow_0rtt"`               // accept requests in the first flight of resumed connections, which may be replayed
+    DisableAltSvc         bool  `json:"disable_alt_svc"`          // do not advertise HTTP/3, for clients configured to use it directly
+}
+
+// ListenerConfig is an extra listener of the server, serving a set of its routes on another port or
+// interface, e.g. an admin listener keeping the health, diagnostics and profiling endpoints off the public
+// surface. Its routes go through the same middleware as on the server port, where they are no longer served
+// unless Shared is set. Other paths are not found on the listener.
+type ListenerConfig struct {
+    Name    string   `json:"name"`    // name of the listener, used in logs
+    Host    string   `json:"host"`    // interface listened on, e.g. 127.0.0.1 or an internal address. every interface if empty
+    Port    int      `json:"port"`    // port listened on
+    Routes  []string `json:"routes"`  // paths of the routes served, a path ending in * matches everything under it e.g. /admin/*
+    Shared  bool     `json:"shared"`  // keep serving the routes on the server port too
+    Pprof   bool     `json:"pprof"`   // serve the profiler at /debug/pprof/
+    Trusted bool     `json:"trusted"` // admin routes without an Authorize function allow every client of the listener, not only local ones
+    TLS     bool     `json:"tls"`     // serve with the TLS certificate of the server
+}
+
+// GracefulRestartConfig restarts the server without closing its ports: on SIGUSR2 a new process is started
+// with the listening sockets of the server, its extra listeners, the fabric broker and the gRPC bridge. Once
+// the new process is online the old one stops accepting, drains the HTTP requests and fabric connections in
+// progress as it does when shutting down, and exits. Restarts are not supported on Windows.
+type GracefulRestartConfig struct {
+    Executable          string   `json:"executable"`            // program of the new process, this one if empty
+    Args                []string `json:"args"`                  // arguments of the new process, those of this one if nil
+    ReadyTimeoutSeconds int      `json:"ready_timeout_seconds"` // seconds the new process has to come online before it is killed and this one keeps serving, defaults to 60
+    PidFile             string   `json:"pid_file"`              // file the pid of the process serving is written to once online, for deploy scripts to signal
+}
+
+// HealthConfig serves the liveness and readiness of the server for orchestrators and load balancers. The
+// liveness endpoint answers 200 as long as the process serves requests. The readiness endpoint answers 200
+// once the server is online, serving HTTP, with every required dependency up (see DependenciesConfig) and
+// every registered service initialized and passing its health check (see service.HealthCheckEnabled), 503
+// otherwise, so no traffic is routed to the server before its services are ready (see HealthReport).
+type HealthConfig struct {
+    LivePath            string                     `json:"live_path"`             // URI of the liveness endpoint, defaults to /health/live
+    ReadyPath           string                     `json:"ready_path"`            // URI of the readiness endpoint, defaults to /health/ready
+    CheckTimeoutSeconds int                        `json:"check_timeout_seconds"` // time the health checks of the services have to pass, defaults to 5
+    Authorize           func(r *http.Request) bool `json:"-"`                     // decides who may read the endpoints, anyone if nil as orchestrators probe remotely
+}
+
+// MetricsConfig serves the metrics of the server in the Prometheus text format: HTTP request counts and
+// latencies by route, bus channel throughput, requests in flight per service, fabric connections and
+// subscriptions, broker bridges and store sizes. Services can add their own to the registry (see
+// PlatformServer.GetMetricsRegistry).
+type MetricsConfig struct {
+    Endpoint  string                     `json:"endpoint"` // URI the metrics are served at, defaults to /metrics
+    Buckets   []float64                  `json:"buckets"`  // upper bounds in seconds of the request latency buckets, defaults to metrics.DefaultBuckets
+    Authorize func(r *http.Request) bool `json:"-"`        // decides who may read the endpoint, anyone if nil as scrapers run remotely
+}
+
+// AccessLogConfig records every HTTP request served as a structured slog record (see AccessLogEntry): method,
+// path, status, latency, bytes written, principal and trace id, written to each of its sinks.
+type AccessLogConfig struct {
+    Sinks        []*AccessLogSink             `json:"sinks"`         // where records are written
+    TraceHeader  string                       `json:"trace_header"`  // header carrying the trace id, defaults to the W3C traceparent
+    ExcludePaths []string                     `json:"exclude_paths"` // path prefixes not logged, e.g. the health probes
+    Principal    func(r *http.Request) string `json:"-"`             // identifies who made the request, defaults to the principal of the ACL or store access
+    Handler      slog.Handler                 `json:"-"`             // extra handler records are written to, e.g. shipping them to a log platform
+}
+
+// AccessLogSink is where access log records are written: stdout, stderr, a file rotated once it reaches
+// MaxSizeMB, or a bus channel.
+type AccessLogSink struct {
+    Type       string `json:"type"`         // "stdout", "stderr", "file" or "channel"
+    Format     string `json:"format"`       // "json" (default) or "text", for stdout, stderr and files
+    Path       string `json:"path"`         // file written to, relative to RootDir unless absolute
+    MaxSizeMB  int    `json:"max_size_mb"`  // size a file is rotated at, defaults to 100
+    MaxBackups int    `json:"max_backups"`  // rotated files kept, all if 0
+    MaxAgeDays int    `json:"max_age_days"` // days rotated files are kept, forever if 0
+    Compress   bool   `json:"compress"`     // gzip rotated files
+    Channel    string `json:"channel"`      // channel records are sent on as *AccessLogEntry, defaults to RANCH_ACCESS_LOG_CHANNEL
 }
 
 // FabricBrokerConfig defines the endpoint for WebSocket as well as detailed endpoint configuration
 type FabricBrokerConfig struct {
-    FabricEndpoint string              `json:"fabric_endpoint"` // URI to WebSocket endpoint
-    UseTCP         bool                `json:"use_tcp"`         // Use TCP instead of WebSocket
-    TCPPort        int                 `json:"tcp_port"`        // TCP port to use if UseTCP is true
-    EndpointConfig *bus.EndpointConfig `json:"endpoint_config"` // STOMP configuration
+    FabricEndpoint        string              `json:"fabric_endpoint"`         // URI to WebSocket endpoint
+    UseTCP                bool                `json:"use_tcp"`                 // Use TCP instead of WebSocket
+    TCPPort               int                 `json:"tcp_port"`                // TCP port to use if UseTCP is true
+    SharePort             bool                `json:"share_port"`              // if UseTCP is true, serve raw TCP STOMP on the HTTP(S) port instead of TCPPort
+    UseTLS                bool                `json:"use_tls"`                 // if UseTCP is true, serve TCPPort over TLS with the certificate of TLSCertConfig
+    ClientCAFile          string              `json:"client_ca_file"`          // CA bundle verifying the certificates of TCP clients, the subject of a verified certificate is the principal
+    RequireClientCert     bool                `json:"require_client_cert"`     // refuse TCP clients without a certificate verified by ClientCAFile
+    MqttPort              int                 `json:"mqtt_port"`               // also accept MQTT 3.1.1/5 clients on this port if set
+    JsonWebSocketEndpoint string              `json:"json_websocket_endpoint"` // also accept plain JSON WebSocket clients at this URI if set
+    MaxFrameSize          int                 `json:"max_frame_size"`          // bytes of a STOMP frame, headers included, clients sending larger ones are disconnected. not limited if 0
+    MaxBodySize           int                 `json:"max_body_size"`           // bytes of a STOMP frame body, clients sending larger ones are disconnected. not limited if 0
+    Compression           bool                `json:"compression"`             // compress the WebSocket frames of clients negotiating permessage-deflate
+    CompressionLevel      int                 `json:"compression_level"`       // flate compression level, 1 (fastest) to 9 (smallest). defaults to 1
+    CompressionThreshold  int                 `json:"compression_threshold"`   // bytes of the smallest frame compressed, defaults to 512
+    DrainSeconds          int                 `json:"drain_seconds"`           // seconds clients have to disconnect after a shutdown notice when the server stops, before their connections are closed
+    EndpointConfig        *bus.EndpointConfig `json:"endpoint_config"`         // STOMP configuration
+    Ticket                *FabricTicketConfig `json:"ticket"`                  // one-time tickets for browser clients authenticated by a session cookie
+    AllowedOrigins        []string            `json:"allowed_origins"`         // origins browsers may open the fabric WebSockets from besides the server itself, any if empty
+    CSRFCookie            string              `json:"csrf_cookie"`             // cookie the CSRF token of a WebSocket upgrade must match, tokens are not checked if empty
+    CSRFParam             string              `json:"csrf_param"`              // query parameter of the WebSocket upgrade carrying the CSRF token, defaults to csrf_token
+    MaxConnectionsPerIP   int                 `json:"max_connections_per_ip"`  // WebSocket connections a client IP address may have open at once, not limited if 0
+}
+
+// FabricTicketConfig lets browser clients, which cannot set an Authorization header on a WebSocket, connect
+// with a one-time ticket. The client POSTs to Endpoint with its session cookie, and sends the ticket returned
+// (see FabricTicketResponse) in the "ticket" header of its STOMP CONNECT frame. The connection is established
+// with the session token the ticket was issued for, so revoking that token closes it.
+type FabricTicketConfig struct {
+    Endpoint       string                               `json:"endpoint"`        // URI tickets are issued at, defaults to /fabric/ticket
+    TTLSeconds     int                                  `json:"ttl_seconds"`     // how long a ticket may wait to be redeemed, defaults to 30
+    Required       bool                                 `json:"required"`        // reject STOMP connections without a ticket
+    AllowedOrigins []string                             `json:"allowed_origins"` // origins of SPAs served elsewhere that may request tickets with their cookies
+    Authenticate   func(r *http.Request) (string, bool) `json:"-"`               // validates the session cookie and returns its session token, required
+}
+
+// DiagnosticsConfig enables capturing recent logs in memory and serving diagnostics bundles (see
+// PlatformServer.WriteDiagnosticsBundle) from an admin endpoint, and streaming the logs to operators as
+// they are recorded.
+type DiagnosticsConfig struct {
+    Endpoint          string                     `json:"endpoint"`            // URI bundles are served at, e.g. /ranch/diagnostics. no endpoint if empty
+    LogBufferSize     int                        `json:"log_buffer_size"`     // number of recent log records kept, defaults to 1000
+    LogStreamEndpoint string                     `json:"log_stream_endpoint"` // URI logs are streamed from over SSE or a WebSocket, e.g. /ranch/logs. no endpoint if empty
+    LogStreamRate     int                        `json:"log_stream_rate"`     // most log records sent per second to each stream, defaults to 50
+    MaxLogStreams     int                        `json:"max_log_streams"`     // most streams open at once, defaults to 5
+    Authorize         func(r *http.Request) bool `json:"-"`                   // decides who may download a bundle or stream the logs, defaults to local, unproxied clients
+}
+
+// StoreBackupConfig enables downloading and restoring store backups (see bus.StoreManager.Backup) and
+// snapshots of single stores (see bus.StoreManager.ExportSnapshot) from admin endpoints, and backing up
+// the stores on a schedule.
+type StoreBackupConfig struct {
+    Endpoint         string                     `json:"endpoint"`          // URI backups are downloaded from (GET) and restored with (POST). no endpoint if empty
+    SnapshotEndpoint string                     `json:"snapshot_endpoint"` // URI prefix store snapshots are downloaded from (GET <prefix>/<store>) and imported with (POST). no endpoint if empty
+    MaxRestoreBytes  int64                      `json:"max_restore_bytes"` // largest backup or snapshot accepted for restore, defaults to 64MB
+    IntervalSeconds  int                        `json:"interval_seconds"`  // back up the stores this often, no scheduled backups if 0
+    Directory        string                     `json:"directory"`         // directory scheduled backups are written to when no Destination is set
+    Keep             int                        `json:"keep"`              // number of backups kept in Directory, defaults to 10
+    Destination      StoreBackupDestination     `json:"-"`                 // where scheduled backups go, overrides Directory
+    Authorize        func(r *http.Request) bool `json:"-"`                 // decides who may use the endpoint, defaults to local, unproxied clients
+}
+
+// WarmupConfig bounds the warm-up of the services (see service.OnWarmupEnabled) run before the server
+// reports being online, and ramps traffic up once it is: a decreasing share of requests is rejected with a
+// 503 and a Retry-After header, so caches and services still cold are not swamped right after a deploy.
+type WarmupConfig struct {
+    TimeoutSeconds    int      `json:"timeout_seconds"`     // how long services may take to be ready and warm up, defaults to 60
+    RampSeconds       int      `json:"ramp_seconds"`        // every request is admitted after this long online, no ramp if 0
+    RampStartPercent  int      `json:"ramp_start_percent"`  // share of requests admitted as the server comes online, defaults to 10
+    RetryAfterSeconds int      `json:"retry_after_seconds"` // Retry-After of rejected requests, defaults to 1
+    ExemptPaths       []string `json:"exempt_paths"`        // path prefixes never rejected, besides the health, liveness, readiness, load signal and metrics endpoints
+}
+
+// WebSubConfig runs a WebSub hub (see the websub package) pushing the responses of channels to the HTTP
+// callbacks of subscribers, for integrations that cannot hold a fabric connection. Subscribers send their
+// requests to Endpoint, the hub verifies their intent, then posts every response sent on the channel of the
+// topic, signed with the secret of the subscription and retried with a backoff. Anyone able to reach the
+// endpoint may subscribe to the topics.
+type WebSubConfig struct {
+    Endpoint          string            `json:"endpoint"`            // path of the hub, defaults to /websub
+    HubURL            string            `json:"hub_url"`             // public URL of the hub, advertised to subscribers in the Link header of deliveries
+    Topics            map[string]string `json:"topics"`              // channel whose responses are pushed, by topic URL
+    LeaseSeconds      int               `json:"lease_seconds"`       // lease of subscriptions not asking for one, defaults to a day
+    MaxLeaseSeconds   int               `json:"max_lease_seconds"`   // longest lease granted, defaults to 10 days
+    MaxAttempts       int               `json:"max_attempts"`        // attempts at a delivery before giving up, defaults to 5
+    RetryDelaySeconds int               `json:"retry_delay_seconds"` // wait before retrying a delivery, doubled after every attempt, defaults to 2
+}
+
+// LoadSignalConfig exposes a load signal (see LoadSignal) for external autoscalers, at an endpoint and on
+// RANCH_LOAD_SIGNAL_CHANNEL. Capacities left at 0 do not count towards the utilization.
+type LoadSignalConfig struct {
+    Endpoint                 string                     `json:"endpoint"`                    // URI the load signal is served at, e.g. /ranch/load. no endpoint if empty
+    PublishIntervalSeconds   int                        `json:"publish_interval_seconds"`    // publish the load signal on the bus this often, not published if 0
+    MaxInFlightHttpRequests  int64                      `json:"max_in_flight_http_requests"` // HTTP requests an instance serves comfortably at once
+    MaxQueueDepth            int64                      `json:"max_queue_depth"`             // requests a single service channel handles comfortably at once
+    MaxFabricConnections     int64                      `json:"max_fabric_connections"`      // fabric connections an instance holds comfortably
+    TargetUtilizationPercent int                        `json:"target_utilization_percent"`  // utilization autoscalers should aim for, defaults to 80
+    Authorize                func(r *http.Request) bool `json:"-"`                           // decides who may read the endpoint, anyone if nil as scalers run remotely
+}
+
+// UsageAccountingConfig enables usage reports (see UsageReport) attributing the resources of the instance to
+// the services it hosts, served at an admin endpoint and published on RANCH_USAGE_REPORT_CHANNEL.
+type UsageAccountingConfig struct {
+    Endpoint              string                     `json:"endpoint"`                // URI the usage of the current period is served at. no endpoint if empty
+    ReportIntervalSeconds int                        `json:"report_interval_seconds"` // length of a report period, no reports published if 0
+    Authorize             func(r *http.Request) bool `json:"-"`                       // decides who may read the endpoint, defaults to local, unproxied clients
+}
+
+// ConnectionsConfig serves the inventory of the fabric connections and their subscriptions (see
+// FabricConnections) at an admin endpoint, and publishes it on RANCH_FABRIC_CONNECTIONS_CHANNEL.
+type ConnectionsConfig struct {
+    Endpoint               string                     `json:"endpoint"`                 // URI the inventory is served at, e.g. /ranch/connections. no endpoint if empty
+    PublishIntervalSeconds int                        `json:"publish_interval_seconds"` // publish the inventory on the bus this often, not published if 0
+    Authorize              func(r *http.Request) bool `json:"-"`                        // decides who may read the endpoint, defaults to local, unproxied clients
+}
+
+// BridgeRoutingConfig routes part of the requests of REST bridges to alternate service channels (see
+// BridgeRoute). Routes are changed at runtime with PlatformServer.SetBridgeRoute, or by publishing a
+// BridgeRoute on RANCH_BRIDGE_ROUTING_CHANNEL.
+type BridgeRoutingConfig struct {
+    Routes []*BridgeRoute `json:"routes"` // routes in use when the server starts
+}
+
+// AclConfig enforces the access control file at File (see the acl package), granting roles access to
+// channels over the fabric, to REST routes and to stores, on top of FabricConfig.EndpointConfig.Authorize
+// and StoreAccess. The file is read again when it changes. Everything is denied while a file that cannot
+// be read is in force, so a broken file never opens the server up.
+type AclConfig struct {
+    File                  string                          `json:"file"`                    // YAML access control file, relative to the root directory
+    ReloadIntervalSeconds int                             `json:"reload_interval_seconds"` // how often the file is checked for changes, defaults to 10, -1 never
+    Roles                 func(principal string) []string `json:"-"`                       // roles of a principal besides those in the file, e.g. from its token claims
+    HttpPrincipal         func(r *http.Request) string    `json:"-"`                       // resolves the principal of REST requests, defaults to StoreAccess.HttpPrincipal
+}
+
+// ApiDocsConfig serves a searchable HTML API reference of the REST bridges and service channels at Endpoint,
+// rendered from the OpenAPI and AsyncAPI documents served at Endpoint/openapi.json and Endpoint/asyncapi.json.
+// Bridges are documented by the Summary, Description and Examples of their RESTBridgeConfig.
+type ApiDocsConfig struct {
+    Endpoint    string                     `json:"endpoint"`    // URI the reference is served at, defaults to /docs
+    Title       string                     `json:"title"`       // title of the reference, defaults to "ranch API"
+    Version     string                     `json:"version"`     // version of the documented API
+    Description string                     `json:"description"` // introduction shown above the reference
+    Authorize   func(r *http.Request) bool `json:"-"`           // decides who may read the reference, anyone if nil
+}
+
+// StorePersistenceConfig keeps the items of the listed stores across restarts (see bus.StorePersistence).
+// Stores are persisted to a bbolt database in Directory, or shared through Redis by every instance configured
+// with the same Redis server, unless a Persistence, e.g. wrapping a Badger database, is set.
+type StorePersistenceConfig struct {
+    Stores      []string             `json:"stores"`      // names of the stores kept across restarts
+    Directory   string               `json:"directory"`   // directory the store database is kept in when no Persistence is set
+    SyncWrites  bool                 `json:"sync_writes"` // flush every change to disk, surviving power loss at the cost of throughput
+    Redis       *RedisStoreConfig    `json:"redis"`       // keep the stores in Redis instead of Directory
+    Persistence bus.StorePersistence `json:"-"`           // persistence backing the stores, overrides Directory and Redis
+}
+
+// DependenciesConfig declares the external systems (databases, brokers, upstream APIs) the server depends
+// on. They are probed during startup, the server reports being online on RANCH_SERVER_ONLINE_CHANNEL once
+// every required dependency is up, and their status is served at HealthEndpoint (see HealthReport).
+type DependenciesConfig struct {
+    HealthEndpoint string                     `json:"health_endpoint"` // URI health output is served at, e.g. /health. no endpoint if empty
+    Dependencies   []*DependencyConfig        `json:"dependencies"`    // dependencies to probe
+    Authorize      func(r *http.Request) bool `json:"-"`               // decides who may read the endpoint, anyone if nil as orchestrators probe remotely
+}
+
+// DependencyConfig describes an external dependency and how to probe it. Dependencies in the startup phase
+// hold up the server before it starts listening, those in the ready phase hold up reporting it online.
+type DependencyConfig struct {
+    Name               string                          `json:"name"`                 // name of the dependency, used in logs and health output
+    Probe              string                          `json:"probe"`                // "tcp" (default) or "http"
+    Target             string                          `json:"target"`               // host:port for tcp probes, URL for http probes
+    Phase              string                          `json:"phase"`                // "startup" or "ready" (default)
+    Optional           bool                            `json:"optional"`             // reported in health output, but does not hold up the server
+    TimeoutSeconds     int                             `json:"timeout_seconds"`      // probe timeout, defaults to 5
+    IntervalSeconds    int                             `json:"interval_seconds"`     // probe a dependency that is up this often, defaults to 30
+    MaxBackoffSeconds  int                             `json:"max_backoff_seconds"`  // longest wait between probes of a dependency that is down, defaults to 30
+    MaxStartupAttempts int                             `json:"max_startup_attempts"` // give up on a dependency that never came up after this many probes, 0 retries forever
+    Check              func(ctx context.Context) error `json:"-"`                    // custom probe, e.g. pinging a database, overrides Probe and Target
+}
+
+// RequestLoggingConfig gives every HTTP request a request-scoped logger carrying its request id, route and
+// principal, available to middleware and services through the request context (see log.FromContext and
+// model.Request.Logger). The request id is taken from RequestIdHeader if the client sent one, generated
+// otherwise, and echoed back in the response.
+type RequestLoggingConfig struct {
+    RequestIdHeader string                       `json:"request_id_header"` // header carrying the request id, defaults to X-Request-Id
+    Principal       func(r *http.Request) string `json:"-"`                 // identifies who made the request, not logged if nil or empty
+}
+
+// EdgeCacheConfig tags the GET and HEAD responses of REST bridges with surrogate keys, the service channel
+// and the SurrogateKeys of the bridge, so a CDN fronting the server can purge them by key. Services add keys
+// by setting the header on their responses, and publish an edgecache.Invalidation on
+// RANCH_EDGE_CACHE_INVALIDATION_CHANNEL when content goes stale, which is handed to every purger. Keys of
+// REST bridges are resolved to their paths for CDNs that purge by path.
+type EdgeCacheConfig struct {
+    SurrogateKeyHeader  string             `json:"surrogate_key_header"`  // header surrogate keys are sent in, defaults to Surrogate-Key (Cache-Tag for Cloudflare or Akamai)
+    PurgeTimeoutSeconds int                `json:"purge_timeout_seconds"` // how long a purge may take, defaults to 30
+    Purgers             []edgecache.Purger `json:"-"`                     // CDNs to purge, e.g. edgecache.FastlyPurger or edgecache.CloudFrontPurger
+}
+
+// ReplicationConfig replicates channels and stores with the servers of other regions, which all accept
+// writes. Regions exchange updates on a destination of a broker they all connect to. Responses sent on a
+// replicated channel are delivered in the other regions as a replication.RegionMessage tagged with the
+// region they were sent in, and concurrent writes to replicated store items are resolved by the strategy
+// of the store, see replication.StrategyLWW and replication.StrategyORSet.
+type ReplicationConfig struct {
+    Region      string                     `json:"region"`      // name of this region, unique among the regions
+    Broker      *BrokerBridgeConfig        `json:"broker"`      // broker the regions connect to, channel mappings are not used
+    Destination string                     `json:"destination"` // destination updates are exchanged on, defaults to /topic/ranch-replication
+    Channels    []string                   `json:"channels"`    // channels whose responses are replicated
+    Stores      []*replication.StoreConfig `json:"stores"`      // stores replicated and how conflicting writes are resolved
+}
+
+// BackplaneConfig shares the fabric broker with the other instances of a deployment through a Redis or
+// NATS server they all connect to, so WebSocket clients may connect to any instance behind a load balancer,
+// without sticky sessions, and still receive every message broadcast on the channels (see
+// stompserver.Backplane). Each instance handles the requests of its own clients, only what is broadcast to
+// topics is shared: messages sent to a single client or to a queue stay on their instance.
+type BackplaneConfig struct {
+    Broker      *BrokerBridgeConfig `json:"broker"`      // broker the instances connect to, transport "redis" or "nats", channel mappings are not used
+    Destination string              `json:"destination"` // Redis channel or NATS subject messages are exchanged on, defaults to ranch-backplane
+}
+
+// MessageBridgeConfig bounds the buffer the responses of each bridged service channel wait in until the REST
+// bridge requests they answer pick them up. Its capacity starts at 100 and is tuned within the bounds from
+// the rates responses arrive and are consumed at, each resize is published on
+// RANCH_MESSAGE_BRIDGE_RESIZE_CHANNEL as a MessageBridgeResize.
+type MessageBridgeConfig struct {
+    MinPayloadCapacity    int `json:"min_payload_capacity"`    // smallest capacity, defaults to 16
+    MaxPayloadCapacity    int `json:"max_payload_capacity"`    // largest capacity, responses arriving while it is full stall the bus, defaults to 4096
+    ResizeIntervalSeconds int `json:"resize_interval_seconds"` // window rates are measured over, defaults to 10
+}
+
+// StaticContentConfig controls what happens while a static directory (StaticDir, SetStaticRoute) or the
+// root folder of the SPA is missing or unreadable. Such content is always reported at startup. With this set
+// it is also checked again periodically, reporting when it can be served again, e.g. once deployed after
+// the server started, and a placeholder page can be served in the meantime instead of 404s.
+type StaticContentConfig struct {
+    Placeholder            bool   `json:"placeholder"`              // serve a placeholder page while content is missing
+    PlaceholderFile        string `json:"placeholder_file"`         // HTML page served as the placeholder, defaults to a built-in page
+    PlaceholderStatus      int    `json:"placeholder_status"`       // status of the placeholder, defaults to 503
+    RecheckIntervalSeconds int    `json:"recheck_interval_seconds"` // how often content is checked again, defaults to 10, -1 never
+}
+
+// TrustedHeaderAuthConfig authenticates requests with the headers set by a reverse proxy handling single
+// sign-on in front of the server, such as oauth2-proxy. The user header becomes the principal of REST
+// requests and of the fabric clients connecting over WebSocket, and the groups header its roles in the
+// ACL. The headers are only read from requests sent by the proxy, recognised by the address it connects
+// from, the client certificate it presents, or both when both are set; at least one is required. The
+// headers of any other request are removed, so they cannot be forged by connecting to the server directly.
+// Checking the certificate requires the HTTPS server to verify client certificates, see CustomizeTLSConfig.
+type TrustedHeaderAuthConfig struct {
+    UserHeader        string                                    `json:"user_header"`         // header with the user, defaults to X-Forwarded-User
+    GroupsHeader      string                                    `json:"groups_header"`       // header with the groups of the user, defaults to X-Forwarded-Groups
+    GroupsSeparator   string                                    `json:"groups_separator"`    // separates the groups in the header, defaults to a comma
+    TrustedProxies    []string                                  `json:"trusted_proxies"`     // addresses or CIDR ranges the proxy connects from
+    ProxyCertSubjects []string                                  `json:"proxy_cert_subjects"` // principals of the client certificates of the proxy, see stompserver.ClientCertificatePrincipal
+    Principal         func(user string, groups []string) string `json:"-"`                   // maps the headers to the principal, defaults to the user
+}
+
+// FederationConfig relays channels between ranch instances forming a mesh, without an external broker
+// between them (see the federation package). The server connects to the fabric broker of every peer, and
+// accepts the connections of the instances it is a peer of on RANCH_FEDERATION_CHANNEL, so each pair of
+// instances only needs to be configured on one side. Responses sent on a federated channel are delivered
+// on the other instances as a federation.FederatedMessage tagged with the instance they were sent on, or
+// as sent (the []byte of their JSON) if Transparent. Anyone able to reach RANCH_FEDERATION_CHANNEL can inject messages, so restrict it to the peers with the
+// ACL or client certificates (FabricBrokerConfig.RequireClientCert).
+type FederationConfig struct {
+    Node             string                `json:"node"`               // name of this instance, unique in the mesh
+    Channels         []string              `json:"channels"`           // channels whose responses are relayed
+    Peers            []*BrokerBridgeConfig `json:"peers"`              // fabric brokers of the instances to connect to, channel mappings are not used
+    Transparent      bool                  `json:"transparent"`        // deliver the messages of the other instances as sent, as relays do
+    MaxHops          int                   `json:"max_hops"`           // instances a message may cross, defaults to 8
+    TopicPrefix      string                `json:"topic_prefix"`       // topic prefix of the peers' fabric brokers, defaults to /topic
+    AppRequestPrefix string                `json:"app_request_prefix"` // request prefix of the peers' fabric brokers, defaults to /pub
+}
+
+// RelayConfig mirrors channels with an upstream ranch instance, e.g. an edge instance with the core one,
+// without an external broker between them. The server connects to the fabric broker of the upstream
+// instance as a client, and the upstream instance accepts it with its FederationConfig (which needs no
+// peers): the relay joins the mesh of the upstream instance, as an instance nothing else connects to.
+// Responses sent on a relayed channel on either side are delivered on the other as sent, the []byte of
+// their JSON, and are never relayed back. Channels may be named differently upstream, e.g. so the edge
+// instances relay their "orders" channel as "orders-<edge>" and the core one tells them apart.
+type RelayConfig struct {
+    Node             string                 `json:"node"`               // name of this instance, unique in the mesh of the upstream instance
+    Upstream         *BrokerBridgeConfig    `json:"upstream"`           // fabric broker of the upstream instance, channel mappings are not used
+    Channels         []*RelayChannelMapping `json:"channels"`           // channels mirrored with the upstream instance
+    MaxHops          int                    `json:"max_hops"`           // instances a message may cross, defaults to 8
+    TopicPrefix      string                 `json:"topic_prefix"`       // topic prefix of the upstream fabric broker, defaults to /topic
+    AppRequestPrefix string                 `json:"app_request_prefix"` // request prefix of the upstream fabric broker, defaults to /pub
+}
+
+// RelayChannelMapping mirrors a local channel with a channel of the upstream instance.
+type RelayChannelMapping struct {
+    Channel         string `json:"channel"`          // local bus channel
+    UpstreamChannel string `json:"upstream_channel"` // channel of the upstream instance, defaults to Channel
+}
+
+// ArchiveConfig keeps the recent responses of channels, which clients replay through a built-in service
+// on ReplayChannel. A client sends a "replay" request (see archive.ReplayRequest) to the private
+// destination of the service, e.g. "/pub/queue/ranch-replay", and the archived messages are streamed to its
+// own queue at the pace they were sent, or faster.
+type ArchiveConfig struct {
+    Channels              []string `json:"channels"`                 // channels whose responses are archived
+    MaxMessagesPerChannel int      `json:"max_messages_per_channel"` // messages kept per channel, defaults to 10000
+    MaxAgeMinutes         int      `json:"max_age_minutes"`          // messages older than this are dropped, 0 keeps them until the channel is full
+    ReplayChannel         string   `json:"replay_channel"`           // channel of the replay service, defaults to ranch-replay
+    MaxReplaySpeed        float64  `json:"max_replay_speed"`         // fastest replay allowed, defaults to 100 times the original pace
+    MaxReplays            int      `json:"max_replays"`              // replays running at once, defaults to 16
+    Compress              bool     `json:"compress"`                 // store each distinct payload once, compressed, for high-volume channels
+}
+
+// StoreAccessConfig restricts which principals may read and write stores on behalf of clients (see
+// bus.StoreAccessControl), e.g. letting browsers read a config store that only backend services write. The
+// principal of a fabric client is resolved by FabricConfig.EndpointConfig.Principal when it connects, and
+// that of a REST bridge request by HttpPrincipal. Services writing stores for their callers can check
+// model.Request.Principal against the store manager's access control.
+type StoreAccessConfig struct {
+    Stores        map[string]*bus.StoreAccessRule `json:"stores"` // rules by store name, stores not listed are open to every client
+    HttpPrincipal func(r *http.Request) string    `json:"-"`      // resolves the principal of REST bridge requests, e.g. set by an auth middleware
+}
+
+// CorsConfig lets browsers on other origins call the REST bridges, or every route of the server with
+// AllRoutes. The server answers their preflights itself, so bridges do not need AllowOptions for them, and
+// browsers cache the answers for MaxAgeSeconds. Preflights for methods a route does not take are left to the
+// router, which refuses them.
+type CorsConfig struct {
+    AllowedOrigins   []string `json:"allowed_origins"`   // origins allowed to call the bridges, "*" for any. * in an origin matches a subdomain e.g. https://*.pb33f.io
+    AllowedMethods   []string `json:"allowed_methods"`   // methods allowed, every method of the route if empty
+    AllowedHeaders   []string `json:"allowed_headers"`   // request headers allowed, the ones a preflight asks for if empty
+    ExposedHeaders   []string `json:"exposed_headers"`   // response headers scripts may read besides the CORS safelisted ones
+    AllowCredentials bool     `json:"allow_credentials"` // whether browsers send cookies and authorization with requests
+    MaxAgeSeconds    int      `json:"max_age_seconds"`   // how long browsers cache a preflight, defaults to 600
+    AllRoutes        bool     `json:"all_routes"`        // apply the policy to every route, e.g. static content and custom routes, not only the REST bridges
+}
+
+// DevModeConfig lets front-end developers run the server standalone, with REST endpoints serving canned
+// fixture responses at a realistic pace instead of calling services. Mock bridges take precedence over
+// services bridging the same endpoint.
+type DevModeConfig struct {
+    FixturesDir   string              `json:"fixtures_dir"`   // directory relative fixture paths are resolved against, defaults to the root directory
+    LatencyMillis int                 `json:"latency_millis"` // delay before every mock bridge responds, unless the bridge sets its own
+    Bridges       []*MockBridgeConfig `json:"bridges"`        // endpoints serving fixtures
+}
+
+// MockBridgeConfig maps a REST endpoint to a fixture file served as its response.
+type MockBridgeConfig struct {
+    Uri           string            `json:"uri"`            // URI of the endpoint, may have path variables e.g. /api/users/{id}
+    Method        string            `json:"method"`         // HTTP method of the endpoint, defaults to GET
+    Fixture       string            `json:"fixture"`        // file served, path variables are substituted e.g. users/{id}.json
+    StatusCode    int               `json:"status_code"`    // status of the response, defaults to 200
+    ContentType   string            `json:"content_type"`   // content type of the response, defaults to the type of the fixture extension
+    Headers       map[string]string `json:"headers"`        // extra response headers
+    LatencyMillis int               `json:"latency_millis"` // delay before responding, overrides DevModeConfig.LatencyMillis
+    JitterMillis  int               `json:"jitter_millis"`  // random extra delay of up to this
+}
+
+// RedisStoreConfig describes the Redis server distributed stores are kept in (see
+// bridge.RedisStorePersistence). Changes made by other instances are announced on a Redis channel per store,
+// changes made outside ranch are picked up through keyspace events.
+type RedisStoreConfig struct {
+    ServerAddr string `json:"server_addr"`            // host:port of the Redis server
+    Username   string `json:"username"`               // Redis ACL user
+    Password   string `json:"password" secret:"true"` // Redis password
+    UseTLS     bool   `json:"use_tls"`                // connect with TLS
+    KeyPrefix  string `json:"key_prefix"`             // prefix of store keys, defaults to "ranch:store:"
+}
+
+// GrpcBridgeConfig exposes service channels as bidirectional gRPC streams on a dedicated port (see the
+// grpcbridge package). The port is served with TLS when TLSCertConfig is set, and as cleartext HTTP/2 (h2c)
+// otherwise.
+type GrpcBridgeConfig struct {
+    Port                   int      `json:"port"`                     // port the gRPC bridge listens on
+    Channels               []string `json:"channels"`                 // service channels clients may open streams on
+    MaxMessageBytes        int      `json:"max_message_bytes"`        // largest frame accepted from clients, defaults to 4MB
+    ResponseTimeoutSeconds int      `json:"response_timeout_seconds"` // wait for outstanding responses once a client half closes, defaults to 60
+}
+
+// BrokerBridgeConfig describes an external STOMP broker (RabbitMQ, ActiveMQ etc.), NATS server or Redis server,
+// and the local channels that should be bridged to destinations on it. Plank instances bridged to the same
+// Redis server share the mapped channels through Redis pub/sub.
+type BrokerBridgeConfig struct {
+    Name                  string                  `json:"name"`                    // name of the bridge, used in logs
+    Transport             string                  `json:"transport"`               // "stomp" (default), "nats" or "redis", destinations are NATS subjects or Redis channels
+    ServerAddr            string                  `json:"server_addr"`             // host:port of the broker
+    Username              string                  `json:"username"`                // broker login
+    Password              string                  `json:"password" secret:"true"`  // broker passcode
+    HostHeader            string                  `json:"host_header"`             // STOMP host header (virtual host)
+    UseWebSocket          bool                    `json:"use_websocket"`           // connect over WebSocket instead of TCP
+    WebSocketPath         string                  `json:"websocket_path"`          // WebSocket path when UseWebSocket is true
+    UseTLS                bool                    `json:"use_tls"`                 // use TLS for the WebSocket or Redis connection
+    ReconnectDelaySeconds int                     `json:"reconnect_delay_seconds"` // delay between connection attempts, defaults to 5
+    MaxReconnectAttempts  int                     `json:"max_reconnect_attempts"`  // give up after this many attempts, 0 retries forever
+    Channels              []*BrokerChannelMapping `json:"channels"`                // channel to destination mappings
+    TokenRevocations      string                  `json:"token_revocations"`       // destination session token revocations are shared with the other instances on, not shared if empty
+}
+
+// ClassificationConfig labels every HTTP request and fabric client with the class of traffic it belongs to
+// (see the classify package), e.g. {"client": "bot", "tier": "free"}. Services receive the labels with the
+// requests (model.Request.Labels), request-scoped loggers carry them, policies rate limit and bound the
+// HTTP requests of classes, and the traffic of every label value is counted (see TrafficClasses).
+type ClassificationConfig struct {
+    Rules        []*classify.Rule                                        `json:"rules"`       // labels set from headers and claims, the first rule setting a label wins
+    Classifiers  []classify.Classifier                                   `json:"-"`           // custom classifiers, run after the rules
+    ClientKind   bool                                                    `json:"client_kind"` // label the kind of client (bot, browser or service) from its User-Agent
+    Claims       func(r *http.Request) map[string]interface{}            `json:"-"`           // claims of the token of an HTTP request, none if nil
+    FabricClaims func(conn stompserver.StompConn) map[string]interface{} `json:"-"`           // claims of the session token of a fabric client, none if nil
+    Policies     []*classify.Policy                                      `json:"policies"`    // rate limits and concurrency bounds of classes of HTTP requests
+    Endpoint     string                                                  `json:"endpoint"`    // URI the traffic counts are served at, e.g. /ranch/classes. no endpoint if empty
+    Authorize    func(r *http.Request) bool                              `json:"-"`           // decides who may read the endpoint, defaults to local, unproxied clients
+}
+
+// RequestLimitsConfig bounds the request bodies REST bridges read, refusing those too large with 413 and
+// those not received in time with 408 before they are turned into bus messages. A bridge may set its own
+// limits (see service.RESTBridgeConfig), which take over from these.
+type RequestLimitsConfig struct {
+    MaxRequestBodyBytes int64 `json:"max_request_body_bytes"` // largest body accepted, no limit if 0
+    ReadTimeoutSeconds  int   `json:"read_timeout_seconds"`   // how long reading a body may take, no limit if 0
+}
+
+// NegotiationConfig renders the responses of REST bridges in the representation clients ask for with their
+// Accept header (see the serializer package): JSON, XML, YAML, Protobuf for payloads that are proto messages
+// or plain text for scalars. Responses whose service sets their content type (see model.Response.ContentType)
+// and payloads already in bytes are written as they are.
+type NegotiationConfig struct {
+    MediaTypes  []string                         `json:"media_types"` // media types of the built-in serializers offered, in order of preference. all of them if empty
+    Serializers map[string]serializer.Serializer `json:"-"`           // serializers of other media types, by media type, offered after the built-in ones
+    Strict      bool                             `json:"strict"`      // refuse requests accepting none of the media types with 406, rather than answering with JSON
+    Default     string                           `json:"default"`     // media type of the responses to requests without an Accept header or accepting anything (*/*), like browsers. JSON if empty
+}
+
+// ResponseCacheConfig caches the successful GET and HEAD responses of the REST bridges that opt in (see
+// service.RESTBridgeConfig.Cache), answering repeated requests without sending them to the service channel.
+// Responses are kept in memory, or in Redis to share them with the other instances, until their TTL runs
+// out or an edgecache.Invalidation published on RANCH_EDGE_CACHE_INVALIDATION_CHANNEL names the service
+// channel or a surrogate key of their bridge.
+type ResponseCacheConfig struct {
+    TTLSeconds    int                 `json:"ttl_seconds"`     // how long responses are cached by bridges that set no TTL, defaults to 60
+    MaxEntries    int                 `json:"max_entries"`     // responses kept in memory, the least recently used are evicted, defaults to 10000
+    MaxEntryBytes int64               `json:"max_entry_bytes"` // larger responses are not cached, defaults to 1MB
+    Redis         *RedisStoreConfig   `json:"redis"`           // keep the responses in Redis instead of memory, KeyPrefix defaults to "ranch:cache:"
+    Store         responsecache.Store `json:"-"`               // store keeping the responses, overrides MaxEntries and Redis
+}
+
+// SettingsConfig lets operators change the settings of the running server, and those services register
+// (see PlatformServer.RegisterSettings), from a dashboard or tool. Settings are kept in a store and validated
+// against the JSON schema of their section (see the settings package), and every change is recorded with
+// who made it and why, published on RANCH_AUDIT_EVENT_CHANNEL for the SIEM exporters, and can be rolled back.
+// The settings service on Channel and the REST API at Endpoint both change them:
+//
+//	GET       {endpoint}                    sections and their values
+//	GET       {endpoint}/{section}          a section and its values
+//	PUT|PATCH {endpoint}/{section}          change settings of a section, {"values": {...}, "comment": "..."}
+//	GET       {endpoint}/history            recent changes, {endpoint}/{section}/history for a section
+//	POST      {endpoint}/rollback           roll a change back, {"version": 3, "comment": "..."}
+//
+// Add the store, and its history store, to StorePersistence to keep changes across restarts, they then take
+// over from the configuration.
+type SettingsConfig struct {
+    Endpoint   string                     `json:"endpoint"`    // URI of the settings REST API, e.g. /ranch/settings. no REST API if empty
+    Channel    string                     `json:"channel"`     // channel of the settings service, defaults to an internal channel
+    Store      string                     `json:"store"`       // store of the settings, defaults to ranch-settings. the history is kept in {store}-history
+    MaxHistory int                        `json:"max_history"` // changes kept in the history, defaults to 100
+    Authorize  func(r *http.Request) bool `json:"-"`           // decides who may use the REST API, defaults to local, unproxied clients
+}
+
+// BrokerChannelMapping maps a local bus channel to destinations on an external broker. Messages arriving on
+// Destination are delivered to the channel as responses, and requests sent on the channel are published
+// to PublishDestination (or Destination if not set).
+type BrokerChannelMapping struct {
+    Channel            string `json:"channel"`             // local bus channel
+    Destination        string `json:"destination"`         // broker destination to subscribe to
+    PublishDestination string `json:"publish_destination"` // broker destination to publish requests to
 }
 
 // PlatformServer exposes public API methods that control the behavior of the Plank instance.
 type PlatformServer interface {
-    StartServer(syschan chan os.Signal)                                         // start server
-    StopServer()                                                                // stop server
-    GetRouter() *mux.Router                                                     // get *mux.Router instance
-    RegisterService(svc service.FabricService, svcChannel string) error         // register a new service at given channel
-    SetHttpChannelBridge(bridgeConfig *service.RESTBridgeConfig)                // set up a REST bridge for a service
-    SetStaticRoute(prefix, fullpath string, middlewareFn ...mux.MiddlewareFunc) // set up a static content route
-    SetHttpPathPrefixChannelBridge(bridgeConfig *service.RESTBridgeConfig)      // set up a REST bridge for a path prefix for a service.
-    CustomizeTLSConfig(tls *tls.Config) error                                   // used to replace default tls.Config for HTTP server with a custom config
-    GetRestBridgeSubRoute(uri, method string) (*mux.Route, error)               // get *mux.Route that maps to the provided uri and method
-    GetMiddlewareManager() middleware.MiddlewareManager                         // get middleware manager
+    StartServer(syschan chan os.Signal)                                             // start server
+    StopServer()                                                                    // stop server
+    GetRouter() *mux.Router                                                         // get *mux.Router instance
+    RegisterService(svc service.FabricService, svcChannel string) error             // register a new service at given channel
+    SetHttpChannelBridge(bridgeConfig *service.RESTBridgeConfig)                    // set up a REST bridge for a service
+    SetStaticRoute(prefix, fullpath string, middlewareFn ...mux.MiddlewareFunc)     // set up a static content route
+    SetStaticRouteFS(prefix string, fsys fs.FS, middlewareFn ...mux.MiddlewareFunc) // set up a static content route served from memory, e.g. an embed.FS
+    SetHttpPathPrefixChannelBridge(bridgeConfig *service.RESTBridgeConfig)          // set up a REST bridge for a path prefix for a service.
+    UnsetHttpChannelBridge(uri, method string) error                                // remove the REST bridge at uri for method, at runtime
+    UnsetHttpPathPrefixChannelBridge(uri string) error                              // remove the REST bridge of a path prefix, at runtime
+    CustomizeTLSConfig(tls *tls.Config) error                                       // used to replace default tls.Config for HTTP server with a custom config
+    GetRestBridgeSubRoute(uri, method string) (*mux.Route, error)                   // get *mux.Route that maps to the provided uri and method
+    GetMiddlewareManager() middleware.MiddlewareManager                             // get middleware manager
     GetFabricConnectionListener() stompserver.RawConnectionListener
+    WriteDiagnosticsBundle(w io.Writer) error         // write a diagnostics bundle (zip archive) to w
+    CurrentLoadSignal() *LoadSignal                   // how busy the instance is, for external autoscalers
+    FabricConnections() *FabricConnections            // connections of the fabric broker and their subscriptions
+    SetBridgeRoute(route *BridgeRoute) error          // route requests of the REST bridges of a service channel to another
+    BridgeRoutes() []*BridgeRoute                     // routes of the REST bridges in use
+    Health() *HealthReport                            // status of the server and its external dependencies
+    Readiness(ctx context.Context) *HealthReport      // status of the server, its dependencies and services, ready once traffic can be routed to it
+    GetMetricsRegistry() *metrics.Registry            // registry served by the metrics endpoint, for services to add metrics to. nil if not configured
+    CheckStaticContent() []error                      // check the static directories and SPA root folder again, returning why those missing cannot be served
+    TrafficClasses() []*classify.ClassStats           // traffic counted by label value, nil if traffic is not classified
+    RegisterSettings(section *settings.Section) error // add settings changed at runtime, fails if settings are not configured
 }
 
 // platformServer is the main struct that holds all components together including servers, various managers etc.
 type platformServer struct {
     HttpServer                   *http.Server                      // Http server instance
-    Http2Server                  *http2.Server                     // Http server instance
+    Http2Server                  *http2.Server                     // HTTP/2 settings of the Http server, nil if not configured
     SyscallChan                  chan os.Signal                    // syscall channel to receive SIGINT, SIGKILL events
     eventbus                     bus.EventBus                      // event bus pointer
     serverConfig                 *PlatformServerConfig             // server config instance
@@ -85,12 +758,62 @@ type platformServer struct {
     ServerAvailability           *ServerAvailability               // server availability (not much used other than for internal monitoring for now)
     lock                         sync.Mutex                        // lock
     messageBridgeMap             map[string]*MessageBridge
+    brokerBridges                []*brokerBridge          // bridges to external STOMP brokers
+    siemExporters                []*siem.Exporter         // exporters shipping audit and security events
+    siemHandlers                 []bus.MessageHandler     // handlers feeding events to the SIEM exporters
+    logBuffer                    *diagnostics.LogBuffer   // recent log records included in diagnostics bundles
+    grpcBridge                   *grpcbridge.Bridge       // gRPC bridge to service channels
+    grpcServer                   *http.Server             // HTTP/2 server for the gRPC bridge
+    storeBackupStop              chan struct{}            // stops the scheduled store backups
+    loadSignal                   *loadSignalState         // counters behind the load signal, nil if not configured
+    usageAccountant              *usageAccountant         // usage accounting state, nil if not configured
+    dependencies                 *dependencyMonitor       // dependency probes, nil if not configured
+    storePersistence             io.Closer                // store persistence created from the configuration
+    portMux                      *stompserver.PortMux     // shares the HTTP(S) port with raw TCP STOMP clients, nil if not configured
+    edgeCache                    *edgeCacheState          // surrogate keys of the REST bridges, nil if not configured
+    cors                         *corsState               // CORS of the REST bridges or every route, nil if not configured
+    replication                  *replicationState        // replication with other regions, nil if not configured
+    federation                   *federationState         // federation with other instances, nil if not configured
+    relay                        *federationState         // relay to an upstream instance, nil if not configured
+    staticMounts                 []*staticMount           // static directories and SPA root folder, checked while served
+    staticContentStop            chan struct{}            // stops checking the static content
+    archive                      *archive.Archive         // channel archive, nil if not configured
+    replayService                *archive.ReplayService   // replays the archive to clients, nil if not configured
+    fabricTickets                *stompserver.TicketStore // tickets waiting to be redeemed by fabric clients, nil if not configured
+    connectionsStop              chan struct{}            // stops publishing the fabric connection inventory
+    bridgeRouting                *bridgeRoutingState      // routes of the REST bridges, nil if not configured
+    bridgeControlHandler         bus.MessageHandler       // applies the bridge commands of services
+    revocations                  *stompserver.RevocationList // revoked session tokens, refused by the fabric broker and HTTP routes
+    revocationHandler            bus.MessageHandler       // adds the tokens revoked on the token revocation channel
+    acl                          *acl.ACL                 // access control file in force, nil if not configured
+    aclStop                      chan struct{}            // stops checking the access control file for changes
+    apiDocs                      *apiDocsState            // documented REST bridges, nil if not configured
+    trustedHeaders               *trustedHeaders          // reverse proxy authenticating requests, nil if not configured
+    logStreams                   *logStreams              // clients streaming the logs, nil if not configured
+    trafficRamp                  *trafficRamp             // rejects a decreasing share of requests once online, nil if not configured
+    webSub                       *websub.Hub              // WebSub hub, nil if not configured
+    backplane                    *backplaneState          // messages broadcast to the other instances, nil if not configured
+    classification               *classificationState     // classifier and policies of the traffic, nil if not configured
+    settings                     *settings.Manager        // settings changed at runtime, nil if not configured
+    serializers                  *serializer.Registry     // serializers of the representations REST bridges negotiate, nil if not configured
+    responseCache                *responseCacheState      // store REST bridge responses are cached in, nil if not configured
+    http3Server                  *http3.Server            // HTTP/3 (QUIC) server, nil if not configured
+    listeners                    []*http.Server           // servers of the extra listeners
+    restart                      *restartState            // sockets handed over on restarts, nil if not configured
+    acme                         *acmeState               // certificates obtained from an ACME CA, nil if not configured
+    certificates                 *certificateReloader     // certificate of the cert and key files, reloaded when rotated, nil without TLS
+    health                       *healthState             // liveness and readiness endpoints, nil if not configured
+    metrics                      *metricsState            // metrics served by the metrics endpoint, nil if not configured
+    accessLog                    *accessLogState          // sinks of the access log, nil if not configured
 }
 
-// MessageBridge is a conduit used for returning service responses as HTTP responses
+// MessageBridge is a conduit used for returning service responses as HTTP responses. Responses are handed to
+// the request they are addressed to, by the id of the request.
 type MessageBridge struct {
-    ServiceListenStream bus.MessageHandler  // message handler returned by bus.ListenStream responsible for relaying back messages as HTTP responses
-    payloadChannel      chan *model.Message // internal golang channel used for passing bus responses/errors across goroutines
+    ServiceListenStream bus.MessageHandler            // message handler returned by bus.ListenStream responsible for relaying back messages as HTTP responses
+    payloads            *payloadBuffer                // buffers the responses until they are dispatched to the requests waiting for them
+    waiters             map[uuid.UUID]*responseWaiter // requests waiting for their responses, by request id
+    lock                sync.Mutex                    // guards waiters
 }
 
 // ServerAvailability contains boolean fields to indicate what components of the system are available or not
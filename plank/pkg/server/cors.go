@@ -0,0 +1,222 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gobwas/glob"
+	"github.com/gorilla/mux"
+)
+
+const defaultCorsMaxAge = 600
+
+// CorsMetrics counts the preflights of the REST bridges.
+type CorsMetrics struct {
+	Preflights uint64            `json:"preflights"`          // preflights answered
+	Rejected   uint64            `json:"preflights_rejected"` // preflights from origins, or for methods, that are not allowed
+	ByRoute    map[string]uint64 `json:"preflights_by_route"` // preflights answered, by the name of the bridge route
+}
+
+// corsState answers the preflights of the REST bridges, or every route, and lets allowed origins read their
+// responses.
+type corsState struct {
+	config   *CorsConfig
+	maxAge   string
+	patterns []glob.Glob // allowed origins matching subdomains
+	methods  []string    // allowed methods, in upper case
+	lock     sync.RWMutex
+	bridges  map[string]bool // names of the routes of the REST bridges
+	byRoute  map[string]uint64
+	answered atomic.Uint64
+	rejected atomic.Uint64
+}
+
+// initCors starts answering the preflights of the REST bridges, if configured.
+func (ps *platformServer) initCors() {
+	cfg := ps.serverConfig.Cors
+	if cfg == nil {
+		return
+	}
+	maxAge := cfg.MaxAgeSeconds
+	if maxAge <= 0 {
+		maxAge = defaultCorsMaxAge
+	}
+	cs := &corsState{
+		config:  cfg,
+		maxAge:  strconv.Itoa(maxAge),
+		bridges: make(map[string]bool),
+		byRoute: make(map[string]uint64),
+	}
+	for _, origin := range cfg.AllowedOrigins {
+		if origin == "*" || !strings.Contains(origin, "*") {
+			continue
+		}
+		// * stops at dots, so it only matches one label of the host
+		pattern, err := glob.Compile(origin, '.')
+		if err != nil {
+			ps.serverConfig.Logger.Error(wrapError(errServerInit, err).Error())
+			continue
+		}
+		cs.patterns = append(cs.patterns, pattern)
+	}
+	for _, method := range cfg.AllowedMethods {
+		cs.methods = append(cs.methods, strings.ToUpper(method))
+	}
+	ps.cors = cs
+}
+
+// addBridge starts answering the preflights of the route of a REST bridge.
+func (cs *corsState) addBridge(routeName string) {
+	cs.lock.Lock()
+	cs.bridges[routeName] = true
+	cs.lock.Unlock()
+}
+
+// removeBridge stops answering the preflights of the route of a REST bridge.
+func (cs *corsState) removeBridge(routeName string) {
+	cs.lock.Lock()
+	delete(cs.bridges, routeName)
+	cs.lock.Unlock()
+}
+
+// allowedOrigin returns the value of the Access-Control-Allow-Origin header for the origin, empty if the
+// origin is not allowed.
+func (cs *corsState) allowedOrigin(origin string) string {
+	if slices.Contains(cs.config.AllowedOrigins, origin) {
+		return origin
+	}
+	for _, pattern := range cs.patterns {
+		if pattern.Match(origin) {
+			return origin
+		}
+	}
+	if slices.Contains(cs.config.AllowedOrigins, "*") {
+		// credentials are never sent to a wildcard origin
+		if cs.config.AllowCredentials {
+			return origin
+		}
+		return "*"
+	}
+	return ""
+}
+
+// bridgeRoute returns the name of the REST bridge route the request would be sent to with the method,
+// empty if it would not go to a bridge. With AllRoutes every route counts, by name or else path.
+func (cs *corsState) bridgeRoute(router *mux.Router, r *http.Request, method string) string {
+	probe := r.Clone(r.Context())
+	probe.Method = method
+	var match mux.RouteMatch
+	if !router.Match(probe, &match) || match.Route == nil {
+		return ""
+	}
+	name := match.Route.GetName()
+	if cs.config.AllRoutes {
+		if name == "" {
+			name, _ = match.Route.GetPathTemplate()
+		}
+		if name == "" {
+			name = r.URL.Path
+		}
+		return name
+	}
+	cs.lock.RLock()
+	defer cs.lock.RUnlock()
+	if !cs.bridges[name] {
+		return ""
+	}
+	return name
+}
+
+// middleware answers the preflights of the REST bridges of the router, or of all its routes, and adds the CORS headers to the
+// responses of the bridges to allowed origins. Everything else is passed to the handler.
+func (cs *corsState) middleware(router *mux.Router, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		requestedMethod := r.Header.Get("Access-Control-Request-Method")
+		if r.Method == http.MethodOptions && requestedMethod != "" {
+			route := cs.bridgeRoute(router, r, requestedMethod)
+			if route == "" {
+				handler.ServeHTTP(w, r)
+				return
+			}
+			cs.preflight(w, r, origin, requestedMethod, route)
+			return
+		}
+		if cs.bridgeRoute(router, r, r.Method) != "" {
+			if allowed := cs.allowedOrigin(origin); allowed != "" {
+				cs.setAllowOrigin(w, allowed)
+				if len(cs.config.ExposedHeaders) > 0 {
+					w.Header().Set("Access-Control-Expose-Headers", strings.Join(cs.config.ExposedHeaders, ", "))
+				}
+			}
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// preflight answers the preflight of a request to a REST bridge route.
+func (cs *corsState) preflight(w http.ResponseWriter, r *http.Request, origin, method, route string) {
+	allowed := cs.allowedOrigin(origin)
+	if len(cs.methods) > 0 && !slices.Contains(cs.methods, strings.ToUpper(method)) {
+		allowed = ""
+	}
+	if allowed == "" {
+		cs.rejected.Add(1)
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	cs.answered.Add(1)
+	cs.lock.Lock()
+	cs.byRoute[route]++
+	cs.lock.Unlock()
+
+	cs.setAllowOrigin(w, allowed)
+	if len(cs.methods) > 0 {
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(cs.methods, ", "))
+	} else {
+		w.Header().Set("Access-Control-Allow-Methods", method)
+	}
+	if len(cs.config.AllowedHeaders) > 0 {
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(cs.config.AllowedHeaders, ", "))
+	} else if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+		w.Header().Set("Access-Control-Allow-Headers", headers)
+	}
+	w.Header().Set("Access-Control-Max-Age", cs.maxAge)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cs *corsState) setAllowOrigin(w http.ResponseWriter, allowed string) {
+	w.Header().Set("Access-Control-Allow-Origin", allowed)
+	if allowed != "*" {
+		w.Header().Add("Vary", "Origin")
+	}
+	if cs.config.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// metrics returns a snapshot of the preflight counters.
+func (cs *corsState) metrics() *CorsMetrics {
+	cs.lock.RLock()
+	defer cs.lock.RUnlock()
+	metrics := &CorsMetrics{
+		Preflights: cs.answered.Load(),
+		Rejected:   cs.rejected.Load(),
+		ByRoute:    make(map[string]uint64, len(cs.byRoute)),
+	}
+	for route, count := range cs.byRoute {
+		metrics.ByRoute[route] = count
+	}
+	return metrics
+}
@@ -0,0 +1,126 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestCors(config *CorsConfig) (*corsState, http.Handler) {
+	ps := &platformServer{serverConfig: &PlatformServerConfig{Cors: config}}
+	ps.initCors()
+	router := mux.NewRouter().Schemes("http", "https").Subrouter()
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	router.Path("/cows/{name}").Methods(http.MethodGet).Name("/cows/{name}-GET").Handler(ok)
+	router.PathPrefix("/barn/").Name("/barn/-*").Handler(ok)
+	router.Path("/static").Methods(http.MethodGet).Name("/static").Handler(ok)
+	ps.cors.addBridge("/cows/{name}-GET")
+	ps.cors.addBridge("/barn/-*")
+	return ps.cors, ps.cors.middleware(router, router)
+}
+
+func corsTestRequest(handler http.Handler, method, path, origin, requestMethod string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, nil)
+	if origin != "" {
+		r.Header.Set("Origin", origin)
+	}
+	if requestMethod != "" {
+		r.Header.Set("Access-Control-Request-Method", requestMethod)
+		r.Header.Set("Access-Control-Request-Headers", "content-type")
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestCors_Preflight(t *testing.T) {
+	cors, handler := newTestCors(&CorsConfig{AllowedOrigins: []string{"https://app.pb33f.io"}, AllowCredentials: true})
+
+	w := corsTestRequest(handler, http.MethodOptions, "/cows/daisy", "https://app.pb33f.io", http.MethodGet)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "https://app.pb33f.io", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, http.MethodGet, w.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "content-type", w.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "600", w.Header().Get("Access-Control-Max-Age"))
+
+	// path prefix bridges take every method
+	w = corsTestRequest(handler, http.MethodOptions, "/barn/hay", "https://app.pb33f.io", http.MethodDelete)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	// other origins are refused
+	w = corsTestRequest(handler, http.MethodOptions, "/cows/daisy", "https://evil.example", http.MethodGet)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+
+	// methods a bridge does not take and routes that are not bridges are left to the router
+	for path, method := range map[string]string{"/cows/daisy": http.MethodPost, "/static": http.MethodGet} {
+		w = corsTestRequest(handler, http.MethodOptions, path, "https://app.pb33f.io", method)
+		assert.NotEqual(t, http.StatusNoContent, w.Code)
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	}
+
+	metrics := cors.metrics()
+	assert.Equal(t, uint64(2), metrics.Preflights)
+	assert.Equal(t, uint64(1), metrics.Rejected)
+	assert.Equal(t, map[string]uint64{"/cows/{name}-GET": 1, "/barn/-*": 1}, metrics.ByRoute)
+}
+
+func TestCors_Responses(t *testing.T) {
+	_, handler := newTestCors(&CorsConfig{AllowedOrigins: []string{"*"}, ExposedHeaders: []string{"Surrogate-Key"},
+		MaxAgeSeconds: 60})
+
+	w := corsTestRequest(handler, http.MethodGet, "/cows/daisy", "https://app.pb33f.io", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "Surrogate-Key", w.Header().Get("Access-Control-Expose-Headers"))
+
+	w = corsTestRequest(handler, http.MethodGet, "/static", "https://app.pb33f.io", "")
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+
+	w = corsTestRequest(handler, http.MethodOptions, "/cows/daisy", "https://app.pb33f.io", http.MethodGet)
+	assert.Equal(t, "60", w.Header().Get("Access-Control-Max-Age"))
+}
+
+func TestCors_Policy(t *testing.T) {
+	cors, handler := newTestCors(&CorsConfig{AllowedOrigins: []string{"https://*.pb33f.io"},
+		AllowedMethods: []string{"get", "post"}, AllRoutes: true})
+
+	// origins match a subdomain of the pattern, not deeper ones nor other hosts
+	for origin, allowed := range map[string]bool{
+		"https://app.pb33f.io":      true,
+		"https://docs.pb33f.io":     true,
+		"https://a.b.pb33f.io":      false,
+		"https://evil.example":      false,
+		"http://app.pb33f.io":       false,
+		"https://app.pb33f.io.evil": false,
+	} {
+		w := corsTestRequest(handler, http.MethodOptions, "/cows/daisy", origin, http.MethodGet)
+		if allowed {
+			assert.Equal(t, http.StatusNoContent, w.Code, origin)
+			assert.Equal(t, origin, w.Header().Get("Access-Control-Allow-Origin"))
+			assert.Equal(t, "GET, POST", w.Header().Get("Access-Control-Allow-Methods"))
+		} else {
+			assert.Equal(t, http.StatusForbidden, w.Code, origin)
+		}
+	}
+
+	// routes that are not bridges follow the policy too, methods outside of it are refused
+	w := corsTestRequest(handler, http.MethodOptions, "/static", "https://app.pb33f.io", http.MethodGet)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	w = corsTestRequest(handler, http.MethodGet, "/static", "https://app.pb33f.io", "")
+	assert.Equal(t, "https://app.pb33f.io", w.Header().Get("Access-Control-Allow-Origin"))
+	w = corsTestRequest(handler, http.MethodOptions, "/barn/hay", "https://app.pb33f.io", http.MethodDelete)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	metrics := cors.metrics()
+	assert.Equal(t, uint64(3), metrics.Preflights)
+	assert.Equal(t, uint64(5), metrics.Rejected)
+	assert.Equal(t, map[string]uint64{"/cows/{name}-GET": 2, "/static": 1}, metrics.ByRoute)
+}
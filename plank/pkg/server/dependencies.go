@@ -0,0 +1,335 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pb33f/ranch/clock"
+)
+
+const (
+	DependencyProbeTCP  = "tcp"  // the dependency is up if a TCP connection can be made to it
+	DependencyProbeHTTP = "http" // the dependency is up if a GET request to it returns a 2xx or 3xx status
+
+	DependencyPhaseStartup = "startup" // checked before the server starts listening
+	DependencyPhaseReady   = "ready"   // checked once the server is listening, before it reports being online
+
+	defaultDependencyTimeout    = 5 * time.Second
+	defaultDependencyInterval   = 30 * time.Second
+	defaultDependencyMaxBackoff = 30 * time.Second
+	dependencyMinBackoff        = 500 * time.Millisecond
+)
+
+// Health statuses reported by the health endpoint.
+const (
+	HealthStarting = "starting" // required dependencies are not up yet
+	HealthReady    = "ready"    // online, with every required dependency up
+	HealthDegraded = "degraded" // online, but a required dependency went down since
+)
+
+// DependencyStatus is the last known state of an external dependency.
+type DependencyStatus struct {
+	Name        string    `json:"name"`
+	Phase       string    `json:"phase"`
+	Optional    bool      `json:"optional"`
+	Up          bool      `json:"up"`
+	Failed      bool      `json:"failed"` // gave up after MaxStartupAttempts
+	Attempts    int       `json:"attempts"`
+	LastChecked time.Time `json:"last_checked,omitempty"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// HealthReport is served by the health endpoint.
+type HealthReport struct {
+	Status       string              `json:"status"`
+	Http         bool                `json:"http"`
+	Fabric       bool                `json:"fabric"`
+	Dependencies []*DependencyStatus `json:"dependencies"`
+	Services     []*ServiceHealth    `json:"services,omitempty"` // registered services, in readiness output
+}
+
+// dependencyMonitor probes the configured dependencies and tracks their status. changed is closed and
+// replaced whenever a status changes, so the server can wait on it.
+type dependencyMonitor struct {
+	lock     sync.Mutex
+	statuses []*DependencyStatus
+	changed  chan struct{}
+	online   bool
+	stop     chan struct{}
+}
+
+// initDependencies validates the configured dependencies and registers the health endpoint.
+func (ps *platformServer) initDependencies() {
+	cfg := ps.serverConfig.Dependencies
+	if cfg == nil {
+		return
+	}
+	monitor := &dependencyMonitor{changed: make(chan struct{}), stop: make(chan struct{})}
+	for _, dep := range cfg.Dependencies {
+		if err := validateDependencyConfig(dep); err != nil {
+			panic(wrapError(errServerInit, err))
+		}
+		phase := dep.Phase
+		if phase == "" {
+			phase = DependencyPhaseReady
+		}
+		monitor.statuses = append(monitor.statuses, &DependencyStatus{Name: dep.Name, Phase: phase,
+			Optional: dep.Optional})
+	}
+	ps.dependencies = monitor
+	if cfg.HealthEndpoint == "" {
+		return
+	}
+	ps.router.Path(cfg.HealthEndpoint).Name(cfg.HealthEndpoint).Methods(http.MethodGet).HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if cfg.Authorize != nil && !cfg.Authorize(r) {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			report := ps.Health()
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "no-store")
+			if report.Status != HealthReady {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+			_ = json.NewEncoder(w).Encode(report)
+		})
+	ps.serverConfig.Logger.Info("[ranch] health endpoint enabled", "endpoint", cfg.HealthEndpoint)
+}
+
+// validateDependencyConfig checks a dependency has everything needed to be probed.
+func validateDependencyConfig(dep *DependencyConfig) error {
+	if dep == nil || dep.Name == "" {
+		return fmt.Errorf("dependency config invalid, dependency name missing")
+	}
+	if dep.Phase != "" && dep.Phase != DependencyPhaseStartup && dep.Phase != DependencyPhaseReady {
+		return fmt.Errorf("dependency '%s' has unknown phase '%s'", dep.Name, dep.Phase)
+	}
+	if dep.Check != nil {
+		return nil
+	}
+	if dep.Target == "" {
+		return fmt.Errorf("dependency '%s' has no target to probe", dep.Name)
+	}
+	if dep.Probe != "" && dep.Probe != DependencyProbeTCP && dep.Probe != DependencyProbeHTTP {
+		return fmt.Errorf("dependency '%s' has unknown probe '%s'", dep.Name, dep.Probe)
+	}
+	return nil
+}
+
+// Health returns the status of the server and its dependencies.
+func (ps *platformServer) Health() *HealthReport {
+	report := &HealthReport{
+		Status:       HealthReady,
+		Http:         ps.ServerAvailability.Http,
+		Fabric:       ps.ServerAvailability.Fabric,
+		Dependencies: []*DependencyStatus{},
+	}
+	monitor := ps.dependencies
+	if monitor == nil {
+		return report
+	}
+	monitor.lock.Lock()
+	defer monitor.lock.Unlock()
+	for _, status := range monitor.statuses {
+		s := *status
+		report.Dependencies = append(report.Dependencies, &s)
+		if !s.Up && !s.Optional {
+			report.Status = HealthDegraded
+		}
+	}
+	if !monitor.online {
+		report.Status = HealthStarting
+	}
+	return report
+}
+
+// startDependencyProbes probes every dependency in the background. A dependency that is down is probed
+// again with exponential backoff, one that is up is checked every IntervalSeconds.
+func (ps *platformServer) startDependencyProbes() {
+	monitor := ps.dependencies
+	if monitor == nil {
+		return
+	}
+	for i, dep := range ps.serverConfig.Dependencies.Dependencies {
+		go ps.probeDependency(monitor, monitor.statuses[i], dep)
+	}
+}
+
+func (ps *platformServer) probeDependency(monitor *dependencyMonitor, status *DependencyStatus, dep *DependencyConfig) {
+	timeout := time.Duration(dep.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = defaultDependencyTimeout
+	}
+	interval := time.Duration(dep.IntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = defaultDependencyInterval
+	}
+	maxBackoff := time.Duration(dep.MaxBackoffSeconds) * time.Second
+	if maxBackoff <= 0 {
+		maxBackoff = defaultDependencyMaxBackoff
+	}
+	check := dep.Check
+	if check == nil {
+		check = dependencyProbe(dep, timeout)
+	}
+
+	backoff := dependencyMinBackoff
+	everUp := false
+	for {
+		ctx, cancel := clock.WithTimeout(context.Background(), timeout)
+		err := check(ctx)
+		cancel()
+
+		monitor.lock.Lock()
+		wasUp := status.Up
+		status.Attempts++
+		status.LastChecked = clock.Now().UTC()
+		status.Up = err == nil
+		status.LastError = ""
+		if err != nil {
+			status.LastError = err.Error()
+		}
+		giveUp := err != nil && !everUp && dep.MaxStartupAttempts > 0 && status.Attempts >= dep.MaxStartupAttempts
+		status.Failed = giveUp
+		if wasUp != status.Up || giveUp {
+			close(monitor.changed)
+			monitor.changed = make(chan struct{})
+		}
+		monitor.lock.Unlock()
+
+		wait := interval
+		switch {
+		case err == nil:
+			if !wasUp {
+				ps.serverConfig.Logger.Info("[ranch] dependency is up", "dependency", dep.Name)
+			}
+			everUp = true
+			backoff = dependencyMinBackoff
+		case giveUp:
+			ps.serverConfig.Logger.Error("[ranch] dependency unavailable, giving up", "dependency", dep.Name,
+				"attempts", status.Attempts, "error", err.Error())
+			return
+		default:
+			if wasUp || status.Attempts == 1 {
+				ps.serverConfig.Logger.Warn("[ranch] dependency is down", "dependency", dep.Name, "error", err.Error())
+			}
+			wait = backoff
+			backoff = min(backoff*2, maxBackoff)
+		}
+
+		select {
+		case <-monitor.stop:
+			return
+		case <-clock.After(wait):
+		}
+	}
+}
+
+// dependencyProbe returns the built-in probe for a dependency.
+func dependencyProbe(dep *DependencyConfig, timeout time.Duration) func(ctx context.Context) error {
+	if dep.Probe == DependencyProbeHTTP {
+		client := &http.Client{Timeout: timeout}
+		return func(ctx context.Context) error {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, dep.Target, nil)
+			if err != nil {
+				return err
+			}
+			rsp, err := client.Do(req)
+			if err != nil {
+				return err
+			}
+			_ = rsp.Body.Close()
+			if rsp.StatusCode >= http.StatusBadRequest {
+				return fmt.Errorf("unexpected status %d", rsp.StatusCode)
+			}
+			return nil
+		}
+	}
+	return func(ctx context.Context) error {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", dep.Target)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+// awaitDependencies blocks until every required dependency of the phase is up. It returns an error if
+// one was given up on, and false if the server stopped in the meantime.
+func (ps *platformServer) awaitDependencies(phase string) (bool, error) {
+	monitor := ps.dependencies
+	if monitor == nil {
+		return true, nil
+	}
+	logged := false
+	for {
+		monitor.lock.Lock()
+		waiting := 0
+		var failed *DependencyStatus
+		for _, status := range monitor.statuses {
+			if status.Phase != phase || status.Optional || status.Up {
+				continue
+			}
+			if status.Failed {
+				failed = status
+			}
+			waiting++
+		}
+		changed := monitor.changed
+		monitor.lock.Unlock()
+
+		if failed != nil {
+			return false, fmt.Errorf("dependency '%s' unavailable after %d attempts: %s", failed.Name,
+				failed.Attempts, failed.LastError)
+		}
+		if waiting == 0 {
+			return true, nil
+		}
+		if !logged {
+			ps.serverConfig.Logger.Info("[ranch] waiting for dependencies", "phase", phase, "waiting", waiting)
+			logged = true
+		}
+		select {
+		case <-monitor.stop:
+			return false, nil
+		case <-changed:
+		}
+	}
+}
+
+// markOnline records that the server reported being online, so health output stops reporting it as starting.
+func (ps *platformServer) markOnline() {
+	if ps.health != nil {
+		ps.health.online.Store(true)
+	}
+	if monitor := ps.dependencies; monitor != nil {
+		monitor.lock.Lock()
+		monitor.online = true
+		monitor.lock.Unlock()
+	}
+}
+
+// stopDependencyProbes stops probing dependencies, and waiting for them.
+func (ps *platformServer) stopDependencyProbes() {
+	monitor := ps.dependencies
+	if monitor == nil {
+		return
+	}
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	select {
+	case <-monitor.stop:
+	default:
+		close(monitor.stop)
+	}
+}
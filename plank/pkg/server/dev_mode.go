@@ -0,0 +1,137 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pb33f/ranch/clock"
+)
+
+// LoadDevModeConfig reads a DevModeConfig from a JSON file. Fixtures are looked up next to the file unless
+// it sets a fixtures directory.
+func LoadDevModeConfig(path string) (*DevModeConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg DevModeConfig
+	if err = json.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid dev mode file %s: %w", path, err)
+	}
+	if cfg.FixturesDir == "" {
+		cfg.FixturesDir = filepath.Dir(path)
+	}
+	return &cfg, nil
+}
+
+// initDevMode registers the mock bridges, if dev mode is configured. They are registered before any service
+// can bridge the same endpoints, so a registered service does not replace the canned responses.
+func (ps *platformServer) initDevMode() {
+	cfg := ps.serverConfig.DevMode
+	if cfg == nil {
+		return
+	}
+	fixturesDir := cfg.FixturesDir
+	if fixturesDir == "" {
+		fixturesDir = ps.serverConfig.RootDir
+	}
+	for _, bridge := range cfg.Bridges {
+		if bridge.Uri == "" || bridge.Fixture == "" {
+			ps.serverConfig.Logger.Error(wrapError(errServerInit,
+				fmt.Errorf("mock bridge needs a uri and a fixture: %+v", bridge)).Error())
+			continue
+		}
+		method := bridge.Method
+		if method == "" {
+			method = http.MethodGet
+		}
+		endpointHandlerKey := bridge.Uri + "-" + method
+		ps.endpointHandlerMap[endpointHandlerKey] = ps.mockBridgeHandler(bridge, fixturesDir, cfg.LatencyMillis)
+		ps.router.
+			Path(bridge.Uri).
+			Methods(method).
+			Name(endpointHandlerKey).
+			Handler(ps.endpointHandlerMap[endpointHandlerKey])
+		ps.serverConfig.Logger.Warn("[ranch] dev mode, REST endpoint serves a canned fixture",
+			"url", bridge.Uri, "method", method, "fixture", bridge.Fixture)
+	}
+}
+
+// mockBridgeHandler serves the fixture of the bridge after its latency. The fixture is read on every request,
+// so it can be edited while the server runs.
+func (ps *platformServer) mockBridgeHandler(bridge *MockBridgeConfig, fixturesDir string,
+	defaultLatencyMillis int) http.HandlerFunc {
+
+	latencyMillis := bridge.LatencyMillis
+	if latencyMillis == 0 {
+		latencyMillis = defaultLatencyMillis
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		delay := time.Duration(latencyMillis) * time.Millisecond
+		if bridge.JitterMillis > 0 {
+			delay += time.Duration(rand.IntN(bridge.JitterMillis+1)) * time.Millisecond
+		}
+		if delay > 0 {
+			select {
+			case <-clock.After(delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		fixture, ok := resolveFixture(fixturesDir, bridge.Fixture, mux.Vars(r))
+		if !ok {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		body, err := os.ReadFile(fixture)
+		if err != nil {
+			ps.serverConfig.Logger.Warn("[ranch] unable to read dev mode fixture", "fixture", fixture, "error", err.Error())
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+
+		contentType := bridge.ContentType
+		if contentType == "" {
+			contentType = mime.TypeByExtension(filepath.Ext(fixture))
+		}
+		if contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+		for k, v := range bridge.Headers {
+			w.Header().Set(k, v)
+		}
+		statusCode := bridge.StatusCode
+		if statusCode == 0 {
+			statusCode = http.StatusOK
+		}
+		w.WriteHeader(statusCode)
+		_, _ = w.Write(body)
+	}
+}
+
+// resolveFixture returns the path of the fixture, with every {var} of the fixture name replaced by the path
+// variable of the request, e.g. users/{id}.json. Returns false if a variable would leave fixturesDir.
+func resolveFixture(fixturesDir string, fixture string, vars map[string]string) (string, bool) {
+	for name, value := range vars {
+		if value == "" || value == "." || value == ".." || strings.ContainsAny(value, `/\`) {
+			return "", false
+		}
+		fixture = strings.ReplaceAll(fixture, "{"+name+"}", value)
+	}
+	if filepath.IsAbs(fixture) {
+		return fixture, true
+	}
+	return filepath.Join(fixturesDir, fixture), true
+}
@@ -0,0 +1,109 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/clock"
+	"github.com/pb33f/ranch/model"
+	"github.com/pb33f/ranch/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newDevModeTestServer(t *testing.T, latencyMillis int) *platformServer {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "users"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "users", "42.json"), []byte(`{"id":42,"name":"dave"}`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "teapot.txt"), []byte("short and stout"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "dev.json"), []byte(`{
+		"latency_millis": `+strconv.Itoa(latencyMillis)+`,
+		"bridges": [
+			{"uri": "/api/users/{id}", "fixture": "users/{id}.json"},
+			{"uri": "/api/tea", "method": "POST", "fixture": "teapot.txt", "status_code": 418,
+			 "headers": {"X-Brew": "earl-grey"}, "latency_millis": -1}
+		]
+	}`), 0o644))
+
+	devMode, err := LoadDevModeConfig(filepath.Join(dir, "dev.json"))
+	require.NoError(t, err)
+	assert.Equal(t, dir, devMode.FixturesDir)
+
+	bus.ResetBus()
+	service.ResetServiceRegistry()
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", GetTestPort(), true)
+	config.DevMode = devMode
+	return NewPlatformServer(config).(*platformServer)
+}
+
+func TestPlatformServer_DevMode(t *testing.T) {
+	fake := clock.NewFakeClock(time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC))
+	clock.Set(fake)
+	defer clock.Reset()
+	ps := newDevModeTestServer(t, 250)
+
+	// the fixture is served after the latency
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		ps.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://localhost/api/users/42", nil))
+		close(done)
+	}()
+	fake.BlockUntil(1)
+	fake.Advance(249 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("responded before the latency")
+	case <-time.After(20 * time.Millisecond):
+	}
+	fake.Advance(time.Millisecond)
+	<-done
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"id":42,"name":"dave"}`, rec.Body.String())
+
+	// a negative latency turns off the default
+	rec = httptest.NewRecorder()
+	ps.router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "http://localhost/api/tea", nil))
+	assert.Equal(t, http.StatusTeapot, rec.Code)
+	assert.Equal(t, "earl-grey", rec.Header().Get("X-Brew"))
+	assert.Equal(t, "short and stout", rec.Body.String())
+}
+
+func TestPlatformServer_DevModeMissingFixture(t *testing.T) {
+	ps := newDevModeTestServer(t, 0)
+	rec := httptest.NewRecorder()
+	ps.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://localhost/api/users/7", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// path variables cannot leave the fixtures directory
+	fixture, ok := resolveFixture("/fixtures", "users/{id}.json", map[string]string{"id": "42"})
+	assert.True(t, ok)
+	assert.Equal(t, "/fixtures/users/42.json", fixture)
+	for _, id := range []string{"..", "../../etc/passwd", `..\secrets`, ""} {
+		_, ok = resolveFixture("/fixtures", "users/{id}.json", map[string]string{"id": id})
+		assert.False(t, ok, id)
+	}
+}
+
+func TestPlatformServer_DevModeTakesPrecedence(t *testing.T) {
+	ps := newDevModeTestServer(t, 0)
+	ps.SetHttpChannelBridge(&service.RESTBridgeConfig{
+		ServiceChannel: "users-service",
+		Uri:            "/api/users/{id}",
+		Method:         http.MethodGet,
+		FabricRequestBuilder: func(w http.ResponseWriter, r *http.Request) model.Request {
+			return model.Request{RequestCommand: "get-user"}
+		},
+	})
+	assert.Empty(t, ps.serviceChanToBridgeEndpoints["users-service"])
+}
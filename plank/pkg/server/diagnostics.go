@@ -0,0 +1,278 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"reflect"
+	"sort"
+
+	"github.com/gorilla/mux"
+	"github.com/pb33f/ranch/clock"
+	"github.com/pb33f/ranch/plank/pkg/diagnostics"
+	"github.com/pb33f/ranch/plank/pkg/redact"
+	"github.com/pb33f/ranch/service"
+)
+
+// routeManifest describes a route registered with the router.
+type routeManifest struct {
+	Name    string   `json:"name"`
+	Path    string   `json:"path"`
+	Methods []string `json:"methods,omitempty"`
+}
+
+// channelManifest describes a bus channel.
+type channelManifest struct {
+	Name        string `json:"name"`
+	Galactic    bool   `json:"galactic"`
+	Private     bool   `json:"private"`
+	HasHandlers bool   `json:"has_handlers"`
+}
+
+// serviceManifest describes a registered fabric service and the REST endpoints bridged to it.
+type serviceManifest struct {
+	Channel   string   `json:"channel"`
+	Type      string   `json:"type"`
+	Endpoints []string `json:"endpoints,omitempty"`
+}
+
+// serverMetrics is the metrics snapshot included in a diagnostics bundle.
+type serverMetrics struct {
+	Runtime        *diagnostics.RuntimeMetrics      `json:"runtime"`
+	Availability   ServerAvailability               `json:"availability"`
+	Routes         int                              `json:"routes"`
+	Channels       int                              `json:"channels"`
+	Services       int                              `json:"services"`
+	BrokerBridges  map[string]bool                  `json:"broker_bridges_connected,omitempty"`
+	SiemDropped    map[string]uint64                `json:"siem_events_dropped,omitempty"`
+	Cors           *CorsMetrics                     `json:"cors,omitempty"`
+	MessageBridges map[string]*MessageBridgeMetrics `json:"message_bridges,omitempty"`
+}
+
+// initDiagnostics starts capturing recent log records when diagnostics are enabled. The configured logger
+// is wrapped, so everything logged through it ends up in diagnostics bundles.
+func (ps *platformServer) initDiagnostics() {
+	cfg := ps.serverConfig.Diagnostics
+	if cfg == nil {
+		return
+	}
+	if buffer, ok := ps.serverConfig.Logger.Handler().(*diagnostics.LogBuffer); ok {
+		ps.logBuffer = buffer
+		return
+	}
+	ps.logBuffer = diagnostics.NewLogBuffer(ps.serverConfig.Logger.Handler(), cfg.LogBufferSize)
+	ps.serverConfig.Logger = slog.New(ps.logBuffer)
+}
+
+// setDiagnosticsRoute registers the endpoint diagnostics bundles are downloaded from, if one is configured.
+func (ps *platformServer) setDiagnosticsRoute() {
+	cfg := ps.serverConfig.Diagnostics
+	if cfg == nil || cfg.Endpoint == "" {
+		return
+	}
+	ps.router.Path(cfg.Endpoint).Name(cfg.Endpoint).Methods(http.MethodGet).HandlerFunc(ps.adminHandler(
+		"diagnostics bundle", cfg.Authorize, func(w http.ResponseWriter, r *http.Request) {
+			var buf bytes.Buffer
+			if err := ps.WriteDiagnosticsBundle(&buf); err != nil {
+				ps.serverConfig.Logger.Error("[ranch] unable to generate diagnostics bundle", "error", err.Error())
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			ps.serverConfig.Logger.Info("[ranch] diagnostics bundle generated", "remote", r.RemoteAddr, "bytes", buf.Len())
+			w.Header().Set("Content-Type", "application/zip")
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"ranch-diagnostics-%s.zip\"",
+				clock.Now().UTC().Format("20060102T150405Z")))
+			w.Header().Set("Cache-Control", "no-store")
+			_, _ = w.Write(buf.Bytes())
+		}))
+	ps.serverConfig.Logger.Info("[ranch] diagnostics bundle endpoint enabled", "endpoint", cfg.Endpoint)
+}
+
+// adminHandler guards the handler of an admin route, refusing requests authorize does not allow with a
+// 403. Only local, unproxied clients, and those of trusted listeners, are allowed when authorize is nil.
+// Refusals are logged, naming the route by what.
+func (ps *platformServer) adminHandler(what string, authorize func(r *http.Request) bool,
+	handler http.HandlerFunc) http.HandlerFunc {
+
+	if authorize == nil {
+		authorize = func(r *http.Request) bool {
+			if lc := listenerFromRequest(r); lc != nil && lc.Trusted {
+				return true
+			}
+			return isLocalRequest(r)
+		}
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorize(r) {
+			ps.serverConfig.Logger.Warn("[ranch] "+what+" request denied", "remote", r.RemoteAddr,
+				"method", r.Method, "path", r.URL.Path)
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// isLocalRequest only lets through requests made from the machine itself. Requests carrying forwarding
+// headers are refused, as the proxy headers middleware rewrites the remote address from them.
+func isLocalRequest(r *http.Request) bool {
+	for _, header := range []string{"Forwarded", "X-Forwarded-For", "X-Real-Ip"} {
+		if r.Header.Get(header) != "" {
+			return false
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// WriteDiagnosticsBundle writes a zip archive to w containing the configuration with secrets masked,
+// recent logs (when diagnostics are enabled), goroutine and heap profiles, route, channel and service
+// manifests and a metrics snapshot. Parts that cannot be collected are listed in the bundle manifest.
+func (ps *platformServer) WriteDiagnosticsBundle(w io.Writer) error {
+	bundle := diagnostics.NewBundle(w)
+
+	config, err := redact.JSON(ps.serverConfig)
+	if err == nil {
+		err = bundle.AddFile("config.json", config)
+	}
+	if err != nil {
+		bundle.AddError("config.json", err)
+	}
+
+	if ps.logBuffer != nil {
+		var logs bytes.Buffer
+		encoder := json.NewEncoder(&logs)
+		for _, entry := range ps.logBuffer.Entries() {
+			if err = encoder.Encode(entry); err != nil {
+				bundle.AddError("logs.jsonl", err)
+				break
+			}
+		}
+		if err = bundle.AddFile("logs.jsonl", logs.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	for _, profile := range []struct {
+		file, name string
+		debug      int
+	}{
+		{"goroutines.txt", "goroutine", 2},
+		{"heap.pprof", "heap", 0},
+	} {
+		if err = bundle.AddProfile(profile.file, profile.name, profile.debug); err != nil {
+			bundle.AddError(profile.file, err)
+		}
+	}
+
+	routes, channels, services := ps.routeManifests(), ps.channelManifests(), ps.serviceManifests()
+	parts := []struct {
+		file string
+		v    interface{}
+	}{
+		{"routes.json", routes},
+		{"channels.json", channels},
+		{"services.json", services},
+		{"metrics.json", ps.metricsSnapshot(len(routes), len(channels), len(services))},
+	}
+	for _, part := range parts {
+		if err = bundle.AddJSON(part.file, part.v); err != nil {
+			bundle.AddError(part.file, err)
+		}
+	}
+	return bundle.Close()
+}
+
+func (ps *platformServer) routeManifests() []*routeManifest {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	routes := make([]*routeManifest, 0)
+	if ps.router == nil {
+		return routes
+	}
+	_ = ps.router.Walk(func(r *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := r.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, _ := r.GetMethods()
+		routes = append(routes, &routeManifest{Name: r.GetName(), Path: path, Methods: methods})
+		return nil
+	})
+	return routes
+}
+
+func (ps *platformServer) channelManifests() []*channelManifest {
+	channels := make([]*channelManifest, 0)
+	for name, channel := range ps.eventbus.GetChannelManager().GetAllChannels() {
+		channels = append(channels, &channelManifest{
+			Name:        name,
+			Galactic:    channel.IsGalactic(),
+			Private:     channel.IsPrivate(),
+			HasHandlers: channel.ContainsHandlers(),
+		})
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i].Name < channels[j].Name })
+	return channels
+}
+
+func (ps *platformServer) serviceManifests() []*serviceManifest {
+	registry := service.GetServiceRegistry()
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	services := make([]*serviceManifest, 0)
+	for _, channel := range registry.GetAllServiceChannels() {
+		manifest := &serviceManifest{Channel: channel}
+		if svc, err := registry.GetService(channel); err == nil {
+			manifest.Type = reflect.TypeOf(svc).String()
+		}
+		manifest.Endpoints = append(manifest.Endpoints, ps.serviceChanToBridgeEndpoints[channel]...)
+		sort.Strings(manifest.Endpoints)
+		services = append(services, manifest)
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Channel < services[j].Channel })
+	return services
+}
+
+func (ps *platformServer) metricsSnapshot(routes, channels, services int) *serverMetrics {
+	metrics := &serverMetrics{
+		Runtime:  diagnostics.ReadRuntimeMetrics(),
+		Routes:   routes,
+		Channels: channels,
+		Services: services,
+	}
+	if ps.ServerAvailability != nil {
+		metrics.Availability = *ps.ServerAvailability
+	}
+	ps.lock.Lock()
+	bridges, exporters := ps.brokerBridges, ps.siemExporters
+	ps.lock.Unlock()
+	if len(bridges) > 0 {
+		metrics.BrokerBridges = make(map[string]bool, len(bridges))
+		for _, bb := range bridges {
+			metrics.BrokerBridges[bb.config.Name] = bb.isConnected()
+		}
+	}
+	if ps.cors != nil {
+		metrics.Cors = ps.cors.metrics()
+	}
+	metrics.MessageBridges = ps.messageBridgeMetrics()
+	if len(exporters) > 0 {
+		metrics.SiemDropped = make(map[string]uint64, len(exporters))
+		for _, exporter := range exporters {
+			metrics.SiemDropped[exporter.Name()] = exporter.Dropped()
+		}
+	}
+	return metrics
+}
@@ -0,0 +1,194 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/clock"
+	"github.com/pb33f/ranch/model"
+	"github.com/pb33f/ranch/plank/pkg/edgecache"
+	"github.com/pb33f/ranch/service"
+)
+
+const defaultEdgeCachePurgeTimeout = 30 * time.Second
+
+// edgeCacheState remembers the paths of the REST bridges tagged with each surrogate key, so invalidations
+// by key can be purged from CDNs that purge by path.
+type edgeCacheState struct {
+	lock     sync.Mutex
+	header   string
+	keyPaths map[string][]string
+	handler  bus.MessageHandler
+	purges   sync.WaitGroup
+}
+
+// initEdgeCache starts tagging REST bridge responses with surrogate keys, if configured.
+func (ps *platformServer) initEdgeCache() {
+	cfg := ps.serverConfig.EdgeCache
+	if cfg == nil {
+		return
+	}
+	header := cfg.SurrogateKeyHeader
+	if header == "" {
+		header = edgecache.DefaultSurrogateKeyHeader
+	}
+	ps.edgeCache = &edgeCacheState{
+		header:   http.CanonicalHeaderKey(header),
+		keyPaths: make(map[string][]string),
+	}
+}
+
+// bridgeSurrogateKeys returns the surrogate keys responses of a REST bridge are tagged with, the service
+// channel followed by the keys of the bridge configuration.
+func bridgeSurrogateKeys(bridgeConfig *service.RESTBridgeConfig) []string {
+	keys := []string{edgecache.SanitizeKey(bridgeConfig.ServiceChannel)}
+	for _, key := range bridgeConfig.SurrogateKeys {
+		if key = edgecache.SanitizeKey(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// bridgeInvalidationPath returns the path that covers everything a REST bridge serves. Route variables
+// cannot be purged individually, so the path is cut at the first one and matches everything under it.
+func bridgeInvalidationPath(uri string, prefix bool) string {
+	if i := strings.Index(uri, "{"); i >= 0 {
+		return uri[:i] + "*"
+	}
+	if prefix {
+		return uri + "*"
+	}
+	return uri
+}
+
+// tagResponses wraps the handler of a REST bridge, tagging its GET and HEAD responses with the surrogate
+// keys of the bridge.
+func (ec *edgeCacheState) tagResponses(bridgeConfig *service.RESTBridgeConfig, prefix bool,
+	handler http.HandlerFunc) http.HandlerFunc {
+
+	keys := bridgeSurrogateKeys(bridgeConfig)
+	path := bridgeInvalidationPath(bridgeConfig.Uri, prefix)
+	ec.lock.Lock()
+	for _, key := range keys {
+		ec.keyPaths[key] = appendMissing(ec.keyPaths[key], path)
+	}
+	ec.lock.Unlock()
+
+	value := strings.Join(keys, " ")
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			w.Header().Set(ec.header, value)
+		}
+		handler(w, r)
+	}
+}
+
+// setResponseHeader sets a header of a REST bridge response. Surrogate keys the service tags its response
+// with are added to those of the bridge rather than replacing them.
+func (ps *platformServer) setResponseHeader(w http.ResponseWriter, name string, value interface{}) {
+	v := fmt.Sprint(value)
+	if ps.edgeCache != nil && http.CanonicalHeaderKey(name) == ps.edgeCache.header {
+		if keys := w.Header().Get(name); keys != "" {
+			v = keys + " " + v
+		}
+	}
+	w.Header().Set(name, v)
+}
+
+// resolvePaths adds the paths of the REST bridges tagged with the keys of the invalidation.
+func (ec *edgeCacheState) resolvePaths(invalidation *edgecache.Invalidation) *edgecache.Invalidation {
+	resolved := &edgecache.Invalidation{Keys: invalidation.Keys, Paths: append([]string{}, invalidation.Paths...)}
+	ec.lock.Lock()
+	defer ec.lock.Unlock()
+	for _, key := range invalidation.Keys {
+		for _, path := range ec.keyPaths[key] {
+			resolved.Paths = appendMissing(resolved.Paths, path)
+		}
+	}
+	return resolved
+}
+
+// startEdgeCachePurges purges the configured CDNs whenever an invalidation is published on
+// RANCH_EDGE_CACHE_INVALIDATION_CHANNEL.
+func (ps *platformServer) startEdgeCachePurges() {
+	ec := ps.edgeCache
+	if ec == nil || len(ps.serverConfig.EdgeCache.Purgers) == 0 {
+		return
+	}
+	cm := ps.eventbus.GetChannelManager()
+	if !cm.CheckChannelExists(RANCH_EDGE_CACHE_INVALIDATION_CHANNEL) {
+		cm.CreateChannel(RANCH_EDGE_CACHE_INVALIDATION_CHANNEL)
+	}
+	handler, err := ps.eventbus.ListenFirehose(RANCH_EDGE_CACHE_INVALIDATION_CHANNEL)
+	if err != nil {
+		ps.serverConfig.Logger.Error(wrapError(errServerInit, err).Error())
+		return
+	}
+	handler.Handle(func(msg *model.Message) {
+		if invalidation := edgecache.FromPayload(msg.Payload); invalidation != nil {
+			ps.purgeEdgeCache(ec.resolvePaths(invalidation))
+		}
+	}, func(err error) {})
+
+	ps.lock.Lock()
+	ec.handler = handler
+	ps.lock.Unlock()
+}
+
+// purgeEdgeCache hands the invalidation to every purger at once, failures are logged.
+func (ps *platformServer) purgeEdgeCache(invalidation *edgecache.Invalidation) {
+	cfg := ps.serverConfig.EdgeCache
+	timeout := time.Duration(cfg.PurgeTimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = defaultEdgeCachePurgeTimeout
+	}
+	for _, purger := range cfg.Purgers {
+		ps.edgeCache.purges.Add(1)
+		go func() {
+			defer ps.edgeCache.purges.Done()
+			ctx, cancel := clock.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			if err := purger.Purge(ctx, invalidation); err != nil {
+				ps.serverConfig.Logger.Error("[ranch] edge cache purge failed", "purger", purger.Name(),
+					"keys", invalidation.Keys, "paths", invalidation.Paths, "error", err.Error())
+				return
+			}
+			ps.serverConfig.Logger.Debug("[ranch] edge cache purged", "purger", purger.Name(),
+				"keys", invalidation.Keys, "paths", invalidation.Paths)
+		}()
+	}
+}
+
+// stopEdgeCachePurges stops listening for invalidations and waits for the purges in progress.
+func (ps *platformServer) stopEdgeCachePurges() {
+	ec := ps.edgeCache
+	if ec == nil {
+		return
+	}
+	ps.lock.Lock()
+	handler := ec.handler
+	ec.handler = nil
+	ps.lock.Unlock()
+	if handler != nil {
+		handler.Close()
+	}
+	ec.purges.Wait()
+}
+
+func appendMissing(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
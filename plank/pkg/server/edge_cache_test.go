@@ -0,0 +1,100 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/model"
+	"github.com/pb33f/ranch/plank/pkg/edgecache"
+	"github.com/pb33f/ranch/service"
+	"github.com/stretchr/testify/assert"
+)
+
+type edgeCacheTestService struct{}
+
+func (s *edgeCacheTestService) HandleServiceRequest(request *model.Request, core service.FabricServiceCore) {
+	core.SendResponseWithHeaders(request, "moo", map[string]any{"Surrogate-Key": "cow-" + request.RequestCommand})
+}
+
+type recordingPurger struct {
+	purged chan *edgecache.Invalidation
+}
+
+func (p *recordingPurger) Name() string {
+	return "recording"
+}
+
+func (p *recordingPurger) Purge(ctx context.Context, invalidation *edgecache.Invalidation) error {
+	p.purged <- invalidation
+	return nil
+}
+
+func TestBridgeInvalidationPath(t *testing.T) {
+	assert.Equal(t, "/moo", bridgeInvalidationPath("/moo", false))
+	assert.Equal(t, "/moo/*", bridgeInvalidationPath("/moo/", true))
+	assert.Equal(t, "/moo/*", bridgeInvalidationPath("/moo/{name}/baa", false))
+}
+
+func TestPlatformServer_EdgeCache(t *testing.T) {
+	newBus := bus.ResetBus()
+	service.ResetServiceRegistry()
+	port := GetTestPort()
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", port, true)
+	purger := &recordingPurger{purged: make(chan *edgecache.Invalidation, 1)}
+	config.EdgeCache = &EdgeCacheConfig{Purgers: []edgecache.Purger{purger}}
+	ps := NewPlatformServer(config)
+	ps.(*platformServer).eventbus = newBus
+	_ = ps.RegisterService(&edgeCacheTestService{}, "cow-service")
+	ps.SetHttpChannelBridge(&service.RESTBridgeConfig{
+		ServiceChannel: "cow-service",
+		Uri:            "/cows/{name}",
+		Method:         http.MethodGet,
+		SurrogateKeys:  []string{"herd"},
+		FabricRequestBuilder: func(w http.ResponseWriter, r *http.Request) model.Request {
+			return model.Request{Id: &uuid.UUID{}, RequestCommand: "daisy"}
+		},
+	})
+	ps.SetHttpPathPrefixChannelBridge(&service.RESTBridgeConfig{
+		ServiceChannel: "cow-service",
+		Uri:            "/barn/",
+		FabricRequestBuilder: func(w http.ResponseWriter, r *http.Request) model.Request {
+			return model.Request{Id: &uuid.UUID{}, RequestCommand: "bessie"}
+		},
+	})
+
+	syschan := make(chan os.Signal, 1)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go ps.StartServer(syschan)
+	RunWhenServerReady(t, newBus, func(t2 *testing.T) {
+		rsp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/cows/daisy", port))
+		if assert.Nil(t, err) {
+			_ = rsp.Body.Close()
+			assert.Equal(t, "cow-service herd cow-daisy", rsp.Header.Get("Surrogate-Key"))
+		}
+
+		_ = newBus.SendResponseMessage(RANCH_EDGE_CACHE_INVALIDATION_CHANNEL,
+			&edgecache.Invalidation{Keys: []string{"cow-service"}, Paths: []string{"/pasture"}}, nil)
+		assert.Equal(t, &edgecache.Invalidation{
+			Keys:  []string{"cow-service"},
+			Paths: []string{"/pasture", "/cows/*", "/barn/*"},
+		}, <-purger.purged)
+
+		// invalidations relayed from fabric clients arrive as JSON.
+		_ = newBus.SendResponseMessage(RANCH_EDGE_CACHE_INVALIDATION_CHANNEL, []byte(`{"keys":["cow-daisy"]}`), nil)
+		assert.Equal(t, &edgecache.Invalidation{Keys: []string{"cow-daisy"}, Paths: []string{}}, <-purger.purged)
+
+		ps.StopServer()
+		wg.Done()
+	})
+	wg.Wait()
+}
@@ -3,8 +3,13 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/google/uuid"
+	"github.com/pb33f/ranch/clock"
 	"github.com/pb33f/ranch/model"
+	"github.com/pb33f/ranch/plank/pkg/classify"
+	"github.com/pb33f/ranch/plank/pkg/serializer"
 	"github.com/pb33f/ranch/service"
 	"net/http"
 	"reflect"
@@ -12,8 +17,9 @@ import (
 )
 
 // buildEndpointHandler builds a http.HandlerFunc that wraps Transport Bus operations in an HTTP request-response cycle.
-// service channel, request builder and rest bridge timeout are passed as parameters.
-func (ps *platformServer) buildEndpointHandler(svcChannel string, reqBuilder service.RequestBuilder, restBridgeTimeout time.Duration, msgChan chan *model.Message) http.HandlerFunc {
+// service channel, request builder, rest bridge timeout, the message bridge of the service channel and the limits of
+// request bodies are passed as parameters. The responses a request gets are those addressed to its id.
+func (ps *platformServer) buildEndpointHandler(svcChannel string, reqBuilder service.RequestBuilder, restBridgeTimeout time.Duration, bridge *MessageBridge, limits requestLimits) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if r := recover(); r != nil {
@@ -22,13 +28,50 @@ func (ps *platformServer) buildEndpointHandler(svcChannel string, reqBuilder ser
 			}
 		}()
 
-		// set context that expires after the provided amount of time in restBridgeTimeout to prevent requests from hanging forever
-		ctx, cancelFn := context.WithTimeout(context.Background(), restBridgeTimeout)
+		// set context that expires after the provided amount of time in restBridgeTimeout to prevent requests from hanging forever,
+		// kept on the shared timer wheel so the many requests waiting at once do not each hold a runtime timer
+		ctx, cancelFn := clock.WithTimeout(context.Background(), restBridgeTimeout)
 		defer cancelFn()
 
+		// refuse oversized and slow request bodies before they make it into a bus message
+		r, status, err := limits.readBody(w, r)
+		if err != nil {
+			ps.serverConfig.Logger.Debug("[ranch] REST bridge request refused", "channel", svcChannel,
+				"path", r.URL.Path, "error", err.Error())
+			http.Error(w, err.Error(), status)
+			return
+		}
+		if form := service.MultipartFormFromRequest(r); form != nil {
+			// the files of the form are spooled for as long as the request lasts
+			defer func() { _ = form.RemoveAll() }()
+		}
+
 		// relay the request to transport channel
 		reqModel := reqBuilder(w, r)
-		err := ps.eventbus.SendRequestMessage(svcChannel, reqModel, reqModel.Id)
+		if reqModel.Ctx == nil {
+			reqModel.Ctx = r.Context()
+		}
+		if reqModel.Principal == "" {
+			reqModel.Principal = ps.httpPrincipal(r)
+		}
+		if reqModel.Labels == nil {
+			reqModel.Labels = classify.FromContext(r.Context())
+		}
+		if reqModel.Id == nil {
+			id := uuid.New()
+			reqModel.Id = &id
+		}
+
+		// wait for the responses to this request before sending it, so none is missed
+		channel, routedBridge := ps.routeBridgeRequest(svcChannel, bridge, r, reqModel.Principal)
+		responses, release := routedBridge.await(*reqModel.Id)
+		defer release()
+		if err = ps.eventbus.SendRequestMessage(channel, reqModel, reqModel.Id); err != nil {
+			ps.serverConfig.Logger.Error("[ranch] unable to relay request to service channel", "channel", channel,
+				"error", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 
 		// get a response from the channel, render the results using ResponseWriter and log the data/error
 		// to the console as well.
@@ -37,10 +80,14 @@ func (ps *platformServer) buildEndpointHandler(svcChannel string, reqBuilder ser
 			http.Error(
 				w,
 				fmt.Sprintf("no response received from service channel in %s, request timed out", restBridgeTimeout.String()), 500)
-		case msg := <-msgChan:
+		case msg := <-responses:
+			if response, ok := msg.Payload.(*model.Response); ok && msg.Error == nil && response.Stream {
+				ps.writeStreamedResponse(w, r, channel, response, responses, restBridgeTimeout)
+				return
+			}
 			if msg.Error != nil {
 				ps.serverConfig.Logger.Error(
-					"Error received from channel", "error", msg.Error, "channel", svcChannel)
+					"Error received from channel", "error", msg.Error, "channel", channel)
 				http.Error(w, msg.Error.Error(), 500)
 			} else {
 				// only send the actual user payloadChannel not wrapper information
@@ -60,13 +107,11 @@ func (ps *platformServer) buildEndpointHandler(svcChannel string, reqBuilder ser
 				if response.Error {
 
 					// we have to set the headers for the error response
-					for k, v := range response.Headers {
-						w.Header().Set(k, fmt.Sprint(v))
-					}
+					ps.setResponseHeaders(w, response)
 
 					// deal with the response body now, if set.
 					if respBody != "" {
-						w.WriteHeader(response.ErrorCode)
+						w.WriteHeader(errorStatusCode(response))
 						switch reflect.TypeOf(respBody).Kind() {
 						case reflect.String:
 							_, _ = w.Write([]byte(fmt.Sprint(respBody)))
@@ -84,7 +129,7 @@ func (ps *platformServer) buildEndpointHandler(svcChannel string, reqBuilder ser
 							if e != nil {
 								http.Error(w, e.Error(), 500)
 							} else {
-								w.WriteHeader(response.ErrorCode)
+								w.WriteHeader(errorStatusCode(response))
 								w.Write(n)
 							}
 						}
@@ -92,19 +137,33 @@ func (ps *platformServer) buildEndpointHandler(svcChannel string, reqBuilder ser
 				} else {
 					// if the response has headers, set those headers. particularly if you're sending around
 					// byte array data for things like zip files etc.
-					for k, v := range response.Headers {
-						w.Header().Set(k, fmt.Sprint(v))
-					}
+					ps.setResponseHeaders(w, response)
 
 					var respBodyBytes []byte
 					// ensure respBody is properly converted to a byte slice as Content-Type header might not be
 					// set in the request and the restBody could be in a format that is not a byte slice.
 					if response.Marshal {
-						respBodyBytes, err = ensureResponseInByteSlice(respBody)
+						respBodyBytes, err = ps.negotiateResponse(w, r, response, respBody)
+						if errors.Is(err, serializer.ErrNotAcceptable) {
+							http.Error(w, err.Error(), http.StatusNotAcceptable)
+							return
+						}
+						if err != nil {
+							ps.serverConfig.Logger.Error("[ranch] unable to render response", "channel", channel,
+								"error", err.Error())
+							http.Error(w, err.Error(), http.StatusInternalServerError)
+							return
+						}
 					} else {
 						respBodyBytes = []byte(fmt.Sprint(respBody))
 					}
 
+					// spare polling clients the bodies they have already
+					if ps.serverConfig.ETags && notModified(w, r, response.HttpStatusCode, respBodyBytes) {
+						writeNotModified(w)
+						return
+					}
+
 					if response.HttpStatusCode != 0 {
 						w.WriteHeader(response.HttpStatusCode)
 					}
@@ -112,7 +171,7 @@ func (ps *platformServer) buildEndpointHandler(svcChannel string, reqBuilder ser
 					// write the non-error payload back.
 					if _, err = w.Write(respBodyBytes); err != nil {
 						ps.serverConfig.Logger.Error("error received from channel", "error",
-							err.Error(), "channel", svcChannel)
+							err.Error(), "channel", channel)
 						http.Error(w, err.Error(), 500)
 					}
 				}
@@ -120,3 +179,35 @@ func (ps *platformServer) buildEndpointHandler(svcChannel string, reqBuilder ser
 		}
 	}
 }
+
+// setResponseHeaders sets the headers, content type and cookies of a service response on the HTTP response.
+func (ps *platformServer) setResponseHeaders(w http.ResponseWriter, response *model.Response) {
+	for k, v := range response.Headers {
+		if values, ok := v.([]string); ok {
+			w.Header().Del(k)
+			for _, value := range values {
+				w.Header().Add(k, value)
+			}
+			continue
+		}
+		ps.setResponseHeader(w, k, v)
+	}
+	if response.ContentType != "" {
+		w.Header().Set("Content-Type", response.ContentType)
+	}
+	for _, cookie := range response.Cookies {
+		http.SetCookie(w, cookie)
+	}
+}
+
+// errorStatusCode returns the status code of an error response: its error code, else its HTTP status code,
+// else 500.
+func errorStatusCode(response *model.Response) int {
+	switch {
+	case response.ErrorCode >= 100 && response.ErrorCode <= 999:
+		return response.ErrorCode
+	case response.HttpStatusCode != 0:
+		return response.HttpStatusCode
+	}
+	return http.StatusInternalServerError
+}
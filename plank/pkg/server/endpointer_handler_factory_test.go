@@ -1,22 +1,59 @@
 package server
 
 import (
+	"bufio"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/pb33f/ranch/bus"
 	"github.com/pb33f/ranch/model"
 	"github.com/pb33f/ranch/service"
 	"github.com/stretchr/testify/assert"
+	"io"
+	"mime/multipart"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
 
+// answerRequests answers the requests sent on a channel with the replies queued on the returned channel, one
+// set of replies per request, addressed to it. Replies are pushed to the message bridge directly, as the bus
+// does not keep their order. The requests answered are sent on the other channel returned.
+func answerRequests(t *testing.T, b bus.EventBus, bridge *MessageBridge, channel string) (chan []*model.Message, chan model.Request) {
+	replies, requests := make(chan []*model.Message, 4), make(chan model.Request, 4)
+	mh, err := b.ListenRequestStream(channel)
+	assert.NoError(t, err)
+	mh.Handle(func(message *model.Message) {
+		req := message.Payload.(model.Request)
+		select {
+		case msgs := <-replies:
+			for _, msg := range msgs {
+				reply(bridge, req.Id, msg)
+			}
+		default:
+		}
+		requests <- req
+	}, func(e error) {})
+	t.Cleanup(mh.Close)
+	return replies, requests
+}
+
+// reply pushes a response to a request to a message bridge.
+func reply(bridge *MessageBridge, id *uuid.UUID, msg *model.Message) {
+	msg.DestinationId = id
+	if rsp, ok := msg.Payload.(*model.Response); ok {
+		rsp.Id = id
+	}
+	bridge.payloads.push(msg)
+}
+
 func TestBuildEndpointHandler_Timeout(t *testing.T) {
 	b := bus.ResetBus()
 	service.ResetServiceRegistry()
-	msgChan := make(chan *model.Message, 1)
 	_ = b.GetChannelManager().CreateChannel("test-chan")
 	port := GetTestPort()
 	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", port, true)
@@ -27,50 +64,49 @@ func TestBuildEndpointHandler_Timeout(t *testing.T) {
 			Payload:        nil,
 			RequestCommand: "test-request",
 		}
-	}, 5*time.Millisecond, msgChan), "GET", "http://localhost", nil, "request timed out")
+	}, 5*time.Millisecond, ps.bridgeFor("test-chan"), requestLimits{}), "GET", "http://localhost", nil, "request timed out")
 }
 
 func TestBuildEndpointHandler_ChanResponseErr(t *testing.T) {
 	b := bus.ResetBus()
 	service.ResetServiceRegistry()
-	msgChan := make(chan *model.Message, 1)
 	_ = b.GetChannelManager().CreateChannel("test-chan")
 	port := GetTestPort()
 	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", port, true)
 	ps := NewPlatformServer(config).(*platformServer)
 	ps.eventbus = b
+	bridge := ps.bridgeFor("test-chan")
+	replies, _ := answerRequests(t, b, bridge, "test-chan")
+	replies <- []*model.Message{{Error: fmt.Errorf("test error")}}
 	assert.HTTPErrorf(t, ps.buildEndpointHandler("test-chan", func(w http.ResponseWriter, r *http.Request) model.Request {
-		uId := &uuid.UUID{}
-		msgChan <- &model.Message{Error: fmt.Errorf("test error")}
 		return model.Request{
-			Id:             uId,
 			Payload:        nil,
 			RequestCommand: "test-request",
 		}
-	}, 5*time.Second, msgChan), "GET", "http://localhost", nil, "test error")
+	}, 5*time.Second, bridge, requestLimits{}), "GET", "http://localhost", nil, "test error")
 }
 
 func TestBuildEndpointHandler_SuccessResponse(t *testing.T) {
 	b := bus.ResetBus()
 	service.ResetServiceRegistry()
-	msgChan := make(chan *model.Message, 1)
 	_ = b.GetChannelManager().CreateChannel("test-chan")
 	port := GetTestPort()
 	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", port, true)
 	ps := NewPlatformServer(config).(*platformServer)
 	ps.eventbus = b
+	bridge := ps.bridgeFor("test-chan")
+	replies, _ := answerRequests(t, b, bridge, "test-chan")
+	replies <- []*model.Message{{Payload: &model.Response{
+		Payload: "{\"error\": false}",
+	}}}
 	assert.HTTPBodyContains(t, ps.buildEndpointHandler("test-chan", func(w http.ResponseWriter, r *http.Request) model.Request {
-		uId := &uuid.UUID{}
-		msgChan <- &model.Message{Payload: &model.Response{
-			Id:      uId,
-			Payload: "{\"error\": false}",
-		}}
+		uId := uuid.New()
 		return model.Request{
-			Id:             uId,
+			Id:             &uId,
 			Payload:        nil,
 			RequestCommand: "test-request",
 		}
-	}, 5*time.Second, msgChan), "GET", "http://localhost", nil, "{\"error\": false}")
+	}, 5*time.Second, bridge, requestLimits{}), "GET", "http://localhost", nil, "{\"error\": false}")
 }
 
 func TestBuildEndpointHandler_ErrorResponse(t *testing.T) {
@@ -84,58 +120,53 @@ func TestBuildEndpointHandler_ErrorResponse(t *testing.T) {
 
 	expected := `{"error": true}`
 
-	msgChan := make(chan *model.Message, 1)
-	uId := &uuid.UUID{}
+	bridge := ps.bridgeFor("test-chan")
+	replies, _ := answerRequests(t, b, bridge, "test-chan")
 	rsp := &model.Response{
-		Id:        uId,
 		Payload:   expected,
 		ErrorCode: 500,
 		Error:     true,
 	}
+	replies <- []*model.Message{{Payload: rsp}}
 
 	assert.HTTPBodyContains(t, ps.buildEndpointHandler("test-chan", func(w http.ResponseWriter, r *http.Request) model.Request {
-		msgChan <- &model.Message{Payload: rsp}
 		return model.Request{
-			Id:             uId,
 			Payload:        nil,
 			RequestCommand: "test-request",
 		}
 
-	}, 5*time.Second, msgChan), "GET", "http://localhost", nil, expected)
+	}, 5*time.Second, bridge, requestLimits{}), "GET", "http://localhost", nil, expected)
 }
 
 func TestBuildEndpointHandler_ErrorResponseAlternative(t *testing.T) {
 	b := bus.ResetBus()
 	service.ResetServiceRegistry()
-	msgChan := make(chan *model.Message, 1)
 	_ = b.GetChannelManager().CreateChannel("test-chan")
 	port := GetTestPort()
 	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", port, true)
 	ps := NewPlatformServer(config).(*platformServer)
 	ps.eventbus = b
 
-	uId := &uuid.UUID{}
+	bridge := ps.bridgeFor("test-chan")
+	replies, _ := answerRequests(t, b, bridge, "test-chan")
 	rsp := &model.Response{
-		Id:        uId,
 		ErrorCode: 418,
 		Error:     true,
 	}
+	replies <- []*model.Message{{Payload: rsp}}
 
 	assert.HTTPBodyContains(t, ps.buildEndpointHandler("test-chan", func(w http.ResponseWriter, r *http.Request) model.Request {
-		msgChan <- &model.Message{Payload: rsp}
 		return model.Request{
-			Id:             uId,
 			Payload:        nil,
 			RequestCommand: "test-request",
 		}
 
-	}, 5*time.Second, msgChan), "GET", "http://localhost", nil, "418")
+	}, 5*time.Second, bridge, requestLimits{}), "GET", "http://localhost", nil, "418")
 }
 
 func TestBuildEndpointHandler_CatchPanic(t *testing.T) {
 	b := bus.ResetBus()
 	service.ResetServiceRegistry()
-	msgChan := make(chan *model.Message, 1)
 	_ = b.GetChannelManager().CreateChannel("test-chan")
 	port := GetTestPort()
 	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", port, true)
@@ -147,5 +178,352 @@ func TestBuildEndpointHandler_CatchPanic(t *testing.T) {
 			Payload:        nil,
 			RequestCommand: "test-request",
 		}
-	}, 5*time.Second, msgChan), "GET", "http://localhost", nil, "Internal Server Error")
+	}, 5*time.Second, ps.bridgeFor("test-chan"), requestLimits{}), "GET", "http://localhost", nil, "Internal Server Error")
+}
+
+func TestBuildEndpointHandler_Principal(t *testing.T) {
+	b := bus.ResetBus()
+	service.ResetServiceRegistry()
+	_ = b.GetChannelManager().CreateChannel("test-chan")
+	port := GetTestPort()
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", port, true)
+	config.StoreAccess = &StoreAccessConfig{HttpPrincipal: func(r *http.Request) string {
+		return r.Header.Get("X-User")
+	}}
+	ps := NewPlatformServer(config).(*platformServer)
+	ps.eventbus = b
+
+	bridge := ps.bridgeFor("test-chan")
+	replies, requests := answerRequests(t, b, bridge, "test-chan")
+	replies <- []*model.Message{{Payload: &model.Response{Payload: "ok"}}}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.Header.Set("X-User", "alice")
+	rec := httptest.NewRecorder()
+	ps.buildEndpointHandler("test-chan", func(w http.ResponseWriter, r *http.Request) model.Request {
+		return model.Request{RequestCommand: "test-request"}
+	}, time.Second, bridge, requestLimits{})(rec, req)
+	assert.Equal(t, "alice", (<-requests).Principal)
+}
+
+func TestBuildEndpointHandler_ResponseHeaders(t *testing.T) {
+	b := bus.ResetBus()
+	service.ResetServiceRegistry()
+	_ = b.GetChannelManager().CreateChannel("test-chan")
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", GetTestPort(), true)
+	ps := NewPlatformServer(config).(*platformServer)
+	ps.eventbus = b
+	bridge := ps.bridgeFor("test-chan")
+	replies, _ := answerRequests(t, b, bridge, "test-chan")
+	handler := func(rsp *model.Response) *httptest.ResponseRecorder {
+		replies <- []*model.Message{{Payload: rsp}}
+		rec := httptest.NewRecorder()
+		ps.buildEndpointHandler("test-chan", func(w http.ResponseWriter, r *http.Request) model.Request {
+			return model.Request{RequestCommand: "test-request"}
+		}, time.Second, bridge, requestLimits{})(rec, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+		return rec
+	}
+
+	// services set the status code, content type, headers and cookies of the response
+	rec := handler(&model.Response{
+		Payload:        map[string]string{"cow": "daisy"},
+		Marshal:        true,
+		HttpStatusCode: http.StatusCreated,
+		ContentType:    "application/vnd.cow+json",
+		Headers: map[string]interface{}{"Content-Type": "application/json", "Location": "/cows/daisy",
+			"Link": []string{"</cows>; rel=up", "</herds>; rel=related"}},
+		Cookies: []*http.Cookie{{Name: "session", Value: "moo"}, {Name: "herd", Value: "cattle", HttpOnly: true}},
+	})
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, "application/vnd.cow+json", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "/cows/daisy", rec.Header().Get("Location"))
+	assert.Equal(t, []string{"</cows>; rel=up", "</herds>; rel=related"}, rec.Header().Values("Link"))
+	assert.Equal(t, []string{"session=moo", "herd=cattle; HttpOnly"}, rec.Header().Values("Set-Cookie"))
+	assert.Equal(t, `{"cow":"daisy"}`, rec.Body.String())
+
+	// error responses as well, falling back on the status code without an error code
+	rec = handler(&model.Response{
+		Error:          true,
+		Payload:        "no such cow",
+		HttpStatusCode: http.StatusNotFound,
+		ContentType:    "text/plain",
+		Cookies:        []*http.Cookie{{Name: "session", Value: "", MaxAge: -1}},
+	})
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, "text/plain", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "session=; Max-Age=0", rec.Header().Get("Set-Cookie"))
+	assert.Equal(t, "no such cow", rec.Body.String())
+	assert.Equal(t, http.StatusInternalServerError, errorStatusCode(&model.Response{Error: true, ErrorCode: 42}))
+}
+
+func TestBuildEndpointHandler_CorrelatedResponses(t *testing.T) {
+	b := bus.ResetBus()
+	service.ResetServiceRegistry()
+	_ = b.GetChannelManager().CreateChannel("test-chan")
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", GetTestPort(), true)
+	ps := NewPlatformServer(config).(*platformServer)
+	ps.eventbus = b
+
+	// the service answers once every request arrived, the last first, after responses to nobody
+	const count = 10
+	requests := make(chan model.Request, count)
+	mh, _ := b.ListenRequestStream("test-chan")
+	mh.Handle(func(message *model.Message) {
+		requests <- message.Payload.(model.Request)
+	}, func(e error) {})
+	defer mh.Close()
+	go func() {
+		var received []model.Request
+		for range count {
+			received = append(received, <-requests)
+		}
+		stranger := uuid.New()
+		_ = b.SendResponseMessage("test-chan", &model.Response{Id: &stranger, Payload: "stranger"}, &stranger)
+		_ = b.SendResponseMessage("test-chan", &model.Response{Payload: "nobody"}, nil)
+		for i := len(received) - 1; i >= 0; i-- {
+			req := received[i]
+			_ = b.SendResponseMessage("test-chan", &model.Response{Id: req.Id, Payload: req.Payload}, req.Id)
+		}
+	}()
+
+	handler := ps.buildEndpointHandler("test-chan", func(w http.ResponseWriter, r *http.Request) model.Request {
+		return model.Request{RequestCommand: "test-request", Payload: r.URL.Query().Get("cow")}
+	}, 5*time.Second, ps.bridgeFor("test-chan"), requestLimits{})
+
+	// every request gets its own response, whatever order they come in
+	var wg sync.WaitGroup
+	recs := make([]*httptest.ResponseRecorder, count)
+	for i := range count {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			recs[i] = httptest.NewRecorder()
+			handler(recs[i], httptest.NewRequest(http.MethodGet, fmt.Sprintf("http://localhost/?cow=%d", i), nil))
+		}()
+	}
+	wg.Wait()
+	for i, rec := range recs {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, fmt.Sprintf("%d", i), rec.Body.String())
+	}
+
+	// responses to requests that no longer wait are dropped
+	bridge := ps.bridgeFor("test-chan")
+	assert.Eventually(t, func() bool {
+		bridge.lock.Lock()
+		defer bridge.lock.Unlock()
+		return len(bridge.waiters) == 0
+	}, time.Second, time.Millisecond)
+}
+
+func TestBuildEndpointHandler_StreamedResponse(t *testing.T) {
+	b := bus.ResetBus()
+	service.ResetServiceRegistry()
+	_ = b.GetChannelManager().CreateChannel("test-chan")
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", GetTestPort(), true)
+	ps := NewPlatformServer(config).(*platformServer)
+	ps.eventbus = b
+	bridge := ps.bridgeFor("test-chan")
+	replies, _ := answerRequests(t, b, bridge, "test-chan")
+
+	handler := func(chunks ...*model.Response) *httptest.ResponseRecorder {
+		var msgs []*model.Message
+		for _, chunk := range chunks {
+			msgs = append(msgs, &model.Message{Payload: chunk})
+		}
+		replies <- msgs
+		rec := httptest.NewRecorder()
+		ps.buildEndpointHandler("test-chan", func(w http.ResponseWriter, r *http.Request) model.Request {
+			return model.Request{RequestCommand: "test-request"}
+		}, 50*time.Millisecond, bridge, requestLimits{})(rec, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+		return rec
+	}
+
+	// chunks marshalled to JSON are written as NDJSON
+	rec := handler(
+		&model.Response{Stream: true, Marshal: true, Payload: map[string]int{"cow": 1},
+			Headers: map[string]interface{}{"Content-Type": "application/json", "X-Herd": "cattle"}},
+		&model.Response{Stream: true, Marshal: true, Payload: map[string]int{"cow": 2}},
+		&model.Response{})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/x-ndjson", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "cattle", rec.Header().Get("X-Herd"))
+	assert.Equal(t, "{\"cow\":1}\n{\"cow\":2}\n", rec.Body.String())
+	assert.True(t, rec.Flushed)
+
+	// others as they are, an error ending the stream
+	rec = handler(
+		&model.Response{Stream: true, Payload: "moo ", HttpStatusCode: http.StatusAccepted},
+		&model.Response{Stream: true, Payload: []byte("moo ")},
+		&model.Response{Error: true, ErrorCode: 500, Payload: "stampede"},
+		&model.Response{Stream: true, Payload: "never written"})
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	assert.Equal(t, "moo moo stampede", rec.Body.String())
+
+	// the stream ends when no chunk arrives within the bridge timeout
+	rec = handler(&model.Response{Stream: true, Marshal: true, Payload: "moo"})
+	assert.Equal(t, "\"moo\"\n", rec.Body.String())
+}
+
+func TestBuildEndpointHandler_StreamedResponseFlushed(t *testing.T) {
+	b := bus.ResetBus()
+	service.ResetServiceRegistry()
+	_ = b.GetChannelManager().CreateChannel("test-chan")
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", GetTestPort(), true)
+	ps := NewPlatformServer(config).(*platformServer)
+	ps.eventbus = b
+	bridge := ps.bridgeFor("test-chan")
+	replies, requests := answerRequests(t, b, bridge, "test-chan")
+
+	server := httptest.NewServer(ps.buildEndpointHandler("test-chan",
+		func(w http.ResponseWriter, r *http.Request) model.Request {
+			return model.Request{RequestCommand: "test-request"}
+		}, 5*time.Second, bridge, requestLimits{}))
+	defer server.Close()
+
+	replies <- []*model.Message{{Payload: &model.Response{Stream: true, Marshal: true, Payload: 1}}}
+	rsp, err := http.Get(server.URL)
+	assert.NoError(t, err)
+	defer rsp.Body.Close()
+	assert.Equal(t, []string{"chunked"}, rsp.TransferEncoding)
+	id := (<-requests).Id
+
+	// every chunk reaches the client before the next one is sent
+	lines := bufio.NewReader(rsp.Body)
+	for i := 2; i <= 3; i++ {
+		line, err := lines.ReadString('\n')
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("%d\n", i-1), line)
+		reply(bridge, id, &model.Message{Payload: &model.Response{Stream: true, Marshal: true, Payload: i}})
+	}
+	reply(bridge, id, &model.Message{Payload: &model.Response{}})
+	rest, err := lines.ReadString(0)
+	assert.Equal(t, "3\n", rest)
+	assert.True(t, strings.HasSuffix(err.Error(), "EOF"))
+}
+
+func TestBuildEndpointHandler_RequestLimits(t *testing.T) {
+	b := bus.ResetBus()
+	service.ResetServiceRegistry()
+	_ = b.GetChannelManager().CreateChannel("test-chan")
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", GetTestPort(), true)
+	config.RequestLimits = &RequestLimitsConfig{MaxRequestBodyBytes: 1024, ReadTimeoutSeconds: 30}
+	ps := NewPlatformServer(config).(*platformServer)
+	ps.eventbus = b
+
+	// bridges may set their own limits
+	assert.Equal(t, requestLimits{maxBodyBytes: 1024, readTimeout: 30 * time.Second},
+		ps.bridgeRequestLimits(&service.RESTBridgeConfig{}))
+	limits := ps.bridgeRequestLimits(&service.RESTBridgeConfig{MaxRequestBodyBytes: 8,
+		ReadTimeout: 50 * time.Millisecond})
+	assert.Equal(t, requestLimits{maxBodyBytes: 8, readTimeout: 50 * time.Millisecond}, limits)
+
+	bridge := ps.bridgeFor("test-chan")
+	replies, requests := answerRequests(t, b, bridge, "test-chan")
+	replies <- []*model.Message{{Payload: &model.Response{Payload: "ok"}}}
+	handler := ps.buildEndpointHandler("test-chan", func(w http.ResponseWriter, r *http.Request) model.Request {
+		body, _ := io.ReadAll(r.Body)
+		return model.Request{RequestCommand: "test-request", Payload: string(body)}
+	}, time.Second, bridge, limits)
+	post := func(body io.Reader, length int64) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "http://localhost", body)
+		req.ContentLength = length
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusOK, post(strings.NewReader("moo"), 3).Code)
+	assert.Equal(t, "moo", (<-requests).Payload)
+
+	// oversized bodies are refused whether their length is known up front or not
+	assert.Equal(t, http.StatusRequestEntityTooLarge, post(strings.NewReader("moo moo moo"), 11).Code)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, post(io.MultiReader(strings.NewReader("moo moo moo")), -1).Code)
+	assert.Empty(t, requests)
+
+	// as are bodies not received in time
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nmo"))
+	assert.NoError(t, err)
+	rsp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusRequestTimeout, rsp.StatusCode)
+	assert.Empty(t, requests)
+}
+
+func TestBuildEndpointHandler_Multipart(t *testing.T) {
+	b := bus.ResetBus()
+	service.ResetServiceRegistry()
+	_ = b.GetChannelManager().CreateChannel("test-chan")
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", GetTestPort(), true)
+	ps := NewPlatformServer(config).(*platformServer)
+	ps.eventbus = b
+
+	dir := t.TempDir()
+	limits := ps.bridgeRequestLimits(&service.RESTBridgeConfig{
+		Multipart: &service.MultipartConfig{MaxMemory: 16, MaxFileBytes: 64, TempDir: dir}})
+	bridge := ps.bridgeFor("test-chan")
+	replies, requests := answerRequests(t, b, bridge, "test-chan")
+	handler := ps.buildEndpointHandler("test-chan", func(w http.ResponseWriter, r *http.Request) model.Request {
+		return model.Request{RequestCommand: "upload", Payload: service.MultipartFormFromRequest(r)}
+	}, time.Second, bridge, limits)
+	upload := func(content string) *httptest.ResponseRecorder {
+		var body strings.Builder
+		writer := multipart.NewWriter(&body)
+		_ = writer.WriteField("herd", "cattle")
+		part, _ := writer.CreateFormFile("cow", "cow.txt")
+		_, _ = part.Write([]byte(content))
+		_ = writer.Close()
+		req := httptest.NewRequest(http.MethodPost, "http://localhost", strings.NewReader(body.String()))
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	// the files of the form reach the service, spooled for as long as the request lasts
+	files := make(chan int, 1)
+	mh, _ := b.ListenRequestStream("test-chan")
+	mh.Handle(func(message *model.Message) {
+		entries, _ := os.ReadDir(dir)
+		files <- len(entries)
+	}, func(e error) {})
+	defer mh.Close()
+	replies <- []*model.Message{{Payload: &model.Response{Payload: "ok"}}}
+	rec := upload(strings.Repeat("moo", 10))
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	form := (<-requests).Payload.(*service.MultipartForm)
+	assert.Equal(t, []string{"cattle"}, form.Values["herd"])
+	assert.Equal(t, "cow.txt", form.Files["cow"][0].Filename)
+	assert.Equal(t, int64(30), form.Files["cow"][0].Size)
+	assert.Equal(t, 1, <-files)
+	entries, _ := os.ReadDir(dir)
+	assert.Empty(t, entries)
+
+	// files too large are refused
+	assert.Equal(t, http.StatusRequestEntityTooLarge, upload(strings.Repeat("moo", 30)).Code)
+	assert.Empty(t, requests)
+}
+
+func TestBuildEndpointHandler_SendError(t *testing.T) {
+	b := bus.ResetBus()
+	service.ResetServiceRegistry()
+	port := GetTestPort()
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", port, true)
+	ps := NewPlatformServer(config).(*platformServer)
+	ps.eventbus = b
+
+	// the channel is gone, the request cannot be sent and is not left waiting for the timeout
+	handler := ps.buildEndpointHandler("missing-chan", func(w http.ResponseWriter, r *http.Request) model.Request {
+		return model.Request{RequestCommand: "test-request"}
+	}, 5*time.Second, ps.bridgeFor("missing-chan"), requestLimits{})
+	b.GetChannelManager().DestroyChannel("missing-chan")
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.Contains(t, rr.Body.String(), "missing-chan")
 }
@@ -0,0 +1,126 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pb33f/ranch/stompserver"
+)
+
+const (
+	defaultFabricTicketEndpoint = "/fabric/ticket"
+	defaultFabricTicketTTL      = 30 * time.Second
+)
+
+// FabricTicketResponse is returned by the ticket endpoint. The client sends Ticket in the Header header of
+// its STOMP CONNECT frame before ExpiresAt.
+type FabricTicketResponse struct {
+	Ticket    string    `json:"ticket"`
+	Header    string    `json:"header"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// initFabricTicket registers the ticket endpoint, if fabric tickets are configured.
+func (ps *platformServer) initFabricTicket() {
+	if ps.serverConfig.FabricConfig == nil || ps.serverConfig.FabricConfig.Ticket == nil {
+		return
+	}
+	cfg := ps.serverConfig.FabricConfig.Ticket
+	if cfg.Authenticate == nil {
+		ps.serverConfig.Logger.Error(wrapError(errServerInit,
+			fmt.Errorf("fabric tickets need an Authenticate function")).Error())
+		return
+	}
+	ps.fabricTickets = stompserver.NewTicketStore()
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = defaultFabricTicketEndpoint
+	}
+	ttl := time.Duration(cfg.TTLSeconds) * time.Second
+	if ttl <= 0 {
+		ttl = defaultFabricTicketTTL
+	}
+	tickets := ps.fabricTickets
+	ps.router.Path(endpoint).Name(endpoint).Methods(http.MethodPost, http.MethodOptions).HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if !cfg.allowCrossOrigin(w, r) {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			if r.Method == http.MethodOptions {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			sessionToken, ok := cfg.Authenticate(r)
+			if !ok {
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			ticket, expiresAt, err := tickets.Issue(sessionToken, ttl)
+			if err != nil {
+				ps.serverConfig.Logger.Error("[ranch] unable to issue fabric ticket", "error", err.Error())
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "no-store")
+			_ = json.NewEncoder(w).Encode(&FabricTicketResponse{
+				Ticket:    ticket,
+				Header:    stompserver.TicketHeader,
+				ExpiresAt: expiresAt,
+			})
+		})
+	ps.serverConfig.Logger.Info("[ranch] fabric ticket endpoint enabled", "endpoint", endpoint)
+}
+
+// allowCrossOrigin sets the CORS headers letting an allowed origin call the endpoint with its cookies,
+// returning false for requests from any other origin. Requests without an Origin are same-origin or not
+// from a browser.
+func (cfg *FabricTicketConfig) allowCrossOrigin(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	allowed := false
+	for _, o := range cfg.AllowedOrigins {
+		if o == origin {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		// same-origin POSTs carry an Origin too
+		return r.Method == http.MethodPost && origin == requestOrigin(r)
+	}
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	w.Header().Add("Vary", "Origin")
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Methods", http.MethodPost)
+		if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+			w.Header().Set("Access-Control-Allow-Headers", headers)
+		}
+	}
+	return true
+}
+
+// requestOrigin returns the origin the request was sent to.
+func requestOrigin(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// withFabricTicketMiddleware returns a copy of the registry with the ticket middleware at the front of the
+// CONNECT middleware chain.
+func withFabricTicketMiddleware(registry stompserver.MiddlewareRegistry, tickets *stompserver.TicketStore,
+	required bool) stompserver.MiddlewareRegistry {
+	return registry.Prepend("CONNECT", stompserver.TicketMiddleware(tickets, required))
+}
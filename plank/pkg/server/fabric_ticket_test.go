@@ -0,0 +1,87 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/stompserver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newFabricTicketTestServer(t *testing.T) *platformServer {
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", GetTestPort(), true)
+	config.FabricConfig = &FabricBrokerConfig{
+		FabricEndpoint: "/ws",
+		EndpointConfig: &bus.EndpointConfig{TopicPrefix: "/topic"},
+		Ticket: &FabricTicketConfig{
+			AllowedOrigins: []string{"https://app.example.com"},
+			Authenticate: func(r *http.Request) (string, bool) {
+				cookie, err := r.Cookie("session")
+				if err != nil {
+					return "", false
+				}
+				return cookie.Value, true
+			},
+		},
+	}
+	ps := NewPlatformServer(config).(*platformServer)
+	require.NotNil(t, ps.fabricTickets)
+	return ps
+}
+
+func TestPlatformServer_FabricTicket(t *testing.T) {
+	ps := newFabricTicketTestServer(t)
+
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/fabric/ticket", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.AddCookie(&http.Cookie{Name: "session", Value: "cookie-session"})
+	rec := httptest.NewRecorder()
+	ps.router.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "https://app.example.com", rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", rec.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+	var rsp FabricTicketResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &rsp))
+	assert.Equal(t, stompserver.TicketHeader, rsp.Header)
+
+	ticket, ok := ps.fabricTickets.Redeem(rsp.Ticket)
+	require.True(t, ok)
+	assert.Equal(t, "cookie-session", ticket.SessionToken)
+}
+
+func TestPlatformServer_FabricTicketRejected(t *testing.T) {
+	ps := newFabricTicketTestServer(t)
+
+	// no session cookie
+	rec := httptest.NewRecorder()
+	ps.router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "http://localhost/fabric/ticket", nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// origin that is not allowed
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/fabric/ticket", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	req.AddCookie(&http.Cookie{Name: "session", Value: "cookie-session"})
+	rec = httptest.NewRecorder()
+	ps.router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	// preflight from an allowed origin
+	req = httptest.NewRequest(http.MethodOptions, "http://localhost/fabric/ticket", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Headers", "content-type")
+	rec = httptest.NewRecorder()
+	ps.router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, http.MethodPost, rec.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "content-type", rec.Header().Get("Access-Control-Allow-Headers"))
+}
@@ -0,0 +1,246 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/pb33f/ranch/bridge"
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/model"
+	"github.com/pb33f/ranch/plank/pkg/federation"
+)
+
+const (
+	federationRequestCommand = "federate"
+	federationInboundLink    = "inbound"
+)
+
+// federationState links a federation.Federation to the other instances of the mesh. The server is a client
+// of the fabric broker of every peer, each connection managed by a brokerBridge without channel mappings,
+// and sends its envelopes as requests on RANCH_FEDERATION_CHANNEL, reading those of the peer from the topic
+// of the channel. The instances connected to this one the same way share a single inbound link: their
+// requests are received from the channel, and what is sent to them is broadcast on it.
+type federationState struct {
+	federation *federation.Federation
+	eventBus   bus.EventBus
+	peers      []*federationPeer
+	inbound    bool // whether instances may connect to this one
+	handler    bus.MessageHandler
+	logger     *slog.Logger
+}
+
+// federationPeer is the connection to the fabric broker of a peer.
+type federationPeer struct {
+	bridge      *brokerBridge
+	topic       string
+	destination string
+	logger      *slog.Logger
+	sub         bridge.Subscription
+	lock        sync.Mutex
+}
+
+func newFederationState(config *FederationConfig, eventBus bus.EventBus, logger *slog.Logger) (*federationState, error) {
+	f, err := federation.New(eventBus, &federation.Config{
+		Node:        config.Node,
+		Channels:    config.Channels,
+		Transparent: config.Transparent,
+		MaxHops:     config.MaxHops,
+		Logger:      logger,
+	})
+	if err != nil {
+		return nil, err
+	}
+	fs := &federationState{federation: f, eventBus: eventBus, inbound: true, logger: logger}
+	for _, peerConfig := range config.Peers {
+		if err := fs.addPeer(peerConfig, config.TopicPrefix, config.AppRequestPrefix); err != nil {
+			return nil, err
+		}
+	}
+	f.AddLink(federationInboundLink, fs.broadcast)
+	return fs, nil
+}
+
+// addPeer links the federation to the instance serving a fabric broker.
+func (fs *federationState) addPeer(config *BrokerBridgeConfig, topicPrefix string, requestPrefix string) error {
+	if err := validateBrokerConnection(config); err != nil {
+		return err
+	}
+	if topicPrefix == "" {
+		topicPrefix = "/topic"
+	}
+	if requestPrefix == "" {
+		requestPrefix = "/pub"
+	}
+	peer := &federationPeer{
+		bridge:      newBrokerBridge(config, fs.eventBus, fs.logger),
+		topic:       strings.TrimSuffix(topicPrefix, "/") + "/" + RANCH_FEDERATION_CHANNEL,
+		destination: strings.TrimSuffix(requestPrefix, "/") + "/" + RANCH_FEDERATION_CHANNEL,
+		logger:      fs.logger,
+	}
+	peer.bridge.onConnect = func(conn bridge.Connection) {
+		peer.connected(conn, fs.federation)
+	}
+	fs.peers = append(fs.peers, peer)
+	fs.federation.AddLink(config.Name, peer.send)
+	return nil
+}
+
+// start relays the federated channels, accepts the envelopes of the instances connected to this one and
+// connects to the peers in the background.
+func (fs *federationState) start() error {
+	if fs.inbound {
+		cm := fs.eventBus.GetChannelManager()
+		if !cm.CheckChannelExists(RANCH_FEDERATION_CHANNEL) {
+			cm.CreateChannel(RANCH_FEDERATION_CHANNEL)
+		}
+		handler, err := fs.eventBus.ListenRequestStream(RANCH_FEDERATION_CHANNEL)
+		if err != nil {
+			return err
+		}
+		handler.Handle(fs.receive, func(err error) {})
+		fs.handler = handler
+	}
+
+	if err := fs.federation.Start(); err != nil {
+		if fs.handler != nil {
+			fs.handler.Close()
+		}
+		return err
+	}
+	for _, peer := range fs.peers {
+		peer := peer
+		go func() {
+			if err := peer.bridge.dial(); err != nil {
+				fs.logger.Error("[ranch] federation unable to connect to peer", "peer", peer.bridge.config.Name,
+					"error", err.Error())
+			}
+		}()
+	}
+	return nil
+}
+
+func (fs *federationState) stop() {
+	fs.federation.Stop()
+	if fs.handler != nil {
+		fs.handler.Close()
+	}
+	for _, peer := range fs.peers {
+		peer.stop()
+	}
+}
+
+// receive receives an envelope sent by an instance connected to this one. The inbound link is shared, so
+// the envelope is relayed to every link including it, the sender drops the copy it gets back.
+func (fs *federationState) receive(msg *model.Message) {
+	req, ok := msg.Payload.(*model.Request)
+	if !ok || req.RequestCommand != federationRequestCommand {
+		return
+	}
+	payload, err := json.Marshal(req.Payload)
+	if err != nil {
+		return
+	}
+	fs.federation.Receive("", payload)
+}
+
+// broadcast sends an envelope to the instances connected to this one.
+func (fs *federationState) broadcast(payload []byte) error {
+	return fs.eventBus.SendResponseMessage(RANCH_FEDERATION_CHANNEL, payload, nil)
+}
+
+// connected reads the envelopes of the peer on a new connection to its broker.
+func (fp *federationPeer) connected(conn bridge.Connection, f *federation.Federation) {
+	sub, err := conn.Subscribe(fp.topic)
+	if err != nil {
+		fp.logger.Error("[ranch] federation unable to subscribe", "peer", fp.bridge.config.Name,
+			"destination", fp.topic, "error", err.Error())
+		return
+	}
+	fp.lock.Lock()
+	previous := fp.sub
+	fp.sub = sub
+	fp.lock.Unlock()
+	if previous != nil {
+		_ = previous.Unsubscribe()
+	}
+	go func() {
+		for msg := range sub.GetMsgChannel() {
+			payload, err := encodeBrokerPayload(msg.Payload)
+			if err != nil {
+				continue
+			}
+			f.Receive(fp.bridge.config.Name, payload)
+		}
+	}()
+}
+
+// send sends an envelope to the peer as a request, reconnecting if its broker cannot be reached.
+func (fp *federationPeer) send(payload []byte) error {
+	conn := fp.bridge.connection()
+	if conn == nil {
+		return fmt.Errorf("not connected to peer '%s'", fp.bridge.config.Name)
+	}
+	id := uuid.New()
+	req, err := json.Marshal(&model.Request{
+		Id:             &id,
+		Destination:    RANCH_FEDERATION_CHANNEL,
+		RequestCommand: federationRequestCommand,
+		Payload:        json.RawMessage(payload),
+	})
+	if err != nil {
+		return err
+	}
+	if err := conn.SendJSONMessage(fp.destination, req); err != nil {
+		go fp.bridge.reconnect()
+		return err
+	}
+	return nil
+}
+
+func (fp *federationPeer) stop() {
+	fp.bridge.stop()
+	fp.lock.Lock()
+	sub := fp.sub
+	fp.sub = nil
+	fp.lock.Unlock()
+	if sub != nil {
+		_ = sub.Unsubscribe()
+	}
+}
+
+// startFederation relays channels with the other instances of the mesh, if configured.
+func (ps *platformServer) startFederation() {
+	cfg := ps.serverConfig.Federation
+	if cfg == nil {
+		return
+	}
+	fs, err := newFederationState(cfg, ps.eventbus, ps.serverConfig.Logger)
+	if err == nil {
+		err = fs.start()
+	}
+	if err != nil {
+		ps.serverConfig.Logger.Error(wrapError(errServerInit, err).Error())
+		return
+	}
+	ps.lock.Lock()
+	ps.federation = fs
+	ps.lock.Unlock()
+}
+
+// stopFederation stops relaying and disconnects from the peers.
+func (ps *platformServer) stopFederation() {
+	ps.lock.Lock()
+	fs := ps.federation
+	ps.federation = nil
+	ps.lock.Unlock()
+	if fs != nil {
+		fs.stop()
+	}
+}
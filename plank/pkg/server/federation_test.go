@@ -0,0 +1,128 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/pb33f/ranch/bridge"
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/model"
+	"github.com/pb33f/ranch/plank/pkg/federation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewFederationState_Invalid(t *testing.T) {
+	b := bus.NewEventBusInstance()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	_, err := newFederationState(&FederationConfig{Channels: []string{"chat"}}, b, logger)
+	assert.Error(t, err)
+	_, err = newFederationState(&FederationConfig{Node: "eu", Channels: []string{"chat"},
+		Peers: []*BrokerBridgeConfig{{Name: "us"}}}, b, logger)
+	assert.Error(t, err)
+}
+
+func TestFederation_RelaysChannels(t *testing.T) {
+	b := bus.NewEventBusInstance()
+	conn := newFakeBrokerConnection()
+	fs, err := newFederationState(&FederationConfig{
+		Node:     "eu",
+		Channels: []string{"chat"},
+		Peers:    []*BrokerBridgeConfig{{Name: "us", ServerAddr: "localhost:30080"}},
+	}, b, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	require.NoError(t, err)
+	fs.peers[0].bridge.connectFn = func(config *bridge.BrokerConnectorConfig) (bridge.Connection, error) {
+		return conn, nil
+	}
+	require.NoError(t, fs.start())
+	defer fs.stop()
+
+	received := make(chan *federation.FederatedMessage, 2)
+	chat, _ := b.ListenStream("chat")
+	chat.Handle(func(msg *model.Message) {
+		if fm, ok := msg.Payload.(*federation.FederatedMessage); ok {
+			received <- fm
+		}
+	}, func(err error) {})
+	defer chat.Close()
+	broadcast := make(chan []byte, 2)
+	inbound, _ := b.ListenStream(RANCH_FEDERATION_CHANNEL)
+	inbound.Handle(func(msg *model.Message) {
+		broadcast <- msg.Payload.([]byte)
+	}, func(err error) {})
+	defer inbound.Close()
+
+	hops := func(payload []byte) []interface{} {
+		env := make(map[string]interface{})
+		require.NoError(t, json.Unmarshal(payload, &env))
+		return env["hops"].([]interface{})
+	}
+
+	// local messages are sent to the peer as requests, and broadcast to the instances connected here
+	require.Eventually(t, func() bool { return conn.getSub("/topic/ranch-federation") != nil },
+		time.Second, 5*time.Millisecond)
+	require.NoError(t, b.SendResponseMessage("chat", map[string]string{"text": "hello"}, nil))
+	select {
+	case msg := <-conn.sent:
+		assert.Equal(t, "/pub/ranch-federation", msg.destination)
+		req := make(map[string]interface{})
+		require.NoError(t, json.Unmarshal(msg.payload, &req))
+		assert.Equal(t, "federate", req["request"])
+		env := req["payload"].(map[string]interface{})
+		assert.Equal(t, "eu", env["origin"])
+		assert.Equal(t, map[string]interface{}{"text": "hello"}, env["payload"])
+	case <-time.After(time.Second):
+		t.Fatal("message was not sent to the peer")
+	}
+	select {
+	case payload := <-broadcast:
+		assert.Equal(t, []interface{}{"eu"}, hops(payload))
+	case <-time.After(time.Second):
+		t.Fatal("message was not broadcast")
+	}
+
+	// messages of the peer are delivered and relayed to the instances connected here
+	conn.getSub("/topic/ranch-federation").c <- model.GenerateResponse(&model.MessageConfig{
+		Payload: []byte(`{"id":"1","origin":"us","hops":["us"],"channel":"chat","payload":"hi"}`),
+	})
+	select {
+	case fm := <-received:
+		assert.Equal(t, "us", fm.Node)
+		assert.Equal(t, "hi", fm.Payload)
+	case <-time.After(time.Second):
+		t.Fatal("message of the peer was not delivered")
+	}
+	select {
+	case payload := <-broadcast:
+		assert.Equal(t, []interface{}{"us", "eu"}, hops(payload))
+	case <-time.After(time.Second):
+		t.Fatal("message of the peer was not relayed")
+	}
+
+	// messages of the instances connected here are delivered and relayed to the peer
+	require.NoError(t, b.SendRequestMessage(RANCH_FEDERATION_CHANNEL, &model.Request{
+		RequestCommand: "federate",
+		Payload: map[string]interface{}{"id": "2", "origin": "ap", "hops": []string{"ap"}, "channel": "chat",
+			"payload": "hey"},
+	}, nil))
+	select {
+	case fm := <-received:
+		assert.Equal(t, "ap", fm.Node)
+	case <-time.After(time.Second):
+		t.Fatal("message of a connected instance was not delivered")
+	}
+	select {
+	case msg := <-conn.sent:
+		req := make(map[string]interface{})
+		require.NoError(t, json.Unmarshal(msg.payload, &req))
+		assert.Equal(t, []interface{}{"ap", "eu"}, req["payload"].(map[string]interface{})["hops"])
+	case <-time.After(time.Second):
+		t.Fatal("message of a connected instance was not relayed to the peer")
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+)
+
+// fipsCipherSuites is the vetted set of TLS 1.2 cipher suites permitted in FIPS mode. The TLS 1.3 suites
+// cannot be configured in crypto/tls and include TLS_CHACHA20_POLY1305_SHA256, which is not FIPS approved,
+// so FIPS mode caps connections at TLS 1.2.
+var fipsCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+}
+
+// fipsCurves is the vetted set of elliptic curves permitted in FIPS mode.
+var fipsCurves = []tls.CurveID{tls.CurveP256, tls.CurveP384, tls.CurveP521}
+
+const fipsMinRSAKeyBits = 2048
+
+// NewFIPSTLSConfig returns a tls.Config restricted to FIPS approved protocol versions, cipher suites and curves.
+func NewFIPSTLSConfig() *tls.Config {
+	suites := make([]uint16, len(fipsCipherSuites))
+	copy(suites, fipsCipherSuites)
+	curves := make([]tls.CurveID, len(fipsCurves))
+	copy(curves, fipsCurves)
+	return &tls.Config{
+		MinVersion:       tls.VersionTLS12,
+		MaxVersion:       tls.VersionTLS12,
+		CipherSuites:     suites,
+		CurvePreferences: curves,
+	}
+}
+
+// validateFIPSTLSConfig checks a tls.Config only permits FIPS approved versions, cipher suites and curves.
+func validateFIPSTLSConfig(config *tls.Config) error {
+	if config == nil {
+		return fmt.Errorf("fips mode: tls config is missing")
+	}
+	if config.MinVersion < tls.VersionTLS12 {
+		return fmt.Errorf("fips mode: minimum TLS version must be TLS 1.2 or higher")
+	}
+	if config.MaxVersion == 0 || config.MaxVersion > tls.VersionTLS12 {
+		return fmt.Errorf("fips mode: maximum TLS version must be TLS 1.2, TLS 1.3 cipher suites cannot be restricted")
+	}
+	if len(config.CipherSuites) == 0 {
+		return fmt.Errorf("fips mode: cipher suites must be explicitly restricted")
+	}
+	for _, suite := range config.CipherSuites {
+		if !containsUint16(fipsCipherSuites, suite) {
+			return fmt.Errorf("fips mode: cipher suite %s is not permitted", tls.CipherSuiteName(suite))
+		}
+	}
+	if len(config.CurvePreferences) == 0 {
+		return fmt.Errorf("fips mode: curve preferences must be explicitly restricted")
+	}
+	for _, curve := range config.CurvePreferences {
+		if !containsCurve(fipsCurves, curve) {
+			return fmt.Errorf("fips mode: curve %s is not permitted", curve.String())
+		}
+	}
+	return nil
+}
+
+// validateFIPSCertificate loads the certificate and key pair and rejects keys that are not FIPS compliant.
+func validateFIPSCertificate(certFile, keyFile string) error {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("fips mode: unable to load certificate: %w", err)
+	}
+	leaf := pair.Leaf
+	if leaf == nil {
+		if leaf, err = x509.ParseCertificate(pair.Certificate[0]); err != nil {
+			return fmt.Errorf("fips mode: unable to parse certificate: %w", err)
+		}
+	}
+	switch key := leaf.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if key.N.BitLen() < fipsMinRSAKeyBits {
+			return fmt.Errorf("fips mode: RSA key size of %d bits is below the minimum of %d bits",
+				key.N.BitLen(), fipsMinRSAKeyBits)
+		}
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P256(), elliptic.P384(), elliptic.P521():
+		default:
+			return fmt.Errorf("fips mode: ECDSA curve %s is not permitted", key.Curve.Params().Name)
+		}
+	default:
+		return fmt.Errorf("fips mode: certificate key type %T is not permitted", key)
+	}
+	return nil
+}
+
+func containsUint16(values []uint16, v uint16) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+func containsCurve(values []tls.CurveID, v tls.CurveID) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
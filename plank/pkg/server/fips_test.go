@@ -0,0 +1,99 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestKeyPair(t *testing.T, pub, priv interface{}) (string, string) {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, pub, priv)
+	assert.Nil(t, err)
+	keyDer, err := x509.MarshalPKCS8PrivateKey(priv)
+	assert.Nil(t, err)
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	_ = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	_ = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), 0600)
+	return certFile, keyFile
+}
+
+func TestNewFIPSTLSConfig_IsValid(t *testing.T) {
+	assert.Nil(t, validateFIPSTLSConfig(NewFIPSTLSConfig()))
+}
+
+func TestValidateFIPSTLSConfig_Rejects(t *testing.T) {
+	assert.Error(t, validateFIPSTLSConfig(nil))
+
+	config := NewFIPSTLSConfig()
+	config.MinVersion = tls.VersionTLS10
+	assert.ErrorContains(t, validateFIPSTLSConfig(config), "minimum TLS version")
+
+	config = NewFIPSTLSConfig()
+	config.MaxVersion = tls.VersionTLS13
+	assert.ErrorContains(t, validateFIPSTLSConfig(config), "maximum TLS version")
+
+	config = NewFIPSTLSConfig()
+	config.MaxVersion = 0
+	assert.ErrorContains(t, validateFIPSTLSConfig(config), "maximum TLS version")
+
+	config = NewFIPSTLSConfig()
+	config.CipherSuites = append(config.CipherSuites, tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305)
+	assert.ErrorContains(t, validateFIPSTLSConfig(config), "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305")
+
+	config = NewFIPSTLSConfig()
+	config.CurvePreferences = []tls.CurveID{tls.X25519}
+	assert.ErrorContains(t, validateFIPSTLSConfig(config), "X25519")
+}
+
+func TestValidateFIPSCertificate(t *testing.T) {
+	// the bundled test certificate uses a 2048 bit RSA key.
+	tlsConfig := GetTestTLSCertConfig(t.TempDir())
+	assert.Nil(t, validateFIPSCertificate(tlsConfig.CertFile, tlsConfig.KeyFile))
+
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	certFile, keyFile := writeTestKeyPair(t, &ecKey.PublicKey, ecKey)
+	assert.Nil(t, validateFIPSCertificate(certFile, keyFile))
+
+	edPub, edPriv, _ := ed25519.GenerateKey(rand.Reader)
+	certFile, keyFile = writeTestKeyPair(t, edPub, edPriv)
+	assert.ErrorContains(t, validateFIPSCertificate(certFile, keyFile), "not permitted")
+
+	assert.ErrorContains(t, validateFIPSCertificate("nope.crt", "nope.key"), "unable to load certificate")
+}
+
+func TestPlatformServer_CustomizeTLSConfig_FIPSMode(t *testing.T) {
+	port := GetTestPort()
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", port, true)
+	config.TLSCertConfig = GetTestTLSCertConfig(t.TempDir())
+	config.TLSCertConfig.FIPSMode = true
+	ps := NewPlatformServer(config).(*platformServer)
+
+	assert.Nil(t, validateFIPSTLSConfig(ps.HttpServer.TLSConfig))
+	assert.Error(t, ps.CustomizeTLSConfig(&tls.Config{MinVersion: tls.VersionTLS11}))
+	assert.Nil(t, ps.CustomizeTLSConfig(NewFIPSTLSConfig()))
+	assert.Nil(t, ps.validateTLSCompliance())
+}
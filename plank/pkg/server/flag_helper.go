@@ -36,6 +36,10 @@ func (f *serverConfigFactory) CertKey() string {
 	return viper.GetString(utils.PlatformServerFlagConstants["CertKey"]["FlagName"])
 }
 
+func (f *serverConfigFactory) FIPSMode() bool {
+	return viper.GetBool(utils.PlatformServerFlagConstants["FIPSMode"]["FlagName"])
+}
+
 func (f *serverConfigFactory) Static() []string {
 	return viper.GetStringSlice(utils.PlatformServerFlagConstants["Static"]["FlagName"])
 }
@@ -100,6 +104,10 @@ func (f *serverConfigFactory) RestBridgeTimeout() int64 {
 	return viper.GetInt64(utils.PlatformServerFlagConstants["RestBridgeTimeout"]["FlagName"])
 }
 
+func (f *serverConfigFactory) DevMode() string {
+	return viper.GetString(utils.PlatformServerFlagConstants["DevMode"]["FlagName"])
+}
+
 // parseFlags reads OS arguments into the FlagSet in this factory instance
 func (f *serverConfigFactory) parseFlags(args []string) {
 	f.flagSet.Parse(args[1:])
@@ -145,6 +153,10 @@ func (f *serverConfigFactory) configureFlagsInFlagSet(fs *flag.FlagSet) {
 		utils.PlatformServerFlagConstants["CertKey"]["FlagName"],
 		"",
 		utils.PlatformServerFlagConstants["CertKey"]["Description"])
+	fs.Bool(
+		utils.PlatformServerFlagConstants["FIPSMode"]["FlagName"],
+		false,
+		utils.PlatformServerFlagConstants["FIPSMode"]["Description"])
 
 	fs.StringSliceVarP(
 		&f.statics,
@@ -221,4 +233,8 @@ func (f *serverConfigFactory) configureFlagsInFlagSet(fs *flag.FlagSet) {
 		utils.PlatformServerFlagConstants["RestBridgeTimeout"]["FlagName"],
 		1,
 		utils.PlatformServerFlagConstants["RestBridgeTimeout"]["Description"])
+	fs.String(
+		utils.PlatformServerFlagConstants["DevMode"]["FlagName"],
+		"",
+		utils.PlatformServerFlagConstants["DevMode"]["Description"])
 }
@@ -0,0 +1,180 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pb33f/ranch/service"
+)
+
+const (
+	defaultHealthLivePath     = "/health/live"
+	defaultHealthReadyPath    = "/health/ready"
+	defaultHealthCheckTimeout = 5 * time.Second
+)
+
+// Health statuses reported by the liveness and readiness endpoints, next to those of the health endpoint.
+const (
+	HealthLive        = "live"        // the process answers requests, whether or not it is ready to serve
+	HealthUnavailable = "unavailable" // the HTTP server is not serving, e.g. while shutting down
+)
+
+// ServiceHealth is the state of a registered service in readiness output.
+type ServiceHealth struct {
+	Channel string `json:"channel"`
+	Ready   bool   `json:"ready"`           // the service finished initializing, as recorded in the service ready store
+	Healthy bool   `json:"healthy"`         // the health check of the service passed, or it has none
+	Error   string `json:"error,omitempty"` // why the health check failed
+}
+
+// healthState is the liveness and readiness endpoints, and whether the server reported being online.
+type healthState struct {
+	livePath  string
+	readyPath string
+	timeout   time.Duration
+	online    atomic.Bool
+}
+
+// paths returns the URIs of the liveness and readiness endpoints.
+func (cfg *HealthConfig) paths() (live, ready string) {
+	live, ready = cfg.LivePath, cfg.ReadyPath
+	if live == "" {
+		live = defaultHealthLivePath
+	}
+	if ready == "" {
+		ready = defaultHealthReadyPath
+	}
+	return live, ready
+}
+
+// initHealth registers the liveness and readiness endpoints, if configured.
+func (ps *platformServer) initHealth() {
+	cfg := ps.serverConfig.Health
+	if cfg == nil {
+		return
+	}
+	state := &healthState{timeout: defaultHealthCheckTimeout}
+	state.livePath, state.readyPath = cfg.paths()
+	if cfg.CheckTimeoutSeconds > 0 {
+		state.timeout = time.Duration(cfg.CheckTimeoutSeconds) * time.Second
+	}
+	ps.health = state
+
+	ps.setHealthRoute(state.livePath, func(r *http.Request) *HealthReport {
+		return &HealthReport{
+			Status:       HealthLive,
+			Http:         ps.ServerAvailability.Http,
+			Fabric:       ps.ServerAvailability.Fabric,
+			Dependencies: []*DependencyStatus{},
+		}
+	}, HealthLive)
+	ps.setHealthRoute(state.readyPath, func(r *http.Request) *HealthReport {
+		return ps.Readiness(r.Context())
+	}, HealthReady)
+	ps.serverConfig.Logger.Info("[ranch] liveness and readiness endpoints enabled", "live", state.livePath,
+		"ready", state.readyPath)
+}
+
+// setHealthRoute serves the report of a health endpoint, with a 503 unless its status is ok.
+func (ps *platformServer) setHealthRoute(path string, report func(r *http.Request) *HealthReport, ok string) {
+	cfg := ps.serverConfig.Health
+	ps.router.Path(path).Name(path).Methods(http.MethodGet, http.MethodHead).HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if cfg.Authorize != nil && !cfg.Authorize(r) {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			rep := report(r)
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "no-store")
+			if rep.Status != ok {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+			if r.Method != http.MethodHead {
+				_ = json.NewEncoder(w).Encode(rep)
+			}
+		})
+}
+
+// Readiness returns whether the server is ready to be sent traffic: online, serving HTTP, with every required
+// dependency up and every registered service initialized and passing its health check. Health checks run
+// concurrently, each given the configured timeout.
+func (ps *platformServer) Readiness(ctx context.Context) *HealthReport {
+	report := ps.Health()
+	timeout := defaultHealthCheckTimeout
+	if ps.health != nil {
+		timeout = ps.health.timeout
+		if !ps.health.online.Load() {
+			report.Status = HealthStarting
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	readyStore := ps.eventbus.GetStoreManager().GetStore(service.ServiceReadyStore)
+	lifecycleManager := service.GetServiceLifecycleManager()
+	channels := service.GetServiceRegistry().GetAllServiceChannels()
+	sort.Strings(channels)
+	var wg sync.WaitGroup
+	for _, channel := range channels {
+		svc := &ServiceHealth{Channel: channel, Healthy: true}
+		if ready, found := readyStore.Get(channel); found && ready == true {
+			svc.Ready = true
+		}
+		report.Services = append(report.Services, svc)
+		check := lifecycleManager.GetHealthCheckService(channel)
+		if check == nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := runHealthCheck(ctx, check); err != nil {
+				svc.Healthy = false
+				svc.Error = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, svc := range report.Services {
+		switch {
+		case !svc.Ready:
+			report.Status = HealthStarting
+		case !svc.Healthy && report.Status == HealthReady:
+			report.Status = HealthDegraded
+		}
+	}
+	if !report.Http {
+		report.Status = HealthUnavailable
+	}
+	return report
+}
+
+// runHealthCheck runs the health check of a service, failing it once ctx is done even if the check hangs.
+func runHealthCheck(ctx context.Context, check service.HealthCheckEnabled) error {
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("health check panicked: %v", r)
+			}
+		}()
+		done <- check.HealthCheck(ctx)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("health check timed out: %w", ctx.Err())
+	}
+}
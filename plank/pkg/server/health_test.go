@@ -0,0 +1,116 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/model"
+	"github.com/pb33f/ranch/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type healthTestService struct {
+	err  error
+	hang bool
+}
+
+func (s *healthTestService) HandleServiceRequest(request *model.Request, core service.FabricServiceCore) {
+}
+
+func (s *healthTestService) OnServiceReady() chan bool {
+	return make(chan bool, 1)
+}
+
+func (s *healthTestService) HealthCheck(ctx context.Context) error {
+	if s.hang {
+		// ignores ctx, as a check stuck on a dead connection would
+		time.Sleep(5 * time.Second)
+	}
+	return s.err
+}
+
+func TestHealth_LiveAndReady(t *testing.T) {
+	bus.ResetBus()
+	service.ResetServiceRegistry()
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", GetTestPort(), true)
+	config.Health = &HealthConfig{CheckTimeoutSeconds: 1}
+	ps := NewPlatformServer(config).(*platformServer)
+	require.NotNil(t, ps.health)
+
+	cattle := &healthTestService{}
+	require.NoError(t, ps.RegisterService(&usageTestService{}, "feed-service"))
+	require.NoError(t, ps.RegisterService(cattle, "cattle-service"))
+
+	get := func(path string) (int, *HealthReport) {
+		w := httptest.NewRecorder()
+		ps.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		var report HealthReport
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&report))
+		return w.Code, &report
+	}
+
+	// alive from the start, ready only once serving and online
+	status, report := get("/health/live")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, HealthLive, report.Status)
+	status, report = get("/health/ready")
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, HealthUnavailable, report.Status)
+
+	ps.ServerAvailability.Http = true
+	ps.markOnline()
+	status, report = get("/health/ready")
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, HealthStarting, report.Status)
+	if assert.Len(t, report.Services, 2) {
+		assert.Equal(t, "cattle-service", report.Services[0].Channel)
+		assert.False(t, report.Services[0].Ready)
+		assert.Equal(t, "feed-service", report.Services[1].Channel)
+		assert.True(t, report.Services[1].Ready)
+	}
+
+	// ready once every service is initialized
+	readyStore := ps.eventbus.GetStoreManager().GetStore(service.ServiceReadyStore)
+	readyStore.Put("cattle-service", true, service.ServiceInitStateChange)
+	status, report = get("/health/ready")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, HealthReady, report.Status)
+
+	// failing and hanging health checks degrade it
+	cattle.err = errors.New("the herd got out")
+	status, report = get("/health/ready")
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, HealthDegraded, report.Status)
+	assert.False(t, report.Services[0].Healthy)
+	assert.Equal(t, "the herd got out", report.Services[0].Error)
+
+	cattle.err, cattle.hang = nil, true
+	started := time.Now()
+	report = ps.Readiness(context.Background())
+	assert.Less(t, time.Since(started), 3*time.Second)
+	assert.Equal(t, HealthDegraded, report.Status)
+	assert.Contains(t, report.Services[0].Error, "timed out")
+
+	// load balancers probing with HEAD get the status alone
+	w := httptest.NewRecorder()
+	ps.router.ServeHTTP(w, httptest.NewRequest(http.MethodHead, "/health/live", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Zero(t, w.Body.Len())
+
+	// probes may be restricted
+	ps.serverConfig.Health.Authorize = func(r *http.Request) bool { return false }
+	w = httptest.NewRecorder()
+	ps.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
@@ -24,6 +24,7 @@ func generatePlatformServerConfig(f *serverConfigFactory) (*PlatformServerConfig
 	noBanner := f.NoBanner()
 	cert := f.Cert()
 	certKey := f.CertKey()
+	fipsMode := f.FIPSMode()
 	spaPath := f.SpaPath()
 	noFabricBroker := f.NoFabricBroker()
 	fabricEndpoint := f.FabricEndpoint()
@@ -32,6 +33,8 @@ func generatePlatformServerConfig(f *serverConfigFactory) (*PlatformServerConfig
 	requestPrefix := f.RequestPrefix()
 	requestQueuePrefix := f.RequestQueuePrefix()
 	restBridgeTimeout := f.RestBridgeTimeout()
+	devMode := f.DevMode()
+	accessLog := f.AccessLog()
 
 	// if config file flag is provided, read directly from the file
 	if len(configFile) > 0 {
@@ -65,6 +68,12 @@ func generatePlatformServerConfig(f *serverConfigFactory) (*PlatformServerConfig
 			serverConfig.SpaConfig.CollateCacheControlRules()
 		}
 
+		if len(devMode) > 0 {
+			if serverConfig.DevMode, err = LoadDevModeConfig(devMode); err != nil {
+				return nil, err
+			}
+		}
+
 		return &serverConfig, nil
 	}
 
@@ -80,24 +89,18 @@ func generatePlatformServerConfig(f *serverConfigFactory) (*PlatformServerConfig
 
 	// instantiate a server config
 	serverConfig := &PlatformServerConfig{
-		Host:            host,
-		Port:            port,
-		RootDir:         rootDir,
-		StaticDir:       static,
-		ShutdownTimeout: time.Duration(shutdownTimeoutInMinutes) * time.Minute,
-		//LogConfig: &utils.LogConfig{
-		//	AccessLog:     accessLog,
-		//	ErrorLog:      errorLog,
-		//	OutputLog:     outputLog,
-		//	Root:          rootDir,
-		//	FormatOptions: &utils.LogFormatOption{},
-		//},
+		Host:              host,
+		Port:              port,
+		RootDir:           rootDir,
+		StaticDir:         static,
+		ShutdownTimeout:   time.Duration(shutdownTimeoutInMinutes) * time.Minute,
+		AccessLog:         accessLogConfig(accessLog),
 		Debug:             debug,
 		NoBanner:          noBanner,
 		RestBridgeTimeout: time.Duration(restBridgeTimeout) * time.Minute,
 	}
 
-	if len(certKey) > 0 && len(certKey) > 0 {
+	if len(cert) > 0 && len(certKey) > 0 {
 		var err error
 		certKey, err = filepath.Abs(certKey)
 		if err != nil {
@@ -108,7 +111,7 @@ func generatePlatformServerConfig(f *serverConfigFactory) (*PlatformServerConfig
 			return nil, err
 		}
 
-		serverConfig.TLSCertConfig = &TLSCertConfig{CertFile: cert, KeyFile: certKey}
+		serverConfig.TLSCertConfig = &TLSCertConfig{CertFile: cert, KeyFile: certKey, FIPSMode: fipsMode}
 	}
 
 	if len(strings.TrimSpace(spaPath)) > 0 {
@@ -132,6 +135,13 @@ func generatePlatformServerConfig(f *serverConfigFactory) (*PlatformServerConfig
 		}
 	}
 
+	if len(devMode) > 0 {
+		var err error
+		if serverConfig.DevMode, err = LoadDevModeConfig(devMode); err != nil {
+			return nil, err
+		}
+	}
+
 	return serverConfig, nil
 }
 
@@ -170,3 +180,15 @@ func sanitizeConfigRootPath(config *PlatformServerConfig) {
 	// once it has been confirmed that the path exists, set config.RootDir to the absolute path
 	config.RootDir = absRootPath
 }
+
+// accessLogConfig returns the access log of the --access-log flag: stdout, stderr, null for none, or the path
+// of a file.
+func accessLogConfig(output string) *AccessLogConfig {
+	switch output {
+	case "", "null":
+		return nil
+	case AccessLogSinkStdout, AccessLogSinkStderr:
+		return &AccessLogConfig{Sinks: []*AccessLogSink{{Type: output}}}
+	}
+	return &AccessLogConfig{Sinks: []*AccessLogSink{{Type: AccessLogSinkFile, Path: output}}}
+}
@@ -1,9 +1,11 @@
 package server
 
 import (
+	"bytes"
 	"encoding/json"
 	"github.com/spf13/pflag"
 	"github.com/stretchr/testify/assert"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"testing"
@@ -38,6 +40,7 @@ func TestGeneratePlatformServerConfig_Default(t *testing.T) {
 	assert.EqualValues(t, "/pub", config.FabricConfig.EndpointConfig.AppRequestPrefix)
 	assert.EqualValues(t, "/pub/queue", config.FabricConfig.EndpointConfig.AppRequestQueuePrefix)
 	assert.EqualValues(t, 60000, config.FabricConfig.EndpointConfig.Heartbeat)
+	assert.EqualValues(t, AccessLogSinkStdout, config.AccessLog.Sinks[0].Type)
 }
 
 func TestGeneratePlatformServerConfig_CertConfig(t *testing.T) {
@@ -132,3 +135,26 @@ func TestMarshalResponseBody_nonByteSlice(t *testing.T) {
 	assert.Nil(t, err)
 	assert.EqualValues(t, jsonMarshalled, results)
 }
+
+func TestPlatformServerConfig_RedactsSecrets(t *testing.T) {
+	config := &PlatformServerConfig{
+		Host: "localhost",
+		BrokerBridges: []*BrokerBridgeConfig{{
+			Name:       "rabbit",
+			ServerAddr: "rabbit:61613",
+			Username:   "guest",
+			Password:   "hunter2",
+		}},
+	}
+
+	out := config.String()
+	assert.NotContains(t, out, "hunter2")
+	assert.Contains(t, out, `"password": "********"`)
+	assert.Contains(t, out, `"username": "guest"`)
+
+	var buf bytes.Buffer
+	slog.New(slog.NewJSONHandler(&buf, nil)).Info("config", "config", config)
+	assert.NotContains(t, buf.String(), "hunter2")
+	assert.Contains(t, buf.String(), `"server_addr":"rabbit:61613"`)
+	assert.Equal(t, "hunter2", config.BrokerBridges[0].Password)
+}
@@ -1,19 +1,25 @@
 package server
 
 import (
+    "crypto/tls"
+    "crypto/x509"
     "fmt"
     "github.com/gorilla/mux"
     "github.com/pb33f/ranch/bus"
+    "github.com/pb33f/ranch/log"
     "github.com/pb33f/ranch/model"
     "github.com/pb33f/ranch/plank/pkg/middleware"
     "github.com/pb33f/ranch/plank/utils"
     "github.com/pb33f/ranch/service"
     "github.com/pb33f/ranch/stompserver"
+    "net"
     "net/http"
     _ "net/http/pprof"
+    "os"
     "path/filepath"
     "reflect"
     "runtime"
+    "strings"
     "time"
 )
 
@@ -30,9 +36,24 @@ func (ps *platformServer) initialize() {
     var serviceRegistryInstance = service.GetServiceRegistry()
     var svcLifecycleManager = service.GetServiceLifecycleManager()
 
+    // capture recent logs for diagnostics bundles before anything is logged
+    ps.initDiagnostics()
+
+    // route the logging of the bus, stores, STOMP server, bridges and services through the configured logger
+    log.SetLogger(ps.serverConfig.Logger)
+
     // create essential bus channels
     ps.eventbus.GetChannelManager().CreateChannel(RANCH_SERVER_ONLINE_CHANNEL)
 
+    // relay abuse guard events onto the bus
+    ps.initAbuseGuard()
+
+    // refuse revoked session tokens, on the fabric broker and the HTTP routes alike
+    ps.initTokenRevocations()
+
+    // keep the configured stores across restarts
+    ps.initStorePersistence()
+
     // initialize HTTP endpoint handlers map
     ps.endpointHandlerMap = map[string]http.HandlerFunc{}
     ps.serviceChanToBridgeEndpoints = make(map[string][]string, 0)
@@ -61,6 +82,7 @@ func (ps *platformServer) initialize() {
             }
         }()
         ps.serverConfig.Logger.Debug("Debug logging and profiling enabled. Available types of profiles at http://localhost:6060/debug/pprof")
+        ps.serverConfig.Logger.Debug("[ranch] server configuration", "config", ps.serverConfig)
     }
 
     // set a new route handler
@@ -80,6 +102,43 @@ func (ps *platformServer) initialize() {
         ps.SetStaticRoute(uri, p)
     }
 
+    // register the diagnostics bundle, log stream, store backup, store snapshot, usage report and fabric
+    // connections admin endpoints, the load signal, traffic classes, traffic ramp, health output, liveness and
+    // readiness, metrics and fabric ticket endpoint, open the access log, tag REST bridge responses for edge
+    // caches, answer the CORS preflights of REST bridges, trust the user headers of the SSO proxy, read the
+    // access control file, document the REST bridges, mount the WebSub hub and the settings REST API,
+    // negotiate the representations of REST bridge responses and cache them
+    ps.setDiagnosticsRoute()
+    ps.setLogStreamRoute()
+    ps.setStoreBackupRoute()
+    ps.setStoreSnapshotRoute()
+    ps.initUsageAccounting()
+    ps.setConnectionsRoute()
+    ps.initLoadSignal()
+    ps.initClassification()
+    ps.initTrafficRamp()
+    ps.initDependencies()
+    ps.initHealth()
+    ps.initMetrics()
+    ps.initAccessLog()
+    ps.initFabricTicket()
+    ps.initEdgeCache()
+    ps.initCors()
+    ps.initBridgeRouting()
+    ps.initTrustedHeaders()
+    ps.initAcl()
+    ps.initApiDocs()
+    ps.initWebSub()
+    ps.initSettings()
+    ps.initNegotiation()
+    ps.initResponseCache()
+
+    // serve the canned responses of dev mode before services get to bridge the same endpoints
+    ps.initDevMode()
+
+    // take over the listening sockets of the process this one replaces, before anything listens
+    ps.initGracefulRestart()
+
     // create an Http server instance
     ps.HttpServer = &http.Server{
         Addr:         fmt.Sprintf(":%d", ps.serverConfig.Port),
@@ -87,6 +146,19 @@ func (ps *platformServer) initialize() {
         WriteTimeout: 60 * time.Second,
     }
 
+    // in FIPS mode restrict the TLS configuration to the vetted set from the outset
+    if ps.serverConfig.TLSCertConfig != nil && ps.serverConfig.TLSCertConfig.FIPSMode {
+        ps.HttpServer.TLSConfig = NewFIPSTLSConfig()
+    }
+
+    // configure HTTP/2, h2c and the HTTP/3 server
+    ps.initHttp2()
+    ps.initHttp3()
+
+    // get the certificates from an ACME CA such as Let's Encrypt, or the cert and key files as they are rotated
+    ps.initAcme()
+    ps.initCertificateReloads()
+
     // set up a listener to receive REST bridge configs for services and set them up according to their specs
     lcmChanHandler, err := ps.eventbus.ListenStreamForDestination(service.LifecycleManagerChannelName, ps.eventbus.GetId())
     if err != nil {
@@ -148,52 +220,203 @@ func (ps *platformServer) configureFabric() {
     }
 
     var err error
-    if ps.serverConfig.FabricConfig.UseTCP {
-        ps.fabricConn, err = stompserver.NewTcpConnectionListener(fmt.Sprintf(":%d", ps.serverConfig.FabricConfig.TCPPort))
+    if ps.serverConfig.FabricConfig.UseTCP && ps.serverConfig.FabricConfig.SharePort {
+        // raw TCP STOMP clients share the HTTP(S) port, connections are told apart as they come in
+        var tlsConfig *tls.Config
+        if tlsConfig, err = ps.fabricTLSConfig(); err == nil {
+            ps.portMux = stompserver.NewPortMux(tlsConfig, 0)
+            ps.fabricConn = stompserver.NewTcpConnectionListenerFromListener(ps.portMux.StompListener())
+        }
+    } else if ps.serverConfig.FabricConfig.UseTCP && ps.serverConfig.FabricConfig.UseTLS {
+        var tlsConfig *tls.Config
+        if tlsConfig, err = ps.fabricTLSConfig(); err == nil && tlsConfig == nil {
+            err = fmt.Errorf("fabric over TCP with TLS requires a TLS certificate configuration")
+        }
+        var listener net.Listener
+        if err == nil {
+            listener, err = ps.listen("fabric", fmt.Sprintf(":%d", ps.serverConfig.FabricConfig.TCPPort))
+        }
+        if err == nil {
+            ps.fabricConn = stompserver.NewTcpConnectionListenerFromListener(tls.NewListener(listener, tlsConfig))
+        }
+    } else if ps.serverConfig.FabricConfig.UseTCP {
+        var listener net.Listener
+        if listener, err = ps.listen("fabric", fmt.Sprintf(":%d", ps.serverConfig.FabricConfig.TCPPort)); err == nil {
+            ps.fabricConn = stompserver.NewTcpConnectionListenerFromListener(listener)
+        }
     } else {
         ps.fabricConn, err = stompserver.NewWebSocketConnectionFromExistingHttpServer(
             ps.HttpServer,
             ps.router,
             ps.serverConfig.FabricConfig.FabricEndpoint,
             nil, ps.serverConfig.Logger, ps.serverConfig.Debug,
-            ps.serverConfig.SocketCreationFunc) // TODO: consider tightening access by allowing configuring allowedOrigins
+            ps.serverConfig.SocketCreationFunc)
     }
 
     // if creation of listener fails, crash and burn
     if err != nil {
         panic(err)
     }
+
+    // refuse giant frames before they are read into memory
+    if limited, ok := ps.fabricConn.(stompserver.FrameLimitedListener); ok {
+        limited.SetFrameLimits(stompserver.FrameLimits{
+            MaxFrameSize: ps.serverConfig.FabricConfig.MaxFrameSize,
+            MaxBodySize:  ps.serverConfig.FabricConfig.MaxBodySize,
+        })
+    }
+
+    // chatty UI channels send many similar frames, which compress well
+    if compressed, ok := ps.fabricConn.(stompserver.CompressedListener); ok && ps.serverConfig.FabricConfig.Compression {
+        compressed.SetCompression(stompserver.WebSocketCompression{
+            Enabled:   true,
+            Level:     ps.serverConfig.FabricConfig.CompressionLevel,
+            Threshold: ps.serverConfig.FabricConfig.CompressionThreshold,
+        })
+    }
+
+    // browsers may only connect from the allowed origins, with the CSRF token of their session
+    guard := stompserver.WebSocketGuard{
+        AllowedOrigins:      ps.serverConfig.FabricConfig.AllowedOrigins,
+        CSRFCookie:          ps.serverConfig.FabricConfig.CSRFCookie,
+        CSRFParam:           ps.serverConfig.FabricConfig.CSRFParam,
+        MaxConnectionsPerIP: ps.serverConfig.FabricConfig.MaxConnectionsPerIP,
+    }
+    if guarded, ok := ps.fabricConn.(stompserver.GuardedListener); ok {
+        guarded.SetWebSocketGuard(guard)
+    }
+
+    endpointConfig := ps.serverConfig.FabricConfig.EndpointConfig
+    if endpointConfig == nil {
+        return
+    }
+    listeners := []stompserver.RawConnectionListener{ps.fabricConn}
+
+    // MQTT clients share the broker, topics map onto the same channels STOMP destinations do
+    if ps.serverConfig.FabricConfig.MqttPort > 0 {
+        listener, err := ps.listen("mqtt", fmt.Sprintf(":%d", ps.serverConfig.FabricConfig.MqttPort))
+        if err != nil {
+            panic(err)
+        }
+        listeners = append(listeners, stompserver.NewMqttConnectionListenerFromListener(listener,
+            stompserver.MqttConfig{
+                TopicPrefix:      withTrailingSlash(endpointConfig.TopicPrefix),
+                AppRequestPrefix: withTrailingSlash(endpointConfig.AppRequestPrefix),
+            }))
+    }
+
+    // so do clients speaking plain JSON over a WebSocket, for browsers without a STOMP library
+    if ps.serverConfig.FabricConfig.JsonWebSocketEndpoint != "" {
+        jsonListener, err := stompserver.NewJsonWebSocketConnectionListener(
+            ps.router,
+            ps.serverConfig.FabricConfig.JsonWebSocketEndpoint,
+            nil,
+            stompserver.JsonWebSocketConfig{
+                TopicPrefix:           withTrailingSlash(endpointConfig.TopicPrefix),
+                UserQueuePrefix:       withTrailingSlash(endpointConfig.UserQueuePrefix),
+                AppRequestPrefix:      withTrailingSlash(endpointConfig.AppRequestPrefix),
+                AppRequestQueuePrefix: withTrailingSlash(endpointConfig.AppRequestQueuePrefix),
+            }, ps.serverConfig.Logger)
+        if err != nil {
+            panic(err)
+        }
+        jsonListener.(stompserver.GuardedListener).SetWebSocketGuard(guard)
+        listeners = append(listeners, jsonListener)
+    }
+
+    if len(listeners) > 1 {
+        ps.fabricConn = stompserver.NewMultiConnectionListener(listeners...)
+    }
+}
+
+// fabricTLSConfig returns the TLS configuration of raw TCP STOMP clients, on a shared port or TCPPort, nil
+// if the server does not use TLS. Client certificates are verified against ClientCAFile if set.
+func (ps *platformServer) fabricTLSConfig() (*tls.Config, error) {
+    certConfig := ps.serverConfig.TLSCertConfig
+    if certConfig == nil {
+        return nil, nil
+    }
+    tlsConfig := &tls.Config{}
+    if ps.HttpServer.TLSConfig != nil {
+        tlsConfig = ps.HttpServer.TLSConfig.Clone()
+    }
+    if tlsConfig.GetCertificate == nil {
+        cert, err := tls.LoadX509KeyPair(certConfig.CertFile, certConfig.KeyFile)
+        if err != nil {
+            return nil, err
+        }
+        tlsConfig.Certificates = []tls.Certificate{cert}
+    }
+
+    fabricConfig := ps.serverConfig.FabricConfig
+    if fabricConfig.ClientCAFile != "" {
+        pem, err := os.ReadFile(fabricConfig.ClientCAFile)
+        if err != nil {
+            return nil, err
+        }
+        tlsConfig.ClientCAs = x509.NewCertPool()
+        if !tlsConfig.ClientCAs.AppendCertsFromPEM(pem) {
+            return nil, fmt.Errorf("no certificates found in client CA file %s", fabricConfig.ClientCAFile)
+        }
+        tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+        if fabricConfig.RequireClientCert {
+            tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+        }
+    } else if fabricConfig.RequireClientCert {
+        return nil, fmt.Errorf("client certificates cannot be required without a client CA file")
+    }
+    return tlsConfig, nil
+}
+
+func withTrailingSlash(prefix string) string {
+    if prefix != "" && !strings.HasSuffix(prefix, "/") {
+        return prefix + "/"
+    }
+    return prefix
 }
 
 func (ps *platformServer) configureSPA() {
-    if ps.serverConfig.SpaConfig == nil {
+    spa := ps.serverConfig.SpaConfig
+    if spa == nil {
         return
     }
 
     // TODO: error if the base uri conflicts with another URI registered before
-    for _, asset := range ps.serverConfig.SpaConfig.StaticAssets {
+    for _, asset := range spa.StaticAssets {
         folderPath, uri := utils.DeriveStaticURIFromPath(asset)
-        ps.SetStaticRoute(
-            utils.SanitizeUrl(uri, false),
-            folderPath,
-            ps.serverConfig.SpaConfig.CacheControlMiddleware())
+        uri = utils.SanitizeUrl(uri, false)
+        if spa.Assets != nil {
+            ps.SetStaticRouteFS(uri, subFS(spa.Assets, folderPath), spa.CacheControlMiddleware())
+            continue
+        }
+        ps.SetStaticRoute(uri, folderPath, spa.CacheControlMiddleware())
     }
 
+    root := &staticMount{uri: spa.BaseUri, path: spa.RootFolder, fsys: os.DirFS(spa.RootFolder), index: true}
+    if spa.Assets != nil {
+        root.path, root.fsys = "", subFS(spa.Assets, spa.RootFolder)
+    }
+    ps.addStaticMount(root)
+
     // TODO: consider handling handlers of conflicting keys
-    endpointHandlerMapKey := ps.serverConfig.SpaConfig.BaseUri + "*"
-    ps.endpointHandlerMap[endpointHandlerMapKey] = func(w http.ResponseWriter, r *http.Request) { // '*' at the end of BaseUri is to indicate it is a prefix route handler
+    endpointHandlerMapKey := spa.BaseUri + "*"
+    handler := func(w http.ResponseWriter, r *http.Request) { // '*' at the end of BaseUri is to indicate it is a prefix route handler
         resource := "index.html"
 
         // if the URI contains an extension we treat it as access to static resources
         if len(filepath.Ext(r.URL.Path)) > 0 {
             resource = filepath.Clean(r.URL.Path)
         }
-        http.ServeFile(w, r, filepath.Join(ps.serverConfig.SpaConfig.RootFolder, resource))
+        http.ServeFile(w, r, filepath.Join(spa.RootFolder, resource))
+    }
+    if spa.Assets != nil {
+        handler = spaFSHandler(spa.BaseUri, root.fsys)
     }
+    ps.endpointHandlerMap[endpointHandlerMapKey] = ps.staticContentHandler(root, handler)
 
-    spaConfigCacheControlMiddleware := ps.serverConfig.SpaConfig.CacheControlMiddleware()
+    spaConfigCacheControlMiddleware := spa.CacheControlMiddleware()
     ps.router.
-        PathPrefix(ps.serverConfig.SpaConfig.BaseUri).
+        PathPrefix(spa.BaseUri).
         Name(endpointHandlerMapKey).
         Handler(spaConfigCacheControlMiddleware(ps.endpointHandlerMap[endpointHandlerMapKey]))
 }
@@ -0,0 +1,131 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+	"time"
+)
+
+// listenerKey is the context key of the listener a request came in on, absent for the server port.
+type listenerKey struct{}
+
+// listenerFromRequest returns the extra listener a request came in on, nil if it came in on the server port.
+func listenerFromRequest(r *http.Request) *ListenerConfig {
+	cfg, _ := r.Context().Value(listenerKey{}).(*ListenerConfig)
+	return cfg
+}
+
+// matchesRoutes tells whether a path is one of the routes of a listener. Routes ending in * match every
+// path under them.
+func (lc *ListenerConfig) matchesRoutes(path string) bool {
+	for _, route := range lc.Routes {
+		if prefix, ok := strings.CutSuffix(route, "*"); ok {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		} else if path == route {
+			return true
+		}
+	}
+	return false
+}
+
+// listenerRoutesMiddleware keeps the routes moved to extra listeners off the server port, answering them
+// with 404 there.
+func (ps *platformServer) listenerRoutesMiddleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if listenerFromRequest(r) == nil {
+			for _, lc := range ps.serverConfig.Listeners {
+				if !lc.Shared && lc.matchesRoutes(r.URL.Path) {
+					http.NotFound(w, r)
+					return
+				}
+			}
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// listenerHandler serves the routes of an extra listener with the handler of the server, so they go through
+// the same middleware, and the profiler if the listener serves it. Other paths are not found.
+func (ps *platformServer) listenerHandler(lc *ListenerConfig) http.Handler {
+	profiler := http.NewServeMux()
+	profiler.HandleFunc("/debug/pprof/", pprof.Index)
+	profiler.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	profiler.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	profiler.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	profiler.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case lc.Pprof && strings.HasPrefix(r.URL.Path, "/debug/pprof/"):
+			profiler.ServeHTTP(w, r)
+		case lc.matchesRoutes(r.URL.Path):
+			ps.HttpServer.Handler.ServeHTTP(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+// startListeners starts the extra listeners, once the handler of the server is ready.
+func (ps *platformServer) startListeners() {
+	for _, lc := range ps.serverConfig.Listeners {
+		srv := &http.Server{
+			Addr:              net.JoinHostPort(lc.Host, fmt.Sprint(lc.Port)),
+			Handler:           ps.listenerHandler(lc),
+			ReadHeaderTimeout: 10 * time.Second,
+			BaseContext: func(net.Listener) context.Context {
+				return context.WithValue(context.Background(), listenerKey{}, lc)
+			},
+		}
+		if lc.TLS && ps.HttpServer.TLSConfig != nil {
+			srv.TLSConfig = ps.HttpServer.TLSConfig.Clone()
+		}
+		listener, err := ps.listen("listener:"+lc.Name, srv.Addr)
+		if err != nil {
+			ps.serverConfig.Logger.Error(wrapError(errServerInit,
+				fmt.Errorf("unable to start listener %s: %w", lc.Name, err)).Error())
+			continue
+		}
+
+		ps.lock.Lock()
+		ps.listeners = append(ps.listeners, srv)
+		ps.lock.Unlock()
+
+		ps.serverConfig.Logger.Info("[ranch] opening another gate to the ranch", "listener", lc.Name,
+			"address", listener.Addr().String(), "routes", lc.Routes, "pprof", lc.Pprof)
+		go func() {
+			var err error
+			if lc.TLS && ps.serverConfig.TLSCertConfig != nil {
+				certFile, keyFile := ps.certFiles()
+				err = srv.ServeTLS(listener, certFile, keyFile)
+			} else {
+				err = srv.Serve(listener)
+			}
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				ps.serverConfig.Logger.Error(wrapError(errServerInit, err).Error())
+			}
+		}()
+	}
+}
+
+// stopListeners gracefully stops the extra listeners, until ctx is done.
+func (ps *platformServer) stopListeners(ctx context.Context) {
+	ps.lock.Lock()
+	listeners := ps.listeners
+	ps.listeners = nil
+	ps.lock.Unlock()
+	for _, srv := range listeners {
+		if err := srv.Shutdown(ctx); err != nil {
+			ps.serverConfig.Logger.Error(err.Error())
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// freePort returns a port nothing listens on.
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestListeners(t *testing.T) {
+	bus.ResetBus()
+	service.ResetServiceRegistry()
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", GetTestPort(), true)
+	admin := &ListenerConfig{Name: "admin", Host: "127.0.0.1", Port: freePort(t), Pprof: true,
+		Routes: []string{"/health", "/admin/*"}}
+	docs := &ListenerConfig{Name: "docs", Host: "127.0.0.1", Port: freePort(t), Routes: []string{"/docs"}, Shared: true}
+	config.Listeners = []*ListenerConfig{admin, docs}
+	ps := NewPlatformServer(config).(*platformServer)
+	router := mux.NewRouter()
+	for _, path := range []string{"/health", "/admin/stores", "/docs", "/api/cows"} {
+		router.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("moo"))
+		})
+	}
+	ps.loadGlobalHttpHandler(router)
+	public := httptest.NewServer(ps.HttpServer.Handler)
+	defer public.Close()
+	ps.startListeners()
+	defer ps.stopListeners(context.Background())
+
+	get := func(base, path string) int {
+		rsp, err := http.Get(base + path)
+		require.NoError(t, err)
+		_, _ = io.Copy(io.Discard, rsp.Body)
+		_ = rsp.Body.Close()
+		return rsp.StatusCode
+	}
+	adminUrl := fmt.Sprintf("http://127.0.0.1:%d", admin.Port)
+	docsUrl := fmt.Sprintf("http://127.0.0.1:%d", docs.Port)
+
+	// routes moved to a listener are only served there, shared ones on both
+	assert.Equal(t, http.StatusOK, get(adminUrl, "/health"))
+	assert.Equal(t, http.StatusOK, get(adminUrl, "/admin/stores"))
+	assert.Equal(t, http.StatusOK, get(adminUrl, "/debug/pprof/"))
+	assert.Equal(t, http.StatusNotFound, get(adminUrl, "/api/cows"))
+	assert.Equal(t, http.StatusNotFound, get(adminUrl, "/docs"))
+	assert.Equal(t, http.StatusOK, get(docsUrl, "/docs"))
+	assert.Equal(t, http.StatusNotFound, get(docsUrl, "/debug/pprof/"))
+
+	assert.Equal(t, http.StatusOK, get(public.URL, "/api/cows"))
+	assert.Equal(t, http.StatusOK, get(public.URL, "/docs"))
+	assert.Equal(t, http.StatusNotFound, get(public.URL, "/health"))
+	assert.Equal(t, http.StatusNotFound, get(public.URL, "/admin/stores"))
+	assert.Equal(t, http.StatusNotFound, get(public.URL, "/debug/pprof/"))
+
+	// a listener that cannot listen is skipped
+	ps.serverConfig.Listeners = []*ListenerConfig{{Name: "taken", Host: "127.0.0.1", Port: admin.Port}}
+	ps.startListeners()
+	assert.Len(t, ps.listeners, 2)
+}
+
+func TestAdminHandler_TrustedListener(t *testing.T) {
+	bus.ResetBus()
+	service.ResetServiceRegistry()
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", GetTestPort(), true)
+	ps := NewPlatformServer(config).(*platformServer)
+	handler := ps.adminHandler("cows", nil, func(w http.ResponseWriter, r *http.Request) {})
+
+	request := func(lc *ListenerConfig) int {
+		req := httptest.NewRequest(http.MethodGet, "/admin/cows", nil)
+		req.RemoteAddr = "10.0.0.7:43210"
+		if lc != nil {
+			req = req.WithContext(context.WithValue(req.Context(), listenerKey{}, lc))
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec.Code
+	}
+	assert.Equal(t, http.StatusForbidden, request(nil))
+	assert.Equal(t, http.StatusForbidden, request(&ListenerConfig{Name: "internal"}))
+	assert.Equal(t, http.StatusOK, request(&ListenerConfig{Name: "internal", Trusted: true}))
+}
@@ -0,0 +1,188 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/clock"
+	"github.com/pb33f/ranch/model"
+	"github.com/pb33f/ranch/service"
+	"github.com/pb33f/ranch/stompserver"
+)
+
+const defaultTargetUtilizationPercent = 80
+
+// LoadSignal is a compact snapshot of how busy the instance is, meant for external autoscalers. A KEDA
+// metrics-api scaler can point valueLocation at utilization_percent with target_utilization_percent as
+// its targetValue, an HPA external metric can use it the same way.
+type LoadSignal struct {
+	InFlightHttpRequests     int64            `json:"in_flight_http_requests"`
+	InFlightServiceRequests  int64            `json:"in_flight_service_requests"`
+	QueueDepths              map[string]int64 `json:"queue_depths"` // requests being handled, by service channel
+	FabricConnections        int64            `json:"fabric_connections"`
+	UtilizationPercent       int              `json:"utilization_percent"` // busiest signal against its configured capacity
+	TargetUtilizationPercent int              `json:"target_utilization_percent"`
+	Timestamp                time.Time        `json:"timestamp"`
+}
+
+// loadSignalState holds the counters that are not tracked anywhere else.
+type loadSignalState struct {
+	inFlightHttp      int64
+	fabricConnections int64
+	sessionHandler    bus.MessageHandler
+	stop              chan struct{}
+}
+
+// initLoadSignal starts tracking in-flight HTTP requests and registers the load signal endpoint, if
+// the load signal is configured.
+func (ps *platformServer) initLoadSignal() {
+	cfg := ps.serverConfig.LoadSignal
+	if cfg == nil {
+		return
+	}
+	ps.loadSignal = &loadSignalState{}
+	if cfg.Endpoint == "" {
+		return
+	}
+	ps.router.Path(cfg.Endpoint).Name(cfg.Endpoint).Methods(http.MethodGet).HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if cfg.Authorize != nil && !cfg.Authorize(r) {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "no-store")
+			_ = json.NewEncoder(w).Encode(ps.CurrentLoadSignal())
+		})
+	ps.serverConfig.Logger.Info("[ranch] load signal endpoint enabled", "endpoint", cfg.Endpoint)
+}
+
+// loadSignalMiddleware counts the HTTP requests being served.
+func (ps *platformServer) loadSignalMiddleware(next http.Handler) http.Handler {
+	state := ps.loadSignal
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&state.inFlightHttp, 1)
+		defer atomic.AddInt64(&state.inFlightHttp, -1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// CurrentLoadSignal returns how busy the instance is right now.
+func (ps *platformServer) CurrentLoadSignal() *LoadSignal {
+	signal := &LoadSignal{
+		QueueDepths:              service.GetServiceRegistry().GetInFlightRequests(),
+		TargetUtilizationPercent: defaultTargetUtilizationPercent,
+		Timestamp:                clock.Now().UTC(),
+	}
+	var maxDepth int64
+	for _, depth := range signal.QueueDepths {
+		signal.InFlightServiceRequests += depth
+		if depth > maxDepth {
+			maxDepth = depth
+		}
+	}
+	cfg := ps.serverConfig.LoadSignal
+	if ps.loadSignal == nil || cfg == nil {
+		return signal
+	}
+	signal.InFlightHttpRequests = atomic.LoadInt64(&ps.loadSignal.inFlightHttp)
+	signal.FabricConnections = atomic.LoadInt64(&ps.loadSignal.fabricConnections)
+	if cfg.TargetUtilizationPercent > 0 {
+		signal.TargetUtilizationPercent = cfg.TargetUtilizationPercent
+	}
+	signal.UtilizationPercent = max(
+		utilizationPercent(signal.InFlightHttpRequests, cfg.MaxInFlightHttpRequests),
+		utilizationPercent(maxDepth, cfg.MaxQueueDepth),
+		utilizationPercent(signal.FabricConnections, cfg.MaxFabricConnections))
+	return signal
+}
+
+// utilizationPercent returns value as a percentage of capacity, signals without a capacity count as idle.
+func utilizationPercent(value, capacity int64) int {
+	if capacity <= 0 {
+		return 0
+	}
+	return int(value * 100 / capacity)
+}
+
+// startLoadSignal counts fabric connections and publishes the load signal on RANCH_LOAD_SIGNAL_CHANNEL
+// on the configured interval, until the server stops.
+func (ps *platformServer) startLoadSignal() {
+	cfg := ps.serverConfig.LoadSignal
+	state := ps.loadSignal
+	if cfg == nil || state == nil {
+		return
+	}
+
+	if ps.serverConfig.FabricConfig != nil {
+		handler, err := ps.eventbus.ListenStream(bus.STOMP_SESSION_NOTIFY_CHANNEL)
+		if err == nil {
+			handler.Handle(func(msg *model.Message) {
+				evt, ok := msg.Payload.(*bus.StompSessionEvent)
+				if !ok {
+					return
+				}
+				switch evt.EventType {
+				case stompserver.ConnectionStarting:
+					atomic.AddInt64(&state.fabricConnections, 1)
+				case stompserver.ConnectionClosed:
+					if atomic.AddInt64(&state.fabricConnections, -1) < 0 {
+						atomic.StoreInt64(&state.fabricConnections, 0)
+					}
+				}
+			}, func(err error) {})
+			ps.lock.Lock()
+			state.sessionHandler = handler
+			ps.lock.Unlock()
+		}
+	}
+
+	if cfg.PublishIntervalSeconds <= 0 {
+		return
+	}
+	ps.eventbus.GetChannelManager().CreateChannel(RANCH_LOAD_SIGNAL_CHANNEL)
+	stop := make(chan struct{})
+	ps.lock.Lock()
+	state.stop = stop
+	ps.lock.Unlock()
+
+	interval := time.Duration(cfg.PublishIntervalSeconds) * time.Second
+	ps.serverConfig.Logger.Info("[ranch] publishing load signal", "channel", RANCH_LOAD_SIGNAL_CHANNEL,
+		"interval", interval.String())
+	go func() {
+		ticker := clock.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C():
+				_ = ps.eventbus.SendResponseMessage(RANCH_LOAD_SIGNAL_CHANNEL, ps.CurrentLoadSignal(), nil)
+			}
+		}
+	}()
+}
+
+// stopLoadSignal stops counting fabric connections and publishing the load signal.
+func (ps *platformServer) stopLoadSignal() {
+	state := ps.loadSignal
+	if state == nil {
+		return
+	}
+	ps.lock.Lock()
+	handler, stop := state.sessionHandler, state.stop
+	state.sessionHandler, state.stop = nil, nil
+	ps.lock.Unlock()
+	if handler != nil {
+		handler.Close()
+	}
+	if stop != nil {
+		close(stop)
+	}
+}
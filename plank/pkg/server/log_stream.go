@@ -0,0 +1,268 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pb33f/ranch/clock"
+	"github.com/pb33f/ranch/plank/pkg/diagnostics"
+)
+
+const (
+	defaultLogStreamRate      = 50
+	defaultMaxLogStreams      = 5
+	defaultLogStreamBacklog   = 100
+	logStreamBufferSize       = 256
+	logStreamKeepAlive        = 15 * time.Second
+	logStreamWebSocketTimeout = 10 * time.Second
+)
+
+// logStreams keeps track of the clients streaming the logs.
+type logStreams struct {
+	lock  sync.Mutex
+	count int
+	max   int
+	rate  int
+	stop  chan struct{} // closed when the server stops, ending every stream
+}
+
+// open counts a new stream, returning false if as many as allowed are already open.
+func (s *logStreams) open() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.count >= s.max {
+		return false
+	}
+	s.count++
+	return true
+}
+
+func (s *logStreams) close() {
+	s.lock.Lock()
+	s.count--
+	s.lock.Unlock()
+}
+
+// logStreamEvent is a message sent to a log stream: a log record, or the number of records dropped
+// because the client did not keep up or exceeded the rate of the stream.
+type logStreamEvent struct {
+	Type    string                `json:"type"`
+	Entry   *diagnostics.LogEntry `json:"entry,omitempty"`
+	Dropped uint64                `json:"dropped,omitempty"`
+}
+
+// logStreamWriter sends an event to the client of a stream.
+type logStreamWriter func(event *logStreamEvent) error
+
+// setLogStreamRoute registers the endpoint recent and new log records are streamed from, if one is
+// configured. Clients asking for a WebSocket upgrade get a message per record, the others a stream of
+// server-sent events. Query parameters select what is streamed:
+//
+//	level      lowest level streamed: debug, info, warn or error. defaults to info
+//	subsystem  comma separated subsystems streamed, e.g. ranch,bridge. all of them if empty
+//	backlog    number of recent records sent first, defaults to 100
+func (ps *platformServer) setLogStreamRoute() {
+	cfg := ps.serverConfig.Diagnostics
+	if cfg == nil || cfg.LogStreamEndpoint == "" || ps.logBuffer == nil {
+		return
+	}
+	streams := &logStreams{max: cfg.MaxLogStreams, rate: cfg.LogStreamRate, stop: make(chan struct{})}
+	if streams.max <= 0 {
+		streams.max = defaultMaxLogStreams
+	}
+	if streams.rate <= 0 {
+		streams.rate = defaultLogStreamRate
+	}
+	ps.lock.Lock()
+	ps.logStreams = streams
+	ps.lock.Unlock()
+
+	ps.router.Path(cfg.LogStreamEndpoint).Name(cfg.LogStreamEndpoint).Methods(http.MethodGet).HandlerFunc(
+		ps.adminHandler("log stream", cfg.Authorize, func(w http.ResponseWriter, r *http.Request) {
+			filter, backlog, err := parseLogStreamQuery(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if !streams.open() {
+				http.Error(w, "too many log streams open", http.StatusServiceUnavailable)
+				return
+			}
+			defer streams.close()
+			if websocket.IsWebSocketUpgrade(r) {
+				ps.serveLogWebSocket(w, r, streams, filter, backlog)
+			} else {
+				ps.serveLogEvents(w, r, streams, filter, backlog)
+			}
+		}))
+	ps.serverConfig.Logger.Info("[ranch] log stream endpoint enabled", "endpoint", cfg.LogStreamEndpoint,
+		"rate", streams.rate, "streams", streams.max)
+}
+
+// stopLogStreams ends the log streams.
+func (ps *platformServer) stopLogStreams() {
+	ps.lock.Lock()
+	streams := ps.logStreams
+	ps.logStreams = nil
+	ps.lock.Unlock()
+	if streams != nil {
+		close(streams.stop)
+	}
+}
+
+// parseLogStreamQuery reads the filter and backlog of a log stream from the query of its request.
+func parseLogStreamQuery(r *http.Request) (diagnostics.LogFilter, int, error) {
+	query := r.URL.Query()
+	filter := diagnostics.LogFilter{Level: slog.LevelInfo}
+	if level := query.Get("level"); level != "" {
+		if err := filter.Level.UnmarshalText([]byte(level)); err != nil {
+			return filter, 0, fmt.Errorf("invalid level '%s'", level)
+		}
+	}
+	for _, subsystem := range strings.Split(query.Get("subsystem"), ",") {
+		if subsystem = strings.TrimSpace(subsystem); subsystem != "" {
+			filter.Subsystems = append(filter.Subsystems, subsystem)
+		}
+	}
+	backlog := defaultLogStreamBacklog
+	if value := query.Get("backlog"); value != "" {
+		var err error
+		if backlog, err = strconv.Atoi(value); err != nil || backlog < 0 {
+			return filter, 0, fmt.Errorf("invalid backlog '%s'", value)
+		}
+	}
+	return filter, backlog, nil
+}
+
+// serveLogEvents streams the logs as server-sent events, a "log" event per record and a "dropped" event
+// when records were dropped.
+func (ps *platformServer) serveLogEvents(w http.ResponseWriter, r *http.Request, streams *logStreams,
+	filter diagnostics.LogFilter, backlog int) {
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+	keepAlive := func() error {
+		if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+	ps.streamLogs(r, r.Context().Done(), streams, filter, backlog, keepAlive, func(event *logStreamEvent) error {
+		var data any = event.Entry
+		if event.Entry == nil {
+			data = event
+		}
+		payload, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		if _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, payload); err != nil {
+			return err
+		}
+		return rc.Flush()
+	})
+}
+
+// serveLogWebSocket streams the logs over a WebSocket, as a JSON logStreamEvent per message.
+func (ps *platformServer) serveLogWebSocket(w http.ResponseWriter, r *http.Request, streams *logStreams,
+	filter diagnostics.LogFilter, backlog int) {
+
+	upgrader := websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 1024}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// the client sends nothing, reading only notices it went away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	keepAlive := func() error {
+		return conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(logStreamWebSocketTimeout))
+	}
+	ps.streamLogs(r, closed, streams, filter, backlog, keepAlive, func(event *logStreamEvent) error {
+		_ = conn.SetWriteDeadline(time.Now().Add(logStreamWebSocketTimeout))
+		return conn.WriteJSON(event)
+	})
+}
+
+// streamLogs sends the recent records matching filter and then the new ones as they are logged, until the
+// client goes away, closing done, or the server stops. At most streams.rate records are sent per second,
+// the others are dropped and counted, as are those logged faster than the client reads them.
+func (ps *platformServer) streamLogs(r *http.Request, done <-chan struct{}, streams *logStreams,
+	filter diagnostics.LogFilter, backlog int, keepAlive func() error, write logStreamWriter) {
+
+	sub, recent := ps.logBuffer.Subscribe(filter, logStreamBufferSize)
+	defer sub.Close()
+	ps.serverConfig.Logger.Info("[ranch] log stream opened", "remote", r.RemoteAddr, "level",
+		filter.Level.String(), "subsystems", strings.Join(filter.Subsystems, ","))
+
+	if len(recent) > backlog {
+		recent = recent[len(recent)-backlog:]
+	}
+	for i := range recent {
+		if write(&logStreamEvent{Type: "log", Entry: &recent[i]}) != nil {
+			return
+		}
+	}
+
+	ticker := clock.NewTicker(logStreamKeepAlive)
+	defer ticker.Stop()
+	var window time.Time
+	var sent int
+	var limited uint64
+	for {
+		select {
+		case <-done:
+			return
+		case <-streams.stop:
+			return
+		case <-ticker.C():
+			if keepAlive() != nil {
+				return
+			}
+		case entry := <-sub.Entries():
+			if now := clock.Now(); now.Sub(window) >= time.Second {
+				window, sent = now, 0
+			}
+			if sent >= streams.rate {
+				limited++
+				continue
+			}
+			if dropped := limited + sub.Dropped(); dropped > 0 {
+				limited = 0
+				if write(&logStreamEvent{Type: "dropped", Dropped: dropped}) != nil {
+					return
+				}
+			}
+			sent++
+			if write(&logStreamEvent{Type: "log", Entry: &entry}) != nil {
+				return
+			}
+		}
+	}
+}
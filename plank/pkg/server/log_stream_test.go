@@ -0,0 +1,162 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/clock"
+	"github.com/pb33f/ranch/plank/pkg/diagnostics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLogStreamServer(t *testing.T, cfg *DiagnosticsConfig) (*platformServer, *httptest.Server) {
+	cfg.LogStreamEndpoint = "/ranch/logs"
+	ps := &platformServer{
+		eventbus: bus.NewEventBusInstance(),
+		router:   mux.NewRouter(),
+		serverConfig: &PlatformServerConfig{
+			Logger:      slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelDebug})),
+			Diagnostics: cfg,
+		},
+	}
+	ps.initDiagnostics()
+	ps.setLogStreamRoute()
+	require.NotNil(t, ps.logStreams)
+	server := httptest.NewServer(ps.router)
+	t.Cleanup(func() {
+		ps.stopLogStreams()
+		server.Close()
+	})
+	return ps, server
+}
+
+// nextLogEvent reads the next server-sent event, skipping keep-alives.
+func nextLogEvent(t *testing.T, reader *bufio.Reader) (string, string) {
+	var event, data string
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		case line == "" && event != "":
+			return event, data
+		}
+	}
+}
+
+func TestPlatformServer_LogStream(t *testing.T) {
+	fake := clock.NewFakeClock(time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC))
+	clock.Set(fake)
+	defer clock.Reset()
+	ps, server := newTestLogStreamServer(t, &DiagnosticsConfig{LogStreamRate: 2, MaxLogStreams: 1})
+	logger := ps.serverConfig.Logger
+	logger.Warn("[bridge] broker unreachable")
+	logger.Warn("[ranch] not this subsystem")
+	logger.Info("[bridge] not this level")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/ranch/logs?level=warn&subsystem=bridge", nil)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	reader := bufio.NewReader(resp.Body)
+
+	// the recent records come first
+	event, data := nextLogEvent(t, reader)
+	assert.Equal(t, "log", event)
+	var entry diagnostics.LogEntry
+	require.NoError(t, json.Unmarshal([]byte(data), &entry))
+	assert.Equal(t, "[bridge] broker unreachable", entry.Message)
+	assert.Equal(t, "bridge", entry.Subsystem)
+
+	// only one stream may be open
+	busy, err := http.Get(server.URL + "/ranch/logs")
+	require.NoError(t, err)
+	busy.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, busy.StatusCode)
+
+	// records over the rate are dropped, and reported before the next one sent
+	for _, msg := range []string{"[bridge] retry 1", "[bridge] retry 2", "[bridge] retry 3"} {
+		logger.Warn(msg)
+	}
+	for _, msg := range []string{"[bridge] retry 1", "[bridge] retry 2"} {
+		_, data = nextLogEvent(t, reader)
+		require.NoError(t, json.Unmarshal([]byte(data), &entry))
+		assert.Equal(t, msg, entry.Message)
+	}
+	time.Sleep(100 * time.Millisecond) // the stream takes the third record off its queue
+	fake.Advance(time.Second)
+	logger.Error("[bridge] gave up")
+
+	event, data = nextLogEvent(t, reader)
+	assert.Equal(t, "dropped", event)
+	assert.JSONEq(t, `{"type":"dropped","dropped":1}`, data)
+	_, data = nextLogEvent(t, reader)
+	require.NoError(t, json.Unmarshal([]byte(data), &entry))
+	assert.Equal(t, "[bridge] gave up", entry.Message)
+	assert.Equal(t, "ERROR", entry.Level)
+
+	// the stream ends when the server stops
+	ps.stopLogStreams()
+	_, err = io.ReadAll(reader)
+	assert.NoError(t, err)
+}
+
+func TestPlatformServer_LogStreamWebSocket(t *testing.T) {
+	ps, server := newTestLogStreamServer(t, &DiagnosticsConfig{})
+	ps.serverConfig.Logger.Error("[store] snapshot failed")
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ranch/logs?subsystem=store&backlog=1"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	var event logStreamEvent
+	require.NoError(t, conn.ReadJSON(&event))
+	assert.Equal(t, "log", event.Type)
+	require.NotNil(t, event.Entry)
+	assert.Equal(t, "[store] snapshot failed", event.Entry.Message)
+
+	ps.serverConfig.Logger.Info("[store] snapshot written")
+	require.NoError(t, conn.ReadJSON(&event))
+	assert.Equal(t, "[store] snapshot written", event.Entry.Message)
+}
+
+func TestPlatformServer_LogStreamDenied(t *testing.T) {
+	ps, _ := newTestLogStreamServer(t, &DiagnosticsConfig{})
+
+	// the logs may carry sensitive details, so only local clients may stream them by default
+	r := httptest.NewRequest(http.MethodGet, "/ranch/logs", nil)
+	r.RemoteAddr = "127.0.0.1:50000"
+	r.Header.Set("X-Forwarded-For", "203.0.113.9")
+	w := httptest.NewRecorder()
+	ps.router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	r = httptest.NewRequest(http.MethodGet, "/ranch/logs?level=loud", nil)
+	r.RemoteAddr = "127.0.0.1:50000"
+	w = httptest.NewRecorder()
+	ps.router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
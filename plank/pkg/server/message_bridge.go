@@ -0,0 +1,386 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pb33f/ranch/clock"
+	"github.com/pb33f/ranch/model"
+)
+
+// Defaults of MessageBridgeConfig. Bridges start with defaultPayloadCapacity, within the bounds.
+const (
+	defaultPayloadCapacity       = 100
+	defaultMinPayloadCapacity    = 16
+	defaultMaxPayloadCapacity    = 4096
+	defaultPayloadResizeInterval = 10 * time.Second
+)
+
+// responseWaiterCapacity is how many responses a request may fall behind by, e.g. the chunks of a stream
+// written to a slow client, before the responses to other requests wait.
+const responseWaiterCapacity = 16
+
+// Reasons of a MessageBridgeResize.
+const (
+	PayloadResizeFull    = "full"    // a response arrived while the buffer was full
+	PayloadResizeBacklog = "backlog" // responses arrived faster than they were consumed, the buffer is filling up
+	PayloadResizeIdle    = "idle"    // the buffer was mostly empty, consumers keep up
+)
+
+// MessageBridgeResize reports the payload buffer of a message bridge changing capacity, published on
+// RANCH_MESSAGE_BRIDGE_RESIZE_CHANNEL. Rates are per second, over the window the resize was decided on.
+type MessageBridgeResize struct {
+	Channel         string  `json:"channel"`
+	From            int     `json:"from"`
+	To              int     `json:"to"`
+	Reason          string  `json:"reason"`
+	Queued          int     `json:"queued"`
+	ArrivalRate     float64 `json:"arrival_rate"`
+	ConsumptionRate float64 `json:"consumption_rate"`
+}
+
+// MessageBridgeMetrics is the state of the payload buffer of a message bridge.
+type MessageBridgeMetrics struct {
+	Capacity int    `json:"capacity"` // responses the buffer holds before stalling the bus
+	Queued   int    `json:"queued"`   // responses waiting for a request to pick them up
+	Grown    uint64 `json:"grown"`    // times the capacity grew
+	Shrunk   uint64 `json:"shrunk"`   // times the capacity shrank
+}
+
+// payloadBuffer holds the responses of a service channel until they are read from out, to be dispatched to
+// the REST bridge requests waiting for them. Its capacity is tuned within bounds: it grows when responses
+// arrive while it is full, or faster than they are consumed, so the bus does not stall, and shrinks once
+// consumers keep up, so idle bridges do not hold on to memory.
+type payloadBuffer struct {
+	channel  string
+	min, max int
+	interval time.Duration
+	onResize func(resize *MessageBridgeResize)
+	out      chan *model.Message // closed once the buffer is closed
+	done     chan struct{}       // closed by close
+
+	lock     sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	queue    []*model.Message
+	capacity int
+	grown    uint64
+	shrunk   uint64
+	resizes  []*MessageBridgeResize // resizes not reported yet
+	closed   bool
+
+	// the window arrival and consumption rates are measured over
+	windowStart time.Time
+	arrivals    int
+	consumed    int
+	peak        int // most responses queued at once
+}
+
+func newPayloadBuffer(channel string, cfg *MessageBridgeConfig, onResize func(resize *MessageBridgeResize)) *payloadBuffer {
+	b := &payloadBuffer{
+		channel:     channel,
+		min:         defaultMinPayloadCapacity,
+		max:         defaultMaxPayloadCapacity,
+		interval:    defaultPayloadResizeInterval,
+		onResize:    onResize,
+		out:         make(chan *model.Message),
+		done:        make(chan struct{}),
+		windowStart: clock.Now(),
+	}
+	if cfg != nil {
+		if cfg.MinPayloadCapacity > 0 {
+			b.min = cfg.MinPayloadCapacity
+		}
+		if cfg.MaxPayloadCapacity > 0 {
+			b.max = cfg.MaxPayloadCapacity
+		}
+		if cfg.ResizeIntervalSeconds > 0 {
+			b.interval = time.Duration(cfg.ResizeIntervalSeconds) * time.Second
+		}
+	}
+	b.max = max(b.max, b.min)
+	b.capacity = b.clamp(defaultPayloadCapacity)
+	b.notEmpty = sync.NewCond(&b.lock)
+	b.notFull = sync.NewCond(&b.lock)
+	go b.pump()
+	return b
+}
+
+// push queues a response, waiting for room while the buffer is full at its largest. Responses pushed once
+// the buffer is closed are dropped.
+func (b *payloadBuffer) push(message *model.Message) {
+	b.lock.Lock()
+	b.evaluate()
+	for len(b.queue) >= b.capacity && !b.closed {
+		if b.capacity < b.max {
+			b.resize(b.clamp(b.capacity*2), PayloadResizeFull)
+			break
+		}
+		b.notFull.Wait()
+	}
+	if b.closed {
+		b.lock.Unlock()
+		return
+	}
+	b.queue = append(b.queue, message)
+	b.arrivals++
+	b.peak = max(b.peak, len(b.queue))
+	b.notEmpty.Signal()
+	resizes := b.takeResizes()
+	b.lock.Unlock()
+	b.report(resizes)
+}
+
+// pump hands the queued responses to the requests reading out, in the order they arrived, until the buffer
+// is closed. It closes out when it returns.
+func (b *payloadBuffer) pump() {
+	defer close(b.out)
+	for {
+		b.lock.Lock()
+		for len(b.queue) == 0 && !b.closed {
+			b.notEmpty.Wait()
+		}
+		if b.closed {
+			b.lock.Unlock()
+			return
+		}
+		message := b.queue[0]
+		b.queue[0] = nil
+		b.queue = b.queue[1:]
+		b.notFull.Signal()
+		b.lock.Unlock()
+
+		select {
+		case b.out <- message:
+		case <-b.done:
+			return
+		}
+
+		b.lock.Lock()
+		b.consumed++
+		b.evaluate()
+		resizes := b.takeResizes()
+		b.lock.Unlock()
+		b.report(resizes)
+	}
+}
+
+// close stops the pump and drops the queued responses, pushes waiting for room return.
+func (b *payloadBuffer) close() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	b.queue = nil
+	close(b.done)
+	b.notEmpty.Broadcast()
+	b.notFull.Broadcast()
+}
+
+// evaluate resizes the buffer once a window is over, based on the rates and the peak measured over it.
+// Callers hold the lock.
+func (b *payloadBuffer) evaluate() {
+	elapsed := clock.Since(b.windowStart)
+	if elapsed < b.interval {
+		return
+	}
+	arrivals, consumed, peak := b.arrivals, b.consumed, b.peak
+	b.windowStart = clock.Now()
+	b.arrivals, b.consumed, b.peak = 0, 0, len(b.queue)
+
+	switch backlog := peak + arrivals - consumed; {
+	case arrivals > consumed && backlog*4 > b.capacity*3 && b.capacity < b.max:
+		b.resizeOver(b.clamp(max(b.capacity*2, backlog*2)), PayloadResizeBacklog, arrivals, consumed, elapsed)
+	case arrivals <= consumed && peak*4 <= b.capacity && b.capacity > b.min:
+		b.resizeOver(b.clamp(max(b.capacity/2, peak*2)), PayloadResizeIdle, arrivals, consumed, elapsed)
+	}
+}
+
+// resize changes the capacity within the current window. Callers hold the lock.
+func (b *payloadBuffer) resize(capacity int, reason string) {
+	b.resizeOver(capacity, reason, b.arrivals, b.consumed, clock.Since(b.windowStart))
+}
+
+// resizeOver changes the capacity, reporting the rates of a window. The queue is never shrunk below the
+// responses it holds. Callers hold the lock.
+func (b *payloadBuffer) resizeOver(capacity int, reason string, arrivals, consumed int, elapsed time.Duration) {
+	capacity = max(capacity, len(b.queue))
+	if capacity == b.capacity {
+		return
+	}
+	if capacity > b.capacity {
+		b.grown++
+	} else {
+		b.shrunk++
+		// let go of the memory held for the larger buffer
+		queue := make([]*model.Message, len(b.queue), capacity)
+		copy(queue, b.queue)
+		b.queue = queue
+	}
+	resize := &MessageBridgeResize{
+		Channel: b.channel,
+		From:    b.capacity,
+		To:      capacity,
+		Reason:  reason,
+		Queued:  len(b.queue),
+	}
+	if seconds := elapsed.Seconds(); seconds > 0 {
+		resize.ArrivalRate = float64(arrivals) / seconds
+		resize.ConsumptionRate = float64(consumed) / seconds
+	}
+	b.capacity = capacity
+	b.resizes = append(b.resizes, resize)
+	b.notFull.Broadcast()
+}
+
+func (b *payloadBuffer) clamp(capacity int) int {
+	return min(max(capacity, b.min), b.max)
+}
+
+// takeResizes returns the resizes to report once the lock is released. Callers hold the lock.
+func (b *payloadBuffer) takeResizes() []*MessageBridgeResize {
+	resizes := b.resizes
+	b.resizes = nil
+	return resizes
+}
+
+func (b *payloadBuffer) report(resizes []*MessageBridgeResize) {
+	if b.onResize == nil {
+		return
+	}
+	for _, resize := range resizes {
+		b.onResize(resize)
+	}
+}
+
+func (b *payloadBuffer) metrics() *MessageBridgeMetrics {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return &MessageBridgeMetrics{
+		Capacity: b.capacity,
+		Queued:   len(b.queue),
+		Grown:    b.grown,
+		Shrunk:   b.shrunk,
+	}
+}
+
+// newMessageBridge listens to the responses of a service channel, buffering them for its REST bridges.
+// Callers hold ps.lock.
+func (ps *platformServer) newMessageBridge(serviceChannel string) *MessageBridge {
+	buffer := newPayloadBuffer(serviceChannel, ps.serverConfig.MessageBridge, ps.reportPayloadResize)
+	mb := &MessageBridge{payloads: buffer, waiters: make(map[uuid.UUID]*responseWaiter)}
+	mb.ServiceListenStream, _ = ps.eventbus.ListenStream(serviceChannel)
+	mb.ServiceListenStream.Handle(buffer.push, func(err error) {})
+	go mb.dispatch()
+	ps.messageBridgeMap[serviceChannel] = mb
+	return mb
+}
+
+// responseWaiter receives the responses to a REST bridge request.
+type responseWaiter struct {
+	responses chan *model.Message
+	done      chan struct{} // closed once the request no longer waits
+}
+
+// await registers a request waiting for the responses addressed to its id, and returns the channel they are
+// delivered on. The request waits until release is called.
+func (mb *MessageBridge) await(id uuid.UUID) (responses chan *model.Message, release func()) {
+	waiter := &responseWaiter{
+		responses: make(chan *model.Message, responseWaiterCapacity),
+		done:      make(chan struct{}),
+	}
+	mb.lock.Lock()
+	mb.waiters[id] = waiter
+	mb.lock.Unlock()
+	var once sync.Once
+	return waiter.responses, func() {
+		once.Do(func() {
+			mb.lock.Lock()
+			if mb.waiters[id] == waiter {
+				delete(mb.waiters, id)
+			}
+			mb.lock.Unlock()
+			close(waiter.done)
+		})
+	}
+}
+
+// close stops listening to the service channel and dispatching responses. Requests still waiting get no
+// response and time out.
+func (mb *MessageBridge) close() {
+	if mb.ServiceListenStream != nil {
+		mb.ServiceListenStream.Close()
+	}
+	mb.payloads.close()
+}
+
+// dispatch hands the buffered responses to the requests they are addressed to, until the bridge is closed.
+// Responses no request waits for, e.g. those to fabric clients or to requests that timed out, are dropped.
+func (mb *MessageBridge) dispatch() {
+	for message := range mb.payloads.out {
+		id := message.DestinationId
+		if response, ok := message.Payload.(*model.Response); ok && id == nil {
+			id = response.Id
+		}
+		if id == nil {
+			continue
+		}
+		mb.lock.Lock()
+		waiter := mb.waiters[*id]
+		mb.lock.Unlock()
+		if waiter == nil {
+			continue
+		}
+		select {
+		case waiter.responses <- message:
+		case <-waiter.done:
+		}
+	}
+}
+
+// releaseMessageBridge closes and forgets the message bridge of a service channel once no REST bridge or
+// bridge route uses it. Callers hold ps.lock.
+func (ps *platformServer) releaseMessageBridge(serviceChannel string) {
+	mb, exists := ps.messageBridgeMap[serviceChannel]
+	if !exists || len(ps.serviceChanToBridgeEndpoints[serviceChannel]) > 0 || ps.routesTo(mb) {
+		return
+	}
+	delete(ps.messageBridgeMap, serviceChannel)
+	mb.close()
+}
+
+// reportPayloadResize logs the resize of the payload buffer of a message bridge, and publishes it on
+// RANCH_MESSAGE_BRIDGE_RESIZE_CHANNEL.
+func (ps *platformServer) reportPayloadResize(resize *MessageBridgeResize) {
+	ps.serverConfig.Logger.Debug("[ranch] message bridge payload buffer resized", "channel", resize.Channel,
+		"from", resize.From, "to", resize.To, "reason", resize.Reason)
+	cm := ps.eventbus.GetChannelManager()
+	if !cm.CheckChannelExists(RANCH_MESSAGE_BRIDGE_RESIZE_CHANNEL) {
+		cm.CreateChannel(RANCH_MESSAGE_BRIDGE_RESIZE_CHANNEL)
+	}
+	_ = ps.eventbus.SendResponseMessage(RANCH_MESSAGE_BRIDGE_RESIZE_CHANNEL, resize, nil)
+}
+
+// messageBridgeMetrics returns the state of the payload buffers of the message bridges, by service channel.
+func (ps *platformServer) messageBridgeMetrics() map[string]*MessageBridgeMetrics {
+	ps.lock.Lock()
+	bridges := make(map[string]*MessageBridge, len(ps.messageBridgeMap))
+	for channel, mb := range ps.messageBridgeMap {
+		bridges[channel] = mb
+	}
+	ps.lock.Unlock()
+	if len(bridges) == 0 {
+		return nil
+	}
+	metrics := make(map[string]*MessageBridgeMetrics, len(bridges))
+	for channel, mb := range bridges {
+		metrics[channel] = mb.payloads.metrics()
+	}
+	return metrics
+}
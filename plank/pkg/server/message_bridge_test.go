@@ -0,0 +1,244 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"net/http"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/clock"
+	"github.com/pb33f/ranch/model"
+	"github.com/pb33f/ranch/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testPayloadBuffer returns a payload buffer with a one second window, and the resizes it reported.
+func testPayloadBuffer(minCapacity, maxCapacity int) (*payloadBuffer, func() []*MessageBridgeResize) {
+	var lock sync.Mutex
+	var resizes []*MessageBridgeResize
+	b := newPayloadBuffer("cows", &MessageBridgeConfig{MinPayloadCapacity: minCapacity,
+		MaxPayloadCapacity: maxCapacity, ResizeIntervalSeconds: 1}, func(resize *MessageBridgeResize) {
+		lock.Lock()
+		defer lock.Unlock()
+		resizes = append(resizes, resize)
+	})
+	return b, func() []*MessageBridgeResize {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]*MessageBridgeResize(nil), resizes...)
+	}
+}
+
+// drain reads count responses from a payload buffer, checking they arrive in order, and waits for the
+// buffer to count them as consumed.
+func drain(t *testing.T, b *payloadBuffer, first, count int) {
+	for i := first; i < first+count; i++ {
+		select {
+		case msg := <-b.out:
+			require.Equal(t, i, msg.Payload)
+		case <-time.After(time.Second):
+			t.Fatal("timed out")
+		}
+	}
+	assert.Eventually(t, func() bool {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		return len(b.queue) == 0 && b.consumed >= count
+	}, time.Second, time.Millisecond)
+}
+
+func TestPayloadBuffer_Full(t *testing.T) {
+	fake := clock.NewFakeClock(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	clock.Set(fake)
+	defer clock.Reset()
+
+	b, resizes := testPayloadBuffer(10, 400)
+	assert.Equal(t, 100, b.metrics().Capacity)
+
+	// responses arriving while the buffer is full grow it, rather than stalling the bus
+	for i := 0; i < 102; i++ {
+		b.push(&model.Message{Payload: i})
+	}
+	assert.Equal(t, 200, b.metrics().Capacity)
+	require.Len(t, resizes(), 1)
+	assert.Equal(t, &MessageBridgeResize{Channel: "cows", From: 100, To: 200, Reason: PayloadResizeFull,
+		Queued: resizes()[0].Queued}, resizes()[0])
+	drain(t, b, 0, 102)
+
+	// buffers shrink once a window passes with consumers keeping up, down to the minimum
+	fake.Advance(time.Second)
+	b.push(&model.Message{Payload: 102})
+	drain(t, b, 102, 1)
+	for i := 103; i < 108; i++ {
+		fake.Advance(time.Second)
+		b.push(&model.Message{Payload: i})
+		drain(t, b, i, 1)
+	}
+	var sizes []int
+	for _, resize := range resizes()[1:] {
+		assert.Equal(t, PayloadResizeIdle, resize.Reason)
+		sizes = append(sizes, resize.To)
+	}
+	assert.Equal(t, []int{100, 50, 25, 12, 10}, sizes)
+	assert.Equal(t, &MessageBridgeMetrics{Capacity: 10, Grown: 1, Shrunk: 5}, b.metrics())
+}
+
+func TestPayloadBuffer_Backlog(t *testing.T) {
+	fake := clock.NewFakeClock(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	clock.Set(fake)
+	defer clock.Reset()
+
+	// responses arriving faster than they are consumed grow the buffer before it fills up
+	b, resizes := testPayloadBuffer(10, 1000)
+	for i := 0; i < 80; i++ {
+		b.push(&model.Message{Payload: i})
+	}
+	fake.Advance(2 * time.Second)
+	b.push(&model.Message{Payload: 80})
+	require.Len(t, resizes(), 1)
+	resize := resizes()[0]
+	assert.Equal(t, PayloadResizeBacklog, resize.Reason)
+	assert.Equal(t, 100, resize.From)
+	assert.GreaterOrEqual(t, resize.To, 300)
+	assert.Equal(t, 40.0, resize.ArrivalRate)
+	assert.Equal(t, 0.0, resize.ConsumptionRate)
+
+	// never beyond the maximum
+	b, resizes = testPayloadBuffer(10, 120)
+	for i := 0; i < 110; i++ {
+		b.push(&model.Message{Payload: i})
+	}
+	assert.Equal(t, 120, b.metrics().Capacity)
+	fake.Advance(time.Second)
+	blocked := make(chan struct{})
+	go func() {
+		for i := 110; i < 125; i++ {
+			b.push(&model.Message{Payload: i})
+		}
+		close(blocked)
+	}()
+	select {
+	case <-blocked:
+		t.Fatal("pushed beyond the maximum capacity")
+	case <-time.After(20 * time.Millisecond):
+	}
+	drain(t, b, 0, 125)
+	<-blocked
+	assert.Len(t, resizes(), 1)
+}
+
+func TestPayloadBuffer_Close(t *testing.T) {
+	b, _ := testPayloadBuffer(1, 1)
+	b.push(&model.Message{Payload: 0})
+	b.push(&model.Message{Payload: 1})
+
+	// a push waiting for room returns once the buffer is closed
+	pushed := make(chan struct{})
+	go func() {
+		b.push(&model.Message{Payload: 2})
+		close(pushed)
+	}()
+	b.close()
+	select {
+	case <-pushed:
+	case <-time.After(time.Second):
+		t.Fatal("push still waiting for room")
+	}
+
+	// the pump stops and closes out, queued responses are dropped
+	assert.Eventually(t, func() bool {
+		select {
+		case _, ok := <-b.out:
+			return !ok
+		default:
+			return false
+		}
+	}, time.Second, time.Millisecond)
+	b.push(&model.Message{Payload: 3})
+	assert.Zero(t, b.metrics().Queued)
+	b.close()
+}
+
+func TestPlatformServer_MessageBridgeReleased(t *testing.T) {
+	ps := newTestBridgeRouting()
+	ps.eventbus.GetChannelManager().CreateChannel("cows")
+	requestBuilder := func(w http.ResponseWriter, r *http.Request) model.Request {
+		return model.Request{RequestCommand: "moo"}
+	}
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		ps.SetHttpChannelBridge(&service.RESTBridgeConfig{ServiceChannel: "cows", Uri: "/cows", Method: method,
+			FabricRequestBuilder: requestBuilder})
+	}
+	mb := ps.messageBridgeMap["cows"]
+	require.NotNil(t, mb)
+
+	// the bridge stays while a REST bridge of the channel is left
+	require.NoError(t, ps.UnsetHttpChannelBridge("/cows", http.MethodGet))
+	assert.Same(t, mb, ps.messageBridgeMap["cows"])
+	require.NoError(t, ps.UnsetHttpChannelBridge("/cows", http.MethodPost))
+	assert.NotContains(t, ps.messageBridgeMap, "cows")
+	_, open := <-mb.payloads.out
+	assert.False(t, open)
+
+	// the bridge of an alternate channel stays while a route sends requests to it
+	require.NoError(t, ps.SetBridgeRoute(&BridgeRoute{Channel: "cows", Alternate: "cows-canary", Percent: 10}))
+	canary := ps.messageBridgeMap["cows-canary"]
+	require.NotNil(t, canary)
+	ps.SetHttpChannelBridge(&service.RESTBridgeConfig{ServiceChannel: "cows-canary", Uri: "/canary",
+		Method: http.MethodGet, FabricRequestBuilder: requestBuilder})
+	require.NoError(t, ps.UnsetHttpChannelBridge("/canary", http.MethodGet))
+	assert.Same(t, canary, ps.messageBridgeMap["cows-canary"])
+	require.NoError(t, ps.SetBridgeRoute(&BridgeRoute{Channel: "cows", Remove: true}))
+	assert.NotContains(t, ps.messageBridgeMap, "cows-canary")
+}
+
+func TestPlatformServer_MessageBridgeResizes(t *testing.T) {
+	b := bus.ResetBus()
+	service.ResetServiceRegistry()
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", GetTestPort(), true)
+	config.MessageBridge = &MessageBridgeConfig{MinPayloadCapacity: 1, MaxPayloadCapacity: 2}
+	ps := NewPlatformServer(config).(*platformServer)
+	ps.eventbus = b
+	assert.Nil(t, ps.messageBridgeMetrics())
+
+	b.GetChannelManager().CreateChannel(RANCH_MESSAGE_BRIDGE_RESIZE_CHANNEL)
+	handler, err := b.ListenStream(RANCH_MESSAGE_BRIDGE_RESIZE_CHANNEL)
+	require.NoError(t, err)
+	resized := make(chan *MessageBridgeResize, 1)
+	handler.Handle(func(message *model.Message) {
+		resized <- message.Payload.(*MessageBridgeResize)
+	}, func(err error) {})
+
+	bridge := ps.bridgeFor("cows")
+	assert.Equal(t, map[string]*MessageBridgeMetrics{"cows": {Capacity: 2}}, ps.messageBridgeMetrics())
+	id := uuid.New()
+	responses, release := bridge.await(id)
+	defer release()
+	for i := 0; i < 4; i++ {
+		require.NoError(t, b.SendResponseMessage("cows", i, &id))
+	}
+	select {
+	case resize := <-resized:
+		t.Fatalf("resized beyond the maximum: %v", resize)
+	case <-time.After(50 * time.Millisecond):
+	}
+	for i := 0; i < 4; i++ {
+		<-responses
+	}
+
+	// resizes are published
+	ps.reportPayloadResize(&MessageBridgeResize{Channel: "cows", From: 2, To: 1, Reason: PayloadResizeIdle})
+	select {
+	case resize := <-resized:
+		assert.Equal(t, PayloadResizeIdle, resize.Reason)
+	case <-time.After(time.Second):
+		t.Fatal("timed out")
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/gorilla/mux"
+	"github.com/pb33f/ranch/clock"
+	"github.com/pb33f/ranch/plank/pkg/metrics"
+	"github.com/pb33f/ranch/service"
+)
+
+const (
+	defaultMetricsEndpoint = "/metrics"
+
+	// metricsUnmatchedRoute labels requests no route matched, so unknown paths do not each get a series.
+	metricsUnmatchedRoute = "unmatched"
+)
+
+// metricsState is the registry the metrics endpoint serves, and the HTTP metrics updated as requests are served.
+type metricsState struct {
+	registry *metrics.Registry
+	requests *metrics.CounterVec
+	latency  *metrics.HistogramVec
+	inFlight atomic.Int64
+}
+
+// endpoint returns the URI of the metrics endpoint.
+func (cfg *MetricsConfig) endpoint() string {
+	if cfg.Endpoint == "" {
+		return defaultMetricsEndpoint
+	}
+	return cfg.Endpoint
+}
+
+// initMetrics registers the metrics of the server and the metrics endpoint, if configured.
+func (ps *platformServer) initMetrics() {
+	cfg := ps.serverConfig.Metrics
+	if cfg == nil {
+		return
+	}
+	registry := metrics.NewRegistry()
+	state := &metricsState{
+		registry: registry,
+		requests: registry.NewCounterVec("ranch_http_requests_total",
+			"HTTP requests served, by route, method and status code.", "route", "method", "code"),
+		latency: registry.NewHistogramVec("ranch_http_request_duration_seconds",
+			"Time taken to serve HTTP requests, by route and method.", cfg.Buckets, "route", "method"),
+	}
+	registry.NewGaugeFunc("ranch_http_requests_in_flight", "HTTP requests being served.", func() float64 {
+		return float64(state.inFlight.Load())
+	})
+	registry.NewCollector("ranch_bus_channel_messages_total", "Messages sent on each bus channel.",
+		metrics.TypeCounter, []string{"channel"}, func(observe func(float64, ...string)) {
+			for name, channel := range ps.eventbus.GetChannelManager().GetAllChannels() {
+				observe(float64(channel.MessagesSent()), name)
+			}
+		})
+	registry.NewGaugeFunc("ranch_bus_channels", "Open bus channels.", func() float64 {
+		return float64(len(ps.eventbus.GetChannelManager().GetAllChannels()))
+	})
+	registry.NewCollector("ranch_service_requests_in_flight", "Requests each service is handling.",
+		metrics.TypeGauge, []string{"channel"}, func(observe func(float64, ...string)) {
+			for channel, depth := range service.GetServiceRegistry().GetInFlightRequests() {
+				observe(float64(depth), channel)
+			}
+		})
+	registry.NewCollector("ranch_store_items", "Items in each bus store.", metrics.TypeGauge,
+		[]string{"store"}, func(observe func(float64, ...string)) {
+			for name, size := range ps.eventbus.GetStoreManager().GetStoreSizes() {
+				observe(float64(size), name)
+			}
+		})
+	registry.NewGaugeFunc("ranch_fabric_connections", "Open STOMP connections of the fabric broker.",
+		func() float64 {
+			return float64(len(ps.eventbus.GetFabricConnections()))
+		})
+	registry.NewGaugeFunc("ranch_fabric_subscriptions",
+		"Subscriptions of the STOMP connections of the fabric broker.", func() float64 {
+			subscriptions := 0
+			for _, conn := range ps.eventbus.GetFabricConnections() {
+				subscriptions += len(conn.Subscriptions)
+			}
+			return float64(subscriptions)
+		})
+	registry.NewCollector("ranch_broker_bridge_connected", "Whether each external broker bridge is connected.",
+		metrics.TypeGauge, []string{"bridge"}, func(observe func(float64, ...string)) {
+			ps.lock.Lock()
+			bridges := ps.brokerBridges
+			ps.lock.Unlock()
+			for _, bb := range bridges {
+				connected := 0.0
+				if bb.isConnected() {
+					connected = 1
+				}
+				observe(connected, bb.config.Name)
+			}
+		})
+	ps.metrics = state
+
+	endpoint := cfg.endpoint()
+	ps.router.Path(endpoint).Name(endpoint).Methods(http.MethodGet).HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if cfg.Authorize != nil && !cfg.Authorize(r) {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Content-Type", metrics.ContentType)
+			w.Header().Set("Cache-Control", "no-store")
+			_, _ = registry.WriteTo(w)
+		})
+	ps.serverConfig.Logger.Info("[ranch] metrics endpoint enabled", "endpoint", endpoint)
+}
+
+// GetMetricsRegistry returns the registry served by the metrics endpoint, for services to add their own
+// metrics to, nil if metrics are not configured.
+func (ps *platformServer) GetMetricsRegistry() *metrics.Registry {
+	if ps.metrics == nil {
+		return nil
+	}
+	return ps.metrics.registry
+}
+
+// metricsMiddleware counts the HTTP requests served and times them, by the path template of their route.
+func (ps *platformServer) metricsMiddleware(next http.Handler) http.Handler {
+	state := ps.metrics
+	router := ps.router
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := metricsUnmatchedRoute
+		var match mux.RouteMatch
+		if router.Match(r, &match) && match.Route != nil {
+			if template, err := match.Route.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		state.inFlight.Add(1)
+		started := clock.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			state.inFlight.Add(-1)
+			state.latency.Observe(clock.Since(started).Seconds(), route, r.Method)
+			state.requests.Inc(route, r.Method, strconv.Itoa(recorder.status))
+		}()
+		next.ServeHTTP(recorder, r)
+	})
+}
+
+// statusRecorder captures the status code written by a handler, and the bytes of the body.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(data []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(data)
+	r.bytes += int64(n)
+	return n, err
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack is passed through so WebSocket upgrades keep working.
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := r.ResponseWriter.(http.Hijacker); ok {
+		r.status = http.StatusSwitchingProtocols
+		return h.Hijack()
+	}
+	return nil, nil, fmt.Errorf("response writer does not support hijacking")
+}
+
+// Unwrap lets http.ResponseController reach the underlying response writer.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
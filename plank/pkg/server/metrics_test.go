@@ -0,0 +1,71 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/plank/pkg/metrics"
+	"github.com/pb33f/ranch/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics_Endpoint(t *testing.T) {
+	bus.ResetBus()
+	service.ResetServiceRegistry()
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", GetTestPort(), true)
+	config.Metrics = &MetricsConfig{Buckets: []float64{0.1, 1}}
+	ps := NewPlatformServer(config).(*platformServer)
+	require.NotNil(t, ps.GetMetricsRegistry())
+	ps.router.HandleFunc("/cows/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/cows/lost" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}).Methods(http.MethodGet)
+	ps.loadGlobalHttpHandler(ps.router)
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		ps.HttpServer.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+	serve("/cows/1")
+	serve("/cows/2")
+	serve("/cows/lost")
+	serve("/pigs")
+
+	ps.eventbus.GetChannelManager().CreateChannel("herd")
+	_ = ps.eventbus.SendResponseMessage("herd", "moo", nil)
+	_ = ps.eventbus.SendResponseMessage("herd", "moo", nil)
+	ps.eventbus.GetStoreManager().CreateStore("barn").Put("hay", 12, nil)
+
+	// services add their own metrics
+	ps.GetMetricsRegistry().NewCounterVec("ranch_cows_milked_total", "Cows milked.").Inc()
+
+	w := serve("/metrics")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, metrics.ContentType, w.Header().Get("Content-Type"))
+	body, _ := io.ReadAll(w.Body)
+	out := string(body)
+	assert.Contains(t, out, `ranch_http_requests_total{route="/cows/{id}",method="GET",code="200"} 2`)
+	assert.Contains(t, out, `ranch_http_requests_total{route="/cows/{id}",method="GET",code="404"} 1`)
+	assert.Contains(t, out, `ranch_http_requests_total{route="unmatched",method="GET",code="404"} 1`)
+	assert.Contains(t, out, `ranch_http_request_duration_seconds_bucket{route="/cows/{id}",method="GET",le="1"} 3`)
+	assert.Contains(t, out, `ranch_http_request_duration_seconds_count{route="/cows/{id}",method="GET"} 3`)
+	assert.Contains(t, out, "ranch_http_requests_in_flight 1")
+	assert.Contains(t, out, `ranch_bus_channel_messages_total{channel="herd"} 2`)
+	assert.Contains(t, out, `ranch_store_items{store="barn"} 1`)
+	assert.Contains(t, out, "ranch_fabric_connections 0")
+	assert.Contains(t, out, "ranch_cows_milked_total 1")
+
+	// scrapes may be restricted
+	ps.serverConfig.Metrics.Authorize = func(r *http.Request) bool { return false }
+	assert.Equal(t, http.StatusForbidden, serve("/metrics").Code)
+}
@@ -0,0 +1,81 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/pb33f/ranch/model"
+	"github.com/pb33f/ranch/plank/pkg/serializer"
+)
+
+// initNegotiation creates the serializers of the representations REST bridges negotiate, if configured.
+func (ps *platformServer) initNegotiation() {
+	cfg := ps.serverConfig.Negotiation
+	if cfg == nil {
+		return
+	}
+	serializers := serializer.NewRegistry(cfg.MediaTypes...)
+	for mediaType, s := range cfg.Serializers {
+		serializers.Register(mediaType, s)
+	}
+	ps.serializers = serializers
+	if cfg.Default != "" && !slices.Contains(serializers.MediaTypes(), strings.ToLower(cfg.Default)) {
+		ps.serverConfig.Logger.Warn("[ranch] default media type is not offered, JSON is the default",
+			"default", cfg.Default)
+	}
+	ps.serverConfig.Logger.Info("[ranch] content negotiation enabled", "media_types", serializers.MediaTypes())
+}
+
+// negotiateResponse renders the payload of a marshalled response in the representation the request accepts
+// and sets its Content-Type, if content negotiation is configured. Responses whose service set their content
+// type and payloads already in bytes get JSON, as they do without negotiation. Requests without an Accept
+// header or accepting anything, like browsers do, get the default representation. Requests accepting none of
+// the representations get JSON too, unless negotiation is strict, in which case serializer.ErrNotAcceptable
+// is returned.
+func (ps *platformServer) negotiateResponse(w http.ResponseWriter, r *http.Request, response *model.Response,
+	payload interface{}) ([]byte, error) {
+
+	if ps.serializers == nil || response.ContentType != "" {
+		return ensureResponseInByteSlice(payload)
+	}
+	if _, ok := payload.([]byte); ok {
+		return ensureResponseInByteSlice(payload)
+	}
+	w.Header().Add("Vary", "Accept")
+	accept := r.Header.Get("Accept")
+	if accept == "" || serializer.AcceptsAnything(accept) {
+		return ps.defaultRepresentation(w, payload)
+	}
+	mediaType, data, err := ps.serializers.Serialize(accept, payload)
+	switch {
+	case errors.Is(err, serializer.ErrNotAcceptable) && !ps.serverConfig.Negotiation.Strict:
+		return ensureResponseInByteSlice(payload)
+	case err != nil:
+		return nil, err
+	}
+	w.Header().Set("Content-Type", serializer.ContentType(mediaType))
+	return data, nil
+}
+
+// defaultRepresentation renders a payload in the default representation of negotiation, falling back to JSON
+// if the default cannot render it.
+func (ps *platformServer) defaultRepresentation(w http.ResponseWriter, payload interface{}) ([]byte, error) {
+	defaultType := ps.serverConfig.Negotiation.Default
+	if defaultType == "" || strings.EqualFold(defaultType, serializer.MediaTypeJSON) {
+		return ensureResponseInByteSlice(payload)
+	}
+	mediaType, data, err := ps.serializers.Serialize(defaultType, payload)
+	switch {
+	case errors.Is(err, serializer.ErrNotAcceptable):
+		return ensureResponseInByteSlice(payload)
+	case err != nil:
+		return nil, err
+	}
+	w.Header().Set("Content-Type", serializer.ContentType(mediaType))
+	return data, nil
+}
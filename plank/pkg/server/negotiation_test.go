@@ -0,0 +1,108 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/model"
+	"github.com/pb33f/ranch/plank/pkg/serializer"
+	"github.com/pb33f/ranch/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildEndpointHandler_Negotiation(t *testing.T) {
+	b := bus.ResetBus()
+	service.ResetServiceRegistry()
+	_ = b.GetChannelManager().CreateChannel("test-chan")
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", GetTestPort(), true)
+	config.Negotiation = &NegotiationConfig{
+		MediaTypes: []string{serializer.MediaTypeJSON, serializer.MediaTypeYAML, serializer.MediaTypeText},
+		Serializers: map[string]serializer.Serializer{"text/csv": serializer.SerializerFunc(
+			func(payload interface{}) ([]byte, error) {
+				return []byte("name,moos\ndaisy,3\n"), nil
+			})},
+	}
+	ps := NewPlatformServer(config).(*platformServer)
+	ps.eventbus = b
+	bridge := ps.bridgeFor("test-chan")
+	replies, _ := answerRequests(t, b, bridge, "test-chan")
+	handler := ps.buildEndpointHandler("test-chan", func(w http.ResponseWriter, r *http.Request) model.Request {
+		return model.Request{RequestCommand: "test-request"}
+	}, time.Second, bridge, requestLimits{})
+	get := func(accept string, rsp *model.Response) *httptest.ResponseRecorder {
+		replies <- []*model.Message{{Payload: rsp}}
+		req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+	daisy := func() *model.Response {
+		return &model.Response{Payload: map[string]int{"moos": 3}, Marshal: true,
+			Headers: map[string]interface{}{"Content-Type": "application/json"}}
+	}
+
+	rec := get("application/yaml", daisy())
+	assert.Equal(t, "application/yaml", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "Accept", rec.Header().Get("Vary"))
+	assert.Equal(t, "moos: 3\n", rec.Body.String())
+
+	rec = get("text/csv", daisy())
+	assert.Equal(t, "text/csv; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "name,moos\ndaisy,3\n", rec.Body.String())
+
+	rec = get("text/plain, application/json;q=0.5", &model.Response{Payload: 42, Marshal: true})
+	assert.Equal(t, "text/plain; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "42", rec.Body.String())
+
+	// JSON without an Accept header, or one accepting nothing offered, unless negotiation is strict
+	for _, accept := range []string{"", "application/xml"} {
+		rec = get(accept, daisy())
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+		assert.Equal(t, `{"moos":3}`, rec.Body.String())
+	}
+	config.Negotiation.Strict = true
+	assert.Equal(t, http.StatusNotAcceptable, get("application/xml", daisy()).Code)
+
+	// content types set by services are kept
+	rsp := daisy()
+	rsp.ContentType = "application/vnd.cow+json"
+	rec = get("application/yaml", rsp)
+	assert.Equal(t, "application/vnd.cow+json", rec.Header().Get("Content-Type"))
+	assert.Equal(t, `{"moos":3}`, rec.Body.String())
+
+	// browsers accept anything, they get the default representation rather than the XML they list
+	browser := "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"
+	config.Negotiation.MediaTypes = nil
+	ps.initNegotiation()
+	rec = get(browser, daisy())
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Equal(t, `{"moos":3}`, rec.Body.String())
+	config.Negotiation.Default = serializer.MediaTypeYAML
+	for _, accept := range []string{"", browser} {
+		rec = get(accept, daisy())
+		assert.Equal(t, "application/yaml", rec.Header().Get("Content-Type"))
+		assert.Equal(t, "moos: 3\n", rec.Body.String())
+	}
+
+	// payloads a representation fails to render are a server error, not an empty response
+	config.Negotiation.Serializers = map[string]serializer.Serializer{"text/csv": serializer.SerializerFunc(
+		func(payload interface{}) ([]byte, error) {
+			return nil, errors.New("the herd has no columns")
+		})}
+	ps.initNegotiation()
+	rec = get("text/csv", daisy())
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Contains(t, rec.Body.String(), "the herd has no columns")
+}
@@ -10,7 +10,7 @@ import (
 )
 
 type NoDirFileSystem struct {
-	fs http.Dir
+	fs http.FileSystem
 }
 
 type neuteredStatFile struct {
@@ -26,16 +26,17 @@ func (e neuteredStatFile) Stat() (os.FileInfo, error) {
 	if s.IsDir() {
 	LOOP:
 		for {
+			// files of an fs.FS, such as an embed.FS, return their last entries along with io.EOF
 			fl, err := e.File.Readdir(e.readDirCount)
+			for _, f := range fl {
+				if f.Name() == "index.html" {
+					return s, nil
+				}
+			}
 			switch err {
 			case io.EOF:
 				break LOOP
 			case nil:
-				for _, f := range fl {
-					if f.Name() == "index.html" {
-						return s, err
-					}
-				}
 			default:
 				return nil, err
 			}
@@ -0,0 +1,156 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// initHttp2 applies the HTTP/2 settings to the HTTP server. HTTPS clients negotiate HTTP/2 with ALPN unless
+// it is disabled, cleartext clients only speak it if h2c is enabled (see withProtocols).
+func (ps *platformServer) initHttp2() {
+	cfg := ps.serverConfig.Http2
+	if cfg == nil {
+		return
+	}
+	if cfg.Disabled {
+		// an empty, rather than nil, map of protocols keeps net/http from configuring HTTP/2 by itself
+		ps.HttpServer.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+		if ps.serverConfig.TLSCertConfig != nil {
+			if ps.HttpServer.TLSConfig == nil {
+				ps.HttpServer.TLSConfig = &tls.Config{}
+			}
+			ps.HttpServer.TLSConfig.NextProtos = []string{"http/1.1"}
+		}
+		return
+	}
+	ps.Http2Server = &http2.Server{
+		MaxConcurrentStreams:         cfg.MaxConcurrentStreams,
+		MaxReadFrameSize:             cfg.MaxReadFrameSize,
+		MaxUploadBufferPerConnection: cfg.MaxUploadBufferPerConnection,
+		MaxUploadBufferPerStream:     cfg.MaxUploadBufferPerStream,
+		IdleTimeout:                  time.Duration(cfg.IdleTimeoutSeconds) * time.Second,
+	}
+	if err := http2.ConfigureServer(ps.HttpServer, ps.Http2Server); err != nil {
+		ps.serverConfig.Logger.Error(wrapError(errServerInit, err).Error())
+	}
+}
+
+// initHttp3 creates the HTTP/3 server, if configured. It serves the same handler as the HTTP server, on a
+// UDP port, and needs the certificate of the HTTPS server.
+func (ps *platformServer) initHttp3() {
+	cfg := ps.serverConfig.Http3
+	if cfg == nil {
+		return
+	}
+	if ps.serverConfig.TLSCertConfig == nil {
+		ps.serverConfig.Logger.Error(wrapError(errServerInit,
+			errors.New("HTTP/3 requires TLS, configure a TLS certificate to serve it")).Error())
+		return
+	}
+	port := cfg.Port
+	if port == 0 {
+		port = ps.serverConfig.Port
+	}
+	ps.http3Server = &http3.Server{
+		Addr: fmt.Sprintf(":%d", port),
+		Port: port,
+		QUICConfig: &quic.Config{
+			MaxIdleTimeout:     time.Duration(cfg.MaxIdleTimeoutSeconds) * time.Second,
+			MaxIncomingStreams: cfg.MaxIncomingStreams,
+			Allow0RTT:          cfg.Allow0RTT,
+		},
+		Logger: ps.serverConfig.Logger,
+	}
+}
+
+// withProtocols wraps the handler of the HTTP server: cleartext HTTP/2 is accepted if h2c is enabled, and
+// HTTPS responses advertise the HTTP/3 server with an Alt-Svc header, for clients to switch to it.
+func (ps *platformServer) withProtocols(handler http.Handler) http.Handler {
+	if h3 := ps.http3Server; h3 != nil && !ps.serverConfig.Http3.DisableAltSvc {
+		next := handler
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS != nil && r.ProtoMajor < 3 {
+				// fails until the HTTP/3 server listens, nothing is advertised then
+				_ = h3.SetQUICHeaders(w.Header())
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	if cfg := ps.serverConfig.Http2; cfg != nil && cfg.H2C && !cfg.Disabled && ps.serverConfig.TLSCertConfig == nil {
+		h2s := ps.Http2Server
+		if h2s == nil {
+			h2s = &http2.Server{}
+		}
+		handler = h2c.NewHandler(handler, h2s)
+	}
+	return handler
+}
+
+// startHttp3 starts serving HTTP/3, if configured, with the certificate and TLS settings of the HTTPS server.
+func (ps *platformServer) startHttp3() {
+	ps.lock.Lock()
+	h3 := ps.http3Server
+	if h3 != nil {
+		h3.Handler = ps.HttpServer.Handler
+	}
+	ps.lock.Unlock()
+	if h3 == nil {
+		return
+	}
+
+	tlsConfig := &tls.Config{}
+	if ps.HttpServer.TLSConfig != nil {
+		tlsConfig = ps.HttpServer.TLSConfig.Clone()
+	}
+	if len(tlsConfig.Certificates) == 0 && tlsConfig.GetCertificate == nil {
+		certFile, keyFile := ps.serverConfig.TLSCertConfig.CertFile, ps.serverConfig.TLSCertConfig.KeyFile
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			ps.serverConfig.Logger.Error(wrapError(errServerInit, err).Error())
+			return
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	h3.TLSConfig = http3.ConfigureTLSConfig(tlsConfig)
+
+	conn, err := ps.listenPacket("http3", h3.Addr)
+	if err != nil {
+		ps.serverConfig.Logger.Error(wrapError(errServerInit, err).Error())
+		return
+	}
+	go func() {
+		ps.serverConfig.Logger.Info("[ranch] giddy-up! starting up the ranch's HTTP/3 server (experimental)",
+			"host", ps.serverConfig.Host, "port", h3.Port)
+		if err := h3.Serve(conn); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			ps.serverConfig.Logger.Error(wrapError(errServerInit, err).Error())
+		}
+		// the server leaves closing connections it did not open to their owner
+		_ = conn.Close()
+	}()
+}
+
+// stopHttp3 stops the HTTP/3 server, letting the requests in progress finish until ctx is done.
+func (ps *platformServer) stopHttp3(ctx context.Context) {
+	ps.lock.Lock()
+	h3 := ps.http3Server
+	ps.lock.Unlock()
+	if h3 == nil {
+		return
+	}
+	if err := h3.Shutdown(ctx); err != nil {
+		ps.serverConfig.Logger.Error(err.Error())
+		_ = h3.Close()
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/service"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+)
+
+// protocolServer returns a server whose router answers /proto with the protocol of the request.
+func protocolServer(config *PlatformServerConfig) *platformServer {
+	bus.ResetBus()
+	service.ResetServiceRegistry()
+	ps := NewPlatformServer(config).(*platformServer)
+	router := mux.NewRouter()
+	router.HandleFunc("/proto", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Proto))
+	})
+	ps.loadGlobalHttpHandler(router)
+	return ps
+}
+
+func TestHttp2_H2C(t *testing.T) {
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", GetTestPort(), true)
+	config.Http2 = &Http2Config{H2C: true, MaxConcurrentStreams: 10}
+	ps := protocolServer(config)
+	assert.Equal(t, uint32(10), ps.Http2Server.MaxConcurrentStreams)
+	srv := httptest.NewServer(ps.HttpServer.Handler)
+	defer srv.Close()
+
+	// HTTP/2 with prior knowledge, over cleartext
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+	rsp, err := client.Get(srv.URL + "/proto")
+	require.NoError(t, err)
+	body, _ := io.ReadAll(rsp.Body)
+	_ = rsp.Body.Close()
+	assert.Equal(t, "HTTP/2.0", string(body))
+
+	// HTTP/1.1 clients are still served
+	rsp, err = http.Get(srv.URL + "/proto")
+	require.NoError(t, err)
+	body, _ = io.ReadAll(rsp.Body)
+	_ = rsp.Body.Close()
+	assert.Equal(t, "HTTP/1.1", string(body))
+
+	// h2c is left out when the server serves TLS
+	config.TLSCertConfig = GetTestTLSCertConfig(t.TempDir())
+	tlsSrv := httptest.NewServer(protocolServer(config).HttpServer.Handler)
+	defer tlsSrv.Close()
+	_, err = client.Get(tlsSrv.URL + "/proto")
+	assert.Error(t, err)
+}
+
+func TestHttp2_Disabled(t *testing.T) {
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", GetTestPort(), true)
+	config.TLSCertConfig = GetTestTLSCertConfig(t.TempDir())
+	config.Http2 = &Http2Config{Disabled: true, H2C: true}
+	ps := protocolServer(config)
+	assert.Nil(t, ps.Http2Server)
+	assert.NotNil(t, ps.HttpServer.TLSNextProto)
+	assert.Empty(t, ps.HttpServer.TLSNextProto)
+	assert.Equal(t, []string{"http/1.1"}, ps.HttpServer.TLSConfig.NextProtos)
+
+	srv := httptest.NewUnstartedServer(ps.HttpServer.Handler)
+	srv.EnableHTTP2 = false
+	srv.Config.TLSNextProto = ps.HttpServer.TLSNextProto
+	srv.TLS = ps.HttpServer.TLSConfig.Clone()
+	srv.StartTLS()
+	defer srv.Close()
+	client := srv.Client()
+	client.Transport.(*http.Transport).ForceAttemptHTTP2 = true
+	rsp, err := client.Get(srv.URL + "/proto")
+	require.NoError(t, err)
+	body, _ := io.ReadAll(rsp.Body)
+	_ = rsp.Body.Close()
+	assert.Equal(t, "HTTP/1.1", string(body))
+}
+
+func TestHttp3(t *testing.T) {
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", GetTestPort(), true)
+	config.Http3 = &Http3Config{}
+	assert.Nil(t, protocolServer(config).http3Server, "HTTP/3 requires TLS")
+
+	config.TLSCertConfig = GetTestTLSCertConfig(t.TempDir())
+	config.Http3 = &Http3Config{Port: GetTestPort(), MaxIdleTimeoutSeconds: 5}
+	ps := protocolServer(config)
+	require.NotNil(t, ps.http3Server)
+	ps.startHttp3()
+	defer ps.stopHttp3(context.Background())
+
+	transport := &http3.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	defer transport.Close()
+	client := &http.Client{Transport: transport, Timeout: 5 * time.Second}
+	var body []byte
+	require.Eventually(t, func() bool {
+		rsp, err := client.Get(fmt.Sprintf("https://localhost:%d/proto", config.Http3.Port))
+		if err != nil {
+			return false
+		}
+		defer rsp.Body.Close()
+		body, _ = io.ReadAll(rsp.Body)
+		return true
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.Equal(t, "HTTP/3.0", string(body))
+
+	// HTTPS responses advertise HTTP/3
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "https://localhost/proto", nil)
+	ps.HttpServer.Handler.ServeHTTP(rec, req)
+	assert.Equal(t, fmt.Sprintf(`h3=":%d"; ma=2592000`, config.Http3.Port), rec.Header().Get("Alt-Svc"))
+	rec = httptest.NewRecorder()
+	ps.HttpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://localhost/proto", nil))
+	assert.Empty(t, rec.Header().Get("Alt-Svc"))
+}
@@ -0,0 +1,83 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/plank/pkg/federation"
+)
+
+const defaultRelayUpstreamName = "upstream"
+
+// newRelayState creates a transparent federation with the upstream instance as its only peer, and no
+// inbound link: nothing connects to a relay.
+func newRelayState(config *RelayConfig, eventBus bus.EventBus, logger *slog.Logger) (*federationState, error) {
+	if config.Upstream == nil {
+		return nil, fmt.Errorf("relay of '%s' has no upstream instance", config.Node)
+	}
+	channels := make([]string, 0, len(config.Channels))
+	names := make(map[string]string)
+	for _, mapping := range config.Channels {
+		if mapping == nil || mapping.Channel == "" {
+			return nil, fmt.Errorf("relay of '%s' has a channel mapping without a channel", config.Node)
+		}
+		channels = append(channels, mapping.Channel)
+		if mapping.UpstreamChannel != "" {
+			names[mapping.Channel] = mapping.UpstreamChannel
+		}
+	}
+	f, err := federation.New(eventBus, &federation.Config{
+		Node:        config.Node,
+		Channels:    channels,
+		Names:       names,
+		Transparent: true,
+		MaxHops:     config.MaxHops,
+		Logger:      logger,
+	})
+	if err != nil {
+		return nil, err
+	}
+	upstream := *config.Upstream
+	if upstream.Name == "" {
+		upstream.Name = defaultRelayUpstreamName
+	}
+	fs := &federationState{federation: f, eventBus: eventBus, logger: logger}
+	if err := fs.addPeer(&upstream, config.TopicPrefix, config.AppRequestPrefix); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+// startRelay mirrors channels with the upstream instance, if configured.
+func (ps *platformServer) startRelay() {
+	cfg := ps.serverConfig.Relay
+	if cfg == nil {
+		return
+	}
+	rs, err := newRelayState(cfg, ps.eventbus, ps.serverConfig.Logger)
+	if err == nil {
+		err = rs.start()
+	}
+	if err != nil {
+		ps.serverConfig.Logger.Error(wrapError(errServerInit, err).Error())
+		return
+	}
+	ps.lock.Lock()
+	ps.relay = rs
+	ps.lock.Unlock()
+}
+
+// stopRelay stops mirroring and disconnects from the upstream instance.
+func (ps *platformServer) stopRelay() {
+	ps.lock.Lock()
+	rs := ps.relay
+	ps.relay = nil
+	ps.lock.Unlock()
+	if rs != nil {
+		rs.stop()
+	}
+}
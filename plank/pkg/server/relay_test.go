@@ -0,0 +1,93 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/pb33f/ranch/bridge"
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRelayState_Invalid(t *testing.T) {
+	b := bus.NewEventBusInstance()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	channels := []*RelayChannelMapping{{Channel: "orders"}}
+	_, err := newRelayState(&RelayConfig{Node: "edge", Channels: channels}, b, logger)
+	assert.Error(t, err)
+	_, err = newRelayState(&RelayConfig{Node: "edge", Channels: channels, Upstream: &BrokerBridgeConfig{}}, b, logger)
+	assert.Error(t, err)
+	_, err = newRelayState(&RelayConfig{Node: "edge", Channels: []*RelayChannelMapping{{}},
+		Upstream: &BrokerBridgeConfig{ServerAddr: "localhost:30080"}}, b, logger)
+	assert.Error(t, err)
+	_, err = newRelayState(&RelayConfig{Channels: channels,
+		Upstream: &BrokerBridgeConfig{ServerAddr: "localhost:30080"}}, b, logger)
+	assert.Error(t, err)
+}
+
+func TestRelay_MirrorsChannels(t *testing.T) {
+	b := bus.NewEventBusInstance()
+	conn := newFakeBrokerConnection()
+	rs, err := newRelayState(&RelayConfig{
+		Node:     "edge",
+		Upstream: &BrokerBridgeConfig{ServerAddr: "localhost:30080"},
+		Channels: []*RelayChannelMapping{{Channel: "orders", UpstreamChannel: "orders-edge"}},
+	}, b, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	require.NoError(t, err)
+	rs.peers[0].bridge.connectFn = func(config *bridge.BrokerConnectorConfig) (bridge.Connection, error) {
+		return conn, nil
+	}
+	require.NoError(t, rs.start())
+	defer rs.stop()
+	assert.False(t, b.GetChannelManager().CheckChannelExists(RANCH_FEDERATION_CHANNEL),
+		"nothing connects to a relay")
+
+	received := make(chan interface{}, 2)
+	orders, _ := b.ListenStream("orders")
+	orders.Handle(func(msg *model.Message) {
+		received <- msg.Payload
+	}, func(err error) {})
+	defer orders.Close()
+
+	// local messages are sent upstream under the name of the upstream channel
+	require.Eventually(t, func() bool { return conn.getSub("/topic/ranch-federation") != nil },
+		time.Second, 5*time.Millisecond)
+	require.NoError(t, b.SendResponseMessage("orders", map[string]string{"item": "hay"}, nil))
+	<-received
+	select {
+	case msg := <-conn.sent:
+		assert.Equal(t, "/pub/ranch-federation", msg.destination)
+		req := make(map[string]interface{})
+		require.NoError(t, json.Unmarshal(msg.payload, &req))
+		env := req["payload"].(map[string]interface{})
+		assert.Equal(t, "edge", env["origin"])
+		assert.Equal(t, "orders-edge", env["channel"])
+		assert.Equal(t, map[string]interface{}{"item": "hay"}, env["payload"])
+	case <-time.After(time.Second):
+		t.Fatal("message was not sent upstream")
+	}
+
+	// upstream messages are delivered as sent, and not sent back
+	conn.getSub("/topic/ranch-federation").c <- model.GenerateResponse(&model.MessageConfig{
+		Payload: []byte(`{"id":"1","origin":"core","hops":["core"],"channel":"orders-edge","payload":{"item":"straw"}}`),
+	})
+	select {
+	case payload := <-received:
+		assert.Equal(t, []byte(`{"item":"straw"}`), payload)
+	case <-time.After(time.Second):
+		t.Fatal("upstream message was not delivered")
+	}
+	select {
+	case msg := <-conn.sent:
+		t.Fatalf("upstream message was sent back: %s", msg.payload)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/pb33f/ranch/bridge"
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/plank/pkg/replication"
+)
+
+const defaultReplicationDestination = "/topic/ranch-replication"
+
+// replicationState exchanges the updates of a replication.Replicator with the other regions through a
+// broker. The broker connection is managed by a brokerBridge without channel mappings, so it is dialed and
+// re-established the same way, and every time it connects the destination is subscribed to again and the
+// regions resync.
+type replicationState struct {
+	replicator  *replication.Replicator
+	bridge      *brokerBridge
+	destination string
+	logger      *slog.Logger
+	sub         bridge.Subscription
+	lock        sync.Mutex
+}
+
+func newReplicationState(config *ReplicationConfig, eventBus bus.EventBus, logger *slog.Logger) (*replicationState, error) {
+	if err := validateBrokerConnection(config.Broker); err != nil {
+		return nil, err
+	}
+	rs := &replicationState{
+		bridge:      newBrokerBridge(config.Broker, eventBus, logger),
+		destination: config.Destination,
+		logger:      logger,
+	}
+	if rs.destination == "" {
+		rs.destination = defaultReplicationDestination
+	}
+	replicator, err := replication.NewReplicator(eventBus, &replication.Config{
+		Region:   config.Region,
+		Channels: config.Channels,
+		Stores:   config.Stores,
+		Logger:   logger,
+	}, rs.publish)
+	if err != nil {
+		return nil, err
+	}
+	rs.replicator = replicator
+	rs.bridge.onConnect = rs.connected
+	return rs, nil
+}
+
+// start replicates the configured channels and stores, and connects to the broker in the background.
+func (rs *replicationState) start() error {
+	if err := rs.replicator.Start(); err != nil {
+		return err
+	}
+	go func() {
+		if err := rs.bridge.dial(); err != nil {
+			rs.logger.Error("[ranch] replication unable to connect to broker", "error", err.Error())
+		}
+	}()
+	return nil
+}
+
+func (rs *replicationState) stop() {
+	rs.replicator.Stop()
+	rs.bridge.stop()
+	rs.lock.Lock()
+	sub := rs.sub
+	rs.sub = nil
+	rs.lock.Unlock()
+	if sub != nil {
+		_ = sub.Unsubscribe()
+	}
+}
+
+// connected subscribes to the updates of the other regions on a new broker connection, then resyncs.
+func (rs *replicationState) connected(conn bridge.Connection) {
+	sub, err := conn.Subscribe(rs.destination)
+	if err != nil {
+		rs.logger.Error("[ranch] replication unable to subscribe", "destination", rs.destination,
+			"error", err.Error())
+		return
+	}
+	rs.lock.Lock()
+	previous := rs.sub
+	rs.sub = sub
+	rs.lock.Unlock()
+	if previous != nil {
+		_ = previous.Unsubscribe()
+	}
+	go func() {
+		for msg := range sub.GetMsgChannel() {
+			payload, err := encodeBrokerPayload(msg.Payload)
+			if err != nil {
+				continue
+			}
+			rs.replicator.Receive(payload)
+		}
+	}()
+	rs.replicator.Resync()
+}
+
+// publish sends an update to the other regions, reconnecting if the broker cannot be reached.
+func (rs *replicationState) publish(payload []byte) error {
+	conn := rs.bridge.connection()
+	if conn == nil {
+		return fmt.Errorf("not connected to broker '%s'", rs.bridge.config.Name)
+	}
+	if err := conn.SendJSONMessage(rs.destination, payload); err != nil {
+		go rs.bridge.reconnect()
+		return err
+	}
+	return nil
+}
+
+// startReplication replicates channels and stores with the other regions, if configured.
+func (ps *platformServer) startReplication() {
+	cfg := ps.serverConfig.Replication
+	if cfg == nil {
+		return
+	}
+	rs, err := newReplicationState(cfg, ps.eventbus, ps.serverConfig.Logger)
+	if err == nil {
+		err = rs.start()
+	}
+	if err != nil {
+		ps.serverConfig.Logger.Error(wrapError(errServerInit, err).Error())
+		return
+	}
+	ps.lock.Lock()
+	ps.replication = rs
+	ps.lock.Unlock()
+}
+
+// stopReplication stops replicating and disconnects from the broker.
+func (ps *platformServer) stopReplication() {
+	ps.lock.Lock()
+	rs := ps.replication
+	ps.replication = nil
+	ps.lock.Unlock()
+	if rs != nil {
+		rs.stop()
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/pb33f/ranch/bridge"
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/model"
+	"github.com/pb33f/ranch/plank/pkg/replication"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestReplication(t *testing.T, b bus.EventBus, conn *fakeBrokerConnection) *replicationState {
+	rs, err := newReplicationState(&ReplicationConfig{
+		Region:   "eu",
+		Broker:   &BrokerBridgeConfig{Name: "regions", ServerAddr: "localhost:61613"},
+		Channels: []string{"dashboard-events"},
+		Stores:   []*replication.StoreConfig{{Name: "dashboard"}},
+	}, b, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	require.NoError(t, err)
+	rs.bridge.connectFn = func(config *bridge.BrokerConnectorConfig) (bridge.Connection, error) {
+		return conn, nil
+	}
+	return rs
+}
+
+func receiveReplicationUpdate(t *testing.T, conn *fakeBrokerConnection) map[string]interface{} {
+	select {
+	case msg := <-conn.sent:
+		assert.Equal(t, defaultReplicationDestination, msg.destination)
+		update := make(map[string]interface{})
+		require.NoError(t, json.Unmarshal(msg.payload, &update))
+		return update
+	case <-time.After(time.Second):
+		t.Fatal("no replication update published")
+	}
+	return nil
+}
+
+func TestNewReplicationState_Invalid(t *testing.T) {
+	b := bus.ResetBus()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	_, err := newReplicationState(&ReplicationConfig{Region: "eu"}, b, logger)
+	assert.Error(t, err)
+	_, err = newReplicationState(&ReplicationConfig{
+		Broker: &BrokerBridgeConfig{Name: "regions", ServerAddr: "localhost:61613"}}, b, logger)
+	assert.Error(t, err)
+}
+
+func TestReplication_ExchangesUpdates(t *testing.T) {
+	b := bus.ResetBus()
+	conn := newFakeBrokerConnection()
+	store := b.GetStoreManager().CreateStore("dashboard")
+	rs := newTestReplication(t, b, conn)
+	require.NoError(t, rs.start())
+	defer rs.stop()
+
+	// connecting resyncs with the other regions
+	update := receiveReplicationUpdate(t, conn)
+	assert.Equal(t, "state", update["type"])
+	assert.Equal(t, true, update["reply"])
+
+	// local store writes are published
+	store.Put("cpu", 42.0, "updated")
+	update = receiveReplicationUpdate(t, conn)
+	assert.Equal(t, "eu", update["region"])
+	items := update["items"].([]interface{})
+	assert.Len(t, items, 1)
+	assert.Equal(t, "cpu", items[0].(map[string]interface{})["id"])
+
+	// messages of other regions are delivered tagged with their region
+	received := make(chan *replication.RegionMessage, 1)
+	handler, _ := b.ListenStream("dashboard-events")
+	handler.Handle(func(msg *model.Message) {
+		if rm, ok := msg.Payload.(*replication.RegionMessage); ok {
+			received <- rm
+		}
+	}, func(err error) {})
+	sub := conn.getSub(defaultReplicationDestination)
+	require.NotNil(t, sub)
+	sub.c <- model.GenerateResponse(&model.MessageConfig{
+		Payload: []byte(`{"type":"message","region":"us","channel":"dashboard-events","payload":{"cpu":7}}`),
+	})
+	select {
+	case rm := <-received:
+		assert.Equal(t, "us", rm.Region)
+		assert.Equal(t, map[string]interface{}{"cpu": 7.0}, rm.Payload)
+	case <-time.After(time.Second):
+		t.Fatal("message of another region was not delivered")
+	}
+
+	// and store items of other regions are applied
+	sub.c <- model.GenerateResponse(&model.MessageConfig{
+		Payload: []byte(`{"type":"state","region":"us","items":[{"store":"dashboard","id":"mem","value":3,` +
+			`"stamp":{"wall":1,"logical":0,"region":"us"}}]}`),
+	})
+	assert.Eventually(t, func() bool {
+		return store.GetValue("mem") == 3.0
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,101 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/pb33f/ranch/service"
+)
+
+// requestLimits bound the request bodies a REST bridge reads.
+type requestLimits struct {
+	maxBodyBytes int64                    // largest body accepted, no limit if 0
+	readTimeout  time.Duration            // how long reading the body may take, no limit if 0
+	multipart    *service.MultipartConfig // multipart/form-data bodies are parsed within these limits, if set
+}
+
+// bridgeRequestLimits returns the limits of the request bodies of a REST bridge, those of the server unless
+// the bridge sets its own.
+func (ps *platformServer) bridgeRequestLimits(bridgeConfig *service.RESTBridgeConfig) requestLimits {
+	var limits requestLimits
+	if cfg := ps.serverConfig.RequestLimits; cfg != nil {
+		limits.maxBodyBytes = cfg.MaxRequestBodyBytes
+		limits.readTimeout = time.Duration(cfg.ReadTimeoutSeconds) * time.Second
+	}
+	if bridgeConfig.MaxRequestBodyBytes > 0 {
+		limits.maxBodyBytes = bridgeConfig.MaxRequestBodyBytes
+	}
+	if bridgeConfig.ReadTimeout > 0 {
+		limits.readTimeout = bridgeConfig.ReadTimeout
+	}
+	limits.multipart = bridgeConfig.Multipart
+	return limits
+}
+
+// readBody reads the body of a request within the limits, before the request builder gets to it, and
+// replaces it with the bytes read. Multipart forms are parsed instead, if the bridge accepts them, and
+// carried by the context of the request returned. Requests refused are returned with the status code
+// refusing them: 413 for bodies too large, 408 for bodies not received in time.
+func (l requestLimits) readBody(w http.ResponseWriter, r *http.Request) (*http.Request, int, error) {
+	multipart := l.multipart != nil && isMultipartForm(r)
+	if (!multipart && l.maxBodyBytes <= 0 && l.readTimeout <= 0) || r.Body == nil || r.Body == http.NoBody {
+		return r, 0, nil
+	}
+	if l.maxBodyBytes > 0 && r.ContentLength > l.maxBodyBytes {
+		w.Header().Set("Connection", "close")
+		return r, http.StatusRequestEntityTooLarge, fmt.Errorf("request body is larger than %d bytes", l.maxBodyBytes)
+	}
+	// the deadline is that of the connection, enforced by the network stack rather than the clock
+	rc := http.NewResponseController(w)
+	deadline := l.readTimeout > 0 && rc.SetReadDeadline(time.Now().Add(l.readTimeout)) == nil
+	if l.maxBodyBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, l.maxBodyBytes)
+	}
+	var data []byte
+	var form *service.MultipartForm
+	var err error
+	if multipart {
+		form, err = service.ParseMultipartForm(r, l.multipart)
+	} else {
+		data, err = io.ReadAll(r.Body)
+	}
+	if err != nil {
+		// the rest of the body is not read, the connection cannot be reused
+		w.Header().Set("Connection", "close")
+	}
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		return r, http.StatusRequestEntityTooLarge, fmt.Errorf("request body is larger than %d bytes", l.maxBodyBytes)
+	case errors.Is(err, service.ErrMultipartLimit):
+		return r, http.StatusRequestEntityTooLarge, err
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return r, http.StatusRequestTimeout, fmt.Errorf("request body not received within %s", l.readTimeout)
+	case err != nil:
+		return r, http.StatusBadRequest, fmt.Errorf("cannot read request body: %w", err)
+	}
+	if deadline {
+		_ = rc.SetReadDeadline(time.Time{})
+	}
+	if form != nil {
+		r.Body = http.NoBody
+		return r.WithContext(service.NewMultipartContext(r.Context(), form)), 0, nil
+	}
+	r.Body = io.NopCloser(bytes.NewReader(data))
+	return r, 0, nil
+}
+
+// isMultipartForm tells whether the body of a request is a multipart/form-data form.
+func isMultipartForm(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "multipart/form-data"
+}
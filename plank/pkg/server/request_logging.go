@@ -0,0 +1,47 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/pb33f/ranch/log"
+)
+
+const defaultRequestIdHeader = "X-Request-Id"
+
+// requestLoggingMiddleware attaches a request-scoped logger to the context of every HTTP request, carrying
+// the request id, the route matched and the principal.
+func (ps *platformServer) requestLoggingMiddleware(next http.Handler) http.Handler {
+	cfg := ps.serverConfig.RequestLogging
+	header := cfg.RequestIdHeader
+	if header == "" {
+		header = defaultRequestIdHeader
+	}
+	router := ps.router
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestId := r.Header.Get(header)
+		if requestId == "" || len(requestId) > 128 {
+			requestId = uuid.New().String()
+		}
+		w.Header().Set(header, requestId)
+
+		args := []any{log.RequestIdKey, requestId}
+		var match mux.RouteMatch
+		if router.Match(r, &match) && match.Route != nil {
+			if route, err := match.Route.GetPathTemplate(); err == nil {
+				args = append(args, log.RouteKey, route)
+			}
+		}
+		if cfg.Principal != nil {
+			if principal := cfg.Principal(r); principal != "" {
+				args = append(args, log.PrincipalKey, principal)
+			}
+		}
+		logger := ps.serverConfig.Logger.With(args...)
+		next.ServeHTTP(w, r.WithContext(log.NewContext(r.Context(), logger)))
+	})
+}
@@ -0,0 +1,287 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/clock"
+	"github.com/pb33f/ranch/model"
+	"github.com/pb33f/ranch/plank/pkg/edgecache"
+	"github.com/pb33f/ranch/plank/pkg/responsecache"
+	"github.com/pb33f/ranch/service"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultResponseCacheTTL           = time.Minute
+	defaultResponseCacheMaxEntryBytes = 1 << 20
+	responseCacheTimeout              = 5 * time.Second
+)
+
+// responseCacheState is the store REST bridge responses are cached in.
+type responseCacheState struct {
+	store         responsecache.Store
+	ttl           time.Duration
+	maxEntryBytes int64
+	ownStore      bool // the store was created from the configuration, and is closed with the server
+	handler       bus.MessageHandler
+}
+
+// initResponseCache creates the store of the response cache, if configured. Bridges opt in to it.
+func (ps *platformServer) initResponseCache() {
+	cfg := ps.serverConfig.ResponseCache
+	if cfg == nil {
+		return
+	}
+	rc := &responseCacheState{
+		store:         cfg.Store,
+		ttl:           time.Duration(cfg.TTLSeconds) * time.Second,
+		maxEntryBytes: cfg.MaxEntryBytes,
+	}
+	if rc.ttl <= 0 {
+		rc.ttl = defaultResponseCacheTTL
+	}
+	if rc.maxEntryBytes <= 0 {
+		rc.maxEntryBytes = defaultResponseCacheMaxEntryBytes
+	}
+	if rc.store == nil && cfg.Redis != nil {
+		client, err := newResponseCacheRedisClient(cfg.Redis)
+		if err != nil {
+			ps.serverConfig.Logger.Error(wrapError(errServerInit, err).Error())
+			return
+		}
+		rc.store, rc.ownStore = responsecache.NewRedisStore(client, cfg.Redis.KeyPrefix), true
+	}
+	if rc.store == nil {
+		rc.store, rc.ownStore = responsecache.NewMemoryStore(cfg.MaxEntries), true
+	}
+	ps.responseCache = rc
+}
+
+// newResponseCacheRedisClient connects to the Redis server responses are cached in.
+func newResponseCacheRedisClient(cfg *RedisStoreConfig) (*redis.Client, error) {
+	opts := &redis.Options{
+		Addr:        cfg.ServerAddr,
+		Username:    cfg.Username,
+		Password:    cfg.Password,
+		DialTimeout: responseCacheTimeout,
+	}
+	if cfg.UseTLS {
+		opts.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	client := redis.NewClient(opts)
+	ctx, cancel := clock.WithTimeout(context.Background(), responseCacheTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// cacheResponses wraps the handler of a REST bridge that opts in to the response cache, answering GET and
+// HEAD requests with the response cached for them, and caching the successful responses of the handler.
+// Requests with credentials the responses do not vary by bypass the cache, as do those asking for a fresh
+// response with Cache-Control: no-cache, which replace the response cached for them.
+func (ps *platformServer) cacheResponses(bridgeConfig *service.RESTBridgeConfig,
+	handler http.HandlerFunc) http.HandlerFunc {
+
+	rc := ps.responseCache
+	ttl := bridgeConfig.Cache.TTL
+	if ttl <= 0 {
+		ttl = rc.ttl
+	}
+	vary := slices.Clone(bridgeConfig.Cache.Vary)
+	if ps.serializers != nil {
+		vary = append(vary, "Accept")
+	}
+	tags := bridgeSurrogateKeys(bridgeConfig)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if (r.Method != http.MethodGet && r.Method != http.MethodHead) || privateRequest(r, vary) {
+			handler(w, r)
+			return
+		}
+		key := responsecache.Key(r, vary)
+		if !strings.Contains(strings.ToLower(r.Header.Get("Cache-Control")), "no-cache") {
+			ctx, cancel := clock.WithTimeout(r.Context(), responseCacheTimeout)
+			entry, err := rc.store.Get(ctx, key)
+			cancel()
+			if err != nil {
+				ps.serverConfig.Logger.Error("[ranch] unable to read the response cache", "key", key,
+					"error", err.Error())
+			}
+			if entry != nil {
+				ps.writeCachedResponse(w, r, entry)
+				return
+			}
+		}
+
+		recorder := &cacheRecorder{ResponseWriter: w, before: w.Header().Clone(), maxBytes: rc.maxEntryBytes}
+		handler(recorder, r)
+		if entry := recorder.entry(); entry != nil {
+			entry.Tags, entry.Stored = tags, clock.Now()
+			ctx, cancel := clock.WithTimeout(context.Background(), responseCacheTimeout)
+			defer cancel()
+			if err := rc.store.Set(ctx, key, entry, ttl); err != nil {
+				ps.serverConfig.Logger.Error("[ranch] unable to cache response", "key", key, "error", err.Error())
+			}
+		}
+	}
+}
+
+// privateRequest tells whether a request carries credentials the responses to it do not vary by, which
+// would leak them to other clients if they were cached.
+func privateRequest(r *http.Request, vary []string) bool {
+	for _, name := range []string{"Authorization", "Cookie"} {
+		if r.Header.Get(name) != "" && !slices.ContainsFunc(vary, func(v string) bool {
+			return strings.EqualFold(v, name)
+		}) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeCachedResponse answers a request with a cached response, or with 304 if the client has it already.
+func (ps *platformServer) writeCachedResponse(w http.ResponseWriter, r *http.Request, entry *responsecache.Entry) {
+	for name, values := range entry.Header {
+		w.Header()[name] = slices.Clone(values)
+	}
+	w.Header().Set("Age", strconv.Itoa(int(max(clock.Since(entry.Stored), 0).Seconds())))
+	if ps.serverConfig.ETags && notModified(w, r, entry.Status, entry.Body) {
+		writeNotModified(w)
+		return
+	}
+	w.WriteHeader(entry.Status)
+	_, _ = w.Write(entry.Body)
+}
+
+// cacheRecorder passes a response through to the client, keeping a copy to cache unless it turns out
+// not to be cacheable: not a 200, too large, streamed, setting cookies or marked no-store or private.
+type cacheRecorder struct {
+	http.ResponseWriter
+	before      http.Header // headers set before the handler ran, by the middleware in front of it
+	maxBytes    int64
+	status      int
+	header      http.Header
+	body        bytes.Buffer
+	uncacheable bool
+}
+
+func (cr *cacheRecorder) WriteHeader(status int) {
+	if cr.status == 0 {
+		cr.status = status
+		cr.header = make(http.Header)
+		for name, values := range cr.ResponseWriter.Header() {
+			if !slices.Equal(cr.before[name], values) {
+				cr.header[name] = slices.Clone(values)
+			}
+		}
+	}
+	cr.ResponseWriter.WriteHeader(status)
+}
+
+func (cr *cacheRecorder) Write(data []byte) (int, error) {
+	if cr.status == 0 {
+		cr.WriteHeader(http.StatusOK)
+	}
+	if !cr.uncacheable {
+		if int64(cr.body.Len()+len(data)) > cr.maxBytes {
+			cr.uncacheable = true
+			cr.body.Reset()
+		} else {
+			cr.body.Write(data)
+		}
+	}
+	return cr.ResponseWriter.Write(data)
+}
+
+// Flush passes streamed responses through, they are not cached.
+func (cr *cacheRecorder) Flush() {
+	cr.uncacheable = true
+	_ = http.NewResponseController(cr.ResponseWriter).Flush()
+}
+
+func (cr *cacheRecorder) Unwrap() http.ResponseWriter {
+	return cr.ResponseWriter
+}
+
+// entry returns the response recorded, nil if it cannot be cached.
+func (cr *cacheRecorder) entry() *responsecache.Entry {
+	if cr.uncacheable || cr.status != http.StatusOK || cr.header.Get("Set-Cookie") != "" {
+		return nil
+	}
+	cacheControl := strings.ToLower(cr.header.Get("Cache-Control"))
+	if strings.Contains(cacheControl, "no-store") || strings.Contains(cacheControl, "private") {
+		return nil
+	}
+	return &responsecache.Entry{Status: cr.status, Header: cr.header, Body: bytes.Clone(cr.body.Bytes())}
+}
+
+// startResponseCachePurges purges the cached responses tagged with the keys of the invalidations published
+// on RANCH_EDGE_CACHE_INVALIDATION_CHANNEL, the same that purge CDNs.
+func (ps *platformServer) startResponseCachePurges() {
+	rc := ps.responseCache
+	if rc == nil {
+		return
+	}
+	cm := ps.eventbus.GetChannelManager()
+	if !cm.CheckChannelExists(RANCH_EDGE_CACHE_INVALIDATION_CHANNEL) {
+		cm.CreateChannel(RANCH_EDGE_CACHE_INVALIDATION_CHANNEL)
+	}
+	handler, err := ps.eventbus.ListenFirehose(RANCH_EDGE_CACHE_INVALIDATION_CHANNEL)
+	if err != nil {
+		ps.serverConfig.Logger.Error(wrapError(errServerInit, err).Error())
+		return
+	}
+	handler.Handle(func(msg *model.Message) {
+		invalidation := edgecache.FromPayload(msg.Payload)
+		if invalidation == nil || len(invalidation.Keys) == 0 {
+			return
+		}
+		ctx, cancel := clock.WithTimeout(context.Background(), responseCacheTimeout)
+		defer cancel()
+		if err := rc.store.Purge(ctx, invalidation.Keys...); err != nil {
+			ps.serverConfig.Logger.Error("[ranch] response cache purge failed", "keys", invalidation.Keys,
+				"error", err.Error())
+			return
+		}
+		ps.serverConfig.Logger.Debug("[ranch] response cache purged", "keys", invalidation.Keys)
+	}, func(err error) {})
+
+	ps.lock.Lock()
+	rc.handler = handler
+	ps.lock.Unlock()
+}
+
+// stopResponseCachePurges stops listening for invalidations and closes the store created from the
+// configuration.
+func (ps *platformServer) stopResponseCachePurges() {
+	rc := ps.responseCache
+	if rc == nil {
+		return
+	}
+	ps.lock.Lock()
+	handler := rc.handler
+	rc.handler = nil
+	ps.lock.Unlock()
+	if handler != nil {
+		handler.Close()
+	}
+	if rc.ownStore {
+		if err := rc.store.Close(); err != nil {
+			ps.serverConfig.Logger.Error("[ranch] unable to close the response cache", "error", err.Error())
+		}
+	}
+}
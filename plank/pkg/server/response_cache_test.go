@@ -0,0 +1,143 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/model"
+	"github.com/pb33f/ranch/plank/pkg/edgecache"
+	"github.com/pb33f/ranch/plank/pkg/responsecache"
+	"github.com/pb33f/ranch/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheResponses(t *testing.T) {
+	b := bus.ResetBus()
+	service.ResetServiceRegistry()
+	_ = b.GetChannelManager().CreateChannel("cow-service")
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", GetTestPort(), true)
+	config.ResponseCache = &ResponseCacheConfig{MaxEntryBytes: 64}
+	config.ETags = true
+	ps := NewPlatformServer(config).(*platformServer)
+	ps.eventbus = b
+	ps.startResponseCachePurges()
+	defer ps.stopResponseCachePurges()
+	store := ps.responseCache.store.(*responsecache.MemoryStore)
+
+	bridge := ps.bridgeFor("cow-service")
+	replies, requests := answerRequests(t, b, bridge, "cow-service")
+	bridgeConfig := &service.RESTBridgeConfig{ServiceChannel: "cow-service", Uri: "/herd", Method: http.MethodGet,
+		SurrogateKeys: []string{"herd"}, Cache: &service.CacheConfig{TTL: time.Minute, Vary: []string{"Accept-Language"}}}
+	handler := ps.cacheResponses(bridgeConfig, ps.buildEndpointHandler("cow-service",
+		func(w http.ResponseWriter, r *http.Request) model.Request {
+			return model.Request{RequestCommand: "list"}
+		}, time.Second, bridge, requestLimits{}))
+
+	// serve answers a request, with the response of the service given or from the cache if nil
+	serve := func(method, uri string, headers map[string]string, rsp *model.Response) *httptest.ResponseRecorder {
+		if rsp != nil {
+			replies <- []*model.Message{{Payload: rsp}}
+		}
+		req := httptest.NewRequest(method, uri, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		rec.Header().Set("X-Request-Id", "from-middleware")
+		handler(rec, req)
+		if rsp != nil {
+			<-requests
+		}
+		return rec
+	}
+	herd := func(names ...string) *model.Response {
+		return &model.Response{Payload: names, Marshal: true,
+			Headers: map[string]interface{}{"Content-Type": "application/json"}}
+	}
+
+	rec := serve(http.MethodGet, "/herd", nil, herd("daisy", "bessie"))
+	assert.Equal(t, `["daisy","bessie"]`, rec.Body.String())
+	assert.Empty(t, rec.Header().Get("Age"))
+	etag := rec.Header().Get("ETag")
+
+	// repeated requests skip the service, and get the headers it set but not those of the middleware
+	rec = serve(http.MethodGet, "/herd", nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `["daisy","bessie"]`, rec.Body.String())
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Equal(t, etag, rec.Header().Get("ETag"))
+	assert.Equal(t, "0", rec.Header().Get("Age"))
+	assert.Equal(t, "from-middleware", rec.Header().Get("X-Request-Id"))
+	assert.Equal(t, http.StatusNotModified,
+		serve(http.MethodGet, "/herd", map[string]string{"If-None-Match": etag}, nil).Code)
+	assert.Empty(t, requests)
+
+	// responses are cached by URI and vary headers
+	assert.Equal(t, `["wilbur"]`, serve(http.MethodGet, "/herd?kind=pig", nil, herd("wilbur")).Body.String())
+	assert.Equal(t, `["vache"]`, serve(http.MethodGet, "/herd", map[string]string{"Accept-Language": "fr"},
+		herd("vache")).Body.String())
+	assert.Equal(t, `["vache"]`, serve(http.MethodGet, "/herd", map[string]string{"Accept-Language": "fr"},
+		nil).Body.String())
+
+	// fresh responses are asked for with no-cache, and replace those cached
+	assert.Equal(t, `["daisy"]`, serve(http.MethodGet, "/herd", map[string]string{"Cache-Control": "no-cache"},
+		herd("daisy")).Body.String())
+	assert.Equal(t, `["daisy"]`, serve(http.MethodGet, "/herd", nil, nil).Body.String())
+
+	// requests with credentials, and responses that are not cacheable, go to the service every time
+	for _, rsp := range []func() *model.Response{
+		func() *model.Response { return &model.Response{Payload: "moo", HttpStatusCode: http.StatusAccepted} },
+		func() *model.Response { return &model.Response{Payload: "stampede", Error: true, ErrorCode: 503} },
+		func() *model.Response {
+			rsp := herd("daisy")
+			rsp.Headers["Cache-Control"] = "private, max-age=60"
+			return rsp
+		},
+		func() *model.Response {
+			return &model.Response{Payload: "moo", Cookies: []*http.Cookie{{Name: "herd", Value: "cows"}}}
+		},
+		func() *model.Response { return herd(make([]string, 40)...) },
+	} {
+		serve(http.MethodGet, "/uncacheable", nil, rsp())
+		serve(http.MethodGet, "/uncacheable", nil, rsp())
+	}
+	serve(http.MethodGet, "/herd", map[string]string{"Authorization": "Bearer moo"}, herd("secret"))
+	serve(http.MethodPost, "/herd", nil, herd("posted"))
+	assert.Equal(t, `["daisy"]`, serve(http.MethodGet, "/herd", nil, nil).Body.String())
+	assert.Equal(t, 3, store.Len())
+
+	// invalidations of the service channel or a surrogate key of the bridge purge its responses
+	require.NoError(t, b.SendResponseMessage(RANCH_EDGE_CACHE_INVALIDATION_CHANNEL,
+		&edgecache.Invalidation{Keys: []string{"sheep-service"}, Paths: []string{"/herd"}}, nil))
+	require.NoError(t, b.SendResponseMessage(RANCH_EDGE_CACHE_INVALIDATION_CHANNEL,
+		&edgecache.Invalidation{Keys: []string{"herd"}}, nil))
+	assert.Eventually(t, func() bool { return store.Len() == 0 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, `["bessie"]`, serve(http.MethodGet, "/herd", nil, herd("bessie")).Body.String())
+}
+
+func TestInitResponseCache_Redis(t *testing.T) {
+	srv := miniredis.RunT(t)
+	bus.ResetBus()
+	service.ResetServiceRegistry()
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", GetTestPort(), true)
+	config.ResponseCache = &ResponseCacheConfig{TTLSeconds: 30, Redis: &RedisStoreConfig{ServerAddr: srv.Addr()}}
+	ps := NewPlatformServer(config).(*platformServer)
+	require.NotNil(t, ps.responseCache)
+	assert.IsType(t, &responsecache.RedisStore{}, ps.responseCache.store)
+	assert.Equal(t, 30*time.Second, ps.responseCache.ttl)
+	ps.stopResponseCachePurges()
+
+	// the cache is left out when Redis cannot be reached
+	srv.Close()
+	ps = NewPlatformServer(config).(*platformServer)
+	assert.Nil(t, ps.responseCache)
+}
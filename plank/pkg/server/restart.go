@@ -0,0 +1,307 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	// RANCH_LISTENER_FDS names the sockets handed over to a new process, as name=fd pairs separated by commas.
+	RANCH_LISTENER_FDS = "RANCH_LISTENER_FDS"
+
+	// RANCH_RESTART_READY_FD is the pipe a new process writes to once online, for the old one to step down.
+	RANCH_RESTART_READY_FD = "RANCH_RESTART_READY_FD"
+
+	// defaultRestartReadyTimeout is how long a new process has to come online, unless configured.
+	defaultRestartReadyTimeout = 60 * time.Second
+)
+
+// fileSocket is a listening socket that can be handed over to another process, such as *net.TCPListener
+// and *net.UDPConn.
+type fileSocket interface {
+	File() (*os.File, error)
+}
+
+// namedSocket is a listening socket of the server, named after what listens on it.
+type namedSocket struct {
+	name   string
+	socket fileSocket
+}
+
+// restartState is the sockets the server listens on, handed over to the new process on restarts, and those
+// it inherited from the process it replaces.
+type restartState struct {
+	inherited  map[string]*os.File // sockets inherited from the process replaced, by name, until listened on
+	handedOver bool                // the process replaced handed its sockets over
+	ready      *os.File            // written to once online, for the process replaced to step down, nil if none
+	sockets    []namedSocket       // sockets listened on, in the order they are handed over
+	signals    chan os.Signal      // restart signals, nil until restarts are started
+	restarting atomic.Bool         // a new process is being started
+	output     *os.File            // stdout and stderr of new processes, those of this process if nil
+}
+
+// initGracefulRestart takes over the sockets and readiness pipe the process this one replaces handed over, if
+// restarts are configured.
+func (ps *platformServer) initGracefulRestart() {
+	if ps.serverConfig.GracefulRestart == nil {
+		return
+	}
+	state := &restartState{inherited: make(map[string]*os.File)}
+	if fds := os.Getenv(RANCH_LISTENER_FDS); fds != "" {
+		for _, pair := range strings.Split(fds, ",") {
+			name, value, _ := strings.Cut(pair, "=")
+			fd, err := strconv.Atoi(value)
+			if err != nil {
+				ps.serverConfig.Logger.Error(wrapError(errServerInit,
+					fmt.Errorf("invalid handed over socket %q: %w", pair, err)).Error())
+				continue
+			}
+			state.inherited[name] = os.NewFile(uintptr(fd), name)
+		}
+		state.handedOver = true
+	}
+	if value := os.Getenv(RANCH_RESTART_READY_FD); value != "" {
+		if fd, err := strconv.Atoi(value); err == nil {
+			state.ready = os.NewFile(uintptr(fd), "ready")
+		}
+	}
+	// the sockets are ours now, processes started by this one do not get them
+	_ = os.Unsetenv(RANCH_LISTENER_FDS)
+	_ = os.Unsetenv(RANCH_RESTART_READY_FD)
+	ps.restart = state
+}
+
+// inheritsSockets tells whether the server took over the sockets of the process it replaces.
+func (ps *platformServer) inheritsSockets() bool {
+	return ps.restart != nil && ps.restart.handedOver
+}
+
+// listen listens on a TCP address, or takes over the socket of the same name the process this one replaces
+// listened on. Sockets are recorded, to be handed over on restarts.
+func (ps *platformServer) listen(name, addr string) (net.Listener, error) {
+	if ps.restart == nil {
+		return net.Listen("tcp", addr)
+	}
+	var listener net.Listener
+	var err error
+	if file := ps.inheritedSocket(name); file != nil {
+		listener, err = net.FileListener(file)
+		_ = file.Close()
+	} else {
+		listener, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	ps.recordSocket(name, listener)
+	return listener, nil
+}
+
+// listenPacket is listen for UDP addresses.
+func (ps *platformServer) listenPacket(name, addr string) (net.PacketConn, error) {
+	if ps.restart == nil {
+		return net.ListenPacket("udp", addr)
+	}
+	var conn net.PacketConn
+	var err error
+	if file := ps.inheritedSocket(name); file != nil {
+		conn, err = net.FilePacketConn(file)
+		_ = file.Close()
+	} else {
+		conn, err = net.ListenPacket("udp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	ps.recordSocket(name, conn)
+	return conn, nil
+}
+
+// inheritedSocket returns the inherited socket of a name once, nil if there is none.
+func (ps *platformServer) inheritedSocket(name string) *os.File {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	file := ps.restart.inherited[name]
+	delete(ps.restart.inherited, name)
+	return file
+}
+
+// recordSocket records a socket to hand over on restarts, if it can be.
+func (ps *platformServer) recordSocket(name string, socket any) {
+	if fs, ok := socket.(fileSocket); ok {
+		ps.lock.Lock()
+		ps.restart.sockets = append(ps.restart.sockets, namedSocket{name: name, socket: fs})
+		ps.lock.Unlock()
+	}
+}
+
+// startGracefulRestart hands the sockets over to a new process when the server gets a restart signal, then
+// shuts the server down the way it does on SIGTERM once the new process is online.
+func (ps *platformServer) startGracefulRestart() {
+	if ps.restart == nil {
+		return
+	}
+	if len(restartSignals) == 0 {
+		ps.serverConfig.Logger.Error(wrapError(errServerInit,
+			errors.New("graceful restarts are not supported on this platform")).Error())
+		return
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, restartSignals...)
+	ps.lock.Lock()
+	ps.restart.signals = signals
+	ps.lock.Unlock()
+
+	go func() {
+		for range signals {
+			ps.serverConfig.Logger.Info("[ranch] saddling up a new ranch hand to take over")
+			if err := ps.handOver(); err != nil {
+				ps.serverConfig.Logger.Error("[ranch] restart failed, carrying on", "error", err.Error())
+				continue
+			}
+			ps.serverConfig.Logger.Info("[ranch] the new ranch hand is online, draining connections before stepping down")
+			ps.SyscallChan <- syscall.SIGTERM
+			return
+		}
+	}()
+}
+
+// stopGracefulRestart stops listening for restart signals.
+func (ps *platformServer) stopGracefulRestart() {
+	if ps.restart == nil {
+		return
+	}
+	ps.lock.Lock()
+	signals := ps.restart.signals
+	ps.restart.signals = nil
+	ps.lock.Unlock()
+	if signals != nil {
+		signal.Stop(signals)
+		close(signals)
+	}
+}
+
+// handOver starts a new process with the sockets of the server and waits for it to come online. The new
+// process is killed if it does not in time, and the server keeps serving.
+func (ps *platformServer) handOver() error {
+	state := ps.restart
+	if !state.restarting.CompareAndSwap(false, true) {
+		return errors.New("a restart is already in progress")
+	}
+	defer state.restarting.Store(false)
+
+	cfg := ps.serverConfig.GracefulRestart
+	executable, args := cfg.Executable, cfg.Args
+	if executable == "" {
+		var err error
+		if executable, err = os.Executable(); err != nil {
+			return err
+		}
+	}
+	if args == nil {
+		args = os.Args[1:]
+	}
+	timeout := time.Duration(cfg.ReadyTimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = defaultRestartReadyTimeout
+	}
+
+	// the sockets are duplicated, they stay open in this process until it shuts down
+	ps.lock.Lock()
+	sockets := append([]namedSocket(nil), state.sockets...)
+	ps.lock.Unlock()
+	var files []*os.File
+	defer func() {
+		for _, file := range files {
+			_ = file.Close()
+		}
+	}()
+	var fds []string
+	for _, s := range sockets {
+		file, err := s.socket.File()
+		if err != nil {
+			return fmt.Errorf("unable to hand over socket %s: %w", s.name, err)
+		}
+		// the files of a process started with ExtraFiles start at descriptor 3
+		fds = append(fds, fmt.Sprintf("%s=%d", s.name, 3+len(files)))
+		files = append(files, file)
+	}
+	ready, readyWriter, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer ready.Close()
+
+	cmd := exec.Command(executable, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if state.output != nil {
+		cmd.Stdout, cmd.Stderr = state.output, state.output
+	}
+	cmd.Env = append(os.Environ(),
+		RANCH_LISTENER_FDS+"="+strings.Join(fds, ","),
+		fmt.Sprintf("%s=%d", RANCH_RESTART_READY_FD, 3+len(files)))
+	cmd.ExtraFiles = append(files, readyWriter)
+	err = cmd.Start()
+	_ = readyWriter.Close()
+	if err != nil {
+		return err
+	}
+
+	online := make(chan error, 1)
+	go func() {
+		_, err := ready.Read(make([]byte, 1))
+		online <- err
+	}()
+	select {
+	case err = <-online:
+		if err != nil {
+			err = fmt.Errorf("the new process exited before coming online: %w", err)
+		}
+	case <-time.After(timeout):
+		err = fmt.Errorf("the new process did not come online within %s", timeout)
+	}
+	if err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return err
+	}
+	ps.serverConfig.Logger.Info("[ranch] handed the ranch over", "pid", cmd.Process.Pid, "sockets", len(files))
+	return cmd.Process.Release()
+}
+
+// restartReady writes the pid file, once the server is online, and tells the process it replaces, if any,
+// to step down.
+func (ps *platformServer) restartReady() {
+	if ps.restart == nil {
+		return
+	}
+	if pidFile := ps.serverConfig.GracefulRestart.PidFile; pidFile != "" {
+		if err := os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())), 0644); err != nil {
+			ps.serverConfig.Logger.Error("[ranch] unable to write pid file", "file", pidFile, "error", err.Error())
+		}
+	}
+	ps.lock.Lock()
+	ready, unused := ps.restart.ready, ps.restart.inherited
+	ps.restart.ready, ps.restart.inherited = nil, nil
+	ps.lock.Unlock()
+	// sockets nothing listens on anymore, e.g. for listeners no longer configured, are closed
+	for _, file := range unused {
+		_ = file.Close()
+	}
+	if ready != nil {
+		_, _ = ready.Write([]byte{1})
+		_ = ready.Close()
+	}
+}
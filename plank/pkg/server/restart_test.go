@@ -0,0 +1,177 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+//go:build !windows
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// restartServer returns a server that hands its sockets over on restarts.
+func restartServer(restart *GracefulRestartConfig) *platformServer {
+	bus.ResetBus()
+	service.ResetServiceRegistry()
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", GetTestPort(), true)
+	config.GracefulRestart = restart
+	return NewPlatformServer(config).(*platformServer)
+}
+
+// discardRestartOutput discards the output of the new processes of a server, which would otherwise be
+// mistaken for that of the tests.
+func discardRestartOutput(t *testing.T, ps *platformServer) {
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = devNull.Close() })
+	ps.restart.output = devNull
+}
+
+// serveAnswer serves answer on a listener until the returned server is closed.
+func serveAnswer(listener net.Listener, answer string, served func()) *http.Server {
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(answer))
+		if served != nil {
+			served()
+		}
+	})}
+	go func() { _ = srv.Serve(listener) }()
+	return srv
+}
+
+func TestListen_InheritsSockets(t *testing.T) {
+	inherited, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer inherited.Close()
+	file, err := inherited.(*net.TCPListener).File()
+	require.NoError(t, err)
+	defer file.Close()
+	fd, err := syscall.Dup(int(file.Fd()))
+	require.NoError(t, err)
+	t.Setenv(RANCH_LISTENER_FDS, fmt.Sprintf("http=%d,broken=moo", fd))
+
+	ps := restartServer(&GracefulRestartConfig{})
+	assert.True(t, ps.inheritsSockets())
+	assert.Empty(t, os.Getenv(RANCH_LISTENER_FDS))
+
+	// the socket of the same name is taken over, others are listened on
+	listener, err := ps.listen("http", ":0")
+	require.NoError(t, err)
+	defer listener.Close()
+	assert.Equal(t, inherited.Addr().String(), listener.Addr().String())
+	other, err := ps.listen("http", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer other.Close()
+	assert.NotEqual(t, inherited.Addr().String(), other.Addr().String())
+	conn, err := ps.listenPacket("http3", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Len(t, ps.restart.sockets, 3)
+
+	// without restarts configured nothing is taken over nor recorded
+	ps = restartServer(nil)
+	assert.False(t, ps.inheritsSockets())
+	listener, err = ps.listen("http", "127.0.0.1:0")
+	require.NoError(t, err)
+	_ = listener.Close()
+	assert.Nil(t, ps.restart)
+}
+
+func TestGracefulRestart(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "ranch.pid")
+	t.Setenv("RANCH_TEST_PID_FILE", pidFile)
+	ps := restartServer(&GracefulRestartConfig{
+		Executable:          os.Args[0],
+		Args:                []string{"-test.run=^TestGracefulRestart_NewProcess$"},
+		ReadyTimeoutSeconds: 10,
+		PidFile:             pidFile,
+	})
+	ps.SyscallChan = make(chan os.Signal, 1)
+	discardRestartOutput(t, ps)
+	listener, err := ps.listen("http", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := serveAnswer(listener, "old", nil)
+	url := fmt.Sprintf("http://%s/", listener.Addr().String())
+
+	get := func() string {
+		rsp, err := (&http.Client{Timeout: 5 * time.Second}).Get(url)
+		require.NoError(t, err)
+		defer rsp.Body.Close()
+		body, _ := io.ReadAll(rsp.Body)
+		return string(body)
+	}
+	assert.Equal(t, "old", get())
+
+	// a restart signal hands the socket over, then shuts this server down once the new process is online.
+	// it is delivered as signal.Notify would, the test binary is not signalled.
+	ps.startGracefulRestart()
+	defer ps.stopGracefulRestart()
+	ps.restart.signals <- syscall.SIGUSR2
+	select {
+	case sig := <-ps.SyscallChan:
+		assert.Equal(t, syscall.SIGTERM, sig)
+	case <-time.After(15 * time.Second):
+		t.Fatal("the new process did not take over")
+	}
+	pid, err := os.ReadFile(pidFile)
+	require.NoError(t, err)
+	assert.NotEqual(t, strconv.Itoa(os.Getpid()), string(pid))
+
+	// the port stays open once this server stops, the new process serves it
+	require.NoError(t, srv.Close())
+	assert.Equal(t, "new", get())
+}
+
+func TestGracefulRestart_NotReady(t *testing.T) {
+	// the new process exits without coming online
+	t.Setenv("RANCH_TEST_NOT_READY", "true")
+	ps := restartServer(&GracefulRestartConfig{Executable: os.Args[0],
+		Args: []string{"-test.run=^TestGracefulRestart_NewProcess$"}})
+	discardRestartOutput(t, ps)
+	listener, err := ps.listen("http", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	assert.ErrorContains(t, ps.handOver(), "exited before coming online")
+
+	// nor does it when there is nothing to start
+	ps.serverConfig.GracefulRestart.Executable = filepath.Join(t.TempDir(), "missing")
+	assert.Error(t, ps.handOver())
+}
+
+// TestGracefulRestart_NewProcess is the new process of TestGracefulRestart, it serves the socket it is handed
+// until it answered a request. It exits at once as the new process of TestGracefulRestart_NotReady.
+func TestGracefulRestart_NewProcess(t *testing.T) {
+	if os.Getenv(RANCH_LISTENER_FDS) == "" {
+		t.Skip("only run as the new process of TestGracefulRestart")
+	}
+	if os.Getenv("RANCH_TEST_NOT_READY") != "" {
+		return
+	}
+	ps := restartServer(&GracefulRestartConfig{PidFile: os.Getenv("RANCH_TEST_PID_FILE")})
+	require.True(t, ps.inheritsSockets())
+	listener, err := ps.listen("http", ":0")
+	require.NoError(t, err)
+	served := make(chan struct{}, 1)
+	srv := serveAnswer(listener, "new", func() { served <- struct{}{} })
+	defer srv.Shutdown(context.Background())
+	ps.restartReady()
+	select {
+	case <-served:
+	case <-time.After(10 * time.Second):
+	}
+}
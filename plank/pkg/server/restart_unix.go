@@ -0,0 +1,14 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+//go:build !windows
+
+package server
+
+import (
+	"os"
+	"syscall"
+)
+
+// restartSignals are the signals handing the sockets over to a new process.
+var restartSignals = []os.Signal{syscall.SIGUSR2}
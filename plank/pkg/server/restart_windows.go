@@ -0,0 +1,9 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import "os"
+
+// restartSignals is empty, sockets cannot be handed over to another process on Windows.
+var restartSignals []os.Signal
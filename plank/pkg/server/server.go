@@ -19,6 +19,8 @@ import (
     "os/signal"
     "path"
     "reflect"
+    "slices"
+    "strings"
     "sync"
     "sync/atomic"
     "syscall"
@@ -27,18 +29,39 @@ import (
     "github.com/gorilla/handlers"
     "github.com/gorilla/mux"
     "github.com/pb33f/ranch/bus"
+    "github.com/pb33f/ranch/log"
     "github.com/pb33f/ranch/model"
+    "github.com/pb33f/ranch/plank/pkg/abuse"
+    "github.com/pb33f/ranch/plank/pkg/grpcbridge"
     "github.com/pb33f/ranch/plank/pkg/middleware"
+    "github.com/pb33f/ranch/plank/pkg/siem"
     "github.com/pb33f/ranch/service"
+    "golang.org/x/net/http2"
+    "golang.org/x/net/http2/h2c"
 )
 
 const RANCH_SERVER_ONLINE_CHANNEL = bus.RANCH_INTERNAL_CHANNEL_PREFIX + "ranch-online-notify"
+const RANCH_ABUSE_EVENT_CHANNEL = bus.RANCH_INTERNAL_CHANNEL_PREFIX + "abuse-events"
+const RANCH_AUDIT_EVENT_CHANNEL = bus.RANCH_INTERNAL_CHANNEL_PREFIX + "audit-events"
+const RANCH_LOAD_SIGNAL_CHANNEL = bus.RANCH_INTERNAL_CHANNEL_PREFIX + "load-signal"
+const RANCH_USAGE_REPORT_CHANNEL = bus.RANCH_INTERNAL_CHANNEL_PREFIX + "usage-reports"
+const RANCH_EDGE_CACHE_INVALIDATION_CHANNEL = bus.RANCH_INTERNAL_CHANNEL_PREFIX + "edge-cache-invalidations"
+const RANCH_FABRIC_CONNECTIONS_CHANNEL = bus.RANCH_INTERNAL_CHANNEL_PREFIX + "fabric-connections"
+const RANCH_BRIDGE_ROUTING_CHANNEL = bus.RANCH_INTERNAL_CHANNEL_PREFIX + "bridge-routing"
+const RANCH_MESSAGE_BRIDGE_RESIZE_CHANNEL = bus.RANCH_INTERNAL_CHANNEL_PREFIX + "message-bridge-resizes"
+const RANCH_BRIDGE_CONTROL_CHANNEL = bus.RANCH_INTERNAL_CHANNEL_PREFIX + "bridge-control"
+const RANCH_SETTINGS_CHANNEL = bus.RANCH_INTERNAL_CHANNEL_PREFIX + "settings"
+const RANCH_ACCESS_LOG_CHANNEL = bus.RANCH_INTERNAL_CHANNEL_PREFIX + "access-log"
+const RANCH_FEDERATION_CHANNEL = "ranch-federation" // not internal, federated peers send to it through the fabric broker
 const AllMethodsWildcard = "*" // every method, open the gates!
 
 // NewPlatformServer configures and returns a new platformServer instance
 func NewPlatformServer(config *PlatformServerConfig) PlatformServer {
 
     // configure a default logger if none is provided
+    if config.Logger == nil && config.LogAdapter != nil {
+        config.Logger = slog.New(log.NewAdapterHandler(config.LogAdapter))
+    }
     if config.Logger == nil {
         config.Logger = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
             Level: slog.LevelInfo,
@@ -91,7 +114,12 @@ func NewPlatformServerFromConfig(configPath string) (PlatformServer, error) {
     // the raw value from the config.json needs to be multiplied by time.Minute otherwise it's interpreted as nanosecond
     config.RestBridgeTimeout = config.RestBridgeTimeout * time.Minute
 
-    if config.TLSCertConfig != nil {
+    if config.TLSCertConfig != nil && config.TLSCertConfig.ACME != nil {
+        // certificates obtained from an ACME CA are kept in the cache directory, there are no files to resolve
+        if acme := config.TLSCertConfig.ACME; acme.CacheDir != "" && !path.IsAbs(acme.CacheDir) {
+            acme.CacheDir = path.Clean(path.Join(config.RootDir, acme.CacheDir))
+        }
+    } else if config.TLSCertConfig != nil {
         if !path.IsAbs(config.TLSCertConfig.CertFile) {
             config.TLSCertConfig.CertFile = path.Clean(path.Join(config.RootDir, config.TLSCertConfig.CertFile))
         }
@@ -127,8 +155,23 @@ func (ps *platformServer) StartServer(syschan chan os.Signal) {
     ps.SyscallChan = syschan
     signal.Notify(ps.SyscallChan, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 
-    // ensure port is available
-    ps.checkPortAvailability()
+    // ensure port is available, unless its socket was handed over by the process this one replaces
+    if !ps.inheritsSockets() {
+        ps.checkPortAvailability()
+    }
+
+    // refuse to start with a TLS setup that breaks FIPS mode, rather than serving without it
+    if err := ps.validateTLSCompliance(); err != nil {
+        panic(wrapError(errServerInit, err))
+    }
+
+    // probe external dependencies, those needed before listening must be up before going any further
+    ps.startDependencyProbes()
+    if ok, err := ps.awaitDependencies(DependencyPhaseStartup); err != nil {
+        panic(wrapError(errServerInit, err))
+    } else if !ok {
+        return
+    }
 
     // finalize handler by setting out writer
     ps.loadGlobalHttpHandler(ps.router)
@@ -138,6 +181,12 @@ func (ps *platformServer) StartServer(syschan chan os.Signal) {
     // then all other routes registered after SPA route will be masked away.
     ps.configureSPA()
 
+    // restrict which clients may read and write stores, before any client can connect
+    ps.startStoreAccess()
+
+    // share broadcast messages with the other instances, before the fabric broker starts
+    ps.startBackplane()
+
     // if Fabric broker configuration is found, start the broker
     if ps.serverConfig.FabricConfig != nil {
         go func() {
@@ -145,31 +194,128 @@ func (ps *platformServer) StartServer(syschan chan os.Signal) {
             fabricEndpoint := ps.serverConfig.FabricConfig.FabricEndpoint
             if ps.serverConfig.FabricConfig.UseTCP {
                 // if using TCP adjust port accordingly and drop endpoint
-                fabricPort = ps.serverConfig.FabricConfig.TCPPort
+                if !ps.serverConfig.FabricConfig.SharePort {
+                    fabricPort = ps.serverConfig.FabricConfig.TCPPort
+                }
                 fabricEndpoint = ""
             }
             brokerLocation := fmt.Sprintf("%s:%d%s", ps.serverConfig.Host, fabricPort, fabricEndpoint)
             ps.serverConfig.Logger.Info("[ranch] hot-dang! starting up the ranch's STOMP message broker", "location", brokerLocation)
+            if ps.serverConfig.FabricConfig.MqttPort > 0 {
+                ps.serverConfig.Logger.Info("[ranch] MQTT clients are welcome too",
+                    "location", fmt.Sprintf("%s:%d", ps.serverConfig.Host, ps.serverConfig.FabricConfig.MqttPort))
+            }
+            if ps.serverConfig.FabricConfig.JsonWebSocketEndpoint != "" {
+                ps.serverConfig.Logger.Info("[ranch] and so are plain JSON WebSocket clients",
+                    "location", fmt.Sprintf("%s:%d%s", ps.serverConfig.Host, ps.serverConfig.Port,
+                        ps.serverConfig.FabricConfig.JsonWebSocketEndpoint))
+            }
             ps.ServerAvailability.Fabric = true
 
-            if err := ps.eventbus.StartFabricEndpoint(ps.fabricConn, *ps.serverConfig.FabricConfig.EndpointConfig); err != nil {
+            endpointConfig := *ps.serverConfig.FabricConfig.EndpointConfig
+            if ps.serverConfig.AbuseGuard != nil {
+                endpointConfig.MiddlewareRegistry = withAbuseGuardMiddleware(
+                    endpointConfig.MiddlewareRegistry, ps.serverConfig.AbuseGuard)
+            }
+            if ps.fabricTickets != nil {
+                endpointConfig.MiddlewareRegistry = withFabricTicketMiddleware(endpointConfig.MiddlewareRegistry,
+                    ps.fabricTickets, ps.serverConfig.FabricConfig.Ticket.Required)
+            }
+            if ps.trustedHeaders != nil && endpointConfig.Principal == nil {
+                // record the principal WebSocket clients took from the proxy
+                endpointConfig.Principal = func(conn stompserver.StompConn) string {
+                    return conn.GetPrincipal()
+                }
+            }
+            if ps.acl != nil {
+                endpointConfig.Authorize = ps.withAclAuthorization(&endpointConfig)
+            }
+            if ps.backplane != nil {
+                endpointConfig.Backplane = ps.backplane
+            }
+            if endpointConfig.RevocationList == nil {
+                endpointConfig.RevocationList = ps.revocations
+            }
+            if ps.classification != nil && endpointConfig.Labels == nil {
+                endpointConfig.Labels = ps.fabricLabels
+            }
+
+            if err := ps.eventbus.StartFabricEndpoint(ps.fabricConn, endpointConfig); err != nil {
                 ps.serverConfig.Logger.Error(wrapError(errServerInit, err).Error())
             }
         }()
     }
 
+    // ship audit and security events to any configured SIEM collectors
+    ps.startSiemExporters()
+
+    // connect any external brokers that local channels should be bridged to
+    ps.startBrokerBridges()
+
+    // expose service channels as gRPC streams
+    ps.startGrpcBridge()
+
+    // back up the stores on a schedule
+    ps.startStoreBackups()
+
+    // count fabric connections and publish the load signal
+    ps.startLoadSignal()
+
+    // publish per service usage reports
+    ps.startUsageReports()
+
+    // publish the inventory of the fabric connections
+    ps.startConnectionsPublishing()
+
+    // route REST bridge requests to alternate service channels
+    ps.startBridgeRouting()
+
+    // add and remove REST bridges on the commands of services
+    ps.startBridgeControl()
+
+    // pick up revoked session tokens
+    ps.startTokenRevocations()
+
+    // pick up changes to the access control file
+    ps.startAclReloads()
+
+    // purge CDNs and cached responses when services invalidate cached content
+    ps.startEdgeCachePurges()
+    ps.startResponseCachePurges()
+
+    // replicate channels and stores with the other regions
+    ps.startReplication()
+
+    // relay channels between the instances of the mesh
+    ps.startFederation()
+
+    // mirror channels with the upstream instance
+    ps.startRelay()
+
+    // check missing static content again, so it is served once deployed
+    ps.startStaticContentChecks()
+
+    // archive channel history and let clients replay it
+    ps.startArchive()
+
+    // push channel responses to WebSub subscribers
+    ps.startWebSub()
+
+    // let dashboards change the settings through the settings service
+    ps.startSettings()
+
     go func() {
         ps.ServerAvailability.Http = true
         if ps.serverConfig.TLSCertConfig != nil {
             ps.serverConfig.Logger.Info("[ranch] yee-haw! starting up the ranch's HTTPS server at %s:%d with TLS", "host", ps.serverConfig.Host, "port", ps.serverConfig.Port)
-            if err := ps.HttpServer.ListenAndServeTLS(ps.serverConfig.TLSCertConfig.CertFile, ps.serverConfig.TLSCertConfig.KeyFile); err != nil {
+            if err := ps.listenAndServe(); err != nil {
                 if !errors.Is(err, http.ErrServerClosed) {
                     ps.serverConfig.Logger.Error(wrapError(errServerInit, err).Error())
                 }
             }
         } else {
             ps.serverConfig.Logger.Info("[ranch] yee-haw! starting up the ranch's HTTP server", "host", ps.serverConfig.Host, "port", ps.serverConfig.Port)
-            if err := ps.HttpServer.ListenAndServe(); err != nil {
+            if err := ps.listenAndServe(); err != nil {
                 if !errors.Is(err, http.ErrServerClosed) {
                     ps.serverConfig.Logger.Error(wrapError(errServerInit, err).Error())
                 }
@@ -177,6 +323,21 @@ func (ps *platformServer) StartServer(syschan chan os.Signal) {
         }
     }()
 
+    // serve HTTP/3 next to HTTPS
+    ps.startHttp3()
+
+    // answer the HTTP-01 challenges of the ACME CA
+    ps.startAcme()
+
+    // pick up rotated certificates
+    ps.startCertificateReloads()
+
+    // open the extra listeners, e.g. for admin routes
+    ps.startListeners()
+
+    // hand the sockets over to a new process on restarts
+    ps.startGracefulRestart()
+
     // spawn another goroutine to respond to syscall to shut down servers and terminate the main thread
     go func() {
         <-ps.SyscallChan
@@ -195,10 +356,23 @@ func (ps *platformServer) StartServer(syschan chan os.Signal) {
             time.Sleep(1 * time.Millisecond)
             continue
         }
-        _ = ps.eventbus.SendResponseMessage(RANCH_SERVER_ONLINE_CHANNEL, true, nil)
         break
     }
 
+    // only report being online once the dependencies needed to serve are up
+    if ok, err := ps.awaitDependencies(DependencyPhaseReady); err != nil {
+        ps.serverConfig.Logger.Error("[ranch] server will not report being online", "error", err.Error())
+    } else if ok {
+        // warm the services up while health output still reports the server as starting
+        ps.runWarmup()
+        ps.markOnline()
+        ps.startTrafficRamp()
+        _ = ps.eventbus.SendResponseMessage(RANCH_SERVER_ONLINE_CHANNEL, true, nil)
+
+        // let the process this one replaces know it can step down
+        ps.restartReady()
+    }
+
     <-connClosed
 }
 
@@ -207,8 +381,11 @@ func (ps *platformServer) StopServer() {
     ps.serverConfig.Logger.Info("[ranch] server shutting down... see you around soon, partner!")
     ps.ServerAvailability.Http = false
 
+    ps.lock.Lock()
+    shutdownTimeout := ps.serverConfig.ShutdownTimeout
+    ps.lock.Unlock()
     baseCtx := context.Background()
-    shutdownCtx, cancel := context.WithTimeout(baseCtx, ps.serverConfig.ShutdownTimeout)
+    shutdownCtx, cancel := context.WithTimeout(baseCtx, shutdownTimeout)
 
     go func() {
         select {
@@ -216,7 +393,7 @@ func (ps *platformServer) StopServer() {
             if errors.Is(shutdownCtx.Err(), context.DeadlineExceeded) {
                 ps.serverConfig.Logger.Error(
                     "server failed to gracefully shut down after timeout", "timeout",
-                    ps.serverConfig.ShutdownTimeout.String())
+                    shutdownTimeout.String())
             }
         }
     }()
@@ -240,41 +417,74 @@ func (ps *platformServer) StopServer() {
         }
     }
 
+    // log streams never end by themselves, end them so the HTTP server does not wait on them
+    ps.stopLogStreams()
+
     // start graceful shutdown
     err := ps.HttpServer.Shutdown(shutdownCtx)
     if err != nil {
         ps.serverConfig.Logger.Error(err.Error())
     }
+    ps.stopHttp3(shutdownCtx)
+    ps.stopAcme(shutdownCtx)
+    ps.stopCertificateReloads()
+    ps.stopListeners(shutdownCtx)
+    ps.stopGracefulRestart()
+
+    ps.stopBrokerBridges()
 
     if ps.fabricConn != nil {
+        // tell the clients the server is going away and send them their messages, rather than dropping
+        // their connections mid-frame
+        grace := time.Duration(ps.serverConfig.FabricConfig.DrainSeconds) * time.Second
+        if err = ps.eventbus.DrainFabricEndpoint(shutdownCtx, grace); err != nil {
+            ps.serverConfig.Logger.Error(err.Error())
+        }
         err = ps.eventbus.StopFabricEndpoint()
         if err != nil {
             ps.serverConfig.Logger.Error(err.Error())
         }
         ps.ServerAvailability.Fabric = false
     }
+    if ps.portMux != nil {
+        _ = ps.portMux.Close()
+    }
+
+    ps.stopSiemExporters()
+    ps.stopGrpcBridge()
+    ps.stopStoreBackups()
+    ps.stopLoadSignal()
+    ps.stopUsageReports()
+    ps.stopConnectionsPublishing()
+    ps.stopBridgeRouting()
+    ps.stopBridgeControl()
+    ps.stopTokenRevocations()
+    ps.stopAclReloads()
+    ps.stopEdgeCachePurges()
+    ps.stopResponseCachePurges()
+    ps.stopReplication()
+    ps.stopFederation()
+    ps.stopRelay()
+    ps.stopBackplane()
+    ps.stopStaticContentChecks()
+    ps.stopArchive()
+    ps.stopWebSub()
+    ps.stopSettings()
+    ps.stopStoreAccess()
+    ps.stopDependencyProbes()
 
     // wait for all teardown jobs to be done. if shutdown deadline arrives earlier
     // the main thread will be terminated forcefully
     wg.Wait()
+    ps.closeStorePersistence()
+    ps.stopAccessLog()
 }
 
 // SetStaticRoute adds a route where static resources will be served
 func (ps *platformServer) SetStaticRoute(prefix, fullpath string, middlewareFn ...mux.MiddlewareFunc) {
-    //ps.router.Handle(prefix, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-    //	http.Redirect(w, r, prefix+"/", http.StatusMovedPermanently)
-    //}))
-
-    ndir := NoDirFileSystem{http.Dir(fullpath)}
-    endpointHandlerMapKey := prefix + "*"
-    compositeHandler := http.StripPrefix(prefix, middleware.BasicSecurityHeaderMiddleware()(http.FileServer(ndir)))
-
-    for _, mw := range middlewareFn {
-        compositeHandler = mw(compositeHandler)
-    }
-
-    ps.endpointHandlerMap[endpointHandlerMapKey] = compositeHandler.(http.HandlerFunc)
-    ps.router.PathPrefix(prefix + "/").Name(endpointHandlerMapKey).Handler(ps.endpointHandlerMap[endpointHandlerMapKey])
+    fileServer := http.FileServer(NoDirFileSystem{http.Dir(fullpath)})
+    ps.setStaticMount(&staticMount{uri: prefix, path: fullpath, fsys: os.DirFS(fullpath)}, fileServer.ServeHTTP,
+        middlewareFn...)
 }
 
 // RegisterService registers a Fabric service with Bifrost
@@ -318,15 +528,7 @@ func (ps *platformServer) SetHttpChannelBridge(bridgeConfig *service.RESTBridgeC
     }
 
     if _, exists := ps.messageBridgeMap[bridgeConfig.ServiceChannel]; !exists {
-        handler, _ := ps.eventbus.ListenStream(bridgeConfig.ServiceChannel)
-        handler.Handle(func(message *model.Message) {
-            ps.messageBridgeMap[bridgeConfig.ServiceChannel].payloadChannel <- message
-        }, func(err error) {})
-
-        ps.messageBridgeMap[bridgeConfig.ServiceChannel] = &MessageBridge{
-            ServiceListenStream: handler,
-            payloadChannel:      make(chan *model.Message, 100),
-        }
+        ps.newMessageBridge(bridgeConfig.ServiceChannel)
     }
 
     // NOTE: mux.Router does not have mutex or any locking mechanism so it could sometimes lead to concurrency write
@@ -340,8 +542,17 @@ func (ps *platformServer) SetHttpChannelBridge(bridgeConfig *service.RESTBridgeC
     ps.endpointHandlerMap[endpointHandlerKey] = ps.buildEndpointHandler(
         bridgeConfig.ServiceChannel,
         bridgeConfig.FabricRequestBuilder,
-        ps.serverConfig.RestBridgeTimeout,
-        ps.messageBridgeMap[bridgeConfig.ServiceChannel].payloadChannel)
+        ps.restBridgeTimeout(bridgeConfig),
+        ps.messageBridgeMap[bridgeConfig.ServiceChannel],
+        ps.bridgeRequestLimits(bridgeConfig))
+    if ps.responseCache != nil && bridgeConfig.Cache != nil {
+        ps.endpointHandlerMap[endpointHandlerKey] = ps.cacheResponses(
+            bridgeConfig, ps.endpointHandlerMap[endpointHandlerKey])
+    }
+    if ps.edgeCache != nil {
+        ps.endpointHandlerMap[endpointHandlerKey] = ps.edgeCache.tagResponses(
+            bridgeConfig, false, ps.endpointHandlerMap[endpointHandlerKey])
+    }
 
     ps.serviceChanToBridgeEndpoints[bridgeConfig.ServiceChannel] = append(
         ps.serviceChanToBridgeEndpoints[bridgeConfig.ServiceChannel], endpointHandlerKey)
@@ -359,6 +570,12 @@ func (ps *platformServer) SetHttpChannelBridge(bridgeConfig *service.RESTBridgeC
         Methods(permittedMethods...).
         Name(fmt.Sprintf("%s-%s", bridgeConfig.Uri, bridgeConfig.Method)).
         Handler(ps.endpointHandlerMap[endpointHandlerKey])
+    if ps.cors != nil {
+        ps.cors.addBridge(endpointHandlerKey)
+    }
+    if ps.apiDocs != nil {
+        ps.apiDocs.addBridge(endpointHandlerKey, bridgeConfig, false)
+    }
     //if !atomic.CompareAndSwapInt32(ps.routerConcurrencyProtection, 1, 0) {
     //	panic("Concurrency write on router detected when running ")
     //}
@@ -368,6 +585,14 @@ func (ps *platformServer) SetHttpChannelBridge(bridgeConfig *service.RESTBridgeC
         "channel", bridgeConfig.ServiceChannel, "url", bridgeConfig.Uri, "method", bridgeConfig.Method)
 }
 
+// restBridgeTimeout returns how long the requests of a REST bridge wait for the service to respond.
+func (ps *platformServer) restBridgeTimeout(bridgeConfig *service.RESTBridgeConfig) time.Duration {
+    if bridgeConfig.Timeout > 0 {
+        return bridgeConfig.Timeout
+    }
+    return ps.serverConfig.RestBridgeTimeout
+}
+
 // SetHttpPathPrefixChannelBridge establishes a conduit between the transport service channel and a path prefix
 // every request on this prefix will be sent through to the target service, all methods, all sub paths, lock, stock and barrel.
 func (ps *platformServer) SetHttpPathPrefixChannelBridge(bridgeConfig *service.RESTBridgeConfig) {
@@ -388,23 +613,24 @@ func (ps *platformServer) SetHttpPathPrefixChannelBridge(bridgeConfig *service.R
     }
 
     if _, exists := ps.messageBridgeMap[bridgeConfig.ServiceChannel]; !exists {
-        handler, _ := ps.eventbus.ListenStream(bridgeConfig.ServiceChannel)
-        handler.Handle(func(message *model.Message) {
-            ps.messageBridgeMap[bridgeConfig.ServiceChannel].payloadChannel <- message
-        }, func(err error) {})
-
-        ps.messageBridgeMap[bridgeConfig.ServiceChannel] = &MessageBridge{
-            ServiceListenStream: handler,
-            payloadChannel:      make(chan *model.Message, 100),
-        }
+        ps.newMessageBridge(bridgeConfig.ServiceChannel)
     }
 
     // build endpoint handler
     ps.endpointHandlerMap[endpointHandlerKey] = ps.buildEndpointHandler(
         bridgeConfig.ServiceChannel,
         bridgeConfig.FabricRequestBuilder,
-        ps.serverConfig.RestBridgeTimeout,
-        ps.messageBridgeMap[bridgeConfig.ServiceChannel].payloadChannel)
+        ps.restBridgeTimeout(bridgeConfig),
+        ps.messageBridgeMap[bridgeConfig.ServiceChannel],
+        ps.bridgeRequestLimits(bridgeConfig))
+    if ps.responseCache != nil && bridgeConfig.Cache != nil {
+        ps.endpointHandlerMap[endpointHandlerKey] = ps.cacheResponses(
+            bridgeConfig, ps.endpointHandlerMap[endpointHandlerKey])
+    }
+    if ps.edgeCache != nil {
+        ps.endpointHandlerMap[endpointHandlerKey] = ps.edgeCache.tagResponses(
+            bridgeConfig, true, ps.endpointHandlerMap[endpointHandlerKey])
+    }
 
     ps.serviceChanToBridgeEndpoints[bridgeConfig.ServiceChannel] = append(
         ps.serviceChanToBridgeEndpoints[bridgeConfig.ServiceChannel], endpointHandlerKey)
@@ -421,6 +647,12 @@ func (ps *platformServer) SetHttpPathPrefixChannelBridge(bridgeConfig *service.R
     if !atomic.CompareAndSwapInt32(ps.routerConcurrencyProtection, 1, 0) {
         panic("Concurrency write on router detected when running SetHttpPathPrefixChannelBridge()")
     }
+    if ps.cors != nil {
+        ps.cors.addBridge(endpointHandlerKey)
+    }
+    if ps.apiDocs != nil {
+        ps.apiDocs.addBridge(endpointHandlerKey, bridgeConfig, true)
+    }
 
     ps.serverConfig.Logger.Info(
         "[ranch] Service channel is now bridged to a REST path prefix",
@@ -447,10 +679,55 @@ func (c *platformServer) CustomizeTLSConfig(tls *tls.Config) error {
     if c.ServerAvailability.Http || c.ServerAvailability.Fabric {
         return fmt.Errorf("TLS configuration can be provided only if the server is not running")
     }
+    if c.serverConfig.TLSCertConfig != nil && c.serverConfig.TLSCertConfig.FIPSMode {
+        if err := validateFIPSTLSConfig(tls); err != nil {
+            return err
+        }
+    }
+    if tls != nil && tls.GetCertificate == nil && len(tls.Certificates) == 0 && c.HttpServer.TLSConfig != nil {
+        // keep serving the rotated or ACME certificates
+        tls.GetCertificate = c.HttpServer.TLSConfig.GetCertificate
+    }
     c.HttpServer.TLSConfig = tls
     return nil
 }
 
+// UnsetHttpChannelBridge removes the REST bridge at uri for method while the server runs, leaving the other
+// bridges of its service channel, and every other route, in place.
+func (ps *platformServer) UnsetHttpChannelBridge(uri, method string) error {
+    return ps.unsetHttpBridge(uri + "-" + method)
+}
+
+// UnsetHttpPathPrefixChannelBridge removes the REST bridge of a path prefix while the server runs, see
+// UnsetHttpChannelBridge.
+func (ps *platformServer) UnsetHttpPathPrefixChannelBridge(uri string) error {
+    return ps.unsetHttpBridge(uri + "-" + AllMethodsWildcard)
+}
+
+// unsetHttpBridge removes the REST bridge of a route from a new router, which then replaces the current one.
+func (ps *platformServer) unsetHttpBridge(endpointHandlerKey string) error {
+    ps.lock.Lock()
+    channel, found := "", false
+    for serviceChannel, keys := range ps.serviceChanToBridgeEndpoints {
+        if idx := slices.Index(keys, endpointHandlerKey); idx >= 0 {
+            ps.serviceChanToBridgeEndpoints[serviceChannel] = slices.Delete(keys, idx, idx+1)
+            channel, found = serviceChannel, true
+            break
+        }
+    }
+    if !found {
+        ps.lock.Unlock()
+        return fmt.Errorf("no REST bridge exists at %s", endpointHandlerKey)
+    }
+    newRouter := ps.rebuildRouterWithout(map[string]bool{endpointHandlerKey: true})
+    ps.removeBridgeHandler(endpointHandlerKey, channel)
+    ps.releaseMessageBridge(channel)
+    ps.lock.Unlock()
+
+    ps.loadGlobalHttpHandler(newRouter)
+    return nil
+}
+
 // clearHttpChannelBridgesForService takes serviceChannel, gets all mux.Route instances associated with
 // the service and removes them while keeping the rest of the routes intact. returns the pointer
 // of a new instance of mux.Router.
@@ -458,41 +735,69 @@ func (ps *platformServer) clearHttpChannelBridgesForService(serviceChannel strin
     ps.lock.Lock()
     defer ps.lock.Unlock()
 
+    lookupMap := make(map[string]bool)
+    for _, key := range ps.serviceChanToBridgeEndpoints[serviceChannel] {
+        lookupMap[key] = true
+    }
+    newRouter := ps.rebuildRouterWithout(lookupMap)
+
+    // if in override mode delete existing mappings associated with the service
+    existingMappings := ps.serviceChanToBridgeEndpoints[serviceChannel]
+    ps.serviceChanToBridgeEndpoints[serviceChannel] = make([]string, 0)
+    for _, handlerKey := range existingMappings {
+        ps.removeBridgeHandler(handlerKey, serviceChannel)
+    }
+    ps.releaseMessageBridge(serviceChannel)
+    return newRouter
+}
+
+// rebuildRouterWithout returns a new router with every route of the current one, except those named in
+// removed. callers hold ps.lock.
+func (ps *platformServer) rebuildRouterWithout(removed map[string]bool) *mux.Router {
     // NOTE: gorilla mux doesn't allow us to mutate routes field of the Router struct which is critical in rerouting incoming
     // requests to the new route. there is not a public API that allows us to do it so we're instead creating a new instance of
     // Router and assigning the existing config and route. this means `ps.route` is treated as immutable and will be
     // replaced with a new instance of mux.Router by the operation performed in this function
 
-    // walk over existing routes and store them temporarily EXCEPT the ones that are being overwritten which can
-    // be tracked by the service channel
+    // walk over existing routes and store them temporarily EXCEPT the ones that are being removed
     newRouter := mux.NewRouter().Schemes("http", "https").Subrouter()
-    lookupMap := make(map[string]bool)
-    for _, key := range ps.serviceChanToBridgeEndpoints[serviceChannel] {
-        lookupMap[key] = true
-    }
-
     ps.router.Walk(func(r *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
         name := r.GetName()
         path, _ := r.GetPathTemplate()
         handler := r.GetHandler()
         methods, _ := r.GetMethods()
-        // do not want to copy over the routes that will be overridden
-        if lookupMap[name] {
-            ps.serverConfig.Logger.Debug("[ranch] route will be overridden so not copying over to the new router instance", "route", name)
+        // do not want to copy over the routes that will be removed
+        if removed[name] {
+            ps.serverConfig.Logger.Debug("[ranch] route will be removed so not copying over to the new router instance", "route", name)
+            return nil
+        }
+        route := newRouter.Name(name)
+        // path prefixes (static content, prefix bridges) match every path below them, and are copied as such
+        if pattern, err := r.GetPathRegexp(); err == nil && !strings.HasSuffix(pattern, "$") {
+            route.PathPrefix(path)
         } else {
-            newRouter.Name(name).Path(path).Methods(methods...).Handler(handler)
+            route.Path(path)
+        }
+        if len(methods) > 0 {
+            route.Methods(methods...)
         }
+        route.Handler(handler)
         return nil
     })
+    return newRouter
+}
 
-    // if in override mode delete existing mappings associated with the service
-    existingMappings := ps.serviceChanToBridgeEndpoints[serviceChannel]
-    ps.serviceChanToBridgeEndpoints[serviceChannel] = make([]string, 0)
-    for _, handlerKey := range existingMappings {
-        ps.serverConfig.Logger.Info("[ranch] Removing existing service - REST mapping", "key", handlerKey, "channel", serviceChannel)
-        delete(ps.endpointHandlerMap, handlerKey)
+// removeBridgeHandler forgets the handler of a REST bridge of a service channel, once its route is gone.
+// callers hold ps.lock.
+func (ps *platformServer) removeBridgeHandler(handlerKey string, serviceChannel string) {
+    ps.serverConfig.Logger.Info("[ranch] Removing existing service - REST mapping", "key", handlerKey, "channel", serviceChannel)
+    delete(ps.endpointHandlerMap, handlerKey)
+    if ps.cors != nil {
+        ps.cors.removeBridge(handlerKey)
+    }
+    if ps.apiDocs != nil {
+        ps.apiDocs.removeBridge(handlerKey)
     }
-    return newRouter
 }
 
 func (ps *platformServer) getSubRoute(name string) (*mux.Route, error) {
@@ -507,11 +812,134 @@ func (ps *platformServer) loadGlobalHttpHandler(h *mux.Router) {
     ps.lock.Lock()
     defer ps.lock.Unlock()
     ps.router = h
-    ps.HttpServer.Handler = handlers.RecoveryHandler()(
-        handlers.CompressHandler(
-            handlers.ProxyHeaders(ps.router)))
-    //handlers.CombinedLoggingHandler(
-    //	ps.serverConfig.LogConfig.GetAccessLogFilePointer(), ps.router)))
+    var handler http.Handler = ps.router
+    if ps.acl != nil {
+        handler = ps.aclMiddleware(handler)
+    }
+    if ps.revocations != nil {
+        handler = ps.revocationMiddleware(handler)
+    }
+    if ps.cors != nil {
+        handler = ps.cors.middleware(ps.router, handler)
+    }
+    if ps.serverConfig.AbuseGuard != nil {
+        handler = ps.serverConfig.AbuseGuard.HttpMiddleware()(handler)
+    }
+    if ps.trafficRamp != nil {
+        handler = ps.trafficRamp.middleware(handler)
+    }
+    if ps.loadSignal != nil {
+        handler = ps.loadSignalMiddleware(handler)
+    }
+    if ps.metrics != nil {
+        handler = ps.metricsMiddleware(handler)
+    }
+    if ps.classification != nil {
+        handler = ps.classificationMiddleware(handler)
+    }
+    if ps.serverConfig.RequestLogging != nil {
+        handler = ps.requestLoggingMiddleware(handler)
+    }
+    if ps.accessLog != nil {
+        handler = ps.accessLogMiddleware(handler)
+    }
+    if len(ps.serverConfig.Listeners) > 0 {
+        handler = ps.listenerRoutesMiddleware(handler)
+    }
+    // the trusted proxy is recognised by the connection, before the forwarded headers replace its address
+    handler = handlers.ProxyHeaders(handler)
+    if ps.trustedHeaders != nil {
+        handler = ps.trustedHeaders.middleware(handler)
+    }
+    if ps.serverConfig.AbuseGuard != nil {
+        // the guard keys clients on the connection, forwarded headers can be spoofed
+        handler = abuse.ConnectionAddrMiddleware(handler)
+    }
+    ps.HttpServer.Handler = ps.withProtocols(handlers.RecoveryHandler()(
+        handlers.CompressHandler(stompserver.PortMuxTLSHandler(handler))))
+}
+
+// validateTLSCompliance checks the TLS configuration and certificate are compliant when FIPS mode is enabled.
+func (ps *platformServer) validateTLSCompliance() error {
+    if ps.serverConfig.TLSCertConfig == nil || !ps.serverConfig.TLSCertConfig.FIPSMode {
+        return nil
+    }
+    if err := validateFIPSTLSConfig(ps.HttpServer.TLSConfig); err != nil {
+        return err
+    }
+    if ps.serverConfig.TLSCertConfig.ACME != nil {
+        // certificates are issued later on, for P-256 ECDSA or 2048 bit RSA keys which are both vetted
+        return nil
+    }
+    return validateFIPSCertificate(ps.serverConfig.TLSCertConfig.CertFile, ps.serverConfig.TLSCertConfig.KeyFile)
+}
+
+// initAbuseGuard publishes every event raised by the abuse guard on RANCH_ABUSE_EVENT_CHANNEL, so
+// they can be picked up by auditing and SIEM exporters.
+func (ps *platformServer) initAbuseGuard() {
+    guard := ps.serverConfig.AbuseGuard
+    if guard == nil {
+        return
+    }
+    ps.eventbus.GetChannelManager().CreateChannel(RANCH_ABUSE_EVENT_CHANNEL)
+    guard.AddEventHandler(func(evt *abuse.Event) {
+        if evt.Type != abuse.EventRejected {
+            ps.serverConfig.Logger.Warn("[ranch] abuse guard enforcement", "type", evt.Type, "key", evt.Key,
+                "detector", evt.Detector, "reason", evt.Reason, "until", evt.Until)
+        }
+        _ = ps.eventbus.SendResponseMessage(RANCH_ABUSE_EVENT_CHANNEL, evt, nil)
+    })
+}
+
+// withAbuseGuardMiddleware returns a copy of the registry with the abuse guard at the front of the
+// global STOMP middleware chain.
+func withAbuseGuardMiddleware(registry stompserver.MiddlewareRegistry, guard *abuse.Guard) stompserver.MiddlewareRegistry {
+    return registry.Prepend("*", guard.StompMiddleware())
+}
+
+// startBrokerBridges connects every configured broker bridge in the background, as brokers may not be
+// reachable yet and connecting will keep retrying.
+func (ps *platformServer) startBrokerBridges() {
+    ps.lock.Lock()
+    defer ps.lock.Unlock()
+    for _, bridgeConfig := range ps.serverConfig.BrokerBridges {
+        bb := newBrokerBridge(bridgeConfig, ps.eventbus, ps.serverConfig.Logger)
+        ps.brokerBridges = append(ps.brokerBridges, bb)
+        go func() {
+            if err := bb.start(); err != nil {
+                ps.serverConfig.Logger.Error(wrapError(errServerInit, err).Error())
+            }
+        }()
+    }
+}
+
+// stopBrokerBridges disconnects all broker bridges and returns bridged channels to being local.
+func (ps *platformServer) stopBrokerBridges() {
+    ps.lock.Lock()
+    bridges := ps.brokerBridges
+    ps.brokerBridges = nil
+    ps.lock.Unlock()
+    for _, bb := range bridges {
+        bb.stop()
+    }
+}
+
+// listenAndServe serves HTTP, or HTTPS if configured, on the server port. When the port is shared with raw
+// TCP STOMP clients, connections are handed to the HTTP server once they are known to speak HTTP.
+func (ps *platformServer) listenAndServe() error {
+    listener, err := ps.listen("http", ps.HttpServer.Addr)
+    if err != nil {
+        return err
+    }
+    if ps.portMux == nil {
+        if ps.serverConfig.TLSCertConfig != nil {
+            certFile, keyFile := ps.certFiles()
+            return ps.HttpServer.ServeTLS(listener, certFile, keyFile)
+        }
+        return ps.HttpServer.Serve(listener)
+    }
+    go ps.portMux.Serve(listener)
+    return ps.portMux.ServeHttp(ps.HttpServer)
 }
 
 func (ps *platformServer) checkPortAvailability() {
@@ -530,3 +958,133 @@ func (ps *platformServer) setEventBusRef(evtBus bus.EventBus) {
     ps.eventbus = evtBus
     ps.lock.Unlock()
 }
+
+// siemEventChannels are the channels carrying audit and security events that are shipped to SIEM exporters.
+// Services can publish their own *siem.Event payloads on RANCH_AUDIT_EVENT_CHANNEL.
+var siemEventChannels = []string{RANCH_ABUSE_EVENT_CHANNEL, RANCH_AUDIT_EVENT_CHANNEL, bus.TOKEN_REVOCATION_CHANNEL}
+
+// startSiemExporters starts every configured SIEM exporter and feeds it the events published on the audit
+// and security channels.
+func (ps *platformServer) startSiemExporters() {
+    if len(ps.serverConfig.SiemExporters) == 0 {
+        return
+    }
+    ps.lock.Lock()
+    defer ps.lock.Unlock()
+    for _, exporterConfig := range ps.serverConfig.SiemExporters {
+        cfg := *exporterConfig
+        if cfg.Logger == nil {
+            cfg.Logger = ps.serverConfig.Logger
+        }
+        exporter, err := siem.NewExporter(&cfg)
+        if err != nil {
+            ps.serverConfig.Logger.Error(wrapError(errServerInit, err).Error())
+            continue
+        }
+        exporter.Start()
+        ps.siemExporters = append(ps.siemExporters, exporter)
+    }
+    if len(ps.siemExporters) == 0 {
+        return
+    }
+
+    exporters := ps.siemExporters
+    cm := ps.eventbus.GetChannelManager()
+    for _, channel := range siemEventChannels {
+        if !cm.CheckChannelExists(channel) {
+            cm.CreateChannel(channel)
+        }
+        handler, err := ps.eventbus.ListenFirehose(channel)
+        if err != nil {
+            ps.serverConfig.Logger.Error(wrapError(errServerInit, err).Error())
+            continue
+        }
+        handler.Handle(func(msg *model.Message) {
+            evt := siem.FromPayload(msg.Payload)
+            if evt == nil {
+                return
+            }
+            for _, exporter := range exporters {
+                exporter.Export(evt)
+            }
+        }, func(err error) {})
+        ps.siemHandlers = append(ps.siemHandlers, handler)
+    }
+}
+
+// stopSiemExporters stops listening for events, then sends or spools whatever the exporters have queued.
+func (ps *platformServer) stopSiemExporters() {
+    ps.lock.Lock()
+    handlers, exporters := ps.siemHandlers, ps.siemExporters
+    ps.siemHandlers, ps.siemExporters = nil, nil
+    ps.lock.Unlock()
+    for _, handler := range handlers {
+        handler.Close()
+    }
+    for _, exporter := range exporters {
+        exporter.Stop()
+    }
+}
+
+// startGrpcBridge serves the gRPC bridge on its own port, with TLS if the HTTP server uses TLS and over
+// cleartext HTTP/2 otherwise.
+func (ps *platformServer) startGrpcBridge() {
+    cfg := ps.serverConfig.GrpcBridge
+    if cfg == nil {
+        return
+    }
+    bridgeConfig := &grpcbridge.Config{
+        Channels:        cfg.Channels,
+        MaxMessageBytes: cfg.MaxMessageBytes,
+        ResponseTimeout: time.Duration(cfg.ResponseTimeoutSeconds) * time.Second,
+        Logger:          ps.serverConfig.Logger,
+    }
+    gb := grpcbridge.NewBridge(ps.eventbus, bridgeConfig)
+    var handler http.Handler = gb
+    if ps.serverConfig.AbuseGuard != nil {
+        handler = ps.serverConfig.AbuseGuard.HttpMiddleware()(handler)
+    }
+    srv := &http.Server{Addr: fmt.Sprintf(":%d", cfg.Port), Handler: handler}
+
+    ps.lock.Lock()
+    ps.grpcBridge, ps.grpcServer = gb, srv
+    ps.lock.Unlock()
+
+    go func() {
+        listener, err := ps.listen("grpc", srv.Addr)
+        if err != nil {
+            ps.serverConfig.Logger.Error(wrapError(errServerInit, err).Error())
+            return
+        }
+        if ps.serverConfig.TLSCertConfig != nil {
+            if ps.HttpServer.TLSConfig != nil {
+                srv.TLSConfig = ps.HttpServer.TLSConfig.Clone()
+            }
+            ps.serverConfig.Logger.Info("[ranch] starting up the ranch's gRPC bridge with TLS", "port", cfg.Port,
+                "channels", cfg.Channels)
+            certFile, keyFile := ps.certFiles()
+            err = srv.ServeTLS(listener, certFile, keyFile)
+        } else {
+            srv.Handler = h2c.NewHandler(handler, &http2.Server{})
+            ps.serverConfig.Logger.Info("[ranch] starting up the ranch's gRPC bridge", "port", cfg.Port,
+                "channels", cfg.Channels)
+            err = srv.Serve(listener)
+        }
+        if err != nil && !errors.Is(err, http.ErrServerClosed) {
+            ps.serverConfig.Logger.Error(wrapError(errServerInit, err).Error())
+        }
+    }()
+}
+
+// stopGrpcBridge ends every open gRPC stream and stops the gRPC server.
+func (ps *platformServer) stopGrpcBridge() {
+    ps.lock.Lock()
+    gb, srv := ps.grpcBridge, ps.grpcServer
+    ps.grpcBridge, ps.grpcServer = nil, nil
+    ps.lock.Unlock()
+    if gb == nil {
+        return
+    }
+    gb.Close()
+    _ = srv.Close()
+}
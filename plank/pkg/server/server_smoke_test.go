@@ -3,14 +3,19 @@ package server
 import (
     "crypto/tls"
     "fmt"
+    "github.com/gorilla/websocket"
     "github.com/pb33f/ranch/bus"
     "github.com/pb33f/ranch/service"
+    "github.com/pb33f/ranch/stompserver"
     "github.com/stretchr/testify/assert"
+    "io"
+    "net"
     "net/http"
     "os"
     "path/filepath"
     "sync"
     "testing"
+    "time"
 )
 
 // TestSmokeTests_TLS tests if Plank starts with TLS enabled
@@ -110,6 +115,96 @@ func TestSmokeTests(t *testing.T) {
     wg.Wait()
 }
 
+func TestSmokeTests_Mqtt(t *testing.T) {
+    newBus := bus.ResetBus()
+    service.ResetServiceRegistry()
+    testRoot := filepath.Join(os.TempDir(), "plank-tests")
+    _ = os.MkdirAll(testRoot, 0755)
+    defer os.RemoveAll(testRoot)
+
+    l, err := net.Listen("tcp", "127.0.0.1:0")
+    assert.Nil(t, err)
+    mqttPort := l.Addr().(*net.TCPAddr).Port
+    _ = l.Close()
+
+    port := GetTestPort()
+    cfg := GetBasicTestServerConfig(testRoot, "stdout", "stdout", "stderr", port, true)
+    cfg.FabricConfig = GetTestFabricBrokerConfig()
+    cfg.FabricConfig.MqttPort = mqttPort
+
+    _, _, testServer := CreateTestServer(cfg)
+    testServer.(*platformServer).eventbus = newBus
+
+    syschan := make(chan os.Signal, 1)
+    wg := sync.WaitGroup{}
+    wg.Add(1)
+    go testServer.StartServer(syschan)
+    RunWhenServerReady(t, newBus, func(t *testing.T) {
+        conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", mqttPort))
+        if assert.Nil(t, err) {
+            // MQTT 3.1.1 CONNECT with a clean session, 30s keep alive and client id "c1"
+            _, err = conn.Write([]byte{0x10, 14, 0, 4, 'M', 'Q', 'T', 'T', 4, 0x02, 0, 30, 0, 2, 'c', '1'})
+            assert.Nil(t, err)
+
+            connack := make([]byte, 4)
+            _ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+            _, err = io.ReadFull(conn, connack)
+            assert.Nil(t, err)
+            assert.Equal(t, []byte{0x20, 2, 0, 0}, connack)
+            _ = conn.Close()
+        }
+
+        testServer.StopServer()
+        wg.Done()
+    })
+    wg.Wait()
+}
+
+func TestSmokeTests_JsonWebSocket(t *testing.T) {
+    newBus := bus.ResetBus()
+    service.ResetServiceRegistry()
+    testRoot := filepath.Join(os.TempDir(), "plank-tests")
+    _ = os.MkdirAll(testRoot, 0755)
+    defer os.RemoveAll(testRoot)
+
+    port := GetTestPort()
+    cfg := GetBasicTestServerConfig(testRoot, "stdout", "stdout", "stderr", port, true)
+    cfg.FabricConfig = GetTestFabricBrokerConfig()
+    cfg.FabricConfig.JsonWebSocketEndpoint = "/ws-json"
+
+    _, _, testServer := CreateTestServer(cfg)
+    testServer.(*platformServer).eventbus = newBus
+
+    syschan := make(chan os.Signal, 1)
+    wg := sync.WaitGroup{}
+    wg.Add(1)
+    go testServer.StartServer(syschan)
+    RunWhenServerReady(t, newBus, func(t *testing.T) {
+        conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://127.0.0.1:%d/ws-json", port), nil)
+        if assert.Nil(t, err) {
+            var f stompserver.JsonFrame
+            _ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+            assert.Nil(t, conn.WriteJSON(&stompserver.JsonFrame{Type: stompserver.JsonFrameSubscribe,
+                Channel: "smoke", Receipt: "s-1"}))
+            assert.Nil(t, conn.ReadJSON(&f))
+            assert.Equal(t, stompserver.JsonFrameConnected, f.Type)
+            assert.Nil(t, conn.ReadJSON(&f))
+            assert.Equal(t, "s-1", f.Receipt)
+
+            _ = newBus.SendResponseMessage("smoke", map[string]string{"hello": "there"}, nil)
+            assert.Nil(t, conn.ReadJSON(&f))
+            assert.Equal(t, stompserver.JsonFrameMessage, f.Type)
+            assert.Equal(t, "smoke", f.Channel)
+            assert.Contains(t, string(f.Payload), "there")
+            _ = conn.Close()
+        }
+
+        testServer.StopServer()
+        wg.Done()
+    })
+    wg.Wait()
+}
+
 func TestSmokeTests_NoFabric(t *testing.T) {
     newBus := bus.ResetBus()
     service.ResetServiceRegistry()
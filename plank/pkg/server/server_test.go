@@ -4,21 +4,42 @@
 package server
 
 import (
+	"archive/zip"
+	"bytes"
 	"context"
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
+	"github.com/go-stomp/stomp/v3"
 	"github.com/google/uuid"
+	"github.com/gorilla/mux"
 	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/log"
 	"github.com/pb33f/ranch/model"
+	"github.com/pb33f/ranch/plank/pkg/abuse"
+	"github.com/pb33f/ranch/plank/pkg/grpcbridge"
+	"github.com/pb33f/ranch/plank/pkg/siem"
 	"github.com/pb33f/ranch/plank/services"
 	"github.com/pb33f/ranch/service"
+	"github.com/pb33f/ranch/stompserver"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"golang.org/x/net/context/ctxhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"io"
 	"io/ioutil"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestNewPlatformServer(t *testing.T) {
@@ -30,6 +51,37 @@ func TestNewPlatformServer(t *testing.T) {
 	assert.NotNil(t, ps)
 }
 
+type testLogAdapter struct {
+	messages []string
+	lock     sync.Mutex
+}
+
+func (a *testLogAdapter) Enabled(level slog.Level) bool {
+	return level >= slog.LevelInfo
+}
+
+func (a *testLogAdapter) Log(level slog.Level, msg string, fields map[string]any) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.messages = append(a.messages, msg)
+}
+
+func TestNewPlatformServer_LogAdapter(t *testing.T) {
+	bus.ResetBus()
+	service.ResetServiceRegistry()
+	port := GetTestPort()
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", port, true)
+	adapter := &testLogAdapter{}
+	config.LogAdapter = adapter
+	NewPlatformServer(config)
+	defer log.SetLogger(nil)
+
+	// subsystems log through the configured logger too.
+	assert.Equal(t, config.Logger, log.Logger())
+	log.Logger().Info("[ranch] moo")
+	assert.Contains(t, adapter.messages, "[ranch] moo")
+}
+
 func TestNewPlatformServer_EmptyRootDir(t *testing.T) {
 	bus.ResetBus()
 	service.ResetServiceRegistry()
@@ -138,6 +190,45 @@ func TestPlatformServer_SetHttpPathPrefixChannelBridge(t *testing.T) {
 	wg.Wait()
 }
 
+func TestPlatformServer_UnsetHttpChannelBridge(t *testing.T) {
+	newBus := bus.ResetBus()
+	service.ResetServiceRegistry()
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", GetTestPort(), true)
+	ps := NewPlatformServer(config).(*platformServer)
+	ps.eventbus = newBus
+	newBus.GetChannelManager().CreateChannel("cows")
+	requestBuilder := func(w http.ResponseWriter, r *http.Request) model.Request {
+		return model.Request{RequestCommand: "moo"}
+	}
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		ps.SetHttpChannelBridge(&service.RESTBridgeConfig{ServiceChannel: "cows", Uri: "/cows", Method: method,
+			FabricRequestBuilder: requestBuilder})
+	}
+	ps.SetHttpPathPrefixChannelBridge(&service.RESTBridgeConfig{ServiceChannel: "cows", Uri: "/barn",
+		FabricRequestBuilder: requestBuilder})
+	ps.SetStaticRoute("/hay", t.TempDir())
+	routed := func(method string, path string) bool {
+		var match mux.RouteMatch
+		return ps.router.Match(httptest.NewRequest(method, "http://localhost"+path, nil), &match)
+	}
+	require.True(t, routed(http.MethodGet, "/cows"))
+
+	// the bridge goes, the other routes stay, path prefixes included
+	require.NoError(t, ps.UnsetHttpChannelBridge("/cows", http.MethodGet))
+	assert.False(t, routed(http.MethodGet, "/cows"))
+	assert.True(t, routed(http.MethodPost, "/cows"))
+	assert.True(t, routed(http.MethodDelete, "/barn/stalls/1"))
+	assert.True(t, routed(http.MethodGet, "/hay/bales.txt"))
+	assert.Equal(t, []string{"/cows-POST", "/barn-*"}, ps.serviceChanToBridgeEndpoints["cows"])
+	assert.Error(t, ps.UnsetHttpChannelBridge("/cows", http.MethodGet))
+
+	require.NoError(t, ps.UnsetHttpPathPrefixChannelBridge("/barn"))
+	assert.False(t, routed(http.MethodDelete, "/barn/stalls/1"))
+	assert.True(t, routed(http.MethodPost, "/cows"))
+	assert.Equal(t, []string{"/cows-POST"}, ps.serviceChanToBridgeEndpoints["cows"])
+	assert.NotContains(t, ps.endpointHandlerMap, "/barn-*")
+}
+
 func TestPlatformServer_SetHttpChannelBridge(t *testing.T) {
 	newBus := bus.ResetBus()
 	service.ResetServiceRegistry()
@@ -166,6 +257,35 @@ func TestPlatformServer_SetHttpChannelBridge(t *testing.T) {
 	wg.Wait()
 }
 
+func TestPlatformServer_RestBridgeTimeout(t *testing.T) {
+	newBus := bus.ResetBus()
+	service.ResetServiceRegistry()
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", GetTestPort(), true)
+	ps := NewPlatformServer(config).(*platformServer)
+	ps.eventbus = newBus
+	newBus.GetChannelManager().CreateChannel("reports")
+
+	requestBuilder := func(w http.ResponseWriter, r *http.Request) model.Request {
+		return model.Request{RequestCommand: "report"}
+	}
+	// nobody answers on the channel, so requests wait for as long as their bridge allows
+	ps.SetHttpChannelBridge(&service.RESTBridgeConfig{ServiceChannel: "reports", Uri: "/reports",
+		Method: http.MethodGet, FabricRequestBuilder: requestBuilder, Timeout: 10 * time.Millisecond})
+	ps.SetHttpPathPrefixChannelBridge(&service.RESTBridgeConfig{ServiceChannel: "reports", Uri: "/archive",
+		FabricRequestBuilder: requestBuilder, Timeout: 20 * time.Millisecond})
+
+	for path, timeout := range map[string]string{"/reports": "10ms", "/archive/2023": "20ms"} {
+		rec := httptest.NewRecorder()
+		ps.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://localhost"+path, nil))
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		assert.Contains(t, rec.Body.String(), timeout)
+	}
+
+	// bridges without a timeout use the one of the server
+	assert.Equal(t, config.RestBridgeTimeout, ps.restBridgeTimeout(&service.RESTBridgeConfig{}))
+	assert.Equal(t, time.Hour, ps.restBridgeTimeout(&service.RESTBridgeConfig{Timeout: time.Hour}))
+}
+
 func TestPlatformServer_UnknownRequest(t *testing.T) {
 	newBus := bus.ResetBus()
 	service.ResetServiceRegistry()
@@ -214,3 +334,790 @@ func setupBridge(ps PlatformServer, endpoint, method, channel, request string) {
 	}
 	ps.SetHttpChannelBridge(bridgeConfig)
 }
+
+func TestPlatformServer_AbuseGuard(t *testing.T) {
+	newBus := bus.ResetBus()
+	service.ResetServiceRegistry()
+	port := GetTestPort()
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", port, true)
+	config.AbuseGuard = abuse.NewGuard(nil, abuse.NewBurstDetector(1, time.Minute, abuse.ActionBan))
+	ps := NewPlatformServer(config)
+	ps.(*platformServer).eventbus = newBus
+
+	events := make(chan *abuse.Event, 2)
+	handler, err := newBus.ListenStream(RANCH_ABUSE_EVENT_CHANNEL)
+	assert.Nil(t, err)
+	handler.Handle(func(message *model.Message) {
+		events <- message.Payload.(*abuse.Event)
+	}, func(err error) {})
+
+	syschan := make(chan os.Signal, 1)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go ps.StartServer(syschan)
+	RunWhenServerReady(t, newBus, func(t2 *testing.T) {
+		url := fmt.Sprintf("http://localhost:%d", port)
+		rsp, err := http.Get(url)
+		assert.Nil(t, err)
+		assert.Equal(t, 404, rsp.StatusCode)
+
+		// second request trips the burst detector, the third is turned away, forwarded address or not.
+		_, _ = http.Get(url)
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		req.Header.Set("X-Forwarded-For", "203.0.113.7")
+		rsp, err = http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusForbidden, rsp.StatusCode)
+
+		evt := <-events
+		assert.Equal(t, abuse.EventBanned, evt.Type)
+		ps.StopServer()
+		wg.Done()
+	})
+	wg.Wait()
+
+	registry := withAbuseGuardMiddleware(stompserver.MiddlewareRegistry{}, config.AbuseGuard)
+	assert.Len(t, registry["*"], 1)
+}
+
+func TestPlatformServer_SiemExporters(t *testing.T) {
+	collector, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer collector.Close()
+
+	newBus := bus.ResetBus()
+	service.ResetServiceRegistry()
+	port := GetTestPort()
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", port, true)
+	config.SiemExporters = []*siem.ExporterConfig{{
+		Network:   siem.NetworkUDP,
+		Address:   collector.LocalAddr().String(),
+		Format:    siem.FormatCEF,
+		BatchSize: 1,
+	}}
+	ps := NewPlatformServer(config)
+	ps.(*platformServer).eventbus = newBus
+
+	syschan := make(chan os.Signal, 1)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go ps.StartServer(syschan)
+	RunWhenServerReady(t, newBus, func(t2 *testing.T) {
+		assert.Nil(t, newBus.SendResponseMessage(RANCH_AUDIT_EVENT_CHANNEL,
+			&siem.Event{Class: "audit.login", Name: "Login", User: "alice"}, nil))
+		assert.Nil(t, bus.RevokeSessionToken(newBus, &bus.TokenRevocation{Token: "secret-token", Reason: "logout"}))
+		// not an audit event, ignored.
+		assert.Nil(t, newBus.SendResponseMessage(RANCH_AUDIT_EVENT_CHANNEL, "hello", nil))
+
+		var received []string
+		buf := make([]byte, 2048)
+		_ = collector.SetReadDeadline(time.Now().Add(2 * time.Second))
+		for len(received) < 2 {
+			n, _, err := collector.ReadFrom(buf)
+			if !assert.Nil(t, err) {
+				break
+			}
+			received = append(received, string(buf[:n]))
+		}
+		all := strings.Join(received, "\n")
+		assert.Contains(t, all, "|audit.login|Login|")
+		assert.Contains(t, all, "|session.revoked|Session token revoked|")
+		assert.NotContains(t, all, "secret-token")
+		ps.StopServer()
+		wg.Done()
+	})
+	wg.Wait()
+}
+
+func readDiagnosticsBundle(t *testing.T, data []byte) map[string]string {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if !assert.Nil(t, err) {
+		return nil
+	}
+	files := make(map[string]string)
+	for _, f := range reader.File {
+		rc, err := f.Open()
+		assert.Nil(t, err)
+		content, _ := io.ReadAll(rc)
+		_ = rc.Close()
+		files[f.Name] = string(content)
+	}
+	return files
+}
+
+func TestPlatformServer_WriteDiagnosticsBundle(t *testing.T) {
+	bus.ResetBus()
+	service.ResetServiceRegistry()
+	port := GetTestPort()
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", port, true)
+	config.Diagnostics = &DiagnosticsConfig{LogBufferSize: 10}
+	config.BrokerBridges = []*BrokerBridgeConfig{{Name: "rabbit", ServerAddr: "localhost:61613", Password: "hunter2"}}
+	ps := NewPlatformServer(config)
+	assert.Nil(t, ps.RegisterService(services.NewPingPongService(), "ping-pong-service"))
+	ps.SetHttpChannelBridge(&service.RESTBridgeConfig{
+		ServiceChannel: "ping-pong-service",
+		Uri:            "/ping",
+		Method:         http.MethodGet,
+		FabricRequestBuilder: func(w http.ResponseWriter, r *http.Request) model.Request {
+			return model.Request{Payload: "hello", RequestCommand: "ping-get"}
+		},
+	})
+	config.Logger.Info("[ranch] diagnostics test", "database", "postgres://ranch:s3cret@db/ranch")
+
+	var buf bytes.Buffer
+	assert.Nil(t, ps.WriteDiagnosticsBundle(&buf))
+	files := readDiagnosticsBundle(t, buf.Bytes())
+	for _, name := range []string{"config.json", "logs.jsonl", "goroutines.txt", "heap.pprof", "routes.json",
+		"channels.json", "services.json", "metrics.json", "manifest.json"} {
+		assert.Contains(t, files, name)
+	}
+
+	assert.NotContains(t, files["config.json"], "hunter2")
+	assert.Contains(t, files["config.json"], `"password": "********"`)
+	assert.Contains(t, files["logs.jsonl"], "[ranch] Service registered")
+	assert.Contains(t, files["logs.jsonl"], "postgres://ranch:********@db/ranch")
+	assert.NotContains(t, files["logs.jsonl"], "s3cret")
+	assert.Contains(t, files["goroutines.txt"], "goroutine")
+	assert.Contains(t, files["routes.json"], `"path": "/ping"`)
+	assert.Contains(t, files["channels.json"], `"name": "ping-pong-service"`)
+	assert.Contains(t, files["services.json"], `"type": "*services.PingPongService"`)
+	assert.Contains(t, files["services.json"], "/ping-GET")
+	assert.Contains(t, files["metrics.json"], `"goroutines"`)
+	assert.Contains(t, files["manifest.json"], `"heap.pprof"`)
+}
+
+func TestPlatformServer_DiagnosticsEndpoint(t *testing.T) {
+	newBus := bus.ResetBus()
+	service.ResetServiceRegistry()
+	port := GetTestPort()
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", port, true)
+	config.Diagnostics = &DiagnosticsConfig{Endpoint: "/ranch/diagnostics"}
+	ps := NewPlatformServer(config)
+	ps.(*platformServer).eventbus = newBus
+
+	syschan := make(chan os.Signal, 1)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go ps.StartServer(syschan)
+	RunWhenServerReady(t, newBus, func(t2 *testing.T) {
+		endpoint := fmt.Sprintf("http://127.0.0.1:%d/ranch/diagnostics", port)
+		rsp, err := http.Get(endpoint)
+		if assert.Nil(t, err) {
+			body, _ := io.ReadAll(rsp.Body)
+			_ = rsp.Body.Close()
+			assert.Equal(t, http.StatusOK, rsp.StatusCode)
+			assert.Equal(t, "application/zip", rsp.Header.Get("Content-Type"))
+			assert.Contains(t, readDiagnosticsBundle(t, body), "manifest.json")
+		}
+
+		// requests relayed by a proxy are not local, even when the proxy is.
+		req, _ := http.NewRequest(http.MethodGet, endpoint, nil)
+		req.Header.Set("X-Forwarded-For", "127.0.0.1")
+		rsp, err = http.DefaultClient.Do(req)
+		if assert.Nil(t, err) {
+			_ = rsp.Body.Close()
+			assert.Equal(t, http.StatusForbidden, rsp.StatusCode)
+		}
+		ps.StopServer()
+		wg.Done()
+	})
+	wg.Wait()
+}
+
+func TestPlatformServer_GrpcBridge(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	grpcPort := l.Addr().(*net.TCPAddr).Port
+	_ = l.Close()
+
+	newBus := bus.ResetBus()
+	service.ResetServiceRegistry()
+	port := GetTestPort()
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", port, true)
+	config.GrpcBridge = &GrpcBridgeConfig{Port: grpcPort, Channels: []string{"ping-pong-service"}}
+	ps := NewPlatformServer(config)
+	ps.(*platformServer).eventbus = newBus
+	assert.Nil(t, ps.RegisterService(services.NewPingPongService(), "ping-pong-service"))
+
+	syschan := make(chan os.Signal, 1)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go ps.StartServer(syschan)
+	RunWhenServerReady(t, newBus, func(t2 *testing.T) {
+		conn, err := grpc.NewClient(fmt.Sprintf("127.0.0.1:%d", grpcPort),
+			grpc.WithTransportCredentials(insecure.NewCredentials()))
+		assert.Nil(t, err)
+		defer conn.Close()
+		ctx := metadata.AppendToOutgoingContext(context.Background(), grpcbridge.ChannelMetadataKey, "ping-pong-service")
+
+		var frame *grpcbridge.Frame
+		assert.Eventually(t, func() bool {
+			stream, err := grpcbridge.NewFabricClient(conn).Stream(ctx)
+			if err != nil || stream.Send(&grpcbridge.Frame{Command: "ping-get", Payload: []byte(`"hello"`)}) != nil {
+				return false
+			}
+			frame, err = stream.Recv()
+			_ = stream.CloseSend()
+			return err == nil
+		}, 2*time.Second, 10*time.Millisecond)
+
+		if assert.NotNil(t, frame) {
+			assert.Contains(t, string(frame.Payload), `"payload":"hello-response"`)
+			assert.Equal(t, "application/json", frame.Headers["Content-Type"])
+		}
+		ps.StopServer()
+		wg.Done()
+	})
+	wg.Wait()
+}
+
+type testBackupDestination struct {
+	saved chan string
+}
+
+func (d *testBackupDestination) Save(name string, backup []byte) error {
+	d.saved <- string(backup)
+	return nil
+}
+
+func TestPlatformServer_StoreBackup(t *testing.T) {
+	newBus := bus.ResetBus()
+	service.ResetServiceRegistry()
+	port := GetTestPort()
+	destination := &testBackupDestination{saved: make(chan string, 10)}
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", port, true)
+	config.StoreBackup = &StoreBackupConfig{Endpoint: "/ranch/stores", IntervalSeconds: 1, Destination: destination}
+	ps := NewPlatformServer(config)
+	ps.(*platformServer).eventbus = newBus
+	newBus.GetStoreManager().CreateStore("cattle").Put("bessie", "moo", nil)
+
+	syschan := make(chan os.Signal, 1)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go ps.StartServer(syschan)
+	RunWhenServerReady(t, newBus, func(t2 *testing.T) {
+		endpoint := fmt.Sprintf("http://127.0.0.1:%d/ranch/stores", port)
+		rsp, err := http.Get(endpoint)
+		var backup []byte
+		if assert.Nil(t, err) {
+			backup, _ = io.ReadAll(rsp.Body)
+			_ = rsp.Body.Close()
+			assert.Equal(t, http.StatusOK, rsp.StatusCode)
+			assert.Contains(t, string(backup), `"bessie":"moo"`)
+		}
+
+		newBus.GetStoreManager().GetStore("cattle").Put("bessie", "baa", nil)
+		rsp, err = http.Post(endpoint, "application/json", bytes.NewReader(backup))
+		if assert.Nil(t, err) {
+			_ = rsp.Body.Close()
+			assert.Equal(t, http.StatusNoContent, rsp.StatusCode)
+			assert.Equal(t, "moo", newBus.GetStoreManager().GetStore("cattle").GetValue("bessie"))
+		}
+
+		rsp, err = http.Post(endpoint, "application/json", strings.NewReader(`{"formatVersion":9}`))
+		if assert.Nil(t, err) {
+			_ = rsp.Body.Close()
+			assert.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+		}
+
+		select {
+		case saved := <-destination.saved:
+			assert.Contains(t, saved, `"cattle"`)
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, "no scheduled backup saved")
+		}
+		ps.StopServer()
+		wg.Done()
+	})
+	wg.Wait()
+}
+
+func TestPlatformServer_StoreSnapshots(t *testing.T) {
+	newBus := bus.ResetBus()
+	service.ResetServiceRegistry()
+	port := GetTestPort()
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", port, true)
+	config.StoreBackup = &StoreBackupConfig{SnapshotEndpoint: "/ranch/snapshots"}
+	ps := NewPlatformServer(config)
+	ps.(*platformServer).eventbus = newBus
+	newBus.GetStoreManager().CreateStore("cattle").Put("bessie", "moo", nil)
+
+	syschan := make(chan os.Signal, 1)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go ps.StartServer(syschan)
+	RunWhenServerReady(t, newBus, func(t2 *testing.T) {
+		endpoint := fmt.Sprintf("http://127.0.0.1:%d/ranch/snapshots/", port)
+		rsp, err := http.Get(endpoint + "cattle")
+		var snapshot []byte
+		if assert.Nil(t, err) {
+			snapshot, _ = io.ReadAll(rsp.Body)
+			_ = rsp.Body.Close()
+			assert.Equal(t, http.StatusOK, rsp.StatusCode)
+			assert.Contains(t, rsp.Header.Get("Content-Disposition"), "cattle-")
+			assert.Contains(t, string(snapshot), `"bessie":"moo"`)
+		}
+
+		rsp, err = http.Get(endpoint + "horses")
+		if assert.Nil(t, err) {
+			_ = rsp.Body.Close()
+			assert.Equal(t, http.StatusNotFound, rsp.StatusCode)
+		}
+
+		// importing seeds the store named in the path.
+		rsp, err = http.Post(endpoint+"herd", "application/json", bytes.NewReader(snapshot))
+		if assert.Nil(t, err) {
+			_ = rsp.Body.Close()
+			assert.Equal(t, http.StatusNoContent, rsp.StatusCode)
+			assert.Equal(t, "moo", newBus.GetStoreManager().GetStore("herd").GetValue("bessie"))
+		}
+
+		rsp, err = http.Post(endpoint+"herd", "application/json", strings.NewReader(`not json`))
+		if assert.Nil(t, err) {
+			_ = rsp.Body.Close()
+			assert.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+		}
+		ps.StopServer()
+		wg.Done()
+	})
+	wg.Wait()
+}
+
+func TestPlatformServer_LoadSignal(t *testing.T) {
+	newBus := bus.ResetBus()
+	service.ResetServiceRegistry()
+	port := GetTestPort()
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", port, true)
+	config.LoadSignal = &LoadSignalConfig{Endpoint: "/ranch/load", PublishIntervalSeconds: 1, MaxInFlightHttpRequests: 2}
+	ps := NewPlatformServer(config)
+	ps.(*platformServer).eventbus = newBus
+	newBus.GetChannelManager().CreateChannel(RANCH_LOAD_SIGNAL_CHANNEL)
+	published, _ := newBus.ListenStream(RANCH_LOAD_SIGNAL_CHANNEL)
+	signals := make(chan *LoadSignal, 10)
+	published.Handle(func(msg *model.Message) {
+		signals <- msg.Payload.(*LoadSignal)
+	}, func(err error) {})
+
+	syschan := make(chan os.Signal, 1)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go ps.StartServer(syschan)
+	RunWhenServerReady(t, newBus, func(t2 *testing.T) {
+		rsp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/ranch/load", port))
+		if assert.Nil(t, err) {
+			var signal LoadSignal
+			assert.Nil(t, json.NewDecoder(rsp.Body).Decode(&signal))
+			_ = rsp.Body.Close()
+			assert.Equal(t, http.StatusOK, rsp.StatusCode)
+
+			// the request reading the signal is in flight itself.
+			assert.EqualValues(t, 1, signal.InFlightHttpRequests)
+			assert.Equal(t, 50, signal.UtilizationPercent)
+			assert.Equal(t, 80, signal.TargetUtilizationPercent)
+		}
+
+		select {
+		case signal := <-signals:
+			assert.NotNil(t, signal.QueueDepths)
+			assert.Zero(t, signal.InFlightServiceRequests)
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, "no load signal published")
+		}
+		ps.StopServer()
+		wg.Done()
+	})
+	wg.Wait()
+}
+
+type usageTestService struct{}
+
+func (s *usageTestService) HandleServiceRequest(request *model.Request, core service.FabricServiceCore) {
+	core.SendResponse(request, "moo")
+}
+
+func TestPlatformServer_UsageAccounting(t *testing.T) {
+	newBus := bus.ResetBus()
+	service.ResetServiceRegistry()
+	port := GetTestPort()
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", port, true)
+	config.UsageAccounting = &UsageAccountingConfig{Endpoint: "/ranch/usage", ReportIntervalSeconds: 1}
+	ps := NewPlatformServer(config)
+	ps.(*platformServer).eventbus = newBus
+	newBus.GetChannelManager().CreateChannel(RANCH_USAGE_REPORT_CHANNEL)
+	published, _ := newBus.ListenStream(RANCH_USAGE_REPORT_CHANNEL)
+	reports := make(chan *UsageReport, 10)
+	published.Handle(func(msg *model.Message) {
+		reports <- msg.Payload.(*UsageReport)
+	}, func(err error) {})
+
+	syschan := make(chan os.Signal, 1)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go ps.StartServer(syschan)
+	RunWhenServerReady(t, newBus, func(t2 *testing.T) {
+		registry := service.GetServiceRegistry()
+		assert.Nil(t, registry.RegisterService(&usageTestService{}, "usage-test"))
+		_ = newBus.SendRequestMessage("usage-test", &model.Request{RequestCommand: "moo", Payload: "hi"}, nil)
+		assert.Eventually(t, func() bool {
+			return registry.GetServiceUsage()["usage-test"].Requests == 1
+		}, time.Second, time.Millisecond)
+
+		rsp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/ranch/usage", port))
+		if assert.Nil(t, err) {
+			var report UsageReport
+			assert.Nil(t, json.NewDecoder(rsp.Body).Decode(&report))
+			_ = rsp.Body.Close()
+			if assert.Len(t, report.Services, 1) {
+				assert.Equal(t, "usage-test", report.Services[0].Channel)
+				assert.EqualValues(t, 1, report.Services[0].Requests)
+				assert.EqualValues(t, 1, report.Services[0].Responses)
+				assert.EqualValues(t, 2, report.Services[0].RequestBytes)
+				assert.EqualValues(t, 3, report.Services[0].ResponseBytes)
+			}
+		}
+
+		select {
+		case report := <-reports:
+			assert.True(t, report.PeriodEnd.After(report.PeriodStart))
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, "no usage report published")
+		}
+		ps.StopServer()
+		wg.Done()
+	})
+	wg.Wait()
+}
+
+func TestPlatformServer_StorePersistence(t *testing.T) {
+	dir := t.TempDir()
+	newConfig := func() *PlatformServerConfig {
+		config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", GetTestPort(), true)
+		config.StorePersistence = &StorePersistenceConfig{Stores: []string{"herd"}, Directory: dir}
+		return config
+	}
+
+	newBus := bus.ResetBus()
+	service.ResetServiceRegistry()
+	ps := NewPlatformServer(newConfig())
+	newBus.GetStoreManager().CreateStore("herd").Put("bessie", "moo", nil)
+	newBus.GetStoreManager().CreateStore("strays").Put("daisy", "moo", nil)
+	ps.(*platformServer).closeStorePersistence()
+
+	// the next instance comes up with the persistent stores as they were.
+	newBus = bus.ResetBus()
+	service.ResetServiceRegistry()
+	ps = NewPlatformServer(newConfig())
+	assert.Equal(t, "moo", newBus.GetStoreManager().CreateStore("herd").GetValue("bessie"))
+	assert.Nil(t, newBus.GetStoreManager().CreateStore("strays").GetValue("daisy"))
+	ps.(*platformServer).closeStorePersistence()
+}
+
+func TestValidateDependencyConfig(t *testing.T) {
+	assert.Error(t, validateDependencyConfig(nil))
+	assert.Error(t, validateDependencyConfig(&DependencyConfig{Target: "localhost:5432"}))
+	assert.Error(t, validateDependencyConfig(&DependencyConfig{Name: "db"}))
+	assert.Error(t, validateDependencyConfig(&DependencyConfig{Name: "db", Target: "localhost:5432", Probe: "ping"}))
+	assert.Error(t, validateDependencyConfig(&DependencyConfig{Name: "db", Target: "localhost:5432", Phase: "later"}))
+	assert.NoError(t, validateDependencyConfig(&DependencyConfig{Name: "db", Target: "localhost:5432"}))
+	assert.NoError(t, validateDependencyConfig(&DependencyConfig{Name: "db",
+		Check: func(ctx context.Context) error { return nil }}))
+}
+
+func TestPlatformServer_Dependencies(t *testing.T) {
+	newBus := bus.ResetBus()
+	service.ResetServiceRegistry()
+	port := GetTestPort()
+
+	db, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer db.Close()
+	var upstreamHits int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&upstreamHits, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer upstream.Close()
+	var checked int64
+
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", port, true)
+	config.Dependencies = &DependenciesConfig{
+		HealthEndpoint: "/health",
+		Dependencies: []*DependencyConfig{
+			{Name: "db", Target: db.Addr().String(), Phase: DependencyPhaseStartup},
+			{Name: "upstream", Probe: DependencyProbeHTTP, Target: upstream.URL, IntervalSeconds: 1, MaxBackoffSeconds: 1},
+			{Name: "cache", Target: "127.0.0.1:1", Optional: true, MaxStartupAttempts: 1},
+			{Name: "queue", Check: func(ctx context.Context) error {
+				atomic.AddInt64(&checked, 1)
+				return nil
+			}},
+		},
+	}
+	ps := NewPlatformServer(config)
+	ps.(*platformServer).eventbus = newBus
+
+	getHealth := func() (int, *HealthReport) {
+		rsp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/health", port))
+		if !assert.Nil(t, err) {
+			return 0, nil
+		}
+		defer rsp.Body.Close()
+		var report HealthReport
+		assert.Nil(t, json.NewDecoder(rsp.Body).Decode(&report))
+		return rsp.StatusCode, &report
+	}
+
+	syschan := make(chan os.Signal, 1)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go ps.StartServer(syschan)
+	RunWhenServerReady(t, newBus, func(t2 *testing.T) {
+		// online only once the upstream stopped failing.
+		assert.GreaterOrEqual(t, atomic.LoadInt64(&upstreamHits), int64(3))
+		assert.NotZero(t, atomic.LoadInt64(&checked))
+
+		status, report := getHealth()
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, HealthReady, report.Status)
+		assert.True(t, report.Http)
+		if assert.Len(t, report.Dependencies, 4) {
+			assert.True(t, report.Dependencies[0].Up)
+			assert.Equal(t, DependencyPhaseStartup, report.Dependencies[0].Phase)
+			assert.True(t, report.Dependencies[1].Up)
+			assert.False(t, report.Dependencies[2].Up)
+			assert.True(t, report.Dependencies[2].Failed)
+			assert.True(t, report.Dependencies[3].Up)
+		}
+
+		upstream.Close()
+		assert.Eventually(t, func() bool {
+			status, report = getHealth()
+			return status == http.StatusServiceUnavailable && report.Status == HealthDegraded
+		}, 5*time.Second, 100*time.Millisecond)
+		assert.NotEmpty(t, report.Dependencies[1].LastError)
+
+		ps.StopServer()
+		wg.Done()
+	})
+	wg.Wait()
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use, for capturing logs.
+type syncBuffer struct {
+	buf  bytes.Buffer
+	lock sync.Mutex
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.String()
+}
+
+type requestLoggingTestService struct{}
+
+func (s *requestLoggingTestService) HandleServiceRequest(request *model.Request, core service.FabricServiceCore) {
+	request.Logger().Info("handling request")
+	core.SendResponse(request, "moo")
+}
+
+func TestPlatformServer_RequestLogging(t *testing.T) {
+	newBus := bus.ResetBus()
+	service.ResetServiceRegistry()
+	port := GetTestPort()
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", port, true)
+	out := &syncBuffer{}
+	config.Logger = slog.New(slog.NewTextHandler(out, nil))
+	config.RequestLogging = &RequestLoggingConfig{Principal: func(r *http.Request) string {
+		return r.Header.Get("X-User")
+	}}
+	ps := NewPlatformServer(config)
+	ps.(*platformServer).eventbus = newBus
+	_ = ps.RegisterService(&requestLoggingTestService{}, "logging-service")
+	ps.SetHttpChannelBridge(&service.RESTBridgeConfig{
+		ServiceChannel: "logging-service",
+		Uri:            "/moo/{name}",
+		Method:         http.MethodGet,
+		FabricRequestBuilder: func(w http.ResponseWriter, r *http.Request) model.Request {
+			return model.Request{Id: &uuid.UUID{}, RequestCommand: "moo"}
+		},
+	})
+
+	syschan := make(chan os.Signal, 1)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go ps.StartServer(syschan)
+	RunWhenServerReady(t, newBus, func(t2 *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("http://127.0.0.1:%d/moo/cow", port), nil)
+		req.Header.Set("X-Request-Id", "abc-123")
+		req.Header.Set("X-User", "dave")
+		rsp, err := http.DefaultClient.Do(req)
+		if assert.Nil(t, err) {
+			_ = rsp.Body.Close()
+			assert.Equal(t, http.StatusOK, rsp.StatusCode)
+			assert.Equal(t, "abc-123", rsp.Header.Get("X-Request-Id"))
+		}
+		logged := out.String()
+		assert.Contains(t, logged, "msg=\"handling request\" request_id=abc-123 route=/moo/{name} principal=dave channel=logging-service")
+
+		// a request id is generated if the client did not send one.
+		rsp, err = http.Get(fmt.Sprintf("http://127.0.0.1:%d/moo/cow", port))
+		if assert.Nil(t, err) {
+			_ = rsp.Body.Close()
+			_, err = uuid.Parse(rsp.Header.Get("X-Request-Id"))
+			assert.Nil(t, err)
+		}
+		ps.StopServer()
+		service.GetServiceRegistry().UnregisterService("logging-service")
+		wg.Done()
+	})
+	wg.Wait()
+}
+
+func TestPlatformServer_SharedPort(t *testing.T) {
+	newBus := bus.ResetBus()
+	service.ResetServiceRegistry()
+	port := GetTestPort()
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", port, true)
+	config.FabricConfig = &FabricBrokerConfig{
+		UseTCP:    true,
+		SharePort: true,
+		EndpointConfig: &bus.EndpointConfig{
+			TopicPrefix:      "/topic",
+			AppRequestPrefix: "/pub",
+			Heartbeat:        60000,
+		},
+	}
+	config.LoadSignal = &LoadSignalConfig{Endpoint: "/ranch/load"}
+	ps := NewPlatformServer(config)
+	ps.(*platformServer).eventbus = newBus
+
+	syschan := make(chan os.Signal, 1)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go ps.StartServer(syschan)
+	RunWhenServerReady(t, newBus, func(t2 *testing.T) {
+		// raw STOMP and HTTP clients share the server port.
+		conn, err := stomp.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		if assert.Nil(t, err) {
+			assert.Nil(t, conn.Disconnect())
+		}
+		rsp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/ranch/load", port))
+		if assert.Nil(t, err) {
+			_ = rsp.Body.Close()
+			assert.Equal(t, http.StatusOK, rsp.StatusCode)
+		}
+		ps.StopServer()
+		wg.Done()
+	})
+	wg.Wait()
+}
+
+func TestPlatformServer_FabricTLS(t *testing.T) {
+	newBus := bus.ResetBus()
+	service.ResetServiceRegistry()
+	port := GetTestPort()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	tcpPort := l.Addr().(*net.TCPAddr).Port
+	_ = l.Close()
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", port, true)
+	config.TLSCertConfig = GetTestTLSCertConfig(t.TempDir())
+	config.FabricConfig = &FabricBrokerConfig{
+		UseTCP:       true,
+		UseTLS:       true,
+		TCPPort:      tcpPort,
+		ClientCAFile: config.TLSCertConfig.CertFile,
+		EndpointConfig: &bus.EndpointConfig{
+			TopicPrefix:      "/topic",
+			AppRequestPrefix: "/pub",
+			Heartbeat:        60000,
+		},
+	}
+	ps := NewPlatformServer(config)
+	ps.(*platformServer).eventbus = newBus
+
+	syschan := make(chan os.Signal, 1)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go ps.StartServer(syschan)
+	RunWhenServerReady(t, newBus, func(t2 *testing.T) {
+		// the TCP listener only speaks TLS, clients without a certificate are anonymous
+		tlsConn, err := tls.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", tcpPort), &tls.Config{InsecureSkipVerify: true})
+		if assert.Nil(t, err) {
+			conn, err := stomp.Connect(tlsConn)
+			if assert.Nil(t, err) {
+				assert.Nil(t, conn.Disconnect())
+			}
+		}
+		ps.StopServer()
+		wg.Done()
+	})
+	wg.Wait()
+}
+
+func TestPlatformServer_FabricTLSConfig(t *testing.T) {
+	certConfig := GetTestTLSCertConfig(t.TempDir())
+	ps := &platformServer{
+		HttpServer:   &http.Server{},
+		serverConfig: &PlatformServerConfig{TLSCertConfig: certConfig, FabricConfig: &FabricBrokerConfig{}},
+	}
+	tlsConfig, err := ps.fabricTLSConfig()
+	assert.Nil(t, err)
+	assert.Len(t, tlsConfig.Certificates, 1)
+	assert.Equal(t, tls.NoClientCert, tlsConfig.ClientAuth)
+
+	ps.serverConfig.FabricConfig.ClientCAFile = certConfig.CertFile
+	tlsConfig, err = ps.fabricTLSConfig()
+	assert.Nil(t, err)
+	assert.NotNil(t, tlsConfig.ClientCAs)
+	assert.Equal(t, tls.VerifyClientCertIfGiven, tlsConfig.ClientAuth)
+
+	ps.serverConfig.FabricConfig.RequireClientCert = true
+	tlsConfig, _ = ps.fabricTLSConfig()
+	assert.Equal(t, tls.RequireAndVerifyClientCert, tlsConfig.ClientAuth)
+
+	// a CA file without certificates verifies nobody
+	ps.serverConfig.FabricConfig.ClientCAFile = certConfig.KeyFile
+	_, err = ps.fabricTLSConfig()
+	assert.NotNil(t, err)
+
+	ps.serverConfig.FabricConfig.ClientCAFile = ""
+	_, err = ps.fabricTLSConfig()
+	assert.NotNil(t, err)
+
+	// without a certificate there is no TLS
+	ps.serverConfig.TLSCertConfig = nil
+	tlsConfig, err = ps.fabricTLSConfig()
+	assert.Nil(t, err)
+	assert.Nil(t, tlsConfig)
+}
+
+func TestPlatformServer_FabricWebSocketGuard(t *testing.T) {
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", GetTestPort(), true)
+	config.FabricConfig = &FabricBrokerConfig{
+		FabricEndpoint:        "/ws",
+		JsonWebSocketEndpoint: "/ws-json",
+		EndpointConfig:        &bus.EndpointConfig{TopicPrefix: "/topic"},
+		AllowedOrigins:        []string{"https://app.example.com"},
+		CSRFCookie:            "csrf",
+	}
+	ps := NewPlatformServer(config).(*platformServer)
+
+	// upgrades without the CSRF token of the session are refused, on both WebSocket endpoints
+	for _, endpoint := range []string{"/ws", "/ws-json"} {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost"+endpoint+"?csrf_token=baa", nil)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Origin", "https://app.example.com")
+		req.AddCookie(&http.Cookie{Name: "csrf", Value: "moo"})
+		rec := httptest.NewRecorder()
+		ps.router.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusForbidden, rec.Code, endpoint)
+	}
+}
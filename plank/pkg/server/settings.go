@@ -0,0 +1,211 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pb33f/ranch/plank/pkg/settings"
+	"github.com/pb33f/ranch/plank/pkg/siem"
+	"github.com/pb33f/ranch/service"
+)
+
+const (
+	serverSettingsSection  = "server"
+	maxSettingsRequestSize = 1 << 20
+)
+
+const serverSettingsSchema = `{
+	"type": "object",
+	"properties": {
+		"rest_bridge_timeout_seconds": {"type": "integer", "minimum": 1},
+		"shutdown_timeout_seconds": {"type": "integer", "minimum": 1}
+	},
+	"additionalProperties": false
+}`
+
+// initSettings creates the settings manager, registers the settings of the server itself and the settings
+// REST API, if configured.
+func (ps *platformServer) initSettings() {
+	cfg := ps.serverConfig.Settings
+	if cfg == nil {
+		return
+	}
+	ps.eventbus.GetChannelManager().CreateChannel(RANCH_AUDIT_EVENT_CHANNEL)
+	manager := settings.NewManager(ps.eventbus.GetStoreManager(), &settings.Config{
+		Store:      cfg.Store,
+		MaxHistory: cfg.MaxHistory,
+		OnChange:   ps.auditSettingsChange,
+	})
+	if err := manager.Register(ps.serverSettings()); err != nil {
+		ps.serverConfig.Logger.Error(wrapError(errServerInit, err).Error())
+		return
+	}
+	ps.settings = manager
+	if cfg.Endpoint != "" {
+		ps.setSettingsRoutes(cfg)
+	}
+}
+
+// serverSettings is the settings section of the server. A new REST bridge timeout applies to the bridges set
+// up after the change.
+func (ps *platformServer) serverSettings() *settings.Section {
+	return &settings.Section{
+		Name:        serverSettingsSection,
+		Description: "timeouts of the server",
+		Schema:      []byte(serverSettingsSchema),
+		Values: settings.Values{
+			"rest_bridge_timeout_seconds": int(ps.serverConfig.RestBridgeTimeout / time.Second),
+			"shutdown_timeout_seconds":    int(ps.serverConfig.ShutdownTimeout / time.Second),
+		},
+		Apply: func(values settings.Values) error {
+			ps.lock.Lock()
+			defer ps.lock.Unlock()
+			ps.serverConfig.RestBridgeTimeout = time.Duration(values.Int("rest_bridge_timeout_seconds")) * time.Second
+			ps.serverConfig.ShutdownTimeout = time.Duration(values.Int("shutdown_timeout_seconds")) * time.Second
+			return nil
+		},
+	}
+}
+
+// auditSettingsChange publishes a change to the settings on RANCH_AUDIT_EVENT_CHANNEL, for the SIEM exporters.
+func (ps *platformServer) auditSettingsChange(change *settings.Change) {
+	evt := &siem.Event{
+		Time:     change.Time,
+		Class:    "settings.changed",
+		Name:     "Settings changed",
+		Severity: 3,
+		Outcome:  siem.OutcomeSuccess,
+		User:     change.Principal,
+		Message:  change.Comment,
+		Extensions: map[string]string{
+			"section": change.Section,
+			"version": strconv.Itoa(change.Version),
+		},
+	}
+	if change.RolledBack > 0 {
+		evt.Class, evt.Name = "settings.rolled_back", "Settings rolled back"
+		evt.Extensions["rolled_back"] = strconv.Itoa(change.RolledBack)
+	}
+	ps.serverConfig.Logger.Info("[ranch] settings changed", "section", change.Section, "version", change.Version,
+		"principal", change.Principal, "rolled_back", change.RolledBack)
+	_ = ps.eventbus.SendResponseMessage(RANCH_AUDIT_EVENT_CHANNEL, evt, nil)
+}
+
+// RegisterSettings adds a section to the settings changed at runtime, e.g. the tunables of a service.
+func (ps *platformServer) RegisterSettings(section *settings.Section) error {
+	if ps.settings == nil {
+		return fmt.Errorf("settings are not configured")
+	}
+	return ps.settings.Register(section)
+}
+
+// startSettings registers the service changing the settings, if configured.
+func (ps *platformServer) startSettings() {
+	if ps.settings == nil {
+		return
+	}
+	if err := ps.RegisterService(settings.NewService(ps.settings), ps.settingsChannel()); err != nil {
+		ps.serverConfig.Logger.Error(wrapError(errServerInit, err).Error())
+	}
+}
+
+// stopSettings unregisters the service changing the settings.
+func (ps *platformServer) stopSettings() {
+	if ps.settings == nil {
+		return
+	}
+	_ = service.GetServiceRegistry().UnregisterService(ps.settingsChannel())
+}
+
+func (ps *platformServer) settingsChannel() string {
+	if ps.serverConfig.Settings.Channel != "" {
+		return ps.serverConfig.Settings.Channel
+	}
+	return RANCH_SETTINGS_CHANNEL
+}
+
+// setSettingsRoutes registers the settings REST API: the sections and their values, their changes, and the
+// history of the changes.
+func (ps *platformServer) setSettingsRoutes(cfg *SettingsConfig) {
+	endpoint := strings.TrimSuffix(cfg.Endpoint, "/")
+	route := func(path string, methods ...string) *mux.Route {
+		return ps.router.Path(endpoint + path).Name(endpoint + path + " " + methods[0]).Methods(methods...)
+	}
+	handler := func(handle func(w http.ResponseWriter, r *http.Request) (interface{}, error)) http.HandlerFunc {
+		return ps.adminHandler("settings", cfg.Authorize, func(w http.ResponseWriter, r *http.Request) {
+			result, err := handle(w, r)
+			var unreadable *settingsRequestError
+			switch {
+			case errors.As(err, &unreadable):
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			case err != nil:
+				http.Error(w, err.Error(), settings.ErrorCode(err))
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "no-store")
+			_ = json.NewEncoder(w).Encode(result)
+		})
+	}
+
+	route("", http.MethodGet).HandlerFunc(handler(
+		func(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+			return ps.settings.Sections(), nil
+		}))
+	route("/history", http.MethodGet).HandlerFunc(handler(
+		func(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+			return ps.settings.History(""), nil
+		}))
+	route("/rollback", http.MethodPost).HandlerFunc(handler(
+		func(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+			var rollback settings.RollbackRequest
+			if err := decodeSettingsRequest(w, r, &rollback); err != nil {
+				return nil, err
+			}
+			return ps.settings.Rollback(rollback.Version, ps.httpPrincipal(r), rollback.Comment)
+		}))
+	route("/{section}", http.MethodGet).HandlerFunc(handler(
+		func(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+			return ps.settings.Section(mux.Vars(r)["section"])
+		}))
+	route("/{section}", http.MethodPut, http.MethodPatch).HandlerFunc(handler(
+		func(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+			var set settings.SetRequest
+			if err := decodeSettingsRequest(w, r, &set); err != nil {
+				return nil, err
+			}
+			return ps.settings.Set(mux.Vars(r)["section"], set.Values, ps.httpPrincipal(r), set.Comment)
+		}))
+	route("/{section}/history", http.MethodGet).HandlerFunc(handler(
+		func(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+			return ps.settings.History(mux.Vars(r)["section"]), nil
+		}))
+	ps.serverConfig.Logger.Info("[ranch] settings endpoint enabled", "endpoint", endpoint)
+}
+
+// settingsRequestError is returned for settings requests whose body cannot be read.
+type settingsRequestError struct {
+	err error
+}
+
+func (e *settingsRequestError) Error() string {
+	return "cannot read settings request: " + e.err.Error()
+}
+
+// decodeSettingsRequest decodes the JSON body of a settings request.
+func decodeSettingsRequest(w http.ResponseWriter, r *http.Request, target interface{}) error {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSettingsRequestSize)).Decode(target); err != nil {
+		return &settingsRequestError{err: err}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/model"
+	"github.com/pb33f/ranch/plank/pkg/settings"
+	"github.com/pb33f/ranch/plank/pkg/siem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSettings_RestApi(t *testing.T) {
+	var logs bytes.Buffer
+	ps := &platformServer{router: mux.NewRouter(), eventbus: bus.NewEventBusInstance(),
+		serverConfig: &PlatformServerConfig{
+			Logger:            slog.New(slog.NewTextHandler(&logs, nil)),
+			RestBridgeTimeout: time.Minute,
+			ShutdownTimeout:   5 * time.Minute,
+			ACL:               &AclConfig{HttpPrincipal: func(r *http.Request) string { return r.Header.Get("X-User") }},
+			Settings: &SettingsConfig{
+				Endpoint:  "/ranch/settings",
+				Authorize: func(r *http.Request) bool { return r.Header.Get("X-User") != "" },
+			},
+		}}
+	ps.initSettings()
+	require.NotNil(t, ps.settings)
+
+	audited := make(chan *siem.Event, 10)
+	handler, err := ps.eventbus.ListenStream(RANCH_AUDIT_EVENT_CHANNEL)
+	require.NoError(t, err)
+	defer handler.Close()
+	handler.Handle(func(msg *model.Message) {
+		audited <- msg.Payload.(*siem.Event)
+	}, func(err error) {})
+
+	// services add their own settings
+	var greeting string
+	require.NoError(t, ps.RegisterSettings(&settings.Section{
+		Name:   "greeter",
+		Schema: []byte(`{"properties": {"greeting": {"type": "string", "minLength": 1}}}`),
+		Values: settings.Values{"greeting": "howdy"},
+		Apply: func(values settings.Values) error {
+			greeting = values.String("greeting")
+			return nil
+		},
+	}))
+	assert.Equal(t, "howdy", greeting)
+
+	serve := func(method, path, user, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if user != "" {
+			r.Header.Set("X-User", user)
+		}
+		w := httptest.NewRecorder()
+		ps.router.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve(http.MethodGet, "/ranch/settings", "ops", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var sections []*settings.SectionState
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &sections))
+	require.Len(t, sections, 2)
+	assert.Equal(t, "greeter", sections[0].Name)
+	assert.Equal(t, settings.Values{"rest_bridge_timeout_seconds": float64(60), "shutdown_timeout_seconds": float64(300)},
+		sections[1].Values)
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodGet, "/ranch/settings", "", "").Code)
+
+	// changes are validated, applied and audited
+	w = serve(http.MethodPatch, "/ranch/settings/server", "ops",
+		`{"values": {"rest_bridge_timeout_seconds": 10}, "comment": "slow backend"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, 10*time.Second, ps.serverConfig.RestBridgeTimeout)
+	assert.Equal(t, 5*time.Minute, ps.serverConfig.ShutdownTimeout)
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPut, "/ranch/settings/server", "ops",
+		`{"values": {"rest_bridge_timeout_seconds": 0}}`).Code)
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPut, "/ranch/settings/greeter", "ops", `{"values": `).Code)
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodPut, "/ranch/settings/cattle", "ops", `{"values": {}}`).Code)
+	w = serve(http.MethodPut, "/ranch/settings/greeter", "admin", `{"values": {"greeting": "yee-haw"}}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "yee-haw", greeting)
+
+	w = serve(http.MethodGet, "/ranch/settings/server/history", "ops", "")
+	var history []*settings.Change
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &history))
+	require.Len(t, history, 1)
+	assert.Equal(t, "ops", history[0].Principal)
+	assert.Equal(t, "slow backend", history[0].Comment)
+
+	// and rolled back
+	w = serve(http.MethodPost, "/ranch/settings/rollback", "ops", `{"version": 1, "comment": "backend is fine"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, time.Minute, ps.serverConfig.RestBridgeTimeout)
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodPost, "/ranch/settings/rollback", "ops", `{"version": 9}`).Code)
+
+	w = serve(http.MethodGet, "/ranch/settings/history", "ops", "")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &history))
+	assert.Len(t, history, 3)
+
+	// the bus does not keep the order of the events
+	events := make(map[string]*siem.Event)
+	for range 3 {
+		evt := <-audited
+		events[evt.Extensions["version"]] = evt
+	}
+	assert.Equal(t, "settings.changed", events["1"].Class)
+	assert.Equal(t, "ops", events["1"].User)
+	assert.Equal(t, map[string]string{"section": "greeter", "version": "2"}, events["2"].Extensions)
+	assert.Equal(t, "settings.rolled_back", events["3"].Class)
+	assert.Equal(t, "1", events["3"].Extensions["rolled_back"])
+}
+
+func TestSettings_NotConfigured(t *testing.T) {
+	ps := &platformServer{router: mux.NewRouter(), serverConfig: &PlatformServerConfig{}}
+	ps.initSettings()
+	assert.Nil(t, ps.settings)
+	assert.Error(t, ps.RegisterSettings(&settings.Section{Name: "greeter"}))
+
+	var notFound *settings.NotFoundError
+	ps = &platformServer{router: mux.NewRouter(), eventbus: bus.NewEventBusInstance(),
+		serverConfig: &PlatformServerConfig{Logger: slog.Default(), RestBridgeTimeout: time.Minute,
+			ShutdownTimeout: time.Minute, Settings: &SettingsConfig{}}}
+	ps.initSettings()
+	_, err := ps.settings.Section("greeter")
+	assert.True(t, errors.As(err, &notFound))
+}
@@ -4,6 +4,8 @@
 package server
 
 import (
+	"io/fs"
+
 	"github.com/gorilla/mux"
 	"github.com/pb33f/ranch/plank/pkg/middleware"
 	"github.com/pb33f/ranch/plank/utils"
@@ -20,6 +22,7 @@ type SpaConfig struct {
 	BaseUri           string            `json:"base_uri"`            // base URI for the SPA
 	StaticAssets      []string          `json:"static_assets"`       // locations for static assets used by the SPA
 	CacheControlRules map[string]string `json:"cache_control_rules"` // map holding glob pattern - cache-control header value
+	Assets            fs.FS             `json:"-"`                   // serve the SPA from memory e.g. an embed.FS, RootFolder and StaticAssets folders are then paths within it
 
 	cacheControlRulePairs []middleware.CacheControlRulePair
 }
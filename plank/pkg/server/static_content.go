@@ -0,0 +1,213 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pb33f/ranch/clock"
+	"github.com/pb33f/ranch/plank/pkg/middleware"
+)
+
+const (
+	defaultStaticRecheckInterval = 10 * time.Second
+	defaultPlaceholderStatus     = http.StatusServiceUnavailable
+)
+
+const defaultPlaceholderPage = `<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><title>Content unavailable</title></head>
+<body><h1>Content unavailable</h1><p>This content is being deployed, please try again shortly.</p></body>
+</html>
+`
+
+// staticMount is the content served by a static route or the SPA, on disk or in memory.
+type staticMount struct {
+	uri     string
+	path    string // directory on disk, empty for content in memory
+	fsys    fs.FS
+	index   bool // whether the content must have an index.html, as the root folder of the SPA does
+	lock    sync.Mutex
+	problem error // why the content cannot be served, nil if it can
+}
+
+// check returns why the content cannot be served, nil if it can.
+func (m *staticMount) check() error {
+	var info fs.FileInfo
+	var err error
+	name := "embedded assets"
+	if m.path != "" {
+		name = fmt.Sprintf("directory '%s'", m.path)
+		info, err = os.Stat(m.path)
+	} else {
+		info, err = fs.Stat(m.fsys, ".")
+	}
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return fmt.Errorf("static %s served at %s does not exist, create it or correct its path", name, m.uri)
+	case err != nil:
+		return fmt.Errorf("static %s served at %s cannot be read, check its permissions: %w", name, m.uri, err)
+	case !info.IsDir():
+		return fmt.Errorf("static %s served at %s is not a directory, correct its path", name, m.uri)
+	}
+	if _, err = fs.ReadDir(m.fsys, "."); err != nil {
+		return fmt.Errorf("static %s served at %s cannot be listed, check its permissions: %w", name, m.uri, err)
+	}
+	if m.index {
+		if _, err = fs.Stat(m.fsys, "index.html"); err != nil {
+			return fmt.Errorf("SPA %s served at %s has no readable index.html, build the application into it "+
+				"or correct the root folder: %w", name, m.uri, err)
+		}
+	}
+	return nil
+}
+
+// recheck checks the content again, returning whether it can be served changed since the last check, and
+// the problem found.
+func (m *staticMount) recheck() (bool, error) {
+	problem := m.check()
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	changed := (problem == nil) != (m.problem == nil)
+	m.problem = problem
+	return changed, problem
+}
+
+func (m *staticMount) available() bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.problem == nil
+}
+
+// setStaticMount serves the content of a mount at its URI with handler, reporting straight away if it
+// cannot be served.
+func (ps *platformServer) setStaticMount(mount *staticMount, handler http.HandlerFunc, middlewareFn ...mux.MiddlewareFunc) {
+	ps.addStaticMount(mount)
+	endpointHandlerMapKey := mount.uri + "*"
+	compositeHandler := http.StripPrefix(mount.uri,
+		middleware.BasicSecurityHeaderMiddleware()(ps.staticContentHandler(mount, handler)))
+
+	for _, mw := range middlewareFn {
+		compositeHandler = mw(compositeHandler)
+	}
+
+	ps.endpointHandlerMap[endpointHandlerMapKey] = compositeHandler.(http.HandlerFunc)
+	ps.router.PathPrefix(mount.uri + "/").Name(endpointHandlerMapKey).Handler(ps.endpointHandlerMap[endpointHandlerMapKey])
+}
+
+// addStaticMount keeps track of a mount so it is checked again, and reports if it cannot be served.
+func (ps *platformServer) addStaticMount(mount *staticMount) {
+	if _, problem := mount.recheck(); problem != nil {
+		ps.serverConfig.Logger.Error("[ranch] static content cannot be served", "uri", mount.uri,
+			"error", problem.Error())
+	}
+	ps.lock.Lock()
+	ps.staticMounts = append(ps.staticMounts, mount)
+	ps.lock.Unlock()
+}
+
+// staticContentHandler serves the placeholder page while the content of a mount cannot be served, if
+// configured, and the content otherwise.
+func (ps *platformServer) staticContentHandler(mount *staticMount, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := ps.serverConfig.StaticContent
+		if cfg != nil && cfg.Placeholder && !mount.available() {
+			ps.servePlaceholder(w)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func (ps *platformServer) servePlaceholder(w http.ResponseWriter) {
+	cfg := ps.serverConfig.StaticContent
+	page := []byte(defaultPlaceholderPage)
+	if cfg.PlaceholderFile != "" {
+		if custom, err := os.ReadFile(cfg.PlaceholderFile); err == nil {
+			page = custom
+		} else {
+			ps.serverConfig.Logger.Warn("[ranch] placeholder page cannot be read, serving the default one",
+				"file", cfg.PlaceholderFile, "error", err.Error())
+		}
+	}
+	status := cfg.PlaceholderStatus
+	if status == 0 {
+		status = defaultPlaceholderStatus
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_, _ = w.Write(page)
+}
+
+// CheckStaticContent checks the static directories and the SPA root folder again, e.g. once new content
+// was deployed, and returns why the ones that cannot be served cannot.
+func (ps *platformServer) CheckStaticContent() []error {
+	ps.lock.Lock()
+	mounts := append([]*staticMount(nil), ps.staticMounts...)
+	ps.lock.Unlock()
+
+	var problems []error
+	for _, mount := range mounts {
+		changed, problem := mount.recheck()
+		switch {
+		case problem != nil:
+			problems = append(problems, problem)
+			if changed {
+				ps.serverConfig.Logger.Error("[ranch] static content cannot be served", "uri", mount.uri,
+					"error", problem.Error())
+			}
+		case changed:
+			ps.serverConfig.Logger.Info("[ranch] static content can be served again", "uri", mount.uri)
+		}
+	}
+	return problems
+}
+
+// startStaticContentChecks checks the static content again periodically, until the server stops.
+func (ps *platformServer) startStaticContentChecks() {
+	cfg := ps.serverConfig.StaticContent
+	if cfg == nil || cfg.RecheckIntervalSeconds < 0 {
+		return
+	}
+	interval := defaultStaticRecheckInterval
+	if cfg.RecheckIntervalSeconds > 0 {
+		interval = time.Duration(cfg.RecheckIntervalSeconds) * time.Second
+	}
+	stop := make(chan struct{})
+	ps.lock.Lock()
+	ps.staticContentStop = stop
+	ps.lock.Unlock()
+
+	go func() {
+		ticker := clock.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C():
+				ps.CheckStaticContent()
+			}
+		}
+	}()
+}
+
+// stopStaticContentChecks stops checking the static content.
+func (ps *platformServer) stopStaticContentChecks() {
+	ps.lock.Lock()
+	stop := ps.staticContentStop
+	ps.staticContentStop = nil
+	ps.lock.Unlock()
+	if stop != nil {
+		close(stop)
+	}
+}
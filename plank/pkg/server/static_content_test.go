@@ -0,0 +1,173 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestStaticServer(config *StaticContentConfig) *platformServer {
+	return &platformServer{
+		router:             mux.NewRouter(),
+		endpointHandlerMap: make(map[string]http.HandlerFunc),
+		serverConfig: &PlatformServerConfig{
+			Logger:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+			StaticContent: config,
+		},
+	}
+}
+
+func getStatic(ps *platformServer, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	ps.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w
+}
+
+func TestPlatformServer_StaticRouteMissing(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "public")
+	placeholder := filepath.Join(t.TempDir(), "soon.html")
+	require.NoError(t, os.WriteFile(placeholder, []byte("<p>back soon</p>"), 0644))
+	ps := newTestStaticServer(&StaticContentConfig{Placeholder: true, PlaceholderFile: placeholder})
+	ps.SetStaticRoute("/public", dir)
+
+	problems := ps.CheckStaticContent()
+	if assert.Len(t, problems, 1) {
+		assert.Contains(t, problems[0].Error(), "does not exist")
+	}
+	w := getStatic(ps, "/public/hello.txt")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "<p>back soon</p>", w.Body.String())
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+
+	// content deployed after startup is served once checked again
+	require.NoError(t, os.Mkdir(dir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "hello.txt"), []byte("hello"), 0644))
+	assert.Empty(t, ps.CheckStaticContent())
+	w = getStatic(ps, "/public/hello.txt")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "hello", w.Body.String())
+}
+
+func TestPlatformServer_StaticRouteNotADirectory(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "public")
+	require.NoError(t, os.WriteFile(file, []byte("oops"), 0644))
+	ps := newTestStaticServer(nil)
+	ps.SetStaticRoute("/public", file)
+
+	problems := ps.CheckStaticContent()
+	if assert.Len(t, problems, 1) {
+		assert.Contains(t, problems[0].Error(), "is not a directory")
+	}
+	// without a placeholder missing content is still a 404
+	assert.Equal(t, http.StatusNotFound, getStatic(ps, "/public/oops").Code)
+}
+
+func TestPlatformServer_SetStaticRouteFS(t *testing.T) {
+	ps := newTestStaticServer(nil)
+	ps.SetStaticRouteFS("/assets", fstest.MapFS{"app.js": {Data: []byte("console.log('moo')")}})
+
+	assert.Empty(t, ps.CheckStaticContent())
+	w := getStatic(ps, "/assets/app.js")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "console.log('moo')", w.Body.String())
+	assert.Equal(t, http.StatusNotFound, getStatic(ps, "/assets/nope.js").Code)
+}
+
+func TestPlatformServer_SpaAssets(t *testing.T) {
+	ps := newTestStaticServer(&StaticContentConfig{Placeholder: true})
+	assets := fstest.MapFS{
+		"dist/index.html":      {Data: []byte("<html>spa</html>")},
+		"dist/assets/main.css": {Data: []byte("body{}")},
+	}
+	ps.serverConfig.SpaConfig = &SpaConfig{
+		RootFolder:   "./dist/",
+		BaseUri:      "/",
+		StaticAssets: []string{"dist/assets:/assets"},
+		Assets:       assets,
+	}
+	ps.configureSPA()
+
+	assert.Empty(t, ps.CheckStaticContent())
+	w := getStatic(ps, "/cows/daisy")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "<html>spa</html>", w.Body.String())
+	assert.Equal(t, "body{}", getStatic(ps, "/assets/main.css").Body.String())
+
+	// the SPA needs its index.html, the placeholder is served without it
+	delete(assets, "dist/index.html")
+	problems := ps.CheckStaticContent()
+	if assert.Len(t, problems, 1) {
+		assert.Contains(t, problems[0].Error(), "index.html")
+	}
+	w = getStatic(ps, "/cows/daisy")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "Content unavailable")
+}
+
+func TestPlatformServer_SetStaticRouteFS_ETag(t *testing.T) {
+	ps := newTestStaticServer(nil)
+	assets := fstest.MapFS{
+		"app.js":          {Data: []byte("console.log('moo')")},
+		"docs/index.html": {Data: []byte("<p>docs</p>")},
+	}
+	ps.SetStaticRouteFS("/assets", assets)
+
+	w := getStatic(ps, "/assets/app.js")
+	etag := w.Header().Get("ETag")
+	assert.Regexp(t, `^"[0-9a-f]{32}"$`, etag)
+	assert.NotEmpty(t, getStatic(ps, "/assets/docs/").Header().Get("ETag"))
+
+	// revalidating unchanged content is not modified
+	r := httptest.NewRequest(http.MethodGet, "/assets/app.js", nil)
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	ps.router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	// changed content gets a new ETag
+	assets["app.js"] = &fstest.MapFile{Data: []byte("console.log('moooo')")}
+	assert.NotEqual(t, etag, getStatic(ps, "/assets/app.js").Header().Get("ETag"))
+}
+
+func TestPlatformServer_SpaAssetsIndexFallback(t *testing.T) {
+	ps := newTestStaticServer(nil)
+	ps.serverConfig.SpaConfig = &SpaConfig{
+		RootFolder: "dist",
+		BaseUri:    "/app",
+		Assets: fstest.MapFS{
+			"dist/index.html":    {Data: []byte("<html>spa</html>")},
+			"dist/main.js":       {Data: []byte("main()")},
+			"dist/cows/daisy.md": {Data: []byte("# daisy")},
+		},
+	}
+	ps.configureSPA()
+
+	for _, uri := range []string{"/app", "/app/", "/app/cows", "/app/cows/daisy"} {
+		w := getStatic(ps, uri)
+		assert.Equal(t, http.StatusOK, w.Code, uri)
+		assert.Equal(t, "<html>spa</html>", w.Body.String(), uri)
+		assert.NotEmpty(t, w.Header().Get("ETag"), uri)
+	}
+	assert.Equal(t, "main()", getStatic(ps, "/app/main.js").Body.String())
+	assert.Equal(t, "# daisy", getStatic(ps, "/app/cows/daisy.md").Body.String())
+
+	// missing resources are not found, unless a page is requested
+	assert.Equal(t, http.StatusNotFound, getStatic(ps, "/app/missing.js").Code)
+	r := httptest.NewRequest(http.MethodGet, "/app/users/jane.doe", nil)
+	r.Header.Set("Accept", "text/html,application/xhtml+xml")
+	w := httptest.NewRecorder()
+	ps.router.ServeHTTP(w, r)
+	assert.Equal(t, "<html>spa</html>", w.Body.String())
+}
@@ -0,0 +1,124 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// SetStaticRouteFS adds a route where the static resources of fsys are served, e.g. the UI of the
+// application embedded in its binary with an embed.FS. Resources are served with an ETag derived from their
+// content, so clients revalidating them get a 304 Not Modified until a new build changes them.
+func (ps *platformServer) SetStaticRouteFS(prefix string, fsys fs.FS, middlewareFn ...mux.MiddlewareFunc) {
+	etags := newContentETags(fsys)
+	fileServer := http.FileServer(NoDirFileSystem{http.FS(fsys)})
+	ps.setStaticMount(&staticMount{uri: prefix, fsys: fsys}, func(w http.ResponseWriter, r *http.Request) {
+		etags.set(w, fsName(r.URL.Path))
+		fileServer.ServeHTTP(w, r)
+	}, middlewareFn...)
+}
+
+// spaFSHandler serves the SPA from fsys at baseUri. Resources that exist are served as they are, any other
+// path is a route of the application and is served its index.html, except for missing resources with an
+// extension that are not requested as a page, such as a script, which are not found.
+func spaFSHandler(baseUri string, fsys fs.FS) http.HandlerFunc {
+	etags := newContentETags(fsys)
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := fsName(strings.TrimPrefix(r.URL.Path, strings.TrimSuffix(baseUri, "/")))
+		info, err := fs.Stat(fsys, name)
+		if err != nil && path.Ext(name) != "" && !strings.Contains(r.Header.Get("Accept"), "text/html") {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil || info.IsDir() {
+			name = "index.html"
+		}
+		etags.set(w, name)
+		http.ServeFileFS(w, r, fsys, name)
+	}
+}
+
+// fsName returns the name in a file system of a URL path.
+func fsName(urlPath string) string {
+	name := strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// contentETags computes the ETags of the files of a file system from their content. They are computed once
+// per file and kept as long as its size and modification time do not change.
+type contentETags struct {
+	fsys  fs.FS
+	cache sync.Map
+}
+
+func newContentETags(fsys fs.FS) *contentETags {
+	return &contentETags{fsys: fsys}
+}
+
+// set sets the ETag header of the response serving a file, the index.html of a directory. The ETag is
+// left out if the file cannot be read, so serving it reports why.
+func (e *contentETags) set(w http.ResponseWriter, name string) {
+	if etag, ok := e.etag(name); ok {
+		w.Header().Set("ETag", etag)
+	}
+}
+
+func (e *contentETags) etag(name string) (string, bool) {
+	info, err := fs.Stat(e.fsys, name)
+	if err == nil && info.IsDir() {
+		name = path.Join(name, "index.html")
+		info, err = fs.Stat(e.fsys, name)
+	}
+	if err != nil || info.IsDir() {
+		return "", false
+	}
+	key := fmt.Sprintf("%s|%d|%d", name, info.Size(), info.ModTime().UnixNano())
+	if etag, ok := e.cache.Load(key); ok {
+		return etag.(string), true
+	}
+	f, err := e.fsys.Open(name)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err = io.Copy(hash, f); err != nil {
+		return "", false
+	}
+	etag := `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+	e.cache.Store(key, etag)
+	return etag, true
+}
+
+// missingFS is the file system of a directory that does not exist.
+type missingFS struct{}
+
+func (missingFS) Open(name string) (fs.File, error) {
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// subFS returns the directory of fsys at dir, given as a relative path such as "dist/" or "./dist". An
+// invalid path yields an empty file system, reported like a missing directory.
+func subFS(fsys fs.FS, dir string) fs.FS {
+	dir = path.Clean(strings.TrimPrefix(filepath.ToSlash(dir), "/"))
+	sub, err := fs.Sub(fsys, dir)
+	if err != nil {
+		return missingFS{}
+	}
+	return sub
+}
@@ -0,0 +1,38 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"github.com/pb33f/ranch/bus"
+)
+
+// startStoreAccess restricts client access to the stores with a rule, if store access is configured, and
+// to the other stores as the access control file says, if there is one.
+func (ps *platformServer) startStoreAccess() {
+	cfg := ps.serverConfig.StoreAccess
+	if cfg == nil && ps.acl == nil {
+		return
+	}
+	accessControl := bus.NewStoreAccessControl()
+	var rules int
+	if cfg != nil {
+		for storeName, rule := range cfg.Stores {
+			accessControl.SetRule(storeName, rule)
+		}
+		rules = len(cfg.Stores)
+	}
+	if ps.acl != nil {
+		accessControl.SetFallback(ps.aclStoreAccess)
+	}
+	ps.eventbus.GetStoreManager().SetAccessControl(accessControl)
+	ps.serverConfig.Logger.Info("[ranch] store access control enabled", "stores", rules, "acl", ps.acl != nil)
+}
+
+// stopStoreAccess lifts the restrictions set by startStoreAccess.
+func (ps *platformServer) stopStoreAccess() {
+	if ps.serverConfig.StoreAccess == nil && ps.acl == nil {
+		return
+	}
+	ps.eventbus.GetStoreManager().SetAccessControl(nil)
+}
@@ -0,0 +1,249 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/clock"
+)
+
+const (
+	storeBackupPrefix         = "ranch-stores-"
+	defaultStoreBackupKeep    = 10
+	defaultMaxStoreRestoreLen = 64 << 20
+)
+
+// StoreBackupDestination receives the scheduled store backups, e.g. a directory or an object store bucket.
+type StoreBackupDestination interface {
+	// Save stores a backup under name, a name sorting after the names of earlier backups.
+	Save(name string, backup []byte) error
+}
+
+// FileBackupDestination writes backups to a directory, keeping the most recent ones.
+type FileBackupDestination struct {
+	Dir  string // directory backups are written to, created if missing
+	Keep int    // number of backups kept, older ones are deleted. defaults to 10
+}
+
+// Save writes the backup to a temporary file first, so a partial backup never replaces a complete one.
+func (d *FileBackupDestination) Save(name string, backup []byte) error {
+	if err := os.MkdirAll(d.Dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(d.Dir, ".tmp-"+name)
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(backup)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(d.Dir, name))
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return d.prune()
+}
+
+func (d *FileBackupDestination) prune() error {
+	keep := d.Keep
+	if keep <= 0 {
+		keep = defaultStoreBackupKeep
+	}
+	entries, err := os.ReadDir(d.Dir)
+	if err != nil {
+		return err
+	}
+	var backups []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasPrefix(entry.Name(), storeBackupPrefix) {
+			backups = append(backups, entry.Name())
+		}
+	}
+	sort.Strings(backups)
+	for len(backups) > keep {
+		if err = os.Remove(filepath.Join(d.Dir, backups[0])); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+func storeBackupName(t time.Time) string {
+	return fmt.Sprintf("%s%s.json", storeBackupPrefix, t.UTC().Format("20060102T150405.000Z"))
+}
+
+// setStoreBackupRoute registers the endpoint store backups are downloaded from (GET) and restored with
+// (POST), if one is configured.
+func (ps *platformServer) setStoreBackupRoute() {
+	cfg := ps.serverConfig.StoreBackup
+	if cfg == nil || cfg.Endpoint == "" {
+		return
+	}
+	maxRestoreBytes := cfg.MaxRestoreBytes
+	if maxRestoreBytes <= 0 {
+		maxRestoreBytes = defaultMaxStoreRestoreLen
+	}
+	ps.router.Path(cfg.Endpoint).Name(cfg.Endpoint).Methods(http.MethodGet, http.MethodPost).HandlerFunc(
+		ps.adminHandler("store backup", cfg.Authorize, func(w http.ResponseWriter, r *http.Request) {
+			storeManager := ps.eventbus.GetStoreManager()
+			if r.Method == http.MethodPost {
+				if err := storeManager.Restore(http.MaxBytesReader(w, r.Body, maxRestoreBytes)); err != nil {
+					ps.serverConfig.Logger.Error("[ranch] unable to restore stores", "error", err.Error())
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				ps.serverConfig.Logger.Info("[ranch] stores restored from backup", "remote", r.RemoteAddr)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			var buf bytes.Buffer
+			if err := storeManager.Backup(&buf); err != nil {
+				ps.serverConfig.Logger.Error("[ranch] unable to back up stores", "error", err.Error())
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			ps.serverConfig.Logger.Info("[ranch] store backup generated", "remote", r.RemoteAddr, "bytes", buf.Len())
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Disposition",
+				fmt.Sprintf("attachment; filename=\"%s\"", storeBackupName(clock.Now())))
+			w.Header().Set("Cache-Control", "no-store")
+			_, _ = w.Write(buf.Bytes())
+		}))
+	ps.serverConfig.Logger.Info("[ranch] store backup endpoint enabled", "endpoint", cfg.Endpoint)
+}
+
+// setStoreSnapshotRoute registers the endpoint snapshots of single stores are downloaded from (GET) and
+// imported with (POST), if one is configured. The store is named by the last path segment, an imported
+// snapshot replaces the items of that store whatever store it was taken from, so it can seed another store.
+func (ps *platformServer) setStoreSnapshotRoute() {
+	cfg := ps.serverConfig.StoreBackup
+	if cfg == nil || cfg.SnapshotEndpoint == "" {
+		return
+	}
+	maxRestoreBytes := cfg.MaxRestoreBytes
+	if maxRestoreBytes <= 0 {
+		maxRestoreBytes = defaultMaxStoreRestoreLen
+	}
+	endpoint := strings.TrimSuffix(cfg.SnapshotEndpoint, "/") + "/{store}"
+	ps.router.Path(endpoint).Name(endpoint).Methods(http.MethodGet, http.MethodPost).HandlerFunc(
+		ps.adminHandler("store snapshot", cfg.Authorize, func(w http.ResponseWriter, r *http.Request) {
+			storeName := mux.Vars(r)["store"]
+			storeManager := ps.eventbus.GetStoreManager()
+			if r.Method == http.MethodPost {
+				var snapshot bus.StoreSnapshot
+				if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRestoreBytes)).Decode(&snapshot); err != nil {
+					http.Error(w, fmt.Sprintf("cannot read store snapshot: %s", err.Error()), http.StatusBadRequest)
+					return
+				}
+				snapshot.Name = storeName
+				if err := storeManager.ImportSnapshot(&snapshot); err != nil {
+					ps.serverConfig.Logger.Error("[ranch] unable to import store snapshot", "store", storeName,
+						"error", err.Error())
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				ps.serverConfig.Logger.Info("[ranch] store snapshot imported", "remote", r.RemoteAddr,
+					"store", storeName, "items", len(snapshot.Items))
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			if storeManager.GetStore(storeName) == nil {
+				http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+				return
+			}
+			snapshot, err := storeManager.ExportSnapshot(storeName)
+			if err != nil {
+				ps.serverConfig.Logger.Error("[ranch] unable to export store snapshot", "store", storeName,
+					"error", err.Error())
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s-%s.json\"",
+				url.PathEscape(storeName), snapshot.Created.Format("20060102T150405.000Z")))
+			w.Header().Set("Cache-Control", "no-store")
+			_ = json.NewEncoder(w).Encode(snapshot)
+		}))
+	ps.serverConfig.Logger.Info("[ranch] store snapshot endpoint enabled", "endpoint", endpoint)
+}
+
+// startStoreBackups backs up the stores on the configured interval, until the server stops.
+func (ps *platformServer) startStoreBackups() {
+	cfg := ps.serverConfig.StoreBackup
+	if cfg == nil || cfg.IntervalSeconds <= 0 {
+		return
+	}
+	destination := cfg.Destination
+	if destination == nil {
+		if cfg.Directory == "" {
+			ps.serverConfig.Logger.Error("[ranch] scheduled store backups need a destination or a directory")
+			return
+		}
+		destination = &FileBackupDestination{Dir: cfg.Directory, Keep: cfg.Keep}
+	}
+
+	stop := make(chan struct{})
+	ps.lock.Lock()
+	ps.storeBackupStop = stop
+	ps.lock.Unlock()
+
+	interval := time.Duration(cfg.IntervalSeconds) * time.Second
+	ps.serverConfig.Logger.Info("[ranch] scheduled store backups enabled", "interval", interval.String())
+	go func() {
+		ticker := clock.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case t := <-ticker.C():
+				ps.backupStores(destination, t)
+			}
+		}
+	}()
+}
+
+func (ps *platformServer) backupStores(destination StoreBackupDestination, t time.Time) {
+	var buf bytes.Buffer
+	err := ps.eventbus.GetStoreManager().Backup(&buf)
+	name := storeBackupName(t)
+	if err == nil {
+		err = destination.Save(name, buf.Bytes())
+	}
+	if err != nil {
+		ps.serverConfig.Logger.Error("[ranch] scheduled store backup failed", "name", name, "error", err.Error())
+		return
+	}
+	ps.serverConfig.Logger.Debug("[ranch] scheduled store backup saved", "name", name, "bytes", buf.Len())
+}
+
+// stopStoreBackups stops the scheduled store backups.
+func (ps *platformServer) stopStoreBackups() {
+	ps.lock.Lock()
+	stop := ps.storeBackupStop
+	ps.storeBackupStop = nil
+	ps.lock.Unlock()
+	if stop != nil {
+		close(stop)
+	}
+}
@@ -0,0 +1,34 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileBackupDestination_Save(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "backups")
+	d := &FileBackupDestination{Dir: dir, Keep: 2}
+
+	start := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, d.Save(storeBackupName(start.Add(time.Duration(i)*time.Minute)), []byte("{}")))
+	}
+
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.Equal(t, []string{
+		"ranch-stores-20230501T120100.000Z.json",
+		"ranch-stores-20230501T120200.000Z.json",
+	}, names)
+}
@@ -0,0 +1,69 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pb33f/ranch/bridge"
+	"github.com/pb33f/ranch/bus"
+)
+
+// storeDatabaseFile is the bbolt database persistent stores are kept in, inside the configured directory.
+const storeDatabaseFile = "stores.db"
+
+// initStorePersistence backs the configured stores with persistence. It runs before services are
+// registered, so the stores they create pick up their saved items.
+func (ps *platformServer) initStorePersistence() {
+	cfg := ps.serverConfig.StorePersistence
+	if cfg == nil || len(cfg.Stores) == 0 {
+		return
+	}
+	persistence := cfg.Persistence
+	if persistence == nil && cfg.Redis != nil {
+		connectorConfig := &bridge.BrokerConnectorConfig{
+			ServerAddr: cfg.Redis.ServerAddr,
+			Username:   cfg.Redis.Username,
+			Password:   cfg.Redis.Password,
+		}
+		if cfg.Redis.UseTLS {
+			connectorConfig.WebSocketConfig = &bridge.WebSocketConfig{UseTLS: true}
+		}
+		redisPersistence, err := bridge.NewRedisStorePersistence(connectorConfig, cfg.Redis.KeyPrefix,
+			ps.serverConfig.Debug)
+		if err != nil {
+			panic(err)
+		}
+		persistence = redisPersistence
+		ps.storePersistence = redisPersistence
+	}
+	if persistence == nil {
+		if err := os.MkdirAll(cfg.Directory, 0700); err != nil {
+			panic(err)
+		}
+		boltPersistence, err := bus.NewBoltStorePersistence(filepath.Join(cfg.Directory, storeDatabaseFile),
+			cfg.SyncWrites)
+		if err != nil {
+			panic(err)
+		}
+		persistence = boltPersistence
+		ps.storePersistence = boltPersistence
+	}
+	if err := ps.eventbus.GetStoreManager().SetStorePersistence(persistence, cfg.Stores...); err != nil {
+		panic(err)
+	}
+	ps.serverConfig.Logger.Info("[ranch] persistent stores enabled", "stores", cfg.Stores)
+}
+
+// closeStorePersistence closes the persistence created from the configuration, once nothing writes
+// to the stores anymore.
+func (ps *platformServer) closeStorePersistence() {
+	if ps.storePersistence == nil {
+		return
+	}
+	if err := ps.storePersistence.Close(); err != nil {
+		ps.serverConfig.Logger.Error("[ranch] unable to close store persistence", "error", err.Error())
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"crypto/tls"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/pb33f/ranch/clock"
+)
+
+// defaultCertificateReloadInterval is how often the certificate files are checked for changes, unless configured.
+const defaultCertificateReloadInterval = 10 * time.Second
+
+// certificateReloader serves the certificate of the cert and key files, and swaps in the new one when they
+// change, so handshakes pick it up without restarting the listeners.
+type certificateReloader struct {
+	certFile, keyFile string
+	fips              bool                            // certificates must pass the FIPS checks to be used
+	cert              atomic.Pointer[tls.Certificate] // certificate served
+	lock              sync.Mutex                      // one reload at a time
+	loaded            [2]os.FileInfo                  // cert and key files the certificate was loaded from
+	stop              chan struct{}                   // stops the reloads, nil until started
+}
+
+// newCertificateReloader loads the certificate of the cert and key files.
+func newCertificateReloader(certFile, keyFile string, fips bool) (*certificateReloader, error) {
+	r := &certificateReloader{certFile: certFile, keyFile: keyFile, fips: fips}
+	if _, err := r.reload(true); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate returns the certificate served, for tls.Config.
+func (r *certificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load(), nil
+}
+
+// reload loads the certificate again if the files changed since it was loaded, or if forced. The certificate
+// served is kept if the files cannot be loaded, e.g. while the key of a new certificate is yet to be written.
+func (r *certificateReloader) reload(force bool) (bool, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return false, err
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return false, err
+	}
+	if !force && sameFile(r.loaded[0], certInfo) && sameFile(r.loaded[1], keyInfo) {
+		return false, nil
+	}
+	if r.fips {
+		if err = validateFIPSCertificate(r.certFile, r.keyFile); err != nil {
+			return false, err
+		}
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, err
+	}
+	r.cert.Store(&cert)
+	r.loaded = [2]os.FileInfo{certInfo, keyInfo}
+	return true, nil
+}
+
+// sameFile tells whether a file is unchanged since it was last seen.
+func sameFile(seen, info os.FileInfo) bool {
+	return seen != nil && seen.ModTime().Equal(info.ModTime()) && seen.Size() == info.Size()
+}
+
+// initCertificateReloads serves the certificate files through a reloader, unless certificates come from an
+// ACME CA. It runs once the TLS configuration of the HTTP server is set up.
+func (ps *platformServer) initCertificateReloads() {
+	certConfig := ps.serverConfig.TLSCertConfig
+	if certConfig == nil || certConfig.ACME != nil {
+		return
+	}
+	reloader, err := newCertificateReloader(certConfig.CertFile, certConfig.KeyFile, certConfig.FIPSMode)
+	if err != nil {
+		ps.serverConfig.Logger.Error(wrapError(errServerInit, err).Error())
+		return
+	}
+	if ps.HttpServer.TLSConfig == nil {
+		ps.HttpServer.TLSConfig = &tls.Config{}
+	}
+	ps.HttpServer.TLSConfig.GetCertificate = reloader.GetCertificate
+	ps.certificates = reloader
+}
+
+// startCertificateReloads reloads the certificate files when they change, and on SIGHUP, until the server stops.
+func (ps *platformServer) startCertificateReloads() {
+	reloader := ps.certificates
+	if reloader == nil {
+		return
+	}
+	interval := defaultCertificateReloadInterval
+	if seconds := ps.serverConfig.TLSCertConfig.ReloadIntervalSeconds; seconds > 0 {
+		interval = time.Duration(seconds) * time.Second
+	}
+	stop := make(chan struct{})
+	ps.lock.Lock()
+	reloader.stop = stop
+	ps.lock.Unlock()
+
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hangups)
+		var ticks <-chan time.Time
+		if ps.serverConfig.TLSCertConfig.ReloadIntervalSeconds >= 0 {
+			ticker := clock.NewTicker(interval)
+			defer ticker.Stop()
+			ticks = ticker.C()
+		}
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticks:
+				ps.reloadCertificate(false)
+			case <-hangups:
+				ps.reloadCertificate(true)
+			}
+		}
+	}()
+}
+
+// reloadCertificate loads the certificate files again, if they changed or if forced.
+func (ps *platformServer) reloadCertificate(force bool) {
+	reloaded, err := ps.certificates.reload(force)
+	if err != nil {
+		ps.serverConfig.Logger.Error("[ranch] TLS certificate not reloaded, still serving the previous one",
+			"error", err.Error())
+		return
+	}
+	if reloaded {
+		ps.serverConfig.Logger.Info("[ranch] TLS certificate reloaded", "file", ps.certificates.certFile)
+	}
+}
+
+// stopCertificateReloads stops reloading the certificate files.
+func (ps *platformServer) stopCertificateReloads() {
+	if ps.certificates == nil {
+		return
+	}
+	ps.lock.Lock()
+	stop := ps.certificates.stop
+	ps.certificates.stop = nil
+	ps.lock.Unlock()
+	if stop != nil {
+		close(stop)
+	}
+}
+
+// certFiles returns the cert and key files HTTPS servers load their certificate from, none if the TLS
+// configuration of the server gets the certificates itself, as they would take precedence for clients
+// that do not send a server name.
+func (ps *platformServer) certFiles() (string, string) {
+	if ps.HttpServer.TLSConfig != nil && ps.HttpServer.TLSConfig.GetCertificate != nil {
+		return "", ""
+	}
+	return ps.serverConfig.TLSCertConfig.CertFile, ps.serverConfig.TLSCertConfig.KeyFile
+}
@@ -0,0 +1,96 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pb33f/ranch/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCertificateReloads(t *testing.T) {
+	fake := clock.NewFakeClock(time.Now())
+	clock.Set(fake)
+	defer clock.Reset()
+
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", GetTestPort(), true)
+	config.TLSCertConfig = GetTestTLSCertConfig(t.TempDir())
+	ps := protocolServer(config)
+	require.NotNil(t, ps.certificates)
+	certFile, keyFile := ps.certFiles()
+	assert.Empty(t, certFile)
+	assert.Empty(t, keyFile)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = ps.HttpServer.ServeTLS(listener, certFile, keyFile) }()
+	defer ps.HttpServer.Close()
+	url := "https://" + listener.Addr().String()
+
+	// served returns the names of the certificate the server answers handshakes with
+	served := func(serverName string) []string {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{ServerName: serverName, InsecureSkipVerify: true}}}
+		rsp, err := client.Get(url + "/proto")
+		require.NoError(t, err)
+		_ = rsp.Body.Close()
+		return rsp.TLS.PeerCertificates[0].DNSNames
+	}
+	original := served("")
+
+	// rotate writes a new certificate, dated so it differs from the one replaced
+	modified := time.Now()
+	rotate := func(domain string, key []byte) {
+		certPEM, keyPEM := testCertificatePEM(t, domain)
+		if key != nil {
+			keyPEM = key
+		}
+		modified = modified.Add(time.Second)
+		require.NoError(t, os.WriteFile(config.TLSCertConfig.CertFile, certPEM, 0600))
+		require.NoError(t, os.WriteFile(config.TLSCertConfig.KeyFile, keyPEM, 0600))
+		require.NoError(t, os.Chtimes(config.TLSCertConfig.CertFile, modified, modified))
+		require.NoError(t, os.Chtimes(config.TLSCertConfig.KeyFile, modified, modified))
+	}
+
+	ps.startCertificateReloads()
+	defer ps.stopCertificateReloads()
+
+	// rotated certificates are picked up by the running listener on the next check
+	rotate("ranch.example", nil)
+	require.Eventually(t, func() bool {
+		fake.Advance(defaultCertificateReloadInterval)
+		return served("")[0] == "ranch.example"
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"ranch.example"}, served("localhost"))
+
+	// a certificate that does not load, e.g. halfway through a rotation, leaves the previous one in place
+	reloaded, err := ps.certificates.reload(false)
+	require.NoError(t, err)
+	assert.False(t, reloaded)
+	rotate("rustler.example", []byte("not a key"))
+	reloaded, err = ps.certificates.reload(false)
+	assert.Error(t, err)
+	assert.False(t, reloaded)
+	assert.Equal(t, []string{"ranch.example"}, served(""))
+	assert.NotEqual(t, original, served(""))
+
+	// SIGHUP reloads them at once
+	if runtime.GOOS != "windows" {
+		rotate("barn.example", nil)
+		process, err := os.FindProcess(os.Getpid())
+		require.NoError(t, err)
+		require.NoError(t, process.Signal(syscall.SIGHUP))
+		assert.Eventually(t, func() bool { return served("")[0] == "barn.example" }, 5*time.Second,
+			10*time.Millisecond)
+	}
+}
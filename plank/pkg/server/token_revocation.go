@@ -0,0 +1,84 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/model"
+	"github.com/pb33f/ranch/stompserver"
+)
+
+// revokedTokenChallenge tells clients presenting a revoked bearer token to get a new one (RFC 6750).
+const revokedTokenChallenge = `Bearer error="invalid_token", error_description="the token has been revoked"`
+
+// initTokenRevocations sets up the list of revoked session tokens, shared by the fabric broker and the
+// HTTP routes so a token revoked on TOKEN_REVOCATION_CHANNEL is refused by both.
+func (ps *platformServer) initTokenRevocations() {
+	if fc := ps.serverConfig.FabricConfig; fc != nil && fc.EndpointConfig != nil && fc.EndpointConfig.RevocationList != nil {
+		ps.revocations = fc.EndpointConfig.RevocationList
+		return
+	}
+	ps.revocations = stompserver.NewRevocationList()
+}
+
+// startTokenRevocations adds every token published on TOKEN_REVOCATION_CHANNEL to the revocation list.
+func (ps *platformServer) startTokenRevocations() {
+	cm := ps.eventbus.GetChannelManager()
+	if !cm.CheckChannelExists(bus.TOKEN_REVOCATION_CHANNEL) {
+		cm.CreateChannel(bus.TOKEN_REVOCATION_CHANNEL)
+	}
+	handler, err := ps.eventbus.ListenFirehose(bus.TOKEN_REVOCATION_CHANNEL)
+	if err != nil {
+		ps.serverConfig.Logger.Error(wrapError(errServerInit, err).Error())
+		return
+	}
+	handler.Handle(func(msg *model.Message) {
+		revocation, err := bus.DecodeTokenRevocation(msg.Payload)
+		if err != nil {
+			ps.serverConfig.Logger.Warn("[ranch] ignoring invalid token revocation", "error", err.Error())
+			return
+		}
+		ps.revocations.Revoke(revocation.Token, revocation.ExpiresAt)
+	}, func(err error) {})
+
+	ps.lock.Lock()
+	ps.revocationHandler = handler
+	ps.lock.Unlock()
+}
+
+// stopTokenRevocations stops picking up revocations, the tokens revoked so far stay revoked.
+func (ps *platformServer) stopTokenRevocations() {
+	ps.lock.Lock()
+	handler := ps.revocationHandler
+	ps.revocationHandler = nil
+	ps.lock.Unlock()
+	if handler != nil {
+		handler.Close()
+	}
+}
+
+// revocationMiddleware refuses HTTP requests bearing a revoked session token with 401.
+func (ps *platformServer) revocationMiddleware(next http.Handler) http.Handler {
+	revocations := ps.revocations
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if revocations.IsRevoked(bearerToken(r)) {
+			w.Header().Set("WWW-Authenticate", revokedTokenChallenge)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bearerToken returns the bearer token of the Authorization header of a request, empty if there is none.
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < len("Bearer ") || !strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(auth[len("Bearer "):])
+}
@@ -0,0 +1,75 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenRevocation_RefusesRevokedBearerTokens(t *testing.T) {
+	b := bus.ResetBus()
+	service.ResetServiceRegistry()
+	config := GetBasicTestServerConfig(os.TempDir(), "stdout", "stdout", "stderr", GetTestPort(), true)
+	ps := NewPlatformServer(config).(*platformServer)
+	ps.eventbus = b
+	ps.router.HandleFunc("/cows", func(w http.ResponseWriter, r *http.Request) {})
+	ps.loadGlobalHttpHandler(ps.router)
+	ps.startTokenRevocations()
+	defer ps.stopTokenRevocations()
+
+	serve := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/cows", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		ps.HttpServer.Handler.ServeHTTP(w, req)
+		return w
+	}
+	assert.Equal(t, http.StatusOK, serve("stolen").Code)
+
+	require.NoError(t, bus.RevokeSessionToken(b, &bus.TokenRevocation{Token: "stolen", Reason: "leaked"}))
+	assert.Eventually(t, func() bool { return serve("stolen").Code == http.StatusUnauthorized },
+		time.Second, 5*time.Millisecond)
+	assert.Equal(t, revokedTokenChallenge, serve("stolen").Header().Get("WWW-Authenticate"))
+
+	// other tokens, and requests without one, are let through
+	assert.Equal(t, http.StatusOK, serve("fresh").Code)
+	assert.Equal(t, http.StatusOK, serve("").Code)
+}
+
+func TestTokenRevocation_SharedThroughBrokerBridges(t *testing.T) {
+	b := bus.ResetBus()
+	conn := newFakeBrokerConnection()
+	bb, _ := newTestBrokerBridge(b, conn)
+	bb.config.TokenRevocations = "/topic/revocations"
+	assert.NoError(t, bb.start())
+	defer bb.stop()
+
+	// revocations of this instance are published to the others
+	require.NoError(t, bus.RevokeSessionToken(b, &bus.TokenRevocation{Token: "stolen"}))
+	select {
+	case msg := <-conn.sent:
+		assert.Equal(t, "/topic/revocations", msg.destination)
+		assert.JSONEq(t, `{"token":"stolen","expiresAt":"0001-01-01T00:00:00Z"}`, string(msg.payload))
+	case <-time.After(time.Second):
+		t.Fatal("revocation was not published to the broker")
+	}
+
+	// and theirs arrive on the revocation channel
+	assert.NotNil(t, conn.getSub("/topic/revocations"))
+
+	// a bridge may share nothing but revocations
+	assert.NoError(t, validateBrokerBridgeConfig(&BrokerBridgeConfig{Name: "a", ServerAddr: "localhost:61613",
+		TokenRevocations: "/topic/revocations"}))
+}
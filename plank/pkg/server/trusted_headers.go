@@ -0,0 +1,156 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/pb33f/ranch/stompserver"
+)
+
+const (
+	defaultTrustedUserHeader      = "X-Forwarded-User"
+	defaultTrustedGroupsHeader    = "X-Forwarded-Groups"
+	defaultTrustedGroupsSeparator = ","
+)
+
+// trustedHeaders authenticates the requests sent by a reverse proxy with the headers it sets.
+type trustedHeaders struct {
+	config       *TrustedHeaderAuthConfig
+	userHeader   string
+	groupsHeader string
+	separator    string
+	proxies      []netip.Prefix
+	logger       *slog.Logger
+	groups       sync.Map // principal -> groups of its last request
+}
+
+// initTrustedHeaders reads the trusted header configuration, if configured. Headers are not trusted if the
+// configuration is invalid.
+func (ps *platformServer) initTrustedHeaders() {
+	cfg := ps.serverConfig.TrustedHeaderAuth
+	if cfg == nil {
+		return
+	}
+	th, err := newTrustedHeaders(cfg, ps.serverConfig.Logger)
+	if err != nil {
+		ps.serverConfig.Logger.Error(wrapError(errServerInit, err).Error())
+		return
+	}
+	ps.trustedHeaders = th
+	ps.serverConfig.Logger.Info("[ranch] trusting the user headers of the reverse proxy", "header", th.userHeader,
+		"proxies", len(th.proxies), "certificates", len(cfg.ProxyCertSubjects))
+}
+
+func newTrustedHeaders(cfg *TrustedHeaderAuthConfig, logger *slog.Logger) (*trustedHeaders, error) {
+	if len(cfg.TrustedProxies) == 0 && len(cfg.ProxyCertSubjects) == 0 {
+		return nil, fmt.Errorf("trusted header authentication needs the addresses or the certificates of the proxy")
+	}
+	th := &trustedHeaders{
+		config:       cfg,
+		userHeader:   cfg.UserHeader,
+		groupsHeader: cfg.GroupsHeader,
+		separator:    cfg.GroupsSeparator,
+		logger:       logger,
+	}
+	if th.userHeader == "" {
+		th.userHeader = defaultTrustedUserHeader
+	}
+	if th.groupsHeader == "" {
+		th.groupsHeader = defaultTrustedGroupsHeader
+	}
+	if th.separator == "" {
+		th.separator = defaultTrustedGroupsSeparator
+	}
+	for _, proxy := range cfg.TrustedProxies {
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(proxy)
+			if addrErr != nil {
+				return nil, fmt.Errorf("trusted proxy '%s' is neither an address nor a CIDR range", proxy)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		th.proxies = append(th.proxies, prefix.Masked())
+	}
+	return th, nil
+}
+
+// trusted returns true if the request was sent by the proxy: from one of its addresses and with one of its
+// certificates, whichever are configured. It looks at the connection the request came in on, so it must
+// run before the forwarded headers replace the remote address.
+func (th *trustedHeaders) trusted(r *http.Request) bool {
+	if len(th.proxies) > 0 {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		addr, err := netip.ParseAddr(host)
+		if err != nil || !slices.ContainsFunc(th.proxies, func(p netip.Prefix) bool {
+			return p.Contains(addr.Unmap())
+		}) {
+			return false
+		}
+	}
+	if len(th.config.ProxyCertSubjects) > 0 {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.PeerCertificates) == 0 ||
+			!slices.Contains(th.config.ProxyCertSubjects,
+				stompserver.ClientCertificatePrincipal(r.TLS.PeerCertificates[0])) {
+			return false
+		}
+	}
+	return true
+}
+
+// middleware sets the principal of the requests of the proxy carrying a user in their context (see
+// stompserver.ContextWithPrincipal), and removes the user and groups headers of the other requests.
+func (th *trustedHeaders) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !th.trusted(r) {
+			if r.Header.Get(th.userHeader) != "" || r.Header.Get(th.groupsHeader) != "" {
+				th.logger.Warn("[ranch] ignoring user headers of a request not sent by the trusted proxy",
+					"remote", r.RemoteAddr, "path", r.URL.Path)
+				r.Header.Del(th.userHeader)
+				r.Header.Del(th.groupsHeader)
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		user := strings.TrimSpace(r.Header.Get(th.userHeader))
+		if user == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var groups []string
+		for _, group := range strings.Split(r.Header.Get(th.groupsHeader), th.separator) {
+			if group = strings.TrimSpace(group); group != "" {
+				groups = append(groups, group)
+			}
+		}
+		principal := user
+		if th.config.Principal != nil {
+			principal = th.config.Principal(user, groups)
+		}
+		if principal != "" {
+			th.groups.Store(principal, groups)
+			r = r.WithContext(stompserver.ContextWithPrincipal(r.Context(), principal))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// roles returns the groups the proxy last sent for the principal.
+func (th *trustedHeaders) roles(principal string) []string {
+	if groups, ok := th.groups.Load(principal); ok {
+		return groups.([]string)
+	}
+	return nil
+}
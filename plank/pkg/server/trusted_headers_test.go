@@ -0,0 +1,102 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/gorilla/handlers"
+	"github.com/pb33f/ranch/bus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewTrustedHeaders_Invalid(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	_, err := newTrustedHeaders(&TrustedHeaderAuthConfig{}, logger)
+	assert.Error(t, err, "the proxy must be verified")
+	_, err = newTrustedHeaders(&TrustedHeaderAuthConfig{TrustedProxies: []string{"proxy.local"}}, logger)
+	assert.Error(t, err)
+	th, err := newTrustedHeaders(&TrustedHeaderAuthConfig{TrustedProxies: []string{"10.0.0.0/8", "::1"}}, logger)
+	require.NoError(t, err)
+	assert.Len(t, th.proxies, 2)
+}
+
+func TestPlatformServer_TrustedHeaders(t *testing.T) {
+	ps := newTestAcl(t, "routes:\n  - path: /admin/*\n    roles: [ops]\nchannels:\n"+
+		"  - channel: alerts\n    read: [ops]\n")
+	ps.serverConfig.TrustedHeaderAuth = &TrustedHeaderAuthConfig{
+		TrustedProxies: []string{"10.1.0.0/16"},
+		Principal: func(user string, groups []string) string {
+			return user + "@corp"
+		},
+	}
+	ps.initTrustedHeaders()
+	require.NotNil(t, ps.trustedHeaders)
+
+	var principal, user string
+	handler := ps.trustedHeaders.middleware(handlers.ProxyHeaders(ps.aclMiddleware(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal = ps.httpPrincipal(r)
+			user = r.Header.Get("X-Forwarded-User")
+		}))))
+	serve := func(remote string, path string, forwardedFor string) int {
+		principal, user = "", ""
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.RemoteAddr = remote
+		r.Header.Set("X-Forwarded-User", "alice")
+		r.Header.Set("X-Forwarded-Groups", "dev, ops")
+		r.Header.Set("X-Forwarded-For", forwardedFor)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// the proxy is trusted by its own address, not the one it forwards
+	assert.Equal(t, http.StatusOK, serve("10.1.2.3:40000", "/admin/stores", "192.168.1.20"))
+	assert.Equal(t, "alice@corp", principal)
+	assert.Equal(t, []string{"dev", "ops"}, ps.trustedHeaders.roles("alice@corp"))
+	assert.NoError(t, ps.withAclAuthorization(&bus.EndpointConfig{TopicPrefix: "/topic/"})(
+		frame.SUBSCRIBE, "/topic/alerts", "alice@corp"), "groups are roles over the fabric too")
+
+	// anyone else cannot forge the headers, even claiming to come from the proxy
+	assert.Equal(t, http.StatusForbidden, serve("192.168.1.20:40000", "/admin/stores", "10.1.2.3"))
+	assert.Equal(t, http.StatusOK, serve("192.168.1.20:40000", "/api/cows", "10.1.2.3"))
+	assert.Empty(t, principal)
+	assert.Empty(t, user, "the headers are removed")
+}
+
+func TestTrustedHeaders_ProxyCertificate(t *testing.T) {
+	th, err := newTrustedHeaders(&TrustedHeaderAuthConfig{
+		TrustedProxies:    []string{"127.0.0.1"},
+		ProxyCertSubjects: []string{"sso-proxy"},
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	require.NoError(t, err)
+	state := func(cn string, verified bool) *tls.ConnectionState {
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: cn}}
+		s := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+		if verified {
+			s.VerifiedChains = [][]*x509.Certificate{{cert}}
+		}
+		return s
+	}
+	trusted := func(remote string, tlsState *tls.ConnectionState) bool {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr, r.TLS = remote, tlsState
+		return th.trusted(r)
+	}
+	assert.True(t, trusted("127.0.0.1:1234", state("sso-proxy", true)))
+	assert.False(t, trusted("127.0.0.1:1234", state("sso-proxy", false)), "the certificate must be verified")
+	assert.False(t, trusted("127.0.0.1:1234", state("someone", true)))
+	assert.False(t, trusted("127.0.0.1:1234", nil))
+	assert.False(t, trusted("127.0.0.2:1234", state("sso-proxy", true)), "both checks apply when both are set")
+}
@@ -0,0 +1,187 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime/metrics"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pb33f/ranch/clock"
+	"github.com/pb33f/ranch/service"
+)
+
+// userCPUMetric is the CPU time spent running Go code, excluding the runtime (GC, scheduler).
+const userCPUMetric = "/cpu/classes/user:cpu-seconds"
+
+// UsageReport accounts for the resources each service consumed during a period, so the cost of a
+// shared instance can be attributed to the services it hosts.
+type UsageReport struct {
+	PeriodStart time.Time             `json:"period_start"`
+	PeriodEnd   time.Time             `json:"period_end"`
+	CPUSeconds  float64               `json:"cpu_seconds"` // CPU time the process spent running Go code
+	Services    []*ServiceUsageReport `json:"services"`
+}
+
+// ServiceUsageReport is the usage of one service during a report period. The runtime cannot measure CPU
+// time per goroutine, so the process CPU time read from runtime/metrics is shared out in proportion to the
+// wall-clock time spent in each handler. Handlers that mostly wait on I/O are charged more than they use.
+type ServiceUsageReport struct {
+	Channel            string  `json:"channel"`
+	Requests           int64   `json:"requests"`
+	Responses          int64   `json:"responses"`
+	ErrorResponses     int64   `json:"error_responses"`
+	RequestBytes       int64   `json:"request_bytes"`
+	ResponseBytes      int64   `json:"response_bytes"`
+	HandlerWallSeconds float64 `json:"handler_wall_seconds"` // wall-clock time spent handling requests
+	CPUSeconds         float64 `json:"cpu_seconds"`          // estimated share of the process CPU time
+}
+
+// usageAccountant remembers the counters at the start of the current report period.
+type usageAccountant struct {
+	lock        sync.Mutex
+	periodStart time.Time
+	cpuSeconds  float64
+	usage       map[string]service.ServiceUsage
+	stop        chan struct{}
+}
+
+func newUsageAccountant() *usageAccountant {
+	return &usageAccountant{
+		periodStart: clock.Now().UTC(),
+		cpuSeconds:  readUserCPUSeconds(),
+		usage:       service.GetServiceRegistry().GetServiceUsage(),
+	}
+}
+
+// report returns the usage since the start of the current period. If closePeriod is set, the next
+// period starts now.
+func (a *usageAccountant) report(closePeriod bool) *UsageReport {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	now := clock.Now().UTC()
+	cpuSeconds := readUserCPUSeconds()
+	usage := service.GetServiceRegistry().GetServiceUsage()
+	report := &UsageReport{
+		PeriodStart: a.periodStart,
+		PeriodEnd:   now,
+		CPUSeconds:  cpuSeconds - a.cpuSeconds,
+		Services:    make([]*ServiceUsageReport, 0, len(usage)),
+	}
+
+	var handlerSeconds float64
+	for channel, current := range usage {
+		previous, ok := a.usage[channel]
+		if !ok || current.Requests < previous.Requests {
+			// registered, or registered again, during the period.
+			previous = service.ServiceUsage{}
+		}
+		svc := &ServiceUsageReport{
+			Channel:            channel,
+			Requests:           current.Requests - previous.Requests,
+			Responses:          current.Responses - previous.Responses,
+			ErrorResponses:     current.ErrorResponses - previous.ErrorResponses,
+			RequestBytes:       current.RequestBytes - previous.RequestBytes,
+			ResponseBytes:      current.ResponseBytes - previous.ResponseBytes,
+			HandlerWallSeconds: (current.HandlerWallTime - previous.HandlerWallTime).Seconds(),
+		}
+		handlerSeconds += svc.HandlerWallSeconds
+		report.Services = append(report.Services, svc)
+	}
+	if handlerSeconds > 0 {
+		for _, svc := range report.Services {
+			svc.CPUSeconds = report.CPUSeconds * svc.HandlerWallSeconds / handlerSeconds
+		}
+	}
+	sort.Slice(report.Services, func(i, j int) bool {
+		return report.Services[i].Channel < report.Services[j].Channel
+	})
+
+	if closePeriod {
+		a.periodStart, a.cpuSeconds, a.usage = now, cpuSeconds, usage
+	}
+	return report
+}
+
+func readUserCPUSeconds() float64 {
+	sample := []metrics.Sample{{Name: userCPUMetric}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() != metrics.KindFloat64 {
+		return 0
+	}
+	return sample[0].Value.Float64()
+}
+
+// initUsageAccounting registers the usage report endpoint, if usage accounting is configured.
+func (ps *platformServer) initUsageAccounting() {
+	cfg := ps.serverConfig.UsageAccounting
+	if cfg == nil {
+		return
+	}
+	service.GetServiceRegistry().EnableUsageAccounting(true)
+	ps.usageAccountant = newUsageAccountant()
+	if cfg.Endpoint == "" {
+		return
+	}
+	ps.router.Path(cfg.Endpoint).Name(cfg.Endpoint).Methods(http.MethodGet).HandlerFunc(ps.adminHandler(
+		"usage report", cfg.Authorize, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "no-store")
+			_ = json.NewEncoder(w).Encode(ps.usageAccountant.report(false))
+		}))
+	ps.serverConfig.Logger.Info("[ranch] usage report endpoint enabled", "endpoint", cfg.Endpoint)
+}
+
+// startUsageReports publishes a usage report on RANCH_USAGE_REPORT_CHANNEL at the end of every
+// report period, until the server stops.
+func (ps *platformServer) startUsageReports() {
+	cfg := ps.serverConfig.UsageAccounting
+	accountant := ps.usageAccountant
+	if cfg == nil || accountant == nil || cfg.ReportIntervalSeconds <= 0 {
+		return
+	}
+	ps.eventbus.GetChannelManager().CreateChannel(RANCH_USAGE_REPORT_CHANNEL)
+	stop := make(chan struct{})
+	ps.lock.Lock()
+	accountant.stop = stop
+	ps.lock.Unlock()
+
+	interval := time.Duration(cfg.ReportIntervalSeconds) * time.Second
+	ps.serverConfig.Logger.Info("[ranch] publishing usage reports", "channel", RANCH_USAGE_REPORT_CHANNEL,
+		"interval", interval.String())
+	go func() {
+		ticker := clock.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C():
+				report := accountant.report(true)
+				ps.serverConfig.Logger.Debug("[ranch] usage report published", "services", len(report.Services),
+					"cpu_seconds", report.CPUSeconds)
+				_ = ps.eventbus.SendResponseMessage(RANCH_USAGE_REPORT_CHANNEL, report, nil)
+			}
+		}
+	}()
+}
+
+// stopUsageReports stops publishing usage reports.
+func (ps *platformServer) stopUsageReports() {
+	accountant := ps.usageAccountant
+	if accountant == nil {
+		return
+	}
+	ps.lock.Lock()
+	stop := accountant.stop
+	accountant.stop = nil
+	ps.lock.Unlock()
+	if stop != nil {
+		close(stop)
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pb33f/ranch/clock"
+	"github.com/pb33f/ranch/service"
+)
+
+const (
+	defaultWarmupTimeout     = 60 * time.Second
+	defaultRampStartPercent  = 10
+	defaultRampRetryAfter    = 1
+	warmupReadyCheckInterval = 20 * time.Millisecond
+)
+
+// runWarmup waits for the services to be ready and runs the warm-up hooks of those implementing
+// service.OnWarmupEnabled. Services failing to warm up, or taking longer than the timeout, are reported
+// and do not hold the server up any longer.
+func (ps *platformServer) runWarmup() {
+	lcm := service.GetServiceLifecycleManager()
+	hooks := make(map[string]service.OnWarmupEnabled)
+	for _, channel := range service.GetServiceRegistry().GetAllServiceChannels() {
+		if hook := lcm.GetOnWarmupService(channel); hook != nil {
+			hooks[channel] = hook
+		}
+	}
+	if len(hooks) == 0 {
+		return
+	}
+	timeout := defaultWarmupTimeout
+	if cfg := ps.serverConfig.Warmup; cfg != nil && cfg.TimeoutSeconds > 0 {
+		timeout = time.Duration(cfg.TimeoutSeconds) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if waiting := ps.awaitServicesReady(ctx); len(waiting) > 0 {
+		ps.serverConfig.Logger.Warn("[ranch] services still not ready, warming up anyway", "services",
+			strings.Join(waiting, ","))
+	}
+	ps.serverConfig.Logger.Info("[ranch] warming up services", "services", len(hooks))
+	started := clock.Now()
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for channel, hook := range hooks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := hook.OnWarmup(ctx); err != nil {
+				ps.serverConfig.Logger.Warn("[ranch] service failed to warm up", "channel", channel,
+					"error", err.Error())
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		ps.serverConfig.Logger.Info("[ranch] services warmed up", "duration", clock.Since(started).String())
+	case <-ctx.Done():
+		ps.serverConfig.Logger.Warn("[ranch] services did not warm up in time, reporting online anyway",
+			"timeout", timeout.String())
+	}
+}
+
+// awaitServicesReady waits for every registered service to be ready, until ctx is done. It returns the
+// channels of the services that are not.
+func (ps *platformServer) awaitServicesReady(ctx context.Context) []string {
+	readyStore := ps.eventbus.GetStoreManager().GetStore(service.ServiceReadyStore)
+	ticker := time.NewTicker(warmupReadyCheckInterval)
+	defer ticker.Stop()
+	for {
+		var waiting []string
+		for _, channel := range service.GetServiceRegistry().GetAllServiceChannels() {
+			if ready, found := readyStore.Get(channel); !found || ready != true {
+				waiting = append(waiting, channel)
+			}
+		}
+		if len(waiting) == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return waiting
+		case <-ticker.C:
+		}
+	}
+}
+
+// trafficRamp admits a share of the requests growing linearly from its start share to all of them over
+// its duration, once the server is online. Every request is admitted before, as load balancers do not send
+// traffic to a server not yet reporting ready.
+type trafficRamp struct {
+	lock       sync.Mutex
+	started    time.Time // when the server came online, zero before
+	complete   bool      // whether every request is admitted again
+	duration   time.Duration
+	startShare float64
+	retryAfter string
+	exempt     []string
+	logger     *slog.Logger
+	random     func() float64
+}
+
+// initTrafficRamp sets up the traffic ramp, if configured.
+func (ps *platformServer) initTrafficRamp() {
+	cfg := ps.serverConfig.Warmup
+	if cfg == nil || cfg.RampSeconds <= 0 {
+		return
+	}
+	ramp := &trafficRamp{
+		duration:   time.Duration(cfg.RampSeconds) * time.Second,
+		startShare: float64(defaultRampStartPercent) / 100,
+		retryAfter: strconv.Itoa(defaultRampRetryAfter),
+		exempt:     append([]string(nil), cfg.ExemptPaths...),
+		logger:     ps.serverConfig.Logger,
+		random:     rand.Float64,
+	}
+	if cfg.RampStartPercent > 0 {
+		ramp.startShare = min(float64(cfg.RampStartPercent)/100, 1)
+	}
+	if cfg.RetryAfterSeconds > 0 {
+		ramp.retryAfter = strconv.Itoa(cfg.RetryAfterSeconds)
+	}
+	// probes must see the server as it is, rejecting them would take it out of rotation
+	if deps := ps.serverConfig.Dependencies; deps != nil && deps.HealthEndpoint != "" {
+		ramp.exempt = append(ramp.exempt, deps.HealthEndpoint)
+	}
+	if health := ps.serverConfig.Health; health != nil {
+		live, ready := health.paths()
+		ramp.exempt = append(ramp.exempt, live, ready)
+	}
+	if signal := ps.serverConfig.LoadSignal; signal != nil && signal.Endpoint != "" {
+		ramp.exempt = append(ramp.exempt, signal.Endpoint)
+	}
+	if cfg := ps.serverConfig.Metrics; cfg != nil {
+		ramp.exempt = append(ramp.exempt, cfg.endpoint())
+	}
+	ps.trafficRamp = ramp
+}
+
+// startTrafficRamp starts ramping traffic up, as the server comes online.
+func (ps *platformServer) startTrafficRamp() {
+	ramp := ps.trafficRamp
+	if ramp == nil {
+		return
+	}
+	ramp.lock.Lock()
+	ramp.started = clock.Now()
+	ramp.lock.Unlock()
+	ps.serverConfig.Logger.Info("[ranch] ramping traffic up", "duration", ramp.duration.String(),
+		"start_percent", int(ramp.startShare*100))
+}
+
+// share returns the share of requests admitted right now.
+func (r *trafficRamp) share() float64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.started.IsZero() || r.complete {
+		return 1
+	}
+	elapsed := clock.Since(r.started)
+	if elapsed >= r.duration {
+		r.complete = true
+		r.logger.Info("[ranch] traffic ramp complete, admitting every request")
+		return 1
+	}
+	return r.startShare + (1-r.startShare)*float64(elapsed)/float64(r.duration)
+}
+
+// middleware rejects the requests the ramp does not admit with a 503 and a Retry-After header.
+func (r *trafficRamp) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		for _, prefix := range r.exempt {
+			if strings.HasPrefix(req.URL.Path, prefix) {
+				next.ServeHTTP(w, req)
+				return
+			}
+		}
+		if share := r.share(); share < 1 && r.random() >= share {
+			w.Header().Set("Retry-After", r.retryAfter)
+			http.Error(w, "server warming up, retry shortly", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,111 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/clock"
+	"github.com/pb33f/ranch/model"
+	"github.com/pb33f/ranch/service"
+	"github.com/stretchr/testify/assert"
+)
+
+type warmupTestService struct {
+	ready    atomic.Bool
+	warmedUp atomic.Bool
+	err      error
+}
+
+func (s *warmupTestService) HandleServiceRequest(request *model.Request, core service.FabricServiceCore) {
+}
+
+func (s *warmupTestService) OnServiceReady() chan bool {
+	return make(chan bool, 1)
+}
+
+func (s *warmupTestService) OnWarmup(ctx context.Context) error {
+	// warm-up only starts once every service is ready
+	s.warmedUp.Store(s.ready.Load())
+	return s.err
+}
+
+func TestPlatformServer_Warmup(t *testing.T) {
+	newBus := bus.NewEventBusInstance()
+	newBus.GetStoreManager().CreateStoreWithType(service.ServiceReadyStore, reflect.TypeOf(true)).Initialize()
+	service.ResetServiceRegistry()
+	var logs bytes.Buffer
+	ps := &platformServer{
+		eventbus:     newBus,
+		serverConfig: &PlatformServerConfig{Logger: slog.New(slog.NewTextHandler(&logs, nil))},
+	}
+	cache := &warmupTestService{}
+	search := &warmupTestService{err: errors.New("index unavailable")}
+	assert.NoError(t, ps.RegisterService(cache, "cache-service"))
+	assert.NoError(t, ps.RegisterService(search, "search-service"))
+
+	readyStore := newBus.GetStoreManager().GetStore(service.ServiceReadyStore)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		for channel, svc := range map[string]*warmupTestService{"cache-service": cache, "search-service": search} {
+			svc.ready.Store(true)
+			readyStore.Put(channel, true, service.ServiceInitStateChange)
+		}
+	}()
+	ps.runWarmup()
+
+	assert.True(t, cache.warmedUp.Load())
+	assert.True(t, search.warmedUp.Load())
+	assert.Contains(t, logs.String(), "service failed to warm up")
+	assert.Contains(t, logs.String(), "index unavailable")
+	assert.Contains(t, logs.String(), "services warmed up")
+}
+
+func TestPlatformServer_TrafficRamp(t *testing.T) {
+	fake := clock.NewFakeClock(time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC))
+	clock.Set(fake)
+	defer clock.Reset()
+	ps := &platformServer{serverConfig: &PlatformServerConfig{
+		Logger:       slog.Default(),
+		Warmup:       &WarmupConfig{RampSeconds: 10, RampStartPercent: 20, RetryAfterSeconds: 3},
+		Dependencies: &DependenciesConfig{HealthEndpoint: "/health"},
+	}}
+	ps.initTrafficRamp()
+	ps.trafficRamp.random = func() float64 { return 0.5 }
+	handler := ps.trafficRamp.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	// nothing is rejected before the server is online
+	assert.Equal(t, http.StatusOK, serve("/api/cows").Code)
+
+	// then a decreasing share of requests is, probes excepted
+	ps.startTrafficRamp()
+	rejected := serve("/api/cows")
+	assert.Equal(t, http.StatusServiceUnavailable, rejected.Code)
+	assert.Equal(t, "3", rejected.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusOK, serve("/health").Code)
+
+	fake.Advance(5 * time.Second)
+	assert.InDelta(t, 0.6, ps.trafficRamp.share(), 0.001)
+	assert.Equal(t, http.StatusOK, serve("/api/cows").Code)
+
+	fake.Advance(5 * time.Second)
+	assert.Equal(t, 1.0, ps.trafficRamp.share())
+	ps.trafficRamp.random = func() float64 { return 0.999 }
+	assert.Equal(t, http.StatusOK, serve("/api/cows").Code)
+}
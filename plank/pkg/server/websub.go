@@ -0,0 +1,57 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"time"
+
+	"github.com/pb33f/ranch/plank/pkg/websub"
+)
+
+const defaultWebSubEndpoint = "/websub"
+
+// initWebSub creates the WebSub hub, if configured, and mounts it at its endpoint. It delivers once the
+// server starts.
+func (ps *platformServer) initWebSub() {
+	cfg := ps.serverConfig.WebSub
+	if cfg == nil {
+		return
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = defaultWebSubEndpoint
+	}
+	hub, err := websub.New(ps.eventbus, &websub.Config{
+		HubURL:          cfg.HubURL,
+		Topics:          cfg.Topics,
+		LeaseSeconds:    cfg.LeaseSeconds,
+		MaxLeaseSeconds: cfg.MaxLeaseSeconds,
+		MaxAttempts:     cfg.MaxAttempts,
+		RetryDelay:      time.Duration(cfg.RetryDelaySeconds) * time.Second,
+		Logger:          ps.serverConfig.Logger,
+	})
+	if err != nil {
+		ps.serverConfig.Logger.Error(wrapError(errServerInit, err).Error())
+		return
+	}
+	ps.webSub = hub
+	ps.router.Path(cfg.Endpoint).Name(cfg.Endpoint).Handler(hub)
+	ps.serverConfig.Logger.Info("[ranch] WebSub hub enabled", "endpoint", cfg.Endpoint, "topics", len(cfg.Topics))
+}
+
+// startWebSub pushes the responses of the topic channels to their subscribers, if configured.
+func (ps *platformServer) startWebSub() {
+	if ps.webSub == nil {
+		return
+	}
+	if err := ps.webSub.Start(); err != nil {
+		ps.serverConfig.Logger.Error(wrapError(errServerInit, err).Error())
+	}
+}
+
+// stopWebSub stops pushing, the subscriptions are kept until the server stops for good.
+func (ps *platformServer) stopWebSub() {
+	if ps.webSub != nil {
+		ps.webSub.Stop()
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/plank/pkg/websub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlatformServer_WebSub(t *testing.T) {
+	delivered := make(chan string, 1)
+	subscriber := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			_, _ = w.Write([]byte(r.URL.Query().Get("hub.challenge")))
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		delivered <- string(body)
+	}))
+	defer subscriber.Close()
+
+	ps := &platformServer{
+		eventbus: bus.NewEventBusInstance(),
+		router:   mux.NewRouter(),
+		serverConfig: &PlatformServerConfig{
+			Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+			WebSub: &WebSubConfig{Topics: map[string]string{"https://ranch.pb33f.io/cows": "cows"}},
+		},
+	}
+	ps.initWebSub()
+	require.NotNil(t, ps.webSub)
+	assert.Equal(t, defaultWebSubEndpoint, ps.serverConfig.WebSub.Endpoint)
+	ps.startWebSub()
+	defer ps.stopWebSub()
+
+	form := url.Values{
+		"hub.mode":     {websub.ModeSubscribe},
+		"hub.topic":    {"https://ranch.pb33f.io/cows"},
+		"hub.callback": {subscriber.URL},
+	}
+	req := httptest.NewRequest(http.MethodPost, defaultWebSubEndpoint, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	ps.router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	require.Eventually(t, func() bool {
+		return len(ps.webSub.Subscriptions()) == 1
+	}, time.Second, 5*time.Millisecond)
+
+	require.NoError(t, ps.eventbus.SendResponseMessage("cows", "moo", nil))
+	select {
+	case body := <-delivered:
+		assert.Equal(t, `"moo"`, body)
+	case <-time.After(time.Second):
+		assert.Fail(t, "nothing delivered")
+	}
+
+	// a hub without topics is not mounted
+	ps = &platformServer{
+		eventbus:     bus.NewEventBusInstance(),
+		router:       mux.NewRouter(),
+		serverConfig: &PlatformServerConfig{Logger: slog.New(slog.NewTextHandler(io.Discard, nil)), WebSub: &WebSubConfig{}},
+	}
+	ps.initWebSub()
+	assert.Nil(t, ps.webSub)
+	ps.startWebSub()
+	ps.stopWebSub()
+}
@@ -0,0 +1,252 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package settings
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/clock"
+	"github.com/pb33f/ranch/model"
+)
+
+const (
+	DefaultStore      = "ranch-settings"
+	defaultMaxHistory = 100
+)
+
+// Config selects where settings are kept.
+type Config struct {
+	Store      string               // store holding the values of the sections, by section, defaults to ranch-settings
+	MaxHistory int                  // changes kept in the history, the oldest are forgotten first, defaults to 100
+	OnChange   func(change *Change) // called with every change made, e.g. to audit it. may be nil
+}
+
+// HistoryStore returns the name of the store holding the history of the changes, by version.
+func (c *Config) HistoryStore() string {
+	return c.store() + "-history"
+}
+
+func (c *Config) store() string {
+	if c.Store != "" {
+		return c.Store
+	}
+	return DefaultStore
+}
+
+// Manager keeps the settings of the registered sections, validates and applies changes to them and
+// records their history. Safe for concurrent use.
+type Manager struct {
+	config   *Config
+	values   bus.BusStore
+	history  bus.BusStore
+	sections map[string]*Section
+	versions map[string]int // version of the last change, by section
+	version  int            // version of the last change
+	lock     sync.Mutex
+}
+
+// NewManager creates a Manager keeping settings in the stores of storeManager. Stores made persistent
+// before are loaded, with the history of the changes.
+func NewManager(storeManager bus.StoreManager, config *Config) *Manager {
+	m := &Manager{
+		config:   config,
+		values:   storeManager.CreateStore(config.store()),
+		history:  storeManager.CreateStoreWithType(config.HistoryStore(), reflect.TypeOf(&Change{})),
+		sections: make(map[string]*Section),
+		versions: make(map[string]int),
+	}
+	for _, item := range m.history.AllValues() {
+		if change, ok := item.(*Change); ok {
+			m.version = max(m.version, change.Version)
+			m.versions[change.Section] = max(m.versions[change.Section], change.Version)
+		}
+	}
+	m.values.Initialize()
+	m.history.Initialize()
+	return m
+}
+
+// Register adds a section. Values kept for the section, e.g. loaded from a persistent store, take over from
+// the values it starts with. The values are applied before Register returns.
+func (m *Manager) Register(section *Section) error {
+	if section == nil || section.Name == "" {
+		return fmt.Errorf("settings section has no name")
+	}
+	rawSchema := section.Schema
+	if len(rawSchema) == 0 {
+		rawSchema = []byte(`{"type": "object"}`)
+	}
+	schema, err := model.CompileJSONSchema(rawSchema)
+	if err != nil {
+		return fmt.Errorf("settings section '%s' has an invalid schema: %w", section.Name, err)
+	}
+	section.schema = schema
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.sections[section.Name]; ok {
+		return fmt.Errorf("settings section '%s' is already registered", section.Name)
+	}
+	values, err := decodeValues(section.Values)
+	if err != nil {
+		return &ValidationError{Section: section.Name, Err: err}
+	}
+	if kept, ok := m.values.Get(section.Name); ok {
+		if keptValues, err := decodeValues(kept); err == nil {
+			for name, value := range keptValues {
+				values[name] = value
+			}
+		}
+	}
+	if err = m.apply(section, values); err != nil {
+		return err
+	}
+	m.sections[section.Name] = section
+	m.values.Put(section.Name, values, nil)
+	return nil
+}
+
+// apply validates values and puts them into effect.
+func (m *Manager) apply(section *Section, values Values) error {
+	if err := section.schema.Validate(values); err != nil {
+		return &ValidationError{Section: section.Name, Err: err}
+	}
+	if section.Apply != nil {
+		if err := section.Apply(values.clone()); err != nil {
+			return &ValidationError{Section: section.Name, Err: err}
+		}
+	}
+	return nil
+}
+
+// Sections returns the registered sections and their values, by name.
+func (m *Manager) Sections() []*SectionState {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	states := make([]*SectionState, 0, len(m.sections))
+	for _, section := range m.sections {
+		states = append(states, m.state(section))
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Name < states[j].Name })
+	return states
+}
+
+// Section returns a registered section and its values.
+func (m *Manager) Section(name string) (*SectionState, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	section, ok := m.sections[name]
+	if !ok {
+		return nil, &NotFoundError{Section: name}
+	}
+	return m.state(section), nil
+}
+
+func (m *Manager) state(section *Section) *SectionState {
+	values, _ := decodeValues(m.values.GetValue(section.Name))
+	return &SectionState{Section: section, Values: values, Version: m.versions[section.Name]}
+}
+
+// Set changes some of the settings of a section, those it does not name keep their values. The settings
+// are validated and applied, and the change is recorded.
+func (m *Manager) Set(section string, values Values, principal string, comment string) (*Change, error) {
+	return m.change(section, func(current Values) (Values, int, error) {
+		updates, err := decodeValues(values)
+		if err != nil {
+			return nil, 0, &ValidationError{Section: section, Err: err}
+		}
+		for name, value := range updates {
+			current[name] = value
+		}
+		return current, 0, nil
+	}, principal, comment)
+}
+
+// Rollback restores the settings a change replaced, recording the rollback as a change of its own.
+func (m *Manager) Rollback(version int, principal string, comment string) (*Change, error) {
+	item, ok := m.history.Get(strconv.Itoa(version))
+	rolledBack, isChange := item.(*Change)
+	if !ok || !isChange {
+		return nil, &NotFoundError{Version: version}
+	}
+	return m.change(rolledBack.Section, func(Values) (Values, int, error) {
+		return rolledBack.Before.clone(), rolledBack.Version, nil
+	}, principal, comment)
+}
+
+// change applies the values update derives from those in effect, and records the change.
+func (m *Manager) change(name string, update func(current Values) (Values, int, error), principal string,
+	comment string) (*Change, error) {
+
+	m.lock.Lock()
+	section, ok := m.sections[name]
+	if !ok {
+		m.lock.Unlock()
+		return nil, &NotFoundError{Section: name}
+	}
+	before, _ := decodeValues(m.values.GetValue(name))
+	after, rolledBack, err := update(before.clone())
+	if err == nil {
+		err = m.apply(section, after)
+	}
+	if err != nil {
+		m.lock.Unlock()
+		return nil, err
+	}
+
+	m.version++
+	change := &Change{
+		Version:    m.version,
+		Section:    name,
+		Time:       clock.Now().UTC(),
+		Principal:  principal,
+		Comment:    comment,
+		Before:     before,
+		After:      after,
+		RolledBack: rolledBack,
+	}
+	m.versions[name] = m.version
+	m.values.Put(name, after, nil)
+	m.history.Put(strconv.Itoa(change.Version), change, nil)
+	m.forgetHistory()
+	m.lock.Unlock()
+
+	if m.config.OnChange != nil {
+		m.config.OnChange(change)
+	}
+	return change, nil
+}
+
+// forgetHistory removes the oldest changes beyond the size of the history, the lock must be held.
+func (m *Manager) forgetHistory() {
+	maxHistory := m.config.MaxHistory
+	if maxHistory <= 0 {
+		maxHistory = defaultMaxHistory
+	}
+	if oldest := m.version - maxHistory; oldest > 0 {
+		for id := range m.history.AllValuesAsMap() {
+			if version, err := strconv.Atoi(id); err == nil && version <= oldest {
+				m.history.Remove(id, nil)
+			}
+		}
+	}
+}
+
+// History returns the changes kept in the history, of a section or of every section if empty, the most
+// recent first.
+func (m *Manager) History(section string) []*Change {
+	var changes []*Change
+	for _, item := range m.history.AllValues() {
+		if change, ok := item.(*Change); ok && (section == "" || change.Section == section) {
+			changes = append(changes, change)
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Version > changes[j].Version })
+	return changes
+}
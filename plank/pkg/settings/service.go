@@ -0,0 +1,137 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package settings
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/pb33f/ranch/model"
+	"github.com/pb33f/ranch/service"
+)
+
+const (
+	GetSectionsRequestCommand = "get-sections" // returns every section and its values
+	GetSectionRequestCommand  = "get-section"  // returns a section and its values, see SectionRequest
+	SetRequestCommand         = "set"          // changes settings of a section, see SetRequest
+	HistoryRequestCommand     = "history"      // returns the recent changes, see SectionRequest
+	RollbackRequestCommand    = "rollback"     // rolls a change back, see RollbackRequest
+)
+
+// SectionRequest is the payload of get-section and history requests.
+type SectionRequest struct {
+	Section string `json:"section"` // section, every section for history requests if empty
+}
+
+// SetRequest is the payload of a set request.
+type SetRequest struct {
+	Section string `json:"section"`
+	Values  Values `json:"values"`  // settings changed, the others keep their values
+	Comment string `json:"comment"` // why the settings are changed, recorded in the history
+}
+
+// RollbackRequest is the payload of a rollback request.
+type RollbackRequest struct {
+	Version int    `json:"version"` // version of the change rolled back
+	Comment string `json:"comment"` // why the change is rolled back, recorded in the history
+}
+
+// Service exposes a Manager on a bus channel, for dashboards and operators' tools. Changes are attributed
+// to the principal of the request. Register the service on an internal channel unless fabric clients
+// should reach it, their principal then decides who may change settings.
+type Service struct {
+	manager *Manager
+}
+
+// NewService creates a service changing the settings of manager.
+func NewService(manager *Manager) *Service {
+	return &Service{manager: manager}
+}
+
+func (s *Service) HandleServiceRequest(request *model.Request, core service.FabricServiceCore) {
+	switch request.RequestCommand {
+	case GetSectionsRequestCommand:
+		core.SendResponse(request, s.manager.Sections())
+	case GetSectionRequestCommand:
+		var get SectionRequest
+		if err := decodePayload(request.Payload, &get); err != nil {
+			core.SendErrorResponse(request, 400, "invalid get-section request: "+err.Error())
+			return
+		}
+		state, err := s.manager.Section(get.Section)
+		if err != nil {
+			sendError(request, core, err)
+			return
+		}
+		core.SendResponse(request, state)
+	case SetRequestCommand:
+		var set SetRequest
+		if err := decodePayload(request.Payload, &set); err != nil {
+			core.SendErrorResponse(request, 400, "invalid set request: "+err.Error())
+			return
+		}
+		change, err := s.manager.Set(set.Section, set.Values, request.Principal, set.Comment)
+		if err != nil {
+			sendError(request, core, err)
+			return
+		}
+		core.SendResponse(request, change)
+	case HistoryRequestCommand:
+		var history SectionRequest
+		if request.Payload != nil {
+			if err := decodePayload(request.Payload, &history); err != nil {
+				core.SendErrorResponse(request, 400, "invalid history request: "+err.Error())
+				return
+			}
+		}
+		core.SendResponse(request, s.manager.History(history.Section))
+	case RollbackRequestCommand:
+		var rollback RollbackRequest
+		if err := decodePayload(request.Payload, &rollback); err != nil {
+			core.SendErrorResponse(request, 400, "invalid rollback request: "+err.Error())
+			return
+		}
+		change, err := s.manager.Rollback(rollback.Version, request.Principal, rollback.Comment)
+		if err != nil {
+			sendError(request, core, err)
+			return
+		}
+		core.SendResponse(request, change)
+	default:
+		core.HandleUnknownRequest(request)
+	}
+}
+
+// ErrorCode returns the HTTP like status code of an error returned by a Manager: 404 for sections and
+// changes that do not exist, 400 for invalid settings, 500 otherwise.
+func ErrorCode(err error) int {
+	var notFound *NotFoundError
+	var invalid *ValidationError
+	switch {
+	case errors.As(err, &notFound):
+		return 404
+	case errors.As(err, &invalid):
+		return 400
+	}
+	return 500
+}
+
+func sendError(request *model.Request, core service.FabricServiceCore, err error) {
+	core.SendErrorResponse(request, ErrorCode(err), err.Error())
+}
+
+// decodePayload decodes a request payload, sent as JSON or already decoded from it.
+func decodePayload(payload interface{}, target interface{}) error {
+	switch p := payload.(type) {
+	case []byte:
+		return json.Unmarshal(p, target)
+	case string:
+		return json.Unmarshal([]byte(p), target)
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, target)
+}
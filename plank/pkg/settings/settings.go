@@ -0,0 +1,125 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+// Package settings keeps the configuration of a running server and of its services in bus stores, so
+// operators can tune running instances from a dashboard instead of editing JSON on disk. The configuration is
+// split in sections, each with a JSON schema its values are validated against and an Apply callback putting
+// them into effect. Every change is recorded with who made it and why, and can be rolled back. Making the
+// stores persistent keeps the settings, and their history, across restarts.
+package settings
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pb33f/ranch/model"
+)
+
+// Values are the values of the settings of a section, by name, as decoded from JSON: numbers are float64.
+type Values map[string]interface{}
+
+// String returns a setting as a string, empty if it is not set or not a string.
+func (v Values) String(name string) string {
+	s, _ := v[name].(string)
+	return s
+}
+
+// Int returns a setting as an int, 0 if it is not set or not a number.
+func (v Values) Int(name string) int {
+	return int(v.Float(name))
+}
+
+// Float returns a setting as a float64, 0 if it is not set or not a number.
+func (v Values) Float(name string) float64 {
+	f, _ := v[name].(float64)
+	return f
+}
+
+// Bool returns a setting as a bool, false if it is not set or not a bool.
+func (v Values) Bool(name string) bool {
+	b, _ := v[name].(bool)
+	return b
+}
+
+// clone returns a copy of the values, nested values are shared as they are never modified.
+func (v Values) clone() Values {
+	c := make(Values, len(v))
+	for name, value := range v {
+		c[name] = value
+	}
+	return c
+}
+
+// decodeValues returns values in their JSON form, whatever their Go types.
+func decodeValues(values interface{}) (Values, error) {
+	encoded, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	var decoded Values
+	if err = json.Unmarshal(encoded, &decoded); err != nil {
+		return nil, err
+	}
+	if decoded == nil {
+		decoded = Values{}
+	}
+	return decoded, nil
+}
+
+// Section is a part of the configuration that can be changed at runtime, e.g. the tunables of a service.
+type Section struct {
+	Name        string                    `json:"name"`
+	Description string                    `json:"description,omitempty"`
+	Schema      json.RawMessage           `json:"schema"` // JSON schema of the values, see model.JSONSchema for the keywords supported
+	Values      Values                    `json:"-"`      // values the section starts with, e.g. from the configuration file
+	Apply       func(values Values) error `json:"-"`      // puts values into effect, or refuses them with an error. may be nil
+	schema      *model.JSONSchema
+}
+
+// SectionState is a section and the values in effect.
+type SectionState struct {
+	*Section
+	Values  Values `json:"values"`
+	Version int    `json:"version"` // version of the last change to the section, 0 if it has not changed
+}
+
+// Change is a change to the values of a section.
+type Change struct {
+	Version    int       `json:"version"`               // version of the change, increasing over every section
+	Section    string    `json:"section"`               // section changed
+	Time       time.Time `json:"time"`                  // when the change was made
+	Principal  string    `json:"principal,omitempty"`   // who made the change, empty if unknown
+	Comment    string    `json:"comment,omitempty"`     // why the change was made
+	Before     Values    `json:"before"`                // values of the section before the change
+	After      Values    `json:"after"`                 // values of the section after the change
+	RolledBack int       `json:"rolled_back,omitempty"` // version of the change this change rolled back, 0 if it is not a rollback
+}
+
+// ValidationError is returned when the values of a section do not match its schema, or its Apply callback
+// refuses them. Nothing was changed.
+type ValidationError struct {
+	Section string
+	Err     error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid settings for section '%s': %s", e.Section, e.Err.Error())
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// NotFoundError is returned for sections that are not registered and changes that are not in the history.
+type NotFoundError struct {
+	Section string // section not registered, empty if a change was not found
+	Version int    // version of the change not found
+}
+
+func (e *NotFoundError) Error() string {
+	if e.Section != "" {
+		return fmt.Sprintf("no settings section '%s'", e.Section)
+	}
+	return fmt.Sprintf("no settings change with version %d", e.Version)
+}
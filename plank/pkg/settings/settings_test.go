@@ -0,0 +1,186 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package settings
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/model"
+	"github.com/pb33f/ranch/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const cacheSchema = `{
+	"type": "object",
+	"properties": {
+		"size": {"type": "integer", "minimum": 1, "maximum": 1000},
+		"mode": {"enum": ["lru", "fifo"]}
+	},
+	"additionalProperties": false
+}`
+
+// cacheSection returns a section whose applied values are recorded in applied.
+func cacheSection(applied *Values) *Section {
+	return &Section{
+		Name:   "cache",
+		Schema: []byte(cacheSchema),
+		Values: Values{"size": 10, "mode": "lru"},
+		Apply: func(values Values) error {
+			if values.String("mode") == "fifo" && values.Int("size") > 100 {
+				return errors.New("fifo caches hold at most 100 items")
+			}
+			*applied = values
+			return nil
+		},
+	}
+}
+
+func TestManager_Set(t *testing.T) {
+	var changes []*Change
+	m := NewManager(bus.NewEventBusInstance().GetStoreManager(), &Config{
+		OnChange: func(change *Change) { changes = append(changes, change) },
+	})
+	var applied Values
+	require.NoError(t, m.Register(cacheSection(&applied)))
+	assert.Equal(t, Values{"size": float64(10), "mode": "lru"}, applied)
+	assert.Error(t, m.Register(cacheSection(&applied)))
+
+	// settings are changed one by one
+	change, err := m.Set("cache", Values{"size": 200}, "ops", "more room")
+	require.NoError(t, err)
+	assert.Equal(t, 1, change.Version)
+	assert.Equal(t, Values{"size": float64(10), "mode": "lru"}, change.Before)
+	assert.Equal(t, Values{"size": float64(200), "mode": "lru"}, change.After)
+	assert.Equal(t, "ops", change.Principal)
+	assert.Equal(t, 200, applied.Int("size"))
+	assert.Equal(t, []*Change{change}, changes)
+
+	// and refused if they do not match the schema, or cannot be applied
+	for _, values := range []Values{{"size": 0}, {"size": 1.5}, {"mode": "random"}, {"colour": "blue"},
+		{"mode": "fifo"}} {
+		_, err = m.Set("cache", values, "ops", "")
+		var invalid *ValidationError
+		assert.ErrorAs(t, err, &invalid, "%v", values)
+		assert.Equal(t, 400, ErrorCode(err))
+	}
+	_, err = m.Set("queue", Values{"size": 1}, "ops", "")
+	assert.Equal(t, 404, ErrorCode(err))
+
+	state, err := m.Section("cache")
+	require.NoError(t, err)
+	assert.Equal(t, Values{"size": float64(200), "mode": "lru"}, state.Values)
+	assert.Equal(t, 1, state.Version)
+	assert.Len(t, m.Sections(), 1)
+	assert.Len(t, changes, 1)
+}
+
+func TestManager_Rollback(t *testing.T) {
+	m := NewManager(bus.NewEventBusInstance().GetStoreManager(), &Config{MaxHistory: 3})
+	var applied Values
+	require.NoError(t, m.Register(cacheSection(&applied)))
+
+	for _, size := range []int{20, 30, 40} {
+		_, err := m.Set("cache", Values{"size": size}, "ops", "")
+		require.NoError(t, err)
+	}
+	change, err := m.Rollback(2, "admin", "30 was too many")
+	require.NoError(t, err)
+	assert.Equal(t, 4, change.Version)
+	assert.Equal(t, 2, change.RolledBack)
+	assert.Equal(t, 20, applied.Int("size"))
+
+	// the history is bounded, the most recent changes first
+	history := m.History("cache")
+	require.Len(t, history, 3)
+	assert.Equal(t, []int{4, 3, 2}, []int{history[0].Version, history[1].Version, history[2].Version})
+	assert.Empty(t, m.History("queue"))
+	_, err = m.Rollback(1, "admin", "")
+	assert.Equal(t, 404, ErrorCode(err))
+}
+
+func TestManager_Persistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "settings.db")
+	open := func() (*Manager, *bus.BoltStorePersistence) {
+		persistence, err := bus.NewBoltStorePersistence(path, true)
+		require.NoError(t, err)
+		storeManager := bus.NewEventBusInstance().GetStoreManager()
+		config := &Config{}
+		require.NoError(t, storeManager.SetStorePersistence(persistence, DefaultStore, config.HistoryStore()))
+		return NewManager(storeManager, config), persistence
+	}
+
+	m, persistence := open()
+	var applied Values
+	require.NoError(t, m.Register(cacheSection(&applied)))
+	_, err := m.Set("cache", Values{"mode": "fifo"}, "ops", "")
+	require.NoError(t, err)
+	require.NoError(t, persistence.Close())
+
+	// settings changed at runtime take over from the configuration after a restart
+	m, persistence = open()
+	defer persistence.Close()
+	require.NoError(t, m.Register(cacheSection(&applied)))
+	assert.Equal(t, "fifo", applied.String("mode"))
+	change, err := m.Set("cache", Values{"size": 5}, "ops", "")
+	require.NoError(t, err)
+	assert.Equal(t, 2, change.Version)
+	_, err = m.Rollback(1, "ops", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "lru", applied.String("mode"))
+}
+
+func TestService(t *testing.T) {
+	b := bus.ResetBus()
+	registry := service.ResetServiceRegistry()
+	m := NewManager(b.GetStoreManager(), &Config{})
+	var applied Values
+	require.NoError(t, m.Register(cacheSection(&applied)))
+	require.NoError(t, registry.RegisterService(NewService(m), "settings"))
+
+	responses := make(chan *model.Response, 1)
+	handler, err := b.ListenStream("settings")
+	require.NoError(t, err)
+	defer handler.Close()
+	handler.Handle(func(msg *model.Message) {
+		responses <- msg.Payload.(*model.Response)
+	}, func(err error) {})
+	send := func(command string, payload interface{}) *model.Response {
+		id := uuid.New()
+		require.NoError(t, b.SendRequestMessage("settings", &model.Request{Id: &id, RequestCommand: command,
+			Payload: payload, Principal: "dashboard"}, nil))
+		select {
+		case rsp := <-responses:
+			return rsp
+		case <-time.After(time.Second):
+			t.Fatal("no response")
+		}
+		return nil
+	}
+
+	rsp := send(SetRequestCommand, map[string]interface{}{"section": "cache", "values": map[string]interface{}{
+		"size": 50}, "comment": "busy day"})
+	require.False(t, rsp.Error, rsp.ErrorMessage)
+	assert.Equal(t, "dashboard", rsp.Payload.(*Change).Principal)
+	assert.Equal(t, 50, applied.Int("size"))
+
+	rsp = send(GetSectionRequestCommand, []byte(`{"section": "cache"}`))
+	assert.Equal(t, Values{"size": float64(50), "mode": "lru"}, rsp.Payload.(*SectionState).Values)
+	assert.Len(t, send(GetSectionsRequestCommand, nil).Payload, 1)
+	assert.Len(t, send(HistoryRequestCommand, nil).Payload, 1)
+
+	rsp = send(RollbackRequestCommand, RollbackRequest{Version: 1})
+	require.False(t, rsp.Error, rsp.ErrorMessage)
+	assert.Equal(t, 10, applied.Int("size"))
+
+	rsp = send(SetRequestCommand, SetRequest{Section: "cache", Values: Values{"size": -1}})
+	assert.Equal(t, 400, rsp.ErrorCode)
+	rsp = send(GetSectionRequestCommand, SectionRequest{Section: "queue"})
+	assert.Equal(t, 404, rsp.ErrorCode)
+}
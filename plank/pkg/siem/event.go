@@ -0,0 +1,119 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+// Package siem exports audit and security events to enterprise SOC pipelines. Events are formatted as
+// RFC 5424 syslog, CEF or LEEF and shipped in batches to a syslog collector over TCP, TLS or UDP. When the
+// collector cannot be reached, batches are spooled to local disk and replayed once it comes back.
+package siem
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/clock"
+	"github.com/pb33f/ranch/plank/pkg/abuse"
+)
+
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Event is an audit or security event to be exported.
+type Event struct {
+	Time       time.Time         `json:"time"`
+	Class      string            `json:"class"`                // event class, e.g. "abuse.banned", used as the CEF signature id
+	Name       string            `json:"name"`                 // short human readable name of the event class
+	Severity   int               `json:"severity"`             // 0 (lowest) to 10 (highest), as used by CEF
+	Outcome    string            `json:"outcome,omitempty"`    // OutcomeSuccess or OutcomeFailure
+	SourceIP   string            `json:"source_ip,omitempty"`  // address of the client that caused the event
+	User       string            `json:"user,omitempty"`       // principal that caused the event
+	Message    string            `json:"message,omitempty"`    // free text description
+	Extensions map[string]string `json:"extensions,omitempty"` // additional key/value pairs
+}
+
+// syslogSeverity maps the 0-10 event severity onto a syslog severity level.
+func (e *Event) syslogSeverity() int {
+	switch {
+	case e.Severity >= 9:
+		return 2 // critical
+	case e.Severity >= 7:
+		return 3 // error
+	case e.Severity >= 5:
+		return 4 // warning
+	case e.Severity >= 3:
+		return 5 // notice
+	}
+	return 6 // informational
+}
+
+// FromAbuseEvent converts an event raised by an abuse.Guard.
+func FromAbuseEvent(evt *abuse.Event) *Event {
+	e := &Event{
+		Time:     evt.Time,
+		Class:    "abuse." + string(evt.Type),
+		SourceIP: evt.IP,
+		User:     evt.Principal,
+		Message:  evt.Reason,
+		Outcome:  OutcomeFailure,
+		Extensions: map[string]string{
+			"source": evt.Source,
+			"key":    evt.Key,
+		},
+	}
+	switch evt.Type {
+	case abuse.EventBanned:
+		e.Name, e.Severity = "Client banned", 8
+	case abuse.EventChallenged:
+		e.Name, e.Severity = "Client challenged", 6
+	default:
+		e.Name, e.Severity = "Request rejected", 4
+	}
+	if evt.Detector != "" {
+		e.Extensions["detector"] = evt.Detector
+	}
+	if evt.Score != 0 {
+		e.Extensions["score"] = fmt.Sprintf("%.2f", evt.Score)
+	}
+	if !evt.Until.IsZero() {
+		e.Extensions["until"] = evt.Until.UTC().Format(time.RFC3339)
+	}
+	return e
+}
+
+// FromTokenRevocation converts a session token revocation. The token itself is never exported.
+func FromTokenRevocation(revocation *bus.TokenRevocation) *Event {
+	e := &Event{
+		Time:     clock.Now(),
+		Class:    "session.revoked",
+		Name:     "Session token revoked",
+		Severity: 5,
+		Outcome:  OutcomeSuccess,
+		Message:  revocation.Reason,
+	}
+	if !revocation.ExpiresAt.IsZero() {
+		e.Extensions = map[string]string{"expires": revocation.ExpiresAt.UTC().Format(time.RFC3339)}
+	}
+	return e
+}
+
+// FromPayload converts a bus message payload into an event, returning nil if the payload is not a
+// recognised audit or security event.
+func FromPayload(payload interface{}) *Event {
+	switch p := payload.(type) {
+	case *Event:
+		return p
+	case Event:
+		return &p
+	case *abuse.Event:
+		return FromAbuseEvent(p)
+	case abuse.Event:
+		return FromAbuseEvent(&p)
+	case *bus.TokenRevocation:
+		return FromTokenRevocation(p)
+	case bus.TokenRevocation:
+		return FromTokenRevocation(&p)
+	}
+	return nil
+}
@@ -0,0 +1,424 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package siem
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pb33f/ranch/clock"
+	"github.com/pb33f/ranch/log"
+)
+
+const (
+	NetworkTCP = "tcp"
+	NetworkTLS = "tls"
+	NetworkUDP = "udp"
+)
+
+const (
+	defaultBatchSize     = 100
+	defaultFlushInterval = 5 * time.Second
+	defaultDialTimeout   = 10 * time.Second
+	defaultMaxSpoolBytes = 64 << 20
+	defaultSDID          = "ranch@32473"
+)
+
+// ExporterConfig configures where and how an Exporter ships events.
+type ExporterConfig struct {
+	Name          string        `json:"name"`            // name of the exporter, used for logs and the spool file name
+	Network       string        `json:"network"`         // NetworkTCP (default), NetworkTLS or NetworkUDP
+	Address       string        `json:"address"`         // host:port of the syslog collector
+	TLSConfig     *tls.Config   `json:"-"`               // TLS configuration when Network is NetworkTLS
+	Format        string        `json:"format"`          // FormatSyslog (default), FormatCEF or FormatLEEF
+	Facility      int           `json:"facility"`        // syslog facility, defaults to FacilityLogAudit
+	Hostname      string        `json:"hostname"`        // syslog HOSTNAME, defaults to the OS hostname
+	AppName       string        `json:"app_name"`        // syslog APP-NAME, defaults to "ranch"
+	SDID          string        `json:"sd_id"`           // structured data id for FormatSyslog, defaults to "ranch@32473"
+	Vendor        string        `json:"vendor"`          // CEF/LEEF device vendor, defaults to "pb33f"
+	Product       string        `json:"product"`         // CEF/LEEF device product, defaults to "ranch"
+	Version       string        `json:"version"`         // CEF/LEEF device version, defaults to "1.0"
+	BatchSize     int           `json:"batch_size"`      // events sent per batch, defaults to 100
+	FlushInterval time.Duration `json:"flush_interval"`  // how often partial batches are sent, defaults to 5 seconds
+	DialTimeout   time.Duration `json:"dial_timeout"`    // connect and write timeout, defaults to 10 seconds
+	SpoolDir      string        `json:"spool_dir"`       // directory batches are spooled to while the collector is down, empty disables spooling
+	MaxSpoolBytes int64         `json:"max_spool_bytes"` // spool size limit, events beyond it are dropped, defaults to 64MB
+	Logger        *slog.Logger  `json:"-"`               // defaults to log.Logger()
+}
+
+// Exporter ships events to a syslog collector in batches. Delivery is at least once: a spooled batch that
+// was partially sent before the collector failed is sent again in full.
+type Exporter struct {
+	config    ExporterConfig
+	formatter *formatter
+	logger    *slog.Logger
+	spoolPath string
+	events    chan *Event
+	flushReq  chan chan struct{}
+	stop      chan struct{}
+	done      chan struct{}
+	conn      net.Conn
+	dialFn    func() (net.Conn, error)
+	dropped   atomic.Uint64
+	startOnce sync.Once
+	stopOnce  sync.Once
+}
+
+// NewExporter validates the configuration and creates an exporter. Call Start before exporting events.
+func NewExporter(config *ExporterConfig) (*Exporter, error) {
+	if config == nil {
+		return nil, fmt.Errorf("siem exporter config is nil")
+	}
+	c := *config
+	if c.Address == "" {
+		return nil, fmt.Errorf("siem exporter '%s' is missing an address", c.Name)
+	}
+	switch c.Network {
+	case "":
+		c.Network = NetworkTCP
+	case NetworkTCP, NetworkTLS, NetworkUDP:
+	default:
+		return nil, fmt.Errorf("siem exporter '%s' has unknown network '%s'", c.Name, c.Network)
+	}
+	switch c.Format {
+	case "":
+		c.Format = FormatSyslog
+	case FormatSyslog, FormatCEF, FormatLEEF:
+	default:
+		return nil, fmt.Errorf("siem exporter '%s' has unknown format '%s'", c.Name, c.Format)
+	}
+	if c.Facility <= 0 || c.Facility > 23 {
+		c.Facility = FacilityLogAudit
+	}
+	if c.Hostname == "" {
+		c.Hostname, _ = os.Hostname()
+	}
+	if c.AppName == "" {
+		c.AppName = "ranch"
+	}
+	if c.SDID == "" {
+		c.SDID = defaultSDID
+	}
+	if c.Vendor == "" {
+		c.Vendor = "pb33f"
+	}
+	if c.Product == "" {
+		c.Product = "ranch"
+	}
+	if c.Version == "" {
+		c.Version = "1.0"
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = defaultBatchSize
+	}
+	if c.FlushInterval <= 0 {
+		c.FlushInterval = defaultFlushInterval
+	}
+	if c.DialTimeout <= 0 {
+		c.DialTimeout = defaultDialTimeout
+	}
+	if c.MaxSpoolBytes <= 0 {
+		c.MaxSpoolBytes = defaultMaxSpoolBytes
+	}
+	if c.Name == "" {
+		c.Name = "siem"
+	}
+	if c.Logger == nil {
+		c.Logger = log.Logger()
+	}
+
+	e := &Exporter{
+		config: c,
+		formatter: &formatter{
+			format:   c.Format,
+			facility: c.Facility,
+			hostname: c.Hostname,
+			appName:  c.AppName,
+			procId:   strconv.Itoa(os.Getpid()),
+			sdId:     sdName(c.SDID),
+			vendor:   c.Vendor,
+			product:  c.Product,
+			version:  c.Version,
+		},
+		logger:   c.Logger,
+		events:   make(chan *Event, c.BatchSize*16),
+		flushReq: make(chan chan struct{}),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if c.SpoolDir != "" {
+		if err := os.MkdirAll(c.SpoolDir, 0o700); err != nil {
+			return nil, fmt.Errorf("siem exporter '%s' cannot create spool directory: %w", c.Name, err)
+		}
+		e.spoolPath = filepath.Join(c.SpoolDir, c.Name+".spool")
+	}
+	e.dialFn = e.dial
+	return e, nil
+}
+
+// Name returns the name of the exporter.
+func (e *Exporter) Name() string {
+	return e.config.Name
+}
+
+// Start begins shipping exported events in the background.
+func (e *Exporter) Start() {
+	e.startOnce.Do(func() {
+		go e.run()
+	})
+}
+
+// Stop sends or spools any queued events and closes the connection to the collector.
+func (e *Exporter) Stop() {
+	e.stopOnce.Do(func() {
+		close(e.stop)
+	})
+	e.startOnce.Do(func() {
+		close(e.done)
+	})
+	<-e.done
+}
+
+// Export queues an event for shipping. Events are dropped if the queue is full or the exporter is stopped.
+func (e *Exporter) Export(evt *Event) {
+	select {
+	case <-e.stop:
+		e.dropped.Add(1)
+		return
+	default:
+	}
+	select {
+	case e.events <- evt:
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+// Flush sends the current batch of a started exporter immediately and waits for it to be sent or spooled.
+func (e *Exporter) Flush() {
+	ack := make(chan struct{})
+	select {
+	case e.flushReq <- ack:
+		<-ack
+	case <-e.done:
+	}
+}
+
+// Dropped returns the number of events that were discarded because the queue or spool was full.
+func (e *Exporter) Dropped() uint64 {
+	return e.dropped.Load()
+}
+
+func (e *Exporter) run() {
+	defer close(e.done)
+	ticker := clock.NewTicker(e.config.FlushInterval)
+	defer ticker.Stop()
+	batch := make([]*Event, 0, e.config.BatchSize)
+	flush := func() {
+		e.ship(batch)
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case evt := <-e.events:
+			batch = append(batch, evt)
+			if len(batch) >= e.config.BatchSize {
+				flush()
+			}
+		case ack := <-e.flushReq:
+			e.drainQueue(&batch)
+			flush()
+			close(ack)
+		case <-ticker.C():
+			flush()
+		case <-e.stop:
+			e.drainQueue(&batch)
+			flush()
+			e.closeConn()
+			return
+		}
+	}
+}
+
+func (e *Exporter) drainQueue(batch *[]*Event) {
+	for {
+		select {
+		case evt := <-e.events:
+			*batch = append(*batch, evt)
+		default:
+			return
+		}
+	}
+}
+
+// ship replays any spooled events, then sends the batch. If the collector cannot be reached, the batch is
+// appended to the spool so events keep their order.
+func (e *Exporter) ship(batch []*Event) {
+	if err := e.replaySpool(); err != nil {
+		e.logger.Warn("[ranch] unable to replay spooled events to SIEM collector", "exporter", e.config.Name,
+			"address", e.config.Address, "error", err.Error())
+		e.spool(batch)
+		return
+	}
+	if len(batch) == 0 {
+		return
+	}
+	if err := e.send(batch); err != nil {
+		e.logger.Warn("[ranch] unable to ship events to SIEM collector", "exporter", e.config.Name,
+			"address", e.config.Address, "events", len(batch), "error", err.Error())
+		e.spool(batch)
+	}
+}
+
+func (e *Exporter) send(batch []*Event) error {
+	if e.conn == nil {
+		conn, err := e.dialFn()
+		if err != nil {
+			return err
+		}
+		e.conn = conn
+	}
+	var buf bytes.Buffer
+	for _, evt := range batch {
+		msg := e.formatter.Format(evt)
+		if e.config.Network == NetworkUDP {
+			// every datagram carries exactly one message.
+			if err := e.write(msg); err != nil {
+				return err
+			}
+			continue
+		}
+		// octet counting framing, as required for syslog over TLS (RFC 5425).
+		buf.WriteString(strconv.Itoa(len(msg)))
+		buf.WriteByte(' ')
+		buf.Write(msg)
+	}
+	if buf.Len() > 0 {
+		return e.write(buf.Bytes())
+	}
+	return nil
+}
+
+func (e *Exporter) write(data []byte) error {
+	_ = e.conn.SetWriteDeadline(time.Now().Add(e.config.DialTimeout))
+	if _, err := e.conn.Write(data); err != nil {
+		e.closeConn()
+		return err
+	}
+	return nil
+}
+
+func (e *Exporter) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: e.config.DialTimeout}
+	switch e.config.Network {
+	case NetworkTLS:
+		tlsConfig := e.config.TLSConfig
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		return tls.DialWithDialer(dialer, "tcp", e.config.Address, tlsConfig)
+	case NetworkUDP:
+		return dialer.Dial("udp", e.config.Address)
+	}
+	return dialer.Dial("tcp", e.config.Address)
+}
+
+func (e *Exporter) closeConn() {
+	if e.conn != nil {
+		_ = e.conn.Close()
+		e.conn = nil
+	}
+}
+
+// spool appends events to the spool file, one JSON document per line. Without a spool directory, or once
+// the spool is full, events are dropped.
+func (e *Exporter) spool(batch []*Event) {
+	if len(batch) == 0 {
+		return
+	}
+	if e.spoolPath == "" {
+		e.dropped.Add(uint64(len(batch)))
+		return
+	}
+	var size int64
+	if info, err := os.Stat(e.spoolPath); err == nil {
+		size = info.Size()
+	}
+	var buf bytes.Buffer
+	spooled := 0
+	for _, evt := range batch {
+		line, err := json.Marshal(evt)
+		if err != nil || size+int64(buf.Len()+len(line)+1) > e.config.MaxSpoolBytes {
+			continue
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+		spooled++
+	}
+	if dropped := len(batch) - spooled; dropped > 0 {
+		e.dropped.Add(uint64(dropped))
+		e.logger.Error("[ranch] SIEM spool is full, dropping events", "exporter", e.config.Name,
+			"events", dropped)
+	}
+	if spooled == 0 {
+		return
+	}
+	f, err := os.OpenFile(e.spoolPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err == nil {
+		_, err = f.Write(buf.Bytes())
+		err = errors.Join(err, f.Close())
+	}
+	if err != nil {
+		e.dropped.Add(uint64(spooled))
+		e.logger.Error("[ranch] unable to spool SIEM events", "exporter", e.config.Name, "error", err.Error())
+	}
+}
+
+// replaySpool sends every spooled event and removes the spool once they have all been sent.
+func (e *Exporter) replaySpool() error {
+	if e.spoolPath == "" {
+		return nil
+	}
+	f, err := os.Open(e.spoolPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var events []*Event
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		evt := new(Event)
+		if json.Unmarshal(scanner.Bytes(), evt) == nil {
+			events = append(events, evt)
+		}
+	}
+	_ = f.Close()
+
+	for start := 0; start < len(events); start += e.config.BatchSize {
+		end := min(start+e.config.BatchSize, len(events))
+		if err = e.send(events[start:end]); err != nil {
+			return err
+		}
+	}
+	if len(events) > 0 {
+		e.logger.Info("[ranch] replayed spooled events to SIEM collector", "exporter", e.config.Name,
+			"events", len(events))
+	}
+	return os.Remove(e.spoolPath)
+}
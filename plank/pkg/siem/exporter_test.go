@@ -0,0 +1,178 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package siem
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testCollector accepts syslog connections and reads octet counted messages.
+type testCollector struct {
+	listener net.Listener
+	messages chan string
+}
+
+func newTestCollector(t *testing.T) *testCollector {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	c := &testCollector{listener: l, messages: make(chan string, 100)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go c.read(conn)
+		}
+	}()
+	t.Cleanup(func() { _ = l.Close() })
+	return c
+}
+
+func (c *testCollector) read(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		length, err := reader.ReadString(' ')
+		if err != nil {
+			return
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(length))
+		if err != nil {
+			return
+		}
+		msg := make([]byte, size)
+		if _, err = io.ReadFull(reader, msg); err != nil {
+			return
+		}
+		c.messages <- string(msg)
+	}
+}
+
+func (c *testCollector) next(t *testing.T) string {
+	select {
+	case msg := <-c.messages:
+		return msg
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "collector did not receive a message")
+		return ""
+	}
+}
+
+func TestNewExporter_Validation(t *testing.T) {
+	_, err := NewExporter(nil)
+	assert.Error(t, err)
+	_, err = NewExporter(&ExporterConfig{})
+	assert.ErrorContains(t, err, "missing an address")
+	_, err = NewExporter(&ExporterConfig{Address: "localhost:514", Network: "carrier-pigeon"})
+	assert.ErrorContains(t, err, "unknown network")
+	_, err = NewExporter(&ExporterConfig{Address: "localhost:514", Format: "xml"})
+	assert.ErrorContains(t, err, "unknown format")
+}
+
+func TestExporter_ShipsBatches(t *testing.T) {
+	collector := newTestCollector(t)
+	exporter, err := NewExporter(&ExporterConfig{
+		Address:       collector.listener.Addr().String(),
+		Format:        FormatCEF,
+		BatchSize:     2,
+		FlushInterval: time.Hour,
+	})
+	assert.NoError(t, err)
+	exporter.Start()
+
+	exporter.Export(&Event{Class: "audit.login", Name: "Login", Severity: 3, User: "alice"})
+	exporter.Export(&Event{Class: "audit.logout", Name: "Logout", Severity: 3, User: "alice"})
+	assert.Contains(t, collector.next(t), "CEF:0|pb33f|ranch|1.0|audit.login|Login|3|")
+	assert.Contains(t, collector.next(t), "|audit.logout|Logout|")
+
+	// partial batches are sent on flush.
+	exporter.Export(&Event{Class: "audit.token", Name: "Token issued"})
+	exporter.Flush()
+	assert.Contains(t, collector.next(t), "|audit.token|")
+
+	exporter.Stop()
+	exporter.Export(&Event{Class: "late"})
+	assert.Equal(t, uint64(1), exporter.Dropped())
+}
+
+func TestExporter_SpoolsWhileCollectorIsDown(t *testing.T) {
+	collector := newTestCollector(t)
+	spoolDir := filepath.Join(t.TempDir(), "spool")
+	exporter, err := NewExporter(&ExporterConfig{
+		Name:          "soc",
+		Address:       collector.listener.Addr().String(),
+		FlushInterval: time.Hour,
+		SpoolDir:      spoolDir,
+	})
+	assert.NoError(t, err)
+
+	up := false
+	exporter.dialFn = func() (net.Conn, error) {
+		if !up {
+			return nil, fmt.Errorf("connection refused")
+		}
+		return exporter.dial()
+	}
+	exporter.Start()
+
+	exporter.Export(&Event{Class: "first"})
+	exporter.Flush()
+	exporter.Export(&Event{Class: "second"})
+	exporter.Flush()
+
+	spooled, err := os.ReadFile(filepath.Join(spoolDir, "soc.spool"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(spooled), "\n"))
+
+	// once the collector is back, spooled events are replayed ahead of new ones.
+	up = true
+	exporter.Export(&Event{Class: "third"})
+	exporter.Flush()
+	assert.Contains(t, collector.next(t), " first ")
+	assert.Contains(t, collector.next(t), " second ")
+	assert.Contains(t, collector.next(t), " third ")
+	assert.NoFileExists(t, filepath.Join(spoolDir, "soc.spool"))
+	assert.Zero(t, exporter.Dropped())
+	exporter.Stop()
+}
+
+func TestExporter_DropsWhenSpoolIsFull(t *testing.T) {
+	exporter, _ := NewExporter(&ExporterConfig{
+		Address:       "127.0.0.1:1",
+		FlushInterval: time.Hour,
+		SpoolDir:      t.TempDir(),
+		MaxSpoolBytes: 200,
+	})
+	exporter.dialFn = func() (net.Conn, error) {
+		return nil, fmt.Errorf("connection refused")
+	}
+	exporter.Start()
+	for i := 0; i < 5; i++ {
+		exporter.Export(&Event{Class: "audit.event", Message: "a message that is long enough"})
+	}
+	exporter.Stop()
+	assert.Equal(t, uint64(4), exporter.Dropped())
+
+	// without a spool directory nothing is kept.
+	exporter, _ = NewExporter(&ExporterConfig{Address: "127.0.0.1:1", FlushInterval: time.Hour})
+	exporter.dialFn = func() (net.Conn, error) {
+		return nil, fmt.Errorf("connection refused")
+	}
+	exporter.Start()
+	exporter.Export(&Event{Class: "audit.event"})
+	exporter.Stop()
+	assert.Equal(t, uint64(1), exporter.Dropped())
+}
@@ -0,0 +1,184 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package siem
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pb33f/ranch/clock"
+)
+
+const (
+	FormatSyslog = "syslog" // RFC 5424 with the event carried as structured data
+	FormatCEF    = "cef"    // ArcSight Common Event Format carried in an RFC 5424 message
+	FormatLEEF   = "leef"   // IBM QRadar Log Event Extended Format carried in an RFC 5424 message
+)
+
+// FacilityLogAudit is the syslog "log audit" facility, used by default.
+const FacilityLogAudit = 13
+
+// formatter renders events as complete RFC 5424 syslog messages.
+type formatter struct {
+	format   string
+	facility int
+	hostname string
+	appName  string
+	procId   string
+	sdId     string
+	vendor   string
+	product  string
+	version  string
+}
+
+func (f *formatter) Format(evt *Event) []byte {
+	var b strings.Builder
+	pri := f.facility*8 + evt.syslogSeverity()
+	ts := evt.Time
+	if ts.IsZero() {
+		ts = clock.Now()
+	}
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %s ", pri, ts.UTC().Format(time.RFC3339Nano),
+		syslogHeaderField(f.hostname, 255), syslogHeaderField(f.appName, 48), syslogHeaderField(f.procId, 128))
+
+	switch f.format {
+	case FormatCEF:
+		b.WriteString("- - ")
+		f.writeCEF(&b, evt)
+	case FormatLEEF:
+		b.WriteString("- - ")
+		f.writeLEEF(&b, evt)
+	default:
+		b.WriteString(syslogHeaderField(evt.Class, 32))
+		b.WriteString(" ")
+		f.writeStructuredData(&b, evt)
+		if evt.Message != "" {
+			b.WriteString(" ")
+			b.WriteString(evt.Message)
+		}
+	}
+	return []byte(b.String())
+}
+
+// writeStructuredData writes a single SD-ELEMENT holding every field of the event.
+func (f *formatter) writeStructuredData(b *strings.Builder, evt *Event) {
+	b.WriteString("[")
+	b.WriteString(f.sdId)
+	writeParam := func(name, value string) {
+		if value == "" {
+			return
+		}
+		b.WriteString(" ")
+		b.WriteString(sdName(name))
+		b.WriteString(`="`)
+		b.WriteString(sdParamEscaper.Replace(value))
+		b.WriteString(`"`)
+	}
+	writeParam("name", evt.Name)
+	writeParam("severity", strconv.Itoa(evt.Severity))
+	writeParam("outcome", evt.Outcome)
+	writeParam("src", evt.SourceIP)
+	writeParam("user", evt.User)
+	for _, key := range sortedKeys(evt.Extensions) {
+		writeParam(key, evt.Extensions[key])
+	}
+	b.WriteString("]")
+}
+
+func (f *formatter) writeCEF(b *strings.Builder, evt *Event) {
+	fmt.Fprintf(b, "CEF:0|%s|%s|%s|%s|%s|%d|",
+		cefHeaderEscaper.Replace(f.vendor), cefHeaderEscaper.Replace(f.product), cefHeaderEscaper.Replace(f.version),
+		cefHeaderEscaper.Replace(evt.Class), cefHeaderEscaper.Replace(evt.Name), evt.Severity)
+	var ext []string
+	add := func(key, value string) {
+		if value != "" {
+			ext = append(ext, key+"="+cefExtensionEscaper.Replace(value))
+		}
+	}
+	if !evt.Time.IsZero() {
+		add("rt", strconv.FormatInt(evt.Time.UnixMilli(), 10))
+	}
+	add("src", evt.SourceIP)
+	add("suser", evt.User)
+	add("outcome", evt.Outcome)
+	add("msg", evt.Message)
+	for _, key := range sortedKeys(evt.Extensions) {
+		add(key, evt.Extensions[key])
+	}
+	b.WriteString(strings.Join(ext, " "))
+}
+
+func (f *formatter) writeLEEF(b *strings.Builder, evt *Event) {
+	fmt.Fprintf(b, "LEEF:1.0|%s|%s|%s|%s|",
+		leefHeaderEscaper.Replace(f.vendor), leefHeaderEscaper.Replace(f.product), leefHeaderEscaper.Replace(f.version),
+		leefHeaderEscaper.Replace(evt.Class))
+	var attrs []string
+	add := func(key, value string) {
+		if value != "" {
+			attrs = append(attrs, key+"="+leefValueEscaper.Replace(value))
+		}
+	}
+	if !evt.Time.IsZero() {
+		add("devTime", strconv.FormatInt(evt.Time.UnixMilli(), 10))
+		add("devTimeFormat", "epoch")
+	}
+	add("cat", evt.Name)
+	add("sev", strconv.Itoa(evt.Severity))
+	add("src", evt.SourceIP)
+	add("usrName", evt.User)
+	add("outcome", evt.Outcome)
+	add("msg", evt.Message)
+	for _, key := range sortedKeys(evt.Extensions) {
+		add(key, evt.Extensions[key])
+	}
+	b.WriteString(strings.Join(attrs, "\t"))
+}
+
+var (
+	sdParamEscaper      = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+	cefHeaderEscaper    = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ")
+	cefExtensionEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+	leefHeaderEscaper   = strings.NewReplacer(`|`, `\|`, "\n", " ", "\r", " ")
+	leefValueEscaper    = strings.NewReplacer("\t", " ", "\n", " ", "\r", " ")
+)
+
+// syslogHeaderField returns a value safe for an RFC 5424 header field: printable US-ASCII without spaces,
+// truncated to max characters, or the nil value "-" when empty.
+func syslogHeaderField(value string, max int) string {
+	field := strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return -1
+		}
+		return r
+	}, value)
+	if len(field) > max {
+		field = field[:max]
+	}
+	if field == "" {
+		return "-"
+	}
+	return field
+}
+
+// sdName returns a valid SD-NAME, which may not contain '=', ']', '"' or spaces and is limited to 32 characters.
+func sdName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '=' || r == ']' || r == '"' {
+			return '_'
+		}
+		return r
+	}, syslogHeaderField(name, 32))
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
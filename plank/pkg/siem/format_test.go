@@ -0,0 +1,85 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package siem
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/plank/pkg/abuse"
+	"github.com/stretchr/testify/assert"
+)
+
+func testFormatter(format string) *formatter {
+	return &formatter{
+		format:   format,
+		facility: FacilityLogAudit,
+		hostname: "ranch-01",
+		appName:  "ranch",
+		procId:   "42",
+		sdId:     defaultSDID,
+		vendor:   "pb33f",
+		product:  "ranch",
+		version:  "1.0",
+	}
+}
+
+func testEvent() *Event {
+	return &Event{
+		Time:       time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC),
+		Class:      "abuse.banned",
+		Name:       "Client banned",
+		Severity:   8,
+		Outcome:    OutcomeFailure,
+		SourceIP:   "10.0.0.1",
+		User:       "mallory",
+		Message:    `too many "errors" = bad|worse`,
+		Extensions: map[string]string{"detector": "burst"},
+	}
+}
+
+func TestFormatter_Syslog(t *testing.T) {
+	assert.Equal(t, `<107>1 2023-05-01T12:00:00Z ranch-01 ranch 42 abuse.banned [ranch@32473 name="Client banned" `+
+		`severity="8" outcome="failure" src="10.0.0.1" user="mallory" detector="burst"] too many "errors" = bad|worse`,
+		string(testFormatter(FormatSyslog).Format(testEvent())))
+
+	evt := &Event{Time: time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC), Severity: 1,
+		Extensions: map[string]string{"path": `C:\temp]`}}
+	assert.Equal(t, `<110>1 2023-05-01T12:00:00Z ranch-01 ranch 42 - [ranch@32473 severity="1" path="C:\\temp\]"]`,
+		string(testFormatter(FormatSyslog).Format(evt)))
+}
+
+func TestFormatter_CEF(t *testing.T) {
+	assert.Equal(t, `<107>1 2023-05-01T12:00:00Z ranch-01 ranch 42 - - CEF:0|pb33f|ranch|1.0|abuse.banned|Client banned|8|`+
+		`rt=1682942400000 src=10.0.0.1 suser=mallory outcome=failure msg=too many "errors" \= bad|worse detector=burst`,
+		string(testFormatter(FormatCEF).Format(testEvent())))
+}
+
+func TestFormatter_LEEF(t *testing.T) {
+	assert.Equal(t, "<107>1 2023-05-01T12:00:00Z ranch-01 ranch 42 - - LEEF:1.0|pb33f|ranch|1.0|abuse.banned|"+
+		"devTime=1682942400000\tdevTimeFormat=epoch\tcat=Client banned\tsev=8\tsrc=10.0.0.1\tusrName=mallory\t"+
+		"outcome=failure\tmsg=too many \"errors\" = bad|worse\tdetector=burst",
+		string(testFormatter(FormatLEEF).Format(testEvent())))
+}
+
+func TestFromPayload(t *testing.T) {
+	until := time.Now().Add(time.Minute)
+	evt := FromPayload(&abuse.Event{Type: abuse.EventBanned, Source: abuse.SourceHttp, Detector: "burst",
+		Key: abuse.IPKey("10.0.0.1"), IP: "10.0.0.1", Score: 1.5, Until: until})
+	assert.Equal(t, "abuse.banned", evt.Class)
+	assert.Equal(t, 8, evt.Severity)
+	assert.Equal(t, "10.0.0.1", evt.SourceIP)
+	assert.Equal(t, "1.50", evt.Extensions["score"])
+	assert.Equal(t, "burst", evt.Extensions["detector"])
+
+	evt = FromPayload(bus.TokenRevocation{Token: "secret-token", Reason: "logout"})
+	assert.Equal(t, "session.revoked", evt.Class)
+	assert.Equal(t, "logout", evt.Message)
+	assert.NotContains(t, string(testFormatter(FormatSyslog).Format(evt)), "secret-token")
+
+	custom := &Event{Class: "audit.login"}
+	assert.Same(t, custom, FromPayload(custom))
+	assert.Nil(t, FromPayload("not an event"))
+}
@@ -0,0 +1,495 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+// Package websub is a WebSub hub (https://www.w3.org/TR/websub/) pushing the responses of bus channels to
+// HTTP callbacks, for integrations unable to hold a fabric connection. Every topic of the hub maps to a
+// channel. Subscribers register a callback for a topic, the hub verifies they meant to before accepting
+// it, and every response sent on the channel is then posted to the callback, signed with the secret of the
+// subscription if it has one, and retried with a backoff when the subscriber fails to accept it.
+package websub
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/clock"
+	"github.com/pb33f/ranch/model"
+)
+
+const (
+	// SignatureHeader carries the HMAC of a delivery when its subscription has a secret, as
+	// "sha256=<hex digest>".
+	SignatureHeader = "X-Hub-Signature"
+
+	ModeSubscribe   = "subscribe"
+	ModeUnsubscribe = "unsubscribe"
+	ModeDenied      = "denied"
+
+	defaultLeaseSeconds    = 24 * 60 * 60
+	defaultMaxLeaseSeconds = 10 * 24 * 60 * 60
+	defaultMaxAttempts     = 5
+	defaultRetryDelay      = 2 * time.Second
+	maxSecretLength        = 200 // secrets must be shorter, as the specification requires
+	deliveryQueueSize      = 256 // deliveries waiting per subscription, those beyond are dropped
+)
+
+// Config selects the topics of a Hub and how it delivers.
+type Config struct {
+	HubURL          string            // public URL of the hub, advertised in the Link header of deliveries
+	Topics          map[string]string // channel whose responses are delivered, by topic URL
+	LeaseSeconds    int               // lease of subscriptions not asking for one, defaults to a day
+	MaxLeaseSeconds int               // longest lease granted, defaults to 10 days
+	MaxAttempts     int               // attempts at a delivery before giving up, defaults to 5
+	RetryDelay      time.Duration     // wait before the second attempt, doubled after every attempt, defaults to 2 seconds
+	Client          *http.Client      // verifies intents and delivers, defaults to a client with a 30 second timeout
+	Logger          *slog.Logger      // defaults to slog.Default()
+}
+
+// Subscription is a verified subscription of a callback to a topic.
+type Subscription struct {
+	Topic    string    `json:"topic"`
+	Callback string    `json:"callback"`
+	Expires  time.Time `json:"expires"`
+}
+
+// Stats counts the deliveries of a Hub.
+type Stats struct {
+	Delivered uint64 `json:"delivered"` // deliveries accepted by their subscriber
+	Retried   uint64 `json:"retried"`   // attempts made again after a subscriber failed to accept a delivery
+	Failed    uint64 `json:"failed"`    // deliveries given up on after every attempt failed
+	Dropped   uint64 `json:"dropped"`   // deliveries dropped as the subscriber was too far behind
+}
+
+// subscription is a Subscription and the queue of its deliveries, posted in order by its own goroutine.
+type subscription struct {
+	Subscription
+	secret string
+	queue  chan []byte
+	done   chan struct{}
+}
+
+// Hub is a WebSub hub for the topics of its configuration. It is an http.Handler receiving subscription
+// requests, to be mounted at HubURL.
+type Hub struct {
+	config        *Config
+	eventBus      bus.EventBus
+	logger        *slog.Logger
+	client        *http.Client
+	subscriptions map[string]*subscription // by topic and callback
+	handlers      []bus.MessageHandler
+	started       bool
+	stop          chan struct{}
+	wg            sync.WaitGroup
+	lock          sync.Mutex
+	delivered     atomic.Uint64
+	retried       atomic.Uint64
+	failed        atomic.Uint64
+	dropped       atomic.Uint64
+}
+
+// New creates a Hub for the topics of config.
+func New(eventBus bus.EventBus, config *Config) (*Hub, error) {
+	if config == nil || len(config.Topics) == 0 {
+		return nil, fmt.Errorf("websub hub has no topics")
+	}
+	for topic, channel := range config.Topics {
+		if channel == "" {
+			return nil, fmt.Errorf("websub topic '%s' has no channel", topic)
+		}
+	}
+	h := &Hub{
+		config:        config,
+		eventBus:      eventBus,
+		logger:        config.Logger,
+		client:        config.Client,
+		subscriptions: make(map[string]*subscription),
+	}
+	if h.logger == nil {
+		h.logger = slog.Default()
+	}
+	if h.client == nil {
+		h.client = &http.Client{Timeout: 30 * time.Second}
+	}
+	return h, nil
+}
+
+// Start delivers the responses sent on the channels of the topics to their subscribers, including those
+// subscribed before the hub was stopped.
+func (h *Hub) Start() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.started {
+		return fmt.Errorf("websub hub already started")
+	}
+	cm := h.eventBus.GetChannelManager()
+	for topic, channel := range h.config.Topics {
+		if !cm.CheckChannelExists(channel) {
+			cm.CreateChannel(channel)
+		}
+		handler, err := h.eventBus.ListenStream(channel)
+		if err != nil {
+			h.closeHandlers()
+			return err
+		}
+		topic := topic
+		handler.Handle(func(msg *model.Message) {
+			h.publish(topic, msg.Payload)
+		}, func(err error) {})
+		h.handlers = append(h.handlers, handler)
+	}
+	h.stop = make(chan struct{})
+	h.started = true
+	for _, sub := range h.subscriptions {
+		sub.queue = make(chan []byte, deliveryQueueSize)
+		h.wg.Add(1)
+		go h.run(sub, sub.queue, h.stop)
+	}
+	return nil
+}
+
+// Stop stops delivering and waits for the deliveries and verifications under way to give up. Subscriptions
+// are kept, but their queued deliveries are dropped.
+func (h *Hub) Stop() {
+	h.lock.Lock()
+	if !h.started {
+		h.lock.Unlock()
+		return
+	}
+	h.started = false
+	h.closeHandlers()
+	close(h.stop)
+	h.lock.Unlock()
+	h.wg.Wait()
+}
+
+func (h *Hub) closeHandlers() {
+	for _, handler := range h.handlers {
+		handler.Close()
+	}
+	h.handlers = nil
+}
+
+// Subscriptions returns the subscriptions whose lease has not expired, by topic and callback.
+func (h *Hub) Subscriptions() []Subscription {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	now := clock.Now()
+	subs := make([]Subscription, 0, len(h.subscriptions))
+	for _, sub := range h.subscriptions {
+		if sub.Expires.After(now) {
+			subs = append(subs, sub.Subscription)
+		}
+	}
+	sort.Slice(subs, func(i, j int) bool {
+		if subs[i].Topic != subs[j].Topic {
+			return subs[i].Topic < subs[j].Topic
+		}
+		return subs[i].Callback < subs[j].Callback
+	})
+	return subs
+}
+
+// Stats returns the counters of the hub.
+func (h *Hub) Stats() Stats {
+	return Stats{
+		Delivered: h.delivered.Load(),
+		Retried:   h.retried.Load(),
+		Failed:    h.failed.Load(),
+		Dropped:   h.dropped.Load(),
+	}
+}
+
+// ServeHTTP receives subscription and unsubscription requests. Valid requests are accepted straight away and
+// verified in the background: the subscription only changes once the subscriber confirmed the intent,
+// requests for topics the hub does not have are denied to the subscriber.
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	mode, topic, callback := r.PostForm.Get("hub.mode"), r.PostForm.Get("hub.topic"), r.PostForm.Get("hub.callback")
+	if mode != ModeSubscribe && mode != ModeUnsubscribe {
+		http.Error(w, fmt.Sprintf("unsupported hub.mode '%s'", mode), http.StatusBadRequest)
+		return
+	}
+	if topic == "" {
+		http.Error(w, "hub.topic is required", http.StatusBadRequest)
+		return
+	}
+	if u, err := url.Parse(callback); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		http.Error(w, "hub.callback must be an absolute http(s) URL", http.StatusBadRequest)
+		return
+	}
+	secret := r.PostForm.Get("hub.secret")
+	if len(secret) >= maxSecretLength {
+		http.Error(w, "hub.secret must be shorter than 200 bytes", http.StatusBadRequest)
+		return
+	}
+	lease := h.lease(r.PostForm.Get("hub.lease_seconds"))
+
+	h.lock.Lock()
+	if !h.started {
+		h.lock.Unlock()
+		http.Error(w, "hub is not running", http.StatusServiceUnavailable)
+		return
+	}
+	h.wg.Add(1)
+	h.lock.Unlock()
+	go func() {
+		defer h.wg.Done()
+		if _, ok := h.config.Topics[topic]; !ok {
+			h.deny(topic, callback, "unknown topic")
+			return
+		}
+		h.verify(mode, topic, callback, secret, lease)
+	}()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// lease returns the lease granted for the lease asked for, in seconds.
+func (h *Hub) lease(asked string) int {
+	lease, maxLease := h.config.LeaseSeconds, h.config.MaxLeaseSeconds
+	if lease <= 0 {
+		lease = defaultLeaseSeconds
+	}
+	if maxLease <= 0 {
+		maxLease = defaultMaxLeaseSeconds
+	}
+	if seconds, err := strconv.Atoi(asked); err == nil && seconds > 0 {
+		lease = seconds
+	}
+	return min(lease, maxLease)
+}
+
+// verify asks the subscriber to confirm the intent of a request by echoing a challenge, and subscribes or
+// unsubscribes the callback once it did.
+func (h *Hub) verify(mode, topic, callback, secret string, lease int) {
+	challenge := uuid.NewString()
+	query := url.Values{
+		"hub.mode":      {mode},
+		"hub.topic":     {topic},
+		"hub.challenge": {challenge},
+	}
+	if mode == ModeSubscribe {
+		query.Set("hub.lease_seconds", strconv.Itoa(lease))
+	}
+	resp, err := h.client.Get(withQuery(callback, query))
+	if err != nil {
+		h.logger.Warn("[ranch] websub intent not verified", "topic", topic, "callback", callback, "error", err.Error())
+		return
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, int64(len(challenge))+1))
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 || string(body) != challenge {
+		h.logger.Warn("[ranch] websub intent not confirmed", "topic", topic, "callback", callback,
+			"status", resp.StatusCode)
+		return
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	key := topic + " " + callback
+	existing := h.subscriptions[key]
+	if mode == ModeUnsubscribe {
+		if existing != nil {
+			close(existing.done)
+			delete(h.subscriptions, key)
+		}
+		return
+	}
+	expires := clock.Now().Add(time.Duration(lease) * time.Second)
+	if existing != nil {
+		existing.Expires, existing.secret = expires, secret
+		return
+	}
+	sub := &subscription{
+		Subscription: Subscription{Topic: topic, Callback: callback, Expires: expires},
+		secret:       secret,
+		queue:        make(chan []byte, deliveryQueueSize),
+		done:         make(chan struct{}),
+	}
+	h.subscriptions[key] = sub
+	if h.started {
+		h.wg.Add(1)
+		go h.run(sub, sub.queue, h.stop)
+	}
+}
+
+// deny tells the subscriber its request was denied.
+func (h *Hub) deny(topic, callback, reason string) {
+	resp, err := h.client.Get(withQuery(callback, url.Values{
+		"hub.mode":   {ModeDenied},
+		"hub.topic":  {topic},
+		"hub.reason": {reason},
+	}))
+	if err != nil {
+		return
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+// publish queues a delivery of the payload to every subscriber of the topic. []byte payloads are delivered
+// as they are, any other as JSON.
+func (h *Hub) publish(topic string, payload interface{}) {
+	body, ok := payload.([]byte)
+	if !ok {
+		var err error
+		if body, err = json.Marshal(payload); err != nil {
+			h.logger.Warn("[ranch] websub payload cannot be encoded", "topic", topic, "error", err.Error())
+			return
+		}
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	now := clock.Now()
+	for key, sub := range h.subscriptions {
+		if sub.Topic != topic {
+			continue
+		}
+		if !sub.Expires.After(now) {
+			close(sub.done)
+			delete(h.subscriptions, key)
+			continue
+		}
+		select {
+		case sub.queue <- body:
+		default:
+			h.dropped.Add(1)
+		}
+	}
+}
+
+// run posts the deliveries of a subscription in order, until it is removed or the hub stops.
+func (h *Hub) run(sub *subscription, queue chan []byte, stop chan struct{}) {
+	defer h.wg.Done()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-sub.done:
+			return
+		case body := <-queue:
+			if !h.deliver(sub, body, stop) {
+				return
+			}
+		}
+	}
+}
+
+// deliver posts a delivery to the callback of the subscription, retrying with a backoff until it is accepted
+// or every attempt failed. A subscriber answering 410 Gone is unsubscribed, deliver returns false then.
+func (h *Hub) deliver(sub *subscription, body []byte, stop chan struct{}) bool {
+	h.lock.Lock()
+	secret := sub.secret
+	h.lock.Unlock()
+	maxAttempts, delay := h.config.MaxAttempts, h.config.RetryDelay
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+	if delay <= 0 {
+		delay = defaultRetryDelay
+	}
+	for attempt := 1; ; attempt++ {
+		status, err := h.post(sub, body, secret)
+		switch {
+		case err == nil && status >= 200 && status <= 299:
+			h.delivered.Add(1)
+			return true
+		case err == nil && status == http.StatusGone:
+			h.remove(sub)
+			return false
+		}
+		if attempt >= maxAttempts {
+			h.failed.Add(1)
+			reason := fmt.Sprintf("status %d", status)
+			if err != nil {
+				reason = err.Error()
+			}
+			h.logger.Warn("[ranch] websub delivery failed", "topic", sub.Topic, "callback", sub.Callback,
+				"attempts", attempt, "error", reason)
+			return true
+		}
+		h.retried.Add(1)
+		select {
+		case <-stop:
+			return false
+		case <-sub.done:
+			return false
+		case <-clock.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func (h *Hub) post(sub *subscription, body []byte, secret string) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, sub.Callback, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	links := []string{fmt.Sprintf(`<%s>; rel="self"`, sub.Topic)}
+	if h.config.HubURL != "" {
+		links = append(links, fmt.Sprintf(`<%s>; rel="hub"`, h.config.HubURL))
+	}
+	req.Header.Set("Link", strings.Join(links, ", "))
+	if secret != "" {
+		req.Header.Set(SignatureHeader, Sign(secret, body))
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// remove unsubscribes a subscription, unless it was already.
+func (h *Hub) remove(sub *subscription) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	key := sub.Topic + " " + sub.Callback
+	if h.subscriptions[key] == sub {
+		close(sub.done)
+		delete(h.subscriptions, key)
+	}
+}
+
+// Sign returns the signature of a delivery to a subscription with secret, as sent in SignatureHeader.
+// Subscribers check a delivery by comparing the header with the signature of its body, e.g. with
+// hmac.Equal.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func withQuery(callback string, query url.Values) string {
+	separator := "?"
+	if strings.Contains(callback, "?") {
+		separator = "&"
+	}
+	return callback + separator + query.Encode()
+}
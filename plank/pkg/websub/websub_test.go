@@ -0,0 +1,259 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package websub
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testTopic = "https://example.com/orders"
+
+// testSubscriber is a callback confirming every intent, and answering deliveries with the statuses queued
+// in replies, then 200.
+type testSubscriber struct {
+	server     *httptest.Server
+	lock       sync.Mutex
+	replies    []int
+	confirm    bool
+	intents    []url.Values
+	deliveries []*http.Request
+	bodies     []string
+}
+
+func newTestSubscriber(t *testing.T) *testSubscriber {
+	s := &testSubscriber{confirm: true}
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if r.Method == http.MethodGet {
+			s.intents = append(s.intents, r.URL.Query())
+			if s.confirm {
+				_, _ = w.Write([]byte(r.URL.Query().Get("hub.challenge")))
+			}
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		s.deliveries = append(s.deliveries, r)
+		s.bodies = append(s.bodies, string(body))
+		if len(s.replies) > 0 {
+			w.WriteHeader(s.replies[0])
+			s.replies = s.replies[1:]
+		}
+	}))
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+func (s *testSubscriber) received() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string(nil), s.bodies...)
+}
+
+func (s *testSubscriber) delivery(i int) *http.Request {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.deliveries[i]
+}
+
+func (s *testSubscriber) lastIntent() url.Values {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.intents) == 0 {
+		return nil
+	}
+	return s.intents[len(s.intents)-1]
+}
+
+func newTestHub(t *testing.T, config *Config) (*Hub, bus.EventBus) {
+	eventBus := bus.NewEventBusInstance()
+	config.Topics = map[string]string{testTopic: "orders"}
+	config.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	hub, err := New(eventBus, config)
+	require.NoError(t, err)
+	require.NoError(t, hub.Start())
+	t.Cleanup(hub.Stop)
+	return hub, eventBus
+}
+
+func request(hub *Hub, form url.Values) int {
+	req := httptest.NewRequest(http.MethodPost, "/websub", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	hub.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func subscribe(t *testing.T, hub *Hub, callback string, extra url.Values) {
+	form := url.Values{"hub.mode": {ModeSubscribe}, "hub.topic": {testTopic}, "hub.callback": {callback}}
+	for k, v := range extra {
+		form[k] = v
+	}
+	require.Equal(t, http.StatusAccepted, request(hub, form))
+	require.Eventually(t, func() bool {
+		return len(hub.Subscriptions()) == 1
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(bus.NewEventBusInstance(), &Config{})
+	assert.Error(t, err)
+	_, err = New(bus.NewEventBusInstance(), &Config{Topics: map[string]string{testTopic: ""}})
+	assert.Error(t, err)
+}
+
+func TestHub_Deliver(t *testing.T) {
+	hub, eventBus := newTestHub(t, &Config{HubURL: "https://example.com/websub", MaxLeaseSeconds: 60})
+	subscriber := newTestSubscriber(t)
+	subscribe(t, hub, subscriber.server.URL+"/hook?tenant=1",
+		url.Values{"hub.secret": {"s3cret"}, "hub.lease_seconds": {"3600"}})
+
+	intent := subscriber.lastIntent()
+	assert.Equal(t, ModeSubscribe, intent.Get("hub.mode"))
+	assert.Equal(t, testTopic, intent.Get("hub.topic"))
+	assert.Equal(t, "1", intent.Get("tenant"))
+	assert.Equal(t, "60", intent.Get("hub.lease_seconds"))
+
+	require.NoError(t, eventBus.SendResponseMessage("orders", map[string]int{"cows": 12}, nil))
+	assert.Eventually(t, func() bool {
+		return len(subscriber.received()) == 1
+	}, time.Second, 5*time.Millisecond)
+	require.NoError(t, eventBus.SendResponseMessage("orders", []byte(`{"raw":true}`), nil))
+	assert.Eventually(t, func() bool {
+		return len(subscriber.received()) == 2
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []string{`{"cows":12}`, `{"raw":true}`}, subscriber.received())
+
+	delivery := subscriber.delivery(0)
+	assert.Equal(t, "application/json", delivery.Header.Get("Content-Type"))
+	assert.Equal(t, Sign("s3cret", []byte(`{"cows":12}`)), delivery.Header.Get(SignatureHeader))
+	assert.Equal(t, `<https://example.com/orders>; rel="self", <https://example.com/websub>; rel="hub"`,
+		delivery.Header.Get("Link"))
+	assert.Equal(t, Stats{Delivered: 2}, hub.Stats())
+
+	// unsubscribing is verified too
+	assert.Equal(t, http.StatusAccepted, request(hub, url.Values{"hub.mode": {ModeUnsubscribe},
+		"hub.topic": {testTopic}, "hub.callback": {subscriber.server.URL + "/hook?tenant=1"}}))
+	assert.Eventually(t, func() bool {
+		return len(hub.Subscriptions()) == 0
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, ModeUnsubscribe, subscriber.lastIntent().Get("hub.mode"))
+}
+
+func TestHub_Retry(t *testing.T) {
+	hub, eventBus := newTestHub(t, &Config{MaxAttempts: 3, RetryDelay: time.Millisecond})
+	subscriber := newTestSubscriber(t)
+	subscriber.replies = []int{http.StatusInternalServerError, http.StatusBadGateway}
+	subscribe(t, hub, subscriber.server.URL, nil)
+
+	require.NoError(t, eventBus.SendResponseMessage("orders", "first", nil))
+	assert.Eventually(t, func() bool {
+		return hub.Stats().Delivered == 1
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []string{`"first"`, `"first"`, `"first"`}, subscriber.received())
+	assert.Empty(t, subscriber.delivery(0).Header.Get(SignatureHeader))
+
+	// deliveries are given up on once every attempt failed
+	subscriber.lock.Lock()
+	subscriber.replies = []int{500, 500, 500}
+	subscriber.lock.Unlock()
+	require.NoError(t, eventBus.SendResponseMessage("orders", "second", nil))
+	assert.Eventually(t, func() bool {
+		return hub.Stats().Failed == 1
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, Stats{Delivered: 1, Retried: 4, Failed: 1}, hub.Stats())
+	assert.Len(t, hub.Subscriptions(), 1)
+
+	// subscribers gone are unsubscribed
+	subscriber.lock.Lock()
+	subscriber.replies = []int{http.StatusGone}
+	subscriber.lock.Unlock()
+	require.NoError(t, eventBus.SendResponseMessage("orders", "third", nil))
+	assert.Eventually(t, func() bool {
+		return len(hub.Subscriptions()) == 0
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestHub_Requests(t *testing.T) {
+	hub, _ := newTestHub(t, &Config{})
+	subscriber := newTestSubscriber(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/websub", nil)
+	rec := httptest.NewRecorder()
+	hub.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	valid := url.Values{"hub.mode": {ModeSubscribe}, "hub.topic": {testTopic}, "hub.callback": {subscriber.server.URL}}
+	for name, change := range map[string]url.Values{
+		"mode":     {"hub.mode": {"publish"}},
+		"topic":    {"hub.topic": {""}},
+		"callback": {"hub.callback": {"/relative"}},
+		"secret":   {"hub.secret": {strings.Repeat("s", 200)}},
+	} {
+		form := url.Values{}
+		for k, v := range valid {
+			form[k] = v
+		}
+		for k, v := range change {
+			form[k] = v
+		}
+		assert.Equal(t, http.StatusBadRequest, request(hub, form), name)
+	}
+
+	// requests for other topics are denied to the subscriber
+	assert.Equal(t, http.StatusAccepted, request(hub, url.Values{"hub.mode": {ModeSubscribe},
+		"hub.topic": {"https://example.com/stock"}, "hub.callback": {subscriber.server.URL}}))
+	assert.Eventually(t, func() bool {
+		return subscriber.lastIntent().Get("hub.mode") == ModeDenied
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, "https://example.com/stock", subscriber.lastIntent().Get("hub.topic"))
+
+	// subscribers not confirming the intent are not subscribed
+	subscriber.lock.Lock()
+	subscriber.confirm = false
+	subscriber.lock.Unlock()
+	assert.Equal(t, http.StatusAccepted, request(hub, valid))
+	assert.Eventually(t, func() bool {
+		return subscriber.lastIntent().Get("hub.mode") == ModeSubscribe
+	}, time.Second, 5*time.Millisecond)
+	hub.Stop()
+	assert.Empty(t, hub.Subscriptions())
+	assert.Equal(t, http.StatusServiceUnavailable, request(hub, valid))
+}
+
+func TestHub_Lease(t *testing.T) {
+	fake := clock.NewFakeClock(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	clock.Set(fake)
+	defer clock.Reset()
+
+	hub, eventBus := newTestHub(t, &Config{LeaseSeconds: 60})
+	subscriber := newTestSubscriber(t)
+	subscribe(t, hub, subscriber.server.URL, nil)
+	assert.Equal(t, "60", subscriber.lastIntent().Get("hub.lease_seconds"))
+	assert.Equal(t, fake.Now().Add(time.Minute), hub.Subscriptions()[0].Expires)
+
+	// expired subscriptions are left out, and removed once something is published
+	fake.Advance(time.Minute)
+	assert.Empty(t, hub.Subscriptions())
+	require.NoError(t, eventBus.SendResponseMessage("orders", "late", nil))
+	assert.Eventually(t, func() bool {
+		hub.lock.Lock()
+		defer hub.lock.Unlock()
+		return len(hub.subscriptions) == 0
+	}, time.Second, 5*time.Millisecond)
+	assert.Empty(t, subscriber.received())
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/pb33f/ranch/clock"
 	"github.com/pb33f/ranch/model"
 	"github.com/pb33f/ranch/service"
 	"io/ioutil"
@@ -49,7 +50,7 @@ func (ps *PingPongService) HandleServiceRequest(request *model.Request, core ser
 	// ping-post request type accepts the payload as a POJO
 	case "ping-post":
 		m := make(map[string]interface{})
-		m["timestamp"] = time.Now().Unix()
+		m["timestamp"] = clock.Now().Unix()
 		err := json.Unmarshal(request.Payload.([]byte), &m)
 		if err != nil {
 			core.SendErrorResponse(request, 400, err.Error())
@@ -61,7 +62,7 @@ func (ps *PingPongService) HandleServiceRequest(request *model.Request, core ser
 		rsp := make(map[string]interface{})
 		val := request.Payload.(string)
 		rsp["payload"] = val + "-response"
-		rsp["timestamp"] = time.Now().Unix()
+		rsp["timestamp"] = clock.Now().Unix()
 		core.SendResponse(request, rsp)
 	default:
 		core.HandleUnknownRequest(request)
@@ -86,7 +87,7 @@ func (ps *PingPongService) OnServiceReady() chan bool {
 // or cleanup that needs to be done, this is the right place to perform that.
 func (ps *PingPongService) OnServerShutdown() {
 	// for sample purposes emulate a 1 second teardown process
-	time.Sleep(1 * time.Second)
+	clock.Sleep(1 * time.Second)
 }
 
 // GetRESTBridgeConfig returns a list of REST bridge configurations that Plank will use to automatically register
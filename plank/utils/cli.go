@@ -27,6 +27,10 @@ var PlatformServerFlagConstants = map[string]map[string]string{
 		"FlagName":    "cert-key",
 		"Description": "X509 Certificate private Key file for TLS",
 	},
+	"FIPSMode": {
+		"FlagName":    "fips-mode",
+		"Description": "Restrict TLS versions, cipher suites, curves and certificate keys to a FIPS vetted set",
+	},
 	"Static": {
 		"FlagName":    "static",
 		"ShortFlag":   "s",
@@ -97,4 +101,8 @@ var PlatformServerFlagConstants = map[string]map[string]string{
 		"FlagName":    "rest-bridge-timeout",
 		"Description": "Time in minutes before a REST endpoint for a service request to timeout",
 	},
+	"DevMode": {
+		"FlagName":    "dev-mode",
+		"Description": "Serve the canned bridge responses described in this JSON file, to run the server standalone during development",
+	},
 }
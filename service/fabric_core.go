@@ -34,6 +34,20 @@ type FabricServiceCore interface {
 	// SendResponseWithHeadersAndCode is the same as SendResponseWithHeaders, but inclides a custom HTTP status code.
 	SendResponseWithHeadersAndCode(request *model.Request, responsePayload interface{}, headers map[string]any, code int)
 
+	// SendHttpResponse is the same as SendResponse, but sets the status code, content type, headers and cookies of
+	// the HTTP response REST bridges write for it.
+	SendHttpResponse(request *model.Request, responsePayload interface{}, httpResponse *model.HttpResponse)
+
+	// SendStreamResponse sends a chunk of a streamed response, marshalled to JSON. REST bridges write each chunk
+	// as a line of NDJSON as soon as it arrives, with the headers of the first chunk, until EndStreamResponse.
+	SendStreamResponse(request *model.Request, chunk interface{}, headers map[string]any)
+
+	// SendStreamResponseAsString is the same as SendStreamResponse, but the chunk is written as is.
+	SendStreamResponseAsString(request *model.Request, chunk string, headers map[string]any)
+
+	// EndStreamResponse ends a streamed response. An error response sent instead ends it as well.
+	EndStreamResponse(request *model.Request)
+
 	// SendErrorResponse builds an error model.Response object and sends it on the service channel as response to the "request" param.
 	SendErrorResponse(request *model.Request, responseErrorCode int, responseErrorMessage string)
 
@@ -53,6 +67,11 @@ type FabricServiceCore interface {
 	SendErrorResponseWithHeadersAndPayload(request *model.Request, responseErrorCode int, responseErrorMessage string,
 		payload interface{}, headers map[string]any)
 
+	// SendErrorResponseWithDetail is the same as SendErrorResponse, but classifies the error with its kind,
+	// the translation key of the message and whether the request may be retried.
+	SendErrorResponseWithDetail(request *model.Request, responseErrorCode int, responseErrorMessage string,
+		detail model.ErrorDetail)
+
 	// HandleUnknownRequest handles unknown/unsupported/un-implemented requests,
 	HandleUnknownRequest(request *model.Request)
 
@@ -76,6 +95,7 @@ type fabricCore struct {
 	channelName string
 	bus         bus.EventBus
 	headers     map[string]string
+	usage       *serviceUsage
 }
 
 func (core *fabricCore) Bus() bus.EventBus {
@@ -94,7 +114,7 @@ func (core *fabricCore) SendResponse(request *model.Request, responsePayload int
 		Marshal:           true,
 		BrokerDestination: request.BrokerDestination,
 	}
-	core.bus.SendResponseMessage(core.channelName, response, request.Id)
+	core.sendResponse(response)
 }
 
 func (core *fabricCore) SendResponseAsStringWithHeaders(request *model.Request, responsePayload string, headers map[string]any) {
@@ -109,7 +129,7 @@ func (core *fabricCore) SendResponseAsStringWithHeaders(request *model.Request,
 		Marshal:           false,
 		BrokerDestination: request.BrokerDestination,
 	}
-	core.bus.SendResponseMessage(core.channelName, response, request.Id)
+	core.sendResponse(response)
 }
 
 func (core *fabricCore) SendResponseAsString(request *model.Request, responsePayload string) {
@@ -124,7 +144,7 @@ func (core *fabricCore) SendResponseAsString(request *model.Request, responsePay
 		Marshal:           false,
 		BrokerDestination: request.BrokerDestination,
 	}
-	core.bus.SendResponseMessage(core.channelName, response, request.Id)
+	core.sendResponse(response)
 }
 
 func (core *fabricCore) SendResponseWithHeaders(request *model.Request, responsePayload interface{}, headers map[string]any) {
@@ -139,7 +159,7 @@ func (core *fabricCore) SendResponseWithHeaders(request *model.Request, response
 		BrokerDestination: request.BrokerDestination,
 		Headers:           headers,
 	}
-	core.bus.SendResponseMessage(core.channelName, response, request.Id)
+	core.sendResponse(response)
 }
 
 func (core *fabricCore) SendResponseWithHeadersAndCode(request *model.Request, responsePayload interface{}, headers map[string]any, code int) {
@@ -155,7 +175,59 @@ func (core *fabricCore) SendResponseWithHeadersAndCode(request *model.Request, r
 		Headers:           headers,
 		HttpStatusCode:    code,
 	}
-	core.bus.SendResponseMessage(core.channelName, response, request.Id)
+	core.sendResponse(response)
+}
+
+func (core *fabricCore) SendHttpResponse(request *model.Request, responsePayload interface{},
+	httpResponse *model.HttpResponse) {
+
+	if httpResponse == nil {
+		httpResponse = &model.HttpResponse{}
+	}
+	response := &model.Response{
+		Id:                request.Id,
+		Destination:       core.channelName,
+		Payload:           responsePayload,
+		Marshal:           true,
+		BrokerDestination: request.BrokerDestination,
+		Headers:           core.mergeHeadersWithDefaults(httpResponse.Headers),
+		HttpStatusCode:    httpResponse.StatusCode,
+		ContentType:       httpResponse.ContentType,
+		Cookies:           httpResponse.Cookies,
+	}
+	core.sendResponse(response)
+}
+
+func (core *fabricCore) SendStreamResponse(request *model.Request, chunk interface{}, headers map[string]any) {
+	core.sendStreamResponse(request, chunk, headers, true)
+}
+
+func (core *fabricCore) SendStreamResponseAsString(request *model.Request, chunk string, headers map[string]any) {
+	core.sendStreamResponse(request, chunk, headers, false)
+}
+
+func (core *fabricCore) sendStreamResponse(request *model.Request, chunk interface{}, headers map[string]any,
+	marshal bool) {
+
+	response := &model.Response{
+		Id:                request.Id,
+		Destination:       core.channelName,
+		Payload:           chunk,
+		Headers:           core.mergeHeadersWithDefaults(headers),
+		Marshal:           marshal,
+		BrokerDestination: request.BrokerDestination,
+		Stream:            true,
+	}
+	core.sendResponse(response)
+}
+
+func (core *fabricCore) EndStreamResponse(request *model.Request) {
+	response := &model.Response{
+		Id:                request.Id,
+		Destination:       core.channelName,
+		BrokerDestination: request.BrokerDestination,
+	}
+	core.sendResponse(response)
 }
 
 func (core *fabricCore) SendErrorResponse(
@@ -180,7 +252,7 @@ func (core *fabricCore) SendErrorResponseWithPayload(
 		ErrorMessage:      responseErrorMessage,
 		BrokerDestination: request.BrokerDestination,
 	}
-	core.bus.SendResponseMessage(core.channelName, response, request.Id)
+	core.sendResponse(response)
 }
 
 func (core *fabricCore) SendErrorResponseWithHeaders(
@@ -199,7 +271,7 @@ func (core *fabricCore) SendErrorResponseWithHeaders(
 		ErrorMessage:      responseErrorMessage,
 		BrokerDestination: request.BrokerDestination,
 	}
-	core.bus.SendResponseMessage(core.channelName, response, request.Id)
+	core.sendResponse(response)
 }
 
 func (core *fabricCore) SendErrorResponseWithHeadersAndPayload(
@@ -219,7 +291,7 @@ func (core *fabricCore) SendErrorResponseWithHeadersAndPayload(
 		ErrorMessage:      responseErrorMessage,
 		BrokerDestination: request.BrokerDestination,
 	}
-	core.bus.SendResponseMessage(core.channelName, response, request.Id)
+	core.sendResponse(response)
 }
 
 func (core *fabricCore) SendErrorResponseAsStringWithHeadersAndPayload(
@@ -239,7 +311,35 @@ func (core *fabricCore) SendErrorResponseAsStringWithHeadersAndPayload(
 		ErrorMessage:      responseErrorMessage,
 		BrokerDestination: request.BrokerDestination,
 	}
-	core.bus.SendResponseMessage(core.channelName, response, request.Id)
+	core.sendResponse(response)
+}
+
+func (core *fabricCore) SendErrorResponseWithDetail(
+	request *model.Request,
+	responseErrorCode int, responseErrorMessage string, detail model.ErrorDetail) {
+
+	headers := core.mergeHeadersWithDefaults(nil)
+
+	response := &model.Response{
+		Id:                request.Id,
+		Destination:       core.channelName,
+		Headers:           headers,
+		Error:             true,
+		Marshal:           true,
+		ErrorCode:         responseErrorCode,
+		ErrorMessage:      responseErrorMessage,
+		ErrorKind:         detail.Kind,
+		ErrorKey:          detail.Key,
+		Retriable:         detail.Retriable,
+		BrokerDestination: request.BrokerDestination,
+	}
+	core.sendResponse(response)
+}
+
+// sendResponse sends the response on the service channel and accounts for it in the service usage.
+func (core *fabricCore) sendResponse(response *model.Response) {
+	core.usage.recordResponse(response.Payload, response.Error)
+	core.bus.SendResponseMessage(core.channelName, response, response.Id)
 }
 
 func (core *fabricCore) HandleUnknownRequest(request *model.Request) {
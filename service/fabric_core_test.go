@@ -9,6 +9,7 @@ import (
 	"github.com/pb33f/ranch/bus"
 	"github.com/pb33f/ranch/model"
 	"github.com/stretchr/testify/assert"
+	"net/http"
 	"sync"
 	"testing"
 )
@@ -131,18 +132,101 @@ func TestFabricCore_SendMethods(t *testing.T) {
 	assert.Equal(t, response.ErrorMessage, "test-header-payload-error")
 
 	wg.Add(1)
-	core.HandleUnknownRequest(&req)
+	core.SendErrorResponseWithDetail(&req, 503, "test-detail-error",
+		model.ErrorDetail{Key: "errors.maintenance", Retriable: true})
 	wg.Wait()
 
 	assert.Equal(t, count, 6)
 	response = lastMessage.Payload.(*model.Response)
 
+	assert.True(t, response.Error)
+	assert.Equal(t, "errors.maintenance", response.ErrorKey)
+	assert.True(t, response.Retriable)
+	assert.Equal(t, model.ErrorKindUnavailable, response.ErrorDetail().Kind)
+
+	wg.Add(1)
+	core.HandleUnknownRequest(&req)
+	wg.Wait()
+
+	assert.Equal(t, count, 7)
+	response = lastMessage.Payload.(*model.Response)
+
 	assert.Equal(t, response.Id, req.Id)
 	assert.True(t, response.Error)
 	assert.Equal(t, 403, response.ErrorCode)
 	assert.Equal(t, nil, response.Payload)
 }
 
+func TestFabricCore_SendStreamMethods(t *testing.T) {
+	core := newTestFabricCore("test-channel")
+	mh, _ := core.Bus().ListenStream("test-channel")
+	responses := make(chan *model.Response, 3)
+	mh.Handle(func(message *model.Message) {
+		responses <- message.Payload.(*model.Response)
+	}, func(e error) {
+		assert.Fail(t, "unexpected error")
+	})
+
+	id := uuid.New()
+	req := model.Request{Id: &id, RequestCommand: "test-request"}
+
+	core.SendStreamResponse(&req, map[string]int{"chunk": 1}, map[string]any{"X-Herd": "cattle"})
+	response := <-responses
+	assert.True(t, response.Stream)
+	assert.True(t, response.Marshal)
+	assert.Equal(t, map[string]int{"chunk": 1}, response.Payload)
+	assert.Equal(t, "cattle", response.Headers["X-Herd"])
+
+	core.SendStreamResponseAsString(&req, "moo", nil)
+	response = <-responses
+	assert.True(t, response.Stream)
+	assert.False(t, response.Marshal)
+	assert.Equal(t, "moo", response.Payload)
+
+	core.EndStreamResponse(&req)
+	response = <-responses
+	assert.Equal(t, response.Id, req.Id)
+	assert.False(t, response.Stream)
+	assert.False(t, response.Error)
+	assert.Nil(t, response.Payload)
+}
+
+func TestFabricCore_SendHttpResponse(t *testing.T) {
+	core := newTestFabricCore("test-channel")
+	core.SetHeaders(map[string]string{"X-Herd": "cattle"})
+	mh, _ := core.Bus().ListenStream("test-channel")
+	responses := make(chan *model.Response, 2)
+	mh.Handle(func(message *model.Message) {
+		responses <- message.Payload.(*model.Response)
+	}, func(e error) {
+		assert.Fail(t, "unexpected error")
+	})
+
+	id := uuid.New()
+	req := model.Request{Id: &id, RequestCommand: "test-request"}
+
+	cookie := &http.Cookie{Name: "session", Value: "moo"}
+	core.SendHttpResponse(&req, map[string]string{"cow": "daisy"}, &model.HttpResponse{
+		StatusCode:  http.StatusCreated,
+		ContentType: "application/vnd.cow+json",
+		Headers:     map[string]any{"Location": "/cows/daisy"},
+		Cookies:     []*http.Cookie{cookie},
+	})
+	response := <-responses
+	assert.Equal(t, response.Id, req.Id)
+	assert.True(t, response.Marshal)
+	assert.Equal(t, http.StatusCreated, response.HttpStatusCode)
+	assert.Equal(t, "application/vnd.cow+json", response.ContentType)
+	assert.Equal(t, map[string]any{"X-Herd": "cattle", "Location": "/cows/daisy"}, response.Headers)
+	assert.Equal(t, []*http.Cookie{cookie}, response.Cookies)
+
+	core.SendHttpResponse(&req, "moo", nil)
+	response = <-responses
+	assert.Equal(t, "moo", response.Payload)
+	assert.Zero(t, response.HttpStatusCode)
+	assert.Equal(t, map[string]any{"X-Herd": "cattle"}, response.Headers)
+}
+
 func TestFabricCore_RestServiceRequest(t *testing.T) {
 
 	core := newTestFabricCore("test-channel")
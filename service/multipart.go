@@ -0,0 +1,206 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+)
+
+// DefaultMultipartMaxMemory is how much of a multipart form is held in memory if MultipartConfig.MaxMemory is 0.
+const DefaultMultipartMaxMemory = 32 << 20
+
+// ErrMultipartLimit is returned parsing a multipart form that exceeds the limits of its MultipartConfig.
+var ErrMultipartLimit = errors.New("multipart form exceeds its limits")
+
+// MultipartConfig lets a REST bridge accept multipart/form-data requests. The form is parsed as it is read,
+// before the FabricRequestBuilder is called, which gets it with MultipartFormFromRequest. Files that do not
+// fit in memory are spooled to temp files, removed once the response to the request is written.
+type MultipartConfig struct {
+	MaxMemory    int64  // bytes of files and values held in memory, DefaultMultipartMaxMemory if 0
+	MaxFileBytes int64  // largest file accepted, larger ones are refused with 413. no limit if 0
+	MaxFiles     int    // most files accepted, more are refused with 413. no limit if 0
+	TempDir      string // directory files are spooled to, os.TempDir() if empty
+}
+
+// MultipartForm is the parsed body of a multipart/form-data request.
+type MultipartForm struct {
+	Values map[string][]string        `json:"values"` // values of the fields that are not files
+	Files  map[string][]*UploadedFile `json:"files"`  // files, by the name of their field
+}
+
+// UploadedFile is a file of a multipart/form-data request, held in memory or spooled to a temp file.
+type UploadedFile struct {
+	Field       string               `json:"field"`
+	Filename    string               `json:"filename"`
+	ContentType string               `json:"contentType"`
+	Size        int64                `json:"size"`
+	Header      textproto.MIMEHeader `json:"header"`
+	Path        string               `json:"path,omitempty"` // temp file the content is spooled to, empty if held in memory
+	content     []byte
+}
+
+// Open opens the content of the file.
+func (f *UploadedFile) Open() (io.ReadCloser, error) {
+	if f.Path != "" {
+		return os.Open(f.Path)
+	}
+	return io.NopCloser(bytes.NewReader(f.content)), nil
+}
+
+// RemoveAll removes the temp files the files of the form are spooled to.
+func (f *MultipartForm) RemoveAll() error {
+	var errs []error
+	for _, files := range f.Files {
+		for _, file := range files {
+			if file.Path == "" {
+				continue
+			}
+			if err := os.Remove(file.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ParseMultipartForm parses the multipart/form-data body of a request part by part, as it is read, within the
+// limits of cfg. Forms exceeding them fail with ErrMultipartLimit, requests that are not multipart with
+// http.ErrNotMultipart. The files spooled so far are removed if the form cannot be parsed.
+func ParseMultipartForm(r *http.Request, cfg *MultipartConfig) (*MultipartForm, error) {
+	if cfg == nil {
+		cfg = &MultipartConfig{}
+	}
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	memory := cfg.MaxMemory
+	if memory <= 0 {
+		memory = DefaultMultipartMaxMemory
+	}
+
+	form := &MultipartForm{Values: make(map[string][]string), Files: make(map[string][]*UploadedFile)}
+	files := 0
+	fail := func(err error) (*MultipartForm, error) {
+		_ = form.RemoveAll()
+		return nil, err
+	}
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return form, nil
+		}
+		if err != nil {
+			return fail(err)
+		}
+		field := part.FormName()
+		if field == "" {
+			_ = part.Close()
+			continue
+		}
+
+		if part.FileName() == "" {
+			var value bytes.Buffer
+			n, err := io.CopyN(&value, part, memory-int64(len(field))+1)
+			_ = part.Close()
+			if err != nil && !errors.Is(err, io.EOF) {
+				return fail(err)
+			}
+			if memory -= int64(len(field)) + n; memory < 0 {
+				return fail(fmt.Errorf("%w: values are larger than the memory of the form", ErrMultipartLimit))
+			}
+			form.Values[field] = append(form.Values[field], value.String())
+			continue
+		}
+
+		if files++; cfg.MaxFiles > 0 && files > cfg.MaxFiles {
+			_ = part.Close()
+			return fail(fmt.Errorf("%w: more than %d files", ErrMultipartLimit, cfg.MaxFiles))
+		}
+		file, err := readUploadedFile(part, cfg, memory)
+		_ = part.Close()
+		if file != nil {
+			form.Files[field] = append(form.Files[field], file)
+		}
+		if err != nil {
+			return fail(err)
+		}
+		if file.Path == "" {
+			memory -= file.Size
+		}
+	}
+}
+
+// readUploadedFile reads a file part, holding it in memory if it fits, spooling it to a temp file otherwise.
+// The file is returned along with the error if its temp file exists, for it to be removed.
+func readUploadedFile(part *multipart.Part, cfg *MultipartConfig, memory int64) (*UploadedFile, error) {
+	file := &UploadedFile{
+		Field:       part.FormName(),
+		Filename:    part.FileName(),
+		ContentType: part.Header.Get("Content-Type"),
+		Header:      part.Header,
+	}
+	tooLarge := func(size int64) error {
+		if cfg.MaxFileBytes > 0 && size > cfg.MaxFileBytes {
+			return fmt.Errorf("%w: file %q is larger than %d bytes", ErrMultipartLimit, file.Filename, cfg.MaxFileBytes)
+		}
+		return nil
+	}
+
+	var content bytes.Buffer
+	n, err := io.CopyN(&content, part, max(memory, 0)+1)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if err = tooLarge(n); err != nil {
+		return nil, err
+	}
+	if n <= memory {
+		file.Size, file.content = n, content.Bytes()
+		return file, nil
+	}
+
+	spool, err := os.CreateTemp(cfg.TempDir, "ranch-upload-*")
+	if err != nil {
+		return nil, err
+	}
+	defer spool.Close()
+	file.Path = spool.Name()
+	rest := io.Reader(part)
+	if cfg.MaxFileBytes > 0 {
+		rest = io.LimitReader(part, cfg.MaxFileBytes-n+1)
+	}
+	if _, err = spool.Write(content.Bytes()); err != nil {
+		return file, err
+	}
+	copied, err := io.Copy(spool, rest)
+	if err != nil {
+		return file, err
+	}
+	file.Size = n + copied
+	return file, tooLarge(file.Size)
+}
+
+type multipartFormKey struct{}
+
+// NewMultipartContext returns a copy of ctx carrying the multipart form of its request.
+func NewMultipartContext(ctx context.Context, form *MultipartForm) context.Context {
+	return context.WithValue(ctx, multipartFormKey{}, form)
+}
+
+// MultipartFormFromRequest returns the multipart form of a request to a REST bridge accepting them, nil if the
+// request is not multipart/form-data. FabricRequestBuilders use it rather than r.ParseMultipartForm, the body
+// having been read already.
+func MultipartFormFromRequest(r *http.Request) *MultipartForm {
+	form, _ := r.Context().Value(multipartFormKey{}).(*MultipartForm)
+	return form
+}
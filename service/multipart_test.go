@@ -0,0 +1,103 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package service
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// multipartRequest returns a request with a multipart/form-data body of the values and files given.
+func multipartRequest(t *testing.T, values map[string]string, files map[string]string) *http.Request {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for field, value := range values {
+		require.NoError(t, writer.WriteField(field, value))
+	}
+	for filename, content := range files {
+		part, err := writer.CreateFormFile("upload", filename)
+		require.NoError(t, err)
+		_, err = part.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.Close())
+	r := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	return r
+}
+
+func readUpload(t *testing.T, file *UploadedFile) string {
+	content, err := file.Open()
+	require.NoError(t, err)
+	defer content.Close()
+	data, err := io.ReadAll(content)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestParseMultipartForm(t *testing.T) {
+	dir := t.TempDir()
+	r := multipartRequest(t, map[string]string{"herd": "cattle"},
+		map[string]string{"small.txt": "moo", "large.txt": strings.Repeat("moo", 10)})
+	form, err := ParseMultipartForm(r, &MultipartConfig{MaxMemory: 16, TempDir: dir})
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{"herd": {"cattle"}}, form.Values)
+	require.Len(t, form.Files["upload"], 2)
+
+	// files that fit in memory stay there, others are spooled to temp files
+	files := make(map[string]*UploadedFile)
+	for _, file := range form.Files["upload"] {
+		files[file.Filename] = file
+	}
+	assert.Empty(t, files["small.txt"].Path)
+	assert.Equal(t, int64(3), files["small.txt"].Size)
+	assert.Equal(t, "moo", readUpload(t, files["small.txt"]))
+	assert.Equal(t, "application/octet-stream", files["large.txt"].ContentType)
+	assert.Equal(t, dir, filepath.Dir(files["large.txt"].Path))
+	assert.Equal(t, int64(30), files["large.txt"].Size)
+	assert.Equal(t, strings.Repeat("moo", 10), readUpload(t, files["large.txt"]))
+
+	assert.NoError(t, form.RemoveAll())
+	_, err = os.Stat(files["large.txt"].Path)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// the form is carried by the context of its request
+	assert.Nil(t, MultipartFormFromRequest(r))
+	assert.Same(t, form, MultipartFormFromRequest(r.WithContext(NewMultipartContext(r.Context(), form))))
+}
+
+func TestParseMultipartForm_Limits(t *testing.T) {
+	dir := t.TempDir()
+	spooled := func() []os.DirEntry {
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		return entries
+	}
+
+	_, err := ParseMultipartForm(multipartRequest(t, nil, map[string]string{"large.txt": strings.Repeat("moo", 10)}),
+		&MultipartConfig{MaxMemory: 4, MaxFileBytes: 20, TempDir: dir})
+	assert.ErrorIs(t, err, ErrMultipartLimit)
+	assert.Empty(t, spooled())
+
+	_, err = ParseMultipartForm(multipartRequest(t, nil, map[string]string{"a.txt": "moo", "b.txt": "moo"}),
+		&MultipartConfig{MaxFiles: 1, TempDir: dir})
+	assert.ErrorIs(t, err, ErrMultipartLimit)
+
+	_, err = ParseMultipartForm(multipartRequest(t, map[string]string{"herd": strings.Repeat("moo", 10)}, nil),
+		&MultipartConfig{MaxMemory: 16, TempDir: dir})
+	assert.ErrorIs(t, err, ErrMultipartLimit)
+
+	_, err = ParseMultipartForm(httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader("moo")), nil)
+	assert.ErrorIs(t, err, http.ErrNotMultipart)
+}
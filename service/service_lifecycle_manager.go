@@ -1,8 +1,10 @@
 package service
 
 import (
+	"context"
 	"github.com/pb33f/ranch/model"
 	"net/http"
+	"time"
 )
 
 var svcLifecycleManagerInstance ServiceLifecycleManager
@@ -13,6 +15,8 @@ type ServiceLifecycleManager interface {
 	//GetServiceHooks(serviceChannelName string) ServiceLifecycleHookEnabled
 	GetOnReadyCapableService(serviceChannelName string) OnServiceReadyEnabled
 	GetOnServerShutdownService(serviceChannelName string) OnServerShutdownEnabled
+	GetOnWarmupService(serviceChannelName string) OnWarmupEnabled
+	GetHealthCheckService(serviceChannelName string) HealthCheckEnabled
 	GetRESTBridgeEnabledService(serviceChannelName string) RESTBridgeEnabled
 	OverrideRESTBridgeConfig(serviceChannelName string, config []*RESTBridgeConfig) error
 }
@@ -35,6 +39,14 @@ type OnServerShutdownEnabled interface {
 	OnServerShutdown() // teardown logic goes here and will be automatically invoked on graceful server shutdown
 }
 
+type OnWarmupEnabled interface {
+	OnWarmup(ctx context.Context) error // cache priming and first calls go here, invoked once every service is ready and before the server reports being online
+}
+
+type HealthCheckEnabled interface {
+	HealthCheck(ctx context.Context) error // whether the service can serve, e.g. its database answers a ping. checked by the readiness endpoint of the server
+}
+
 type SetupRESTBridgeRequest struct {
 	ServiceChannel string
 	Override       bool
@@ -42,12 +54,37 @@ type SetupRESTBridgeRequest struct {
 }
 
 type RESTBridgeConfig struct {
-	ServiceChannel       string         // transport service channel
-	Uri                  string         // URI to map the transport service to
-	Method               string         // HTTP verb to map the transport service request to URI with
-	AllowHead            bool           // whether HEAD calls are allowed for this bridge point
-	AllowOptions         bool           // whether OPTIONS calls are allowed for this bridge point
-	FabricRequestBuilder RequestBuilder // function to transform HTTP request into a transport request
+	ServiceChannel       string           // transport service channel
+	Uri                  string           // URI to map the transport service to
+	Method               string           // HTTP verb to map the transport service request to URI with
+	AllowHead            bool             // whether HEAD calls are allowed for this bridge point
+	AllowOptions         bool             // whether OPTIONS calls are allowed for this bridge point
+	FabricRequestBuilder RequestBuilder   // function to transform HTTP request into a transport request
+	Timeout              time.Duration    // how long requests wait for the service to respond, the server's REST bridge timeout if 0
+	MaxRequestBodyBytes  int64            // largest request body accepted, larger ones are refused with 413. the server's limit if 0
+	ReadTimeout          time.Duration    // how long reading a request body may take before it is refused with 408, the server's if 0
+	Multipart            *MultipartConfig // accepts multipart/form-data requests, parsed before FabricRequestBuilder is called, if set
+	SurrogateKeys        []string         // surrogate keys responses are tagged with besides the service channel, when edge caching is enabled
+	Cache                *CacheConfig     // caches GET and HEAD responses, when the server has a response cache. not cached if nil
+	Summary              string           // one line summary of the endpoint, shown in the API documentation
+	Description          string           // longer description of the endpoint, shown in the API documentation
+	Examples             []*BridgeExample // example requests and responses, shown in the API documentation
+}
+
+// BridgeExample is an example call of a REST bridge, shown in the API documentation.
+type BridgeExample struct {
+	Name     string      // name of the example, e.g. "existing stock"
+	Request  interface{} // example request body, serialized as JSON. none if nil
+	Response interface{} // example response body, serialized as JSON. none if nil
+}
+
+// CacheConfig lets the server cache the successful GET and HEAD responses of a REST bridge, answering the
+// requests that follow without sending them to the service channel. Responses are cached by method, URI and
+// the values of the Vary headers, and are purged, before they expire, by the invalidations published for the
+// service channel or the SurrogateKeys of the bridge.
+type CacheConfig struct {
+	TTL  time.Duration // how long responses are cached, the server's default if 0
+	Vary []string      // request headers responses depend on, e.g. Accept-Language. Authorization and Cookie make them private to the client
 }
 
 type serviceLifecycleManager struct {
@@ -80,6 +117,32 @@ func (lm *serviceLifecycleManager) GetOnServerShutdownService(serviceChannelName
 	return nil
 }
 
+// GetOnWarmupService returns a service that implements OnWarmupEnabled
+func (lm *serviceLifecycleManager) GetOnWarmupService(serviceChannelName string) OnWarmupEnabled {
+	service, err := lm.serviceRegistryRef.GetService(serviceChannelName)
+	if err != nil {
+		return nil
+	}
+
+	if lifecycleHookEnabled, ok := service.(OnWarmupEnabled); ok {
+		return lifecycleHookEnabled
+	}
+	return nil
+}
+
+// GetHealthCheckService returns a service that implements HealthCheckEnabled
+func (lm *serviceLifecycleManager) GetHealthCheckService(serviceChannelName string) HealthCheckEnabled {
+	service, err := lm.serviceRegistryRef.GetService(serviceChannelName)
+	if err != nil {
+		return nil
+	}
+
+	if lifecycleHookEnabled, ok := service.(HealthCheckEnabled); ok {
+		return lifecycleHookEnabled
+	}
+	return nil
+}
+
 // GetRESTBridgeEnabledService returns a service that implements OnServerShutdownEnabled
 func (lm *serviceLifecycleManager) GetRESTBridgeEnabledService(serviceChannelName string) RESTBridgeEnabled {
 	service, err := lm.serviceRegistryRef.GetService(serviceChannelName)
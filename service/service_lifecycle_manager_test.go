@@ -1,6 +1,8 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"github.com/pb33f/ranch/model"
 	"github.com/stretchr/testify/assert"
 	"net/http"
@@ -33,6 +35,40 @@ func TestServiceLifecycleManager_GetServiceHooks(t *testing.T) {
 	assert.NotNil(t, hooks)
 }
 
+func TestServiceLifecycleManager_GetOnWarmupService(t *testing.T) {
+	// arrange
+	sr := newTestServiceRegistry()
+	lcm := newTestServiceLifecycleManager(sr)
+	sr.RegisterService(&mockLifecycleHookEnabledService{}, "another-test-channel")
+	sr.RegisterService(&mockInitializableService{}, "test-channel")
+
+	// act
+	hooks := lcm.GetOnWarmupService("another-test-channel")
+
+	// assert
+	assert.NotNil(t, hooks)
+	assert.NoError(t, hooks.OnWarmup(context.Background()))
+	assert.Nil(t, lcm.GetOnWarmupService("test-channel"))
+	assert.Nil(t, lcm.GetOnWarmupService("i-don-t-exist"))
+}
+
+func TestServiceLifecycleManager_GetHealthCheckService(t *testing.T) {
+	// arrange
+	sr := newTestServiceRegistry()
+	lcm := newTestServiceLifecycleManager(sr)
+	sr.RegisterService(&mockLifecycleHookEnabledService{unhealthy: errors.New("no hay")}, "another-test-channel")
+	sr.RegisterService(&mockInitializableService{}, "test-channel")
+
+	// act
+	check := lcm.GetHealthCheckService("another-test-channel")
+
+	// assert
+	assert.NotNil(t, check)
+	assert.EqualError(t, check.HealthCheck(context.Background()), "no hay")
+	assert.Nil(t, lcm.GetHealthCheckService("test-channel"))
+	assert.Nil(t, lcm.GetHealthCheckService("i-don-t-exist"))
+}
+
 func TestServiceLifecycleManager_GetServiceHooks_NoSuchService(t *testing.T) {
 	// arrange
 	sr := newTestServiceRegistry()
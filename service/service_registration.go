@@ -0,0 +1,47 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package service
+
+import (
+	"fmt"
+
+	"github.com/pb33f/ranch/bus"
+)
+
+// ServiceRegistrationChannelName is the channel registration changes are published on, as
+// ServiceRegistrationEvent responses. Changes of internal services are not published.
+const ServiceRegistrationChannelName = bus.RANCH_INTERNAL_CHANNEL_PREFIX + "service-registrations"
+
+// ServiceRegistrationEventType is the kind of registration change a ServiceRegistrationEvent reports.
+type ServiceRegistrationEventType string
+
+const (
+	ServiceRegistered   ServiceRegistrationEventType = "registered"   // a service was registered on a free channel
+	ServiceReplaced     ServiceRegistrationEventType = "replaced"     // a service was replaced by a higher version
+	ServiceUnregistered ServiceRegistrationEventType = "unregistered" // a service was unregistered
+)
+
+// ServiceRegistrationEvent reports a change of the service registered on a channel.
+type ServiceRegistrationEvent struct {
+	Type            ServiceRegistrationEventType `json:"type"`
+	Channel         string                       `json:"channel"`
+	Version         int                          `json:"version"`                   // version registered, or unregistered
+	PreviousVersion int                          `json:"previousVersion,omitempty"` // version replaced
+}
+
+// ServiceRegistrationError is returned when registering a service on a channel another service is
+// registered on, without bumping the version.
+type ServiceRegistrationError struct {
+	Channel           string
+	Version           int // version the registration asked for
+	RegisteredVersion int // version of the service registered on the channel
+}
+
+func (e *ServiceRegistrationError) Error() string {
+	if e.Version == 0 && e.RegisteredVersion == 0 {
+		return fmt.Sprintf("unable to register service: service channel name is already used: %s", e.Channel)
+	}
+	return fmt.Sprintf("unable to register service: service channel name is already used: %s "+
+		"(version %d is registered, version %d is not above it)", e.Channel, e.RegisteredVersion, e.Version)
+}
@@ -4,12 +4,15 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"github.com/pb33f/ranch/bus"
+	"github.com/pb33f/ranch/clock"
+	ranchlog "github.com/pb33f/ranch/log"
 	"github.com/pb33f/ranch/model"
-	"log"
 	"reflect"
 	"sync"
+	"sync/atomic"
 )
 
 var internalServices = map[string]bool{
@@ -35,6 +38,12 @@ type ServiceRegistry interface {
 	// its Init method will be called during the registration process.
 	RegisterService(service FabricService, serviceChannelName string) error
 
+	// RegisterServiceVersion registers a fabric service at a version. If a service is registered on the
+	// channel already, it is replaced if version is above its version, or a *ServiceRegistrationError is
+	// returned. The replacement is initialized before taking over the requests of the channel, requests
+	// being handled by the replaced service finish with it. RegisterService registers services at version 0.
+	RegisterServiceVersion(service FabricService, serviceChannelName string, version int) error
+
 	// UnregisterService unregisters the fabric service associated with the given channel.
 	UnregisterService(serviceChannelName string) error
 
@@ -43,6 +52,21 @@ type ServiceRegistry interface {
 
 	// GetService returns the FabricService for the channel name given as the parameter
 	GetService(serviceChannelName string) (FabricService, error)
+
+	// GetServiceVersion returns the version of the service registered on the channel name given as the parameter
+	GetServiceVersion(serviceChannelName string) (int, error)
+
+	// GetInFlightRequests returns the number of requests each service is handling right now, keyed by
+	// service channel. Internal services are left out.
+	GetInFlightRequests() map[string]int64
+
+	// EnableUsageAccounting starts or stops counting what each service consumes. It is off by default,
+	// as measuring every request and response payload slows down every service.
+	EnableUsageAccounting(enable bool)
+
+	// GetServiceUsage returns what each service consumed while usage accounting was enabled, keyed by
+	// service channel. Internal services are left out.
+	GetServiceUsage() map[string]ServiceUsage
 }
 
 type serviceRegistry struct {
@@ -50,6 +74,7 @@ type serviceRegistry struct {
 	services         map[string]*fabricServiceWrapper
 	bus              bus.EventBus
 	lifecycleManager *serviceLifecycleManager
+	usageAccounting  int32 // 1 when usage accounting is enabled, shared with the usage of every service
 }
 
 var once sync.Once
@@ -80,6 +105,9 @@ func newServiceRegistry(bus bus.EventBus) ServiceRegistry {
 	// create a channel for service lifecycle manager
 	_ = bus.GetChannelManager().CreateChannel(LifecycleManagerChannelName)
 
+	// create a channel for registration change events
+	_ = bus.GetChannelManager().CreateChannel(ServiceRegistrationChannelName)
+
 	// create a bus store for delivering service ready notifications
 	bus.GetStoreManager().CreateStoreWithType(ServiceReadyStore, reflect.TypeOf(true)).Initialize()
 
@@ -97,6 +125,15 @@ func (r *serviceRegistry) GetService(serviceChannelName string) (FabricService,
 	return nil, fmt.Errorf("fabric service not found at channel %s", serviceChannelName)
 }
 
+// GetServiceVersion returns the version of the service registered at the provided service channel name.
+// if no service is found at the service channel it returns an error.
+func (r *serviceRegistry) GetServiceVersion(serviceChannelName string) (int, error) {
+	if serviceWrapper, ok := r.services[serviceChannelName]; ok {
+		return serviceWrapper.version, nil
+	}
+	return 0, fmt.Errorf("fabric service not found at channel %s", serviceChannelName)
+}
+
 func (r *serviceRegistry) SetGlobalRestServiceBaseHost(host string) {
 	r.services[restServiceChannel].service.(*restService).setBaseHost(host)
 }
@@ -113,7 +150,46 @@ func (r *serviceRegistry) GetAllServiceChannels() []string {
 	return services
 }
 
+// GetInFlightRequests returns the number of requests each service is handling right now
+func (r *serviceRegistry) GetInFlightRequests() map[string]int64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	inFlight := make(map[string]int64)
+	for chanName, sw := range r.services {
+		if !internalServices[chanName] {
+			inFlight[chanName] = atomic.LoadInt64(&sw.inFlight)
+		}
+	}
+	return inFlight
+}
+
+// EnableUsageAccounting starts or stops counting what each service consumes
+func (r *serviceRegistry) EnableUsageAccounting(enable bool) {
+	var flag int32
+	if enable {
+		flag = 1
+	}
+	atomic.StoreInt32(&r.usageAccounting, flag)
+}
+
+// GetServiceUsage returns the usage counters of every service
+func (r *serviceRegistry) GetServiceUsage() map[string]ServiceUsage {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	usage := make(map[string]ServiceUsage)
+	for chanName, sw := range r.services {
+		if !internalServices[chanName] {
+			usage[chanName] = sw.fabricCore.usage.snapshot()
+		}
+	}
+	return usage
+}
+
 func (r *serviceRegistry) RegisterService(service FabricService, serviceChannelName string) error {
+	return r.RegisterServiceVersion(service, serviceChannelName, 0)
+}
+
+func (r *serviceRegistry) RegisterServiceVersion(service FabricService, serviceChannelName string, version int) error {
 	r.lock.Lock()
 	defer r.lock.Unlock()
 
@@ -121,12 +197,24 @@ func (r *serviceRegistry) RegisterService(service FabricService, serviceChannelN
 		return fmt.Errorf("unable to register service: nil service")
 	}
 
-	if _, ok := r.services[serviceChannelName]; ok {
-		return fmt.Errorf("unable to register service: service channel name is already used: %s", serviceChannelName)
+	previous, replacing := r.services[serviceChannelName]
+	if replacing && version <= previous.version {
+		return &ServiceRegistrationError{
+			Channel:           serviceChannelName,
+			Version:           version,
+			RegisteredVersion: previous.version,
+		}
 	}
 
 	sw := newServiceWrapper(r.bus, service, serviceChannelName)
-	err := sw.init()
+	sw.version = version
+	sw.fabricCore.usage.accounting = &r.usageAccounting
+	var err error
+	if replacing {
+		err = sw.replace(previous)
+	} else {
+		err = sw.init()
+	}
 	if err != nil {
 		return err
 	}
@@ -138,6 +226,12 @@ func (r *serviceRegistry) RegisterService(service FabricService, serviceChannelN
 		return nil
 	}
 
+	event := &ServiceRegistrationEvent{Type: ServiceRegistered, Channel: serviceChannelName, Version: version}
+	if replacing {
+		event.Type, event.PreviousVersion = ServiceReplaced, previous.version
+	}
+	r.publishRegistrationEvent(event)
+
 	// see if the service implements ServiceLifecycleHookEnabled interface and set up REST bridges as configured
 	var hooks RESTBridgeEnabled
 	lcm := GetServiceLifecycleManager()
@@ -151,11 +245,16 @@ func (r *serviceRegistry) RegisterService(service FabricService, serviceChannelN
 		lcm = r.lifecycleManager
 	}
 
-	// hand off registering REST bridges to Plank via bus messages
+	// hand off registering REST bridges to Plank via bus messages. the bridges of a replaced service are
+	// overridden by those of its replacement
 	if hooks = lcm.GetRESTBridgeEnabledService(serviceChannelName); hooks != nil {
 		if err = bus.GetBus().SendResponseMessage(
 			LifecycleManagerChannelName,
-			&SetupRESTBridgeRequest{ServiceChannel: serviceChannelName, Config: hooks.GetRESTBridgeConfig()},
+			&SetupRESTBridgeRequest{
+				ServiceChannel: serviceChannelName,
+				Override:       replacing,
+				Config:         hooks.GetRESTBridgeConfig(),
+			},
 			bus.GetBus().GetId()); err != nil {
 			return err
 		}
@@ -173,13 +272,31 @@ func (r *serviceRegistry) UnregisterService(serviceChannelName string) error {
 	}
 	sw.unregister()
 	delete(r.services, serviceChannelName)
+	if !internalServices[serviceChannelName] {
+		r.publishRegistrationEvent(&ServiceRegistrationEvent{
+			Type:    ServiceUnregistered,
+			Channel: serviceChannelName,
+			Version: sw.version,
+		})
+	}
 	return nil
 }
 
+// publishRegistrationEvent lets dependents know the service registered on a channel changed.
+func (r *serviceRegistry) publishRegistrationEvent(event *ServiceRegistrationEvent) {
+	if err := r.bus.SendResponseMessage(ServiceRegistrationChannelName, event, nil); err != nil {
+		ranchlog.Logger().Warn("[ranch] unable to publish service registration change",
+			"channel", event.Channel, "error", err.Error())
+	}
+}
+
 type fabricServiceWrapper struct {
 	service           FabricService
 	fabricCore        *fabricCore
 	requestMsgHandler bus.MessageHandler
+	dispatch          *atomic.Pointer[fabricServiceWrapper] // wrapper handling the requests of the channel, swapped by replacements
+	version           int
+	inFlight          int64 // requests being handled right now
 }
 
 func newServiceWrapper(
@@ -190,6 +307,7 @@ func newServiceWrapper(
 		fabricCore: &fabricCore{
 			bus:         bus,
 			channelName: serviceChannelName,
+			usage:       &serviceUsage{accounting: new(int32)},
 		},
 	}
 }
@@ -197,12 +315,8 @@ func newServiceWrapper(
 func (sw *fabricServiceWrapper) init() error {
 	sw.fabricCore.bus.GetChannelManager().CreateChannel(sw.fabricCore.channelName)
 
-	initializationService, ok := sw.service.(FabricInitializableService)
-	if ok {
-		initializationErr := initializationService.Init(sw.fabricCore)
-		if initializationErr != nil {
-			return initializationErr
-		}
+	if err := sw.initService(); err != nil {
+		return err
 	}
 
 	mh, err := sw.fabricCore.bus.ListenRequestStream(sw.fabricCore.channelName)
@@ -211,29 +325,81 @@ func (sw *fabricServiceWrapper) init() error {
 	}
 
 	sw.requestMsgHandler = mh
+	sw.dispatch = &atomic.Pointer[fabricServiceWrapper]{}
+	sw.dispatch.Store(sw)
+	dispatch := sw.dispatch
 	mh.Handle(
 		func(message *model.Message) {
-			requestPtr, ok := message.Payload.(*model.Request)
-			if !ok {
-				request, ok := message.Payload.(model.Request)
-				if !ok {
-					log.Println("cannot cast service request payload to model.Request")
-					return
-				}
-				requestPtr = &request
-			}
-
-			if message.DestinationId != nil {
-				requestPtr.Id = message.DestinationId
-			}
-
-			sw.service.HandleServiceRequest(requestPtr, sw.fabricCore)
+			dispatch.Load().handleRequest(message)
 		},
 		func(e error) {})
 
 	return nil
 }
 
+// replace initializes the service, and has it take over the requests of the channel from the service of
+// previous, keeping its request handler: there is no moment no service, or both, handle the requests.
+func (sw *fabricServiceWrapper) replace(previous *fabricServiceWrapper) error {
+	if err := sw.initService(); err != nil {
+		return err
+	}
+	sw.requestMsgHandler, sw.dispatch = previous.requestMsgHandler, previous.dispatch
+	sw.dispatch.Store(sw)
+	return nil
+}
+
+func (sw *fabricServiceWrapper) initService() error {
+	if initializationService, ok := sw.service.(FabricInitializableService); ok {
+		return initializationService.Init(sw.fabricCore)
+	}
+	return nil
+}
+
+func (sw *fabricServiceWrapper) handleRequest(message *model.Message) {
+	requestPtr, ok := message.Payload.(*model.Request)
+	if !ok {
+		request, ok := message.Payload.(model.Request)
+		if !ok {
+			ranchlog.Logger().Warn("[ranch] cannot cast service request payload to model.Request",
+				"channel", sw.fabricCore.channelName)
+			return
+		}
+		requestPtr = &request
+	}
+
+	if message.DestinationId != nil {
+		requestPtr.Id = message.DestinationId
+	}
+	requestPtr.Ctx = sw.requestContext(requestPtr)
+
+	atomic.AddInt64(&sw.inFlight, 1)
+	defer atomic.AddInt64(&sw.inFlight, -1)
+	if !sw.fabricCore.usage.enabled() {
+		sw.service.HandleServiceRequest(requestPtr, sw.fabricCore)
+		return
+	}
+	start := clock.Now()
+	sw.service.HandleServiceRequest(requestPtr, sw.fabricCore)
+	sw.fabricCore.usage.recordRequest(requestPtr.Payload, clock.Since(start))
+}
+
+// requestContext derives the context of a request, with a logger carrying the service channel. Requests
+// that do not carry a request-scoped logger yet, such as those from fabric clients, get one with the
+// request id and the labels of the request.
+func (sw *fabricServiceWrapper) requestContext(request *model.Request) context.Context {
+	ctx := request.Ctx
+	if ctx == nil && request.HttpRequest != nil {
+		ctx = request.HttpRequest.Context()
+	}
+	if !ranchlog.HasLogger(ctx) && request.Id != nil {
+		ctx = ranchlog.With(ctx, ranchlog.RequestIdKey, request.Id.String())
+		if len(request.Labels) > 0 {
+			ctx = ranchlog.With(ctx, ranchlog.LabelsKey, request.Labels)
+		}
+	}
+	return ranchlog.With(ctx, ranchlog.ChannelKey, sw.fabricCore.channelName)
+}
+
 func (sw *fabricServiceWrapper) unregister() {
 	if sw.requestMsgHandler != nil {
 		sw.requestMsgHandler.Close()
@@ -4,14 +4,19 @@
 package service
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"github.com/google/uuid"
 	"github.com/pb33f/ranch/bus"
+	ranchlog "github.com/pb33f/ranch/log"
 	"github.com/pb33f/ranch/model"
 	"github.com/stretchr/testify/assert"
+	"log/slog"
 	"net/http"
 	"sync"
 	"testing"
+	"time"
 )
 
 func newTestServiceRegistry() *serviceRegistry {
@@ -36,9 +41,11 @@ func (fs *mockFabricService) HandleServiceRequest(request *model.Request, core F
 }
 
 type mockLifecycleHookEnabledService struct {
-	initChan chan bool
-	core     FabricServiceCore
-	shutdown bool
+	initChan  chan bool
+	core      FabricServiceCore
+	shutdown  bool
+	warmedUp  bool
+	unhealthy error
 }
 
 func (s *mockLifecycleHookEnabledService) HandleServiceRequest(request *model.Request, core FabricServiceCore) {
@@ -54,6 +61,15 @@ func (s *mockLifecycleHookEnabledService) OnServerShutdown() {
 	s.shutdown = true
 }
 
+func (s *mockLifecycleHookEnabledService) OnWarmup(ctx context.Context) error {
+	s.warmedUp = true
+	return nil
+}
+
+func (s *mockLifecycleHookEnabledService) HealthCheck(ctx context.Context) error {
+	return s.unhealthy
+}
+
 func (s *mockLifecycleHookEnabledService) GetRESTBridgeConfig() []*RESTBridgeConfig {
 	return []*RESTBridgeConfig{
 		{
@@ -113,7 +129,10 @@ func TestServiceRegistry_RegisterService(t *testing.T) {
 	mockService.wg.Wait()
 
 	assert.Equal(t, len(mockService.processedRequests), 1)
-	assert.Equal(t, *mockService.processedRequests[0], req)
+	processed := *mockService.processedRequests[0]
+	assert.NotNil(t, processed.Ctx)
+	processed.Ctx = nil
+	assert.Equal(t, processed, req)
 	assert.NotNil(t, mockService.core)
 
 	registry.bus.SendRequestMessage("test-channel", "invalid-request", nil)
@@ -162,6 +181,116 @@ func TestServiceRegistry_RegisterInitializableService(t *testing.T) {
 		"init-error")
 }
 
+type blockingFabricService struct {
+	started chan bool
+	release chan bool
+}
+
+func (fs *blockingFabricService) HandleServiceRequest(request *model.Request, core FabricServiceCore) {
+	fs.started <- true
+	<-fs.release
+}
+
+func TestServiceRegistry_GetInFlightRequests(t *testing.T) {
+	registry := newTestServiceRegistry()
+	registry.lifecycleManager = newTestServiceLifecycleManager(registry).(*serviceLifecycleManager)
+	svc := &blockingFabricService{started: make(chan bool, 2), release: make(chan bool)}
+	assert.Nil(t, registry.RegisterService(svc, "slow-channel"))
+	assert.Equal(t, map[string]int64{"slow-channel": 0}, registry.GetInFlightRequests())
+
+	registry.bus.SendRequestMessage("slow-channel", &model.Request{RequestCommand: "one"}, nil)
+	registry.bus.SendRequestMessage("slow-channel", &model.Request{RequestCommand: "two"}, nil)
+	<-svc.started
+	<-svc.started
+	assert.Equal(t, map[string]int64{"slow-channel": 2}, registry.GetInFlightRequests())
+
+	close(svc.release)
+	assert.Eventually(t, func() bool {
+		return registry.GetInFlightRequests()["slow-channel"] == 0
+	}, time.Second, time.Millisecond)
+}
+
+type echoFabricService struct{}
+
+func (fs *echoFabricService) HandleServiceRequest(request *model.Request, core FabricServiceCore) {
+	if request.RequestCommand == "fail" {
+		core.SendErrorResponse(request, 500, "failed")
+		return
+	}
+	core.SendResponse(request, request.Payload)
+}
+
+func TestServiceRegistry_GetServiceUsage(t *testing.T) {
+	registry := newTestServiceRegistry()
+	registry.lifecycleManager = newTestServiceLifecycleManager(registry).(*serviceLifecycleManager)
+	assert.Nil(t, registry.RegisterService(&echoFabricService{}, "echo-channel"))
+	assert.Equal(t, map[string]ServiceUsage{"echo-channel": {}}, registry.GetServiceUsage())
+
+	responses, _ := registry.bus.ListenStream("echo-channel")
+	received := make(chan bool, 3)
+	responses.Handle(func(message *model.Message) {
+		received <- true
+	}, func(err error) {})
+
+	// nothing is counted until usage accounting is enabled.
+	registry.bus.SendRequestMessage("echo-channel", &model.Request{RequestCommand: "echo", Payload: "moo"}, nil)
+	<-received
+	assert.Equal(t, map[string]ServiceUsage{"echo-channel": {}}, registry.GetServiceUsage())
+
+	registry.EnableUsageAccounting(true)
+	registry.bus.SendRequestMessage("echo-channel", &model.Request{RequestCommand: "echo", Payload: "moo"}, nil)
+	registry.bus.SendRequestMessage("echo-channel",
+		&model.Request{RequestCommand: "echo", Payload: map[string]int{"cows": 12}}, nil)
+	registry.bus.SendRequestMessage("echo-channel", &model.Request{RequestCommand: "fail"}, nil)
+	for i := 0; i < 3; i++ {
+		<-received
+	}
+
+	assert.Eventually(t, func() bool {
+		return registry.GetServiceUsage()["echo-channel"].Requests == 3
+	}, time.Second, time.Millisecond)
+	usage := registry.GetServiceUsage()["echo-channel"]
+	assert.EqualValues(t, 3, usage.Responses)
+	assert.EqualValues(t, 1, usage.ErrorResponses)
+	assert.EqualValues(t, len("moo")+len(`{"cows":12}`), usage.RequestBytes)
+	assert.EqualValues(t, len("moo")+len(`{"cows":12}`), usage.ResponseBytes)
+	assert.Greater(t, usage.HandlerWallTime, time.Duration(0))
+}
+
+func TestServiceRegistry_RequestLogger(t *testing.T) {
+	registry := newTestServiceRegistry()
+	registry.lifecycleManager = newTestServiceLifecycleManager(registry).(*serviceLifecycleManager)
+	svc := &mockFabricService{}
+	assert.Nil(t, registry.RegisterService(svc, "logging-channel"))
+
+	var defaultOut, requestOut bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&defaultOut, nil)))
+	defer slog.SetDefault(defaultLogger)
+
+	// requests without a logger get one carrying their id and labels.
+	id := uuid.New()
+	svc.wg.Add(1)
+	registry.bus.SendRequestMessage("logging-channel", &model.Request{Id: &id, RequestCommand: "one",
+		Labels: map[string]string{"client": "bot"}}, nil)
+	svc.wg.Wait()
+	svc.processedRequests[0].Logger().Info("moo")
+	assert.Contains(t, defaultOut.String(), "request_id="+id.String())
+	assert.Contains(t, defaultOut.String(), "labels=map[client:bot]")
+	assert.Contains(t, defaultOut.String(), "channel=logging-channel")
+
+	// requests that carry one keep its attributes.
+	logger := slog.New(slog.NewTextHandler(&requestOut, nil)).With(ranchlog.RequestIdKey, "abc-123")
+	svc.wg.Add(1)
+	registry.bus.SendRequestMessage("logging-channel", &model.Request{Id: &id, RequestCommand: "two",
+		Ctx: ranchlog.NewContext(nil, logger)}, nil)
+	svc.wg.Wait()
+	svc.processedRequests[1].Logger().Info("moo")
+	assert.Contains(t, requestOut.String(), "request_id=abc-123")
+	assert.Contains(t, requestOut.String(), "channel=logging-channel")
+	assert.NotContains(t, requestOut.String(), id.String())
+}
+
 func TestServiceRegistry_UnregisterService(t *testing.T) {
 	registry := newTestServiceRegistry()
 	mockService := &mockFabricService{}
@@ -215,3 +344,63 @@ func TestServiceRegistry_RegisterService_LifecycleHookEnabled(t *testing.T) {
 	restBridgeConfig := svc.GetRESTBridgeConfig()
 	assert.NotNil(t, restBridgeConfig)
 }
+
+func TestServiceRegistry_RegisterServiceVersion(t *testing.T) {
+	registry := newTestServiceRegistry()
+	events := make(chan *ServiceRegistrationEvent, 10)
+	mh, _ := registry.bus.ListenStream(ServiceRegistrationChannelName)
+	mh.Handle(func(message *model.Message) {
+		events <- message.Payload.(*ServiceRegistrationEvent)
+	}, func(err error) {})
+
+	first := &mockFabricService{}
+	assert.Nil(t, registry.RegisterServiceVersion(first, "test-channel", 1))
+	assert.Equal(t, &ServiceRegistrationEvent{Type: ServiceRegistered, Channel: "test-channel", Version: 1}, <-events)
+	version, err := registry.GetServiceVersion("test-channel")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, version)
+
+	// registering without bumping the version is refused
+	var registrationErr *ServiceRegistrationError
+	err = registry.RegisterServiceVersion(&mockFabricService{}, "test-channel", 1)
+	assert.ErrorAs(t, err, &registrationErr)
+	assert.Equal(t, &ServiceRegistrationError{Channel: "test-channel", Version: 1, RegisteredVersion: 1}, registrationErr)
+	assert.EqualError(t, err, "unable to register service: service channel name is already used: test-channel "+
+		"(version 1 is registered, version 1 is not above it)")
+	assert.ErrorAs(t, registry.RegisterService(&mockFabricService{}, "test-channel"), &registrationErr)
+
+	// a replacement failing to initialize leaves the registered service in place
+	assert.EqualError(t,
+		registry.RegisterServiceVersion(&mockInitializableService{initError: errors.New("init-error")}, "test-channel", 2),
+		"init-error")
+
+	first.wg.Add(1)
+	registry.bus.SendRequestMessage("test-channel", &model.Request{RequestCommand: "one"}, nil)
+	first.wg.Wait()
+
+	// a higher version takes over the requests of the channel
+	second := &mockInitializableService{}
+	assert.Nil(t, registry.RegisterServiceVersion(second, "test-channel", 2))
+	assert.True(t, second.initialized)
+	assert.Equal(t, &ServiceRegistrationEvent{
+		Type: ServiceReplaced, Channel: "test-channel", Version: 2, PreviousVersion: 1}, <-events)
+	svc, _ := registry.GetService("test-channel")
+	assert.Same(t, second, svc)
+	version, _ = registry.GetServiceVersion("test-channel")
+	assert.Equal(t, 2, version)
+
+	third := &mockFabricService{}
+	assert.Nil(t, registry.RegisterServiceVersion(third, "test-channel", 3))
+	<-events
+	third.wg.Add(1)
+	registry.bus.SendRequestMessage("test-channel", &model.Request{RequestCommand: "two"}, nil)
+	third.wg.Wait()
+	assert.Len(t, first.processedRequests, 1)
+	assert.Len(t, third.processedRequests, 1)
+	assert.Equal(t, "two", third.processedRequests[0].RequestCommand)
+
+	assert.Nil(t, registry.UnregisterService("test-channel"))
+	assert.Equal(t, &ServiceRegistrationEvent{Type: ServiceUnregistered, Channel: "test-channel", Version: 3}, <-events)
+	_, err = registry.GetServiceVersion("test-channel")
+	assert.Error(t, err)
+}
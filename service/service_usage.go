@@ -0,0 +1,93 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package service
+
+import (
+	"encoding/json"
+	"sync/atomic"
+	"time"
+)
+
+// ServiceUsage is what a service consumed while usage accounting was enabled. Byte counts are the size of
+// request and response payloads, structured payloads are counted as their JSON encoding.
+type ServiceUsage struct {
+	Requests        int64         `json:"requests"`
+	Responses       int64         `json:"responses"`
+	ErrorResponses  int64         `json:"errorResponses"`
+	RequestBytes    int64         `json:"requestBytes"`
+	ResponseBytes   int64         `json:"responseBytes"`
+	HandlerWallTime time.Duration `json:"handlerWallTime"` // wall-clock time spent in HandleServiceRequest, including waits
+}
+
+// serviceUsage holds the usage counters of a service, updated atomically. Nothing is recorded unless the
+// accounting flag shared by the services of a registry is set, measuring payloads is not free.
+type serviceUsage struct {
+	accounting     *int32
+	requests       int64
+	responses      int64
+	errorResponses int64
+	requestBytes   int64
+	responseBytes  int64
+	handlerNanos   int64
+}
+
+func (u *serviceUsage) enabled() bool {
+	return u != nil && atomic.LoadInt32(u.accounting) == 1
+}
+
+func (u *serviceUsage) recordRequest(payload interface{}, handlerWallTime time.Duration) {
+	atomic.AddInt64(&u.requests, 1)
+	atomic.AddInt64(&u.requestBytes, payloadSize(payload))
+	atomic.AddInt64(&u.handlerNanos, int64(handlerWallTime))
+}
+
+func (u *serviceUsage) recordResponse(payload interface{}, isError bool) {
+	if !u.enabled() {
+		return
+	}
+	atomic.AddInt64(&u.responses, 1)
+	if isError {
+		atomic.AddInt64(&u.errorResponses, 1)
+	}
+	atomic.AddInt64(&u.responseBytes, payloadSize(payload))
+}
+
+func (u *serviceUsage) snapshot() ServiceUsage {
+	return ServiceUsage{
+		Requests:        atomic.LoadInt64(&u.requests),
+		Responses:       atomic.LoadInt64(&u.responses),
+		ErrorResponses:  atomic.LoadInt64(&u.errorResponses),
+		RequestBytes:    atomic.LoadInt64(&u.requestBytes),
+		ResponseBytes:   atomic.LoadInt64(&u.responseBytes),
+		HandlerWallTime: time.Duration(atomic.LoadInt64(&u.handlerNanos)),
+	}
+}
+
+// byteCounter counts the bytes written to it and throws them away.
+type byteCounter int64
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
+
+// payloadSize returns the size of a payload, structured payloads are measured by encoding them as JSON
+// without keeping the encoding around.
+func payloadSize(payload interface{}) int64 {
+	switch p := payload.(type) {
+	case nil:
+		return 0
+	case []byte:
+		return int64(len(p))
+	case json.RawMessage:
+		return int64(len(p))
+	case string:
+		return int64(len(p))
+	}
+	var size byteCounter
+	if err := json.NewEncoder(&size).Encode(payload); err != nil || size == 0 {
+		return 0
+	}
+	return int64(size) - 1 // the encoder terminates every value with a newline
+}
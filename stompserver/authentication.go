@@ -0,0 +1,108 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/pb33f/ranch/log"
+)
+
+// Credentials are what a client presented in its CONNECT frame.
+type Credentials struct {
+	Login    string        // the login header
+	Passcode string        // the passcode header
+	Token    string        // the bearer token of the Authorization header
+	Header   *frame.Header // every header of the frame, for authenticators looking for something else
+}
+
+// Authenticator authenticates clients when they connect.
+type Authenticator interface {
+	// Authenticate returns the principal of the client presenting the credentials, or an error if they are
+	// not valid. The error is logged, the client is only told that authentication failed.
+	Authenticate(credentials *Credentials) (string, error)
+}
+
+// AuthenticatorFunc is an Authenticator calling a function.
+type AuthenticatorFunc func(credentials *Credentials) (string, error)
+
+// Authenticate calls the function.
+func (fn AuthenticatorFunc) Authenticate(credentials *Credentials) (string, error) {
+	return fn(credentials)
+}
+
+// NewTokenAuthenticator returns an Authenticator accepting a fixed set of tokens, mapped to their principals.
+// The token is taken from the Authorization header, or the passcode header if there is none.
+func NewTokenAuthenticator(tokens map[string]string) Authenticator {
+	return AuthenticatorFunc(func(credentials *Credentials) (string, error) {
+		if principal, ok := tokens[credentials.bearerOrPasscode()]; ok {
+			return principal, nil
+		}
+		return "", errors.New("unknown token")
+	})
+}
+
+// NewJwtAuthenticator returns an Authenticator accepting JSON Web Tokens signed with the key returned by
+// keyFunc, taking the subject claim as the principal. The token is taken from the Authorization header, or
+// the passcode header if there is none. Restrict the accepted signing methods, issuer and audience with
+// the parser options, e.g. jwt.WithValidMethods.
+func NewJwtAuthenticator(keyFunc jwt.Keyfunc, options ...jwt.ParserOption) Authenticator {
+	parser := jwt.NewParser(options...)
+	return AuthenticatorFunc(func(credentials *Credentials) (string, error) {
+		token, err := parser.Parse(credentials.bearerOrPasscode(), keyFunc)
+		if err != nil {
+			return "", err
+		}
+		subject, err := token.Claims.GetSubject()
+		if err != nil {
+			return "", err
+		}
+		if subject == "" {
+			return "", errors.New("token has no subject")
+		}
+		return subject, nil
+	})
+}
+
+func (c *Credentials) bearerOrPasscode() string {
+	if c.Token != "" {
+		return c.Token
+	}
+	return c.Passcode
+}
+
+// credentialsFromFrame returns the credentials presented in a CONNECT frame.
+func credentialsFromFrame(f *frame.Frame) *Credentials {
+	credentials := &Credentials{
+		Login:    f.Header.Get(frame.Login),
+		Passcode: f.Header.Get(frame.Passcode),
+		Header:   f.Header,
+	}
+	if auth, ok := f.Header.Contains("Authorization"); ok {
+		credentials.Token = strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	return credentials
+}
+
+// AuthenticationMiddleware returns a CONNECT MiddlewareFunc authenticating clients with the authenticator.
+// The connection takes the principal it returns, clients failing to authenticate are rejected.
+func AuthenticationMiddleware(authenticator Authenticator) MiddlewareFunc {
+	return func(next FrameHandlerFunc) FrameHandlerFunc {
+		return func(conn StompConn, f *frame.Frame) error {
+			principal, err := authenticator.Authenticate(credentialsFromFrame(f))
+			if err != nil {
+				log.Logger().Warn("[ranch] STOMP authentication failed", "connection", conn.GetId(), "error", err.Error())
+				return authenticationFailedError
+			}
+			if c, ok := conn.(*stompConn); ok {
+				c.principal.Store(&principal)
+			}
+			return next(conn, f)
+		}
+	}
+}
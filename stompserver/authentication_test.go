@@ -0,0 +1,83 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func getAuthenticatedTestStompConn(authenticator Authenticator) (*stompConn, *MockRawConnection, chan *ConnEvent) {
+	config := NewStompConfig(0, []string{})
+	config.SetMiddlewareRegistry(MiddlewareRegistry{frame.CONNECT: {AuthenticationMiddleware(authenticator)}})
+	return getTestStompConn(config, nil)
+}
+
+func TestAuthenticationMiddleware(t *testing.T) {
+	stompConn, rawConn, events := getAuthenticatedTestStompConn(NewTokenAuthenticator(map[string]string{"secret": "backend"}))
+	rawConn.incomingFrames <- frame.New(frame.CONNECT, frame.AcceptVersion, "1.2", frame.Passcode, "secret")
+	assert.Equal(t, ConnectionEstablished, (<-events).eventType)
+	assert.Equal(t, "backend", stompConn.GetPrincipal())
+}
+
+func TestAuthenticationMiddleware_Rejected(t *testing.T) {
+	stompConn, rawConn, events := getAuthenticatedTestStompConn(NewTokenAuthenticator(map[string]string{"secret": "backend"}))
+	rawConn.incomingFrames <- frame.New(frame.CONNECT, frame.AcceptVersion, "1.2", frame.Passcode, "guess")
+	assert.Equal(t, ConnectionClosed, (<-events).eventType)
+	verifyFrame(t, rawConn.LastSentFrame(), frame.New(frame.ERROR,
+		frame.Message, authenticationFailedError.Error()), true)
+	assert.Equal(t, "", stompConn.GetPrincipal())
+}
+
+func TestAuthenticatorFunc(t *testing.T) {
+	authenticator := AuthenticatorFunc(func(credentials *Credentials) (string, error) {
+		if credentials.Login == "alice" && credentials.Passcode == "wonderland" {
+			return credentials.Login, nil
+		}
+		return "", errors.New("invalid login")
+	})
+	principal, err := authenticator.Authenticate(credentialsFromFrame(frame.New(frame.CONNECT,
+		frame.Login, "alice", frame.Passcode, "wonderland")))
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", principal)
+	_, err = authenticator.Authenticate(credentialsFromFrame(frame.New(frame.CONNECT, frame.Login, "alice")))
+	assert.Error(t, err)
+}
+
+func TestJwtAuthenticator(t *testing.T) {
+	key := []byte("signing-key")
+	sign := func(claims jwt.MapClaims) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(key)
+		assert.NoError(t, err)
+		return token
+	}
+	authenticator := NewJwtAuthenticator(func(token *jwt.Token) (interface{}, error) {
+		return key, nil
+	}, jwt.WithValidMethods([]string{"HS256"}))
+	authenticate := func(token string) (string, error) {
+		return authenticator.Authenticate(credentialsFromFrame(frame.New(frame.CONNECT, "Authorization", "Bearer "+token)))
+	}
+
+	principal, err := authenticate(sign(jwt.MapClaims{"sub": "alice", "exp": time.Now().Add(time.Hour).Unix()}))
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", principal)
+
+	_, err = authenticate(sign(jwt.MapClaims{"sub": "alice", "exp": time.Now().Add(-time.Hour).Unix()}))
+	assert.ErrorIs(t, err, jwt.ErrTokenExpired)
+	_, err = authenticate(sign(jwt.MapClaims{"name": "alice"}))
+	assert.EqualError(t, err, "token has no subject")
+	_, err = authenticate("not-a-token")
+	assert.Error(t, err)
+
+	// tokens may also be presented as the passcode, by clients unable to set headers.
+	token := sign(jwt.MapClaims{"sub": "bob"})
+	principal, err = authenticator.Authenticate(credentialsFromFrame(frame.New(frame.CONNECT, frame.Passcode, token)))
+	assert.NoError(t, err)
+	assert.Equal(t, "bob", principal)
+}
@@ -0,0 +1,66 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+// BackplaneMessage is a message broadcast by the broker of one instance, carried to the others.
+type BackplaneMessage struct {
+	Node        string `json:"node"`        // instance the message was broadcast on
+	Destination string `json:"destination"` // destination the message was broadcast to
+	Body        []byte `json:"body"`
+}
+
+// Backplane carries the messages a broker broadcasts to the brokers of the other instances of a deployment,
+// such as a Redis or NATS server they all connect to. With a backplane, instances scale out behind a load
+// balancer without sticky sessions: a client receives every message broadcast to a destination, whichever
+// instance it is connected to. Messages sent to a single client or to a queue stay on their instance.
+type Backplane interface {
+	// Publish sends a message broadcast by this broker to the other instances
+	Publish(msg *BackplaneMessage) error
+	// Subscribe hands the messages published by every instance, this one included, to deliver. nil stops
+	// handing them
+	Subscribe(deliver func(msg *BackplaneMessage))
+}
+
+func (s *stompServer) SetBackplane(backplane Backplane) Backplane {
+	var previous *Backplane
+	if backplane == nil {
+		previous = s.backplane.Swap(nil)
+	} else {
+		previous = s.backplane.Swap(&backplane)
+	}
+	if previous != nil {
+		(*previous).Subscribe(nil)
+	}
+	if backplane != nil {
+		backplane.Subscribe(s.receiveBackplaneMessage)
+	}
+	if previous == nil {
+		return nil
+	}
+	return *previous
+}
+
+// publishBackplaneMessage carries a message broadcast to a topic to the other instances, if there is a
+// backplane.
+func (s *stompServer) publishBackplaneMessage(destination string, body []byte) {
+	backplane := s.backplane.Load()
+	if backplane == nil || s.config.IsQueueDestination(destination) || s.config.IsTempQueueDestination(destination) {
+		return
+	}
+	// the backplane reconnects on its own, messages broadcast in the meantime only reach this instance
+	_ = (*backplane).Publish(&BackplaneMessage{Node: s.node, Destination: destination, Body: body})
+}
+
+// receiveBackplaneMessage delivers a message broadcast on another instance to the subscribers of its
+// destination connected to this one.
+func (s *stompServer) receiveBackplaneMessage(msg *BackplaneMessage) {
+	if msg == nil || msg.Node == s.node {
+		return
+	}
+	s.apiEvents <- &apiEvent{
+		eventType:   sendMessage,
+		destination: msg.Destination,
+		frame:       newMessageFrame(msg.Destination, msg.Body),
+	}
+}
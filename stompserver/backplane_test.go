@@ -0,0 +1,82 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/stretchr/testify/assert"
+)
+
+type testBackplane struct {
+	lock      sync.Mutex
+	published []*BackplaneMessage
+	deliver   func(msg *BackplaneMessage)
+}
+
+func (b *testBackplane) Publish(msg *BackplaneMessage) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.published = append(b.published, msg)
+	return nil
+}
+
+func (b *testBackplane) Subscribe(deliver func(msg *BackplaneMessage)) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.deliver = deliver
+}
+
+func (b *testBackplane) messages() []*BackplaneMessage {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return append([]*BackplaneMessage(nil), b.published...)
+}
+
+func TestStompServer_Backplane(t *testing.T) {
+	config := NewStompConfig(0, []string{"/pub/"})
+	config.SetQueuePrefix("/queue")
+	server, listener := newTestStompServer(config)
+	subscribed := make(chan string, 10)
+	server.OnSubscribeEvent(func(conId string, subId string, destination string, f *frame.Frame) {
+		subscribed <- conId
+	})
+	backplane := &testBackplane{}
+	assert.Nil(t, server.SetBackplane(backplane))
+	assert.NotNil(t, backplane.deliver)
+	go server.Start()
+	defer server.Stop()
+
+	conn := NewMockRawConnection()
+	listener.incomingConnections <- conn
+	conn.SendConnectFrame()
+	conn.incomingFrames <- frame.New(frame.SUBSCRIBE, frame.Destination, "/topic/orders", frame.Id, "s1")
+	id := receive(t, subscribed)
+
+	// messages broadcast to topics are published, those for a single client or a queue are not
+	server.SendMessage("/topic/orders", []byte("mine"))
+	server.SendMessageToClient(id, "/topic/orders", []byte("yours"))
+	server.SendMessage("/queue/jobs", []byte("job"))
+	assert.Equal(t, []string{"mine", "yours"}, messages(t, conn, 2))
+	published := backplane.messages()
+	assert.Len(t, published, 1)
+	assert.Equal(t, "/topic/orders", published[0].Destination)
+	assert.Equal(t, []byte("mine"), published[0].Body)
+	node := published[0].Node
+	assert.NotEmpty(t, node)
+
+	// messages of the other instances are delivered without being published again, this one's are dropped
+	backplane.deliver(&BackplaneMessage{Node: node, Destination: "/topic/orders", Body: []byte("echo")})
+	backplane.deliver(&BackplaneMessage{Node: "other", Destination: "/topic/orders", Body: []byte("theirs")})
+	assert.Equal(t, []string{"mine", "yours", "theirs"}, messages(t, conn, 3))
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, []string{"mine", "yours", "theirs"}, messages(t, conn, 3))
+	assert.Len(t, backplane.messages(), 1)
+
+	assert.Same(t, backplane, server.SetBackplane(nil))
+	assert.Nil(t, backplane.deliver)
+}
@@ -0,0 +1,139 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// BackpressureAdvisoryDestination is the destination clients subscribe to for backpressure advisories. Once
+// messages queue up for one of their subscriptions, the server sends a MESSAGE to it so a well-behaved
+// client can slow down, and another one once the queue drained.
+const BackpressureAdvisoryDestination = "/ranch/backpressure"
+
+// Headers of a backpressure advisory, the body carries the same values as a JSON object.
+const (
+	BackpressureHeader             = "backpressure"              // "on" if the client should slow down, "off" once it may resume
+	BackpressureDestinationHeader  = "backpressure-destination"  // the destination of the subscription messages queue up for
+	BackpressureSubscriptionHeader = "backpressure-subscription" // the id of that subscription
+	BackpressureQueuedHeader       = "backpressure-queued"       // the number of messages queued for it
+)
+
+// defaultOutFrames is how many frames are buffered for a connection before the server waits for it.
+const defaultOutFrames = 32
+
+// BackpressureConfig sets when clients are told that messages queue up for one of their subscriptions.
+type BackpressureConfig struct {
+	// HighWaterMark is the number of messages queued for a subscription at which its client is told to slow
+	// down. Backpressure is not signalled if 0.
+	HighWaterMark int
+	// LowWaterMark is the number of queued messages at which the client is told it may resume, half the
+	// high water mark if 0.
+	LowWaterMark int
+}
+
+func (c BackpressureConfig) enabled() bool {
+	return c.HighWaterMark > 0
+}
+
+func (c BackpressureConfig) lowWaterMark() int {
+	if c.LowWaterMark > 0 && c.LowWaterMark < c.HighWaterMark {
+		return c.LowWaterMark
+	}
+	return c.HighWaterMark / 2
+}
+
+// outFramesSize returns the size of the outgoing frame buffer of a connection, large enough for the high
+// water mark to be reached before the server has to wait for the connection.
+func outFramesSize(c BackpressureConfig) int {
+	if 2*c.HighWaterMark > defaultOutFrames {
+		return 2 * c.HighWaterMark
+	}
+	return defaultOutFrames
+}
+
+// BackpressureAdvisory is the body of a backpressure advisory.
+type BackpressureAdvisory struct {
+	Backpressure bool   `json:"backpressure"`
+	Destination  string `json:"destination"`
+	Subscription string `json:"subscription"`
+	Queued       int    `json:"queued"`
+}
+
+// queuedFrame is a message waiting to be written to a subscription.
+type queuedFrame struct {
+	frame *frame.Frame
+	sub   *Subscription
+}
+
+// checkBackpressure tells the client and the server once the messages queued for the subscription cross one
+// of the water marks. Only called by the run goroutine, before a message of the subscription is written.
+func (conn *stompConn) checkBackpressure(sub *Subscription) error {
+	cfg := conn.config.GetBackpressure()
+	if !cfg.enabled() || sub == nil || conn.subscriptions[sub.id] != sub {
+		return nil
+	}
+	queued := int(atomic.LoadInt32(&sub.queued))
+	switch {
+	case !sub.throttled && queued >= cfg.HighWaterMark:
+		sub.throttled = true
+		return conn.signalBackpressure(sub, queued, BackpressureApplied)
+	case sub.throttled && queued <= cfg.lowWaterMark():
+		sub.throttled = false
+		return conn.signalBackpressure(sub, queued, BackpressureReleased)
+	}
+	return nil
+}
+
+// signalBackpressure sends an advisory to every advisory subscription of the client, ahead of the messages
+// still queued, and notifies the server.
+func (conn *stompConn) signalBackpressure(sub *Subscription, queued int, eventType StompSessionEventType) error {
+	advisory := BackpressureAdvisory{
+		Backpressure: eventType == BackpressureApplied,
+		Destination:  sub.destination,
+		Subscription: sub.id,
+		Queued:       queued,
+	}
+	state := "off"
+	if advisory.Backpressure {
+		state = "on"
+	}
+	body, _ := json.Marshal(advisory)
+
+	for _, advisorySub := range conn.subscriptions {
+		if advisorySub.destination != BackpressureAdvisoryDestination {
+			continue
+		}
+		f := frame.New(frame.MESSAGE,
+			frame.Destination, BackpressureAdvisoryDestination,
+			frame.Subscription, advisorySub.id,
+			frame.ContentType, "application/json;charset=UTF-8",
+			frame.ContentLength, strconv.Itoa(len(body)),
+			BackpressureHeader, state,
+			BackpressureDestinationHeader, sub.destination,
+			BackpressureSubscriptionHeader, sub.id,
+			BackpressureQueuedHeader, strconv.Itoa(queued))
+		f.Body = body
+		if err := conn.populateMessageIdHeader(f); err != nil {
+			return err
+		}
+		if err := conn.writeFrame(f); err != nil {
+			return err
+		}
+	}
+
+	conn.events <- &ConnEvent{
+		ConnId:      conn.GetId(),
+		eventType:   eventType,
+		conn:        conn,
+		sub:         sub,
+		destination: sub.destination,
+		queued:      queued,
+	}
+	return nil
+}
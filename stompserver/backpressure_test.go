@@ -0,0 +1,87 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStompConn_Backpressure(t *testing.T) {
+	config := NewStompConfig(0, []string{})
+	config.SetBackpressure(BackpressureConfig{HighWaterMark: 4, LowWaterMark: 2})
+	stompConn, rawConn, events := getTestStompConn(config, nil)
+	assert.Equal(t, 32, cap(stompConn.outFrames))
+
+	rawConn.SendConnectFrame()
+	assert.Equal(t, ConnectionEstablished, (<-events).eventType)
+	for id, destination := range map[string]string{"sub-id": "/topic/test", "advisories": BackpressureAdvisoryDestination} {
+		rawConn.incomingFrames <- frame.New(frame.SUBSCRIBE, frame.Id, id, frame.Destination, destination)
+		assert.Equal(t, SubscribeToTopic, (<-events).eventType)
+	}
+
+	// the client stops reading while six messages are queued up.
+	rawConn.lock.Lock()
+	rawConn.writeWg = &sync.WaitGroup{}
+	rawConn.writeWg.Add(8)
+	sub := stompConn.subscriptions["sub-id"]
+	for i := 0; i < 6; i++ {
+		stompConn.SendFrameToSubscription(frame.New(frame.MESSAGE, frame.Destination, "/topic/test"), sub)
+	}
+	rawConn.lock.Unlock()
+	rawConn.writeWg.Wait()
+
+	e := <-events
+	assert.Equal(t, BackpressureApplied, e.eventType)
+	assert.Equal(t, "/topic/test", e.Destination())
+	assert.GreaterOrEqual(t, e.Queued(), 4)
+	e = <-events
+	assert.Equal(t, BackpressureReleased, e.eventType)
+	assert.Equal(t, 2, e.Queued())
+
+	var advisories []*frame.Frame
+	messages := 0
+	for _, f := range rawConn.sentFrames[1:] {
+		switch f.Header.Get(frame.Subscription) {
+		case "advisories":
+			advisories = append(advisories, f)
+		case "sub-id":
+			messages++
+		}
+	}
+	assert.Equal(t, 6, messages)
+	if assert.Len(t, advisories, 2) {
+		assert.Equal(t, "on", advisories[0].Header.Get(BackpressureHeader))
+		assert.Equal(t, "/topic/test", advisories[0].Header.Get(BackpressureDestinationHeader))
+		assert.Equal(t, "sub-id", advisories[0].Header.Get(BackpressureSubscriptionHeader))
+		assert.Equal(t, "off", advisories[1].Header.Get(BackpressureHeader))
+		assert.Equal(t, "2", advisories[1].Header.Get(BackpressureQueuedHeader))
+
+		var advisory BackpressureAdvisory
+		assert.NoError(t, json.Unmarshal(advisories[1].Body, &advisory))
+		assert.Equal(t, BackpressureAdvisory{Destination: "/topic/test", Subscription: "sub-id", Queued: 2}, advisory)
+	}
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&sub.queued) == 0 }, time.Second, time.Millisecond)
+}
+
+func TestStompConn_BackpressureDisabled(t *testing.T) {
+	stompConn, rawConn, _ := subscribeWithAck(t, "1.2", AckAuto)
+	sendMessages(stompConn, rawConn, 40)
+	sub := stompConn.subscriptions["sub-id"]
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&sub.queued) == 0 }, time.Second, time.Millisecond)
+	assert.False(t, sub.throttled)
+}
+
+func TestOutFramesSize(t *testing.T) {
+	assert.Equal(t, 32, outFramesSize(BackpressureConfig{}))
+	assert.Equal(t, 200, outFramesSize(BackpressureConfig{HighWaterMark: 100}))
+	assert.Equal(t, 50, BackpressureConfig{HighWaterMark: 100}.lowWaterMark())
+	assert.Equal(t, 50, BackpressureConfig{HighWaterMark: 100, LowWaterMark: 150}.lowWaterMark())
+}
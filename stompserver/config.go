@@ -13,12 +13,39 @@ type StompConfig interface {
     IsAppRequestDestination(destination string) bool
     GetMiddlewareRegistry() MiddlewareRegistry
     SetMiddlewareRegistry(registry MiddlewareRegistry)
+    GetBackpressure() BackpressureConfig
+    SetBackpressure(backpressure BackpressureConfig)
+    GetRateLimits() RateLimitConfig
+    SetRateLimits(limits RateLimitConfig)
+    MaxMissedHeartBeats() int
+    SetMaxMissedHeartBeats(missed int)
+    GetDurableSubscriptions() DurableSubscriptionConfig
+    SetDurableSubscriptions(durable DurableSubscriptionConfig)
+    GetQueuePrefix() string
+    SetQueuePrefix(prefix string)
+    IsQueueDestination(destination string) bool
+    GetTempQueuePrefix() string
+    SetTempQueuePrefix(prefix string)
+    IsTempQueueDestination(destination string) bool
+    GetEncryption() EncryptionConfig
+    SetEncryption(encryption EncryptionConfig)
 }
 
+// DefaultMaxMissedHeartBeats is how many heart-beat intervals a client may send nothing for before it
+// is disconnected.
+const DefaultMaxMissedHeartBeats = 2
+
 type stompConfig struct {
     heartbeat          int64
     appDestPrefix      []string
     middlewareRegistry MiddlewareRegistry
+    backpressure       BackpressureConfig
+    rateLimits         RateLimitConfig
+    maxMissed          int
+    durable            DurableSubscriptionConfig
+    queuePrefix        string
+    tempQueuePrefix    string
+    encryption         EncryptionConfig
 }
 
 func NewStompConfig(heartBeatMs int64, appDestinationPrefix []string) StompConfig {
@@ -34,6 +61,7 @@ func NewStompConfig(heartBeatMs int64, appDestinationPrefix []string) StompConfi
     return &stompConfig{
         heartbeat:     heartBeatMs,
         appDestPrefix: prefixes,
+        maxMissed:     DefaultMaxMissedHeartBeats,
         middlewareRegistry: MiddlewareRegistry{
             // Global middleware (applied to all commands) under key "*"
             "*": []MiddlewareFunc{
@@ -63,6 +91,106 @@ func (c *stompConfig) SetMiddlewareRegistry(registry MiddlewareRegistry) {
     c.middlewareRegistry = registry
 }
 
+// GetBackpressure returns when clients are told that messages queue up for their subscriptions.
+func (c *stompConfig) GetBackpressure() BackpressureConfig {
+    return c.backpressure
+}
+
+// SetBackpressure sets the backpressure water marks, connections established afterwards use them.
+func (c *stompConfig) SetBackpressure(backpressure BackpressureConfig) {
+    c.backpressure = backpressure
+}
+
+// GetRateLimits returns how fast clients may send frames.
+func (c *stompConfig) GetRateLimits() RateLimitConfig {
+    return c.rateLimits
+}
+
+// SetRateLimits sets how fast clients may send frames, connections established afterwards use them.
+func (c *stompConfig) SetRateLimits(limits RateLimitConfig) {
+    c.rateLimits = limits
+}
+
+// GetDurableSubscriptions returns how many messages are queued for offline durable subscribers, and for how
+// long.
+func (c *stompConfig) GetDurableSubscriptions() DurableSubscriptionConfig {
+    return c.durable
+}
+
+// SetDurableSubscriptions sets how many messages are queued for offline durable subscribers, and for how
+// long. Durable subscriptions are not supported if MaxMessages is 0.
+func (c *stompConfig) SetDurableSubscriptions(durable DurableSubscriptionConfig) {
+    c.durable = durable
+}
+
+// GetQueuePrefix returns the prefix of the point-to-point queue destinations, empty if there are none.
+func (c *stompConfig) GetQueuePrefix() string {
+    return c.queuePrefix
+}
+
+// SetQueuePrefix makes the destinations starting with prefix, e.g. "/queue", point-to-point queues: each
+// message sent to a queue is delivered to one of its subscriptions, in turn, rather than to all of them.
+// Clients may send messages to queues, so they can hand work to each other. There are no queues if empty.
+func (c *stompConfig) SetQueuePrefix(prefix string) {
+    if prefix != "" && !strings.HasSuffix(prefix, "/") {
+        prefix += "/"
+    }
+    c.queuePrefix = prefix
+}
+
+// IsQueueDestination returns true if the destination is a point-to-point queue.
+func (c *stompConfig) IsQueueDestination(destination string) bool {
+    return c.queuePrefix != "" && strings.HasPrefix(destination, c.queuePrefix)
+}
+
+// GetTempQueuePrefix returns the prefix of the temporary reply destinations, empty if there are none.
+func (c *stompConfig) GetTempQueuePrefix() string {
+    return c.tempQueuePrefix
+}
+
+// SetTempQueuePrefix makes the destinations starting with prefix, e.g. "/temp-queue", temporary reply
+// destinations: each session subscribing to one has its own, which only receives the replies to the requests
+// the session sent with a ReplyToHeader naming it, and which goes away with the session. There are no
+// temporary reply destinations if empty.
+func (c *stompConfig) SetTempQueuePrefix(prefix string) {
+    if prefix != "" && !strings.HasSuffix(prefix, "/") {
+        prefix += "/"
+    }
+    c.tempQueuePrefix = prefix
+}
+
+// IsTempQueueDestination returns true if the destination is a temporary reply destination.
+func (c *stompConfig) IsTempQueueDestination(destination string) bool {
+    return c.tempQueuePrefix != "" && strings.HasPrefix(destination, c.tempQueuePrefix)
+}
+
+// GetEncryption returns which destinations carry bodies encrypted with a key of the session, and how often
+// the keys rotate.
+func (c *stompConfig) GetEncryption() EncryptionConfig {
+    return c.encryption
+}
+
+// SetEncryption sets which destinations carry bodies encrypted with a key of the session, connections
+// established afterwards use it. See EncryptionConfig for the key exchange.
+func (c *stompConfig) SetEncryption(encryption EncryptionConfig) {
+    c.encryption = encryption
+}
+
+// MaxMissedHeartBeats returns how many heart-beat intervals a client may send nothing for before it is
+// disconnected.
+func (c *stompConfig) MaxMissedHeartBeats() int {
+    return c.maxMissed
+}
+
+// SetMaxMissedHeartBeats sets how many heart-beat intervals a client may send nothing for, values below 1
+// restore the default.
+func (c *stompConfig) SetMaxMissedHeartBeats(missed int) {
+    if missed < 1 {
+        missed = DefaultMaxMissedHeartBeats
+    }
+    c.maxMissed = missed
+}
+
 func (c *stompConfig) HeartBeat() int64 {
     return c.heartbeat
 }
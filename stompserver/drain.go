@@ -0,0 +1,115 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// ShutdownNoticeDestination is the destination clients subscribe to for shutdown notices. When the server
+// drains, it sends a MESSAGE to it so a well-behaved client can finish up and disconnect, or reconnect to
+// another instance, before its connection is closed.
+const ShutdownNoticeDestination = "/ranch/shutdown"
+
+// ShutdownGraceHeader is the header of a shutdown notice carrying the milliseconds left before the server
+// closes the connection.
+const ShutdownGraceHeader = "shutdown-grace"
+
+// ShutdownNotice is the body of a shutdown notice.
+type ShutdownNotice struct {
+	GraceMillis int64 `json:"grace_ms"`
+}
+
+// Drain sends the client a shutdown notice and refuses its new subscriptions. Once grace elapsed, the
+// messages queued for the client are written and the connection is closed with an ERROR frame, unless the
+// client disconnected in the meantime.
+func (conn *stompConn) Drain(grace time.Duration) {
+	select {
+	case conn.drainRequests <- grace:
+	default:
+		// draining already
+	}
+}
+
+// sendShutdownNotice sends a shutdown notice to every notice subscription of the client. Only called by the
+// run goroutine.
+func (conn *stompConn) sendShutdownNotice(grace time.Duration) error {
+	body, _ := json.Marshal(ShutdownNotice{GraceMillis: grace.Milliseconds()})
+	for _, sub := range conn.subscriptions {
+		if sub.destination != ShutdownNoticeDestination {
+			continue
+		}
+		f := frame.New(frame.MESSAGE,
+			frame.Destination, ShutdownNoticeDestination,
+			frame.Subscription, sub.id,
+			frame.ContentType, "application/json;charset=UTF-8",
+			frame.ContentLength, strconv.Itoa(len(body)),
+			ShutdownGraceHeader, strconv.FormatInt(grace.Milliseconds(), 10))
+		f.Body = body
+		if err := conn.populateMessageIdHeader(f); err != nil {
+			return err
+		}
+		if err := conn.writeFrame(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// closeDrained writes the messages queued for the client, and closes the connection with an ERROR frame
+// telling it the server is shutting down. Only called by the run goroutine, which returns afterwards.
+func (conn *stompConn) closeDrained() {
+	// the run goroutine is the only one receiving from outFrames
+	for len(conn.outFrames) > 0 {
+		if !conn.writeQueuedFrame(<-conn.outFrames) {
+			return
+		}
+	}
+	conn.setCloseReason(CloseReasonServer)
+	conn.SendError(serverShuttingDownError)
+}
+
+// Drain closes the connections gracefully before the server stops: new connections are refused, every
+// client is sent a shutdown notice and refused new subscriptions, and once grace elapsed, the connections
+// still open are sent the messages queued for them and closed with an ERROR frame. Drain returns once every
+// connection is closed, or ctx is done.
+func (s *stompServer) Drain(ctx context.Context, grace time.Duration) {
+	if !s.running || !s.draining.CompareAndSwap(false, true) {
+		return
+	}
+	drained := make(chan struct{})
+	s.apiEvents <- &apiEvent{
+		eventType: drainConnections,
+		grace:     grace,
+		drained:   drained,
+	}
+	select {
+	case <-drained:
+	case <-ctx.Done():
+	}
+}
+
+// drainConnections drains every connection, and closes drained once they are closed. Only called by the run
+// goroutine.
+func (s *stompServer) drainConnections(grace time.Duration, drained chan struct{}) {
+	s.drained, s.drainGrace = drained, grace
+	for _, c := range s.connectionsMap {
+		c.Drain(grace)
+	}
+	s.checkDrained()
+}
+
+// checkDrained closes the drained channel once there are no connections left. Only called by the run
+// goroutine.
+func (s *stompServer) checkDrained() {
+	if s.drained != nil && len(s.connectionsMap) == 0 {
+		close(s.drained)
+		s.drained = nil
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/stretchr/testify/assert"
+)
+
+func lastFrame(conn *MockRawConnection) *frame.Frame {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+	if len(conn.sentFrames) == 0 {
+		return nil
+	}
+	return conn.sentFrames[len(conn.sentFrames)-1]
+}
+
+func TestStompServer_Drain(t *testing.T) {
+	server, listener := newTestStompServer(NewStompConfig(0, []string{"/pub/"}))
+	subscribed := make(chan string, 10)
+	server.OnSubscribeEvent(func(conId string, subId string, destination string, f *frame.Frame) {
+		subscribed <- destination
+	})
+	closed := make(chan string, 10)
+	server.SetConnectionEventCallback(ConnectionClosed, func(e *ConnEvent) {
+		closed <- e.Reason()
+	})
+	go server.Start()
+
+	client1, client2 := NewMockRawConnection(), NewMockRawConnection()
+	listener.incomingConnections <- client1
+	client1.SendConnectFrame()
+	client1.incomingFrames <- frame.New(frame.SUBSCRIBE, frame.Destination, ShutdownNoticeDestination, frame.Id, "n1")
+	client1.incomingFrames <- frame.New(frame.SUBSCRIBE, frame.Destination, "/topic/orders", frame.Id, "s1")
+	listener.incomingConnections <- client2
+	client2.SendConnectFrame()
+	for i := 0; i < 2; i++ {
+		receive(t, subscribed)
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		server.Drain(context.Background(), 200*time.Millisecond)
+		close(drained)
+	}()
+
+	// clients subscribed to notices are told how long they have
+	assert.Equal(t, []string{`{"grace_ms":200}`}, messages(t, client1, 1))
+	client1.lock.Lock()
+	assert.Equal(t, "200", client1.sentFrames[len(client1.sentFrames)-1].Header.Get(ShutdownGraceHeader))
+	client1.lock.Unlock()
+
+	// new subscriptions are refused
+	client2.incomingFrames <- frame.New(frame.SUBSCRIBE, frame.Destination, "/topic/orders", frame.Id, "s1")
+	assert.Equal(t, CloseReasonError, receive(t, closed))
+	assert.Equal(t, frame.ERROR, lastFrame(client2).Command)
+	assert.Equal(t, serverShuttingDownError.Error(), lastFrame(client2).Header.Get(frame.Message))
+
+	// messages are still delivered, and the connections left are closed once the grace elapsed
+	server.SendMessage("/topic/orders", []byte("last"))
+	assert.Equal(t, []string{`{"grace_ms":200}`, "last"}, messages(t, client1, 2))
+	select {
+	case <-drained:
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "server not drained")
+	}
+	assert.Equal(t, CloseReasonServer, receive(t, closed))
+	assert.Equal(t, frame.ERROR, lastFrame(client1).Command)
+	assert.Equal(t, serverShuttingDownError.Error(), lastFrame(client1).Header.Get(frame.Message))
+
+	// new connections are refused
+	client3 := NewMockRawConnection()
+	listener.incomingConnections <- client3
+	assert.Empty(t, server.Connections())
+	server.Stop()
+}
+
+func TestStompServer_DrainTimeout(t *testing.T) {
+	server, listener := newTestStompServer(NewStompConfig(0, []string{"/pub/"}))
+
+	// nothing to drain before the server runs
+	server.Drain(context.Background(), time.Hour)
+	assert.False(t, server.draining.Load())
+
+	go server.Start()
+	defer server.Stop()
+	client := NewMockRawConnection()
+	listener.incomingConnections <- client
+	client.SendConnectFrame()
+	assert.Eventually(t, func() bool {
+		return len(server.Connections()) == 1
+	}, time.Second, 5*time.Millisecond)
+
+	// clients are given their grace, Drain gives up waiting for them once its context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	server.Drain(ctx, time.Hour)
+	assert.Less(t, time.Since(started), time.Second)
+	assert.Len(t, server.Connections(), 1)
+}
@@ -0,0 +1,159 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/pb33f/ranch/clock"
+)
+
+// ClientIdHeader is the CONNECT header a client identifies itself with across connections, e.g. an id a
+// browser keeps in its local storage so it survives page reloads.
+const ClientIdHeader = "client-id"
+
+// DurableHeader makes a subscription durable when set to "true" on a SUBSCRIBE frame of a client that sent
+// a ClientIdHeader. While the client is offline, messages sent to the destination are queued, and delivered
+// once the client subscribes durably to the destination again on a new connection. A durable subscription
+// ends when the client unsubscribes.
+const DurableHeader = "durable"
+
+// DurableSubscriptionConfig sets how many messages are queued for offline durable subscribers, and for how
+// long.
+type DurableSubscriptionConfig struct {
+	// MaxMessages is the number of messages queued per offline subscription, the oldest are dropped first.
+	// Durable subscriptions are not supported if 0.
+	MaxMessages int
+	// TTL is how long queued messages are kept, and how long the subscriptions of a client that does not
+	// come back are. Both are kept until the server stops if 0.
+	TTL time.Duration
+}
+
+func (c DurableSubscriptionConfig) enabled() bool {
+	return c.MaxMessages > 0
+}
+
+// expired returns whether something that happened at t is older than the TTL.
+func (c DurableSubscriptionConfig) expired(t time.Time, now time.Time) bool {
+	return c.TTL > 0 && now.Sub(t) > c.TTL
+}
+
+type durableMessage struct {
+	frame    *frame.Frame
+	queuedAt time.Time
+}
+
+// durableSubscription is the durable subscription of a client to a destination. While the client is
+// offline, the subscription of its last connection is kept, so the destination keeps being sent messages.
+type durableSubscription struct {
+	connId       string // connection of the last subscription
+	subId        string // id of the last subscription
+	selector     *selector
+	online       bool
+	offlineSince time.Time
+	queue        []*durableMessage
+}
+
+// durableSubscribed starts or resumes the durable subscription of a client, delivering what was queued
+// while it was offline. Only called by the run goroutine.
+func (s *stompServer) durableSubscribed(e *ConnEvent) {
+	if !s.config.GetDurableSubscriptions().enabled() || !e.sub.durable {
+		return
+	}
+	clients, ok := s.durables[e.destination]
+	if !ok {
+		clients = make(map[string]*durableSubscription)
+		s.durables[e.destination] = clients
+	}
+	ds, ok := clients[e.conn.GetClientId()]
+	if ok && !ds.online {
+		// the subscription of the previous connection was kept while the client was offline
+		s.notifyUnsubscribe(ds.connId, ds.subId, e.destination)
+		now := clock.Now()
+		for _, msg := range ds.queue {
+			if !s.config.GetDurableSubscriptions().expired(msg.queuedAt, now) {
+				e.conn.SendFrameToSubscription(msg.frame, e.sub)
+			}
+		}
+	}
+	clients[e.conn.GetClientId()] = &durableSubscription{connId: e.conn.GetId(), subId: e.sub.id,
+		selector: e.sub.selector, online: true}
+}
+
+// durableUnsubscribed ends the durable subscription of a client. Only called by the run goroutine.
+func (s *stompServer) durableUnsubscribed(conn StompConn, sub *Subscription) {
+	if ds := s.durableSubscription(conn, sub); ds != nil {
+		delete(s.durables[sub.destination], conn.GetClientId())
+		if len(s.durables[sub.destination]) == 0 {
+			delete(s.durables, sub.destination)
+		}
+	}
+}
+
+// durableOffline marks the durable subscription of a client offline as its connection closed, returning
+// false if the subscription is not durable. Only called by the run goroutine.
+func (s *stompServer) durableOffline(conn StompConn, sub *Subscription) bool {
+	ds := s.durableSubscription(conn, sub)
+	if ds == nil {
+		return false
+	}
+	ds.online = false
+	ds.offlineSince = clock.Now()
+	return true
+}
+
+// durableSubscription returns the durable subscription a subscription of a connection is, nil if it is not.
+func (s *stompServer) durableSubscription(conn StompConn, sub *Subscription) *durableSubscription {
+	if !sub.durable {
+		return nil
+	}
+	ds, ok := s.durables[sub.destination][conn.GetClientId()]
+	if !ok || ds.connId != conn.GetId() || ds.subId != sub.id {
+		return nil
+	}
+	return ds
+}
+
+// queueDurable queues a message sent to a destination for its offline durable subscribers, those whose
+// selector matches it. Only called by the run goroutine.
+func (s *stompServer) queueDurable(dest string, msg *selectorMessage) {
+	cfg := s.config.GetDurableSubscriptions()
+	now := clock.Now()
+	for _, ds := range s.durables[dest] {
+		if ds.online || cfg.expired(ds.offlineSince, now) || !ds.selector.matches(msg) {
+			continue
+		}
+		for len(ds.queue) > 0 && (len(ds.queue) >= cfg.MaxMessages || cfg.expired(ds.queue[0].queuedAt, now)) {
+			ds.queue[0] = nil
+			ds.queue = ds.queue[1:]
+		}
+		ds.queue = append(ds.queue, &durableMessage{frame: msg.frame.Clone(), queuedAt: now})
+	}
+}
+
+// expireDurables drops the durable subscriptions of the clients offline for longer than the TTL, and ends
+// the subscriptions kept for them. Only called by the run goroutine.
+func (s *stompServer) expireDurables() {
+	cfg := s.config.GetDurableSubscriptions()
+	now := clock.Now()
+	for dest, clients := range s.durables {
+		for clientId, ds := range clients {
+			if !ds.online && cfg.expired(ds.offlineSince, now) {
+				delete(clients, clientId)
+				s.notifyUnsubscribe(ds.connId, ds.subId, dest)
+			}
+		}
+		if len(clients) == 0 {
+			delete(s.durables, dest)
+		}
+	}
+}
+
+// notifyUnsubscribe tells the unsubscribe listeners a subscription ended. Callers hold the callback lock.
+func (s *stompServer) notifyUnsubscribe(conId string, subId string, dest string) {
+	for _, callback := range s.unsubscribeCallbacks {
+		callback(conId, subId, dest)
+	}
+}
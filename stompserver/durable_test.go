@@ -0,0 +1,167 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/pb33f/ranch/clock"
+	"github.com/stretchr/testify/assert"
+)
+
+type durableTestServer struct {
+	server       *stompServer
+	listener     *MockRawConnectionListener
+	subscribed   chan string
+	unsubscribed chan string
+	closed       chan string
+}
+
+func newDurableTestServer(t *testing.T, durable DurableSubscriptionConfig) *durableTestServer {
+	config := NewStompConfig(0, []string{"/pub/"})
+	config.SetDurableSubscriptions(durable)
+	server, listener := newTestStompServer(config)
+	ts := &durableTestServer{
+		server:       server,
+		listener:     listener,
+		subscribed:   make(chan string, 10),
+		unsubscribed: make(chan string, 10),
+		closed:       make(chan string, 10),
+	}
+	server.OnSubscribeEvent(func(conId string, subId string, destination string, f *frame.Frame) {
+		ts.subscribed <- subId
+	})
+	server.OnUnsubscribeEvent(func(conId string, subId string, destination string) {
+		ts.unsubscribed <- subId
+	})
+	server.SetConnectionEventCallback(ConnectionClosed, func(e *ConnEvent) {
+		ts.closed <- e.ConnId
+	})
+	go server.Start()
+	return ts
+}
+
+// connect opens a connection for the client and subscribes it durably to /topic/news.
+func (ts *durableTestServer) connect(t *testing.T, clientId string, subId string) *MockRawConnection {
+	conn := NewMockRawConnection()
+	ts.listener.incomingConnections <- conn
+	conn.incomingFrames <- frame.New(frame.CONNECT, frame.AcceptVersion, "1.2", ClientIdHeader, clientId)
+	conn.incomingFrames <- frame.New(frame.SUBSCRIBE, frame.Destination, "/topic/news", frame.Id, subId,
+		DurableHeader, "true")
+	assert.Equal(t, subId, receive(t, ts.subscribed))
+	return conn
+}
+
+func (ts *durableTestServer) disconnect(t *testing.T, conn *MockRawConnection) {
+	conn.incomingFrames <- frame.New(frame.DISCONNECT)
+	receive(t, ts.closed)
+}
+
+// send sends messages to /topic/news, returning once the server handled them.
+func (ts *durableTestServer) send(bodies ...string) {
+	for _, body := range bodies {
+		ts.server.SendMessage("/topic/news", []byte(body))
+	}
+	ts.server.Connections()
+}
+
+func receive(t *testing.T, c chan string) string {
+	t.Helper()
+	select {
+	case v := <-c:
+		return v
+	case <-time.After(time.Second):
+		t.Fatal("timed out")
+	}
+	return ""
+}
+
+// messages returns the bodies of the MESSAGE frames sent to a connection, once there are count of them.
+func messages(t *testing.T, conn *MockRawConnection, count int) []string {
+	var bodies []string
+	assert.Eventually(t, func() bool {
+		conn.lock.Lock()
+		defer conn.lock.Unlock()
+		bodies = nil
+		for _, f := range conn.sentFrames {
+			if f.Command == frame.MESSAGE {
+				bodies = append(bodies, string(f.Body))
+			}
+		}
+		return len(bodies) == count
+	}, time.Second, 5*time.Millisecond)
+	return bodies
+}
+
+func TestStompServer_DurableSubscription(t *testing.T) {
+	ts := newDurableTestServer(t, DurableSubscriptionConfig{MaxMessages: 2})
+
+	conn := ts.connect(t, "browser-1", "s1")
+	ts.send("one")
+	assert.Equal(t, []string{"one"}, messages(t, conn, 1))
+
+	// the subscription is kept while the client is offline, and messages are queued for it
+	ts.disconnect(t, conn)
+	assert.Empty(t, ts.unsubscribed)
+	ts.send("two", "three", "four")
+
+	// the queue is delivered on reconnect, the oldest message was dropped as the queue was full
+	conn = ts.connect(t, "browser-1", "s2")
+	assert.Equal(t, "s1", receive(t, ts.unsubscribed), "the subscription kept is released")
+	assert.Equal(t, []string{"three", "four"}, messages(t, conn, 2))
+	conn.lock.Lock()
+	assert.Equal(t, "s2", conn.LastSentFrame().Header.Get(frame.Subscription))
+	conn.lock.Unlock()
+
+	// unsubscribing ends the durable subscription
+	conn.incomingFrames <- frame.New(frame.UNSUBSCRIBE, frame.Id, "s2")
+	assert.Equal(t, "s2", receive(t, ts.unsubscribed))
+	ts.disconnect(t, conn)
+	ts.send("five")
+	conn = ts.connect(t, "browser-1", "s3")
+	ts.send("six")
+	assert.Equal(t, []string{"six"}, messages(t, conn, 1))
+}
+
+func TestStompServer_DurableSubscriptionNeedsClientId(t *testing.T) {
+	ts := newDurableTestServer(t, DurableSubscriptionConfig{MaxMessages: 2})
+
+	conn := ts.connect(t, "", "s1")
+	ts.disconnect(t, conn)
+	assert.Equal(t, "s1", receive(t, ts.unsubscribed))
+	ts.send("one")
+
+	conn = ts.connect(t, "", "s2")
+	ts.send("two")
+	assert.Equal(t, []string{"two"}, messages(t, conn, 1))
+}
+
+func TestStompServer_DurableSubscriptionTTL(t *testing.T) {
+	fake := clock.NewFakeClock(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	clock.Set(fake)
+	defer clock.Reset()
+	ts := newDurableTestServer(t, DurableSubscriptionConfig{MaxMessages: 10, TTL: time.Minute})
+
+	conn := ts.connect(t, "browser-1", "s1")
+	ts.disconnect(t, conn)
+	ts.send("stale")
+	fake.Advance(45 * time.Second)
+	ts.send("fresh")
+	fake.Advance(30 * time.Second)
+
+	// messages older than the TTL are not delivered
+	conn = ts.connect(t, "browser-1", "s2")
+	receive(t, ts.unsubscribed)
+	assert.Equal(t, []string{"fresh"}, messages(t, conn, 1))
+
+	// clients offline for longer than the TTL lose their subscription when another connection closes
+	ts.disconnect(t, conn)
+	fake.Advance(2 * time.Minute)
+	other := ts.connect(t, "browser-2", "s3")
+	ts.disconnect(t, other)
+	assert.Equal(t, "s2", receive(t, ts.unsubscribed))
+	assert.Empty(t, ts.unsubscribed, "the subscription of the other client is kept")
+}
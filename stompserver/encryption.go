@@ -0,0 +1,259 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/pb33f/ranch/clock"
+	"golang.org/x/crypto/hkdf"
+)
+
+// SessionKeyDestination is the destination clients subscribe to for key rotations. When the server rotates
+// the key of a session, it sends a MESSAGE to it carrying the id of the new key in its SessionKeyIdHeader.
+const SessionKeyDestination = "/ranch/session-key"
+
+// Headers of the session encryption.
+const (
+	SessionKeyHeader   = "session-key"    // the public key of the client on CONNECT, of the server on CONNECTED
+	SessionKeyIdHeader = "session-key-id" // the id of the key a body was encrypted with
+	EncryptionHeader   = "encryption"     // the algorithm a body was encrypted with
+)
+
+// SessionEncryptionAlgorithm is the EncryptionHeader of the encrypted bodies.
+const SessionEncryptionAlgorithm = "A256GCM"
+
+// EncryptionConfig sets which destinations carry encrypted bodies, and how often session keys rotate.
+//
+// Session encryption protects the bodies of the messages on sensitive destinations with a key of the
+// session, above TLS, so intermediaries terminating TLS (load balancers, proxies) only see ciphertext. It
+// only uses what browsers offer through WebCrypto: ECDH on P-256, HKDF with SHA-256 and AES-256-GCM.
+//
+// Key exchange. A client wanting an encrypted session generates an ephemeral P-256 key pair and sends its
+// public key in the SessionKeyHeader of its CONNECT frame: the uncompressed point (65 bytes, WebCrypto's
+// "raw" export), base64 encoded. If the server encrypts any destination, it answers with its own ephemeral
+// public key in the SessionKeyHeader of the CONNECTED frame, and "0" in its SessionKeyIdHeader. Both derive
+// the 256 bits shared secret by ECDH (WebCrypto's deriveBits with a length of 256), and key 0 from it:
+//
+//	key 0 = HKDF-SHA256(secret, salt = client public key || server public key, info = "ranch session key 0")
+//
+// A server answering without a SessionKeyHeader does not encrypt anything, clients requiring encryption
+// should disconnect.
+//
+// Messages. The body of a MESSAGE or SEND frame on an encrypted destination is encrypted with AES-256-GCM,
+// with a random 12 bytes nonce and the destination as additional data, and replaced by the base64 encoding
+// of the nonce followed by the ciphertext and its tag. The frame carries SessionEncryptionAlgorithm in its
+// EncryptionHeader, and the id of the key in its SessionKeyIdHeader; its content-type still describes the
+// plaintext. Clients must encrypt what they send to encrypted destinations, and sessions without a key may
+// neither subscribe nor send to them: they are disconnected with an ERROR frame.
+//
+// Rotation. The server rotates the key of a session once it is older than EncryptionConfig.RekeyInterval,
+// or was used for EncryptionConfig.RekeyMessages messages. Each key derives from the previous one:
+//
+//	key n = HKDF-SHA256(key n-1, salt = empty, info = "ranch session key n")
+//
+// so old keys cannot be recovered from the current one. The server tells the subscribers of
+// SessionKeyDestination, and the messages it encrypts carry the id of the new key: clients derive keys up
+// to the id they see, and encrypt what they send with the latest. Messages encrypted with the previous key
+// are still accepted, as the client may not have seen the rotation yet.
+//
+// Only STOMP connections support session encryption, JSON WebSocket and MQTT clients cannot use encrypted
+// destinations.
+type EncryptionConfig struct {
+	// Destinations are encrypted, along with the destinations starting with those ending in a slash.
+	// Nothing is encrypted if empty.
+	Destinations []string
+	// RekeyInterval is how long a session key is used for, keys only rotate after RekeyMessages if 0.
+	RekeyInterval time.Duration
+	// RekeyMessages is how many messages a session key encrypts or decrypts, keys only rotate after
+	// RekeyInterval if 0.
+	RekeyMessages int
+}
+
+func (c EncryptionConfig) enabled() bool {
+	return len(c.Destinations) > 0
+}
+
+// encrypted returns whether the bodies sent to a destination are encrypted.
+func (c EncryptionConfig) encrypted(destination string) bool {
+	for _, d := range c.Destinations {
+		if destination == d || (strings.HasSuffix(d, "/") && strings.HasPrefix(destination, d)) {
+			return true
+		}
+	}
+	return false
+}
+
+// sessionCipher holds the keys of an encrypted session. Only used by the run goroutine of its connection.
+type sessionCipher struct {
+	id       uint64
+	key      []byte
+	current  cipher.AEAD
+	previous cipher.AEAD // accepted for what the client encrypted before it saw the rotation
+	created  time.Time
+	used     int
+}
+
+// newSessionCipher derives key 0 of a session from the public key the client sent, returning the public
+// key the client derives it with.
+func newSessionCipher(clientKey string) (*sessionCipher, string, error) {
+	clientRaw, err := base64.StdEncoding.DecodeString(clientKey)
+	if err != nil {
+		return nil, "", invalidSessionKeyError
+	}
+	clientPublic, err := ecdh.P256().NewPublicKey(clientRaw)
+	if err != nil {
+		return nil, "", invalidSessionKeyError
+	}
+	private, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := private.ECDH(clientPublic)
+	if err != nil {
+		return nil, "", invalidSessionKeyError
+	}
+	serverRaw := private.PublicKey().Bytes()
+	salt := append(append([]byte{}, clientRaw...), serverRaw...)
+	c := &sessionCipher{}
+	if err := c.derive(secret, salt, 0); err != nil {
+		return nil, "", err
+	}
+	return c, base64.StdEncoding.EncodeToString(serverRaw), nil
+}
+
+// derive makes the key with the id derived from a secret the current one.
+func (c *sessionCipher) derive(secret []byte, salt []byte, id uint64) error {
+	key := make([]byte, 32)
+	info := "ranch session key " + strconv.FormatUint(id, 10)
+	if _, err := hkdf.New(sha256.New, secret, salt, []byte(info)).Read(key); err != nil {
+		return err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	c.previous, c.current = c.current, aead
+	c.id, c.key = id, key
+	c.created = clock.Now()
+	c.used = 0
+	return nil
+}
+
+// rotate derives the next key from the current one.
+func (c *sessionCipher) rotate() error {
+	return c.derive(c.key, nil, c.id+1)
+}
+
+// due returns whether the current key must rotate before it is used again.
+func (c *sessionCipher) due(config EncryptionConfig) bool {
+	return (config.RekeyInterval > 0 && clock.Now().Sub(c.created) >= config.RekeyInterval) ||
+		(config.RekeyMessages > 0 && c.used >= config.RekeyMessages)
+}
+
+// encrypt encrypts the body of a frame sent to a destination with the current key.
+func (c *sessionCipher) encrypt(f *frame.Frame, destination string) error {
+	nonce := make([]byte, c.current.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	sealed := c.current.Seal(nonce, nonce, f.Body, []byte(destination))
+	f.Body = []byte(base64.StdEncoding.EncodeToString(sealed))
+	f.Header.Set(EncryptionHeader, SessionEncryptionAlgorithm)
+	f.Header.Set(SessionKeyIdHeader, strconv.FormatUint(c.id, 10))
+	f.Header.Set(frame.ContentLength, strconv.Itoa(len(f.Body)))
+	c.used++
+	return nil
+}
+
+// decrypt replaces the body of a frame a client encrypted for a destination with its plaintext.
+func (c *sessionCipher) decrypt(f *frame.Frame, destination string) error {
+	if f.Header.Get(EncryptionHeader) != SessionEncryptionAlgorithm {
+		return encryptionRequiredError
+	}
+	id, err := strconv.ParseUint(f.Header.Get(SessionKeyIdHeader), 10, 64)
+	if err != nil {
+		return decryptionFailedError
+	}
+	aead := c.current
+	if id != c.id {
+		if id+1 != c.id || c.previous == nil {
+			return decryptionFailedError
+		}
+		aead = c.previous
+	}
+	sealed, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(f.Body)))
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return decryptionFailedError
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	body, err := aead.Open(nil, nonce, ciphertext, []byte(destination))
+	if err != nil {
+		return decryptionFailedError
+	}
+	f.Body = body
+	f.Header.Del(EncryptionHeader)
+	f.Header.Del(SessionKeyIdHeader)
+	f.Header.Set(frame.ContentLength, strconv.Itoa(len(body)))
+	c.used++
+	return nil
+}
+
+// sessionKeyFor returns the key of the session for a message on a destination, rotating it first if it is
+// due. Returns nil if the destination is not encrypted, and an error if the session has no key. Only called
+// by the run goroutine.
+func (conn *stompConn) sessionKeyFor(destination string) (*sessionCipher, error) {
+	cfg := conn.config.GetEncryption()
+	if !cfg.encrypted(destination) {
+		return nil, nil
+	}
+	if conn.session == nil {
+		return nil, encryptionRequiredError
+	}
+	if conn.session.due(cfg) {
+		if err := conn.session.rotate(); err != nil {
+			return nil, err
+		}
+		if err := conn.sendRekeyNotice(); err != nil {
+			return nil, err
+		}
+	}
+	return conn.session, nil
+}
+
+// sendRekeyNotice tells every key rotation subscription of the client the id of the new key.
+func (conn *stompConn) sendRekeyNotice() error {
+	id := strconv.FormatUint(conn.session.id, 10)
+	for _, sub := range conn.subscriptions {
+		if sub.destination != SessionKeyDestination {
+			continue
+		}
+		f := frame.New(frame.MESSAGE,
+			frame.Destination, SessionKeyDestination,
+			frame.Subscription, sub.id,
+			frame.ContentLength, "0",
+			SessionKeyIdHeader, id)
+		if err := conn.populateMessageIdHeader(f); err != nil {
+			return err
+		}
+		if err := conn.writeFrame(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
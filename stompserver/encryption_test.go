@@ -0,0 +1,191 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/hkdf"
+)
+
+// testSessionClient is the client side of the session encryption, as a browser would implement it.
+type testSessionClient struct {
+	private *ecdh.PrivateKey
+	keys    [][]byte // by id
+}
+
+func newTestSessionClient(t *testing.T) *testSessionClient {
+	private, err := ecdh.P256().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	return &testSessionClient{private: private}
+}
+
+func (c *testSessionClient) publicKey() string {
+	return base64.StdEncoding.EncodeToString(c.private.PublicKey().Bytes())
+}
+
+func testHkdf(t *testing.T, secret, salt []byte, id int) []byte {
+	key := make([]byte, 32)
+	_, err := hkdf.New(sha256.New, secret, salt, []byte("ranch session key "+strconv.Itoa(id))).Read(key)
+	require.NoError(t, err)
+	return key
+}
+
+// connected derives key 0 from the CONNECTED frame of the server.
+func (c *testSessionClient) connected(t *testing.T, f *frame.Frame) {
+	require.Equal(t, "0", f.Header.Get(SessionKeyIdHeader))
+	serverRaw, err := base64.StdEncoding.DecodeString(f.Header.Get(SessionKeyHeader))
+	require.NoError(t, err)
+	serverPublic, err := ecdh.P256().NewPublicKey(serverRaw)
+	require.NoError(t, err)
+	secret, err := c.private.ECDH(serverPublic)
+	require.NoError(t, err)
+	salt := append(c.private.PublicKey().Bytes(), serverRaw...)
+	c.keys = [][]byte{testHkdf(t, secret, salt, 0)}
+}
+
+func (c *testSessionClient) aead(t *testing.T, id int) cipher.AEAD {
+	for len(c.keys) <= id {
+		c.keys = append(c.keys, testHkdf(t, c.keys[len(c.keys)-1], nil, len(c.keys)))
+	}
+	block, err := aes.NewCipher(c.keys[id])
+	require.NoError(t, err)
+	aead, err := cipher.NewGCM(block)
+	require.NoError(t, err)
+	return aead
+}
+
+func (c *testSessionClient) decrypt(t *testing.T, f *frame.Frame) string {
+	require.Equal(t, SessionEncryptionAlgorithm, f.Header.Get(EncryptionHeader))
+	id, err := strconv.Atoi(f.Header.Get(SessionKeyIdHeader))
+	require.NoError(t, err)
+	aead := c.aead(t, id)
+	sealed, err := base64.StdEncoding.DecodeString(string(f.Body))
+	require.NoError(t, err)
+	body, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():],
+		[]byte(f.Header.Get(frame.Destination)))
+	require.NoError(t, err)
+	return string(body)
+}
+
+func (c *testSessionClient) send(t *testing.T, id int, destination string, body string) *frame.Frame {
+	aead := c.aead(t, id)
+	nonce := make([]byte, aead.NonceSize())
+	_, _ = rand.Read(nonce)
+	sealed := aead.Seal(nonce, nonce, []byte(body), []byte(destination))
+	f := frame.New(frame.SEND, frame.Destination, destination,
+		EncryptionHeader, SessionEncryptionAlgorithm, SessionKeyIdHeader, strconv.Itoa(id))
+	f.Body = []byte(base64.StdEncoding.EncodeToString(sealed))
+	return f
+}
+
+// sentFrames returns the frames with a command sent to a connection, once there are count of them.
+func sentFrames(t *testing.T, conn *MockRawConnection, command string, count int) []*frame.Frame {
+	var frames []*frame.Frame
+	assert.Eventually(t, func() bool {
+		conn.lock.Lock()
+		defer conn.lock.Unlock()
+		frames = nil
+		for _, f := range conn.sentFrames {
+			if f.Command == command {
+				frames = append(frames, f)
+			}
+		}
+		return len(frames) == count
+	}, time.Second, 5*time.Millisecond)
+	return frames
+}
+
+func TestEncryptionConfig_Encrypted(t *testing.T) {
+	config := EncryptionConfig{Destinations: []string{"/topic/secrets", "/pub/vault/"}}
+	assert.True(t, config.encrypted("/topic/secrets"))
+	assert.False(t, config.encrypted("/topic/secrets-public"))
+	assert.True(t, config.encrypted("/pub/vault/keys"))
+	assert.False(t, config.encrypted("/pub/vault"))
+	assert.False(t, EncryptionConfig{}.encrypted("/topic/secrets"))
+}
+
+func TestStompServer_Encryption(t *testing.T) {
+	config := NewStompConfig(0, []string{"/pub/"})
+	config.SetEncryption(EncryptionConfig{Destinations: []string{"/topic/secrets", "/pub/secrets"},
+		RekeyMessages: 2})
+	server, listener := newTestStompServer(config)
+	subscribed := make(chan string, 10)
+	server.OnSubscribeEvent(func(conId string, subId string, destination string, f *frame.Frame) {
+		subscribed <- destination
+	})
+	requests := make(chan string, 10)
+	server.OnApplicationRequest(func(destination string, message []byte, connectionId string) {
+		requests <- string(message)
+	})
+	go server.Start()
+
+	conn, client := NewMockRawConnection(), newTestSessionClient(t)
+	listener.incomingConnections <- conn
+	conn.incomingFrames <- frame.New(frame.CONNECT, frame.AcceptVersion, "1.2",
+		SessionKeyHeader, client.publicKey())
+	client.connected(t, sentFrames(t, conn, frame.CONNECTED, 1)[0])
+	conn.incomingFrames <- frame.New(frame.SUBSCRIBE, frame.Destination, SessionKeyDestination, frame.Id, "k")
+	conn.incomingFrames <- frame.New(frame.SUBSCRIBE, frame.Destination, "/topic/secrets", frame.Id, "s")
+	conn.incomingFrames <- frame.New(frame.SUBSCRIBE, frame.Destination, "/topic/public", frame.Id, "p")
+	for i := 0; i < 3; i++ {
+		receive(t, subscribed)
+	}
+
+	// messages on the encrypted destinations are encrypted with the key of the session, the others are not
+	server.SendMessage("/topic/public", []byte("moo"))
+	server.SendMessage("/topic/secrets", []byte("the cows are loose"))
+	msgs := sentFrames(t, conn, frame.MESSAGE, 2)
+	assert.Equal(t, "moo", string(msgs[0].Body))
+	assert.Equal(t, "0", msgs[1].Header.Get(SessionKeyIdHeader))
+	assert.NotContains(t, string(msgs[1].Body), "cows")
+	assert.Equal(t, "the cows are loose", client.decrypt(t, msgs[1]))
+
+	conn.incomingFrames <- client.send(t, 0, "/pub/secrets", "round them up")
+	assert.Equal(t, "round them up", receive(t, requests))
+
+	// the key rotates once used for two messages, the client is told and follows
+	server.SendMessage("/topic/secrets", []byte("they are in the barn"))
+	msgs = sentFrames(t, conn, frame.MESSAGE, 4)
+	assert.Equal(t, SessionKeyDestination, msgs[2].Header.Get(frame.Destination))
+	assert.Equal(t, "1", msgs[2].Header.Get(SessionKeyIdHeader))
+	assert.Equal(t, "1", msgs[3].Header.Get(SessionKeyIdHeader))
+	assert.Equal(t, "they are in the barn", client.decrypt(t, msgs[3]))
+
+	// what the client encrypted with the previous key is still accepted, older keys are not
+	conn.incomingFrames <- client.send(t, 0, "/pub/secrets", "shut the gate")
+	assert.Equal(t, "shut the gate", receive(t, requests))
+	conn.incomingFrames <- client.send(t, 1, "/pub/secrets", "and lock it")
+	assert.Equal(t, "and lock it", receive(t, requests))
+	// the key rotated to 2 before that last message, so key 0 is refused
+	conn.incomingFrames <- client.send(t, 0, "/pub/secrets", "too late")
+	assert.Equal(t, string(decryptionFailedError),
+		sentFrames(t, conn, frame.ERROR, 1)[0].Header.Get(frame.Message))
+
+	// sessions without a key may neither subscribe nor send to the encrypted destinations
+	plain := NewMockRawConnection()
+	listener.incomingConnections <- plain
+	plain.SendConnectFrame()
+	assert.Empty(t, sentFrames(t, plain, frame.CONNECTED, 1)[0].Header.Get(SessionKeyHeader))
+	plain.incomingFrames <- frame.New(frame.SUBSCRIBE, frame.Destination, "/topic/secrets", frame.Id, "s")
+	assert.Equal(t, string(encryptionRequiredError),
+		sentFrames(t, plain, frame.ERROR, 1)[0].Header.Get(frame.Message))
+
+	plain = NewMockRawConnection()
+	listener.incomingConnections <- plain
+	plain.incomingFrames <- frame.New(frame.CONNECT, frame.AcceptVersion, "1.2", SessionKeyHeader, "moo")
+	assert.Equal(t, string(invalidSessionKeyError),
+		sentFrames(t, plain, frame.ERROR, 1)[0].Header.Get(frame.Message))
+}
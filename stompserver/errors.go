@@ -12,6 +12,22 @@ const (
     invalidFrameError            = stompErrorMessage("invalid frame")
     invalidHeaderError           = stompErrorMessage("invalid frame header")
     invalidSendDestinationError  = stompErrorMessage("invalid send destination")
+    revokedSessionTokenError     = stompErrorMessage("session token has been revoked")
+    missingTicketError           = stompErrorMessage("missing ticket")
+    invalidTicketError           = stompErrorMessage("invalid or expired ticket")
+    invalidAckModeError          = stompErrorMessage("invalid ack mode")
+    missingAckIdError            = stompErrorMessage("missing ack id")
+    missingTransactionError      = stompErrorMessage("missing transaction header")
+    unknownTransactionError      = stompErrorMessage("unknown transaction")
+    duplicateTransactionError    = stompErrorMessage("transaction already started")
+    tooManyPendingAcksError      = stompErrorMessage("too many unacknowledged messages")
+    authenticationFailedError    = stompErrorMessage("authentication failed")
+    rateLimitExceededError       = stompErrorMessage("rate limit exceeded")
+    frameTooLargeError           = stompErrorMessage("frame too large")
+    serverShuttingDownError      = stompErrorMessage("server shutting down")
+    invalidSessionKeyError       = stompErrorMessage("invalid session key")
+    encryptionRequiredError      = stompErrorMessage("destination requires an encrypted session")
+    decryptionFailedError        = stompErrorMessage("cannot decrypt message")
 )
 
 type stompErrorMessage string
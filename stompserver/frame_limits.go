@@ -0,0 +1,187 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"sync/atomic"
+
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// frameReaderBufferSize is the read buffer of a connection, as large as that of frame.Reader.
+const frameReaderBufferSize = 4096
+
+// FrameLimits caps the size of the frames clients send, so a client cannot exhaust the memory of the broker
+// with giant frames. A client sending a larger frame is sent an ERROR frame and disconnected, before the
+// frame is read into memory.
+type FrameLimits struct {
+	MaxFrameSize int `json:"max_frame_size"` // bytes of a frame, command and headers included, not limited if 0
+	MaxBodySize  int `json:"max_body_size"`  // bytes of a frame body, not limited if 0
+}
+
+// FrameLimitedListener is a RawConnectionListener whose connections enforce FrameLimits. The STOMP
+// WebSocket and TCP listeners of this package are, and so is a multi connection listener merging them.
+type FrameLimitedListener interface {
+	// SetFrameLimits sets the limits of the connections accepted afterwards.
+	SetFrameLimits(limits FrameLimits)
+}
+
+var headerValueDecoder = strings.NewReplacer("\\r", "\r", "\\n", "\n", "\\c", ":", "\\\\", "\\")
+
+// frameReader reads STOMP frames like frame.Reader, refusing frames exceeding its limits with
+// frameTooLargeError as soon as their size is known.
+type frameReader struct {
+	reader *bufio.Reader
+	limits FrameLimits
+	size   int // bytes of the frame being read
+}
+
+func newFrameReader(r io.Reader, limits FrameLimits) *frameReader {
+	return &frameReader{reader: bufio.NewReaderSize(r, frameReaderBufferSize), limits: limits}
+}
+
+// Read reads a frame, returning a nil frame for a heart-beat.
+func (r *frameReader) Read() (*frame.Frame, error) {
+	r.size = 0
+	command, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(command) == 0 {
+		return nil, nil
+	}
+
+	f := frame.New(string(command))
+	switch f.Command {
+	case frame.CONNECT, frame.STOMP, frame.SEND, frame.SUBSCRIBE, frame.UNSUBSCRIBE, frame.ACK, frame.NACK,
+		frame.BEGIN, frame.COMMIT, frame.ABORT, frame.DISCONNECT, frame.CONNECTED, frame.MESSAGE,
+		frame.RECEIPT, frame.ERROR:
+	default:
+		return nil, frame.ErrInvalidCommand
+	}
+
+	for {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 {
+			break
+		}
+		index := bytes.IndexByte(line, ':')
+		if index <= 0 {
+			return nil, frame.ErrInvalidFrameFormat
+		}
+		f.Header.Add(headerValueDecoder.Replace(string(line[:index])),
+			headerValueDecoder.Replace(string(line[index+1:])))
+	}
+
+	contentLength, ok, err := f.Header.ContentLength()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if f.Body, err = r.readBody(); err != nil {
+			return nil, err
+		}
+		return f, nil
+	}
+
+	// the size of the body is known up front, so giant bodies are refused before they are allocated
+	if err := r.checkBody(contentLength); err != nil {
+		return nil, err
+	}
+	if err := r.count(contentLength + 1); err != nil {
+		return nil, err
+	}
+	f.Body = make([]byte, contentLength)
+	if _, err := io.ReadFull(r.reader, f.Body); err != nil {
+		return nil, err
+	}
+	if terminator, err := r.reader.ReadByte(); err != nil {
+		return nil, err
+	} else if terminator != 0 {
+		return nil, frame.ErrInvalidFrameFormat
+	}
+	return f, nil
+}
+
+// readLine reads a line without its terminating LF or CR-LF.
+func (r *frameReader) readLine() ([]byte, error) {
+	line, err := r.readUntil('\n', -1)
+	if err != nil {
+		return nil, err
+	}
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	return bytes.TrimSuffix(line, []byte("\r")), nil
+}
+
+// readBody reads a body terminated by a NUL byte, without the NUL.
+func (r *frameReader) readBody() ([]byte, error) {
+	body, err := r.readUntil(0, r.limits.MaxBodySize)
+	if err != nil {
+		return nil, err
+	}
+	return body[:len(body)-1], nil
+}
+
+// readUntil reads up to and including delim, refusing to read more than maxBody bytes besides delim if
+// maxBody is not negative, nor more than the frame may hold.
+func (r *frameReader) readUntil(delim byte, maxBody int) ([]byte, error) {
+	var data []byte
+	for {
+		chunk, err := r.reader.ReadSlice(delim)
+		if countErr := r.count(len(chunk)); countErr != nil {
+			return nil, countErr
+		}
+		data = append(data, chunk...)
+		if maxBody > 0 && len(data) > maxBody+1 {
+			return nil, frameTooLargeError
+		}
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return data, nil
+	}
+}
+
+func (r *frameReader) checkBody(size int) error {
+	if r.limits.MaxBodySize > 0 && size > r.limits.MaxBodySize {
+		return frameTooLargeError
+	}
+	return nil
+}
+
+// count adds n bytes to the size of the frame being read.
+func (r *frameReader) count(n int) error {
+	r.size += n
+	if r.limits.MaxFrameSize > 0 && r.size > r.limits.MaxFrameSize {
+		return frameTooLargeError
+	}
+	return nil
+}
+
+// frameLimitsHolder holds the limits of a listener, read by the goroutines accepting its connections.
+type frameLimitsHolder struct {
+	limits atomic.Pointer[FrameLimits]
+}
+
+func (h *frameLimitsHolder) SetFrameLimits(limits FrameLimits) {
+	h.limits.Store(&limits)
+}
+
+func (h *frameLimitsHolder) frameLimits() FrameLimits {
+	if limits := h.limits.Load(); limits != nil {
+		return *limits
+	}
+	return FrameLimits{}
+}
@@ -0,0 +1,109 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrameReader_Read(t *testing.T) {
+	input := "\n" +
+		"SEND\ndestination:/pub/cows\r\nmood:happy\\cmoo\ncontent-length:3\n\nmoo\x00" +
+		"SEND\ndestination:/pub/sheep\n\nbaa\x00"
+	r := newFrameReader(strings.NewReader(input), FrameLimits{})
+
+	f, err := r.Read()
+	assert.NoError(t, err)
+	assert.Nil(t, f, "heart-beat")
+
+	f, err = r.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, frame.SEND, f.Command)
+	assert.Equal(t, "/pub/cows", f.Header.Get(frame.Destination))
+	assert.Equal(t, "happy:moo", f.Header.Get("mood"))
+	assert.Equal(t, "moo", string(f.Body))
+
+	// frames following each other are read from the same buffer
+	f, err = r.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, "/pub/sheep", f.Header.Get(frame.Destination))
+	assert.Equal(t, "baa", string(f.Body))
+
+	_, err = newFrameReader(strings.NewReader("MOO\n\n\x00"), FrameLimits{}).Read()
+	assert.ErrorIs(t, err, frame.ErrInvalidCommand)
+}
+
+func TestFrameReader_Limits(t *testing.T) {
+	read := func(input string, limits FrameLimits) error {
+		_, err := newFrameReader(strings.NewReader(input), limits).Read()
+		return err
+	}
+	body := strings.Repeat("x", 5000)
+
+	// a giant content-length is refused before the body is read
+	assert.ErrorIs(t, read("SEND\ncontent-length:999999999\n\n", FrameLimits{MaxBodySize: 1024}), frameTooLargeError)
+	assert.ErrorIs(t, read("SEND\ncontent-length:999999999\n\n", FrameLimits{MaxFrameSize: 1024}), frameTooLargeError)
+
+	assert.ErrorIs(t, read("SEND\n\n"+body+"\x00", FrameLimits{MaxBodySize: 4999}), frameTooLargeError)
+	assert.NoError(t, read("SEND\n\n"+body+"\x00", FrameLimits{MaxBodySize: 5000}))
+	assert.ErrorIs(t, read("SEND\n\n"+body+"\x00", FrameLimits{MaxFrameSize: 5000}), frameTooLargeError)
+	assert.NoError(t, read("SEND\n\n"+body+"\x00", FrameLimits{MaxFrameSize: 5007}))
+
+	// so are giant headers
+	assert.ErrorIs(t, read("SEND\nmoo:"+body+"\n\n\x00", FrameLimits{MaxFrameSize: 1024}), frameTooLargeError)
+}
+
+func TestTcpStompConnection_FrameTooLarge(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	events := make(chan *ConnEvent, 10)
+	conn := NewStompConn(newTcpStompConnection(serverConn, FrameLimits{MaxBodySize: 16}),
+		NewStompConfig(0, []string{"/pub"}), events)
+
+	// the client reads the ERROR frame the server answers with
+	replies := make(chan *frame.Frame, 2)
+	go func() {
+		reader := frame.NewReader(clientConn)
+		for {
+			f, err := reader.Read()
+			if err != nil {
+				close(replies)
+				return
+			}
+			replies <- f
+		}
+	}()
+
+	writer := frame.NewWriter(clientConn)
+	assert.NoError(t, writer.Write(frame.New(frame.CONNECT, frame.AcceptVersion, "1.2")))
+	assert.Equal(t, ConnectionEstablished, (<-events).eventType)
+	assert.Equal(t, frame.CONNECTED, (<-replies).Command)
+
+	f := frame.New(frame.SEND, frame.Destination, "/pub/cows")
+	f.Body = []byte(strings.Repeat("moo", 10))
+	assert.NoError(t, writer.Write(f))
+
+	e := <-events
+	assert.Equal(t, ConnectionClosed, e.eventType)
+	assert.Equal(t, CloseReasonFrameTooLarge, e.Reason())
+	reply := <-replies
+	if assert.NotNil(t, reply) {
+		assert.Equal(t, frame.ERROR, reply.Command)
+		assert.Equal(t, frameTooLargeError.Error(), reply.Header.Get(frame.Message))
+	}
+	assert.Equal(t, closed, conn.(*stompConn).state)
+}
+
+func TestMultiConnectionListener_SetFrameLimits(t *testing.T) {
+	tcp := &tcpConnectionListener{}
+	listener := &multiConnectionListener{listeners: []RawConnectionListener{tcp, NewMockRawConnectionListener()}}
+	listener.SetFrameLimits(FrameLimits{MaxFrameSize: 1024})
+	assert.Equal(t, FrameLimits{MaxFrameSize: 1024}, tcp.frameLimits())
+}
@@ -0,0 +1,258 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/gorilla/websocket"
+)
+
+// JSON WebSocket frame types, sent by clients.
+const (
+	JsonFrameConnect     = "connect"
+	JsonFrameSubscribe   = "subscribe"
+	JsonFrameUnsubscribe = "unsubscribe"
+	JsonFrameSend        = "send"
+	JsonFrameDisconnect  = "disconnect"
+)
+
+// JSON WebSocket frame types, sent by the server.
+const (
+	JsonFrameConnected = "connected"
+	JsonFrameMessage   = "message"
+	JsonFrameReceipt   = "receipt"
+	JsonFrameError     = "error"
+)
+
+// DefaultJsonWebSocketMaxMessageBytes is the largest frame accepted from clients when no limit is configured.
+const DefaultJsonWebSocketMaxMessageBytes = 1 << 20
+
+var jsonFrameInvalid = errors.New("invalid json websocket frame")
+
+// JsonWebSocketConfig maps the channels named in JSON frames onto STOMP destinations, using the same
+// prefixes the fabric endpoint is configured with, so JSON clients share channels with STOMP clients.
+type JsonWebSocketConfig struct {
+	TopicPrefix           string // destination prefix subscriptions are mapped to, e.g. "/topic/"
+	UserQueuePrefix       string // destination prefix private subscriptions are mapped to, e.g. "/user/queue/"
+	AppRequestPrefix      string // destination prefix sends are mapped to, e.g. "/pub/"
+	AppRequestQueuePrefix string // destination prefix private sends are mapped to, e.g. "/pub/queue/"
+	MaxMessageBytes       int64  // largest frame accepted from clients, defaults to DefaultJsonWebSocketMaxMessageBytes
+}
+
+// JsonFrame is a frame of the JSON WebSocket protocol. Clients connect (optionally, with a token), subscribe
+// to, unsubscribe from and send to channels, for example:
+//
+//	{"type":"subscribe","channel":"orders"}
+//	{"type":"send","channel":"orders","payload":{"request":"list"},"receipt":"r-1"}
+//
+// and receive connected, message, receipt and error frames:
+//
+//	{"type":"message","channel":"orders","id":"orders","payload":{"orders":[]}}
+//
+// Private frames use the user queue and private request prefixes, so responses only reach the sender.
+type JsonFrame struct {
+	Type     string          `json:"type"`
+	Channel  string          `json:"channel,omitempty"`
+	Id       string          `json:"id,omitempty"`       // subscription id, defaults to the subscribed destination
+	Private  bool            `json:"private,omitempty"`  // use the private destination of the channel
+	Token    string          `json:"token,omitempty"`    // session token presented on connect
+	Receipt  string          `json:"receipt,omitempty"`  // answered with a receipt frame once the server handled the frame
+	Selector string          `json:"selector,omitempty"` // filters the messages of a subscription, see SelectorHeader
+	Payload  json.RawMessage `json:"payload,omitempty"`
+	Message  string          `json:"message,omitempty"` // error message
+}
+
+// jsonWebSocketConnection speaks the JSON WebSocket protocol to a client and presents it to the STOMP server
+// as a RawConnection, translating JSON frames into the equivalent STOMP frames and back. Clients that do
+// not send a connect frame first are connected without a token.
+type jsonWebSocketConnection struct {
+	conn      *websocket.Conn
+	config    JsonWebSocketConfig
+	connected bool
+	queue     []*frame.Frame // translated frames not yet returned by ReadFrame
+	writeLock sync.Mutex
+	principal string // principal of the request upgraded, see ContextWithPrincipal
+	release   func() // releases the connection of its client IP address, see WebSocketGuard
+}
+
+func newJsonWebSocketConnection(conn *websocket.Conn, config JsonWebSocketConfig) *jsonWebSocketConnection {
+	if config.MaxMessageBytes <= 0 {
+		config.MaxMessageBytes = DefaultJsonWebSocketMaxMessageBytes
+	}
+	conn.SetReadLimit(config.MaxMessageBytes)
+	return &jsonWebSocketConnection{conn: conn, config: config}
+}
+
+// ReadFrame reads a JSON frame and returns the STOMP frames it translates into, one at a time.
+func (c *jsonWebSocketConnection) ReadFrame() (*frame.Frame, error) {
+	for len(c.queue) == 0 {
+		var f JsonFrame
+		if err := c.conn.ReadJSON(&f); err != nil {
+			var syntaxErr *json.SyntaxError
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+				c.writeError(jsonFrameInvalid.Error())
+				return nil, jsonFrameInvalid
+			}
+			return nil, err
+		}
+		if err := c.translate(&f); err != nil {
+			c.writeError(err.Error())
+			return nil, err
+		}
+	}
+	f := c.queue[0]
+	c.queue = c.queue[1:]
+	return f, nil
+}
+
+// WriteFrame translates a STOMP frame sent by the server into the matching JSON frame.
+func (c *jsonWebSocketConnection) WriteFrame(f *frame.Frame) error {
+	if f == nil {
+		// heart-beats are not negotiated, WebSocket pings keep the connection alive.
+		return nil
+	}
+	switch f.Command {
+	case frame.CONNECTED:
+		return c.write(&JsonFrame{Type: JsonFrameConnected})
+	case frame.MESSAGE:
+		return c.write(c.message(f))
+	case frame.RECEIPT:
+		return c.write(&JsonFrame{Type: JsonFrameReceipt, Receipt: f.Header.Get(frame.ReceiptId)})
+	case frame.ERROR:
+		return c.writeError(f.Header.Get(frame.Message))
+	}
+	return nil
+}
+
+// SetReadDeadline sets the deadline of the underlying WebSocket.
+func (c *jsonWebSocketConnection) SetReadDeadline(t time.Time) {
+	_ = c.conn.SetReadDeadline(t)
+}
+
+// Close closes the WebSocket.
+func (c *jsonWebSocketConnection) Close() error {
+	if c.release != nil {
+		c.release()
+	}
+	return c.conn.Close()
+}
+
+// RemoteAddr returns the address of the client.
+func (c *jsonWebSocketConnection) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *jsonWebSocketConnection) translate(f *JsonFrame) error {
+	if !c.connected {
+		c.connected = true
+		connect := frame.New(frame.CONNECT,
+			frame.AcceptVersion, "1.2",
+			frame.Host, "ranch",
+			frame.HeartBeat, "0,0")
+		if f.Token != "" {
+			connect.Header.Add("Authorization", "Bearer "+f.Token)
+		}
+		c.queue = append(c.queue, connect)
+		if f.Type == JsonFrameConnect {
+			return nil
+		}
+	}
+
+	var stompFrame *frame.Frame
+	switch f.Type {
+	case JsonFrameSubscribe:
+		if f.Channel == "" {
+			return fmt.Errorf("%s frame without a channel", f.Type)
+		}
+		destination := c.destination(f.Channel, f.Private, c.config.TopicPrefix, c.config.UserQueuePrefix)
+		stompFrame = frame.New(frame.SUBSCRIBE,
+			frame.Id, subscriptionId(f, destination),
+			frame.Destination, destination,
+			frame.Ack, "auto")
+		if f.Selector != "" {
+			stompFrame.Header.Add(SelectorHeader, f.Selector)
+		}
+	case JsonFrameUnsubscribe:
+		if f.Channel == "" && f.Id == "" {
+			return fmt.Errorf("%s frame without a channel or id", f.Type)
+		}
+		destination := c.destination(f.Channel, f.Private, c.config.TopicPrefix, c.config.UserQueuePrefix)
+		stompFrame = frame.New(frame.UNSUBSCRIBE, frame.Id, subscriptionId(f, destination))
+	case JsonFrameSend:
+		if f.Channel == "" {
+			return fmt.Errorf("%s frame without a channel", f.Type)
+		}
+		stompFrame = frame.New(frame.SEND,
+			frame.Destination,
+			c.destination(f.Channel, f.Private, c.config.AppRequestPrefix, c.config.AppRequestQueuePrefix),
+			frame.ContentLength, strconv.Itoa(len(f.Payload)),
+			frame.ContentType, "application/json;charset=UTF-8")
+		stompFrame.Body = f.Payload
+	case JsonFrameDisconnect:
+		stompFrame = frame.New(frame.DISCONNECT)
+	case JsonFrameConnect:
+		return fmt.Errorf("already connected")
+	default:
+		return fmt.Errorf("unsupported frame type '%s'", f.Type)
+	}
+	if f.Receipt != "" {
+		stompFrame.Header.Set(frame.Receipt, f.Receipt)
+	}
+	c.queue = append(c.queue, stompFrame)
+	return nil
+}
+
+func (c *jsonWebSocketConnection) destination(channel string, private bool, prefix, privatePrefix string) string {
+	if private {
+		return privatePrefix + channel
+	}
+	return prefix + channel
+}
+
+func subscriptionId(f *JsonFrame, destination string) string {
+	if f.Id != "" {
+		return f.Id
+	}
+	return destination
+}
+
+// message translates a MESSAGE frame, JSON bodies are passed on as is and other bodies as strings.
+func (c *jsonWebSocketConnection) message(f *frame.Frame) *JsonFrame {
+	msg := &JsonFrame{Type: JsonFrameMessage, Id: f.Header.Get(frame.Subscription)}
+	destination := f.Header.Get(frame.Destination)
+	switch {
+	case c.config.UserQueuePrefix != "" && strings.HasPrefix(destination, c.config.UserQueuePrefix):
+		msg.Channel, msg.Private = destination[len(c.config.UserQueuePrefix):], true
+	case c.config.TopicPrefix != "" && strings.HasPrefix(destination, c.config.TopicPrefix):
+		msg.Channel = destination[len(c.config.TopicPrefix):]
+	default:
+		msg.Channel = destination
+	}
+	if json.Valid(f.Body) {
+		msg.Payload = f.Body
+	} else {
+		msg.Payload, _ = json.Marshal(string(f.Body))
+	}
+	return msg
+}
+
+func (c *jsonWebSocketConnection) writeError(message string) error {
+	return c.write(&JsonFrame{Type: JsonFrameError, Message: message})
+}
+
+func (c *jsonWebSocketConnection) write(f *JsonFrame) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	return c.conn.WriteJSON(f)
+}
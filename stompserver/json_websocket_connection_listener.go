@@ -0,0 +1,103 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+type jsonWebSocketConnectionListener struct {
+	guardHolder
+	connections  chan RawConnection
+	done         chan struct{}
+	closeOnce    sync.Once
+	closeChannel chan *Connection
+	openChannel  chan *Connection
+}
+
+// NewJsonWebSocketConnectionListener accepts clients speaking the JSON WebSocket protocol (see JsonFrame) at
+// endpoint on an existing router, every client is presented to the STOMP server as a regular STOMP
+// connection. See JsonWebSocketConfig for how channels map onto destinations.
+func NewJsonWebSocketConnectionListener(handler *mux.Router, endpoint string, allowedOrigins []string,
+	config JsonWebSocketConfig, logger *slog.Logger) (RawConnectionListener, error) {
+	l := &jsonWebSocketConnectionListener{
+		connections:  make(chan RawConnection),
+		done:         make(chan struct{}),
+		openChannel:  make(chan *Connection),
+		closeChannel: make(chan *Connection),
+	}
+
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin: func(r *http.Request) bool {
+			allowed := append(append([]string(nil), allowedOrigins...), l.webSocketGuard().AllowedOrigins...)
+			return allowsOrigin(r, allowed)
+		},
+	}
+
+	handler.HandleFunc(endpoint, func(writer http.ResponseWriter, request *http.Request) {
+		select {
+		case <-l.done:
+			http.Error(writer, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		default:
+		}
+		release, status := l.admit(request)
+		if status != 0 {
+			http.Error(writer, http.StatusText(status), status)
+			return
+		}
+		conn, err := upgrader.Upgrade(writer, request, nil)
+		if err != nil {
+			release()
+			// the upgrader has already answered the request.
+			if logger != nil {
+				logger.Warn("[ranch] failed json websocket connection", "remote", request.RemoteAddr,
+					"error", err.Error())
+			}
+			return
+		}
+		jsonConn := newJsonWebSocketConnection(conn, config)
+		jsonConn.principal = PrincipalFromContext(request.Context())
+		jsonConn.release = release
+		select {
+		case l.connections <- jsonConn:
+		case <-l.done:
+			jsonConn.Close()
+		}
+	})
+	return l, nil
+}
+
+func (l *jsonWebSocketConnectionListener) GetConnectionOpenChannel() chan *Connection {
+	return l.openChannel
+}
+
+func (l *jsonWebSocketConnectionListener) GetConnectionCloseChannel() chan *Connection {
+	return l.closeChannel
+}
+
+func (l *jsonWebSocketConnectionListener) Accept() (RawConnection, error) {
+	select {
+	case conn := <-l.connections:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops accepting clients, the route stays registered and answers with 503 Service Unavailable.
+func (l *jsonWebSocketConnectionListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+	})
+	return nil
+}
@@ -0,0 +1,136 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func startTestJsonWebSocketServer(t *testing.T) (*stompServer, string) {
+	router := mux.NewRouter()
+	listener, err := NewJsonWebSocketConnectionListener(router, "/ws", nil, JsonWebSocketConfig{
+		TopicPrefix:           "/topic/",
+		UserQueuePrefix:       "/user/queue/",
+		AppRequestPrefix:      "/pub/",
+		AppRequestQueuePrefix: "/pub/queue/",
+	}, nil)
+	assert.NoError(t, err)
+	server := NewStompServer(listener, NewStompConfig(0, []string{"/pub/"})).(*stompServer)
+	go server.Start()
+	httpServer := httptest.NewServer(router)
+	t.Cleanup(func() {
+		server.Stop()
+		httpServer.Close()
+	})
+	return server, "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/ws"
+}
+
+func dialTestJsonWebSocket(t *testing.T, url string) *websocket.Conn {
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func receiveJsonFrame(t *testing.T, conn *websocket.Conn) *JsonFrame {
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var f JsonFrame
+	if !assert.NoError(t, conn.ReadJSON(&f)) {
+		t.FailNow()
+	}
+	return &f
+}
+
+func TestJsonWebSocketConnection(t *testing.T) {
+	server, url := startTestJsonWebSocketServer(t)
+
+	subscribed := make(chan string, 2)
+	server.OnSubscribeEvent(func(conId string, subId string, destination string, _ *frame.Frame) {
+		subscribed <- subId + " " + destination
+	})
+	requests := make(chan string, 1)
+	server.OnApplicationRequest(func(destination string, message []byte, connectionId string) {
+		requests <- destination + " " + string(message)
+	})
+
+	conn := dialTestJsonWebSocket(t, url)
+	assert.NoError(t, conn.WriteJSON(&JsonFrame{Type: JsonFrameConnect, Token: "secret"}))
+	assert.Equal(t, JsonFrameConnected, receiveJsonFrame(t, conn).Type)
+
+	assert.NoError(t, conn.WriteJSON(&JsonFrame{Type: JsonFrameSubscribe, Channel: "orders", Receipt: "s-1"}))
+	f := receiveJsonFrame(t, conn)
+	assert.Equal(t, JsonFrameReceipt, f.Type)
+	assert.Equal(t, "s-1", f.Receipt)
+	assert.Equal(t, "/topic/orders /topic/orders", <-subscribed)
+
+	server.SendMessage("/topic/orders", []byte(`{"id":1}`))
+	f = receiveJsonFrame(t, conn)
+	assert.Equal(t, JsonFrameMessage, f.Type)
+	assert.Equal(t, "orders", f.Channel)
+	assert.JSONEq(t, `{"id":1}`, string(f.Payload))
+
+	server.SendMessage("/topic/orders", []byte("plain text"))
+	assert.JSONEq(t, `"plain text"`, string(receiveJsonFrame(t, conn).Payload))
+
+	assert.NoError(t, conn.WriteJSON(&JsonFrame{Type: JsonFrameSend, Channel: "orders", Private: true,
+		Payload: []byte(`{"request":"list"}`), Receipt: "r-1"}))
+	assert.Equal(t, "r-1", receiveJsonFrame(t, conn).Receipt)
+	assert.Equal(t, `/pub/queue/orders {"request":"list"}`, <-requests)
+
+	assert.NoError(t, conn.WriteJSON(&JsonFrame{Type: "shout"}))
+	f = receiveJsonFrame(t, conn)
+	assert.Equal(t, JsonFrameError, f.Type)
+	assert.Contains(t, f.Message, "shout")
+}
+
+func TestJsonWebSocketConnection_ImplicitConnect(t *testing.T) {
+	server, url := startTestJsonWebSocketServer(t)
+
+	subscribed := make(chan string, 1)
+	server.OnSubscribeEvent(func(conId string, subId string, destination string, _ *frame.Frame) {
+		subscribed <- subId + " " + destination
+	})
+
+	conn := dialTestJsonWebSocket(t, url)
+	assert.NoError(t, conn.WriteJSON(&JsonFrame{Type: JsonFrameSubscribe, Channel: "orders", Id: "sub-1", Private: true}))
+	assert.Equal(t, JsonFrameConnected, receiveJsonFrame(t, conn).Type)
+	assert.Equal(t, "sub-1 /user/queue/orders", <-subscribed)
+}
+
+func TestJsonWebSocketConnection_InvalidFrame(t *testing.T) {
+	_, url := startTestJsonWebSocketServer(t)
+
+	conn := dialTestJsonWebSocket(t, url)
+	assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("CONNECT\n\n\x00")))
+	f := receiveJsonFrame(t, conn)
+	assert.Equal(t, JsonFrameError, f.Type)
+	assert.Equal(t, jsonFrameInvalid.Error(), f.Message)
+
+	_, _, err := conn.ReadMessage()
+	assert.Error(t, err)
+}
+
+func TestJsonWebSocketConnectionListener_Close(t *testing.T) {
+	router := mux.NewRouter()
+	listener, _ := NewJsonWebSocketConnectionListener(router, "/ws", nil, JsonWebSocketConfig{}, nil)
+	assert.NoError(t, listener.Close())
+
+	_, err := listener.Accept()
+	assert.Error(t, err)
+
+	rsp := httptest.NewRecorder()
+	router.ServeHTTP(rsp, httptest.NewRequest(http.MethodGet, "/ws", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rsp.Code)
+}
@@ -0,0 +1,200 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"bytes"
+	"io"
+
+	packets5 "github.com/eclipse/paho.golang/packets"
+	packets311 "github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// mqttPacket holds the fields of an MQTT packet the connection cares about, whatever the protocol version.
+type mqttPacket struct {
+	kind        byte // the control packet type
+	packetId    uint16
+	qos         byte
+	topic       string   // the topic of a PUBLISH
+	payload     []byte   // the payload of a PUBLISH
+	topics      []string // the topic filters of a SUBSCRIBE or UNSUBSCRIBE
+	codes       []byte   // the return or reason codes of a CONNACK, SUBACK, UNSUBACK or DISCONNECT
+	keepAlive   uint16   // the keep alive interval of a CONNECT in seconds
+	username    string
+	password    []byte
+	hasUsername bool
+	hasPassword bool
+	refusal     byte   // the CONNACK code a CONNECT must be refused with, mqttSuccess if it is acceptable
+	reason      string // the reason string of a DISCONNECT, only sent to MQTT 5 clients
+}
+
+// mqttCodec decodes and encodes the packets of one MQTT protocol version.
+type mqttCodec interface {
+	decode(r io.Reader) (*mqttPacket, error)
+	encode(p *mqttPacket) []byte // returns nil if the version has no such packet
+}
+
+// mqtt311Codec speaks MQTT 3.1.1 using the packets of the Eclipse Paho 3.1.1 client.
+type mqtt311Codec struct{}
+
+func (mqtt311Codec) decode(r io.Reader) (*mqttPacket, error) {
+	cp, err := packets311.ReadPacket(r)
+	if err != nil {
+		return nil, err
+	}
+	switch p := cp.(type) {
+	case *packets311.ConnectPacket:
+		packet := &mqttPacket{
+			kind:        mqttConnect,
+			keepAlive:   p.Keepalive,
+			username:    p.Username,
+			password:    p.Password,
+			hasUsername: p.UsernameFlag,
+			hasPassword: p.PasswordFlag,
+		}
+		switch code := p.Validate(); code {
+		case packets311.Accepted:
+		case packets311.ErrProtocolViolation:
+			return nil, mqttProtocolError
+		default:
+			packet.refusal = code
+		}
+		return packet, nil
+	case *packets311.PublishPacket:
+		return &mqttPacket{kind: mqttPublish, packetId: p.MessageID, qos: p.Qos, topic: p.TopicName, payload: p.Payload}, nil
+	case *packets311.PubrelPacket:
+		return &mqttPacket{kind: mqttPubrel, packetId: p.MessageID}, nil
+	case *packets311.SubscribePacket:
+		return &mqttPacket{kind: mqttSubscribe, packetId: p.MessageID, topics: p.Topics}, nil
+	case *packets311.UnsubscribePacket:
+		return &mqttPacket{kind: mqttUnsubscribe, packetId: p.MessageID, topics: p.Topics}, nil
+	case *packets311.PingreqPacket:
+		return &mqttPacket{kind: mqttPingreq}, nil
+	case *packets311.PubackPacket:
+		return &mqttPacket{kind: mqttPuback, packetId: p.MessageID}, nil
+	case *packets311.PubrecPacket:
+		return &mqttPacket{kind: mqttPubrec, packetId: p.MessageID}, nil
+	case *packets311.PubcompPacket:
+		return &mqttPacket{kind: mqttPubcomp, packetId: p.MessageID}, nil
+	}
+	// packets only servers send.
+	return nil, mqttProtocolError
+}
+
+func (mqtt311Codec) encode(p *mqttPacket) []byte {
+	var cp packets311.ControlPacket
+	switch p.kind {
+	case mqttConnack:
+		connack := packets311.NewControlPacket(packets311.Connack).(*packets311.ConnackPacket)
+		connack.ReturnCode = p.codes[0]
+		cp = connack
+	case mqttPublish:
+		publish := packets311.NewControlPacket(packets311.Publish).(*packets311.PublishPacket)
+		publish.TopicName, publish.Payload = p.topic, p.payload
+		cp = publish
+	case mqttPuback:
+		cp = &packets311.PubackPacket{FixedHeader: packets311.FixedHeader{MessageType: packets311.Puback}, MessageID: p.packetId}
+	case mqttPubrec:
+		cp = &packets311.PubrecPacket{FixedHeader: packets311.FixedHeader{MessageType: packets311.Pubrec}, MessageID: p.packetId}
+	case mqttPubcomp:
+		cp = &packets311.PubcompPacket{FixedHeader: packets311.FixedHeader{MessageType: packets311.Pubcomp}, MessageID: p.packetId}
+	case mqttSuback:
+		suback := packets311.NewControlPacket(packets311.Suback).(*packets311.SubackPacket)
+		suback.MessageID, suback.ReturnCodes = p.packetId, p.codes
+		cp = suback
+	case mqttUnsuback:
+		unsuback := packets311.NewControlPacket(packets311.Unsuback).(*packets311.UnsubackPacket)
+		unsuback.MessageID = p.packetId
+		cp = unsuback
+	case mqttPingresp:
+		cp = packets311.NewControlPacket(packets311.Pingresp)
+	default:
+		// MQTT 3.1.1 servers cannot send a DISCONNECT.
+		return nil
+	}
+	var b bytes.Buffer
+	_ = cp.Write(&b)
+	return b.Bytes()
+}
+
+// mqtt5Codec speaks MQTT 5 using the packets of the Eclipse Paho MQTT 5 client.
+type mqtt5Codec struct{}
+
+func (mqtt5Codec) decode(r io.Reader) (*mqttPacket, error) {
+	cp, err := packets5.ReadPacket(r)
+	if err != nil {
+		return nil, err
+	}
+	switch p := cp.Content.(type) {
+	case *packets5.Connect:
+		if p.ProtocolName != "MQTT" {
+			return nil, mqttProtocolError
+		}
+		return &mqttPacket{
+			kind:        mqttConnect,
+			keepAlive:   p.KeepAlive,
+			username:    p.Username,
+			password:    p.Password,
+			hasUsername: p.UsernameFlag,
+			hasPassword: p.PasswordFlag,
+		}, nil
+	case *packets5.Publish:
+		return &mqttPacket{kind: mqttPublish, packetId: p.PacketID, qos: p.QoS, topic: p.Topic, payload: p.Payload}, nil
+	case *packets5.Pubrel:
+		return &mqttPacket{kind: mqttPubrel, packetId: p.PacketID}, nil
+	case *packets5.Subscribe:
+		topics := make([]string, len(p.Subscriptions))
+		for i, sub := range p.Subscriptions {
+			topics[i] = sub.Topic
+		}
+		return &mqttPacket{kind: mqttSubscribe, packetId: p.PacketID, topics: topics}, nil
+	case *packets5.Unsubscribe:
+		return &mqttPacket{kind: mqttUnsubscribe, packetId: p.PacketID, topics: p.Topics}, nil
+	case *packets5.Pingreq:
+		return &mqttPacket{kind: mqttPingreq}, nil
+	case *packets5.Puback:
+		return &mqttPacket{kind: mqttPuback, packetId: p.PacketID}, nil
+	case *packets5.Pubrec:
+		return &mqttPacket{kind: mqttPubrec, packetId: p.PacketID}, nil
+	case *packets5.Pubcomp:
+		return &mqttPacket{kind: mqttPubcomp, packetId: p.PacketID}, nil
+	}
+	// packets only servers send, and AUTH as extended authentication is not supported.
+	return nil, mqttProtocolError
+}
+
+func (mqtt5Codec) encode(p *mqttPacket) []byte {
+	var packet io.WriterTo
+	switch p.kind {
+	case mqttConnack:
+		// tell the client what is not supported, so it does not try.
+		unavailable := byte(0)
+		packet = &packets5.Connack{ReasonCode: p.codes[0], Properties: &packets5.Properties{
+			RetainAvailable:      &unavailable,
+			WildcardSubAvailable: &unavailable,
+			SharedSubAvailable:   &unavailable,
+		}}
+	case mqttPublish:
+		packet = &packets5.Publish{Topic: p.topic, Payload: p.payload, Properties: &packets5.Properties{}}
+	case mqttPuback:
+		packet = &packets5.Puback{PacketID: p.packetId, Properties: &packets5.Properties{}}
+	case mqttPubrec:
+		packet = &packets5.Pubrec{PacketID: p.packetId, Properties: &packets5.Properties{}}
+	case mqttPubcomp:
+		packet = &packets5.Pubcomp{PacketID: p.packetId, Properties: &packets5.Properties{}}
+	case mqttSuback:
+		packet = &packets5.Suback{PacketID: p.packetId, Reasons: p.codes, Properties: &packets5.Properties{}}
+	case mqttUnsuback:
+		packet = &packets5.Unsuback{PacketID: p.packetId, Reasons: p.codes, Properties: &packets5.Properties{}}
+	case mqttPingresp:
+		packet = &packets5.Pingresp{}
+	case mqttDisconnect:
+		packet = &packets5.Disconnect{ReasonCode: p.codes[0], Properties: &packets5.Properties{ReasonString: p.reason}}
+	default:
+		return nil
+	}
+	var b bytes.Buffer
+	_, _ = packet.WriteTo(&b)
+	return b.Bytes()
+}
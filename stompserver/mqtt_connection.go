@@ -0,0 +1,438 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// MQTT control packet types.
+const (
+	mqttConnect     = 1
+	mqttConnack     = 2
+	mqttPublish     = 3
+	mqttPuback      = 4
+	mqttPubrec      = 5
+	mqttPubrel      = 6
+	mqttPubcomp     = 7
+	mqttSubscribe   = 8
+	mqttSuback      = 9
+	mqttUnsubscribe = 10
+	mqttUnsuback    = 11
+	mqttPingreq     = 12
+	mqttPingresp    = 13
+	mqttDisconnect  = 14
+)
+
+// MQTT protocol levels.
+const (
+	mqttV311 = 4
+	mqttV5   = 5
+)
+
+// MQTT reason codes, the 3.1.1 CONNACK return codes are used as is.
+const (
+	mqttSuccess                  = 0x00
+	mqttV311BadProtocolVersion   = 0x01
+	mqttV311NotAuthorized        = 0x05
+	mqttV311SubscribeFailure     = 0x80
+	mqttUnspecifiedError         = 0x80
+	mqttNotAuthorized            = 0x87
+	mqttTopicFilterInvalid       = 0x8F
+	mqttSharedSubsNotSupported   = 0x9E
+	mqttWildcardSubsNotSupported = 0xA2
+)
+
+// DefaultMqttMaxPacketBytes is the largest MQTT packet accepted when no limit is configured.
+const DefaultMqttMaxPacketBytes = 1 << 20
+
+var (
+	mqttProtocolError       = errors.New("mqtt protocol violation")
+	mqttUnsupportedProtocol = errors.New("unsupported mqtt protocol version")
+	mqttConnectionRefused   = errors.New("mqtt connection refused")
+)
+
+// MqttConfig maps MQTT topics onto STOMP destinations. A client subscribing to topic "orders" is subscribed
+// to TopicPrefix + "orders", and publishing to "orders" sends to AppRequestPrefix + "orders", so MQTT
+// clients use the same bus channels as STOMP clients.
+type MqttConfig struct {
+	TopicPrefix      string // destination prefix subscriptions are mapped to, e.g. "/topic/"
+	AppRequestPrefix string // destination prefix publishes are mapped to, e.g. "/pub/"
+	MaxPacketBytes   int    // largest packet accepted from clients, defaults to DefaultMqttMaxPacketBytes
+}
+
+// mqttConnection speaks MQTT 3.1.1 and 5 to a client and presents it to the STOMP server as a RawConnection,
+// translating MQTT packets into the equivalent STOMP frames and back. Packets are decoded and encoded with
+// the Eclipse Paho packet libraries, picked by the protocol level of the client's CONNECT. Messages are
+// delivered to MQTT clients at QoS 0, publishes are accepted at any QoS and acknowledged once the STOMP
+// server accepted them. Wildcard and shared subscriptions, retained messages and wills are not supported.
+type mqttConnection struct {
+	conn        net.Conn
+	reader      *bufio.Reader
+	config      MqttConfig
+	version     byte
+	codec       mqttCodec
+	keepAlive   time.Duration
+	connectSeen bool
+	connected   bool
+	queue       []*frame.Frame    // translated frames not yet returned by ReadFrame
+	receipts    map[string][]byte // packets to write once the STOMP receipt with the key arrives
+	inflight    map[uint16]bool   // QoS 2 publishes received but not released yet
+	lock        sync.Mutex
+	writeLock   sync.Mutex
+}
+
+func newMqttConnection(conn net.Conn, config MqttConfig) *mqttConnection {
+	if config.MaxPacketBytes <= 0 {
+		config.MaxPacketBytes = DefaultMqttMaxPacketBytes
+	}
+	return &mqttConnection{
+		conn:     conn,
+		reader:   bufio.NewReader(conn),
+		config:   config,
+		receipts: make(map[string][]byte),
+		inflight: make(map[uint16]bool),
+	}
+}
+
+// ReadFrame reads MQTT packets until one translates into a STOMP frame. Keep alive pings and QoS 2
+// handshakes are answered directly.
+func (c *mqttConnection) ReadFrame() (*frame.Frame, error) {
+	for len(c.queue) == 0 {
+		packet, err := c.readPacket()
+		if err != nil {
+			return nil, err
+		}
+		if err = c.translate(packet); err != nil {
+			return nil, err
+		}
+	}
+	f := c.queue[0]
+	c.queue = c.queue[1:]
+	return f, nil
+}
+
+// SetReadDeadline is ignored, MQTT connections are timed out by their keep alive interval instead.
+func (c *mqttConnection) SetReadDeadline(t time.Time) {}
+
+// WriteFrame translates a STOMP frame sent by the server into the matching MQTT packet.
+func (c *mqttConnection) WriteFrame(f *frame.Frame) error {
+	if f == nil {
+		// STOMP heart-beats have no MQTT equivalent, clients keep the connection alive with pings.
+		return nil
+	}
+	switch f.Command {
+	case frame.CONNECTED:
+		c.lock.Lock()
+		c.connected = true
+		c.lock.Unlock()
+		return c.send(&mqttPacket{kind: mqttConnack, codes: []byte{mqttSuccess}})
+	case frame.MESSAGE:
+		return c.send(&mqttPacket{
+			kind:    mqttPublish,
+			topic:   strings.TrimPrefix(f.Header.Get(frame.Destination), c.config.TopicPrefix),
+			payload: f.Body,
+		})
+	case frame.RECEIPT:
+		c.lock.Lock()
+		packet, ok := c.receipts[f.Header.Get(frame.ReceiptId)]
+		delete(c.receipts, f.Header.Get(frame.ReceiptId))
+		c.lock.Unlock()
+		if ok {
+			return c.write(packet)
+		}
+	case frame.ERROR:
+		return c.writeError(f.Header.Get(frame.Message))
+	}
+	return nil
+}
+
+// Close closes the network connection.
+func (c *mqttConnection) Close() error {
+	return c.conn.Close()
+}
+
+// RemoteAddr returns the address of the client.
+func (c *mqttConnection) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// readPacket reads the next packet, refusing packets larger than the configured limit before reading them.
+func (c *mqttConnection) readPacket() (*mqttPacket, error) {
+	if c.keepAlive > 0 {
+		// the server may close a connection that stays silent for one and a half keep alive intervals.
+		_ = c.conn.SetReadDeadline(time.Now().Add(c.keepAlive * 3 / 2))
+	} else {
+		_ = c.conn.SetReadDeadline(time.Time{})
+	}
+	packetType, headerSize, length, err := c.peekFixedHeader()
+	if err != nil {
+		return nil, err
+	}
+	if length > c.config.MaxPacketBytes {
+		return nil, fmt.Errorf("mqtt packet of %d bytes exceeds the limit of %d", length, c.config.MaxPacketBytes)
+	}
+	if c.codec == nil {
+		if packetType != mqttConnect {
+			return nil, mqttProtocolError
+		}
+		if err = c.pickCodec(headerSize, length); err != nil {
+			return nil, err
+		}
+	}
+	if packetType == mqttDisconnect {
+		// the connection ends whatever the reason, so it is not decoded.
+		_, err = c.reader.Discard(headerSize + length)
+		return &mqttPacket{kind: mqttDisconnect}, err
+	}
+	return c.codec.decode(c.reader)
+}
+
+// peekFixedHeader returns the packet type, the size of the fixed header and the remaining length of the
+// next packet without consuming it.
+func (c *mqttConnection) peekFixedHeader() (byte, int, int, error) {
+	for size := 2; size <= 5; size++ {
+		header, err := c.reader.Peek(size)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		if header[size-1]&0x80 == 0 {
+			length := 0
+			for i := size - 1; i > 0; i-- {
+				length = length<<7 | int(header[i]&0x7f)
+			}
+			return header[0] >> 4, size, length, nil
+		}
+	}
+	return 0, 0, 0, mqttProtocolError
+}
+
+// pickCodec picks the codec for the protocol level of the CONNECT packet about to be read, refusing
+// clients of other versions.
+func (c *mqttConnection) pickCodec(headerSize, length int) error {
+	// the variable header starts with the protocol name, followed by the protocol level.
+	if length < 3 {
+		return mqttProtocolError
+	}
+	b, err := c.reader.Peek(headerSize + 2)
+	if err != nil {
+		return err
+	}
+	levelOffset := 2 + int(binary.BigEndian.Uint16(b[headerSize:]))
+	if levelOffset >= length {
+		return mqttProtocolError
+	}
+	if b, err = c.reader.Peek(headerSize + levelOffset + 1); err != nil {
+		return mqttProtocolError
+	}
+	switch level := b[headerSize+levelOffset]; level {
+	case mqttV311:
+		c.version, c.codec = level, mqtt311Codec{}
+	case mqttV5:
+		c.version, c.codec = level, mqtt5Codec{}
+	default:
+		// answer in the oldest format, which every client understands.
+		c.version, c.codec = mqttV311, mqtt311Codec{}
+		_ = c.send(&mqttPacket{kind: mqttConnack, codes: []byte{mqttV311BadProtocolVersion}})
+		return mqttUnsupportedProtocol
+	}
+	return nil
+}
+
+func (c *mqttConnection) translate(p *mqttPacket) error {
+	switch p.kind {
+	case mqttConnect:
+		if c.connectSeen {
+			return mqttProtocolError
+		}
+		return c.handleConnect(p)
+	case mqttPublish:
+		return c.handlePublish(p)
+	case mqttPubrel:
+		c.lock.Lock()
+		delete(c.inflight, p.packetId)
+		c.lock.Unlock()
+		return c.send(&mqttPacket{kind: mqttPubcomp, packetId: p.packetId})
+	case mqttSubscribe:
+		return c.handleSubscribe(p)
+	case mqttUnsubscribe:
+		return c.handleUnsubscribe(p)
+	case mqttPingreq:
+		return c.send(&mqttPacket{kind: mqttPingresp})
+	case mqttDisconnect:
+		c.queue = append(c.queue, frame.New(frame.DISCONNECT))
+		return nil
+	case mqttPuback, mqttPubrec, mqttPubcomp:
+		// acknowledgements of publishes at QoS 1 and 2, only QoS 0 is sent to clients.
+		return nil
+	}
+	return mqttProtocolError
+}
+
+func (c *mqttConnection) handleConnect(p *mqttPacket) error {
+	c.connectSeen = true
+	if p.refusal != mqttSuccess {
+		_ = c.send(&mqttPacket{kind: mqttConnack, codes: []byte{p.refusal}})
+		return mqttConnectionRefused
+	}
+	c.keepAlive = time.Duration(p.keepAlive) * time.Second
+	// the client id is not needed, sessions are not kept.
+	f := frame.New(frame.CONNECT,
+		frame.AcceptVersion, "1.2",
+		frame.Host, "ranch",
+		frame.HeartBeat, "0,0")
+	if p.hasUsername {
+		f.Header.Add(frame.Login, p.username)
+	}
+	if p.hasPassword {
+		f.Header.Add(frame.Passcode, string(p.password))
+	}
+	c.queue = append(c.queue, f)
+	return nil
+}
+
+func (c *mqttConnection) handlePublish(p *mqttPacket) error {
+	if p.qos > 2 || p.topic == "" || strings.ContainsAny(p.topic, "+#") {
+		return mqttProtocolError
+	}
+
+	f := frame.New(frame.SEND,
+		frame.Destination, c.config.AppRequestPrefix+p.topic,
+		frame.ContentLength, strconv.Itoa(len(p.payload)),
+		frame.ContentType, "application/json;charset=UTF-8")
+	f.Body = p.payload
+
+	switch p.qos {
+	case 1:
+		c.expectReceipt(f, fmt.Sprintf("mqtt-puback-%d", p.packetId), &mqttPacket{kind: mqttPuback, packetId: p.packetId})
+	case 2:
+		pubrec := &mqttPacket{kind: mqttPubrec, packetId: p.packetId}
+		c.lock.Lock()
+		duplicate := c.inflight[p.packetId]
+		c.inflight[p.packetId] = true
+		c.lock.Unlock()
+		if duplicate {
+			// already relayed, the client did not see our PUBREC.
+			return c.send(pubrec)
+		}
+		c.expectReceipt(f, fmt.Sprintf("mqtt-pubrec-%d", p.packetId), pubrec)
+	}
+	c.queue = append(c.queue, f)
+	return nil
+}
+
+func (c *mqttConnection) handleSubscribe(p *mqttPacket) error {
+	if len(p.topics) == 0 {
+		return mqttProtocolError
+	}
+	var codes []byte
+	var frames []*frame.Frame
+	for _, topic := range p.topics {
+		// every subscription is granted QoS 0, whatever the client asked for.
+		if code := c.checkTopicFilter(topic); code != mqttSuccess {
+			codes = append(codes, code)
+			continue
+		}
+		codes = append(codes, mqttSuccess)
+		frames = append(frames, frame.New(frame.SUBSCRIBE,
+			frame.Id, topic,
+			frame.Destination, c.config.TopicPrefix+topic,
+			frame.Ack, "auto"))
+	}
+	suback := &mqttPacket{kind: mqttSuback, packetId: p.packetId, codes: codes}
+	return c.queueWithAck(frames, fmt.Sprintf("mqtt-suback-%d", p.packetId), suback)
+}
+
+func (c *mqttConnection) handleUnsubscribe(p *mqttPacket) error {
+	if len(p.topics) == 0 {
+		return mqttProtocolError
+	}
+	frames := make([]*frame.Frame, len(p.topics))
+	codes := make([]byte, len(p.topics))
+	for i, topic := range p.topics {
+		frames[i] = frame.New(frame.UNSUBSCRIBE, frame.Id, topic)
+		codes[i] = mqttSuccess
+	}
+	unsuback := &mqttPacket{kind: mqttUnsuback, packetId: p.packetId, codes: codes}
+	return c.queueWithAck(frames, fmt.Sprintf("mqtt-unsuback-%d", p.packetId), unsuback)
+}
+
+// queueWithAck queues frames and writes the acknowledgement once the STOMP server handled the last one.
+// Frames are handled in order, so its receipt means they all were.
+func (c *mqttConnection) queueWithAck(frames []*frame.Frame, receipt string, ack *mqttPacket) error {
+	if len(frames) == 0 {
+		return c.send(ack)
+	}
+	c.expectReceipt(frames[len(frames)-1], receipt, ack)
+	c.queue = append(c.queue, frames...)
+	return nil
+}
+
+func (c *mqttConnection) expectReceipt(f *frame.Frame, receipt string, ack *mqttPacket) {
+	f.Header.Set(frame.Receipt, receipt)
+	c.lock.Lock()
+	c.receipts[receipt] = c.codec.encode(ack)
+	c.lock.Unlock()
+}
+
+// checkTopicFilter returns the reason a subscription to the topic filter is refused, or mqttSuccess.
+func (c *mqttConnection) checkTopicFilter(topic string) byte {
+	code := byte(mqttSuccess)
+	switch {
+	case topic == "" || strings.HasPrefix(topic, "$"):
+		code = mqttTopicFilterInvalid
+		if strings.HasPrefix(topic, "$share/") {
+			code = mqttSharedSubsNotSupported
+		}
+	case strings.ContainsAny(topic, "+#"):
+		code = mqttWildcardSubsNotSupported
+	}
+	if code != mqttSuccess && c.version != mqttV5 {
+		return mqttV311SubscribeFailure
+	}
+	return code
+}
+
+// writeError reports a STOMP error, refusing the connection if it was not accepted yet. MQTT 3.1.1 has no
+// way to report errors on an accepted connection, which is simply closed after the error.
+func (c *mqttConnection) writeError(message string) error {
+	c.lock.Lock()
+	connected := c.connected
+	c.lock.Unlock()
+	if !connected {
+		code := byte(mqttV311NotAuthorized)
+		if c.version == mqttV5 {
+			code = mqttNotAuthorized
+		}
+		return c.send(&mqttPacket{kind: mqttConnack, codes: []byte{code}})
+	}
+	return c.send(&mqttPacket{kind: mqttDisconnect, codes: []byte{mqttUnspecifiedError}, reason: message})
+}
+
+// send encodes the packet for the client's protocol version and writes it, packets the version does not
+// have are dropped.
+func (c *mqttConnection) send(p *mqttPacket) error {
+	packet := c.codec.encode(p)
+	if packet == nil {
+		return nil
+	}
+	return c.write(packet)
+}
+
+func (c *mqttConnection) write(packet []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	_, err := c.conn.Write(packet)
+	return err
+}
@@ -0,0 +1,56 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"net"
+)
+
+type mqttConnectionListener struct {
+	listener     net.Listener
+	config       MqttConfig
+	closeChannel chan *Connection
+	openChannel  chan *Connection
+}
+
+// NewMqttConnectionListener listens for MQTT 3.1.1 and 5 clients on addr, every client is presented to the
+// STOMP server as a regular STOMP connection. See MqttConfig for how topics map onto destinations.
+func NewMqttConnectionListener(addr string, config MqttConfig) (RawConnectionListener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewMqttConnectionListenerFromListener(listener, config), nil
+}
+
+// NewMqttConnectionListenerFromListener accepts MQTT clients from an existing listener, such as one inherited
+// from another process.
+func NewMqttConnectionListenerFromListener(listener net.Listener, config MqttConfig) RawConnectionListener {
+	return &mqttConnectionListener{
+		listener:     listener,
+		config:       config,
+		openChannel:  make(chan *Connection),
+		closeChannel: make(chan *Connection),
+	}
+}
+
+func (l *mqttConnectionListener) GetConnectionOpenChannel() chan *Connection {
+	return l.openChannel
+}
+
+func (l *mqttConnectionListener) GetConnectionCloseChannel() chan *Connection {
+	return l.closeChannel
+}
+
+func (l *mqttConnectionListener) Accept() (RawConnection, error) {
+	conn, err := l.listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return newMqttConnection(conn, l.config), nil
+}
+
+func (l *mqttConnectionListener) Close() error {
+	return l.listener.Close()
+}
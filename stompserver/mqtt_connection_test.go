@@ -0,0 +1,204 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	packets5 "github.com/eclipse/paho.golang/packets"
+	"github.com/eclipse/paho.golang/paho"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	packets311 "github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/stretchr/testify/assert"
+)
+
+func startTestMqttServer(t *testing.T) (*stompServer, string) {
+	listener, err := NewMqttConnectionListener("127.0.0.1:0",
+		MqttConfig{TopicPrefix: "/topic/", AppRequestPrefix: "/pub/"})
+	assert.NoError(t, err)
+	server := NewStompServer(listener, NewStompConfig(0, []string{"/pub/"})).(*stompServer)
+	go server.Start()
+	t.Cleanup(server.Stop)
+	return server, listener.(*mqttConnectionListener).listener.Addr().String()
+}
+
+func dialTestMqtt(t *testing.T, addr string) net.Conn {
+	conn, err := net.Dial("tcp", addr)
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	return conn
+}
+
+func TestMqttConnection_V311(t *testing.T) {
+	server, addr := startTestMqttServer(t)
+
+	subscribed := make(chan string, 1)
+	server.OnSubscribeEvent(func(conId string, subId string, destination string, _ *frame.Frame) {
+		subscribed <- destination
+	})
+	requests := make(chan string, 1)
+	server.OnApplicationRequest(func(destination string, message []byte, connectionId string) {
+		requests <- destination + " " + string(message)
+	})
+
+	client := mqtt.NewClient(mqtt.NewClientOptions().
+		AddBroker("tcp://" + addr).
+		SetClientID("client-1").
+		SetUsername("user").
+		SetPassword("secret").
+		SetAutoReconnect(false))
+	token := client.Connect()
+	assert.True(t, token.WaitTimeout(2*time.Second))
+	assert.NoError(t, token.Error())
+	defer client.Disconnect(0)
+
+	messages := make(chan mqtt.Message, 1)
+	token = client.SubscribeMultiple(map[string]byte{"orders": 1, "orders/#": 0}, func(_ mqtt.Client, msg mqtt.Message) {
+		messages <- msg
+	})
+	assert.True(t, token.WaitTimeout(2*time.Second))
+	assert.Equal(t, map[string]byte{"orders": mqttSuccess, "orders/#": mqttV311SubscribeFailure},
+		token.(*mqtt.SubscribeToken).Result())
+	assert.Equal(t, "/topic/orders", <-subscribed)
+
+	server.SendMessage("/topic/orders", []byte(`{"id":1}`))
+	select {
+	case msg := <-messages:
+		assert.Equal(t, "orders", msg.Topic())
+		assert.Equal(t, []byte(`{"id":1}`), msg.Payload())
+		assert.Equal(t, byte(0), msg.Qos())
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "message was not delivered")
+	}
+
+	token = client.Publish("requests", 1, false, "hello")
+	assert.True(t, token.WaitTimeout(2*time.Second))
+	assert.NoError(t, token.Error())
+	assert.Equal(t, "/pub/requests hello", <-requests)
+}
+
+func TestMqttConnection_V5(t *testing.T) {
+	server, addr := startTestMqttServer(t)
+
+	requests := make(chan string, 1)
+	server.OnApplicationRequest(func(destination string, message []byte, connectionId string) {
+		requests <- destination + " " + string(message)
+	})
+
+	messages := make(chan *paho.Publish, 1)
+	conn, err := net.Dial("tcp", addr)
+	assert.NoError(t, err)
+	client := paho.NewClient(paho.ClientConfig{
+		Conn: conn,
+		OnPublishReceived: []func(paho.PublishReceived) (bool, error){
+			func(received paho.PublishReceived) (bool, error) {
+				messages <- received.Packet
+				return true, nil
+			},
+		},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	connack, err := client.Connect(ctx, &paho.Connect{
+		ClientID:     "client-1",
+		CleanStart:   true,
+		KeepAlive:    30,
+		Username:     "user",
+		UsernameFlag: true,
+		Password:     []byte("secret"),
+		PasswordFlag: true,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, byte(mqttSuccess), connack.ReasonCode)
+	assert.False(t, connack.Properties.WildcardSubAvailable)
+	defer client.Disconnect(&paho.Disconnect{})
+
+	suback, err := client.Subscribe(ctx, &paho.Subscribe{Subscriptions: []paho.SubscribeOptions{{Topic: "orders"}}})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{mqttSuccess}, suback.Reasons)
+
+	server.SendMessage("/topic/orders", []byte(`{"id":1}`))
+	select {
+	case msg := <-messages:
+		assert.Equal(t, "orders", msg.Topic)
+		assert.Equal(t, []byte(`{"id":1}`), msg.Payload)
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "message was not delivered")
+	}
+
+	// QoS 2 goes through PUBREC, PUBREL and PUBCOMP before the publish returns.
+	response, err := client.Publish(ctx, &paho.Publish{Topic: "requests", QoS: 2, Payload: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, byte(mqttSuccess), response.ReasonCode)
+	assert.Equal(t, "/pub/requests hello", <-requests)
+
+	unsuback, err := client.Unsubscribe(ctx, &paho.Unsubscribe{Topics: []string{"orders"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{mqttSuccess}, unsuback.Reasons)
+}
+
+func TestMqttConnection_V5UnsupportedSubscriptions(t *testing.T) {
+	_, addr := startTestMqttServer(t)
+	conn := dialTestMqtt(t, addr)
+
+	// clients refuse to send what the CONNACK says is not supported, so the packets are written directly.
+	connect := packets5.NewControlPacket(packets5.CONNECT)
+	connect.Content.(*packets5.Connect).CleanStart = true
+	_, err := connect.WriteTo(conn)
+	assert.NoError(t, err)
+	cp, err := packets5.ReadPacket(conn)
+	assert.NoError(t, err)
+	assert.Equal(t, byte(mqttSuccess), cp.Content.(*packets5.Connack).ReasonCode)
+
+	subscribe := &packets5.Subscribe{PacketID: 1, Properties: &packets5.Properties{}, Subscriptions: []packets5.SubOptions{
+		{Topic: "orders"}, {Topic: "sensors/+"}, {Topic: "$share/group/orders"},
+	}}
+	_, err = subscribe.WriteTo(conn)
+	assert.NoError(t, err)
+	cp, err = packets5.ReadPacket(conn)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{mqttSuccess, mqttWildcardSubsNotSupported, mqttSharedSubsNotSupported},
+		cp.Content.(*packets5.Suback).Reasons)
+}
+
+func TestMqttConnection_UnsupportedVersion(t *testing.T) {
+	_, addr := startTestMqttServer(t)
+	conn := dialTestMqtt(t, addr)
+
+	connect := packets311.NewControlPacket(packets311.Connect).(*packets311.ConnectPacket)
+	connect.ProtocolName, connect.ProtocolVersion = "MQIsdp", 3
+	connect.CleanSession, connect.ClientIdentifier = true, "client-1"
+	assert.NoError(t, connect.Write(conn))
+
+	cp, err := packets311.ReadPacket(conn)
+	assert.NoError(t, err)
+	assert.Equal(t, byte(mqttV311BadProtocolVersion), cp.(*packets311.ConnackPacket).ReturnCode)
+
+	_, err = conn.Read(make([]byte, 1))
+	assert.Error(t, err)
+}
+
+func TestMqttConnection_PacketTooLarge(t *testing.T) {
+	listener, err := NewMqttConnectionListener("127.0.0.1:0", MqttConfig{MaxPacketBytes: 64})
+	assert.NoError(t, err)
+	server := NewStompServer(listener, NewStompConfig(0, nil))
+	go server.Start()
+	t.Cleanup(server.Stop)
+	conn := dialTestMqtt(t, listener.(*mqttConnectionListener).listener.Addr().String())
+
+	connect := packets311.NewControlPacket(packets311.Connect).(*packets311.ConnectPacket)
+	connect.ProtocolName, connect.ProtocolVersion = "MQTT", mqttV311
+	connect.CleanSession, connect.ClientIdentifier = true, string(make([]byte, 100))
+	assert.NoError(t, connect.Write(conn))
+
+	// refused before it is read, without an answer.
+	_, err = conn.Read(make([]byte, 1))
+	assert.Error(t, err)
+}
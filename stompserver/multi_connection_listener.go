@@ -0,0 +1,112 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+type acceptResult struct {
+	conn RawConnection
+	err  error
+}
+
+// multiConnectionListener accepts connections from several listeners, so one STOMP server can serve
+// clients of different transports.
+type multiConnectionListener struct {
+	listeners []RawConnectionListener
+	accepted  chan acceptResult
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewMultiConnectionListener merges listeners into one, connection open and close events are those of the
+// first listener. Closing the returned listener closes all of them.
+func NewMultiConnectionListener(listeners ...RawConnectionListener) RawConnectionListener {
+	l := &multiConnectionListener{
+		listeners: listeners,
+		accepted:  make(chan acceptResult),
+		done:      make(chan struct{}),
+	}
+	for _, listener := range listeners {
+		go l.acceptFrom(listener)
+	}
+	return l
+}
+
+func (l *multiConnectionListener) acceptFrom(listener RawConnectionListener) {
+	for {
+		conn, err := listener.Accept()
+		select {
+		case l.accepted <- acceptResult{conn: conn, err: err}:
+		case <-l.done:
+			if conn != nil {
+				conn.Close()
+			}
+			return
+		}
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+	}
+}
+
+// SetFrameLimits sets the frame limits of the listeners enforcing them.
+func (l *multiConnectionListener) SetFrameLimits(limits FrameLimits) {
+	for _, listener := range l.listeners {
+		if limited, ok := listener.(FrameLimitedListener); ok {
+			limited.SetFrameLimits(limits)
+		}
+	}
+}
+
+// SetCompression sets the compression of the listeners compressing their frames.
+func (l *multiConnectionListener) SetCompression(compression WebSocketCompression) {
+	for _, listener := range l.listeners {
+		if compressed, ok := listener.(CompressedListener); ok {
+			compressed.SetCompression(compression)
+		}
+	}
+}
+
+// SetWebSocketGuard sets the guard of the listeners upgrading WebSockets.
+func (l *multiConnectionListener) SetWebSocketGuard(guard WebSocketGuard) {
+	for _, listener := range l.listeners {
+		if guarded, ok := listener.(GuardedListener); ok {
+			guarded.SetWebSocketGuard(guard)
+		}
+	}
+}
+
+func (l *multiConnectionListener) GetConnectionOpenChannel() chan *Connection {
+	return l.listeners[0].GetConnectionOpenChannel()
+}
+
+func (l *multiConnectionListener) GetConnectionCloseChannel() chan *Connection {
+	return l.listeners[0].GetConnectionCloseChannel()
+}
+
+func (l *multiConnectionListener) Accept() (RawConnection, error) {
+	select {
+	case result := <-l.accepted:
+		return result.conn, result.err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *multiConnectionListener) Close() error {
+	var errs []error
+	l.closeOnce.Do(func() {
+		close(l.done)
+		for _, listener := range l.listeners {
+			if err := listener.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	})
+	return errors.Join(errs...)
+}
@@ -0,0 +1,49 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultiConnectionListener_Accept(t *testing.T) {
+	stompListener, err := NewTcpConnectionListener("127.0.0.1:0")
+	assert.NoError(t, err)
+	mqttListener, err := NewMqttConnectionListener("127.0.0.1:0", MqttConfig{})
+	assert.NoError(t, err)
+
+	listener := NewMultiConnectionListener(stompListener, mqttListener)
+	assert.Equal(t, stompListener.GetConnectionOpenChannel(), listener.GetConnectionOpenChannel())
+
+	for _, addr := range []net.Addr{
+		stompListener.(*tcpConnectionListener).listener.Addr(),
+		mqttListener.(*mqttConnectionListener).listener.Addr(),
+	} {
+		conn, err := net.Dial("tcp", addr.String())
+		assert.NoError(t, err)
+		defer conn.Close()
+	}
+
+	var stompConns, mqttConns int
+	for i := 0; i < 2; i++ {
+		rawConn, err := listener.Accept()
+		assert.NoError(t, err)
+		switch rawConn.(type) {
+		case *tcpStompConnection:
+			stompConns++
+		case *mqttConnection:
+			mqttConns++
+		}
+		rawConn.Close()
+	}
+	assert.Equal(t, 1, stompConns)
+	assert.Equal(t, 1, mqttConns)
+
+	assert.NoError(t, listener.Close())
+	_, err = listener.Accept()
+	assert.ErrorIs(t, err, net.ErrClosed)
+}
@@ -0,0 +1,325 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pb33f/ranch/log"
+)
+
+// StompALPNProtocol is the ALPN protocol id TLS clients ask for to speak raw STOMP on a multiplexed port.
+const StompALPNProtocol = "stomp"
+
+// DefaultPortMuxSniffTimeout is how long a new connection has to reveal what it speaks.
+const DefaultPortMuxSniffTimeout = 10 * time.Second
+
+// maxStompPreamble bounds the heart-beat EOLs a STOMP client may send ahead of its CONNECT frame.
+const maxStompPreamble = 64
+
+var stompConnectCommands = [][]byte{
+	[]byte("CONNECT\n"), []byte("CONNECT\r\n"), []byte("STOMP\n"), []byte("STOMP\r\n"),
+}
+
+// PortMux shares a single port between raw TCP STOMP clients and HTTP, including WebSocket upgrades, so
+// only one port has to be opened. A connection is raw STOMP if it starts with a CONNECT or STOMP frame,
+// anything else is handed to HTTP. With TLS, clients that negotiate the StompALPNProtocol (or h2 and
+// http/1.1) through ALPN are routed without looking at what they send.
+type PortMux struct {
+	tlsConfig    *tls.Config
+	sniffTimeout time.Duration
+	root         net.Listener
+	stomp        *muxListener
+	http         *muxListener
+	lock         sync.Mutex
+	closed       bool
+}
+
+// NewPortMux creates a PortMux. When tlsConfig is set connections are TLS, the StompALPNProtocol is added
+// to the protocols it offers through ALPN. A zero sniffTimeout uses DefaultPortMuxSniffTimeout.
+func NewPortMux(tlsConfig *tls.Config, sniffTimeout time.Duration) *PortMux {
+	if tlsConfig != nil {
+		tlsConfig = tlsConfig.Clone()
+		protocols := tlsConfig.NextProtos
+		if len(protocols) == 0 {
+			protocols = []string{"h2", "http/1.1"}
+		}
+		tlsConfig.NextProtos = append([]string{StompALPNProtocol}, protocols...)
+	}
+	if sniffTimeout <= 0 {
+		sniffTimeout = DefaultPortMuxSniffTimeout
+	}
+	m := &PortMux{tlsConfig: tlsConfig, sniffTimeout: sniffTimeout}
+	m.stomp = newMuxListener(m)
+	m.http = newMuxListener(m)
+	return m
+}
+
+// StompListener returns the listener raw STOMP connections are accepted from, see
+// NewTcpConnectionListenerFromListener.
+func (m *PortMux) StompListener() net.Listener {
+	return m.stomp
+}
+
+// HttpListener returns the listener HTTP connections are accepted from, to be served with http.Server.Serve.
+// Connections are TLS already when the PortMux is, so they are served with Serve rather than ServeTLS.
+func (m *PortMux) HttpListener() net.Listener {
+	return m.http
+}
+
+// Serve accepts connections on l and routes them until l or the PortMux is closed. Temporary accept errors,
+// such as running out of file descriptors, are retried with a growing delay like http.Server does.
+func (m *PortMux) Serve(l net.Listener) error {
+	m.lock.Lock()
+	if m.closed {
+		m.lock.Unlock()
+		return l.Close()
+	}
+	m.root = l
+	m.lock.Unlock()
+	var retryDelay time.Duration
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() && !m.isClosed() {
+				if retryDelay == 0 {
+					retryDelay = 5 * time.Millisecond
+				} else if retryDelay *= 2; retryDelay > time.Second {
+					retryDelay = time.Second
+				}
+				log.Logger().Warn("[ranch] multiplexed port accept failed, retrying", "error", err.Error(),
+					"delay", retryDelay)
+				time.Sleep(retryDelay)
+				continue
+			}
+			m.Close()
+			return err
+		}
+		retryDelay = 0
+		go m.route(conn)
+	}
+}
+
+// tlsStateKey is the context key ServeHttp keeps the TLS state of a sniffed connection under.
+type tlsStateKey struct{}
+
+// ServeHttp serves srv on the HttpListener until the PortMux is closed. net/http only fills in r.TLS for
+// connections that are a *tls.Conn, which TLS connections that had to be sniffed are not, so their TLS
+// state is kept in the request context for PortMuxTLSHandler to fill it in.
+func (m *PortMux) ServeHttp(srv *http.Server) error {
+	connContext := srv.ConnContext
+	srv.ConnContext = func(ctx context.Context, conn net.Conn) context.Context {
+		if connContext != nil {
+			ctx = connContext(ctx, conn)
+		}
+		if sniffed, ok := conn.(*sniffedConn); ok {
+			if state, ok := sniffed.ConnectionState(); ok {
+				ctx = context.WithValue(ctx, tlsStateKey{}, &state)
+			}
+		}
+		return ctx
+	}
+	return srv.Serve(m.http)
+}
+
+// PortMuxTLSHandler fills in r.TLS for requests sent over TLS connections of a PortMux served with
+// ServeHttp, before handing them to handler. Other requests are handed over as they are.
+func PortMuxTLSHandler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if state, ok := r.Context().Value(tlsStateKey{}).(*tls.ConnectionState); ok && r.TLS == nil {
+			r = r.WithContext(r.Context())
+			r.TLS = state
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func (m *PortMux) isClosed() bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.closed
+}
+
+// Close stops accepting connections, on the port and on both listeners.
+func (m *PortMux) Close() error {
+	m.stomp.close()
+	m.http.close()
+	return m.closeRoot()
+}
+
+// closeRoot closes the port, once neither listener accepts connections any more.
+func (m *PortMux) closeRoot() error {
+	if !m.stomp.isClosed() || !m.http.isClosed() {
+		return nil
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+	if m.root != nil {
+		return m.root.Close()
+	}
+	return nil
+}
+
+func (m *PortMux) addr() net.Addr {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.root != nil {
+		return m.root.Addr()
+	}
+	return &net.TCPAddr{}
+}
+
+// route works out what a connection speaks and hands it to the matching listener.
+func (m *PortMux) route(conn net.Conn) {
+	_ = conn.SetDeadline(time.Now().Add(m.sniffTimeout))
+	if m.tlsConfig != nil {
+		tlsConn := tls.Server(conn, m.tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			log.Logger().Debug("[ranch] multiplexed port TLS handshake failed", "remote", conn.RemoteAddr(),
+				"error", err.Error())
+			_ = conn.Close()
+			return
+		}
+		switch tlsConn.ConnectionState().NegotiatedProtocol {
+		case StompALPNProtocol:
+			_ = conn.SetDeadline(time.Time{})
+			m.stomp.deliver(tlsConn)
+			return
+		case "h2", "http/1.1":
+			_ = conn.SetDeadline(time.Time{})
+			m.http.deliver(tlsConn)
+			return
+		}
+		conn = tlsConn
+	}
+
+	reader := bufio.NewReader(conn)
+	isStomp, err := sniffStomp(reader)
+	_ = conn.SetDeadline(time.Time{})
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	sniffed := &sniffedConn{Conn: conn, reader: reader}
+	if isStomp {
+		m.stomp.deliver(sniffed)
+	} else {
+		m.http.deliver(sniffed)
+	}
+}
+
+// sniffStomp peeks at the start of a connection, returns true if it opens with a STOMP CONNECT or STOMP
+// frame. The bytes peeked are left in the reader.
+func sniffStomp(reader *bufio.Reader) (bool, error) {
+	for n := 1; n <= maxStompPreamble+len("CONNECT\r\n"); n++ {
+		peeked, err := reader.Peek(n)
+		if err != nil {
+			if len(peeked) > 0 {
+				// whatever was sent is all there is, let HTTP answer it.
+				return false, nil
+			}
+			return false, err
+		}
+		command := bytes.TrimLeft(peeked, "\r\n")
+		if len(command) == 0 {
+			continue
+		}
+		candidate := false
+		for _, connect := range stompConnectCommands {
+			if bytes.Equal(command, connect) {
+				return true, nil
+			}
+			if bytes.HasPrefix(connect, command) {
+				candidate = true
+			}
+		}
+		if !candidate {
+			return false, nil
+		}
+	}
+	return false, nil
+}
+
+// sniffedConn replays the bytes peeked while sniffing before reading on from the connection.
+type sniffedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *sniffedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// ConnectionState returns the state of the TLS connection, false if the connection is not TLS.
+func (c *sniffedConn) ConnectionState() (tls.ConnectionState, bool) {
+	if tlsConn, ok := c.Conn.(*tls.Conn); ok {
+		return tlsConn.ConnectionState(), true
+	}
+	return tls.ConnectionState{}, false
+}
+
+// muxListener is a listener connections routed by a PortMux are accepted from.
+type muxListener struct {
+	mux       *PortMux
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newMuxListener(mux *PortMux) *muxListener {
+	return &muxListener{mux: mux, conns: make(chan net.Conn), done: make(chan struct{})}
+}
+
+func (l *muxListener) deliver(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		_ = conn.Close()
+	}
+}
+
+func (l *muxListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops accepting connections on the listener, the port is closed once both listeners are.
+func (l *muxListener) Close() error {
+	l.close()
+	return l.mux.closeRoot()
+}
+
+func (l *muxListener) close() {
+	l.closeOnce.Do(func() {
+		close(l.done)
+	})
+}
+
+func (l *muxListener) isClosed() bool {
+	select {
+	case <-l.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *muxListener) Addr() net.Addr {
+	return l.mux.addr()
+}
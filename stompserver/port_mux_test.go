@@ -0,0 +1,190 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSniffStomp(t *testing.T) {
+	tests := []struct {
+		sent    string
+		isStomp bool
+		err     bool
+	}{
+		{sent: "CONNECT\naccept-version:1.2\n\n\x00", isStomp: true},
+		{sent: "\r\n\nSTOMP\r\nhost:ranch\n\n\x00", isStomp: true},
+		{sent: "GET / HTTP/1.1\r\nHost: ranch\r\n\r\n"},
+		{sent: "CONNECT ranch:443 HTTP/1.1\r\n\r\n"},
+		{sent: "CONN"},
+		{sent: "", err: true},
+	}
+	for _, test := range tests {
+		reader := bufio.NewReader(strings.NewReader(test.sent))
+		isStomp, err := sniffStomp(reader)
+		assert.Equal(t, test.isStomp, isStomp, test.sent)
+		assert.Equal(t, test.err, err != nil, test.sent)
+
+		// nothing is consumed.
+		replayed, _ := io.ReadAll(reader)
+		assert.Equal(t, test.sent, string(replayed))
+	}
+}
+
+// servePortMux serves a PortMux on a local port, HTTP requests are answered with "moo", or "moo over TLS".
+func servePortMux(t *testing.T, mux *PortMux) (string, RawConnectionListener) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go mux.Serve(l)
+	go mux.ServeHttp(&http.Server{Handler: PortMuxTLSHandler(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS != nil {
+				_, _ = w.Write([]byte("moo over TLS"))
+				return
+			}
+			_, _ = w.Write([]byte("moo"))
+		}))})
+	return l.Addr().String(), NewTcpConnectionListenerFromListener(mux.StompListener())
+}
+
+func acceptStompConnect(t *testing.T, stompListener RawConnectionListener) {
+	conn, err := stompListener.Accept()
+	if assert.NoError(t, err) {
+		f, err := conn.ReadFrame()
+		assert.NoError(t, err)
+		assert.Equal(t, frame.CONNECT, f.Command)
+		assert.Equal(t, "1.2", f.Header.Get(frame.AcceptVersion))
+		conn.Close()
+	}
+}
+
+func TestPortMux(t *testing.T) {
+	mux := NewPortMux(nil, time.Second)
+	addr, stompListener := servePortMux(t, mux)
+
+	conn, err := net.Dial("tcp", addr)
+	assert.NoError(t, err)
+	defer conn.Close()
+	assert.NoError(t, frame.NewWriter(conn).Write(frame.New(frame.CONNECT, frame.AcceptVersion, "1.2")))
+	acceptStompConnect(t, stompListener)
+
+	rsp, err := http.Get(fmt.Sprintf("http://%s/", addr))
+	if assert.NoError(t, err) {
+		body, _ := io.ReadAll(rsp.Body)
+		_ = rsp.Body.Close()
+		assert.Equal(t, "moo", string(body))
+	}
+
+	assert.NoError(t, mux.Close())
+	_, err = stompListener.Accept()
+	assert.True(t, errors.Is(err, net.ErrClosed))
+	_, err = net.Dial("tcp", addr)
+	assert.Error(t, err)
+}
+
+func TestPortMux_TLS(t *testing.T) {
+	mux := NewPortMux(&tls.Config{Certificates: []tls.Certificate{testCertificate(t)}}, time.Second)
+	addr, stompListener := servePortMux(t, mux)
+	defer mux.Close()
+
+	// STOMP clients either ask for the stomp protocol, or just send their CONNECT frame.
+	for _, protocols := range [][]string{{StompALPNProtocol}, nil} {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true, NextProtos: protocols})
+		if assert.NoError(t, err) {
+			assert.NoError(t, frame.NewWriter(conn).Write(frame.New(frame.CONNECT, frame.AcceptVersion, "1.2")))
+			acceptStompConnect(t, stompListener)
+			conn.Close()
+		}
+	}
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	rsp, err := client.Get(fmt.Sprintf("https://%s/", addr))
+	if assert.NoError(t, err) {
+		body, _ := io.ReadAll(rsp.Body)
+		_ = rsp.Body.Close()
+		assert.Equal(t, "moo over TLS", string(body))
+	}
+
+	// HTTP clients that do not use ALPN are sniffed, their requests are still known to be TLS.
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: ranch\r\nConnection: close\r\n\r\n"))
+		assert.NoError(t, err)
+		rsp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if assert.NoError(t, err) {
+			body, _ := io.ReadAll(rsp.Body)
+			_ = rsp.Body.Close()
+			assert.Equal(t, "moo over TLS", string(body))
+		}
+	}
+}
+
+// flakyListener fails to accept with a temporary error before accepting from the listener it wraps.
+type flakyListener struct {
+	net.Listener
+	failures int
+}
+
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "too many open files" }
+func (temporaryError) Timeout() bool   { return false }
+func (temporaryError) Temporary() bool { return true }
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if l.failures > 0 {
+		l.failures--
+		return nil, temporaryError{}
+	}
+	return l.Listener.Accept()
+}
+
+func TestPortMux_TemporaryAcceptError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	mux := NewPortMux(nil, time.Second)
+	defer mux.Close()
+	go mux.Serve(&flakyListener{Listener: l, failures: 3})
+	stompListener := NewTcpConnectionListenerFromListener(mux.StompListener())
+
+	// the port keeps serving after the failed accepts
+	conn, err := net.Dial("tcp", l.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+	assert.NoError(t, frame.NewWriter(conn).Write(frame.New(frame.CONNECT, frame.AcceptVersion, "1.2")))
+	acceptStompConnect(t, stompListener)
+}
+
+func testCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ranch"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
@@ -0,0 +1,77 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"slices"
+)
+
+// queue is a point-to-point destination, each message sent to it is delivered to one of its subscriptions,
+// in turn.
+type queue struct {
+	subscribers []*queueSubscriber // in the order they subscribed
+	next        int                // index of the subscriber the next message is delivered to
+}
+
+type queueSubscriber struct {
+	conn StompConn
+	sub  *Subscription
+}
+
+// queueSubscribed adds a subscription to its queue, if its destination is one. Only called by the run
+// goroutine.
+func (s *stompServer) queueSubscribed(conn StompConn, sub *Subscription) {
+	if !s.config.IsQueueDestination(sub.destination) {
+		return
+	}
+	q, ok := s.queues[sub.destination]
+	if !ok {
+		q = &queue{}
+		s.queues[sub.destination] = q
+	}
+	q.subscribers = append(q.subscribers, &queueSubscriber{conn: conn, sub: sub})
+}
+
+// queueUnsubscribed removes a subscription from its queue, the messages that follow go to the next
+// subscriber in turn. Only called by the run goroutine.
+func (s *stompServer) queueUnsubscribed(conn StompConn, sub *Subscription) {
+	q, ok := s.queues[sub.destination]
+	if !ok {
+		return
+	}
+	i := slices.IndexFunc(q.subscribers, func(qs *queueSubscriber) bool {
+		return qs.sub == sub && qs.conn.GetId() == conn.GetId()
+	})
+	if i < 0 {
+		return
+	}
+	q.subscribers = slices.Delete(q.subscribers, i, i+1)
+	if i < q.next {
+		q.next--
+	}
+	if len(q.subscribers) == 0 {
+		delete(s.queues, sub.destination)
+	}
+}
+
+// sendToQueue delivers a message to the next subscriber of a queue its selector matches. Messages sent to a
+// queue without subscribers, or that no selector matches, are dropped, as they are for topics. Only called
+// by the run goroutine.
+func (s *stompServer) sendToQueue(dest string, msg *selectorMessage) {
+	q, ok := s.queues[dest]
+	if !ok {
+		return
+	}
+	for range q.subscribers {
+		if q.next >= len(q.subscribers) {
+			q.next = 0
+		}
+		qs := q.subscribers[q.next]
+		q.next++
+		if qs.sub.selector.matches(msg) {
+			qs.conn.SendFrameToSubscription(msg.frame.Clone(), qs.sub)
+			return
+		}
+	}
+}
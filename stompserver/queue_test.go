@@ -0,0 +1,72 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"testing"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStompServer_QueueDestination(t *testing.T) {
+	config := NewStompConfig(0, []string{"/pub/"})
+	config.SetQueuePrefix("/queue")
+	server, listener := newTestStompServer(config)
+	subscribed := make(chan string, 10)
+	unsubscribed := make(chan string, 10)
+	server.OnSubscribeEvent(func(conId string, subId string, destination string, f *frame.Frame) {
+		subscribed <- subId
+	})
+	server.OnUnsubscribeEvent(func(conId string, subId string, destination string) {
+		unsubscribed <- subId
+	})
+	go server.Start()
+
+	subscribe := func(conn *MockRawConnection, subId string, destination string) {
+		conn.incomingFrames <- frame.New(frame.SUBSCRIBE, frame.Destination, destination, frame.Id, subId)
+		assert.Equal(t, subId, receive(t, subscribed))
+	}
+	worker1, worker2 := NewMockRawConnection(), NewMockRawConnection()
+	for _, conn := range []*MockRawConnection{worker1, worker2} {
+		listener.incomingConnections <- conn
+		conn.SendConnectFrame()
+	}
+	subscribe(worker1, "w1", "/queue/jobs")
+	subscribe(worker2, "w2", "/queue/jobs")
+	subscribe(worker2, "w3", "/queue/jobs")
+	subscribe(worker1, "t1", "/topic/jobs")
+
+	// messages go to one subscriber in turn, topics are not affected
+	for _, job := range []string{"1", "2", "3", "4"} {
+		server.SendMessage("/queue/jobs", []byte(job))
+	}
+	server.SendMessage("/topic/jobs", []byte("all"))
+	assert.ElementsMatch(t, []string{"1", "4", "all"}, messages(t, worker1, 3))
+	assert.ElementsMatch(t, []string{"2", "3"}, messages(t, worker2, 2))
+
+	// clients may send to queues, subscribers that left are skipped
+	worker2.incomingFrames <- frame.New(frame.UNSUBSCRIBE, frame.Id, "w3")
+	assert.Equal(t, "w3", receive(t, unsubscribed))
+	worker2.incomingFrames <- frame.New(frame.SEND, frame.Destination, "/queue/jobs")
+	worker2.incomingFrames <- frame.New(frame.SEND, frame.Destination, "/queue/jobs")
+	assert.Len(t, messages(t, worker1, 4), 4)
+	assert.Len(t, messages(t, worker2, 3), 3)
+
+	// as are the subscribers of closed connections
+	worker1.incomingFrames <- frame.New(frame.DISCONNECT)
+	assert.ElementsMatch(t, []string{"w1", "t1"}, []string{receive(t, unsubscribed), receive(t, unsubscribed)})
+	server.SendMessage("/queue/jobs", []byte("5"))
+	server.SendMessage("/queue/jobs", []byte("6"))
+	assert.Len(t, messages(t, worker2, 5), 5)
+}
+
+func TestStompConfig_QueuePrefix(t *testing.T) {
+	config := NewStompConfig(0, nil)
+	assert.False(t, config.IsQueueDestination("/queue/jobs"))
+	config.SetQueuePrefix("/queue")
+	assert.Equal(t, "/queue/", config.GetQueuePrefix())
+	assert.True(t, config.IsQueueDestination("/queue/jobs"))
+	assert.False(t, config.IsQueueDestination("/queues/jobs"))
+}
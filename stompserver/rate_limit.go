@@ -0,0 +1,175 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// RateLimitPolicy is what happens to a client sending faster than its rate limits allow.
+type RateLimitPolicy string
+
+const (
+	// RateLimitSlowDown stops reading from the client until it is back within its limits, so it is slowed
+	// down by the flow control of its transport. The default.
+	RateLimitSlowDown RateLimitPolicy = "slow-down"
+	// RateLimitDisconnect sends the client an ERROR frame and closes the connection.
+	RateLimitDisconnect RateLimitPolicy = "disconnect"
+)
+
+// maxDestinationBuckets is how many destinations a connection keeps rate limits for before the ones it has
+// not sent to lately are forgotten.
+const maxDestinationBuckets = 256
+
+// RateLimit caps the frames and the bytes of frame bodies a client sends per second, allowing bursts of up
+// to a second's worth. Zero rates are not limited.
+type RateLimit struct {
+	FramesPerSecond float64 `json:"frames_per_second"`
+	BytesPerSecond  float64 `json:"bytes_per_second"`
+}
+
+func (l RateLimit) enabled() bool {
+	return l.FramesPerSecond > 0 || l.BytesPerSecond > 0
+}
+
+// RateLimitConfig sets how fast each client may send frames, over its connection and to each destination,
+// so a misbehaving client cannot flood the bus and starve the others. Heart-beats are not limited.
+type RateLimitConfig struct {
+	Connection   RateLimit            `json:"connection"`   // every frame the client sends
+	Destination  RateLimit            `json:"destination"`  // the SEND frames of the client to each destination
+	Destinations map[string]RateLimit `json:"destinations"` // overrides Destination for the destinations listed
+	Policy       RateLimitPolicy      `json:"policy"`       // RateLimitSlowDown if empty
+}
+
+func (c RateLimitConfig) enabled() bool {
+	if c.Connection.enabled() || c.Destination.enabled() {
+		return true
+	}
+	for _, limit := range c.Destinations {
+		if limit.enabled() {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenBucket refills at rate tokens per second up to a second's worth. Taking more tokens than it holds
+// leaves it in debt, paid off before tokens are available again.
+type tokenBucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, now time.Time) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &tokenBucket{rate: rate, tokens: rate, last: now}
+}
+
+// take removes n tokens, returning how long until the bucket is out of debt, 0 if it is not in debt.
+func (b *tokenBucket) take(n float64, now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.refill(now)
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = min(b.rate, b.tokens+elapsed*b.rate)
+	}
+	b.last = now
+}
+
+// full returns true if the bucket refilled completely, so forgetting it allows no more than keeping it.
+func (b *tokenBucket) full(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+	b.refill(now)
+	return b.tokens >= b.rate
+}
+
+// rateBuckets are the buckets of a RateLimit.
+type rateBuckets struct {
+	frames *tokenBucket
+	bytes  *tokenBucket
+}
+
+func newRateBuckets(limit RateLimit, now time.Time) *rateBuckets {
+	return &rateBuckets{
+		frames: newTokenBucket(limit.FramesPerSecond, now),
+		bytes:  newTokenBucket(limit.BytesPerSecond, now),
+	}
+}
+
+func (b *rateBuckets) take(f *frame.Frame, now time.Time) time.Duration {
+	return max(b.frames.take(1, now), b.bytes.take(float64(len(f.Body)), now))
+}
+
+// rateLimiter enforces the rate limits of a connection. Only used by the goroutine reading its frames.
+type rateLimiter struct {
+	config       RateLimitConfig
+	connection   *rateBuckets
+	destinations map[string]*rateBuckets
+}
+
+// newRateLimiter returns the rate limiter of a connection, nil if nothing is limited.
+func newRateLimiter(config RateLimitConfig, now time.Time) *rateLimiter {
+	if !config.enabled() {
+		return nil
+	}
+	return &rateLimiter{
+		config:       config,
+		connection:   newRateBuckets(config.Connection, now),
+		destinations: make(map[string]*rateBuckets),
+	}
+}
+
+// take accounts for a frame received from the client, returning how long the client has to wait before it
+// is within its limits again, 0 if it is within them.
+func (l *rateLimiter) take(f *frame.Frame, now time.Time) time.Duration {
+	if l == nil {
+		return 0
+	}
+	delay := l.connection.take(f, now)
+	if f.Command != frame.SEND {
+		return delay
+	}
+	destination := f.Header.Get(frame.Destination)
+	buckets, ok := l.destinations[destination]
+	if !ok {
+		limit, listed := l.config.Destinations[destination]
+		if !listed {
+			limit = l.config.Destination
+		}
+		if !limit.enabled() {
+			return delay
+		}
+		if len(l.destinations) >= maxDestinationBuckets {
+			l.forgetIdleDestinations(now)
+		}
+		buckets = newRateBuckets(limit, now)
+		l.destinations[destination] = buckets
+	}
+	return max(delay, buckets.take(f, now))
+}
+
+// forgetIdleDestinations drops the buckets of the destinations the client has not sent to lately.
+func (l *rateLimiter) forgetIdleDestinations(now time.Time) {
+	for destination, buckets := range l.destinations {
+		if buckets.frames.full(now) && buckets.bytes.full(now) {
+			delete(l.destinations, destination)
+		}
+	}
+}
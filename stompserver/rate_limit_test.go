@@ -0,0 +1,91 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucket_Take(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(2, now)
+	assert.Zero(t, b.take(1, now))
+	assert.Zero(t, b.take(1, now))
+	assert.Equal(t, 500*time.Millisecond, b.take(1, now))
+
+	// the debt is paid off after half a second, bursts never exceed a second's worth
+	assert.Zero(t, b.take(1, now.Add(time.Second)))
+	assert.True(t, b.full(now.Add(time.Hour)))
+	assert.Equal(t, 2.0, b.tokens)
+
+	assert.Nil(t, newTokenBucket(0, now))
+}
+
+func TestRateLimiter_Take(t *testing.T) {
+	now := time.Now()
+	assert.Nil(t, newRateLimiter(RateLimitConfig{}, now))
+
+	limiter := newRateLimiter(RateLimitConfig{
+		Connection:   RateLimit{BytesPerSecond: 10},
+		Destination:  RateLimit{FramesPerSecond: 1},
+		Destinations: map[string]RateLimit{"/pub/chatty": {FramesPerSecond: 3}},
+	}, now)
+
+	send := func(destination string, body string) time.Duration {
+		f := frame.New(frame.SEND, frame.Destination, destination)
+		f.Body = []byte(body)
+		return limiter.take(f, now)
+	}
+	assert.Zero(t, send("/pub/cows", ""))
+	assert.Equal(t, time.Second, send("/pub/cows", ""))
+	assert.Zero(t, send("/pub/sheep", ""), "each destination has its own limit")
+	assert.Zero(t, send("/pub/chatty", ""))
+	assert.Zero(t, send("/pub/chatty", ""))
+	assert.Zero(t, send("/pub/chatty", ""))
+
+	// every frame counts towards the limits of the connection
+	assert.Zero(t, limiter.take(frame.New(frame.SUBSCRIBE, frame.Destination, "/topic/cows"), now))
+	assert.Equal(t, 500*time.Millisecond, send("/pub/goats", "123456789012345"))
+}
+
+func TestRateLimiter_ForgetIdleDestinations(t *testing.T) {
+	now := time.Now()
+	limiter := newRateLimiter(RateLimitConfig{Destination: RateLimit{FramesPerSecond: 1}}, now)
+	for i := 0; i < maxDestinationBuckets; i++ {
+		limiter.take(frame.New(frame.SEND, frame.Destination, "/pub/"+strconv.Itoa(i)), now)
+	}
+	assert.Len(t, limiter.destinations, maxDestinationBuckets)
+
+	later := now.Add(time.Minute)
+	limiter.take(frame.New(frame.SEND, frame.Destination, "/pub/new"), later)
+	assert.Len(t, limiter.destinations, 1)
+}
+
+func TestStompConn_RateLimitDisconnect(t *testing.T) {
+	config := NewStompConfig(0, []string{"/pub"})
+	config.SetRateLimits(RateLimitConfig{Connection: RateLimit{FramesPerSecond: 2}, Policy: RateLimitDisconnect})
+	stompConn, rawConn, events := getTestStompConn(config, nil)
+
+	rawConn.SendConnectFrame()
+	assert.Equal(t, ConnectionEstablished, (<-events).eventType)
+	rawConn.incomingFrames <- frame.New(frame.SUBSCRIBE, frame.Id, "sub-id", frame.Destination, "/topic/test")
+	assert.Equal(t, SubscribeToTopic, (<-events).eventType)
+	rawConn.incomingFrames <- frame.New(frame.SUBSCRIBE, frame.Id, "sub-id-2", frame.Destination, "/topic/test")
+
+	e := <-events
+	assert.Equal(t, ConnectionClosed, e.eventType)
+	assert.Equal(t, CloseReasonRateLimited, e.Reason())
+	assert.Equal(t, uint64(1), stompConn.GetInfo().RateLimited)
+
+	rawConn.lock.Lock()
+	defer rawConn.lock.Unlock()
+	last := rawConn.sentFrames[len(rawConn.sentFrames)-1]
+	assert.Equal(t, frame.ERROR, last.Command)
+	assert.Equal(t, rateLimitExceededError.Error(), last.Header.Get(frame.Message))
+}
@@ -0,0 +1,200 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/pb33f/ranch/clock"
+)
+
+const (
+	RecordedIncoming = "in"  // a frame a client sent to the broker
+	RecordedOutgoing = "out" // a frame the broker sent to the subscribers of a destination
+
+	recorderBufferSize = 1024
+)
+
+// RecordedFrame is a frame captured by a Recorder. A recording is a stream of them, one JSON object per
+// line, read back with a RecordingReader.
+type RecordedFrame struct {
+	Time         time.Time         `json:"time"`
+	Direction    string            `json:"direction"`               // RecordedIncoming or RecordedOutgoing
+	ConnectionId string            `json:"connection_id,omitempty"` // client that sent the frame, or the only one it was sent to
+	Destination  string            `json:"destination"`
+	Command      string            `json:"command"`
+	Headers      map[string]string `json:"headers,omitempty"`
+	Body         []byte            `json:"body,omitempty"`
+}
+
+// Recorder captures the frames going through a broker, those on every destination or on the selected ones,
+// to a writer. Frames are written by a goroutine of their own so the broker never waits on the disk, those
+// arriving faster than they can be written are dropped and counted.
+type Recorder struct {
+	destinations []string // destination prefixes recorded, all if empty
+	frames       chan *RecordedFrame
+	done         chan struct{}
+	lock         sync.RWMutex // held to write to frames, and to close it
+	closed       bool
+	closeOnce    sync.Once
+	closer       io.Closer
+	recorded     atomic.Uint64
+	dropped      atomic.Uint64
+	err          error // first write error, the recording stops there
+}
+
+// NewRecorder returns a recorder writing the frames sent to or from destinations starting with one of
+// destinations, or every frame if there are none, to w. w is closed with the recorder if it is an
+// io.Closer.
+func NewRecorder(w io.Writer, destinations ...string) *Recorder {
+	r := &Recorder{
+		destinations: destinations,
+		frames:       make(chan *RecordedFrame, recorderBufferSize),
+		done:         make(chan struct{}),
+	}
+	if closer, ok := w.(io.Closer); ok {
+		r.closer = closer
+	}
+	go r.write(w)
+	return r
+}
+
+// NewFileRecorder returns a recorder writing to a new file at path, see NewRecorder.
+func NewFileRecorder(path string, destinations ...string) (*Recorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewRecorder(file, destinations...), nil
+}
+
+func (r *Recorder) write(w io.Writer) {
+	defer close(r.done)
+	buf := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buf)
+	for f := range r.frames {
+		if r.err != nil {
+			continue
+		}
+		if r.err = encoder.Encode(f); r.err == nil {
+			r.recorded.Add(1)
+		}
+		// flush when idle, so a recording is complete up to the last quiet moment if the process dies
+		if len(r.frames) == 0 && r.err == nil {
+			r.err = buf.Flush()
+		}
+	}
+	if r.err == nil {
+		r.err = buf.Flush()
+	}
+}
+
+// Record captures a frame sent to or from dest, if the recorder records dest. It never blocks.
+func (r *Recorder) Record(direction string, connId string, dest string, f *frame.Frame) {
+	if !r.records(dest) {
+		return
+	}
+	recorded := &RecordedFrame{
+		Time:         clock.Now(),
+		Direction:    direction,
+		ConnectionId: connId,
+		Destination:  dest,
+		Command:      f.Command,
+		Body:         f.Body,
+	}
+	if f.Header != nil && f.Header.Len() > 0 {
+		recorded.Headers = make(map[string]string, f.Header.Len())
+		for i := f.Header.Len() - 1; i >= 0; i-- {
+			// the first occurrence of a repeated header is the one that counts
+			key, value := f.Header.GetAt(i)
+			recorded.Headers[key] = value
+		}
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if r.closed {
+		return
+	}
+	select {
+	case r.frames <- recorded:
+	default:
+		r.dropped.Add(1)
+	}
+}
+
+func (r *Recorder) records(dest string) bool {
+	if len(r.destinations) == 0 {
+		return true
+	}
+	for _, prefix := range r.destinations {
+		if strings.HasPrefix(dest, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Recorded returns the number of frames written so far.
+func (r *Recorder) Recorded() uint64 {
+	return r.recorded.Load()
+}
+
+// Dropped returns the number of frames dropped because they arrived faster than they could be written.
+func (r *Recorder) Dropped() uint64 {
+	return r.dropped.Load()
+}
+
+// Close stops recording, writes the frames still buffered and closes the writer. It returns the first error
+// writing the recording.
+func (r *Recorder) Close() error {
+	r.closeOnce.Do(func() {
+		r.lock.Lock()
+		r.closed = true
+		close(r.frames)
+		r.lock.Unlock()
+		<-r.done
+		if r.closer != nil {
+			if err := r.closer.Close(); r.err == nil {
+				r.err = err
+			}
+		}
+	})
+	return r.err
+}
+
+// RecordingReader reads the frames of a recording in the order they were recorded.
+type RecordingReader struct {
+	decoder *json.Decoder
+}
+
+func NewRecordingReader(r io.Reader) *RecordingReader {
+	return &RecordingReader{decoder: json.NewDecoder(r)}
+}
+
+// Next returns the next frame of the recording, io.EOF once there are no more.
+func (r *RecordingReader) Next() (*RecordedFrame, error) {
+	var f RecordedFrame
+	if err := r.decoder.Decode(&f); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// Frame returns the STOMP frame that was recorded.
+func (f *RecordedFrame) Frame() *frame.Frame {
+	recorded := frame.New(f.Command)
+	for key, value := range f.Headers {
+		recorded.Header.Add(key, value)
+	}
+	recorded.Body = f.Body
+	return recorded
+}
@@ -0,0 +1,124 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/pb33f/ranch/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readRecording(t *testing.T, r io.Reader) []*RecordedFrame {
+	reader := NewRecordingReader(r)
+	var frames []*RecordedFrame
+	for {
+		f, err := reader.Next()
+		if err == io.EOF {
+			return frames
+		}
+		require.NoError(t, err)
+		frames = append(frames, f)
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	fake := clock.NewFakeClock(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	clock.Set(fake)
+	defer clock.Reset()
+
+	var buf bytes.Buffer
+	recorder := NewRecorder(&buf, "/topic/orders", "/pub/")
+	sent := frame.New(frame.SEND, frame.Destination, "/pub/orders", "x-trace", "first", "x-trace", "second")
+	sent.Body = []byte(`{"request":"list"}`)
+	recorder.Record(RecordedIncoming, "conn-1", "/pub/orders", sent)
+	fake.Advance(time.Second)
+	recorder.Record(RecordedOutgoing, "", "/topic/stock", frame.New(frame.MESSAGE))
+	recorder.Record(RecordedOutgoing, "", "/topic/orders", frame.New(frame.MESSAGE, frame.Destination, "/topic/orders"))
+	require.NoError(t, recorder.Close())
+	assert.Equal(t, uint64(2), recorder.Recorded())
+	assert.Zero(t, recorder.Dropped())
+
+	// nothing is recorded once closed
+	recorder.Record(RecordedOutgoing, "", "/topic/orders", frame.New(frame.MESSAGE))
+	require.NoError(t, recorder.Close())
+
+	frames := readRecording(t, &buf)
+	require.Len(t, frames, 2)
+	assert.Equal(t, RecordedIncoming, frames[0].Direction)
+	assert.Equal(t, "conn-1", frames[0].ConnectionId)
+	assert.Equal(t, "/pub/orders", frames[0].Destination)
+	assert.Equal(t, "first", frames[0].Headers["x-trace"])
+	assert.True(t, frames[0].Time.Equal(fake.Now().Add(-time.Second)))
+	replayed := frames[0].Frame()
+	assert.Equal(t, frame.SEND, replayed.Command)
+	assert.Equal(t, "/pub/orders", replayed.Header.Get(frame.Destination))
+	assert.Equal(t, sent.Body, replayed.Body)
+	assert.Equal(t, RecordedOutgoing, frames[1].Direction)
+	assert.Equal(t, "/topic/orders", frames[1].Destination)
+	assert.True(t, frames[1].Time.Equal(fake.Now()))
+}
+
+func TestNewFileRecorder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "broker.rec")
+	recorder, err := NewFileRecorder(path)
+	require.NoError(t, err)
+	recorder.Record(RecordedOutgoing, "", "/topic/orders", frame.New(frame.MESSAGE))
+	require.NoError(t, recorder.Close())
+
+	_, err = NewFileRecorder(filepath.Join(path, "nested"))
+	assert.Error(t, err)
+}
+
+func TestStompServer_Recorder(t *testing.T) {
+	server, listener := newTestStompServer(NewStompConfig(0, []string{"/pub/"}))
+	subscribed := make(chan string, 10)
+	server.OnSubscribeEvent(func(conId string, subId string, destination string, f *frame.Frame) {
+		subscribed <- conId
+	})
+	requests := make(chan string, 10)
+	server.OnApplicationRequest(func(destination string, message []byte, connectionId string) {
+		requests <- string(message)
+	})
+	var buf bytes.Buffer
+	recorder := NewRecorder(&buf)
+	assert.Nil(t, server.SetRecorder(recorder))
+	go server.Start()
+
+	conn := NewMockRawConnection()
+	listener.incomingConnections <- conn
+	conn.SendConnectFrame()
+	conn.incomingFrames <- frame.New(frame.SUBSCRIBE, frame.Destination, "/topic/orders", frame.Id, "s1")
+	id := receive(t, subscribed)
+
+	request := frame.New(frame.SEND, frame.Destination, "/pub/orders")
+	request.Body = []byte("list")
+	conn.incomingFrames <- request
+	assert.Equal(t, "list", receive(t, requests))
+	server.SendMessage("/topic/orders", []byte("orders"))
+	server.SendMessageToClient(id, "/topic/orders", []byte("yours"))
+	assert.Equal(t, []string{"orders", "yours"}, messages(t, conn, 2))
+
+	assert.Same(t, recorder, server.SetRecorder(nil))
+	server.SendMessage("/topic/orders", []byte("not recorded"))
+	assert.Equal(t, []string{"orders", "yours", "not recorded"}, messages(t, conn, 3))
+	require.NoError(t, recorder.Close())
+
+	frames := readRecording(t, &buf)
+	require.Len(t, frames, 3)
+	assert.Equal(t, RecordedIncoming, frames[0].Direction)
+	assert.Equal(t, id, frames[0].ConnectionId)
+	assert.Equal(t, []byte("list"), frames[0].Body)
+	assert.Equal(t, RecordedOutgoing, frames[1].Direction)
+	assert.Empty(t, frames[1].ConnectionId)
+	assert.Equal(t, []byte("orders"), frames[1].Body)
+	assert.Equal(t, id, frames[2].ConnectionId)
+	assert.Equal(t, []byte("yours"), frames[2].Body)
+}
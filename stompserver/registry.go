@@ -0,0 +1,111 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"net"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// ConnectionInfo describes an active connection of the broker and its subscriptions.
+type ConnectionInfo struct {
+	Id            string              `json:"id"`
+	RemoteAddress string              `json:"remote_address,omitempty"` // empty if the raw connection does not know it
+	Principal     string              `json:"principal,omitempty"`      // empty if the client is anonymous
+	ClientId      string              `json:"client_id,omitempty"`      // empty if the client sent none, see ClientIdHeader
+	ConnectedAt   time.Time           `json:"connected_at"`
+	FramesIn      uint64              `json:"frames_in"`    // frames received from the client, heart-beats excluded
+	FramesOut     uint64              `json:"frames_out"`   // frames sent to the client, heart-beats excluded
+	RateLimited   uint64              `json:"rate_limited"` // frames received while the client exceeded its rate limits
+	Subscriptions []*SubscriptionInfo `json:"subscriptions"`
+}
+
+// SubscriptionInfo describes a subscription of an active connection.
+type SubscriptionInfo struct {
+	Id          string `json:"id"`
+	Destination string `json:"destination"`
+	Ack         string `json:"ack"`
+	Queued      int    `json:"queued"`            // messages waiting to be written to the client
+	Durable     bool   `json:"durable,omitempty"` // messages are queued while the client is offline
+}
+
+// remoteAddrConnection is a RawConnection knowing the address of its client. The connections of the
+// listeners of this package implement it, custom RawConnections can too.
+type remoteAddrConnection interface {
+	RemoteAddr() net.Addr
+}
+
+// connectionsReply answers a request for the active connections, sent by the run goroutine of the server.
+type connectionsReply chan []*ConnectionInfo
+
+// GetInfo describes the connection, without its subscriptions. Safe to call from any goroutine.
+func (conn *stompConn) GetInfo() *ConnectionInfo {
+	info := &ConnectionInfo{
+		Id:            conn.id,
+		Principal:     conn.GetPrincipal(),
+		ClientId:      conn.GetClientId(),
+		ConnectedAt:   conn.connectedAt,
+		FramesIn:      conn.framesIn.Load(),
+		FramesOut:     conn.framesOut.Load(),
+		RateLimited:   conn.rateLimited.Load(),
+		Subscriptions: make([]*SubscriptionInfo, 0),
+	}
+	if rc, ok := conn.rawConnection.(remoteAddrConnection); ok {
+		if addr := rc.RemoteAddr(); addr != nil {
+			info.RemoteAddress = addr.String()
+		}
+	}
+	return info
+}
+
+// connections describes every active connection and its subscriptions, ordered by the time they connected.
+// Only called by the run goroutine, which owns the connection and subscription maps.
+func (s *stompServer) connections() []*ConnectionInfo {
+	infos := make(map[string]*ConnectionInfo, len(s.connectionsMap))
+	for id, c := range s.connectionsMap {
+		infos[id] = c.GetInfo()
+	}
+	for _, subsMap := range s.subscriptionsMap {
+		for conId, conSub := range subsMap {
+			info, ok := infos[conId]
+			if !ok {
+				continue
+			}
+			for _, sub := range conSub.subscriptions {
+				info.Subscriptions = append(info.Subscriptions, sub.info())
+			}
+		}
+	}
+
+	list := make([]*ConnectionInfo, 0, len(infos))
+	for _, info := range infos {
+		sort.Slice(info.Subscriptions, func(i, j int) bool {
+			return info.Subscriptions[i].Id < info.Subscriptions[j].Id
+		})
+		list = append(list, info)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].ConnectedAt.Equal(list[j].ConnectedAt) {
+			return list[i].Id < list[j].Id
+		}
+		return list[i].ConnectedAt.Before(list[j].ConnectedAt)
+	})
+	return list
+}
+
+func (sub *Subscription) info() *SubscriptionInfo {
+	ackMode := sub.ackMode
+	if ackMode == "" {
+		ackMode = AckAuto
+	}
+	return &SubscriptionInfo{
+		Id:          sub.id,
+		Destination: sub.destination,
+		Ack:         ackMode,
+		Queued:      int(atomic.LoadInt32(&sub.queued)),
+		Durable:     sub.durable,
+	}
+}
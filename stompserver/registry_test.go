@@ -0,0 +1,57 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/stretchr/testify/assert"
+)
+
+type addrRawConnection struct {
+	*MockRawConnection
+}
+
+func (c *addrRawConnection) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(10, 0, 0, 7), Port: 61613}
+}
+
+func TestStompServer_Connections(t *testing.T) {
+	server, conListener := newTestStompServer(NewStompConfig(0, []string{"/pub"}))
+	assert.Nil(t, server.Connections())
+
+	go server.Start()
+
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	server.OnSubscribeEvent(func(conId string, subId string, destination string, frame *frame.Frame) {
+		wg.Done()
+	})
+
+	rawConn := NewMockRawConnection()
+	conListener.incomingConnections <- &addrRawConnection{rawConn}
+	rawConn.SendConnectFrame()
+	rawConn.incomingFrames <- frame.New(frame.SUBSCRIBE, frame.Id, "sub-2", frame.Destination, "/topic/b",
+		frame.Ack, AckClient)
+	rawConn.incomingFrames <- frame.New(frame.SUBSCRIBE, frame.Id, "sub-1", frame.Destination, "/topic/a")
+	wg.Wait()
+
+	connections := server.Connections()
+	if assert.Len(t, connections, 1) {
+		c := connections[0]
+		assert.NotEmpty(t, c.Id)
+		assert.Equal(t, "10.0.0.7:61613", c.RemoteAddress)
+		assert.Empty(t, c.Principal)
+		assert.False(t, c.ConnectedAt.IsZero())
+		assert.Equal(t, uint64(3), c.FramesIn)
+		assert.Equal(t, uint64(1), c.FramesOut)
+		assert.Equal(t, []*SubscriptionInfo{
+			{Id: "sub-1", Destination: "/topic/a", Ack: AckAuto},
+			{Id: "sub-2", Destination: "/topic/b", Ack: AckClient},
+		}, c.Subscriptions)
+	}
+}
@@ -0,0 +1,38 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import "context"
+
+type requestPrincipalKey struct{}
+
+// ContextWithPrincipal returns a copy of ctx carrying the principal an HTTP request was authenticated as
+// before it reached the broker, e.g. by a trusted reverse proxy. WebSocket connections upgraded from such
+// a request take the principal, unless an Authenticator says otherwise.
+func ContextWithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, requestPrincipalKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal set by ContextWithPrincipal, empty if there is none.
+func PrincipalFromContext(ctx context.Context) string {
+	principal, _ := ctx.Value(requestPrincipalKey{}).(string)
+	return principal
+}
+
+// requestPrincipalConnection is a RawConnection whose client may have been authenticated by the HTTP
+// request it was upgraded from.
+type requestPrincipalConnection interface {
+	// RequestPrincipal returns the principal of the request, empty if it was not authenticated.
+	RequestPrincipal() string
+}
+
+// RequestPrincipal returns the principal of the request the connection was upgraded from.
+func (c *WebSocketStompConnection) RequestPrincipal() string {
+	return c.principal
+}
+
+// RequestPrincipal returns the principal of the request the connection was upgraded from.
+func (c *jsonWebSocketConnection) RequestPrincipal() string {
+	return c.principal
+}
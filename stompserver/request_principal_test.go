@@ -0,0 +1,50 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebSocketConnection_RequestPrincipal(t *testing.T) {
+	router := mux.NewRouter()
+	listener, err := NewWebSocketConnectionFromExistingHttpServer(nil, router, "/fabric", nil, nil, false, nil)
+	require.NoError(t, err)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		router.ServeHTTP(w, r.WithContext(ContextWithPrincipal(r.Context(), "alice")))
+	}))
+	defer server.Close()
+
+	clientConn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/fabric", nil)
+	require.NoError(t, err)
+	defer clientConn.Close()
+	rawConn, err := listener.Accept()
+	require.NoError(t, err)
+	assert.Equal(t, "alice", rawConn.(requestPrincipalConnection).RequestPrincipal())
+
+	// the client is the principal of the request once connected
+	events := make(chan *ConnEvent, 10)
+	conn := NewStompConn(rawConn, NewStompConfig(0, []string{"/pub"}), events)
+	w, err := clientConn.NextWriter(websocket.TextMessage)
+	require.NoError(t, err)
+	require.NoError(t, frame.NewWriter(w).Write(frame.New(frame.CONNECT, frame.AcceptVersion, "1.2")))
+	require.NoError(t, w.Close())
+	assert.Equal(t, ConnectionEstablished, (<-events).eventType)
+	assert.Equal(t, "alice", conn.GetPrincipal())
+}
+
+func TestPrincipalFromContext(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	assert.Empty(t, PrincipalFromContext(r.Context()))
+	assert.Equal(t, "bob", PrincipalFromContext(ContextWithPrincipal(r.Context(), "bob")))
+}
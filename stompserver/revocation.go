@@ -0,0 +1,80 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+
+	"github.com/pb33f/ranch/clock"
+)
+
+// RevocationList is a concurrency safe set of revoked session tokens. A revoked token is remembered until
+// its expiry has passed (after which the issuer would reject it anyway), or forever if no expiry is known.
+type RevocationList struct {
+	tokens map[string]time.Time
+	lock   sync.RWMutex
+}
+
+// NewRevocationList creates an empty RevocationList.
+func NewRevocationList() *RevocationList {
+	return &RevocationList{tokens: make(map[string]time.Time)}
+}
+
+// Revoke adds a token to the list. A zero expiresAt keeps the token revoked indefinitely.
+func (r *RevocationList) Revoke(token string, expiresAt time.Time) {
+	if token == "" {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.tokens[token] = expiresAt
+}
+
+// IsRevoked returns true if the token has been revoked and has not yet expired.
+func (r *RevocationList) IsRevoked(token string) bool {
+	if token == "" {
+		return false
+	}
+	r.lock.RLock()
+	expiresAt, ok := r.tokens[token]
+	r.lock.RUnlock()
+	if !ok {
+		return false
+	}
+	if !expiresAt.IsZero() && clock.Now().After(expiresAt) {
+		r.lock.Lock()
+		delete(r.tokens, token)
+		r.lock.Unlock()
+		return false
+	}
+	return true
+}
+
+// Prune removes every token whose expiry has passed.
+func (r *RevocationList) Prune() {
+	now := clock.Now()
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for token, expiresAt := range r.tokens {
+		if !expiresAt.IsZero() && now.After(expiresAt) {
+			delete(r.tokens, token)
+		}
+	}
+}
+
+// RevocationMiddleware returns a MiddlewareFunc that rejects any frame from a connection whose session
+// token is on the revocation list. Returning an error sends an ERROR frame and closes the connection.
+func RevocationMiddleware(list *RevocationList) MiddlewareFunc {
+	return func(next FrameHandlerFunc) FrameHandlerFunc {
+		return func(conn StompConn, f *frame.Frame) error {
+			if list.IsRevoked(conn.GetSessionToken()) {
+				return revokedSessionTokenError
+			}
+			return next(conn, f)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/pb33f/ranch/clock"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRevocationTestConfig(list *RevocationList) StompConfig {
+	conf := NewStompConfig(0, []string{"/pub/"})
+	conf.SetMiddlewareRegistry(MiddlewareRegistry{"*": []MiddlewareFunc{RevocationMiddleware(list)}})
+	return conf
+}
+
+func TestRevocationList_RevokeAndExpire(t *testing.T) {
+	list := NewRevocationList()
+	assert.False(t, list.IsRevoked("token"))
+	assert.False(t, list.IsRevoked(""))
+
+	list.Revoke("token", time.Time{})
+	assert.True(t, list.IsRevoked("token"))
+
+	list.Revoke("expired", time.Now().Add(-time.Minute))
+	assert.False(t, list.IsRevoked("expired"))
+
+	list.Revoke("expiring", time.Now().Add(-time.Minute))
+	list.Prune()
+	list.lock.RLock()
+	_, ok := list.tokens["expiring"]
+	list.lock.RUnlock()
+	assert.False(t, ok)
+}
+
+func TestRevocationList_ExpiresOnClock(t *testing.T) {
+	fake := clock.NewFakeClock(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	clock.Set(fake)
+	defer clock.Reset()
+
+	list := NewRevocationList()
+	list.Revoke("token", fake.Now().Add(time.Hour))
+	fake.Advance(59 * time.Minute)
+	assert.True(t, list.IsRevoked("token"))
+	fake.Advance(2 * time.Minute)
+	assert.False(t, list.IsRevoked("token"))
+}
+
+func TestStompConn_SessionToken(t *testing.T) {
+	stompConn, rawConn, events := getTestStompConn(NewStompConfig(0, []string{}), nil)
+	rawConn.incomingFrames <- frame.New(frame.CONNECT, frame.AcceptVersion, "1.2", frame.Passcode, "secret")
+	<-events
+	assert.Equal(t, "secret", stompConn.GetSessionToken())
+
+	stompConn, rawConn, events = getTestStompConn(NewStompConfig(0, []string{}), nil)
+	rawConn.incomingFrames <- frame.New(frame.CONNECT, frame.AcceptVersion, "1.2",
+		frame.Passcode, "secret", "Authorization", "Bearer my-token")
+	<-events
+	assert.Equal(t, "my-token", stompConn.GetSessionToken())
+}
+
+func TestRevocationMiddleware_RejectsRevokedConnect(t *testing.T) {
+	list := NewRevocationList()
+	list.Revoke("revoked", time.Time{})
+	stompConn, rawConn, events := getTestStompConn(newRevocationTestConfig(list), nil)
+
+	rawConn.incomingFrames <- frame.New(frame.CONNECT, frame.AcceptVersion, "1.2", frame.Passcode, "revoked")
+
+	e := <-events
+	assert.Equal(t, ConnectionClosed, e.eventType)
+	verifyFrame(t, rawConn.LastSentFrame(), frame.New(frame.ERROR,
+		frame.Message, revokedSessionTokenError.Error()), true)
+	assert.Equal(t, closed, stompConn.state)
+}
+
+func TestRevocationMiddleware_RejectsSendAfterRevocation(t *testing.T) {
+	list := NewRevocationList()
+	stompConn, rawConn, events := getTestStompConn(newRevocationTestConfig(list), nil)
+
+	rawConn.incomingFrames <- frame.New(frame.CONNECT, frame.AcceptVersion, "1.2", frame.Passcode, "token")
+	e := <-events
+	assert.Equal(t, ConnectionEstablished, e.eventType)
+
+	list.Revoke("token", time.Time{})
+	rawConn.incomingFrames <- frame.New(frame.SEND, frame.Destination, "/pub/service")
+
+	e = <-events
+	assert.Equal(t, ConnectionClosed, e.eventType)
+	verifyFrame(t, rawConn.LastSentFrame(), frame.New(frame.ERROR,
+		frame.Message, revokedSessionTokenError.Error()), true)
+	assert.Equal(t, closed, stompConn.state)
+}
+
+func TestStompServer_DisconnectSessionToken(t *testing.T) {
+	wg := sync.WaitGroup{}
+	server, listener := newTestStompServer(NewStompConfig(0, []string{"/pub/"}))
+	var closedConns []string
+	server.SetConnectionEventCallback(ConnectionClosed, func(connEvent *ConnEvent) {
+		closedConns = append(closedConns, connEvent.ConnId)
+		wg.Done()
+	})
+	go server.Start()
+
+	connect := func(token string) *MockRawConnection {
+		rawConn := NewMockRawConnection()
+		listener.incomingConnections <- rawConn
+		// wait for the CONNECTED frame so the session token is known before revoking.
+		rawConn.writeWg = &sync.WaitGroup{}
+		rawConn.writeWg.Add(1)
+		rawConn.incomingFrames <- frame.New(frame.CONNECT, frame.AcceptVersion, "1.2", frame.Passcode, token)
+		rawConn.writeWg.Wait()
+		rawConn.writeWg = nil
+		return rawConn
+	}
+	revokedConn := connect("revoked")
+	otherConn := connect("valid")
+
+	wg.Add(1)
+	server.DisconnectSessionToken("revoked")
+	wg.Wait()
+
+	assert.Len(t, closedConns, 1)
+	assert.False(t, revokedConn.connected)
+	assert.True(t, otherConn.connected)
+}
@@ -0,0 +1,469 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// SelectorHeader filters the messages of a subscription when set on its SUBSCRIBE frame, only the messages
+// the selector expression matches are sent to the client. Selectors compare the headers and the JSON body
+// fields of a message with literals, and combine comparisons with AND, OR, NOT and parentheses:
+//
+//	header.priority >= 5 AND (body.payload.type = 'order' OR NOT body.payload.archived)
+//
+// header.<name> is the value of a header, body.<path> the value of a field of the JSON body, each dot
+// going one level down, numbers indexing arrays. Comparisons are =, !=, <, <=, > and >=, between numbers
+// when one side is a number, strings otherwise. A comparison with a missing value does not match, and a
+// value on its own matches if it is true.
+const SelectorHeader = "selector"
+
+// maxSelectorLength is the longest selector a subscription may set.
+const maxSelectorLength = 1024
+
+// selector is a parsed selector expression.
+type selector struct {
+	source string
+	root   selectorNode
+}
+
+// selectorNode is a node of a parsed selector expression, evaluating to a string, float64, bool, nil or a
+// value of a JSON body.
+type selectorNode interface {
+	eval(m *selectorMessage) any
+}
+
+// selectorMessage is a message selectors are evaluated against, its body is decoded once, by the first
+// selector reading a body field.
+type selectorMessage struct {
+	frame   *frame.Frame
+	body    any
+	decoded bool
+}
+
+func newSelectorMessage(f *frame.Frame) *selectorMessage {
+	return &selectorMessage{frame: f}
+}
+
+func (m *selectorMessage) decodedBody() any {
+	if !m.decoded {
+		m.decoded = true
+		// bodies that are not JSON have no fields
+		_ = json.Unmarshal(m.frame.Body, &m.body)
+	}
+	return m.body
+}
+
+// parseSelector parses a selector expression, returning nil for an empty one.
+func parseSelector(source string) (*selector, error) {
+	if strings.TrimSpace(source) == "" {
+		return nil, nil
+	}
+	if len(source) > maxSelectorLength {
+		return nil, fmt.Errorf("selector is longer than %d characters", maxSelectorLength)
+	}
+	tokens, err := tokenizeSelector(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &selectorParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in selector", p.tokens[p.pos].text)
+	}
+	return &selector{source: source, root: root}, nil
+}
+
+// matches returns whether a message is selected, messages always are by a nil selector.
+func (s *selector) matches(m *selectorMessage) bool {
+	if s == nil {
+		return true
+	}
+	return truthy(s.root.eval(m))
+}
+
+type selectorTokenKind int
+
+const (
+	tokenIdentifier selectorTokenKind = iota
+	tokenString
+	tokenNumber
+	tokenOperator
+	tokenOpenParen
+	tokenCloseParen
+)
+
+type selectorToken struct {
+	kind selectorTokenKind
+	text string
+}
+
+func tokenizeSelector(source string) ([]selectorToken, error) {
+	var tokens []selectorToken
+	runes := []rune(source)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, selectorToken{kind: tokenOpenParen, text: "("})
+			i++
+		case r == ')':
+			tokens = append(tokens, selectorToken{kind: tokenCloseParen, text: ")"})
+			i++
+		case r == '\'':
+			// quotes are escaped by doubling them
+			var b strings.Builder
+			i++
+			for {
+				if i >= len(runes) {
+					return nil, fmt.Errorf("unterminated string in selector")
+				}
+				if runes[i] == '\'' {
+					if i+1 < len(runes) && runes[i+1] == '\'' {
+						b.WriteRune('\'')
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				b.WriteRune(runes[i])
+				i++
+			}
+			tokens = append(tokens, selectorToken{kind: tokenString, text: b.String()})
+		case strings.ContainsRune("=!<>", r):
+			op := string(r)
+			if i+1 < len(runes) && runes[i+1] == '=' {
+				op += "="
+			}
+			if op == "!" {
+				return nil, fmt.Errorf("unexpected %q in selector", op)
+			}
+			tokens = append(tokens, selectorToken{kind: tokenOperator, text: op})
+			i += len(op)
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			start := i
+			i++
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, selectorToken{kind: tokenNumber, text: string(runes[start:i])})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) ||
+				strings.ContainsRune("_-.", runes[i])) {
+				i++
+			}
+			tokens = append(tokens, selectorToken{kind: tokenIdentifier, text: string(runes[start:i])})
+		default:
+			return nil, fmt.Errorf("unexpected %q in selector", r)
+		}
+	}
+	return tokens, nil
+}
+
+// selectorParser parses the tokens of a selector by recursive descent, OR binding looser than AND, and AND
+// looser than NOT.
+type selectorParser struct {
+	tokens []selectorToken
+	pos    int
+}
+
+func (p *selectorParser) peek() *selectorToken {
+	if p.pos < len(p.tokens) {
+		return &p.tokens[p.pos]
+	}
+	return nil
+}
+
+// keyword consumes the next token if it is the keyword, keywords are not case-sensitive.
+func (p *selectorParser) keyword(keyword string) bool {
+	t := p.peek()
+	if t != nil && t.kind == tokenIdentifier && strings.EqualFold(t.text, keyword) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *selectorParser) parseOr() (selectorNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{or: true, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *selectorParser) parseAnd() (selectorNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *selectorParser) parseNot() (selectorNode, error) {
+	if p.keyword("not") {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *selectorParser) parseComparison() (selectorNode, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	if t == nil || t.kind != tokenOperator {
+		return left, nil
+	}
+	p.pos++
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return &comparisonNode{op: t.text, left: left, right: right}, nil
+}
+
+func (p *selectorParser) parseOperand() (selectorNode, error) {
+	t := p.peek()
+	if t == nil {
+		return nil, fmt.Errorf("unexpected end of selector")
+	}
+	p.pos++
+	switch t.kind {
+	case tokenOpenParen:
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.peek(); closing == nil || closing.kind != tokenCloseParen {
+			return nil, fmt.Errorf("missing ) in selector")
+		}
+		p.pos++
+		return node, nil
+	case tokenString:
+		return literalNode{value: t.text}, nil
+	case tokenNumber:
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q in selector", t.text)
+		}
+		return literalNode{value: n}, nil
+	case tokenIdentifier:
+		switch {
+		case strings.EqualFold(t.text, "true"):
+			return literalNode{value: true}, nil
+		case strings.EqualFold(t.text, "false"):
+			return literalNode{value: false}, nil
+		case strings.HasPrefix(t.text, "header.") && len(t.text) > len("header."):
+			return headerNode{name: strings.TrimPrefix(t.text, "header.")}, nil
+		case strings.HasPrefix(t.text, "body.") && len(t.text) > len("body."):
+			return bodyNode{path: strings.Split(strings.TrimPrefix(t.text, "body."), ".")}, nil
+		}
+		return nil, fmt.Errorf("unknown field %q in selector, fields start with header. or body.", t.text)
+	}
+	return nil, fmt.Errorf("unexpected %q in selector", t.text)
+}
+
+type literalNode struct {
+	value any
+}
+
+func (n literalNode) eval(*selectorMessage) any {
+	return n.value
+}
+
+type headerNode struct {
+	name string
+}
+
+func (n headerNode) eval(m *selectorMessage) any {
+	if value, ok := m.frame.Header.Contains(n.name); ok {
+		return value
+	}
+	return nil
+}
+
+type bodyNode struct {
+	path []string
+}
+
+func (n bodyNode) eval(m *selectorMessage) any {
+	value := m.decodedBody()
+	for _, key := range n.path {
+		switch v := value.(type) {
+		case map[string]any:
+			value = v[key]
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil
+			}
+			value = v[i]
+		default:
+			return nil
+		}
+	}
+	return value
+}
+
+type notNode struct {
+	operand selectorNode
+}
+
+func (n *notNode) eval(m *selectorMessage) any {
+	return !truthy(n.operand.eval(m))
+}
+
+type logicalNode struct {
+	or          bool
+	left, right selectorNode
+}
+
+func (n *logicalNode) eval(m *selectorMessage) any {
+	if n.or {
+		return truthy(n.left.eval(m)) || truthy(n.right.eval(m))
+	}
+	return truthy(n.left.eval(m)) && truthy(n.right.eval(m))
+}
+
+type comparisonNode struct {
+	op          string
+	left, right selectorNode
+}
+
+func (n *comparisonNode) eval(m *selectorMessage) any {
+	left, right := n.left.eval(m), n.right.eval(m)
+	if left == nil || right == nil {
+		return false
+	}
+	if l, ok := left.(bool); ok {
+		r, ok := right.(bool)
+		if !ok {
+			r, ok = parseBool(right)
+		}
+		return ok && n.compareEqual(l == r)
+	}
+	if r, ok := right.(bool); ok {
+		l, ok := parseBool(left)
+		return ok && n.compareEqual(l == r)
+	}
+	_, leftNumber := left.(float64)
+	_, rightNumber := right.(float64)
+	if leftNumber || rightNumber {
+		l, lok := toNumber(left)
+		r, rok := toNumber(right)
+		if !lok || !rok {
+			return false
+		}
+		return n.compare(compareFloats(l, r))
+	}
+	l, lok := left.(string)
+	r, rok := right.(string)
+	if !lok || !rok {
+		return false
+	}
+	return n.compare(strings.Compare(l, r))
+}
+
+// compareEqual applies an equality operator, values that are only equal or not cannot be ordered.
+func (n *comparisonNode) compareEqual(equal bool) bool {
+	switch n.op {
+	case "=":
+		return equal
+	case "!=":
+		return !equal
+	}
+	return false
+}
+
+func (n *comparisonNode) compare(c int) bool {
+	switch n.op {
+	case "=":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
+
+func compareFloats(l, r float64) int {
+	switch {
+	case l < r:
+		return -1
+	case l > r:
+		return 1
+	}
+	return 0
+}
+
+func toNumber(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case string:
+		n, err := strconv.ParseFloat(v, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// parseBool reads the booleans headers carry as strings.
+func parseBool(value any) (bool, bool) {
+	if s, ok := value.(string); ok {
+		b, err := strconv.ParseBool(s)
+		return b, err == nil
+	}
+	return false, false
+}
+
+func truthy(value any) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		b, _ := strconv.ParseBool(v)
+		return b
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelector_Matches(t *testing.T) {
+	f := frame.New(frame.MESSAGE, "priority", "7", "region", "eu-west", "urgent", "true")
+	f.Body = []byte(`{"payload":{"type":"order","total":12.5,"archived":false,"items":[{"sku":"cow"}],"note":"it's"}}`)
+
+	for source, matches := range map[string]bool{
+		"":                               true,
+		"header.priority = 7":            true,
+		"header.priority >= 10":          false,
+		"header.priority > '10'":         true, // strings compare lexically
+		"header.region = 'eu-west'":      true,
+		"header.region != 'eu-west'":     false,
+		"header.region < 'us'":           true,
+		"header.urgent":                  true,
+		"header.urgent = false":          false,
+		"header.missing != 'x'":          false,
+		"NOT header.missing = 'x'":       true,
+		"body.payload.type = 'order'":    true,
+		"body.payload.total < 20":        true,
+		"body.payload.total = -12.5":     false,
+		"body.payload.archived":          false,
+		"not body.payload.archived":      true,
+		"body.payload.items.0.sku='cow'": true,
+		"body.payload.items.1.sku='cow'": false,
+		"body.payload.note = 'it''s'":    true,
+		"body.payload = 'order'":         false,
+		"header.priority > 5 AND (body.payload.type = 'refund' OR body.payload.total > 10)": true,
+		"header.priority > 5 and body.payload.type = 'refund' or body.payload.total > 100":  false,
+	} {
+		sel, err := parseSelector(source)
+		require.NoError(t, err, source)
+		assert.Equal(t, matches, sel.matches(newSelectorMessage(f)), source)
+	}
+
+	// bodies that are not JSON have no fields
+	sel, err := parseSelector("body.type = 'order' OR header.priority = 7")
+	require.NoError(t, err)
+	assert.True(t, sel.matches(newSelectorMessage(frame.New(frame.MESSAGE, "priority", "7"))))
+}
+
+func TestParseSelector_Invalid(t *testing.T) {
+	for _, source := range []string{
+		"priority = 7",
+		"header.priority =",
+		"header.priority = 'open",
+		"(header.priority = 7",
+		"header.priority = 7)",
+		"header.priority ! 7",
+		"header.priority = 7 header.region = 'eu'",
+		"header. = 7",
+		"header.priority ~ 7",
+		"body.total = " + string(make([]byte, maxSelectorLength)),
+	} {
+		_, err := parseSelector(source)
+		assert.Error(t, err, source)
+	}
+}
+
+func TestStompServer_Selector(t *testing.T) {
+	config := NewStompConfig(0, []string{"/pub/"})
+	config.SetQueuePrefix("/queue")
+	server, listener := newTestStompServer(config)
+	subscribed := make(chan string, 10)
+	server.OnSubscribeEvent(func(conId string, subId string, destination string, f *frame.Frame) {
+		subscribed <- conId
+	})
+	go server.Start()
+
+	subscribe := func(conn *MockRawConnection, subId string, destination string, selector string) string {
+		conn.incomingFrames <- frame.New(frame.SUBSCRIBE, frame.Destination, destination, frame.Id, subId,
+			SelectorHeader, selector)
+		return receive(t, subscribed)
+	}
+	orders, refunds := NewMockRawConnection(), NewMockRawConnection()
+	for _, conn := range []*MockRawConnection{orders, refunds} {
+		listener.incomingConnections <- conn
+		conn.SendConnectFrame()
+	}
+	subscribe(orders, "o1", "/topic/sales", "body.type = 'order'")
+	refundsId := subscribe(refunds, "r1", "/topic/sales", "body.type = 'refund'")
+	subscribe(orders, "o2", "/queue/sales", "body.type = 'order'")
+	subscribe(refunds, "r2", "/queue/sales", "body.type = 'refund'")
+
+	// topic subscribers only receive the messages their selector matches
+	server.SendMessage("/topic/sales", []byte(`{"type":"order","id":1}`))
+	server.SendMessage("/topic/sales", []byte(`{"type":"refund","id":2}`))
+	server.SendMessage("/topic/sales", []byte(`{"type":"quote","id":3}`))
+	server.SendMessageToClient(refundsId, "/topic/sales", []byte(`{"type":"order","id":4}`))
+	assert.Equal(t, []string{`{"type":"order","id":1}`}, messages(t, orders, 1))
+	assert.Equal(t, []string{`{"type":"refund","id":2}`}, messages(t, refunds, 1))
+
+	// queue messages go to the next subscriber whose selector matches them
+	server.SendMessage("/queue/sales", []byte(`{"type":"refund","id":5}`))
+	server.SendMessage("/queue/sales", []byte(`{"type":"refund","id":6}`))
+	server.SendMessage("/queue/sales", []byte(`{"type":"quote","id":7}`))
+	assert.Len(t, messages(t, orders, 1), 1)
+	assert.Equal(t, []string{`{"type":"refund","id":2}`, `{"type":"refund","id":5}`, `{"type":"refund","id":6}`},
+		messages(t, refunds, 3))
+
+	// invalid selectors are refused
+	orders.incomingFrames <- frame.New(frame.SUBSCRIBE, frame.Destination, "/topic/sales", frame.Id, "o3",
+		SelectorHeader, "type = 'order'")
+	assert.Eventually(t, func() bool {
+		orders.lock.Lock()
+		defer orders.lock.Unlock()
+		last := orders.sentFrames[len(orders.sentFrames)-1]
+		return last.Command == frame.ERROR && last.Header.Get(frame.Message) != ""
+	}, time.Second, 5*time.Millisecond)
+}
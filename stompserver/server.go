@@ -4,10 +4,14 @@
 package stompserver
 
 import (
+    "context"
     "github.com/go-stomp/stomp/v3/frame"
-    "log"
+    "github.com/google/uuid"
+    "github.com/pb33f/ranch/log"
     "strconv"
     "sync"
+    "sync/atomic"
+    "time"
 )
 
 type SubscribeHandlerFunction func(conId string, subId string, destination string, frame *frame.Frame)
@@ -16,11 +20,18 @@ type UnsubscribeHandlerFunction func(conId string, subId string, destination str
 
 type ApplicationRequestHandlerFunction func(destination string, message []byte, connectionId string)
 
+// ApplicationRequestWithReplyHandlerFunction receives application requests along with the temporary reply
+// destination of their session they named in a ReplyToHeader, empty if they named none.
+type ApplicationRequestWithReplyHandlerFunction func(destination string, message []byte, connectionId string,
+    replyTo string)
+
 type StompServer interface {
     // starts the server
     Start()
     // stops the server
     Stop()
+    // closes the connections gracefully before the server stops, see Drain
+    Drain(ctx context.Context, grace time.Duration)
     // sends a message to a given stomp topic destination
     SendMessage(destination string, messageBody []byte)
     // sends a message to a single connection client
@@ -31,9 +42,21 @@ type StompServer interface {
     OnUnsubscribeEvent(callback UnsubscribeHandlerFunction)
     // registers a callback for application requests
     OnApplicationRequest(callback ApplicationRequestHandlerFunction)
+    // registers a callback for application requests, receiving their temporary reply destination
+    OnApplicationRequestWithReply(callback ApplicationRequestWithReplyHandlerFunction)
     // SetConnectionEventCallback is used to set up a callback when certain STOMP session events happen
     // such as ConnectionStarting, ConnectionClosed, SubscribeToTopic, UnsubscribeFromTopic and IncomingMessage.
     SetConnectionEventCallback(connEventType StompSessionEventType, cb func(connEvent *ConnEvent))
+    // closes every connection that was established with the given session token
+    DisconnectSessionToken(token string)
+    // describes every active connection and its subscriptions, nil if the server is not running
+    Connections() []*ConnectionInfo
+    // records the frames clients send and the frames sent to them with the recorder, nil stops recording.
+    // returns the recorder replaced, nil if there was none
+    SetRecorder(recorder *Recorder) *Recorder
+    // carries the messages sent with SendMessage to the other instances of a deployment, and theirs to the
+    // clients of this one, nil stops. returns the backplane replaced, nil if there was none
+    SetBackplane(backplane Backplane) Backplane
 }
 
 type StompSessionEventType int
@@ -45,6 +68,10 @@ const (
     SubscribeToTopic
     UnsubscribeFromTopic
     IncomingMessage
+    MessageAcknowledged  // a client acknowledged a message sent to a subscription in a client ack mode
+    MessageNacked        // a client refused a message sent to a subscription in a client ack mode
+    BackpressureApplied  // messages queued up for a subscription, its client was told to slow down
+    BackpressureReleased // the queue of a subscription drained, its client was told it may resume
 )
 
 type ConnEvent struct {
@@ -54,6 +81,29 @@ type ConnEvent struct {
     destination string
     sub         *Subscription
     frame       *frame.Frame
+    queued      int
+    reason      string
+}
+
+// Destination returns the destination of the subscription or message the event is about.
+func (e *ConnEvent) Destination() string {
+    return e.destination
+}
+
+// Frame returns the frame the event is about: the SUBSCRIBE frame, the incoming message, or the message
+// that was acknowledged or refused.
+func (e *ConnEvent) Frame() *frame.Frame {
+    return e.frame
+}
+
+// Reason returns why the connection of a ConnectionClosed event was closed, one of the CloseReason constants.
+func (e *ConnEvent) Reason() string {
+    return e.reason
+}
+
+// Queued returns the number of messages queued for the subscription of a backpressure event.
+func (e *ConnEvent) Queued() int {
+    return e.queued
 }
 
 type apiEventType int
@@ -62,6 +112,9 @@ const (
     closeServer apiEventType = iota
     sendMessage
     sendPrivateMessage
+    disconnectSessionToken
+    listConnections
+    drainConnections
 )
 
 type apiEvent struct {
@@ -69,6 +122,10 @@ type apiEvent struct {
     connId      string
     frame       *frame.Frame
     destination string
+    token       string
+    reply       connectionsReply
+    grace       time.Duration
+    drained     chan struct{}
 }
 
 type connSubscriptions struct {
@@ -91,11 +148,20 @@ type stompServer struct {
     running                     bool
     connectionsMap              map[string]StompConn
     subscriptionsMap            map[string]map[string]*connSubscriptions
+    durables                    map[string]map[string]*durableSubscription // durable subscriptions by destination and client id
+    queues                      map[string]*queue                          // subscriptions of the point-to-point queues by destination
     config                      StompConfig
     callbackLock                sync.RWMutex
     subscribeCallbacks          []SubscribeHandlerFunction
     unsubscribeCallbacks        []UnsubscribeHandlerFunction
     applicationRequestCallbacks []ApplicationRequestHandlerFunction
+    replyRequestCallbacks       []ApplicationRequestWithReplyHandlerFunction
+    recorder                    atomic.Pointer[Recorder]
+    draining                    atomic.Bool   // set once the server drains, new connections are refused
+    drained                     chan struct{} // closed once every connection is closed while draining
+    drainGrace                  time.Duration
+    backplane                   atomic.Pointer[Backplane]
+    node                        string // identifies the server on the backplane
 }
 
 func NewStompServer(listener RawConnectionListener, config StompConfig) StompServer {
@@ -107,9 +173,13 @@ func NewStompServer(listener RawConnectionListener, config StompConfig) StompSer
         connectionEvents:            make(chan *ConnEvent, 64),
         connectionEventCallbacks:    make(map[StompSessionEventType]func(event *ConnEvent)),
         subscriptionsMap:            make(map[string]map[string]*connSubscriptions),
+        durables:                    make(map[string]map[string]*durableSubscription),
+        queues:                      make(map[string]*queue),
         subscribeCallbacks:          make([]SubscribeHandlerFunction, 0),
         unsubscribeCallbacks:        make([]UnsubscribeHandlerFunction, 0),
         applicationRequestCallbacks: make([]ApplicationRequestHandlerFunction, 0),
+        replyRequestCallbacks:       make([]ApplicationRequestWithReplyHandlerFunction, 0),
+        node:                        uuid.NewString(),
     }
 
     return server
@@ -136,38 +206,71 @@ func (s *stompServer) OnApplicationRequest(callback ApplicationRequestHandlerFun
     s.applicationRequestCallbacks = append(s.applicationRequestCallbacks, callback)
 }
 
-func (s *stompServer) SendMessage(destination string, messageBody []byte) {
-
-    // create send frame.
-    f := frame.New(frame.MESSAGE,
-        frame.Destination, destination,
-        frame.ContentLength, strconv.Itoa(len(messageBody)),
-        frame.ContentType, "application/json;charset=UTF-8")
+func (s *stompServer) OnApplicationRequestWithReply(callback ApplicationRequestWithReplyHandlerFunction) {
+    s.callbackLock.Lock()
+    defer s.callbackLock.Unlock()
 
-    f.Body = messageBody
+    s.replyRequestCallbacks = append(s.replyRequestCallbacks, callback)
+}
 
+func (s *stompServer) SendMessage(destination string, messageBody []byte) {
     s.apiEvents <- &apiEvent{
         eventType:   sendMessage,
         destination: destination,
-        frame:       f,
+        frame:       newMessageFrame(destination, messageBody),
     }
+    s.publishBackplaneMessage(destination, messageBody)
 }
 
 func (s *stompServer) SendMessageToClient(connectionId string, destination string, messageBody []byte) {
+    s.apiEvents <- &apiEvent{
+        eventType:   sendPrivateMessage,
+        destination: destination,
+        frame:       newMessageFrame(destination, messageBody),
+        connId:      connectionId,
+    }
+}
 
-    // create send frame.
+// newMessageFrame creates the MESSAGE frame of a message sent to the subscribers of a destination.
+func newMessageFrame(destination string, messageBody []byte) *frame.Frame {
     f := frame.New(frame.MESSAGE,
         frame.Destination, destination,
         frame.ContentLength, strconv.Itoa(len(messageBody)),
         frame.ContentType, "application/json;charset=UTF-8")
-
     f.Body = messageBody
+    return f
+}
 
+func (s *stompServer) DisconnectSessionToken(token string) {
+    if token == "" {
+        return
+    }
     s.apiEvents <- &apiEvent{
-        eventType:   sendPrivateMessage,
-        destination: destination,
-        frame:       f,
-        connId:      connectionId,
+        eventType: disconnectSessionToken,
+        token:     token,
+    }
+}
+
+func (s *stompServer) Connections() []*ConnectionInfo {
+    if !s.running {
+        return nil
+    }
+    reply := make(connectionsReply, 1)
+    s.apiEvents <- &apiEvent{
+        eventType: listConnections,
+        reply:     reply,
+    }
+    return <-reply
+}
+
+func (s *stompServer) SetRecorder(recorder *Recorder) *Recorder {
+    return s.recorder.Swap(recorder)
+}
+
+// record captures a frame with the recorder, if recording.
+func (s *stompServer) record(direction string, conId string, dest string, f *frame.Frame) {
+    if recorder := s.recorder.Load(); recorder != nil {
+        recorder.Record(direction, conId, dest, f)
     }
 }
 
@@ -205,10 +308,14 @@ func (s *stompServer) waitForConnections() {
         rawConn, err := s.connectionListener.Accept()
         if err != nil {
             if s.running {
-                log.Println("Failed to establish client connection:", err)
+                log.Logger().Warn("[ranch] failed to establish client connection", "error", err.Error())
             }
             continue
         }
+        if s.draining.Load() {
+            rawConn.Close()
+            continue
+        }
 
         c := NewStompConn(rawConn, s.config, s.connectionEvents)
 
@@ -237,6 +344,12 @@ func (s *stompServer) run() {
                 s.sendFrame(apiEvent.destination, apiEvent.frame)
             } else if apiEvent.eventType == sendPrivateMessage {
                 s.sendFrameToClient(apiEvent.connId, apiEvent.destination, apiEvent.frame)
+            } else if apiEvent.eventType == disconnectSessionToken {
+                s.closeConnectionsWithToken(apiEvent.token)
+            } else if apiEvent.eventType == listConnections {
+                apiEvent.reply <- s.connections()
+            } else if apiEvent.eventType == drainConnections {
+                s.drainConnections(apiEvent.grace, apiEvent.drained)
             }
 
         case e, _ := <-s.connectionEvents:
@@ -253,6 +366,10 @@ func (s *stompServer) handleConnectionEvent(e *ConnEvent) {
     switch e.eventType {
     case ConnectionStarting:
         s.connectionsMap[e.conn.GetId()] = e.conn
+        // accepted before the server started draining
+        if s.drained != nil {
+            e.conn.Drain(s.drainGrace)
+        }
         if fn, exists := s.connectionEventCallbacks[ConnectionStarting]; exists {
             fn(e)
         }
@@ -264,15 +381,19 @@ func (s *stompServer) handleConnectionEvent(e *ConnEvent) {
             if ok {
                 delete(connSubscriptions, e.conn.GetId())
                 for _, sub := range conSub.subscriptions {
-                    for _, callback := range s.unsubscribeCallbacks {
-                        callback(e.conn.GetId(), sub.id, sub.destination)
+                    s.queueUnsubscribed(e.conn, sub)
+                    // durable subscriptions are kept while their client is offline
+                    if !s.durableOffline(e.conn, sub) {
+                        s.notifyUnsubscribe(e.conn.GetId(), sub.id, sub.destination)
                     }
                 }
             }
         }
+        s.expireDurables()
         if fn, exists := s.connectionEventCallbacks[ConnectionClosed]; exists {
             fn(e)
         }
+        s.checkDrained()
 
     case SubscribeToTopic:
         subsMap, ok := s.subscriptionsMap[e.destination]
@@ -287,11 +408,13 @@ func (s *stompServer) handleConnectionEvent(e *ConnEvent) {
             subsMap[e.conn.GetId()] = conSub
         }
         conSub.subscriptions[e.sub.id] = e.sub
+        s.queueSubscribed(e.conn, e.sub)
 
         // notify listeners
         for _, callback := range s.subscribeCallbacks {
             callback(e.conn.GetId(), e.sub.id, e.destination, e.frame)
         }
+        s.durableSubscribed(e)
         if fn, exists := s.connectionEventCallbacks[SubscribeToTopic]; exists {
             fn(e)
         }
@@ -305,10 +428,10 @@ func (s *stompServer) handleConnectionEvent(e *ConnEvent) {
                 _, ok = conSub.subscriptions[e.sub.id]
                 if ok {
                     delete(conSub.subscriptions, e.sub.id)
+                    s.queueUnsubscribed(e.conn, e.sub)
+                    s.durableUnsubscribed(e.conn, e.sub)
                     // notify listeners
-                    for _, callback := range s.unsubscribeCallbacks {
-                        callback(e.conn.GetId(), e.sub.id, e.destination)
-                    }
+                    s.notifyUnsubscribe(e.conn.GetId(), e.sub.id, e.destination)
                 }
             }
         }
@@ -317,37 +440,78 @@ func (s *stompServer) handleConnectionEvent(e *ConnEvent) {
         }
 
     case IncomingMessage:
-        if s.config.IsAppRequestDestination(e.destination) && e.conn != nil {
+        if e.conn != nil {
+            s.record(RecordedIncoming, e.conn.GetId(), e.destination, e.frame)
+        }
+        if s.config.IsQueueDestination(e.destination) {
+            s.sendFrame(e.destination, e.frame)
+        } else if s.config.IsAppRequestDestination(e.destination) && e.conn != nil {
             // notify app listeners
             for _, callback := range s.applicationRequestCallbacks {
                 callback(e.destination, e.frame.Body, e.conn.GetId())
             }
+            replyTo := s.replyTo(e.frame)
+            for _, callback := range s.replyRequestCallbacks {
+                callback(e.destination, e.frame.Body, e.conn.GetId(), replyTo)
+            }
         }
         if fn, exists := s.connectionEventCallbacks[IncomingMessage]; exists {
             fn(e)
         }
+
+    case MessageAcknowledged, MessageNacked, BackpressureApplied, BackpressureReleased:
+        if fn, exists := s.connectionEventCallbacks[e.eventType]; exists {
+            fn(e)
+        }
     }
 }
 
 func (s *stompServer) sendFrame(dest string, f *frame.Frame) {
+    // each session has its own temporary reply destinations, only messages to one session reach them
+    if s.config.IsTempQueueDestination(dest) {
+        return
+    }
+    s.record(RecordedOutgoing, "", dest, f)
+    if s.config.IsQueueDestination(dest) {
+        s.sendToQueue(dest, newSelectorMessage(f))
+        return
+    }
+    msg := newSelectorMessage(f)
     subsMap, ok := s.subscriptionsMap[dest]
     if ok {
         for _, connSub := range subsMap {
             for _, sub := range connSub.subscriptions {
-                connSub.conn.SendFrameToSubscription(f.Clone(), sub)
+                if sub.selector.matches(msg) {
+                    connSub.conn.SendFrameToSubscription(f.Clone(), sub)
+                }
             }
         }
     }
+    s.queueDurable(dest, msg)
 }
 
 func (s *stompServer) sendFrameToClient(conId string, dest string, f *frame.Frame) {
+    s.record(RecordedOutgoing, conId, dest, f)
     subsMap, ok := s.subscriptionsMap[dest]
     if ok {
         connSubscriptions, ok := subsMap[conId]
         if ok {
+            msg := newSelectorMessage(f)
             for _, sub := range connSubscriptions.subscriptions {
-                connSubscriptions.conn.SendFrameToSubscription(f.Clone(), sub)
+                if sub.selector.matches(msg) {
+                    connSubscriptions.conn.SendFrameToSubscription(f.Clone(), sub)
+                }
             }
         }
     }
 }
+
+func (s *stompServer) closeConnectionsWithToken(token string) {
+    for _, c := range s.connectionsMap {
+        if c.GetSessionToken() == token {
+            c.SendError(revokedSessionTokenError)
+            // closing publishes onto connectionEvents, which this goroutine consumes, so don't block on it.
+            go c.Close()
+        }
+    }
+}
@@ -27,7 +27,7 @@ func NewMockRawConnectionListener() *MockRawConnectionListener {
 
 func (cl *MockRawConnectionListener) Accept() (RawConnection, error) {
     obj := <-cl.incomingConnections
-    mockConn, ok := obj.(*MockRawConnection)
+    mockConn, ok := obj.(RawConnection)
     if ok {
         return mockConn, nil
     }
@@ -0,0 +1,178 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"sort"
+	"sync/atomic"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// Subscription ack modes, set by the ack header of the SUBSCRIBE frame.
+const (
+	AckAuto             = "auto"              // messages are consumed once sent, the default
+	AckClient           = "client"            // an ACK or NACK applies to the message and every earlier one
+	AckClientIndividual = "client-individual" // an ACK or NACK applies to a single message
+)
+
+// MaxPendingAcks is how many messages a connection may leave unacknowledged, across its subscriptions in a
+// client ack mode. A client that falls further behind is sent an ERROR frame and disconnected.
+var MaxPendingAcks = 1024
+
+// pendingAck is a message sent to a subscription in a client ack mode, waiting for an ACK or NACK.
+type pendingAck struct {
+	sub   *Subscription
+	seq   uint64 // the message-id of the message, orders the messages of a subscription
+	frame *frame.Frame
+}
+
+func validAckMode(mode string) bool {
+	return mode == AckAuto || mode == AckClient || mode == AckClientIndividual
+}
+
+// trackAck adds the ack header to a message sent to a subscription in a client ack mode, and keeps the
+// message until the client acknowledges it. Returns an error if the client left too many messages
+// unacknowledged. Only called by the run goroutine.
+func (conn *stompConn) trackAck(f *frame.Frame, messageId string) error {
+	sub := conn.subscriptions[f.Header.Get(frame.Subscription)]
+	if sub == nil || sub.ackMode == "" || sub.ackMode == AckAuto {
+		return nil
+	}
+	if len(conn.pendingAcks) >= MaxPendingAcks {
+		return tooManyPendingAcksError
+	}
+	if conn.version == stomp.V12 {
+		f.Header.Set(frame.Ack, messageId)
+	}
+	conn.pendingAcks[messageId] = &pendingAck{sub: sub, seq: conn.currentMessageId, frame: f}
+	return nil
+}
+
+// handleAck handles ACK and NACK frames. STOMP 1.2 clients name the message by the ack header sent with
+// it, echoed in the id header, older clients by its message-id.
+func (conn *stompConn) handleAck(f *frame.Frame) error {
+	switch atomic.LoadInt32(&conn.state) {
+	case connecting:
+		return notConnectedStompError
+	case closed:
+		return nil
+	}
+
+	header := frame.MessageId
+	if conn.version == stomp.V12 {
+		header = frame.Id
+	}
+	ackId, ok := f.Header.Contains(header)
+	if !ok {
+		return missingAckIdError
+	}
+
+	nack := f.Command == frame.NACK
+	if err := conn.inTransaction(f, func() { conn.applyAck(ackId, nack) }); err != nil {
+		return err
+	}
+	return conn.sendReceiptResponse(f)
+}
+
+// applyAck consumes the acknowledged message, along with the earlier messages of the subscription when it
+// is in client ack mode. Messages that were already acknowledged, or whose subscription is gone, are
+// ignored. Every consumed message is reported as a MessageAcknowledged or MessageNacked event. The broker
+// relays topics rather than queueing messages, so a NACKed message is not redelivered, it is up to the
+// MessageNacked listener to send it again or give up on it.
+func (conn *stompConn) applyAck(ackId string, nack bool) {
+	acked, ok := conn.pendingAcks[ackId]
+	if !ok {
+		return
+	}
+
+	consumed := []*pendingAck{acked}
+	delete(conn.pendingAcks, ackId)
+	if acked.sub.ackMode == AckClient {
+		for id, p := range conn.pendingAcks {
+			if p.sub == acked.sub && p.seq < acked.seq {
+				consumed = append(consumed, p)
+				delete(conn.pendingAcks, id)
+			}
+		}
+		sort.Slice(consumed, func(i, j int) bool { return consumed[i].seq < consumed[j].seq })
+	}
+
+	eventType := MessageAcknowledged
+	if nack {
+		eventType = MessageNacked
+	}
+	for _, p := range consumed {
+		conn.events <- &ConnEvent{
+			ConnId:      conn.GetId(),
+			eventType:   eventType,
+			destination: p.sub.destination,
+			conn:        conn,
+			sub:         p.sub,
+			frame:       p.frame,
+		}
+	}
+}
+
+// dropPendingAcks forgets the messages of a subscription that is gone, they can no longer be acknowledged.
+func (conn *stompConn) dropPendingAcks(sub *Subscription) {
+	for id, p := range conn.pendingAcks {
+		if p.sub == sub {
+			delete(conn.pendingAcks, id)
+		}
+	}
+}
+
+// handleTransaction handles BEGIN, COMMIT and ABORT frames. The SEND, ACK and NACK frames of a transaction
+// take effect when it is committed, in the order they were received, and not at all if it is aborted.
+func (conn *stompConn) handleTransaction(f *frame.Frame) error {
+	switch atomic.LoadInt32(&conn.state) {
+	case connecting:
+		return notConnectedStompError
+	case closed:
+		return nil
+	}
+
+	tx, ok := f.Header.Contains(frame.Transaction)
+	if !ok || tx == "" {
+		return missingTransactionError
+	}
+
+	switch f.Command {
+	case frame.BEGIN:
+		if _, exists := conn.transactions[tx]; exists {
+			return duplicateTransactionError
+		}
+		conn.transactions[tx] = []func(){}
+	case frame.COMMIT, frame.ABORT:
+		ops, exists := conn.transactions[tx]
+		if !exists {
+			return unknownTransactionError
+		}
+		delete(conn.transactions, tx)
+		if f.Command == frame.COMMIT {
+			for _, op := range ops {
+				op()
+			}
+		}
+	}
+	return conn.sendReceiptResponse(f)
+}
+
+// inTransaction runs op now if the frame is not part of a transaction, or when its transaction is
+// committed.
+func (conn *stompConn) inTransaction(f *frame.Frame, op func()) error {
+	tx, ok := f.Header.Contains(frame.Transaction)
+	if !ok {
+		op()
+		return nil
+	}
+	ops, exists := conn.transactions[tx]
+	if !exists {
+		return unknownTransactionError
+	}
+	conn.transactions[tx] = append(ops, op)
+	return nil
+}
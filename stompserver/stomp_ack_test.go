@@ -0,0 +1,194 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/stretchr/testify/assert"
+)
+
+// subscribeWithAck connects a STOMP client of the given version and subscribes it with an ack mode.
+func subscribeWithAck(t *testing.T, version string, ackMode string) (*stompConn, *MockRawConnection, chan *ConnEvent) {
+	stompConn, rawConn, events := getTestStompConn(NewStompConfig(0, []string{"/pub/"}), nil)
+	rawConn.incomingFrames <- frame.New(frame.CONNECT, frame.AcceptVersion, version)
+	assert.Equal(t, ConnectionEstablished, (<-events).eventType)
+
+	rawConn.incomingFrames <- frame.New(frame.SUBSCRIBE,
+		frame.Id, "sub-id",
+		frame.Destination, "/topic/test",
+		frame.Ack, ackMode)
+	e := <-events
+	assert.Equal(t, SubscribeToTopic, e.eventType)
+	assert.Equal(t, ackMode, e.sub.ackMode)
+	return stompConn, rawConn, events
+}
+
+// sendMessages sends count messages to the subscription and waits until they are written.
+func sendMessages(stompConn *stompConn, rawConn *MockRawConnection, count int) {
+	rawConn.lock.Lock()
+	rawConn.writeWg = &sync.WaitGroup{}
+	rawConn.writeWg.Add(count)
+	rawConn.lock.Unlock()
+	for i := 0; i < count; i++ {
+		stompConn.SendFrameToSubscription(frame.New(frame.MESSAGE, frame.Destination, "/topic/test"),
+			stompConn.subscriptions["sub-id"])
+	}
+	rawConn.writeWg.Wait()
+	rawConn.lock.Lock()
+	rawConn.writeWg = nil
+	rawConn.lock.Unlock()
+}
+
+func TestStompConn_SubscribeInvalidAckMode(t *testing.T) {
+	_, rawConn, events := getTestStompConn(NewStompConfig(0, []string{}), nil)
+	rawConn.SendConnectFrame()
+	<-events
+
+	rawConn.incomingFrames <- frame.New(frame.SUBSCRIBE,
+		frame.Id, "sub-id",
+		frame.Destination, "/topic/test",
+		frame.Ack, "whenever")
+
+	assert.Equal(t, ConnectionClosed, (<-events).eventType)
+	verifyFrame(t, rawConn.LastSentFrame(), frame.New(frame.ERROR,
+		frame.Message, invalidAckModeError.Error()), true)
+}
+
+func TestStompConn_AutoAck(t *testing.T) {
+	stompConn, rawConn, _ := subscribeWithAck(t, "1.2", AckAuto)
+	sendMessages(stompConn, rawConn, 1)
+
+	_, ok := rawConn.LastSentFrame().Header.Contains(frame.Ack)
+	assert.False(t, ok)
+	assert.Empty(t, stompConn.pendingAcks)
+}
+
+func TestStompConn_ClientIndividualAck(t *testing.T) {
+	stompConn, rawConn, events := subscribeWithAck(t, "1.2", AckClientIndividual)
+	sendMessages(stompConn, rawConn, 3)
+
+	// STOMP 1.2 messages carry the ack header the client echoes back.
+	assert.Equal(t, "1", rawConn.sentFrames[1].Header.Get(frame.Ack))
+	assert.Equal(t, "3", rawConn.sentFrames[3].Header.Get(frame.Ack))
+
+	rawConn.incomingFrames <- frame.New(frame.ACK, frame.Id, "2", frame.Receipt, "receipt-1")
+	e := <-events
+	assert.Equal(t, MessageAcknowledged, e.eventType)
+	assert.Equal(t, "2", e.Frame().Header.Get(frame.MessageId))
+	assert.Equal(t, "/topic/test", e.Destination())
+
+	rawConn.incomingFrames <- frame.New(frame.NACK, frame.Id, "3")
+	e = <-events
+	assert.Equal(t, MessageNacked, e.eventType)
+	assert.Equal(t, "3", e.Frame().Header.Get(frame.MessageId))
+
+	// acknowledging a message twice does nothing.
+	rawConn.incomingFrames <- frame.New(frame.ACK, frame.Id, "2", frame.Receipt, "receipt-2")
+	rawConn.incomingFrames <- frame.New(frame.DISCONNECT)
+	assert.Equal(t, ConnectionClosed, (<-events).eventType)
+
+	verifyFrame(t, rawConn.sentFrames[4], frame.New(frame.RECEIPT, frame.ReceiptId, "receipt-1"), true)
+	verifyFrame(t, rawConn.sentFrames[5], frame.New(frame.RECEIPT, frame.ReceiptId, "receipt-2"), true)
+	assert.Len(t, stompConn.pendingAcks, 1)
+	assert.NotNil(t, stompConn.pendingAcks["1"])
+}
+
+func TestStompConn_ClientAck(t *testing.T) {
+	stompConn, rawConn, events := subscribeWithAck(t, "1.1", AckClient)
+	sendMessages(stompConn, rawConn, 3)
+
+	// STOMP 1.1 clients acknowledge messages by their message-id.
+	_, ok := rawConn.sentFrames[1].Header.Contains(frame.Ack)
+	assert.False(t, ok)
+
+	rawConn.incomingFrames <- frame.New(frame.ACK, frame.MessageId, "2", frame.Subscription, "sub-id")
+	for _, messageId := range []string{"1", "2"} {
+		e := <-events
+		assert.Equal(t, MessageAcknowledged, e.eventType)
+		assert.Equal(t, messageId, e.Frame().Header.Get(frame.MessageId))
+	}
+
+	rawConn.incomingFrames <- frame.New(frame.UNSUBSCRIBE, frame.Id, "sub-id")
+	assert.Equal(t, UnsubscribeFromTopic, (<-events).eventType)
+	assert.Empty(t, stompConn.pendingAcks)
+}
+
+func TestStompConn_TooManyPendingAcks(t *testing.T) {
+	defer func(max int) { MaxPendingAcks = max }(MaxPendingAcks)
+	MaxPendingAcks = 2
+
+	stompConn, rawConn, events := subscribeWithAck(t, "1.2", AckClientIndividual)
+
+	// the third unacknowledged message is not sent, the client is disconnected instead
+	sendMessages(stompConn, rawConn, 3)
+	assert.Equal(t, ConnectionClosed, (<-events).eventType)
+	assert.Len(t, rawConn.sentFrames, 4)
+	verifyFrame(t, rawConn.LastSentFrame(), frame.New(frame.ERROR,
+		frame.Message, tooManyPendingAcksError.Error()), true)
+}
+
+func TestStompConn_AckMissingId(t *testing.T) {
+	_, rawConn, events := subscribeWithAck(t, "1.2", AckClient)
+
+	rawConn.incomingFrames <- frame.New(frame.ACK, frame.MessageId, "1")
+
+	assert.Equal(t, ConnectionClosed, (<-events).eventType)
+	verifyFrame(t, rawConn.LastSentFrame(), frame.New(frame.ERROR,
+		frame.Message, missingAckIdError.Error()), true)
+}
+
+func TestStompConn_Transactions(t *testing.T) {
+	stompConn, rawConn, events := subscribeWithAck(t, "1.2", AckClientIndividual)
+	sendMessages(stompConn, rawConn, 1)
+
+	rawConn.incomingFrames <- frame.New(frame.BEGIN, frame.Transaction, "tx-1", frame.Receipt, "begin")
+	rawConn.incomingFrames <- frame.New(frame.SEND, frame.Destination, "/pub/test", frame.Transaction, "tx-1")
+	rawConn.incomingFrames <- frame.New(frame.ACK, frame.Id, "1", frame.Transaction, "tx-1")
+	rawConn.incomingFrames <- frame.New(frame.BEGIN, frame.Transaction, "tx-2")
+	rawConn.incomingFrames <- frame.New(frame.SEND, frame.Destination, "/pub/aborted", frame.Transaction, "tx-2")
+	rawConn.incomingFrames <- frame.New(frame.ABORT, frame.Transaction, "tx-2")
+	assert.Empty(t, events)
+
+	rawConn.incomingFrames <- frame.New(frame.COMMIT, frame.Transaction, "tx-1", frame.Receipt, "commit")
+	e := <-events
+	assert.Equal(t, IncomingMessage, e.eventType)
+	assert.Equal(t, "/pub/test", e.destination)
+	_, ok := e.frame.Header.Contains(frame.Transaction)
+	assert.False(t, ok)
+	assert.Equal(t, MessageAcknowledged, (<-events).eventType)
+
+	rawConn.incomingFrames <- frame.New(frame.DISCONNECT)
+	assert.Equal(t, ConnectionClosed, (<-events).eventType)
+	assert.Empty(t, events)
+	verifyFrame(t, rawConn.sentFrames[2], frame.New(frame.RECEIPT, frame.ReceiptId, "begin"), true)
+	verifyFrame(t, rawConn.sentFrames[3], frame.New(frame.RECEIPT, frame.ReceiptId, "commit"), true)
+	assert.Empty(t, stompConn.transactions)
+}
+
+func TestStompConn_TransactionErrors(t *testing.T) {
+	tests := []struct {
+		frames []*frame.Frame
+		err    error
+	}{
+		{[]*frame.Frame{frame.New(frame.BEGIN)}, missingTransactionError},
+		{[]*frame.Frame{frame.New(frame.COMMIT, frame.Transaction, "tx")}, unknownTransactionError},
+		{[]*frame.Frame{frame.New(frame.SEND, frame.Destination, "/pub/test", frame.Transaction, "tx")},
+			unknownTransactionError},
+		{[]*frame.Frame{frame.New(frame.BEGIN, frame.Transaction, "tx"), frame.New(frame.BEGIN, frame.Transaction, "tx")},
+			duplicateTransactionError},
+	}
+	for _, test := range tests {
+		_, rawConn, events := getTestStompConn(NewStompConfig(0, []string{"/pub/"}), nil)
+		rawConn.SendConnectFrame()
+		<-events
+		for _, f := range test.frames {
+			rawConn.incomingFrames <- f
+		}
+		assert.Equal(t, ConnectionClosed, (<-events).eventType)
+		verifyFrame(t, rawConn.LastSentFrame(), frame.New(frame.ERROR, frame.Message, test.err.Error()), true)
+	}
+}
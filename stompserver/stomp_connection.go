@@ -4,11 +4,14 @@
 package stompserver
 
 import (
+    "errors"
     "fmt"
     "github.com/go-stomp/stomp/v3"
     "github.com/go-stomp/stomp/v3/frame"
     "github.com/google/uuid"
-    "log"
+    "github.com/pb33f/ranch/clock"
+    "github.com/pb33f/ranch/log"
+    "os"
     "strconv"
     "strings"
     "sync"
@@ -18,6 +21,29 @@ import (
 
 type MiddlewareRegistry map[string][]MiddlewareFunc
 
+// Prepend returns a copy of the registry with fn at the front of the middleware chain of command ("*" for
+// the global chain), so it runs before the middleware already registered. The registry is left untouched.
+func (r MiddlewareRegistry) Prepend(command string, fn MiddlewareFunc) MiddlewareRegistry {
+    return r.with(command, append([]MiddlewareFunc{fn}, r[command]...))
+}
+
+// Append returns a copy of the registry with fn at the end of the middleware chain of command ("*" for
+// the global chain), so it runs after the middleware already registered. The registry is left untouched.
+func (r MiddlewareRegistry) Append(command string, fn MiddlewareFunc) MiddlewareRegistry {
+    chain := make([]MiddlewareFunc, 0, len(r[command])+1)
+    return r.with(command, append(append(chain, r[command]...), fn))
+}
+
+// with returns a copy of the registry with chain as the middleware chain of command.
+func (r MiddlewareRegistry) with(command string, chain []MiddlewareFunc) MiddlewareRegistry {
+    updated := make(MiddlewareRegistry, len(r)+1)
+    for c, middleware := range r {
+        updated[c] = middleware
+    }
+    updated[command] = chain
+    return updated
+}
+
 // FrameHandlerFunc is a function that processes a STOMP frame.
 type FrameHandlerFunc func(conn StompConn, f *frame.Frame) error
 
@@ -27,6 +53,11 @@ type MiddlewareFunc func(FrameHandlerFunc) FrameHandlerFunc
 type Subscription struct {
     id          string
     destination string
+    ackMode     string    // one of AckAuto, AckClient or AckClientIndividual
+    queued      int32     // messages waiting to be written, updated atomically
+    throttled   bool      // the client was told to slow down, only used by the run goroutine
+    durable     bool      // messages are queued for the client while it is offline, see DurableHeader
+    selector    *selector // only the messages it matches are sent, nil if every message is, see SelectorHeader
 }
 
 // ChainMiddleware applies the list of middleware in order so that the first in the
@@ -105,12 +136,33 @@ type StompConn interface {
     GetEventsChannel() chan *ConnEvent
     SendError(err error)
     SendMessage(msg string)
+    // Return the session token presented when the client connected, empty if none was provided.
+    GetSessionToken() string
+    // Return the principal the client authenticated as, empty if it is anonymous.
+    GetPrincipal() string
+    // Return the client id sent when the client connected (see ClientIdHeader), empty if none was sent.
+    GetClientId() string
+    // Return a description of the connection, without its subscriptions.
+    GetInfo() *ConnectionInfo
+    // Send the client a shutdown notice and close the connection gracefully once grace elapsed.
+    Drain(grace time.Duration)
 }
 
 const (
     maxHeartBeatDuration = time.Duration(999999999) * time.Millisecond
 )
 
+// Reasons a connection was closed, see ConnEvent.Reason.
+const (
+    CloseReasonDisconnect       = "disconnect"         // the client sent a DISCONNECT frame
+    CloseReasonHeartBeatTimeout = "heart-beat timeout" // the client missed too many heart-beats
+    CloseReasonConnectionLost   = "connection lost"    // reading from or writing to the client failed
+    CloseReasonError            = "error"              // the client was sent an ERROR frame
+    CloseReasonServer           = "closed by server"   // the server closed the connection, e.g. when stopping
+    CloseReasonRateLimited      = "rate limited"       // the client exceeded its rate limits, see RateLimitDisconnect
+    CloseReasonFrameTooLarge    = "frame too large"    // the client sent a frame exceeding the FrameLimits of its listener
+)
+
 const (
     connecting int32 = iota
     connected
@@ -122,7 +174,7 @@ type stompConn struct {
     state            int32
     version          stomp.Version
     inFrames         chan *frame.Frame
-    outFrames        chan *frame.Frame
+    outFrames        chan *queuedFrame
     readTimeoutMs    int64
     writeTimeout     time.Duration
     id               string
@@ -131,7 +183,20 @@ type stompConn struct {
     subscriptions    map[string]*Subscription
     currentMessageId uint64
     closeOnce        sync.Once
+    closeReason      atomic.Pointer[string]
     authInfo         *AuthInfo
+    sessionToken     string
+    clientId         string
+    principal        atomic.Pointer[string]
+    connectedAt      time.Time
+    framesIn         atomic.Uint64
+    framesOut        atomic.Uint64
+    rateLimited      atomic.Uint64          // frames received while the client exceeded its rate limits
+    pendingAcks      map[string]*pendingAck // messages waiting for an ACK or NACK, by ack id
+    transactions     map[string][]func()    // operations of the open transactions, run on COMMIT
+    drainRequests    chan time.Duration     // grace of a drain, see Drain
+    draining         bool                   // whether the connection is drained, new subscriptions are refused
+    session          *sessionCipher         // keys of the session, nil unless the client asked for encryption
 }
 
 func NewStompConn(rawConnection RawConnection, config StompConfig, events chan *ConnEvent) StompConn {
@@ -139,11 +204,15 @@ func NewStompConn(rawConnection RawConnection, config StompConfig, events chan *
         rawConnection: rawConnection,
         state:         connecting,
         inFrames:      make(chan *frame.Frame, 32),
-        outFrames:     make(chan *frame.Frame, 32),
+        outFrames:     make(chan *queuedFrame, outFramesSize(config.GetBackpressure())),
         config:        config,
         id:            uuid.New().String(),
+        connectedAt:   time.Now(),
         events:        events,
         subscriptions: make(map[string]*Subscription),
+        pendingAcks:   make(map[string]*pendingAck),
+        transactions:  make(map[string][]func()),
+        drainRequests: make(chan time.Duration, 1),
     }
 
     go conn.run()
@@ -162,7 +231,8 @@ func (conn *stompConn) GetEventsChannel() chan *ConnEvent {
 
 func (conn *stompConn) SendFrameToSubscription(f *frame.Frame, sub *Subscription) {
     f.Header.Add(frame.Subscription, sub.id)
-    conn.outFrames <- f
+    atomic.AddInt32(&sub.queued, 1)
+    conn.outFrames <- &queuedFrame{frame: f, sub: sub}
 }
 
 func (conn *stompConn) Close() {
@@ -170,23 +240,54 @@ func (conn *stompConn) Close() {
         atomic.StoreInt32(&conn.state, closed)
         conn.rawConnection.Close()
 
+        conn.setCloseReason(CloseReasonServer)
         conn.events <- &ConnEvent{
             ConnId:    conn.GetId(),
             eventType: ConnectionClosed,
             conn:      conn,
+            reason:    *conn.closeReason.Load(),
         }
     })
 }
 
+// setCloseReason records why the connection is closed, unless a reason was recorded already.
+func (conn *stompConn) setCloseReason(reason string) {
+    conn.closeReason.CompareAndSwap(nil, &reason)
+}
+
 func (conn *stompConn) GetId() string {
     return conn.id
 }
 
+func (conn *stompConn) GetSessionToken() string {
+    return conn.sessionToken
+}
+
+func (conn *stompConn) GetClientId() string {
+    return conn.clientId
+}
+
+func (conn *stompConn) GetPrincipal() string {
+    if principal := conn.principal.Load(); principal != nil {
+        return *principal
+    }
+    return ""
+}
+
+// writeFrame writes a frame to the client, counting it unless it is a heart-beat.
+func (conn *stompConn) writeFrame(f *frame.Frame) error {
+    if f != nil {
+        conn.framesOut.Add(1)
+    }
+    return conn.rawConnection.WriteFrame(f)
+}
+
 func (conn *stompConn) run() {
     defer conn.Close()
 
     var timerChannel <-chan time.Time
-    var timer *time.Timer
+    var timer clock.Timer
+    var drainChannel <-chan time.Time
 
     for {
 
@@ -195,12 +296,12 @@ func (conn *stompConn) run() {
         }
 
         if timer == nil && conn.writeTimeout > 0 {
-            timer = time.NewTimer(conn.writeTimeout)
-            timerChannel = timer.C
+            timer = clock.NewTimer(conn.writeTimeout)
+            timerChannel = timer.C()
         }
 
         select {
-        case f, ok := <-conn.outFrames:
+        case queued, ok := <-conn.outFrames:
             if !ok {
                 // close connection
                 return
@@ -212,28 +313,53 @@ func (conn *stompConn) run() {
                 timer = nil
             }
 
-            conn.populateMessageIdHeader(f)
-
-            // write the frame to the client
-            err := conn.rawConnection.WriteFrame(f)
-            if err != nil || f.Command == frame.ERROR {
+            if !conn.writeQueuedFrame(queued) {
                 return
             }
 
         case f, ok := <-conn.inFrames:
             if !ok {
+                // the reader recorded why it stopped, clients exceeding their limits are told
+                if reason := conn.closeReason.Load(); reason != nil {
+                    switch *reason {
+                    case CloseReasonRateLimited:
+                        conn.SendError(rateLimitExceededError)
+                    case CloseReasonFrameTooLarge:
+                        conn.SendError(frameTooLargeError)
+                    }
+                }
                 return
             }
 
             if err := conn.handleIncomingFrame(f); err != nil {
+                conn.setCloseReason(CloseReasonError)
                 conn.SendError(err)
                 return
             }
 
+        case grace := <-conn.drainRequests:
+            conn.draining = true
+            if err := conn.sendShutdownNotice(grace); err != nil {
+                conn.setCloseReason(CloseReasonConnectionLost)
+                return
+            }
+            if grace <= 0 {
+                conn.closeDrained()
+                return
+            }
+            drainTimer := clock.NewTimer(grace)
+            defer drainTimer.Stop()
+            drainChannel = drainTimer.C()
+
+        case <-drainChannel:
+            conn.closeDrained()
+            return
+
         case _ = <-timerChannel:
             // write a heart-beat
-            err := conn.rawConnection.WriteFrame(nil)
+            err := conn.writeFrame(nil)
             if err != nil {
+                conn.setCloseReason(CloseReasonConnectionLost)
                 return
             }
             if timer != nil {
@@ -244,6 +370,52 @@ func (conn *stompConn) run() {
     }
 }
 
+// writeQueuedFrame writes a frame queued for a subscription to the client, returning false if the connection
+// must be closed. Only called by the run goroutine.
+func (conn *stompConn) writeQueuedFrame(queued *queuedFrame) bool {
+    f := queued.frame
+
+    // advisories go out ahead of the message
+    if err := conn.checkBackpressure(queued.sub); err != nil {
+        conn.setCloseReason(CloseReasonConnectionLost)
+        return false
+    }
+
+    if err := conn.populateMessageIdHeader(f); err != nil {
+        conn.setCloseReason(CloseReasonError)
+        conn.SendError(err)
+        return false
+    }
+
+    // messages to encrypted destinations are encrypted with the key of the session, ERROR frames aside
+    if f.Command == frame.MESSAGE {
+        dest := f.Header.Get(frame.Destination)
+        session, err := conn.sessionKeyFor(dest)
+        if err == nil && session != nil {
+            err = session.encrypt(f, dest)
+        }
+        if err != nil {
+            atomic.AddInt32(&queued.sub.queued, -1)
+            conn.setCloseReason(CloseReasonError)
+            conn.SendError(err)
+            return false
+        }
+    }
+
+    // write the frame to the client
+    err := conn.writeFrame(f)
+    atomic.AddInt32(&queued.sub.queued, -1)
+    if err != nil {
+        conn.setCloseReason(CloseReasonConnectionLost)
+        return false
+    }
+    if f.Command == frame.ERROR {
+        conn.setCloseReason(CloseReasonError)
+        return false
+    }
+    return true
+}
+
 func (conn *stompConn) handleIncomingFrame(f *frame.Frame) error {
     switch f.Command {
 
@@ -261,6 +433,12 @@ func (conn *stompConn) handleIncomingFrame(f *frame.Frame) error {
 
     case frame.UNSUBSCRIBE:
         return conn.handleUnsubscribe(f)
+
+    case frame.ACK, frame.NACK:
+        return conn.handleAck(f)
+
+    case frame.BEGIN, frame.COMMIT, frame.ABORT:
+        return conn.handleTransaction(f)
     }
 
     return unsupportedStompCommandError
@@ -286,10 +464,36 @@ func (conn *stompConn) handleConnect(f *frame.Frame) error {
         return invalidHeaderError
     }
 
+    conn.sessionToken = sessionTokenFromFrame(f)
+    conn.clientId = f.Header.Get(ClientIdHeader)
+
+    // clients presenting a verified certificate are its subject, unless an authenticator says otherwise
+    if cc, ok := conn.rawConnection.(certificateConnection); ok {
+        if cert := cc.PeerCertificate(); cert != nil {
+            principal := ClientCertificatePrincipal(cert)
+            conn.principal.Store(&principal)
+        }
+    }
+    // as are clients authenticated by the HTTP request their WebSocket was upgraded from
+    if rc, ok := conn.rawConnection.(requestPrincipalConnection); ok {
+        if principal := rc.RequestPrincipal(); principal != "" {
+            conn.principal.Store(&principal)
+        }
+    }
+
+    registry := conn.config.GetMiddlewareRegistry()
+    handler := ChainCommandMiddleware(registry, frame.CONNECT, func(_ StompConn, f *frame.Frame) error {
+        return conn.establishConnection(f)
+    })
+    return handler(conn, f)
+}
+
+// establishConnection negotiates the version and heart-beats and replies with a CONNECTED frame.
+func (conn *stompConn) establishConnection(f *frame.Frame) error {
     var err error
     conn.version, err = determineVersion(f)
     if err != nil {
-        log.Println("cannot determine version")
+        log.Logger().Warn("[ranch] cannot determine STOMP version", "connection", conn.id, "error", err.Error())
         return err
     }
 
@@ -299,38 +503,45 @@ func (conn *stompConn) handleConnect(f *frame.Frame) error {
 
     cxDuration, cyDuration, err := getHeartBeat(f)
     if err != nil {
-        log.Println("invalid heart-beat")
+        log.Logger().Warn("[ranch] invalid STOMP heart-beat", "connection", conn.id, "error", err.Error())
         return err
     }
 
-    min := time.Duration(conn.config.HeartBeat()) * time.Millisecond
-    if min > maxHeartBeatDuration {
-        min = maxHeartBeatDuration
+    server := time.Duration(conn.config.HeartBeat()) * time.Millisecond
+    if server > maxHeartBeatDuration {
+        server = maxHeartBeatDuration
     }
 
-    // apply a minimum heartbeat
-    if cxDuration > 0 {
-        if min == 0 || cxDuration < min {
-            cxDuration = min
-        }
-    }
-    if cyDuration > 0 {
-        if min == 0 || cyDuration < min {
-            cyDuration = min
-        }
-    }
+    // the server sends and expects heart-beats at its configured interval
+    cxDuration = negotiateHeartBeat(cxDuration, server)
+    cyDuration = negotiateHeartBeat(cyDuration, server)
 
     conn.writeTimeout = cyDuration
 
     cx, cy := int64(cxDuration/time.Millisecond), int64(cyDuration/time.Millisecond)
     atomic.StoreInt64(&conn.readTimeoutMs, cx)
+    if cx > 0 {
+        // the reader is already waiting for the next frame, without a deadline
+        conn.rawConnection.SetReadDeadline(time.Now().Add(conn.readTimeout(cx)))
+    }
 
     response := frame.New(frame.CONNECTED,
         frame.Version, string(conn.version),
         frame.Server, "pb33f-ranch/0.0.1",
         frame.HeartBeat, fmt.Sprintf("%d,%d", cy, cx))
 
-    err = conn.rawConnection.WriteFrame(response)
+    // clients sending a public key get one back, to derive the key encrypting the sensitive destinations
+    if clientKey, ok := f.Header.Contains(SessionKeyHeader); ok && conn.config.GetEncryption().enabled() {
+        var serverKey string
+        conn.session, serverKey, err = newSessionCipher(clientKey)
+        if err != nil {
+            return err
+        }
+        response.Header.Add(SessionKeyHeader, serverKey)
+        response.Header.Add(SessionKeyIdHeader, "0")
+    }
+
+    err = conn.writeFrame(response)
     if err != nil {
         return err
     }
@@ -352,6 +563,7 @@ func (conn *stompConn) handleDisconnect(f *frame.Frame) error {
     }
 
     conn.sendReceiptResponse(f)
+    conn.setCloseReason(CloseReasonDisconnect)
     conn.Close()
 
     return nil
@@ -375,8 +587,40 @@ func (conn *stompConn) handleSubscribe(f *frame.Frame) error {
         return invalidFrameError
     }
 
-    // Define the core Subscription handler.
+    ackMode := AckAuto
+    if mode, ok := f.Header.Contains(frame.Ack); ok {
+        if !validAckMode(mode) {
+            return invalidAckModeError
+        }
+        ackMode = mode
+    }
+
+    sel, err := parseSelector(f.Header.Get(SelectorHeader))
+    if err != nil {
+        return err
+    }
+
+    if conn.session == nil && conn.config.GetEncryption().encrypted(dest) {
+        return encryptionRequiredError
+    }
+
+    // messages sent to a queue go to its connected subscribers, they are not kept for offline ones, and
+    // temporary reply destinations go away with their session
+    // the server is going away, the client is told why its connection is closed
+    if conn.draining {
+        return serverShuttingDownError
+    }
+
+    durable := f.Header.Get(DurableHeader) == "true" && conn.GetClientId() != "" &&
+        !conn.config.IsQueueDestination(dest) && !conn.config.IsTempQueueDestination(dest)
+
+    // Define the core Subscription handler, a receipt is sent once the subscription has passed the middleware.
+    sendReceipt := conn.sendReceiptResponse
     coreSubscribeHandler := func(conn StompConn, f *frame.Frame) error {
+        if err := sendReceipt(f); err != nil {
+            return err
+        }
+
         subs := conn.GetSubscriptions()
         if _, exists := subs[subId]; exists {
             // Subscription already exists; nothing more to do.
@@ -386,6 +630,9 @@ func (conn *stompConn) handleSubscribe(f *frame.Frame) error {
         subs[subId] = &Subscription{
             id:          subId,
             destination: dest,
+            ackMode:     ackMode,
+            durable:     durable,
+            selector:    sel,
         }
         evts := conn.GetEventsChannel()
         evts <- &ConnEvent{
@@ -426,8 +673,9 @@ func (conn *stompConn) handleUnsubscribe(f *frame.Frame) error {
         return nil
     }
 
-    // remove the Subscription
+    // remove the Subscription, its unacknowledged messages can no longer be acknowledged
     delete(conn.subscriptions, id)
+    conn.dropPendingAcks(sub)
 
     conn.events <- &ConnEvent{
         ConnId:      conn.GetId(),
@@ -448,9 +696,11 @@ func (conn *stompConn) handleSend(f *frame.Frame) error {
         return nil
     }
 
-    // TODO: Remove if we start supporting transactions
-    if containsHeader(f, frame.Transaction) {
-        return unsupportedStompCommandError
+    // SEND frames of a transaction are delivered when it is committed
+    if tx, ok := f.Header.Contains(frame.Transaction); ok {
+        if _, exists := conn.transactions[tx]; !exists {
+            return unknownTransactionError
+        }
     }
 
     // no destination triggers an error
@@ -459,32 +709,51 @@ func (conn *stompConn) handleSend(f *frame.Frame) error {
         return invalidFrameError
     }
 
-    // reject SENDing directly to non-request channels by clients
-    if !conn.config.IsAppRequestDestination(f.Header.Get(frame.Destination)) {
+    // reject SENDing directly to non-request channels by clients, queues excepted
+    if !conn.config.IsAppRequestDestination(dest) && !conn.config.IsQueueDestination(dest) {
         return invalidSendDestinationError
     }
 
-    err := conn.sendReceiptResponse(f)
+    // bodies sent to encrypted destinations are decrypted before anything looks at them
+    session, err := conn.sessionKeyFor(dest)
     if err != nil {
         return err
     }
+    if session != nil {
+        if err := session.decrypt(f, dest); err != nil {
+            return err
+        }
+    }
 
-    f.Command = frame.MESSAGE
-    conn.events <- &ConnEvent{
-        ConnId:      conn.GetId(),
-        eventType:   IncomingMessage,
-        destination: dest,
-        frame:       f,
-        conn:        conn,
+    coreSendHandler := func(_ StompConn, f *frame.Frame) error {
+        err := conn.sendReceiptResponse(f)
+        if err != nil {
+            return err
+        }
+
+        f.Command = frame.MESSAGE
+        evt := &ConnEvent{
+            ConnId:      conn.GetId(),
+            eventType:   IncomingMessage,
+            destination: dest,
+            frame:       f,
+            conn:        conn,
+        }
+        return conn.inTransaction(f, func() {
+            f.Header.Del(frame.Transaction)
+            conn.events <- evt
+        })
     }
 
-    return nil
+    registry := conn.config.GetMiddlewareRegistry()
+    handler := ChainCommandMiddleware(registry, frame.SEND, coreSendHandler)
+    return handler(conn, f)
 }
 
 func (conn *stompConn) sendReceiptResponse(f *frame.Frame) error {
     if receipt, ok := f.Header.Contains(frame.Receipt); ok {
         f.Header.Del(frame.Receipt)
-        return conn.rawConnection.WriteFrame(frame.New(frame.RECEIPT, frame.ReceiptId, receipt))
+        return conn.writeFrame(frame.New(frame.RECEIPT, frame.ReceiptId, receipt))
     }
     return nil
 }
@@ -493,13 +762,18 @@ func (conn *stompConn) readInFrames() {
     defer func() {
         close(conn.inFrames)
     }()
+    limiter := newRateLimiter(conn.config.GetRateLimits(), clock.Now())
 
-    // we never close the connection, even if the heartbeating is inaccurate.
-    infiniteTimeout := time.Time{}
     for {
-        conn.rawConnection.SetReadDeadline(infiniteTimeout)
+        // once heart-beats are negotiated, a client that sends nothing for too many intervals is gone.
+        deadline := time.Time{}
+        if cx := atomic.LoadInt64(&conn.readTimeoutMs); cx > 0 {
+            deadline = time.Now().Add(conn.readTimeout(cx))
+        }
+        conn.rawConnection.SetReadDeadline(deadline)
         f, err := conn.rawConnection.ReadFrame()
         if err != nil {
+            conn.setCloseReason(readErrorReason(err))
             return
         }
 
@@ -507,11 +781,41 @@ func (conn *stompConn) readInFrames() {
             // heartbeat frame
             continue
         }
+        conn.framesIn.Add(1)
+
+        // clients sending too fast are slowed down by not reading from them, or disconnected
+        if delay := limiter.take(f, clock.Now()); delay > 0 {
+            conn.rateLimited.Add(1)
+            if conn.config.GetRateLimits().Policy == RateLimitDisconnect {
+                log.Warn("Client %s exceeded its rate limits, disconnecting", conn.id)
+                conn.setCloseReason(CloseReasonRateLimited)
+                return
+            }
+            clock.Sleep(delay)
+        }
 
         conn.inFrames <- f
     }
 }
 
+// readTimeout returns how long the client may send nothing, given the interval of its heart-beats in
+// milliseconds.
+func (conn *stompConn) readTimeout(cx int64) time.Duration {
+    return time.Duration(conn.config.MaxMissedHeartBeats()) * time.Duration(cx) * time.Millisecond
+}
+
+// readErrorReason returns why the connection closes after reading from it failed with err.
+func readErrorReason(err error) string {
+    if errors.Is(err, frameTooLargeError) {
+        return CloseReasonFrameTooLarge
+    }
+    var netErr interface{ Timeout() bool }
+    if errors.Is(err, os.ErrDeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+        return CloseReasonHeartBeatTimeout
+    }
+    return CloseReasonConnectionLost
+}
+
 func determineVersion(f *frame.Frame) (stomp.Version, error) {
     if acceptVersion, ok := f.Header.Contains(frame.AcceptVersion); ok {
         versions := strings.Split(acceptVersion, ",")
@@ -531,6 +835,27 @@ func determineVersion(f *frame.Frame) (stomp.Version, error) {
     return emptyVersion, unsupportedStompVersionError
 }
 
+// sessionTokenFromFrame extracts the session token from a CONNECT frame. A bearer token in the
+// Authorization header takes precedence over the STOMP passcode header.
+func sessionTokenFromFrame(f *frame.Frame) string {
+    if auth, ok := f.Header.Contains("Authorization"); ok {
+        return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+    }
+    return f.Header.Get(frame.Passcode)
+}
+
+// negotiateHeartBeat returns the heart-beat interval of one direction as defined by STOMP 1.2: the larger
+// of the client and server intervals, or 0 (no heart-beats) if either side does not want them.
+func negotiateHeartBeat(client, server time.Duration) time.Duration {
+    if client == 0 || server == 0 {
+        return 0
+    }
+    if client > server {
+        return client
+    }
+    return server
+}
+
 func getHeartBeat(f *frame.Frame) (cx, cy time.Duration, err error) {
     if heartBeat, ok := f.Header.Contains(frame.HeartBeat); ok {
         return frame.ParseHeartBeat(heartBeat)
@@ -542,21 +867,23 @@ func (conn *stompConn) SendError(err error) {
     errorFrame := frame.New(frame.ERROR,
         frame.Message, err.Error())
 
-    conn.rawConnection.WriteFrame(errorFrame)
+    conn.writeFrame(errorFrame)
 }
 
 func (conn *stompConn) SendMessage(message string) {
     msgFrame := frame.New(frame.MESSAGE, frame.Message, message)
-    conn.rawConnection.WriteFrame(msgFrame)
+    conn.writeFrame(msgFrame)
 }
 
-func (conn *stompConn) populateMessageIdHeader(f *frame.Frame) {
+func (conn *stompConn) populateMessageIdHeader(f *frame.Frame) error {
     if f.Command == frame.MESSAGE {
         // allocate the value of message-id for this frame
         conn.currentMessageId++
         messageId := strconv.FormatUint(conn.currentMessageId, 10)
         f.Header.Set(frame.MessageId, messageId)
-        // remove the Ack header (if any) as we don't support those
+        // the ack header is only sent to subscriptions that acknowledge their messages
         f.Header.Del(frame.Ack)
+        return conn.trackAck(f, messageId)
     }
+    return nil
 }
@@ -8,6 +8,7 @@ import (
     "fmt"
     "github.com/go-stomp/stomp/v3/frame"
     "github.com/stretchr/testify/assert"
+    "os"
     "sync"
     "testing"
     "time"
@@ -419,6 +420,28 @@ func TestStompConn_Subscribe(t *testing.T) {
     assert.Equal(t, e.sub, stompConn.subscriptions["sub-id"])
 }
 
+func TestStompConn_SubscribeWithReceipt(t *testing.T) {
+    _, rawConn, events := getTestStompConn(NewStompConfig(0, []string{}), nil)
+
+    rawConn.SendConnectFrame()
+
+    e := <-events
+    assert.Equal(t, e.eventType, ConnectionEstablished)
+
+    rawConn.incomingFrames <- frame.New(
+        frame.SUBSCRIBE,
+        frame.Id, "sub-id",
+        frame.Destination, "/topic/test",
+        frame.Receipt, "receipt-id")
+
+    e = <-events
+    assert.Equal(t, e.eventType, SubscribeToTopic)
+
+    assert.Equal(t, len(rawConn.sentFrames), 2)
+    verifyFrame(t, rawConn.sentFrames[1], frame.New(frame.RECEIPT,
+        frame.ReceiptId, "receipt-id"), true)
+}
+
 func TestStompConn_SendNotConnected(t *testing.T) {
     _, rawConn, events := getTestStompConn(NewStompConfig(0, []string{"/pub/"}), nil)
 
@@ -608,6 +631,7 @@ func TestStompConn_Disconnect(t *testing.T) {
 
     e = <-events
     assert.Equal(t, e.eventType, ConnectionClosed)
+    assert.Equal(t, CloseReasonDisconnect, e.Reason())
 
     assert.Equal(t, len(rawConn.sentFrames), 1)
     assert.Equal(t, stompConn.state, closed)
@@ -769,9 +793,112 @@ func TestStompConn_SetReadDeadline(t *testing.T) {
     diff := rawConn.getCurrentReadDeadline().Sub(time.Now())
 
     // verify the read deadline for the connection is
-    // between 15 and 21 seconds
-    // assert.Greater(t, diff.Seconds(), float64(15))  <-- we don't care anymore.
-    assert.Greater(t, float64(21), diff.Seconds())
+    // twice the heart-beat interval
+    assert.Greater(t, diff.Seconds(), float64(35))
+    assert.Greater(t, float64(41), diff.Seconds())
+}
+
+func TestStompConn_MaxMissedHeartBeats(t *testing.T) {
+    config := NewStompConfig(1000, []string{})
+    config.SetMaxMissedHeartBeats(4)
+    _, rawConn, events := getTestStompConn(config, nil)
+
+    rawConn.incomingFrames <- frame.New(
+        frame.CONNECT,
+        frame.AcceptVersion, "1.2",
+        frame.HeartBeat, "3000,0")
+
+    <-events
+
+    diff := time.Until(rawConn.getCurrentReadDeadline())
+    assert.Greater(t, diff.Seconds(), float64(11))
+    assert.Greater(t, float64(12), diff.Seconds())
+
+    config.SetMaxMissedHeartBeats(0)
+    assert.Equal(t, DefaultMaxMissedHeartBeats, config.MaxMissedHeartBeats())
+}
+
+func TestStompConn_HeartBeatTimeout(t *testing.T) {
+    stompConn, rawConn, events := getTestStompConn(NewStompConfig(1000, []string{}), nil)
+
+    rawConn.incomingFrames <- frame.New(
+        frame.CONNECT,
+        frame.AcceptVersion, "1.2",
+        frame.HeartBeat, "1000,0")
+    assert.Equal(t, ConnectionEstablished, (<-events).eventType)
+
+    // the read deadline passes without the client sending a heart-beat
+    rawConn.incomingFrames <- fmt.Errorf("read: %w", os.ErrDeadlineExceeded)
+
+    e := <-events
+    assert.Equal(t, ConnectionClosed, e.eventType)
+    assert.Equal(t, CloseReasonHeartBeatTimeout, e.Reason())
+    assert.Equal(t, closed, stompConn.state)
+}
+
+func TestStompConn_ConnectionLost(t *testing.T) {
+    _, rawConn, events := getTestStompConn(NewStompConfig(0, []string{}), nil)
+
+    rawConn.SendConnectFrame()
+    assert.Equal(t, ConnectionEstablished, (<-events).eventType)
+
+    rawConn.incomingFrames <- errors.New("connection reset by peer")
+
+    e := <-events
+    assert.Equal(t, ConnectionClosed, e.eventType)
+    assert.Equal(t, CloseReasonConnectionLost, e.Reason())
+}
+
+func TestStompConn_NegotiateHeartBeat(t *testing.T) {
+    assert.Equal(t, time.Duration(0), negotiateHeartBeat(0, 0))
+    assert.Equal(t, time.Duration(0), negotiateHeartBeat(5*time.Second, 0))
+    assert.Equal(t, time.Duration(0), negotiateHeartBeat(0, 5*time.Second))
+    assert.Equal(t, 5*time.Second, negotiateHeartBeat(time.Second, 5*time.Second))
+    assert.Equal(t, 5*time.Second, negotiateHeartBeat(5*time.Second, time.Second))
+}
+
+func TestStompConn_HeartBeatNegotiatedPerDirection(t *testing.T) {
+    _, rawConn, events := getTestStompConn(NewStompConfig(1000, []string{}), nil)
+
+    rawConn.incomingFrames <- frame.New(
+        frame.CONNECT,
+        frame.AcceptVersion, "1.2",
+        frame.HeartBeat, "3000,0")
+
+    <-events
+
+    // the client sends heart-beats every 3 seconds and does not want any
+    verifyFrame(t, rawConn.sentFrames[0], frame.New(frame.CONNECTED,
+        frame.HeartBeat, "0,3000"), false)
+}
+
+func TestStompConn_ReadDeadlineAfterConnect(t *testing.T) {
+    _, rawConn, events := getTestStompConn(NewStompConfig(1000, []string{}), nil)
+
+    rawConn.incomingFrames <- frame.New(
+        frame.CONNECT,
+        frame.AcceptVersion, "1.2",
+        frame.HeartBeat, "3000,0")
+
+    <-events
+
+    // the client must send something within twice the negotiated interval, before any other frame
+    diff := time.Until(rawConn.getCurrentReadDeadline())
+    assert.Greater(t, diff.Seconds(), float64(5))
+    assert.Greater(t, float64(6), diff.Seconds())
+
+    // clients that cannot send heart-beats are never timed out
+    _, rawConn, events = getTestStompConn(NewStompConfig(1000, []string{}), nil)
+    rawConn.incomingFrames <- frame.New(
+        frame.CONNECT,
+        frame.AcceptVersion, "1.2",
+        frame.HeartBeat, "0,3000")
+
+    <-events
+
+    rawConn.incomingFrames <- nil
+    rawConn.incomingFrames <- nil
+    assert.Equal(t, time.Time{}, rawConn.getCurrentReadDeadline())
 }
 
 func TestStompConn_WriteHeartbeat(t *testing.T) {
@@ -831,3 +958,29 @@ func printFrame(f *frame.Frame) {
         fmt.Println("BODY:", string(f.Body))
     }
 }
+
+func TestMiddlewareRegistry_PrependAndAppend(t *testing.T) {
+    var calls []string
+    named := func(name string) MiddlewareFunc {
+        return func(next FrameHandlerFunc) FrameHandlerFunc {
+            return func(conn StompConn, f *frame.Frame) error {
+                calls = append(calls, name)
+                return next(conn, f)
+            }
+        }
+    }
+    registry := MiddlewareRegistry{frame.CONNECT: {named("registered")}}
+
+    updated := registry.Prepend(frame.CONNECT, named("first")).Append(frame.CONNECT, named("last")).
+        Prepend("*", named("global"))
+    assert.Len(t, registry, 1)
+    assert.Len(t, registry[frame.CONNECT], 1)
+
+    handler := ChainCommandMiddleware(updated, frame.CONNECT, func(conn StompConn, f *frame.Frame) error {
+        return nil
+    })
+    assert.NoError(t, handler(nil, frame.New(frame.CONNECT)))
+    assert.Equal(t, []string{"global", "first", "registered", "last"}, calls)
+
+    assert.Len(t, MiddlewareRegistry(nil).Prepend(frame.SEND, named("only"))[frame.SEND], 1)
+}
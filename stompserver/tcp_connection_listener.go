@@ -11,12 +11,16 @@ import (
 
 type tcpStompConnection struct {
     tcpCon net.Conn
+    reader *frameReader
+}
+
+func newTcpStompConnection(conn net.Conn, limits FrameLimits) *tcpStompConnection {
+    return &tcpStompConnection{tcpCon: conn, reader: newFrameReader(conn, limits)}
 }
 
 func (c *tcpStompConnection) ReadFrame() (*frame.Frame, error) {
-    frameR := frame.NewReader(c.tcpCon)
-    f, e := frameR.Read()
-    return f, e
+    // the reader is kept across frames, it may have buffered the start of the next one
+    return c.reader.Read()
 }
 
 func (c *tcpStompConnection) WriteFrame(f *frame.Frame) error {
@@ -33,7 +37,12 @@ func (c *tcpStompConnection) Close() error {
     return c.tcpCon.Close()
 }
 
+func (c *tcpStompConnection) RemoteAddr() net.Addr {
+    return c.tcpCon.RemoteAddr()
+}
+
 type tcpConnectionListener struct {
+    frameLimitsHolder
     listener     net.Listener
     closeChannel chan *Connection
     openChannel  chan *Connection
@@ -47,6 +56,12 @@ func NewTcpConnectionListener(addr string) (RawConnectionListener, error) {
     return &tcpConnectionListener{listener: tcpListener, openChannel: make(chan *Connection), closeChannel: make(chan *Connection)}, nil
 }
 
+// NewTcpConnectionListenerFromListener accepts raw STOMP connections from an existing listener, such as the
+// StompListener of a PortMux.
+func NewTcpConnectionListenerFromListener(listener net.Listener) RawConnectionListener {
+    return &tcpConnectionListener{listener: listener, openChannel: make(chan *Connection), closeChannel: make(chan *Connection)}
+}
+
 func (l *tcpConnectionListener) GetConnectionOpenChannel() chan *Connection {
     return l.openChannel
 }
@@ -61,7 +76,7 @@ func (l *tcpConnectionListener) Accept() (RawConnection, error) {
         return nil, err
     }
 
-    return &tcpStompConnection{tcpCon: conn}, nil
+    return newTcpStompConnection(conn, l.frameLimits()), nil
 }
 
 func (l *tcpConnectionListener) Close() error {
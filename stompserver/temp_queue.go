@@ -0,0 +1,21 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import "github.com/go-stomp/stomp/v3/frame"
+
+// ReplyToHeader is the header of a SEND frame naming the temporary reply destination of the session the
+// replies to the request go to, e.g. "/temp-queue/orders". Its name is chosen by the client, and two
+// sessions using the same name each receive only their own replies.
+const ReplyToHeader = "reply-to"
+
+// replyTo returns the temporary reply destination a request frame names, empty if it names none or a
+// destination that is not a temporary reply destination.
+func (s *stompServer) replyTo(f *frame.Frame) string {
+	replyTo := f.Header.Get(ReplyToHeader)
+	if !s.config.IsTempQueueDestination(replyTo) {
+		return ""
+	}
+	return replyTo
+}
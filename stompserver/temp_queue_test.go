@@ -0,0 +1,58 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStompServer_TempQueueDestination(t *testing.T) {
+	config := NewStompConfig(0, []string{"/pub/"})
+	config.SetTempQueuePrefix("/temp-queue")
+	server, listener := newTestStompServer(config)
+	subscribed := make(chan string, 10)
+	server.OnSubscribeEvent(func(conId string, subId string, destination string, f *frame.Frame) {
+		subscribed <- conId
+	})
+	replies := make(chan string, 10)
+	server.OnApplicationRequestWithReply(func(destination string, message []byte, connectionId string, replyTo string) {
+		replies <- connectionId + " " + replyTo
+	})
+	go server.Start()
+
+	client1, client2 := NewMockRawConnection(), NewMockRawConnection()
+	var ids []string
+	for _, conn := range []*MockRawConnection{client1, client2} {
+		listener.incomingConnections <- conn
+		conn.SendConnectFrame()
+		conn.incomingFrames <- frame.New(frame.SUBSCRIBE, frame.Destination, "/temp-queue/replies", frame.Id, "r1")
+		ids = append(ids, receive(t, subscribed))
+	}
+
+	// requests name the reply destination of their session, if it is one
+	client1.incomingFrames <- frame.New(frame.SEND, frame.Destination, "/pub/orders",
+		ReplyToHeader, "/temp-queue/replies")
+	assert.Equal(t, ids[0]+" /temp-queue/replies", receive(t, replies))
+	client1.incomingFrames <- frame.New(frame.SEND, frame.Destination, "/pub/orders", ReplyToHeader, "/topic/orders")
+	assert.Equal(t, ids[0]+" ", receive(t, replies))
+
+	// replies reach the session they are sent to only, nothing is broadcast to temporary destinations
+	server.SendMessage("/temp-queue/replies", []byte("everyone"))
+	server.SendMessageToClient(ids[1], "/temp-queue/replies", []byte("reply"))
+	assert.Equal(t, []string{"reply"}, messages(t, client2, 1))
+	assert.Empty(t, messages(t, client1, 0))
+
+	// clients cannot send to them either
+	client1.incomingFrames <- frame.New(frame.SEND, frame.Destination, "/temp-queue/replies")
+	assert.Eventually(t, func() bool {
+		client1.lock.Lock()
+		defer client1.lock.Unlock()
+		last := client1.sentFrames[len(client1.sentFrames)-1]
+		return last.Command == frame.ERROR
+	}, time.Second, 5*time.Millisecond)
+}
@@ -0,0 +1,97 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"sync"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+
+	"github.com/pb33f/ranch/clock"
+)
+
+// TicketHeader is the CONNECT frame header a client presents its ticket in.
+const TicketHeader = "ticket"
+
+// Ticket is a short-lived, one-time token standing in for a session token. Browsers cannot set an
+// Authorization header on a WebSocket, so a client authenticated by a session cookie obtains a ticket over
+// HTTP and presents it in the CONNECT frame instead.
+type Ticket struct {
+	SessionToken string    // session token the connection is established with once the ticket is redeemed
+	ExpiresAt    time.Time // the ticket is rejected after this time
+}
+
+// TicketStore is a concurrency safe set of tickets waiting to be redeemed.
+type TicketStore struct {
+	tickets map[string]*Ticket
+	lock    sync.Mutex
+}
+
+// NewTicketStore creates an empty TicketStore.
+func NewTicketStore() *TicketStore {
+	return &TicketStore{tickets: make(map[string]*Ticket)}
+}
+
+// Issue creates a ticket for the session token, valid for ttl, and returns it with its expiry.
+func (s *TicketStore) Issue(sessionToken string, ttl time.Duration) (string, time.Time, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", time.Time{}, err
+	}
+	id := base64.RawURLEncoding.EncodeToString(raw)
+	now := clock.Now()
+	expiresAt := now.Add(ttl)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	// tickets live for seconds, dropping the expired ones here keeps the set small without a sweeper
+	for t, ticket := range s.tickets {
+		if now.After(ticket.ExpiresAt) {
+			delete(s.tickets, t)
+		}
+	}
+	s.tickets[id] = &Ticket{SessionToken: sessionToken, ExpiresAt: expiresAt}
+	return id, expiresAt, nil
+}
+
+// Redeem removes the ticket from the store and returns it, or returns false if the ticket is unknown, was
+// already redeemed or has expired.
+func (s *TicketStore) Redeem(id string) (*Ticket, bool) {
+	s.lock.Lock()
+	ticket, ok := s.tickets[id]
+	delete(s.tickets, id)
+	s.lock.Unlock()
+	if !ok || clock.Now().After(ticket.ExpiresAt) {
+		return nil, false
+	}
+	return ticket, true
+}
+
+// TicketMiddleware returns a CONNECT MiddlewareFunc redeeming the ticket presented in the TicketHeader. The
+// connection takes the session token of the ticket, so revoking that token closes it. A connection presenting
+// an invalid ticket is rejected, as is one presenting none if required is true.
+func TicketMiddleware(store *TicketStore, required bool) MiddlewareFunc {
+	return func(next FrameHandlerFunc) FrameHandlerFunc {
+		return func(conn StompConn, f *frame.Frame) error {
+			id, ok := f.Header.Contains(TicketHeader)
+			if !ok {
+				if required {
+					return missingTicketError
+				}
+				return next(conn, f)
+			}
+			ticket, ok := store.Redeem(id)
+			if !ok {
+				return invalidTicketError
+			}
+			if c, ok := conn.(*stompConn); ok {
+				c.sessionToken = ticket.SessionToken
+			}
+			return next(conn, f)
+		}
+	}
+}
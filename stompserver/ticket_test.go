@@ -0,0 +1,90 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/pb33f/ranch/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTicketTestConfig(store *TicketStore, required bool) StompConfig {
+	conf := NewStompConfig(0, []string{"/pub/"})
+	conf.SetMiddlewareRegistry(MiddlewareRegistry{frame.CONNECT: []MiddlewareFunc{TicketMiddleware(store, required)}})
+	return conf
+}
+
+func TestTicketStore_RedeemOnce(t *testing.T) {
+	fake := clock.NewFakeClock(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	clock.Set(fake)
+	defer clock.Reset()
+
+	store := NewTicketStore()
+	id, expiresAt, err := store.Issue("session", 30*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, fake.Now().Add(30*time.Second), expiresAt)
+
+	ticket, ok := store.Redeem(id)
+	assert.True(t, ok)
+	assert.Equal(t, "session", ticket.SessionToken)
+	_, ok = store.Redeem(id)
+	assert.False(t, ok)
+
+	expiring, _, _ := store.Issue("session", 30*time.Second)
+	fake.Advance(31 * time.Second)
+	_, ok = store.Redeem(expiring)
+	assert.False(t, ok)
+
+	// expired tickets are dropped when the next one is issued
+	_, _, _ = store.Issue("stale", time.Second)
+	fake.Advance(2 * time.Second)
+	_, _, _ = store.Issue("fresh", time.Second)
+	store.lock.Lock()
+	assert.Len(t, store.tickets, 1)
+	store.lock.Unlock()
+}
+
+func TestTicketMiddleware_ConnectWithTicket(t *testing.T) {
+	store := NewTicketStore()
+	id, _, _ := store.Issue("cookie-session", time.Minute)
+	stompConn, rawConn, events := getTestStompConn(newTicketTestConfig(store, true), nil)
+
+	rawConn.incomingFrames <- frame.New(frame.CONNECT, frame.AcceptVersion, "1.2", TicketHeader, id)
+
+	e := <-events
+	assert.Equal(t, ConnectionEstablished, e.eventType)
+	assert.Equal(t, "cookie-session", stompConn.GetSessionToken())
+}
+
+func TestTicketMiddleware_RejectsInvalidTicket(t *testing.T) {
+	store := NewTicketStore()
+	stompConn, rawConn, events := getTestStompConn(newTicketTestConfig(store, false), nil)
+
+	rawConn.incomingFrames <- frame.New(frame.CONNECT, frame.AcceptVersion, "1.2", TicketHeader, "forged")
+
+	e := <-events
+	assert.Equal(t, ConnectionClosed, e.eventType)
+	verifyFrame(t, rawConn.LastSentFrame(), frame.New(frame.ERROR,
+		frame.Message, invalidTicketError.Error()), true)
+	assert.Equal(t, closed, stompConn.state)
+}
+
+func TestTicketMiddleware_Required(t *testing.T) {
+	_, rawConn, events := getTestStompConn(newTicketTestConfig(NewTicketStore(), true), nil)
+	rawConn.incomingFrames <- frame.New(frame.CONNECT, frame.AcceptVersion, "1.2")
+	e := <-events
+	assert.Equal(t, ConnectionClosed, e.eventType)
+	verifyFrame(t, rawConn.LastSentFrame(), frame.New(frame.ERROR,
+		frame.Message, missingTicketError.Error()), true)
+
+	// without a ticket, connecting is left to the other middleware when tickets are optional
+	_, rawConn, events = getTestStompConn(newTicketTestConfig(NewTicketStore(), false), nil)
+	rawConn.incomingFrames <- frame.New(frame.CONNECT, frame.AcceptVersion, "1.2")
+	e = <-events
+	assert.Equal(t, ConnectionEstablished, e.eventType)
+}
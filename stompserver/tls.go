@@ -0,0 +1,52 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+)
+
+// NewTlsConnectionListener accepts raw STOMP connections over TLS. When tlsConfig verifies client
+// certificates (tls.VerifyClientCertIfGiven or tls.RequireAndVerifyClientCert with ClientCAs set), the subject
+// of a verified certificate becomes the principal of the connection, see ClientCertificatePrincipal.
+func NewTlsConnectionListener(addr string, tlsConfig *tls.Config) (RawConnectionListener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewTcpConnectionListenerFromListener(tls.NewListener(listener, tlsConfig)), nil
+}
+
+// ClientCertificatePrincipal returns the principal of a client presenting a certificate: the common name of
+// its subject, or the whole subject if it has no common name.
+func ClientCertificatePrincipal(cert *x509.Certificate) string {
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+	return cert.Subject.String()
+}
+
+// certificateConnection is a RawConnection that may have verified the certificate of its client.
+type certificateConnection interface {
+	// PeerCertificate returns the verified certificate of the client, nil if it presented none or it was not
+	// verified.
+	PeerCertificate() *x509.Certificate
+}
+
+// PeerCertificate returns the verified certificate of the client of a TLS connection, nil if the connection
+// is not TLS, the client presented no certificate or it was not verified. The handshake is complete once a
+// frame has been read.
+func (c *tcpStompConnection) PeerCertificate() *x509.Certificate {
+	tlsConn, ok := c.tcpCon.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	state := tlsConn.ConnectionState()
+	if len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	return state.PeerCertificates[0]
+}
@@ -0,0 +1,118 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/stretchr/testify/assert"
+)
+
+// testIssue returns a certificate for the subject signed by the CA, or self-signed CA if ca is nil.
+func testIssue(t *testing.T, subject pkix.Name, ca *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	parent, signer := template, interface{}(key)
+	if ca == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		parent, signer = ca.Leaf, ca.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	assert.NoError(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestTlsConnectionListener_ClientCertificate(t *testing.T) {
+	ca := testIssue(t, pkix.Name{CommonName: "ranch CA"}, nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	server := testIssue(t, pkix.Name{CommonName: "localhost"}, &ca)
+
+	listener, err := NewTlsConnectionListener("127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{server},
+		ClientCAs:    pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	})
+	assert.NoError(t, err)
+	defer listener.Close()
+	addr := listener.(*tcpConnectionListener).listener.Addr().String()
+
+	connect := func(certificates ...tls.Certificate) string {
+		go func() {
+			conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: pool, Certificates: certificates})
+			if assert.NoError(t, err) {
+				_ = frame.NewWriter(conn).Write(frame.New(frame.CONNECT, frame.AcceptVersion, "1.2"))
+				_, _ = frame.NewReader(conn).Read()
+				conn.Close()
+			}
+		}()
+		rawConn, err := listener.Accept()
+		if !assert.NoError(t, err) {
+			return ""
+		}
+		events := make(chan *ConnEvent, 10)
+		conn := NewStompConn(rawConn, NewStompConfig(0, []string{"/pub"}), events)
+		defer conn.Close()
+		assert.Equal(t, ConnectionEstablished, (<-events).eventType)
+		return conn.GetPrincipal()
+	}
+
+	assert.Equal(t, "alice", connect(testIssue(t, pkix.Name{CommonName: "alice"}, &ca)))
+	assert.Equal(t, "O=pb33f", connect(testIssue(t, pkix.Name{Organization: []string{"pb33f"}}, &ca)))
+	assert.Empty(t, connect(), "clients without a certificate are anonymous")
+}
+
+func TestTlsConnectionListener_RequireClientCertificate(t *testing.T) {
+	ca := testIssue(t, pkix.Name{CommonName: "ranch CA"}, nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+
+	listener, err := NewTlsConnectionListener("127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{testIssue(t, pkix.Name{CommonName: "localhost"}, &ca)},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+	assert.NoError(t, err)
+	defer listener.Close()
+	addr := listener.(*tcpConnectionListener).listener.Addr().String()
+
+	// a certificate from another CA is refused during the handshake, before any frame is read
+	other := testIssue(t, pkix.Name{CommonName: "other CA"}, nil)
+	go func() {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: pool,
+			Certificates: []tls.Certificate{testIssue(t, pkix.Name{CommonName: "mallory"}, &other)}})
+		if err == nil {
+			_ = frame.NewWriter(conn).Write(frame.New(frame.CONNECT, frame.AcceptVersion, "1.2"))
+			conn.Close()
+		}
+	}()
+	rawConn, err := listener.Accept()
+	if assert.NoError(t, err) {
+		_, err = rawConn.ReadFrame()
+		assert.Error(t, err)
+		assert.Nil(t, rawConn.(*tcpStompConnection).PeerCertificate())
+	}
+}
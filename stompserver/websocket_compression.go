@@ -0,0 +1,56 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"compress/flate"
+	"sync/atomic"
+)
+
+// DefaultCompressionThreshold is the size of the smallest frame compressed, smaller frames do not shrink
+// enough to be worth the CPU.
+const DefaultCompressionThreshold = 512
+
+// stompSubprotocols are the WebSocket subprotocols of the STOMP versions the broker speaks, in the order
+// of preference. A client offering several is given the first of these it offers, a client offering none
+// of them is not given any and may then fail the handshake.
+var stompSubprotocols = []string{"v12.stomp", "v11.stomp", "v10.stomp"}
+
+// WebSocketCompression configures the permessage-deflate compression of STOMP WebSocket connections (RFC
+// 7692). Frames are compressed only if the client negotiated the extension, and only if they are at least
+// Threshold bytes.
+type WebSocketCompression struct {
+	Enabled   bool `json:"enabled"`
+	Level     int  `json:"level"`     // flate compression level, 1 (fastest) to 9 (smallest). defaults to 1
+	Threshold int  `json:"threshold"` // bytes of the smallest frame compressed, defaults to DefaultCompressionThreshold
+}
+
+// CompressedListener is a RawConnectionListener whose connections can compress the frames they write. The
+// STOMP WebSocket listeners of this package are, and so is a multi connection listener merging them.
+type CompressedListener interface {
+	// SetCompression sets the compression of the connections accepted afterwards.
+	SetCompression(compression WebSocketCompression)
+}
+
+// compressionHolder holds the compression of a listener, read by the goroutines accepting its connections.
+type compressionHolder struct {
+	compression atomic.Pointer[WebSocketCompression]
+}
+
+func (h *compressionHolder) SetCompression(compression WebSocketCompression) {
+	if compression.Level < flate.BestSpeed || compression.Level > flate.BestCompression {
+		compression.Level = flate.BestSpeed
+	}
+	if compression.Threshold <= 0 {
+		compression.Threshold = DefaultCompressionThreshold
+	}
+	h.compression.Store(&compression)
+}
+
+func (h *compressionHolder) webSocketCompression() WebSocketCompression {
+	if compression := h.compression.Load(); compression != nil {
+		return *compression
+	}
+	return WebSocketCompression{}
+}
@@ -0,0 +1,126 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestWebSocketListener(t *testing.T) (*webSocketConnectionListener, string) {
+	router := mux.NewRouter()
+	listener, err := NewWebSocketConnectionFromExistingHttpServer(nil, router, "/fabric", nil, nil, false, nil)
+	require.NoError(t, err)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return listener.(*webSocketConnectionListener), "ws" + strings.TrimPrefix(server.URL, "http") + "/fabric"
+}
+
+func TestWebSocketConnectionListener_Subprotocols(t *testing.T) {
+	listener, url := newTestWebSocketListener(t)
+
+	for _, test := range []struct {
+		offered  []string
+		selected string
+	}{
+		{offered: []string{"v10.stomp", "v12.stomp", "v11.stomp"}, selected: "v12.stomp"},
+		{offered: []string{"v11.stomp", "access-token.something"}, selected: "v11.stomp"},
+		{offered: []string{"mqtt"}, selected: ""},
+		{offered: nil, selected: ""},
+	} {
+		dialer := &websocket.Dialer{Subprotocols: test.offered}
+		clientConn, resp, err := dialer.Dial(url, nil)
+		require.NoError(t, err)
+		rawConn, err := listener.Accept()
+		require.NoError(t, err)
+		assert.Equal(t, test.selected, clientConn.Subprotocol(), "offered %v", test.offered)
+		assert.Equal(t, test.selected, resp.Header.Get("Sec-WebSocket-Protocol"), "offered %v", test.offered)
+		clientConn.Close()
+		rawConn.Close()
+	}
+}
+
+func TestWebSocketConnectionListener_Compression(t *testing.T) {
+	listener, url := newTestWebSocketListener(t)
+	listener.SetCompression(WebSocketCompression{Enabled: true, Level: 42, Threshold: 100})
+	assert.Equal(t, WebSocketCompression{Enabled: true, Level: 1, Threshold: 100}, listener.webSocketCompression())
+
+	dialer := &websocket.Dialer{EnableCompression: true}
+	clientConn, resp, err := dialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer clientConn.Close()
+	assert.Contains(t, resp.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate")
+	rawConn, err := listener.Accept()
+	require.NoError(t, err)
+	defer rawConn.Close()
+
+	small := frame.New(frame.MESSAGE, frame.Destination, "/topic/small")
+	small.Body = []byte("hello")
+	large := frame.New(frame.MESSAGE, frame.Destination, "/topic/large")
+	large.Body = []byte(strings.Repeat("chatty ", 100))
+	for _, f := range []*frame.Frame{small, large} {
+		require.NoError(t, rawConn.WriteFrame(f))
+		_, r, err := clientConn.NextReader()
+		require.NoError(t, err)
+		received, err := frame.NewReader(r).Read()
+		require.NoError(t, err)
+		assert.Equal(t, f.Header.Get(frame.Destination), received.Header.Get(frame.Destination))
+		assert.Equal(t, f.Body, received.Body)
+	}
+}
+
+func TestWebSocketConnectionListener_CompressionNotNegotiated(t *testing.T) {
+	listener, url := newTestWebSocketListener(t)
+	listener.SetCompression(WebSocketCompression{Enabled: true})
+	assert.Equal(t, DefaultCompressionThreshold, listener.webSocketCompression().Threshold)
+
+	clientConn, resp, err := (&websocket.Dialer{}).Dial(url, nil)
+	require.NoError(t, err)
+	defer clientConn.Close()
+	assert.Empty(t, resp.Header.Get("Sec-WebSocket-Extensions"))
+	rawConn, err := listener.Accept()
+	require.NoError(t, err)
+	defer rawConn.Close()
+
+	f := frame.New(frame.MESSAGE, frame.Destination, "/topic/large")
+	f.Body = []byte(strings.Repeat("chatty ", 200))
+	require.NoError(t, rawConn.WriteFrame(f))
+	_, r, err := clientConn.NextReader()
+	require.NoError(t, err)
+	received, err := frame.NewReader(r).Read()
+	require.NoError(t, err)
+	assert.Equal(t, f.Body, received.Body)
+}
+
+func TestMultiConnectionListener_SetCompression(t *testing.T) {
+	ws, _ := newTestWebSocketListener(t)
+	tcp, err := NewTcpConnectionListener("127.0.0.1:0")
+	require.NoError(t, err)
+	defer tcp.Close()
+	l := NewMultiConnectionListener(ws, tcp)
+	l.(CompressedListener).SetCompression(WebSocketCompression{Enabled: true, Level: 9})
+	assert.Equal(t, WebSocketCompression{Enabled: true, Level: 9, Threshold: DefaultCompressionThreshold},
+		ws.webSocketCompression())
+}
+
+func TestWebSocketConnectionListener_CompressionDisabled(t *testing.T) {
+	listener, url := newTestWebSocketListener(t)
+
+	clientConn, resp, err := (&websocket.Dialer{EnableCompression: true}).Dial(url, nil)
+	require.NoError(t, err)
+	defer clientConn.Close()
+	assert.Empty(t, resp.Header.Get("Sec-WebSocket-Extensions"))
+	rawConn, err := listener.Accept()
+	require.NoError(t, err)
+	rawConn.Close()
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+}
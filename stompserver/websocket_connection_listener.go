@@ -4,6 +4,7 @@
 package stompserver
 
 import (
+    "bytes"
     "fmt"
     "github.com/go-stomp/stomp/v3/frame"
     "github.com/gorilla/mux"
@@ -11,14 +12,17 @@ import (
     "log/slog"
     "net"
     "net/http"
-    "net/url"
     "strings"
 
     "time"
 )
 
 type WebSocketStompConnection struct {
-    WSCon *websocket.Conn
+    WSCon       *websocket.Conn
+    Limits      FrameLimits          // limits of the frames read, not limited if zero
+    Compression WebSocketCompression // compression of the frames written, if the client negotiated it
+    principal   string               // principal of the request upgraded, see ContextWithPrincipal
+    release     func()               // releases the connection of its client IP address, see WebSocketGuard
 }
 
 func (c *WebSocketStompConnection) ReadFrame() (*frame.Frame, error) {
@@ -26,12 +30,21 @@ func (c *WebSocketStompConnection) ReadFrame() (*frame.Frame, error) {
     if err != nil {
         return nil, err
     }
-    frameR := frame.NewReader(r)
+    frameR := newFrameReader(r, c.Limits)
     f, e := frameR.Read()
     return f, e
 }
 
 func (c *WebSocketStompConnection) WriteFrame(f *frame.Frame) error {
+    if c.Compression.Enabled {
+        // the frame is only compressed if it is large enough, which is known once it is written
+        var buf bytes.Buffer
+        if err := frame.NewWriter(&buf).Write(f); err != nil {
+            return err
+        }
+        c.WSCon.EnableWriteCompression(buf.Len() >= c.Compression.Threshold)
+        return c.WSCon.WriteMessage(websocket.TextMessage, buf.Bytes())
+    }
     wr, err := c.WSCon.NextWriter(websocket.TextMessage)
     if err != nil {
         return err
@@ -50,10 +63,20 @@ func (c *WebSocketStompConnection) SetReadDeadline(t time.Time) {
 }
 
 func (c *WebSocketStompConnection) Close() error {
+    if c.release != nil {
+        c.release()
+    }
     return c.WSCon.Close()
 }
 
+func (c *WebSocketStompConnection) RemoteAddr() net.Addr {
+    return c.WSCon.RemoteAddr()
+}
+
 type webSocketConnectionListener struct {
+    frameLimitsHolder
+    compressionHolder
+    guardHolder
     httpServer            *http.Server
     requestHandler        *http.ServeMux
     tcpConnectionListener net.Listener
@@ -78,13 +101,6 @@ func NewWebSocketConnectionFromExistingHttpServer(httpServer *http.Server, handl
         allowedOrigins:     allowedOrigins,
     }
 
-    var upgrader = websocket.Upgrader{
-        ReadBufferSize:  1024,
-        WriteBufferSize: 1024,
-    }
-
-    upgrader.CheckOrigin = l.checkOrigin
-
     handler.HandleFunc(endpoint, func(writer http.ResponseWriter, request *http.Request) {
         if debug {
             if logger != nil {
@@ -102,15 +118,33 @@ func NewWebSocketConnectionFromExistingHttpServer(httpServer *http.Server, handl
             return
         }
 
-        upgrader.Subprotocols = websocket.Subprotocols(request)
-        conn, err := upgrader.Upgrade(writer, request, nil)
+        release, status := l.admit(request)
+        if status != 0 {
+            http.Error(writer, http.StatusText(status), status)
+            if debug && logger != nil {
+                logger.Warn(fmt.Sprintf("[ranch] refused websocket connection from: %s", request.RemoteAddr),
+                    "status", status)
+            }
+            return
+        }
+
+        compression := l.webSocketCompression()
+        conn, err := l.upgrader(compression).Upgrade(writer, request, nil)
         if err != nil {
+            release()
             l.connectionsChannel <- RawConnResult{Err: err}
             return
         }
+        if compression.Enabled {
+            _ = conn.SetCompressionLevel(compression.Level)
+        }
 
         wsConn := &WebSocketStompConnection{
-            WSCon: conn,
+            WSCon:       conn,
+            Limits:      l.frameLimits(),
+            Compression: compression,
+            principal:   PrincipalFromContext(request.Context()),
+            release:     release,
         }
 
         conn.SetCloseHandler(func(code int, text string) error {
@@ -155,13 +189,6 @@ func NewWebSocketConnectionListener(addr string, endpoint string, allowedOrigins
         allowedOrigins:     allowedOrigins,
     }
 
-    var upgrader = websocket.Upgrader{
-        ReadBufferSize:  1024,
-        WriteBufferSize: 1024,
-    }
-
-    upgrader.CheckOrigin = l.checkOrigin
-
     rh.HandleFunc(endpoint, func(writer http.ResponseWriter, request *http.Request) {
         if debug {
             if logger != nil {
@@ -179,15 +206,28 @@ func NewWebSocketConnectionListener(addr string, endpoint string, allowedOrigins
             return
         }
 
-        upgrader.Subprotocols = websocket.Subprotocols(request)
-        conn, err := upgrader.Upgrade(writer, request, nil)
+        release, status := l.admit(request)
+        if status != 0 {
+            http.Error(writer, http.StatusText(status), status)
+            return
+        }
+
+        compression := l.webSocketCompression()
+        conn, err := l.upgrader(compression).Upgrade(writer, request, nil)
         if err != nil {
+            release()
             l.connectionsChannel <- RawConnResult{Err: err}
 
         } else {
+            if compression.Enabled {
+                _ = conn.SetCompressionLevel(compression.Level)
+            }
             l.connectionsChannel <- RawConnResult{
                 Conn: &WebSocketStompConnection{
-                    WSCon: conn,
+                    WSCon:       conn,
+                    Limits:      l.frameLimits(),
+                    Compression: compression,
+                    release:     release,
                 },
             }
         }
@@ -203,6 +243,18 @@ func NewWebSocketConnectionListener(addr string, endpoint string, allowedOrigins
     return l, nil
 }
 
+// upgrader returns the upgrader of a request, negotiating a STOMP subprotocol and, if enabled,
+// permessage-deflate compression.
+func (l *webSocketConnectionListener) upgrader(compression WebSocketCompression) *websocket.Upgrader {
+    return &websocket.Upgrader{
+        ReadBufferSize:    1024,
+        WriteBufferSize:   1024,
+        CheckOrigin:       l.checkOrigin,
+        Subprotocols:      stompSubprotocols,
+        EnableCompression: compression.Enabled,
+    }
+}
+
 func (l *webSocketConnectionListener) GetConnectionOpenChannel() chan *Connection {
     return l.openChannel
 }
@@ -211,30 +263,10 @@ func (l *webSocketConnectionListener) GetConnectionCloseChannel() chan *Connecti
     return l.closeChannel
 }
 
+// checkOrigin allows the origins the listener was created with, and those of its WebSocketGuard.
 func (l *webSocketConnectionListener) checkOrigin(r *http.Request) bool {
-    if len(l.allowedOrigins) == 0 {
-        return true
-    }
-
-    origin := r.Header["Origin"]
-    if len(origin) == 0 {
-        return true
-    }
-    u, err := url.Parse(origin[0])
-    if err != nil {
-        return false
-    }
-    if strings.ToLower(u.Host) == strings.ToLower(r.Host) {
-        return true
-    }
-
-    for _, allowedOrigin := range l.allowedOrigins {
-        if strings.ToLower(u.Host) == strings.ToLower(allowedOrigin) {
-            return true
-        }
-    }
-
-    return false
+    allowed := append(append([]string(nil), l.allowedOrigins...), l.webSocketGuard().AllowedOrigins...)
+    return allowsOrigin(r, allowed)
 }
 
 func (l *webSocketConnectionListener) Accept() (RawConnection, error) {
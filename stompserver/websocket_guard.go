@@ -0,0 +1,145 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultCSRFParam is the query parameter of the WebSocket upgrade carrying the CSRF token.
+const DefaultCSRFParam = "csrf_token"
+
+// WebSocketGuard hardens the WebSocket upgrade of browser-facing listeners against cross-site WebSocket
+// hijacking, and clients opening more connections than they should. Browsers cannot set headers on a
+// WebSocket, so the CSRF token travels in a query parameter of the URL the client connects to.
+type WebSocketGuard struct {
+	// AllowedOrigins are the origins browsers may connect from besides the host serving the endpoint, as
+	// hosts ("app.example.com") or origins ("https://app.example.com"). Any origin is allowed if empty.
+	AllowedOrigins []string `json:"allowed_origins"`
+	// CSRFCookie is the cookie whose value the CSRF token must match (the double-submit cookie pattern),
+	// tokens are not checked if empty and ValidateCSRF is not set.
+	CSRFCookie string `json:"csrf_cookie"`
+	// CSRFParam is the query parameter carrying the token, DefaultCSRFParam if empty.
+	CSRFParam string `json:"csrf_param"`
+	// ValidateCSRF checks the token of an upgrade request, instead of comparing it to CSRFCookie.
+	ValidateCSRF func(r *http.Request, token string) bool `json:"-"`
+	// MaxConnectionsPerIP is how many WebSocket connections a client IP address may have open at once, not
+	// limited if 0. The address is the remote address of the request, rewrite it from the forwarding
+	// headers of trusted proxies before the request reaches the listener.
+	MaxConnectionsPerIP int `json:"max_connections_per_ip"`
+}
+
+func (g WebSocketGuard) csrfParam() string {
+	if g.CSRFParam != "" {
+		return g.CSRFParam
+	}
+	return DefaultCSRFParam
+}
+
+// validCSRF returns whether the upgrade request carries a valid CSRF token, if tokens are checked.
+func (g WebSocketGuard) validCSRF(r *http.Request) bool {
+	if g.ValidateCSRF == nil && g.CSRFCookie == "" {
+		return true
+	}
+	token := r.URL.Query().Get(g.csrfParam())
+	if token == "" {
+		return false
+	}
+	if g.ValidateCSRF != nil {
+		return g.ValidateCSRF(r, token)
+	}
+	cookie, err := r.Cookie(g.CSRFCookie)
+	return err == nil && subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(token)) == 1
+}
+
+// GuardedListener is a RawConnectionListener whose WebSocket upgrades a WebSocketGuard checks. The
+// WebSocket listeners of this package are, and so is a multi connection listener merging them.
+type GuardedListener interface {
+	// SetWebSocketGuard sets the guard of the upgrade requests received afterwards.
+	SetWebSocketGuard(guard WebSocketGuard)
+}
+
+// guardHolder holds the guard of a listener, and the connections open per client IP address.
+type guardHolder struct {
+	guard       atomic.Pointer[WebSocketGuard]
+	lock        sync.Mutex
+	connections map[string]int
+}
+
+func (h *guardHolder) SetWebSocketGuard(guard WebSocketGuard) {
+	h.guard.Store(&guard)
+}
+
+func (h *guardHolder) webSocketGuard() WebSocketGuard {
+	if guard := h.guard.Load(); guard != nil {
+		return *guard
+	}
+	return WebSocketGuard{}
+}
+
+// admit checks the CSRF token and the connections of the client IP address of an upgrade request. It
+// returns the status refusing the request, or a function releasing the connection once it closes.
+func (h *guardHolder) admit(r *http.Request) (func(), int) {
+	guard := h.webSocketGuard()
+	if !guard.validCSRF(r) {
+		return nil, http.StatusForbidden
+	}
+	if guard.MaxConnectionsPerIP <= 0 {
+		return func() {}, 0
+	}
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.connections[ip] >= guard.MaxConnectionsPerIP {
+		return nil, http.StatusTooManyRequests
+	}
+	if h.connections == nil {
+		h.connections = make(map[string]int)
+	}
+	h.connections[ip]++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			h.lock.Lock()
+			defer h.lock.Unlock()
+			if h.connections[ip]--; h.connections[ip] <= 0 {
+				delete(h.connections, ip)
+			}
+		})
+	}, 0
+}
+
+// allowsOrigin returns whether a browser may connect from the origin of a request: its own host, one of
+// the allowed origins, or any if none are. Requests without an origin do not come from a browser.
+func allowsOrigin(r *http.Request, allowedOrigins []string) bool {
+	if len(allowedOrigins) == 0 {
+		return true
+	}
+	origin := r.Header["Origin"]
+	if len(origin) == 0 {
+		return true
+	}
+	u, err := url.Parse(origin[0])
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range allowedOrigins {
+		if strings.EqualFold(u.Host, allowed) || strings.EqualFold(origin[0], strings.TrimSuffix(allowed, "/")) {
+			return true
+		}
+	}
+	return false
+}
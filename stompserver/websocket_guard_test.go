@@ -0,0 +1,78 @@
+// Copyright 2023 Princess B33f Heavy Industries / Dave Shanley
+// SPDX-License-Identifier: BSD-2-Clause
+
+package stompserver
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebSocketGuard(t *testing.T) {
+	listener, err := NewWebSocketConnectionListener("127.0.0.1:0", "/fabric", nil, nil, false)
+	require.NoError(t, err)
+	defer listener.Close()
+	listener.(GuardedListener).SetWebSocketGuard(WebSocketGuard{
+		AllowedOrigins:      []string{"https://app.example.com"},
+		CSRFCookie:          "csrf",
+		MaxConnectionsPerIP: 1,
+	})
+	accepted := make(chan RawConnection, 10)
+	go func() {
+		for {
+			// refused origins are reported as errors, the listener goes on
+			if conn, err := listener.Accept(); err == nil {
+				accepted <- conn
+			}
+		}
+	}()
+
+	url := "ws://" + listener.(*webSocketConnectionListener).tcpConnectionListener.Addr().String() + "/fabric"
+	dial := func(origin string, cookie string, token string) (*websocket.Conn, int) {
+		header := http.Header{"Origin": []string{origin}}
+		if cookie != "" {
+			header.Set("Cookie", "csrf="+cookie)
+		}
+		conn, resp, err := websocket.DefaultDialer.Dial(url+"?csrf_token="+token, header)
+		if err != nil {
+			require.NotNil(t, resp, err)
+			return nil, resp.StatusCode
+		}
+		return conn, http.StatusSwitchingProtocols
+	}
+
+	// browsers connecting from other origins, or without the token of their session, are refused
+	_, status := dial("https://evil.example.com", "moo", "moo")
+	assert.Equal(t, http.StatusForbidden, status)
+	_, status = dial("https://app.example.com", "moo", "")
+	assert.Equal(t, http.StatusForbidden, status)
+	_, status = dial("https://app.example.com", "moo", "baa")
+	assert.Equal(t, http.StatusForbidden, status)
+
+	conn, status := dial("https://app.example.com", "moo", "moo")
+	require.Equal(t, http.StatusSwitchingProtocols, status)
+	defer conn.Close()
+
+	// clients may only have so many connections open at once
+	_, status = dial("https://app.example.com", "moo", "moo")
+	assert.Equal(t, http.StatusTooManyRequests, status)
+	require.NoError(t, (<-accepted).Close())
+	conn, status = dial("https://app.example.com", "moo", "moo")
+	require.Equal(t, http.StatusSwitchingProtocols, status)
+	defer conn.Close()
+}
+
+func TestWebSocketGuard_ValidateCSRF(t *testing.T) {
+	guard := WebSocketGuard{CSRFParam: "t", ValidateCSRF: func(r *http.Request, token string) bool {
+		return token == "issued"
+	}}
+	r, _ := http.NewRequest(http.MethodGet, "/fabric?t=issued", nil)
+	assert.True(t, guard.validCSRF(r))
+	r, _ = http.NewRequest(http.MethodGet, "/fabric?csrf_token=issued", nil)
+	assert.False(t, guard.validCSRF(r))
+	assert.True(t, WebSocketGuard{}.validCSRF(r))
+}